@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/server"
+	"github.com/elskow/chef-infra/internal/version"
+)
+
+func main() {
+	hostname, _ := os.Hostname()
+
+	target := flag.String("server", "localhost:50051", "control plane gRPC address")
+	plaintext := flag.Bool("insecure", false, "connect without TLS")
+	name := flag.String("name", hostname, "agent name shown in logs")
+	labels := flag.String("labels", "", "extra labels as key=value pairs separated by commas")
+	capacity := flag.Int("capacity", 1, "builds run at once")
+	workDir := flag.String("work-dir", filepath.Join(os.TempDir(), "chef-agent"), "directory for sources and build output")
+	flag.Parse()
+
+	token := os.Getenv("CHEF_AGENT_TOKEN")
+	if token == "" {
+		fmt.Fprintln(os.Stderr, "CHEF_AGENT_TOKEN must be set")
+		os.Exit(1)
+	}
+
+	if os.Getenv("APP_ENV") == "" {
+		os.Setenv("APP_ENV", "development")
+	}
+
+	logger, err := server.NewLogger(os.Getenv("APP_ENV"))
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+
+	// Builders use the same nodejs settings as the control plane
+	cfg, err := server.LoadConfig()
+	if err != nil {
+		logger.Fatal("failed to load config", zap.Error(err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	agentLabels, err := parseLabels(*labels)
+	if err != nil {
+		logger.Fatal("invalid labels", zap.Error(err))
+	}
+	agentLabels = withDefaultLabels(ctx, agentLabels)
+
+	creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if *plaintext {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(*target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		logger.Fatal("failed to create client", zap.Error(err))
+	}
+	defer conn.Close()
+
+	worker := agent.NewWorker(conn, builder.NewBuilderFactory(&cfg.Pipeline, logger), agent.WorkerConfig{
+		Name:     *name,
+		Token:    token,
+		Labels:   agentLabels,
+		Capacity: *capacity,
+		WorkDir:  *workDir,
+	}, logger)
+
+	logger.Info("starting build agent", append(version.Get().Fields(),
+		zap.String("server", *target),
+		zap.Any("labels", agentLabels))...)
+	if err := worker.Run(ctx); err != nil {
+		logger.Fatal("build agent stopped", zap.Error(err))
+	}
+}
+
+// parseLabels reads "key=value,key=value"
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("label %q is not key=value", pair)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// withDefaultLabels adds the os, arch and docker version labels unless set
+// explicitly
+func withDefaultLabels(ctx context.Context, labels map[string]string) map[string]string {
+	defaults := map[string]string{
+		"os":   runtime.GOOS,
+		"arch": runtime.GOARCH,
+	}
+	if cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation()); err == nil {
+		if version, err := cli.ServerVersion(ctx); err == nil {
+			defaults["docker"] = version.Version
+		}
+		cli.Close()
+	}
+
+	for key, value := range defaults {
+		if _, ok := labels[key]; !ok {
+			labels[key] = value
+		}
+	}
+	return labels
+}
@@ -1,21 +1,52 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
 
 	"github.com/elskow/chef-infra/internal/app"
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/diagnostics"
 	"github.com/elskow/chef-infra/internal/server"
 )
 
 func main() {
+	check := flag.Bool("check", false, "run preflight diagnostics and exit")
+	validate := flag.String("validate-config", "", "validate the given config file and exit")
+	example := flag.Bool("example-config", false, "print a documented example config and exit")
+	encrypt := flag.Bool("encrypt-value", false, "encrypt the value read from stdin with the config key and exit")
+	flag.Parse()
+
+	if *validate != "" {
+		os.Exit(validateConfig(*validate))
+	}
+	if *encrypt {
+		os.Exit(encryptValue())
+	}
+	if *example {
+		if err := config.WriteExample(os.Stdout, config.Example()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if os.Getenv("APP_ENV") == "" {
 		os.Setenv("APP_ENV", "development")
 	}
 
+	if *check {
+		os.Exit(runChecks())
+	}
+
 	logger, err := server.NewLogger(os.Getenv("APP_ENV"))
 	if err != nil {
 		panic(err)
@@ -33,3 +64,66 @@ func main() {
 
 	app.Run()
 }
+
+// runChecks prints a preflight report and returns the process exit code
+func runChecks() int {
+	cfg, err := server.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[fail] config: %v\n", err)
+		return 1
+	}
+
+	report := diagnostics.NewRunner(cfg, diagnostics.DefaultChecks()).Run(context.Background())
+	report.WriteText(os.Stdout)
+
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
+
+// encryptValue prints the value read from stdin encrypted for the config,
+// so secrets stay out of the shell history, and returns the process exit code
+func encryptValue() int {
+	key, err := config.LoadConfigKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if key == nil {
+		fmt.Fprintf(os.Stderr, "error: set %s or %s to encrypt values\n", config.ConfigKeyEnv, config.ConfigKeyFileEnv)
+		return 1
+	}
+
+	plaintext, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	value, err := config.EncryptValue(key, strings.TrimRight(string(plaintext), "\r\n"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Println(value)
+	return 0
+}
+
+// validateConfig prints the problems found in a config file and returns
+// the process exit code. Warnings alone do not fail validation.
+func validateConfig(path string) int {
+	problems, err := config.ValidateFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if config.HasErrors(problems) {
+		return 1
+	}
+	fmt.Printf("%s is valid\n", path)
+	return 0
+}
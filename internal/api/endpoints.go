@@ -3,19 +3,228 @@ package api
 // Authentication service endpoints
 const (
 	// Service name
-	AuthService = "auth.Auth"
+	AuthService = "auth.v1.Auth"
 
 	// Authentication endpoints
-	AuthRegister      = "/auth.Auth/Register"
-	AuthLogin         = "/auth.Auth/Login"
-	AuthValidateToken = "/auth.Auth/ValidateToken"
-	AuthRefreshToken  = "/auth.Auth/RefreshToken"
+	AuthRegister      = "/auth.v1.Auth/Register"
+	AuthLogin         = "/auth.v1.Auth/Login"
+	AuthValidateToken = "/auth.v1.Auth/ValidateToken"
+	AuthRefreshToken  = "/auth.v1.Auth/RefreshToken"
+
+	// Impersonation endpoints
+	AuthImpersonateUser     = "/auth.v1.Auth/ImpersonateUser"
+	AuthRevokeImpersonation = "/auth.v1.Auth/RevokeImpersonation"
+)
+
+// Unversioned authentication endpoints, deprecated in favor of auth.v1
+const (
+	// Service name
+	LegacyAuthService = "auth.Auth"
+
+	LegacyAuthRegister      = "/auth.Auth/Register"
+	LegacyAuthLogin         = "/auth.Auth/Login"
+	LegacyAuthValidateToken = "/auth.Auth/ValidateToken"
+	LegacyAuthRefreshToken  = "/auth.Auth/RefreshToken"
 )
 
-// PublicEndpoints defines endpoints that don't require authentication
+// Server information endpoints
+const (
+	// Service name
+	ServerService = "server.v1.Server"
+
+	ServerInfo = "/server.v1.Server/ServerInfo"
+)
+
+// Pipeline service endpoints
+const (
+	// Service name
+	PipelineService = "pipeline.v1.Pipeline"
+
+	// Node version matrix endpoints
+	PipelineListNodeVersions   = "/pipeline.v1.Pipeline/ListNodeVersions"
+	PipelineUpdateNodeVersions = "/pipeline.v1.Pipeline/UpdateNodeVersions"
+
+	// Uptime endpoints
+	PipelineGetUptime = "/pipeline.v1.Pipeline/GetUptime"
+
+	// Usage endpoints
+	PipelineGetUsage    = "/pipeline.v1.Pipeline/GetUsage"
+	PipelineExportUsage = "/pipeline.v1.Pipeline/ExportUsage"
+
+	// Runtime endpoints
+	PipelineGetAppLogs = "/pipeline.v1.Pipeline/GetAppLogs"
+	PipelineExecApp    = "/pipeline.v1.Pipeline/ExecApp"
+
+	// Deployment control endpoints
+	PipelineRestartDeployment = "/pipeline.v1.Pipeline/RestartDeployment"
+	PipelineScaleDeployment   = "/pipeline.v1.Pipeline/ScaleDeployment"
+
+	// Build history endpoints
+	PipelineGetBuild      = "/pipeline.v1.Pipeline/GetBuild"
+	PipelineListBuilds    = "/pipeline.v1.Pipeline/ListBuilds"
+	PipelinePromoteBuild  = "/pipeline.v1.Pipeline/PromoteBuild"
+	PipelinePinBuild      = "/pipeline.v1.Pipeline/PinBuild"
+	PipelineUnpinBuild    = "/pipeline.v1.Pipeline/UnpinBuild"
+	PipelineAnnotateBuild = "/pipeline.v1.Pipeline/AnnotateBuild"
+	PipelineApproveBuild  = "/pipeline.v1.Pipeline/ApproveBuild"
+	PipelineSearchLogs    = "/pipeline.v1.Pipeline/SearchLogs"
+	PipelineVerifyBuild   = "/pipeline.v1.Pipeline/VerifyBuild"
+
+	// Scaling endpoints
+	PipelineGetConcurrency = "/pipeline.v1.Pipeline/GetConcurrency"
+
+	// Deploy lock endpoints
+	PipelineListDeployLocks   = "/pipeline.v1.Pipeline/ListDeployLocks"
+	PipelineReleaseDeployLock = "/pipeline.v1.Pipeline/ReleaseDeployLock"
+
+	// Deploy target endpoints
+	PipelineDescribeDeployTargets = "/pipeline.v1.Pipeline/DescribeDeployTargets"
+)
+
+// Project service endpoints
+const (
+	// Service name
+	ProjectService = "project.Project"
+
+	ProjectCreate         = "/project.Project/CreateProject"
+	ProjectCreateFromRepo = "/project.Project/CreateProjectFromRepo"
+	ProjectGet            = "/project.Project/GetProject"
+	ProjectList           = "/project.Project/ListProjects"
+	ProjectDelete         = "/project.Project/DeleteProject"
+	ProjectRestore        = "/project.Project/RestoreProject"
+	ProjectUpdateSettings = "/project.Project/UpdateProjectSettings"
+	ProjectSimulatePush   = "/project.Project/SimulatePush"
+)
+
+// Public project endpoints
+const (
+	// Service name
+	PublicProjectsService = "public.v1.PublicProjects"
+
+	PublicProjectsGetProjectStatus = "/public.v1.PublicProjects/GetProjectStatus"
+	PublicProjectsListBuilds       = "/public.v1.PublicProjects/ListBuilds"
+)
+
+// Webhook service endpoints
+const (
+	// Service name
+	WebhookService = "webhook.Webhook"
+
+	WebhookCreate         = "/webhook.Webhook/CreateWebhook"
+	WebhookList           = "/webhook.Webhook/ListWebhooks"
+	WebhookUpdate         = "/webhook.Webhook/UpdateWebhook"
+	WebhookDelete         = "/webhook.Webhook/DeleteWebhook"
+	WebhookListDeliveries = "/webhook.Webhook/ListDeliveries"
+	WebhookRedeliver      = "/webhook.Webhook/RedeliverWebhook"
+)
+
+// Subscription service endpoints
+const (
+	// Service name
+	SubscriptionsService = "subscription.Subscriptions"
+
+	SubscriptionsGetPreferences = "/subscription.Subscriptions/GetPreferences"
+	SubscriptionsSaveFilter     = "/subscription.Subscriptions/SaveFilter"
+	SubscriptionsDeleteFilter   = "/subscription.Subscriptions/DeleteFilter"
+	SubscriptionsSaveChannel    = "/subscription.Subscriptions/SaveChannel"
+	SubscriptionsDeleteChannel  = "/subscription.Subscriptions/DeleteChannel"
+	SubscriptionsSubscribe      = "/subscription.Subscriptions/Subscribe"
+	SubscriptionsUnsubscribe    = "/subscription.Subscriptions/Unsubscribe"
+)
+
+// Source control service endpoints
+const (
+	// Service name
+	SourceControlService = "scm.SourceControl"
+
+	SourceControlConnect          = "/scm.SourceControl/ConnectProvider"
+	SourceControlDisconnect       = "/scm.SourceControl/DisconnectProvider"
+	SourceControlListConnections  = "/scm.SourceControl/ListConnections"
+	SourceControlListRepositories = "/scm.SourceControl/ListRepositories"
+	SourceControlListBranches     = "/scm.SourceControl/ListBranches"
+)
+
+// Diagnostics service endpoints
+const (
+	// Service name
+	DiagnosticsService = "diagnostics.Diagnostics"
+
+	DiagnosticsDiagnose = "/diagnostics.Diagnostics/Diagnose"
+)
+
+// Build agent endpoints
+const (
+	// Service name
+	AgentsService = "agent.Agents"
+
+	AgentsRegister       = "/agent.Agents/Register"
+	AgentsHeartbeat      = "/agent.Agents/Heartbeat"
+	AgentsReceiveWork    = "/agent.Agents/ReceiveWork"
+	AgentsDownloadSource = "/agent.Agents/DownloadSource"
+	AgentsUploadArtifact = "/agent.Agents/UploadArtifact"
+	AgentsCompleteWork   = "/agent.Agents/CompleteWork"
+)
+
+// Secrets rotation endpoints
+const (
+	// Service name
+	SecretsService = "secrets.v1.Secrets"
+
+	SecretsRotateSigningKey  = "/secrets.v1.Secrets/RotateSigningKey"
+	SecretsReencryptSecrets  = "/secrets.v1.Secrets/ReencryptSecrets"
+	SecretsGetRotationStatus = "/secrets.v1.Secrets/GetRotationStatus"
+)
+
+// PublicEndpoints defines endpoints that don't require authentication.
+// Agent endpoints check the agent token in their handler instead.
 var PublicEndpoints = map[string]bool{
 	AuthRegister:      true,
 	AuthLogin:         true,
 	AuthValidateToken: true,
 	AuthRefreshToken:  true,
+
+	LegacyAuthRegister:      true,
+	LegacyAuthLogin:         true,
+	LegacyAuthValidateToken: true,
+	LegacyAuthRefreshToken:  true,
+
+	ServerInfo: true,
+
+	AgentsRegister:       true,
+	AgentsHeartbeat:      true,
+	AgentsReceiveWork:    true,
+	AgentsDownloadSource: true,
+	AgentsUploadArtifact: true,
+	AgentsCompleteWork:   true,
+}
+
+// ProjectReadEndpoints defines unary endpoints that read a single project,
+// named by the request's project_id. Callers are admitted by the project's
+// visibility, anonymous ones included when it is public.
+var ProjectReadEndpoints = map[string]bool{
+	PublicProjectsGetProjectStatus: true,
+	PublicProjectsListBuilds:       true,
+}
+
+// AdminEndpoints defines endpoints that require the admin role
+var AdminEndpoints = map[string]bool{
+	AuthImpersonateUser:           true,
+	AuthRevokeImpersonation:       true,
+	PipelineUpdateNodeVersions:    true,
+	PipelineExportUsage:           true,
+	PipelineVerifyBuild:           true,
+	PipelineGetConcurrency:        true,
+	PipelineReleaseDeployLock:     true,
+	PipelineDescribeDeployTargets: true,
+	DiagnosticsDiagnose:           true,
+	ProjectSimulatePush:           true,
+	SecretsRotateSigningKey:       true,
+	SecretsReencryptSecrets:       true,
+	SecretsGetRotationStatus:      true,
+}
+
+// CompressedEndpoints defines streaming endpoints whose responses are gzip
+// compressed when compression is enabled and the client accepts it
+var CompressedEndpoints = map[string]bool{
+	PipelineGetAppLogs: true,
 }
@@ -0,0 +1,29 @@
+package api
+
+// Versions are the versions of the gRPC API the server speaks, oldest
+// first. Services outside versioned packages are listed without one.
+var Versions = []string{"v1"}
+
+// Service describes a gRPC service the server registers
+type Service struct {
+	Name       string
+	Version    string // Empty for unversioned services
+	Deprecated bool
+	ReplacedBy string // The service deprecated ones are to be replaced with
+}
+
+// Services are the services the server registers, reported by ServerInfo
+var Services = []Service{
+	{Name: AuthService, Version: "v1"},
+	{Name: LegacyAuthService, Deprecated: true, ReplacedBy: AuthService},
+	{Name: PipelineService, Version: "v1"},
+	{Name: ServerService, Version: "v1"},
+	{Name: PublicProjectsService, Version: "v1"},
+	{Name: SecretsService, Version: "v1"},
+	{Name: ProjectService},
+	{Name: WebhookService},
+	{Name: SubscriptionsService},
+	{Name: SourceControlService},
+	{Name: DiagnosticsService},
+	{Name: AgentsService},
+}
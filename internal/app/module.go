@@ -3,15 +3,36 @@ package app
 import (
 	"context"
 	"os"
+	"time"
 
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
+	"github.com/elskow/chef-infra/internal/audit"
 	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/cache"
 	"github.com/elskow/chef-infra/internal/config"
 	"github.com/elskow/chef-infra/internal/database"
+	"github.com/elskow/chef-infra/internal/diagnostics"
+	"github.com/elskow/chef-infra/internal/events"
+	"github.com/elskow/chef-infra/internal/github"
+	"github.com/elskow/chef-infra/internal/httpsec"
+	"github.com/elskow/chef-infra/internal/leader"
 	"github.com/elskow/chef-infra/internal/migration"
+	"github.com/elskow/chef-infra/internal/pipeline"
+	"github.com/elskow/chef-infra/internal/pipeline/addon"
+	pipelineconfig "github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/source"
+	"github.com/elskow/chef-infra/internal/pipeline/store"
+	"github.com/elskow/chef-infra/internal/pipeline/usage"
+	"github.com/elskow/chef-infra/internal/pipeline/validator"
+	"github.com/elskow/chef-infra/internal/project"
+	"github.com/elskow/chef-infra/internal/scm"
+	"github.com/elskow/chef-infra/internal/secrets"
 	"github.com/elskow/chef-infra/internal/server"
+	"github.com/elskow/chef-infra/internal/subscription"
+	"github.com/elskow/chef-infra/internal/version"
+	"github.com/elskow/chef-infra/internal/webhook"
 )
 
 func Module() fx.Option {
@@ -28,24 +49,289 @@ func Module() fx.Option {
 		// Migration (after database is set up)
 		migration.Module(),
 
+		// Leader election for background singletons. Its hooks come before
+		// the jobs' dependencies stop and after the database is up.
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig, dbm *database.Manager, log *zap.Logger) (*leader.Elector, error) {
+					if !config.Leader.Enabled {
+						return leader.NewElector(nil, 0, log), nil
+					}
+					sqlDB, err := dbm.DB().DB()
+					if err != nil {
+						return nil, err
+					}
+					lock := leader.NewPostgresLock(sqlDB, config.Leader.LockID)
+					return leader.NewElector(lock, config.Leader.RetryInterval, log), nil
+				},
+			),
+		),
+		fx.Invoke(registerLeaderHooks),
+
+		// Shared cache, Redis-backed when configured
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig, log *zap.Logger) cache.Store {
+					return cache.New(&config.Redis, log)
+				},
+			),
+			fx.Annotate(
+				func(config *config.AppConfig, store cache.Store) *cache.Limiter {
+					return cache.NewLimiter(store, config.RateLimit.RequestsPerMinute, time.Minute)
+				},
+			),
+		),
+
+		// Event bus for build, deploy and auth events
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig, log *zap.Logger) (events.Bus, error) {
+					return events.New(&config.Events, log)
+				},
+			),
+		),
+
+		// Secrets Module: master key encryption and rotation of JWT signing
+		// keys
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig) (*secrets.Keyring, error) {
+					return secrets.NewKeyring(&config.Secrets)
+				},
+			),
+			fx.Annotate(
+				func(dbm *database.Manager) secrets.Repository {
+					return secrets.NewRepository(dbm.DB())
+				},
+			),
+			fx.Annotate(
+				func(config *config.AppConfig, repo secrets.Repository, keyring *secrets.Keyring, log *zap.Logger) *secrets.SigningKeys {
+					return secrets.NewSigningKeys(repo, keyring, &config.Auth, &config.Secrets, log)
+				},
+			),
+			// Stored secrets sealed with the master key besides the signing keys
+			fx.Annotate(
+				func(config *config.AppConfig, repo secrets.Repository, keyring *secrets.Keyring, keys *secrets.SigningKeys, log *zap.Logger) *secrets.Rotator {
+					columns := []secrets.Column{
+						{Table: "provider_connections", Name: "access_token"},
+						{Table: "webhooks", Name: "secret"},
+					}
+					return secrets.NewRotator(repo, keyring, keys, columns, &config.Secrets, log)
+				},
+			),
+			fx.Annotate(
+				func(keys *secrets.SigningKeys, rotator *secrets.Rotator, keyring *secrets.Keyring, dbm *database.Manager, bus events.Bus, log *zap.Logger) *secrets.Handler {
+					return secrets.NewHandler(keys, rotator, keyring, dbm, bus, log)
+				},
+			),
+		),
+		fx.Invoke(registerSecretsHooks),
+
 		// Auth Module
 		fx.Provide(
+			fx.Annotate(
+				func(dbm *database.Manager) auth.Repository {
+					return auth.NewRepository(dbm.DB())
+				},
+			),
 			// Provide AuthMiddleware
 			fx.Annotate(
-				func(config *config.AppConfig) *auth.AuthMiddleware {
-					return auth.NewAuthMiddleware(&config.Auth)
+				func(config *config.AppConfig, repo auth.Repository, keys *secrets.SigningKeys) *auth.AuthMiddleware {
+					return auth.NewAuthMiddleware(&config.Auth, repo, keys)
 				},
 			),
 			// Provide AuthService
 			fx.Annotate(
-				func(config *config.AppConfig, log *zap.Logger, dbm *database.Manager) *auth.Service {
-					return auth.NewService(&config.Auth, log, auth.NewRepository(dbm.DB()))
+				func(config *config.AppConfig, log *zap.Logger, repo auth.Repository, store cache.Store, keys *secrets.SigningKeys) *auth.Service {
+					return auth.NewService(&config.Auth, log, repo, store, keys)
 				},
 			),
 			// Provide AuthHandler
 			fx.Annotate(
-				func(svc *auth.Service, log *zap.Logger) *auth.Handler {
-					return auth.NewHandler(svc, log)
+				func(svc *auth.Service, bus events.Bus, log *zap.Logger) *auth.Handler {
+					return auth.NewHandler(svc, bus, log)
+				},
+			),
+			fx.Annotate(auth.NewLegacyHandler),
+		),
+
+		// Audit Module
+		fx.Provide(
+			fx.Annotate(
+				func(log *zap.Logger, dbm *database.Manager) *audit.Service {
+					return audit.NewService(audit.NewRepository(dbm.DB(), dbm), log)
+				},
+			),
+			fx.Annotate(
+				func(svc *audit.Service) pipeline.AuditRecorder {
+					return svc
+				},
+			),
+		),
+
+		// Project Module
+		fx.Provide(
+			fx.Annotate(
+				func(dbm *database.Manager, store cache.Store) project.Repository {
+					return project.NewCachedRepository(project.NewRepository(dbm.DB(), dbm), store)
+				},
+			),
+			fx.Annotate(
+				func(repo project.Repository, authSvc *auth.Service, importer *scm.Importer, log *zap.Logger) *project.Service {
+					return project.NewService(repo, authSvc, importer, log)
+				},
+			),
+			fx.Annotate(
+				func(svc *project.Service, log *zap.Logger) *project.Handler {
+					return project.NewHandler(svc, log)
+				},
+			),
+			// Project ownership backs RBAC checks in the pipeline API
+			fx.Annotate(
+				func(svc *project.Service) pipeline.ProjectAuthorizer {
+					return svc
+				},
+			),
+			// Project owners choose who may read their status page
+			fx.Annotate(
+				func(svc *project.Service) pipeline.StatusPageAuthorizer {
+					return svc
+				},
+			),
+			// Purging a project tears down what the pipeline built and deployed
+			fx.Annotate(
+				func(config *config.AppConfig, repo project.Repository, p *pipeline.Pipeline, log *zap.Logger) *project.Purger {
+					return project.NewPurger(repo, p, &config.Project, log)
+				},
+			),
+		),
+
+		// Notification Module: webhooks, user subscriptions, GitHub commit
+		// statuses and releases
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig, log *zap.Logger, dbm *database.Manager, keyring *secrets.Keyring) *webhook.Service {
+					return webhook.NewService(webhook.NewRepository(dbm.DB(), dbm, keyring), &config.Webhook, log)
+				},
+			),
+			fx.Annotate(
+				func(config *config.AppConfig, log *zap.Logger) (*github.Reporter, error) {
+					return github.NewReporter(&config.GitHub, config.I18n.Locale(), log)
+				},
+			),
+			// Deploys are released on GitHub
+			fx.Annotate(
+				func(reporter *github.Reporter) pipeline.Releaser {
+					return reporter
+				},
+			),
+			// Branch lanes are shared with the other instances through Redis
+			fx.Annotate(
+				func(config *config.AppConfig, store cache.Store) pipeline.LaneStore {
+					if config.Redis.Addr == "" {
+						return nil
+					}
+					return store
+				},
+			),
+			// Build and deploy lifecycle events are published on the bus
+			fx.Annotate(
+				func(bus events.Bus, log *zap.Logger) pipeline.Notifier {
+					return events.NewNotifier(bus, log)
+				},
+			),
+			fx.Annotate(
+				func(svc *webhook.Service, projects *project.Service, log *zap.Logger) *webhook.Handler {
+					return webhook.NewHandler(svc, projects, log)
+				},
+			),
+			// Users subscribe their own channels to saved build filters
+			fx.Annotate(
+				func(config *config.AppConfig, log *zap.Logger, dbm *database.Manager, projects *project.Service) *subscription.Service {
+					return subscription.NewService(subscription.NewRepository(dbm.DB()), projects, &config.Webhook, log)
+				},
+			),
+			fx.Annotate(
+				func(svc *subscription.Service, log *zap.Logger) *subscription.Handler {
+					return subscription.NewHandler(svc, log)
+				},
+			),
+		),
+		// Registered before the pipeline so queued events from builds
+		// cancelled at shutdown are still delivered
+		fx.Invoke(registerWebhookHooks),
+		fx.Invoke(registerSubscriptionHooks),
+		fx.Invoke(registerGitHubHooks),
+		// After the consumers' hooks so the bus drains into them on shutdown
+		fx.Invoke(registerEventConsumers),
+
+		// Source Control Module
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig, log *zap.Logger, dbm *database.Manager, keyring *secrets.Keyring) *scm.Service {
+					return scm.NewService(scm.NewRepository(dbm.DB(), keyring), log,
+						scm.NewGitHubProvider(config.GitHub.APIURL),
+					)
+				},
+			),
+			// Clones and inspects repositories for CreateProjectFromRepo
+			fx.Annotate(
+				func(config *config.AppConfig, svc *scm.Service, log *zap.Logger) *scm.Importer {
+					return scm.NewImporter(svc, source.NewFetcher(&config.Pipeline.Source, log),
+						config.GitHub.PushHookURL, config.GitHub.PushHookSecret, log)
+				},
+			),
+			fx.Annotate(
+				func(svc *scm.Service, log *zap.Logger) *scm.Handler {
+					return scm.NewHandler(svc, log)
+				},
+			),
+		),
+
+		// HTTP security middleware
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig) httpsec.Middleware {
+					return httpsec.New(&config.HTTP)
+				},
+			),
+		),
+
+		// Pipeline Module
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig) *pipelineconfig.PipelineConfig {
+					return &config.Pipeline
+				},
+			),
+			fx.Annotate(
+				func(dbm *database.Manager) pipeline.BuildStore {
+					return store.New(dbm.DB())
+				},
+			),
+			fx.Annotate(
+				func(dbm *database.Manager) usage.Store {
+					return store.New(dbm.DB())
+				},
+			),
+			fx.Annotate(
+				func(dbm *database.Manager) addon.Store {
+					return store.New(dbm.DB())
+				},
+			),
+			fx.Annotate(
+				func(dbm *database.Manager) validator.VersionStore {
+					return store.New(dbm.DB())
+				},
+			),
+		),
+		pipeline.Module(),
+
+		// Diagnostics
+		fx.Provide(
+			fx.Annotate(
+				func(config *config.AppConfig, log *zap.Logger) *diagnostics.Handler {
+					return diagnostics.NewHandler(diagnostics.NewRunner(config, diagnostics.DefaultChecks()), log)
 				},
 			),
 		),
@@ -55,6 +341,8 @@ func Module() fx.Option {
 
 		// Start the server
 		fx.Invoke(registerHooks),
+		fx.Invoke(registerPurgerHooks),
+		fx.Invoke(registerUpdateCheck),
 	)
 }
 
@@ -84,3 +372,114 @@ func registerHooks(
 		},
 	})
 }
+
+func registerPurgerHooks(elector *leader.Elector, purger *project.Purger) {
+	elector.Register("project-purger", purger)
+}
+
+// registerUpdateCheck logs newer chef-infra releases when enabled
+func registerUpdateCheck(config *config.AppConfig, elector *leader.Elector, log *zap.Logger) {
+	if !config.UpdateCheck.Enabled {
+		return
+	}
+	check := &config.UpdateCheck
+	elector.Register("update-check", version.NewChecker(check.URL, check.Interval, log))
+}
+
+// registerSecretsHooks loads the signing keys once migrations have run and
+// re-encrypts stored secrets on the leader
+func registerSecretsHooks(lifecycle fx.Lifecycle, keys *secrets.SigningKeys, rotator *secrets.Rotator, elector *leader.Elector) {
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := keys.Load(); err != nil {
+				return err
+			}
+			keys.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			keys.Stop()
+			return nil
+		},
+	})
+	elector.Register("secrets-rotation", rotator)
+}
+
+func registerLeaderHooks(lifecycle fx.Lifecycle, elector *leader.Elector) {
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			elector.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			elector.Stop()
+			return nil
+		},
+	})
+}
+
+// registerEventConsumers subscribes webhooks, user subscriptions, commit
+// statuses and the audit log to the bus, and closes it once the pipeline has stopped publishing
+func registerEventConsumers(
+	lifecycle fx.Lifecycle,
+	bus events.Bus,
+	webhooks *webhook.Service,
+	subscriptions *subscription.Service,
+	reporter *github.Reporter,
+	auditor *audit.Service,
+) error {
+	consumers := map[string]events.Handler{
+		"webhooks":        events.LifecycleHandler(webhooks),
+		"subscriptions":   events.LifecycleHandler(subscriptions),
+		"github-statuses": events.LifecycleHandler(reporter),
+		"audit":           auditor.HandleEvent,
+	}
+	for name, handler := range consumers {
+		if err := bus.Subscribe(name, handler); err != nil {
+			return err
+		}
+	}
+
+	lifecycle.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return bus.Close(ctx)
+		},
+	})
+	return nil
+}
+
+func registerWebhookHooks(lifecycle fx.Lifecycle, svc *webhook.Service) {
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			svc.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return svc.Stop(ctx)
+		},
+	})
+}
+
+func registerSubscriptionHooks(lifecycle fx.Lifecycle, svc *subscription.Service) {
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			svc.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return svc.Stop(ctx)
+		},
+	})
+}
+
+func registerGitHubHooks(lifecycle fx.Lifecycle, reporter *github.Reporter) {
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			reporter.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return reporter.Stop(ctx)
+		},
+	})
+}
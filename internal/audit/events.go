@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/events"
+)
+
+// systemActor records events the server caused on its own, such as an
+// automatic rollback
+const systemActor = "system"
+
+// HandleEvent records auth, secrets and deploy events from the event bus.
+// Build progress is left to the build history.
+func (s *Service) HandleEvent(event events.Event) {
+	details := map[string]interface{}{"source": event.Source}
+	if event.Message != "" {
+		details["message"] = event.Message
+	}
+
+	actor, resource := event.Actor, ""
+	switch {
+	case strings.HasPrefix(event.Type, "auth."):
+		resource = "user:" + event.Actor
+		if event.Subject != "" {
+			resource = "user:" + event.Subject
+		}
+	case strings.HasPrefix(event.Type, "secrets."):
+		resource = "secrets"
+	case strings.HasPrefix(event.Type, "deploy.") && event.Build != nil:
+		actor = systemActor
+		resource = event.Build.ProjectID
+		details["build_id"] = event.Build.ID
+		details["environment"] = event.Build.Environment
+	default:
+		return
+	}
+
+	if err := s.Record(actor, event.Type, resource, details); err != nil {
+		s.log.Error("failed to record audit entry",
+			zap.String("action", event.Type),
+			zap.String("resource", resource),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,16 @@
+package audit
+
+import "time"
+
+type Entry struct {
+	ID        uint   `gorm:"primaryKey"`
+	Actor     string `gorm:"index;not null"` // Username performing the action
+	Action    string `gorm:"index;not null"` // e.g. "exec.start"
+	Resource  string `gorm:"index"`          // e.g. the project name
+	Details   string `gorm:"type:jsonb"`
+	CreatedAt time.Time
+}
+
+func (Entry) TableName() string {
+	return "audit_logs"
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/elskow/chef-infra/internal/database"
+	"github.com/elskow/chef-infra/internal/pagination"
+)
+
+// Filter narrows audit queries; empty fields match everything
+type Filter struct {
+	Actor    string
+	Action   string
+	Resource string
+}
+
+type Repository interface {
+	CreateEntry(entry *Entry) error
+	ListEntries(filter Filter, params pagination.Params) (*pagination.Page[Entry], error)
+}
+
+var listSpec = pagination.Spec{
+	SortFields: map[string]string{
+		"created_at": "created_at",
+	},
+	DefaultSort:   "-created_at",
+	SearchColumns: []string{"action", "resource"},
+}
+
+type repository struct {
+	db    *gorm.DB
+	reads database.ReadSource
+}
+
+func NewRepository(db *gorm.DB, reads database.ReadSource) Repository {
+	return &repository{db: db, reads: reads}
+}
+
+func (r *repository) CreateEntry(entry *Entry) error {
+	return r.db.Create(entry).Error
+}
+
+// ListEntries returns a page of matching entries, newest first by default
+func (r *repository) ListEntries(filter Filter, params pagination.Params) (*pagination.Page[Entry], error) {
+	query := r.reads.ReadDB()
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Resource != "" {
+		query = query.Where("resource = ?", filter.Resource)
+	}
+	return pagination.List[Entry](query, params, listSpec)
+}
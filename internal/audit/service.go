@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+type Service struct {
+	repository Repository
+	log        *zap.Logger
+}
+
+func NewService(repo Repository, log *zap.Logger) *Service {
+	return &Service{
+		repository: repo,
+		log:        log,
+	}
+}
+
+// Record persists an audit entry. Entries are also written to the log so
+// they survive a database outage.
+func (s *Service) Record(actor, action, resource string, details map[string]interface{}) error {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details: %w", err)
+	}
+
+	s.log.Info("audit",
+		zap.String("actor", actor),
+		zap.String("action", action),
+		zap.String("resource", resource),
+		zap.ByteString("details", payload))
+
+	entry := &Entry{
+		Actor:    actor,
+		Action:   action,
+		Resource: resource,
+		Details:  string(payload),
+	}
+	if err := s.repository.CreateEntry(entry); err != nil {
+		return fmt.Errorf("failed to store audit entry: %w", err)
+	}
+	return nil
+}
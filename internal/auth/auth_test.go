@@ -1,13 +1,16 @@
 package auth
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 
+	"github.com/elskow/chef-infra/internal/cache"
 	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/events"
 )
 
 func newTestLogger(t *testing.T) *zap.Logger {
@@ -30,11 +33,13 @@ func newTestService(t *testing.T) *Service {
 		newTestConfig(),
 		newTestLogger(t),
 		newMockRepository(),
+		cache.NewMemory(),
+		nil,
 	)
 }
 
 func newTestHandler(t *testing.T) *Handler {
-	return NewHandler(newTestService(t), newTestLogger(t))
+	return NewHandler(newTestService(t), &recordingPublisher{}, newTestLogger(t))
 }
 
 func newTestServiceWithRepo(t *testing.T, repo Repository) *Service {
@@ -42,5 +47,17 @@ func newTestServiceWithRepo(t *testing.T, repo Repository) *Service {
 		newTestConfig(),
 		newTestLogger(t),
 		repo,
+		cache.NewMemory(),
+		nil,
 	)
 }
+
+// recordingPublisher remembers the auth events a handler publishes
+type recordingPublisher struct {
+	events []events.Event
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, event events.Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
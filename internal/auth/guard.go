@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/peer"
+
+	"github.com/elskow/chef-infra/internal/cache"
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+// tokenGuard keeps the public ValidateToken endpoint from being used to
+// guess tokens. Failed validations are counted per client address and
+// callers over the limit are turned away before their token is parsed.
+// Every failure takes at least the configured delay, so response times
+// don't tell why a token was rejected.
+type tokenGuard struct {
+	failures *cache.Limiter
+	delay    time.Duration
+	log      *zap.Logger
+}
+
+func newTokenGuard(config *config.AuthConfig, store cache.Store, log *zap.Logger) *tokenGuard {
+	limit := config.ValidateFailureLimit
+	if store == nil {
+		limit = 0
+	}
+	return &tokenGuard{
+		failures: cache.NewLimiter(store, limit, time.Minute),
+		delay:    config.ValidateFailureDelay,
+		log:      log,
+	}
+}
+
+// throttled reports whether addr failed too many validations this minute.
+// Counting errors let the request through.
+func (g *tokenGuard) throttled(ctx context.Context, addr string) bool {
+	exceeded, err := g.failures.Exceeded(ctx, "validate:"+addr)
+	if err != nil {
+		g.log.Warn("failed to check token validation failures", zap.Error(err))
+		return false
+	}
+	return exceeded
+}
+
+// failed counts a failed validation for addr and holds the response until
+// the failure delay has passed since start
+func (g *tokenGuard) failed(ctx context.Context, addr string, start time.Time) {
+	if _, err := g.failures.Allow(ctx, "validate:"+addr); err != nil {
+		g.log.Warn("failed to count token validation failure", zap.Error(err))
+	}
+
+	wait := g.delay - time.Since(start)
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// ClientAddr returns the caller's IP address without its port
+func ClientAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
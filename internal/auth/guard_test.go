@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/cache"
+	pb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+)
+
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+}
+
+func TestValidateToken_ThrottlesFailures(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ValidateFailureLimit = 2
+	cfg.ValidateFailureDelay = 20 * time.Millisecond
+	h := NewHandler(NewService(cfg, newTestLogger(t), newMockRepository(), cache.NewMemory(), nil), &recordingPublisher{}, newTestLogger(t))
+	ctx := peerContext("10.0.0.1")
+
+	token, err := h.service.GenerateToken("testuser")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		resp, err := h.ValidateToken(ctx, &pb.ValidateTokenRequest{Token: "guess"})
+		require.NoError(t, err)
+		assert.False(t, resp.Valid)
+		assert.Equal(t, "invalid token", resp.Message)
+		assert.GreaterOrEqual(t, time.Since(start), cfg.ValidateFailureDelay, "failures take the failure delay")
+	}
+
+	// Over the limit even valid tokens are refused until the window ends
+	_, err = h.ValidateToken(ctx, &pb.ValidateTokenRequest{Token: token})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Other addresses are unaffected
+	resp, err := h.ValidateToken(peerContext("10.0.0.2"), &pb.ValidateTokenRequest{Token: token})
+	require.NoError(t, err)
+	assert.True(t, resp.Valid)
+}
+
+func TestValidateToken_SameMessageForEveryFailure(t *testing.T) {
+	h := newTestHandler(t)
+
+	expired := NewService(newTestConfig(), newTestLogger(t), newMockRepository(), nil, nil)
+	expired.config.AccessTokenDuration = -time.Minute
+	expiredToken, err := expired.GenerateToken("testuser")
+	require.NoError(t, err)
+
+	for _, token := range []string{"malformed", "invalid.token.here", expiredToken} {
+		resp, err := h.ValidateToken(context.Background(), &pb.ValidateTokenRequest{Token: token})
+		require.NoError(t, err)
+		assert.False(t, resp.Valid)
+		assert.Equal(t, "invalid token", resp.Message)
+	}
+}
+
+func TestCheckToken_Cache(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ValidateCacheTTL = time.Minute
+	svc := NewService(cfg, newTestLogger(t), newMockRepository(), cache.NewMemory(), nil)
+	ctx := context.Background()
+
+	token, err := svc.GenerateToken("testuser")
+	require.NoError(t, err)
+	claims, err := svc.CheckToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", claims.Username)
+
+	// A cached token is not parsed again, so rotating the secret doesn't
+	// affect it until the entry expires
+	cfg.JWTSecret = "rotated-secret-key"
+	claims, err = svc.CheckToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", claims.Username)
+
+	// Without a cache TTL every check parses the token
+	cfg.ValidateCacheTTL = 0
+	_, err = svc.CheckToken(ctx, token)
+	assert.Error(t, err)
+}
+
+func TestClientAddr(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", ClientAddr(peerContext("10.0.0.1")))
+	assert.Equal(t, "unknown", ClientAddr(context.Background()))
+}
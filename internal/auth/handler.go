@@ -2,80 +2,80 @@ package auth
 
 import (
 	"context"
-	"net/mail"
+	"errors"
+	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	pb "github.com/elskow/chef-infra/proto/gen/auth"
+	"github.com/elskow/chef-infra/internal/events"
+	"github.com/elskow/chef-infra/internal/i18n"
+	pb "github.com/elskow/chef-infra/proto/gen/auth/v1"
 )
 
+// Handler serves auth.v1.Auth. Requests reach it already checked against
+// the field rules in auth.proto by the server's validation interceptor.
 type Handler struct {
 	pb.UnimplementedAuthServer
 	service *Service
+	guard   *tokenGuard
+	events  events.Publisher
 	log     *zap.Logger
 }
 
-func NewHandler(service *Service, log *zap.Logger) *Handler {
+func NewHandler(service *Service, publisher events.Publisher, log *zap.Logger) *Handler {
 	return &Handler{
 		service: service,
+		guard:   newTokenGuard(service.config, service.store, log),
+		events:  publisher,
 		log:     log,
 	}
 }
 
-func (h *Handler) Register(_ context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
-	// Validate input fields
-	if err := validateRegisterRequest(req); err != nil {
-		h.log.Warn("invalid register request",
-			zap.String("error", err.Error()),
-			zap.Any("request", req))
-		return nil, err
-	}
-
+func (h *Handler) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 	h.log.Info("handling register request", zap.String("username", req.Username))
 
 	// Check if user already exists
 	if _, err := h.service.repository.GetUserByUsername(req.Username); err == nil {
-		return nil, status.Error(codes.AlreadyExists, "username already taken")
+		return nil, status.Error(codes.AlreadyExists, i18n.T(ctx, i18n.UsernameTaken))
 	}
 
 	// Check if email already exists
 	if _, err := h.service.repository.GetUserByEmail(req.Email); err == nil {
-		return nil, status.Error(codes.AlreadyExists, "email already registered")
+		return nil, status.Error(codes.AlreadyExists, i18n.T(ctx, i18n.EmailTaken))
 	}
 
 	// Register the user
 	if err := h.service.RegisterUser(req.Username, req.Password, req.Email); err != nil {
 		if err == ErrUserExists {
-			return nil, status.Error(codes.AlreadyExists, "user already exists")
+			return nil, status.Error(codes.AlreadyExists, i18n.T(ctx, i18n.UserExists))
 		}
 		h.log.Error("failed to register user", zap.Error(err))
 		return nil, status.Error(codes.Internal, "failed to register user")
 	}
 
+	h.rememberLocale(ctx, req.Username)
+	h.publish(ctx, events.AuthRegistered, req.Username, "")
+
 	return &pb.RegisterResponse{
 		Success: true,
-		Message: "User registered successfully",
+		Message: i18n.T(ctx, i18n.Registered),
 	}, nil
 }
 
-func (h *Handler) Login(_ context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
-	if err := validateLoginRequest(req); err != nil {
-		h.log.Warn("invalid login request",
-			zap.String("error", err.Error()),
-			zap.String("username", req.Username))
-		return nil, err
-	}
-
+func (h *Handler) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
 	// Validate credentials and generate tokens
 	accessToken, refreshToken, err := h.service.ValidateLoginWithRefresh(req.Username, req.Password)
 	if err != nil {
 		if err == ErrUserNotFound {
-			return nil, status.Error(codes.NotFound, "user not found")
+			h.publish(ctx, events.AuthLoginFailed, req.Username, err.Error())
+			return nil, status.Error(codes.NotFound, i18n.T(ctx, i18n.UserNotFound))
 		}
 		if err == ErrInvalidPassword {
-			return nil, status.Error(codes.Unauthenticated, "invalid password")
+			h.publish(ctx, events.AuthLoginFailed, req.Username, err.Error())
+			return nil, status.Error(codes.Unauthenticated, i18n.T(ctx, i18n.InvalidPassword))
 		}
 		h.log.Error("login failed",
 			zap.String("username", req.Username),
@@ -83,44 +83,57 @@ func (h *Handler) Login(_ context.Context, req *pb.LoginRequest) (*pb.LoginRespo
 		return nil, status.Error(codes.Internal, "login failed")
 	}
 
+	h.rememberLocale(ctx, req.Username)
+	h.publish(ctx, events.AuthLogin, req.Username, "")
+
 	return &pb.LoginResponse{
 		Success:      true,
-		Message:      "Login successful",
+		Message:      i18n.T(ctx, i18n.LoggedIn),
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 	}, nil
 }
 
-func (h *Handler) ValidateToken(_ context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
+// ValidateToken is public, so callers that fail too many validations are
+// throttled and every failure gets the same message, hiding whether the
+// token was malformed, forged or expired
+func (h *Handler) ValidateToken(ctx context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
 	if req.Token == "" {
 		return &pb.ValidateTokenResponse{
 			Valid:   false,
-			Message: "token is required",
+			Message: i18n.T(ctx, i18n.TokenRequired),
 		}, nil
 	}
 
-	claims, err := h.service.ValidateToken(req.Token)
+	start := time.Now()
+	addr := ClientAddr(ctx)
+	if h.guard.throttled(ctx, addr) {
+		h.log.Warn("token validation throttled", zap.String("caller", addr))
+		return nil, status.Error(codes.ResourceExhausted, i18n.T(ctx, i18n.RateLimited))
+	}
+
+	claims, err := h.service.CheckToken(ctx, req.Token)
 	if err != nil {
+		h.guard.failed(ctx, addr, start)
 		return &pb.ValidateTokenResponse{
 			Valid:   false,
-			Message: err.Error(),
+			Message: i18n.T(ctx, i18n.TokenInvalid),
 		}, nil
 	}
 
 	return &pb.ValidateTokenResponse{
 		Valid:    true,
 		Username: claims.Username,
-		Message:  "Token is valid",
+		Message:  i18n.T(ctx, i18n.TokenValid),
 	}, nil
 }
 
-func (h *Handler) RefreshToken(_ context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
-	if req.RefreshToken == "" {
-		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
-	}
-
+func (h *Handler) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
 	// Generate new token pair using refresh token
 	accessToken, refreshToken, err := h.service.RefreshTokenPair(req.RefreshToken)
+	if errors.Is(err, ErrTokenReused) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
 	if err != nil {
 		h.log.Error("failed to refresh token", zap.Error(err))
 		return nil, status.Error(codes.Internal, "failed to refresh token")
@@ -130,43 +143,106 @@ func (h *Handler) RefreshToken(_ context.Context, req *pb.RefreshTokenRequest) (
 		Success:      true,
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
-		Message:      "Token refreshed successfully",
+		Message:      i18n.T(ctx, i18n.TokenRefreshed),
 	}, nil
 }
 
-func validateRegisterRequest(req *pb.RegisterRequest) error {
-	if req.Username == "" {
-		return status.Error(codes.InvalidArgument, "username is required")
-	}
-	if len(req.Username) < 3 || len(req.Username) > 32 {
-		return status.Error(codes.InvalidArgument, "username must be between 3 and 32 characters")
+// ImpersonateUser issues the calling admin a token acting as another user.
+// The server checks the admin role; sessions that are impersonating can't
+// start another.
+func (h *Handler) ImpersonateUser(ctx context.Context, req *pb.ImpersonateUserRequest) (*pb.ImpersonateUserResponse, error) {
+	admin, err := h.impersonationAdmin(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if req.Password == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+
+	impersonation, err := h.service.Impersonate(admin, req.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrImpersonationDisabled):
+			return nil, status.Error(codes.FailedPrecondition, i18n.T(ctx, i18n.ImpersonationDisabled))
+		case errors.Is(err, ErrImpersonateSelf):
+			return nil, status.Error(codes.InvalidArgument, i18n.T(ctx, i18n.ImpersonateSelf))
+		case errors.Is(err, ErrUserNotFound):
+			return nil, status.Error(codes.NotFound, i18n.T(ctx, i18n.UserNotFound))
+		}
+		h.log.Error("failed to issue impersonation token", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to impersonate user")
+	}
+
+	h.log.Warn("impersonation started",
+		zap.String("admin", admin),
+		zap.String("username", req.Username),
+		zap.String("token_id", impersonation.ID),
+		zap.String("reason", req.Reason))
+	h.publishEvent(ctx, events.Event{
+		Type:    events.AuthImpersonationStarted,
+		Actor:   admin,
+		Subject: req.Username,
+		Message: fmt.Sprintf("token %s: %s", impersonation.ID, req.Reason),
+	})
+
+	return &pb.ImpersonateUserResponse{
+		AccessToken: impersonation.Token,
+		TokenId:     impersonation.ID,
+		ExpiresAt:   impersonation.ExpiresAt.Unix(),
+	}, nil
+}
+
+// RevokeImpersonation rejects an impersonation token from now on
+func (h *Handler) RevokeImpersonation(ctx context.Context, req *pb.RevokeImpersonationRequest) (*pb.RevokeImpersonationResponse, error) {
+	admin, err := h.impersonationAdmin(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if len(req.Password) < 8 {
-		return status.Error(codes.InvalidArgument, "password must be at least 8 characters")
+
+	if err := h.service.RevokeImpersonation(req.TokenId); err != nil {
+		h.log.Error("failed to revoke impersonation token", zap.String("token_id", req.TokenId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to revoke impersonation token")
 	}
-	if req.Email == "" {
-		return status.Error(codes.InvalidArgument, "email is required")
+
+	h.log.Warn("impersonation revoked", zap.String("admin", admin), zap.String("token_id", req.TokenId))
+	h.publishEvent(ctx, events.Event{
+		Type:    events.AuthImpersonationRevoked,
+		Actor:   admin,
+		Message: "token " + req.TokenId,
+	})
+	return &pb.RevokeImpersonationResponse{Success: true}, nil
+}
+
+// impersonationAdmin returns the admin managing impersonation, refusing
+// callers that are themselves impersonating
+func (h *Handler) impersonationAdmin(ctx context.Context) (string, error) {
+	if _, ok := GetImpersonatorFromContext(ctx); ok {
+		return "", status.Error(codes.PermissionDenied, i18n.T(ctx, i18n.ImpersonationNested))
 	}
-	if !isValidEmail(req.Email) {
-		return status.Error(codes.InvalidArgument, "invalid email format")
+	admin, err := GetUserFromContext(ctx)
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, i18n.T(ctx, i18n.AuthRequired))
 	}
-	return nil
+	return admin, nil
 }
 
-func validateLoginRequest(req *pb.LoginRequest) error {
-	if req.Username == "" {
-		return status.Error(codes.InvalidArgument, "username is required")
-	}
-	if req.Password == "" {
-		return status.Error(codes.InvalidArgument, "password is required")
+// rememberLocale saves the locale the client asked for on the user's
+// profile. Failing to save it does not fail the request.
+func (h *Handler) rememberLocale(ctx context.Context, username string) {
+	if err := h.service.RememberLocale(ctx, username); err != nil {
+		h.log.Warn("failed to save user locale",
+			zap.String("username", username),
+			zap.Error(err))
 	}
-	return nil
 }
 
-func isValidEmail(email string) bool {
-	_, err := mail.ParseAddress(email)
-	return err == nil
+// publish reports an auth event for the audit log and other consumers
+func (h *Handler) publish(ctx context.Context, eventType, username, message string) {
+	h.publishEvent(ctx, events.Event{Type: eventType, Actor: username, Message: message})
+}
+
+func (h *Handler) publishEvent(ctx context.Context, event events.Event) {
+	if err := h.events.Publish(ctx, event); err != nil {
+		h.log.Warn("failed to publish auth event",
+			zap.String("event", event.Type),
+			zap.String("username", event.Actor),
+			zap.Error(err))
+	}
 }
@@ -6,10 +6,15 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
-	pb "github.com/elskow/chef-infra/proto/gen/auth"
+	"github.com/elskow/chef-infra/internal/i18n"
+	"github.com/elskow/chef-infra/internal/validate"
+	pb "github.com/elskow/chef-infra/proto/gen/auth/v1"
 )
 
 func TestHandler_Register(t *testing.T) {
@@ -129,7 +134,7 @@ func TestHandler_Register(t *testing.T) {
 				require.NoError(t, setupErr, "Setup should succeed")
 			}
 
-			resp, err := h.Register(ctx, tt.request)
+			resp, err := serve(ctx, h.Register, tt.request)
 
 			if tt.wantCode != codes.OK {
 				require.Error(t, err, "Expected an error")
@@ -156,10 +161,31 @@ func TestHandler_Register(t *testing.T) {
 	}
 }
 
+func TestHandler_RegisterLocale(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("accept-language", "id-ID,id;q=0.9"))
+
+	_, err := serve(ctx, h.Register, &pb.RegisterRequest{Username: "ab", Password: "testpass123", Email: "test@example.com"})
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "nama pengguna harus terdiri dari 3 sampai 32 karakter", st.Message())
+
+	resp, err := h.Register(ctx, &pb.RegisterRequest{Username: "budi", Password: "testpass123", Email: "budi@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "Pendaftaran pengguna berhasil", resp.Message)
+
+	// The requested locale is saved on the profile
+	locale, ok := h.service.UserLocale("budi")
+	require.True(t, ok)
+	assert.Equal(t, i18n.Indonesian, locale)
+}
+
 func TestHandler_Login(t *testing.T) {
 	repo := newMockRepository()
 	svc := newTestServiceWithRepo(t, repo)
-	h := NewHandler(svc, newTestLogger(t))
+	publisher := &recordingPublisher{}
+	h := NewHandler(svc, publisher, newTestLogger(t))
 	ctx := context.Background()
 
 	registerResp, err := h.Register(ctx, &pb.RegisterRequest{
@@ -217,7 +243,7 @@ func TestHandler_Login(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp, err := h.Login(ctx, tt.request)
+			resp, err := serve(ctx, h.Login, tt.request)
 
 			if tt.wantCode != codes.OK {
 				require.Error(t, err)
@@ -232,12 +258,23 @@ func TestHandler_Login(t *testing.T) {
 			assert.NotEmpty(t, resp.Message)
 		})
 	}
+
+	var published []string
+	for _, event := range publisher.events {
+		published = append(published, event.Type+" "+event.Actor)
+	}
+	assert.Equal(t, []string{
+		"auth.registered testuser",
+		"auth.login testuser",
+		"auth.login_failed testuser",
+		"auth.login_failed nonexistent",
+	}, published)
 }
 
 func TestHandler_ValidateToken(t *testing.T) {
 	repo := newMockRepository()
 	svc := newTestServiceWithRepo(t, repo)
-	h := NewHandler(svc, newTestLogger(t))
+	h := NewHandler(svc, &recordingPublisher{}, newTestLogger(t))
 	ctx := context.Background()
 
 	_, err := h.Register(ctx, &pb.RegisterRequest{
@@ -295,3 +332,17 @@ func TestHandler_ValidateToken(t *testing.T) {
 		})
 	}
 }
+
+// serve calls a handler method behind the validation interceptor, as the
+// server does
+func serve[Req proto.Message, Resp any](ctx context.Context, method func(context.Context, Req) (Resp, error), req Req) (Resp, error) {
+	resp, err := validate.UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return method(ctx, req.(Req))
+		})
+	if err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return resp.(Resp), nil
+}
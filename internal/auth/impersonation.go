@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const defaultImpersonationDuration = 15 * time.Minute
+
+var (
+	ErrImpersonationDisabled = errors.New("impersonation is disabled")
+	ErrImpersonateSelf       = errors.New("cannot impersonate yourself")
+	ErrImpersonationRevoked  = errors.New("impersonation token was revoked")
+)
+
+// Impersonation is an access token issued to an admin acting as another
+// user
+type Impersonation struct {
+	Token     string
+	ID        string // Revokes the token
+	Username  string
+	ExpiresAt time.Time
+}
+
+// Impersonate issues admin a short-lived access token acting as username.
+// The token names the admin in its impersonator claim, cannot be refreshed
+// and can be revoked by its ID before it expires.
+func (s *Service) Impersonate(admin, username string) (*Impersonation, error) {
+	if !s.config.ImpersonationEnabled {
+		return nil, ErrImpersonationDisabled
+	}
+	if admin == username {
+		return nil, ErrImpersonateSelf
+	}
+	if _, err := s.repository.GetUserByUsername(username); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(s.impersonationDuration())
+	claims := &Claims{
+		Username:     username,
+		Impersonator: admin,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   "access",
+		},
+	}
+
+	token, err := signToken(s.keys, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &Impersonation{Token: token, ID: claims.ID, Username: username, ExpiresAt: expiresAt}, nil
+}
+
+// RevokeImpersonation rejects the impersonation token with id from now on,
+// on every instance. Revocations are kept for the longest an impersonation
+// token lives.
+func (s *Service) RevokeImpersonation(id string) error {
+	return s.repository.RevokeImpersonation(id, time.Now().Add(s.impersonationDuration()))
+}
+
+func (s *Service) impersonationDuration() time.Duration {
+	if s.config.ImpersonationDuration > 0 {
+		return s.config.ImpersonationDuration
+	}
+	return defaultImpersonationDuration
+}
+
+// impersonationRevoked reports whether claims belong to a revoked
+// impersonation token. Tokens of the users themselves are never revoked.
+func impersonationRevoked(revocations Revocations, claims *Claims) (bool, error) {
+	if claims.Impersonator == "" {
+		return false, nil
+	}
+	return revocations.ImpersonationRevoked(claims.ID)
+}
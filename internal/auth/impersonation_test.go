@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/cache"
+	"github.com/elskow/chef-infra/internal/events"
+	pb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+)
+
+func newImpersonationService(t *testing.T, enabled bool) *Service {
+	cfg := newTestConfig()
+	cfg.ImpersonationEnabled = enabled
+	svc := NewService(cfg, newTestLogger(t), newMockRepository(), cache.NewMemory(), nil)
+	require.NoError(t, svc.RegisterUser("admin", "adminpass123", "admin@example.com"))
+	require.NoError(t, svc.RegisterUser("alice", "alicepass123", "alice@example.com"))
+	return svc
+}
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token))
+}
+
+func TestService_Impersonate(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		svc := newImpersonationService(t, false)
+		_, err := svc.Impersonate("admin", "alice")
+		assert.ErrorIs(t, err, ErrImpersonationDisabled)
+	})
+
+	t.Run("self", func(t *testing.T) {
+		svc := newImpersonationService(t, true)
+		_, err := svc.Impersonate("admin", "admin")
+		assert.ErrorIs(t, err, ErrImpersonateSelf)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		svc := newImpersonationService(t, true)
+		_, err := svc.Impersonate("admin", "nobody")
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	})
+
+	t.Run("marks the impersonator", func(t *testing.T) {
+		svc := newImpersonationService(t, true)
+		impersonation, err := svc.Impersonate("admin", "alice")
+		require.NoError(t, err)
+		assert.NotEmpty(t, impersonation.ID)
+
+		claims, err := svc.ValidateToken(impersonation.Token)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", claims.Username)
+		assert.Equal(t, "admin", claims.Impersonator)
+		assert.Equal(t, impersonation.ID, claims.ID)
+		assert.WithinDuration(t, impersonation.ExpiresAt, claims.ExpiresAt.Time, defaultImpersonationDuration)
+
+		_, err = svc.RefreshToken(impersonation.Token)
+		assert.Error(t, err)
+	})
+}
+
+func TestAuthMiddleware_Impersonation(t *testing.T) {
+	svc := newImpersonationService(t, true)
+	middleware := NewAuthMiddleware(svc.config, svc.repository, nil)
+
+	impersonation, err := svc.Impersonate("admin", "alice")
+	require.NoError(t, err)
+
+	ctx, err := middleware.AuthenticationMiddleware(withToken(impersonation.Token))
+	require.NoError(t, err)
+	username, err := GetUserFromContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	impersonator, ok := GetImpersonatorFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "admin", impersonator)
+
+	own, err := svc.GenerateToken("alice")
+	require.NoError(t, err)
+	ctx, err = middleware.AuthenticationMiddleware(withToken(own))
+	require.NoError(t, err)
+	_, ok = GetImpersonatorFromContext(ctx)
+	assert.False(t, ok)
+
+	require.NoError(t, svc.RevokeImpersonation(impersonation.ID))
+	_, err = middleware.AuthenticationMiddleware(withToken(impersonation.Token))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = svc.ValidateToken(impersonation.Token)
+	assert.ErrorIs(t, err, ErrImpersonationRevoked)
+
+	// Revocation applies to the one token only
+	_, err = middleware.AuthenticationMiddleware(withToken(own))
+	assert.NoError(t, err)
+}
+
+func TestService_RevokeImpersonationSharedByInstances(t *testing.T) {
+	svc := newImpersonationService(t, true)
+	impersonation, err := svc.Impersonate("admin", "alice")
+	require.NoError(t, err)
+
+	// Another instance shares the database but not the cache
+	other := NewService(svc.config, newTestLogger(t), svc.repository, cache.NewMemory(), nil)
+	middleware := NewAuthMiddleware(svc.config, svc.repository, nil)
+	require.NoError(t, svc.RevokeImpersonation(impersonation.ID))
+
+	_, err = other.ValidateToken(impersonation.Token)
+	assert.ErrorIs(t, err, ErrImpersonationRevoked)
+	_, err = middleware.AuthenticationMiddleware(withToken(impersonation.Token))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestHandler_ImpersonateUser(t *testing.T) {
+	svc := newImpersonationService(t, true)
+	publisher := &recordingPublisher{}
+	h := NewHandler(svc, publisher, newTestLogger(t))
+	adminCtx := context.WithValue(context.Background(), UserContextKey, "admin")
+
+	resp, err := h.ImpersonateUser(adminCtx, &pb.ImpersonateUserRequest{Username: "alice", Reason: "ticket 42"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.AccessToken)
+	assert.NotEmpty(t, resp.TokenId)
+	require.Len(t, publisher.events, 1)
+	started := publisher.events[0]
+	assert.Equal(t, events.AuthImpersonationStarted, started.Type)
+	assert.Equal(t, "admin", started.Actor)
+	assert.Equal(t, "alice", started.Subject)
+	assert.Contains(t, started.Message, "ticket 42")
+	assert.Contains(t, started.Message, resp.TokenId)
+
+	_, err = h.ImpersonateUser(adminCtx, &pb.ImpersonateUserRequest{Username: "admin", Reason: "ticket 42"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = h.ImpersonateUser(adminCtx, &pb.ImpersonateUserRequest{Username: "nobody", Reason: "ticket 42"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// An impersonating session can neither start nor revoke impersonation
+	impersonating := context.WithValue(context.WithValue(context.Background(), UserContextKey, "alice"), ImpersonatorContextKey, "admin")
+	_, err = h.ImpersonateUser(impersonating, &pb.ImpersonateUserRequest{Username: "admin", Reason: "ticket 42"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = h.RevokeImpersonation(impersonating, &pb.RevokeImpersonationRequest{TokenId: resp.TokenId})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	revoked, err := h.RevokeImpersonation(adminCtx, &pb.RevokeImpersonationRequest{TokenId: resp.TokenId})
+	require.NoError(t, err)
+	assert.True(t, revoked.Success)
+	assert.Equal(t, events.AuthImpersonationRevoked, publisher.events[len(publisher.events)-1].Type)
+	_, err = svc.ValidateToken(resp.AccessToken)
+	assert.ErrorIs(t, err, ErrImpersonationRevoked)
+
+	disabled := NewHandler(newImpersonationService(t, false), &recordingPublisher{}, newTestLogger(t))
+	_, err = disabled.ImpersonateUser(adminCtx, &pb.ImpersonateUserRequest{Username: "alice", Reason: "ticket 42"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
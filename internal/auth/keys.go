@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+var ErrUnknownSigningKey = errors.New("token was signed with an unknown key")
+
+// Keys provides the key tokens are signed with and the keys they are
+// verified against, so the signing key can be rotated while tokens of the
+// previous one are still accepted. Tokens name their key in the kid
+// header; those without one were signed with the configured jwt_secret.
+type Keys interface {
+	// SigningKey returns the current key and its ID, empty for jwt_secret
+	SigningKey() (id string, secret []byte)
+	// VerificationKey returns the key a token names, if it is still accepted
+	VerificationKey(id string) ([]byte, bool)
+}
+
+// staticKeys signs and verifies every token with the configured jwt_secret
+type staticKeys struct {
+	config *config.AuthConfig
+}
+
+func (k staticKeys) SigningKey() (string, []byte) {
+	return "", []byte(k.config.JWTSecret)
+}
+
+func (k staticKeys) VerificationKey(id string) ([]byte, bool) {
+	return []byte(k.config.JWTSecret), id == ""
+}
+
+// signToken signs claims with the current key
+func signToken(keys Keys, claims *Claims) (string, error) {
+	id, secret := keys.SigningKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if id != "" {
+		token.Header["kid"] = id
+	}
+	return token.SignedString(secret)
+}
+
+// parseToken verifies a token against the key it names
+func parseToken(keys Keys, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		id, _ := token.Header["kid"].(string)
+		secret, ok := keys.VerificationKey(id)
+		if !ok {
+			return nil, ErrUnknownSigningKey
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/cache"
+)
+
+// rotatedKeys signs with "new" and still accepts the configured secret
+type rotatedKeys struct {
+	retired bool
+}
+
+func (k *rotatedKeys) SigningKey() (string, []byte) {
+	return "new", []byte("rotated-secret-key")
+}
+
+func (k *rotatedKeys) VerificationKey(id string) ([]byte, bool) {
+	switch id {
+	case "new":
+		return []byte("rotated-secret-key"), true
+	case "":
+		return []byte("test-secret-key"), !k.retired
+	}
+	return nil, false
+}
+
+func TestService_RotatedKeys(t *testing.T) {
+	legacy := newTestService(t)
+	oldToken, err := legacy.GenerateToken("testuser")
+	require.NoError(t, err)
+
+	keys := &rotatedKeys{}
+	svc := NewService(newTestConfig(), newTestLogger(t), newMockRepository(), cache.NewMemory(), keys)
+
+	token, err := svc.GenerateToken("testuser")
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", parsed.Header["kid"])
+
+	_, err = svc.ValidateToken(token)
+	assert.NoError(t, err)
+	_, err = svc.ValidateToken(oldToken)
+	assert.NoError(t, err)
+
+	// Tokens of a retired key are rejected
+	keys.retired = true
+	_, err = svc.ValidateToken(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownSigningKey)
+
+	// So are tokens naming a key that does not exist
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{Username: "testuser"})
+	unknown.Header["kid"] = "other"
+	forged, err := unknown.SignedString([]byte("rotated-secret-key"))
+	require.NoError(t, err)
+	_, err = svc.ValidateToken(forged)
+	assert.ErrorIs(t, err, ErrUnknownSigningKey)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/elskow/chef-infra/internal/validate"
+
+	legacypb "github.com/elskow/chef-infra/proto/gen/auth"
+	pb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+)
+
+// deprecationHeader tells clients of deprecated services what replaces them
+const deprecationHeader = "deprecation"
+
+// LegacyHandler serves the unversioned auth.Auth service for clients that
+// predate auth.v1. Messages are converted for Handler and validated
+// against the auth.v1 field rules, so both services behave the same.
+type LegacyHandler struct {
+	legacypb.UnimplementedAuthServer
+	handler *Handler
+}
+
+func NewLegacyHandler(handler *Handler) *LegacyHandler {
+	return &LegacyHandler{handler: handler}
+}
+
+func (h *LegacyHandler) Register(ctx context.Context, req *legacypb.RegisterRequest) (*legacypb.RegisterResponse, error) {
+	deprecated(ctx)
+	v1 := &pb.RegisterRequest{
+		Username: req.Username,
+		Password: req.Password,
+		Email:    req.Email,
+	}
+	if err := validate.Message(ctx, v1); err != nil {
+		return nil, err
+	}
+	resp, err := h.handler.Register(ctx, v1)
+	if err != nil {
+		return nil, err
+	}
+	return &legacypb.RegisterResponse{Success: resp.Success, Message: resp.Message}, nil
+}
+
+func (h *LegacyHandler) Login(ctx context.Context, req *legacypb.LoginRequest) (*legacypb.LoginResponse, error) {
+	deprecated(ctx)
+	v1 := &pb.LoginRequest{Username: req.Username, Password: req.Password}
+	if err := validate.Message(ctx, v1); err != nil {
+		return nil, err
+	}
+	resp, err := h.handler.Login(ctx, v1)
+	if err != nil {
+		return nil, err
+	}
+	return &legacypb.LoginResponse{
+		Success:      resp.Success,
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		Message:      resp.Message,
+	}, nil
+}
+
+func (h *LegacyHandler) ValidateToken(ctx context.Context, req *legacypb.ValidateTokenRequest) (*legacypb.ValidateTokenResponse, error) {
+	deprecated(ctx)
+	resp, err := h.handler.ValidateToken(ctx, &pb.ValidateTokenRequest{Token: req.Token})
+	if err != nil {
+		return nil, err
+	}
+	return &legacypb.ValidateTokenResponse{Valid: resp.Valid, Username: resp.Username, Message: resp.Message}, nil
+}
+
+func (h *LegacyHandler) RefreshToken(ctx context.Context, req *legacypb.RefreshTokenRequest) (*legacypb.RefreshTokenResponse, error) {
+	deprecated(ctx)
+	v1 := &pb.RefreshTokenRequest{RefreshToken: req.RefreshToken}
+	if err := validate.Message(ctx, v1); err != nil {
+		return nil, err
+	}
+	resp, err := h.handler.RefreshToken(ctx, v1)
+	if err != nil {
+		return nil, err
+	}
+	return &legacypb.RefreshTokenResponse{
+		Success:      resp.Success,
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		Message:      resp.Message,
+	}, nil
+}
+
+// deprecated points the client to auth.v1 in the response headers. Calls
+// outside a gRPC server, e.g. in tests, have no headers to set.
+func deprecated(ctx context.Context) {
+	_ = grpc.SetHeader(ctx, metadata.Pairs(deprecationHeader, "auth.Auth is deprecated, use auth.v1.Auth"))
+}
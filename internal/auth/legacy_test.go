@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	legacypb "github.com/elskow/chef-infra/proto/gen/auth"
+)
+
+func TestLegacyHandler(t *testing.T) {
+	h := NewLegacyHandler(newTestHandler(t))
+	ctx := context.Background()
+
+	registered, err := h.Register(ctx, &legacypb.RegisterRequest{
+		Username: "legacy",
+		Password: "testpass123",
+		Email:    "legacy@example.com",
+	})
+	require.NoError(t, err)
+	assert.True(t, registered.Success)
+
+	login, err := h.Login(ctx, &legacypb.LoginRequest{Username: "legacy", Password: "testpass123"})
+	require.NoError(t, err)
+	require.NotEmpty(t, login.AccessToken)
+
+	validated, err := h.ValidateToken(ctx, &legacypb.ValidateTokenRequest{Token: login.AccessToken})
+	require.NoError(t, err)
+	assert.True(t, validated.Valid)
+	assert.Equal(t, "legacy", validated.Username)
+
+	refreshed, err := h.RefreshToken(ctx, &legacypb.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	require.NoError(t, err)
+	assert.NotEmpty(t, refreshed.AccessToken)
+
+	// Requests are held to the auth.v1 field rules
+	_, err = h.Register(ctx, &legacypb.RegisterRequest{Username: "ab", Password: "testpass123", Email: "ab@example.com"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = h.RefreshToken(ctx, &legacypb.RefreshTokenRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Errors pass through unchanged
+	_, err = h.Login(ctx, &legacypb.LoginRequest{Username: "legacy", Password: "wrongpass123"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 
-	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -18,15 +17,26 @@ type contextKey string
 const (
 	// UserContextKey is the key used to store the username in the context
 	UserContextKey contextKey = "user"
+	// ImpersonatorContextKey holds the admin behind an impersonation token
+	ImpersonatorContextKey contextKey = "impersonator"
 )
 
 type AuthMiddleware struct {
-	config *config.AuthConfig
+	config      *config.AuthConfig
+	revocations Revocations
+	keys        Keys
 }
 
-func NewAuthMiddleware(config *config.AuthConfig) *AuthMiddleware {
+// NewAuthMiddleware returns the middleware authenticating requests. Tokens
+// are verified with the configured jwt_secret when keys is nil.
+func NewAuthMiddleware(config *config.AuthConfig, revocations Revocations, keys Keys) *AuthMiddleware {
+	if keys == nil {
+		keys = staticKeys{config: config}
+	}
 	return &AuthMiddleware{
-		config: config,
+		config:      config,
+		revocations: revocations,
+		keys:        keys,
 	}
 }
 
@@ -43,13 +53,20 @@ func (m *AuthMiddleware) AuthenticationMiddleware(ctx context.Context) (context.
 
 	token := values[0] // Get the first token
 
-	claims, err := validateToken(token, m.config.JWTSecret)
+	claims, err := parseToken(m.keys, token)
 	if err != nil {
 		return nil, status.Error(codes.Unauthenticated, "invalid token")
 	}
+	if revoked, err := impersonationRevoked(m.revocations, claims); err != nil || revoked {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
 
 	// Use the custom context key type
-	return context.WithValue(ctx, UserContextKey, claims.Username), nil
+	ctx = context.WithValue(ctx, UserContextKey, claims.Username)
+	if claims.Impersonator != "" {
+		ctx = context.WithValue(ctx, ImpersonatorContextKey, claims.Impersonator)
+	}
+	return ctx, nil
 }
 
 // Helper function to get username from context
@@ -61,19 +78,9 @@ func GetUserFromContext(ctx context.Context) (string, error) {
 	return username, nil
 }
 
-func validateToken(tokenString string, secretKey string) (*Claims, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	if !token.Valid {
-		return nil, errors.New("invalid token")
-	}
-
-	return claims, nil
+// GetImpersonatorFromContext returns the admin acting as the context's
+// user, reporting false for requests made with the user's own token
+func GetImpersonatorFromContext(ctx context.Context) (string, bool) {
+	impersonator, ok := ctx.Value(ImpersonatorContextKey).(string)
+	return impersonator, ok && impersonator != ""
 }
@@ -1,12 +1,17 @@
 package auth
 
 import (
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pagination"
 )
 
 type mockRepository struct {
 	users        map[string]*User
 	usersByEmail map[string]*User
+	revoked      map[string]time.Time
 	mu           sync.RWMutex
 }
 
@@ -14,6 +19,7 @@ func newMockRepository() Repository {
 	return &mockRepository{
 		users:        make(map[string]*User),
 		usersByEmail: make(map[string]*User),
+		revoked:      make(map[string]time.Time),
 	}
 }
 
@@ -34,6 +40,8 @@ func (r *mockRepository) CreateUser(user *User) error {
 		Username:     user.Username,
 		PasswordHash: user.PasswordHash,
 		Email:        user.Email,
+		Role:         user.Role,
+		Locale:       user.Locale,
 	}
 
 	r.users[user.Username] = newUser
@@ -66,3 +74,43 @@ func (r *mockRepository) GetUserByEmail(email string) (*User, error) {
 func (r *mockRepository) VerifyEmail(userID uint) error {
 	return nil
 }
+
+func (r *mockRepository) SetLocale(username, locale string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	user.Locale = locale
+	return nil
+}
+
+func (r *mockRepository) ListUsers(_ pagination.Params) (*pagination.Page[User], error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return &pagination.Page[User]{Items: users}, nil
+}
+
+func (r *mockRepository) RevokeImpersonation(tokenID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revoked[tokenID] = expiresAt
+	return nil
+}
+
+func (r *mockRepository) ImpersonationRevoked(tokenID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, revoked := r.revoked[tokenID]
+	return revoked, nil
+}
@@ -6,12 +6,19 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID            uint   `gorm:"primaryKey"`
 	Username      string `gorm:"uniqueIndex;not null"`
 	PasswordHash  string `gorm:"not null"`
 	Email         string `gorm:"uniqueIndex;not null"`
 	EmailVerified bool   `gorm:"default:false"`
+	Role          string `gorm:"not null;default:user"`
+	Locale        string // Language of messages sent to the user, empty for the server default
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	DeletedAt     gorm.DeletedAt `gorm:"index"`
@@ -20,3 +27,15 @@ type User struct {
 func (User) TableName() string {
 	return "users"
 }
+
+// RevokedImpersonation is an impersonation token rejected before it
+// expires
+type RevokedImpersonation struct {
+	TokenID   string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index;not null"` // The token is rejected past it anyway, so the row can go
+	CreatedAt time.Time
+}
+
+func (RevokedImpersonation) TableName() string {
+	return "revoked_impersonations"
+}
@@ -5,7 +5,9 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"github.com/elskow/chef-infra/internal/cache"
 	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/events"
 )
 
 // NewModule returns the auth module options
@@ -18,20 +20,21 @@ func NewModule(db *gorm.DB) fx.Option {
 				},
 			),
 			fx.Annotate(
-				func(config *config.AppConfig, log *zap.Logger, repo Repository) *Service {
-					return NewService(&config.Auth, log, repo)
+				func(config *config.AppConfig, log *zap.Logger, repo Repository, store cache.Store) *Service {
+					return NewService(&config.Auth, log, repo, store, nil)
 				},
 			),
 			// Provide handler
 			fx.Annotate(
-				func(svc *Service, log *zap.Logger) *Handler {
-					return NewHandler(svc, log)
+				func(svc *Service, bus events.Bus, log *zap.Logger) *Handler {
+					return NewHandler(svc, bus, log)
 				},
 			),
+			fx.Annotate(NewLegacyHandler),
 			// Provide middleware
 			fx.Annotate(
-				func(config *config.AppConfig) *AuthMiddleware {
-					return NewAuthMiddleware(&config.Auth)
+				func(config *config.AppConfig, repo Repository) *AuthMiddleware {
+					return NewAuthMiddleware(&config.Auth, repo, nil)
 				},
 			),
 		),
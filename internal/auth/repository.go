@@ -2,8 +2,12 @@ package auth
 
 import (
 	"errors"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/elskow/chef-infra/internal/pagination"
 )
 
 var (
@@ -17,6 +21,25 @@ type Repository interface {
 	GetUserByUsername(username string) (*User, error)
 	GetUserByEmail(email string) (*User, error)
 	VerifyEmail(userID uint) error
+	SetLocale(username, locale string) error
+	ListUsers(params pagination.Params) (*pagination.Page[User], error)
+	RevokeImpersonation(tokenID string, expiresAt time.Time) error
+	Revocations
+}
+
+// Revocations tells revoked impersonation tokens apart. They are kept in
+// the database so every instance rejects them, also after a restart.
+type Revocations interface {
+	ImpersonationRevoked(tokenID string) (bool, error)
+}
+
+var userListSpec = pagination.Spec{
+	SortFields: map[string]string{
+		"username":   "username",
+		"created_at": "created_at",
+	},
+	DefaultSort:   "username",
+	SearchColumns: []string{"username", "email"},
 }
 
 type repository struct {
@@ -56,3 +79,29 @@ func (r *repository) GetUserByEmail(email string) (*User, error) {
 func (r *repository) VerifyEmail(userID uint) error {
 	return r.db.Model(&User{}).Where("id = ?", userID).Update("email_verified", true).Error
 }
+
+func (r *repository) SetLocale(username, locale string) error {
+	return r.db.Model(&User{}).Where("username = ?", username).Update("locale", locale).Error
+}
+
+func (r *repository) ListUsers(params pagination.Params) (*pagination.Page[User], error) {
+	return pagination.List[User](r.db, params, userListSpec)
+}
+
+// RevokeImpersonation records the token as revoked until expiresAt and
+// drops the revocations of tokens that expired since
+func (r *repository) RevokeImpersonation(tokenID string, expiresAt time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", time.Now()).Delete(&RevokedImpersonation{}).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&RevokedImpersonation{TokenID: tokenID, ExpiresAt: expiresAt}).Error
+	})
+}
+
+func (r *repository) ImpersonationRevoked(tokenID string) (bool, error) {
+	var count int64
+	err := r.db.Model(&RevokedImpersonation{}).Where("token_id = ?", tokenID).Count(&count).Error
+	return count > 0, err
+}
@@ -1,6 +1,10 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -9,25 +13,39 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/elskow/chef-infra/internal/cache"
 	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/i18n"
 )
 
+var ErrTokenReused = errors.New("refresh token was already used")
+
 type Service struct {
 	config     *config.AuthConfig
 	log        *zap.Logger
 	repository Repository
+	store      cache.Store // Exchanged refresh tokens and recently validated access tokens
+	keys       Keys
 }
 
 type Claims struct {
-	Username string `json:"username"`
+	Username     string `json:"username"`
+	Impersonator string `json:"impersonator,omitempty"` // Admin acting as Username, set on impersonation tokens
 	jwt.RegisteredClaims
 }
 
-func NewService(config *config.AuthConfig, log *zap.Logger, repo Repository) *Service {
+// NewService returns the auth service. Tokens are signed with the
+// configured jwt_secret when keys is nil.
+func NewService(config *config.AuthConfig, log *zap.Logger, repo Repository, store cache.Store, keys Keys) *Service {
+	if keys == nil {
+		keys = staticKeys{config: config}
+	}
 	return &Service{
 		config:     config,
 		log:        log,
 		repository: repo,
+		store:      store,
+		keys:       keys,
 	}
 }
 
@@ -52,24 +70,57 @@ func (s *Service) GenerateToken(username string) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.config.JWTSecret))
+	return signToken(s.keys, claims)
 }
 
 func (s *Service) ValidateToken(tokenString string) (*Claims, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.config.JWTSecret), nil
-	})
+	claims, err := parseToken(s.keys, tokenString)
+	if err != nil {
+		return nil, err
+	}
 
+	revoked, err := impersonationRevoked(s.repository, claims)
 	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, ErrImpersonationRevoked
+	}
 
-	if !token.Valid {
-		return nil, errors.New("invalid token")
+	return claims, nil
+}
+
+// CheckToken validates a token like ValidateToken, remembering valid ones
+// for the configured cache TTL so repeated checks skip parsing. The cache
+// is keyed by token hash and never outlives the token.
+func (s *Service) CheckToken(ctx context.Context, tokenString string) (*Claims, error) {
+	if s.store == nil || s.config.ValidateCacheTTL <= 0 {
+		return s.ValidateToken(tokenString)
 	}
 
+	sum := sha256.Sum256([]byte(tokenString))
+	key := "token-valid:" + hex.EncodeToString(sum[:])
+	if username, ok, err := s.store.Get(ctx, key); err == nil && ok {
+		return &Claims{Username: username}, nil
+	}
+
+	claims, err := s.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	// Impersonation tokens can be revoked, so each check looks them up
+	if claims.Impersonator != "" {
+		return claims, nil
+	}
+	ttl := s.config.ValidateCacheTTL
+	if claims.ExpiresAt != nil {
+		ttl = min(ttl, time.Until(claims.ExpiresAt.Time))
+	}
+	if ttl > 0 {
+		if err := s.store.Set(ctx, key, claims.Username, ttl); err != nil {
+			s.log.Warn("failed to cache validated token", zap.Error(err))
+		}
+	}
 	return claims, nil
 }
 
@@ -108,11 +159,45 @@ func (s *Service) RegisterUser(username, password, email string) error {
 		Username:     username,
 		PasswordHash: hashedPassword,
 		Email:        email,
+		Role:         RoleUser,
 	}
 
 	return s.repository.CreateUser(user)
 }
 
+// IsAdmin reports whether the user holds the admin role. Roles are looked up
+// on every call rather than embedded in tokens so revocation is immediate.
+func (s *Service) IsAdmin(username string) (bool, error) {
+	user, err := s.repository.GetUserByUsername(username)
+	if err != nil {
+		return false, err
+	}
+	return user.Role == RoleAdmin, nil
+}
+
+// UserLocale returns the locale saved on the user's profile. It reports
+// false when the user has none or is unknown.
+func (s *Service) UserLocale(username string) (i18n.Locale, bool) {
+	user, err := s.repository.GetUserByUsername(username)
+	if err != nil || !i18n.Supported(i18n.Locale(user.Locale)) {
+		return "", false
+	}
+	return i18n.Locale(user.Locale), true
+}
+
+// RememberLocale saves the locale requested in ctx's metadata on the
+// user's profile, so later requests without one and notifications use it
+func (s *Service) RememberLocale(ctx context.Context, username string) error {
+	locale, ok := i18n.FromMetadata(ctx)
+	if !ok {
+		return nil
+	}
+	if current, ok := s.UserLocale(username); ok && current == locale {
+		return nil
+	}
+	return s.repository.SetLocale(username, string(locale))
+}
+
 func (s *Service) ValidateLogin(username, password string) (string, error) {
 	user, err := s.repository.GetUserByUsername(username)
 	if err != nil {
@@ -154,18 +239,25 @@ func (s *Service) generateRefreshToken(username string) (string, error) {
 		return "", errors.New("refresh token functionality is disabled")
 	}
 
+	// A random ID tells apart tokens issued in the same second, so using
+	// one does not block the other
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
 	expirationTime := time.Now().Add(s.config.RefreshTokenDuration) // Use RefreshTokenDuration
 	claims := &Claims{
 		Username: username,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   "refresh",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.config.JWTSecret))
+	return signToken(s.keys, claims)
 }
 
 func (s *Service) RefreshToken(refreshToken string) (string, error) {
@@ -196,6 +288,39 @@ func (s *Service) RefreshTokenPair(refreshToken string) (accessToken, newRefresh
 		return "", "", err
 	}
 
+	// Refresh tokens are rotated: each can be exchanged once
+	if err := s.markUsed(claims, refreshToken); err != nil {
+		return "", "", err
+	}
+
 	// Generate new token pair
 	return s.GenerateTokenPair(claims.Username)
 }
+
+// markUsed adds a refresh token to the blocklist until it expires and
+// fails when it was already there, e.g. a stolen token being replayed
+func (s *Service) markUsed(claims *Claims, token string) error {
+	if s.store == nil {
+		return nil
+	}
+
+	key := claims.ID
+	if key == "" {
+		sum := sha256.Sum256([]byte(token))
+		key = hex.EncodeToString(sum[:])
+	}
+	ttl := time.Minute
+	if claims.ExpiresAt != nil {
+		ttl = time.Until(claims.ExpiresAt.Time) + time.Minute
+	}
+
+	added, err := s.store.Add(context.Background(), "refresh-used:"+key, claims.Username, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to check refresh token: %w", err)
+	}
+	if !added {
+		s.log.Warn("refresh token reused", zap.String("username", claims.Username))
+		return ErrTokenReused
+	}
+	return nil
+}
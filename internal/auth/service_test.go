@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/elskow/chef-infra/internal/cache"
 	"github.com/elskow/chef-infra/internal/config"
 )
 
@@ -115,6 +116,8 @@ func TestService_ValidateToken(t *testing.T) {
 					expiredConfig,
 					newTestLogger(t),
 					newMockRepository(),
+					cache.NewMemory(),
+					nil,
 				)
 				token, _ := expiredSvc.GenerateToken("testuser")
 				return token
@@ -292,7 +295,7 @@ func TestService_RefreshToken(t *testing.T) {
 			setupToken: func() string {
 				cfg := newTestConfig()
 				cfg.RefreshTokenDuration = -time.Hour
-				expiredSvc := NewService(cfg, newTestLogger(t), newMockRepository())
+				expiredSvc := NewService(cfg, newTestLogger(t), newMockRepository(), cache.NewMemory(), nil)
 				_, refresh, _ := expiredSvc.GenerateTokenPair(username)
 				return refresh
 			},
@@ -324,6 +327,23 @@ func TestService_RefreshToken(t *testing.T) {
 	}
 }
 
+func TestService_RefreshTokenPairRotates(t *testing.T) {
+	svc := newTestService(t)
+
+	_, first, err := svc.GenerateTokenPair("testuser")
+	require.NoError(t, err)
+
+	_, second, err := svc.RefreshTokenPair(first)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	// The exchanged token cannot be replayed, its successor still works
+	_, _, err = svc.RefreshTokenPair(first)
+	assert.ErrorIs(t, err, ErrTokenReused)
+	_, _, err = svc.RefreshTokenPair(second)
+	assert.NoError(t, err)
+}
+
 func TestService_CheckPasswordHash(t *testing.T) {
 	svc := newTestService(t)
 
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const defaultKeyPrefix = "chef:"
+
+// Store is a key-value store with expiring entries. Backed by Redis it is
+// shared by every replica; in memory each replica keeps its own.
+type Store interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// Add sets key only if it is absent and reports whether it did
+	Add(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// Incr increments a counter whose window starts with its first
+	// increment and returns the new count
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+// New returns a Redis-backed store when an address is configured and an
+// in-memory one otherwise. Redis errors, including Redis being down at
+// startup, degrade to the in-memory store until it is reachable again.
+func New(cfg *config.RedisConfig, log *zap.Logger) Store {
+	local := NewMemory()
+	if cfg.Addr == "" {
+		return local
+	}
+
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = defaultKeyPrefix
+	}
+	redis := NewRedis(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redis.timeout)
+	defer cancel()
+	if err := redis.Ping(ctx); err != nil {
+		log.Warn("redis unavailable, using in-memory cache until it is reachable",
+			zap.String("addr", cfg.Addr),
+			zap.Error(err))
+	}
+
+	return &fallback{
+		primary: prefixed{store: redis, prefix: prefix},
+		local:   local,
+		log:     log,
+	}
+}
+
+// prefixed namespaces keys so the server can share a Redis database
+type prefixed struct {
+	store  Store
+	prefix string
+}
+
+func (p prefixed) Get(ctx context.Context, key string) (string, bool, error) {
+	return p.store.Get(ctx, p.prefix+key)
+}
+
+func (p prefixed) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return p.store.Set(ctx, p.prefix+key, value, ttl)
+}
+
+func (p prefixed) Add(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return p.store.Add(ctx, p.prefix+key, value, ttl)
+}
+
+func (p prefixed) Delete(ctx context.Context, key string) error {
+	return p.store.Delete(ctx, p.prefix+key)
+}
+
+func (p prefixed) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return p.store.Incr(ctx, p.prefix+key, window)
+}
+
+// fallback answers from the local store while the primary fails
+type fallback struct {
+	primary Store
+	local   Store
+	log     *zap.Logger
+}
+
+func (f *fallback) degraded(op string, err error) {
+	f.log.Warn("redis unavailable, using in-memory cache",
+		zap.String("op", op),
+		zap.Error(err))
+}
+
+func (f *fallback) Get(ctx context.Context, key string) (string, bool, error) {
+	value, ok, err := f.primary.Get(ctx, key)
+	if err != nil {
+		f.degraded("get", err)
+		return f.local.Get(ctx, key)
+	}
+	return value, ok, nil
+}
+
+func (f *fallback) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := f.primary.Set(ctx, key, value, ttl); err != nil {
+		f.degraded("set", err)
+		return f.local.Set(ctx, key, value, ttl)
+	}
+	return nil
+}
+
+func (f *fallback) Add(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	added, err := f.primary.Add(ctx, key, value, ttl)
+	if err != nil {
+		f.degraded("add", err)
+		return f.local.Add(ctx, key, value, ttl)
+	}
+	return added, nil
+}
+
+// Delete removes the key from both stores so an entry written while Redis
+// was down cannot outlive an invalidation
+func (f *fallback) Delete(ctx context.Context, key string) error {
+	_ = f.local.Delete(ctx, key)
+	if err := f.primary.Delete(ctx, key); err != nil {
+		f.degraded("delete", err)
+	}
+	return nil
+}
+
+func (f *fallback) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := f.primary.Incr(ctx, key, window)
+	if err != nil {
+		f.degraded("incr", err)
+		return f.local.Incr(ctx, key, window)
+	}
+	return count, nil
+}
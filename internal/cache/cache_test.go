@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+// fakeRedis serves the commands the Store uses, without expiry
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{listener: listener, data: make(map[string]string)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = item.(string)
+		}
+		fmt.Fprint(conn, f.handle(args))
+	}
+}
+
+func (f *fakeRedis) handle(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		value, ok := f.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		for _, opt := range args[3:] {
+			if strings.ToUpper(opt) == "NX" {
+				if _, exists := f.data[args[1]]; exists {
+					return "$-1\r\n"
+				}
+			}
+		}
+		f.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		delete(f.data, args[1])
+		return ":1\r\n"
+	case "INCR":
+		n, _ := strconv.ParseInt(f.data[args[1]], 10, 64)
+		n++
+		f.data[args[1]] = strconv.FormatInt(n, 10)
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	_, ok, err := store.Get(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.Set(ctx, "key", "value", time.Minute))
+	value, ok, err := store.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	added, err := store.Add(ctx, "key", "other", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, added)
+	added, err = store.Add(ctx, "new", "value", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	require.NoError(t, store.Delete(ctx, "key"))
+	_, ok, err = store.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := store.Incr(ctx, "counter", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, want, count)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestMemory_Expiry(t *testing.T) {
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	require.NoError(t, m.Set(ctx, "key", "value", time.Second))
+	_, err := m.Incr(ctx, "counter", time.Second)
+	require.NoError(t, err)
+
+	now = now.Add(time.Second)
+	_, ok, _ := m.Get(ctx, "key")
+	assert.False(t, ok)
+	count, _ := m.Incr(ctx, "counter", time.Second)
+	assert.Equal(t, int64(1), count, "a new window starts after expiry")
+}
+
+func TestRedis(t *testing.T) {
+	server := newFakeRedis(t)
+	testStore(t, NewRedis(&config.RedisConfig{Addr: server.listener.Addr().String()}))
+}
+
+func TestNew_PrefixesKeys(t *testing.T) {
+	server := newFakeRedis(t)
+	store := New(&config.RedisConfig{Addr: server.listener.Addr().String(), KeyPrefix: "test:"}, zap.NewNop())
+
+	require.NoError(t, store.Set(context.Background(), "key", "value", 0))
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.Equal(t, "value", server.data["test:key"])
+}
+
+func TestNew_DegradesWithoutRedis(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	store := New(&config.RedisConfig{Addr: addr, Timeout: 100 * time.Millisecond}, zap.NewNop())
+	testStore(t, store)
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(NewMemory(), 2, time.Minute)
+	ctx := context.Background()
+
+	for i, want := range []bool{true, true, false} {
+		allowed, err := limiter.Allow(ctx, "user:alice")
+		require.NoError(t, err)
+		assert.Equal(t, want, allowed, "request %d", i+1)
+	}
+	allowed, _ := limiter.Allow(ctx, "user:bob")
+	assert.True(t, allowed, "limits are per key")
+
+	unlimited := NewLimiter(NewMemory(), 0, time.Minute)
+	for i := 0; i < 10; i++ {
+		allowed, _ := unlimited.Allow(ctx, "user:alice")
+		assert.True(t, allowed)
+	}
+}
+
+func TestLimiterExceeded(t *testing.T) {
+	limiter := NewLimiter(NewMemory(), 2, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		exceeded, err := limiter.Exceeded(ctx, "addr:10.0.0.1")
+		require.NoError(t, err)
+		assert.False(t, exceeded, "checking does not count")
+		_, _ = limiter.Allow(ctx, "addr:10.0.0.1")
+	}
+	exceeded, err := limiter.Exceeded(ctx, "addr:10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, exceeded)
+
+	exceeded, _ = NewLimiter(NewMemory(), 0, time.Minute).Exceeded(ctx, "addr:10.0.0.1")
+	assert.False(t, exceeded)
+}
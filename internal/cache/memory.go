@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many writes pass between removals of expired entries
+const sweepEvery = 1024
+
+type entry struct {
+	value   string
+	expires time.Time // Zero never expires
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// Memory is a Store local to this process
+type Memory struct {
+	mu     sync.Mutex
+	items  map[string]entry
+	writes int
+	now    func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{items: make(map[string]entry), now: time.Now}
+}
+
+func (m *Memory) Get(_ context.Context, key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.lookup(key)
+	return e.value, ok, nil
+}
+
+func (m *Memory) Set(_ context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store(key, value, ttl)
+	return nil
+}
+
+func (m *Memory) Add(_ context.Context, key, value string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lookup(key); ok {
+		return false, nil
+	}
+	m.store(key, value, ttl)
+	return true, nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+func (m *Memory) Incr(_ context.Context, key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.lookup(key)
+	if !ok {
+		m.store(key, "1", window)
+		return 1, nil
+	}
+	count, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	count++
+	e.value = strconv.FormatInt(count, 10)
+	m.items[key] = e
+	return count, nil
+}
+
+// lookup returns a live entry, dropping it when it expired
+func (m *Memory) lookup(key string) (entry, bool) {
+	e, ok := m.items[key]
+	if ok && e.expired(m.now()) {
+		delete(m.items, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func (m *Memory) store(key, value string, ttl time.Duration) {
+	e := entry{value: value}
+	if ttl > 0 {
+		e.expires = m.now().Add(ttl)
+	}
+	m.items[key] = e
+
+	m.writes++
+	if m.writes%sweepEvery == 0 {
+		now := m.now()
+		for k, e := range m.items {
+			if e.expired(now) {
+				delete(m.items, k)
+			}
+		}
+	}
+}
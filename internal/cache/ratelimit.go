@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Limiter allows a number of requests per key in fixed windows. Counters
+// live in the store, so with Redis the limit holds across replicas.
+type Limiter struct {
+	store  Store
+	limit  int
+	window time.Duration
+}
+
+// NewLimiter returns a limiter allowing limit requests per window; a
+// limit of zero allows everything
+func NewLimiter(store Store, limit int, window time.Duration) *Limiter {
+	return &Limiter{store: store, limit: limit, window: window}
+}
+
+// Allow counts a request for key and reports whether it is within the
+// limit
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, error) {
+	if l.limit <= 0 {
+		return true, nil
+	}
+	count, err := l.store.Incr(ctx, "ratelimit:"+key, l.window)
+	if err != nil {
+		return false, err
+	}
+	return count <= int64(l.limit), nil
+}
+
+// Exceeded reports whether key has used up its limit in the current
+// window without counting a request. Together with Allow it limits
+// outcomes, such as failed attempts, rather than every request.
+func (l *Limiter) Exceeded(ctx context.Context, key string) (bool, error) {
+	if l.limit <= 0 {
+		return false, nil
+	}
+	value, ok, err := l.store.Get(ctx, "ratelimit:"+key)
+	if err != nil || !ok {
+		return false, err
+	}
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return count >= int64(l.limit), nil
+}
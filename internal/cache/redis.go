@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const (
+	defaultRedisTimeout = 2 * time.Second
+	maxIdleConns        = 8
+)
+
+// errNil is Redis' reply for missing keys
+var errNil = errors.New("redis: nil")
+
+// RedisError is an error reply from the server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Redis is a Store speaking RESP to a single Redis server. It only
+// implements the handful of commands the Store needs.
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func NewRedis(cfg *config.RedisConfig) *Redis {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	return &Redis{
+		addr:     cfg.Addr,
+		password: cfg.Password,
+		db:       cfg.DB,
+		timeout:  timeout,
+		idle:     make(chan *redisConn, maxIdleConns),
+	}
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	_, err := r.do(ctx, "PING")
+	return err
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (string, bool, error) {
+	reply, err := r.do(ctx, "GET", key)
+	if errors.Is(err, errNil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	value, _ := reply.(string)
+	return value, true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+func (r *Redis) Add(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	args := []string{"SET", key, value, "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := r.do(ctx, args...)
+	if errors.Is(err, errNil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", key)
+	return err
+}
+
+// Incr starts the window with SET NX so the counter always expires, even
+// when the server stops between the two commands
+func (r *Redis) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if _, err := r.Add(ctx, key, "0", window); err != nil {
+		return 0, err
+	}
+	reply, err := r.do(ctx, "INCR", key)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCR reply %v", reply)
+	}
+	return count, nil
+}
+
+// do sends one command and reads its reply. Connections that failed are
+// closed rather than reused.
+func (r *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := r.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(r.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	reply, err := c.roundTrip(deadline, args)
+	var redisErr RedisError
+	if err != nil && !errors.Is(err, errNil) && !errors.As(err, &redisErr) {
+		c.conn.Close()
+		return nil, err
+	}
+	r.put(c)
+	return reply, err
+}
+
+func (r *Redis) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: r.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+
+	deadline := time.Now().Add(r.timeout)
+	if r.password != "" {
+		if _, err := c.roundTrip(deadline, []string{"AUTH", r.password}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.roundTrip(deadline, []string{"SELECT", strconv.Itoa(r.db)}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (r *Redis) put(c *redisConn) {
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *redisConn) roundTrip(deadline time.Time, args []string) (interface{}, error) {
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// readReply parses one RESP2 reply. Bulk strings and simple strings
+// become strings, integers int64 and arrays []interface{}.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readReply(r)
+			if err != nil && !errors.Is(err, errNil) {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EncryptedPrefix marks config values encrypted with the config key, e.g.
+// password = "enc:..." as printed by chef-infra -encrypt-value
+const EncryptedPrefix = "enc:"
+
+// The config key is read from CHEF_CONFIG_KEY, or from the file named by
+// CHEF_CONFIG_KEY_FILE, e.g. one a KMS or secrets manager agent writes.
+// Like the master key it is 32 base64 encoded bytes.
+const (
+	ConfigKeyEnv     = "CHEF_CONFIG_KEY"
+	ConfigKeyFileEnv = "CHEF_CONFIG_KEY_FILE"
+)
+
+var ErrNoConfigKey = fmt.Errorf("is encrypted but neither %s nor %s is set", ConfigKeyEnv, ConfigKeyFileEnv)
+
+// LoadConfigKey returns the config key, nil when none is set
+func LoadConfigKey() ([]byte, error) {
+	encoded := os.Getenv(ConfigKeyEnv)
+	if path := os.Getenv(ConfigKeyFileEnv); encoded == "" && path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", ConfigKeyFileEnv, err)
+		}
+		encoded = string(content)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := DecodeMasterKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("config key %w", err)
+	}
+	return key, nil
+}
+
+func configCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptValue encrypts a config value with the config key
+func EncryptValue(key []byte, plaintext string) (string, error) {
+	aead, err := configCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptValue decrypts a config value. Values without EncryptedPrefix are
+// returned as they are.
+func DecryptValue(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, EncryptedPrefix) {
+		return value, nil
+	}
+	if key == nil {
+		return "", ErrNoConfigKey
+	}
+	aead, err := configCipher(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("is not a valid encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot be decrypted with the config key")
+	}
+	return string(plaintext), nil
+}
+
+// DecryptValues replaces the encrypted values read into v, including those
+// in arrays and arrays of tables, with their plaintext
+func DecryptValues(v *viper.Viper, key []byte) []Problem {
+	var problems []Problem
+	names := v.AllKeys()
+	sort.Strings(names)
+	for _, name := range names {
+		value, changed, err := decryptAny(key, v.Get(name))
+		if err != nil {
+			problems = append(problems, Problem{Key: name, Message: err.Error()})
+			continue
+		}
+		if changed {
+			v.Set(name, value)
+		}
+	}
+	return problems
+}
+
+func decryptAny(key []byte, value interface{}) (interface{}, bool, error) {
+	switch value := value.(type) {
+	case string:
+		if !strings.HasPrefix(value, EncryptedPrefix) {
+			return value, false, nil
+		}
+		plaintext, err := DecryptValue(key, value)
+		return plaintext, err == nil, err
+	case []interface{}:
+		decrypted := make([]interface{}, len(value))
+		changed := false
+		for i, item := range value {
+			item, itemChanged, err := decryptAny(key, item)
+			if err != nil {
+				return nil, false, err
+			}
+			decrypted[i] = item
+			changed = changed || itemChanged
+		}
+		return decrypted, changed, nil
+	case []map[string]interface{}:
+		decrypted := make([]map[string]interface{}, len(value))
+		changed := false
+		for i, item := range value {
+			table, tableChanged, err := decryptAny(key, item)
+			if err != nil {
+				return nil, false, err
+			}
+			decrypted[i] = table.(map[string]interface{})
+			changed = changed || tableChanged
+		}
+		return decrypted, changed, nil
+	case map[string]interface{}:
+		decrypted := make(map[string]interface{}, len(value))
+		changed := false
+		for name, item := range value {
+			item, itemChanged, err := decryptAny(key, item)
+			if err != nil {
+				return nil, false, err
+			}
+			decrypted[name] = item
+			changed = changed || itemChanged
+		}
+		return decrypted, changed, nil
+	}
+	return value, false, nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import "fmt"
+
+const (
+	// DefaultMaxMessageSize is the production default for both directions
+	DefaultMaxMessageSize = 4 * 1024 * 1024
+	// MinMessageSize keeps auth and project requests from being rejected
+	MinMessageSize = 64 * 1024
+	// MaxMessageSize bounds per-message memory on the server
+	MaxMessageSize = 64 * 1024 * 1024
+)
+
+// ApplyDefaults fills unset message sizes with the production defaults
+func (c *GRPCConfig) ApplyDefaults() {
+	if c.MaxReceiveMessageSize == 0 {
+		c.MaxReceiveMessageSize = DefaultMaxMessageSize
+	}
+	if c.MaxSendMessageSize == 0 {
+		c.MaxSendMessageSize = DefaultMaxMessageSize
+	}
+}
+
+// Validate checks the message sizes are within the supported range
+func (c *GRPCConfig) Validate() error {
+	if err := validateMessageSize("grpc.max_receive_message_size", c.MaxReceiveMessageSize); err != nil {
+		return err
+	}
+	return validateMessageSize("grpc.max_send_message_size", c.MaxSendMessageSize)
+}
+
+func validateMessageSize(key string, size int) error {
+	if size < MinMessageSize || size > MaxMessageSize {
+		return fmt.Errorf("%s must be between %d and %d bytes, got %d (production default is %d)",
+			key, MinMessageSize, MaxMessageSize, size, DefaultMaxMessageSize)
+	}
+	return nil
+}
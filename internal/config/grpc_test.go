@@ -0,0 +1,46 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGRPCConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  GRPCConfig
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			config: GRPCConfig{},
+		},
+		{
+			name:   "development sizes",
+			config: GRPCConfig{MaxReceiveMessageSize: 16 << 20, MaxSendMessageSize: 16 << 20},
+		},
+		{
+			name:    "receive size too small",
+			config:  GRPCConfig{MaxReceiveMessageSize: 1024},
+			wantErr: "grpc.max_receive_message_size must be between",
+		},
+		{
+			name:    "send size too large",
+			config:  GRPCConfig{MaxSendMessageSize: 1 << 30},
+			wantErr: "production default is 4194304",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.ApplyDefaults()
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package config
+
+import "github.com/elskow/chef-infra/internal/i18n"
+
+// Locale returns the configured default locale, English when unset
+func (c *I18nConfig) Locale() i18n.Locale {
+	if c.DefaultLocale == "" {
+		return i18n.Default
+	}
+	return i18n.Locale(c.DefaultLocale)
+}
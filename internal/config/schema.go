@@ -0,0 +1,756 @@
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+
+	"github.com/elskow/chef-infra/internal/i18n"
+	pipelineconfig "github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/plugin"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/version"
+)
+
+//go:embed types.go
+var source string
+
+// minJWTSecretLength is the HS256 key size recommended by RFC 7518
+const minJWTSecretLength = 32
+
+// requiredKeys must be set to a non-empty value
+var requiredKeys = []string{
+	"server.port",
+	"auth.jwt_secret",
+	"database.host",
+	"database.user",
+	"database.name",
+	"pipeline.deploy.platform",
+}
+
+// Problem is a finding about a config file. Warnings flag suspicious
+// values the server still starts with.
+type Problem struct {
+	Key     string
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s", level, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", level, p.Key, p.Message)
+}
+
+// HasErrors reports whether any problem is not a warning
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFile checks a TOML config file against AppConfig: unknown keys,
+// values of the wrong type, missing required keys and suspicious values.
+// Encrypted values are checked once decrypted, so the config key must be
+// set when there are any.
+func ValidateFile(path string) ([]Problem, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("toml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	key, err := LoadConfigKey()
+	if err != nil {
+		return nil, err
+	}
+	if problems := DecryptValues(v, key); len(problems) > 0 {
+		return problems, nil
+	}
+	return Validate(v), nil
+}
+
+// Validate checks the config read into v
+func Validate(v *viper.Viper) []Problem {
+	var problems []Problem
+
+	fields := schema(reflect.TypeOf(AppConfig{}), "")
+	for _, key := range v.AllKeys() {
+		if !fields.known(key) {
+			problems = append(problems, Problem{Key: key, Message: "unknown key"})
+		}
+	}
+
+	var cfg AppConfig
+	if err := v.Unmarshal(&cfg); err != nil {
+		var decodeErr *mapstructure.Error
+		if !errors.As(err, &decodeErr) {
+			return append(problems, Problem{Message: err.Error()})
+		}
+		for _, message := range decodeErr.Errors {
+			problems = append(problems, Problem{Message: message})
+		}
+		return problems
+	}
+
+	for _, key := range requiredKeys {
+		if strings.TrimSpace(v.GetString(key)) == "" {
+			problems = append(problems, Problem{Key: key, Message: "is required"})
+		}
+	}
+
+	cfg.GRPC.ApplyDefaults()
+	for _, p := range cfg.Check() {
+		// Missing required keys were reported above
+		if !p.Warning && v.GetString(p.Key) == "" && contains(requiredKeys, p.Key) {
+			continue
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// Check reports invalid and suspicious values of a decoded config
+func (c *AppConfig) Check() []Problem {
+	var problems []Problem
+	fail := func(key, format string, args ...interface{}) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(key, format string, args ...interface{}) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...), Warning: true})
+	}
+
+	if c.Server.Port == "" {
+		fail("server.port", "is not set")
+	}
+	switch {
+	case c.Auth.JWTSecret == "":
+		fail("auth.jwt_secret", "is not set")
+	case len(c.Auth.JWTSecret) < minJWTSecretLength:
+		warn("auth.jwt_secret", "is shorter than %d bytes, tokens can be forged by guessing it", minJWTSecretLength)
+	}
+	if c.Auth.AccessTokenDuration <= 0 {
+		fail("auth.access_token_duration", "must be positive")
+	}
+	if c.Auth.RefreshTokenEnabled && c.Auth.RefreshTokenDuration <= 0 {
+		fail("auth.refresh_token_duration", "must be positive when refresh tokens are enabled")
+	}
+	if c.Auth.ValidateFailureLimit < 0 {
+		fail("auth.validate_failure_limit", "must not be negative")
+	}
+	if c.Auth.ValidateFailureDelay < 0 {
+		fail("auth.validate_failure_delay", "must not be negative")
+	}
+	if c.Auth.ValidateCacheTTL < 0 {
+		fail("auth.validate_cache_ttl", "must not be negative")
+	}
+	if c.Auth.ImpersonationDuration < 0 {
+		fail("auth.impersonation_duration", "must not be negative")
+	}
+	if c.Auth.ImpersonationEnabled && c.Auth.ImpersonationDuration > c.Auth.AccessTokenDuration {
+		warn("auth.impersonation_duration", "is longer than auth.access_token_duration, impersonation tokens should be short-lived")
+	}
+	if err := c.GRPC.Validate(); err != nil {
+		fail("", "%v", err)
+	}
+	switch c.Pipeline.Deploy.Platform {
+	case "kubernetes", "static":
+	case "ssh":
+		checkSSHDeploy("pipeline.deploy.ssh", c.Pipeline.Deploy.SSH, fail)
+	case "external":
+		checkExternalDeploy("pipeline.deploy.external", c.Pipeline.Deploy.External, fail)
+	case "sftp", "ftps":
+		checkFileTransfer("pipeline.deploy.file_transfer", c.Pipeline.Deploy.Platform, c.Pipeline.Deploy.FileTransfer, fail)
+	default:
+		fail("pipeline.deploy.platform", "%q is not supported, expected kubernetes, static, ssh, external, sftp or ftps", c.Pipeline.Deploy.Platform)
+	}
+	for env, target := range c.Pipeline.Deploy.Targets {
+		key := "pipeline.deploy.targets." + env
+		switch target.Platform {
+		case "", "kubernetes", "static":
+		case "ssh":
+			checkSSHDeploy(key+".ssh", c.Pipeline.Deploy.Target(env).SSH, fail)
+		case "external":
+			checkExternalDeploy(key+".external", c.Pipeline.Deploy.Target(env).External, fail)
+		case "sftp", "ftps":
+			checkFileTransfer(key+".file_transfer", target.Platform, c.Pipeline.Deploy.Target(env).FileTransfer, fail)
+		default:
+			fail(key+".platform", "%q is not supported, expected kubernetes, static, ssh, external, sftp or ftps", target.Platform)
+		}
+		if len(target.Targets) > 0 {
+			fail(key+".targets", "targets cannot be nested")
+		}
+		checkStaticSync(key+".sync", target.Sync, fail)
+	}
+	checkStaticSync("pipeline.deploy.sync", c.Pipeline.Deploy.Sync, fail)
+	if c.RateLimit.RequestsPerMinute < 0 {
+		fail("rate_limit.requests_per_minute", "must not be negative")
+	}
+	switch c.Events.Driver {
+	case "", "memory":
+	case "nats":
+		if c.Events.NATSURL == "" {
+			fail("events.nats_url", "is not set")
+		}
+	default:
+		fail("events.driver", "%q is not supported, expected memory or nats", c.Events.Driver)
+	}
+	if c.Events.QueueSize < 0 {
+		fail("events.queue_size", "must not be negative")
+	}
+	if c.Leader.RetryInterval < 0 {
+		fail("leader.retry_interval", "must not be negative")
+	}
+	if c.Secrets.MasterKey != "" {
+		checkMasterKey("secrets.master_key", c.Secrets.MasterKey, fail)
+	}
+	for i, key := range c.Secrets.PreviousMasterKeys {
+		checkMasterKey(fmt.Sprintf("secrets.previous_master_keys[%d]", i), key, fail)
+	}
+	if c.Secrets.SigningKeyGrace < 0 {
+		fail("secrets.signing_key_grace", "must not be negative")
+	}
+	if c.Secrets.RefreshInterval < 0 {
+		fail("secrets.refresh_interval", "must not be negative")
+	}
+	if c.Secrets.ReencryptInterval < 0 {
+		fail("secrets.reencrypt_interval", "must not be negative")
+	}
+	if c.UpdateCheck.URL != "" && !strings.HasPrefix(c.UpdateCheck.URL, "https://") && !strings.HasPrefix(c.UpdateCheck.URL, "http://") {
+		fail("update_check.url", "must be an http(s) URL")
+	}
+	if c.UpdateCheck.Interval < 0 {
+		fail("update_check.interval", "must not be negative")
+	}
+	if c.I18n.DefaultLocale != "" && !i18n.Supported(i18n.Locale(c.I18n.DefaultLocale)) {
+		fail("i18n.default_locale", "%q is not supported, expected one of %v", c.I18n.DefaultLocale, i18n.Locales)
+	}
+	if err := plugin.Validate(c.Pipeline.Plugins); err != nil {
+		fail("pipeline.plugins", "%v", err)
+	}
+	if err := types.ValidatePlatforms(c.Pipeline.NodeJS.Platforms); err != nil {
+		fail("pipeline.nodejs.platforms", "%v", err)
+	}
+	for project, platforms := range c.Pipeline.NodeJS.ProjectPlatforms {
+		if err := types.ValidatePlatforms(platforms); err != nil {
+			fail("pipeline.nodejs.project_platforms."+project, "%v", err)
+		}
+	}
+	for project, registries := range c.Pipeline.NodeJS.NPMRegistries {
+		for _, registry := range registries {
+			if err := registry.Validate(); err != nil {
+				fail("pipeline.nodejs.npm_registries."+project, "%v", err)
+			}
+		}
+	}
+	switch maps := c.Pipeline.SourceMaps; maps.Provider {
+	case "":
+	case "sentry":
+		if maps.Organization == "" {
+			fail("pipeline.source_maps.organization", "is required for sentry")
+		}
+		if maps.Token == "" {
+			fail("pipeline.source_maps.token", "is required for sentry")
+		}
+	default:
+		fail("pipeline.source_maps.provider", "%q is not supported, expected sentry", maps.Provider)
+	}
+	if format := c.Pipeline.Releases.TagFormat; format != "" && !strings.Contains(format, "{timestamp}") && !strings.Contains(format, "{build}") {
+		fail("pipeline.releases.tag_format", "must contain {timestamp} or {build} so every deploy gets its own tag")
+	}
+	timeouts := []struct {
+		key     string
+		seconds int
+	}{
+		{"default_timeout", c.Pipeline.DefaultTimeout},
+		{"timeouts.clone", c.Pipeline.Timeouts.Clone},
+		{"timeouts.install", c.Pipeline.Timeouts.Install},
+		{"timeouts.build", c.Pipeline.Timeouts.Build},
+		{"timeouts.package", c.Pipeline.Timeouts.Package},
+		{"timeouts.deploy", c.Pipeline.Timeouts.Deploy},
+		{"timeouts.health_check", c.Pipeline.Timeouts.HealthCheck},
+	}
+	for _, timeout := range timeouts {
+		if timeout.seconds < 0 {
+			fail("pipeline."+timeout.key, "must not be negative")
+		}
+	}
+	if c.Pipeline.DeployLock.Lease < 0 {
+		fail("pipeline.deploy_lock.lease", "must not be negative")
+	}
+	if c.Pipeline.DeployLock.Timeout < 0 {
+		fail("pipeline.deploy_lock.timeout", "must not be negative")
+	}
+	limits := c.Pipeline.Source.Limits
+	if limits.MaxRepoSize < 0 {
+		fail("pipeline.source.limits.max_repo_size", "must not be negative")
+	}
+	if limits.MaxTreeSize < 0 {
+		fail("pipeline.source.limits.max_tree_size", "must not be negative")
+	}
+	if limits.MaxFiles < 0 {
+		fail("pipeline.source.limits.max_files", "must not be negative")
+	}
+	if c.Pipeline.Recovery.StaleAfter < 0 {
+		fail("pipeline.recovery.stale_after", "must not be negative")
+	}
+	if c.Pipeline.Badges.MaxAge < 0 {
+		fail("pipeline.badges.max_age", "must not be negative")
+	}
+	if c.Pipeline.PerfAudit.Threshold < 0 || c.Pipeline.PerfAudit.Threshold > 100 {
+		fail("pipeline.perf_audit.threshold", "must be between 0 and 100")
+	}
+	if c.Pipeline.Integrity.Samples < 0 {
+		fail("pipeline.integrity.samples", "must not be negative")
+	}
+	if c.Pipeline.Integrity.Attempts < 0 {
+		fail("pipeline.integrity.attempts", "must not be negative")
+	}
+	if c.Pipeline.Debug.TTL < 0 {
+		fail("pipeline.debug.ttl", "must not be negative")
+	}
+	if !types.ValidDedupPolicy(types.DedupPolicy(c.Pipeline.BuildDedup)) {
+		fail("pipeline.build_dedup", "%q is not supported, expected queue or supersede", c.Pipeline.BuildDedup)
+	}
+	if c.Pipeline.Scheduling.MaxConcurrentBuilds < 0 {
+		fail("pipeline.scheduling.max_concurrent_builds", "must not be negative")
+	}
+	if c.Pipeline.Agents.Enabled && c.Pipeline.Agents.Token == "" {
+		fail("pipeline.agents.token", "is required when build agents are enabled")
+	}
+	if scale := c.Pipeline.Agents.Autoscale; scale.Enabled {
+		switch scale.Provider {
+		case "kubernetes", "ec2", "hetzner":
+		default:
+			fail("pipeline.agents.autoscale.provider", "%q is not supported, expected kubernetes, ec2 or hetzner", scale.Provider)
+		}
+		if scale.MinAgents < 0 {
+			fail("pipeline.agents.autoscale.min_agents", "must not be negative")
+		}
+		if scale.MaxAgents > 0 && scale.MaxAgents < scale.MinAgents {
+			fail("pipeline.agents.autoscale.max_agents", "must not be below min_agents")
+		}
+	}
+
+	if addons := c.Pipeline.AddOns; addons.Provider != "" {
+		switch addons.Provider {
+		case "kubernetes":
+		case "external":
+			if addons.APIURL == "" {
+				fail("pipeline.addons.api_url", "is required for the external provider")
+			}
+		default:
+			fail("pipeline.addons.provider", "%q is not supported, expected kubernetes or external", addons.Provider)
+		}
+		if addons.Timeout < 0 {
+			fail("pipeline.addons.timeout", "must not be negative")
+		}
+	}
+
+	if c.HTTP.CORS.AllowCredentials && contains(c.HTTP.CORS.AllowedOrigins, "*") {
+		warn("http.cors.allowed_origins", "allows any origin with credentials")
+	}
+	for _, command := range c.Pipeline.Exec.AllowedCommands {
+		if shells[path.Base(command)] {
+			warn("pipeline.exec.allowed_commands", "allows the shell %s, which runs any command", command)
+		}
+	}
+	if c.Webhook.AllowPrivateURLs {
+		warn("webhook.allow_private_urls", "lets project owners make the server call internal addresses")
+	}
+	if c.Pipeline.Agents.Autoscale.Enabled && !c.Pipeline.Agents.Enabled {
+		warn("pipeline.agents.autoscale.enabled", "has no effect without pipeline.agents.enabled")
+	}
+	if c.Pipeline.Scheduling.Preempt && c.Pipeline.Scheduling.MaxConcurrentBuilds == 0 {
+		warn("pipeline.scheduling.preempt", "has no effect without max_concurrent_builds")
+	}
+	if c.GitHub.Enabled && c.GitHub.Token == "" && c.GitHub.App.AppID == 0 &&
+		len(c.GitHub.OwnerTokens) == 0 && len(c.GitHub.ProjectTokens) == 0 {
+		warn("github.enabled", "no token or app is configured, statuses cannot be reported")
+	}
+	if c.Pipeline.Releases.Enabled && !c.GitHub.Enabled {
+		warn("pipeline.releases.enabled", "has no effect without github.enabled")
+	}
+	if c.Pipeline.StatusPage.Enabled && c.Pipeline.Monitor.MetricsAddr == "" {
+		warn("pipeline.status_page.enabled", "has no effect without pipeline.monitor.metrics_addr")
+	}
+	if c.Pipeline.Badges.Enabled && c.Pipeline.Monitor.MetricsAddr == "" {
+		warn("pipeline.badges.enabled", "has no effect without pipeline.monitor.metrics_addr")
+	}
+	if c.Pipeline.Provenance.Verify && c.Pipeline.Provenance.Key == "" && c.Pipeline.Provenance.PublicKey == "" {
+		warn("pipeline.provenance.verify", "no key is configured, every deploy will be refused")
+	}
+	if c.Pipeline.AddOns.Provider != "" {
+		kubernetes := c.Pipeline.Deploy.Platform == "kubernetes"
+		for _, target := range c.Pipeline.Deploy.Targets {
+			kubernetes = kubernetes || target.Platform == "kubernetes"
+		}
+		if !kubernetes {
+			warn("pipeline.addons.provider", "add-ons are only injected into deployments on the kubernetes platform")
+		}
+	}
+	return problems
+}
+
+// checkMasterKey reports a master key that cannot encrypt secrets. The
+// key itself is never part of the message.
+func checkMasterKey(key, value string, fail func(key, format string, args ...interface{})) {
+	if _, err := DecodeMasterKey(value); err != nil {
+		fail(key, "%v", err)
+	}
+}
+
+// checkStaticSync reports invalid replication settings of a deploy target
+func checkStaticSync(key string, sync pipelineconfig.StaticSyncConfig, fail func(key, format string, args ...interface{})) {
+	switch sync.Method {
+	case "", "rsync":
+	case "s3":
+		if !strings.HasPrefix(sync.Bucket, "s3://") {
+			fail(key+".bucket", "must be an s3:// URL for the s3 method")
+		}
+	default:
+		fail(key+".method", "%q is not supported, expected rsync or s3", sync.Method)
+	}
+	if sync.MinHosts < 0 || sync.MinHosts > len(sync.Hosts) {
+		fail(key+".min_hosts", "must be between 0 and the number of hosts")
+	}
+	if sync.Timeout < 0 {
+		fail(key+".timeout", "must not be negative")
+	}
+	if sync.KeepReleases < 0 {
+		fail(key+".keep_releases", "must not be negative")
+	}
+}
+
+// checkSSHDeploy reports missing settings of the ssh platform
+func checkSSHDeploy(key string, ssh pipelineconfig.SSHDeployConfig, fail func(key, format string, args ...interface{})) {
+	if ssh.Host == "" {
+		fail(key+".host", "is required for the ssh platform")
+	}
+	if ssh.User == "" {
+		fail(key+".user", "is required for the ssh platform")
+	}
+	if ssh.PrivateKey == "" && ssh.PrivateKeyFile == "" && ssh.PrivateKeySecret == "" {
+		fail(key+".private_key", "private_key, private_key_file or private_key_secret is required for the ssh platform")
+	}
+	if ssh.KnownHosts == "" && ssh.HostKey == "" {
+		fail(key+".known_hosts", "known_hosts or host_key is required to verify the host")
+	}
+	if ssh.KeepReleases < 0 {
+		fail(key+".keep_releases", "must not be negative")
+	}
+}
+
+// checkExternalDeploy reports missing settings of the external platform
+func checkExternalDeploy(key string, external pipelineconfig.ExternalDeployConfig, fail func(key, format string, args ...interface{})) {
+	switch external.Provider {
+	case "cloudflare":
+		if external.AccountID == "" {
+			fail(key+".account_id", "is required for cloudflare")
+		}
+	case "netlify":
+	default:
+		fail(key+".provider", "%q is not supported, expected cloudflare or netlify", external.Provider)
+	}
+	if external.Token == "" && len(external.ProjectTokens) == 0 {
+		fail(key+".token", "token or project_tokens is required for the external platform")
+	}
+	if external.Timeout < 0 {
+		fail(key+".timeout", "must not be negative")
+	}
+}
+
+// checkFileTransfer reports missing settings of the sftp and ftps
+// platforms
+func checkFileTransfer(key, platform string, transfer pipelineconfig.FileTransferConfig, fail func(key, format string, args ...interface{})) {
+	if transfer.Host == "" {
+		fail(key+".host", "is required for the %s platform", platform)
+	}
+	if transfer.User == "" {
+		fail(key+".user", "is required for the %s platform", platform)
+	}
+	if platform == "ftps" {
+		if transfer.Password == "" {
+			fail(key+".password", "is required for the ftps platform")
+		}
+	} else {
+		if transfer.Password == "" && transfer.PrivateKeyFile == "" {
+			fail(key+".password", "password or private_key_file is required for the sftp platform")
+		}
+		if transfer.KnownHosts == "" && transfer.HostKey == "" {
+			fail(key+".known_hosts", "known_hosts or host_key is required to verify the host")
+		}
+	}
+	if transfer.Concurrency < 0 {
+		fail(key+".concurrency", "must not be negative")
+	}
+}
+
+// field is a setting in the config file
+type field struct {
+	key string
+	typ reflect.Type
+	doc string
+}
+
+type fields []field
+
+// known reports whether key is a setting. Keys below map settings are
+// free-form, and grpc.<environment> repeats the grpc settings.
+func (fs fields) known(key string) bool {
+	for _, f := range fs {
+		if f.key == key {
+			return true
+		}
+		if f.typ.Kind() == reflect.Map && strings.HasPrefix(key, f.key+".") {
+			return true
+		}
+	}
+	if parts := strings.Split(key, "."); len(parts) == 3 && parts[0] == "grpc" {
+		return fs.known("grpc." + parts[2])
+	}
+	return false
+}
+
+// schema lists the settings of a config struct. Nested structs other
+// than durations become sections and are not listed themselves.
+func schema(t reflect.Type, prefix string) fields {
+	var fs fields
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := sf.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if isSection(sf.Type) {
+			fs = append(fs, schema(sf.Type, key+".")...)
+			continue
+		}
+		fs = append(fs, field{key: key, typ: sf.Type})
+	}
+	return fs
+}
+
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != reflect.TypeOf(time.Duration(0))
+}
+
+// Example returns the values the example config is generated with. They
+// match the shipped config, secrets are left empty.
+func Example() *AppConfig {
+	return &AppConfig{
+		Server: ServerConfig{Host: "0.0.0.0", Port: "50051"},
+		Auth: AuthConfig{
+			AccessTokenDuration:  15 * time.Minute,
+			RefreshTokenDuration: 72 * time.Hour,
+			RefreshTokenEnabled:  true,
+			ValidateFailureLimit: 10,
+			ValidateFailureDelay: 100 * time.Millisecond,
+
+			ImpersonationDuration: 15 * time.Minute,
+		},
+		Database: DatabaseConfig{Host: "localhost", Port: 5432, User: "postgres", Name: "chef_infra", SSLMode: "require"},
+		Project:  ProjectConfig{DeletedRetention: 7 * 24 * time.Hour, PurgeInterval: time.Hour},
+		HTTP: HTTPConfig{
+			CORS: CORSConfig{
+				AllowedMethods: []string{"GET", "POST"},
+				AllowedHeaders: []string{"Authorization", "Content-Type"},
+				MaxAge:         10 * time.Minute,
+			},
+		},
+		Webhook: WebhookConfig{Timeout: 10 * time.Second, Workers: 4, QueueSize: 256, FailureThreshold: 10},
+		GitHub:  GitHubConfig{StatusContext: "chef-infra"},
+		I18n:    I18nConfig{DefaultLocale: string(i18n.Default)},
+		Leader:  LeaderConfig{RetryInterval: 15 * time.Second},
+		Redis:   RedisConfig{KeyPrefix: "chef:", Timeout: 2 * time.Second},
+		Events:  EventsConfig{Driver: "memory", SubjectPrefix: "chef.events", QueueSize: 256, Timeout: 5 * time.Second},
+		Secrets: SecretsConfig{RefreshInterval: time.Minute, ReencryptInterval: time.Hour},
+		UpdateCheck: UpdateCheckConfig{
+			URL:      version.DefaultReleaseURL,
+			Interval: 24 * time.Hour,
+		},
+		Pipeline: pipelineconfig.PipelineConfig{
+			BuildDir:       "/var/lib/chef-infra/builds",
+			ArtifactsDir:   "/var/lib/chef-infra/artifacts",
+			CacheDir:       "/var/lib/chef-infra/cache",
+			DefaultTimeout: 1800,
+			BuildDedup:     string(types.DedupQueue),
+			NodeJS:         pipelineconfig.NodeJSConfig{DefaultVersion: "20", MaxBuildTime: 1800, BuildCache: true},
+			Deploy:         pipelineconfig.DeployConfig{Platform: "static", StaticPath: "/var/www/html", MaxDeploySize: 100 << 20},
+		},
+	}
+}
+
+// WriteExample writes a TOML config with every setting of cfg, commented
+// from the config structs' documentation
+func WriteExample(w io.Writer, cfg *AppConfig) error {
+	docs, err := parseDocs(source, pipelineconfig.Source)
+	if err != nil {
+		return err
+	}
+	ew := &exampleWriter{w: w, docs: docs}
+	fmt.Fprintln(w, "# chef-infra configuration. Generated with -example-config.")
+	fmt.Fprintf(w, "# String values may be encrypted with -encrypt-value and the key in %s.\n", ConfigKeyEnv)
+	ew.section(reflect.ValueOf(cfg).Elem(), "")
+	return ew.err
+}
+
+type exampleWriter struct {
+	w    io.Writer
+	docs map[string]string
+	err  error
+}
+
+func (ew *exampleWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
+
+func (ew *exampleWriter) comment(doc string) {
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		if line != "" {
+			ew.printf("# %s\n", line)
+		}
+	}
+}
+
+// section writes the settings of a struct, then its sections. TOML keys
+// after a table header belong to that table.
+func (ew *exampleWriter) section(v reflect.Value, prefix string) {
+	t := v.Type()
+	var sections []int
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := sf.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		if isSection(sf.Type) {
+			sections = append(sections, i)
+			continue
+		}
+		ew.comment(ew.docs[t.Name()+"."+sf.Name])
+		if contains(requiredKeys, prefix+name) {
+			ew.comment("Required")
+		}
+		ew.printf("%s = %s\n", name, tomlValue(v.Field(i)))
+	}
+
+	for _, i := range sections {
+		sf := t.Field(i)
+		key := prefix + sf.Tag.Get("mapstructure")
+		ew.printf("\n")
+		if doc := ew.docs[sf.Type.Name()]; doc != "" {
+			ew.comment(doc)
+		} else {
+			ew.comment(ew.docs[t.Name()+"."+sf.Name])
+		}
+		ew.printf("[%s]\n", key)
+		ew.section(v.Field(i), key+".")
+	}
+}
+
+func tomlValue(v reflect.Value) string {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return strconv.Quote(d.String())
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = tomlValue(v.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = strconv.Quote(key.String()) + " = " + tomlValue(v.MapIndex(key))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return strconv.Quote(fmt.Sprint(v.Interface()))
+}
+
+// parseDocs reads the comments of struct types and their fields from Go
+// sources, keyed by "Type" and "Type.Field"
+func parseDocs(sources ...string) (map[string]string, error) {
+	docs := make(map[string]string)
+	for _, src := range sources {
+		file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config source: %w", err)
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				doc := ts.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+				docs[ts.Name.Name] = doc.Text()
+				for _, f := range st.Fields.List {
+					text := f.Doc.Text() + f.Comment.Text()
+					for _, name := range f.Names {
+						docs[ts.Name.Name+"."+name.Name] = text
+					}
+				}
+			}
+		}
+	}
+	return docs, nil
+}
+
+// shells run any command they are given, defeating an allowlist
+var shells = map[string]bool{"sh": true, "bash": true, "ash": true, "dash": true, "zsh": true, "ksh": true, "fish": true}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
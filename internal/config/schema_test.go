@@ -0,0 +1,358 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+const validConfig = `
+[server]
+port = "50051"
+
+[auth]
+jwt_secret = "0123456789abcdef0123456789abcdef"
+access_token_duration = "15m"
+
+[database]
+host = "localhost"
+user = "postgres"
+name = "chef_infra"
+
+[grpc.production]
+max_send_message_size = 4194304
+
+[github]
+owner_tokens = { "acme" = "token" }
+
+[pipeline.deploy]
+platform = "static"
+`
+
+func TestValidateFile(t *testing.T) {
+	problems, err := ValidateFile(writeConfig(t, validConfig))
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	tests := []struct {
+		name    string
+		edit    func(string) string
+		want    string
+		warning bool
+	}{
+		{
+			name: "unknown key",
+			edit: func(c string) string { return c + "\n[pipeline.nodejs]\ndefault_verison = \"20\"\n" },
+			want: "error: pipeline.nodejs.default_verison: unknown key",
+		},
+		{
+			name: "type error",
+			edit: func(c string) string {
+				return strings.Replace(c, `host = "localhost"`, "host = \"localhost\"\nport = \"fivefourthreetwo\"", 1)
+			},
+			want: "database.port",
+		},
+		{
+			name: "missing required key",
+			edit: func(c string) string { return strings.Replace(c, `name = "chef_infra"`, "", 1) },
+			want: "error: database.name: is required",
+		},
+		{
+			name:    "short jwt secret",
+			edit:    func(c string) string { return strings.Replace(c, "0123456789abcdef0123456789abcdef", "secret", 1) },
+			want:    "warning: auth.jwt_secret: is shorter than 32 bytes",
+			warning: true,
+		},
+		{
+			name: "negative token validation limit",
+			edit: func(c string) string {
+				return strings.Replace(c, "access_token_duration", "validate_failure_limit = -1\naccess_token_duration", 1)
+			},
+			want: "error: auth.validate_failure_limit: must not be negative",
+		},
+		{
+			name: "long-lived impersonation tokens",
+			edit: func(c string) string {
+				return strings.Replace(c, "access_token_duration", "impersonation_enabled = true\nimpersonation_duration = \"1h\"\naccess_token_duration", 1)
+			},
+			want:    "warning: auth.impersonation_duration: is longer than auth.access_token_duration",
+			warning: true,
+		},
+		{
+			name: "unsupported platform",
+			edit: func(c string) string { return strings.Replace(c, `"static"`, `"heroku"`, 1) },
+			want: `error: pipeline.deploy.platform: "heroku" is not supported`,
+		},
+		{
+			name: "unsupported target platform",
+			edit: func(c string) string {
+				return c + "\n[pipeline.deploy.targets.production]\nplatform = \"s3\"\n"
+			},
+			want: `error: pipeline.deploy.targets.production.platform: "s3" is not supported`,
+		},
+		{
+			name: "ssh target without host verification",
+			edit: func(c string) string {
+				return c + "\n[pipeline.deploy.targets.production]\nplatform = \"ssh\"\n[pipeline.deploy.targets.production.ssh]\n" +
+					"host = \"vm1\"\nuser = \"deploy\"\nprivate_key_file = \"/etc/chef/id_ed25519\"\n"
+			},
+			want: "error: pipeline.deploy.targets.production.ssh.known_hosts: known_hosts or host_key is required",
+		},
+		{
+			name: "cloudflare without an account",
+			edit: func(c string) string {
+				return c + "\n[pipeline.deploy.targets.production]\nplatform = \"external\"\n[pipeline.deploy.targets.production.external]\n" +
+					"provider = \"cloudflare\"\ntoken = \"cf-token\"\n"
+			},
+			want: "error: pipeline.deploy.targets.production.external.account_id: is required for cloudflare",
+		},
+		{
+			name: "external add-ons without an API",
+			edit: func(c string) string {
+				c = strings.Replace(c, `platform = "static"`, `platform = "kubernetes"`, 1)
+				return c + "\n[pipeline.addons]\nprovider = \"external\"\n"
+			},
+			want: "error: pipeline.addons.api_url: is required for the external provider",
+		},
+		{
+			name: "ftps without a password",
+			edit: func(c string) string {
+				return c + "\n[pipeline.deploy.targets.legacy]\nplatform = \"ftps\"\n[pipeline.deploy.targets.legacy.file_transfer]\n" +
+					"host = \"ftp.example.com\"\nuser = \"shop\"\n"
+			},
+			want: "error: pipeline.deploy.targets.legacy.file_transfer.password: is required for the ftps platform",
+		},
+		{
+			name: "sync to s3 without a bucket",
+			edit: func(c string) string {
+				return c + "\n[pipeline.deploy.targets.production.sync]\nhosts = [\"web1\"]\nmethod = \"s3\"\n"
+			},
+			want: "error: pipeline.deploy.targets.production.sync.bucket: must be an s3:// URL",
+		},
+		{
+			name: "sync requiring more hosts than listed",
+			edit: func(c string) string {
+				return c + "\n[pipeline.deploy.sync]\nhosts = [\"web1\", \"web2\"]\nmin_hosts = 3\n"
+			},
+			want: "error: pipeline.deploy.sync.min_hosts: must be between 0 and the number of hosts",
+		},
+		{
+			name:    "shell allowed in exec sessions",
+			edit:    func(c string) string { return c + "\n[pipeline.exec]\nallowed_commands = [\"ls\", \"/bin/bash\"]\n" },
+			want:    "warning: pipeline.exec.allowed_commands: allows the shell /bin/bash, which runs any command",
+			warning: true,
+		},
+		{
+			name:    "preemption without a build limit",
+			edit:    func(c string) string { return c + "\n[pipeline.scheduling]\npreempt = true\n" },
+			want:    "warning: pipeline.scheduling.preempt: has no effect without max_concurrent_builds",
+			warning: true,
+		},
+		{
+			name: "build agents without a token",
+			edit: func(c string) string { return c + "\n[pipeline.agents]\nenabled = true\n" },
+			want: "error: pipeline.agents.token: is required when build agents are enabled",
+		},
+		{
+			name: "unknown autoscale provider",
+			edit: func(c string) string {
+				return c + "\n[pipeline.agents]\nenabled = true\ntoken = \"secret\"\n[pipeline.agents.autoscale]\nenabled = true\nprovider = \"gce\"\n"
+			},
+			want: `error: pipeline.agents.autoscale.provider: "gce" is not supported`,
+		},
+		{
+			name: "autoscaling without agents",
+			edit: func(c string) string {
+				return c + "\n[pipeline.agents.autoscale]\nenabled = true\nprovider = \"hetzner\"\n"
+			},
+			want:    "warning: pipeline.agents.autoscale.enabled: has no effect without pipeline.agents.enabled",
+			warning: true,
+		},
+		{
+			name:    "status page without an HTTP address",
+			edit:    func(c string) string { return c + "\n[pipeline.status_page]\nenabled = true\n" },
+			want:    "warning: pipeline.status_page.enabled: has no effect without pipeline.monitor.metrics_addr",
+			warning: true,
+		},
+		{
+			name: "unsupported locale",
+			edit: func(c string) string { return c + "\n[i18n]\ndefault_locale = \"fr\"\n" },
+			want: `error: i18n.default_locale: "fr" is not supported`,
+		},
+		{
+			name: "unknown plugin stage",
+			edit: func(c string) string {
+				return c + "\n[[pipeline.plugins]]\nname = \"scan\"\ntype = \"command\"\ncommand = [\"scan\"]\nstages = [\"pre_test\"]\n"
+			},
+			want: `error: pipeline.plugins: plugin scan: unknown stage "pre_test"`,
+		},
+		{
+			name: "npm registry without scope",
+			edit: func(c string) string {
+				return c + "\n[pipeline.nodejs.npm_registries]\nshop = [{ scope = \"company\", url = \"https://npm.company.com/\", token = \"secret\" }]\n"
+			},
+			want: `error: pipeline.nodejs.npm_registries.shop: scope "company" must be a lowercase npm scope`,
+		},
+		{
+			name: "unsupported default platform",
+			edit: func(c string) string { return c + "\n[pipeline.nodejs]\nplatforms = [\"linux/s390x\"]\n" },
+			want: "error: pipeline.nodejs.platforms: unsupported target platform: linux/s390x",
+		},
+		{
+			name: "unsupported project platform",
+			edit: func(c string) string {
+				return c + "\n[pipeline.nodejs.project_platforms]\nshop = [\"linux/amd64\", \"linux/arm/v7\"]\n"
+			},
+			want: "error: pipeline.nodejs.project_platforms.shop: unsupported target platform: linux/arm/v7",
+		},
+		{
+			name: "sentry without token",
+			edit: func(c string) string {
+				return c + "\n[pipeline.source_maps]\nprovider = \"sentry\"\norganization = \"acme\"\n"
+			},
+			want: "error: pipeline.source_maps.token: is required for sentry",
+		},
+		{
+			name: "release tag without unique part",
+			edit: func(c string) string {
+				return c + "\n[pipeline.releases]\ntag_format = \"{env}-{commit}\"\n"
+			},
+			want: "error: pipeline.releases.tag_format: must contain {timestamp} or {build} so every deploy gets its own tag",
+		},
+		{
+			name: "negative deploy lock lease",
+			edit: func(c string) string { return c + "\n[pipeline.deploy_lock]\nlease = -1\n" },
+			want: "error: pipeline.deploy_lock.lease: must not be negative",
+		},
+		{
+			name: "negative recovery stale after",
+			edit: func(c string) string { return c + "\n[pipeline.recovery]\nstale_after = -1\n" },
+			want: "error: pipeline.recovery.stale_after: must not be negative",
+		},
+		{
+			name: "negative source file limit",
+			edit: func(c string) string { return c + "\n[pipeline.source.limits]\nmax_files = -1\n" },
+			want: "error: pipeline.source.limits.max_files: must not be negative",
+		},
+		{
+			name: "negative phase timeout",
+			edit: func(c string) string { return c + "\n[pipeline.timeouts]\ninstall = -1\n" },
+			want: "error: pipeline.timeouts.install: must not be negative",
+		},
+		{
+			name: "update check without http URL",
+			edit: func(c string) string { return c + "\n[update_check]\nenabled = true\nurl = \"ftp://example.com\"\n" },
+			want: "error: update_check.url: must be an http(s) URL",
+		},
+		{
+			name: "nats without url",
+			edit: func(c string) string { return c + "\n[events]\ndriver = \"nats\"\n" },
+			want: "error: events.nats_url: is not set",
+		},
+		{
+			name: "short master key",
+			edit: func(c string) string { return c + "\n[secrets]\nprevious_master_keys = [\"c2hvcnQ=\"]\n" },
+			want: "error: secrets.previous_master_keys[0]: must decode to 32 bytes, got 5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := ValidateFile(writeConfig(t, tt.edit(validConfig)))
+			require.NoError(t, err)
+			require.Len(t, problems, 1)
+			assert.Contains(t, problems[0].String(), tt.want)
+			assert.Equal(t, !tt.warning, HasErrors(problems))
+		})
+	}
+}
+
+func TestValidateFile_EncryptedValues(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, MasterKeySize)
+	secret, err := EncryptValue(key, "0123456789abcdef0123456789abcdef")
+	require.NoError(t, err)
+	token, err := EncryptValue(key, "token")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, EncryptedPrefix))
+
+	encrypted := strings.Replace(validConfig, `"0123456789abcdef0123456789abcdef"`, `"`+secret+`"`, 1)
+	encrypted = strings.Replace(encrypted, `"acme" = "token"`, `"acme" = "`+token+`"`, 1)
+	path := writeConfig(t, encrypted)
+
+	t.Setenv(ConfigKeyEnv, "")
+	problems, err := ValidateFile(path)
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	assert.Equal(t, "error: auth.jwt_secret: is encrypted but neither CHEF_CONFIG_KEY nor CHEF_CONFIG_KEY_FILE is set", problems[0].String())
+
+	// Decrypted values are checked like plain ones
+	t.Setenv(ConfigKeyEnv, base64.StdEncoding.EncodeToString(key))
+	problems, err = ValidateFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	keyFile := filepath.Join(t.TempDir(), "config.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, MasterKeySize))+"\n"), 0600))
+	t.Setenv(ConfigKeyEnv, "")
+	t.Setenv(ConfigKeyFileEnv, keyFile)
+	problems, err = ValidateFile(path)
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	assert.Equal(t, "github.owner_tokens.acme", problems[1].Key)
+	assert.Equal(t, "cannot be decrypted with the config key", problems[1].Message)
+}
+
+func TestDecryptValue(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, MasterKeySize)
+	value, err := EncryptValue(key, "postgres")
+	require.NoError(t, err)
+
+	plaintext, err := DecryptValue(key, value)
+	require.NoError(t, err)
+	assert.Equal(t, "postgres", plaintext)
+
+	plaintext, err = DecryptValue(nil, "postgres")
+	require.NoError(t, err)
+	assert.Equal(t, "postgres", plaintext)
+
+	_, err = DecryptValue(key, EncryptedPrefix+"bm9wZQ==")
+	assert.EqualError(t, err, "is not a valid encrypted value")
+}
+
+func TestValidateFile_ShippedConfig(t *testing.T) {
+	problems, err := ValidateFile(filepath.Join("..", "..", "config", "chef-infra", "config.toml"))
+	require.NoError(t, err)
+	assert.False(t, HasErrors(problems), "%v", problems)
+}
+
+func TestWriteExample(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteExample(&buf, Example()))
+	example := buf.String()
+
+	assert.Contains(t, example, "# ReadReplicaConfig configures an optional replica")
+	assert.Contains(t, example, "[pipeline.image_gc.registry]\n")
+	assert.Contains(t, example, "# \"kubernetes\", \"static\", \"ssh\", \"external\", \"sftp\" or \"ftps\"\n# Required\nplatform = \"static\"\n")
+
+	// Only the secret is left to fill in
+	problems, err := ValidateFile(writeConfig(t, example))
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "auth.jwt_secret", problems[0].Key)
+
+	withSecret := strings.Replace(example, `jwt_secret = ""`, `jwt_secret = "0123456789abcdef0123456789abcdef"`, 1)
+	problems, err = ValidateFile(writeConfig(t, withSecret))
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
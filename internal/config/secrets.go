@@ -0,0 +1,23 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// MasterKeySize is the size of the AES-256 keys encrypting stored secrets
+const MasterKeySize = 32
+
+// DecodeMasterKey decodes a base64 encoded master key, e.g. one generated
+// with `openssl rand -base64 32`
+func DecodeMasterKey(key string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.New("is not valid base64")
+	}
+	if len(decoded) != MasterKeySize {
+		return nil, fmt.Errorf("must decode to %d bytes, got %d", MasterKeySize, len(decoded))
+	}
+	return decoded, nil
+}
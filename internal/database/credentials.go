@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+// Postgres error codes of a refused login
+const (
+	invalidPassword      = "28P01"
+	invalidAuthorization = "28000"
+)
+
+// fallbackConnector connects with the configured password, and with the
+// previous one when the database refuses it, so the password can be
+// changed on the database and in the config in either order. Either side
+// may change first; each new connection tries both.
+type fallbackConnector struct {
+	current  driver.Connector
+	previous driver.Connector // nil without a previous password
+	// usedPrevious reports whether the last connection needed the
+	// previous password
+	usedPrevious atomic.Bool
+}
+
+func (c *fallbackConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.current.Connect(ctx)
+	if err == nil || c.previous == nil || !loginRefused(err) {
+		if err == nil {
+			c.usedPrevious.Store(false)
+		}
+		return conn, err
+	}
+
+	conn, previousErr := c.previous.Connect(ctx)
+	if previousErr != nil {
+		return nil, err
+	}
+	c.usedPrevious.Store(true)
+	return conn, nil
+}
+
+func (c *fallbackConnector) Driver() driver.Driver {
+	return c.current.Driver()
+}
+
+func loginRefused(err error) bool {
+	var state interface{ SQLState() string }
+	if !errors.As(err, &state) {
+		return false
+	}
+	return state.SQLState() == invalidPassword || state.SQLState() == invalidAuthorization
+}
+
+func dsn(cfg *config.DatabaseConfig, password string) string {
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
+		cfg.Host,
+		cfg.User,
+		password,
+		cfg.Name,
+		cfg.Port,
+		cfg.SSLMode,
+	)
+}
+
+// newConnector returns the connector of cfg's database, made by connect
+// for each password
+func newConnector(cfg *config.DatabaseConfig, connect func(dsn string) (driver.Connector, error)) (*fallbackConnector, error) {
+	current, err := connect(dsn(cfg, cfg.Password))
+	if err != nil {
+		return nil, err
+	}
+	connector := &fallbackConnector{current: current}
+	if cfg.PreviousPassword != "" {
+		if connector.previous, err = connect(dsn(cfg, cfg.PreviousPassword)); err != nil {
+			return nil, err
+		}
+	}
+	return connector, nil
+}
+
+// pgxConnector connects through pgx, as gorm does
+func pgxConnector(dsn string) (driver.Connector, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return stdlib.GetConnector(*connConfig), nil
+}
+
+// OpenSQL opens cfg's database through lib/pq for tools outside gorm, such
+// as migrations, trying database.previous_password when the password is
+// refused
+func OpenSQL(cfg *config.DatabaseConfig) (*sql.DB, error) {
+	connector, err := newConnector(cfg, func(dsn string) (driver.Connector, error) {
+		return pq.NewConnector(dsn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
@@ -1,12 +1,11 @@
 package database
 
 import (
-	"fmt"
+	"database/sql"
 	"log"
 	"os"
 	"time"
 
-	_ "github.com/lib/pq"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -16,38 +15,60 @@ import (
 )
 
 type Manager struct {
-	db     *gorm.DB
-	config *config.DatabaseConfig
-	logger *zap.Logger
+	db        *gorm.DB
+	connector *fallbackConnector
+	replica   *replica
+	config    *config.DatabaseConfig
+	logger    *zap.Logger
 }
 
 func NewManager(config *config.DatabaseConfig, logger *zap.Logger) (*Manager, error) {
-	db, err := newDatabase(config)
+	db, connector, err := newDatabase(config)
 	if err != nil {
 		return nil, err
 	}
+	if connector.usedPrevious.Load() {
+		logger.Warn("connected with database.previous_password, finish rotating the password")
+	}
+
+	manager := &Manager{
+		db:        db,
+		connector: connector,
+		config:    config,
+		logger:    logger,
+	}
+
+	if config.ReadReplica.Host != "" {
+		manager.replica = &replica{config: replicaConfig(config)}
+
+		// An unreachable replica must not prevent startup
+		replicaDB, _, err := newDatabase(manager.replica.config)
+		if err != nil {
+			logger.Warn("read replica unavailable, using primary for reads", zap.Error(err))
+		} else {
+			manager.replica.db = replicaDB
+			manager.replica.healthy = true
+		}
+	}
 
-	return &Manager{
-		db:     db,
-		config: config,
-		logger: logger,
-	}, nil
+	return manager, nil
 }
 
 func (m *Manager) DB() *gorm.DB {
 	return m.db
 }
 
-func newDatabase(config *config.DatabaseConfig) (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
-		config.Host,
-		config.User,
-		config.Password,
-		config.Name,
-		config.Port,
-		config.SSLMode,
-	)
+// UsingPreviousPassword reports whether the last connection to the
+// primary needed database.previous_password
+func (m *Manager) UsingPreviousPassword() bool {
+	return m.connector != nil && m.connector.usedPrevious.Load()
+}
+
+func newDatabase(config *config.DatabaseConfig) (*gorm.DB, *fallbackConnector, error) {
+	connector, err := newConnector(config, pgxConnector)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	gormConfig := &gorm.Config{
 		Logger: logger.New(
@@ -61,5 +82,9 @@ func newDatabase(config *config.DatabaseConfig) (*gorm.DB, error) {
 		),
 	}
 
-	return gorm.Open(postgres.Open(dsn), gormConfig)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(connector)}), gormConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, connector, nil
 }
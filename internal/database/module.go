@@ -29,10 +29,14 @@ func registerHooks(
 ) {
 	lifecycle.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
+			manager.startReplicaHealthCheck()
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
 			logger.Info("Closing database connections")
+			if err := manager.stopReplica(); err != nil {
+				logger.Warn("failed to close read replica", zap.Error(err))
+			}
 			sqlDB, err := manager.db.DB()
 			if err != nil {
 				return err
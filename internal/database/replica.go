@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const defaultReplicaHealthCheckInterval = 10 * time.Second
+
+// ReadSource provides the connection used for read-only list/history queries
+type ReadSource interface {
+	ReadDB() *gorm.DB
+}
+
+// replica tracks an optional read replica and whether it is usable
+type replica struct {
+	config  *config.DatabaseConfig
+	db      *gorm.DB
+	healthy bool
+	mu      sync.RWMutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// replicaConfig fills unset replica connection fields from the primary
+func replicaConfig(primary *config.DatabaseConfig) *config.DatabaseConfig {
+	r := primary.ReadReplica
+	cfg := *primary
+	cfg.Host = r.Host
+	if r.Port != 0 {
+		cfg.Port = r.Port
+	}
+	if r.User != "" {
+		cfg.User = r.User
+	}
+	if r.Password != "" {
+		cfg.Password = r.Password
+		cfg.PreviousPassword = ""
+	}
+	if r.Name != "" {
+		cfg.Name = r.Name
+	}
+	if r.SSLMode != "" {
+		cfg.SSLMode = r.SSLMode
+	}
+	return &cfg
+}
+
+// ReadDB returns the read replica when one is configured and healthy, and
+// the primary otherwise.
+func (m *Manager) ReadDB() *gorm.DB {
+	if m.replica == nil {
+		return m.db
+	}
+
+	m.replica.mu.RLock()
+	defer m.replica.mu.RUnlock()
+
+	if m.replica.db == nil || !m.replica.healthy {
+		return m.db
+	}
+	return m.replica.db
+}
+
+// startReplicaHealthCheck periodically pings the replica, reconnecting when
+// it was unavailable at startup.
+func (m *Manager) startReplicaHealthCheck() {
+	if m.replica == nil {
+		return
+	}
+
+	interval := defaultReplicaHealthCheckInterval
+	if seconds := m.config.ReadReplica.HealthCheckInterval; seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	m.replica.stop = make(chan struct{})
+	m.replica.done = make(chan struct{})
+
+	go func() {
+		defer close(m.replica.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.replica.stop:
+				return
+			case <-ticker.C:
+				m.checkReplica()
+			}
+		}
+	}()
+}
+
+func (m *Manager) checkReplica() {
+	m.replica.mu.RLock()
+	db := m.replica.db
+	wasHealthy := m.replica.healthy
+	m.replica.mu.RUnlock()
+
+	var err error
+	if db == nil {
+		db, _, err = newDatabase(m.replica.config)
+	} else {
+		err = ping(db)
+	}
+	healthy := err == nil
+
+	m.replica.mu.Lock()
+	if db != nil {
+		m.replica.db = db
+	}
+	m.replica.healthy = healthy
+	m.replica.mu.Unlock()
+
+	switch {
+	case wasHealthy && !healthy:
+		m.logger.Warn("read replica unavailable, falling back to primary", zap.Error(err))
+	case !wasHealthy && healthy:
+		m.logger.Info("read replica available, routing reads to replica")
+	}
+}
+
+func (m *Manager) stopReplica() error {
+	if m.replica == nil {
+		return nil
+	}
+	if m.replica.stop != nil {
+		close(m.replica.stop)
+		<-m.replica.done
+	}
+
+	m.replica.mu.Lock()
+	defer m.replica.mu.Unlock()
+
+	if m.replica.db == nil {
+		return nil
+	}
+	sqlDB, err := m.replica.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func ping(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
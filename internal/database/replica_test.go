@@ -0,0 +1,75 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+func TestReplicaConfig(t *testing.T) {
+	primary := &config.DatabaseConfig{
+		Host:     "postgres",
+		Port:     5432,
+		User:     "postgres",
+		Password: "secret",
+		Name:     "chef_infra",
+		SSLMode:  "disable",
+		ReadReplica: config.ReadReplicaConfig{
+			Host: "postgres-replica",
+			Port: 5433,
+			User: "reader",
+		},
+	}
+
+	cfg := replicaConfig(primary)
+	assert.Equal(t, "postgres-replica", cfg.Host)
+	assert.Equal(t, 5433, cfg.Port)
+	assert.Equal(t, "reader", cfg.User)
+	assert.Equal(t, "secret", cfg.Password)
+	assert.Equal(t, "chef_infra", cfg.Name)
+	assert.Equal(t, "disable", cfg.SSLMode)
+
+	// The primary config is left untouched
+	assert.Equal(t, "postgres", primary.Host)
+}
+
+func TestManager_ReadDB(t *testing.T) {
+	primary := &gorm.DB{}
+	replicaDB := &gorm.DB{}
+
+	tests := []struct {
+		name     string
+		replica  *replica
+		expected *gorm.DB
+	}{
+		{
+			name:     "no replica configured",
+			expected: primary,
+		},
+		{
+			name:     "replica never connected",
+			replica:  &replica{},
+			expected: primary,
+		},
+		{
+			name:     "replica unhealthy",
+			replica:  &replica{db: replicaDB},
+			expected: primary,
+		},
+		{
+			name:     "replica healthy",
+			replica:  &replica{db: replicaDB, healthy: true},
+			expected: replicaDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{db: primary, replica: tt.replica}
+			assert.Same(t, tt.expected, m.ReadDB())
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package diagnostics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/migration"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+)
+
+// k8sPermissions lists the verbs the Kubernetes deployer relies on
+var k8sPermissions = []authorizationv1.ResourceAttributes{
+	{Group: "apps", Resource: "deployments", Verb: "create"},
+	{Group: "apps", Resource: "deployments", Verb: "update"},
+	{Group: "apps", Resource: "replicasets", Verb: "list"},
+	{Resource: "services", Verb: "create"},
+	{Group: "networking.k8s.io", Resource: "ingresses", Verb: "create"},
+	{Resource: "pods", Verb: "list"},
+	{Resource: "pods/log", Verb: "get"},
+	{Group: "batch", Resource: "jobs", Verb: "create"},
+	{Resource: "nodes", Verb: "list"},
+}
+
+func checkConfig(_ context.Context, cfg *config.AppConfig) (Status, string) {
+	var problems []string
+	for _, p := range cfg.Check() {
+		// Suspicious values are reported by -validate-config
+		if !p.Warning {
+			problems = append(problems, strings.TrimSpace(p.Key+" "+p.Message))
+		}
+	}
+
+	if len(problems) > 0 {
+		return StatusFail, strings.Join(problems, "; ")
+	}
+	return StatusOK, "configuration is valid"
+}
+
+func checkDatabase(ctx context.Context, cfg *config.AppConfig) (Status, string) {
+	migrator, err := migration.NewMigrator(&cfg.Database)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	defer migrator.Close()
+
+	if err := migrator.Ping(ctx); err != nil {
+		return StatusFail, fmt.Sprintf("cannot reach %s:%d: %v", cfg.Database.Host, cfg.Database.Port, err)
+	}
+	return StatusOK, fmt.Sprintf("connected to %s:%d/%s", cfg.Database.Host, cfg.Database.Port, cfg.Database.Name)
+}
+
+func checkMigrations(ctx context.Context, cfg *config.AppConfig) (Status, string) {
+	migrator, err := migration.NewMigrator(&cfg.Database)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	defer migrator.Close()
+
+	if err := migrator.Ping(ctx); err != nil {
+		return StatusSkip, "database unreachable"
+	}
+
+	current, err := migrator.GetCurrentVersion()
+	if err != nil {
+		return StatusFail, fmt.Sprintf("failed to read schema version: %v", err)
+	}
+	latest, err := migrator.GetLatestVersion()
+	if err != nil {
+		return StatusFail, fmt.Sprintf("failed to read migrations: %v", err)
+	}
+
+	if current != latest {
+		// The server migrates on startup, so a pending migration is not fatal
+		return StatusWarn, fmt.Sprintf("schema at version %d, latest is %d", current, latest)
+	}
+	return StatusOK, fmt.Sprintf("schema up to date at version %d", current)
+}
+
+func checkDocker(ctx context.Context, _ *config.AppConfig) (Status, string) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	defer cli.Close()
+
+	ping, err := cli.Ping(ctx)
+	if err != nil {
+		return StatusFail, fmt.Sprintf("docker daemon unreachable: %v", err)
+	}
+	return StatusOK, fmt.Sprintf("docker daemon reachable (API %s, %s)", ping.APIVersion, ping.OSType)
+}
+
+func checkKubernetes(ctx context.Context, cfg *config.AppConfig) (Status, string) {
+	if cfg.Pipeline.Deploy.Platform != "kubernetes" {
+		return StatusSkip, "deploy platform is not kubernetes"
+	}
+
+	restConfig, err := deployer.LoadKubeConfig()
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return StatusFail, fmt.Sprintf("cluster unreachable: %v", err)
+	}
+
+	var denied []string
+	for _, attrs := range k8sPermissions {
+		attrs := attrs
+		attrs.Namespace = cfg.Pipeline.Deploy.Namespace
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attrs},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return StatusFail, fmt.Sprintf("failed to review permissions: %v", err)
+		}
+		if !review.Status.Allowed {
+			denied = append(denied, fmt.Sprintf("%s %s", attrs.Verb, attrs.Resource))
+		}
+	}
+
+	if len(denied) > 0 {
+		return StatusFail, fmt.Sprintf("cluster %s reachable but missing permissions: %s", version.GitVersion, strings.Join(denied, ", "))
+	}
+	return StatusOK, fmt.Sprintf("cluster %s reachable with required permissions", version.GitVersion)
+}
+
+func checkRegistry(ctx context.Context, cfg *config.AppConfig) (Status, string) {
+	registry := cfg.Pipeline.NodeJS.Registry
+	if registry == "" {
+		registry = cfg.Pipeline.Deploy.Registry
+	}
+	if registry == "" {
+		return StatusSkip, "no registry configured"
+	}
+	host := strings.SplitN(registry, "/", 2)[0]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/v2/", host), nil)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return StatusFail, fmt.Sprintf("registry %s unreachable: %v", host, err)
+	}
+	resp.Body.Close()
+
+	if !deployer.HasDockerCredentials(host) {
+		return StatusWarn, fmt.Sprintf("registry %s reachable but no docker login found", host)
+	}
+	return StatusOK, fmt.Sprintf("registry %s reachable with stored credentials", host)
+}
+
+func checkWritablePaths(_ context.Context, cfg *config.AppConfig) (Status, string) {
+	paths := map[string]string{
+		"build_dir":     cfg.Pipeline.BuildDir,
+		"artifacts_dir": cfg.Pipeline.ArtifactsDir,
+		"cache_dir":     cfg.Pipeline.CacheDir,
+	}
+	if cfg.Pipeline.Deploy.Platform == "static" {
+		paths["static_path"] = cfg.Pipeline.Deploy.StaticPath
+	}
+
+	var problems []string
+	for _, name := range []string{"build_dir", "artifacts_dir", "cache_dir", "static_path"} {
+		path, ok := paths[name]
+		if !ok || path == "" {
+			continue
+		}
+		if err := checkWritable(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", name, path, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return StatusFail, strings.Join(problems, "; ")
+	}
+	return StatusOK, "all paths writable"
+}
+
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, ".chef-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
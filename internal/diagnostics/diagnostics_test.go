@@ -0,0 +1,84 @@
+package diagnostics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/config"
+	pipelineconfig "github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestRunner(t *testing.T) {
+	checks := []Check{
+		{Name: "passing", Run: func(context.Context, *config.AppConfig) (Status, string) { return StatusOK, "fine" }},
+		{Name: "warning", Run: func(context.Context, *config.AppConfig) (Status, string) { return StatusWarn, "meh" }},
+	}
+
+	report := NewRunner(&config.AppConfig{}, checks).Run(context.Background())
+	assert.Len(t, report.Results, 2)
+	assert.False(t, report.Failed(), "warnings must not fail the report")
+
+	checks = append(checks, Check{Name: "failing", Run: func(context.Context, *config.AppConfig) (Status, string) {
+		return StatusFail, "broken"
+	}})
+	report = NewRunner(&config.AppConfig{}, checks).Run(context.Background())
+	assert.True(t, report.Failed())
+
+	var out strings.Builder
+	report.WriteText(&out)
+	assert.Contains(t, out.String(), "[fail] failing")
+	assert.Contains(t, out.String(), "1 passed, 1 warnings, 1 failed, 0 skipped")
+}
+
+func TestCheckConfig(t *testing.T) {
+	valid := config.AppConfig{
+		Server: config.ServerConfig{Port: "50051"},
+		GRPC:   config.GRPCConfig{MaxReceiveMessageSize: config.DefaultMaxMessageSize, MaxSendMessageSize: config.DefaultMaxMessageSize},
+		Auth:   config.AuthConfig{JWTSecret: "secret", AccessTokenDuration: time.Minute},
+		Pipeline: pipelineconfig.PipelineConfig{
+			Deploy: pipelineconfig.DeployConfig{Platform: "static"},
+		},
+	}
+
+	status, _ := checkConfig(context.Background(), &valid)
+	assert.Equal(t, StatusOK, status)
+
+	invalid := valid
+	invalid.Auth.JWTSecret = ""
+	invalid.Pipeline.Deploy.Platform = "heroku"
+	invalid.GRPC.MaxSendMessageSize = 1 << 30
+	status, message := checkConfig(context.Background(), &invalid)
+	assert.Equal(t, StatusFail, status)
+	assert.Contains(t, message, "jwt_secret")
+	assert.Contains(t, message, "heroku")
+	assert.Contains(t, message, "grpc.max_send_message_size")
+}
+
+func TestCheckWritablePaths(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.AppConfig{
+		Pipeline: pipelineconfig.PipelineConfig{
+			BuildDir:     filepath.Join(dir, "builds"),
+			ArtifactsDir: filepath.Join(dir, "artifacts"),
+			CacheDir:     filepath.Join(dir, "cache"),
+		},
+	}
+
+	status, _ := checkWritablePaths(context.Background(), cfg)
+	assert.Equal(t, StatusOK, status)
+
+	// A regular file cannot be used as a directory
+	blocker := filepath.Join(dir, "blocker")
+	assert.NoError(t, os.WriteFile(blocker, nil, 0644))
+	cfg.Pipeline.CacheDir = filepath.Join(blocker, "cache")
+
+	status, message := checkWritablePaths(context.Background(), cfg)
+	assert.Equal(t, StatusFail, status)
+	assert.Contains(t, message, "cache_dir")
+}
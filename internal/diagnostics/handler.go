@@ -0,0 +1,41 @@
+package diagnostics
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	pb "github.com/elskow/chef-infra/proto/gen/diagnostics"
+)
+
+type Handler struct {
+	pb.UnimplementedDiagnosticsServer
+	runner *Runner
+	log    *zap.Logger
+}
+
+func NewHandler(runner *Runner, log *zap.Logger) *Handler {
+	return &Handler{
+		runner: runner,
+		log:    log,
+	}
+}
+
+func (h *Handler) Diagnose(ctx context.Context, _ *pb.DiagnoseRequest) (*pb.DiagnoseResponse, error) {
+	report := h.runner.Run(ctx)
+
+	resp := &pb.DiagnoseResponse{Healthy: !report.Failed()}
+	for _, result := range report.Results {
+		resp.Results = append(resp.Results, &pb.CheckResult{
+			Name:       result.Name,
+			Status:     string(result.Status),
+			Message:    result.Message,
+			DurationMs: result.Duration.Milliseconds(),
+		})
+	}
+
+	if report.Failed() {
+		h.log.Warn("diagnostics reported failures")
+	}
+	return resp, nil
+}
@@ -0,0 +1,49 @@
+package diagnostics
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+type Result struct {
+	Name     string
+	Status   Status
+	Message  string
+	Duration time.Duration
+}
+
+type Report struct {
+	Results []Result
+}
+
+// Failed reports whether any check failed. Warnings do not fail the report.
+func (r *Report) Failed() bool {
+	for _, result := range r.Results {
+		if result.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText prints a human-readable report
+func (r *Report) WriteText(w io.Writer) {
+	counts := make(map[Status]int)
+	for _, result := range r.Results {
+		counts[result.Status]++
+		fmt.Fprintf(w, "[%-4s] %-28s %s\n", result.Status, result.Name, result.Message)
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		counts[StatusOK], counts[StatusWarn], counts[StatusFail], counts[StatusSkip])
+}
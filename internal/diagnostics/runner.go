@@ -0,0 +1,62 @@
+package diagnostics
+
+import (
+	"context"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const checkTimeout = 15 * time.Second
+
+// CheckFunc performs a single preflight check
+type CheckFunc func(ctx context.Context, cfg *config.AppConfig) (Status, string)
+
+type Check struct {
+	Name string
+	Run  CheckFunc
+}
+
+// DefaultChecks verifies everything the server needs at runtime
+func DefaultChecks() []Check {
+	return []Check{
+		{Name: "config", Run: checkConfig},
+		{Name: "database", Run: checkDatabase},
+		{Name: "migrations", Run: checkMigrations},
+		{Name: "docker", Run: checkDocker},
+		{Name: "kubernetes", Run: checkKubernetes},
+		{Name: "registry", Run: checkRegistry},
+		{Name: "paths", Run: checkWritablePaths},
+	}
+}
+
+type Runner struct {
+	config *config.AppConfig
+	checks []Check
+}
+
+func NewRunner(cfg *config.AppConfig, checks []Check) *Runner {
+	return &Runner{
+		config: cfg,
+		checks: checks,
+	}
+}
+
+// Run executes every check in order, each bounded by its own timeout
+func (r *Runner) Run(ctx context.Context) *Report {
+	report := &Report{}
+	for _, check := range r.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		start := time.Now()
+		status, message := check.Run(checkCtx, r.config)
+		cancel()
+
+		report.Results = append(report.Results, Result{
+			Name:     check.Name,
+			Status:   status,
+			Message:  message,
+			Duration: time.Since(start),
+		})
+	}
+	return report
+}
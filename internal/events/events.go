@@ -0,0 +1,129 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const defaultQueueSize = 256
+
+// Auth events, build and deploy events use the types.LifecycleEvent names
+const (
+	AuthRegistered  = "auth.registered"
+	AuthLogin       = "auth.login"
+	AuthLoginFailed = "auth.login_failed"
+
+	// Impersonation events name the admin as actor and the impersonated
+	// user as subject
+	AuthImpersonationStarted = "auth.impersonation_started"
+	AuthImpersonationRevoked = "auth.impersonation_revoked"
+	AuthImpersonatedRequest  = "auth.impersonated_request"
+)
+
+// Secrets rotation events name the admin as actor
+const (
+	SecretsSigningKeyRotated = "secrets.signing_key_rotated"
+	SecretsReencrypted       = "secrets.reencrypted"
+)
+
+var ErrClosed = errors.New("event bus is closed")
+
+// Event is a build, deploy, auth or secrets event. Build is a snapshot set
+// for build and deploy events.
+type Event struct {
+	Type    string       `json:"type"`
+	Time    time.Time    `json:"time"`
+	Source  string       `json:"source"`            // Instance that published the event
+	Actor   string       `json:"actor,omitempty"`   // User behind auth and secrets events
+	Subject string       `json:"subject,omitempty"` // User acted on, when not the actor
+	Build   *types.Build `json:"build,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// Handler consumes events. Events reach a handler one at a time.
+type Handler func(Event)
+
+// Publisher is the side of the bus producers need
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Bus carries events from producers to named consumers. Publish never
+// waits for consumers: each has a queue, and events are dropped with a
+// warning when it is full.
+type Bus interface {
+	Publisher
+	// Subscribe delivers events to handler. Consumers with the same name
+	// on different replicas share the events, each handled by one of them.
+	Subscribe(name string, handler Handler) error
+	// SubscribeLocal delivers only the events this instance publishes, for
+	// per-instance state such as metrics
+	SubscribeLocal(name string, handler Handler) error
+	// Close stops accepting events and waits for consumers to drain
+	Close(ctx context.Context) error
+}
+
+// New returns the bus selected by cfg
+func New(cfg *config.EventsConfig, log *zap.Logger) (Bus, error) {
+	switch cfg.Driver {
+	case "", "memory":
+		return NewMemory(cfg.QueueSize, log), nil
+	case "nats":
+		return NewNATS(cfg, log)
+	}
+	return nil, fmt.Errorf("unsupported event bus driver %q", cfg.Driver)
+}
+
+// InstanceID tells replicas apart, e.g. in events and deploy locks
+func InstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Notifier publishes pipeline lifecycle events on the bus
+type Notifier struct {
+	bus Publisher
+	log *zap.Logger
+}
+
+func NewNotifier(bus Publisher, log *zap.Logger) *Notifier {
+	return &Notifier{bus: bus, log: log}
+}
+
+func (n *Notifier) Notify(event types.LifecycleEvent, build *types.Build, message string) {
+	err := n.bus.Publish(context.Background(), Event{
+		Type:    string(event),
+		Build:   build,
+		Message: message,
+	})
+	if err != nil {
+		n.log.Warn("failed to publish lifecycle event",
+			zap.String("event", string(event)),
+			zap.String("build_id", build.ID),
+			zap.Error(err))
+	}
+}
+
+// LifecycleHandler adapts a pipeline notifier, such as webhook delivery,
+// into a consumer of build and deploy events
+func LifecycleHandler(notifier interface {
+	Notify(types.LifecycleEvent, *types.Build, string)
+}) Handler {
+	return func(event Event) {
+		if event.Build == nil {
+			return
+		}
+		notifier.Notify(types.LifecycleEvent(event.Type), event.Build, event.Message)
+	}
+}
@@ -0,0 +1,264 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// recorder collects the events a handler receives
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) handle(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, event := range r.events {
+		names = append(names, event.Type)
+	}
+	return names
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+func TestMemory_FansOut(t *testing.T) {
+	bus := NewMemory(0, zap.NewNop())
+	var webhooks, audit recorder
+	require.NoError(t, bus.Subscribe("webhooks", webhooks.handle))
+	require.NoError(t, bus.Subscribe("audit", audit.handle))
+
+	ctx := context.Background()
+	require.NoError(t, bus.Publish(ctx, Event{Type: "build.started"}))
+	require.NoError(t, bus.Publish(ctx, Event{Type: AuthLogin, Actor: "alice"}))
+	require.NoError(t, bus.Close(ctx))
+
+	assert.Equal(t, []string{"build.started", AuthLogin}, webhooks.types())
+	assert.Equal(t, []string{"build.started", AuthLogin}, audit.types())
+	assert.False(t, webhooks.events[0].Time.IsZero())
+	assert.NotEmpty(t, webhooks.events[0].Source)
+
+	assert.ErrorIs(t, bus.Publish(ctx, Event{Type: "build.started"}), ErrClosed)
+}
+
+func TestMemory_SlowConsumerDoesNotBlock(t *testing.T) {
+	bus := NewMemory(1, zap.NewNop())
+	release := make(chan struct{})
+	require.NoError(t, bus.Subscribe("slow", func(Event) { <-release }))
+	var fast recorder
+	require.NoError(t, bus.Subscribe("fast", fast.handle))
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, bus.Publish(ctx, Event{Type: "build.started"}))
+	}
+	// Publishing did not wait for the stuck consumer, whose extra events
+	// were dropped
+	close(release)
+	require.NoError(t, bus.Close(ctx))
+	assert.Positive(t, fast.count())
+}
+
+func TestNotifier_PublishesLifecycleEvents(t *testing.T) {
+	bus := NewMemory(0, zap.NewNop())
+	var got []types.LifecycleEvent
+	var mu sync.Mutex
+	require.NoError(t, bus.Subscribe("webhooks", LifecycleHandler(notifyFunc(func(event types.LifecycleEvent, build *types.Build, _ string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, event)
+		assert.Equal(t, "build-1", build.ID)
+	}))))
+
+	notifier := NewNotifier(bus, zap.NewNop())
+	notifier.Notify(types.LifecycleBuildStarted, &types.Build{ID: "build-1"}, "")
+	require.NoError(t, bus.Publish(context.Background(), Event{Type: AuthLogin}))
+	require.NoError(t, bus.Close(context.Background()))
+
+	assert.Equal(t, []types.LifecycleEvent{types.LifecycleBuildStarted}, got, "auth events carry no build")
+}
+
+type notifyFunc func(types.LifecycleEvent, *types.Build, string)
+
+func (f notifyFunc) Notify(event types.LifecycleEvent, build *types.Build, message string) {
+	f(event, build, message)
+}
+
+// fakeNATS routes messages like a NATS server: every plain subscription
+// and one member of each queue group receives a message
+type fakeNATS struct {
+	listener net.Listener
+	mu       sync.Mutex
+	subs     []*fakeSub
+}
+
+type fakeSub struct {
+	conn    net.Conn
+	prefix  string
+	group   string
+	sid     string
+	writeMu *sync.Mutex
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeNATS{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeNATS) url() string {
+	return "nats://" + f.listener.Addr().String()
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	var writeMu sync.Mutex
+	send := func(s string) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		io.WriteString(conn, s)
+	}
+
+	send("INFO {\"server_id\":\"fake\"}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			send("PONG\r\n")
+		case "SUB":
+			sub := &fakeSub{conn: conn, prefix: strings.TrimSuffix(fields[1], ">"), sid: fields[len(fields)-1], writeMu: &writeMu}
+			if len(fields) == 4 {
+				sub.group = fields[2]
+			}
+			f.mu.Lock()
+			f.subs = append(f.subs, sub)
+			f.mu.Unlock()
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			f.route(fields[1], payload[:size])
+		}
+	}
+}
+
+func (f *fakeNATS) route(subject string, payload []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	groups := make(map[string]bool)
+	for _, sub := range f.subs {
+		if !strings.HasPrefix(subject, sub.prefix) {
+			continue
+		}
+		if sub.group != "" {
+			if groups[sub.group] {
+				continue
+			}
+			groups[sub.group] = true
+		}
+		sub.writeMu.Lock()
+		fmt.Fprintf(sub.conn, "MSG %s %s %d\r\n%s\r\n", subject, sub.sid, len(payload), payload)
+		sub.writeMu.Unlock()
+	}
+}
+
+func (f *fakeNATS) subscriptions() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs)
+}
+
+func TestNATS_ConsumersShareEventsAcrossReplicas(t *testing.T) {
+	server := newFakeNATS(t)
+	cfg := &config.EventsConfig{Driver: "nats", NATSURL: server.url()}
+
+	var webhooksA, webhooksB, metricsA, metricsB recorder
+	a, err := New(cfg, zap.NewNop())
+	require.NoError(t, err)
+	b, err := New(cfg, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, a.Subscribe("webhooks", webhooksA.handle))
+	require.NoError(t, b.Subscribe("webhooks", webhooksB.handle))
+	require.NoError(t, a.SubscribeLocal("metrics", metricsA.handle))
+	require.NoError(t, b.SubscribeLocal("metrics", metricsB.handle))
+	require.Eventually(t, func() bool { return server.subscriptions() == 2 }, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	require.NoError(t, a.Publish(ctx, Event{Type: "build.started", Build: &types.Build{ID: "build-1"}}))
+	require.NoError(t, b.Publish(ctx, Event{Type: "build.succeeded", Build: &types.Build{ID: "build-2"}}))
+
+	require.Eventually(t, func() bool { return webhooksA.count()+webhooksB.count() == 2 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, a.Close(ctx))
+	require.NoError(t, b.Close(ctx))
+
+	assert.ElementsMatch(t, []string{"build.started", "build.succeeded"}, append(webhooksA.types(), webhooksB.types()...),
+		"each event reaches one webhook consumer")
+	assert.Equal(t, []string{"build.started"}, metricsA.types())
+	assert.Equal(t, []string{"build.succeeded"}, metricsB.types())
+}
+
+func TestNATS_HandlesEventsLocallyWhileUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	bus, err := NewNATS(&config.EventsConfig{NATSURL: "nats://" + addr, Timeout: 100 * time.Millisecond}, zap.NewNop())
+	require.NoError(t, err)
+	var audit recorder
+	require.NoError(t, bus.Subscribe("audit", audit.handle))
+
+	ctx := context.Background()
+	require.NoError(t, bus.Publish(ctx, Event{Type: AuthLogin, Actor: "alice"}))
+	require.NoError(t, bus.Close(ctx))
+	assert.Equal(t, []string{AuthLogin}, audit.types())
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// consumer runs a handler over its own queue so a slow consumer, such as
+// an unreachable webhook, does not hold up the others
+type consumer struct {
+	name    string
+	handler Handler
+	queue   chan Event
+	done    chan struct{}
+}
+
+func newConsumer(name string, handler Handler, queueSize int) *consumer {
+	c := &consumer{
+		name:    name,
+		handler: handler,
+		queue:   make(chan Event, queueSize),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		for event := range c.queue {
+			c.handler(event)
+		}
+	}()
+	return c
+}
+
+// consumers is a set of queues events are fanned out to
+type consumers struct {
+	queueSize int
+	log       *zap.Logger
+
+	mu     sync.RWMutex
+	all    []*consumer
+	closed bool
+}
+
+func (s *consumers) add(name string, handler Handler) (*consumer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	c := newConsumer(name, handler, s.queueSize)
+	s.all = append(s.all, c)
+	return c, nil
+}
+
+func (s *consumers) deliver(event Event) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	for _, c := range s.all {
+		s.enqueue(c, event)
+	}
+	return nil
+}
+
+// deliverTo queues event for one consumer, ignoring it once closed
+func (s *consumers) deliverTo(c *consumer, event Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.closed {
+		s.enqueue(c, event)
+	}
+}
+
+func (s *consumers) enqueue(c *consumer, event Event) {
+	select {
+	case c.queue <- event:
+	default:
+		s.log.Warn("event queue full, dropping event",
+			zap.String("consumer", c.name),
+			zap.String("event", event.Type))
+	}
+}
+
+func (s *consumers) close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		for _, c := range s.all {
+			close(c.queue)
+		}
+	}
+	all := s.all
+	s.mu.Unlock()
+
+	for _, c := range all {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Memory is a Bus within one process. Every consumer sees every event.
+type Memory struct {
+	source    string
+	consumers consumers
+}
+
+func NewMemory(queueSize int, log *zap.Logger) *Memory {
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	return &Memory{
+		source:    InstanceID(),
+		consumers: consumers{queueSize: queueSize, log: log},
+	}
+}
+
+func (m *Memory) Publish(_ context.Context, event Event) error {
+	stamp(&event, m.source)
+	return m.consumers.deliver(event)
+}
+
+func (m *Memory) Subscribe(name string, handler Handler) error {
+	_, err := m.consumers.add(name, handler)
+	return err
+}
+
+func (m *Memory) SubscribeLocal(name string, handler Handler) error {
+	return m.Subscribe(name, handler)
+}
+
+func (m *Memory) Close(ctx context.Context) error {
+	return m.consumers.close(ctx)
+}
+
+func stamp(event *Event, source string) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Source == "" {
+		event.Source = source
+	}
+}
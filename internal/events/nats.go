@@ -0,0 +1,357 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const (
+	defaultSubjectPrefix = "chef.events"
+	defaultNATSTimeout   = 5 * time.Second
+	maxReconnectDelay    = 30 * time.Second
+)
+
+var errNotConnected = errors.New("nats: not connected")
+
+// NATS is a Bus shared by the replicas through a NATS server. Consumers
+// subscribe with their name as queue group, so each event is handled by
+// one replica. While the server is unreachable, events are handled by
+// the replica that published them.
+//
+// It speaks the core NATS text protocol itself, which is all the bus needs.
+type NATS struct {
+	addr     string
+	user     string
+	password string
+	token    string
+	prefix   string
+	timeout  time.Duration
+	source   string
+	log      *zap.Logger
+
+	local  consumers // SubscribeLocal
+	shared consumers // Subscribe
+
+	mu      sync.Mutex
+	conn    net.Conn
+	w       *bufio.Writer
+	subs    map[int]*consumer
+	nextSID int
+	closed  bool
+}
+
+func NewNATS(cfg *config.EventsConfig, log *zap.Logger) (*NATS, error) {
+	u, err := url.Parse(cfg.NATSURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q", cfg.NATSURL)
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	prefix := cfg.SubjectPrefix
+	if prefix == "" {
+		prefix = defaultSubjectPrefix
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultNATSTimeout
+	}
+
+	n := &NATS{
+		addr:    u.Host,
+		prefix:  prefix,
+		timeout: timeout,
+		source:  InstanceID(),
+		log:     log,
+		local:   consumers{queueSize: queueSize, log: log},
+		shared:  consumers{queueSize: queueSize, log: log},
+		subs:    make(map[int]*consumer),
+	}
+	if u.Port() == "" {
+		n.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			n.user, n.password = u.User.Username(), password
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+
+	if err := n.connect(); err != nil {
+		log.Warn("nats unavailable, handling events locally until it is reachable",
+			zap.String("addr", n.addr),
+			zap.Error(err))
+		go n.reconnect()
+	}
+	return n, nil
+}
+
+func (n *NATS) Publish(_ context.Context, event Event) error {
+	stamp(&event, n.source)
+	if err := n.local.deliver(event); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	if err := n.publish(n.prefix+"."+event.Type, payload); err != nil {
+		n.log.Warn("nats unavailable, handling event locally",
+			zap.String("event", event.Type),
+			zap.Error(err))
+		return n.shared.deliver(event)
+	}
+	return nil
+}
+
+func (n *NATS) Subscribe(name string, handler Handler) error {
+	c, err := n.shared.add(name, handler)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nextSID++
+	sid := n.nextSID
+	n.subs[sid] = c
+	if n.conn != nil {
+		// A failed write is retried by the resubscription on reconnect
+		_ = n.write(n.subCommand(sid, c.name))
+	}
+	return nil
+}
+
+func (n *NATS) SubscribeLocal(name string, handler Handler) error {
+	_, err := n.local.add(name, handler)
+	return err
+}
+
+func (n *NATS) Close(ctx context.Context) error {
+	n.mu.Lock()
+	n.closed = true
+	if n.conn != nil {
+		n.w.Flush()
+		n.conn.Close()
+		n.conn = nil
+	}
+	n.mu.Unlock()
+
+	return errors.Join(n.local.close(ctx), n.shared.close(ctx))
+}
+
+func (n *NATS) subCommand(sid int, group string) string {
+	return fmt.Sprintf("SUB %s.> %s %d\r\n", n.prefix, group, sid)
+}
+
+func (n *NATS) publish(subject string, payload []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		return errNotConnected
+	}
+	return n.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(payload), payload))
+}
+
+// write sends a command, dropping the connection when it fails so the
+// read loop reconnects. n.mu must be held.
+func (n *NATS) write(cmd string) error {
+	n.conn.SetWriteDeadline(time.Now().Add(n.timeout))
+	if _, err := n.w.WriteString(cmd); err != nil {
+		n.conn.Close()
+		return err
+	}
+	if err := n.w.Flush(); err != nil {
+		n.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// connect performs the handshake, restores the subscriptions and starts
+// reading deliveries
+func (n *NATS) connect() error {
+	conn, err := net.DialTimeout("tcp", n.addr, n.timeout)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(n.timeout))
+
+	line, err := readLine(r)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", line)
+	}
+
+	options, _ := json.Marshal(map[string]interface{}{
+		"verbose":    false,
+		"pedantic":   false,
+		"name":       "chef-infra",
+		"lang":       "go",
+		"protocol":   1,
+		"user":       n.user,
+		"pass":       n.password,
+		"auth_token": n.token,
+	})
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", options); err != nil {
+		conn.Close()
+		return err
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		conn.Close()
+		return ErrClosed
+	}
+	n.conn = conn
+	n.w = bufio.NewWriter(conn)
+	for sid, c := range n.subs {
+		if err := n.write(n.subCommand(sid, c.name)); err != nil {
+			n.conn = nil
+			return err
+		}
+	}
+	go n.readLoop(conn, r)
+	return nil
+}
+
+func (n *NATS) readLoop(conn net.Conn, r *bufio.Reader) {
+	err := n.read(conn, r)
+
+	n.mu.Lock()
+	closed := n.closed
+	if n.conn == conn {
+		n.conn = nil
+	}
+	n.mu.Unlock()
+	conn.Close()
+
+	if closed {
+		return
+	}
+	n.log.Warn("lost connection to nats, reconnecting", zap.Error(err))
+	n.reconnect()
+}
+
+func (n *NATS) read(conn net.Conn, r *bufio.Reader) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return fmt.Errorf("nats: malformed %q", line)
+			}
+			sid, _ := strconv.Atoi(fields[2])
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return fmt.Errorf("nats: malformed %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			n.dispatch(sid, payload[:size])
+		case line == "PING":
+			n.mu.Lock()
+			if n.conn == conn {
+				n.write("PONG\r\n")
+			}
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			n.log.Warn("nats error", zap.String("error", strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))))
+		}
+	}
+}
+
+func (n *NATS) dispatch(sid int, payload []byte) {
+	n.mu.Lock()
+	c := n.subs[sid]
+	n.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		n.log.Warn("dropping malformed event", zap.Error(err))
+		return
+	}
+	n.shared.deliverTo(c, event)
+}
+
+func (n *NATS) reconnect() {
+	delay := time.Second
+	for {
+		time.Sleep(delay)
+
+		n.mu.Lock()
+		closed := n.closed
+		n.mu.Unlock()
+		if closed {
+			return
+		}
+
+		err := n.connect()
+		if err == nil {
+			n.log.Info("connected to nats", zap.String("addr", n.addr))
+			return
+		}
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		n.log.Debug("nats reconnect failed", zap.Error(err))
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
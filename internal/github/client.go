@@ -0,0 +1,108 @@
+// Package github reports build results back to GitHub as commit statuses.
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnauthorized is returned when GitHub rejects the credentials
+var ErrUnauthorized = errors.New("github rejected the credentials")
+
+const (
+	DefaultAPIURL = "https://api.github.com"
+
+	maxErrorBody = 1024
+)
+
+// State is a commit status state
+type State string
+
+const (
+	StatePending State = "pending"
+	StateSuccess State = "success"
+	StateFailure State = "failure"
+	StateError   State = "error"
+)
+
+// Status is the body of a commit status
+type Status struct {
+	State       State  `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context"`
+}
+
+// Client is a minimal GitHub REST client
+type Client struct {
+	apiURL string
+	http   *http.Client
+}
+
+func NewClient(apiURL string) *Client {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	return &Client{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		http:   &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// CreateStatus sets a commit status on sha in repository (owner/name)
+func (c *Client) CreateStatus(ctx context.Context, token, repository, sha string, status Status) error {
+	path := fmt.Sprintf("/repos/%s/statuses/%s", repository, sha)
+	_, err := c.do(ctx, http.MethodPost, path, "token "+token, status, nil)
+	return err
+}
+
+// do sends a JSON request, decodes the JSON response into out and returns
+// the response headers
+func (c *Client) do(ctx context.Context, method, path, authorization string, body, out interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	req.Header.Set("Authorization", authorization)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("github request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, fmt.Errorf("github %s %s returned %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.Header, nil
+}
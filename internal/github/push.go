@@ -0,0 +1,133 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/trigger"
+)
+
+const (
+	// Push payloads list at most 20 commits
+	maxPushCommits = 20
+	// The compare API lists at most 300 files
+	maxCompareFiles = 300
+)
+
+// PushEvent is the subset of the push webhook payload chef uses
+type PushEvent struct {
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	Commits []struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+	HeadCommit *struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name     string `json:"name"`
+			Email    string `json:"email"`
+			Username string `json:"username"`
+		} `json:"author"`
+	} `json:"head_commit"`
+}
+
+func ParsePushEvent(payload []byte) (*PushEvent, error) {
+	var event PushEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %w", err)
+	}
+	if event.Ref == "" || event.After == "" {
+		return nil, fmt.Errorf("invalid push payload: missing ref or after")
+	}
+	return &event, nil
+}
+
+// Push converts the event for filter evaluation. The changed paths are
+// incomplete when GitHub truncated the commit list.
+func (e *PushEvent) Push() trigger.Push {
+	seen := make(map[string]bool)
+	var changed []string
+	for _, commit := range e.Commits {
+		for _, files := range [][]string{commit.Added, commit.Removed, commit.Modified} {
+			for _, file := range files {
+				if !seen[file] {
+					seen[file] = true
+					changed = append(changed, file)
+				}
+			}
+		}
+	}
+	return trigger.Push{
+		Ref:           e.Ref,
+		Before:        e.Before,
+		After:         e.After,
+		Deleted:       e.Deleted,
+		ChangedPaths:  changed,
+		PathsComplete: len(e.Commits) < maxPushCommits,
+	}
+}
+
+// Commit describes the pushed head commit for the build record
+func (e *PushEvent) Commit() *types.CommitInfo {
+	info := &types.CommitInfo{}
+	switch {
+	case strings.HasPrefix(e.Ref, "refs/heads/"):
+		info.Branch = strings.TrimPrefix(e.Ref, "refs/heads/")
+	case strings.HasPrefix(e.Ref, "refs/tags/"):
+		info.Tag = strings.TrimPrefix(e.Ref, "refs/tags/")
+	}
+	if head := e.HeadCommit; head != nil {
+		info.Author = head.Author.Name
+		if info.Author == "" {
+			info.Author = head.Author.Username
+		}
+		info.AuthorEmail = head.Author.Email
+		info.Message = head.Message
+	}
+	return info
+}
+
+// CompareFiles lists the files changed between base and head in repository
+// (owner/name). It reports false when GitHub truncated the list.
+func (c *Client) CompareFiles(ctx context.Context, token, repository, base, head string) ([]string, bool, error) {
+	var comparison struct {
+		Files []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		} `json:"files"`
+	}
+	path := fmt.Sprintf("/repos/%s/compare/%s...%s?per_page=%d", repository, base, head, maxCompareFiles)
+	if _, err := c.do(ctx, http.MethodGet, path, "token "+token, nil, &comparison); err != nil {
+		return nil, false, err
+	}
+
+	var files []string
+	for _, file := range comparison.Files {
+		files = append(files, file.Filename)
+		// A rename touches both paths
+		if file.PreviousFilename != "" {
+			files = append(files, file.PreviousFilename)
+		}
+	}
+	return files, len(comparison.Files) < maxCompareFiles, nil
+}
+
+// DiffFunc returns a trigger.DiffFunc backed by the compare API
+func (c *Client) DiffFunc(token, repository string) trigger.DiffFunc {
+	return func(ctx context.Context, before, after string) ([]string, bool, error) {
+		return c.CompareFiles(ctx, token, repository, before, after)
+	}
+}
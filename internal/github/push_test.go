@@ -0,0 +1,91 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePushEvent(t *testing.T) {
+	payload := `{
+		"ref": "refs/heads/main",
+		"before": "1111111111111111111111111111111111111111",
+		"after": "2222222222222222222222222222222222222222",
+		"repository": {"full_name": "acme/shop"},
+		"commits": [
+			{"added": ["web/new.ts"], "modified": ["web/app.ts"], "removed": []},
+			{"added": [], "modified": ["web/app.ts"], "removed": ["api/old.go"]}
+		],
+		"head_commit": {
+			"id": "2222222222222222222222222222222222222222",
+			"message": "Fix checkout\n\nThe total was off by one.",
+			"author": {"name": "Jane Doe", "email": "jane@example.com", "username": "jane"}
+		}
+	}`
+
+	event, err := ParsePushEvent([]byte(payload))
+	require.NoError(t, err)
+	assert.Equal(t, "acme/shop", event.Repository.FullName)
+
+	push := event.Push()
+	assert.Equal(t, "main", push.Branch())
+	assert.Equal(t, []string{"web/new.ts", "web/app.ts", "api/old.go"}, push.ChangedPaths)
+	assert.True(t, push.PathsComplete)
+
+	commit := event.Commit()
+	assert.Equal(t, "Jane Doe", commit.Author)
+	assert.Equal(t, "jane@example.com", commit.AuthorEmail)
+	assert.Equal(t, "main", commit.Branch)
+	assert.Equal(t, "Fix checkout", commit.Subject())
+
+	_, err = ParsePushEvent([]byte(`{"zen": "ping"}`))
+	assert.Error(t, err)
+}
+
+func TestPushEvent_TruncatedCommits(t *testing.T) {
+	commits := make([]string, maxPushCommits)
+	for i := range commits {
+		commits[i] = fmt.Sprintf(`{"modified": ["file-%d"]}`, i)
+	}
+	payload := `{"ref": "refs/heads/main", "after": "abc", "commits": [` + strings.Join(commits, ",") + `]}`
+
+	event, err := ParsePushEvent([]byte(payload))
+	require.NoError(t, err)
+	assert.False(t, event.Push().PathsComplete)
+}
+
+func TestPushEvent_TagCommit(t *testing.T) {
+	event, err := ParsePushEvent([]byte(`{"ref": "refs/tags/v1.2.0", "after": "abc"}`))
+	require.NoError(t, err)
+
+	commit := event.Commit()
+	assert.Equal(t, "v1.2.0", commit.Tag)
+	assert.Empty(t, commit.Branch)
+	assert.Empty(t, commit.Author)
+}
+
+func TestClient_CompareFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/acme/shop/compare/aaa...bbb", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"files": []map[string]string{
+				{"filename": "web/app.ts"},
+				{"filename": "web/renamed.ts", "previous_filename": "api/original.ts"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	diff := NewClient(server.URL).DiffFunc("token", "acme/shop")
+	files, complete, err := diff(context.Background(), "aaa", "bbb")
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"web/app.ts", "web/renamed.ts", "api/original.ts"}, files)
+}
@@ -0,0 +1,109 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// maxCompareCommits is the most commits the compare API lists per page
+const maxCompareCommits = 250
+
+var errNotGitHub = errors.New("build was not pushed to github")
+
+// Commit is the subset of a compared commit chef uses
+type Commit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+// Release is the body of a release
+type Release struct {
+	TagName         string `json:"tag_name"`
+	TargetCommitish string `json:"target_commitish"`
+	Name            string `json:"name,omitempty"`
+	Body            string `json:"body,omitempty"`
+	HTMLURL         string `json:"html_url,omitempty"`
+}
+
+// CompareCommits lists up to 250 commits after base up to head in
+// repository (owner/name), oldest first, and the total number of commits
+func (c *Client) CompareCommits(ctx context.Context, token, repository, base, head string) ([]Commit, int, error) {
+	var comparison struct {
+		TotalCommits int      `json:"total_commits"`
+		Commits      []Commit `json:"commits"`
+	}
+	path := fmt.Sprintf("/repos/%s/compare/%s...%s?per_page=%d", repository, base, head, maxCompareCommits)
+	if _, err := c.do(ctx, http.MethodGet, path, "token "+token, nil, &comparison); err != nil {
+		return nil, 0, err
+	}
+	return comparison.Commits, comparison.TotalCommits, nil
+}
+
+// CreateRelease creates a release in repository (owner/name), tagging its
+// target commit when the tag does not exist yet
+func (c *Client) CreateRelease(ctx context.Context, token, repository string, release Release) (*Release, error) {
+	var created Release
+	path := fmt.Sprintf("/repos/%s/releases", repository)
+	if _, err := c.do(ctx, http.MethodPost, path, "token "+token, release, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Changelog lists the commits after base up to the build's commit, oldest
+// first, and their total. It implements pipeline.Releaser.
+func (r *Reporter) Changelog(ctx context.Context, build *types.Build, base string) ([]types.ChangelogEntry, int, error) {
+	if !r.config.Enabled || build.Source == nil || build.Source.Provider != ProviderName {
+		return nil, 0, errNotGitHub
+	}
+	token, err := r.token(ctx, build.ProjectID, build.Source.Repository)
+	if err != nil {
+		return nil, 0, err
+	}
+	commits, total, err := r.client.CompareCommits(ctx, token, build.Source.Repository, base, build.CommitHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]types.ChangelogEntry, len(commits))
+	for i, commit := range commits {
+		info := types.CommitInfo{Message: commit.Commit.Message}
+		entries[i] = types.ChangelogEntry{
+			Commit:  commit.SHA,
+			Author:  commit.Commit.Author.Name,
+			Subject: info.Subject(),
+		}
+	}
+	return entries, total, nil
+}
+
+// CreateRelease creates a release named tag of the build's commit and
+// returns its URL. It implements pipeline.Releaser.
+func (r *Reporter) CreateRelease(ctx context.Context, build *types.Build, tag, notes string) (string, error) {
+	if !r.config.Enabled || build.Source == nil || build.Source.Provider != ProviderName {
+		return "", errNotGitHub
+	}
+	token, err := r.token(ctx, build.ProjectID, build.Source.Repository)
+	if err != nil {
+		return "", err
+	}
+	release, err := r.client.CreateRelease(ctx, token, build.Source.Repository, Release{
+		TagName:         tag,
+		TargetCommitish: build.CommitHash,
+		Name:            tag,
+		Body:            notes,
+	})
+	if err != nil {
+		return "", err
+	}
+	return release.HTMLURL, nil
+}
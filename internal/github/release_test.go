@@ -0,0 +1,69 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/i18n"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestReporter_Changelog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/Acme/web/compare/0000aaaa...0123abcd", r.URL.Path)
+		assert.Equal(t, "token token", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"total_commits": 2, "commits": [
+			{"sha": "1111", "commit": {"message": "Add cart\n\nWith totals.", "author": {"name": "Jane Doe"}}},
+			{"sha": "0123abcd", "commit": {"message": "Fix checkout", "author": {"name": "John Roe"}}}
+		]}`))
+	}))
+	defer server.Close()
+
+	reporter, err := NewReporter(&config.GitHubConfig{Enabled: true, APIURL: server.URL, Token: "token"}, i18n.English, zap.NewNop())
+	require.NoError(t, err)
+
+	entries, total, err := reporter.Changelog(context.Background(), githubBuild(), "0000aaaa")
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []types.ChangelogEntry{
+		{Commit: "1111", Author: "Jane Doe", Subject: "Add cart"},
+		{Commit: "0123abcd", Author: "John Roe", Subject: "Fix checkout"},
+	}, entries)
+
+	manual := githubBuild()
+	manual.Source = nil
+	_, _, err = reporter.Changelog(context.Background(), manual, "0000aaaa")
+	assert.ErrorIs(t, err, errNotGitHub)
+}
+
+func TestReporter_CreateRelease(t *testing.T) {
+	var got Release
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/Acme/web/releases", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Release{HTMLURL: "https://github.com/Acme/web/releases/tag/" + got.TagName})
+	}))
+	defer server.Close()
+
+	reporter, err := NewReporter(&config.GitHubConfig{Enabled: true, APIURL: server.URL, Token: "token"}, i18n.English, zap.NewNop())
+	require.NoError(t, err)
+
+	url, err := reporter.CreateRelease(context.Background(), githubBuild(), "production-20261016-090000", "- 0123abc Fix checkout")
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/Acme/web/releases/tag/production-20261016-090000", url)
+	assert.Equal(t, Release{
+		TagName:         "production-20261016-090000",
+		TargetCommitish: "0123abcd",
+		Name:            "production-20261016-090000",
+		Body:            "- 0123abc Fix checkout",
+	}, got)
+}
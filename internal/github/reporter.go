@@ -0,0 +1,225 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/i18n"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	ProviderName = "github"
+
+	defaultContext = "chef-infra"
+	reportTimeout  = 30 * time.Second
+	queueSize      = 256
+)
+
+var errNoCredentials = errors.New("no github credentials configured for repository")
+
+// statusFor maps lifecycle events to commit statuses. Events that are not
+// listed leave the status unchanged.
+var statusFor = map[types.LifecycleEvent]struct {
+	state       State
+	description i18n.Key
+}{
+	types.LifecycleBuildStarted:     {StatePending, i18n.StatusBuildStarted},
+	types.LifecycleBuildSucceeded:   {StatePending, i18n.StatusBuildSucceeded},
+	types.LifecycleBuildFailed:      {StateFailure, i18n.StatusBuildFailed},
+	types.LifecycleBuildCancelled:   {StateError, i18n.StatusBuildCancelled},
+	types.LifecycleBuildSuperseded:  {StateError, i18n.StatusBuildSuperseded},
+	types.LifecycleBuildInterrupted: {StateError, i18n.StatusBuildInterrupted},
+	types.LifecycleDeploySucceeded:  {StateSuccess, i18n.StatusDeploySucceeded},
+	types.LifecycleDeployFailed:     {StateFailure, i18n.StatusDeployFailed},
+	types.LifecyclePreviewReady:     {StateSuccess, i18n.StatusPreviewReady},
+}
+
+type report struct {
+	token      func(ctx context.Context) (string, error)
+	repository string
+	sha        string
+	status     Status
+}
+
+// Reporter publishes commit statuses for builds triggered from GitHub. It
+// implements pipeline.Notifier.
+type Reporter struct {
+	config *config.GitHubConfig
+	client *Client
+	app    *appAuth
+	locale i18n.Locale // Language of status descriptions
+	log    *zap.Logger
+
+	queue   chan report
+	done    chan struct{}
+	mu      sync.Mutex
+	started bool
+	stopped bool
+}
+
+func NewReporter(cfg *config.GitHubConfig, locale i18n.Locale, log *zap.Logger) (*Reporter, error) {
+	r := &Reporter{
+		config: cfg,
+		client: NewClient(cfg.APIURL),
+		locale: locale,
+		log:    log,
+		queue:  make(chan report, queueSize),
+		done:   make(chan struct{}),
+	}
+	if cfg.Enabled && cfg.App.AppID != 0 {
+		app, err := newAppAuth(cfg.App.AppID, cfg.App.PrivateKeyPath, r.client)
+		if err != nil {
+			return nil, err
+		}
+		r.app = app
+	}
+	return r, nil
+}
+
+// Notify queues a status update for builds that carry a GitHub source
+func (r *Reporter) Notify(event types.LifecycleEvent, build *types.Build, message string) {
+	if !r.config.Enabled || build.Source == nil || build.Source.Provider != ProviderName || build.CommitHash == "" {
+		return
+	}
+	mapped, ok := statusFor[event]
+	if !ok {
+		return
+	}
+
+	description := i18n.Translate(r.locale, mapped.description)
+	if message != "" && mapped.state != StatePending {
+		description += ": " + message
+	}
+	// GitHub rejects descriptions longer than 140 characters
+	if len(description) > 140 {
+		description = description[:137] + "..."
+	}
+
+	projectID, repository := build.ProjectID, build.Source.Repository
+	rep := report{
+		token: func(ctx context.Context) (string, error) {
+			return r.token(ctx, projectID, repository)
+		},
+		repository: repository,
+		sha:        build.CommitHash,
+		status: Status{
+			State:       mapped.state,
+			TargetURL:   r.buildURL(build),
+			Description: description,
+			Context:     r.statusContext(),
+		},
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	select {
+	case r.queue <- rep:
+	default:
+		r.log.Warn("github status queue full, dropping update",
+			zap.String("repository", repository),
+			zap.String("sha", build.CommitHash))
+	}
+}
+
+// Start launches the worker. A single worker keeps updates for a commit
+// in order so a late pending status cannot overwrite the final result.
+func (r *Reporter) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return
+	}
+	r.started = true
+
+	go func() {
+		defer close(r.done)
+		for rep := range r.queue {
+			r.send(rep)
+		}
+	}()
+}
+
+// Stop flushes queued updates and waits for the worker or ctx
+func (r *Reporter) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	started := r.started
+	if !r.stopped {
+		r.stopped = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Reporter) send(rep report) {
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+
+	token, err := rep.token(ctx)
+	if err != nil {
+		r.log.Warn("skipping github status",
+			zap.String("repository", rep.repository),
+			zap.Error(err))
+		return
+	}
+	if err := r.client.CreateStatus(ctx, token, rep.repository, rep.sha, rep.status); err != nil {
+		r.log.Error("failed to report github status",
+			zap.String("repository", rep.repository),
+			zap.String("sha", rep.sha),
+			zap.String("state", string(rep.status.State)),
+			zap.Error(err))
+	}
+}
+
+// token resolves credentials for the repository. Config map keys are
+// lowercase because the config loader folds them.
+func (r *Reporter) token(ctx context.Context, projectID, repository string) (string, error) {
+	if token := r.config.ProjectTokens[strings.ToLower(projectID)]; token != "" {
+		return token, nil
+	}
+
+	owner, _, _ := strings.Cut(repository, "/")
+	owner = strings.ToLower(owner)
+	if token := r.config.OwnerTokens[owner]; token != "" {
+		return token, nil
+	}
+	if r.app != nil {
+		if installationID, ok := r.config.App.Installations[owner]; ok {
+			return r.app.installationToken(ctx, installationID)
+		}
+	}
+	if r.config.Token != "" {
+		return r.config.Token, nil
+	}
+	return "", errNoCredentials
+}
+
+func (r *Reporter) buildURL(build *types.Build) string {
+	return strings.NewReplacer("{project}", build.ProjectID, "{build}", build.ID).Replace(r.config.BuildURL)
+}
+
+func (r *Reporter) statusContext() string {
+	if r.config.StatusContext != "" {
+		return r.config.StatusContext
+	}
+	return defaultContext
+}
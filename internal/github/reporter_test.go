@@ -0,0 +1,191 @@
+package github
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/i18n"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+type statusRequest struct {
+	path          string
+	authorization string
+	status        Status
+}
+
+// fakeGitHub records status requests and issues installation tokens
+type fakeGitHub struct {
+	mu            sync.Mutex
+	statuses      []statusRequest
+	tokenRequests int
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/app/installations/42/access_tokens" {
+		f.tokenRequests++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(installationToken{
+			Token:     "installation-token",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		return
+	}
+
+	var status Status
+	json.NewDecoder(r.Body).Decode(&status)
+	f.statuses = append(f.statuses, statusRequest{
+		path:          r.URL.Path,
+		authorization: r.Header.Get("Authorization"),
+		status:        status,
+	})
+	w.WriteHeader(http.StatusCreated)
+}
+
+func githubBuild() *types.Build {
+	return &types.Build{
+		ID:         "build-7",
+		ProjectID:  "web",
+		CommitHash: "0123abcd",
+		Source:     &types.BuildSource{Provider: ProviderName, Repository: "Acme/web", PullRequest: 12},
+	}
+}
+
+func TestReporter_ReportsLifecycle(t *testing.T) {
+	api := &fakeGitHub{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	reporter, err := NewReporter(&config.GitHubConfig{
+		Enabled:     true,
+		APIURL:      server.URL,
+		BuildURL:    "https://chef.example.com/projects/{project}/builds/{build}",
+		OwnerTokens: map[string]string{"acme": "owner-token"},
+	}, i18n.English, zap.NewNop())
+	require.NoError(t, err)
+	reporter.Start()
+
+	build := githubBuild()
+	reporter.Notify(types.LifecycleBuildStarted, build, "")
+	reporter.Notify(types.LifecycleDeployRestarted, build, "")
+	reporter.Notify(types.LifecycleDeployFailed, build, "health check failed")
+	require.NoError(t, reporter.Stop(context.Background()))
+
+	require.Len(t, api.statuses, 2)
+	for _, req := range api.statuses {
+		assert.Equal(t, "/repos/Acme/web/statuses/0123abcd", req.path)
+		assert.Equal(t, "token owner-token", req.authorization)
+		assert.Equal(t, "chef-infra", req.status.Context)
+		assert.Equal(t, "https://chef.example.com/projects/web/builds/build-7", req.status.TargetURL)
+	}
+	assert.Equal(t, StatePending, api.statuses[0].status.State)
+	assert.Equal(t, StateFailure, api.statuses[1].status.State)
+	assert.Equal(t, "Deployment failed: health check failed", api.statuses[1].status.Description)
+}
+
+func TestReporter_Locale(t *testing.T) {
+	api := &fakeGitHub{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	reporter, err := NewReporter(&config.GitHubConfig{Enabled: true, APIURL: server.URL, Token: "token"}, i18n.Indonesian, zap.NewNop())
+	require.NoError(t, err)
+	reporter.Start()
+
+	reporter.Notify(types.LifecycleBuildFailed, githubBuild(), "npm exited with 1")
+	require.NoError(t, reporter.Stop(context.Background()))
+
+	require.Len(t, api.statuses, 1)
+	assert.Equal(t, "Build gagal: npm exited with 1", api.statuses[0].status.Description)
+}
+
+func TestReporter_SkipsOtherBuilds(t *testing.T) {
+	api := &fakeGitHub{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	reporter, err := NewReporter(&config.GitHubConfig{Enabled: true, APIURL: server.URL, Token: "token"}, i18n.English, zap.NewNop())
+	require.NoError(t, err)
+	reporter.Start()
+
+	manual := githubBuild()
+	manual.Source = nil
+	gitlab := githubBuild()
+	gitlab.Source.Provider = "gitlab"
+
+	reporter.Notify(types.LifecycleBuildStarted, manual, "")
+	reporter.Notify(types.LifecycleBuildStarted, gitlab, "")
+	require.NoError(t, reporter.Stop(context.Background()))
+
+	assert.Empty(t, api.statuses)
+}
+
+func TestReporter_Token(t *testing.T) {
+	api := &fakeGitHub{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "app.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600))
+
+	reporter, err := NewReporter(&config.GitHubConfig{
+		Enabled:       true,
+		APIURL:        server.URL,
+		Token:         "default-token",
+		OwnerTokens:   map[string]string{"acme": "owner-token"},
+		ProjectTokens: map[string]string{"docs": "project-token"},
+		App: config.GitHubAppConfig{
+			AppID:          1,
+			PrivateKeyPath: keyPath,
+			Installations:  map[string]int64{"initech": 42},
+		},
+	}, i18n.English, zap.NewNop())
+	require.NoError(t, err)
+
+	tests := []struct {
+		project    string
+		repository string
+		want       string
+	}{
+		{project: "docs", repository: "acme/docs", want: "project-token"},
+		{project: "web", repository: "ACME/web", want: "owner-token"},
+		{project: "tps", repository: "initech/tps", want: "installation-token"},
+		{project: "blog", repository: "someone/blog", want: "default-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repository, func(t *testing.T) {
+			token, err := reporter.token(context.Background(), tt.project, tt.repository)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, token)
+		})
+	}
+
+	// Installation tokens are cached until they near expiry
+	_, err = reporter.token(context.Background(), "tps", "initech/tps")
+	require.NoError(t, err)
+	assert.Equal(t, 1, api.tokenRequests)
+}
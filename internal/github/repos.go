@@ -0,0 +1,145 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Repository is the subset of the GitHub repository object chef uses
+type Repository struct {
+	FullName      string `json:"full_name"`
+	CloneURL      string `json:"clone_url"`
+	DefaultBranch string `json:"default_branch"`
+	Private       bool   `json:"private"`
+	Description   string `json:"description"`
+	Size          int64  `json:"size"` // Kilobytes
+}
+
+type Branch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+type User struct {
+	Login string `json:"login"`
+}
+
+// AuthenticatedUser returns the user the token belongs to
+func (c *Client) AuthenticatedUser(ctx context.Context, token string) (*User, error) {
+	var user User
+	if _, err := c.do(ctx, http.MethodGet, "/user", "token "+token, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListRepositories returns one page of repositories the token can access,
+// most recently pushed first. The returned page is 0 on the last page.
+func (c *Client) ListRepositories(ctx context.Context, token string, page, perPage int) ([]Repository, int, error) {
+	query := url.Values{}
+	query.Set("sort", "pushed")
+	query.Set("page", fmt.Sprint(page))
+	query.Set("per_page", fmt.Sprint(perPage))
+
+	var repos []Repository
+	header, err := c.do(ctx, http.MethodGet, "/user/repos?"+query.Encode(), "token "+token, nil, &repos)
+	if err != nil {
+		return nil, 0, err
+	}
+	next := 0
+	if strings.Contains(header.Get("Link"), `rel="next"`) {
+		next = page + 1
+	}
+	return repos, next, nil
+}
+
+// GetRepository returns repository (owner/name)
+func (c *Client) GetRepository(ctx context.Context, token, repository string) (*Repository, error) {
+	if strings.Count(repository, "/") != 1 {
+		return nil, fmt.Errorf("invalid repository %q, expected owner/name", repository)
+	}
+
+	var repo Repository
+	if _, err := c.do(ctx, http.MethodGet, "/repos/"+repository, "token "+token, nil, &repo); err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+// ListBranches returns up to 100 branches of repository (owner/name)
+func (c *Client) ListBranches(ctx context.Context, token, repository string) ([]Branch, error) {
+	if strings.Count(repository, "/") != 1 {
+		return nil, fmt.Errorf("invalid repository %q, expected owner/name", repository)
+	}
+
+	var branches []Branch
+	path := fmt.Sprintf("/repos/%s/branches?per_page=100", repository)
+	if _, err := c.do(ctx, http.MethodGet, path, "token "+token, nil, &branches); err != nil {
+		return nil, err
+	}
+	return branches, nil
+}
+
+// Hook configures a repository webhook
+type Hook struct {
+	Name   string     `json:"name"`
+	Active bool       `json:"active"`
+	Events []string   `json:"events"`
+	Config HookConfig `json:"config"`
+}
+
+type HookConfig struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Secret      string `json:"secret,omitempty"`
+	InsecureSSL string `json:"insecure_ssl"`
+}
+
+// CreateHook registers a push and pull request webhook on repository
+// (owner/name). It needs admin access to the repository.
+func (c *Client) CreateHook(ctx context.Context, token, repository, hookURL, secret string) error {
+	if strings.Count(repository, "/") != 1 {
+		return fmt.Errorf("invalid repository %q, expected owner/name", repository)
+	}
+
+	hook := Hook{
+		Name:   "web",
+		Active: true,
+		Events: []string{"push", "pull_request"},
+		Config: HookConfig{
+			URL:         hookURL,
+			ContentType: "json",
+			Secret:      secret,
+			InsecureSSL: "0",
+		},
+	}
+	_, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/hooks", repository), "token "+token, hook, nil)
+	return err
+}
+
+// ParseRepositoryURL extracts owner/name from a github.com clone URL
+func ParseRepositoryURL(raw string) (string, bool) {
+	var path string
+	switch {
+	case strings.HasPrefix(raw, "git@github.com:"):
+		path = strings.TrimPrefix(raw, "git@github.com:")
+	default:
+		parsed, err := url.Parse(raw)
+		if err != nil || !strings.EqualFold(parsed.Host, "github.com") {
+			return "", false
+		}
+		path = strings.TrimPrefix(parsed.Path, "/")
+	}
+
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".git")
+	owner, name, ok := strings.Cut(path, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return owner + "/" + name, true
+}
@@ -0,0 +1,75 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRepositoryURL(t *testing.T) {
+	tests := []struct {
+		url        string
+		repository string
+		ok         bool
+	}{
+		{"https://github.com/elskow/chef-infra", "elskow/chef-infra", true},
+		{"https://github.com/elskow/chef-infra.git", "elskow/chef-infra", true},
+		{"https://github.com/elskow/chef-infra/", "elskow/chef-infra", true},
+		{"git@github.com:elskow/chef-infra.git", "elskow/chef-infra", true},
+		{"ssh://git@github.com/elskow/chef-infra.git", "elskow/chef-infra", true},
+		{"https://gitlab.com/elskow/chef-infra.git", "", false},
+		{"https://github.com/elskow", "", false},
+		{"https://github.com/elskow/chef-infra/tree/main", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			repository, ok := ParseRepositoryURL(tt.url)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.repository, repository)
+		})
+	}
+}
+
+func TestClient_CreateHook(t *testing.T) {
+	var received Hook
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/repos/elskow/chef-infra/hooks", r.URL.Path)
+		assert.Equal(t, "token secret-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	err := client.CreateHook(context.Background(), "secret-token", "elskow/chef-infra",
+		"https://chef.example.com/hooks/github", "hook-secret")
+	require.NoError(t, err)
+
+	assert.Equal(t, "web", received.Name)
+	assert.ElementsMatch(t, []string{"push", "pull_request"}, received.Events)
+	assert.Equal(t, "https://chef.example.com/hooks/github", received.Config.URL)
+	assert.Equal(t, "hook-secret", received.Config.Secret)
+}
+
+func TestClient_GetRepository(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/elskow/chef-infra", r.URL.Path)
+		assert.Equal(t, "token secret-token", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"full_name": "elskow/chef-infra", "size": 2048}`))
+	}))
+	defer server.Close()
+
+	repo, err := NewClient(server.URL).GetRepository(context.Background(), "secret-token", "elskow/chef-infra")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2048), repo.Size)
+
+	_, err = NewClient(server.URL).GetRepository(context.Background(), "secret-token", "chef-infra")
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+// Package httpsec applies CORS, security headers and request size limits to
+// the HTTP handlers served alongside the gRPC API.
+package httpsec
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+var (
+	defaultAllowedMethods = []string{http.MethodGet, http.MethodPost}
+	defaultAllowedHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// Middleware wraps an HTTP handler
+type Middleware func(http.Handler) http.Handler
+
+// New builds the middleware described by cfg
+func New(cfg *config.HTTPConfig) Middleware {
+	p := newPolicy(cfg)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.setSecurityHeaders(w)
+
+			if origin := r.Header.Get("Origin"); origin != "" {
+				allowed := p.allowOrigin(w, origin)
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					p.preflight(w, r, allowed)
+					return
+				}
+			}
+
+			if p.maxRequestBytes > 0 {
+				if r.ContentLength > p.maxRequestBytes {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, p.maxRequestBytes)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// policy is the precomputed form of the configuration
+type policy struct {
+	origins          map[string]bool
+	anyOrigin        bool
+	methods          map[string]bool
+	allowMethods     string
+	allowHeaders     string
+	allowCredentials bool
+	maxAge           string
+	hsts             string
+	csp              string
+	maxRequestBytes  int64
+}
+
+func newPolicy(cfg *config.HTTPConfig) *policy {
+	methods := cfg.CORS.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultAllowedMethods
+	}
+	headers := cfg.CORS.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultAllowedHeaders
+	}
+
+	p := &policy{
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		allowMethods:     strings.Join(methods, ", "),
+		allowHeaders:     strings.Join(headers, ", "),
+		allowCredentials: cfg.CORS.AllowCredentials,
+		csp:              cfg.ContentSecurityPolicy,
+		maxRequestBytes:  cfg.MaxRequestBytes,
+	}
+	for _, origin := range cfg.CORS.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		}
+		p.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	for _, method := range methods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	if cfg.CORS.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.CORS.MaxAge.Seconds()))
+	}
+	if cfg.HSTSMaxAge > 0 {
+		p.hsts = fmt.Sprintf("max-age=%d", int(cfg.HSTSMaxAge.Seconds()))
+		if cfg.HSTSIncludeSubdomains {
+			p.hsts += "; includeSubDomains"
+		}
+	}
+	return p
+}
+
+func (p *policy) setSecurityHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	if p.hsts != "" {
+		h.Set("Strict-Transport-Security", p.hsts)
+	}
+	if p.csp != "" {
+		h.Set("Content-Security-Policy", p.csp)
+	}
+}
+
+// allowOrigin sets the CORS response headers when origin is allowed
+func (p *policy) allowOrigin(w http.ResponseWriter, origin string) bool {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	if !p.anyOrigin && !p.origins[origin] {
+		return false
+	}
+
+	// Credentials cannot be combined with a wildcard origin, so the
+	// request origin is always echoed back
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.allowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+func (p *policy) preflight(w http.ResponseWriter, r *http.Request, allowed bool) {
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !allowed || !p.methods[method] {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", p.allowMethods)
+	h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,136 @@
+package httpsec
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+func TestMiddleware(t *testing.T) {
+	cfg := &config.HTTPConfig{
+		CORS: config.CORSConfig{
+			AllowedOrigins:   []string{"https://dashboard.example.com"},
+			AllowedMethods:   []string{"GET", "POST"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		},
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'self'",
+		MaxRequestBytes:       16,
+	}
+
+	handler := New(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		headers map[string]string
+		status  int
+		expect  map[string]string
+	}{
+		{
+			name:   "security headers on every response",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			expect: map[string]string{
+				"X-Content-Type-Options":      "nosniff",
+				"X-Frame-Options":             "DENY",
+				"Strict-Transport-Security":   "max-age=31536000; includeSubDomains",
+				"Content-Security-Policy":     "default-src 'self'",
+				"Access-Control-Allow-Origin": "",
+			},
+		},
+		{
+			name:    "allowed origin",
+			method:  http.MethodGet,
+			headers: map[string]string{"Origin": "https://dashboard.example.com"},
+			status:  http.StatusOK,
+			expect: map[string]string{
+				"Access-Control-Allow-Origin":      "https://dashboard.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:    "unknown origin",
+			method:  http.MethodGet,
+			headers: map[string]string{"Origin": "https://evil.example.com"},
+			status:  http.StatusOK,
+			expect:  map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "preflight",
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://dashboard.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			status: http.StatusNoContent,
+			expect: map[string]string{
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Authorization, Content-Type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:   "preflight for disallowed method",
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://dashboard.example.com",
+				"Access-Control-Request-Method": "DELETE",
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "declared body too large",
+			method: http.MethodPost,
+			body:   strings.Repeat("x", 32),
+			status: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/metrics", strings.NewReader(tt.body))
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			for key, value := range tt.expect {
+				assert.Equal(t, value, rec.Header().Get(key), key)
+			}
+		})
+	}
+}
+
+func TestMiddleware_StreamedBodyLimit(t *testing.T) {
+	handler := New(&config.HTTPConfig{MaxRequestBytes: 16})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	// Without a Content-Length the limit is enforced while reading
+	req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(strings.Repeat("x", 32))))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -0,0 +1,146 @@
+package i18n
+
+// Key identifies a user-facing message
+type Key string
+
+// Authentication and account messages
+const (
+	UsernameTaken   Key = "auth.username_taken"
+	EmailTaken      Key = "auth.email_taken"
+	UserExists      Key = "auth.user_exists"
+	UserNotFound    Key = "auth.user_not_found"
+	InvalidPassword Key = "auth.invalid_password"
+	TokenRequired   Key = "auth.token_required"
+	TokenInvalid    Key = "auth.token_invalid"
+	Registered      Key = "auth.registered"
+	LoggedIn        Key = "auth.logged_in"
+	TokenValid      Key = "auth.token_valid"
+	TokenRefreshed  Key = "auth.token_refreshed"
+	AuthRequired    Key = "auth.required"
+	AdminRequired   Key = "auth.admin_required"
+	RateLimited     Key = "auth.rate_limited"
+
+	ImpersonationDisabled Key = "auth.impersonation_disabled"
+	ImpersonateSelf       Key = "auth.impersonate_self"
+	ImpersonationNested   Key = "auth.impersonation_nested"
+)
+
+// Request validation messages. Each takes the field's display name first.
+const (
+	FieldRequired  Key = "validate.required"
+	FieldLength    Key = "validate.length"
+	FieldMinLength Key = "validate.min_length"
+	FieldMaxLength Key = "validate.max_length"
+	FieldPattern   Key = "validate.pattern"
+	FieldEmail     Key = "validate.email"
+)
+
+// Display names of request fields, keyed by "field." and the proto name
+const (
+	FieldUsername     Key = "field.username"
+	FieldPassword     Key = "field.password"
+	FieldEmailAddress Key = "field.email"
+	FieldRefreshToken Key = "field.refresh_token"
+)
+
+// Commit status descriptions reported for build and deploy events
+const (
+	StatusBuildStarted     Key = "status.build_started"
+	StatusBuildSucceeded   Key = "status.build_succeeded"
+	StatusBuildFailed      Key = "status.build_failed"
+	StatusBuildCancelled   Key = "status.build_cancelled"
+	StatusBuildSuperseded  Key = "status.build_superseded"
+	StatusBuildInterrupted Key = "status.build_interrupted"
+	StatusDeploySucceeded  Key = "status.deploy_succeeded"
+	StatusDeployFailed     Key = "status.deploy_failed"
+	StatusPreviewReady     Key = "status.preview_ready"
+)
+
+// catalog holds the messages of each locale. English is complete; other
+// locales fall back to it for missing keys.
+var catalog = map[Locale]map[Key]string{
+	English: {
+		UsernameTaken:   "username already taken",
+		EmailTaken:      "email already registered",
+		UserExists:      "user already exists",
+		UserNotFound:    "user not found",
+		InvalidPassword: "invalid password",
+		TokenRequired:   "token is required",
+		TokenInvalid:    "invalid token",
+		Registered:      "User registered successfully",
+		LoggedIn:        "Login successful",
+		TokenValid:      "Token is valid",
+		TokenRefreshed:  "Token refreshed successfully",
+		AuthRequired:    "authentication required",
+		AdminRequired:   "admin privileges required",
+		RateLimited:     "rate limit exceeded, try again later",
+
+		ImpersonationDisabled: "impersonation is disabled on this server",
+		ImpersonateSelf:       "you cannot impersonate yourself",
+		ImpersonationNested:   "impersonation sessions cannot impersonate or revoke",
+
+		FieldRequired:  "%s is required",
+		FieldLength:    "%s must be between %d and %d characters",
+		FieldMinLength: "%s must be at least %d characters",
+		FieldMaxLength: "%s must be at most %d characters",
+		FieldPattern:   "%s has an invalid format",
+		FieldEmail:     "invalid %s format",
+
+		FieldUsername:     "username",
+		FieldPassword:     "password",
+		FieldEmailAddress: "email",
+		FieldRefreshToken: "refresh token",
+
+		StatusBuildStarted:     "Build in progress",
+		StatusBuildSucceeded:   "Build succeeded, deploying",
+		StatusBuildFailed:      "Build failed",
+		StatusBuildCancelled:   "Build cancelled",
+		StatusBuildSuperseded:  "Superseded by a newer push",
+		StatusBuildInterrupted: "Interrupted by a server restart",
+		StatusDeploySucceeded:  "Deployed",
+		StatusDeployFailed:     "Deployment failed",
+		StatusPreviewReady:     "Preview ready",
+	},
+	Indonesian: {
+		UsernameTaken:   "nama pengguna sudah dipakai",
+		EmailTaken:      "email sudah terdaftar",
+		UserExists:      "pengguna sudah ada",
+		UserNotFound:    "pengguna tidak ditemukan",
+		InvalidPassword: "kata sandi salah",
+		TokenRequired:   "token wajib diisi",
+		TokenInvalid:    "token tidak valid",
+		Registered:      "Pendaftaran pengguna berhasil",
+		LoggedIn:        "Berhasil masuk",
+		TokenValid:      "Token valid",
+		TokenRefreshed:  "Token berhasil diperbarui",
+		AuthRequired:    "autentikasi diperlukan",
+		AdminRequired:   "memerlukan hak akses admin",
+		RateLimited:     "batas permintaan terlampaui, coba lagi nanti",
+
+		ImpersonationDisabled: "impersonasi dinonaktifkan di server ini",
+		ImpersonateSelf:       "anda tidak dapat mengimpersonasi diri sendiri",
+		ImpersonationNested:   "sesi impersonasi tidak dapat mengimpersonasi atau mencabut",
+
+		FieldRequired:  "%s wajib diisi",
+		FieldLength:    "%s harus terdiri dari %d sampai %d karakter",
+		FieldMinLength: "%s minimal %d karakter",
+		FieldMaxLength: "%s maksimal %d karakter",
+		FieldPattern:   "format %s tidak valid",
+		FieldEmail:     "format %s tidak valid",
+
+		FieldUsername:     "nama pengguna",
+		FieldPassword:     "kata sandi",
+		FieldEmailAddress: "email",
+		FieldRefreshToken: "refresh token",
+
+		StatusBuildStarted:     "Build sedang berjalan",
+		StatusBuildSucceeded:   "Build berhasil, sedang deploy",
+		StatusBuildFailed:      "Build gagal",
+		StatusBuildCancelled:   "Build dibatalkan",
+		StatusBuildSuperseded:  "Digantikan oleh push yang lebih baru",
+		StatusBuildInterrupted: "Terhenti karena server dimulai ulang",
+		StatusDeploySucceeded:  "Berhasil di-deploy",
+		StatusDeployFailed:     "Deploy gagal",
+		StatusPreviewReady:     "Preview siap",
+	},
+}
@@ -0,0 +1,135 @@
+package i18n
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Locale is a language the server answers in, as a BCP 47 primary tag
+type Locale string
+
+const (
+	English    Locale = "en"
+	Indonesian Locale = "id"
+
+	// Default is used when neither the request nor the user picks a locale
+	Default = English
+)
+
+// Locales lists the supported locales
+var Locales = []Locale{English, Indonesian}
+
+// MetadataKey is the gRPC metadata header clients select a locale with,
+// using Accept-Language syntax, e.g. "id-ID,id;q=0.9,en;q=0.8"
+const MetadataKey = "accept-language"
+
+type contextKey struct{}
+
+// Supported reports whether locale has a catalog
+func Supported(locale Locale) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse picks the supported locale the client prefers from an
+// Accept-Language value. Region subtags are ignored, so "id-ID" selects
+// Indonesian.
+func Parse(value string) (Locale, bool) {
+	type candidate struct {
+		locale Locale
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(value, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if weight, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(weight, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		locale := Locale(primary)
+		if q <= 0 || !Supported(locale) {
+			continue
+		}
+		candidates = append(candidates, candidate{locale, q})
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale, true
+}
+
+// FromMetadata returns the locale requested in the incoming gRPC metadata
+func FromMetadata(ctx context.Context) (Locale, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(MetadataKey)
+	if len(values) == 0 {
+		return "", false
+	}
+	return Parse(strings.Join(values, ","))
+}
+
+// WithLocale returns a context whose messages are rendered in locale
+func WithLocale(ctx context.Context, locale Locale) context.Context {
+	return context.WithValue(ctx, contextKey{}, locale)
+}
+
+// FromContext returns the locale chosen for the request, falling back to
+// the one asked for in its metadata and then to Default
+func FromContext(ctx context.Context) Locale {
+	if locale, ok := ctx.Value(contextKey{}).(Locale); ok {
+		return locale
+	}
+	if locale, ok := FromMetadata(ctx); ok {
+		return locale
+	}
+	return Default
+}
+
+// Translate renders the message for key in locale. Messages missing from
+// a catalog fall back to English.
+func Translate(locale Locale, key Key, args ...interface{}) string {
+	format, ok := catalog[locale][key]
+	if !ok {
+		format, ok = catalog[English][key]
+	}
+	if !ok {
+		format = string(key)
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// T renders the message for key in the request's locale
+func T(ctx context.Context, key Key, args ...interface{}) string {
+	return Translate(FromContext(ctx), key, args...)
+}
+
+// Field renders the display name of a request field in the request's
+// locale. Fields missing from the catalog show their proto name.
+func Field(ctx context.Context, name string) string {
+	key := Key("field." + name)
+	if _, ok := catalog[English][key]; !ok {
+		return strings.ReplaceAll(name, "_", " ")
+	}
+	return T(ctx, key)
+}
@@ -0,0 +1,68 @@
+package i18n
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   Locale
+		wantOK bool
+	}{
+		{"id", Indonesian, true},
+		{"id-ID,id;q=0.9,en;q=0.8", Indonesian, true},
+		{"en-US,en;q=0.9", English, true},
+		{"fr-FR,id;q=0.5,en;q=0.7", English, true},
+		{"fr, de", "", false},
+		{"id;q=0", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := Parse(tt.value)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, Default, FromContext(context.Background()))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "id-ID"))
+	assert.Equal(t, Indonesian, FromContext(ctx))
+
+	// A locale chosen for the request wins over its metadata
+	assert.Equal(t, English, FromContext(WithLocale(ctx, English)))
+}
+
+func TestTranslate(t *testing.T) {
+	assert.Equal(t, "username must be between 3 and 32 characters", Translate(English, FieldLength, "username", 3, 32))
+	assert.Equal(t, "kata sandi minimal 8 karakter", Translate(Indonesian, FieldMinLength, "kata sandi", 8))
+
+	// Unknown locales and keys fall back to English and the key
+	assert.Equal(t, "Deployed", Translate("fr", StatusDeploySucceeded))
+	assert.Equal(t, "missing.key", Translate(Indonesian, "missing.key"))
+}
+
+func TestField(t *testing.T) {
+	ctx := WithLocale(context.Background(), Indonesian)
+	assert.Equal(t, "nama pengguna", Field(ctx, "username"))
+	assert.Equal(t, "username", Field(context.Background(), "username"))
+
+	// Fields without a display name fall back to their proto name
+	assert.Equal(t, "project id", Field(ctx, "project_id"))
+}
+
+func TestCatalogsCoverEnglish(t *testing.T) {
+	for _, locale := range Locales {
+		for key := range catalog[English] {
+			assert.Contains(t, catalog[locale], key, "%s is missing %s", locale, key)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetryInterval = 15 * time.Second
+	lockTimeout          = 5 * time.Second
+)
+
+// Job is a background singleton that must run on one instance at a time.
+// Start is called when the instance becomes leader and Stop when it steps
+// down; a job may be started again after it was stopped.
+type Job interface {
+	Start()
+	Stop()
+}
+
+// Lock is the cluster-wide lock leadership is held by
+type Lock interface {
+	// TryAcquire reports whether the lock is held by this instance
+	TryAcquire(ctx context.Context) (bool, error)
+	// Check fails when a held lock may have been lost
+	Check(ctx context.Context) error
+	Release(ctx context.Context) error
+}
+
+type namedJob struct {
+	name string
+	job  Job
+}
+
+// Elector runs the registered jobs while this instance holds the lock.
+// Instances that lose the lock stop their jobs, and the others campaign
+// for it every retry interval, so a crashed leader is replaced within one
+// interval. Without a lock every instance leads.
+type Elector struct {
+	lock     Lock
+	interval time.Duration
+	log      *zap.Logger
+
+	mu      sync.Mutex
+	jobs    []namedJob
+	leading bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewElector(lock Lock, interval time.Duration, log *zap.Logger) *Elector {
+	if interval <= 0 {
+		interval = defaultRetryInterval
+	}
+	return &Elector{
+		lock:     lock,
+		interval: interval,
+		log:      log,
+	}
+}
+
+// Register adds a job to run while leading. Jobs registered while leading
+// are started right away.
+func (e *Elector) Register(name string, job Job) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.jobs = append(e.jobs, namedJob{name: name, job: job})
+	if e.leading {
+		job.Start()
+	}
+}
+
+// IsLeader reports whether the jobs run on this instance
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+// Start campaigns for leadership in the background until Stop is called
+func (e *Elector) Start() {
+	if e.lock == nil {
+		e.lead()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			e.Campaign(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the jobs and gives up the lock so another instance can take
+// over without waiting for the connection to time out
+func (e *Elector) Stop() {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+	}
+	if !e.stepDown() || e.lock == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	if err := e.lock.Release(ctx); err != nil {
+		e.log.Warn("failed to release leader lock", zap.Error(err))
+	}
+}
+
+// Campaign runs one round of the election: a leader confirms it still
+// holds the lock, other instances try to acquire it
+func (e *Elector) Campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+
+	if e.IsLeader() {
+		if err := e.lock.Check(ctx); err != nil {
+			e.log.Warn("lost leadership, stopping singleton jobs", zap.Error(err))
+			e.stepDown()
+		}
+		return
+	}
+
+	acquired, err := e.lock.TryAcquire(ctx)
+	if err != nil {
+		e.log.Warn("failed to campaign for leadership", zap.Error(err))
+		return
+	}
+	if acquired {
+		e.log.Info("elected leader, starting singleton jobs")
+		e.lead()
+	}
+}
+
+func (e *Elector) lead() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leading {
+		return
+	}
+	e.leading = true
+	for _, j := range e.jobs {
+		e.log.Debug("starting singleton job", zap.String("job", j.name))
+		j.job.Start()
+	}
+}
+
+// stepDown stops the jobs in reverse order and reports whether this
+// instance was leading
+func (e *Elector) stepDown() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.leading {
+		return false
+	}
+	e.leading = false
+	for i := len(e.jobs) - 1; i >= 0; i-- {
+		e.log.Debug("stopping singleton job", zap.String("job", e.jobs[i].name))
+		e.jobs[i].job.Stop()
+	}
+	return true
+}
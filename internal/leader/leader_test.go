@@ -0,0 +1,137 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeLock is shared by the electors of a test, like an advisory lock
+// shared by the replicas of a deployment
+type fakeLock struct {
+	mu     sync.Mutex
+	holder *instanceLock
+}
+
+type instanceLock struct {
+	shared *fakeLock
+	lost   bool
+}
+
+func (f *fakeLock) instance() *instanceLock {
+	return &instanceLock{shared: f}
+}
+
+func (l *instanceLock) TryAcquire(context.Context) (bool, error) {
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	if l.shared.holder == nil {
+		l.shared.holder = l
+		l.lost = false
+	}
+	return l.shared.holder == l, nil
+}
+
+func (l *instanceLock) Check(context.Context) error {
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	if l.lost || l.shared.holder != l {
+		return errors.New("session closed")
+	}
+	return nil
+}
+
+func (l *instanceLock) Release(context.Context) error {
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	if l.shared.holder == l {
+		l.shared.holder = nil
+	}
+	return nil
+}
+
+// crash drops the lock like a closed database session would
+func (l *instanceLock) crash() {
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	l.lost = true
+	l.shared.holder = nil
+}
+
+type countingJob struct {
+	starts, stops int
+}
+
+func (j *countingJob) Start() { j.starts++ }
+func (j *countingJob) Stop()  { j.stops++ }
+
+func (j *countingJob) running() bool { return j.starts > j.stops }
+
+func TestElector_SingleLeaderAndFailover(t *testing.T) {
+	shared := &fakeLock{}
+	lockA, lockB := shared.instance(), shared.instance()
+	jobA, jobB := &countingJob{}, &countingJob{}
+
+	a := NewElector(lockA, 0, zap.NewNop())
+	a.Register("purger", jobA)
+	b := NewElector(lockB, 0, zap.NewNop())
+	b.Register("purger", jobB)
+
+	ctx := context.Background()
+	a.Campaign(ctx)
+	b.Campaign(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+	assert.True(t, jobA.running())
+	assert.False(t, jobB.running())
+
+	// The leader notices its session is gone and stops its jobs, then the
+	// other instance takes over
+	lockA.crash()
+	a.Campaign(ctx)
+	assert.False(t, a.IsLeader())
+	assert.False(t, jobA.running())
+
+	b.Campaign(ctx)
+	a.Campaign(ctx)
+	assert.True(t, b.IsLeader())
+	assert.False(t, a.IsLeader())
+	assert.True(t, jobB.running())
+	assert.False(t, jobA.running())
+}
+
+func TestElector_StopReleasesLock(t *testing.T) {
+	shared := &fakeLock{}
+	a := NewElector(shared.instance(), 0, zap.NewNop())
+	b := NewElector(shared.instance(), 0, zap.NewNop())
+	job := &countingJob{}
+	a.Register("meter", job)
+
+	a.Campaign(context.Background())
+	a.Stop()
+	assert.Equal(t, 1, job.stops)
+	assert.False(t, a.IsLeader())
+
+	b.Campaign(context.Background())
+	assert.True(t, b.IsLeader())
+}
+
+func TestElector_WithoutLockAlwaysLeads(t *testing.T) {
+	e := NewElector(nil, 0, zap.NewNop())
+	before := &countingJob{}
+	e.Register("monitor", before)
+	e.Start()
+
+	after := &countingJob{}
+	e.Register("registry-gc", after)
+	assert.True(t, before.running())
+	assert.True(t, after.running())
+
+	e.Stop()
+	assert.False(t, before.running())
+	assert.False(t, after.running())
+}
@@ -0,0 +1,92 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultLockID is the advisory lock key used when none is configured
+const DefaultLockID int64 = 0x63686566 // "chef"
+
+var errNotHeld = errors.New("leader lock is not held")
+
+// PostgresLock is a session-level advisory lock. It pins one connection
+// from the pool while held; Postgres releases the lock when that
+// connection closes, which is how a crashed leader gives it up.
+type PostgresLock struct {
+	db  *sql.DB
+	key int64
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+func NewPostgresLock(db *sql.DB, key int64) *PostgresLock {
+	if key == 0 {
+		key = DefaultLockID
+	}
+	return &PostgresLock{db: db, key: key}
+}
+
+func (l *PostgresLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		conn, err := l.db.Conn(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to get connection: %w", err)
+		}
+		l.conn = conn
+	}
+
+	var acquired bool
+	if err := l.conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		l.closeLocked()
+		return false, fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+	if !acquired {
+		l.closeLocked()
+	}
+	return acquired, nil
+}
+
+// Check verifies the session holding the lock is still alive. A
+// session-level lock is only released explicitly or with its session.
+func (l *PostgresLock) Check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return errNotHeld
+	}
+	if err := l.conn.PingContext(ctx); err != nil {
+		l.closeLocked()
+		return fmt.Errorf("lost leader lock session: %w", err)
+	}
+	return nil
+}
+
+func (l *PostgresLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	defer l.closeLocked()
+	if _, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		return fmt.Errorf("failed to release advisory lock: %w", err)
+	}
+	return nil
+}
+
+func (l *PostgresLock) closeLocked() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
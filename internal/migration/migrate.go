@@ -1,13 +1,14 @@
 package migration
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
-	_ "github.com/lib/pq"
 	"github.com/pressly/goose/v3"
 
 	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/database"
 )
 
 type Migrator struct {
@@ -16,17 +17,7 @@ type Migrator struct {
 }
 
 func NewMigrator(config *config.DatabaseConfig) (*Migrator, error) {
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
-		config.Host,
-		config.User,
-		config.Password,
-		config.Name,
-		config.Port,
-		config.SSLMode,
-	)
-
-	db, err := sql.Open("postgres", dsn)
+	db, err := database.OpenSQL(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -71,6 +62,11 @@ func (m *Migrator) Down() error {
 	return nil
 }
 
+// Ping verifies the database is reachable
+func (m *Migrator) Ping(ctx context.Context) error {
+	return m.db.PingContext(ctx)
+}
+
 func (m *Migrator) Close() error {
 	return m.db.Close()
 }
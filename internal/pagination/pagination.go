@@ -0,0 +1,190 @@
+// Package pagination implements the list conventions shared by repositories:
+// opaque keyset cursors, capped page sizes, whitelisted sort fields and
+// case-insensitive search.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var (
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrInvalidSort      = errors.New("invalid sort field")
+)
+
+// Params are the list options supplied by API callers
+type Params struct {
+	PageSize  int    // Defaults to DefaultPageSize, capped at MaxPageSize
+	PageToken string // NextPageToken of the previous page
+	SortBy    string // Sort field, prefixed with "-" for descending order
+	Search    string // Case-insensitive substring match on the search columns
+}
+
+// Spec describes how a model may be listed
+type Spec struct {
+	SortFields    map[string]string // Allowed sort fields mapped to their columns
+	DefaultSort   string            // Used when Params.SortBy is empty
+	SearchColumns []string
+}
+
+// Page is a single page of results. NextPageToken is empty on the last page.
+type Page[T any] struct {
+	Items         []T
+	NextPageToken string
+}
+
+// cursor identifies the last row of a page. The sort is included so a token
+// cannot be replayed against a different ordering.
+type cursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    json.RawMessage `json:"i"`
+}
+
+// List runs query for T with the spec's ordering and search applied and
+// returns the page following params.PageToken. Rows are ordered by the sort
+// column with the primary key as tie-breaker, so paging stays stable while
+// rows are inserted.
+func List[T any](query *gorm.DB, params Params, spec Spec) (*Page[T], error) {
+	sortBy := params.SortBy
+	if sortBy == "" {
+		sortBy = spec.DefaultSort
+	}
+	desc := strings.HasPrefix(sortBy, "-")
+	name := strings.TrimPrefix(sortBy, "-")
+
+	column, ok := spec.SortFields[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSort, name)
+	}
+
+	stmt := &gorm.Statement{DB: query}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, fmt.Errorf("failed to parse model: %w", err)
+	}
+	sortField := stmt.Schema.LookUpField(column)
+	idField := stmt.Schema.PrioritizedPrimaryField
+	if sortField == nil || idField == nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSort, name)
+	}
+
+	if params.Search != "" && len(spec.SearchColumns) > 0 {
+		pattern := "%" + escapeLike(params.Search) + "%"
+		conditions := make([]string, len(spec.SearchColumns))
+		args := make([]interface{}, len(spec.SearchColumns))
+		for i, column := range spec.SearchColumns {
+			conditions[i] = column + " ILIKE ?"
+			args[i] = pattern
+		}
+		query = query.Where("("+strings.Join(conditions, " OR ")+")", args...)
+	}
+
+	if params.PageToken != "" {
+		value, id, err := decodeCursor(params.PageToken, sortBy, sortField, idField)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		query = query.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", sortField.DBName, idField.DBName, op), value, id)
+	}
+
+	size := pageSize(params.PageSize)
+	var items []T
+	err := query.
+		Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+			{Column: clause.Column{Name: sortField.DBName}, Desc: desc},
+			{Column: clause.Column{Name: idField.DBName}, Desc: desc},
+		}}).
+		Limit(size + 1).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Items: items}
+	if len(items) > size {
+		page.Items = items[:size]
+		token, err := encodeCursor(stmt, sortBy, sortField, idField, &page.Items[size-1])
+		if err != nil {
+			return nil, err
+		}
+		page.NextPageToken = token
+	}
+	return page, nil
+}
+
+func pageSize(requested int) int {
+	switch {
+	case requested <= 0:
+		return DefaultPageSize
+	case requested > MaxPageSize:
+		return MaxPageSize
+	default:
+		return requested
+	}
+}
+
+// escapeLike makes user input match literally inside an ILIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func encodeCursor(stmt *gorm.Statement, sortBy string, sortField, idField *schema.Field, item interface{}) (string, error) {
+	row := reflect.ValueOf(item)
+	value, _ := sortField.ValueOf(stmt.Context, row)
+	id, _ := idField.ValueOf(stmt.Context, row)
+
+	var c cursor
+	var err error
+	c.Sort = sortBy
+	if c.Value, err = json.Marshal(value); err != nil {
+		return "", fmt.Errorf("failed to encode page token: %w", err)
+	}
+	if c.ID, err = json.Marshal(id); err != nil {
+		return "", fmt.Errorf("failed to encode page token: %w", err)
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode page token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// decodeCursor restores the cursor values with the column types so they
+// compare correctly in the database
+func decodeCursor(token, sortBy string, sortField, idField *schema.Field) (interface{}, interface{}, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, nil, ErrInvalidPageToken
+	}
+
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil || c.Sort != sortBy {
+		return nil, nil, ErrInvalidPageToken
+	}
+
+	value := reflect.New(sortField.FieldType)
+	id := reflect.New(idField.FieldType)
+	if json.Unmarshal(c.Value, value.Interface()) != nil || json.Unmarshal(c.ID, id.Interface()) != nil {
+		return nil, nil, ErrInvalidPageToken
+	}
+	return value.Elem().Interface(), id.Elem().Interface(), nil
+}
@@ -0,0 +1,144 @@
+package pagination
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+type widget struct {
+	ID        uint
+	Name      string
+	CreatedAt time.Time
+}
+
+var widgetSpec = Spec{
+	SortFields:    map[string]string{"name": "name", "created_at": "created_at"},
+	DefaultSort:   "name",
+	SearchColumns: []string{"name", "owner"},
+}
+
+// fakeDB builds queries without a server. Queries return the first rows up to
+// the limit, and the last SQL statement and its variables are recorded.
+type fakeDB struct {
+	db   *gorm.DB
+	sql  string
+	vars []interface{}
+}
+
+func newFakeDB(t *testing.T, rows []widget) *fakeDB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	require.NoError(t, err)
+
+	f := &fakeDB{db: db}
+	err = db.Callback().Query().Replace("gorm:query", func(tx *gorm.DB) {
+		callbacks.BuildQuerySQL(tx)
+		f.sql = tx.Statement.SQL.String()
+		f.vars = tx.Statement.Vars
+
+		result := rows
+		if c, ok := tx.Statement.Clauses["LIMIT"]; ok {
+			if limit := c.Expression.(clause.Limit).Limit; limit != nil && *limit < len(result) {
+				result = result[:*limit]
+			}
+		}
+		reflect.ValueOf(tx.Statement.Dest).Elem().Set(reflect.ValueOf(result))
+	})
+	require.NoError(t, err)
+	return f
+}
+
+func TestList(t *testing.T) {
+	created := time.Date(2025, 2, 17, 9, 0, 0, 0, time.UTC)
+	rows := []widget{
+		{ID: 1, Name: "alpha", CreatedAt: created},
+		{ID: 2, Name: "beta", CreatedAt: created},
+		{ID: 3, Name: "gamma", CreatedAt: created},
+	}
+
+	t.Run("first page", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+
+		page, err := List[widget](f.db, Params{PageSize: 2}, widgetSpec)
+		require.NoError(t, err)
+
+		assert.Len(t, page.Items, 2)
+		assert.NotEmpty(t, page.NextPageToken)
+		assert.Equal(t, `SELECT * FROM "widgets" ORDER BY "name","id" LIMIT $1`, f.sql)
+		assert.Equal(t, []interface{}{3}, f.vars)
+	})
+
+	t.Run("next page continues after cursor", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+		first, err := List[widget](f.db, Params{PageSize: 2, SortBy: "-created_at"}, widgetSpec)
+		require.NoError(t, err)
+
+		_, err = List[widget](f.db, Params{PageSize: 2, SortBy: "-created_at", PageToken: first.NextPageToken}, widgetSpec)
+		require.NoError(t, err)
+
+		assert.Equal(t, `SELECT * FROM "widgets" WHERE (created_at, id) < ($1, $2) ORDER BY "created_at" DESC,"id" DESC LIMIT $3`, f.sql)
+		assert.Equal(t, []interface{}{created, uint(2), 3}, f.vars)
+	})
+
+	t.Run("last page has no token", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+
+		page, err := List[widget](f.db, Params{}, widgetSpec)
+		require.NoError(t, err)
+
+		assert.Len(t, page.Items, 3)
+		assert.Empty(t, page.NextPageToken)
+	})
+
+	t.Run("search escapes wildcards", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+
+		_, err := List[widget](f.db, Params{Search: "50%_off"}, widgetSpec)
+		require.NoError(t, err)
+
+		assert.Equal(t, `SELECT * FROM "widgets" WHERE (name ILIKE $1 OR owner ILIKE $2) ORDER BY "name","id" LIMIT $3`, f.sql)
+		assert.Equal(t, `%50\%\_off%`, f.vars[0])
+	})
+
+	t.Run("page size is capped", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+
+		_, err := List[widget](f.db, Params{PageSize: 10000}, widgetSpec)
+		require.NoError(t, err)
+		assert.Equal(t, MaxPageSize+1, f.vars[len(f.vars)-1])
+	})
+
+	t.Run("unknown sort field", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+
+		_, err := List[widget](f.db, Params{SortBy: "password"}, widgetSpec)
+		assert.ErrorIs(t, err, ErrInvalidSort)
+	})
+
+	t.Run("token from another sort order", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+		first, err := List[widget](f.db, Params{PageSize: 1}, widgetSpec)
+		require.NoError(t, err)
+
+		_, err = List[widget](f.db, Params{SortBy: "created_at", PageToken: first.NextPageToken}, widgetSpec)
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		f := newFakeDB(t, rows)
+
+		_, err := List[widget](f.db, Params{PageToken: "not-a-token"}, widgetSpec)
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+	})
+}
@@ -0,0 +1,362 @@
+// Package addon provisions the managed services, such as a Postgres
+// database or a Redis instance, projects request in chef.yaml and tears
+// them down with the project.
+package addon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultTimeout = 300 * time.Second
+
+	// Labels of every object created for an add-on
+	projectLabel = "chef-infra/project"
+	kindLabel    = "chef-infra/addon"
+
+	// maxNameLength keeps StatefulSet names short enough for the
+	// controller-revision-hash label of their pods
+	maxNameLength = 52
+)
+
+// pollInterval is how often provisioning instances are checked
+var pollInterval = 2 * time.Second
+
+// ErrDisabled is returned for projects requesting add-ons while no
+// provider is configured
+var ErrDisabled = errors.New("add-ons are not enabled")
+
+// Store keeps the lifecycle records of add-ons
+type Store interface {
+	// SaveAddOn creates or replaces the record of a project's add-on
+	SaveAddOn(ctx context.Context, addon *types.AddOn) error
+	// ListAddOns returns the add-ons of a project ordered by kind
+	ListAddOns(ctx context.Context, projectID string) ([]types.AddOn, error)
+	// DeleteAddOn removes the record of a torn down add-on
+	DeleteAddOn(ctx context.Context, projectID, kind string) error
+}
+
+// Provider creates and deletes add-on instances
+type Provider interface {
+	Name() string
+	// Provision creates the add-on's instance, or returns it when it
+	// already exists, e.g. after an earlier attempt timed out. current
+	// holds the connection settings stored for the add-on, empty on first
+	// provisioning.
+	Provision(ctx context.Context, addon *types.AddOn, current map[string]string) (*Instance, error)
+	// Ready reports whether the instance accepts connections
+	Ready(ctx context.Context, addon *types.AddOn) (bool, error)
+	// Deprovision deletes the instance and its data. Instances that are
+	// already gone are not an error.
+	Deprovision(ctx context.Context, addon *types.AddOn) error
+}
+
+// Instance is a provisioned add-on
+type Instance struct {
+	Ref  string            // Provider's ID of the instance
+	Host string            // Host name the project connects to
+	Env  map[string]string // Connection settings injected as env vars
+}
+
+// NewProvider creates the provider selected by cfg. client manages the
+// add-ons of the kubernetes provider in namespace.
+func NewProvider(cfg *config.AddOnConfig, namespace string, client kubernetes.Interface) (Provider, error) {
+	switch cfg.Provider {
+	case "kubernetes":
+		return NewKubernetesProvider(cfg, namespace, client)
+	case "external":
+		return NewExternalProvider(cfg)
+	}
+	return nil, fmt.Errorf("unknown add-on provider %q, expected kubernetes or external", cfg.Provider)
+}
+
+// Manager provisions the add-ons a project requests before it is deployed
+// and tracks their lifecycle. Connection settings are written to a secret
+// named like the add-on in namespace.
+type Manager struct {
+	provider  Provider // Nil when add-ons are disabled
+	client    kubernetes.Interface
+	namespace string
+	store     Store
+	timeout   time.Duration
+	logger    *zap.Logger
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // Per project, so concurrent deploys provision once
+}
+
+// NewManager creates a manager. provider may be nil to disable add-ons.
+func NewManager(cfg *config.AddOnConfig, namespace string, provider Provider, client kubernetes.Interface, store Store, logger *zap.Logger) *Manager {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Manager{
+		provider:  provider,
+		client:    client,
+		namespace: namespace,
+		store:     store,
+		timeout:   timeout,
+		logger:    logger,
+		locks:     make(map[string]*sync.Mutex),
+	}
+}
+
+func (m *Manager) Enabled() bool {
+	return m != nil && m.provider != nil
+}
+
+// Name is the name of the Kubernetes objects of a project's add-on,
+// including the secret holding its connection settings
+func Name(projectID, kind string) string {
+	name := projectID + "-" + kind
+	if len(name) <= maxNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	keep := maxNameLength - len(kind) - 10
+	return fmt.Sprintf("%s-%s-%s", projectID[:keep], hex.EncodeToString(sum[:])[:8], kind)
+}
+
+// Ensure provisions the kinds of add-ons the project does not have yet and
+// waits until they are ready. It returns the add-ons provisioned by this
+// call; those already ready are left alone.
+func (m *Manager) Ensure(ctx context.Context, projectID string, kinds []string) ([]types.AddOn, error) {
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+	if !m.Enabled() {
+		return nil, fmt.Errorf("%w, the project requests %s", ErrDisabled, strings.Join(kinds, " and "))
+	}
+
+	lock := m.lock(projectID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	existing, err := m.records(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var provisioned []types.AddOn
+	for _, kind := range kinds {
+		addon, ok := existing[kind]
+		if ok && addon.Status == types.AddOnReady {
+			continue
+		}
+		if !ok {
+			addon = &types.AddOn{
+				ProjectID: projectID,
+				Kind:      kind,
+				Provider:  m.provider.Name(),
+				Secret:    Name(projectID, kind),
+				CreatedAt: time.Now(),
+			}
+		}
+		if err := m.provision(ctx, addon); err != nil {
+			addon.Status = types.AddOnFailed
+			addon.Message = err.Error()
+			m.save(ctx, addon)
+			return provisioned, fmt.Errorf("failed to provision %s: %w", kind, err)
+		}
+		provisioned = append(provisioned, *addon)
+	}
+	return provisioned, nil
+}
+
+func (m *Manager) provision(ctx context.Context, addon *types.AddOn) error {
+	addon.Status = types.AddOnProvisioning
+	addon.Message = ""
+	if err := m.save(ctx, addon); err != nil {
+		return err
+	}
+
+	current, err := m.readSecret(ctx, addon.Secret)
+	if err != nil {
+		return err
+	}
+	instance, err := m.provider.Provision(ctx, addon, current)
+	if err != nil {
+		return err
+	}
+	addon.Ref = instance.Ref
+	addon.Host = instance.Host
+	if err := m.writeSecret(ctx, addon, instance.Env); err != nil {
+		return err
+	}
+	// The reference is kept before waiting so an interrupted provisioning
+	// can still be torn down
+	if err := m.save(ctx, addon); err != nil {
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		ready, err := m.provider.Ready(waitCtx, addon)
+		if err != nil {
+			return err
+		}
+		if ready {
+			break
+		}
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("not ready after %s", m.timeout)
+		case <-ticker.C:
+		}
+	}
+
+	addon.Status = types.AddOnReady
+	if err := m.save(ctx, addon); err != nil {
+		return err
+	}
+	m.logger.Info("add-on ready",
+		zap.String("project", addon.ProjectID),
+		zap.String("kind", addon.Kind),
+		zap.String("host", addon.Host))
+	return nil
+}
+
+// Remove deletes every add-on of a project along with its data and
+// connection settings. It is safe to call again after a partial failure.
+func (m *Manager) Remove(ctx context.Context, projectID string) error {
+	lock := m.lock(projectID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	addons, err := m.store.ListAddOns(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to list add-ons: %w", err)
+	}
+	for i := range addons {
+		addon := &addons[i]
+		if !m.Enabled() {
+			return fmt.Errorf("%w, cannot remove %s of %s", ErrDisabled, addon.Kind, projectID)
+		}
+		addon.Status = types.AddOnDeleting
+		if err := m.save(ctx, addon); err != nil {
+			return err
+		}
+		if err := m.provider.Deprovision(ctx, addon); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", addon.Kind, err)
+		}
+		err := m.client.CoreV1().Secrets(m.namespace).Delete(ctx, addon.Secret, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete secret %s: %w", addon.Secret, err)
+		}
+		if err := m.store.DeleteAddOn(ctx, projectID, addon.Kind); err != nil {
+			return err
+		}
+		m.logger.Info("add-on removed",
+			zap.String("project", projectID),
+			zap.String("kind", addon.Kind))
+	}
+	return nil
+}
+
+func (m *Manager) lock(projectID string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.locks[projectID]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[projectID] = lock
+	}
+	return lock
+}
+
+func (m *Manager) records(ctx context.Context, projectID string) (map[string]*types.AddOn, error) {
+	addons, err := m.store.ListAddOns(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list add-ons: %w", err)
+	}
+	records := make(map[string]*types.AddOn, len(addons))
+	for i := range addons {
+		records[addons[i].Kind] = &addons[i]
+	}
+	return records, nil
+}
+
+func (m *Manager) save(ctx context.Context, addon *types.AddOn) error {
+	addon.UpdatedAt = time.Now()
+	if err := m.store.SaveAddOn(ctx, addon); err != nil {
+		m.logger.Error("failed to save add-on",
+			zap.String("project", addon.ProjectID),
+			zap.String("kind", addon.Kind),
+			zap.Error(err))
+		return fmt.Errorf("failed to save add-on: %w", err)
+	}
+	return nil
+}
+
+// readSecret returns the connection settings stored in a secret, nil when
+// it does not exist
+func (m *Manager) readSecret(ctx context.Context, name string) (map[string]string, error) {
+	secret, err := m.client.CoreV1().Secrets(m.namespace).Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	values := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		values[key] = string(value)
+	}
+	return values, nil
+}
+
+func (m *Manager) writeSecret(ctx context.Context, addon *types.AddOn, env map[string]string) error {
+	data := make(map[string][]byte, len(env))
+	for key, value := range env {
+		data[key] = []byte(value)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      addon.Secret,
+			Namespace: m.namespace,
+			Labels:    labels(addon.ProjectID, addon.Kind),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+	secrets := m.client.CoreV1().Secrets(m.namespace)
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s: %w", addon.Secret, err)
+	}
+	return nil
+}
+
+func labels(projectID, kind string) map[string]string {
+	return map[string]string{
+		projectLabel:                   projectID,
+		kindLabel:                      kind,
+		"app.kubernetes.io/managed-by": "chef-infra",
+	}
+}
@@ -0,0 +1,167 @@
+package addon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// maxErrorBody caps the error responses read from the provisioning API
+const maxErrorBody = 64 * 1024
+
+// errInstanceNotFound is returned for instances the API does not know
+var errInstanceNotFound = errors.New("instance not found")
+
+// ExternalProvider requests add-ons from a provisioning API, e.g. a thin
+// service in front of a cloud provider's managed databases:
+//
+//	POST   /instances       {"project": "shop", "kind": "postgres"}
+//	GET    /instances/{id}
+//	DELETE /instances/{id}
+//
+// POST and GET answer with the instance as {"id", "status", "host", "env",
+// "message"}, where status is "provisioning", "ready" or "failed" and env
+// holds the connection settings injected into the project.
+type ExternalProvider struct {
+	endpoint string
+	token    string
+	http     *http.Client
+}
+
+type externalInstance struct {
+	ID      string            `json:"id"`
+	Status  string            `json:"status"`
+	Host    string            `json:"host"`
+	Env     map[string]string `json:"env"`
+	Message string            `json:"message"`
+}
+
+func NewExternalProvider(cfg *config.AddOnConfig) (*ExternalProvider, error) {
+	if cfg.APIURL == "" {
+		return nil, errors.New("the external add-on provider requires an api_url")
+	}
+	return &ExternalProvider{
+		endpoint: strings.TrimSuffix(cfg.APIURL, "/"),
+		token:    cfg.Token,
+		http:     &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (p *ExternalProvider) Name() string {
+	return "external"
+}
+
+// Provision returns the instance recorded on addon when the API still
+// knows it and requests a new one otherwise
+func (p *ExternalProvider) Provision(ctx context.Context, addon *types.AddOn, _ map[string]string) (*Instance, error) {
+	var instance *externalInstance
+	var err error
+	if addon.Ref != "" {
+		instance, err = p.get(ctx, addon.Ref)
+		if err != nil && !errors.Is(err, errInstanceNotFound) {
+			return nil, err
+		}
+	}
+	if instance == nil {
+		instance = &externalInstance{}
+		body := map[string]string{"project": addon.ProjectID, "kind": addon.Kind}
+		if err := p.call(ctx, http.MethodPost, "/instances", body, instance); err != nil {
+			return nil, err
+		}
+	}
+	if instance.Status == "failed" {
+		return nil, fmt.Errorf("provisioning failed: %s", instance.Message)
+	}
+	if instance.ID == "" {
+		return nil, errors.New("provisioning API returned no instance ID")
+	}
+	return &Instance{Ref: instance.ID, Host: instance.Host, Env: instance.Env}, nil
+}
+
+func (p *ExternalProvider) Ready(ctx context.Context, addon *types.AddOn) (bool, error) {
+	instance, err := p.get(ctx, addon.Ref)
+	if err != nil {
+		return false, err
+	}
+	if instance.Status == "failed" {
+		return false, fmt.Errorf("provisioning failed: %s", instance.Message)
+	}
+	return instance.Status == "ready", nil
+}
+
+func (p *ExternalProvider) Deprovision(ctx context.Context, addon *types.AddOn) error {
+	if addon.Ref == "" {
+		return nil
+	}
+	err := p.call(ctx, http.MethodDelete, "/instances/"+url.PathEscape(addon.Ref), nil, nil)
+	if errors.Is(err, errInstanceNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (p *ExternalProvider) get(ctx context.Context, id string) (*externalInstance, error) {
+	var instance externalInstance
+	if err := p.call(ctx, http.MethodGet, "/instances/"+url.PathEscape(id), nil, &instance); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+func (p *ExternalProvider) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("add-on API %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("add-on API %s %s: %w", method, path, errInstanceNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("add-on API %s %s: %s: %s", method, path, resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("add-on API %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode add-on API response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package addon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/teardown"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultStorageSize   = "1Gi"
+	defaultPostgresImage = "postgres:16-alpine"
+	defaultRedisImage    = "redis:7-alpine"
+
+	postgresUser = "chef"
+	postgresPort = 5432
+	redisPort    = 6379
+
+	// dataVolume is the volume claim template of each StatefulSet
+	dataVolume = "data"
+)
+
+// KubernetesProvider runs each add-on as a single replica StatefulSet with
+// a persistent volume and a Service in front of it. Passwords are generated
+// on first provisioning and read back from the add-on's secret afterwards.
+type KubernetesProvider struct {
+	config      *config.AddOnConfig
+	namespace   string
+	storageSize resource.Quantity
+	client      kubernetes.Interface
+}
+
+func NewKubernetesProvider(cfg *config.AddOnConfig, namespace string, client kubernetes.Interface) (*KubernetesProvider, error) {
+	size := cfg.StorageSize
+	if size == "" {
+		size = defaultStorageSize
+	}
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid add-on storage size %q: %w", size, err)
+	}
+	return &KubernetesProvider{config: cfg, namespace: namespace, storageSize: quantity, client: client}, nil
+}
+
+func (p *KubernetesProvider) Name() string {
+	return "kubernetes"
+}
+
+func (p *KubernetesProvider) Provision(ctx context.Context, addon *types.AddOn, current map[string]string) (*Instance, error) {
+	name := Name(addon.ProjectID, addon.Kind)
+	host := fmt.Sprintf("%s.%s.svc", name, p.namespace)
+
+	var env map[string]string
+	var container corev1.Container
+	var port int
+	switch addon.Kind {
+	case types.AddOnPostgres:
+		password, err := reusePassword(current["PGPASSWORD"])
+		if err != nil {
+			return nil, err
+		}
+		database := strings.ReplaceAll(addon.ProjectID, "-", "_")
+		port = postgresPort
+		env = map[string]string{
+			"DATABASE_URL": (&url.URL{
+				Scheme:   "postgres",
+				User:     url.UserPassword(postgresUser, password),
+				Host:     net.JoinHostPort(host, strconv.Itoa(port)),
+				Path:     "/" + database,
+				RawQuery: "sslmode=disable",
+			}).String(),
+			"PGHOST":     host,
+			"PGPORT":     strconv.Itoa(port),
+			"PGUSER":     postgresUser,
+			"PGPASSWORD": password,
+			"PGDATABASE": database,
+		}
+		container = corev1.Container{
+			Name:  addon.Kind,
+			Image: imageOrDefault(p.config.PostgresImage, defaultPostgresImage),
+			Env: []corev1.EnvVar{
+				{Name: "POSTGRES_USER", Value: postgresUser},
+				{Name: "POSTGRES_DB", Value: database},
+				{Name: "POSTGRES_PASSWORD", ValueFrom: secretKey(name, "PGPASSWORD")},
+				// The volume's root holds lost+found, which initdb refuses
+				{Name: "PGDATA", Value: "/var/lib/postgresql/data/pgdata"},
+			},
+			VolumeMounts: []corev1.VolumeMount{{Name: dataVolume, MountPath: "/var/lib/postgresql/data"}},
+		}
+	case types.AddOnRedis:
+		password, err := reusePassword(current["REDIS_PASSWORD"])
+		if err != nil {
+			return nil, err
+		}
+		port = redisPort
+		env = map[string]string{
+			"REDIS_URL": (&url.URL{
+				Scheme: "redis",
+				User:   url.UserPassword("default", password),
+				Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+			}).String(),
+			"REDIS_HOST":     host,
+			"REDIS_PORT":     strconv.Itoa(port),
+			"REDIS_PASSWORD": password,
+		}
+		container = corev1.Container{
+			Name:         addon.Kind,
+			Image:        imageOrDefault(p.config.RedisImage, defaultRedisImage),
+			Args:         []string{"--requirepass", "$(REDIS_PASSWORD)", "--appendonly", "yes"},
+			Env:          []corev1.EnvVar{{Name: "REDIS_PASSWORD", ValueFrom: secretKey(name, "REDIS_PASSWORD")}},
+			VolumeMounts: []corev1.VolumeMount{{Name: dataVolume, MountPath: "/data"}},
+		}
+	default:
+		return nil, fmt.Errorf("unknown add-on %q", addon.Kind)
+	}
+	container.Ports = []corev1.ContainerPort{{ContainerPort: int32(port)}}
+	container.ReadinessProbe = &corev1.Probe{
+		ProbeHandler:  corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(int32(port))}},
+		PeriodSeconds: 5,
+	}
+
+	if err := p.createService(ctx, addon, name, port); err != nil {
+		return nil, err
+	}
+	if err := p.createStatefulSet(ctx, addon, name, container); err != nil {
+		return nil, err
+	}
+	return &Instance{Ref: name, Host: host, Env: env}, nil
+}
+
+func (p *KubernetesProvider) Ready(ctx context.Context, addon *types.AddOn) (bool, error) {
+	set, err := p.client.AppsV1().StatefulSets(p.namespace).Get(ctx, addon.Ref, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get statefulset %s: %w", addon.Ref, err)
+	}
+	return set.Status.ReadyReplicas > 0, nil
+}
+
+// Deprovision deletes the StatefulSet and its Service, then its volumes
+// once its pods are gone and no longer hold them, waiting for each step
+func (p *KubernetesProvider) Deprovision(ctx context.Context, addon *types.AddOn) error {
+	name := addon.Ref
+	if name == "" {
+		name = Name(addon.ProjectID, addon.Kind)
+	}
+	sets := p.client.AppsV1().StatefulSets(p.namespace)
+	services := p.client.CoreV1().Services(p.namespace)
+	propagation := metav1.DeletePropagationForeground
+	err := teardown.Delete(ctx, p.deleteTimeout(),
+		teardown.Object{
+			Kind: "statefulset",
+			Name: name,
+			Delete: func(ctx context.Context) error {
+				return sets.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+			},
+			Get: teardown.Getter(func(ctx context.Context) (*appsv1.StatefulSet, error) {
+				return sets.Get(ctx, name, metav1.GetOptions{})
+			}),
+		},
+		teardown.Object{
+			Kind: "service",
+			Name: name,
+			Delete: func(ctx context.Context) error {
+				return services.Delete(ctx, name, metav1.DeleteOptions{})
+			},
+			Get: teardown.Getter(func(ctx context.Context) (*corev1.Service, error) {
+				return services.Get(ctx, name, metav1.GetOptions{})
+			}),
+		})
+	if err != nil {
+		return err
+	}
+
+	// Claims of StatefulSets outlive them
+	claims := p.client.CoreV1().PersistentVolumeClaims(p.namespace)
+	list, err := claims.List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", projectLabel, addon.ProjectID, kindLabel, addon.Kind),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes of %s: %w", name, err)
+	}
+	var volumes []teardown.Object
+	for _, claim := range list.Items {
+		volumes = append(volumes, teardown.Object{
+			Kind: "volume",
+			Name: claim.Name,
+			Delete: func(ctx context.Context) error {
+				return claims.Delete(ctx, claim.Name, metav1.DeleteOptions{})
+			},
+			Get: teardown.Getter(func(ctx context.Context) (*corev1.PersistentVolumeClaim, error) {
+				return claims.Get(ctx, claim.Name, metav1.GetOptions{})
+			}),
+		})
+	}
+	return teardown.Delete(ctx, p.deleteTimeout(), volumes...)
+}
+
+func (p *KubernetesProvider) deleteTimeout() time.Duration {
+	return time.Duration(p.config.DeleteTimeout) * time.Second
+}
+
+func (p *KubernetesProvider) createService(ctx context.Context, addon *types.AddOn, name string, port int) error {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: p.namespace,
+			Labels:    labels(addon.ProjectID, addon.Kind),
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels(addon.ProjectID, addon.Kind),
+			Ports:    []corev1.ServicePort{{Port: int32(port), TargetPort: intstr.FromInt32(int32(port))}},
+		},
+	}
+	_, err := p.client.CoreV1().Services(p.namespace).Create(ctx, service, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service %s: %w", name, err)
+	}
+	return nil
+}
+
+func (p *KubernetesProvider) createStatefulSet(ctx context.Context, addon *types.AddOn, name string, container corev1.Container) error {
+	replicas := int32(1)
+	claim := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   dataVolume,
+			Labels: labels(addon.ProjectID, addon.Kind),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: p.storageSize},
+			},
+		},
+	}
+	if p.config.StorageClass != "" {
+		claim.Spec.StorageClassName = &p.config.StorageClass
+	}
+
+	set := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: p.namespace,
+			Labels:    labels(addon.ProjectID, addon.Kind),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: name,
+			Selector:    &metav1.LabelSelector{MatchLabels: labels(addon.ProjectID, addon.Kind)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels(addon.ProjectID, addon.Kind)},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{container}},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{claim},
+		},
+	}
+	_, err := p.client.AppsV1().StatefulSets(p.namespace).Create(ctx, set, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create statefulset %s: %w", name, err)
+	}
+	return nil
+}
+
+// reusePassword returns the stored password, or a new one on first
+// provisioning
+func reusePassword(stored string) (string, error) {
+	if stored != "" {
+		return stored, nil
+	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func secretKey(secret, key string) *corev1.EnvVarSource {
+	return &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+		Key:                  key,
+	}}
+}
+
+func imageOrDefault(image, fallback string) string {
+	if image == "" {
+		return fallback
+	}
+	return image
+}
@@ -0,0 +1,26 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// ensureAddOns provisions the add-ons a build requests before it is
+// deployed, so their connection settings exist when its pods start
+func (p *Pipeline) ensureAddOns(ctx context.Context, build *types.Build) error {
+	if len(build.AddOns) == 0 {
+		return nil
+	}
+	provisioned, err := p.addons.Ensure(ctx, build.ProjectID, build.AddOns)
+	p.updateBuild(build, func(b *types.Build) {
+		for _, addon := range provisioned {
+			b.AddEvent(types.EventAddOnReady, addon.Kind, "ready at "+addon.Host)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("add-ons unavailable: %w", err)
+	}
+	return nil
+}
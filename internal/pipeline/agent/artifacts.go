@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+)
+
+// maxArtifactSize bounds an uploaded artifact
+const maxArtifactSize = 2 << 30
+
+// ArtifactStore keeps the artifacts agents upload
+type ArtifactStore interface {
+	// Put stores the artifact of a build and returns its local path and
+	// size. An artifact uploaded again replaces the earlier one.
+	Put(ctx context.Context, buildID string, artifact io.Reader) (string, int64, error)
+}
+
+// FileStore keeps artifacts beside those of local builds, where cleanup
+// and project purges find them
+type FileStore struct {
+	layout builder.Layout
+}
+
+func NewFileStore(layout builder.Layout) *FileStore {
+	return &FileStore{layout: layout}
+}
+
+func (s *FileStore) Put(ctx context.Context, buildID string, artifact io.Reader) (string, int64, error) {
+	dir := s.layout.ArtifactDir(buildID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create artifact: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, io.LimitReader(artifact, maxArtifactSize+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write artifact: %w", err)
+	}
+	if size > maxArtifactSize {
+		return "", 0, fmt.Errorf("artifact exceeds %d bytes", int64(maxArtifactSize))
+	}
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+
+	path := s.layout.ArtifactPath(buildID)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, fmt.Errorf("failed to store artifact: %w", err)
+	}
+	return path, size, nil
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// Factory creates builders that run builds on agents
+type Factory struct {
+	registry *Registry
+}
+
+func NewFactory(registry *Registry) *Factory {
+	return &Factory{registry: registry}
+}
+
+// Status reports the agents builds run on
+func (f *Factory) Status() Status {
+	return f.registry.Status()
+}
+
+// CreateBuilder returns a builder for any framework; the agent that runs
+// the build rejects frameworks it cannot build
+func (f *Factory) CreateBuilder(framework string, options *builder.Options) (builder.Builder, error) {
+	return &remoteBuilder{registry: f.registry, environment: options.Environment}, nil
+}
+
+// remoteBuilder hands builds to the registry
+type remoteBuilder struct {
+	registry    *Registry
+	environment map[string]string
+}
+
+func (b *remoteBuilder) Build(ctx context.Context, build *types.Build) (*types.BuildResult, error) {
+	return b.registry.Build(ctx, build, b.environment)
+}
+
+// Validate leaves validation to the agent's builder
+func (b *remoteBuilder) Validate(build *types.Build) error {
+	return nil
+}
+
+// Cleanup has nothing to do, agents clean up after each build
+func (b *remoteBuilder) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/agent"
+)
+
+// TokenHeader carries the shared agent token
+const TokenHeader = "x-agent-token"
+
+// sourceChunkSize is the size of the source pieces sent to agents
+const sourceChunkSize = 256 << 10
+
+// Handler serves the API build agents call. Agents authenticate with the
+// shared token instead of user credentials.
+type Handler struct {
+	pb.UnimplementedAgentsServer
+	registry *Registry
+	token    string
+	log      *zap.Logger
+}
+
+func NewHandler(registry *Registry, token string, log *zap.Logger) *Handler {
+	return &Handler{registry: registry, token: token, log: log}
+}
+
+// authenticate rejects callers without the agent token. Without a
+// configured token, or with agents disabled, every call is rejected.
+func (h *Handler) authenticate(ctx context.Context) error {
+	if !h.registry.Enabled() || h.token == "" {
+		return status.Error(codes.FailedPrecondition, "build agents are disabled")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(TokenHeader)
+	if len(tokens) != 1 || subtle.ConstantTimeCompare([]byte(tokens[0]), []byte(h.token)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid agent token")
+	}
+	return nil
+}
+
+// registryError maps registry errors to gRPC statuses
+func registryError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownAgent):
+		return status.Error(codes.NotFound, "agent is not registered")
+	case errors.Is(err, ErrUnknownJob):
+		return status.Error(codes.NotFound, "build is not assigned to the agent")
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (h *Handler) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	if err := h.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	agent := h.registry.Register(req.Name, req.Labels, int(req.Capacity))
+	return &pb.RegisterResponse{
+		AgentId:                  agent.ID,
+		HeartbeatIntervalSeconds: int64(h.registry.HeartbeatTimeout().Seconds() / 3),
+	}, nil
+}
+
+func (h *Handler) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	if err := h.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	if err := h.registry.Heartbeat(req.AgentId); err != nil {
+		return nil, registryError(err)
+	}
+	return &pb.HeartbeatResponse{}, nil
+}
+
+func (h *Handler) ReceiveWork(req *pb.ReceiveWorkRequest, stream pb.Agents_ReceiveWorkServer) error {
+	ctx := stream.Context()
+	if err := h.authenticate(ctx); err != nil {
+		return err
+	}
+	work, done, err := h.registry.Receive(req.AgentId)
+	if err != nil {
+		return registryError(err)
+	}
+	defer done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case assignment := <-work:
+			err := stream.Send(&pb.WorkAssignment{
+				BuildId:     assignment.BuildID,
+				Cancel:      assignment.Cancel,
+				Build:       assignment.Build,
+				Environment: assignment.Environment,
+			})
+			if err != nil {
+				// The build fails once the agent misses its heartbeats
+				h.log.Warn("failed to send work to agent",
+					zap.String("agent_id", req.AgentId),
+					zap.String("build_id", assignment.BuildID),
+					zap.Error(err))
+				return nil
+			}
+		}
+	}
+}
+
+func (h *Handler) DownloadSource(req *pb.DownloadSourceRequest, stream pb.Agents_DownloadSourceServer) error {
+	if err := h.authenticate(stream.Context()); err != nil {
+		return err
+	}
+	dir, err := h.registry.SourceDir(req.AgentId, req.BuildId)
+	if err != nil {
+		return registryError(err)
+	}
+
+	w := &chunkWriter{send: func(data []byte) error {
+		return stream.Send(&pb.Chunk{Data: data})
+	}}
+	if err := writeSource(w, dir); err != nil {
+		h.log.Error("failed to send sources to agent",
+			zap.String("build_id", req.BuildId),
+			zap.Error(err))
+		return status.Error(codes.Internal, "failed to send sources")
+	}
+	return w.flush()
+}
+
+func (h *Handler) UploadArtifact(stream pb.Agents_UploadArtifactServer) error {
+	ctx := stream.Context()
+	if err := h.authenticate(ctx); err != nil {
+		return err
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "missing upload request")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := pw.Write(first.Data); err != nil {
+			return
+		}
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(req.Data); err != nil {
+				return
+			}
+		}
+	}()
+
+	size, err := h.registry.StoreArtifact(ctx, first.AgentId, first.BuildId, pr)
+	pr.Close()
+	if err != nil {
+		if errors.Is(err, ErrUnknownAgent) || errors.Is(err, ErrUnknownJob) {
+			return registryError(err)
+		}
+		h.log.Error("failed to store artifact",
+			zap.String("build_id", first.BuildId),
+			zap.Error(err))
+		return status.Error(codes.Internal, "failed to store artifact")
+	}
+	return stream.SendAndClose(&pb.UploadArtifactResponse{Size: size})
+}
+
+func (h *Handler) CompleteWork(ctx context.Context, req *pb.CompleteWorkRequest) (*pb.CompleteWorkResponse, error) {
+	if err := h.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	var result *types.BuildResult
+	if req.Error == "" {
+		result = &types.BuildResult{}
+		if err := json.Unmarshal(req.Result, result); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid build result")
+		}
+	}
+	if err := h.registry.Complete(req.AgentId, req.BuildId, result, req.Error); err != nil {
+		return nil, registryError(err)
+	}
+	return &pb.CompleteWorkResponse{}, nil
+}
+
+// chunkWriter buffers writes into messages of sourceChunkSize
+type chunkWriter struct {
+	send func([]byte) error
+	buf  []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= sourceChunkSize {
+		if err := w.send(w.buf[:sourceChunkSize]); err != nil {
+			return 0, err
+		}
+		w.buf = append([]byte(nil), w.buf[sourceChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.send(w.buf)
+	w.buf = nil
+	return err
+}
@@ -0,0 +1,531 @@
+// Package agent runs builds on remote build agents. The control plane keeps
+// a Registry of connected agents and hands builds to those whose labels
+// match; agents run them with the local builders and upload the artifacts.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultHeartbeatTimeout = 30 * time.Second
+	defaultAssignTimeout    = 10 * time.Minute
+)
+
+var (
+	ErrUnknownAgent = errors.New("unknown agent")
+	ErrUnknownJob   = errors.New("build is not assigned to the agent")
+	ErrNoAgent      = errors.New("no matching build agent is available")
+	ErrAgentLost    = errors.New("build agent stopped responding")
+)
+
+// Assignment is a build handed to an agent, or the cancellation of one
+type Assignment struct {
+	BuildID     string
+	Cancel      bool
+	Build       []byte // JSON encoded build
+	Environment map[string]string
+}
+
+// Agent is a connected build agent
+type Agent struct {
+	ID       string
+	Name     string
+	Labels   map[string]string
+	Capacity int
+
+	lastSeen  time.Time
+	idleSince time.Time
+	work      chan Assignment // Nil while the agent is not receiving work
+	jobs      map[string]*job
+}
+
+// AgentStatus is a snapshot of a connected agent
+type AgentStatus struct {
+	ID        string
+	Name      string
+	Labels    map[string]string
+	Capacity  int
+	Running   int
+	IdleSince time.Time // Zero while builds run
+}
+
+// Status is a snapshot of the registry
+type Status struct {
+	Agents []AgentStatus
+	// Waiting holds the requirements of each build waiting for an agent
+	Waiting []map[string]string
+}
+
+// job is a build running on an agent
+type job struct {
+	agent     *Agent
+	sourceDir string
+	artifact  string // Path in the artifact store once uploaded
+	done      chan struct{}
+	result    *types.BuildResult
+	err       error
+}
+
+// Registry tracks the connected agents and the builds they run
+type Registry struct {
+	config    *config.AgentsConfig
+	artifacts ArtifactStore
+	log       *zap.Logger
+
+	mu      sync.Mutex
+	agents  map[string]*Agent
+	jobs    map[string]*job              // By build ID
+	waiting map[string]map[string]string // Requirements of builds waiting for an agent
+	changed chan struct{}                // Closed when an agent may have room for a build
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewRegistry(cfg *config.AgentsConfig, artifacts ArtifactStore, log *zap.Logger) *Registry {
+	return &Registry{
+		config:    cfg,
+		artifacts: artifacts,
+		log:       log,
+		agents:    make(map[string]*Agent),
+		jobs:      make(map[string]*job),
+		waiting:   make(map[string]map[string]string),
+		changed:   make(chan struct{}),
+	}
+}
+
+// Enabled reports whether builds run on agents
+func (r *Registry) Enabled() bool {
+	return r.config.Enabled
+}
+
+// HeartbeatTimeout is how long an agent may go without a heartbeat
+func (r *Registry) HeartbeatTimeout() time.Duration {
+	if r.config.HeartbeatTimeout > 0 {
+		return time.Duration(r.config.HeartbeatTimeout) * time.Second
+	}
+	return defaultHeartbeatTimeout
+}
+
+func (r *Registry) assignTimeout() time.Duration {
+	if r.config.AssignTimeout > 0 {
+		return time.Duration(r.config.AssignTimeout) * time.Second
+	}
+	return defaultAssignTimeout
+}
+
+// signal wakes builds waiting for an agent. Callers hold mu.
+func (r *Registry) signal() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Register adds an agent. Agents that reconnect register again and get a
+// new ID; builds of their earlier registration fail once it expires.
+func (r *Registry) Register(name string, labels map[string]string, capacity int) *Agent {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	now := time.Now()
+	agent := &Agent{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Labels:    labels,
+		Capacity:  capacity,
+		lastSeen:  now,
+		idleSince: now,
+		jobs:      make(map[string]*job),
+	}
+
+	r.mu.Lock()
+	r.agents[agent.ID] = agent
+	r.mu.Unlock()
+
+	r.log.Info("build agent registered",
+		zap.String("agent_id", agent.ID),
+		zap.String("name", name),
+		zap.Any("labels", labels),
+		zap.Int("capacity", capacity))
+	return agent
+}
+
+// Heartbeat records that the agent is alive
+func (r *Registry) Heartbeat(agentID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[agentID]
+	if !ok {
+		return ErrUnknownAgent
+	}
+	agent.lastSeen = time.Now()
+	return nil
+}
+
+// Receive returns the channel the agent's work is sent on until done is
+// called. Builds it already runs keep running.
+func (r *Registry) Receive(agentID string) (<-chan Assignment, func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[agentID]
+	if !ok {
+		return nil, nil, ErrUnknownAgent
+	}
+
+	// Room for an assignment and a cancellation per slot
+	work := make(chan Assignment, 2*agent.Capacity)
+	agent.work = work
+	agent.lastSeen = time.Now()
+	r.signal()
+
+	done := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if agent.work == work {
+			agent.work = nil
+		}
+	}
+	return work, done, nil
+}
+
+// Build runs the build on a matching agent and waits for its result. The
+// build waits for an agent with a free slot for up to the assign timeout.
+func (r *Registry) Build(ctx context.Context, build *types.Build, env map[string]string) (*types.BuildResult, error) {
+	payload, err := json.Marshal(build)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode build: %w", err)
+	}
+	sourceDir, _ := build.BuilderConfig["sourceDir"].(string)
+	j := &job{sourceDir: sourceDir, done: make(chan struct{})}
+
+	timeout := time.NewTimer(r.assignTimeout())
+	defer timeout.Stop()
+	defer func() {
+		r.mu.Lock()
+		delete(r.waiting, build.ID)
+		r.mu.Unlock()
+	}()
+	for {
+		r.mu.Lock()
+		agent := r.pick(build.Requirements)
+		if agent != nil {
+			j.agent = agent
+			agent.jobs[build.ID] = j
+			agent.idleSince = time.Time{}
+			r.jobs[build.ID] = j
+			delete(r.waiting, build.ID)
+			agent.work <- Assignment{BuildID: build.ID, Build: payload, Environment: env}
+		} else {
+			r.waiting[build.ID] = build.Requirements
+		}
+		changed := r.changed
+		r.mu.Unlock()
+
+		if agent != nil {
+			r.log.Info("assigned build to agent",
+				zap.String("build_id", build.ID),
+				zap.String("agent_id", agent.ID),
+				zap.String("agent", agent.Name))
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, fmt.Errorf("%w for requirements %s", ErrNoAgent, formatLabels(build.Requirements))
+		case <-changed:
+		}
+	}
+
+	select {
+	case <-j.done:
+		return j.result, j.err
+	case <-ctx.Done():
+		r.abort(build.ID, j)
+		return nil, ctx.Err()
+	}
+}
+
+// pick returns the least busy receiving agent with a free slot whose
+// labels meet the requirements. Callers hold mu.
+func (r *Registry) pick(requirements map[string]string) *Agent {
+	var best *Agent
+	for _, agent := range r.agents {
+		// A full work channel means the agent is not reading it
+		if agent.work == nil || len(agent.work) == cap(agent.work) ||
+			len(agent.jobs) >= agent.Capacity || !Matches(agent.Labels, requirements) {
+			continue
+		}
+		if best == nil || len(agent.jobs)*best.Capacity < len(best.jobs)*agent.Capacity {
+			best = agent
+		}
+	}
+	return best
+}
+
+// abort tells the agent to stop the build and forgets it
+func (r *Registry) abort(buildID string, j *job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jobs[buildID] != j {
+		return
+	}
+	if j.agent.work != nil {
+		select {
+		case j.agent.work <- Assignment{BuildID: buildID, Cancel: true}:
+		default:
+			// The agent's result is rejected once it finishes
+		}
+	}
+	r.finish(buildID, j)
+}
+
+// finish removes a job and frees its agent's slot. Callers hold mu.
+func (r *Registry) finish(buildID string, j *job) {
+	delete(r.jobs, buildID)
+	delete(j.agent.jobs, buildID)
+	if len(j.agent.jobs) == 0 {
+		j.agent.idleSince = time.Now()
+	}
+	close(j.done)
+	r.signal()
+}
+
+// lookup returns the agent's job of a build. Callers hold mu.
+func (r *Registry) lookup(agentID, buildID string) (*job, error) {
+	if _, ok := r.agents[agentID]; !ok {
+		return nil, ErrUnknownAgent
+	}
+	j, ok := r.jobs[buildID]
+	if !ok || j.agent.ID != agentID {
+		return nil, ErrUnknownJob
+	}
+	return j, nil
+}
+
+// SourceDir returns the sources of a build assigned to the agent
+func (r *Registry) SourceDir(agentID, buildID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, err := r.lookup(agentID, buildID)
+	if err != nil {
+		return "", err
+	}
+	return j.sourceDir, nil
+}
+
+// StoreArtifact saves the artifact of a build assigned to the agent
+func (r *Registry) StoreArtifact(ctx context.Context, agentID, buildID string, artifact io.Reader) (int64, error) {
+	r.mu.Lock()
+	_, err := r.lookup(agentID, buildID)
+	r.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	path, size, err := r.artifacts.Put(ctx, buildID, artifact)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, err := r.lookup(agentID, buildID)
+	if err != nil {
+		return 0, err
+	}
+	j.artifact = path
+	return size, nil
+}
+
+// Complete records the outcome the agent reported for a build. A
+// successful build must have uploaded its artifact first.
+func (r *Registry) Complete(agentID, buildID string, result *types.BuildResult, buildErr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, err := r.lookup(agentID, buildID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case buildErr != "":
+		j.err = errors.New(buildErr)
+	case j.artifact == "":
+		j.err = errors.New("build agent reported success without uploading an artifact")
+	case result == nil:
+		j.err = errors.New("build agent reported no result")
+	default:
+		result.ArtifactPath = j.artifact
+		j.result = result
+	}
+	r.finish(buildID, j)
+	return nil
+}
+
+// Expire drops agents that missed their heartbeats and fails their builds
+func (r *Registry) Expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, agent := range r.agents {
+		if now.Sub(agent.lastSeen) <= r.HeartbeatTimeout() {
+			continue
+		}
+		for buildID, j := range agent.jobs {
+			j.err = fmt.Errorf("%w: %s", ErrAgentLost, agent.Name)
+			r.finish(buildID, j)
+		}
+		delete(r.agents, id)
+		r.log.Warn("build agent expired",
+			zap.String("agent_id", id),
+			zap.String("name", agent.Name))
+	}
+}
+
+// Status returns the connected agents and the builds waiting for one
+func (r *Registry) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		Agents:  make([]AgentStatus, 0, len(r.agents)),
+		Waiting: make([]map[string]string, 0, len(r.waiting)),
+	}
+	for _, agent := range r.agents {
+		status.Agents = append(status.Agents, AgentStatus{
+			ID:        agent.ID,
+			Name:      agent.Name,
+			Labels:    agent.Labels,
+			Capacity:  agent.Capacity,
+			Running:   len(agent.jobs),
+			IdleSince: agent.idleSince,
+		})
+	}
+	sort.Slice(status.Agents, func(i, j int) bool { return status.Agents[i].Name < status.Agents[j].Name })
+	for _, requirements := range r.waiting {
+		status.Waiting = append(status.Waiting, requirements)
+	}
+	return status
+}
+
+// Retire removes an agent that has been idle for at least idleFor, so it
+// gets no more builds before it is shut down. It reports whether the agent
+// was removed.
+func (r *Registry) Retire(agentID string, idleFor time.Duration, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[agentID]
+	if !ok || len(agent.jobs) > 0 || now.Sub(agent.idleSince) < idleFor {
+		return false
+	}
+	delete(r.agents, agentID)
+	r.log.Info("build agent retired",
+		zap.String("agent_id", agentID),
+		zap.String("name", agent.Name))
+	return true
+}
+
+// Start expires silent agents in the background
+func (r *Registry) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.HeartbeatTimeout() / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				r.Expire(now)
+			}
+		}
+	}()
+}
+
+func (r *Registry) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+// Matches reports whether labels meet every requirement. A requirement is
+// met by an equal label, or for values starting with ">=" by a label of at
+// least that dotted version, e.g. docker=">=24".
+func Matches(labels, requirements map[string]string) bool {
+	for key, want := range requirements {
+		have, ok := labels[key]
+		if !ok {
+			return false
+		}
+		if min, ok := strings.CutPrefix(want, ">="); ok {
+			if compareVersions(have, strings.TrimSpace(min)) < 0 {
+				return false
+			}
+		} else if have != want {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersions compares dotted versions numerically by the leading
+// digits of each part, missing parts count as 0
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := versionPart(as, i), versionPart(bs, i)
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	digits := parts[i]
+	if end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+		digits = digits[:end]
+	}
+	n, _ := strconv.Atoi(digits)
+	return n
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "none"
+	}
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
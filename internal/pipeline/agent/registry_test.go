@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestMatches(t *testing.T) {
+	labels := map[string]string{"arch": "arm64", "docker": "27.5.1"}
+
+	tests := []struct {
+		name         string
+		requirements map[string]string
+		want         bool
+	}{
+		{name: "no requirements", want: true},
+		{name: "equal label", requirements: map[string]string{"arch": "arm64"}, want: true},
+		{name: "different label", requirements: map[string]string{"arch": "amd64"}},
+		{name: "missing label", requirements: map[string]string{"gpu": "true"}},
+		{name: "newer version", requirements: map[string]string{"docker": ">=24"}, want: true},
+		{name: "same version", requirements: map[string]string{"docker": ">= 27.5.1"}, want: true},
+		{name: "older version", requirements: map[string]string{"docker": ">=27.10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Matches(labels, tt.requirements))
+		})
+	}
+}
+
+type buildOutcome struct {
+	result *types.BuildResult
+	err    error
+}
+
+func newTestRegistry(t *testing.T) *Registry {
+	return NewRegistry(&config.AgentsConfig{Enabled: true, AssignTimeout: 1},
+		NewFileStore(builder.Layout{ArtifactsDir: t.TempDir()}), zap.NewNop())
+}
+
+// startBuild runs the build on the registry in the background
+func startBuild(r *Registry, build *types.Build, env map[string]string) <-chan buildOutcome {
+	outcome := make(chan buildOutcome, 1)
+	go func() {
+		result, err := r.Build(context.Background(), build, env)
+		outcome <- buildOutcome{result, err}
+	}()
+	return outcome
+}
+
+func receive(t *testing.T, work <-chan Assignment) Assignment {
+	t.Helper()
+	select {
+	case assignment := <-work:
+		return assignment
+	case <-time.After(time.Second):
+		t.Fatal("build was not assigned")
+		return Assignment{}
+	}
+}
+
+func TestRegistry_AssignsBuildsToMatchingAgents(t *testing.T) {
+	r := newTestRegistry(t)
+	amd := r.Register("amd", map[string]string{"arch": "amd64"}, 1)
+	arm := r.Register("arm", map[string]string{"arch": "arm64"}, 1)
+	amdWork, _, err := r.Receive(amd.ID)
+	require.NoError(t, err)
+	armWork, _, err := r.Receive(arm.ID)
+	require.NoError(t, err)
+
+	build := &types.Build{ID: "build-1", Requirements: map[string]string{"arch": "arm64"}}
+	outcome := startBuild(r, build, map[string]string{"API_URL": "https://api"})
+
+	assignment := receive(t, armWork)
+	assert.Equal(t, "build-1", assignment.BuildID)
+	assert.Equal(t, "https://api", assignment.Environment["API_URL"])
+	assert.Empty(t, amdWork)
+
+	assert.ErrorIs(t, r.Complete(amd.ID, "build-1", nil, "failed"), ErrUnknownJob)
+	require.NoError(t, r.Complete(arm.ID, "build-1", nil, "npm run build exited with 1"))
+	assert.EqualError(t, (<-outcome).err, "npm run build exited with 1")
+
+	// Nothing matches, the build gives up after the assign timeout
+	build = &types.Build{ID: "build-2", Requirements: map[string]string{"arch": "riscv64"}}
+	assert.ErrorIs(t, (<-startBuild(r, build, nil)).err, ErrNoAgent)
+}
+
+func TestRegistry_RequiresUploadedArtifact(t *testing.T) {
+	r := newTestRegistry(t)
+	agent := r.Register("agent", nil, 1)
+	work, _, err := r.Receive(agent.ID)
+	require.NoError(t, err)
+
+	outcome := startBuild(r, &types.Build{ID: "build-1"}, nil)
+	receive(t, work)
+	require.NoError(t, r.Complete(agent.ID, "build-1", &types.BuildResult{Success: true}, ""))
+	assert.ErrorContains(t, (<-outcome).err, "without uploading an artifact")
+
+	outcome = startBuild(r, &types.Build{ID: "build-2"}, nil)
+	receive(t, work)
+	size, err := r.StoreArtifact(context.Background(), agent.ID, "build-2", strings.NewReader("artifact"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), size)
+	require.NoError(t, r.Complete(agent.ID, "build-2", &types.BuildResult{Success: true, ImageID: "sha256:abc"}, ""))
+
+	result := <-outcome
+	require.NoError(t, result.err)
+	assert.Equal(t, "sha256:abc", result.result.ImageID)
+	data, err := os.ReadFile(result.result.ArtifactPath)
+	require.NoError(t, err)
+	assert.Equal(t, "artifact", string(data))
+}
+
+func TestRegistry_FailsBuildsOfExpiredAgents(t *testing.T) {
+	r := newTestRegistry(t)
+	agent := r.Register("agent", nil, 1)
+	work, _, err := r.Receive(agent.ID)
+	require.NoError(t, err)
+
+	outcome := startBuild(r, &types.Build{ID: "build-1"}, nil)
+	receive(t, work)
+
+	r.Expire(time.Now())
+	assert.Empty(t, outcome, "agent is still within its heartbeat timeout")
+
+	r.Expire(time.Now().Add(time.Minute))
+	assert.ErrorIs(t, (<-outcome).err, ErrAgentLost)
+	assert.ErrorIs(t, r.Heartbeat(agent.ID), ErrUnknownAgent)
+}
+
+func TestRegistry_CancelsAbandonedBuilds(t *testing.T) {
+	r := newTestRegistry(t)
+	agent := r.Register("agent", nil, 1)
+	work, _, err := r.Receive(agent.ID)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.Build(ctx, &types.Build{ID: "build-1"}, nil)
+		errs <- err
+	}()
+	receive(t, work)
+
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	assignment := receive(t, work)
+	assert.Equal(t, Assignment{BuildID: "build-1", Cancel: true}, assignment)
+	assert.ErrorIs(t, r.Complete(agent.ID, "build-1", nil, "cancelled"), ErrUnknownJob)
+}
+
+func TestSourceRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "src", "components"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "package.json"), []byte(`{"name":"app"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "src", "components", "App.jsx"), []byte("export default App"), 0644))
+	require.NoError(t, os.Symlink("/etc/passwd", filepath.Join(src, "passwd")))
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSource(&buf, src))
+
+	dst := filepath.Join(t.TempDir(), "source")
+	require.NoError(t, extractSource(&buf, dst))
+
+	data, err := os.ReadFile(filepath.Join(dst, "src", "components", "App.jsx"))
+	require.NoError(t, err)
+	assert.Equal(t, "export default App", string(data))
+	assert.FileExists(t, filepath.Join(dst, "package.json"))
+	assert.NoFileExists(t, filepath.Join(dst, "passwd"), "symlinks are not sent")
+}
+
+func TestRegistry_RetiresIdleAgents(t *testing.T) {
+	r := newTestRegistry(t)
+	agent := r.Register("agent", nil, 1)
+	work, _, err := r.Receive(agent.ID)
+	require.NoError(t, err)
+
+	outcome := startBuild(r, &types.Build{ID: "build-1"}, nil)
+	receive(t, work)
+	status := r.Status()
+	require.Len(t, status.Agents, 1)
+	assert.Equal(t, 1, status.Agents[0].Running)
+	assert.False(t, r.Retire(agent.ID, 0, time.Now()), "busy agents are kept")
+
+	require.NoError(t, r.Complete(agent.ID, "build-1", nil, "failed"))
+	<-outcome
+	assert.False(t, r.Retire(agent.ID, time.Minute, time.Now()), "agent has not been idle long enough")
+	assert.True(t, r.Retire(agent.ID, time.Minute, time.Now().Add(time.Hour)))
+	assert.Empty(t, r.Status().Agents)
+
+	// Builds wait for an agent until one registers
+	outcome = startBuild(r, &types.Build{ID: "build-2", Requirements: map[string]string{"arch": "arm64"}}, nil)
+	assert.Eventually(t, func() bool { return len(r.Status().Waiting) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]string{"arch": "arm64"}, r.Status().Waiting[0])
+	<-outcome
+	assert.Empty(t, r.Status().Waiting)
+}
@@ -0,0 +1,339 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/agent"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// WorkerConfig describes the agent a Worker registers as
+type WorkerConfig struct {
+	Name     string
+	Token    string
+	Labels   map[string]string
+	Capacity int
+	WorkDir  string // Sources and build output, one directory per build
+}
+
+// Worker is the agent side: it registers with the control plane, runs
+// the builds it is assigned with the local builders and reports back
+type Worker struct {
+	client  pb.AgentsClient
+	factory builder.FactoryInterface
+	config  WorkerConfig
+	log     *zap.Logger
+}
+
+func NewWorker(conn grpc.ClientConnInterface, factory builder.FactoryInterface, cfg WorkerConfig, log *zap.Logger) *Worker {
+	return &Worker{
+		client:  pb.NewAgentsClient(conn),
+		factory: factory,
+		config:  cfg,
+		log:     log,
+	}
+}
+
+// Run serves builds until ctx is cancelled, registering again with
+// backoff whenever the connection to the control plane is lost
+func (w *Worker) Run(ctx context.Context) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, TokenHeader, w.config.Token)
+
+	delay := minReconnectDelay
+	for {
+		started := time.Now()
+		err := w.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if status.Code(err) == codes.Unauthenticated || status.Code(err) == codes.FailedPrecondition {
+			return err
+		}
+		if time.Since(started) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		w.log.Warn("lost connection to control plane, reconnecting",
+			zap.Duration("delay", delay),
+			zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// session registers once and runs builds until the work stream or the
+// heartbeats fail. Builds of the session are cancelled when it ends, the
+// control plane fails them once the registration expires.
+func (w *Worker) session(ctx context.Context) error {
+	reg, err := w.client.Register(ctx, &pb.RegisterRequest{
+		Name:     w.config.Name,
+		Labels:   w.config.Labels,
+		Capacity: int32(w.config.Capacity),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register: %w", err)
+	}
+	w.log.Info("registered with control plane", zap.String("agent_id", reg.AgentId))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var builds sync.WaitGroup
+	defer builds.Wait()
+
+	heartbeatErr := make(chan error, 1)
+	go func() {
+		heartbeatErr <- w.heartbeat(ctx, reg.AgentId, time.Duration(reg.HeartbeatIntervalSeconds)*time.Second)
+		cancel()
+	}()
+
+	stream, err := w.client.ReceiveWork(ctx, &pb.ReceiveWorkRequest{AgentId: reg.AgentId})
+	if err != nil {
+		return fmt.Errorf("failed to receive work: %w", err)
+	}
+
+	var mu sync.Mutex
+	running := make(map[string]context.CancelFunc)
+	for {
+		assignment, err := stream.Recv()
+		if err != nil {
+			select {
+			case hbErr := <-heartbeatErr:
+				if hbErr != nil {
+					return hbErr
+				}
+			default:
+			}
+			if err == io.EOF {
+				return errors.New("control plane closed the work stream")
+			}
+			return err
+		}
+
+		if assignment.Cancel {
+			mu.Lock()
+			if cancelBuild, ok := running[assignment.BuildId]; ok {
+				cancelBuild()
+			}
+			mu.Unlock()
+			continue
+		}
+
+		buildCtx, cancelBuild := context.WithCancel(ctx)
+		mu.Lock()
+		running[assignment.BuildId] = cancelBuild
+		mu.Unlock()
+
+		builds.Add(1)
+		go func() {
+			defer builds.Done()
+			defer func() {
+				mu.Lock()
+				delete(running, assignment.BuildId)
+				mu.Unlock()
+				cancelBuild()
+			}()
+			w.run(buildCtx, reg.AgentId, assignment)
+		}()
+	}
+}
+
+// heartbeat keeps the registration alive until ctx is done or the control
+// plane no longer knows the agent
+func (w *Worker) heartbeat(ctx context.Context, agentID string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultHeartbeatTimeout / 3
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			_, err := w.client.Heartbeat(ctx, &pb.HeartbeatRequest{AgentId: agentID})
+			if status.Code(err) == codes.NotFound {
+				return fmt.Errorf("registration expired: %w", err)
+			}
+			if err != nil && ctx.Err() == nil {
+				w.log.Warn("heartbeat failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// run builds an assignment and reports its outcome
+func (w *Worker) run(ctx context.Context, agentID string, assignment *pb.WorkAssignment) {
+	log := w.log.With(zap.String("build_id", assignment.BuildId))
+	log.Info("starting build")
+
+	dir := filepath.Join(w.config.WorkDir, assignment.BuildId)
+	defer os.RemoveAll(dir)
+
+	result, err := w.build(ctx, agentID, assignment, dir)
+
+	req := &pb.CompleteWorkRequest{AgentId: agentID, BuildId: assignment.BuildId}
+	if err != nil {
+		log.Error("build failed", zap.Error(err))
+		req.Error = err.Error()
+	} else {
+		log.Info("build completed")
+		// The result's error does not survive encoding and is nil on success
+		result.Error = nil
+		if req.Result, err = json.Marshal(result); err != nil {
+			req.Error = fmt.Sprintf("failed to encode build result: %v", err)
+		}
+	}
+
+	if _, err := w.client.CompleteWork(ctx, req); err != nil && ctx.Err() == nil {
+		log.Error("failed to report build result", zap.Error(err))
+	}
+}
+
+// build fetches the sources, runs the local builder and uploads the
+// artifact
+func (w *Worker) build(ctx context.Context, agentID string, assignment *pb.WorkAssignment, dir string) (*types.BuildResult, error) {
+	build := &types.Build{}
+	if err := json.Unmarshal(assignment.Build, build); err != nil {
+		return nil, fmt.Errorf("invalid build: %w", err)
+	}
+
+	// Stages are timed here and recorded by the server with the result
+	timer := &types.StageTimer{}
+	ctx = types.WithStageTimer(ctx, timer)
+
+	sourceDir := filepath.Join(dir, "source")
+	err := types.TimeStage(ctx, types.StageFetch, func() error {
+		return w.downloadSource(ctx, agentID, build.ID, sourceDir)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if build.BuilderConfig == nil {
+		build.BuilderConfig = make(map[string]interface{})
+	}
+	build.BuilderConfig["sourceDir"] = sourceDir
+	ctx = buildlog.WithBuild(ctx, build)
+
+	b, err := w.factory.CreateBuilder(build.Framework, &builder.Options{
+		WorkDir:     filepath.Join(dir, "work"),
+		ArtifactDir: filepath.Join(dir, "artifacts"),
+		CacheDir:    filepath.Join(dir, "cache"),
+		Environment: assignment.Environment,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create builder: %w", err)
+	}
+	defer func() {
+		if err := b.Cleanup(); err != nil {
+			buildlog.Logger(ctx, w.log).Error("cleanup failed", zap.Error(err))
+		}
+	}()
+
+	if err := b.Validate(build); err != nil {
+		return nil, err
+	}
+	result, err := b.Build(ctx, build)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.uploadArtifact(ctx, agentID, build.ID, result.ArtifactPath); err != nil {
+		return nil, err
+	}
+	result.Stages = timer.Stages()
+	return result, nil
+}
+
+func (w *Worker) downloadSource(ctx context.Context, agentID, buildID, dir string) error {
+	stream, err := w.client.DownloadSource(ctx, &pb.DownloadSourceRequest{AgentId: agentID, BuildId: buildID})
+	if err != nil {
+		return fmt.Errorf("failed to download sources: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(chunk.Data); err != nil {
+				return
+			}
+		}
+	}()
+	defer pr.Close()
+
+	if err := extractSource(pr, dir); err != nil {
+		return fmt.Errorf("failed to download sources: %w", err)
+	}
+	return nil
+}
+
+func (w *Worker) uploadArtifact(ctx context.Context, agentID, buildID, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open artifact: %w", err)
+	}
+	defer f.Close()
+
+	stream, err := w.client.UploadArtifact(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to upload artifact: %w", err)
+	}
+
+	req := &pb.UploadArtifactRequest{AgentId: agentID, BuildId: buildID}
+	buf := make([]byte, sourceChunkSize)
+	for {
+		n, readErr := f.Read(buf)
+		if n > 0 || req.AgentId != "" {
+			req.Data = buf[:n]
+			if err := stream.Send(req); err != nil {
+				return fmt.Errorf("failed to upload artifact: %w", err)
+			}
+			req = &pb.UploadArtifactRequest{}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read artifact: %w", readErr)
+		}
+	}
+
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return fmt.Errorf("failed to upload artifact: %w", err)
+	}
+	return nil
+}
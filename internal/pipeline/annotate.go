@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// ErrInvalidAnnotation is returned for notes and labels that cannot be
+// attached to a build
+var ErrInvalidAnnotation = errors.New("invalid annotation")
+
+// AnnotateBuild replaces the note and labels of a build. Labels are
+// lowercased and deduplicated.
+func (p *Pipeline) AnnotateBuild(ctx context.Context, buildID, note string, labels []string) error {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > types.MaxNoteLength {
+		return fmt.Errorf("%w: note is longer than %d characters", ErrInvalidAnnotation, types.MaxNoteLength)
+	}
+	var normalized []string
+	for _, label := range labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if !types.ValidLabel(label) {
+			return fmt.Errorf("%w: label %q must be up to 32 lowercase letters, digits, '.', '_' or '-'", ErrInvalidAnnotation, label)
+		}
+		if !slices.Contains(normalized, label) {
+			normalized = append(normalized, label)
+		}
+	}
+	if len(normalized) > types.MaxLabels {
+		return fmt.Errorf("%w: at most %d labels are allowed", ErrInvalidAnnotation, types.MaxLabels)
+	}
+
+	p.mu.Lock()
+	build, inMemory := p.builds[buildID]
+	if inMemory {
+		build.Note = note
+		build.Labels = normalized
+	}
+	p.mu.Unlock()
+
+	if inMemory {
+		p.persist(build)
+		return nil
+	}
+	if p.store == nil {
+		return fmt.Errorf("%w: %s", types.ErrBuildNotFound, buildID)
+	}
+	return p.store.SetAnnotation(ctx, buildID, note, normalized)
+}
+
+// hasLabels reports whether build carries every one of labels
+func hasLabels(build *types.Build, labels []string) bool {
+	for _, label := range labels {
+		if !slices.Contains(build.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// checkPolicy evaluates the deploy policy for build and records the
+// results on it. Builds outside the policy's environments pass.
+func (p *Pipeline) checkPolicy(ctx context.Context, build *types.Build, preview bool) error {
+	if p.policy == nil || !p.policy.Applies(build) {
+		return nil
+	}
+
+	p.mu.RLock()
+	snapshot := *build
+	p.mu.RUnlock()
+
+	results, err := p.policy.Evaluate(ctx, &snapshot, preview)
+	p.updateBuild(build, func(b *types.Build) {
+		b.Vulnerabilities = snapshot.Vulnerabilities
+		b.PolicyResults = results
+	})
+	p.persist(build)
+
+	if err != nil {
+		return fmt.Errorf("refusing to deploy: %w", err)
+	}
+	return nil
+}
+
+// ApproveBuild records user's approval of a previewed build awaiting
+// promotion. Approving twice has no further effect.
+func (p *Pipeline) ApproveBuild(ctx context.Context, buildID, user string) error {
+	p.mu.Lock()
+	build, exists := p.builds[buildID]
+	if !exists {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", types.ErrBuildNotFound, buildID)
+	}
+	if build.Status != types.BuildStatusSuccess || !build.Preview.Active() || build.Preview.PromotedAt != nil {
+		p.mu.Unlock()
+		return ErrNotPromotable
+	}
+	for _, approval := range build.Approvals {
+		if approval.User == user {
+			p.mu.Unlock()
+			return nil
+		}
+	}
+	build.Approvals = append(build.Approvals, types.Approval{User: user, Time: time.Now()})
+	build.AddEvent(types.EventApproved, "", "approved by "+user)
+	p.mu.Unlock()
+
+	p.persist(build)
+	return nil
+}
@@ -0,0 +1,334 @@
+// Package autoscale launches build agents while builds wait for one and
+// shuts down agents that stay idle, on Kubernetes, EC2 or Hetzner Cloud.
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const (
+	defaultMaxAgents      = 10
+	defaultIdleCooldown   = 10 * time.Minute
+	defaultStartupTimeout = 5 * time.Minute
+	defaultInterval       = 30 * time.Second
+
+	// auditActor records scaling in the audit log
+	auditActor = "autoscaler"
+)
+
+// Instance is a machine or job running an agent
+type Instance struct {
+	ID   string // Provider ID used to terminate it
+	Name string // Name its agent registers with
+}
+
+// Provider launches and terminates agent instances
+type Provider interface {
+	// Instances lists the running or starting instances
+	Instances(ctx context.Context) ([]Instance, error)
+	Launch(ctx context.Context, count int) error
+	Terminate(ctx context.Context, instance Instance) error
+}
+
+// Registry is the part of the agent registry scaling looks at
+type Registry interface {
+	Status() agent.Status
+	Retire(agentID string, idleFor time.Duration, now time.Time) bool
+}
+
+// AuditRecorder stores audit entries for scaling actions
+type AuditRecorder interface {
+	Record(actor, action, resource string, details map[string]interface{}) error
+}
+
+// NewProvider returns the configured provider
+func NewProvider(cfg *config.AutoscaleConfig, deploy *config.DeployConfig) (Provider, error) {
+	switch cfg.Provider {
+	case "kubernetes":
+		return NewKubernetesProvider(&cfg.Kubernetes, cfg, deploy.Namespace)
+	case "ec2":
+		return NewEC2Provider(&cfg.EC2)
+	case "hetzner":
+		return NewHetznerProvider(&cfg.Hetzner)
+	default:
+		return nil, fmt.Errorf("unsupported autoscale provider: %s", cfg.Provider)
+	}
+}
+
+// instanceName names an instance its agent registers as
+func instanceName() string {
+	return "chef-agent-" + uuid.NewString()[:8]
+}
+
+// Autoscaler periodically sizes the agent pool to the waiting builds
+type Autoscaler struct {
+	config   *config.AutoscaleConfig
+	provider Provider
+	registry Registry
+	auditor  AuditRecorder
+	log      *zap.Logger
+
+	mu        sync.Mutex
+	firstSeen map[string]time.Time // By instance name, for starting instances
+	events    map[scaleEvent]int
+	instances int
+	waiting   int
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// scaleEvent keys the scaling metrics
+type scaleEvent struct {
+	action string // "launch" or "terminate"
+	result string // "succeeded" or "failed"
+}
+
+func NewAutoscaler(cfg *config.AutoscaleConfig, provider Provider, registry Registry, auditor AuditRecorder, log *zap.Logger) *Autoscaler {
+	return &Autoscaler{
+		config:    cfg,
+		provider:  provider,
+		registry:  registry,
+		auditor:   auditor,
+		log:       log,
+		firstSeen: make(map[string]time.Time),
+		events:    make(map[scaleEvent]int),
+	}
+}
+
+// Enabled reports whether agents are scaled, which needs a provider
+func (a *Autoscaler) Enabled() bool {
+	return a.provider != nil
+}
+
+func (a *Autoscaler) maxAgents() int {
+	if a.config.MaxAgents > 0 {
+		return a.config.MaxAgents
+	}
+	return defaultMaxAgents
+}
+
+func (a *Autoscaler) capacity() int {
+	if a.config.Capacity > 0 {
+		return a.config.Capacity
+	}
+	return 1
+}
+
+func seconds(value int, fallback time.Duration) time.Duration {
+	if value > 0 {
+		return time.Duration(value) * time.Second
+	}
+	return fallback
+}
+
+// Scale launches agents for the builds waiting beyond the queue threshold
+// and shuts down agents idle past the cooldown, keeping the pool between
+// min_agents and max_agents
+func (a *Autoscaler) Scale(ctx context.Context, now time.Time) error {
+	instances, err := a.provider.Instances(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list agent instances: %w", err)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	status := a.registry.Status()
+
+	registered := make(map[string]agent.AgentStatus, len(status.Agents))
+	for _, st := range status.Agents {
+		registered[st.Name] = st
+	}
+
+	// Instances that have not registered yet are starting until the
+	// startup timeout; their capacity already covers waiting builds
+	startupTimeout := seconds(a.config.StartupTimeout, defaultStartupTimeout)
+	starting := 0
+	seen := make(map[string]time.Time, len(instances))
+	a.mu.Lock()
+	for _, instance := range instances {
+		first, ok := a.firstSeen[instance.Name]
+		if !ok {
+			first = now
+		}
+		seen[instance.Name] = first
+		if _, ok := registered[instance.Name]; !ok && now.Sub(first) < startupTimeout {
+			starting++
+		}
+	}
+	a.firstSeen = seen
+	a.mu.Unlock()
+
+	waiting := 0
+	for _, requirements := range status.Waiting {
+		if agent.Matches(a.config.Labels, requirements) {
+			waiting++
+		}
+	}
+
+	launch := 0
+	if waiting > a.config.QueueThreshold {
+		capacity := a.capacity()
+		uncovered := waiting - starting*capacity
+		launch = (uncovered + capacity - 1) / capacity
+	}
+	launch = max(launch, a.config.MinAgents-len(instances))
+	launch = min(launch, a.maxAgents()-len(instances))
+
+	total := len(instances)
+	if launch > 0 {
+		if err := a.launch(ctx, launch, waiting); err != nil {
+			return err
+		}
+		total += launch
+	}
+
+	// Only agents of the provider's instances are shut down
+	cooldown := seconds(a.config.IdleCooldown, defaultIdleCooldown)
+	for _, instance := range instances {
+		if total <= a.config.MinAgents {
+			break
+		}
+		st, ok := registered[instance.Name]
+		if !ok || !a.registry.Retire(st.ID, cooldown, now) {
+			continue
+		}
+		if err := a.terminate(ctx, instance, now.Sub(st.IdleSince)); err != nil {
+			a.log.Error("failed to terminate agent", zap.String("instance", instance.Name), zap.Error(err))
+			continue
+		}
+		total--
+	}
+
+	a.mu.Lock()
+	a.instances = total
+	a.waiting = waiting
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Autoscaler) launch(ctx context.Context, count, waiting int) error {
+	err := a.provider.Launch(ctx, count)
+	a.record("launch", err, count)
+	details := map[string]interface{}{"count": count, "waiting_builds": waiting}
+	if err != nil {
+		details["error"] = err.Error()
+		a.audit("agent.launch", a.config.Provider, details)
+		return fmt.Errorf("failed to launch agents: %w", err)
+	}
+
+	a.log.Info("launched build agents",
+		zap.Int("count", count),
+		zap.Int("waiting_builds", waiting))
+	a.audit("agent.launch", a.config.Provider, details)
+	return nil
+}
+
+func (a *Autoscaler) terminate(ctx context.Context, instance Instance, idle time.Duration) error {
+	err := a.provider.Terminate(ctx, instance)
+	a.record("terminate", err, 1)
+	details := map[string]interface{}{"instance_id": instance.ID, "idle_seconds": int(idle.Seconds())}
+	if err != nil {
+		details["error"] = err.Error()
+	} else {
+		a.log.Info("terminated idle build agent",
+			zap.String("instance", instance.Name),
+			zap.Duration("idle", idle))
+	}
+	a.audit("agent.terminate", instance.Name, details)
+	return err
+}
+
+func (a *Autoscaler) record(action string, err error, count int) {
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	a.mu.Lock()
+	a.events[scaleEvent{action, result}] += count
+	a.mu.Unlock()
+}
+
+func (a *Autoscaler) audit(action, resource string, details map[string]interface{}) {
+	if a.auditor == nil {
+		return
+	}
+	if err := a.auditor.Record(auditActor, action, resource, details); err != nil {
+		a.log.Error("failed to record audit entry",
+			zap.String("action", action),
+			zap.String("resource", resource),
+			zap.Error(err))
+	}
+}
+
+// WriteMetrics writes scaling metrics in the Prometheus text exposition
+// format
+func (a *Autoscaler) WriteMetrics(w io.Writer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP chef_agent_instances Build agent instances launched by the autoscaler.\n# TYPE chef_agent_instances gauge\n")
+	fmt.Fprintf(w, "chef_agent_instances %d\n", a.instances)
+
+	fmt.Fprintf(w, "# HELP chef_agent_waiting_builds Builds waiting for an agent the autoscaler can launch.\n# TYPE chef_agent_waiting_builds gauge\n")
+	fmt.Fprintf(w, "chef_agent_waiting_builds %d\n", a.waiting)
+
+	events := make([]scaleEvent, 0, len(a.events))
+	for event := range a.events {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].action != events[j].action {
+			return events[i].action < events[j].action
+		}
+		return events[i].result < events[j].result
+	})
+	fmt.Fprintf(w, "# HELP chef_agent_scaling_total Agents launched and terminated by result.\n# TYPE chef_agent_scaling_total counter\n")
+	for _, event := range events {
+		fmt.Fprintf(w, "chef_agent_scaling_total{provider=\"%s\",action=\"%s\",result=\"%s\"} %d\n",
+			a.config.Provider, event.action, event.result, a.events[event])
+	}
+}
+
+// Start scales the pool in the background until Stop is called
+func (a *Autoscaler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.done = make(chan struct{})
+
+	go func() {
+		defer close(a.done)
+
+		ticker := time.NewTicker(seconds(a.config.Interval, defaultInterval))
+		defer ticker.Stop()
+
+		for {
+			if err := a.Scale(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				a.log.Error("agent autoscaling failed", zap.Error(err))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (a *Autoscaler) Stop() {
+	if a.cancel == nil {
+		return
+	}
+	a.cancel()
+	<-a.done
+}
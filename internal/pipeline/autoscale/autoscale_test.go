@@ -0,0 +1,172 @@
+package autoscale
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+type fakeProvider struct {
+	instances  []Instance
+	launched   int
+	terminated []string
+	launchErr  error
+}
+
+func (p *fakeProvider) Instances(ctx context.Context) ([]Instance, error) {
+	return append([]Instance(nil), p.instances...), nil
+}
+
+func (p *fakeProvider) Launch(ctx context.Context, count int) error {
+	if p.launchErr != nil {
+		return p.launchErr
+	}
+	for i := 0; i < count; i++ {
+		p.launched++
+		name := fmt.Sprintf("agent-%d", p.launched)
+		p.instances = append(p.instances, Instance{ID: name, Name: name})
+	}
+	return nil
+}
+
+func (p *fakeProvider) Terminate(ctx context.Context, instance Instance) error {
+	p.terminated = append(p.terminated, instance.Name)
+	for i, existing := range p.instances {
+		if existing.ID == instance.ID {
+			p.instances = append(p.instances[:i], p.instances[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type fakeRegistry struct {
+	status  agent.Status
+	retired []string
+}
+
+func (r *fakeRegistry) Status() agent.Status {
+	return r.status
+}
+
+func (r *fakeRegistry) Retire(agentID string, idleFor time.Duration, now time.Time) bool {
+	for _, st := range r.status.Agents {
+		if st.ID == agentID && st.Running == 0 && now.Sub(st.IdleSince) >= idleFor {
+			r.retired = append(r.retired, agentID)
+			return true
+		}
+	}
+	return false
+}
+
+type recordingAuditor struct {
+	actions []string
+}
+
+func (a *recordingAuditor) Record(actor, action, resource string, details map[string]interface{}) error {
+	a.actions = append(a.actions, actor+" "+action+" "+resource)
+	return nil
+}
+
+func waiting(n int, requirements map[string]string) []map[string]string {
+	builds := make([]map[string]string, n)
+	for i := range builds {
+		builds[i] = requirements
+	}
+	return builds
+}
+
+func TestAutoscaler_LaunchesAgentsForWaitingBuilds(t *testing.T) {
+	provider := &fakeProvider{}
+	registry := &fakeRegistry{}
+	auditor := &recordingAuditor{}
+	scaler := NewAutoscaler(&config.AutoscaleConfig{
+		Provider:       "hetzner",
+		MaxAgents:      3,
+		QueueThreshold: 1,
+		Capacity:       2,
+		Labels:         map[string]string{"arch": "amd64"},
+	}, provider, registry, auditor, zap.NewNop())
+	now := time.Now()
+
+	// One waiting build is within the threshold, arm64 builds never count
+	registry.status.Waiting = append(waiting(1, nil), waiting(4, map[string]string{"arch": "arm64"})...)
+	require.NoError(t, scaler.Scale(context.Background(), now))
+	assert.Zero(t, provider.launched)
+
+	// Five builds need three agents of capacity two
+	registry.status.Waiting = waiting(5, map[string]string{"arch": "amd64"})
+	require.NoError(t, scaler.Scale(context.Background(), now))
+	assert.Equal(t, 3, provider.launched)
+	assert.Equal(t, []string{"autoscaler agent.launch hetzner"}, auditor.actions)
+
+	// Starting agents already cover the waiting builds, and max_agents caps
+	// the pool regardless
+	registry.status.Waiting = waiting(8, nil)
+	require.NoError(t, scaler.Scale(context.Background(), now.Add(time.Minute)))
+	assert.Equal(t, 3, provider.launched)
+
+	var metrics bytes.Buffer
+	scaler.WriteMetrics(&metrics)
+	assert.Contains(t, metrics.String(), `chef_agent_scaling_total{provider="hetzner",action="launch",result="succeeded"} 3`)
+	assert.Contains(t, metrics.String(), "chef_agent_instances 3")
+	assert.Contains(t, metrics.String(), "chef_agent_waiting_builds 8")
+}
+
+func TestAutoscaler_TerminatesIdleAgentsAfterCooldown(t *testing.T) {
+	provider := &fakeProvider{instances: []Instance{
+		{ID: "1", Name: "agent-a"},
+		{ID: "2", Name: "agent-b"},
+		{ID: "3", Name: "agent-c"},
+	}}
+	now := time.Now()
+	registry := &fakeRegistry{status: agent.Status{Agents: []agent.AgentStatus{
+		{ID: "a", Name: "agent-a", IdleSince: now.Add(-time.Hour)},
+		{ID: "b", Name: "agent-b", Running: 1},
+		{ID: "c", Name: "agent-c", IdleSince: now.Add(-time.Minute)},
+		{ID: "manual", Name: "workstation", IdleSince: now.Add(-time.Hour)},
+	}}}
+	auditor := &recordingAuditor{}
+	scaler := NewAutoscaler(&config.AutoscaleConfig{
+		Provider:     "kubernetes",
+		MinAgents:    1,
+		IdleCooldown: 600,
+	}, provider, registry, auditor, zap.NewNop())
+
+	require.NoError(t, scaler.Scale(context.Background(), now))
+	assert.Equal(t, []string{"agent-a"}, provider.terminated)
+	assert.Equal(t, []string{"a"}, registry.retired, "agents the provider did not launch are left alone")
+	assert.Equal(t, []string{"autoscaler agent.terminate agent-a"}, auditor.actions)
+
+	// agent-c passes the cooldown, but min_agents keeps one agent
+	registry.status.Agents[1].Running = 0
+	registry.status.Agents[1].IdleSince = now.Add(-time.Hour)
+	require.NoError(t, scaler.Scale(context.Background(), now.Add(time.Hour)))
+	assert.Equal(t, []string{"agent-a", "agent-b"}, provider.terminated)
+	assert.Len(t, provider.instances, 1)
+}
+
+func TestAutoscaler_RecordsFailedLaunches(t *testing.T) {
+	provider := &fakeProvider{launchErr: fmt.Errorf("quota exceeded")}
+	registry := &fakeRegistry{status: agent.Status{Waiting: waiting(1, nil)}}
+	auditor := &recordingAuditor{}
+	scaler := NewAutoscaler(&config.AutoscaleConfig{Provider: "ec2"}, provider, registry, auditor, zap.NewNop())
+
+	err := scaler.Scale(context.Background(), time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "quota exceeded")
+	assert.Equal(t, []string{"autoscaler agent.launch ec2"}, auditor.actions)
+
+	var metrics bytes.Buffer
+	scaler.WriteMetrics(&metrics)
+	assert.Contains(t, metrics.String(), `chef_agent_scaling_total{provider="ec2",action="launch",result="failed"} 1`)
+}
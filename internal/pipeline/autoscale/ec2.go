@@ -0,0 +1,251 @@
+package autoscale
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const (
+	autoScalingAPIVersion = "2011-01-01"
+	maxErrorBody          = 1024
+)
+
+// EC2Provider scales an Auto Scaling group through its query API. Agents
+// must register under their instance ID.
+type EC2Provider struct {
+	group    string
+	region   string
+	endpoint string
+	creds    awsCredentials
+	http     *http.Client
+	now      func() time.Time
+}
+
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+func NewEC2Provider(cfg *config.EC2ScalerConfig) (*EC2Provider, error) {
+	if cfg.Region == "" || cfg.AutoScalingGroup == "" {
+		return nil, errors.New("ec2 autoscaling requires a region and auto scaling group")
+	}
+	creds := awsCredentials{
+		accessKeyID:     cfg.AccessKeyID,
+		secretAccessKey: cfg.SecretAccessKey,
+	}
+	if creds.accessKeyID == "" {
+		creds = awsCredentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return nil, errors.New("ec2 autoscaling requires AWS credentials")
+	}
+
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://autoscaling." + cfg.Region + ".amazonaws.com"
+	}
+	return &EC2Provider{
+		group:    cfg.AutoScalingGroup,
+		region:   cfg.Region,
+		endpoint: endpoint,
+		creds:    creds,
+		http:     &http.Client{Timeout: 30 * time.Second},
+		now:      time.Now,
+	}, nil
+}
+
+type describeGroupsResponse struct {
+	Groups []struct {
+		Name            string `xml:"AutoScalingGroupName"`
+		DesiredCapacity int    `xml:"DesiredCapacity"`
+		Instances       []struct {
+			ID             string `xml:"InstanceId"`
+			LifecycleState string `xml:"LifecycleState"`
+		} `xml:"Instances>member"`
+	} `xml:"DescribeAutoScalingGroupsResult>AutoScalingGroups>member"`
+}
+
+type awsErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func (p *EC2Provider) describe(ctx context.Context) (*describeGroupsResponse, error) {
+	var out describeGroupsResponse
+	err := p.call(ctx, url.Values{
+		"Action":                         {"DescribeAutoScalingGroups"},
+		"AutoScalingGroupNames.member.1": {p.group},
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Groups) == 0 {
+		return nil, fmt.Errorf("auto scaling group %s not found", p.group)
+	}
+	return &out, nil
+}
+
+func (p *EC2Provider) Instances(ctx context.Context) ([]Instance, error) {
+	out, err := p.describe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	for _, instance := range out.Groups[0].Instances {
+		// Terminating:Wait, Terminated and the like are on their way out
+		if strings.HasPrefix(instance.LifecycleState, "Terminat") {
+			continue
+		}
+		instances = append(instances, Instance{ID: instance.ID, Name: instance.ID})
+	}
+	return instances, nil
+}
+
+// Launch raises the group's desired capacity, the group's maximum size
+// still applies
+func (p *EC2Provider) Launch(ctx context.Context, count int) error {
+	out, err := p.describe(ctx)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, url.Values{
+		"Action":               {"SetDesiredCapacity"},
+		"AutoScalingGroupName": {p.group},
+		"DesiredCapacity":      {strconv.Itoa(out.Groups[0].DesiredCapacity + count)},
+		"HonorCooldown":        {"false"},
+	}, nil)
+}
+
+// Terminate removes the instance and lowers the desired capacity so it is
+// not replaced
+func (p *EC2Provider) Terminate(ctx context.Context, instance Instance) error {
+	return p.call(ctx, url.Values{
+		"Action":                         {"TerminateInstanceInAutoScalingGroup"},
+		"InstanceId":                     {instance.ID},
+		"ShouldDecrementDesiredCapacity": {"true"},
+	}, nil)
+}
+
+// call sends a signed query API request and decodes the XML response into
+// out when set
+func (p *EC2Provider) call(ctx context.Context, params url.Values, out interface{}) error {
+	params.Set("Version", autoScalingAPIVersion)
+	body := params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signV4(req, []byte(body), p.creds, p.region, "autoscaling", p.now())
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", params.Get("Action"), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		var apiErr awsErrorResponse
+		if xml.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("%s failed: %s: %s", params.Get("Action"), apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("%s failed: %s", params.Get("Action"), resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", params.Get("Action"), err)
+	}
+	return nil
+}
+
+// signV4 signs a request with AWS Signature Version 4
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	values := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         req.URL.Host,
+		"x-amz-date":   amzDate,
+	}
+	if creds.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+		values["x-amz-security-token"] = creds.sessionToken
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(values[h]) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hexSHA256(body),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,166 @@
+package autoscale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const (
+	defaultHetznerEndpoint   = "https://api.hetzner.cloud/v1"
+	defaultHetznerServerType = "cx22"
+	defaultHetznerImage      = "docker-ce"
+
+	// hetznerAgentLabel marks the servers the autoscaler manages
+	hetznerAgentLabel = "chef-agent"
+)
+
+// HetznerProvider creates a Hetzner Cloud server per agent. Servers are
+// named like their agent, which registers under its hostname by default.
+type HetznerProvider struct {
+	config   *config.HetznerScalerConfig
+	endpoint string
+	http     *http.Client
+}
+
+func NewHetznerProvider(cfg *config.HetznerScalerConfig) (*HetznerProvider, error) {
+	if cfg.Token == "" || cfg.UserData == "" {
+		return nil, errors.New("hetzner autoscaling requires a token and user data")
+	}
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = defaultHetznerEndpoint
+	}
+	return &HetznerProvider{
+		config:   cfg,
+		endpoint: endpoint,
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+type hetznerServer struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+func (p *HetznerProvider) Instances(ctx context.Context) ([]Instance, error) {
+	var instances []Instance
+	for page := 1; page > 0; {
+		query := url.Values{
+			"label_selector": {hetznerAgentLabel},
+			"page":           {strconv.Itoa(page)},
+			"per_page":       {"50"},
+		}
+		var out struct {
+			Servers []hetznerServer `json:"servers"`
+			Meta    struct {
+				Pagination struct {
+					NextPage int `json:"next_page"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+		if err := p.call(ctx, http.MethodGet, "/servers?"+query.Encode(), nil, &out); err != nil {
+			return nil, err
+		}
+		for _, server := range out.Servers {
+			if server.Status == "deleting" {
+				continue
+			}
+			instances = append(instances, Instance{ID: strconv.FormatInt(server.ID, 10), Name: server.Name})
+		}
+		page = out.Meta.Pagination.NextPage
+	}
+	return instances, nil
+}
+
+func (p *HetznerProvider) Launch(ctx context.Context, count int) error {
+	serverType := p.config.ServerType
+	if serverType == "" {
+		serverType = defaultHetznerServerType
+	}
+	image := p.config.Image
+	if image == "" {
+		image = defaultHetznerImage
+	}
+
+	for i := 0; i < count; i++ {
+		server := map[string]interface{}{
+			"name":        instanceName(),
+			"server_type": serverType,
+			"image":       image,
+			"user_data":   p.config.UserData,
+			"labels":      map[string]string{hetznerAgentLabel: "true"},
+		}
+		if p.config.Location != "" {
+			server["location"] = p.config.Location
+		}
+		if err := p.call(ctx, http.MethodPost, "/servers", server, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *HetznerProvider) Terminate(ctx context.Context, instance Instance) error {
+	return p.call(ctx, http.MethodDelete, "/servers/"+url.PathEscape(instance.ID), nil, nil)
+}
+
+// call sends a request to the Hetzner Cloud API and decodes the JSON
+// response into out when set
+func (p *HetznerProvider) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.config.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("hetzner %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		var apiErr struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Code != "" {
+			return fmt.Errorf("hetzner %s %s: %s: %s", method, path, apiErr.Error.Code, apiErr.Error.Message)
+		}
+		return fmt.Errorf("hetzner %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode hetzner response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package autoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+)
+
+const (
+	agentJobLabel = "chef-infra/agent"
+
+	// Finished jobs are removed by Kubernetes after this many seconds
+	finishedJobTTL = 300
+)
+
+// KubernetesProvider runs each agent as a Job
+type KubernetesProvider struct {
+	config    *config.KubernetesScalerConfig
+	scale     *config.AutoscaleConfig
+	namespace string
+	client    kubernetes.Interface
+}
+
+func NewKubernetesProvider(cfg *config.KubernetesScalerConfig, scale *config.AutoscaleConfig, defaultNamespace string) (*KubernetesProvider, error) {
+	if cfg.Image == "" || cfg.Server == "" || cfg.TokenSecret == "" {
+		return nil, errors.New("kubernetes autoscaling requires an image, server and token secret")
+	}
+	for _, quantity := range []string{cfg.CPU, cfg.Memory} {
+		if _, err := resource.ParseQuantity(quantity); quantity != "" && err != nil {
+			return nil, fmt.Errorf("invalid agent resources %q: %w", quantity, err)
+		}
+	}
+	restConfig, err := deployer.LoadKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create k8s client: %w", err)
+	}
+	return newKubernetesProvider(cfg, scale, defaultNamespace, client), nil
+}
+
+func newKubernetesProvider(cfg *config.KubernetesScalerConfig, scale *config.AutoscaleConfig, defaultNamespace string, client kubernetes.Interface) *KubernetesProvider {
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return &KubernetesProvider{config: cfg, scale: scale, namespace: namespace, client: client}
+}
+
+func (p *KubernetesProvider) Instances(ctx context.Context) ([]Instance, error) {
+	jobs, err := p.client.BatchV1().Jobs(p.namespace).List(ctx, metav1.ListOptions{LabelSelector: agentJobLabel + "=true"})
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	for _, job := range jobs.Items {
+		// A finished job's agent has exited
+		if job.DeletionTimestamp != nil || job.Status.Succeeded > 0 || job.Status.Failed > 0 {
+			continue
+		}
+		instances = append(instances, Instance{ID: job.Name, Name: job.Name})
+	}
+	return instances, nil
+}
+
+func (p *KubernetesProvider) Launch(ctx context.Context, count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := p.client.BatchV1().Jobs(p.namespace).Create(ctx, p.job(instanceName()), metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create agent job: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *KubernetesProvider) Terminate(ctx context.Context, instance Instance) error {
+	propagation := metav1.DeletePropagationBackground
+	err := p.client.BatchV1().Jobs(p.namespace).Delete(ctx, instance.ID, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		return fmt.Errorf("failed to delete agent job: %w", err)
+	}
+	return nil
+}
+
+// job returns the Job running one agent named name
+func (p *KubernetesProvider) job(name string) *batchv1.Job {
+	backoffLimit := int32(0)
+	ttl := int32(finishedJobTTL)
+	labels := map[string]string{
+		agentJobLabel:                  "true",
+		"app.kubernetes.io/managed-by": "chef-infra",
+	}
+
+	args := []string{
+		"-server", p.config.Server,
+		"-name", name,
+		"-capacity", strconv.Itoa(max(p.scale.Capacity, 1)),
+	}
+	if len(p.scale.Labels) > 0 {
+		args = append(args, "-labels", joinLabels(p.scale.Labels))
+	}
+	args = append(args, p.config.Args...)
+
+	container := corev1.Container{
+		Name:  "agent",
+		Image: p.config.Image,
+		Args:  args,
+		Env: []corev1.EnvVar{{
+			Name: "CHEF_AGENT_TOKEN",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: p.config.TokenSecret},
+				Key:                  "token",
+			}},
+		}},
+	}
+	resources := corev1.ResourceList{}
+	if p.config.CPU != "" {
+		resources[corev1.ResourceCPU] = resource.MustParse(p.config.CPU)
+	}
+	if p.config.Memory != "" {
+		resources[corev1.ResourceMemory] = resource.MustParse(p.config.Memory)
+	}
+	if len(resources) > 0 {
+		container.Resources = corev1.ResourceRequirements{Requests: resources, Limits: resources}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: p.config.ServiceAccount,
+					Containers:         []corev1.Container{container},
+				},
+			},
+		},
+	}
+}
+
+// joinLabels formats labels as chef-agent's -labels flag
+func joinLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,179 @@
+package autoscale
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+// TestSignV4 checks the post-x-www-form-urlencoded case of the AWS
+// Signature Version 4 test suite
+func TestSignV4(t *testing.T) {
+	body := "Param1=value1"
+	req, err := http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	creds := awsCredentials{accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, []byte(body), creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		req.Header.Get("Authorization"))
+}
+
+func TestEC2Provider(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		actions []url.Values
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKID/20250102/eu-west-1/autoscaling/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature="))
+		assert.Equal(t, "20250102T030405Z", r.Header.Get("X-Amz-Date"))
+
+		body, _ := io.ReadAll(r.Body)
+		params, _ := url.ParseQuery(string(body))
+		mu.Lock()
+		actions = append(actions, params)
+		mu.Unlock()
+
+		switch params.Get("Action") {
+		case "DescribeAutoScalingGroups":
+			io.WriteString(w, `<DescribeAutoScalingGroupsResponse><DescribeAutoScalingGroupsResult><AutoScalingGroups><member>
+				<AutoScalingGroupName>agents</AutoScalingGroupName><DesiredCapacity>2</DesiredCapacity>
+				<Instances>
+					<member><InstanceId>i-1</InstanceId><LifecycleState>InService</LifecycleState></member>
+					<member><InstanceId>i-2</InstanceId><LifecycleState>Terminating:Wait</LifecycleState></member>
+				</Instances>
+			</member></AutoScalingGroups></DescribeAutoScalingGroupsResult></DescribeAutoScalingGroupsResponse>`)
+		case "TerminateInstanceInAutoScalingGroup":
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `<ErrorResponse><Error><Code>ValidationError</Code><Message>Instance i-9 is not in the group</Message></Error></ErrorResponse>`)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewEC2Provider(&config.EC2ScalerConfig{
+		Region:           "eu-west-1",
+		AutoScalingGroup: "agents",
+		AccessKeyID:      "AKID",
+		SecretAccessKey:  "secret",
+		Endpoint:         server.URL,
+	})
+	require.NoError(t, err)
+	provider.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	instances, err := provider.Instances(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{{ID: "i-1", Name: "i-1"}}, instances)
+
+	require.NoError(t, provider.Launch(context.Background(), 3))
+	last := actions[len(actions)-1]
+	assert.Equal(t, "SetDesiredCapacity", last.Get("Action"))
+	assert.Equal(t, "5", last.Get("DesiredCapacity"))
+
+	err = provider.Terminate(context.Background(), Instance{ID: "i-9"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ValidationError: Instance i-9 is not in the group")
+	last = actions[len(actions)-1]
+	assert.Equal(t, "true", last.Get("ShouldDecrementDesiredCapacity"))
+}
+
+func TestHetznerProvider(t *testing.T) {
+	var created []map[string]interface{}
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer hcloud", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("page") == "1":
+			assert.Equal(t, "chef-agent", r.URL.Query().Get("label_selector"))
+			io.WriteString(w, `{"servers":[{"id":1,"name":"chef-agent-a","status":"running"}],"meta":{"pagination":{"next_page":2}}}`)
+		case r.Method == http.MethodGet:
+			io.WriteString(w, `{"servers":[{"id":2,"name":"chef-agent-b","status":"deleting"}],"meta":{"pagination":{"next_page":null}}}`)
+		case r.Method == http.MethodPost:
+			var server map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&server))
+			created = append(created, server)
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{}`)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"code":"not_found","message":"server not found"}}`)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewHetznerProvider(&config.HetznerScalerConfig{
+		Token:    "hcloud",
+		UserData: "#cloud-config\n",
+		Location: "fsn1",
+		Endpoint: server.URL,
+	})
+	require.NoError(t, err)
+
+	instances, err := provider.Instances(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Instance{{ID: "1", Name: "chef-agent-a"}}, instances)
+
+	require.NoError(t, provider.Launch(context.Background(), 2))
+	require.Len(t, created, 2)
+	assert.Equal(t, "cx22", created[0]["server_type"])
+	assert.Equal(t, "docker-ce", created[0]["image"])
+	assert.Equal(t, "fsn1", created[0]["location"])
+	assert.Equal(t, map[string]interface{}{"chef-agent": "true"}, created[0]["labels"])
+	assert.NotEqual(t, created[0]["name"], created[1]["name"])
+
+	err = provider.Terminate(context.Background(), Instance{ID: "7"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not_found: server not found")
+	assert.Equal(t, []string{"/servers/7"}, deleted)
+}
+
+func TestKubernetesProvider(t *testing.T) {
+	client := fake.NewSimpleClientset(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "chef-agent-done", Namespace: "builds", Labels: map[string]string{agentJobLabel: "true"}},
+		Status:     batchv1.JobStatus{Succeeded: 1},
+	})
+	provider := newKubernetesProvider(&config.KubernetesScalerConfig{
+		Image:       "chef-agent:1",
+		Server:      "chef:50051",
+		TokenSecret: "agent-token",
+		Memory:      "4Gi",
+		Args:        []string{"-insecure"},
+	}, &config.AutoscaleConfig{Capacity: 2, Labels: map[string]string{"pool": "k8s", "arch": "amd64"}}, "builds", client)
+
+	require.NoError(t, provider.Launch(context.Background(), 1))
+	instances, err := provider.Instances(context.Background())
+	require.NoError(t, err)
+	require.Len(t, instances, 1, "finished jobs are not instances")
+
+	job, err := client.BatchV1().Jobs("builds").Get(context.Background(), instances[0].ID, metav1.GetOptions{})
+	require.NoError(t, err)
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "chef-agent:1", container.Image)
+	assert.Equal(t, []string{"-server", "chef:50051", "-name", job.Name, "-capacity", "2", "-labels", "arch=amd64,pool=k8s", "-insecure"}, container.Args)
+	assert.Equal(t, "agent-token", container.Env[0].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, "4Gi", container.Resources.Limits.Memory().String())
+
+	require.NoError(t, provider.Terminate(context.Background(), instances[0]))
+	instances, err = provider.Instances(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, instances)
+}
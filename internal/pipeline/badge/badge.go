@@ -0,0 +1,71 @@
+// Package badge renders flat SVG status badges, e.g. "build | passing",
+// for embedding in READMEs.
+package badge
+
+import (
+	"bytes"
+	"html/template"
+	"unicode/utf8"
+)
+
+// Colors of badge messages
+const (
+	Green  = "#4c1"
+	Red    = "#e05d44"
+	Yellow = "#dfb317"
+	Blue   = "#007ec6"
+	Grey   = "#9f9f9f"
+)
+
+const (
+	// charWidth approximates the width of a Verdana 11px character, exact
+	// text metrics are not worth a font table
+	charWidth = 7
+	padding   = 10
+)
+
+type badge struct {
+	Label, Message, Color      string
+	LabelWidth, MessageWidth   int
+	Width                      int
+	LabelCenter, MessageCenter int
+}
+
+// Render returns the SVG of a badge with a grey label and a message on
+// color
+func Render(label, message, color string) []byte {
+	b := badge{
+		Label:        label,
+		Message:      message,
+		Color:        color,
+		LabelWidth:   textWidth(label),
+		MessageWidth: textWidth(message),
+	}
+	b.Width = b.LabelWidth + b.MessageWidth
+	// Text is drawn scaled down by 10 for sharper rendering
+	b.LabelCenter = b.LabelWidth * 5
+	b.MessageCenter = (b.LabelWidth*2 + b.MessageWidth) * 5
+
+	var buf bytes.Buffer
+	if err := svg.Execute(&buf, b); err != nil {
+		// The template only fails on writes, which a buffer never does
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func textWidth(text string) int {
+	return utf8.RuneCountInString(text)*charWidth + padding
+}
+
+var svg = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">
+<title>{{.Label}}: {{.Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="{{.LabelWidth}}" height="20" fill="#555"/><rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/><rect width="{{.Width}}" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="110">
+<text x="{{.LabelCenter}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)">{{.Label}}</text><text x="{{.LabelCenter}}" y="140" transform="scale(.1)">{{.Label}}</text>
+<text x="{{.MessageCenter}}" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)">{{.Message}}</text><text x="{{.MessageCenter}}" y="140" transform="scale(.1)">{{.Message}}</text>
+</g>
+</svg>
+`))
@@ -0,0 +1,33 @@
+package badge
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	svg := string(Render("build", "passing", Green))
+
+	assert.Contains(t, svg, `width="104"`)
+	assert.Contains(t, svg, `aria-label="build: passing"`)
+	assert.Contains(t, svg, `fill="#4c1"`)
+	assert.Contains(t, svg, `>passing</text>`)
+
+	var doc struct {
+		XMLName xml.Name `xml:"svg"`
+	}
+	require.NoError(t, xml.Unmarshal([]byte(svg), &doc))
+}
+
+func TestRender_EscapesText(t *testing.T) {
+	svg := string(Render("version", `<script>"x"</script>`, Blue))
+
+	assert.NotContains(t, svg, "<script>")
+	var doc struct {
+		XMLName xml.Name `xml:"svg"`
+	}
+	require.NoError(t, xml.Unmarshal([]byte(svg), &doc))
+}
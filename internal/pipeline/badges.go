@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/badge"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const defaultBadgeMaxAge = 300
+
+// Badges serves a project's build status at /badge/{project}/build.svg,
+// optionally of ?branch=, and its deployed version at
+// /badge/{project}/version.svg. Projects without a readable status page
+// get 404s, so badges reveal nothing a status page would not.
+type Badges struct {
+	pipeline   *Pipeline
+	authorizer StatusPageAuthorizer
+	maxAge     string
+	logger     *zap.Logger
+}
+
+func NewBadges(cfg *config.BadgesConfig, pipeline *Pipeline, authorizer StatusPageAuthorizer, logger *zap.Logger) *Badges {
+	maxAge := cfg.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultBadgeMaxAge
+	}
+	return &Badges{
+		pipeline:   pipeline,
+		authorizer: authorizer,
+		maxAge:     "max-age=" + strconv.Itoa(maxAge),
+		logger:     logger,
+	}
+}
+
+func (b *Badges) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID, kind, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/badge/"), "/")
+	if !ok || projectID == "" || (kind != "build.svg" && kind != "version.svg") {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := statusPageToken(r)
+	allowed, err := b.authorizer.CanReadStatusPage(projectID, token)
+	if err != nil {
+		b.logger.Error("failed to check badge access", zap.String("project_id", projectID), zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.NotFound(w, r)
+		return
+	}
+
+	var svg []byte
+	if kind == "build.svg" {
+		svg, err = b.buildBadge(r.Context(), projectID, r.URL.Query().Get("branch"))
+	} else {
+		svg, err = b.versionBadge(r.Context(), projectID)
+	}
+	if err != nil {
+		b.logger.Error("failed to render badge", zap.String("project_id", projectID), zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(svg)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	h := w.Header()
+	h.Set("ETag", etag)
+	if token != "" {
+		h.Set("Cache-Control", "private, "+b.maxAge)
+	} else {
+		h.Set("Cache-Control", "public, "+b.maxAge)
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+// buildBadge shows whether the latest finished build of the branch passed
+func (b *Badges) buildBadge(ctx context.Context, projectID, branch string) ([]byte, error) {
+	build, err := b.pipeline.latestFinishedBuild(ctx, projectID, branch)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case build == nil:
+		return badge.Render("build", "unknown", badge.Grey), nil
+	case build.Status == types.BuildStatusSuccess:
+		return badge.Render("build", "passing", badge.Green), nil
+	}
+	return badge.Render("build", "failing", badge.Red), nil
+}
+
+// versionBadge shows the release tag, else the commit, of the build
+// deployed to the status page's environment
+func (b *Badges) versionBadge(ctx context.Context, projectID string) ([]byte, error) {
+	status, err := b.pipeline.ProjectStatus(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	version := status.Version
+	switch {
+	case version == nil:
+		return badge.Render(status.Environment, "not deployed", badge.Grey), nil
+	case version.Release != "":
+		return badge.Render(status.Environment, version.Release, badge.Blue), nil
+	case version.Commit != "":
+		return badge.Render(status.Environment, version.Commit, badge.Blue), nil
+	}
+	return badge.Render(status.Environment, version.Build, badge.Blue), nil
+}
+
+// latestFinishedBuild returns the project's last build that passed or
+// failed, of branch unless empty, or nil when there is none
+func (p *Pipeline) latestFinishedBuild(ctx context.Context, projectID, branch string) (*types.Build, error) {
+	if p.store != nil {
+		return p.store.LatestFinishedBuild(ctx, projectID, branch)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var latest *types.Build
+	for _, b := range p.builds {
+		if b.ProjectID != projectID || (b.Status != types.BuildStatusSuccess && b.Status != types.BuildStatusFailed) {
+			continue
+		}
+		if branch != "" && (b.Commit == nil || b.Commit.Branch != branch) {
+			continue
+		}
+		if latest == nil || b.StartTime.After(latest.StartTime) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return &types.Build{ID: latest.ID, ProjectID: latest.ProjectID, Status: latest.Status}, nil
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestBadges(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	now := time.Now()
+	passed := deployedBuild("b1", now)
+	passed.StartTime = now.Add(-time.Hour)
+	pipeline.builds["b1"] = passed
+	failed := deployedBuild("b2", now)
+	failed.StartTime = now
+	failed.Status = types.BuildStatusFailed
+	failed.Commit = &types.CommitInfo{Branch: "develop"}
+	pipeline.builds["b2"] = failed
+
+	badges := NewBadges(&config.BadgesConfig{}, pipeline, statusPages{"shop": "", "admin": "s3cret"}, zap.NewNop())
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		badges.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/badge/shop/build.svg", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Body.String(), "build: failing")
+
+	rec = get("/badge/shop/build.svg?branch=main", "")
+	assert.Contains(t, rec.Body.String(), "build: passing")
+	rec = get("/badge/shop/build.svg?branch=feature", "")
+	assert.Contains(t, rec.Body.String(), "build: unknown")
+
+	rec = get("/badge/shop/version.svg", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "production: 0123abc")
+
+	// Unchanged badges are revalidated without a body
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	rec = get("/badge/shop/version.svg", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("/badge/admin/build.svg", "").Code)
+	rec = get("/badge/admin/build.svg?token=s3cret", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "private, max-age=300", rec.Header().Get("Cache-Control"))
+	assert.Equal(t, http.StatusNotFound, get("/badge/shop/coverage.svg", "").Code)
+}
@@ -1,9 +1,12 @@
 package builder
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
 )
 
 type BuildContext struct {
@@ -13,13 +16,102 @@ type BuildContext struct {
 	CacheDir    string
 }
 
-func NewBuildContext(rootDir, buildID string) (*BuildContext, error) {
-	bc := &BuildContext{
-		RootDir:     rootDir,
-		BuildDir:    filepath.Join(rootDir, "builds", buildID),
-		ArtifactDir: filepath.Join(rootDir, "artifacts", buildID),
-		CacheDir:    filepath.Join(rootDir, "cache", buildID),
+// DefaultRootDir returns the per-user cache location used when no build
+// directory is configured, e.g. ~/.cache/chef-infra or %LocalAppData%\chef-infra.
+func DefaultRootDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve user cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "chef-infra"), nil
+}
+
+// Layout places the files of builds on this host. Each build has its own
+// directory under builds, artifacts and cache, and its artifact is always
+// ArtifactPath in its artifact directory, whether it was built here or
+// uploaded by an agent.
+type Layout struct {
+	RootDir      string // build_dir, DefaultRootDir when unset
+	ArtifactsDir string // artifacts_dir, <RootDir>/artifacts when unset
+}
+
+// NewLayout resolves the layout of the pipeline config
+func NewLayout(cfg *config.PipelineConfig) (Layout, error) {
+	rootDir := cfg.BuildDir
+	if rootDir == "" {
+		defaultDir, err := DefaultRootDir()
+		if err != nil {
+			return Layout{}, err
+		}
+		rootDir = defaultDir
+	}
+	artifactsDir := cfg.ArtifactsDir
+	if artifactsDir == "" {
+		artifactsDir = filepath.Join(rootDir, "artifacts")
+	}
+	return Layout{RootDir: rootDir, ArtifactsDir: artifactsDir}, nil
+}
+
+// Dirs returns the directories holding one directory per build
+func (l Layout) Dirs() []string {
+	return []string{filepath.Join(l.RootDir, "builds"), l.ArtifactsDir, filepath.Join(l.RootDir, "cache")}
+}
+
+func (l Layout) ArtifactDir(buildID string) string {
+	return filepath.Join(l.ArtifactsDir, buildID)
+}
+
+// ArtifactPath returns where the artifact of a build is kept
+func (l Layout) ArtifactPath(buildID string) string {
+	return ArtifactPath(l.ArtifactDir(buildID), buildID)
+}
+
+// ArtifactPath returns the artifact of a build in artifactDir
+func ArtifactPath(artifactDir, buildID string) string {
+	return filepath.Join(artifactDir, buildID+".tar.gz")
+}
+
+// MigrateArtifacts moves the artifact directories kept in <RootDir>/artifacts,
+// where they were placed before artifacts_dir was honoured, to
+// ArtifactsDir and returns the moved artifacts keyed by their old path.
+// Directories already present at the destination are left in place.
+func (l Layout) MigrateArtifacts() (map[string]string, error) {
+	legacy := Layout{RootDir: l.RootDir, ArtifactsDir: filepath.Join(l.RootDir, "artifacts")}
+	if filepath.Clean(legacy.ArtifactsDir) == filepath.Clean(l.ArtifactsDir) {
+		return nil, nil
 	}
+	entries, err := os.ReadDir(legacy.ArtifactsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := os.MkdirAll(l.ArtifactsDir, 0755); err != nil {
+		return nil, err
+	}
+
+	moved := make(map[string]string)
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		buildID := entry.Name()
+		if _, err := os.Stat(l.ArtifactDir(buildID)); err == nil {
+			continue
+		}
+		if err := os.Rename(legacy.ArtifactDir(buildID), l.ArtifactDir(buildID)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		moved[legacy.ArtifactPath(buildID)] = l.ArtifactPath(buildID)
+	}
+	return moved, errors.Join(errs...)
+}
+
+func NewBuildContext(layout Layout, buildID string) (*BuildContext, error) {
+	bc := newBuildContext(layout, buildID)
 
 	// Create directories
 	dirs := []string{bc.BuildDir, bc.ArtifactDir, bc.CacheDir}
@@ -32,6 +124,32 @@ func NewBuildContext(rootDir, buildID string) (*BuildContext, error) {
 	return bc, nil
 }
 
+func newBuildContext(layout Layout, buildID string) *BuildContext {
+	return &BuildContext{
+		RootDir:     layout.RootDir,
+		BuildDir:    filepath.Join(layout.RootDir, "builds", buildID),
+		ArtifactDir: layout.ArtifactDir(buildID),
+		CacheDir:    filepath.Join(layout.RootDir, "cache", buildID),
+	}
+}
+
+// RemoveBuildContext removes the directories of a build that did not
+// finish, keeping its artifacts when keepArtifacts is set
+func RemoveBuildContext(layout Layout, buildID string, keepArtifacts bool) error {
+	bc := newBuildContext(layout, buildID)
+	dirs := []string{bc.BuildDir, bc.CacheDir}
+	if !keepArtifacts {
+		dirs = append(dirs, bc.ArtifactDir)
+	}
+	var errs []error
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (bc *BuildContext) Cleanup() error {
 	// Cleanup everything except artifacts
 	return os.RemoveAll(bc.BuildDir)
@@ -0,0 +1,51 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestNewLayout(t *testing.T) {
+	layout, err := NewLayout(&config.PipelineConfig{BuildDir: "/var/lib/chef"})
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/chef/artifacts/b1/b1.tar.gz", layout.ArtifactPath("b1"))
+
+	layout, err = NewLayout(&config.PipelineConfig{BuildDir: "/var/lib/chef/builds", ArtifactsDir: "/srv/artifacts"})
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/artifacts/b1/b1.tar.gz", layout.ArtifactPath("b1"))
+	assert.Equal(t, []string{"/var/lib/chef/builds/builds", "/srv/artifacts", "/var/lib/chef/builds/cache"}, layout.Dirs())
+
+	bc := newBuildContext(layout, "b1")
+	assert.Equal(t, "/srv/artifacts/b1", bc.ArtifactDir)
+	assert.Equal(t, "/var/lib/chef/builds/builds/b1", bc.BuildDir)
+}
+
+func TestLayout_MigrateArtifacts(t *testing.T) {
+	root := t.TempDir()
+	legacy := Layout{RootDir: root, ArtifactsDir: filepath.Join(root, "artifacts")}
+	for _, id := range []string{"b1", "b2"} {
+		require.NoError(t, os.MkdirAll(legacy.ArtifactDir(id), 0755))
+		require.NoError(t, os.WriteFile(legacy.ArtifactPath(id), []byte(id), 0644))
+	}
+
+	moved, err := legacy.MigrateArtifacts()
+	require.NoError(t, err)
+	assert.Empty(t, moved, "nothing moves without artifacts_dir")
+
+	layout := Layout{RootDir: root, ArtifactsDir: filepath.Join(t.TempDir(), "artifacts")}
+	// Already migrated
+	require.NoError(t, os.MkdirAll(layout.ArtifactDir("b2"), 0755))
+
+	moved, err = layout.MigrateArtifacts()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{legacy.ArtifactPath("b1"): layout.ArtifactPath("b1")}, moved)
+	assert.FileExists(t, layout.ArtifactPath("b1"))
+	assert.NoDirExists(t, legacy.ArtifactDir("b1"))
+	assert.DirExists(t, legacy.ArtifactDir("b2"))
+}
@@ -13,7 +13,8 @@ type Builder interface {
 }
 type Options struct {
 	WorkDir     string
+	ArtifactDir string // Where the artifact is written, Cleanup keeps it
 	CacheDir    string
 	Environment map[string]string
-	Timeout     int
+	Timeouts    types.PhaseTimeouts // The server's, chef.yaml may override them
 }
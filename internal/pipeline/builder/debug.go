@@ -0,0 +1,161 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// debugStages are the Dockerfile stages a failed build is kept from, latest
+// first: dependencies installed and sources copied, or only the package
+// files when npm install itself failed
+var debugStages = []string{"deps", "base"}
+
+// Debugger keeps what a failed build left behind for inspection
+type Debugger interface {
+	// DebugImage builds the last stage of the build's Dockerfile that
+	// succeeds and returns its tag and stage name. It must be called
+	// before Cleanup.
+	DebugImage(ctx context.Context, build *types.Build) (image, stage string, err error)
+}
+
+// DebugImageTag names the image kept from a failed build
+func DebugImageTag(build *types.Build) string {
+	return fmt.Sprintf("chef-debug-%s:%s", build.ProjectID, build.ID)
+}
+
+func (b *NodeJSBuilder) DebugImage(ctx context.Context, build *types.Build) (string, string, error) {
+	buildDir := filepath.Join(b.options.WorkDir, build.ID)
+	if _, err := os.Stat(filepath.Join(buildDir, "Dockerfile")); err != nil {
+		return "", "", fmt.Errorf("build failed before its Dockerfile was written: %w", err)
+	}
+
+	// Registry tokens are mounted as a BuildKit secret, so no stage's
+	// history holds them
+	tag := DebugImageTag(build)
+	var lastErr error
+	for _, stage := range debugStages {
+		// Layers that built are cached, so only the failing step reruns
+		if lastErr = b.buildTarget(ctx, buildDir, tag, "", stage, buildLabels(build.ID)); lastErr == nil {
+			return tag, stage, nil
+		}
+	}
+	return "", "", fmt.Errorf("no stage of the build could be kept: %w", lastErr)
+}
+
+// ShellOptions describe a command started in a debug image
+type ShellOptions struct {
+	Command []string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	TTY     bool
+}
+
+// ExitError is returned when the command exited with a non-zero code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+// Shell runs commands in throwaway containers of debug images
+type Shell struct {
+	cli *client.Client
+}
+
+func NewShell() (*Shell, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	return &Shell{cli: cli}, nil
+}
+
+// Run starts the command in a new container of the image ref and streams its
+// input and output until it exits. The container is removed afterwards.
+func (s *Shell) Run(ctx context.Context, ref string, opts ShellOptions) error {
+	resp, err := s.cli.ContainerCreate(ctx, &container.Config{
+		Image:        ref,
+		Cmd:          opts.Command,
+		WorkingDir:   "/app",
+		Tty:          opts.TTY,
+		OpenStdin:    true,
+		StdinOnce:    true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	}, nil, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	defer func() {
+		// The session context may be done already
+		_ = s.cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+	}()
+
+	attach, err := s.cli.ContainerAttach(ctx, resp.ID, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+	defer attach.Close()
+
+	if err := s.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	go func() {
+		if opts.Stdin != nil {
+			_, _ = io.Copy(attach.Conn, opts.Stdin)
+		}
+		_ = attach.CloseWrite()
+	}()
+
+	output := make(chan error, 1)
+	go func() {
+		var err error
+		if opts.TTY {
+			_, err = io.Copy(opts.Stdout, attach.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(opts.Stdout, opts.Stderr, attach.Reader)
+		}
+		output <- err
+	}()
+
+	waitCh, errCh := s.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to wait for container: %w", err)
+	case result := <-waitCh:
+		// Flush what the command wrote before it exited
+		<-output
+		if result.StatusCode != 0 {
+			return &ExitError{Code: int(result.StatusCode)}
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Remove deletes a debug image
+func (s *Shell) Remove(ctx context.Context, ref string) error {
+	_, err := s.cli.ImageRemove(ctx, ref, image.RemoveOptions{Force: true, PruneChildren: true})
+	return err
+}
@@ -0,0 +1,301 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const (
+	defaultDockerCallTimeout = 60 * time.Second
+	defaultDockerRetries     = 3
+	defaultDockerRetryDelay  = 500 * time.Millisecond
+)
+
+// DockerClient makes the Docker API calls of builds. Calls are bounded by
+// a timeout, retried on transient daemon errors and counted in metrics;
+// their errors name the call that failed.
+type DockerClient struct {
+	api        client.APIClient
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+	metrics    *DockerMetrics
+	logger     *zap.Logger
+}
+
+func NewDockerClient(cfg *config.DockerConfig, metrics *DockerMetrics, logger *zap.Logger) (*DockerClient, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	return newDockerClient(cli, cfg, metrics, logger), nil
+}
+
+func newDockerClient(api client.APIClient, cfg *config.DockerConfig, metrics *DockerMetrics, logger *zap.Logger) *DockerClient {
+	c := &DockerClient{
+		api:        api,
+		timeout:    time.Duration(cfg.CallTimeout) * time.Second,
+		retries:    cfg.Retries,
+		retryDelay: time.Duration(cfg.RetryDelay) * time.Millisecond,
+		metrics:    metrics,
+		logger:     logger,
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultDockerCallTimeout
+	}
+	if c.retries == 0 {
+		c.retries = defaultDockerRetries
+	}
+	if c.retries < 0 {
+		c.retries = 0
+	}
+	if c.retryDelay <= 0 {
+		c.retryDelay = defaultDockerRetryDelay
+	}
+	return c
+}
+
+// Close releases the connections to the daemon
+func (c *DockerClient) Close() error {
+	return c.api.Close()
+}
+
+// callOptions describe how a Docker API call may be bounded and repeated
+type callOptions struct {
+	// stream is set for calls returning a body that is read after the call,
+	// which a timeout would cut off
+	stream bool
+	// idempotent calls are retried on transient errors
+	idempotent bool
+}
+
+// dockerCall runs fn as the Docker API call op
+func dockerCall[T any](ctx context.Context, c *DockerClient, op string, opts callOptions, fn func(ctx context.Context) (T, error)) (T, error) {
+	start := time.Now()
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		result, err := attemptCall(ctx, c.timeout, opts, fn)
+		if err == nil {
+			c.metrics.observe(op, nil, attempt, time.Since(start))
+			return result, nil
+		}
+		if !opts.idempotent || attempt >= c.retries || ctx.Err() != nil || !transientDockerError(err) {
+			c.metrics.observe(op, err, attempt, time.Since(start))
+			return result, fmt.Errorf("docker %s: %w", op, err)
+		}
+
+		buildlog.Logger(ctx, c.logger).Warn("retrying docker call",
+			zap.String("call", op),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("delay", delay),
+			zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			c.metrics.observe(op, ctx.Err(), attempt, time.Since(start))
+			return result, fmt.Errorf("docker %s: %w", op, ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+// attemptCall runs fn once. Calls that do not stream are bounded by
+// timeout, a call timing out is transient unless ctx itself is done.
+func attemptCall[T any](ctx context.Context, timeout time.Duration, opts callOptions, fn func(ctx context.Context) (T, error)) (T, error) {
+	if opts.stream {
+		return fn(ctx)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result, err := fn(callCtx)
+	if err != nil && ctx.Err() == nil && callCtx.Err() != nil {
+		err = &callTimeoutError{timeout: timeout, err: err}
+	}
+	return result, err
+}
+
+type callTimeoutError struct {
+	timeout time.Duration
+	err     error
+}
+
+func (e *callTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s: %v", e.timeout, e.err)
+}
+
+func (e *callTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// transientDockerError reports whether err is likely to go away when the
+// call is repeated, e.g. while the daemon restarts
+func transientDockerError(err error) bool {
+	var timeout *callTimeoutError
+	return errors.As(err, &timeout) ||
+		client.IsErrConnectionFailed(err) ||
+		errdefs.IsUnavailable(err) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func (c *DockerClient) ImageInspect(ctx context.Context, ref string) (dockertypes.ImageInspect, error) {
+	return dockerCall(ctx, c, "image inspect", callOptions{idempotent: true}, func(ctx context.Context) (dockertypes.ImageInspect, error) {
+		info, _, err := c.api.ImageInspectWithRaw(ctx, ref)
+		return info, err
+	})
+}
+
+// ImageBuild returns the build output, which has to be read and closed
+func (c *DockerClient) ImageBuild(ctx context.Context, buildContext io.Reader, options dockertypes.ImageBuildOptions) (io.ReadCloser, error) {
+	return dockerCall(ctx, c, "image build", callOptions{stream: true}, func(ctx context.Context) (io.ReadCloser, error) {
+		resp, err := c.api.ImageBuild(ctx, buildContext, options)
+		return resp.Body, err
+	})
+}
+
+// ImagePull returns the pull progress, which has to be read and closed
+func (c *DockerClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	return dockerCall(ctx, c, "image pull", callOptions{stream: true, idempotent: true}, func(ctx context.Context) (io.ReadCloser, error) {
+		return c.api.ImagePull(ctx, ref, options)
+	})
+}
+
+func (c *DockerClient) ImageTag(ctx context.Context, source, target string) error {
+	_, err := dockerCall(ctx, c, "image tag", callOptions{idempotent: true}, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, c.api.ImageTag(ctx, source, target)
+	})
+	return err
+}
+
+// ImageList returns the images matching options
+func (c *DockerClient) ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error) {
+	return dockerCall(ctx, c, "image list", callOptions{idempotent: true}, func(ctx context.Context) ([]image.Summary, error) {
+		return c.api.ImageList(ctx, options)
+	})
+}
+
+func (c *DockerClient) ImageRemove(ctx context.Context, ref string, options image.RemoveOptions) error {
+	_, err := dockerCall(ctx, c, "image remove", callOptions{idempotent: true}, func(ctx context.Context) ([]image.DeleteResponse, error) {
+		return c.api.ImageRemove(ctx, ref, options)
+	})
+	return err
+}
+
+// ContainerCreate creates a container of config and returns its ID
+func (c *DockerClient) ContainerCreate(ctx context.Context, config *container.Config) (string, error) {
+	resp, err := dockerCall(ctx, c, "container create", callOptions{}, func(ctx context.Context) (container.CreateResponse, error) {
+		return c.api.ContainerCreate(ctx, config, nil, nil, nil, "")
+	})
+	return resp.ID, err
+}
+
+func (c *DockerClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	_, err := dockerCall(ctx, c, "container remove", callOptions{idempotent: true}, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, c.api.ContainerRemove(ctx, containerID, options)
+	})
+	return err
+}
+
+// ContainerList returns the containers matching options
+func (c *DockerClient) ContainerList(ctx context.Context, options container.ListOptions) ([]dockertypes.Container, error) {
+	return dockerCall(ctx, c, "container list", callOptions{idempotent: true}, func(ctx context.Context) ([]dockertypes.Container, error) {
+		return c.api.ContainerList(ctx, options)
+	})
+}
+
+func (c *DockerClient) ContainerStatPath(ctx context.Context, containerID, path string) (container.PathStat, error) {
+	return dockerCall(ctx, c, "container stat", callOptions{idempotent: true}, func(ctx context.Context) (container.PathStat, error) {
+		return c.api.ContainerStatPath(ctx, containerID, path)
+	})
+}
+
+// CopyFromContainer returns path as a tar stream, which has to be read and
+// closed
+func (c *DockerClient) CopyFromContainer(ctx context.Context, containerID, path string) (io.ReadCloser, error) {
+	return dockerCall(ctx, c, "container copy", callOptions{stream: true, idempotent: true}, func(ctx context.Context) (io.ReadCloser, error) {
+		reader, _, err := c.api.CopyFromContainer(ctx, containerID, path)
+		return reader, err
+	})
+}
+
+func (c *DockerClient) ServerVersion(ctx context.Context) (dockertypes.Version, error) {
+	return dockerCall(ctx, c, "version", callOptions{idempotent: true}, func(ctx context.Context) (dockertypes.Version, error) {
+		return c.api.ServerVersion(ctx)
+	})
+}
+
+// DockerMetrics counts the Docker API calls of all builds
+type DockerMetrics struct {
+	mu        sync.Mutex
+	calls     map[string]map[string]int
+	durations map[string]time.Duration
+	retries   map[string]int
+}
+
+func NewDockerMetrics() *DockerMetrics {
+	return &DockerMetrics{
+		calls:     make(map[string]map[string]int),
+		durations: make(map[string]time.Duration),
+		retries:   make(map[string]int),
+	}
+}
+
+// observe records a finished call after its retries. Nil metrics record
+// nothing.
+func (m *DockerMetrics) observe(op string, err error, retries int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.calls[op] == nil {
+		m.calls[op] = make(map[string]int)
+	}
+	m.calls[op][result]++
+	m.durations[op] += duration
+	m.retries[op] += retries
+}
+
+// WriteMetrics writes the call metrics in the Prometheus text exposition
+// format
+func (m *DockerMetrics) WriteMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP chef_docker_calls_total Docker API calls of builds by call and result.\n# TYPE chef_docker_calls_total counter\n")
+	for _, op := range slices.Sorted(maps.Keys(m.calls)) {
+		for _, result := range slices.Sorted(maps.Keys(m.calls[op])) {
+			fmt.Fprintf(w, "chef_docker_calls_total{call=\"%s\",result=\"%s\"} %d\n", op, result, m.calls[op][result])
+		}
+	}
+	fmt.Fprintf(w, "# HELP chef_docker_call_duration_seconds_total Time spent in Docker API calls including retries.\n# TYPE chef_docker_call_duration_seconds_total counter\n")
+	for _, op := range slices.Sorted(maps.Keys(m.durations)) {
+		fmt.Fprintf(w, "chef_docker_call_duration_seconds_total{call=\"%s\"} %g\n", op, m.durations[op].Seconds())
+	}
+	fmt.Fprintf(w, "# HELP chef_docker_call_retries_total Docker API calls repeated after a transient error.\n# TYPE chef_docker_call_retries_total counter\n")
+	for _, op := range slices.Sorted(maps.Keys(m.retries)) {
+		fmt.Fprintf(w, "chef_docker_call_retries_total{call=\"%s\"} %d\n", op, m.retries[op])
+	}
+}
@@ -0,0 +1,119 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+// fakeDocker fails the first calls with the queued errors
+type fakeDocker struct {
+	client.APIClient
+	errs  []error
+	calls int
+	hang  bool
+}
+
+func (f *fakeDocker) next(ctx context.Context) error {
+	f.calls++
+	if f.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *fakeDocker) ImageInspectWithRaw(ctx context.Context, ref string) (dockertypes.ImageInspect, []byte, error) {
+	return dockertypes.ImageInspect{ID: ref}, nil, f.next(ctx)
+}
+
+func (f *fakeDocker) ImageBuild(ctx context.Context, _ io.Reader, _ dockertypes.ImageBuildOptions) (dockertypes.ImageBuildResponse, error) {
+	return dockertypes.ImageBuildResponse{Body: io.NopCloser(strings.NewReader(""))}, f.next(ctx)
+}
+
+func newTestDockerClient(api *fakeDocker, cfg config.DockerConfig) (*DockerClient, *DockerMetrics) {
+	metrics := NewDockerMetrics()
+	return newDockerClient(api, &cfg, metrics, zap.NewNop()), metrics
+}
+
+func TestDockerClient_Retries(t *testing.T) {
+	api := &fakeDocker{errs: []error{
+		errdefs.Unavailable(errors.New("daemon restarting")),
+		syscall.ECONNRESET,
+	}}
+	docker, metrics := newTestDockerClient(api, config.DockerConfig{RetryDelay: 1})
+
+	info, err := docker.ImageInspect(context.Background(), "chef-shop:1")
+	require.NoError(t, err)
+	assert.Equal(t, "chef-shop:1", info.ID)
+	assert.Equal(t, 3, api.calls)
+
+	// Errors that will not go away are returned at once
+	api.calls = 0
+	api.errs = []error{errdefs.NotFound(errors.New("no such image"))}
+	_, err = docker.ImageInspect(context.Background(), "chef-shop:2")
+	assert.True(t, errdefs.IsNotFound(err))
+	assert.Contains(t, err.Error(), "docker image inspect")
+	assert.Equal(t, 1, api.calls)
+
+	// Retries are bounded
+	api.calls = 0
+	api.errs = []error{syscall.ECONNREFUSED, syscall.ECONNREFUSED, syscall.ECONNREFUSED, syscall.ECONNREFUSED}
+	_, err = docker.ImageInspect(context.Background(), "chef-shop:3")
+	assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	assert.Equal(t, 4, api.calls)
+
+	// The build context cannot be sent twice
+	api.calls = 0
+	api.errs = []error{syscall.ECONNRESET}
+	_, err = docker.ImageBuild(context.Background(), strings.NewReader(""), dockertypes.ImageBuildOptions{})
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, 1, api.calls)
+
+	var out bytes.Buffer
+	metrics.WriteMetrics(&out)
+	assert.Contains(t, out.String(), `chef_docker_calls_total{call="image inspect",result="error"} 2`)
+	assert.Contains(t, out.String(), `chef_docker_calls_total{call="image inspect",result="success"} 1`)
+	assert.Contains(t, out.String(), `chef_docker_calls_total{call="image build",result="error"} 1`)
+	assert.Contains(t, out.String(), `chef_docker_call_retries_total{call="image inspect"} 5`)
+}
+
+func TestDockerClient_Timeout(t *testing.T) {
+	api := &fakeDocker{hang: true}
+	docker, _ := newTestDockerClient(api, config.DockerConfig{Retries: -1})
+	docker.timeout = 10 * time.Millisecond
+
+	_, err := docker.ImageInspect(context.Background(), "chef-shop:1")
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "docker image inspect: timed out after 10ms"), err.Error())
+	assert.Equal(t, 1, api.calls)
+
+	// A cancelled build is not retried
+	docker.retries = 3
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	docker.timeout = time.Minute
+	api.calls = 0
+	_, err = docker.ImageInspect(ctx, "chef-shop:1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, api.calls)
+}
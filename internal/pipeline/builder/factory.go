@@ -2,6 +2,8 @@ package builder
 
 import (
 	"fmt"
+	"io"
+	"sync"
 
 	"go.uber.org/zap"
 
@@ -11,6 +13,11 @@ import (
 type Factory struct {
 	config *config.PipelineConfig
 	logger *zap.Logger
+
+	// The Docker client is shared by all builds and created on first use
+	dockerMu      sync.Mutex
+	docker        *DockerClient
+	dockerMetrics *DockerMetrics
 }
 
 type FactoryInterface interface {
@@ -19,20 +26,39 @@ type FactoryInterface interface {
 
 func NewBuilderFactory(config *config.PipelineConfig, logger *zap.Logger) *Factory {
 	return &Factory{
-		config: config,
-		logger: logger,
+		config:        config,
+		logger:        logger,
+		dockerMetrics: NewDockerMetrics(),
 	}
 }
 
 func (f *Factory) CreateBuilder(framework string, options *Options) (Builder, error) {
 	switch framework {
 	case "react", "vue", "svelte", "angular":
-		builder, err := NewNodeJSBuilder(&f.config.NodeJS, options, f.logger)
+		docker, err := f.dockerClient()
 		if err != nil {
 			return nil, fmt.Errorf("failed to create nodejs builder: %w", err)
 		}
-		return builder, nil
+		return NewNodeJSBuilder(&f.config.NodeJS, docker, options, f.logger), nil
 	default:
 		return nil, fmt.Errorf("unsupported framework: %s", framework)
 	}
 }
+
+func (f *Factory) dockerClient() (*DockerClient, error) {
+	f.dockerMu.Lock()
+	defer f.dockerMu.Unlock()
+	if f.docker == nil {
+		docker, err := NewDockerClient(&f.config.Docker, f.dockerMetrics, f.logger.With(zap.String("component", "docker")))
+		if err != nil {
+			return nil, err
+		}
+		f.docker = docker
+	}
+	return f.docker, nil
+}
+
+// WriteMetrics writes the metrics of the Docker API calls of builds
+func (f *Factory) WriteMetrics(w io.Writer) {
+	f.dockerMetrics.WriteMetrics(w)
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"context"
+	"errors"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// Containers and images a build creates are labelled with its ID, so
+// those left behind by a failed build or a stopped server can be found.
+// Containers and images only used while the build runs are labelled as
+// stages too; containers of the build's image inherit only its ID.
+const (
+	BuildLabel = "chef.build"
+	StageLabel = "chef.stage"
+)
+
+func buildLabels(buildID string) map[string]string {
+	return map[string]string{BuildLabel: buildID}
+}
+
+func stageLabels(buildID string) map[string]string {
+	return map[string]string{BuildLabel: buildID, StageLabel: "true"}
+}
+
+// RemoveLeftovers removes the containers and stage images of a build that
+// did not finish, e.g. one interrupted by a server restart. The build's
+// image is kept since a deploy may already use it.
+func (f *Factory) RemoveLeftovers(ctx context.Context, build *types.Build) error {
+	docker, err := f.dockerClient()
+	if err != nil {
+		return err
+	}
+	return docker.removeLeftovers(ctx, build)
+}
+
+func (c *DockerClient) removeLeftovers(ctx context.Context, build *types.Build) error {
+	stages := filters.NewArgs(filters.Arg("label", BuildLabel+"="+build.ID), filters.Arg("label", StageLabel))
+	containers, err := c.ContainerList(ctx, container.ListOptions{All: true, Filters: stages})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, leftover := range containers {
+		err := c.ContainerRemove(ctx, leftover.ID, container.RemoveOptions{RemoveVolumes: true, Force: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	images, err := c.ImageList(ctx, image.ListOptions{Filters: stages})
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, stage := range images {
+		err := c.ImageRemove(ctx, stage.ID, image.RemoveOptions{Force: true, PruneChildren: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
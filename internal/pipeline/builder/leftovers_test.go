@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// leftoverDocker lists one container and two stage images of a build and
+// records removals
+type leftoverDocker struct {
+	client.APIClient
+	filter      []string
+	imageFilter []string
+	containers  []string
+	images      []string
+}
+
+func (d *leftoverDocker) ContainerList(_ context.Context, options container.ListOptions) ([]dockertypes.Container, error) {
+	d.filter = options.Filters.Get("label")
+	return []dockertypes.Container{{ID: "c1"}}, nil
+}
+
+func (d *leftoverDocker) ContainerRemove(_ context.Context, id string, _ container.RemoveOptions) error {
+	d.containers = append(d.containers, id)
+	return nil
+}
+
+func (d *leftoverDocker) ImageList(_ context.Context, options image.ListOptions) ([]image.Summary, error) {
+	d.imageFilter = options.Filters.Get("label")
+	return []image.Summary{{ID: "sha256:output"}, {ID: "sha256:test"}}, nil
+}
+
+func (d *leftoverDocker) ImageRemove(_ context.Context, ref string, _ image.RemoveOptions) ([]image.DeleteResponse, error) {
+	d.images = append(d.images, ref)
+	if ref == "sha256:test" {
+		return nil, errdefs.NotFound(errors.New("no such image"))
+	}
+	return nil, nil
+}
+
+func TestDockerClient_RemoveLeftovers(t *testing.T) {
+	api := &leftoverDocker{}
+	docker := newDockerClient(api, &config.DockerConfig{}, nil, zap.NewNop())
+
+	err := docker.removeLeftovers(context.Background(), &types.Build{ID: "b1", ProjectID: "shop"})
+	require.NoError(t, err, "images already gone are not an error")
+	assert.ElementsMatch(t, []string{BuildLabel + "=b1", StageLabel}, api.filter)
+	assert.Equal(t, []string{"c1"}, api.containers)
+	assert.ElementsMatch(t, []string{BuildLabel + "=b1", StageLabel}, api.imageFilter)
+	assert.Equal(t, []string{"sha256:output", "sha256:test"}, api.images)
+}
+
+func TestRemoveBuildContext(t *testing.T) {
+	layout, err := NewLayout(&config.PipelineConfig{BuildDir: t.TempDir()})
+	require.NoError(t, err)
+	bc, err := NewBuildContext(layout, "b1")
+	require.NoError(t, err)
+
+	require.NoError(t, RemoveBuildContext(layout, "b1", true))
+	assert.NoDirExists(t, bc.BuildDir)
+	assert.NoDirExists(t, bc.CacheDir)
+	assert.DirExists(t, bc.ArtifactDir)
+
+	require.NoError(t, RemoveBuildContext(layout, "b1", false))
+	assert.NoDirExists(t, bc.ArtifactDir)
+}
@@ -0,0 +1,134 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/elskow/chef-infra/internal/pipeline/diagnose"
+	"github.com/elskow/chef-infra/internal/pipeline/manifest"
+)
+
+// minBuildMemory is the smallest memory limit npm installs fit in
+const minBuildMemory = 256 << 20
+
+// buildMemory is the memory limit of a build's container and Node's heap
+// limit within it
+type buildMemory struct {
+	limit  string // As configured, e.g. "4Gi", empty when unlimited
+	bytes  int64
+	heapMB int // Node's default when 0
+}
+
+// OutOfMemoryError is returned when a build's container was killed for
+// exceeding its memory limit or Node exceeded its heap limit
+type OutOfMemoryError struct {
+	Memory string // Memory limit of the build container, empty when unlimited
+	HeapMB int    // Node's heap limit in megabytes, 0 when Node's default
+	Err    error
+}
+
+func (e *OutOfMemoryError) Error() string {
+	limit := "no memory limit"
+	if e.Memory != "" {
+		limit = "a memory limit of " + e.Memory
+	}
+	if e.HeapMB > 0 {
+		limit += fmt.Sprintf(" and a Node heap limit of %d MB", e.HeapMB)
+	}
+	return fmt.Sprintf("OUT_OF_MEMORY: build ran out of memory with %s: %v", limit, e.Err)
+}
+
+func (e *OutOfMemoryError) Unwrap() error {
+	return e.Err
+}
+
+// Details returns the limits the build ran with
+func (e *OutOfMemoryError) Details() []string {
+	details := []string{"memory limit: none"}
+	if e.Memory != "" {
+		details[0] = "memory limit: " + e.Memory
+	}
+	if e.HeapMB > 0 {
+		details = append(details, fmt.Sprintf("node heap limit: %d MB", e.HeapMB))
+	}
+	return details
+}
+
+// memoryOf returns the memory of a build, chef.yaml's settings over the
+// server's default
+func (b *NodeJSBuilder) memoryOf(settings manifest.Build) (buildMemory, error) {
+	memory := buildMemory{limit: settings.Memory, heapMB: settings.MaxOldSpaceSize}
+	if memory.limit == "" {
+		memory.limit = b.config.BuildMemory
+	}
+	if memory.limit == "" {
+		return memory, nil
+	}
+
+	quantity, err := resource.ParseQuantity(memory.limit)
+	if err != nil {
+		return memory, fmt.Errorf("invalid build memory %q: %w", memory.limit, err)
+	}
+	memory.bytes = quantity.Value()
+	if memory.bytes < minBuildMemory {
+		return memory, fmt.Errorf("build memory %s is below the minimum of 256Mi", memory.limit)
+	}
+	// The rest is left to npm and native modules outside the heap
+	if memory.heapMB == 0 {
+		memory.heapMB = int(memory.bytes * 3 / 4 >> 20)
+	}
+	if int64(memory.heapMB)<<20 >= memory.bytes {
+		return memory, fmt.Errorf("max_old_space_size of %d MB must be below the build memory of %s", memory.heapMB, memory.limit)
+	}
+	return memory, nil
+}
+
+// nodeOptions adds the heap limit to the NODE_OPTIONS of the project's
+// build environment
+func (m buildMemory) nodeOptions(env map[string]string) string {
+	if m.heapMB == 0 {
+		return env["NODE_OPTIONS"]
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s --max-old-space-size=%d", env["NODE_OPTIONS"], m.heapMB))
+}
+
+// heapStep passes NODE_OPTIONS to every stage running Node. An ENV set from
+// the build argument keeps it out of the Dockerfile and is inherited by the
+// stages built on the base.
+func heapStep(memory buildMemory) string {
+	if memory.heapMB == 0 {
+		return ""
+	}
+	return "ARG NODE_OPTIONS\nENV NODE_OPTIONS=\"$NODE_OPTIONS\""
+}
+
+// heapOnly is the memory of the steps buildx builds. BuildKit has no
+// memory limit per build, Node's heap limit still applies.
+func (m buildMemory) heapOnly() buildMemory {
+	return buildMemory{heapMB: m.heapMB}
+}
+
+// buildxWarning tells that the memory limit does not apply to the steps
+// buildx builds, empty without a limit
+func (m buildMemory) buildxWarning() string {
+	if m.limit == "" {
+		return ""
+	}
+	return fmt.Sprintf("build memory %s is not enforced on steps built with BuildKit, used for private npm registries and multi-platform images; only the Node heap limit of %d MB applies to them", m.limit, m.heapMB)
+}
+
+// outOfMemory wraps the error of a build step that ran out of memory
+func (m buildMemory) outOfMemory(err error) error {
+	output := err.Error()
+	var outputErr *OutputError
+	if errors.As(err, &outputErr) {
+		output = strings.Join(append(append([]string(nil), outputErr.Output...), output), "\n")
+	}
+	if diagnosis := diagnose.Analyze(output); diagnosis == nil || diagnosis.Rule != diagnose.RuleOutOfMemory {
+		return err
+	}
+	return &OutOfMemoryError{Memory: m.limit, HeapMB: m.heapMB, Err: err}
+}
@@ -0,0 +1,76 @@
+package builder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/manifest"
+)
+
+func TestMemoryOf(t *testing.T) {
+	b := &NodeJSBuilder{config: &config.NodeJSConfig{BuildMemory: "2Gi"}}
+
+	memory, err := b.memoryOf(manifest.Build{})
+	require.NoError(t, err)
+	assert.Equal(t, buildMemory{limit: "2Gi", bytes: 2 << 30, heapMB: 1536}, memory)
+
+	memory, err = b.memoryOf(manifest.Build{Memory: "8Gi", MaxOldSpaceSize: 4096})
+	require.NoError(t, err)
+	assert.Equal(t, buildMemory{limit: "8Gi", bytes: 8 << 30, heapMB: 4096}, memory)
+	assert.Equal(t, "--max-old-space-size=4096", memory.nodeOptions(nil))
+	assert.Equal(t, "--enable-source-maps --max-old-space-size=4096", memory.nodeOptions(map[string]string{"NODE_OPTIONS": "--enable-source-maps"}))
+
+	_, err = b.memoryOf(manifest.Build{MaxOldSpaceSize: 4096})
+	assert.EqualError(t, err, "max_old_space_size of 4096 MB must be below the build memory of 2Gi")
+	_, err = b.memoryOf(manifest.Build{Memory: "128Mi"})
+	assert.EqualError(t, err, "build memory 128Mi is below the minimum of 256Mi")
+
+	unlimited := &NodeJSBuilder{config: &config.NodeJSConfig{}}
+	memory, err = unlimited.memoryOf(manifest.Build{})
+	require.NoError(t, err)
+	assert.Empty(t, heapStep(memory))
+	assert.Empty(t, memory.nodeOptions(nil))
+}
+
+func TestOutOfMemory(t *testing.T) {
+	memory := buildMemory{limit: "2Gi", bytes: 2 << 30, heapMB: 1536}
+
+	killed := &OutputError{
+		Err:    errors.New("docker build error: The command '/bin/sh -c npm run build' returned a non-zero code: 137"),
+		Output: []string{"Step 12/14 : RUN npm run build"},
+	}
+	err := memory.outOfMemory(killed)
+	var memoryErr *OutOfMemoryError
+	require.ErrorAs(t, err, &memoryErr)
+	assert.ErrorIs(t, err, killed)
+	assert.Equal(t, "OUT_OF_MEMORY: build ran out of memory with a memory limit of 2Gi and a Node heap limit of 1536 MB: "+killed.Error(), err.Error())
+
+	heap := &OutputError{
+		Err:    errors.New("docker build error: The command '/bin/sh -c npm run build' returned a non-zero code: 134"),
+		Output: []string{"FATAL ERROR: Reached heap limit Allocation failed - JavaScript heap out of memory"},
+	}
+	assert.ErrorAs(t, memory.outOfMemory(heap), &memoryErr)
+
+	failed := &OutputError{Err: errors.New("docker build error: The command '/bin/sh -c npm run build' returned a non-zero code: 1")}
+	assert.Same(t, failed, memory.outOfMemory(failed))
+}
+
+func TestBuildxMemory(t *testing.T) {
+	memory := buildMemory{limit: "2Gi", bytes: 2 << 30, heapMB: 1536}
+	assert.Contains(t, memory.buildxWarning(), "build memory 2Gi is not enforced")
+	assert.Empty(t, buildMemory{}.buildxWarning())
+
+	// Steps built by buildx only ran with the heap limit
+	heap := &OutputError{
+		Err:    errors.New("buildx build failed: exit status 1"),
+		Output: []string{"FATAL ERROR: Reached heap limit Allocation failed - JavaScript heap out of memory"},
+	}
+	err := memory.heapOnly().outOfMemory(heap)
+	var memoryErr *OutOfMemoryError
+	require.ErrorAs(t, err, &memoryErr)
+	assert.Equal(t, []string{"memory limit: none", "node heap limit: 1536 MB"}, memoryErr.Details())
+}
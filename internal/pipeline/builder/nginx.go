@@ -0,0 +1,148 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/pipeline/manifest"
+)
+
+// nginxConfigFile is written next to the sources and copied into the
+// runtime image
+const nginxConfigFile = ".chef-nginx.conf"
+
+// compressibleTypes are compressed by gzip and brotli; text/html always is
+var compressibleTypes = []string{
+	"text/plain",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/xml",
+	"image/svg+xml",
+	"font/ttf",
+	"font/otf",
+}
+
+// nginxConfig renders the server block for the static site
+func nginxConfig(serve manifest.Serve) string {
+	tryFiles := "$uri $uri/ =404"
+	if serve.SPAEnabled() {
+		tryFiles = "$uri $uri/ /index.html"
+	}
+
+	var headers []string
+	for name, value := range serve.Headers {
+		headers = append(headers, fmt.Sprintf("add_header %s %s always;", name, nginxQuote(value)))
+	}
+	sort.Strings(headers)
+
+	var b strings.Builder
+	b.WriteString("server {\n")
+	b.WriteString("    listen 80;\n")
+	b.WriteString("    server_name _;\n")
+	b.WriteString("    root /usr/share/nginx/html;\n")
+	b.WriteString("    index index.html;\n")
+
+	if serve.GzipEnabled() {
+		b.WriteString("\n    gzip on;\n")
+		b.WriteString("    gzip_vary on;\n")
+		b.WriteString("    gzip_min_length 1024;\n")
+		fmt.Fprintf(&b, "    gzip_types %s;\n", strings.Join(compressibleTypes, " "))
+	}
+	if serve.Brotli {
+		b.WriteString("\n    brotli on;\n")
+		b.WriteString("    brotli_min_length 1024;\n")
+		fmt.Fprintf(&b, "    brotli_types %s;\n", strings.Join(compressibleTypes, " "))
+	}
+
+	// nginx drops inherited add_header directives in any location that
+	// sets its own, so every location repeats the custom headers
+	writeLocation := func(match string, extra ...string) {
+		fmt.Fprintf(&b, "\n    location %s {\n", match)
+		for _, line := range append(extra, headers...) {
+			fmt.Fprintf(&b, "        %s\n", line)
+		}
+		fmt.Fprintf(&b, "        try_files %s;\n", tryFiles)
+		b.WriteString("    }\n")
+	}
+	for _, rule := range serve.Cache {
+		writeLocation(fmt.Sprintf("~ %s", nginxQuote(patternRegexp(rule.Pattern))),
+			fmt.Sprintf("add_header Cache-Control %s always;", nginxQuote(rule.Control)))
+	}
+	writeLocation("/")
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// patternRegexp converts a cache rule pattern into an anchored regular
+// expression over the request path
+func patternRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^/")
+	if strings.HasPrefix(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		b.WriteString("(.*/)?")
+	}
+
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+			i++
+		case pattern[i] == '?':
+			b.WriteString("[^/]")
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			i++
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// nginxQuote wraps s in double quotes with quotes and backslashes escaped
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// runtimeStage is the final Dockerfile stage serving outputDir. Brotli
+// needs a module the official nginx image does not ship, so those sites
+// run on Alpine's nginx package instead.
+func runtimeStage(serve manifest.Serve, outputDir string) string {
+	if serve.Brotli {
+		return fmt.Sprintf(`FROM %s
+RUN apk add --no-cache nginx nginx-mod-http-brotli
+COPY %s /etc/nginx/http.d/default.conf
+COPY --from=build /app/%s /usr/share/nginx/html
+EXPOSE 80
+CMD ["nginx", "-g", "daemon off;"]
+`, runtimeImage(serve), nginxConfigFile, outputDir)
+	}
+
+	return fmt.Sprintf(`FROM %s
+COPY %s /etc/nginx/conf.d/default.conf
+COPY --from=build /app/%s /usr/share/nginx/html
+EXPOSE 80
+`, runtimeImage(serve), nginxConfigFile, outputDir)
+}
+
+// runtimeImage is the base image of the runtime stage
+func runtimeImage(serve manifest.Serve) string {
+	if serve.Brotli {
+		return "alpine:3.20"
+	}
+	return "nginx:alpine"
+}
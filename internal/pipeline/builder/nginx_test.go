@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/pipeline/manifest"
+)
+
+func TestNginxConfig(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		conf := nginxConfig(manifest.Serve{})
+		assert.Contains(t, conf, "try_files $uri $uri/ /index.html;")
+		assert.Contains(t, conf, "gzip on;")
+		assert.NotContains(t, conf, "brotli")
+	})
+
+	t.Run("custom settings", func(t *testing.T) {
+		spa := false
+		conf := nginxConfig(manifest.Serve{
+			SPA:     &spa,
+			Brotli:  true,
+			Headers: map[string]string{"Content-Security-Policy": `default-src 'self'`, "X-Note": `say "hi"`},
+			Cache:   []manifest.CacheRule{{Pattern: "/assets/**", Control: "public, max-age=31536000, immutable"}},
+		})
+		assert.Contains(t, conf, "try_files $uri $uri/ =404;")
+		assert.Contains(t, conf, "brotli on;")
+		assert.Contains(t, conf, `location ~ "^/assets/.*$" {`)
+		assert.Contains(t, conf, `add_header Cache-Control "public, max-age=31536000, immutable" always;`)
+		assert.Contains(t, conf, `add_header X-Note "say \"hi\"" always;`)
+		// Both locations repeat the custom headers
+		assert.Len(t, regexp.MustCompile(`Content-Security-Policy`).FindAllString(conf, -1), 2)
+	})
+}
+
+func TestPatternRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.js", "/app.js", true},
+		{"*.js", "/assets/app.js", true},
+		{"*.js", "/app.json", false},
+		{"/index.html", "/index.html", true},
+		{"/index.html", "/docs/index.html", false},
+		{"/assets/*", "/assets/app.css", true},
+		{"/assets/*", "/assets/img/logo.png", false},
+		{"/assets/**", "/assets/img/logo.png", true},
+		{"/static/**/*.woff2", "/static/font.woff2", true},
+		{"/static/**/*.woff2", "/static/fonts/a/font.woff2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.match, regexp.MustCompile(patternRegexp(tt.pattern)).MatchString(tt.path))
+		})
+	}
+}
+
+func TestRuntimeStage(t *testing.T) {
+	assert.Contains(t, runtimeStage(manifest.Serve{}, "dist"), "FROM nginx:alpine")
+	brotli := runtimeStage(manifest.Serve{Brotli: true}, "dist")
+	assert.Contains(t, brotli, "nginx-mod-http-brotli")
+	assert.Contains(t, brotli, "COPY --from=build /app/dist /usr/share/nginx/html")
+}
@@ -11,9 +11,13 @@ import (
 
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/envtemplate"
+	"github.com/elskow/chef-infra/internal/pipeline/manifest"
+	"github.com/elskow/chef-infra/internal/pipeline/source"
 	pipelinetypes "github.com/elskow/chef-infra/internal/pipeline/types"
 	"go.uber.org/zap"
 )
@@ -22,81 +26,233 @@ type NodeJSBuilder struct {
 	config    *config.NodeJSConfig
 	options   *Options
 	logger    *zap.Logger
-	dockerCli *client.Client
+	dockerCli *DockerClient
+	// Private registries of the build's project, their tokens are passed
+	// to buildx as a secret
+	registries []config.NPMRegistryConfig
+	memory     buildMemory
 }
 
-func NewNodeJSBuilder(config *config.NodeJSConfig, options *Options, logger *zap.Logger) (*NodeJSBuilder, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create docker client: %w", err)
-	}
-
+func NewNodeJSBuilder(config *config.NodeJSConfig, docker *DockerClient, options *Options, logger *zap.Logger) *NodeJSBuilder {
 	return &NodeJSBuilder{
 		config:    config,
 		options:   options,
 		logger:    logger,
-		dockerCli: cli,
-	}, nil
+		dockerCli: docker,
+	}
 }
 
 func (b *NodeJSBuilder) Build(ctx context.Context, build *pipelinetypes.Build) (*pipelinetypes.BuildResult, error) {
-	b.logger.Info("starting nodejs build in docker",
-		zap.String("project", build.ProjectID),
-		zap.String("commit", build.CommitHash))
+	buildlog.Logger(ctx, b.logger).Info("starting nodejs build in docker")
+
+	b.registries = b.npmRegistries(build.ProjectID)
+	if err := checkNPMRegistries(ctx, b.registries); err != nil {
+		return nil, err
+	}
 
 	// Create build directory and prepare files
 	buildDir := filepath.Join(b.options.WorkDir, build.ID)
-	if err := b.prepareBuildDirectory(buildDir, build); err != nil {
+	err := b.options.Timeouts.RunPhase(ctx, pipelinetypes.PhaseClone, func(ctx context.Context) error {
+		return b.prepareBuildDirectory(ctx, buildDir, build)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("failed to prepare build directory: %w", err)
 	}
 
+	// The project's chef.yaml was copied along with the sources
+	settings, err := manifest.Load(buildDir)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := b.options.Timeouts.Override(settings.Timeouts.Phases())
+	if b.memory, err = b.memoryOf(settings.Build); err != nil {
+		return nil, err
+	}
+
 	// Create Dockerfile
-	if err := b.createDockerfile(buildDir, build); err != nil {
+	baseImages, err := b.createDockerfile(buildDir, build, settings)
+	if err != nil {
 		return nil, fmt.Errorf("failed to create dockerfile: %w", err)
 	}
 
+	// Multi-platform builds are installed, tested and checked on the
+	// host platform
+	platforms := build.Platforms
+	platform := ""
+	if len(platforms) == 1 {
+		platform = platforms[0]
+	}
+	if warning := b.memory.buildxWarning(); warning != "" && (len(platforms) > 1 || len(b.registries) > 0) {
+		buildlog.Logger(ctx, b.logger).Warn("build memory not enforced", zap.String("warning", warning))
+		pipelinetypes.UpdateBuild(ctx, build, func(b *pipelinetypes.Build) {
+			b.Warnings = append(b.Warnings, warning)
+		})
+	}
+	err = timeouts.RunPhase(ctx, pipelinetypes.PhaseInstall, func(ctx context.Context) error {
+		return b.installDependencies(ctx, buildDir, build, platform)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var testResults *pipelinetypes.TestResults
+	var coverage *pipelinetypes.Coverage
+	toolchain := &pipelinetypes.Toolchain{}
+	var sourceMaps *string
+	sourceMapsPath := ""
+	if settings.Build.SourceMaps == pipelinetypes.SourceMapsUpload {
+		sourceMaps = &sourceMapsPath
+	}
+	err = timeouts.RunPhase(ctx, pipelinetypes.PhaseBuild, func(ctx context.Context) error {
+		if settings.Test.Script != "" {
+			var err error
+			testResults, coverage, err = b.runTests(ctx, buildDir, build, settings.Test)
+			if err != nil {
+				return err
+			}
+			if err := checkTests(settings.Test, testResults, coverage); err != nil {
+				return err
+			}
+		}
+		// Catch a wrong output directory before the runtime stage fails
+		// to copy it
+		return b.checkOutputDir(ctx, buildDir, build, platform, b.outputDir(build), toolchain, sourceMaps)
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	imageTag := fmt.Sprintf("chef-%s:%s", build.ProjectID, build.ID)
 	if build.CommitHash != "" {
 		imageTag = fmt.Sprintf("chef-%s:%s", build.ProjectID, build.CommitHash)
 	}
 
+	imageID := imageTag
+	err = timeouts.RunPhase(ctx, pipelinetypes.PhasePackage, func(ctx context.Context) error {
+		if len(platforms) > 1 {
+			ref, err := b.buildMultiPlatform(ctx, buildDir, imageTag, platforms, buildLabels(build.ID))
+			if err != nil {
+				return err
+			}
+			imageID = ref
+		} else if err := b.buildImage(ctx, buildDir, imageTag, platform, buildLabels(build.ID)); err != nil {
+			return err
+		}
+
+		// Create artifact from build output
+		if err := b.createArtifactFromContainer(ctx, build); err != nil {
+			return fmt.Errorf("failed to create artifact: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &pipelinetypes.BuildResult{
+		Success:        true,
+		ArtifactPath:   ArtifactPath(b.options.ArtifactDir, build.ID),
+		ImageID:        imageID,
+		BaseImages:     baseImages,
+		TestResults:    testResults,
+		Coverage:       coverage,
+		Toolchain:      toolchain,
+		AddOns:         settings.AddOns,
+		Jobs:           settings.BuildJobs(),
+		Processes:      settings.BuildProcesses(),
+		Hooks:          settings.BuildHooks(),
+		SourceMaps:     settings.Build.SourceMaps,
+		SourceMapsPath: sourceMapsPath,
+		Timeouts:       settings.Timeouts.Phases(),
+	}
+	b.toolchain(ctx, toolchain, baseImages, len(platforms) > 1 || len(b.registries) > 0)
+	if info, err := b.dockerCli.ImageInspect(ctx, imageTag); err == nil {
+		result.ImageSize = info.Size
+	} else {
+		buildlog.Logger(ctx, b.logger).Warn("failed to inspect image", zap.String("image", imageTag), zap.Error(err))
+	}
+	return result, nil
+}
+
+// installDependencies builds the deps stage so installing is timed on its
+// own; later stages reuse its cached layers
+func (b *NodeJSBuilder) installDependencies(ctx context.Context, buildDir string, build *pipelinetypes.Build, platform string) error {
+	tag := fmt.Sprintf("chef-deps-%s:%s", build.ProjectID, build.ID)
+	if err := b.buildTarget(ctx, buildDir, tag, platform, "deps", stageLabels(build.ID)); err != nil {
+		return fmt.Errorf("failed to install dependencies: %w", err)
+	}
+	if err := b.dockerCli.ImageRemove(context.Background(), tag, image.RemoveOptions{Force: true}); err != nil {
+		buildlog.Logger(ctx, b.logger).Warn("failed to remove dependencies image", zap.String("image", tag), zap.Error(err))
+	}
+	return nil
+}
+
+func (b *NodeJSBuilder) buildImage(ctx context.Context, buildDir, imageTag, platform string, labels map[string]string) error {
+	return b.buildTarget(ctx, buildDir, imageTag, platform, "", labels)
+}
+
+// buildTarget builds the Dockerfile up to target, or completely when
+// target is empty, and labels the image
+func (b *NodeJSBuilder) buildTarget(ctx context.Context, buildDir, imageTag, platform, target string, labels map[string]string) error {
+	// Secrets need BuildKit, the daemon's classic builder has none
+	if len(b.registries) > 0 {
+		args := []string{"--tag", imageTag, "--load"}
+		if platform != "" {
+			args = append(args, "--platform", platform)
+		}
+		if target != "" {
+			args = append(args, "--target", target)
+		}
+		return b.buildx(ctx, buildDir, args, labels)
+	}
+
 	// Build Docker image with proper error handling
 	buildOpts := dockertypes.ImageBuildOptions{
 		Dockerfile: "Dockerfile",
 		Tags:       []string{imageTag},
 		Remove:     true,
-		// Add build args if needed
+		Platform:   platform,
+		Target:     target,
+		Labels:     labels,
+		Memory:     b.memory.bytes,
+		MemorySwap: b.memory.bytes, // No swap beyond the limit
 		BuildArgs: map[string]*string{
 			"NODE_ENV": &[]string{"production"}[0],
 		},
 	}
+	for key, value := range b.buildArgValues() {
+		buildOpts.BuildArgs[key] = &value
+	}
 
 	buildContext := b.createBuildContext(buildDir)
 	if buildContext == nil {
-		return nil, fmt.Errorf("failed to create build context")
+		return fmt.Errorf("failed to create build context")
 	}
 
-	resp, err := b.dockerCli.ImageBuild(ctx, buildContext, buildOpts)
+	output, err := b.dockerCli.ImageBuild(ctx, buildContext, buildOpts)
 	if err != nil {
-		return nil, fmt.Errorf("docker build failed: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
+	defer output.Close()
 
 	// Process build output
-	if err := b.processBuildOutput(resp.Body); err != nil {
-		return nil, err
+	if err := b.processBuildOutput(ctx, output); err != nil {
+		return b.memory.outOfMemory(err)
 	}
+	return nil
+}
 
-	// Create artifact from build output
-	if err := b.createArtifactFromContainer(ctx, build); err != nil {
-		return nil, fmt.Errorf("failed to create artifact: %w", err)
+// buildArgValues returns the values of the build arguments: the project's
+// build environment and NODE_OPTIONS with its heap limit
+func (b *NodeJSBuilder) buildArgValues() map[string]string {
+	values := make(map[string]string, len(b.options.Environment)+1)
+	for key, value := range b.options.Environment {
+		values[key] = value
 	}
-
-	return &pipelinetypes.BuildResult{
-		Success:      true,
-		ArtifactPath: filepath.Join(b.options.WorkDir, "artifacts", fmt.Sprintf("%s.tar.gz", build.ID)),
-		ImageID:      imageTag,
-	}, nil
+	if options := b.memory.nodeOptions(b.options.Environment); options != "" {
+		values["NODE_OPTIONS"] = options
+	}
+	return values
 }
 
 func (b *NodeJSBuilder) Validate(build *pipelinetypes.Build) error {
@@ -104,8 +260,12 @@ func (b *NodeJSBuilder) Validate(build *pipelinetypes.Build) error {
 	if build.BuildCommand == "" {
 		return fmt.Errorf("build command is required")
 	}
-	if build.OutputDir == "" {
-		return fmt.Errorf("output directory is required")
+	dir := b.outputDir(build)
+	if dir == "" {
+		return fmt.Errorf("output directory is required, framework %q has no default", build.Framework)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(dir)) {
+		return fmt.Errorf("output directory must be a path inside the project: %s", dir)
 	}
 	if build.BuilderConfig == nil {
 		return fmt.Errorf("builder configuration is required")
@@ -129,18 +289,36 @@ func (b *NodeJSBuilder) Validate(build *pipelinetypes.Build) error {
 	return nil
 }
 
-func (b *NodeJSBuilder) createDockerfile(buildDir string, build *pipelinetypes.Build) error {
+// createDockerfile writes the Dockerfile and returns the base images it
+// builds on
+func (b *NodeJSBuilder) createDockerfile(buildDir string, build *pipelinetypes.Build, settings *manifest.Manifest) ([]string, error) {
+	nodeVersion := build.NodeVersion
+	if nodeVersion == "" {
+		nodeVersion = b.config.DefaultVersion
+	}
+
+	if err := os.WriteFile(filepath.Join(buildDir, nginxConfigFile), []byte(nginxConfig(settings.Serve)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write nginx config: %w", err)
+	}
+
+	baseImages := []string{fmt.Sprintf("node:%s-alpine", nodeVersion), runtimeImage(settings.Serve)}
+	// Named stages let a failed build be debugged from the last one that
+	// built, see DebugImage
 	dockerfile := fmt.Sprintf(`
-FROM node:%s-alpine
+FROM %s AS base
 
 WORKDIR /app
+%s
+%s
 
 # Add build dependencies
 RUN apk add --no-cache python3 make g++
 
 # Copy package files
 COPY package*.json ./
-RUN npm install
+
+FROM base AS deps
+%s
 
 # Copy source files
 COPY . .
@@ -148,16 +326,31 @@ COPY . .
 # Set environment variables
 ENV NODE_ENV=production
 ENV CI=true
-
+%s
+FROM deps AS build
+%s
 # Build the application
 RUN npm run %s
+%s
+%s
+
+%s`, baseImages[0], toolchainStep(), heapStep(b.memory), npmInstallStep(b.registries), testStage(settings.Test), buildArgs(b.options.Environment), build.BuildCommand, outputCandidatesStep(), sourceMapsStep(settings.Build.SourceMaps, b.outputDir(build)), runtimeStage(settings.Serve, b.outputDir(build)))
 
-FROM nginx:alpine
-COPY --from=0 /app/%s /usr/share/nginx/html
-EXPOSE 80
-`, b.config.DefaultVersion, build.BuildCommand, build.OutputDir)
+	if err := os.WriteFile(filepath.Join(buildDir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		return nil, err
+	}
+	return baseImages, nil
+}
 
-	return os.WriteFile(filepath.Join(buildDir, "Dockerfile"), []byte(dockerfile), 0644)
+// buildArgs declares the build-time variables so the build command sees
+// them. Values are passed as build arguments rather than written into the
+// Dockerfile and never reach the runtime stage.
+func buildArgs(env map[string]string) string {
+	var b strings.Builder
+	for _, key := range envtemplate.SortedKeys(env) {
+		fmt.Fprintf(&b, "ARG %s\n", key)
+	}
+	return b.String()
 }
 
 func (b *NodeJSBuilder) createBuildContext(buildDir string) io.Reader {
@@ -168,7 +361,40 @@ func (b *NodeJSBuilder) createBuildContext(buildDir string) io.Reader {
 	return tar
 }
 
-func (b *NodeJSBuilder) processBuildOutput(reader io.Reader) error {
+// maxOutputLines bounds the build output kept for diagnosing failures
+const maxOutputLines = 200
+
+// OutputError fails a build with the last lines of its output
+type OutputError struct {
+	Err    error
+	Output []string
+}
+
+func (e *OutputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// outputTail keeps the last maxOutputLines lines written to it
+type outputTail struct {
+	lines []string
+}
+
+func (t *outputTail) add(text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if len(t.lines) == maxOutputLines {
+			t.lines = append(t.lines[:0], t.lines[1:]...)
+		}
+		t.lines = append(t.lines, line)
+	}
+}
+
+func (b *NodeJSBuilder) processBuildOutput(ctx context.Context, reader io.Reader) error {
+	logger := buildlog.Logger(ctx, b.logger)
+	var tail outputTail
 	decoder := json.NewDecoder(reader)
 	for {
 		var message struct {
@@ -186,22 +412,23 @@ func (b *NodeJSBuilder) processBuildOutput(reader io.Reader) error {
 		}
 
 		if message.Error != "" {
-			return fmt.Errorf("docker build error: %s", message.Error)
+			return &OutputError{Err: fmt.Errorf("docker build error: %s", message.Error), Output: tail.lines}
 		}
 
 		// Log all types of Docker messages
 		if message.Stream != "" {
-			b.logger.Debug("docker build output", zap.String("output", strings.TrimSpace(message.Stream)))
+			tail.add(message.Stream)
+			logger.Debug("docker build output", zap.String("output", strings.TrimSpace(message.Stream)))
 		}
 		if message.Status != "" {
-			b.logger.Debug("docker status",
+			logger.Debug("docker status",
 				zap.String("status", message.Status),
 				zap.String("id", message.ID))
 		}
 	}
 }
 
-func (b *NodeJSBuilder) prepareBuildDirectory(buildDir string, build *pipelinetypes.Build) error {
+func (b *NodeJSBuilder) prepareBuildDirectory(ctx context.Context, buildDir string, build *pipelinetypes.Build) error {
 	// Create build directory
 	if err := os.MkdirAll(buildDir, 0755); err != nil {
 		return fmt.Errorf("failed to create build directory: %w", err)
@@ -209,21 +436,24 @@ func (b *NodeJSBuilder) prepareBuildDirectory(buildDir string, build *pipelinety
 
 	// Copy source files to build directory
 	sourceDir := build.BuilderConfig["sourceDir"].(string)
-	if err := b.copySourceFiles(sourceDir, buildDir); err != nil {
+	if err := b.copySourceFiles(ctx, sourceDir, buildDir); err != nil {
 		return fmt.Errorf("failed to copy source files: %w", err)
 	}
 
 	return nil
 }
 
-func (b *NodeJSBuilder) copySourceFiles(sourceDir, targetDir string) error {
+func (b *NodeJSBuilder) copySourceFiles(ctx context.Context, sourceDir, targetDir string) error {
 	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		// Skip node_modules and .git
-		if info.IsDir() && (info.Name() == "node_modules" || info.Name() == ".git") {
+		if info.IsDir() && source.Ignored(info.Name()) {
 			return filepath.SkipDir
 		}
 
@@ -266,8 +496,7 @@ func (b *NodeJSBuilder) createArtifactFromContainer(ctx context.Context, build *
 	}
 
 	// Verify image exists before creating container
-	_, _, err := b.dockerCli.ImageInspectWithRaw(ctx, imageTag)
-	if err != nil {
+	if _, err := b.dockerCli.ImageInspect(ctx, imageTag); err != nil {
 		return fmt.Errorf("image not found: %s: %w", imageTag, err)
 	}
 
@@ -275,7 +504,7 @@ func (b *NodeJSBuilder) createArtifactFromContainer(ctx context.Context, build *
 		Image: imageTag,
 	}
 
-	containerID, err := b.createContainer(ctx, containerConfig)
+	containerID, err := b.createContainer(ctx, build.ID, containerConfig)
 	if err != nil {
 		return err
 	}
@@ -286,24 +515,22 @@ func (b *NodeJSBuilder) createArtifactFromContainer(ctx context.Context, build *
 		})
 
 		if err != nil {
-			b.logger.Warn("failed to remove container", zap.String("container", containerID), zap.Error(err))
+			buildlog.Logger(ctx, b.logger).Warn("failed to remove container", zap.String("container", containerID), zap.Error(err))
 		}
 	}()
 
 	// Copy the built files from the container
-	artifactDir := filepath.Join(b.options.WorkDir, "artifacts")
-	if err := os.MkdirAll(artifactDir, 0755); err != nil {
+	if err := os.MkdirAll(b.options.ArtifactDir, 0755); err != nil {
 		return err
 	}
 
-	reader, _, err := b.dockerCli.CopyFromContainer(ctx, containerID, "/usr/share/nginx/html")
+	reader, err := b.dockerCli.CopyFromContainer(ctx, containerID, "/usr/share/nginx/html")
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
-	artifactPath := filepath.Join(artifactDir, fmt.Sprintf("%s.tar.gz", build.ID))
-	outFile, err := os.Create(artifactPath)
+	outFile, err := os.Create(ArtifactPath(b.options.ArtifactDir, build.ID))
 	if err != nil {
 		return err
 	}
@@ -313,13 +540,10 @@ func (b *NodeJSBuilder) createArtifactFromContainer(ctx context.Context, build *
 	return err
 }
 
-func (b *NodeJSBuilder) createContainer(ctx context.Context, config *container.Config) (string, error) {
+func (b *NodeJSBuilder) createContainer(ctx context.Context, buildID string, config *container.Config) (string, error) {
 	config.Image = b.getImageTag(config.Image)
-	resp, err := b.dockerCli.ContainerCreate(ctx, config, nil, nil, nil, "")
-	if err != nil {
-		return "", err
-	}
-	return resp.ID, nil
+	config.Labels = stageLabels(buildID)
+	return b.dockerCli.ContainerCreate(ctx, config)
 }
 
 func (b *NodeJSBuilder) getImageTag(imageID string) string {
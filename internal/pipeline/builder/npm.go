@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+// npmPingTimeout bounds the check of each private registry
+const npmPingTimeout = 10 * time.Second
+
+const (
+	npmrcPath   = "/tmp/chef.npmrc" // Where the install step mounts the .npmrc of the registries
+	npmrcSecret = "chef_npmrc"      // BuildKit secret holding the .npmrc
+	npmrcEnv    = "CHEF_NPMRC"      // Variable buildx reads the secret from
+)
+
+// npmRegistries returns the private registries of a project
+func (b *NodeJSBuilder) npmRegistries(projectID string) []config.NPMRegistryConfig {
+	return b.config.NPMRegistries[strings.ToLower(projectID)]
+}
+
+// npmrc returns the .npmrc pointing the scopes of the registries at them
+// with their tokens
+func npmrc(registries []config.NPMRegistryConfig) string {
+	var b strings.Builder
+	for _, registry := range registries {
+		u, _ := url.Parse(strings.TrimSuffix(registry.URL, "/") + "/")
+		fmt.Fprintf(&b, "%s:registry=%s\n", registry.Scope, u)
+		fmt.Fprintf(&b, "//%s%s:_authToken=%s\n", u.Host, u.Path, registry.Token)
+	}
+	return b.String()
+}
+
+// checkNPMRegistries pings each registry with its token, so an unreachable
+// registry or a revoked token fails the build before it starts instead of
+// halfway through npm install
+func checkNPMRegistries(ctx context.Context, registries []config.NPMRegistryConfig) error {
+	for _, registry := range registries {
+		if err := registry.Validate(); err != nil {
+			return fmt.Errorf("invalid npm registry: %w", err)
+		}
+		if err := pingNPMRegistry(ctx, registry); err != nil {
+			return fmt.Errorf("npm registry of %s: %w", registry.Scope, err)
+		}
+	}
+	return nil
+}
+
+func pingNPMRegistry(ctx context.Context, registry config.NPMRegistryConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, npmPingTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(registry.URL, "/")+"/-/ping", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+registry.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s is unreachable: %w", registry.URL, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%s rejected the token (%s)", registry.URL, resp.Status)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s answered %s", registry.URL, resp.Status)
+	}
+	return nil
+}
+
+// npmInstallStep installs the dependencies, from the project's private
+// registries when it has any. The .npmrc is mounted as a BuildKit secret
+// for this step only, so the tokens end up in neither a layer nor the
+// image history.
+func npmInstallStep(registries []config.NPMRegistryConfig) string {
+	if len(registries) == 0 {
+		return "RUN npm install"
+	}
+	return fmt.Sprintf("RUN --mount=type=secret,id=%s,target=%s NPM_CONFIG_USERCONFIG=%s npm install", npmrcSecret, npmrcPath, npmrcPath)
+}
@@ -0,0 +1,51 @@
+package builder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestNPMInstallStep(t *testing.T) {
+	assert.Equal(t, "RUN npm install", npmInstallStep(nil))
+
+	registries := []config.NPMRegistryConfig{
+		{Scope: "@company", URL: "https://npm.company.com/private", Token: "secret-token"},
+		{Scope: "@partner", URL: "https://registry.partner.io/", Token: "other-token"},
+	}
+	step := npmInstallStep(registries)
+	assert.Equal(t, "RUN --mount=type=secret,id=chef_npmrc,target=/tmp/chef.npmrc NPM_CONFIG_USERCONFIG=/tmp/chef.npmrc npm install", step)
+	assert.NotContains(t, step, "secret-token")
+
+	assert.Equal(t, "@company:registry=https://npm.company.com/private/\n"+
+		"//npm.company.com/private/:_authToken=secret-token\n"+
+		"@partner:registry=https://registry.partner.io/\n"+
+		"//registry.partner.io/:_authToken=other-token\n", npmrc(registries))
+}
+
+func TestCheckNPMRegistries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/-/ping" || r.Header.Get("Authorization") != "Bearer valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	registry := config.NPMRegistryConfig{Scope: "@company", URL: server.URL + "/", Token: "valid"}
+	require.NoError(t, checkNPMRegistries(context.Background(), []config.NPMRegistryConfig{registry}))
+
+	registry.Token = "revoked"
+	err := checkNPMRegistries(context.Background(), []config.NPMRegistryConfig{registry})
+	assert.EqualError(t, err, "npm registry of @company: "+server.URL+"/ rejected the token (401 Unauthorized)")
+	assert.NotContains(t, err.Error(), "revoked")
+
+	registry.URL = "https://npm.company.com/'; cat /etc/passwd; '"
+	err = checkNPMRegistries(context.Background(), []config.NPMRegistryConfig{registry})
+	assert.EqualError(t, err, "invalid npm registry: registry of @company must not have credentials, a query or quotes")
+}
@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// outputCandidatesFile lists the directories of the build stage holding an
+// index.html, written after the build command
+const outputCandidatesFile = "/tmp/chef-output-candidates"
+
+// defaultOutputDirs are where frameworks write their build by default.
+// Builds that set no output directory use them unless the config
+// overrides them.
+var defaultOutputDirs = map[string]string{
+	"react":            "build",
+	"create-react-app": "build",
+	"vue":              "dist",
+	"vue-cli":          "dist",
+	"vite":             "dist",
+	"svelte":           "build",
+	"sveltekit":        "build",
+	"angular":          "dist",
+	"astro":            "dist",
+	"nextjs":           "out",
+	"nuxt":             ".output/public",
+	"gatsby":           "public",
+}
+
+// OutputDirError fails a build whose output directory does not exist
+type OutputDirError struct {
+	Dir        string
+	Candidates []string // Directories with an index.html
+}
+
+func (e *OutputDirError) Error() string {
+	msg := fmt.Sprintf("output directory %q was not created by the build command", e.Dir)
+	if len(e.Candidates) == 0 {
+		return msg + " and no directory with an index.html was found"
+	}
+	return fmt.Sprintf("%s, directories with an index.html: %s", msg, strings.Join(e.Candidates, ", "))
+}
+
+// outputDir returns the directory of the build output relative to the
+// project, or an empty string when the build sets none and the framework
+// has no default
+func (b *NodeJSBuilder) outputDir(build *types.Build) string {
+	dir := build.OutputDir
+	if dir == "" {
+		dir = b.config.OutputDirs[build.Framework]
+	}
+	if dir == "" {
+		dir = defaultOutputDirs[build.Framework]
+	}
+	if dir == "" {
+		return ""
+	}
+	return path.Clean(filepath.ToSlash(dir))
+}
+
+// outputCandidatesStep records the candidate output directories in the
+// build stage so a missing output directory can be reported helpfully
+func outputCandidatesStep() string {
+	return fmt.Sprintf(`RUN find . -maxdepth 4 -name node_modules -prune -o -name index.html -print > %s`, outputCandidatesFile)
+}
+
+// parseOutputCandidates turns the find output into directories relative to
+// the project
+func parseOutputCandidates(listing string) []string {
+	var candidates []string
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		dir := path.Dir(strings.TrimPrefix(line, "./"))
+		if dir == "." {
+			continue
+		}
+		candidates = append(candidates, dir)
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// checkOutputDir builds the build stage and fails with an OutputDirError
+// when dir is missing from it, before the runtime stage copies it. The
+// stage's layers are cached for the image built afterwards. The node and
+// npm versions of the stage are recorded in toolchain. When sourceMaps is
+// not nil, the source maps moved out of dir are saved next to the artifact
+// and the tar's path is stored in it.
+func (b *NodeJSBuilder) checkOutputDir(ctx context.Context, buildDir string, build *types.Build, platform, dir string, toolchain *types.Toolchain, sourceMaps *string) error {
+	tag := fmt.Sprintf("chef-output-%s:%s", build.ProjectID, build.ID)
+	if err := b.buildTarget(ctx, buildDir, tag, platform, "build", stageLabels(build.ID)); err != nil {
+		return err
+	}
+	defer func() {
+		if err := b.dockerCli.ImageRemove(context.Background(), tag, image.RemoveOptions{Force: true}); err != nil {
+			buildlog.Logger(ctx, b.logger).Warn("failed to remove build stage image", zap.String("image", tag), zap.Error(err))
+		}
+	}()
+
+	containerID, err := b.createContainer(ctx, build.ID, &container.Config{Image: tag})
+	if err != nil {
+		return fmt.Errorf("failed to inspect build output: %w", err)
+	}
+	defer func() {
+		if err := b.dockerCli.ContainerRemove(context.Background(), containerID, container.RemoveOptions{Force: true}); err != nil {
+			buildlog.Logger(ctx, b.logger).Warn("failed to remove container", zap.String("container", containerID), zap.Error(err))
+		}
+	}()
+
+	if files, err := b.readContainerFiles(ctx, containerID, toolchainFile); err == nil && len(files) == 1 {
+		parseToolchainFile(string(files[0]), toolchain)
+	}
+	if sourceMaps != nil {
+		tar := filepath.Join(b.options.WorkDir, "artifacts", build.ID+".sourcemaps.tar")
+		if err := os.MkdirAll(filepath.Dir(tar), 0755); err != nil {
+			return err
+		}
+		saved, err := b.saveSourceMaps(ctx, containerID, tar)
+		if err != nil {
+			return err
+		}
+		if saved {
+			*sourceMaps = tar
+		}
+	}
+
+	stat, err := b.dockerCli.ContainerStatPath(ctx, containerID, path.Join("/app", dir))
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect build output: %w", err)
+	}
+	if err == nil && stat.Mode.IsDir() {
+		return nil
+	}
+
+	outputErr := &OutputDirError{Dir: dir}
+	if files, err := b.readContainerFiles(ctx, containerID, outputCandidatesFile); err == nil && len(files) == 1 {
+		outputErr.Candidates = parseOutputCandidates(string(files[0]))
+	}
+	return outputErr
+}
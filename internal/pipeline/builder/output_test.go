@@ -0,0 +1,33 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestOutputDir(t *testing.T) {
+	b := &NodeJSBuilder{config: &config.NodeJSConfig{OutputDirs: map[string]string{"angular": "dist/app/browser/"}}}
+
+	assert.Equal(t, "build", b.outputDir(&types.Build{Framework: "react"}))
+	assert.Equal(t, "dist/app/browser", b.outputDir(&types.Build{Framework: "angular"}))
+	assert.Equal(t, "public", b.outputDir(&types.Build{Framework: "vue", OutputDir: "./public"}))
+	assert.Empty(t, b.outputDir(&types.Build{Framework: "ember"}))
+}
+
+func TestParseOutputCandidates(t *testing.T) {
+	listing := "./public/index.html\n./dist/index.html\n./index.html\n./dist/docs/index.html\n"
+	assert.Equal(t, []string{"dist", "dist/docs", "public"}, parseOutputCandidates(listing))
+	assert.Empty(t, parseOutputCandidates(""))
+}
+
+func TestOutputDirError(t *testing.T) {
+	err := &OutputDirError{Dir: "build", Candidates: []string{"dist", "public"}}
+	assert.EqualError(t, err, `output directory "build" was not created by the build command, directories with an index.html: dist, public`)
+
+	err = &OutputDirError{Dir: "build"}
+	assert.EqualError(t, err, `output directory "build" was not created by the build command and no directory with an index.html was found`)
+}
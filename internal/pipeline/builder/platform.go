@@ -0,0 +1,100 @@
+package builder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/envtemplate"
+)
+
+// buildMultiPlatform builds and pushes a manifest list with buildx, then pulls
+// the first platform back so the artifact can be extracted locally. It returns
+// the registry reference of the pushed image.
+func (b *NodeJSBuilder) buildMultiPlatform(ctx context.Context, buildDir, imageTag string, platforms []string, labels map[string]string) (string, error) {
+	if b.config.Registry == "" {
+		return "", fmt.Errorf("a registry is required for multi-platform builds")
+	}
+
+	ref := fmt.Sprintf("%s/%s", strings.TrimSuffix(b.config.Registry, "/"), imageTag)
+
+	buildlog.Logger(ctx, b.logger).Info("starting multi-platform build",
+		zap.String("image", ref),
+		zap.Strings("platforms", platforms))
+
+	args := []string{"--platform", strings.Join(platforms, ","), "--tag", ref, "--push"}
+	if err := b.buildx(ctx, buildDir, args, labels); err != nil {
+		return "", err
+	}
+
+	// Static output is architecture independent, any variant will do
+	pull, err := b.dockerCli.ImagePull(ctx, ref, image.PullOptions{Platform: platforms[0]})
+	if err != nil {
+		return "", fmt.Errorf("failed to pull %s: %w", ref, err)
+	}
+	defer pull.Close()
+
+	if err := b.processBuildOutput(ctx, pull); err != nil {
+		return "", err
+	}
+
+	if err := b.dockerCli.ImageTag(ctx, ref, imageTag); err != nil {
+		return "", fmt.Errorf("failed to tag %s: %w", ref, err)
+	}
+
+	return ref, nil
+}
+
+// buildx builds buildDir with docker buildx and the given output args. The
+// .npmrc of the project's registries is passed as a secret.
+func (b *NodeJSBuilder) buildx(ctx context.Context, buildDir string, output []string, labels map[string]string) error {
+	args := []string{"buildx", "build", "--build-arg", "NODE_ENV=production"}
+	// Values come from the environment so they stay out of the process list
+	env := os.Environ()
+	values := b.buildArgValues()
+	for _, key := range envtemplate.SortedKeys(values) {
+		args = append(args, "--build-arg", key)
+		env = append(env, key+"="+values[key])
+	}
+	if len(b.registries) > 0 {
+		args = append(args, "--secret", fmt.Sprintf("id=%s,env=%s", npmrcSecret, npmrcEnv))
+		env = append(env, npmrcEnv+"="+npmrc(b.registries))
+	}
+	for _, key := range envtemplate.SortedKeys(labels) {
+		args = append(args, "--label", key+"="+labels[key])
+	}
+	args = append(append(args, output...), buildDir)
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = env
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start buildx: %w", err)
+	}
+
+	logger := buildlog.Logger(ctx, b.logger)
+	var tail outputTail
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		tail.add(scanner.Text())
+		logger.Debug("buildx output", zap.String("output", scanner.Text()))
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return b.memory.heapOnly().outOfMemory(&OutputError{Err: fmt.Errorf("buildx build failed: %w", err), Output: tail.lines})
+	}
+	return nil
+}
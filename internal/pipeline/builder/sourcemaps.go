@@ -0,0 +1,51 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/errdefs"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// sourceMapsDir holds the source maps moved out of the output directory in
+// the build stage, under their paths in the output
+const sourceMapsDir = "/tmp/chef-sourcemaps"
+
+// sourceMapsStep moves the source maps out of the output directory when
+// they are not to be deployed. Their relative paths are kept so uploads
+// can be matched to the files referencing them.
+func sourceMapsStep(policy types.SourceMaps, outputDir string) string {
+	if !policy.Removed() {
+		return ""
+	}
+	return fmt.Sprintf(`RUN if [ -d '%[1]s' ]; then cd '%[1]s' && find . -type f -name '*.map' -exec sh -c 'for f; do mkdir -p "%[2]s/${f%%/*}" && mv "$f" "%[2]s/$f"; done' sh {} +; fi`,
+		outputDir, sourceMapsDir)
+}
+
+// saveSourceMaps copies the source maps moved out of the output directory
+// from a container of the build stage to a tar at path. It returns false
+// when the build produced none.
+func (b *NodeJSBuilder) saveSourceMaps(ctx context.Context, containerID, path string) (bool, error) {
+	reader, err := b.dockerCli.CopyFromContainer(ctx, containerID, sourceMapsDir)
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to copy source maps: %w", err)
+	}
+	defer reader.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, reader); err != nil {
+		return false, fmt.Errorf("failed to copy source maps: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,17 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestSourceMapsStep(t *testing.T) {
+	assert.Empty(t, sourceMapsStep("", "build"))
+	assert.Empty(t, sourceMapsStep(types.SourceMapsKeep, "build"))
+	assert.Equal(t,
+		`RUN if [ -d 'dist/app' ]; then cd 'dist/app' && find . -type f -name '*.map' -exec sh -c 'for f; do mkdir -p "/tmp/chef-sourcemaps/${f%/*}" && mv "$f" "/tmp/chef-sourcemaps/$f"; done' sh {} +; fi`,
+		sourceMapsStep(types.SourceMapsUpload, "dist/app"))
+}
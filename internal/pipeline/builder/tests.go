@@ -0,0 +1,187 @@
+package builder
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/manifest"
+	"github.com/elskow/chef-infra/internal/pipeline/testreport"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	// testExitFile holds the exit code of the test script, which must not
+	// fail the stage so its reports can still be read
+	testExitFile = "/tmp/chef-test-exit"
+
+	// maxReportSize bounds each report read from the test stage
+	maxReportSize = 10 << 20
+)
+
+// TestsFailedError fails a build whose tests failed or whose coverage is
+// below the project's minimum
+type TestsFailedError struct {
+	Results     *types.TestResults
+	Coverage    *types.Coverage // Nil without coverage reports
+	MinCoverage float64
+}
+
+func (e *TestsFailedError) Error() string {
+	if !e.Results.Succeeded() {
+		return "tests failed: " + e.Results.String()
+	}
+	covered := 0.0
+	if e.Coverage != nil {
+		covered = e.Coverage.Percent()
+	}
+	return fmt.Sprintf("coverage %.1f%% is below the minimum of %.1f%%", covered, e.MinCoverage)
+}
+
+// checkTests fails the build when tests failed or coverage is too low
+func checkTests(test manifest.Test, results *types.TestResults, coverage *types.Coverage) error {
+	failed := &TestsFailedError{Results: results, Coverage: coverage, MinCoverage: test.MinCoverage}
+	if !results.Succeeded() {
+		return failed
+	}
+	if test.MinCoverage > 0 && (coverage == nil || coverage.Percent() < test.MinCoverage) {
+		return failed
+	}
+	return nil
+}
+
+// testStage runs the project's test script on top of the installed
+// dependencies. The build stage does not depend on it; runTests builds it
+// first so its layers are cached by the time the image is built.
+func testStage(test manifest.Test) string {
+	if test.Script == "" {
+		return ""
+	}
+	return fmt.Sprintf(`
+FROM deps AS test
+RUN npm run %s; echo $? > %s
+`, test.Script, testExitFile)
+}
+
+// runTests builds the test stage and reads the exit code, test reports and
+// coverage reports the test script left in it. Coverage is nil when no
+// coverage report could be read.
+func (b *NodeJSBuilder) runTests(ctx context.Context, buildDir string, build *types.Build, test manifest.Test) (*types.TestResults, *types.Coverage, error) {
+	tag := fmt.Sprintf("chef-test-%s:%s", build.ProjectID, build.ID)
+	if err := b.buildTarget(ctx, buildDir, tag, "", "test", stageLabels(build.ID)); err != nil {
+		return nil, nil, fmt.Errorf("failed to run tests: %w", err)
+	}
+	defer func() {
+		if err := b.dockerCli.ImageRemove(context.Background(), tag, image.RemoveOptions{Force: true}); err != nil {
+			buildlog.Logger(ctx, b.logger).Warn("failed to remove test image", zap.String("image", tag), zap.Error(err))
+		}
+	}()
+
+	containerID, err := b.createContainer(ctx, build.ID, &container.Config{Image: tag})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read test results: %w", err)
+	}
+	defer func() {
+		if err := b.dockerCli.ContainerRemove(context.Background(), containerID, container.RemoveOptions{Force: true}); err != nil {
+			buildlog.Logger(ctx, b.logger).Warn("failed to remove container", zap.String("container", containerID), zap.Error(err))
+		}
+	}()
+
+	exitFiles, err := b.readContainerFiles(ctx, containerID, testExitFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read test exit code: %w", err)
+	}
+	if len(exitFiles) != 1 {
+		return nil, nil, fmt.Errorf("test exit code not found")
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(string(exitFiles[0])))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid test exit code: %w", err)
+	}
+
+	results := &types.TestResults{ExitCode: exitCode}
+	b.readReports(ctx, containerID, build, test.Reports, func(data []byte) error {
+		parsed, err := testreport.Parse(data)
+		if err == nil {
+			results.Add(parsed)
+		}
+		return err
+	})
+
+	var coverage *types.Coverage
+	b.readReports(ctx, containerID, build, test.Coverage, func(data []byte) error {
+		parsed, err := testreport.ParseCoverage(data)
+		if err == nil {
+			if coverage == nil {
+				coverage = &types.Coverage{}
+			}
+			coverage.Add(parsed)
+		}
+		return err
+	})
+	return results, coverage, nil
+}
+
+// readReports hands every file at the report paths of the container to
+// parse. Missing and unreadable reports are logged and skipped.
+func (b *NodeJSBuilder) readReports(ctx context.Context, containerID string, build *types.Build, reports []string, parse func([]byte) error) {
+	for _, report := range reports {
+		files, err := b.readContainerFiles(ctx, containerID, path.Join("/app", report))
+		if err != nil {
+			buildlog.Logger(ctx, b.logger).Warn("failed to read test report",
+				zap.String("report", report),
+				zap.Error(err))
+			continue
+		}
+		for _, data := range files {
+			if err := parse(data); err != nil {
+				buildlog.Logger(ctx, b.logger).Warn("skipping unreadable test report",
+					zap.String("report", report),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// readContainerFiles returns the regular files at p, a file or directory
+// of the container
+func (b *NodeJSBuilder) readContainerFiles(ctx context.Context, containerID, p string) ([][]byte, error) {
+	reader, err := b.dockerCli.CopyFromContainer(ctx, containerID, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var files [][]byte
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxReportSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxReportSize {
+			return nil, fmt.Errorf("%s exceeds %d bytes", header.Name, maxReportSize)
+		}
+		files = append(files, data)
+	}
+}
@@ -0,0 +1,34 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/pipeline/manifest"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestTestStage(t *testing.T) {
+	assert.Empty(t, testStage(manifest.Test{}))
+	stage := testStage(manifest.Test{Script: "test:ci"})
+	assert.Contains(t, stage, "FROM deps AS test")
+	assert.Contains(t, stage, "RUN npm run test:ci; echo $? > "+testExitFile)
+}
+
+func TestCheckTests(t *testing.T) {
+	passed := &types.TestResults{Total: 10, Passed: 10}
+	coverage := &types.Coverage{Lines: 100, Covered: 72}
+
+	assert.NoError(t, checkTests(manifest.Test{}, passed, nil))
+	assert.NoError(t, checkTests(manifest.Test{MinCoverage: 70}, passed, coverage))
+
+	err := checkTests(manifest.Test{}, &types.TestResults{Total: 10, Passed: 8, Failed: 2, ExitCode: 1}, coverage)
+	assert.EqualError(t, err, "tests failed: 2 of 10 tests failed")
+
+	err = checkTests(manifest.Test{MinCoverage: 80}, passed, coverage)
+	assert.EqualError(t, err, "coverage 72.0% is below the minimum of 80.0%")
+
+	err = checkTests(manifest.Test{MinCoverage: 80}, passed, nil)
+	assert.EqualError(t, err, "coverage 0.0% is below the minimum of 80.0%")
+}
@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// toolchainFile holds the node and npm versions of the base stage
+const toolchainFile = "/tmp/chef-toolchain"
+
+// toolchainStep records the node and npm versions in the base stage
+func toolchainStep() string {
+	return fmt.Sprintf(`RUN printf 'node=%%s\nnpm=%%s\n' "$(node --version)" "$(npm --version)" > %s`, toolchainFile)
+}
+
+// parseToolchainFile sets the versions written by toolchainStep
+func parseToolchainFile(data string, toolchain *types.Toolchain) {
+	for _, line := range strings.Split(data, "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "node":
+			toolchain.NodeVersion = strings.TrimPrefix(value, "v")
+		case "npm":
+			toolchain.NPMVersion = value
+		}
+	}
+}
+
+// toolchain records the images and tools of a finished build. The node and
+// npm versions are read from the build stage by checkOutputDir.
+func (b *NodeJSBuilder) toolchain(ctx context.Context, toolchain *types.Toolchain, baseImages []string, buildx bool) {
+	toolchain.BuilderVersion = types.BuilderVersion()
+	toolchain.NodeImage = b.imageDigest(ctx, baseImages[0])
+	toolchain.RuntimeImage = b.imageDigest(ctx, baseImages[1])
+
+	if version, err := b.dockerCli.ServerVersion(ctx); err == nil {
+		toolchain.DockerVersion = version.Version
+	} else {
+		buildlog.Logger(ctx, b.logger).Warn("failed to get docker version", zap.Error(err))
+	}
+
+	// Other builds use the daemon's classic builder
+	if buildx {
+		output, err := exec.CommandContext(ctx, "docker", "buildx", "inspect").Output()
+		if err != nil {
+			buildlog.Logger(ctx, b.logger).Warn("failed to inspect buildx builder", zap.Error(err))
+			return
+		}
+		toolchain.BuildKitVersion = parseBuildKitVersion(string(output))
+	}
+}
+
+// imageDigest pins ref to the digest it was pulled by. Images only known to
+// a remote builder keep their tag.
+func (b *NodeJSBuilder) imageDigest(ctx context.Context, ref string) string {
+	info, err := b.dockerCli.ImageInspect(ctx, ref)
+	if err != nil || len(info.RepoDigests) == 0 {
+		return ref
+	}
+	_, digest, found := strings.Cut(info.RepoDigests[0], "@")
+	if !found {
+		return ref
+	}
+	return ref + "@" + digest
+}
+
+// parseBuildKitVersion reads the BuildKit version of the first node listed
+// by docker buildx inspect
+func parseBuildKitVersion(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "buildkit", "buildkit version":
+			return strings.TrimPrefix(strings.TrimSpace(value), "v")
+		}
+	}
+	return ""
+}
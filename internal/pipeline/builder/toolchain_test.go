@@ -0,0 +1,36 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestParseToolchainFile(t *testing.T) {
+	var toolchain types.Toolchain
+	parseToolchainFile("node=v20.11.1\nnpm=10.2.4\n", &toolchain)
+	assert.Equal(t, "20.11.1", toolchain.NodeVersion)
+	assert.Equal(t, "10.2.4", toolchain.NPMVersion)
+
+	assert.Equal(t, `RUN printf 'node=%s\nnpm=%s\n' "$(node --version)" "$(npm --version)" > /tmp/chef-toolchain`, toolchainStep())
+}
+
+func TestParseBuildKitVersion(t *testing.T) {
+	output := `Name:          multiarch
+Driver:        docker-container
+Last Activity: 2025-03-10 09:00:00 +0000 UTC
+
+Nodes:
+Name:                  multiarch0
+Endpoint:              unix:///var/run/docker.sock
+Status:                running
+BuildKit daemon flags: --allow-insecure-entitlement=network.host
+BuildKit version:      v0.13.2
+Platforms:             linux/amd64, linux/arm64
+`
+	assert.Equal(t, "0.13.2", parseBuildKitVersion(output))
+	assert.Equal(t, "0.12.5", parseBuildKitVersion("Status:    running\nBuildkit:  v0.12.5\n"))
+	assert.Empty(t, parseBuildKitVersion("Status: inactive\n"))
+}
@@ -0,0 +1,77 @@
+// Package buildlog carries the build a context works on, so builders,
+// validators and deployers log with the same build fields and their
+// entries can be correlated across components.
+package buildlog
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+type contextKey struct{}
+
+// Metadata identifies the build a context works on
+type Metadata struct {
+	BuildID   string
+	ProjectID string
+	Commit    string // Short hash
+	Branch    string
+}
+
+// scope is stored in contexts with the fields built once
+type scope struct {
+	metadata Metadata
+	fields   []zap.Field
+}
+
+// MetadataOf returns the metadata of build
+func MetadataOf(build *types.Build) Metadata {
+	metadata := Metadata{
+		BuildID:   build.ID,
+		ProjectID: build.ProjectID,
+		Commit:    build.ShortHash(),
+	}
+	if build.Commit != nil {
+		metadata.Branch = build.Commit.Branch
+	}
+	return metadata
+}
+
+// Fields returns the metadata as log fields, skipping unset ones
+func (m Metadata) Fields() []zap.Field {
+	fields := []zap.Field{zap.String("build_id", m.BuildID), zap.String("project_id", m.ProjectID)}
+	if m.Commit != "" {
+		fields = append(fields, zap.String("commit", m.Commit))
+	}
+	if m.Branch != "" {
+		fields = append(fields, zap.String("branch", m.Branch))
+	}
+	return fields
+}
+
+// WithBuild returns a context working on build
+func WithBuild(ctx context.Context, build *types.Build) context.Context {
+	metadata := MetadataOf(build)
+	return context.WithValue(ctx, contextKey{}, &scope{metadata: metadata, fields: metadata.Fields()})
+}
+
+// FromContext returns the metadata of the build ctx works on
+func FromContext(ctx context.Context) (Metadata, bool) {
+	if s, ok := ctx.Value(contextKey{}).(*scope); ok {
+		return s.metadata, true
+	}
+	return Metadata{}, false
+}
+
+// Logger returns logger with the fields of the build ctx works on, or
+// logger itself outside of builds. Components keep their own fields, e.g.
+// the deploy target's environment.
+func Logger(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	if s, ok := ctx.Value(contextKey{}).(*scope); ok {
+		return logger.With(s.fields...)
+	}
+	return logger
+}
@@ -0,0 +1,39 @@
+package buildlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core).With(zap.String("component", "deployer"))
+
+	Logger(context.Background(), logger).Info("outside")
+
+	build := &types.Build{ID: "b1", ProjectID: "shop", CommitHash: "0123abcdef", Commit: &types.CommitInfo{Branch: "main"}}
+	ctx := WithBuild(context.Background(), build)
+	Logger(ctx, logger).Info("inside")
+
+	entries := logs.All()
+	assert.Equal(t, map[string]interface{}{"component": "deployer"}, entries[0].ContextMap())
+	assert.Equal(t, map[string]interface{}{
+		"component":  "deployer",
+		"build_id":   "b1",
+		"project_id": "shop",
+		"commit":     "0123abc",
+		"branch":     "main",
+	}, entries[1].ContextMap())
+
+	metadata, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, Metadata{BuildID: "b1", ProjectID: "shop", Commit: "0123abc", Branch: "main"}, metadata)
+	_, ok = FromContext(context.Background())
+	assert.False(t, ok)
+}
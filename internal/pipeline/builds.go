@@ -0,0 +1,382 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+func (h *Handler) GetBuild(ctx context.Context, req *pb.GetBuildRequest) (*pb.BuildInfo, error) {
+	if req.BuildId == "" {
+		return nil, status.Error(codes.InvalidArgument, "build id is required")
+	}
+
+	build, err := h.pipeline.LookupBuild(ctx, req.BuildId)
+	if err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return nil, status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to get build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get build")
+	}
+	if err := h.authorizeProject(ctx, build.ProjectID); err != nil {
+		return nil, err
+	}
+
+	info := buildToProto(build)
+	for _, event := range build.Events {
+		info.Events = append(info.Events, &pb.BuildEvent{
+			Type:      string(event.Type),
+			Hook:      event.Hook,
+			Message:   event.Message,
+			Timestamp: event.Timestamp.Unix(),
+		})
+	}
+	return info, nil
+}
+
+func (h *Handler) ListBuilds(ctx context.Context, req *pb.ListBuildsRequest) (*pb.ListBuildsResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	labels := make([]string, len(req.Labels))
+	for i, label := range req.Labels {
+		labels[i] = strings.ToLower(strings.TrimSpace(label))
+	}
+	page, err := h.pipeline.ListBuilds(ctx, req.ProjectId, labels, pagination.Params{
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+		Search:    req.Search,
+	})
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to list builds", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list builds")
+	}
+
+	resp := &pb.ListBuildsResponse{NextPageToken: page.NextPageToken}
+	for i := range page.Items {
+		resp.Builds = append(resp.Builds, buildToProto(&page.Items[i]))
+	}
+	return resp, nil
+}
+
+func (h *Handler) PromoteBuild(ctx context.Context, req *pb.PromoteBuildRequest) (*pb.PromoteBuildResponse, error) {
+	if req.BuildId == "" {
+		return nil, status.Error(codes.InvalidArgument, "build id is required")
+	}
+
+	build, err := h.pipeline.LookupBuild(ctx, req.BuildId)
+	if err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return nil, status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to get build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get build")
+	}
+	if err := h.authorizeProject(ctx, build.ProjectID); err != nil {
+		return nil, err
+	}
+
+	if err := h.pipeline.PromoteBuild(ctx, req.BuildId); err != nil {
+		switch {
+		case errors.Is(err, types.ErrBuildNotFound):
+			// Only known to the store, e.g. after a restart
+			return nil, status.Error(codes.FailedPrecondition, "build is no longer available for promotion, start a new build")
+		case errors.Is(err, ErrNotPromotable):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		h.log.Error("failed to promote build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to promote build")
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "build.promote", build.ProjectID, map[string]interface{}{"build_id": build.ID})
+
+	return &pb.PromoteBuildResponse{
+		Success: true,
+		Message: "Build promoted",
+	}, nil
+}
+
+func (h *Handler) PinBuild(ctx context.Context, req *pb.PinBuildRequest) (*pb.PinBuildResponse, error) {
+	if err := h.setPinned(ctx, req.BuildId, true); err != nil {
+		return nil, err
+	}
+	return &pb.PinBuildResponse{
+		Success: true,
+		Message: "Build pinned",
+	}, nil
+}
+
+func (h *Handler) UnpinBuild(ctx context.Context, req *pb.UnpinBuildRequest) (*pb.UnpinBuildResponse, error) {
+	if err := h.setPinned(ctx, req.BuildId, false); err != nil {
+		return nil, err
+	}
+	return &pb.UnpinBuildResponse{
+		Success: true,
+		Message: "Build unpinned",
+	}, nil
+}
+
+func (h *Handler) AnnotateBuild(ctx context.Context, req *pb.AnnotateBuildRequest) (*pb.AnnotateBuildResponse, error) {
+	if req.BuildId == "" {
+		return nil, status.Error(codes.InvalidArgument, "build id is required")
+	}
+
+	build, err := h.pipeline.LookupBuild(ctx, req.BuildId)
+	if err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return nil, status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to get build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get build")
+	}
+	if err := h.authorizeProject(ctx, build.ProjectID); err != nil {
+		return nil, err
+	}
+
+	if err := h.pipeline.AnnotateBuild(ctx, req.BuildId, req.Note, req.Labels); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidAnnotation):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, types.ErrBuildNotFound):
+			return nil, status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to annotate build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to update build")
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "build.annotate", build.ProjectID, map[string]interface{}{
+		"build_id": build.ID,
+		"note":     req.Note,
+		"labels":   req.Labels,
+	})
+
+	return &pb.AnnotateBuildResponse{
+		Success: true,
+		Message: "Build annotated",
+	}, nil
+}
+
+func (h *Handler) ApproveBuild(ctx context.Context, req *pb.ApproveBuildRequest) (*pb.ApproveBuildResponse, error) {
+	if req.BuildId == "" {
+		return nil, status.Error(codes.InvalidArgument, "build id is required")
+	}
+
+	build, err := h.pipeline.LookupBuild(ctx, req.BuildId)
+	if err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return nil, status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to get build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get build")
+	}
+	if err := h.authorizeProject(ctx, build.ProjectID); err != nil {
+		return nil, err
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	if err := h.pipeline.ApproveBuild(ctx, req.BuildId, username); err != nil {
+		switch {
+		case errors.Is(err, types.ErrBuildNotFound):
+			return nil, status.Error(codes.FailedPrecondition, "build is no longer available for promotion, start a new build")
+		case errors.Is(err, ErrNotPromotable):
+			return nil, status.Error(codes.FailedPrecondition, "only previews awaiting promotion can be approved")
+		}
+		h.log.Error("failed to approve build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to approve build")
+	}
+
+	h.audit(username, "build.approve", build.ProjectID, map[string]interface{}{"build_id": build.ID})
+
+	return &pb.ApproveBuildResponse{
+		Success: true,
+		Message: "Build approved",
+	}, nil
+}
+
+func (h *Handler) setPinned(ctx context.Context, buildID string, pinned bool) error {
+	if buildID == "" {
+		return status.Error(codes.InvalidArgument, "build id is required")
+	}
+
+	build, err := h.pipeline.LookupBuild(ctx, buildID)
+	if err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to get build", zap.String("build_id", buildID), zap.Error(err))
+		return status.Error(codes.Internal, "failed to get build")
+	}
+	if err := h.authorizeProject(ctx, build.ProjectID); err != nil {
+		return err
+	}
+
+	if err := h.pipeline.SetPinned(ctx, buildID, pinned); err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to pin build", zap.String("build_id", buildID), zap.Error(err))
+		return status.Error(codes.Internal, "failed to update build")
+	}
+
+	action := "build.pin"
+	if !pinned {
+		action = "build.unpin"
+	}
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, action, build.ProjectID, map[string]interface{}{"build_id": build.ID})
+	return nil
+}
+
+func buildToProto(build *types.Build) *pb.BuildInfo {
+	info := &pb.BuildInfo{
+		Id:           build.ID,
+		ProjectId:    build.ProjectID,
+		Status:       string(build.Status),
+		Commit:       &pb.CommitInfo{Hash: build.CommitHash},
+		Framework:    build.Framework,
+		Environment:  build.Environment,
+		ErrorMessage: build.ErrorMessage,
+		Warnings:     build.Warnings,
+		BuildEnv:     build.BuildEnv,
+		Addons:       build.AddOns,
+		Pinned:       build.Pinned,
+		Note:         build.Note,
+		Labels:       build.Labels,
+		StartTime:    build.StartTime.Unix(),
+	}
+	if commit := build.Commit; commit != nil {
+		info.Commit.Author = commit.Author
+		info.Commit.AuthorEmail = commit.AuthorEmail
+		info.Commit.Message = commit.Message
+		info.Commit.Branch = commit.Branch
+		info.Commit.Tag = commit.Tag
+	}
+	if build.CompleteTime != nil {
+		info.CompleteTime = build.CompleteTime.Unix()
+	}
+	info.ArtifactDigest = build.ArtifactDigest
+	info.BuilderVersion = build.BuilderVersion
+	info.RolledBackTo = build.RolledBackTo
+	if build.Debug.Available(time.Now()) {
+		info.DebuggableUntil = build.Debug.ExpiresAt.Unix()
+	}
+	if audit := build.PerfAudit; audit != nil {
+		info.PerfAudit = &pb.PerfAudit{
+			Url:         audit.URL,
+			Scores:      make(map[string]int32, len(audit.Scores)),
+			Metrics:     audit.Metrics,
+			Regressions: audit.Regressions,
+			AuditedAt:   audit.AuditedAt.Unix(),
+		}
+		for category, score := range audit.Scores {
+			info.PerfAudit.Scores[category] = int32(score)
+		}
+	}
+	if external := build.External; external != nil {
+		info.ExternalDeployment = &pb.ExternalDeployment{
+			Provider:    external.Provider,
+			Site:        external.Site,
+			Id:          external.ID,
+			Environment: external.Environment,
+			Url:         external.URL,
+			Status:      external.Status,
+			Message:     external.Message,
+			DeployedAt:  external.DeployedAt.Unix(),
+		}
+	}
+	if diagnosis := build.Diagnosis; diagnosis != nil {
+		info.Diagnosis = &pb.Diagnosis{
+			Rule:       diagnosis.Rule,
+			Cause:      diagnosis.Cause,
+			Suggestion: diagnosis.Suggestion,
+			Line:       diagnosis.Line,
+			Details:    diagnosis.Details,
+		}
+	}
+	if toolchain := build.Toolchain; toolchain != nil {
+		info.Toolchain = &pb.Toolchain{
+			NodeImage:       toolchain.NodeImage,
+			NodeVersion:     toolchain.NodeVersion,
+			NpmVersion:      toolchain.NPMVersion,
+			RuntimeImage:    toolchain.RuntimeImage,
+			BuilderVersion:  toolchain.BuilderVersion,
+			DockerVersion:   toolchain.DockerVersion,
+			BuildkitVersion: toolchain.BuildKitVersion,
+		}
+	}
+	if prov := build.Provenance; prov != nil {
+		info.Provenance = &pb.ProvenanceInfo{
+			Digest:       prov.Digest,
+			InputsDigest: prov.InputsDigest,
+			Signed:       prov.Signed(),
+			SignedImage:  prov.SignedImage,
+		}
+	}
+	for _, result := range build.PolicyResults {
+		info.PolicyResults = append(info.PolicyResults, &pb.PolicyResult{
+			Rule:    result.Rule,
+			Passed:  result.Passed,
+			Message: result.Message,
+		})
+	}
+	for _, approval := range build.Approvals {
+		info.ApprovedBy = append(info.ApprovedBy, approval.User)
+	}
+	for _, stage := range build.Stages {
+		info.Stages = append(info.Stages, &pb.StageTiming{
+			Stage:      string(stage.Stage),
+			StartedAt:  stage.StartedAt.Unix(),
+			DurationMs: stage.Duration.Milliseconds(),
+		})
+	}
+	for _, process := range build.Processes {
+		info.Processes = append(info.Processes, &pb.Process{
+			Name:     process.Name,
+			Command:  process.Command,
+			Replicas: process.Replicas,
+		})
+	}
+	for _, job := range build.Jobs {
+		info.Jobs = append(info.Jobs, &pb.Job{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Command:  job.Command,
+			Cpu:      job.CPU,
+			Memory:   job.Memory,
+		})
+	}
+	if len(build.Vulnerabilities) > 0 {
+		info.Vulnerabilities = make(map[string]int32, len(build.Vulnerabilities))
+		for severity, count := range build.Vulnerabilities {
+			info.Vulnerabilities[severity] = int32(count)
+		}
+	}
+	if preview := build.Preview; preview != nil {
+		info.Preview = &pb.PreviewInfo{
+			Url:       preview.URL,
+			ExpiresAt: preview.ExpiresAt.Unix(),
+			Active:    preview.Active(),
+		}
+		if preview.PromotedAt != nil {
+			info.Preview.PromotedAt = preview.PromotedAt.Unix()
+		}
+	}
+	return info
+}
@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+func TestHandler_Builds(t *testing.T) {
+	started := time.Unix(1700000000, 0)
+	p := &Pipeline{builds: map[string]*types.Build{
+		"b1": {
+			ID:         "b1",
+			ProjectID:  "shop",
+			CommitHash: "a1b2c3d4e5f6",
+			Status:     types.BuildStatusSuccess,
+			StartTime:  started,
+			Commit: &types.CommitInfo{
+				Author:  "Jane Doe",
+				Message: "Fix checkout",
+				Branch:  "main",
+			},
+			Toolchain: &types.Toolchain{NodeImage: "node:20-alpine@sha256:4d1d", NPMVersion: "10.2.4"},
+			Events:    []types.DeploymentEvent{{Type: types.EventRestarted, Message: "restarted by alice", Timestamp: started}},
+		},
+	}}
+	h := NewHandler(p, nil, nil, nil, nil, ownerAuthorizer{}, nil, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	build, err := h.GetBuild(alice, &pb.GetBuildRequest{BuildId: "b1"})
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe", build.Commit.Author)
+	assert.Equal(t, "main", build.Commit.Branch)
+	assert.Equal(t, "a1b2c3d4e5f6", build.Commit.Hash)
+	assert.Equal(t, started.Unix(), build.StartTime)
+	assert.Equal(t, "node:20-alpine@sha256:4d1d", build.Toolchain.NodeImage)
+	assert.Equal(t, "10.2.4", build.Toolchain.NpmVersion)
+	require.Len(t, build.Events, 1)
+
+	_, err = h.GetBuild(bob, &pb.GetBuildRequest{BuildId: "b1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = h.GetBuild(alice, &pb.GetBuildRequest{BuildId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := h.ListBuilds(alice, &pb.ListBuildsRequest{ProjectId: "shop"})
+	require.NoError(t, err)
+	require.Len(t, list.Builds, 1)
+	assert.Equal(t, "Fix checkout", list.Builds[0].Commit.Message)
+	assert.Empty(t, list.Builds[0].Events)
+
+	_, err = h.ListBuilds(bob, &pb.ListBuildsRequest{ProjectId: "shop"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestHandler_PinBuild(t *testing.T) {
+	p := &Pipeline{builds: map[string]*types.Build{
+		"b1": {ID: "b1", ProjectID: "shop", Status: types.BuildStatusSuccess},
+	}}
+	auditor := &recordingAuditor{}
+	h := NewHandler(p, nil, nil, nil, nil, ownerAuthorizer{}, auditor, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	_, err := h.PinBuild(bob, &pb.PinBuildRequest{BuildId: "b1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = h.PinBuild(alice, &pb.PinBuildRequest{BuildId: "b1"})
+	require.NoError(t, err)
+	list, err := h.ListBuilds(alice, &pb.ListBuildsRequest{ProjectId: "shop"})
+	require.NoError(t, err)
+	require.Len(t, list.Builds, 1)
+	assert.True(t, list.Builds[0].Pinned)
+
+	_, err = h.UnpinBuild(alice, &pb.UnpinBuildRequest{BuildId: "b1"})
+	require.NoError(t, err)
+	build, err := h.GetBuild(alice, &pb.GetBuildRequest{BuildId: "b1"})
+	require.NoError(t, err)
+	assert.False(t, build.Pinned)
+	assert.Equal(t, []string{"build.pin", "build.unpin"}, auditor.actions)
+
+	_, err = h.PinBuild(alice, &pb.PinBuildRequest{BuildId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestHandler_AnnotateBuild(t *testing.T) {
+	p := &Pipeline{builds: map[string]*types.Build{
+		"b1": {ID: "b1", ProjectID: "shop", Status: types.BuildStatusSuccess},
+		"b2": {ID: "b2", ProjectID: "shop", Status: types.BuildStatusSuccess},
+	}}
+	auditor := &recordingAuditor{}
+	h := NewHandler(p, nil, nil, nil, nil, ownerAuthorizer{}, auditor, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	_, err := h.AnnotateBuild(bob, &pb.AnnotateBuildRequest{BuildId: "b1", Note: "mine"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = h.AnnotateBuild(alice, &pb.AnnotateBuildRequest{
+		BuildId: "b1",
+		Note:    " hotfix for checkout bug ",
+		Labels:  []string{"Hotfix", "checkout", "hotfix"},
+	})
+	require.NoError(t, err)
+	build, err := h.GetBuild(alice, &pb.GetBuildRequest{BuildId: "b1"})
+	require.NoError(t, err)
+	assert.Equal(t, "hotfix for checkout bug", build.Note)
+	assert.Equal(t, []string{"hotfix", "checkout"}, build.Labels)
+	assert.Equal(t, []string{"build.annotate"}, auditor.actions)
+
+	list, err := h.ListBuilds(alice, &pb.ListBuildsRequest{ProjectId: "shop", Labels: []string{"HOTFIX"}})
+	require.NoError(t, err)
+	require.Len(t, list.Builds, 1)
+	assert.Equal(t, "b1", list.Builds[0].Id)
+
+	_, err = h.AnnotateBuild(alice, &pb.AnnotateBuildRequest{BuildId: "b2", Labels: []string{"needs review"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = h.AnnotateBuild(alice, &pb.AnnotateBuildRequest{BuildId: "b2", Note: strings.Repeat("x", types.MaxNoteLength+1)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = h.AnnotateBuild(alice, &pb.AnnotateBuildRequest{BuildId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestHandler_ApproveBuild(t *testing.T) {
+	p := &Pipeline{builds: map[string]*types.Build{
+		"b1": {
+			ID:        "b1",
+			ProjectID: "shop",
+			Status:    types.BuildStatusSuccess,
+			Preview:   &types.Preview{Name: "preview-b1", ExpiresAt: time.Now().Add(time.Hour)},
+		},
+		"b2": {ID: "b2", ProjectID: "shop", Status: types.BuildStatusSuccess},
+	}}
+	auditor := &recordingAuditor{}
+	h := NewHandler(p, nil, nil, nil, nil, ownerAuthorizer{}, auditor, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	_, err := h.ApproveBuild(bob, &pb.ApproveBuildRequest{BuildId: "b1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = h.ApproveBuild(alice, &pb.ApproveBuildRequest{BuildId: "b1"})
+	require.NoError(t, err)
+	build, err := h.GetBuild(alice, &pb.GetBuildRequest{BuildId: "b1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, build.ApprovedBy)
+	assert.Equal(t, []string{"build.approve"}, auditor.actions)
+
+	_, err = h.ApproveBuild(alice, &pb.ApproveBuildRequest{BuildId: "b2"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = h.ApproveBuild(alice, &pb.ApproveBuildRequest{BuildId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -1,49 +1,284 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
 	"go.uber.org/zap"
 )
 
+// PinSource lists the builds cleanup must keep
+type PinSource interface {
+	PinnedBuilds(ctx context.Context) (map[string]bool, error)
+}
+
 type CleanupManager struct {
 	config *config.PipelineConfig
+	pins   PinSource
 	logger *zap.Logger
 }
 
-func (cm *CleanupManager) CleanupOldBuilds(maxAge time.Duration) error {
-	now := time.Now()
-	buildDirs, err := os.ReadDir(cm.config.BuildDir)
+func NewCleanupManager(config *config.PipelineConfig, pins PinSource, logger *zap.Logger) *CleanupManager {
+	return &CleanupManager{config: config, pins: pins, logger: logger}
+}
+
+// CleanupOldBuilds removes the working, artifact and cache directories of
+// builds older than maxAge. Pinned builds are kept; when the pins cannot
+// be read nothing is removed.
+func (cm *CleanupManager) CleanupOldBuilds(ctx context.Context, maxAge time.Duration) error {
+	pinned, err := cm.pins.PinnedBuilds(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to read build directory: %w", err)
+		return fmt.Errorf("failed to list pinned builds: %w", err)
+	}
+
+	layout, err := builder.NewLayout(cm.config)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, root := range layout.Dirs() {
+		buildDirs, err := os.ReadDir(root)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read %s: %w", root, err)
+		}
+
+		for _, dir := range buildDirs {
+			if !dir.IsDir() || pinned[dir.Name()] {
+				continue
+			}
+
+			info, err := dir.Info()
+			if err != nil {
+				cm.logger.Warn("failed to get directory info",
+					zap.String("dir", dir.Name()),
+					zap.Error(err))
+				continue
+			}
+
+			if now.Sub(info.ModTime()) > maxAge {
+				path := filepath.Join(root, dir.Name())
+				if err := os.RemoveAll(path); err != nil {
+					cm.logger.Error("failed to remove old build",
+						zap.String("path", path),
+						zap.Error(err))
+				}
+			}
+		}
 	}
 
-	for _, dir := range buildDirs {
-		if !dir.IsDir() {
+	return nil
+}
+
+// SetPinned pins or unpins a build. Pinned builds, e.g. the last
+// known-good release, survive cleanup of old builds; deleting the project
+// still removes them.
+func (p *Pipeline) SetPinned(ctx context.Context, buildID string, pinned bool) error {
+	p.mu.Lock()
+	build, inMemory := p.builds[buildID]
+	if inMemory {
+		build.Pinned = pinned
+	}
+	p.mu.Unlock()
+
+	if inMemory {
+		p.persist(build)
+		return nil
+	}
+	if p.store == nil {
+		return fmt.Errorf("%w: %s", types.ErrBuildNotFound, buildID)
+	}
+	return p.store.SetPinned(ctx, buildID, pinned)
+}
+
+// PinnedBuilds returns the IDs of all pinned builds
+func (p *Pipeline) PinnedBuilds(ctx context.Context) (map[string]bool, error) {
+	pinned := make(map[string]bool)
+	if p.store != nil {
+		ids, err := p.store.ListPinned(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			pinned[id] = true
+		}
+	}
+
+	// Memory wins over the store for builds of this process, whose last
+	// write may not have been persisted
+	p.mu.RLock()
+	for id, build := range p.builds {
+		if build.Pinned {
+			pinned[id] = true
+		} else {
+			delete(pinned, id)
+		}
+	}
+	p.mu.RUnlock()
+	return pinned, nil
+}
+
+// ProtectedImages returns the images image garbage collection must keep:
+// those of pinned builds, of deployed previews and of the latest deployed
+// build of each project. Older images a rollback may return to are left
+// to the collector's keep-per-project limit.
+func (p *Pipeline) ProtectedImages(ctx context.Context) (map[string]bool, error) {
+	protected := make(map[string]bool)
+	if p.store != nil {
+		refs, err := p.store.ListProtectedImages(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			protected[ref] = true
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	latest := make(map[string]*types.Build)
+	for _, build := range p.builds {
+		if build.ImageID == "" {
+			continue
+		}
+		if build.Pinned || build.Preview.Active() {
+			protected[build.ImageID] = true
+		}
+		if build.Status != types.BuildStatusSuccess || (build.Preview != nil && build.Preview.PromotedAt == nil) {
 			continue
 		}
+		if current, ok := latest[build.ProjectID]; !ok || build.StartTime.After(current.StartTime) {
+			latest[build.ProjectID] = build
+		}
+	}
+	for _, build := range latest {
+		protected[build.ImageID] = true
+	}
+	return protected, nil
+}
 
-		info, err := dir.Info()
+// LiveBuilds returns the IDs of the builds still building or deploying,
+// here or on another instance sharing the Docker host. The image collector
+// keeps their containers and stage images.
+func (p *Pipeline) LiveBuilds(ctx context.Context) (map[string]bool, error) {
+	live := make(map[string]bool)
+	if p.store != nil {
+		builds, err := p.store.ListUnfinishedBuilds(ctx)
 		if err != nil {
-			cm.logger.Warn("failed to get directory info",
-				zap.String("dir", dir.Name()),
-				zap.Error(err))
+			return nil, err
+		}
+		for _, build := range builds {
+			live[build.ID] = true
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for id, build := range p.builds {
+		if build.Unfinished() {
+			live[id] = true
+		}
+	}
+	return live, nil
+}
+
+// MigrateArtifacts moves the artifacts kept under build_dir before
+// artifacts_dir was honoured into it and points their builds there. It
+// returns how many artifacts moved.
+func (p *Pipeline) MigrateArtifacts(ctx context.Context) (int, error) {
+	layout, err := builder.NewLayout(p.config)
+	if err != nil {
+		return 0, err
+	}
+	moved, err := layout.MigrateArtifacts()
+	if len(moved) > 0 && p.store != nil {
+		if storeErr := p.store.RelocateArtifacts(ctx, moved); storeErr != nil {
+			return len(moved), fmt.Errorf("failed to update artifact paths: %w", storeErr)
+		}
+	}
+	if err != nil {
+		return len(moved), fmt.Errorf("failed to move artifacts: %w", err)
+	}
+	return len(moved), nil
+}
+
+// PurgeProject permanently removes everything the pipeline holds for a
+// project: running builds are cancelled, monitoring stops, the deployment and
+// add-ons are torn down and build artifacts and history are deleted. It is safe to call
+// again after a partial failure.
+func (p *Pipeline) PurgeProject(ctx context.Context, projectID string) error {
+	p.mu.Lock()
+	var buildIDs []string
+	for _, build := range p.builds {
+		if build.ProjectID != projectID {
 			continue
 		}
+		buildIDs = append(buildIDs, build.ID)
+		if build.Status == types.BuildStatusBuilding && build.CancelFunc != nil {
+			build.CancelFunc()
+		}
+	}
+	// Queued pushes must not start once the project is gone
+	p.dropQueued(projectID)
+	for key := range p.migrations {
+		if key.projectID == projectID {
+			delete(p.migrations, key)
+		}
+	}
+	p.mu.Unlock()
 
-		if now.Sub(info.ModTime()) > maxAge {
-			path := filepath.Join(cm.config.BuildDir, dir.Name())
+	if p.monitor != nil {
+		p.monitor.Untrack(projectID)
+	}
+
+	for _, remover := range p.removers() {
+		if err := remover.Remove(ctx, projectID, buildIDs); err != nil {
+			return fmt.Errorf("failed to remove deployment: %w", err)
+		}
+	}
+	if p.addons != nil {
+		if err := p.addons.Remove(ctx, projectID); err != nil {
+			return fmt.Errorf("failed to remove add-ons: %w", err)
+		}
+	}
+
+	layout, err := builder.NewLayout(p.config)
+	if err != nil {
+		return err
+	}
+	for _, buildID := range buildIDs {
+		for _, root := range layout.Dirs() {
+			path := filepath.Join(root, buildID)
 			if err := os.RemoveAll(path); err != nil {
-				cm.logger.Error("failed to remove old build",
-					zap.String("path", path),
-					zap.Error(err))
+				return fmt.Errorf("failed to remove %s: %w", path, err)
 			}
 		}
 	}
 
+	if p.store != nil {
+		if err := p.store.DeleteProjectBuilds(ctx, projectID); err != nil {
+			return fmt.Errorf("failed to delete build records: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	for _, buildID := range buildIDs {
+		delete(p.builds, buildID)
+		delete(p.storedEvents, buildID)
+	}
+	p.mu.Unlock()
+
+	p.logger.Info("purged project",
+		zap.String("project", projectID),
+		zap.Int("builds", len(buildIDs)))
 	return nil
 }
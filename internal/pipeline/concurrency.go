@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// Concurrency is a snapshot of the builds and deploys of this instance and
+// of the agents its builds run on, meant to drive the autoscaling of
+// chef-infra replicas and build agents
+type Concurrency struct {
+	BuildSlots    int // 0 when unlimited
+	RunningBuilds int // Holding a build slot
+	QueuedBuilds  int // Waiting for a slot or behind a build of the same branch
+	Deploying     int
+	Agents        int
+	AgentSlots    int
+	AgentBuilds   int // Running on agents
+	AgentQueued   int // Waiting for a free agent
+}
+
+// Utilization is the share of build slots in use, 0 when unlimited
+func (c Concurrency) Utilization() float64 {
+	if c.BuildSlots == 0 {
+		return 0
+	}
+	return float64(c.RunningBuilds) / float64(c.BuildSlots)
+}
+
+// AgentUtilization is the share of agent slots in use
+func (c Concurrency) AgentUtilization() float64 {
+	if c.AgentSlots == 0 {
+		return 0
+	}
+	return float64(c.AgentBuilds) / float64(c.AgentSlots)
+}
+
+// agentPool is implemented by builder factories running builds on agents
+type agentPool interface {
+	Status() agent.Status
+}
+
+// factoryWrapper is implemented by builder factories wrapping another
+type factoryWrapper interface {
+	Unwrap() builder.FactoryInterface
+}
+
+// agents returns the agent pool builds run on, looking through wrappers
+// like fault injection
+func (p *Pipeline) agents() (agentPool, bool) {
+	factory := p.builderFactory
+	for {
+		if pool, ok := factory.(agentPool); ok {
+			return pool, true
+		}
+		wrapper, ok := factory.(factoryWrapper)
+		if !ok {
+			return nil, false
+		}
+		factory = wrapper.Unwrap()
+	}
+}
+
+// Concurrency reports the builds and deploys of this instance
+func (p *Pipeline) Concurrency() Concurrency {
+	p.mu.RLock()
+	c := Concurrency{
+		BuildSlots:    max(p.config.Scheduling.MaxConcurrentBuilds, 0),
+		RunningBuilds: len(p.active),
+		QueuedBuilds:  len(p.waiting),
+	}
+	for _, l := range p.lanes {
+		c.QueuedBuilds += len(l.pending)
+	}
+	p.mu.RUnlock()
+	c.Deploying = int(p.deploying.Load())
+
+	if pool, ok := p.agents(); ok {
+		status := pool.Status()
+		c.Agents = len(status.Agents)
+		c.AgentQueued = len(status.Waiting)
+		for _, a := range status.Agents {
+			c.AgentSlots += a.Capacity
+			c.AgentBuilds += a.Running
+		}
+	}
+	return c
+}
+
+// WriteConcurrency writes the concurrency of this instance as Prometheus
+// gauges, e.g. for KEDA or an HPA through the Prometheus adapter
+func (p *Pipeline) WriteConcurrency(w io.Writer) {
+	c := p.Concurrency()
+
+	fmt.Fprintf(w, "# HELP chef_build_slots Builds this instance runs at once, 0 when unlimited.\n# TYPE chef_build_slots gauge\n")
+	fmt.Fprintf(w, "chef_build_slots %d\n", c.BuildSlots)
+	fmt.Fprintf(w, "# HELP chef_build_slots_used Builds holding a build slot.\n# TYPE chef_build_slots_used gauge\n")
+	fmt.Fprintf(w, "chef_build_slots_used %d\n", c.RunningBuilds)
+	fmt.Fprintf(w, "# HELP chef_build_slot_utilization Share of build slots in use, 0 when unlimited.\n# TYPE chef_build_slot_utilization gauge\n")
+	fmt.Fprintf(w, "chef_build_slot_utilization %g\n", c.Utilization())
+	fmt.Fprintf(w, "# HELP chef_build_queue_depth Builds waiting for a slot or behind a build of the same branch.\n# TYPE chef_build_queue_depth gauge\n")
+	fmt.Fprintf(w, "chef_build_queue_depth %d\n", c.QueuedBuilds)
+	fmt.Fprintf(w, "# HELP chef_deploys_running Deploys in progress.\n# TYPE chef_deploys_running gauge\n")
+	fmt.Fprintf(w, "chef_deploys_running %d\n", c.Deploying)
+
+	if _, ok := p.agents(); !ok {
+		return
+	}
+	fmt.Fprintf(w, "# HELP chef_agents_connected Build agents connected to this instance.\n# TYPE chef_agents_connected gauge\n")
+	fmt.Fprintf(w, "chef_agents_connected %d\n", c.Agents)
+	fmt.Fprintf(w, "# HELP chef_agent_slots Builds the connected agents run at once.\n# TYPE chef_agent_slots gauge\n")
+	fmt.Fprintf(w, "chef_agent_slots %d\n", c.AgentSlots)
+	fmt.Fprintf(w, "# HELP chef_agent_slots_used Builds running on agents.\n# TYPE chef_agent_slots_used gauge\n")
+	fmt.Fprintf(w, "chef_agent_slots_used %d\n", c.AgentBuilds)
+	fmt.Fprintf(w, "# HELP chef_agent_utilization Share of agent slots in use.\n# TYPE chef_agent_utilization gauge\n")
+	fmt.Fprintf(w, "chef_agent_utilization %g\n", c.AgentUtilization())
+	fmt.Fprintf(w, "# HELP chef_agent_queue_depth Builds waiting for a free agent.\n# TYPE chef_agent_queue_depth gauge\n")
+	fmt.Fprintf(w, "chef_agent_queue_depth %d\n", c.AgentQueued)
+}
+
+// GetConcurrency reports the instance answering; clients behind a load
+// balancer see one replica per call
+func (h *Handler) GetConcurrency(ctx context.Context, req *pb.GetConcurrencyRequest) (*pb.GetConcurrencyResponse, error) {
+	c := h.pipeline.Concurrency()
+	return &pb.GetConcurrencyResponse{
+		BuildSlots:        int32(c.BuildSlots),
+		RunningBuilds:     int32(c.RunningBuilds),
+		QueuedBuilds:      int32(c.QueuedBuilds),
+		Deploying:         int32(c.Deploying),
+		Utilization:       c.Utilization(),
+		Agents:            int32(c.Agents),
+		AgentSlots:        int32(c.AgentSlots),
+		AgentBuilds:       int32(c.AgentBuilds),
+		AgentQueuedBuilds: int32(c.AgentQueued),
+		AgentUtilization:  c.AgentUtilization(),
+	}, nil
+}
@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestPipeline_Concurrency(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.config.Scheduling.MaxConcurrentBuilds = 4
+	pipeline.active = map[*types.Build]bool{{ID: "a"}: false, {ID: "b"}: false}
+	pipeline.waiting = []*types.Build{{ID: "c"}}
+	pipeline.lanes = map[laneKey]*lane{{project: "shop", ref: "main"}: {pending: []*types.Build{{ID: "d"}}}}
+	pipeline.deploying.Add(1)
+
+	c := pipeline.Concurrency()
+	assert.Equal(t, Concurrency{BuildSlots: 4, RunningBuilds: 2, QueuedBuilds: 2, Deploying: 1}, c)
+	assert.Equal(t, 0.5, c.Utilization())
+
+	var out strings.Builder
+	pipeline.WriteConcurrency(&out)
+	assert.Contains(t, out.String(), "chef_build_slot_utilization 0.5\n")
+	assert.Contains(t, out.String(), "chef_build_queue_depth 2\n")
+	assert.NotContains(t, out.String(), "chef_agent", "no agents without an agent pool")
+
+	registry := agent.NewRegistry(&config.AgentsConfig{Enabled: true}, nil, zap.NewNop())
+	registry.Register("agent-1", nil, 2)
+	registry.Register("agent-2", nil, 3)
+	pipeline.builderFactory = agent.NewFactory(registry)
+
+	c = pipeline.Concurrency()
+	assert.Equal(t, 2, c.Agents)
+	assert.Equal(t, 5, c.AgentSlots)
+	assert.Zero(t, c.AgentUtilization())
+
+	out.Reset()
+	pipeline.WriteConcurrency(&out)
+	assert.Contains(t, out.String(), "chef_agent_slots 5\n")
+}
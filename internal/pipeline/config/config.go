@@ -1,33 +1,572 @@
 package config
 
 type PipelineConfig struct {
-	BuildDir       string       `mapstructure:"build_dir"`
-	ArtifactsDir   string       `mapstructure:"artifacts_dir"`
-	CacheDir       string       `mapstructure:"cache_dir"`
-	DefaultTimeout int          `mapstructure:"default_timeout"`
-	NodeJS         NodeJSConfig `mapstructure:"nodejs"`
-	Deploy         DeployConfig `mapstructure:"deploy"`
+	BuildDir       string           `mapstructure:"build_dir"`
+	ArtifactsDir   string           `mapstructure:"artifacts_dir"` // Artifacts of local and agent builds, <build_dir>/artifacts when empty
+	CacheDir       string           `mapstructure:"cache_dir"`
+	DefaultTimeout int              `mapstructure:"default_timeout"` // Seconds each phase may take unless timeouts sets it, 0 for no limit
+	Timeouts       TimeoutsConfig   `mapstructure:"timeouts"`
+	BuildDedup     string           `mapstructure:"build_dedup"` // "queue" (default) or "supersede", projects may override
+	Scheduling     SchedulingConfig `mapstructure:"scheduling"`
+	Agents         AgentsConfig     `mapstructure:"agents"`
+	NodeJS         NodeJSConfig     `mapstructure:"nodejs"`
+	Docker         DockerConfig     `mapstructure:"docker"`
+	Deploy         DeployConfig     `mapstructure:"deploy"`
+	Monitor        MonitorConfig    `mapstructure:"monitor"`
+	Exec           ExecConfig       `mapstructure:"exec"`
+	Debug          DebugConfig      `mapstructure:"debug"`
+	Source         SourceConfig     `mapstructure:"source"`
+	Preview        PreviewConfig    `mapstructure:"preview"`
+	ImageGC        ImageGCConfig    `mapstructure:"image_gc"`
+	Provenance     ProvenanceConfig `mapstructure:"provenance"`
+	Policy         PolicyConfig     `mapstructure:"policy"`
+	Usage          UsageConfig      `mapstructure:"usage"`
+	PerfAudit      PerfAuditConfig  `mapstructure:"perf_audit"`
+	Integrity      IntegrityConfig  `mapstructure:"integrity"`
+	SourceMaps     SourceMapsConfig `mapstructure:"source_maps"`
+	Releases       ReleasesConfig   `mapstructure:"releases"`
+	StatusPage     StatusPageConfig `mapstructure:"status_page"`
+	Badges         BadgesConfig     `mapstructure:"badges"`
+	AddOns         AddOnConfig      `mapstructure:"addons"`
+	Migration      MigrationConfig  `mapstructure:"migration"`
+	DeployLock     DeployLockConfig `mapstructure:"deploy_lock"`
+	Recovery       RecoveryConfig   `mapstructure:"recovery"`
+	Faults         FaultsConfig     `mapstructure:"faults"`  // Only allowed with APP_ENV=testing
+	Plugins        []PluginConfig   `mapstructure:"plugins"` // Run in order at their stages
+}
+
+// PluginConfig enables a pipeline plugin, e.g. a scanner before deploys or
+// a ticket update after them. Plugins see the build without its env vars.
+type PluginConfig struct {
+	Name   string   `mapstructure:"name"`
+	Type   string   `mapstructure:"type"`   // "command", "http" or a built-in plugin
+	Stages []string `mapstructure:"stages"` // "pre_build", "post_build", "pre_deploy" and "post_deploy"
+	// Command is started with the build as JSON on stdin and the stage in
+	// CHEF_PLUGIN_STAGE; a non-zero exit is a failure
+	Command []string `mapstructure:"command"`
+	// URL receives the build and stage as a JSON POST; a non-2xx response
+	// is a failure
+	URL      string            `mapstructure:"url"`
+	Options  map[string]string `mapstructure:"options"`  // Settings of built-in plugins
+	Timeout  int               `mapstructure:"timeout"`  // Seconds per run, defaults to 60
+	Required bool              `mapstructure:"required"` // Failures fail the build instead of being logged
+}
+
+// SchedulingConfig bounds the builds running at once. Waiting builds start
+// by priority, then in the order they were started.
+type SchedulingConfig struct {
+	MaxConcurrentBuilds int  `mapstructure:"max_concurrent_builds"` // Unlimited when 0
+	Preempt             bool `mapstructure:"preempt"`               // Cancel and requeue lower priority builds when no slot is free
+	// HighPriorityEnvironments are the environments whose builds default to
+	// high priority, defaults to production. Other builds default to normal.
+	HighPriorityEnvironments []string `mapstructure:"high_priority_environments"`
+}
+
+// AgentsConfig runs builds on remote build agents instead of this server's
+// Docker host. Uploaded artifacts are kept under build_dir like those of
+// local builds; images only reach deployers when agents push them to
+// nodejs.registry.
+type AgentsConfig struct {
+	Enabled          bool   `mapstructure:"enabled"`
+	Token            string `mapstructure:"token"`             // Shared secret agents authenticate with
+	HeartbeatTimeout int    `mapstructure:"heartbeat_timeout"` // Seconds without a heartbeat before an agent is dropped, defaults to 30
+	AssignTimeout    int    `mapstructure:"assign_timeout"`    // Seconds a build waits for a matching agent, defaults to 600
+
+	Autoscale AutoscaleConfig `mapstructure:"autoscale"`
+}
+
+// AutoscaleConfig launches agents while builds wait for one and shuts down
+// agents idle past the cooldown. Launched agents must register under their
+// instance name (chef-agent -name), which is the default for Kubernetes
+// and Hetzner; EC2 user data must pass the instance ID. Each control plane
+// instance scales for the builds waiting on it.
+type AutoscaleConfig struct {
+	Enabled        bool              `mapstructure:"enabled"`
+	Provider       string            `mapstructure:"provider"`        // "kubernetes", "ec2" or "hetzner"
+	MinAgents      int               `mapstructure:"min_agents"`      // Agents kept running when idle
+	MaxAgents      int               `mapstructure:"max_agents"`      // Defaults to 10
+	QueueThreshold int               `mapstructure:"queue_threshold"` // Waiting builds tolerated before launching agents
+	Capacity       int               `mapstructure:"capacity"`        // Builds each launched agent runs at once, defaults to 1
+	Labels         map[string]string `mapstructure:"labels"`          // Labels launched agents register with; only builds they meet count as waiting
+	IdleCooldown   int               `mapstructure:"idle_cooldown"`   // Seconds an agent stays idle before it is shut down, defaults to 600
+	StartupTimeout int               `mapstructure:"startup_timeout"` // Seconds a launched agent counts as starting until it registers, defaults to 300
+	Interval       int               `mapstructure:"interval"`        // Seconds between scaling decisions, defaults to 30
+
+	Kubernetes KubernetesScalerConfig `mapstructure:"kubernetes"`
+	EC2        EC2ScalerConfig        `mapstructure:"ec2"`
+	Hetzner    HetznerScalerConfig    `mapstructure:"hetzner"`
+}
+
+// KubernetesScalerConfig runs each agent as a Job. The image must run
+// chef-agent with access to a Docker daemon, e.g. through DOCKER_HOST.
+type KubernetesScalerConfig struct {
+	Namespace      string   `mapstructure:"namespace"`       // Defaults to deploy.namespace
+	Image          string   `mapstructure:"image"`           // Image with chef-agent as its entrypoint
+	Server         string   `mapstructure:"server"`          // Control plane address agents connect to
+	TokenSecret    string   `mapstructure:"token_secret"`    // Secret holding the agent token under the "token" key
+	ServiceAccount string   `mapstructure:"service_account"` // Defaults to the namespace default
+	CPU            string   `mapstructure:"cpu"`             // Resource request and limit, e.g. "2"
+	Memory         string   `mapstructure:"memory"`          // Resource request and limit, e.g. "4Gi"
+	Args           []string `mapstructure:"args"`            // Extra chef-agent flags, e.g. ["-insecure"]
+}
+
+// EC2ScalerConfig scales an Auto Scaling group whose launch template
+// starts chef-agent. Credentials fall back to the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+type EC2ScalerConfig struct {
+	Region           string `mapstructure:"region"`
+	AutoScalingGroup string `mapstructure:"auto_scaling_group"`
+	AccessKeyID      string `mapstructure:"access_key_id"`
+	SecretAccessKey  string `mapstructure:"secret_access_key"`
+	Endpoint         string `mapstructure:"endpoint"` // Defaults to the regional Auto Scaling endpoint
+}
+
+// HetznerScalerConfig creates Hetzner Cloud servers whose user data starts
+// chef-agent. Servers are labelled chef-agent so only they are scaled.
+type HetznerScalerConfig struct {
+	Token      string `mapstructure:"token"`
+	ServerType string `mapstructure:"server_type"` // Defaults to "cx22"
+	Image      string `mapstructure:"image"`       // Defaults to "docker-ce"
+	Location   string `mapstructure:"location"`    // e.g. "fsn1", defaults to Hetzner's choice
+	UserData   string `mapstructure:"user_data"`   // cloud-init config that installs and starts chef-agent
+	Endpoint   string `mapstructure:"endpoint"`    // Defaults to https://api.hetzner.cloud/v1
+}
+
+// PerfAuditConfig runs a Lighthouse audit in a headless browser container
+// against each deployed app and alerts when category scores drop
+type PerfAuditConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	Image        string   `mapstructure:"image"`        // Image with the lighthouse CLI and Chromium, defaults to "femtopixel/google-lighthouse"
+	Categories   []string `mapstructure:"categories"`   // Defaults to performance, accessibility, best-practices and seo
+	Environments []string `mapstructure:"environments"` // Environments audited, all when empty
+	Threshold    int      `mapstructure:"threshold"`    // Points a score may drop from the previous deploy before alerting, defaults to 10
+	Timeout      int      `mapstructure:"timeout"`      // Seconds per audit, defaults to 120
+}
+
+// IntegrityConfig verifies after each deploy that a sample of the artifact's
+// files is served unchanged from the app's URL
+type IntegrityConfig struct {
+	Enabled  bool `mapstructure:"enabled"`
+	Samples  int  `mapstructure:"samples"`  // Files fetched per deploy, defaults to 20
+	Attempts int  `mapstructure:"attempts"` // Checks before giving up while caches settle, defaults to 3
+	Timeout  int  `mapstructure:"timeout"`  // Seconds per request, defaults to 10
+	Enforce  bool `mapstructure:"enforce"`  // Roll back on mismatches instead of recording a warning
+}
+
+// SourceMapsConfig uploads the source maps of projects whose chef.yaml
+// sets build.source_maps to upload. Each build is uploaded as a release
+// named after the build ID, which bundles can report as {{ .Build.ID }}.
+type SourceMapsConfig struct {
+	Provider     string            `mapstructure:"provider"` // "sentry", uploads fail when empty
+	URL          string            `mapstructure:"url"`      // Defaults to https://sentry.io
+	Organization string            `mapstructure:"organization"`
+	Token        string            `mapstructure:"token"`    // Auth token with the project:releases scope
+	Projects     map[string]string `mapstructure:"projects"` // Project name -> Sentry project slug, defaults to the project name
+	Timeout      int               `mapstructure:"timeout"`  // Seconds per request, defaults to 30
+}
+
+// ReleasesConfig records the commits each deploy ships since the previous
+// deploy of the environment as the build's changelog and, with Tag, creates
+// a release of the deployed commit at the git provider it was pushed to
+type ReleasesConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	Environments []string `mapstructure:"environments"` // Defaults to production
+	Tag          bool     `mapstructure:"tag"`          // Create a release with the changelog as notes
+	TagFormat    string   `mapstructure:"tag_format"`   // {env}, {timestamp}, {commit} and {build} are substituted, defaults to "{env}-{timestamp}"
+	Timeout      int      `mapstructure:"timeout"`      // Seconds, defaults to 30
+}
+
+// StatusPageConfig serves a read-only status page at /status/{project} on
+// the monitor's metrics address for projects whose owners enable it
+type StatusPageConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	Environment string `mapstructure:"environment"` // Whose deployed version is shown, defaults to production
+}
+
+// BadgesConfig serves SVG build status and deployed version badges at
+// /badge/{project}/build.svg and /badge/{project}/version.svg on the
+// monitor's metrics address. Badges follow the project's status page
+// setting: public pages have public badges, protected ones take its token.
+type BadgesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	MaxAge  int  `mapstructure:"max_age"` // Seconds badges may be cached, defaults to 300
+}
+
+// UsageConfig controls usage metering for billing
+type UsageConfig struct {
+	Enabled  bool `mapstructure:"enabled"`
+	Interval int  `mapstructure:"interval"` // Seconds between samples, defaults to 300
+}
+
+// AddOnConfig provisions the managed services projects request with addons
+// in chef.yaml. Each add-on's connection settings are kept in a Kubernetes
+// secret in namespace and injected into the project's deployments, so
+// add-ons need the kubernetes deploy platform. Add-ons are torn down with
+// their project; removing one from chef.yaml keeps it and its data.
+type AddOnConfig struct {
+	// Provider is "kubernetes" to run each add-on as a StatefulSet or
+	// "external" to request it from a provisioning API. Empty disables
+	// add-ons and fails deploys of projects requesting them.
+	Provider      string `mapstructure:"provider"`
+	Namespace     string `mapstructure:"namespace"`      // Defaults to deploy.namespace
+	StorageSize   string `mapstructure:"storage_size"`   // kubernetes, volume size per add-on, defaults to 1Gi
+	StorageClass  string `mapstructure:"storage_class"`  // kubernetes, defaults to the cluster default
+	PostgresImage string `mapstructure:"postgres_image"` // kubernetes, defaults to postgres:16-alpine
+	RedisImage    string `mapstructure:"redis_image"`    // kubernetes, defaults to redis:7-alpine
+	// APIURL receives POST /instances with the project and kind and
+	// DELETE /instances/{id} for the external provider
+	APIURL  string `mapstructure:"api_url"`
+	Token   string `mapstructure:"token"`   // external, sent as a bearer token
+	Timeout int    `mapstructure:"timeout"` // Seconds an add-on may take to become ready, defaults to 300
+	// DeleteTimeout is how long, in seconds, removal waits for an add-on's
+	// StatefulSet and volumes to be gone, defaults to 120
+	DeleteTimeout int `mapstructure:"delete_timeout"`
+}
+
+// PolicyConfig holds the rules a build must pass before it is deployed.
+// Unset rules are not evaluated.
+type PolicyConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	Environments []string `mapstructure:"environments"` // Environments the rules apply to, all when empty
+	// AllowedRegistries are prefixes of the image references deploys may
+	// use, e.g. "registry.example.com/team"; "local" allows unpushed images
+	AllowedRegistries []string `mapstructure:"allowed_registries"`
+	// AllowedBaseImages are patterns every base image must match, e.g.
+	// "node:20-*"
+	AllowedBaseImages []string `mapstructure:"allowed_base_images"`
+	MaxImageSize      int64    `mapstructure:"max_image_size"` // Bytes
+	// MaxVulnerabilities limits findings per severity, e.g.
+	// {critical = 0, high = 5}. Images are scanned with trivy.
+	MaxVulnerabilities map[string]int `mapstructure:"max_vulnerabilities"`
+	RequiredApprovals  int            `mapstructure:"required_approvals"` // Distinct approvers, previews are exempt
+}
+
+// ProvenanceConfig controls SLSA provenance and cosign signatures. The
+// cosign CLI must be installed to sign or verify; COSIGN_PASSWORD in the
+// server's environment unlocks encrypted keys.
+type ProvenanceConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`    // Write provenance for every build, implied by key
+	Key       string `mapstructure:"key"`        // cosign private key path or KMS URI, e.g. "awskms:///alias/chef"; signing is off when empty
+	PublicKey string `mapstructure:"public_key"` // Defaults to the key for KMS URIs and to <key>.pub for key files
+	Verify    bool   `mapstructure:"verify"`     // Refuse to deploy builds whose signatures do not verify
+	BuilderID string `mapstructure:"builder_id"` // Identifies this server in provenance
+	// TlogUpload records signatures in the public Rekor transparency log.
+	// Off by default since entries reveal image names.
+	TlogUpload bool `mapstructure:"tlog_upload"`
+}
+
+// ImageGCConfig controls removal of old chef-* images from the Docker
+// host. Images of pinned builds, active previews and current deployments
+// are always kept. Each run also removes the containers and stage images
+// of builds that are no longer running.
+type ImageGCConfig struct {
+	Enabled        bool  `mapstructure:"enabled"`
+	Interval       int   `mapstructure:"interval"`         // Seconds between runs, defaults to 3600
+	KeepPerProject int   `mapstructure:"keep_per_project"` // Newest images kept per project, 0 keeps all
+	MaxTotalSize   int64 `mapstructure:"max_total_size"`   // Bytes, older images are removed above it; 0 disables
+	MinAge         int   `mapstructure:"min_age"`          // Seconds before an image may be removed, defaults to 86400
+	// DryRun only logs what would be removed
+	DryRun   bool             `mapstructure:"dry_run"`
+	Registry RegistryGCConfig `mapstructure:"registry"`
+}
+
+// RegistryGCConfig applies the image GC policy to the registry builds push
+// to (nodejs.registry, falling back to deploy.registry)
+type RegistryGCConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Provider string `mapstructure:"provider"` // "registry" (Docker Registry v2, default) or "harbor"
+	URL      string `mapstructure:"url"`      // API base URL, defaults to https://<registry host>
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// PreviousPassword is tried when Password is refused, so the password
+	// can be changed in the config before or after on the registry
+	PreviousPassword string `mapstructure:"previous_password"`
+}
+
+// PreviewConfig controls temporary deployments of builds awaiting
+// promotion
+type PreviewConfig struct {
+	TTL int `mapstructure:"ttl"` // Seconds a preview stays up, defaults to 86400
+}
+
+// MigrationConfig controls moving a project's environment to another
+// deploy target. The new target must pass a health check before traffic
+// is switched, and the old one keeps its deployment for the rollback
+// window.
+type MigrationConfig struct {
+	RollbackWindow int    `mapstructure:"rollback_window"` // Seconds the old target is kept, defaults to 86400
+	HealthPath     string `mapstructure:"health_path"`     // Requested on the new target, defaults to /
+	HealthTimeout  int    `mapstructure:"health_timeout"`  // Seconds to wait for a 2xx response, defaults to 300
+	// SwitchCommand moves DNS or ingress between targets. It is started
+	// with CHEF_PROJECT_ID, CHEF_ENVIRONMENT, CHEF_FROM_URL and CHEF_TO_URL
+	// set, and again with the URLs swapped on rollback. Without it only
+	// chef-infra's own routing changes.
+	SwitchCommand []string `mapstructure:"switch_command"`
+}
+
+// DeployLockConfig tunes the lock serializing deploys of a project's
+// environment. Holders renew the lock while deploying; a lock not renewed
+// within the lease, e.g. of a crashed replica, is taken over.
+// TimeoutsConfig bounds each phase of a build in seconds, defaulting to
+// default_timeout. Projects may raise or lower all but clone in chef.yaml.
+type TimeoutsConfig struct {
+	Clone       int `mapstructure:"clone"`
+	Install     int `mapstructure:"install"`
+	Build       int `mapstructure:"build"`
+	Package     int `mapstructure:"package"`
+	Deploy      int `mapstructure:"deploy"`
+	HealthCheck int `mapstructure:"health_check"`
+}
+
+type DeployLockConfig struct {
+	Lease   int `mapstructure:"lease"`   // Seconds, defaults to 60
+	Timeout int `mapstructure:"timeout"` // Seconds a deploy waits for the lock before failing, defaults to 1800
+}
+
+// RecoveryConfig decides what happens to builds left unfinished by a
+// stopped server. Instances mark their running builds alive; on startup,
+// and periodically after, builds of an earlier process on the same host or
+// not marked alive within stale_after are marked interrupted.
+type RecoveryConfig struct {
+	Requeue    bool `mapstructure:"requeue"`     // Start interrupted builds again under a new ID
+	StaleAfter int  `mapstructure:"stale_after"` // Seconds, defaults to 600
+}
+
+// FaultsConfig makes pipeline operations fail or hang on purpose, so
+// integration tests can exercise retries, rollbacks and timeouts. Faults
+// can also be set and cleared at runtime by tests holding the injector.
+type FaultsConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Faults  []FaultConfig `mapstructure:"faults"`
+}
+
+type FaultConfig struct {
+	Point    string `mapstructure:"point"`    // "docker_build", "artifact_upload", "k8s_apply" or "db_write"
+	Mode     string `mapstructure:"mode"`     // "fail" or "hang"
+	Message  string `mapstructure:"message"`  // Error of failed operations
+	Times    int    `mapstructure:"times"`    // Operations affected, all when 0
+	Duration int    `mapstructure:"duration"` // Milliseconds a hang lasts, until the operation is cancelled when 0
+}
+
+// SourceConfig controls how repositories are fetched. Submodules and LFS
+// objects are fetched when a repository uses them unless disabled here.
+type SourceConfig struct {
+	Root              string             `mapstructure:"root"` // Checkouts builds are started from, defaults to <build_dir>/sources
+	DisableSubmodules bool               `mapstructure:"disable_submodules"`
+	DisableLFS        bool               `mapstructure:"disable_lfs"` // LFS files are left as pointer files
+	Limits            SourceLimitsConfig `mapstructure:"limits"`
+}
+
+// SourceLimitsConfig fails builds of sources too large for the host before
+// they fill its disk. 0 disables a limit.
+type SourceLimitsConfig struct {
+	// MaxRepoSize is checked with the provider's API before cloning, when
+	// it reports one, and while cloning
+	MaxRepoSize int64 `mapstructure:"max_repo_size"` // Bytes
+	MaxTreeSize int64 `mapstructure:"max_tree_size"` // Bytes of the source tree builds copy, without node_modules and .git
+	MaxFiles    int   `mapstructure:"max_files"`     // Files of the source tree builds copy
+}
+
+// ExecConfig controls interactive debugging sessions in running workloads
+type ExecConfig struct {
+	Enabled         bool     `mapstructure:"enabled"`
+	AllowedCommands []string `mapstructure:"allowed_commands"` // Executables that may be started, as sent; empty rejects every session
+	SessionTimeout  int      `mapstructure:"session_timeout"`  // Seconds, defaults to 900
+	IdleTimeout     int      `mapstructure:"idle_timeout"`     // Seconds without input, defaults to 300
+}
+
+// DebugConfig keeps failed builds around for a shell in their last
+// successful stage. Sessions use the exec timeouts.
+type DebugConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	TTL     int  `mapstructure:"ttl"` // Seconds a failed build stays debuggable, defaults to 3600
+}
+
+type MonitorConfig struct {
+	Enabled          bool   `mapstructure:"enabled"`
+	Interval         int    `mapstructure:"interval"`          // Seconds between checks, defaults to 30
+	Timeout          int    `mapstructure:"timeout"`           // Seconds per check, defaults to 5
+	HealthPath       string `mapstructure:"health_path"`       // Appended to each app's URL, e.g. "/healthz"
+	Scheme           string `mapstructure:"scheme"`            // Defaults to https
+	FailureThreshold int    `mapstructure:"failure_threshold"` // Consecutive failures before acting, defaults to 3
+	NotifyURL        string `mapstructure:"notify_url"`        // Webhook notified on sustained failures
+	AutoRestart      bool   `mapstructure:"auto_restart"`      // Restart the workload on sustained failures
+	MetricsAddr      string `mapstructure:"metrics_addr"`      // Serves Prometheus metrics when set, e.g. ":9102"
 }
 
 type DeployConfig struct {
-	Platform      string `mapstructure:"platform"` // "kubernetes" or "static"
+	Platform      string `mapstructure:"platform"` // "kubernetes", "static", "ssh", "external", "sftp" or "ftps"
 	Namespace     string `mapstructure:"namespace"`
 	IngressDomain string `mapstructure:"ingress_domain"`
 	Registry      string `mapstructure:"registry"`
 	PullSecret    string `mapstructure:"pull_secret"`
-	ReplicaCount  int    `mapstructure:"replica_count"`
+	ReplicaCount  int    `mapstructure:"replica_count"` // Replicas of a first deploy, later deploys keep the current ones
+	MinReplicas   int    `mapstructure:"min_replicas"`  // Lower bound for ScaleDeployment, defaults to 1
+	MaxReplicas   int    `mapstructure:"max_replicas"`  // Upper bound for ScaleDeployment, defaults to 10
+	// ForceApply takes over fields of kubernetes objects that another
+	// client manages instead of failing the deploy with a conflict
+	ForceApply bool `mapstructure:"force_apply"`
+	// DeleteTimeout is how long, in seconds, removing a project waits for
+	// its kubernetes objects to be gone, defaults to 120
+	DeleteTimeout int `mapstructure:"delete_timeout"`
+	// RolloutTimeout is how long, in seconds, kubernetes deploys wait for
+	// the new pods to become available, recording the rollout's progress
+	// and pod problems as build events. A rollout that does not finish
+	// fails the deploy. 0 returns once the objects are applied.
+	RolloutTimeout int `mapstructure:"rollout_timeout"`
 
 	// Static deployment specific configuration
 	StaticPath    string `mapstructure:"static_path"`     // Path where static files will be deployed
 	MaxDeploySize int64  `mapstructure:"max_deploy_size"` // Maximum size of deployable artifacts in bytes
+
+	// Terraform/OpenTofu manifest emitted after static deployments
+	TerraformManifest    bool   `mapstructure:"terraform_manifest"`     // Emit a main.tf.json describing deployed objects
+	TerraformManifestDir string `mapstructure:"terraform_manifest_dir"` // Outside static_path, defaults to /var/lib/chef-infra/terraform
+
+	// Replication of static deployments to the web servers serving them
+	Sync StaticSyncConfig `mapstructure:"sync"`
+
+	// Deployment to a plain VM for the ssh platform
+	SSH SSHDeployConfig `mapstructure:"ssh"`
+
+	// Static hosting provider for the external platform
+	External ExternalDeployConfig `mapstructure:"external"`
+
+	// Shared hosting for the sftp and ftps platforms
+	FileTransfer FileTransferConfig `mapstructure:"file_transfer"`
+
+	// Targets deploys environments elsewhere than the settings above, e.g.
+	// staging to kubernetes and production to static hosting. Settings a
+	// target leaves unset are taken from above. Logs, exec, scaling and
+	// restarts act on the default target.
+	Targets map[string]DeployConfig `mapstructure:"targets"`
+}
+
+// StaticSyncConfig replicates each static release to a list of hosts over
+// SSH. Every host keeps its releases in <remote_path>/releases/<project>
+// and serves <remote_path>/<project>, a symlink switched to a release once
+// enough hosts hold an identical copy of it.
+type StaticSyncConfig struct {
+	Hosts        []string `mapstructure:"hosts"`         // SSH destinations, e.g. deploy@web1.example.com
+	Method       string   `mapstructure:"method"`        // "rsync" pushes to every host, "s3" uploads once and hosts pull; defaults to rsync
+	RemotePath   string   `mapstructure:"remote_path"`   // Defaults to static_path
+	Bucket       string   `mapstructure:"bucket"`        // s3://bucket/prefix releases are uploaded to by the s3 method
+	SSHKey       string   `mapstructure:"ssh_key"`       // Identity file, defaults to the SSH agent
+	SSHPort      int      `mapstructure:"ssh_port"`      // Defaults to 22
+	MinHosts     int      `mapstructure:"min_hosts"`     // Hosts that must switch for the deploy to succeed, defaults to all
+	Timeout      int      `mapstructure:"timeout"`       // Seconds per host, defaults to 300
+	KeepReleases int      `mapstructure:"keep_releases"` // Releases kept per project on each host, defaults to 5
+}
+
+// SSHDeployConfig deploys each project to <remote_path>/<project> on a VM.
+// Every build is extracted to releases/<build> there and the current
+// symlink is switched to it; the previous releases are kept for rollbacks.
+// Commands run through the login shell in the new release with RELEASE,
+// PROJECT, BUILD_ID and ARTIFACT set.
+type SSHDeployConfig struct {
+	Host             string   `mapstructure:"host"` // host or host:port, port defaults to 22
+	User             string   `mapstructure:"user"`
+	PrivateKey       string   `mapstructure:"private_key"`        // PEM encoded, e.g. set through the environment
+	PrivateKeyFile   string   `mapstructure:"private_key_file"`   // Read instead of private_key
+	PrivateKeySecret string   `mapstructure:"private_key_secret"` // Kubernetes secret in namespace holding the key under "ssh-privatekey"
+	Passphrase       string   `mapstructure:"passphrase"`         // Of an encrypted private key
+	KnownHosts       string   `mapstructure:"known_hosts"`        // known_hosts file the host key is checked against
+	HostKey          string   `mapstructure:"host_key"`           // Expected host key in authorized_keys format, instead of known_hosts
+	RemotePath       string   `mapstructure:"remote_path"`        // Defaults to /srv/chef
+	ExtractCommand   string   `mapstructure:"extract_command"`    // Defaults to tar -xf "$ARTIFACT" -C "$RELEASE" --strip-components=1
+	Commands         []string `mapstructure:"commands"`           // Run after the switch, e.g. ["sudo -n systemctl reload nginx"]
+	SystemdUnit      string   `mapstructure:"systemd_unit"`       // Restarted after the switch and on rollback, may reference $PROJECT
+	KeepReleases     int      `mapstructure:"keep_releases"`      // Defaults to 5
+	Timeout          int      `mapstructure:"timeout"`            // Seconds per deploy or rollback, defaults to 300
+}
+
+// ExternalDeployConfig publishes static builds on Cloudflare Pages or
+// Netlify through their APIs. Each project deploys to the Pages project or
+// Netlify site of the same name unless sites maps it elsewhere. The
+// provider's deployment ID, URL and status are recorded on the build.
+type ExternalDeployConfig struct {
+	Provider      string            `mapstructure:"provider"`       // "cloudflare" or "netlify"
+	AccountID     string            `mapstructure:"account_id"`     // Cloudflare account owning the Pages projects
+	Token         string            `mapstructure:"token"`          // API token of projects without one in project_tokens
+	ProjectTokens map[string]string `mapstructure:"project_tokens"` // Project name -> API token
+	Sites         map[string]string `mapstructure:"sites"`          // Project name -> Pages project or Netlify site ID
+	// Environments maps chef environments to "production" or the preview
+	// branch they deploy as. Unmapped environments deploy as a branch named
+	// after them, except production and builds without an environment.
+	Environments map[string]string `mapstructure:"environments"`
+	APIURL       string            `mapstructure:"api_url"` // Defaults to the provider's public API
+	Timeout      int               `mapstructure:"timeout"` // Seconds a deployment may take to go live, defaults to 600
+}
+
+// FileTransferConfig uploads static builds to shared hosting for the sftp
+// and ftps platforms. Each project is synced to <remote_path>/<project>:
+// only files whose SHA-256 changed since the last deploy are uploaded, each
+// under a temporary name that is renamed into place once complete.
+type FileTransferConfig struct {
+	Host           string `mapstructure:"host"` // host or host:port, port defaults to 22 for sftp and 21 for ftps
+	User           string `mapstructure:"user"`
+	Password       string `mapstructure:"password"`         // Required for ftps
+	PrivateKeyFile string `mapstructure:"private_key_file"` // sftp, instead of or with password
+	KnownHosts     string `mapstructure:"known_hosts"`      // sftp, known_hosts file the host key is checked against
+	HostKey        string `mapstructure:"host_key"`         // sftp, expected host key in authorized_keys format
+	RemotePath     string `mapstructure:"remote_path"`      // Defaults to the login directory
+	Concurrency    int    `mapstructure:"concurrency"`      // Parallel uploads, defaults to 4
+	DryRun         bool   `mapstructure:"dry_run"`          // Record the changes a deploy would make without making them
+	Timeout        int    `mapstructure:"timeout"`          // Seconds per deploy or rollback, defaults to 600
 }
 
 type NodeJSConfig struct {
-	DefaultVersion string            `mapstructure:"default_version"`
-	AllowedEngines []string          `mapstructure:"allowed_engines"`
-	MaxBuildTime   int               `mapstructure:"max_build_time"`
-	BuildCache     bool              `mapstructure:"build_cache"`
-	EnvVars        map[string]string `mapstructure:"env_vars"`
-	BuildImage     string            `mapstructure:"build_image"`
-	Registry       string            `mapstructure:"registry"`
+	DefaultVersion string              `mapstructure:"default_version"`
+	AllowedEngines []string            `mapstructure:"allowed_engines"`
+	Versions       []NodeVersionConfig `mapstructure:"versions"` // Supported version matrix, falls back to allowed_engines
+	MaxBuildTime   int                 `mapstructure:"max_build_time"`
+	BuildCache     bool                `mapstructure:"build_cache"`
+	EnvVars        map[string]string   `mapstructure:"env_vars"`
+	BuildImage     string              `mapstructure:"build_image"`
+	Registry       string              `mapstructure:"registry"`
+	Platforms      []string            `mapstructure:"platforms"` // Default target platforms, e.g. ["linux/amd64", "linux/arm64"]
+	// ProjectPlatforms are the target platforms of each project, keyed by
+	// project name, over the default ones
+	ProjectPlatforms map[string][]string `mapstructure:"project_platforms"`
+	// OutputDirs overrides the built-in output directory per framework for
+	// builds that set none, e.g. {"angular": "dist/app/browser"}
+	OutputDirs map[string]string `mapstructure:"output_dirs"`
+	// Environment variables with these prefixes are passed to the frontend
+	// build and inlined into the bundle, defaults to REACT_APP_, VITE_,
+	// NEXT_PUBLIC_, NUXT_PUBLIC_, GATSBY_ and PUBLIC_
+	PublicEnvPrefixes []string `mapstructure:"public_env_prefixes"`
+	// NPMRegistries are the private registries of each project's scoped
+	// packages, keyed by project name. Tokens are only available to npm
+	// install and never reach the image or the artifact.
+	NPMRegistries map[string][]NPMRegistryConfig `mapstructure:"npm_registries"`
+	// BuildMemory limits the containers of builds whose chef.yaml sets no
+	// build memory, e.g. "4Gi". Builds are not limited when empty. Steps
+	// built with buildx only get the Node heap limit derived from it.
+	BuildMemory string `mapstructure:"build_memory"`
+}
+
+// NPMRegistryConfig installs the packages of a scope from a private
+// registry, e.g. @company from https://npm.company.com/
+type NPMRegistryConfig struct {
+	Scope string `mapstructure:"scope"`
+	URL   string `mapstructure:"url"`
+	Token string `mapstructure:"token"`
+}
+
+// DockerConfig bounds the Docker API calls of local builds. Image builds,
+// pulls and copies stream for as long as they take and are only bounded by
+// the build's timeout.
+type DockerConfig struct {
+	CallTimeout int `mapstructure:"call_timeout"` // Seconds per call, defaults to 60
+	// Retries of calls failing with a transient daemon error, defaults to
+	// 3; negative disables retries. Image builds and container creates are
+	// never retried.
+	Retries    int `mapstructure:"retries"`
+	RetryDelay int `mapstructure:"retry_delay"` // Milliseconds before the first retry, doubled for each further one, defaults to 500
+}
+
+type NodeVersionConfig struct {
+	Version    string `mapstructure:"version"`    // Major version, e.g. "20"
+	Deprecated bool   `mapstructure:"deprecated"` // End-of-life, still buildable but warns
+	EOLDate    string `mapstructure:"eol_date"`
 }
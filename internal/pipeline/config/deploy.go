@@ -0,0 +1,71 @@
+package config
+
+// Target returns the deploy settings of an environment: its target's
+// settings over the defaults, or the defaults when it has no target
+func (c *DeployConfig) Target(environment string) *DeployConfig {
+	merged := *c
+	merged.Targets = nil
+	target, ok := c.Targets[environment]
+	if !ok {
+		return &merged
+	}
+
+	if target.Platform != "" {
+		merged.Platform = target.Platform
+	}
+	if target.Namespace != "" {
+		merged.Namespace = target.Namespace
+	}
+	if target.IngressDomain != "" {
+		merged.IngressDomain = target.IngressDomain
+	}
+	if target.Registry != "" {
+		merged.Registry = target.Registry
+	}
+	if target.PullSecret != "" {
+		merged.PullSecret = target.PullSecret
+	}
+	if target.ReplicaCount != 0 {
+		merged.ReplicaCount = target.ReplicaCount
+	}
+	if target.MinReplicas != 0 {
+		merged.MinReplicas = target.MinReplicas
+	}
+	if target.MaxReplicas != 0 {
+		merged.MaxReplicas = target.MaxReplicas
+	}
+	if target.ForceApply {
+		merged.ForceApply = true
+	}
+	if target.DeleteTimeout != 0 {
+		merged.DeleteTimeout = target.DeleteTimeout
+	}
+	if target.RolloutTimeout != 0 {
+		merged.RolloutTimeout = target.RolloutTimeout
+	}
+	if target.StaticPath != "" {
+		merged.StaticPath = target.StaticPath
+	}
+	if target.MaxDeploySize != 0 {
+		merged.MaxDeploySize = target.MaxDeploySize
+	}
+	if target.TerraformManifest {
+		merged.TerraformManifest = true
+	}
+	if target.TerraformManifestDir != "" {
+		merged.TerraformManifestDir = target.TerraformManifestDir
+	}
+	if len(target.Sync.Hosts) > 0 {
+		merged.Sync = target.Sync
+	}
+	if target.SSH.Host != "" {
+		merged.SSH = target.SSH
+	}
+	if target.External.Provider != "" {
+		merged.External = target.External
+	}
+	if target.FileTransfer.Host != "" {
+		merged.FileTransfer = target.FileTransfer
+	}
+	return &merged
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// npmScope is a package scope as npm accepts it, e.g. @company
+var npmScope = regexp.MustCompile(`^@[a-z0-9][a-z0-9._-]*$`)
+
+// Validate reports a registry whose settings npm cannot use
+func (r NPMRegistryConfig) Validate() error {
+	if !npmScope.MatchString(r.Scope) {
+		return fmt.Errorf("scope %q must be a lowercase npm scope like @company", r.Scope)
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("registry of %s must be an http(s) URL", r.Scope)
+	}
+	// The URL becomes a line of the .npmrc mounted as a build secret,
+	// credentials belong in the token
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" || strings.ContainsAny(r.URL, "'\"\\$ ") {
+		return fmt.Errorf("registry of %s must not have credentials, a query or quotes", r.Scope)
+	}
+	if r.Token == "" {
+		return fmt.Errorf("registry of %s has no token", r.Scope)
+	}
+	return nil
+}
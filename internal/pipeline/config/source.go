@@ -0,0 +1,9 @@
+package config
+
+import _ "embed"
+
+// Source is this package's config structs, the example config is
+// documented from their comments
+//
+//go:embed config.go
+var Source string
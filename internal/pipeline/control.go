@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const (
+	defaultMinReplicas = 1
+	defaultMaxReplicas = 10
+)
+
+func (h *Handler) RestartDeployment(ctx context.Context, req *pb.RestartDeploymentRequest) (*pb.RestartDeploymentResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	restarter, ok := h.deployer.(deployer.Restarter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "restart is not supported by the deployment platform")
+	}
+
+	if err := restarter.Restart(ctx, req.ProjectId); err != nil {
+		h.log.Error("failed to restart deployment",
+			zap.String("project", req.ProjectId),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to restart deployment")
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.pipeline.RecordProjectEvent(req.ProjectId, types.EventRestarted, fmt.Sprintf("restarted by %s", username))
+	h.audit(username, "deployment.restart", req.ProjectId, nil)
+
+	return &pb.RestartDeploymentResponse{
+		Success: true,
+		Message: "Deployment restart triggered",
+	}, nil
+}
+
+func (h *Handler) ScaleDeployment(ctx context.Context, req *pb.ScaleDeploymentRequest) (*pb.ScaleDeploymentResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	minReplicas, maxReplicas := h.pipeline.replicaBounds()
+	if req.Replicas < minReplicas || req.Replicas > maxReplicas {
+		return nil, status.Errorf(codes.InvalidArgument, "replicas must be between %d and %d", minReplicas, maxReplicas)
+	}
+
+	process := req.Process
+	if process == "" {
+		process = types.WebProcess
+	}
+	var err error
+	if process == types.WebProcess {
+		scaler, ok := h.deployer.(deployer.Scaler)
+		if !ok {
+			return nil, status.Error(codes.Unimplemented, "scaling is not supported by the deployment platform")
+		}
+		err = scaler.Scale(ctx, req.ProjectId, req.Replicas)
+	} else {
+		manager, ok := h.deployer.(deployer.ProcessManager)
+		if !ok {
+			return nil, status.Error(codes.Unimplemented, "processes are not supported by the deployment platform")
+		}
+		err = manager.ScaleProcess(ctx, req.ProjectId, process, req.Replicas)
+	}
+	if errors.Is(err, deployer.ErrProcessNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		h.log.Error("failed to scale deployment",
+			zap.String("project", req.ProjectId),
+			zap.String("process", process),
+			zap.Int32("replicas", req.Replicas),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to scale deployment")
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.pipeline.RecordProjectEvent(req.ProjectId, types.EventScaled,
+		fmt.Sprintf("%s scaled to %d replicas by %s", process, req.Replicas, username))
+	h.audit(username, "deployment.scale", req.ProjectId, map[string]interface{}{
+		"process":  process,
+		"replicas": req.Replicas,
+	})
+
+	return &pb.ScaleDeploymentResponse{
+		Success:  true,
+		Message:  "Deployment scaled successfully",
+		Replicas: req.Replicas,
+	}, nil
+}
+
+func (h *Handler) ListProcesses(ctx context.Context, req *pb.ListProcessesRequest) (*pb.ListProcessesResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	manager, ok := h.deployer.(deployer.ProcessManager)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "processes are not supported by the deployment platform")
+	}
+
+	processes, err := manager.Processes(ctx, req.ProjectId)
+	if errors.Is(err, deployer.ErrProcessNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		h.log.Error("failed to list processes",
+			zap.String("project", req.ProjectId),
+			zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list processes")
+	}
+
+	resp := &pb.ListProcessesResponse{}
+	for _, process := range processes {
+		resp.Processes = append(resp.Processes, &pb.ProcessStatus{
+			Name:          process.Name,
+			Command:       process.Command,
+			Image:         process.Image,
+			Replicas:      process.Replicas,
+			ReadyReplicas: process.ReadyReplicas,
+		})
+	}
+	return resp, nil
+}
+
+// replicaBounds returns the replicas ScaleDeployment accepts
+func (p *Pipeline) replicaBounds() (int32, int32) {
+	cfg := p.config.Deploy
+
+	minReplicas, maxReplicas := int32(defaultMinReplicas), int32(defaultMaxReplicas)
+	if cfg.MinReplicas > 0 {
+		minReplicas = int32(cfg.MinReplicas)
+	}
+	if cfg.MaxReplicas > 0 {
+		maxReplicas = int32(cfg.MaxReplicas)
+	}
+	return minReplicas, maxReplicas
+}
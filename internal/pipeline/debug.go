@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const (
+	defaultDebugTTL    = time.Hour
+	debugSweepInterval = 5 * time.Minute
+)
+
+// DebugShell runs commands in images kept from failed builds
+type DebugShell interface {
+	Run(ctx context.Context, image string, opts builder.ShellOptions) error
+	Remove(ctx context.Context, image string) error
+}
+
+// keepDebugImage keeps the last stage of a failed build that built so its
+// owner can open a shell in it. Cancelled builds are not kept.
+func (p *Pipeline) keepDebugImage(ctx context.Context, build *types.Build, b builder.Builder) {
+	if p.debugShell == nil || ctx.Err() != nil {
+		return
+	}
+	debugger, ok := b.(builder.Debugger)
+	if !ok {
+		return
+	}
+
+	image, stage, err := debugger.DebugImage(ctx, build)
+	if err != nil {
+		p.logger.Warn("failed to keep failed build for debugging",
+			zap.String("build_id", build.ID),
+			zap.Error(err))
+		return
+	}
+
+	ttl := defaultDebugTTL
+	if p.config.Debug.TTL > 0 {
+		ttl = time.Duration(p.config.Debug.TTL) * time.Second
+	}
+	p.updateBuild(build, func(build *types.Build) {
+		build.Debug = &types.DebugImage{Image: image, Stage: stage, ExpiresAt: time.Now().Add(ttl)}
+		build.AddEvent(types.EventDebugImageKept, "", stage)
+	})
+}
+
+// ExpireDebugImages removes the debug images of builds past their TTL.
+// Images of an earlier process are left to image GC.
+func (p *Pipeline) ExpireDebugImages(ctx context.Context) {
+	now := time.Now()
+
+	p.mu.RLock()
+	expired := make(map[*types.Build]string)
+	for _, build := range p.builds {
+		if build.Debug != nil && !build.Debug.Available(now) {
+			expired[build] = build.Debug.Image
+		}
+	}
+	p.mu.RUnlock()
+
+	for build, image := range expired {
+		if err := p.debugShell.Remove(ctx, image); err != nil {
+			p.logger.Error("failed to remove expired debug image",
+				zap.String("build_id", build.ID),
+				zap.String("image", image),
+				zap.Error(err))
+			continue
+		}
+		p.updateBuild(build, func(b *types.Build) {
+			b.Debug = nil
+		})
+		p.persist(build)
+	}
+}
+
+func (p *Pipeline) sweepDebugImages() {
+	defer p.running.Done()
+
+	ticker := time.NewTicker(debugSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.rootCtx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(p.rootCtx, debugSweepInterval)
+			p.ExpireDebugImages(ctx)
+			cancel()
+		}
+	}
+}
+
+// DebugBuild opens a shell in what a failed build left behind. Only the
+// project owner may debug, admins of other projects may not, and sessions
+// are audited like ExecApp, with its session and idle timeouts.
+func (h *Handler) DebugBuild(stream pb.Pipeline_DebugBuildServer) error {
+	ctx := stream.Context()
+
+	shell := h.pipeline.debugShell
+	if shell == nil {
+		return status.Error(codes.FailedPrecondition, "debugging failed builds is disabled")
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "missing session request")
+	}
+	if first.BuildId == "" {
+		return status.Error(codes.InvalidArgument, "build id is required")
+	}
+	build, err := h.pipeline.LookupBuild(ctx, first.BuildId)
+	if err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to get build", zap.String("build_id", first.BuildId), zap.Error(err))
+		return status.Error(codes.Internal, "failed to get build")
+	}
+	if err := h.authorizeOwner(ctx, build.ProjectID); err != nil {
+		return err
+	}
+	if !build.Debug.Available(time.Now()) {
+		return status.Error(codes.FailedPrecondition, "build has nothing to debug or its debug image expired")
+	}
+
+	command := first.Command
+	if len(command) == 0 {
+		command = []string{"sh"}
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "debug.start", build.ProjectID, map[string]interface{}{
+		"build_id": build.ID,
+		"stage":    build.Debug.Stage,
+		"command":  command,
+		"tty":      first.Tty,
+	})
+
+	sessionTimeout, idleTimeout := execTimeouts(h.pipeline.config.Exec)
+	sessionCtx, cancel := context.WithTimeout(ctx, sessionTimeout)
+	defer cancel()
+
+	session := &execSession{
+		stream: stream,
+		idle:   time.AfterFunc(idleTimeout, cancel),
+	}
+	defer session.idle.Stop()
+
+	stdin, stdinWriter := io.Pipe()
+	go session.pumpStdin(first.Stdin, stdinWriter, idleTimeout, func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return req.Stdin, nil
+	})
+
+	start := time.Now()
+	runErr := shell.Run(sessionCtx, build.Debug.Image, builder.ShellOptions{
+		Command: command,
+		Stdin:   stdin,
+		Stdout:  session.writer(false),
+		Stderr:  session.writer(true),
+		TTY:     first.Tty,
+	})
+	stdin.Close()
+
+	result := &pb.ExecResponse{Exited: true}
+	var exitErr *builder.ExitError
+	switch {
+	case runErr == nil:
+	case errors.As(runErr, &exitErr):
+		result.ExitCode = int32(exitErr.Code)
+	case sessionCtx.Err() != nil && ctx.Err() == nil:
+		result.ExitCode = -1
+		result.Error = "session timed out"
+	default:
+		result.ExitCode = -1
+		result.Error = runErr.Error()
+	}
+
+	h.audit(username, "debug.end", build.ProjectID, map[string]interface{}{
+		"build_id":    build.ID,
+		"command":     command,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"exit_code":   result.ExitCode,
+		"error":       result.Error,
+		"stdin_bytes": session.inputBytes(),
+	})
+
+	if ctx.Err() != nil {
+		// Client went away, nothing left to report
+		return nil
+	}
+	return session.send(result)
+}
@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// debuggableBuilder fails every build and keeps its deps stage
+type debuggableBuilder struct {
+	mockBuilder
+}
+
+func (b *debuggableBuilder) DebugImage(_ context.Context, build *types.Build) (string, string, error) {
+	return builder.DebugImageTag(build), "deps", nil
+}
+
+// fakeShell echoes stdin and exits with code 3 for "false"
+type fakeShell struct {
+	removed []string
+}
+
+func (s *fakeShell) Run(_ context.Context, _ string, opts builder.ShellOptions) error {
+	if opts.Command[0] == "false" {
+		return &builder.ExitError{Code: 3}
+	}
+	_, err := io.Copy(opts.Stdout, opts.Stdin)
+	return err
+}
+
+func (s *fakeShell) Remove(_ context.Context, image string) error {
+	s.removed = append(s.removed, image)
+	return nil
+}
+
+type fakeDebugStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests chan *pb.DebugBuildRequest
+	sent     []*pb.ExecResponse
+}
+
+func (s *fakeDebugStream) Context() context.Context { return s.ctx }
+
+func (s *fakeDebugStream) Recv() (*pb.DebugBuildRequest, error) {
+	req, ok := <-s.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (s *fakeDebugStream) Send(resp *pb.ExecResponse) error {
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func runDebug(h *Handler, username string, requests ...*pb.DebugBuildRequest) (*fakeDebugStream, error) {
+	stream := &fakeDebugStream{
+		ctx:      context.WithValue(context.Background(), auth.UserContextKey, username),
+		requests: make(chan *pb.DebugBuildRequest, len(requests)),
+	}
+	for _, req := range requests {
+		stream.requests <- req
+	}
+	close(stream.requests)
+	return stream, h.DebugBuild(stream)
+}
+
+func TestPipeline_KeepDebugImage(t *testing.T) {
+	p := &Pipeline{
+		config:     &config.PipelineConfig{Debug: config.DebugConfig{Enabled: true, TTL: 60}},
+		logger:     zap.NewNop(),
+		builds:     make(map[string]*types.Build),
+		debugShell: &fakeShell{},
+	}
+	build := &types.Build{ID: "build-1", ProjectID: "shop"}
+	p.builds[build.ID] = build
+
+	p.keepDebugImage(context.Background(), build, &mockBuilder{})
+	assert.Nil(t, build.Debug, "builders without debug support keep nothing")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.keepDebugImage(cancelled, build, &debuggableBuilder{})
+	assert.Nil(t, build.Debug, "cancelled builds are not kept")
+
+	p.keepDebugImage(context.Background(), build, &debuggableBuilder{})
+	require.NotNil(t, build.Debug)
+	assert.Equal(t, "chef-debug-shop:build-1", build.Debug.Image)
+	assert.Equal(t, "deps", build.Debug.Stage)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), build.Debug.ExpiresAt, 5*time.Second)
+
+	p.ExpireDebugImages(context.Background())
+	assert.NotNil(t, build.Debug, "unexpired images are kept")
+
+	build.Debug.ExpiresAt = time.Now().Add(-time.Second)
+	p.ExpireDebugImages(context.Background())
+	assert.Nil(t, build.Debug)
+	assert.Equal(t, []string{"chef-debug-shop:build-1"}, p.debugShell.(*fakeShell).removed)
+}
+
+// adminAuthorizer lets admin into every project, like project.Service,
+// while alice owns them
+type adminAuthorizer struct {
+	ownerAuthorizer
+}
+
+func (adminAuthorizer) CanAccessProject(username, projectID string) (bool, error) {
+	return username == "alice" || username == "admin", nil
+}
+
+func TestHandler_DebugBuild(t *testing.T) {
+	newHandler := func(debug *types.DebugImage) (*Handler, *recordingAuditor) {
+		auditor := &recordingAuditor{}
+		p := &Pipeline{
+			config:     &config.PipelineConfig{},
+			builds:     map[string]*types.Build{"build-1": {ID: "build-1", ProjectID: "shop", Debug: debug}},
+			debugShell: &fakeShell{},
+		}
+		return NewHandler(p, nil, nil, nil, &mockDeployer{}, ownerAuthorizer{}, auditor, zap.NewNop()), auditor
+	}
+	kept := &types.DebugImage{Image: "chef-debug-shop:build-1", Stage: "deps", ExpiresAt: time.Now().Add(time.Hour)}
+
+	t.Run("session echoes input and is audited", func(t *testing.T) {
+		h, auditor := newHandler(kept)
+		stream, err := runDebug(h, "alice",
+			&pb.DebugBuildRequest{BuildId: "build-1", Stdin: []byte("ls node_modules\n")})
+		require.NoError(t, err)
+
+		var stdout string
+		for _, resp := range stream.sent {
+			stdout += string(resp.Stdout)
+		}
+		assert.Equal(t, "ls node_modules\n", stdout)
+		last := stream.sent[len(stream.sent)-1]
+		assert.True(t, last.Exited)
+		assert.Zero(t, last.ExitCode)
+
+		assert.Equal(t, []string{"debug.start", "debug.end"}, auditor.actions)
+		assert.Equal(t, []string{"sh"}, auditor.details[0]["command"])
+		assert.Equal(t, len("ls node_modules\n"), auditor.details[1]["stdin_bytes"])
+	})
+
+	t.Run("exit code is reported", func(t *testing.T) {
+		h, _ := newHandler(kept)
+		stream, err := runDebug(h, "alice", &pb.DebugBuildRequest{BuildId: "build-1", Command: []string{"false"}})
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), stream.sent[len(stream.sent)-1].ExitCode)
+	})
+
+	t.Run("only the owner may debug", func(t *testing.T) {
+		h, auditor := newHandler(kept)
+		_, err := runDebug(h, "mallory", &pb.DebugBuildRequest{BuildId: "build-1"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Empty(t, auditor.actions)
+	})
+
+	t.Run("admins may not debug builds of others", func(t *testing.T) {
+		h, auditor := newHandler(kept)
+		h.authorizer = adminAuthorizer{}
+		_, err := runDebug(h, "admin", &pb.DebugBuildRequest{BuildId: "build-1"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Empty(t, auditor.actions)
+	})
+
+	t.Run("expired image", func(t *testing.T) {
+		h, _ := newHandler(&types.DebugImage{Image: "chef-debug-shop:build-1", ExpiresAt: time.Now().Add(-time.Minute)})
+		_, err := runDebug(h, "alice", &pb.DebugBuildRequest{BuildId: "build-1"})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h, _ := newHandler(kept)
+		h.pipeline.debugShell = nil
+		_, err := runDebug(h, "alice", &pb.DebugBuildRequest{BuildId: "build-1"})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
@@ -0,0 +1,418 @@
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	laneLease          = time.Minute
+	laneMarkerTTL      = 24 * time.Hour
+	laneReleaseTimeout = 10 * time.Second
+)
+
+// lanePollInterval is how often a build waiting for a branch held on
+// another instance retries it, and how often the holder renews it
+var lanePollInterval = 2 * time.Second
+
+// LaneStore shares branch lanes between instances, e.g. a cache.Store
+// backed by Redis. The lane is a key holding the ID of the build running
+// the branch; Add takes it only when it is free.
+type LaneStore interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Add(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// laneKey identifies the builds of one project branch
+type laneKey struct {
+	project string
+	ref     string
+}
+
+func (k laneKey) storeKey() string {
+	return "lane:" + k.project + ":" + k.ref
+}
+
+// latestKey holds the start time and ID of the latest build of the branch
+// that supersedes the earlier ones
+func (k laneKey) latestKey() string {
+	return "lane-latest:" + k.project + ":" + k.ref
+}
+
+// lane holds the build of a branch that is running and the pushes waiting
+// behind it. With a lane store the active build also claims the branch
+// there, and waits while another instance holds it.
+type lane struct {
+	active  *types.Build
+	pending []*types.Build
+	claim   *laneClaim
+}
+
+// laneClaim waits for the lane store and then keeps the active build's
+// claim renewed until stop
+type laneClaim struct {
+	stop     context.CancelFunc
+	done     chan struct{}
+	launched bool // Guarded by mu
+}
+
+// laneFor returns the lane of builds triggered by a push; manual builds
+// have none and run right away
+func laneFor(build *types.Build) (laneKey, bool) {
+	if build.Source == nil || build.Source.Ref == "" {
+		return laneKey{}, false
+	}
+	return laneKey{project: build.ProjectID, ref: build.Source.Ref}, true
+}
+
+func (p *Pipeline) dedupPolicy(build *types.Build) types.DedupPolicy {
+	if build.Dedup != "" {
+		return build.Dedup
+	}
+	if p.config.BuildDedup != "" {
+		return types.DedupPolicy(p.config.BuildDedup)
+	}
+	return types.DedupQueue
+}
+
+// admit places the build in its branch lane. It reports whether the build
+// may start now and returns the builds it superseded. Under the supersede
+// policy queued builds are dropped and a running build is cancelled; a
+// build that is already deploying is left to finish so the new build never
+// races its deployment.
+func (p *Pipeline) admit(build *types.Build) (bool, []*types.Build) {
+	key, ok := laneFor(build)
+	if !ok {
+		return true, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.lanes == nil {
+		p.lanes = make(map[laneKey]*lane)
+	}
+	l := p.lanes[key]
+	if l == nil {
+		l = &lane{}
+		p.lanes[key] = l
+	}
+
+	var superseded []*types.Build
+	if p.dedupPolicy(build) == types.DedupSupersede {
+		now := time.Now()
+		for _, queued := range l.pending {
+			queued.Status = types.BuildStatusSuperseded
+			queued.CompleteTime = &now
+			superseded = append(superseded, queued)
+		}
+		l.pending = nil
+
+		if active := l.active; active != nil && active.Status == types.BuildStatusBuilding {
+			if active.CancelFunc != nil {
+				active.CancelFunc()
+			}
+			active.Status = types.BuildStatusSuperseded
+			active.CompleteTime = &now
+			superseded = append(superseded, active)
+		}
+	}
+
+	if l.active == nil {
+		l.active = build
+		return true, superseded
+	}
+	l.pending = append(l.pending, build)
+	return false, superseded
+}
+
+// release frees the build's lane and starts the next queued build. Queued
+// builds are cancelled instead once the pipeline is shutting down.
+func (p *Pipeline) release(build *types.Build) {
+	key, ok := laneFor(build)
+	if !ok {
+		return
+	}
+
+	p.mu.Lock()
+	l := p.lanes[key]
+	if l == nil || l.active != build {
+		p.mu.Unlock()
+		return
+	}
+
+	claim := l.claim
+	l.claim = nil
+	var next *types.Build
+	var cancelled []*types.Build
+	if p.baseContext().Err() != nil {
+		cancelled = l.pending
+		l.pending = nil
+	} else if len(l.pending) > 0 {
+		next = l.pending[0]
+		l.pending = l.pending[1:]
+	}
+	l.active = next
+	if next == nil {
+		delete(p.lanes, key)
+	}
+
+	now := time.Now()
+	for _, queued := range cancelled {
+		queued.Status = types.BuildStatusCancelled
+		queued.CompleteTime = &now
+	}
+	p.mu.Unlock()
+
+	if claim != nil {
+		claim.stop()
+		<-claim.done
+		p.unclaimLane(key, build)
+	}
+	for _, queued := range cancelled {
+		p.persist(queued)
+		p.notify(types.LifecycleBuildCancelled, queued, "pipeline shutting down")
+	}
+	if next != nil {
+		p.enter(next)
+	}
+}
+
+// dropQueued removes the queued builds of a project, in branch lanes or
+// waiting for a build slot, and returns them. Builds waiting for a branch
+// held on another instance count as queued. Callers hold mu.
+func (p *Pipeline) dropQueued(projectID string) []*types.Build {
+	var dropped []*types.Build
+	for key, l := range p.lanes {
+		if key.project != projectID {
+			continue
+		}
+		dropped = append(dropped, l.pending...)
+		l.pending = nil
+		if l.claim != nil && !l.claim.launched {
+			l.claim.stop()
+			dropped = append(dropped, l.active)
+			delete(p.lanes, key)
+		}
+	}
+
+	waiting := p.waiting[:0]
+	for _, build := range p.waiting {
+		if build.ProjectID == projectID {
+			dropped = append(dropped, build)
+		} else {
+			waiting = append(waiting, build)
+		}
+	}
+	p.waiting = waiting
+	return dropped
+}
+
+// enter runs the build that became active in its lane. With a lane store
+// it first waits until no other instance runs the branch.
+func (p *Pipeline) enter(build *types.Build) {
+	key, ok := laneFor(build)
+	if !ok || p.laneStore == nil {
+		p.launch(build)
+		return
+	}
+
+	ctx, stop := context.WithCancel(p.baseContext())
+	claim := &laneClaim{stop: stop, done: make(chan struct{})}
+	p.mu.Lock()
+	l := p.lanes[key]
+	if l == nil || l.active != build {
+		p.mu.Unlock()
+		stop()
+		return
+	}
+	l.claim = claim
+	p.mu.Unlock()
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		launched := p.holdLane(ctx, key, build, claim)
+		close(claim.done)
+		if !launched {
+			p.release(build)
+		}
+	}()
+}
+
+// holdLane claims the branch in the lane store, launches the build and
+// renews the claim until ctx is done. It reports whether the build was
+// launched. A build superseded from another instance is cancelled, or
+// gives up its wait. Without a working store the build runs unclaimed
+// rather than not at all.
+func (p *Pipeline) holdLane(ctx context.Context, key laneKey, build *types.Build, claim *laneClaim) bool {
+	if p.dedupPolicy(build) == types.DedupSupersede {
+		marker := strconv.FormatInt(build.StartTime.UnixNano(), 10) + " " + build.ID
+		if err := p.laneStore.Set(ctx, key.latestKey(), marker, laneMarkerTTL); err != nil {
+			p.logger.Warn("failed to mark the latest build of the branch",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+		}
+	}
+
+	var waitingFor string
+	for {
+		if by, ok := p.laneSuperseded(ctx, key, build); ok {
+			p.supersedeFromLane(build, by, types.BuildStatusPending)
+			return false
+		}
+		acquired, err := p.laneStore.Add(ctx, key.storeKey(), build.ID, laneLease)
+		if err != nil && ctx.Err() == nil {
+			p.logger.Warn("failed to claim branch lane, building without it",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+			return p.launchClaimed(key, build, claim)
+		}
+		if acquired {
+			break
+		}
+		if holder, found, err := p.laneStore.Get(ctx, key.storeKey()); err == nil && found && holder != waitingFor {
+			waitingFor = holder
+			p.updateBuild(build, func(b *types.Build) {
+				b.AddEvent(types.EventLaneWaiting, "", "build "+holder+" is running "+key.ref)
+			})
+			p.persist(build)
+		}
+		select {
+		case <-ctx.Done():
+			p.cancelLaneWait(build)
+			return false
+		case <-time.After(lanePollInterval):
+		}
+	}
+
+	if !p.launchClaimed(key, build, claim) {
+		p.unclaimLane(key, build)
+		return false
+	}
+
+	ticker := time.NewTicker(lanePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-ticker.C:
+		}
+
+		if by, ok := p.laneSuperseded(ctx, key, build); ok {
+			p.supersedeFromLane(build, by, types.BuildStatusBuilding)
+		}
+		holder, found, err := p.laneStore.Get(ctx, key.storeKey())
+		if err == nil && (!found || holder != build.ID) {
+			p.logger.Warn("branch lane lost, another build of the branch may start",
+				zap.String("build_id", build.ID),
+				zap.String("project_id", key.project),
+				zap.String("ref", key.ref))
+			return true
+		}
+		if err == nil {
+			err = p.laneStore.Set(ctx, key.storeKey(), build.ID, laneLease)
+		}
+		if err != nil && ctx.Err() == nil {
+			p.logger.Warn("failed to renew branch lane",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// launchClaimed launches the build unless it left its lane while waiting,
+// e.g. because its project was deleted
+func (p *Pipeline) launchClaimed(key laneKey, build *types.Build, claim *laneClaim) bool {
+	p.mu.Lock()
+	if l := p.lanes[key]; l == nil || l.active != build {
+		p.mu.Unlock()
+		return false
+	}
+	claim.launched = true
+	p.mu.Unlock()
+	p.launch(build)
+	return true
+}
+
+// laneSuperseded returns the build that superseded this one, a later
+// build of the branch under the supersede policy, possibly started on
+// another instance
+func (p *Pipeline) laneSuperseded(ctx context.Context, key laneKey, build *types.Build) (string, bool) {
+	marker, found, err := p.laneStore.Get(ctx, key.latestKey())
+	if err != nil || !found {
+		return "", false
+	}
+	started, id, ok := strings.Cut(marker, " ")
+	if !ok || id == build.ID {
+		return "", false
+	}
+	nanos, err := strconv.ParseInt(started, 10, 64)
+	if err != nil || nanos <= build.StartTime.UnixNano() {
+		return "", false
+	}
+	return id, true
+}
+
+// supersedeFromLane marks the build superseded when it is still in the
+// given status, cancelling it if it is running
+func (p *Pipeline) supersedeFromLane(build *types.Build, by string, status types.BuildStatus) {
+	p.mu.Lock()
+	if build.Status != status {
+		p.mu.Unlock()
+		return
+	}
+	if build.CancelFunc != nil {
+		build.CancelFunc()
+	}
+	now := time.Now()
+	build.Status = types.BuildStatusSuperseded
+	build.CompleteTime = &now
+	p.mu.Unlock()
+
+	p.persist(build)
+	p.notify(types.LifecycleBuildSuperseded, build, "superseded by build "+by)
+}
+
+// cancelLaneWait cancels a build that was waiting for its branch when the
+// pipeline shut down. A build dropped from its lane is left to the caller
+// that dropped it.
+func (p *Pipeline) cancelLaneWait(build *types.Build) {
+	if p.baseContext().Err() == nil {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	build.Status = types.BuildStatusCancelled
+	build.CompleteTime = &now
+	p.mu.Unlock()
+
+	p.persist(build)
+	p.notify(types.LifecycleBuildCancelled, build, "pipeline shutting down")
+}
+
+// unclaimLane frees the branch in the lane store unless another build
+// took it since
+func (p *Pipeline) unclaimLane(key laneKey, build *types.Build) {
+	ctx, cancel := context.WithTimeout(context.Background(), laneReleaseTimeout)
+	defer cancel()
+	holder, found, err := p.laneStore.Get(ctx, key.storeKey())
+	if err == nil && found && holder == build.ID {
+		err = p.laneStore.Delete(ctx, key.storeKey())
+	}
+	if err != nil {
+		p.logger.Warn("failed to release branch lane, it expires after its lease",
+			zap.String("build_id", build.ID),
+			zap.String("lane", key.storeKey()),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/cache"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func pushBuild(id, ref string) *types.Build {
+	build := createTestBuild()
+	build.ID = id
+	build.Source = &types.BuildSource{Provider: "github", Repository: "acme/shop", Ref: ref}
+	return build
+}
+
+func buildStatus(t *testing.T, p *Pipeline, id string) types.BuildStatus {
+	t.Helper()
+	build, err := p.GetBuild(id)
+	require.NoError(t, err)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return build.Status
+}
+
+func TestPipeline_SupersedesEarlierPushes(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	pipeline.config.BuildDedup = string(types.DedupSupersede)
+	notifier := &recordingNotifier{}
+	pipeline.notifier = notifier
+	builder.delay = 300 * time.Millisecond
+
+	require.NoError(t, pipeline.StartBuild(context.Background(), pushBuild("push-1", "main")))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, pipeline.StartBuild(context.Background(), pushBuild("push-2", "main")))
+	require.NoError(t, pipeline.StartBuild(context.Background(), pushBuild("push-3", "main")))
+
+	// Other branches are not affected
+	require.NoError(t, pipeline.StartBuild(context.Background(), pushBuild("feature-1", "feature")))
+
+	assert.Equal(t, types.BuildStatusSuperseded, buildStatus(t, pipeline, "push-1"))
+	assert.Equal(t, types.BuildStatusSuperseded, buildStatus(t, pipeline, "push-2"))
+	assert.Equal(t, types.BuildStatusPending, buildStatus(t, pipeline, "push-3"))
+
+	require.Eventually(t, func() bool {
+		return buildStatus(t, pipeline, "push-3") == types.BuildStatusSuccess &&
+			buildStatus(t, pipeline, "feature-1") == types.BuildStatusSuccess
+	}, 5*time.Second, 20*time.Millisecond)
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	// The cancelled build keeps its status instead of turning into a failure
+	assert.Equal(t, types.BuildStatusSuperseded, buildStatus(t, pipeline, "push-1"))
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	assert.NotContains(t, notifier.events, types.LifecycleBuildFailed)
+	superseded := 0
+	for _, event := range notifier.events {
+		if event == types.LifecycleBuildSuperseded {
+			superseded++
+		}
+	}
+	assert.Equal(t, 2, superseded)
+}
+
+func TestPipeline_QueuesPushesInOrder(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	builder.delay = 100 * time.Millisecond
+
+	first := pushBuild("push-1", "main")
+	second := pushBuild("push-2", "main")
+	// The project policy wins over the pipeline default
+	pipeline.config.BuildDedup = string(types.DedupSupersede)
+	first.Dedup = types.DedupQueue
+	second.Dedup = types.DedupQueue
+
+	require.NoError(t, pipeline.StartBuild(context.Background(), first))
+	require.NoError(t, pipeline.StartBuild(context.Background(), second))
+	assert.Equal(t, types.BuildStatusPending, buildStatus(t, pipeline, "push-2"))
+
+	require.Eventually(t, func() bool {
+		return buildStatus(t, pipeline, "push-2") == types.BuildStatusSuccess
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, types.BuildStatusSuccess, buildStatus(t, pipeline, "push-1"))
+
+	pipeline.mu.RLock()
+	defer pipeline.mu.RUnlock()
+	assert.Empty(t, pipeline.lanes)
+}
+
+func TestPipeline_ManualBuildsSkipLanes(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	pipeline.config.BuildDedup = string(types.DedupSupersede)
+	builder.delay = 200 * time.Millisecond
+
+	first := createTestBuild()
+	first.ID = "manual-1"
+	second := createTestBuild()
+	second.ID = "manual-2"
+
+	require.NoError(t, pipeline.StartBuild(context.Background(), first))
+	require.NoError(t, pipeline.StartBuild(context.Background(), second))
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, types.BuildStatusBuilding, buildStatus(t, pipeline, "manual-1"))
+	assert.Equal(t, types.BuildStatusBuilding, buildStatus(t, pipeline, "manual-2"))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+}
+
+func TestPipeline_ShutdownCancelsQueuedPushes(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	builder.delay = 200 * time.Millisecond
+
+	require.NoError(t, pipeline.StartBuild(context.Background(), pushBuild("push-1", "main")))
+	require.NoError(t, pipeline.StartBuild(context.Background(), pushBuild("push-2", "main")))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	assert.Equal(t, types.BuildStatusCancelled, buildStatus(t, pipeline, "push-2"))
+}
+
+func TestPipeline_SharesLanesAcrossInstances(t *testing.T) {
+	defer func(interval time.Duration) { lanePollInterval = interval }(lanePollInterval)
+	lanePollInterval = 10 * time.Millisecond
+
+	lanes := cache.NewMemory()
+	first, firstBuilder, _, _ := setupTestPipeline(t)
+	second, secondBuilder, _, _ := setupTestPipeline(t)
+	first.laneStore = lanes
+	second.laneStore = lanes
+	firstBuilder.delay = 300 * time.Millisecond
+	secondBuilder.delay = 50 * time.Millisecond
+
+	require.NoError(t, first.StartBuild(context.Background(), pushBuild("push-1", "main")))
+	time.Sleep(50 * time.Millisecond)
+	waiting := pushBuild("push-2", "main")
+	require.NoError(t, second.StartBuild(context.Background(), waiting))
+	time.Sleep(100 * time.Millisecond)
+
+	// The second instance waits for the branch instead of building it too
+	assert.Equal(t, types.BuildStatusBuilding, buildStatus(t, first, "push-1"))
+	assert.Equal(t, types.BuildStatusPending, buildStatus(t, second, "push-2"))
+	second.mu.RLock()
+	require.NotEmpty(t, waiting.Events)
+	assert.Equal(t, types.EventLaneWaiting, waiting.Events[0].Type)
+	assert.Equal(t, "build push-1 is running main", waiting.Events[0].Message)
+	second.mu.RUnlock()
+
+	require.Eventually(t, func() bool {
+		return buildStatus(t, second, "push-2") == types.BuildStatusSuccess
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, types.BuildStatusSuccess, buildStatus(t, first, "push-1"))
+
+	require.NoError(t, first.Shutdown(context.Background()))
+	require.NoError(t, second.Shutdown(context.Background()))
+	_, held, err := lanes.Get(context.Background(), laneKey{project: waiting.ProjectID, ref: "main"}.storeKey())
+	require.NoError(t, err)
+	assert.False(t, held)
+}
+
+func TestPipeline_SupersedesAcrossInstances(t *testing.T) {
+	defer func(interval time.Duration) { lanePollInterval = interval }(lanePollInterval)
+	lanePollInterval = 10 * time.Millisecond
+
+	lanes := cache.NewMemory()
+	first, firstBuilder, _, _ := setupTestPipeline(t)
+	second, secondBuilder, _, _ := setupTestPipeline(t)
+	first.laneStore = lanes
+	second.laneStore = lanes
+	second.config.BuildDedup = string(types.DedupSupersede)
+	firstBuilder.delay = time.Second
+	secondBuilder.delay = 50 * time.Millisecond
+
+	require.NoError(t, first.StartBuild(context.Background(), pushBuild("push-1", "main")))
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, second.StartBuild(context.Background(), pushBuild("push-2", "main")))
+
+	require.Eventually(t, func() bool {
+		return buildStatus(t, second, "push-2") == types.BuildStatusSuccess
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, types.BuildStatusSuperseded, buildStatus(t, first, "push-1"))
+
+	require.NoError(t, first.Shutdown(context.Background()))
+	require.NoError(t, second.Shutdown(context.Background()))
+}
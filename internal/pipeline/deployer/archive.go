@@ -0,0 +1,222 @@
+package deployer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// createTarGz archives the contents of sourceDir into a gzip compressed tarball.
+// Entry names always use forward slashes so archives are portable across hosts.
+// Failures to flush the archive are returned so a truncated one is not kept
+// as a backup.
+func createTarGz(sourceDir, archivePath string) (err error) {
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+
+	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// extractArchive unpacks a tarball into targetDir. Both gzip compressed and
+// plain tar streams are accepted, since docker's CopyFromContainer returns an
+// uncompressed tar.
+func extractArchive(archivePath, targetDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var reader io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		reader = gr
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := safeJoin(targetDir, header.Name)
+		if err != nil {
+			return err
+		}
+		// Entries are never written through a link of an earlier entry
+		if err := checkNoSymlinks(targetDir, filepath.Dir(target)); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("archive entry is written through a symlink: %s", header.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if !symlinksSupported {
+				continue
+			}
+			if err := checkLinkTarget(targetDir, target, header.Linkname); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+	}
+}
+
+// safeJoin resolves name inside root and rejects entries escaping it.
+func safeJoin(root, name string) (string, error) {
+	target := filepath.Join(root, filepath.FromSlash(name))
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry escapes target directory: %s", name)
+	}
+	return target, nil
+}
+
+// checkLinkTarget rejects symlinks pointing outside root: absolute ones
+// and relative ones climbing out of it
+func checkLinkTarget(root, target, linkname string) error {
+	if filepath.IsAbs(linkname) || strings.HasPrefix(linkname, "/") {
+		return fmt.Errorf("archive link points outside target directory: %s -> %s", target, linkname)
+	}
+	resolved := filepath.Join(filepath.Dir(target), filepath.FromSlash(linkname))
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("archive link points outside target directory: %s -> %s", target, linkname)
+	}
+	return nil
+}
+
+// checkNoSymlinks rejects dir when it or any directory between root and it
+// is an existing symlink
+func checkNoSymlinks(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	path := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry is written through a symlink: %s", path)
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,164 @@
+package deployer
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestArchive_RoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDir := filepath.Join(tmpDir, "source")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "static", "js"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "index.html"), []byte("index"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "static", "js", "main.js"), []byte("main"), 0644))
+
+	archivePath := filepath.Join(tmpDir, "site.tar.gz")
+	require.NoError(t, createTarGz(sourceDir, archivePath))
+
+	targetDir := filepath.Join(tmpDir, "target")
+	require.NoError(t, extractArchive(archivePath, targetDir))
+
+	data, err := os.ReadFile(filepath.Join(targetDir, "static", "js", "main.js"))
+	require.NoError(t, err)
+	assert.Equal(t, "main", string(data))
+}
+
+func TestArchive_Extract(t *testing.T) {
+	tests := []struct {
+		name        string
+		entries     map[string]string
+		expectError bool
+	}{
+		{
+			name:    "plain tar",
+			entries: map[string]string{"html/index.html": "index"},
+		},
+		{
+			name:        "path traversal",
+			entries:     map[string]string{"../escape.txt": "nope"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			archivePath := filepath.Join(tmpDir, "artifact.tar")
+			writePlainTar(t, archivePath, tt.entries)
+
+			targetDir := filepath.Join(tmpDir, "target")
+			err := extractArchive(archivePath, targetDir)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			for name, content := range tt.entries {
+				data, err := os.ReadFile(filepath.Join(targetDir, filepath.FromSlash(name)))
+				require.NoError(t, err)
+				assert.Equal(t, content, string(data))
+			}
+		})
+	}
+}
+
+func TestArchive_ExtractSymlinks(t *testing.T) {
+	if !symlinksSupported {
+		t.Skip("symlinks are not extracted on this platform")
+	}
+	link := func(name, target string) *tar.Header {
+		return &tar.Header{Name: name, Linkname: target, Typeflag: tar.TypeSymlink, Mode: 0777}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}
+	}
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+		wantErr string
+	}{
+		{name: "link inside", headers: []*tar.Header{file("assets/app.js"), link("app.js", "assets/app.js")}},
+		{name: "absolute link", headers: []*tar.Header{link("etc", "/etc")}, wantErr: "points outside"},
+		{name: "escaping link", headers: []*tar.Header{link("up", "../..")}, wantErr: "points outside"},
+		{name: "written through a link", headers: []*tar.Header{file("assets/app.js"), link("dir", "assets"), file("dir/x")}, wantErr: "through a symlink"},
+		{name: "overwriting a link", headers: []*tar.Header{file("a.js"), link("b.js", "a.js"), file("b.js")}, wantErr: "through a symlink"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			archivePath := filepath.Join(tmpDir, "artifact.tar")
+			f, err := os.Create(archivePath)
+			require.NoError(t, err)
+			tw := tar.NewWriter(f)
+			for _, header := range tt.headers {
+				require.NoError(t, tw.WriteHeader(header))
+			}
+			require.NoError(t, tw.Close())
+			require.NoError(t, f.Close())
+
+			err = extractArchive(archivePath, filepath.Join(tmpDir, "target"))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestStaticDeployer_DeployAndRollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	deployer := NewStaticDeployer(&config.DeployConfig{
+		StaticPath: filepath.Join(tmpDir, "www"),
+	}, zap.NewNop())
+
+	v1 := filepath.Join(tmpDir, "v1.tar")
+	writePlainTar(t, v1, map[string]string{"index.html": "v1"})
+	v2 := filepath.Join(tmpDir, "v2.tar")
+	writePlainTar(t, v2, map[string]string{"index.html": "v2"})
+
+	require.NoError(t, deployer.Deploy(context.TODO(), &types.Build{ID: "b1", ProjectID: "app", ArtifactPath: v1}))
+
+	build := &types.Build{ID: "b2", ProjectID: "app", ArtifactPath: v2}
+	require.NoError(t, deployer.Deploy(context.TODO(), build))
+
+	indexPath := filepath.Join(tmpDir, "www", "app", "index.html")
+	data, err := os.ReadFile(indexPath)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data))
+
+	require.NoError(t, deployer.Rollback(context.TODO(), build))
+	data, err = os.ReadFile(indexPath)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+}
+
+func writePlainTar(t *testing.T, path string, entries map[string]string) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for name, content := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+}
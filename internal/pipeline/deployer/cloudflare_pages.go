@@ -0,0 +1,331 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+	// Limits of a single asset upload request
+	maxPagesUploadFiles = 1000
+	maxPagesUploadBytes = 40 * 1024 * 1024
+)
+
+// pagesConfigFiles are read by Pages from the deployment request instead
+// of being served
+var pagesConfigFiles = []string{"_headers", "_redirects"}
+
+// cloudflarePages deploys sites with the Pages direct upload API. Files
+// Pages already stores are not uploaded again. Production deploys to the
+// project's production branch, other environments to preview branches.
+type cloudflarePages struct {
+	accountID string
+	endpoint  string
+	http      *http.Client
+}
+
+func newCloudflarePages(accountID, endpoint string) *cloudflarePages {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if endpoint == "" {
+		endpoint = defaultCloudflareAPI
+	}
+	return &cloudflarePages{accountID: accountID, endpoint: endpoint, http: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+type pagesProject struct {
+	ProductionBranch    string `json:"production_branch"`
+	CanonicalDeployment *struct {
+		ID string `json:"id"`
+	} `json:"canonical_deployment"`
+}
+
+type pagesDeployment struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Aliases     []string `json:"aliases"` // Branch URLs, stable across deployments
+	LatestStage struct {
+		Name   string `json:"name"`   // e.g. "build" or "deploy"
+		Status string `json:"status"` // e.g. "active", "success" or "failure"
+	} `json:"latest_stage"`
+}
+
+func (d *pagesDeployment) deployment() *providerDeployment {
+	deployURL := d.URL
+	if len(d.Aliases) > 0 {
+		deployURL = d.Aliases[0]
+	}
+	stage := d.LatestStage
+	deployment := &providerDeployment{
+		ID:     d.ID,
+		URL:    deployURL,
+		Status: stage.Status,
+		Live:   stage.Name == "deploy" && stage.Status == "success",
+		Failed: stage.Status == "failure" || stage.Status == "canceled",
+	}
+	if deployment.Failed {
+		deployment.Message = stage.Name + " stage " + stage.Status
+	}
+	return deployment
+}
+
+// pagesAsset is a file of the site, keyed by a hash of its content
+type pagesAsset struct {
+	path string
+	hash string
+	size int64
+}
+
+func (c *cloudflarePages) Production(ctx context.Context, token, site string) (string, error) {
+	var project pagesProject
+	if err := c.call(ctx, token, http.MethodGet, c.projectPath(site), "", nil, &project); err != nil {
+		return "", err
+	}
+	if project.CanonicalDeployment == nil {
+		return "", nil
+	}
+	return project.CanonicalDeployment.ID, nil
+}
+
+func (c *cloudflarePages) Publish(ctx context.Context, token, site, environment, dir string) (*providerDeployment, error) {
+	branch := environment
+	if environment == productionEnvironment {
+		var project pagesProject
+		if err := c.call(ctx, token, http.MethodGet, c.projectPath(site), "", nil, &project); err != nil {
+			return nil, err
+		}
+		branch = project.ProductionBranch
+	}
+
+	assets, err := hashAssets(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash site: %w", err)
+	}
+	if err := c.uploadAssets(ctx, token, site, dir, assets); err != nil {
+		return nil, err
+	}
+
+	manifest := make(map[string]string, len(assets))
+	for _, asset := range assets {
+		manifest["/"+asset.path] = asset.hash
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("manifest", string(manifestJSON))
+	form.WriteField("branch", branch)
+	for _, name := range pagesConfigFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		part, err := form.CreateFormFile(name, name)
+		if err != nil {
+			return nil, err
+		}
+		part.Write(data)
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	var deployment pagesDeployment
+	if err := c.call(ctx, token, http.MethodPost, c.projectPath(site)+"/deployments", form.FormDataContentType(), &body, &deployment); err != nil {
+		return nil, err
+	}
+	return deployment.deployment(), nil
+}
+
+func (c *cloudflarePages) Get(ctx context.Context, token, site, id string) (*providerDeployment, error) {
+	var deployment pagesDeployment
+	if err := c.call(ctx, token, http.MethodGet, c.projectPath(site)+"/deployments/"+url.PathEscape(id), "", nil, &deployment); err != nil {
+		return nil, err
+	}
+	return deployment.deployment(), nil
+}
+
+func (c *cloudflarePages) Restore(ctx context.Context, token, site, id string) error {
+	return c.call(ctx, token, http.MethodPost, c.projectPath(site)+"/deployments/"+url.PathEscape(id)+"/rollback", "", nil, nil)
+}
+
+// uploadAssets uploads the files Pages is missing with the project's
+// short-lived upload token
+func (c *cloudflarePages) uploadAssets(ctx context.Context, token, site, dir string, assets []pagesAsset) error {
+	var upload struct {
+		JWT string `json:"jwt"`
+	}
+	if err := c.call(ctx, token, http.MethodGet, c.projectPath(site)+"/upload-token", "", nil, &upload); err != nil {
+		return err
+	}
+
+	hashes := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		hashes = append(hashes, asset.hash)
+	}
+	var missing []string
+	if err := c.callJSON(ctx, upload.JWT, "/pages/assets/check-missing", map[string][]string{"hashes": hashes}, &missing); err != nil {
+		return err
+	}
+	isMissing := make(map[string]bool, len(missing))
+	for _, hash := range missing {
+		isMissing[hash] = true
+	}
+
+	type uploadFile struct {
+		Key      string            `json:"key"`
+		Value    string            `json:"value"`
+		Metadata map[string]string `json:"metadata"`
+		Base64   bool              `json:"base64"`
+	}
+	var batch []uploadFile
+	var batchBytes int64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := c.callJSON(ctx, upload.JWT, "/pages/assets/upload", batch, nil)
+		batch, batchBytes = nil, 0
+		return err
+	}
+	for _, asset := range assets {
+		if !isMissing[asset.hash] {
+			continue
+		}
+		if len(batch) == maxPagesUploadFiles || batchBytes+asset.size > maxPagesUploadBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(asset.path)))
+		if err != nil {
+			return err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(asset.path))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		batch = append(batch, uploadFile{
+			Key:      asset.hash,
+			Value:    base64.StdEncoding.EncodeToString(data),
+			Metadata: map[string]string{"contentType": contentType},
+			Base64:   true,
+		})
+		batchBytes += asset.size
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return c.callJSON(ctx, upload.JWT, "/pages/assets/upsert-hashes", map[string][]string{"hashes": hashes}, nil)
+}
+
+func (c *cloudflarePages) projectPath(site string) string {
+	return "/accounts/" + url.PathEscape(c.accountID) + "/pages/projects/" + url.PathEscape(site)
+}
+
+func (c *cloudflarePages) callJSON(ctx context.Context, token, path string, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, token, http.MethodPost, path, "application/json", bytes.NewReader(data), out)
+}
+
+// call sends a request to the Cloudflare API and decodes the result of its
+// response envelope into out
+func (c *cloudflarePages) call(ctx context.Context, token, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("cloudflare %s %s: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("failed to decode cloudflare response: %w", err)
+	}
+	if !envelope.Success || resp.StatusCode >= 300 {
+		if len(envelope.Errors) > 0 {
+			return fmt.Errorf("cloudflare %s %s: %d: %s", method, path, envelope.Errors[0].Code, envelope.Errors[0].Message)
+		}
+		return fmt.Errorf("cloudflare %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil || len(envelope.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Result, out); err != nil {
+		return fmt.Errorf("failed to decode cloudflare response: %w", err)
+	}
+	return nil
+}
+
+// hashAssets keys every file of the site by a hash of its content and
+// extension, the way Pages deduplicates uploads across deployments
+func hashAssets(dir string) ([]pagesAsset, error) {
+	var assets []pagesAsset
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, name := range pagesConfigFiles {
+			if rel == name {
+				return nil
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(data) + strings.TrimPrefix(filepath.Ext(rel), ".")))
+		assets = append(assets, pagesAsset{path: rel, hash: hex.EncodeToString(sum[:16]), size: int64(len(data))})
+		return nil
+	})
+	return assets, err
+}
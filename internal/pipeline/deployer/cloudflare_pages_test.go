@@ -0,0 +1,91 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudflarePages_Publish(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("app"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "_redirects"), []byte("/old /new 301"), 0644))
+	assets, err := hashAssets(dir)
+	require.NoError(t, err)
+	require.Len(t, assets, 2, "_redirects is not an asset")
+	hashes := make(map[string]string)
+	for _, asset := range assets {
+		hashes[asset.path] = asset.hash
+	}
+
+	var uploaded []string
+	var manifest map[string]string
+	var branch, redirects string
+	result := func(w http.ResponseWriter, v interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "errors": []string{}, "result": v})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		project := "/accounts/acc/pages/projects/shop"
+		switch r.URL.Path {
+		case project:
+			result(w, map[string]interface{}{"production_branch": "main", "canonical_deployment": map[string]string{"id": "d0"}})
+		case project + "/upload-token":
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			result(w, map[string]string{"jwt": "upload-jwt"})
+		case "/pages/assets/check-missing":
+			assert.Equal(t, "Bearer upload-jwt", r.Header.Get("Authorization"))
+			// index.html is stored from an earlier deployment
+			result(w, []string{hashes["app.js"]})
+		case "/pages/assets/upload":
+			var files []struct {
+				Key string `json:"key"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&files))
+			for _, f := range files {
+				uploaded = append(uploaded, f.Key)
+			}
+			result(w, nil)
+		case "/pages/assets/upsert-hashes":
+			result(w, true)
+		case project + "/deployments":
+			require.NoError(t, r.ParseMultipartForm(1<<20))
+			require.NoError(t, json.Unmarshal([]byte(r.FormValue("manifest")), &manifest))
+			branch = r.FormValue("branch")
+			f, _, err := r.FormFile("_redirects")
+			require.NoError(t, err)
+			data, _ := io.ReadAll(f)
+			redirects = string(data)
+			result(w, map[string]interface{}{
+				"id":           "d1",
+				"url":          "https://d1.shop.pages.dev",
+				"latest_stage": map[string]string{"name": "deploy", "status": "success"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 8000007, "message": "Project not found"}}})
+		}
+	}))
+	defer server.Close()
+
+	c := newCloudflarePages("acc", server.URL)
+	deployment, err := c.Publish(context.Background(), "secret", "shop", "production", dir)
+	require.NoError(t, err)
+	assert.True(t, deployment.Live)
+	assert.Equal(t, "https://d1.shop.pages.dev", deployment.URL)
+	assert.Equal(t, "main", branch, "production deploys to the production branch")
+	assert.Equal(t, []string{hashes["app.js"]}, uploaded)
+	assert.Equal(t, map[string]string{"/index.html": hashes["index.html"], "/app.js": hashes["app.js"]}, manifest)
+	assert.Equal(t, "/old /new 301", redirects)
+
+	_, err = c.Production(context.Background(), "secret", "blog")
+	assert.ErrorContains(t, err, "8000007: Project not found")
+}
@@ -0,0 +1,20 @@
+package deployer
+
+import (
+	"context"
+	"io"
+)
+
+type ExecOptions struct {
+	Command []string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer // Merged into stdout when TTY is set
+	TTY     bool
+}
+
+// Execer is implemented by deployers that can run commands inside a
+// project's running workload
+type Execer interface {
+	Exec(ctx context.Context, projectID string, opts ExecOptions) error
+}
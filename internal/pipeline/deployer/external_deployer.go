@@ -0,0 +1,260 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultExternalTimeout = 10 * time.Minute
+	productionEnvironment  = "production"
+)
+
+// externalPollInterval is how often a deployment's status is checked until
+// it goes live
+var externalPollInterval = 5 * time.Second
+
+// hostingProvider publishes static sites through a provider's API. Sites
+// are Cloudflare Pages projects or Netlify sites.
+type hostingProvider interface {
+	// Production returns the ID of the deployment serving the site's
+	// production environment, empty before its first
+	Production(ctx context.Context, token, site string) (string, error)
+	// Publish uploads the files in dir as a deployment of the site to
+	// environment, "production" or a preview branch
+	Publish(ctx context.Context, token, site, environment, dir string) (*providerDeployment, error)
+	Get(ctx context.Context, token, site, id string) (*providerDeployment, error)
+	// Restore makes the site's production environment serve deployment id
+	Restore(ctx context.Context, token, site, id string) error
+}
+
+// providerDeployment is the state of a deployment as reported by its
+// provider
+type providerDeployment struct {
+	ID      string
+	URL     string
+	Status  string
+	Message string // Why it failed
+	Live    bool
+	Failed  bool
+}
+
+// ExternalDeployer publishes builds on an external static hosting provider
+type ExternalDeployer struct {
+	config   *config.ExternalDeployConfig
+	provider hostingProvider
+	logger   *zap.Logger
+}
+
+func NewExternalDeployer(cfg *config.DeployConfig, logger *zap.Logger) (*ExternalDeployer, error) {
+	external := &cfg.External
+	var provider hostingProvider
+	switch external.Provider {
+	case "cloudflare":
+		if external.AccountID == "" {
+			return nil, errors.New("cloudflare deployments require account_id")
+		}
+		provider = newCloudflarePages(external.AccountID, external.APIURL)
+	case "netlify":
+		provider = newNetlify(external.APIURL)
+	default:
+		return nil, fmt.Errorf("unsupported hosting provider: %q", external.Provider)
+	}
+	return &ExternalDeployer{
+		config:   external,
+		provider: provider,
+		logger:   logger,
+	}, nil
+}
+
+// Deploy publishes the artifact's files and waits for the provider to
+// serve them. The deployment is recorded on the build as soon as the
+// provider accepted it, so failed deployments can be looked up there.
+func (d *ExternalDeployer) Deploy(ctx context.Context, build *types.Build) error {
+	token, err := d.token(build.ProjectID)
+	if err != nil {
+		return err
+	}
+	site := d.site(build.ProjectID)
+	environment := d.environment(build.Environment)
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+
+	// Production is restored to the deployment it served on rollback
+	var previous string
+	if environment == productionEnvironment {
+		if previous, err = d.provider.Production(ctx, token, site); err != nil {
+			return fmt.Errorf("failed to look up production deployment: %w", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "chef-external-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := extractArchive(build.ArtifactPath, dir); err != nil {
+		return fmt.Errorf("failed to extract artifact: %w", err)
+	}
+	root, err := siteRoot(dir)
+	if err != nil {
+		return err
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("publishing to hosting provider",
+		zap.String("provider", d.config.Provider),
+		zap.String("site", site),
+		zap.String("environment", environment))
+
+	deployment, err := d.provider.Publish(ctx, token, site, environment, root)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", d.config.Provider, err)
+	}
+	external := &types.ExternalDeployment{
+		Provider:    d.config.Provider,
+		Site:        site,
+		ID:          deployment.ID,
+		Environment: environment,
+		Previous:    previous,
+		DeployedAt:  time.Now(),
+	}
+
+	for {
+		external.URL = deployment.URL
+		external.Status = deployment.Status
+		external.Message = deployment.Message
+		// Readers may hold the previous record, each update replaces it
+		published := *external
+		types.UpdateBuild(ctx, build, func(b *types.Build) {
+			b.External = &published
+		})
+		if deployment.Failed {
+			return fmt.Errorf("%s deployment %s failed: %s", d.config.Provider, deployment.ID, deployment.Message)
+		}
+		if deployment.Live {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s deployment %s did not go live: %w", d.config.Provider, deployment.ID, ctx.Err())
+		case <-time.After(externalPollInterval):
+		}
+		if deployment, err = d.provider.Get(ctx, token, site, external.ID); err != nil {
+			return fmt.Errorf("failed to check deployment status: %w", err)
+		}
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("hosting provider deployment completed",
+		zap.String("provider", d.config.Provider),
+		zap.String("deployment", external.ID),
+		zap.String("url", external.URL))
+	return nil
+}
+
+// Rollback restores the production deployment the build replaced if the
+// build's deployment is still the one serving production. Preview
+// deployments replace nothing and are left alone.
+func (d *ExternalDeployer) Rollback(ctx context.Context, build *types.Build) error {
+	external := build.External
+	if external == nil || external.Previous == "" {
+		return nil
+	}
+	token, err := d.token(build.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+
+	current, err := d.provider.Production(ctx, token, external.Site)
+	if err != nil {
+		return fmt.Errorf("failed to look up production deployment: %w", err)
+	}
+	if current != external.ID {
+		return nil
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("restoring previous deployment",
+		zap.String("provider", d.config.Provider),
+		zap.String("deployment", external.Previous))
+
+	if err := d.provider.Restore(ctx, token, external.Site, external.Previous); err != nil {
+		return fmt.Errorf("failed to restore deployment %s: %w", external.Previous, err)
+	}
+	return nil
+}
+
+func (d *ExternalDeployer) Validate(build *types.Build) error {
+	if build.ArtifactPath == "" {
+		return fmt.Errorf("artifact path is required")
+	}
+	if _, err := os.Stat(build.ArtifactPath); err != nil {
+		return fmt.Errorf("failed to stat artifact: %w", err)
+	}
+	_, err := d.token(build.ProjectID)
+	return err
+}
+
+// token returns the project's API token. Project names are lower case
+// since the config keys they are read from are.
+func (d *ExternalDeployer) token(projectID string) (string, error) {
+	if token := d.config.ProjectTokens[strings.ToLower(projectID)]; token != "" {
+		return token, nil
+	}
+	if d.config.Token == "" {
+		return "", fmt.Errorf("no %s token configured for project %s", d.config.Provider, projectID)
+	}
+	return d.config.Token, nil
+}
+
+func (d *ExternalDeployer) site(projectID string) string {
+	if site := d.config.Sites[strings.ToLower(projectID)]; site != "" {
+		return site
+	}
+	return projectID
+}
+
+// environment maps a chef environment to the provider's
+func (d *ExternalDeployer) environment(env string) string {
+	if mapped := d.config.Environments[strings.ToLower(env)]; mapped != "" {
+		return mapped
+	}
+	if env == "" {
+		return productionEnvironment
+	}
+	return env
+}
+
+func (d *ExternalDeployer) timeout() time.Duration {
+	if d.config.Timeout > 0 {
+		return time.Duration(d.config.Timeout) * time.Second
+	}
+	return defaultExternalTimeout
+}
+
+// siteRoot returns the directory holding the site's files. Artifacts keep
+// them in a single top-level directory, e.g. html/.
+func siteRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
@@ -0,0 +1,198 @@
+package deployer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// fakeHostingProvider serves deployments from memory; each goes live after
+// pending status checks
+type fakeHostingProvider struct {
+	pending     int
+	fail        bool
+	production  string
+	published   map[string]string // Deployment ID -> environment
+	files       []string
+	restored    []string
+	checks      int
+	deployments int
+}
+
+func (f *fakeHostingProvider) Production(context.Context, string, string) (string, error) {
+	return f.production, nil
+}
+
+func (f *fakeHostingProvider) Publish(_ context.Context, _, _, environment, dir string) (*providerDeployment, error) {
+	assets, err := hashAssets(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, asset := range assets {
+		f.files = append(f.files, asset.path)
+	}
+	f.deployments++
+	id := fmt.Sprintf("d%d", f.deployments)
+	f.published[id] = environment
+	return f.Get(context.Background(), "", "", id)
+}
+
+func (f *fakeHostingProvider) Get(_ context.Context, _, _, id string) (*providerDeployment, error) {
+	f.checks++
+	deployment := &providerDeployment{ID: id, URL: "https://" + id + ".example.com", Status: "building"}
+	switch {
+	case f.checks <= f.pending:
+	case f.fail:
+		deployment.Status, deployment.Failed, deployment.Message = "error", true, "build script failed"
+	default:
+		deployment.Status, deployment.Live = "ready", true
+		if f.published[id] == productionEnvironment {
+			f.production = id
+		}
+	}
+	return deployment, nil
+}
+
+func (f *fakeHostingProvider) Restore(_ context.Context, _, _, id string) error {
+	f.restored = append(f.restored, id)
+	f.production = id
+	return nil
+}
+
+func TestExternalDeployer(t *testing.T) {
+	externalPollInterval = time.Millisecond
+	provider := &fakeHostingProvider{pending: 2, production: "d0", published: make(map[string]string)}
+	d := &ExternalDeployer{
+		config: &config.ExternalDeployConfig{
+			Provider:      "netlify",
+			Token:         "default-token",
+			ProjectTokens: map[string]string{"shop": "shop-token"},
+			Sites:         map[string]string{"shop": "shop-site"},
+			Environments:  map[string]string{"prod": "production"},
+		},
+		provider: provider,
+		logger:   zap.NewNop(),
+	}
+
+	build := &types.Build{ID: "b1", ProjectID: "shop", Environment: "prod", ArtifactPath: writeSSHArtifact(t, "page b1")}
+	require.NoError(t, d.Validate(build))
+	require.NoError(t, d.Deploy(context.Background(), build))
+	assert.Equal(t, []string{"index.html"}, provider.files, "the artifact's top-level directory is the site root")
+	require.NotNil(t, build.External)
+	assert.Equal(t, "shop-site", build.External.Site)
+	assert.Equal(t, "d1", build.External.ID)
+	assert.Equal(t, "production", build.External.Environment)
+	assert.Equal(t, "ready", build.External.Status)
+	assert.Equal(t, "https://d1.example.com", build.External.URL)
+	assert.Equal(t, "d0", build.External.Previous)
+
+	require.NoError(t, d.Rollback(context.Background(), build))
+	assert.Equal(t, []string{"d0"}, provider.restored)
+
+	// Production moved on, so there is nothing to roll back
+	require.NoError(t, d.Rollback(context.Background(), build))
+	assert.Equal(t, []string{"d0"}, provider.restored)
+
+	provider.checks, provider.fail = 0, true
+	staging := &types.Build{ID: "b2", ProjectID: "shop", Environment: "staging", ArtifactPath: writeSSHArtifact(t, "page b2")}
+	err := d.Deploy(context.Background(), staging)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "build script failed")
+	require.NotNil(t, staging.External)
+	assert.Equal(t, "staging", staging.External.Environment)
+	assert.Equal(t, "error", staging.External.Status)
+	assert.Empty(t, staging.External.Previous)
+	require.NoError(t, d.Rollback(context.Background(), staging))
+	assert.Equal(t, []string{"d0"}, provider.restored)
+}
+
+func TestExternalDeployer_Token(t *testing.T) {
+	d := &ExternalDeployer{config: &config.ExternalDeployConfig{
+		Provider:      "cloudflare",
+		ProjectTokens: map[string]string{"shop": "shop-token"},
+	}}
+
+	token, err := d.token("Shop")
+	require.NoError(t, err)
+	assert.Equal(t, "shop-token", token)
+
+	_, err = d.token("blog")
+	assert.ErrorContains(t, err, "no cloudflare token configured for project blog")
+}
+
+func TestNetlify(t *testing.T) {
+	var uploaded []string
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/sites/shop":
+			w.Write([]byte(`{"published_deploy": {"id": "d0"}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/sites/shop/deploys":
+			assert.Equal(t, "application/zip", r.Header.Get("Content-Type"))
+			query = r.URL.RawQuery
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			require.NoError(t, err)
+			for _, f := range zr.File {
+				uploaded = append(uploaded, f.Name)
+			}
+			json.NewEncoder(w).Encode(map[string]string{"id": "d1", "state": "uploaded", "deploy_ssl_url": "https://d1--shop.netlify.app"})
+		case r.URL.Path == "/deploys/d1":
+			w.Write([]byte(`{"id": "d1", "state": "ready", "context": "production", "ssl_url": "https://shop.netlify.app"}`))
+		case r.URL.Path == "/sites/shop/deploys/d0/restore":
+			w.Write([]byte(`{"id": "d0"}`))
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"code": 422, "message": "unknown site"}`))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("app"), 0644))
+
+	n := newNetlify(server.URL)
+	ctx := context.Background()
+	production, err := n.Production(ctx, "secret", "shop")
+	require.NoError(t, err)
+	assert.Equal(t, "d0", production)
+
+	deployment, err := n.Publish(ctx, "secret", "shop", "production", dir)
+	require.NoError(t, err)
+	assert.Empty(t, query, "production deploys are published")
+	assert.ElementsMatch(t, []string{"index.html", "assets/app.js"}, uploaded)
+	assert.False(t, deployment.Live)
+
+	deployment, err = n.Get(ctx, "secret", "shop", "d1")
+	require.NoError(t, err)
+	assert.True(t, deployment.Live)
+	assert.Equal(t, "https://shop.netlify.app", deployment.URL)
+	require.NoError(t, n.Restore(ctx, "secret", "shop", "d0"))
+
+	_, err = n.Publish(ctx, "secret", "shop", "staging", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "branch=staging&draft=true", query)
+
+	_, err = n.Production(ctx, "secret", "blog")
+	assert.ErrorContains(t, err, "unknown site")
+}
@@ -15,6 +15,12 @@ func NewDeployer(config *config.DeployConfig, logger *zap.Logger) (Deployer, err
 	case "static":
 		deployer := NewStaticDeployer(config, logger)
 		return deployer, nil
+	case "ssh":
+		return NewSSHDeployer(config, logger)
+	case "external":
+		return NewExternalDeployer(config, logger)
+	case "sftp", "ftps":
+		return NewFileTransferDeployer(config, logger)
 	default:
 		return nil, fmt.Errorf("unsupported deployment platform: %s", config.Platform)
 	}
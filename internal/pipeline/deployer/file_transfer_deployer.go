@@ -0,0 +1,487 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultTransferConcurrency = 4
+	defaultTransferTimeout     = 10 * time.Minute
+
+	// transferManifestFile records in each project directory what the last
+	// sync uploaded
+	transferManifestFile = ".chef-sync.json"
+	transferTempSuffix   = ".chef-upload"
+
+	// maxPlanLines caps the files listed by dry runs
+	maxPlanLines = 200
+)
+
+var errRemoteNotExist = errors.New("file does not exist")
+
+// remoteFS is a connection to a file server. Paths are slash separated and
+// relative ones start at the login directory.
+type remoteFS interface {
+	MkdirAll(dir string) error
+	WriteFile(name string, r io.Reader) error
+	ReadFile(name string) ([]byte, error)
+	// Rename replaces to with from
+	Rename(from, to string) error
+	Remove(name string) error
+	Close() error
+}
+
+// transferManifest is what was last synced to a project directory
+type transferManifest struct {
+	Build    string `json:"build"`
+	Artifact string `json:"artifact"` // Synced back by rollbacks of the next build
+	// The build live before, restored by rollbacks of this one
+	PreviousBuild    string            `json:"previous_build,omitempty"`
+	PreviousArtifact string            `json:"previous_artifact,omitempty"`
+	Files            map[string]string `json:"files"` // Path -> SHA-256
+}
+
+// FileTransferDeployer syncs static builds to shared hosting over SFTP or
+// FTPS
+type FileTransferDeployer struct {
+	config   *config.FileTransferConfig
+	protocol string // "sftp" or "ftps"
+	dial     func(ctx context.Context) (remoteFS, error)
+	logger   *zap.Logger
+}
+
+func NewFileTransferDeployer(cfg *config.DeployConfig, logger *zap.Logger) (*FileTransferDeployer, error) {
+	transfer := &cfg.FileTransfer
+	if transfer.Host == "" || transfer.User == "" {
+		return nil, fmt.Errorf("%s deployer requires host and user", cfg.Platform)
+	}
+	d := &FileTransferDeployer{
+		config:   transfer,
+		protocol: cfg.Platform,
+		logger:   logger,
+	}
+
+	switch cfg.Platform {
+	case "sftp":
+		var auth []ssh.AuthMethod
+		if transfer.PrivateKeyFile != "" {
+			key, err := os.ReadFile(transfer.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ssh private key: %w", err)
+			}
+			signer, err := ssh.ParsePrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse ssh private key: %w", err)
+			}
+			auth = append(auth, ssh.PublicKeys(signer))
+		}
+		if transfer.Password != "" {
+			auth = append(auth, ssh.Password(transfer.Password))
+		}
+		if len(auth) == 0 {
+			return nil, fmt.Errorf("sftp deployer requires password or private_key_file")
+		}
+		hostKeyCallback, err := sshHostKeyCallback(transfer.KnownHosts, transfer.HostKey)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig := &ssh.ClientConfig{
+			User:            transfer.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		}
+		d.dial = func(ctx context.Context) (remoteFS, error) {
+			client, err := dialSFTP(ctx, transfer.Host, clientConfig)
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}
+	case "ftps":
+		if transfer.Password == "" {
+			return nil, fmt.Errorf("ftps deployer requires password")
+		}
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		d.dial = func(ctx context.Context) (remoteFS, error) {
+			client, err := dialFTPS(ctx, transfer.Host, transfer.User, transfer.Password, tlsConfig)
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file transfer protocol: %s", cfg.Platform)
+	}
+	return d, nil
+}
+
+// Deploy uploads the files that changed since the last sync and removes
+// those the build no longer has. Dry runs record the changes on the build
+// instead.
+func (d *FileTransferDeployer) Deploy(ctx context.Context, build *types.Build) error {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+
+	root, files, cleanup, err := stageArtifact(build.ArtifactPath)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	remote, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	projectDir := d.projectDir(build.ProjectID)
+	current, err := readTransferManifest(remote, projectDir)
+	if err != nil {
+		return err
+	}
+	plan := planSync(current.Files, files)
+
+	if d.config.DryRun {
+		buildlog.Logger(ctx, d.logger).Info("dry run, not syncing files",
+			zap.String("host", d.config.Host),
+			zap.String("changes", plan.summary()))
+		types.RecordEvent(ctx, build, types.EventSyncPlanned, "", plan.diff())
+		return nil
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("syncing files",
+		zap.String("protocol", d.protocol),
+		zap.String("host", d.config.Host),
+		zap.String("changes", plan.summary()))
+
+	if err := d.sync(ctx, remote, projectDir, root, plan); err != nil {
+		return err
+	}
+	manifest := &transferManifest{
+		Build:            build.ID,
+		Artifact:         build.ArtifactPath,
+		PreviousBuild:    current.Build,
+		PreviousArtifact: current.Artifact,
+		Files:            files,
+	}
+	if err := writeTransferManifest(remote, projectDir, manifest); err != nil {
+		return err
+	}
+
+	types.RecordEvent(ctx, build, types.EventFilesSynced, "", plan.summary())
+	return nil
+}
+
+// Rollback syncs back the files of the build live before this one. The
+// files are compared with the build's own rather than the last sync, so
+// uploads of a deploy that failed halfway are undone as well.
+func (d *FileTransferDeployer) Rollback(ctx context.Context, build *types.Build) error {
+	if d.config.DryRun {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+
+	remote, err := d.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	projectDir := d.projectDir(build.ProjectID)
+	current, err := readTransferManifest(remote, projectDir)
+	if err != nil {
+		return err
+	}
+	restoreBuild, restoreArtifact := current.Build, current.Artifact
+	if current.Build == build.ID {
+		restoreBuild, restoreArtifact = current.PreviousBuild, current.PreviousArtifact
+	}
+	if restoreArtifact == "" {
+		buildlog.Logger(ctx, d.logger).Info("no previous deployment to restore",
+			zap.String("host", d.config.Host))
+		return nil
+	}
+
+	_, uploaded, cleanupBuild, err := stageArtifact(build.ArtifactPath)
+	defer cleanupBuild()
+	if err != nil {
+		return err
+	}
+	root, files, cleanup, err := stageArtifact(restoreArtifact)
+	defer cleanup()
+	if err != nil {
+		return fmt.Errorf("artifact of build %s is no longer available: %w", restoreBuild, err)
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("rolling back deployment",
+		zap.String("host", d.config.Host),
+		zap.String("restored_build", restoreBuild))
+
+	if err := d.sync(ctx, remote, projectDir, root, planSync(uploaded, files)); err != nil {
+		return err
+	}
+	return writeTransferManifest(remote, projectDir, &transferManifest{
+		Build:    restoreBuild,
+		Artifact: restoreArtifact,
+		Files:    files,
+	})
+}
+
+func (d *FileTransferDeployer) Validate(build *types.Build) error {
+	if build.ArtifactPath == "" {
+		return fmt.Errorf("artifact path is required")
+	}
+	if _, err := os.Stat(build.ArtifactPath); err != nil {
+		return fmt.Errorf("failed to stat artifact: %w", err)
+	}
+	return nil
+}
+
+// sync uploads the planned files from root over concurrent connections,
+// remote serving the first, then removes the files no longer wanted
+func (d *FileTransferDeployer) sync(ctx context.Context, remote remoteFS, projectDir, root string, plan *syncPlan) error {
+	uploads := plan.uploads()
+	workers := d.config.Concurrency
+	if workers <= 0 {
+		workers = defaultTransferConcurrency
+	}
+	workers = min(workers, len(uploads))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan string)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := remote
+			if i > 0 {
+				var err error
+				if conn, err = d.dial(ctx); err != nil {
+					errs[i] = err
+					cancel()
+					return
+				}
+				defer conn.Close()
+			}
+			made := make(map[string]bool)
+			for name := range jobs {
+				if err := uploadFile(conn, made, projectDir, root, name); err != nil {
+					errs[i] = err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+feed:
+	for _, name := range uploads {
+		select {
+		case jobs <- name:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	for _, name := range plan.removed {
+		if err := remote.Remove(path.Join(projectDir, name)); err != nil && !errors.Is(err, errRemoteNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// uploadFile writes the file to a temporary name next to its target and
+// renames it into place, so visitors never see a partial file
+func uploadFile(remote remoteFS, made map[string]bool, projectDir, root, name string) error {
+	target := path.Join(projectDir, name)
+	dir := path.Dir(target)
+	if !made[dir] {
+		if err := remote.MkdirAll(dir); err != nil {
+			return err
+		}
+		made[dir] = true
+	}
+
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tmp := path.Join(dir, "."+path.Base(target)+transferTempSuffix)
+	if err := remote.WriteFile(tmp, f); err != nil {
+		remote.Remove(tmp)
+		return err
+	}
+	if err := remote.Rename(tmp, target); err != nil {
+		remote.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (d *FileTransferDeployer) projectDir(projectID string) string {
+	return path.Join(d.config.RemotePath, projectID)
+}
+
+func (d *FileTransferDeployer) timeout() time.Duration {
+	if d.config.Timeout > 0 {
+		return time.Duration(d.config.Timeout) * time.Second
+	}
+	return defaultTransferTimeout
+}
+
+func readTransferManifest(remote remoteFS, projectDir string) (*transferManifest, error) {
+	data, err := remote.ReadFile(path.Join(projectDir, transferManifestFile))
+	if errors.Is(err, errRemoteNotExist) {
+		return &transferManifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync manifest: %w", err)
+	}
+	var manifest transferManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse sync manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func writeTransferManifest(remote remoteFS, projectDir string, manifest *transferManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := remote.MkdirAll(projectDir); err != nil {
+		return err
+	}
+	name := path.Join(projectDir, transferManifestFile)
+	if err := remote.WriteFile(name+transferTempSuffix, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write sync manifest: %w", err)
+	}
+	if err := remote.Rename(name+transferTempSuffix, name); err != nil {
+		return fmt.Errorf("failed to write sync manifest: %w", err)
+	}
+	return nil
+}
+
+// stageArtifact extracts the artifact to a temporary directory and hashes
+// the site's files. cleanup must be called even on errors.
+func stageArtifact(artifactPath string) (string, map[string]string, func(), error) {
+	dir, err := os.MkdirTemp("", "chef-transfer-")
+	if err != nil {
+		return "", nil, func() {}, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if err := extractArchive(artifactPath, dir); err != nil {
+		return "", nil, cleanup, fmt.Errorf("failed to extract artifact: %w", err)
+	}
+	root, err := siteRoot(dir)
+	if err != nil {
+		return "", nil, cleanup, err
+	}
+	files, err := checksumDir(root)
+	if err != nil {
+		return "", nil, cleanup, fmt.Errorf("failed to hash artifact: %w", err)
+	}
+	return root, files, cleanup, nil
+}
+
+// remoteDirs lists dir and its parents, outermost first
+func remoteDirs(dir string) []string {
+	var dirs []string
+	for d := path.Clean(dir); d != "." && d != "/"; d = path.Dir(d) {
+		dirs = append([]string{d}, dirs...)
+	}
+	return dirs
+}
+
+// syncPlan is the difference between the files on the server and a build's
+type syncPlan struct {
+	added     []string
+	modified  []string
+	removed   []string
+	unchanged int
+}
+
+func planSync(remote, local map[string]string) *syncPlan {
+	plan := &syncPlan{}
+	for name, sum := range local {
+		switch remoteSum, ok := remote[name]; {
+		case !ok:
+			plan.added = append(plan.added, name)
+		case remoteSum != sum:
+			plan.modified = append(plan.modified, name)
+		default:
+			plan.unchanged++
+		}
+	}
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			plan.removed = append(plan.removed, name)
+		}
+	}
+	sort.Strings(plan.added)
+	sort.Strings(plan.modified)
+	sort.Strings(plan.removed)
+	return plan
+}
+
+func (p *syncPlan) uploads() []string {
+	return append(append([]string(nil), p.added...), p.modified...)
+}
+
+func (p *syncPlan) summary() string {
+	return fmt.Sprintf("%d added, %d modified, %d removed, %d unchanged",
+		len(p.added), len(p.modified), len(p.removed), p.unchanged)
+}
+
+// diff lists the changes one file per line, marked A, M or D like git
+func (p *syncPlan) diff() string {
+	lines := []string{p.summary()}
+	for _, change := range []struct {
+		mark  string
+		names []string
+	}{{"A", p.added}, {"M", p.modified}, {"D", p.removed}} {
+		for _, name := range change.names {
+			lines = append(lines, change.mark+" "+name)
+		}
+	}
+	if len(lines) > maxPlanLines+1 {
+		more := len(lines) - maxPlanLines - 1
+		lines = append(lines[:maxPlanLines+1], fmt.Sprintf("and %d more", more))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,181 @@
+package deployer
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// memoryFS is a file server shared by every connection dialed to it
+type memoryFS struct {
+	mu       sync.Mutex
+	files    map[string]string
+	written  []string // Names written to, in order
+	failPath string   // Writes to it fail
+}
+
+func (m *memoryFS) dial(context.Context) (remoteFS, error) { return m, nil }
+
+func (m *memoryFS) MkdirAll(string) error { return nil }
+
+func (m *memoryFS) WriteFile(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if strings.Contains(name, m.failPath) && m.failPath != "" {
+		return fmt.Errorf("quota exceeded")
+	}
+	m.files[name] = string(data)
+	m.written = append(m.written, name)
+	return nil
+}
+
+func (m *memoryFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, errRemoteNotExist
+	}
+	return []byte(data), nil
+}
+
+func (m *memoryFS) Rename(from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[from]
+	if !ok {
+		return errRemoteNotExist
+	}
+	delete(m.files, from)
+	m.files[to] = data
+	return nil
+}
+
+func (m *memoryFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return errRemoteNotExist
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memoryFS) Close() error { return nil }
+
+// site lists the project's files, without the sync manifest
+func (m *memoryFS) site() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	site := make(map[string]string)
+	for name, data := range m.files {
+		if name != "www/shop/"+transferManifestFile {
+			site[strings.TrimPrefix(name, "www/shop/")] = data
+		}
+	}
+	return site
+}
+
+func writeSiteArtifact(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "build.tar")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	defer tw.Close()
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "html/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	return path
+}
+
+func TestFileTransferDeployer(t *testing.T) {
+	remote := &memoryFS{files: make(map[string]string)}
+	d := &FileTransferDeployer{
+		config:   &config.FileTransferConfig{Host: "ftp.example.com", RemotePath: "www", Concurrency: 2},
+		protocol: "ftps",
+		dial:     remote.dial,
+		logger:   zap.NewNop(),
+	}
+	ctx := context.Background()
+
+	v1 := map[string]string{"index.html": "v1", "assets/app.js": "app", "old.js": "old"}
+	b1 := &types.Build{ID: "b1", ProjectID: "shop", ArtifactPath: writeSiteArtifact(t, v1)}
+	require.NoError(t, d.Validate(b1))
+	require.NoError(t, d.Deploy(ctx, b1))
+	assert.Equal(t, v1, remote.site())
+	for _, name := range remote.written {
+		assert.True(t, strings.HasSuffix(name, transferTempSuffix), "%s is written under a temporary name", name)
+	}
+
+	v2 := map[string]string{"index.html": "v2", "assets/app.js": "app", "new.js": "new"}
+	b2 := &types.Build{ID: "b2", ProjectID: "shop", ArtifactPath: writeSiteArtifact(t, v2)}
+	remote.written = nil
+	require.NoError(t, d.Deploy(ctx, b2))
+	assert.Equal(t, v2, remote.site())
+	sort.Strings(remote.written)
+	assert.Equal(t, []string{
+		"www/shop/" + transferManifestFile + transferTempSuffix,
+		"www/shop/.index.html" + transferTempSuffix,
+		"www/shop/.new.js" + transferTempSuffix,
+	}, remote.written, "unchanged files are not uploaded")
+	assert.Equal(t, "1 added, 1 modified, 1 removed, 1 unchanged", b2.Events[0].Message)
+
+	require.NoError(t, d.Rollback(ctx, b2))
+	assert.Equal(t, v1, remote.site())
+
+	// A deploy failing halfway is undone by its rollback
+	require.NoError(t, d.Deploy(ctx, b2))
+	remote.failPath = "broken.js"
+	b3 := &types.Build{ID: "b3", ProjectID: "shop", ArtifactPath: writeSiteArtifact(t, map[string]string{
+		"index.html": "v3", "assets/app.js": "app", "broken.js": "",
+	})}
+	err := d.Deploy(ctx, b3)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "quota exceeded")
+	require.NoError(t, d.Rollback(ctx, b3))
+	assert.Equal(t, v2, remote.site())
+}
+
+func TestFileTransferDeployer_DryRun(t *testing.T) {
+	remote := &memoryFS{files: map[string]string{"www/shop/index.html": "hand edited"}}
+	d := &FileTransferDeployer{
+		config: &config.FileTransferConfig{RemotePath: "www", DryRun: true},
+		dial:   remote.dial,
+		logger: zap.NewNop(),
+	}
+
+	build := &types.Build{ID: "b1", ProjectID: "shop", ArtifactPath: writeSiteArtifact(t, map[string]string{"index.html": "v1", "app.js": "app"})}
+	require.NoError(t, d.Deploy(context.Background(), build))
+	assert.Equal(t, map[string]string{"www/shop/index.html": "hand edited"}, remote.files)
+	require.Len(t, build.Events, 1)
+	assert.Equal(t, types.EventSyncPlanned, build.Events[0].Type)
+	assert.Equal(t, "2 added, 0 modified, 0 removed, 0 unchanged\nA app.js\nA index.html", build.Events[0].Message)
+}
+
+func TestRemoteDirs(t *testing.T) {
+	assert.Equal(t, []string{"/srv", "/srv/www", "/srv/www/shop"}, remoteDirs("/srv/www/shop"))
+	assert.Equal(t, []string{"www", "www/shop"}, remoteDirs("www/shop/"))
+	assert.Empty(t, remoteDirs(""))
+}
@@ -0,0 +1,213 @@
+package deployer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpsClient is a minimal FTP client for explicit FTPS (AUTH TLS) covering
+// what uploads need. Transfers use extended passive mode over TLS with the
+// control connection's session, which most servers require.
+type ftpsClient struct {
+	conn      *textproto.Conn
+	host      string
+	tlsConfig *tls.Config
+	dialer    net.Dialer
+	ctx       context.Context
+}
+
+func dialFTPS(ctx context.Context, host, user, password string, tlsConfig *tls.Config) (*ftpsClient, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "21")
+	}
+	hostname, _, _ := net.SplitHostPort(addr)
+
+	c := &ftpsClient{host: hostname, ctx: ctx, dialer: net.Dialer{Timeout: 30 * time.Second}}
+	raw, err := c.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	c.conn = textproto.NewConn(raw)
+	go func() {
+		<-ctx.Done()
+		raw.Close()
+	}()
+
+	if _, _, err := c.conn.ReadResponse(220); err != nil {
+		c.conn.Close()
+		return nil, fmt.Errorf("ftp greeting: %w", err)
+	}
+	if _, _, err := c.cmd(234, "AUTH TLS"); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	c.tlsConfig = tlsConfig.Clone()
+	if c.tlsConfig.ServerName == "" {
+		c.tlsConfig.ServerName = hostname
+	}
+	if c.tlsConfig.ClientSessionCache == nil {
+		c.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(4)
+	}
+	tlsConn := tls.Client(raw, c.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("ftps handshake with %s failed: %w", addr, err)
+	}
+	c.conn = textproto.NewConn(tlsConn)
+
+	code, _, err := c.cmd(0, "USER %s", user)
+	if err == nil && code == 331 {
+		_, _, err = c.cmd(2, "PASS %s", password)
+	}
+	for _, command := range []string{"PBSZ 0", "PROT P", "TYPE I"} {
+		if err != nil {
+			break
+		}
+		_, _, err = c.cmd(2, command)
+	}
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// MkdirAll creates the missing directories of dir. Servers reply to MKD of
+// an existing directory with 550 like to any other failure, so failures
+// are only reported by the transfers that follow.
+func (c *ftpsClient) MkdirAll(dir string) error {
+	for _, parent := range remoteDirs(dir) {
+		if err := checkFTPPath(parent); err != nil {
+			return err
+		}
+		c.cmd(2, "MKD %s", parent)
+	}
+	return nil
+}
+
+func (c *ftpsClient) WriteFile(name string, r io.Reader) error {
+	data, err := c.transfer("STOR", name)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(data, r)
+	if err := data.Close(); copyErr == nil {
+		copyErr = err
+	}
+	if _, _, err := c.conn.ReadResponse(2); err != nil {
+		return fmt.Errorf("ftp STOR %s: %w", name, err)
+	}
+	return copyErr
+}
+
+func (c *ftpsClient) ReadFile(name string) ([]byte, error) {
+	data, err := c.transfer("RETR", name)
+	if err != nil {
+		return nil, err
+	}
+	content, readErr := io.ReadAll(data)
+	data.Close()
+	if _, _, err := c.conn.ReadResponse(2); err != nil {
+		return nil, fmt.Errorf("ftp RETR %s: %w", name, err)
+	}
+	return content, readErr
+}
+
+func (c *ftpsClient) Rename(from, to string) error {
+	if err := checkFTPPath(from, to); err != nil {
+		return err
+	}
+	if _, _, err := c.cmd(3, "RNFR %s", from); err != nil {
+		return err
+	}
+	_, _, err := c.cmd(2, "RNTO %s", to)
+	return err
+}
+
+func (c *ftpsClient) Remove(name string) error {
+	if err := checkFTPPath(name); err != nil {
+		return err
+	}
+	_, _, err := c.cmd(2, "DELE %s", name)
+	return err
+}
+
+func (c *ftpsClient) Close() error {
+	c.cmd(2, "QUIT")
+	return c.conn.Close()
+}
+
+// transfer opens a data connection for command on name. Missing files are
+// reported as errRemoteNotExist.
+func (c *ftpsClient) transfer(command, name string) (io.ReadWriteCloser, error) {
+	if err := checkFTPPath(name); err != nil {
+		return nil, err
+	}
+	_, message, err := c.cmd(229, "EPSV")
+	if err != nil {
+		return nil, err
+	}
+	// e.g. "Entering Extended Passive Mode (|||6446|)"
+	start, end := strings.Index(message, "(|||"), strings.LastIndex(message, "|)")
+	if start < 0 || end < start+4 {
+		return nil, fmt.Errorf("ftp EPSV: unexpected reply %q", message)
+	}
+	port, err := strconv.Atoi(message[start+4 : end])
+	if err != nil {
+		return nil, fmt.Errorf("ftp EPSV: unexpected reply %q", message)
+	}
+
+	raw, err := c.dialer.DialContext(c.ctx, "tcp", net.JoinHostPort(c.host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ftp data connection: %w", err)
+	}
+	_, _, err = c.cmd(1, "%s %s", command, name)
+	if err != nil {
+		raw.Close()
+		if protoErr, ok := err.(*textproto.Error); ok && protoErr.Code == 550 && command == "RETR" {
+			return nil, errRemoteNotExist
+		}
+		return nil, err
+	}
+	data := tls.Client(raw, c.tlsConfig)
+	if err := data.HandshakeContext(c.ctx); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("ftps data handshake failed: %w", err)
+	}
+	return data, nil
+}
+
+// cmd sends a command and reads its reply, which must start with the
+// digits of expect as with textproto.Reader.ReadResponse. Any reply below
+// 400 is accepted with expect 0.
+func (c *ftpsClient) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	id, err := c.conn.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.conn.StartResponse(id)
+	defer c.conn.EndResponse(id)
+	code, message, err := c.conn.ReadResponse(expect)
+	if err == nil && expect == 0 && code >= 400 {
+		err = &textproto.Error{Code: code, Msg: message}
+	}
+	return code, message, err
+}
+
+// checkFTPPath rejects names that would end the command line early
+func checkFTPPath(names ...string) error {
+	for _, name := range names {
+		if strings.ContainsAny(name, "\r\n") {
+			return fmt.Errorf("ftp cannot transfer %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/envtemplate"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultHookTimeout = 5 * time.Minute
+	jobPollInterval    = 2 * time.Second
+
+	hookNameLabel = "chef-infra/hook"
+
+	// maxObjectNameLength is the length of a DNS label, which Job names
+	// and label values must fit in
+	maxObjectNameLength = 63
+)
+
+// hookName matches hook names usable in Kubernetes object names
+var hookName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,18}[a-z0-9])?$`)
+
+// invalidNameChars are replaced in the names of hook Jobs
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// HookRunner executes post-deploy hooks and records their outcome as
+// deployment events on the build. Command and k8s_job hooks run as
+// Kubernetes Jobs, never on the server itself.
+type HookRunner struct {
+	config     *config.DeployConfig
+	k8s        *K8sDeployer // nil unless deploying to kubernetes
+	httpClient *http.Client
+	logger     *zap.Logger
+}
+
+func NewHookRunner(config *config.DeployConfig, deployer Deployer, logger *zap.Logger) *HookRunner {
+	runner := &HookRunner{
+		config:     config,
+		httpClient: &http.Client{},
+		logger:     logger,
+	}
+	if k8s, ok := deployer.(*K8sDeployer); ok {
+		runner.k8s = k8s
+	}
+	return runner
+}
+
+// ValidateHooks checks hook definitions before a build starts
+func ValidateHooks(hooks []types.Hook) error {
+	names := make(map[string]bool, len(hooks))
+	for _, hook := range hooks {
+		if hook.Name == "" {
+			return fmt.Errorf("hook name is required")
+		}
+		if !hookName.MatchString(hook.Name) {
+			return fmt.Errorf("invalid hook name %q, use up to 20 lowercase letters, digits and dashes", hook.Name)
+		}
+		if names[hook.Name] {
+			return fmt.Errorf("duplicate hook name: %s", hook.Name)
+		}
+		names[hook.Name] = true
+
+		switch hook.Type {
+		case types.HookTypeCommand:
+			if len(hook.Command) == 0 {
+				return fmt.Errorf("hook %s: command is required", hook.Name)
+			}
+		case types.HookTypeHTTP:
+			if hook.URL == "" {
+				return fmt.Errorf("hook %s: url is required", hook.Name)
+			}
+			if err := envtemplate.Validate(map[string]string{"url": hook.URL, "body": hook.Body}); err != nil {
+				return fmt.Errorf("hook %s: %w", hook.Name, err)
+			}
+		case types.HookTypeK8sJob:
+			if hook.Image == "" {
+				return fmt.Errorf("hook %s: image is required", hook.Name)
+			}
+		default:
+			return fmt.Errorf("hook %s: unsupported hook type: %s", hook.Name, hook.Type)
+		}
+
+		switch hook.FailurePolicy {
+		case "", types.FailurePolicyIgnore, types.FailurePolicyWarn, types.FailurePolicyRollback:
+		default:
+			return fmt.Errorf("hook %s: unsupported failure policy: %s", hook.Name, hook.FailurePolicy)
+		}
+
+		if hook.TimeoutSeconds < 0 {
+			return fmt.Errorf("hook %s: timeout must not be negative", hook.Name)
+		}
+	}
+	return nil
+}
+
+// Run executes the build's hooks in order. It returns an error only when a
+// hook with the rollback failure policy fails; the caller is expected to roll
+// the deployment back.
+func (r *HookRunner) Run(ctx context.Context, build *types.Build) error {
+	for _, hook := range build.Hooks {
+		types.RecordEvent(ctx, build, types.EventHookStarted, hook.Name, "")
+
+		err := r.runHook(ctx, build, hook)
+		if err == nil {
+			types.RecordEvent(ctx, build, types.EventHookSucceeded, hook.Name, "")
+			continue
+		}
+
+		types.RecordEvent(ctx, build, types.EventHookFailed, hook.Name, err.Error())
+
+		switch hook.FailurePolicy {
+		case types.FailurePolicyIgnore:
+			buildlog.Logger(ctx, r.logger).Debug("post-deploy hook failed",
+				zap.String("hook", hook.Name),
+				zap.Error(err))
+		case types.FailurePolicyRollback:
+			return fmt.Errorf("post-deploy hook %s failed: %w", hook.Name, err)
+		default:
+			buildlog.Logger(ctx, r.logger).Warn("post-deploy hook failed",
+				zap.String("hook", hook.Name),
+				zap.Error(err))
+			warning := fmt.Sprintf("post-deploy hook %s failed: %v", hook.Name, err)
+			types.UpdateBuild(ctx, build, func(b *types.Build) {
+				b.Warnings = append(b.Warnings, warning)
+			})
+		}
+	}
+	return nil
+}
+
+func (r *HookRunner) runHook(ctx context.Context, build *types.Build, hook types.Hook) error {
+	timeout := defaultHookTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	buildlog.Logger(ctx, r.logger).Info("running post-deploy hook",
+		zap.String("hook", hook.Name),
+		zap.String("type", string(hook.Type)))
+
+	switch hook.Type {
+	case types.HookTypeHTTP:
+		return r.runHTTP(hookCtx, build, hook)
+	case types.HookTypeCommand, types.HookTypeK8sJob:
+		return r.runJob(hookCtx, build, hook)
+	default:
+		return fmt.Errorf("unsupported hook type: %s", hook.Type)
+	}
+}
+
+func (r *HookRunner) runHTTP(ctx context.Context, build *types.Build, hook types.Hook) error {
+	domain := fmt.Sprintf("%s.%s", build.ProjectID, r.config.IngressDomain)
+	rendered, err := envtemplate.Render(map[string]string{"url": hook.URL, "body": hook.Body}, envtemplate.NewData(build, domain))
+	if err != nil {
+		return err
+	}
+
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rendered["url"], bytes.NewBufferString(rendered["body"]))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runJob runs a hook as a Kubernetes Job. Command hooks run in the
+// deployed image with the project's env vars, k8s_job hooks in their own
+// image.
+func (r *HookRunner) runJob(ctx context.Context, build *types.Build, hook types.Hook) error {
+	if r.k8s == nil {
+		return fmt.Errorf("%s hooks require the kubernetes platform", hook.Type)
+	}
+
+	container := corev1.Container{
+		Name:    jobContainer,
+		Image:   hook.Image,
+		Command: hook.Command,
+	}
+	if hook.Type == types.HookTypeCommand {
+		env, err := r.k8s.containerEnv(build)
+		if err != nil {
+			return err
+		}
+		container.Image = build.ImageID
+		container.Env = env
+		container.EnvFrom = addOnEnv(build)
+	}
+
+	labels := map[string]string{
+		projectLabel:                   build.ProjectID,
+		hookNameLabel:                  sanitizeName(hook.Name),
+		"build-id":                     build.ID,
+		"app.kubernetes.io/managed-by": "chef-infra",
+	}
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   hookJobName(build.ProjectID, hook.Name, build.ID),
+			Labels: labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Affinity:      architectureAffinity(build.Platforms),
+					Containers:    []corev1.Container{container},
+				},
+			},
+		},
+	}
+
+	client := r.k8s.k8sClient
+	created, err := client.CreateJob(ctx, r.config.Namespace, job)
+	if err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		current, err := client.GetJob(ctx, r.config.Namespace, created.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get job status: %w", err)
+		}
+		if current.Status.Succeeded > 0 {
+			return nil
+		}
+		if current.Status.Failed > 0 {
+			return fmt.Errorf("job %s failed", created.Name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("job %s did not complete: %w", created.Name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// hookJobName is the name of the Job running a build's hook
+func hookJobName(projectID, hook, buildID string) string {
+	return sanitizeName(fmt.Sprintf("%s-%s-%s", projectID, hook, buildID))
+}
+
+// sanitizeName makes s a DNS label: it is lowercased, what a label does
+// not allow is replaced with dashes, and a name too long keeps a hash of
+// the whole so it stays unique
+func sanitizeName(s string) string {
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(name) <= maxObjectNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return strings.TrimRight(name[:maxObjectNameLength-9], "-") + "-" + hex.EncodeToString(sum[:])[:8]
+}
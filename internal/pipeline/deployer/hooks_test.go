@@ -0,0 +1,168 @@
+package deployer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestValidateHooks(t *testing.T) {
+	tests := []struct {
+		name        string
+		hooks       []types.Hook
+		expectError bool
+	}{
+		{
+			name: "valid hooks",
+			hooks: []types.Hook{
+				{Name: "purge", Type: types.HookTypeCommand, Command: []string{"curl", "-X", "PURGE", "https://cdn"}},
+				{Name: "announce", Type: types.HookTypeHTTP, URL: "https://hooks.slack.com/x", Body: `{"text":"{{ .Build.CommitHash }}"}`},
+				{Name: "migrate", Type: types.HookTypeK8sJob, Image: "app:latest", FailurePolicy: types.FailurePolicyRollback},
+			},
+		},
+		{
+			name:        "missing name",
+			hooks:       []types.Hook{{Type: types.HookTypeCommand, Command: []string{"true"}}},
+			expectError: true,
+		},
+		{
+			name: "duplicate name",
+			hooks: []types.Hook{
+				{Name: "a", Type: types.HookTypeCommand, Command: []string{"true"}},
+				{Name: "a", Type: types.HookTypeCommand, Command: []string{"true"}},
+			},
+			expectError: true,
+		},
+		{
+			name:        "name unusable in kubernetes",
+			hooks:       []types.Hook{{Name: "Run_Migrations", Type: types.HookTypeK8sJob, Image: "app:latest"}},
+			expectError: true,
+		},
+		{
+			name:        "unknown type",
+			hooks:       []types.Hook{{Name: "a", Type: "ftp"}},
+			expectError: true,
+		},
+		{
+			name:        "unknown failure policy",
+			hooks:       []types.Hook{{Name: "a", Type: types.HookTypeCommand, Command: []string{"true"}, FailurePolicy: "retry"}},
+			expectError: true,
+		},
+		{
+			name:        "unknown template key in body",
+			hooks:       []types.Hook{{Name: "a", Type: types.HookTypeHTTP, URL: "https://x", Body: "{{ .Build.Author }}"}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateHooks(tt.hooks)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestHookRunner(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if body, _ := io.ReadAll(r.Body); len(body) > 0 {
+			received = string(body)
+		}
+	}))
+	defer server.Close()
+
+	runner := NewHookRunner(&config.DeployConfig{IngressDomain: "example.com"}, NewStaticDeployer(&config.DeployConfig{}, zap.NewNop()), zap.NewNop())
+
+	tests := []struct {
+		name           string
+		hooks          []types.Hook
+		expectError    bool
+		expectWarnings int
+		expectEvents   []types.DeploymentEventType
+	}{
+		{
+			name:         "successful hook",
+			hooks:        []types.Hook{{Name: "announce", Type: types.HookTypeHTTP, URL: server.URL + "/ok", Body: "deployed {{ .Build.CommitHash }}"}},
+			expectEvents: []types.DeploymentEventType{types.EventHookStarted, types.EventHookSucceeded},
+		},
+		{
+			name:         "ignored failure",
+			hooks:        []types.Hook{{Name: "purge", Type: types.HookTypeHTTP, URL: server.URL + "/fail", FailurePolicy: types.FailurePolicyIgnore}},
+			expectEvents: []types.DeploymentEventType{types.EventHookStarted, types.EventHookFailed},
+		},
+		{
+			name:           "warned failure continues",
+			hooks:          []types.Hook{{Name: "purge", Type: types.HookTypeHTTP, URL: server.URL + "/fail"}, {Name: "announce", Type: types.HookTypeHTTP, URL: server.URL + "/ok"}},
+			expectWarnings: 1,
+			expectEvents:   []types.DeploymentEventType{types.EventHookStarted, types.EventHookFailed, types.EventHookStarted, types.EventHookSucceeded},
+		},
+		{
+			name:         "rollback failure stops",
+			hooks:        []types.Hook{{Name: "migrate", Type: types.HookTypeHTTP, URL: server.URL + "/fail", FailurePolicy: types.FailurePolicyRollback}, {Name: "announce", Type: types.HookTypeHTTP, URL: server.URL + "/ok"}},
+			expectError:  true,
+			expectEvents: []types.DeploymentEventType{types.EventHookStarted, types.EventHookFailed},
+		},
+		{
+			name:           "command without kubernetes",
+			hooks:          []types.Hook{{Name: "purge", Type: types.HookTypeCommand, Command: []string{"true"}}},
+			expectWarnings: 1,
+			expectEvents:   []types.DeploymentEventType{types.EventHookStarted, types.EventHookFailed},
+		},
+		{
+			name:           "k8s job without kubernetes",
+			hooks:          []types.Hook{{Name: "migrate", Type: types.HookTypeK8sJob, Image: "app:latest"}},
+			expectWarnings: 1,
+			expectEvents:   []types.DeploymentEventType{types.EventHookStarted, types.EventHookFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			build := &types.Build{ID: "build-1", ProjectID: "shop", CommitHash: "abc123", Hooks: tt.hooks}
+
+			err := runner.Run(context.Background(), build)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Len(t, build.Warnings, tt.expectWarnings)
+			require.Len(t, build.Events, len(tt.expectEvents))
+			for i, eventType := range tt.expectEvents {
+				assert.Equal(t, eventType, build.Events[i].Type)
+			}
+		})
+	}
+
+	assert.Equal(t, "deployed abc123", received)
+}
+
+func TestHookJobName(t *testing.T) {
+	assert.Equal(t, "shop-migrate-b1", hookJobName("shop", "migrate", "b1"))
+	assert.Equal(t, "shop-run-migrations-b1", hookJobName("shop", "Run_Migrations", "b1"))
+
+	long := hookJobName(strings.Repeat("a", 60), "migrate", "0b7c2d4e-8f1a-4c3b-9d2e-5f6a7b8c9d0e")
+	assert.LessOrEqual(t, len(long), 63)
+	assert.Regexp(t, `^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`, long)
+	assert.NotEqual(t, long, hookJobName(strings.Repeat("a", 60), "migrate", "1b7c2d4e-8f1a-4c3b-9d2e-5f6a7b8c9d0e"))
+}
@@ -0,0 +1,22 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// ErrJobNotFound is returned for jobs and runs the project does not have
+var ErrJobNotFound = errors.New("job not found")
+
+// JobRunner is implemented by deployers that run the scheduled jobs of
+// the deployed build
+type JobRunner interface {
+	// JobRuns returns the kept runs of a job, newest first
+	JobRuns(ctx context.Context, projectID, job string) ([]types.JobRun, error)
+	// TriggerJob starts a run of a job outside its schedule
+	TriggerJob(ctx context.Context, projectID, job string) (*types.JobRun, error)
+	// StreamJobLogs sends the output of a run
+	StreamJobLogs(ctx context.Context, projectID, runID string, opts LogOptions, send func(LogLine) error) error
+}
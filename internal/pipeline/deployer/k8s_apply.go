@@ -0,0 +1,97 @@
+package deployer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fieldManager owns the fields chef-infra sets on kubernetes objects
+const fieldManager = "chef-infra"
+
+// ErrApplyConflict is returned when an object's fields are managed by
+// another client and would be overwritten by a deploy
+var ErrApplyConflict = errors.New("fields are managed by another client")
+
+// legacyFieldManager is the manager of objects chef-infra created and
+// updated before it used server-side apply. Kubernetes named it after the
+// binary through the default user agent.
+var legacyFieldManager = filepath.Base(os.Args[0])
+
+// conflictManager extracts the manager from a conflict cause, e.g.
+// conflict with "kubectl-edit" using apps/v1
+var conflictManager = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// applyPatch encodes obj as a server-side apply patch of kind gvk
+func applyPatch(obj runtime.Object, gvk schema.GroupVersionKind) ([]byte, error) {
+	obj = obj.DeepCopyObject()
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", gvk.Kind, err)
+	}
+	return data, nil
+}
+
+func applyOptions(force bool) metav1.PatchOptions {
+	return metav1.PatchOptions{FieldManager: fieldManager, Force: &force}
+}
+
+// apply runs apply, forcing it when the deploy target is configured to.
+// Conflicts only with fields chef-infra wrote itself, through updates or
+// before it used server-side apply, are forced as well; others fail with
+// ErrApplyConflict naming the fields and their managers.
+func (d *K8sDeployer) apply(kind, name string, apply func(force bool) error) error {
+	err := apply(d.config.ForceApply)
+	if err == nil || !k8serrors.IsConflict(err) {
+		return err
+	}
+
+	causes := conflictCauses(err)
+	if len(causes) == 0 {
+		return fmt.Errorf("%w: %s %s: %w", ErrApplyConflict, kind, name, err)
+	}
+	var foreign []string
+	for _, cause := range causes {
+		manager := ""
+		if match := conflictManager.FindStringSubmatch(cause.Message); match != nil {
+			manager = match[1]
+		}
+		if manager != fieldManager && manager != legacyFieldManager {
+			foreign = append(foreign, fmt.Sprintf("%s (%s)", cause.Field, cause.Message))
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("%w: %s %s: %s; revert them or set deploy.force_apply to overwrite them",
+			ErrApplyConflict, kind, name, strings.Join(foreign, ", "))
+	}
+
+	d.logger.Info("taking over fields chef-infra set before server-side apply",
+		zap.String("kind", kind),
+		zap.String("name", name))
+	return apply(true)
+}
+
+func conflictCauses(err error) []metav1.StatusCause {
+	var status k8serrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return nil
+	}
+	var causes []metav1.StatusCause
+	for _, cause := range status.Status().Details.Causes {
+		if cause.Type == metav1.CauseTypeFieldManagerConflict {
+			causes = append(causes, cause)
+		}
+	}
+	return causes
+}
@@ -2,72 +2,218 @@ package deployer
 
 import (
 	"context"
+	"fmt"
+	"io"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
 // K8sClient interface abstracts kubernetes client operations
 type K8sClient interface {
-	CreateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error)
+	// Apply methods apply the object with server-side apply as chef-infra's
+	// field manager. Fields managed by others are kept; setting one of them
+	// to another value fails with a conflict unless force is set.
+	ApplyDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment, force bool) (*appsv1.Deployment, error)
 	UpdateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error)
 	GetDeployment(ctx context.Context, namespace, name string) (*appsv1.Deployment, error)
-	CreateService(ctx context.Context, namespace string, service *corev1.Service) (*corev1.Service, error)
-	UpdateService(ctx context.Context, namespace string, service *corev1.Service) (*corev1.Service, error)
+	ListDeployments(ctx context.Context, namespace string, opts metav1.ListOptions) (*appsv1.DeploymentList, error)
+	ApplyService(ctx context.Context, namespace string, service *corev1.Service, force bool) (*corev1.Service, error)
 	GetService(ctx context.Context, namespace, name string) (*corev1.Service, error)
-	CreateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error)
-	UpdateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error)
+	ApplyIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress, force bool) (*networkingv1.Ingress, error)
 	GetIngress(ctx context.Context, namespace, name string) (*networkingv1.Ingress, error)
 	ListReplicaSets(ctx context.Context, namespace string, opts metav1.ListOptions) (*appsv1.ReplicaSetList, error)
+	ListNodes(ctx context.Context, opts metav1.ListOptions) (*corev1.NodeList, error)
+	CreateJob(ctx context.Context, namespace string, job *batchv1.Job) (*batchv1.Job, error)
+	GetJob(ctx context.Context, namespace, name string) (*batchv1.Job, error)
+	ListJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.JobList, error)
+	ApplyCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob, force bool) (*batchv1.CronJob, error)
+	UpdateCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob) (*batchv1.CronJob, error)
+	GetCronJob(ctx context.Context, namespace, name string) (*batchv1.CronJob, error)
+	ListCronJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.CronJobList, error)
+	DeleteCronJob(ctx context.Context, namespace, name string) error
+	ListPods(ctx context.Context, namespace string, opts metav1.ListOptions) (*corev1.PodList, error)
+	WatchDeployment(ctx context.Context, namespace, name string) (watch.Interface, error)
+	WatchPods(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error)
+	ListEvents(ctx context.Context, namespace string, opts metav1.ListOptions) (*corev1.EventList, error)
+	StreamPodLogs(ctx context.Context, namespace, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error)
+	ExecInPod(ctx context.Context, namespace, pod, container string, opts ExecOptions) error
+	DeleteDeployment(ctx context.Context, namespace, name string) error
+	DeleteService(ctx context.Context, namespace, name string) error
+	DeleteIngress(ctx context.Context, namespace, name string) error
 }
 
 type RealK8sClient struct {
-	clientset kubernetes.Interface
+	clientset  kubernetes.Interface
+	restConfig *rest.Config
 }
 
-func NewRealK8sClient(clientset kubernetes.Interface) *RealK8sClient {
-	return &RealK8sClient{clientset: clientset}
+func NewRealK8sClient(clientset kubernetes.Interface, restConfig *rest.Config) *RealK8sClient {
+	return &RealK8sClient{clientset: clientset, restConfig: restConfig}
 }
 
-func (c *RealK8sClient) CreateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
-	return c.clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{})
+func (c *RealK8sClient) ApplyDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment, force bool) (*appsv1.Deployment, error) {
+	data, err := applyPatch(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.AppsV1().Deployments(namespace).Patch(ctx, deployment.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
 }
 
 func (c *RealK8sClient) UpdateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
-	return c.clientset.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{})
+	return c.clientset.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{FieldManager: fieldManager})
 }
 
 func (c *RealK8sClient) GetDeployment(ctx context.Context, namespace, name string) (*appsv1.Deployment, error) {
 	return c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
 }
 
-func (c *RealK8sClient) CreateService(ctx context.Context, namespace string, service *corev1.Service) (*corev1.Service, error) {
-	return c.clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
-}
-
-func (c *RealK8sClient) CreateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
-	return c.clientset.NetworkingV1().Ingresses(namespace).Create(ctx, ingress, metav1.CreateOptions{})
+func (c *RealK8sClient) ListDeployments(ctx context.Context, namespace string, opts metav1.ListOptions) (*appsv1.DeploymentList, error) {
+	return c.clientset.AppsV1().Deployments(namespace).List(ctx, opts)
 }
 
 func (c *RealK8sClient) ListReplicaSets(ctx context.Context, namespace string, opts metav1.ListOptions) (*appsv1.ReplicaSetList, error) {
 	return c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, opts)
 }
 
-func (c *RealK8sClient) UpdateService(ctx context.Context, namespace string, service *corev1.Service) (*corev1.Service, error) {
-	return c.clientset.CoreV1().Services(namespace).Update(ctx, service, metav1.UpdateOptions{})
+func (c *RealK8sClient) ListNodes(ctx context.Context, opts metav1.ListOptions) (*corev1.NodeList, error) {
+	return c.clientset.CoreV1().Nodes().List(ctx, opts)
+}
+
+func (c *RealK8sClient) CreateJob(ctx context.Context, namespace string, job *batchv1.Job) (*batchv1.Job, error) {
+	return c.clientset.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{FieldManager: fieldManager})
+}
+
+func (c *RealK8sClient) GetJob(ctx context.Context, namespace, name string) (*batchv1.Job, error) {
+	return c.clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *RealK8sClient) ListJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.JobList, error) {
+	return c.clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+}
+
+func (c *RealK8sClient) ApplyCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob, force bool) (*batchv1.CronJob, error) {
+	data, err := applyPatch(cronJob, batchv1.SchemeGroupVersion.WithKind("CronJob"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.BatchV1().CronJobs(namespace).Patch(ctx, cronJob.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
+}
+
+func (c *RealK8sClient) UpdateCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob) (*batchv1.CronJob, error) {
+	return c.clientset.BatchV1().CronJobs(namespace).Update(ctx, cronJob, metav1.UpdateOptions{FieldManager: fieldManager})
+}
+
+func (c *RealK8sClient) GetCronJob(ctx context.Context, namespace, name string) (*batchv1.CronJob, error) {
+	return c.clientset.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *RealK8sClient) ListCronJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.CronJobList, error) {
+	return c.clientset.BatchV1().CronJobs(namespace).List(ctx, opts)
+}
+
+// DeleteCronJob deletes the CronJob along with the runs it keeps. It is
+// gone once the runs are.
+func (c *RealK8sClient) DeleteCronJob(ctx context.Context, namespace, name string) error {
+	propagation := metav1.DeletePropagationForeground
+	return c.clientset.BatchV1().CronJobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+func (c *RealK8sClient) ListPods(ctx context.Context, namespace string, opts metav1.ListOptions) (*corev1.PodList, error) {
+	return c.clientset.CoreV1().Pods(namespace).List(ctx, opts)
+}
+
+func (c *RealK8sClient) WatchDeployment(ctx context.Context, namespace, name string) (watch.Interface, error) {
+	return c.clientset.AppsV1().Deployments(namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+	})
+}
+
+func (c *RealK8sClient) ListEvents(ctx context.Context, namespace string, opts metav1.ListOptions) (*corev1.EventList, error) {
+	return c.clientset.CoreV1().Events(namespace).List(ctx, opts)
+}
+
+func (c *RealK8sClient) WatchPods(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.clientset.CoreV1().Pods(namespace).Watch(ctx, opts)
+}
+
+func (c *RealK8sClient) StreamPodLogs(ctx context.Context, namespace, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return c.clientset.CoreV1().Pods(namespace).GetLogs(name, opts).Stream(ctx)
+}
+
+func (c *RealK8sClient) ExecInPod(ctx context.Context, namespace, pod, container string, opts ExecOptions) error {
+	req := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(c.restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	return executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Stderr: opts.Stderr,
+		Tty:    opts.TTY,
+	})
+}
+
+func (c *RealK8sClient) ApplyService(ctx context.Context, namespace string, service *corev1.Service, force bool) (*corev1.Service, error) {
+	data, err := applyPatch(service, corev1.SchemeGroupVersion.WithKind("Service"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.CoreV1().Services(namespace).Patch(ctx, service.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
 }
 
 func (c *RealK8sClient) GetService(ctx context.Context, namespace, name string) (*corev1.Service, error) {
 	return c.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
 }
 
-func (c *RealK8sClient) UpdateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
-	return c.clientset.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{})
+func (c *RealK8sClient) ApplyIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress, force bool) (*networkingv1.Ingress, error) {
+	data, err := applyPatch(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.NetworkingV1().Ingresses(namespace).Patch(ctx, ingress.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
 }
 
 func (c *RealK8sClient) GetIngress(ctx context.Context, namespace, name string) (*networkingv1.Ingress, error) {
 	return c.clientset.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
 }
+
+// DeleteDeployment deletes the Deployment along with its ReplicaSets and
+// pods. It is gone once the pods are.
+func (c *RealK8sClient) DeleteDeployment(ctx context.Context, namespace, name string) error {
+	propagation := metav1.DeletePropagationForeground
+	return c.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+func (c *RealK8sClient) DeleteService(ctx context.Context, namespace, name string) error {
+	return c.clientset.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func (c *RealK8sClient) DeleteIngress(ctx context.Context, namespace, name string) error {
+	return c.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
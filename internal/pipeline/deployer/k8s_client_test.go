@@ -2,64 +2,173 @@ package deployer
 
 import (
 	"context"
+	"io"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 type TestK8sClient struct {
 	clientset *fake.Clientset
+	execCalls []ExecOptions
 }
 
 func NewTestK8sClient() *TestK8sClient {
 	return &TestK8sClient{
-		clientset: fake.NewSimpleClientset(),
+		clientset: fake.NewClientset(),
 	}
 }
 
+// Objects created by tests stand for those chef-infra created before it
+// used server-side apply
 func (c *TestK8sClient) CreateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
-	return c.clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{})
+	return c.clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{FieldManager: legacyFieldManager})
+}
+
+func (c *TestK8sClient) ApplyDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment, force bool) (*appsv1.Deployment, error) {
+	data, err := applyPatch(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.AppsV1().Deployments(namespace).Patch(ctx, deployment.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
 }
 
 func (c *TestK8sClient) UpdateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
-	return c.clientset.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{})
+	return c.clientset.AppsV1().Deployments(namespace).Update(ctx, deployment, metav1.UpdateOptions{FieldManager: fieldManager})
 }
 
 func (c *TestK8sClient) GetDeployment(ctx context.Context, namespace, name string) (*appsv1.Deployment, error) {
 	return c.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
 }
 
+func (c *TestK8sClient) ListDeployments(ctx context.Context, namespace string, opts metav1.ListOptions) (*appsv1.DeploymentList, error) {
+	return c.clientset.AppsV1().Deployments(namespace).List(ctx, opts)
+}
+
 func (c *TestK8sClient) CreateService(ctx context.Context, namespace string, service *corev1.Service) (*corev1.Service, error) {
-	return c.clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
+	return c.clientset.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{FieldManager: legacyFieldManager})
 }
 
-func (c *TestK8sClient) CreateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
-	return c.clientset.NetworkingV1().Ingresses(namespace).Create(ctx, ingress, metav1.CreateOptions{})
+func (c *TestK8sClient) ApplyService(ctx context.Context, namespace string, service *corev1.Service, force bool) (*corev1.Service, error) {
+	data, err := applyPatch(service, corev1.SchemeGroupVersion.WithKind("Service"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.CoreV1().Services(namespace).Patch(ctx, service.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
+}
+
+func (c *TestK8sClient) ApplyIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress, force bool) (*networkingv1.Ingress, error) {
+	data, err := applyPatch(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.NetworkingV1().Ingresses(namespace).Patch(ctx, ingress.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
 }
 
 func (c *TestK8sClient) ListReplicaSets(ctx context.Context, namespace string, opts metav1.ListOptions) (*appsv1.ReplicaSetList, error) {
 	return c.clientset.AppsV1().ReplicaSets(namespace).List(ctx, opts)
 }
 
-func (c *TestK8sClient) UpdateService(ctx context.Context, namespace string, service *corev1.Service) (*corev1.Service, error) {
-	return c.clientset.CoreV1().Services(namespace).Update(ctx, service, metav1.UpdateOptions{})
+func (c *TestK8sClient) WatchDeployment(ctx context.Context, namespace, name string) (watch.Interface, error) {
+	return c.clientset.AppsV1().Deployments(namespace).Watch(ctx, metav1.ListOptions{})
 }
 
-func (c *TestK8sClient) GetService(ctx context.Context, namespace, name string) (*corev1.Service, error) {
-	return c.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+func (c *TestK8sClient) WatchPods(ctx context.Context, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.clientset.CoreV1().Pods(namespace).Watch(ctx, opts)
+}
+
+func (c *TestK8sClient) ListEvents(ctx context.Context, namespace string, opts metav1.ListOptions) (*corev1.EventList, error) {
+	return c.clientset.CoreV1().Events(namespace).List(ctx, opts)
 }
 
-func (c *TestK8sClient) UpdateIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress) (*networkingv1.Ingress, error) {
-	return c.clientset.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{})
+func (c *TestK8sClient) ListNodes(ctx context.Context, opts metav1.ListOptions) (*corev1.NodeList, error) {
+	return c.clientset.CoreV1().Nodes().List(ctx, opts)
+}
+
+func (c *TestK8sClient) CreateJob(ctx context.Context, namespace string, job *batchv1.Job) (*batchv1.Job, error) {
+	return c.clientset.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{FieldManager: fieldManager})
+}
+
+func (c *TestK8sClient) GetJob(ctx context.Context, namespace, name string) (*batchv1.Job, error) {
+	return c.clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *TestK8sClient) ListJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.JobList, error) {
+	return c.clientset.BatchV1().Jobs(namespace).List(ctx, opts)
+}
+
+func (c *TestK8sClient) CreateCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob) (*batchv1.CronJob, error) {
+	return c.clientset.BatchV1().CronJobs(namespace).Create(ctx, cronJob, metav1.CreateOptions{FieldManager: legacyFieldManager})
+}
+
+func (c *TestK8sClient) ApplyCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob, force bool) (*batchv1.CronJob, error) {
+	data, err := applyPatch(cronJob, batchv1.SchemeGroupVersion.WithKind("CronJob"))
+	if err != nil {
+		return nil, err
+	}
+	return c.clientset.BatchV1().CronJobs(namespace).Patch(ctx, cronJob.Name, k8stypes.ApplyPatchType, data, applyOptions(force))
+}
+
+func (c *TestK8sClient) UpdateCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob) (*batchv1.CronJob, error) {
+	return c.clientset.BatchV1().CronJobs(namespace).Update(ctx, cronJob, metav1.UpdateOptions{FieldManager: fieldManager})
+}
+
+func (c *TestK8sClient) GetCronJob(ctx context.Context, namespace, name string) (*batchv1.CronJob, error) {
+	return c.clientset.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *TestK8sClient) ListCronJobs(ctx context.Context, namespace string, opts metav1.ListOptions) (*batchv1.CronJobList, error) {
+	return c.clientset.BatchV1().CronJobs(namespace).List(ctx, opts)
+}
+
+func (c *TestK8sClient) DeleteCronJob(ctx context.Context, namespace, name string) error {
+	return c.clientset.BatchV1().CronJobs(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func (c *TestK8sClient) ListPods(ctx context.Context, namespace string, opts metav1.ListOptions) (*corev1.PodList, error) {
+	return c.clientset.CoreV1().Pods(namespace).List(ctx, opts)
+}
+
+func (c *TestK8sClient) StreamPodLogs(ctx context.Context, namespace, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return c.clientset.CoreV1().Pods(namespace).GetLogs(name, opts).Stream(ctx)
+}
+
+// ExecInPod echoes stdin to stdout since the fake clientset cannot exec
+func (c *TestK8sClient) ExecInPod(_ context.Context, _, _, _ string, opts ExecOptions) error {
+	c.execCalls = append(c.execCalls, opts)
+	if opts.Stdin != nil && opts.Stdout != nil {
+		_, err := io.Copy(opts.Stdout, opts.Stdin)
+		return err
+	}
+	return nil
+}
+
+func (c *TestK8sClient) GetService(ctx context.Context, namespace, name string) (*corev1.Service, error) {
+	return c.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
 }
 
 func (c *TestK8sClient) GetIngress(ctx context.Context, namespace, name string) (*networkingv1.Ingress, error) {
 	return c.clientset.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
 }
 
+func (c *TestK8sClient) DeleteDeployment(ctx context.Context, namespace, name string) error {
+	return c.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func (c *TestK8sClient) DeleteService(ctx context.Context, namespace, name string) error {
+	return c.clientset.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func (c *TestK8sClient) DeleteIngress(ctx context.Context, namespace, name string) error {
+	return c.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
 func (c *TestK8sClient) GetClientset() *fake.Clientset {
 	return c.clientset
 }
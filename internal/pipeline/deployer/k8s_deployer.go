@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
+	"time"
 
+	"github.com/elskow/chef-infra/internal/pipeline/addon"
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/envtemplate"
+	"github.com/elskow/chef-infra/internal/pipeline/teardown"
 	"github.com/elskow/chef-infra/internal/pipeline/types"
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
@@ -18,9 +22,30 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	// changeCauseAnnotation is shown by kubectl rollout history
+	changeCauseAnnotation = "kubernetes.io/change-cause"
+	// buildAnnotation names the build a deployment runs. The deployment
+	// controller copies it to the ReplicaSet of each revision.
+	buildAnnotation = "chef-infra/build"
+	// revisionAnnotation is set by the deployment controller
+	revisionAnnotation = "deployment.kubernetes.io/revision"
+)
+
+// controllerAnnotations are maintained by the deployment controller and
+// not restored from a ReplicaSet on rollback
+var controllerAnnotations = map[string]bool{
+	revisionAnnotation:                                 true,
+	"deployment.kubernetes.io/revision-history":        true,
+	"deployment.kubernetes.io/desired-replicas":        true,
+	"deployment.kubernetes.io/max-replicas":            true,
+	"kubectl.kubernetes.io/last-applied-configuration": true,
+}
+
 type K8sDeployer struct {
 	config    *config.DeployConfig
 	logger    *zap.Logger
@@ -28,10 +53,9 @@ type K8sDeployer struct {
 }
 
 func NewK8sDeployer(config *config.DeployConfig, logger *zap.Logger) (*K8sDeployer, error) {
-	kubeconfig := filepath.Join(os.Getenv("HOME"), ".kube", "config")
-	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	restConfig, err := LoadKubeConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		return nil, err
 	}
 
 	clientset, err := kubernetes.NewForConfig(restConfig)
@@ -42,21 +66,55 @@ func NewK8sDeployer(config *config.DeployConfig, logger *zap.Logger) (*K8sDeploy
 	return &K8sDeployer{
 		config:    config,
 		logger:    logger,
-		k8sClient: NewRealK8sClient(clientset),
+		k8sClient: NewRealK8sClient(clientset, restConfig),
 	}, nil
 }
 
+// LoadKubeConfig reads the user's kubeconfig from ~/.kube/config
+func LoadKubeConfig() (*rest.Config, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+
+	kubeconfig := filepath.Join(homeDir, ".kube", "config")
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	return restConfig, nil
+}
+
 func (d *K8sDeployer) Deploy(ctx context.Context, build *types.Build) error {
 	pathType := networkingv1.PathTypePrefix
 
-	// Create or update deployment
+	if err := d.validateNodeArchitectures(ctx, build); err != nil {
+		return err
+	}
+
+	env, err := d.containerEnv(build)
+	if err != nil {
+		return err
+	}
+
+	replicas, err := d.replicas(ctx, build.ProjectID, int32(d.config.ReplicaCount))
+	if err != nil {
+		return err
+	}
+
+	// Only the fields set here are applied, others like annotations added
+	// by other controllers are kept
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      build.ProjectID,
 			Namespace: d.config.Namespace,
+			Annotations: map[string]string{
+				changeCauseAnnotation: "Deploy " + build.Describe(),
+				buildAnnotation:       build.ID,
+			},
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &[]int32{int32(d.config.ReplicaCount)}[0],
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
 					"app": build.ProjectID,
@@ -67,15 +125,24 @@ func (d *K8sDeployer) Deploy(ctx context.Context, build *types.Build) error {
 					Labels: map[string]string{
 						"app": build.ProjectID,
 					},
+					// Tells the build's pods apart while rolling out
+					Annotations: map[string]string{
+						buildAnnotation: build.ID,
+					},
 				},
 				Spec: corev1.PodSpec{
+					Affinity: architectureAffinity(build.Platforms),
 					Containers: []corev1.Container{
 						{
-							Name:  build.ProjectID,
-							Image: build.ImageID,
+							Name:    build.ProjectID,
+							Image:   build.ImageID,
+							Env:     env,
+							EnvFrom: addOnEnv(build),
 							Ports: []corev1.ContainerPort{
 								{
+									// Part of the key of ports in server-side apply
 									ContainerPort: 80,
+									Protocol:      corev1.ProtocolTCP,
 								},
 							},
 						},
@@ -86,19 +153,14 @@ func (d *K8sDeployer) Deploy(ctx context.Context, build *types.Build) error {
 	}
 
 	// Apply deployment
-	_, err := d.k8sClient.CreateDeployment(ctx, d.config.Namespace, deployment)
+	err = d.apply("deployment", deployment.Name, func(force bool) error {
+		_, err := d.k8sClient.ApplyDeployment(ctx, d.config.Namespace, deployment, force)
+		return err
+	})
 	if err != nil {
-		if k8serrors.IsAlreadyExists(err) {
-			_, err = d.k8sClient.UpdateDeployment(ctx, d.config.Namespace, deployment)
-			if err != nil {
-				return fmt.Errorf("failed to update deployment: %w", err)
-			}
-		} else {
-			return fmt.Errorf("failed to create deployment: %w", err)
-		}
+		return fmt.Errorf("failed to apply deployment: %w", err)
 	}
 
-	// Create service
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      build.ProjectID,
@@ -111,6 +173,7 @@ func (d *K8sDeployer) Deploy(ctx context.Context, build *types.Build) error {
 			Ports: []corev1.ServicePort{
 				{
 					Port:       80,
+					Protocol:   corev1.ProtocolTCP,
 					TargetPort: intstr.FromInt32(80),
 				},
 			},
@@ -119,19 +182,14 @@ func (d *K8sDeployer) Deploy(ctx context.Context, build *types.Build) error {
 	}
 
 	// Apply service
-	_, err = d.k8sClient.CreateService(ctx, d.config.Namespace, service)
+	err = d.apply("service", service.Name, func(force bool) error {
+		_, err := d.k8sClient.ApplyService(ctx, d.config.Namespace, service, force)
+		return err
+	})
 	if err != nil {
-		if k8serrors.IsAlreadyExists(err) {
-			_, err = d.k8sClient.UpdateService(ctx, d.config.Namespace, service)
-			if err != nil {
-				return fmt.Errorf("failed to update service: %w", err)
-			}
-		} else {
-			return fmt.Errorf("failed to create service: %w", err)
-		}
+		return fmt.Errorf("failed to apply service: %w", err)
 	}
 
-	// Create ingress
 	ingress := &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      build.ProjectID,
@@ -168,71 +226,342 @@ func (d *K8sDeployer) Deploy(ctx context.Context, build *types.Build) error {
 	}
 
 	// Apply ingress
-	_, err = d.k8sClient.CreateIngress(ctx, d.config.Namespace, ingress)
+	err = d.apply("ingress", ingress.Name, func(force bool) error {
+		_, err := d.k8sClient.ApplyIngress(ctx, d.config.Namespace, ingress, force)
+		return err
+	})
 	if err != nil {
-		if k8serrors.IsAlreadyExists(err) {
-			_, err = d.k8sClient.UpdateIngress(ctx, d.config.Namespace, ingress)
-			if err != nil {
-				return fmt.Errorf("failed to update ingress: %w", err)
-			}
-		} else {
-			return fmt.Errorf("failed to create ingress: %w", err)
-		}
+		return fmt.Errorf("failed to apply ingress: %w", err)
 	}
 
-	return nil
+	if err := d.applyProcesses(ctx, build); err != nil {
+		return err
+	}
+	if err := d.applyCronJobs(ctx, build, env); err != nil {
+		return err
+	}
+	return d.waitForRollout(ctx, build)
 }
 
+// Rollback restores the deployment's previous revision like kubectl
+// rollout undo: the pod template of the newest ReplicaSet the deployment
+// owns below its current revision. The build that revision deployed is
+// recorded in build.RolledBackTo when known.
 func (d *K8sDeployer) Rollback(ctx context.Context, build *types.Build) error {
-	d.logger.Info("rolling back deployment",
-		zap.String("project", build.ProjectID))
+	buildlog.Logger(ctx, d.logger).Info("rolling back deployment")
 
-	// Get the deployment
 	deployment, err := d.k8sClient.GetDeployment(ctx, d.config.Namespace, build.ProjectID)
 	if err != nil {
 		return fmt.Errorf("failed to get deployment: %w", err)
 	}
+	if deployment.Spec.Paused {
+		return fmt.Errorf("deployment %s is paused", deployment.Name)
+	}
+
+	previous, err := d.previousRevision(ctx, deployment)
+	if err != nil {
+		return err
+	}
+
+	// The template hash is added by the deployment controller
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	deployment.Spec.Template = *template
 
-	// Initialize annotations map if nil
 	if deployment.Annotations == nil {
 		deployment.Annotations = make(map[string]string)
 	}
+	for key, value := range previous.Annotations {
+		if !controllerAnnotations[key] {
+			deployment.Annotations[key] = value
+		}
+	}
+	deployment.Annotations[changeCauseAnnotation] = rollbackCause(build, previous)
 
-	// Get deployment history
-	revisions, err := d.k8sClient.ListReplicaSets(ctx, d.config.Namespace, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app=%s", build.ProjectID),
-	})
+	// The update fails if the deployment changed since it was read
+	_, err = d.k8sClient.UpdateDeployment(ctx, d.config.Namespace, deployment)
 	if err != nil {
-		return fmt.Errorf("failed to get deployment history: %w", err)
+		return fmt.Errorf("failed to rollback deployment: %w", err)
 	}
 
-	if len(revisions.Items) <= 1 {
-		return fmt.Errorf("no previous revision available for rollback")
+	types.UpdateBuild(ctx, build, func(b *types.Build) {
+		b.RolledBackTo = previous.Annotations[buildAnnotation]
+	})
+	buildlog.Logger(ctx, d.logger).Info("rolled back deployment",
+		zap.String("revision", previous.Annotations[revisionAnnotation]),
+		zap.String("restored_build", previous.Annotations[buildAnnotation]))
+
+	if containers := template.Spec.Containers; len(containers) > 0 {
+		if err := d.restoreProcessImages(ctx, build.ProjectID, containers[0].Image); err != nil {
+			return err
+		}
+		return d.restoreJobImages(ctx, build.ProjectID, containers[0].Image)
 	}
+	return nil
+}
 
-	// Sort ReplicaSets by revision number
-	sort.Slice(revisions.Items, func(i, j int) bool {
-		iRev, _ := strconv.Atoi(revisions.Items[i].Annotations["deployment.kubernetes.io/revision"])
-		jRev, _ := strconv.Atoi(revisions.Items[j].Annotations["deployment.kubernetes.io/revision"])
-		return iRev > jRev
+// previousRevision returns the newest ReplicaSet controlled by deployment
+// with a revision below the deployment's current one. ReplicaSets are
+// matched by owner rather than labels so other workloads sharing the
+// labels are never restored.
+func (d *K8sDeployer) previousRevision(ctx context.Context, deployment *appsv1.Deployment) (*appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of deployment %s: %w", deployment.Name, err)
+	}
+	replicaSets, err := d.k8sClient.ListReplicaSets(ctx, d.config.Namespace, metav1.ListOptions{
+		LabelSelector: selector.String(),
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment history: %w", err)
+	}
 
-	// Get the previous revision (second most recent)
-	previousRevision := &revisions.Items[1]
+	var owned []*appsv1.ReplicaSet
+	current := revision(deployment.Annotations)
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		owner := metav1.GetControllerOf(rs)
+		if owner == nil || owner.Kind != "Deployment" || owner.Name != deployment.Name || owner.UID != deployment.UID {
+			continue
+		}
+		owned = append(owned, rs)
+		// Without a revision on the deployment the newest ReplicaSet is
+		// taken as the current one
+		if _, ok := deployment.Annotations[revisionAnnotation]; !ok {
+			current = max(current, revision(rs.Annotations))
+		}
+	}
+
+	var previous *appsv1.ReplicaSet
+	for _, rs := range owned {
+		rev := revision(rs.Annotations)
+		if rev > 0 && rev < current && (previous == nil || rev > revision(previous.Annotations)) {
+			previous = rs
+		}
+	}
+	if previous == nil {
+		return nil, fmt.Errorf("no revision of deployment %s before revision %d to roll back to", deployment.Name, current)
+	}
+	return previous, nil
+}
 
-	// Update deployment with previous container specs
-	deployment.Spec.Template.Spec.Containers = previousRevision.Spec.Template.Spec.Containers
-	deployment.Annotations["kubernetes.io/change-cause"] = "Rollback triggered by Chef"
+// revision parses the revision annotation of a deployment or ReplicaSet,
+// 0 when missing
+func revision(annotations map[string]string) int64 {
+	rev, _ := strconv.ParseInt(annotations[revisionAnnotation], 10, 64)
+	return rev
+}
 
-	// Apply the rollback
-	_, err = d.k8sClient.UpdateDeployment(ctx, d.config.Namespace, deployment)
+// rollbackCause names the failed build and the revision being restored.
+// ReplicaSets keep the change-cause of the deployment that created them.
+func rollbackCause(build *types.Build, previous *appsv1.ReplicaSet) string {
+	restored := previous.Annotations[changeCauseAnnotation]
+	if restored == "" {
+		restored = "revision " + previous.Annotations[revisionAnnotation]
+	}
+	return fmt.Sprintf("Rollback of %s, restoring %s", build.Describe(), restored)
+}
+
+// Restart triggers a rolling restart of the project's pods, equivalent to
+// kubectl rollout restart.
+func (d *K8sDeployer) Restart(ctx context.Context, projectID string) error {
+	deployment, err := d.k8sClient.GetDeployment(ctx, d.config.Namespace, projectID)
 	if err != nil {
-		return fmt.Errorf("failed to rollback deployment: %w", err)
+		return fmt.Errorf("failed to get deployment: %w", err)
+	}
+
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = make(map[string]string)
+	}
+	deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+
+	if _, err := d.k8sClient.UpdateDeployment(ctx, d.config.Namespace, deployment); err != nil {
+		return fmt.Errorf("failed to restart deployment: %w", err)
 	}
+	return nil
+}
+
+// Scale sets the replica count of the project's deployment
+func (d *K8sDeployer) Scale(ctx context.Context, projectID string, replicas int32) error {
+	deployment, err := d.k8sClient.GetDeployment(ctx, d.config.Namespace, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to get deployment: %w", err)
+	}
+
+	deployment.Spec.Replicas = &replicas
+	if _, err := d.k8sClient.UpdateDeployment(ctx, d.config.Namespace, deployment); err != nil {
+		return fmt.Errorf("failed to scale deployment: %w", err)
+	}
+	return nil
+}
+
+// replicas returns the replicas of the named deployment, so a deploy keeps
+// those set by Scale, or initial when it is not deployed yet
+func (d *K8sDeployer) replicas(ctx context.Context, name string, initial int32) (int32, error) {
+	deployment, err := d.k8sClient.GetDeployment(ctx, d.config.Namespace, name)
+	if k8serrors.IsNotFound(err) {
+		return initial, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get deployment %s: %w", name, err)
+	}
+	if deployment.Spec.Replicas == nil {
+		return initial, nil
+	}
+	return *deployment.Spec.Replicas, nil
+}
+
+// Probe checks that the cluster is reachable and lets the deployer list
+// deployments in its namespace and, with a registry configured, that the
+// server is logged in to it
+func (d *K8sDeployer) Probe(ctx context.Context) []Check {
+	check := Check{Name: "cluster", OK: true, Message: "cluster reachable"}
+	if _, err := d.k8sClient.ListDeployments(ctx, d.config.Namespace, metav1.ListOptions{Limit: 1}); err != nil {
+		check = Check{Name: "cluster", Message: fmt.Sprintf("cluster unreachable: %v", err)}
+	}
+	checks := []Check{check}
+	if d.config.Registry != "" {
+		checks = append(checks, registryCheck(d.config.Registry))
+	}
+	return checks
+}
+
+// Replicas returns the ready replicas of each project in the namespace,
+// counting all of its processes
+func (d *K8sDeployer) Replicas(ctx context.Context) (map[string]int32, error) {
+	deployments, err := d.k8sClient.ListDeployments(ctx, d.config.Namespace, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	replicas := make(map[string]int32, len(deployments.Items))
+	for _, deployment := range deployments.Items {
+		project := deployment.Name
+		if _, ok := deployment.Labels[processLabel]; ok {
+			project = deployment.Labels[projectLabel]
+		}
+		replicas[project] += deployment.Status.ReadyReplicas
+	}
+	return replicas, nil
+}
 
+// Remove deletes the project's cron jobs, processes, ingress, service and
+// deployment and waits until they are gone. Resources that are already gone
+// are skipped so a partially failed removal can be retried.
+func (d *K8sDeployer) Remove(ctx context.Context, projectID string, _ []string) error {
+	cronJobs, err := d.projectCronJobs(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	processes, err := d.processDeployments(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	var objects []teardown.Object
+	for _, cronJob := range cronJobs {
+		objects = append(objects, d.cronJobObject(cronJob.Name))
+	}
+	for _, deployment := range processes {
+		objects = append(objects, d.deploymentObject("process", deployment.Name))
+	}
+	objects = append(objects,
+		d.ingressObject(projectID),
+		d.serviceObject(projectID),
+		d.deploymentObject("deployment", projectID))
+
+	// Waiting lets a preview or project of the same name be deployed again
+	// right away and reports objects held by finalizers
+	if err := teardown.Delete(ctx, d.deleteTimeout(), objects...); err != nil {
+		return err
+	}
+
+	d.logger.Info("removed deployment resources", zap.String("project", projectID))
 	return nil
 }
 
+// containerEnv resolves the build's templated env vars at deploy time
+func (d *K8sDeployer) containerEnv(build *types.Build) ([]corev1.EnvVar, error) {
+	return d.renderEnv(build, build.EnvVars)
+}
+
+func (d *K8sDeployer) renderEnv(build *types.Build, vars map[string]string) ([]corev1.EnvVar, error) {
+	domain := fmt.Sprintf("%s.%s", build.ProjectID, d.config.IngressDomain)
+	rendered, err := envtemplate.Render(vars, envtemplate.NewData(build, domain))
+	if err != nil {
+		return nil, err
+	}
+
+	env := make([]corev1.EnvVar, 0, len(rendered))
+	for _, key := range envtemplate.SortedKeys(rendered) {
+		env = append(env, corev1.EnvVar{Name: key, Value: rendered[key]})
+	}
+	return env, nil
+}
+
+// addOnEnv injects the connection settings of the build's add-ons from
+// the secrets they were provisioned with
+func addOnEnv(build *types.Build) []corev1.EnvFromSource {
+	var sources []corev1.EnvFromSource
+	for _, kind := range build.AddOns {
+		sources = append(sources, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: addon.Name(build.ProjectID, kind)},
+			},
+		})
+	}
+	return sources
+}
+
+// validateNodeArchitectures ensures at least one cluster node can run one of
+// the architectures the image was built for.
+func (d *K8sDeployer) validateNodeArchitectures(ctx context.Context, build *types.Build) error {
+	if len(build.Platforms) == 0 {
+		return nil
+	}
+
+	nodes, err := d.k8sClient.ListNodes(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list cluster nodes: %w", err)
+	}
+
+	archs := types.PlatformArchitectures(build.Platforms)
+	for _, node := range nodes.Items {
+		for _, arch := range archs {
+			if node.Status.NodeInfo.Architecture == arch {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no cluster node can run image platforms %v", build.Platforms)
+}
+
+// architectureAffinity pins pods to nodes matching the built architectures
+func architectureAffinity(platforms []string) *corev1.Affinity {
+	if len(platforms) == 0 {
+		return nil
+	}
+
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      corev1.LabelArchStable,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   types.PlatformArchitectures(platforms),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func (d *K8sDeployer) Validate(build *types.Build) error {
 	if build.ProjectID == "" {
 		return fmt.Errorf("project ID is required for kubernetes deployment")
@@ -249,5 +578,18 @@ func (d *K8sDeployer) Validate(build *types.Build) error {
 	if d.config.ReplicaCount < 1 {
 		return fmt.Errorf("replica count must be at least 1")
 	}
+	if err := envtemplate.Validate(build.EnvVars); err != nil {
+		return err
+	}
+	for _, job := range build.Jobs {
+		if _, err := jobResources(job); err != nil {
+			return err
+		}
+	}
+	for _, process := range build.Processes {
+		if err := envtemplate.Validate(process.Env); err != nil {
+			return fmt.Errorf("process %s: %w", process.Name, err)
+		}
+	}
 	return nil
 }
@@ -3,17 +3,21 @@ package deployer
 import (
 	"context"
 	"fmt"
-	"strings"
 	"testing"
 
 	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/teardown"
 	"github.com/elskow/chef-infra/internal/pipeline/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 type testCase struct {
@@ -29,9 +33,11 @@ func TestK8sDeployer_Deploy(t *testing.T) {
 		{
 			name: "successful deployment",
 			build: &types.Build{
-				ID:        "test-app-1",
-				ProjectID: "test-app",
-				ImageID:   "test-image:latest",
+				ID:         "test-app-1",
+				ProjectID:  "test-app",
+				ImageID:    "test-image:latest",
+				CommitHash: "a1b2c3d4e5f6a7b8",
+				Commit:     &types.CommitInfo{Author: "Jane Doe", Message: "Fix checkout\n\nDetails", Branch: "main"},
 			},
 			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
 				assert.NoError(t, err)
@@ -40,6 +46,7 @@ func TestK8sDeployer_Deploy(t *testing.T) {
 				require.NoError(t, err)
 				assert.Equal(t, "test-app", deployment.Name)
 				assert.Equal(t, "test-image:latest", deployment.Spec.Template.Spec.Containers[0].Image)
+				assert.Equal(t, "Deploy a1b2c3d on main by Jane Doe: Fix checkout", deployment.Annotations["kubernetes.io/change-cause"])
 
 				// Verify service
 				svc, err := client.GetService(context.TODO(), "default", "test-app")
@@ -54,6 +61,72 @@ func TestK8sDeployer_Deploy(t *testing.T) {
 				assert.Equal(t, "test-app.test.local", ing.Spec.Rules[0].Host)
 			},
 		},
+		{
+			name: "add-ons",
+			build: &types.Build{
+				ID:        "test-app-3",
+				ProjectID: "test-app",
+				ImageID:   "test-image:v3",
+				AddOns:    []string{"postgres", "redis"},
+			},
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
+				assert.NoError(t, err)
+
+				deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
+				require.NoError(t, err)
+				envFrom := deployment.Spec.Template.Spec.Containers[0].EnvFrom
+				require.Len(t, envFrom, 2)
+				assert.Equal(t, "test-app-postgres", envFrom[0].SecretRef.Name)
+				assert.Equal(t, "test-app-redis", envFrom[1].SecretRef.Name)
+			},
+		},
+		{
+			name: "cron jobs",
+			build: &types.Build{
+				ID:        "test-app-4",
+				ProjectID: "test-app",
+				ImageID:   "test-image:v4",
+				AddOns:    []string{"postgres"},
+				Jobs: []types.Job{
+					{Name: "cleanup", Schedule: "0 3 * * *", Command: "node scripts/cleanup.js", Memory: "256Mi"},
+				},
+			},
+			setupMocks: func(d *K8sDeployer, client *TestK8sClient) {
+				stale := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{
+					Name:   "test-app-report",
+					Labels: jobLabels("test-app", "report"),
+				}}
+				_, err := client.CreateCronJob(context.TODO(), "default", stale)
+				require.NoError(t, err)
+			},
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
+				assert.NoError(t, err)
+
+				cronJob, err := client.GetCronJob(context.TODO(), "default", "test-app-cleanup")
+				require.NoError(t, err)
+				assert.Equal(t, "0 3 * * *", cronJob.Spec.Schedule)
+				assert.Equal(t, batchv1.ForbidConcurrent, cronJob.Spec.ConcurrencyPolicy)
+				container := cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0]
+				assert.Equal(t, "test-image:v4", container.Image)
+				assert.Equal(t, []string{"/bin/sh", "-c", "node scripts/cleanup.js"}, container.Command)
+				assert.Equal(t, "256Mi", container.Resources.Limits.Memory().String())
+				assert.Equal(t, "test-app-postgres", container.EnvFrom[0].SecretRef.Name)
+
+				// Jobs the build no longer defines are removed
+				_, err = client.GetCronJob(context.TODO(), "default", "test-app-report")
+				assert.True(t, k8serrors.IsNotFound(err))
+			},
+		},
+		{
+			name: "invalid job resources",
+			build: &types.Build{
+				ID:        "test-app-5",
+				ProjectID: "test-app",
+				ImageID:   "test-image:v5",
+				Jobs:      []types.Job{{Name: "cleanup", Schedule: "@daily", Command: "true", CPU: "lots"}},
+			},
+			expectError: true,
+		},
 		{
 			name: "update existing deployment",
 			build: &types.Build{
@@ -74,6 +147,79 @@ func TestK8sDeployer_Deploy(t *testing.T) {
 				assert.Equal(t, "test-image:v2", deployment.Spec.Template.Spec.Containers[0].Image)
 			},
 		},
+		{
+			name: "multi-arch image on matching nodes",
+			build: &types.Build{
+				ID:        "test-app-4",
+				ProjectID: "test-app",
+				ImageID:   "registry.local/chef-test-app:abc",
+				Platforms: []string{"linux/amd64", "linux/arm64"},
+			},
+			setupMocks: func(d *K8sDeployer, client *TestK8sClient) {
+				createTestNode(t, client, "node-arm", "arm64")
+			},
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
+				assert.NoError(t, err)
+
+				deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
+				require.NoError(t, err)
+				terms := deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+				assert.Equal(t, []string{"amd64", "arm64"}, terms[0].MatchExpressions[0].Values)
+			},
+		},
+		{
+			name: "no nodes for requested architecture",
+			build: &types.Build{
+				ID:        "test-app-5",
+				ProjectID: "test-app",
+				ImageID:   "test-image:arm",
+				Platforms: []string{"linux/arm64"},
+			},
+			setupMocks: func(d *K8sDeployer, client *TestK8sClient) {
+				createTestNode(t, client, "node-amd", "amd64")
+			},
+			expectError: true,
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "no cluster node can run image platforms")
+			},
+		},
+		{
+			name: "templated env vars",
+			build: &types.Build{
+				ID:          "test-app-6",
+				ProjectID:   "test-app",
+				ImageID:     "test-image:v3",
+				CommitHash:  "abc123",
+				Environment: "staging",
+				EnvVars: map[string]string{
+					"RELEASE":  "{{ .Build.CommitHash }}",
+					"SITE_URL": "https://{{ .Project.Domain }}",
+					"ENV_NAME": "{{ .Environment.Name }}",
+				},
+			},
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
+				assert.NoError(t, err)
+
+				deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
+				require.NoError(t, err)
+				assert.Equal(t, []corev1.EnvVar{
+					{Name: "ENV_NAME", Value: "staging"},
+					{Name: "RELEASE", Value: "abc123"},
+					{Name: "SITE_URL", Value: "https://test-app.test.local"},
+				}, deployment.Spec.Template.Spec.Containers[0].Env)
+			},
+		},
+		{
+			name: "unknown env template key",
+			build: &types.Build{
+				ID:        "test-app-7",
+				ProjectID: "test-app",
+				ImageID:   "test-image:v3",
+				EnvVars:   map[string]string{"TOKEN": "{{ .Secrets.Token }}"},
+			},
+			expectError: true,
+		},
 		{
 			name: "invalid build config",
 			build: &types.Build{
@@ -125,48 +271,97 @@ func TestK8sDeployer_Deploy(t *testing.T) {
 }
 
 func TestK8sDeployer_Rollback(t *testing.T) {
+	build := func() *types.Build {
+		return &types.Build{
+			ID:        "test-app-3",
+			ProjectID: "test-app",
+			ImageID:   "test-image:v3",
+		}
+	}
+	// setupHistory creates the deployment at its last revision with the
+	// ReplicaSets of the given revisions; missing ones were garbage-collected
+	setupHistory := func(revisions ...string) func(d *K8sDeployer, client *TestK8sClient) {
+		return func(d *K8sDeployer, client *TestK8sClient) {
+			current := revisions[len(revisions)-1]
+			deployment := createTestDeployment("test-app", "test-image:v"+current)
+			deployment.Annotations = map[string]string{
+				"deployment.kubernetes.io/revision": current,
+				changeCauseAnnotation:               "Deploy build test-app-" + current,
+				buildAnnotation:                     "test-app-" + current,
+			}
+			deployment, err := client.CreateDeployment(context.TODO(), "default", deployment)
+			require.NoError(t, err)
+
+			for _, revision := range revisions {
+				rs := createTestReplicaSet(deployment, "test-image:v"+revision, revision)
+				_, err = client.GetClientset().AppsV1().ReplicaSets("default").Create(context.TODO(), rs, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+		}
+	}
+
 	tests := []testCase{
 		{
-			name: "successful rollback",
-			build: &types.Build{
-				ID:        "test-app-1",
-				ProjectID: "test-app",
-				ImageID:   "test-image:v2",
+			name:       "successful rollback",
+			build:      build(),
+			setupMocks: setupHistory("1", "2", "3"),
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
+				require.NoError(t, err)
+
+				deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
+				require.NoError(t, err)
+				assert.Equal(t, "test-image:v2", deployment.Spec.Template.Spec.Containers[0].Image)
+				assert.NotContains(t, deployment.Spec.Template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+				assert.Equal(t, "Rollback of build test-app-3, restoring Deploy build test-app-2", deployment.Annotations[changeCauseAnnotation])
+				assert.Equal(t, "test-app-2", deployment.Annotations[buildAnnotation])
+				// The revision is left to the deployment controller
+				assert.Equal(t, "3", deployment.Annotations["deployment.kubernetes.io/revision"])
 			},
-			setupMocks: func(d *K8sDeployer, client *TestK8sClient) {
-				// Create initial deployment with initialized annotations
-				deployment := createTestDeployment("test-app", "test-image:v1")
-				revisionLimit := int32(5)
-				deployment.Spec.RevisionHistoryLimit = &revisionLimit
-				deployment.Annotations = map[string]string{
-					"kubernetes.io/change-cause": "Initial deployment",
-				}
-				_, err := client.CreateDeployment(context.TODO(), "default", deployment)
+		},
+		{
+			name:       "garbage-collected revisions are skipped",
+			build:      build(),
+			setupMocks: setupHistory("1", "3"),
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
 				require.NoError(t, err)
 
-				// Create ReplicaSet for v1
-				rs1 := createTestReplicaSet("test-app-v1", "test-image:v1", "1")
-				_, err = client.GetClientset().AppsV1().ReplicaSets("default").Create(context.TODO(), rs1, metav1.CreateOptions{})
+				deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
 				require.NoError(t, err)
+				assert.Equal(t, "test-image:v1", deployment.Spec.Template.Spec.Containers[0].Image)
+			},
+		},
+		{
+			name:  "replica sets of other owners are ignored",
+			build: build(),
+			setupMocks: func(d *K8sDeployer, client *TestK8sClient) {
+				setupHistory("1", "3")(d, client)
 
-				// Update to v2
-				deployment.Spec.Template.Spec.Containers[0].Image = "test-image:v2"
-				deployment.Annotations["kubernetes.io/change-cause"] = "Updated to v2"
-				_, err = client.UpdateDeployment(context.TODO(), "default", deployment)
+				// A ReplicaSet sharing the labels but owned elsewhere
+				canary := createTestDeployment("test-app-canary", "test-image:canary")
+				canary.Spec.Selector.MatchLabels["app"] = "test-app"
+				rs := createTestReplicaSet(canary, "test-image:canary", "2")
+				_, err := client.GetClientset().AppsV1().ReplicaSets("default").Create(context.TODO(), rs, metav1.CreateOptions{})
+				require.NoError(t, err)
+			},
+			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
 				require.NoError(t, err)
 
-				// Create ReplicaSet for v2
-				rs2 := createTestReplicaSet("test-app-v2", "test-image:v2", "2")
-				_, err = client.GetClientset().AppsV1().ReplicaSets("default").Create(context.TODO(), rs2, metav1.CreateOptions{})
+				deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
 				require.NoError(t, err)
+				assert.Equal(t, "test-image:v1", deployment.Spec.Template.Spec.Containers[0].Image)
 			},
+		},
+		{
+			name:       "no previous revision",
+			build:      build(),
+			setupMocks: setupHistory("1"),
 			validate: func(t *testing.T, d *K8sDeployer, client *TestK8sClient, err error) {
-				assert.NoError(t, err)
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "no revision of deployment test-app before revision 1")
 
 				deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
 				require.NoError(t, err)
 				assert.Equal(t, "test-image:v1", deployment.Spec.Template.Spec.Containers[0].Image)
-				assert.Contains(t, deployment.Annotations["kubernetes.io/change-cause"], "Rollback")
 			},
 		},
 	}
@@ -191,6 +386,12 @@ func TestK8sDeployer_Rollback(t *testing.T) {
 
 			err := deployer.Rollback(context.TODO(), tt.build)
 			tt.validate(t, deployer, testClient, err)
+			if err == nil {
+				// The build of the restored revision is recorded
+				deployment, err := testClient.GetDeployment(context.TODO(), "default", "test-app")
+				require.NoError(t, err)
+				assert.Equal(t, deployment.Annotations[buildAnnotation], tt.build.RolledBackTo)
+			}
 		})
 	}
 }
@@ -233,35 +434,221 @@ func createTestDeployment(name, image string) *appsv1.Deployment {
 	}
 }
 
-func createTestReplicaSet(name, image, revision string) *appsv1.ReplicaSet {
+func TestK8sDeployer_StreamLogs(t *testing.T) {
+	client := NewTestK8sClient()
+	deployer := &K8sDeployer{
+		config:    &config.DeployConfig{Namespace: "default"},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+
+	var lines []LogLine
+	collect := func(line LogLine) error {
+		lines = append(lines, line)
+		return nil
+	}
+
+	err := deployer.StreamLogs(context.TODO(), "test-app", LogOptions{Limit: 10}, collect)
+	assert.Error(t, err, "streaming without pods should fail")
+
+	for _, name := range []string{"test-app-a", "test-app-b"} {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"app": "test-app"},
+			},
+		}
+		_, err := client.GetClientset().CoreV1().Pods("default").Create(context.TODO(), pod, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	err = deployer.StreamLogs(context.TODO(), "test-app", LogOptions{Limit: 10, SinceSeconds: 60}, collect)
+	require.NoError(t, err)
+
+	// The fake clientset returns a single "fake logs" line per pod
+	require.Len(t, lines, 2)
+	sources := []string{lines[0].Source, lines[1].Source}
+	assert.ElementsMatch(t, []string{"test-app-a", "test-app-b"}, sources)
+	assert.Equal(t, "fake logs", lines[0].Line)
+
+	sendErr := fmt.Errorf("client disconnected")
+	err = deployer.StreamLogs(context.TODO(), "test-app", LogOptions{}, func(LogLine) error {
+		return sendErr
+	})
+	assert.ErrorIs(t, err, sendErr)
+}
+
+func TestK8sDeployer_ScaleAndRestart(t *testing.T) {
+	client := NewTestK8sClient()
+	deployer := &K8sDeployer{
+		config:    &config.DeployConfig{Namespace: "default"},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+
+	assert.Error(t, deployer.Scale(context.TODO(), "test-app", 3), "scaling a missing deployment should fail")
+
+	_, err := client.CreateDeployment(context.TODO(), "default", createTestDeployment("test-app", "test-image:v1"))
+	require.NoError(t, err)
+
+	require.NoError(t, deployer.Scale(context.TODO(), "test-app", 3))
+	require.NoError(t, deployer.Restart(context.TODO(), "test-app"))
+
+	deployment, err := client.GetDeployment(context.TODO(), "default", "test-app")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+	assert.NotEmpty(t, deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"])
+}
+
+func TestK8sDeployer_ApplyConflicts(t *testing.T) {
+	client := NewTestK8sClient()
+	deployer := &K8sDeployer{
+		config:    &config.DeployConfig{Namespace: "default", IngressDomain: "test.local", ReplicaCount: 1},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+	build := &types.Build{ID: "test-app-1", ProjectID: "test-app", ImageID: "test-image:v1"}
+	ctx := context.TODO()
+	require.NoError(t, deployer.Deploy(ctx, build))
+
+	// Fields set by other clients are kept
+	deployment, err := client.GetDeployment(ctx, "default", "test-app")
+	require.NoError(t, err)
+	deployment.Annotations["team"] = "web"
+	deployment, err = client.GetClientset().AppsV1().Deployments("default").Update(ctx, deployment, metav1.UpdateOptions{FieldManager: "kubectl-annotate"})
+	require.NoError(t, err)
+
+	build.ImageID = "test-image:v2"
+	require.NoError(t, deployer.Deploy(ctx, build))
+	deployment, err = client.GetDeployment(ctx, "default", "test-app")
+	require.NoError(t, err)
+	assert.Equal(t, "web", deployment.Annotations["team"])
+	assert.Equal(t, "test-image:v2", deployment.Spec.Template.Spec.Containers[0].Image)
+
+	// Scaling through chef-infra does not conflict with its own deploys,
+	// which keep the replicas
+	require.NoError(t, deployer.Scale(ctx, "test-app", 3))
+	require.NoError(t, deployer.Deploy(ctx, build))
+	deployment, err = client.GetDeployment(ctx, "default", "test-app")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+
+	// Fields another client changed are not overwritten
+	deployment.Spec.Template.Spec.Containers[0].Image = "test-image:hotfix"
+	_, err = client.GetClientset().AppsV1().Deployments("default").Update(ctx, deployment, metav1.UpdateOptions{FieldManager: "kubectl-edit"})
+	require.NoError(t, err)
+
+	err = deployer.Deploy(ctx, build)
+	assert.ErrorIs(t, err, ErrApplyConflict)
+	assert.Contains(t, err.Error(), ".image")
+	assert.Contains(t, err.Error(), `"kubectl-edit"`)
+
+	deployer.config.ForceApply = true
+	require.NoError(t, deployer.Deploy(ctx, build))
+	deployment, err = client.GetDeployment(ctx, "default", "test-app")
+	require.NoError(t, err)
+	assert.Equal(t, "test-image:v2", deployment.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+}
+
+func TestK8sDeployer_Replicas(t *testing.T) {
+	client := NewTestK8sClient()
+	deployer := &K8sDeployer{
+		config:    &config.DeployConfig{Namespace: "default"},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+
+	deployment := createTestDeployment("test-app", "test-image:v1")
+	deployment.Status.ReadyReplicas = 2
+	_, err := client.CreateDeployment(context.TODO(), "default", deployment)
+	require.NoError(t, err)
+
+	replicas, err := deployer.Replicas(context.TODO())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int32{"test-app": 2}, replicas)
+}
+
+func TestK8sDeployer_Remove(t *testing.T) {
+	client := NewTestK8sClient()
+	deployer := &K8sDeployer{
+		config:    &config.DeployConfig{Namespace: "default"},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+
+	_, err := client.CreateDeployment(context.TODO(), "default", createTestDeployment("test-app", "test-image:v1"))
+	require.NoError(t, err)
+	_, err = client.CreateService(context.TODO(), "default", &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test-app"}})
+	require.NoError(t, err)
+
+	// The ingress was never created; removal still succeeds
+	require.NoError(t, deployer.Remove(context.TODO(), "test-app", nil))
+
+	_, err = client.GetDeployment(context.TODO(), "default", "test-app")
+	assert.True(t, k8serrors.IsNotFound(err))
+	_, err = client.GetService(context.TODO(), "default", "test-app")
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	// Removing again is a no-op
+	assert.NoError(t, deployer.Remove(context.TODO(), "test-app", nil))
+
+	// A deployment held by a finalizer is reported once the timeout passes
+	deployer.config.DeleteTimeout = 1
+	deployment := createTestDeployment("test-app", "test-image:v1")
+	deployment.Finalizers = []string{"example.com/protect"}
+	_, err = client.CreateDeployment(context.TODO(), "default", deployment)
+	require.NoError(t, err)
+	client.GetClientset().PrependReactor("delete", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+	err = deployer.Remove(context.TODO(), "test-app", nil)
+	assert.ErrorIs(t, err, teardown.ErrStuck)
+	assert.Contains(t, err.Error(), "deployment test-app (finalizers: example.com/protect)")
+}
+
+func createTestNode(t *testing.T, client *TestK8sClient, name, arch string) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{Architecture: arch},
+		},
+	}
+	_, err := client.GetClientset().CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+// createTestReplicaSet creates the ReplicaSet of a revision of owner as the
+// deployment controller would
+func createTestReplicaSet(owner *appsv1.Deployment, image, revision string) *appsv1.ReplicaSet {
 	replicas := int32(1)
+	hash := "hash" + revision
+	labels := map[string]string{
+		"app":                                  owner.Spec.Selector.MatchLabels["app"],
+		appsv1.DefaultDeploymentUniqueLabelKey: hash,
+	}
 	return &appsv1.ReplicaSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-			Labels: map[string]string{
-				"app": strings.TrimSuffix(name, fmt.Sprintf("-v%s", revision)),
-			},
+			Name:   owner.Name + "-" + hash,
+			Labels: labels,
 			Annotations: map[string]string{
 				"deployment.kubernetes.io/revision": revision,
+				changeCauseAnnotation:               "Deploy build " + owner.Name + "-" + revision,
+				buildAnnotation:                     owner.Name + "-" + revision,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(owner, appsv1.SchemeGroupVersion.WithKind("Deployment")),
 			},
 		},
 		Spec: appsv1.ReplicaSetSpec{
 			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": strings.TrimSuffix(name, fmt.Sprintf("-v%s", revision)),
-				},
-			},
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": strings.TrimSuffix(name, fmt.Sprintf("-v%s", revision)),
-					},
-				},
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Name:  strings.TrimSuffix(name, fmt.Sprintf("-v%s", revision)),
+							Name:  owner.Name,
 							Image: image,
 						},
 					},
@@ -0,0 +1,330 @@
+package deployer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	// projectLabel marks the workloads of a project besides its web
+	// deployment, such as jobs and workers
+	projectLabel = "chef-infra/project"
+	jobNameLabel = "chef-infra/job"
+
+	jobContainer = "job"
+
+	// jobHistoryLimit is the number of finished runs kept per job and
+	// outcome, which bounds the run history
+	jobHistoryLimit = 10
+
+	// maxCronJobNameLength leaves room for the suffix of the Jobs a
+	// CronJob creates within the 63 characters of a label value
+	maxCronJobNameLength = 52
+
+	// instantiateAnnotation marks manually created runs, as kubectl
+	// create job --from does
+	instantiateAnnotation = "cronjob.kubernetes.io/instantiate"
+)
+
+// cronJobName is the name of the CronJob running a project's job
+func cronJobName(projectID, job string) string {
+	name := projectID + "-" + job
+	if len(name) <= maxCronJobNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	keep := maxCronJobNameLength - len(job) - 10
+	return fmt.Sprintf("%s-%s-%s", projectID[:keep], hex.EncodeToString(sum[:])[:8], job)
+}
+
+func jobLabels(projectID, job string) map[string]string {
+	return map[string]string{
+		projectLabel:                   projectID,
+		jobNameLabel:                   job,
+		"app.kubernetes.io/managed-by": "chef-infra",
+	}
+}
+
+// applyCronJobs applies a CronJob for each of the build's jobs
+// and deletes those of jobs the build no longer defines
+func (d *K8sDeployer) applyCronJobs(ctx context.Context, build *types.Build, env []corev1.EnvVar) error {
+	wanted := make(map[string]bool, len(build.Jobs))
+	for _, job := range build.Jobs {
+		cronJob, err := d.cronJob(build, job, env)
+		if err != nil {
+			return err
+		}
+		wanted[cronJob.Name] = true
+
+		err = d.apply("cron job", cronJob.Name, func(force bool) error {
+			_, err := d.k8sClient.ApplyCronJob(ctx, d.config.Namespace, cronJob, force)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply cron job %s: %w", job.Name, err)
+		}
+	}
+
+	existing, err := d.projectCronJobs(ctx, build.ProjectID)
+	if err != nil {
+		return err
+	}
+	for _, cronJob := range existing {
+		if wanted[cronJob.Name] {
+			continue
+		}
+		if err := d.k8sClient.DeleteCronJob(ctx, d.config.Namespace, cronJob.Name); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete cron job %s: %w", cronJob.Name, err)
+		}
+		d.logger.Info("removed cron job",
+			zap.String("project", build.ProjectID),
+			zap.String("job", cronJob.Labels[jobNameLabel]))
+	}
+	return nil
+}
+
+func (d *K8sDeployer) cronJob(build *types.Build, job types.Job, env []corev1.EnvVar) (*batchv1.CronJob, error) {
+	resources, err := jobResources(job)
+	if err != nil {
+		return nil, err
+	}
+	labels := jobLabels(build.ProjectID, job.Name)
+	timeZone := "Etc/UTC"
+	historyLimit := int32(jobHistoryLimit)
+	// Each run gets a single attempt so failures show in the history
+	backoffLimit := int32(0)
+
+	return &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cronJobName(build.ProjectID, job.Name),
+			Namespace: d.config.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				changeCauseAnnotation: "Deploy " + build.Describe(),
+			},
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   job.Schedule,
+			TimeZone:                   &timeZone,
+			ConcurrencyPolicy:          batchv1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: &historyLimit,
+			FailedJobsHistoryLimit:     &historyLimit,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: batchv1.JobSpec{
+					BackoffLimit: &backoffLimit,
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{
+							RestartPolicy: corev1.RestartPolicyNever,
+							Affinity:      architectureAffinity(build.Platforms),
+							Containers: []corev1.Container{
+								{
+									Name:      jobContainer,
+									Image:     build.ImageID,
+									Command:   []string{"/bin/sh", "-c", job.Command},
+									Env:       env,
+									EnvFrom:   addOnEnv(build),
+									Resources: resources,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// jobResources requests and limits the CPU and memory a job asks for
+func jobResources(job types.Job) (corev1.ResourceRequirements, error) {
+	list := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    job.CPU,
+		corev1.ResourceMemory: job.Memory,
+	} {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return corev1.ResourceRequirements{}, fmt.Errorf("job %s has an invalid %s %q: %w", job.Name, name, value, err)
+		}
+		list[name] = quantity
+	}
+	if len(list) == 0 {
+		return corev1.ResourceRequirements{}, nil
+	}
+	return corev1.ResourceRequirements{Requests: list, Limits: list}, nil
+}
+
+// restoreJobImages points the project's CronJobs at the image a rollback
+// restored
+func (d *K8sDeployer) restoreJobImages(ctx context.Context, projectID, image string) error {
+	cronJobs, err := d.projectCronJobs(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	for i := range cronJobs {
+		cronJob := &cronJobs[i]
+		containers := cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers
+		for j := range containers {
+			containers[j].Image = image
+		}
+		if _, err := d.k8sClient.UpdateCronJob(ctx, d.config.Namespace, cronJob); err != nil {
+			return fmt.Errorf("failed to roll back cron job %s: %w", cronJob.Name, err)
+		}
+	}
+	return nil
+}
+
+// JobRuns returns the runs Kubernetes keeps for a job, newest first
+func (d *K8sDeployer) JobRuns(ctx context.Context, projectID, job string) ([]types.JobRun, error) {
+	if _, err := d.getCronJob(ctx, projectID, job); err != nil {
+		return nil, err
+	}
+	jobs, err := d.k8sClient.ListJobs(ctx, d.config.Namespace, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", projectLabel, projectID, jobNameLabel, job),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs of %s: %w", job, err)
+	}
+
+	runs := make([]types.JobRun, 0, len(jobs.Items))
+	for i := range jobs.Items {
+		runs = append(runs, jobRun(&jobs.Items[i]))
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+// TriggerJob creates a run from the job's CronJob, like kubectl create
+// job --from. Concurrency rules of the schedule do not apply to it.
+func (d *K8sDeployer) TriggerJob(ctx context.Context, projectID, job string) (*types.JobRun, error) {
+	cronJob, err := d.getCronJob(ctx, projectID, job)
+	if err != nil {
+		return nil, err
+	}
+
+	isController := true
+	run := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-%d", cronJob.Name, time.Now().Unix()),
+			Namespace:   d.config.Namespace,
+			Labels:      cronJob.Spec.JobTemplate.Labels,
+			Annotations: map[string]string{instantiateAnnotation: "manual"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "CronJob",
+				Name:       cronJob.Name,
+				UID:        cronJob.UID,
+				Controller: &isController,
+			}},
+		},
+		Spec: *cronJob.Spec.JobTemplate.Spec.DeepCopy(),
+	}
+	created, err := d.k8sClient.CreateJob(ctx, d.config.Namespace, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", job, err)
+	}
+
+	d.logger.Info("triggered job",
+		zap.String("project", projectID),
+		zap.String("job", job),
+		zap.String("run", created.Name))
+	result := jobRun(created)
+	return &result, nil
+}
+
+// StreamJobLogs sends the output of a run's pods
+func (d *K8sDeployer) StreamJobLogs(ctx context.Context, projectID, runID string, opts LogOptions, send func(LogLine) error) error {
+	run, err := d.k8sClient.GetJob(ctx, d.config.Namespace, runID)
+	if k8serrors.IsNotFound(err) || err == nil && run.Labels[projectLabel] != projectID {
+		return fmt.Errorf("%w: no run %s", ErrJobNotFound, runID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get run %s: %w", runID, err)
+	}
+
+	pods, err := d.k8sClient.ListPods(ctx, d.config.Namespace, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", runID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("run %s has not started a pod", runID)
+	}
+	return d.streamPodLogs(ctx, pods.Items, jobContainer, opts, send)
+}
+
+func (d *K8sDeployer) projectCronJobs(ctx context.Context, projectID string) ([]batchv1.CronJob, error) {
+	list, err := d.k8sClient.ListCronJobs(ctx, d.config.Namespace, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", projectLabel, projectID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cron jobs: %w", err)
+	}
+	return list.Items, nil
+}
+
+func (d *K8sDeployer) getCronJob(ctx context.Context, projectID, job string) (*batchv1.CronJob, error) {
+	cronJob, err := d.k8sClient.GetCronJob(ctx, d.config.Namespace, cronJobName(projectID, job))
+	if k8serrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s has no job %s", ErrJobNotFound, projectID, job)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cron job: %w", err)
+	}
+	return cronJob, nil
+}
+
+func jobRun(job *batchv1.Job) types.JobRun {
+	run := types.JobRun{
+		ID:        job.Name,
+		Job:       job.Labels[jobNameLabel],
+		Status:    types.JobRunPending,
+		Manual:    job.Annotations[instantiateAnnotation] == "manual",
+		StartedAt: job.CreationTimestamp.Time,
+	}
+	if job.Status.StartTime != nil {
+		run.StartedAt = job.Status.StartTime.Time
+	}
+	if job.Status.Active > 0 {
+		run.Status = types.JobRunRunning
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			run.Status = types.JobRunSucceeded
+		case batchv1.JobFailed:
+			run.Status = types.JobRunFailed
+		default:
+			continue
+		}
+		finished := condition.LastTransitionTime.Time
+		if job.Status.CompletionTime != nil {
+			finished = job.Status.CompletionTime.Time
+		}
+		run.FinishedAt = &finished
+	}
+	return run
+}
@@ -0,0 +1,122 @@
+package deployer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestK8sDeployer_Jobs(t *testing.T) {
+	client := NewTestK8sClient()
+	deployer := &K8sDeployer{
+		config: &config.DeployConfig{
+			Namespace:     "default",
+			IngressDomain: "test.local",
+			ReplicaCount:  1,
+		},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+	ctx := context.TODO()
+
+	build := &types.Build{
+		ID:        "test-app-1",
+		ProjectID: "test-app",
+		ImageID:   "test-image:v1",
+		Jobs:      []types.Job{{Name: "cleanup", Schedule: "@daily", Command: "node cleanup.js"}},
+	}
+	require.NoError(t, deployer.Deploy(ctx, build))
+
+	runs, err := deployer.JobRuns(ctx, "test-app", "cleanup")
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+
+	_, err = deployer.JobRuns(ctx, "test-app", "report")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+	_, err = deployer.TriggerJob(ctx, "other-app", "cleanup")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	// A scheduled run that finished an hour ago
+	started := metav1.NewTime(time.Now().Add(-time.Hour))
+	finished := metav1.NewTime(started.Add(time.Minute))
+	scheduled := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-app-cleanup-28000000", Labels: jobLabels("test-app", "cleanup")},
+		Status: batchv1.JobStatus{
+			StartTime:      &started,
+			CompletionTime: &finished,
+			Conditions:     []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+		},
+	}
+	_, err = client.CreateJob(ctx, "default", scheduled)
+	require.NoError(t, err)
+
+	run, err := deployer.TriggerJob(ctx, "test-app", "cleanup")
+	require.NoError(t, err)
+	assert.True(t, run.Manual)
+	assert.Equal(t, "cleanup", run.Job)
+	assert.Equal(t, types.JobRunPending, run.Status)
+
+	created, err := client.GetJob(ctx, "default", run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "test-image:v1", created.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "test-app-cleanup", created.OwnerReferences[0].Name)
+
+	now := metav1.Now()
+	created.Status = batchv1.JobStatus{StartTime: &now, Active: 1}
+	_, err = client.GetClientset().BatchV1().Jobs("default").UpdateStatus(ctx, created, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	runs, err = deployer.JobRuns(ctx, "test-app", "cleanup")
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, run.ID, runs[0].ID, "newest run first")
+	assert.Equal(t, types.JobRunRunning, runs[0].Status)
+	assert.Equal(t, types.JobRunSucceeded, runs[1].Status)
+	require.NotNil(t, runs[1].FinishedAt)
+	assert.Equal(t, finished.Unix(), runs[1].FinishedAt.Unix())
+
+	var lines []LogLine
+	collect := func(line LogLine) error {
+		lines = append(lines, line)
+		return nil
+	}
+	err = deployer.StreamJobLogs(ctx, "test-app", run.ID, LogOptions{}, collect)
+	assert.Error(t, err, "runs without pods have no logs")
+	assert.ErrorIs(t, deployer.StreamJobLogs(ctx, "other-app", run.ID, LogOptions{}, collect), ErrJobNotFound)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:   run.ID + "-abcde",
+		Labels: map[string]string{"job-name": run.ID},
+	}}
+	_, err = client.GetClientset().CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, deployer.StreamJobLogs(ctx, "test-app", run.ID, LogOptions{}, collect))
+	require.Len(t, lines, 1)
+	assert.Equal(t, pod.Name, lines[0].Source)
+
+	require.NoError(t, deployer.Remove(ctx, "test-app", nil))
+	_, err = client.GetCronJob(ctx, "default", "test-app-cleanup")
+	assert.True(t, k8serrors.IsNotFound(err))
+}
+
+func TestCronJobName(t *testing.T) {
+	assert.Equal(t, "shop-cleanup", cronJobName("shop", "cleanup"))
+
+	long := strings.Repeat("a", 60)
+	name := cronJobName(long, "cleanup")
+	assert.Len(t, name, maxCronJobNameLength)
+	assert.True(t, strings.HasSuffix(name, "-cleanup"))
+	assert.NotEqual(t, name, cronJobName(long+"b", "cleanup"))
+}
@@ -0,0 +1,217 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// processLabel names the process of a Deployment and its pods. The web
+// process keeps the app label it always had.
+const processLabel = "chef-infra/process"
+
+// processName is the name of the Deployment running a project's process
+func processName(projectID, process string) string {
+	return projectID + "-" + process
+}
+
+func processLabels(projectID, process string) map[string]string {
+	return map[string]string{
+		projectLabel:                   projectID,
+		processLabel:                   process,
+		"app.kubernetes.io/managed-by": "chef-infra",
+	}
+}
+
+// applyProcesses applies a Deployment for each of the build's
+// processes besides web and deletes those of processes the build no
+// longer declares
+func (d *K8sDeployer) applyProcesses(ctx context.Context, build *types.Build) error {
+	wanted := make(map[string]bool, len(build.Processes))
+	for _, process := range build.Processes {
+		deployment, err := d.processDeployment(build, process)
+		if err != nil {
+			return err
+		}
+		// Processes scaled by ScaleProcess keep their replicas
+		replicas, err := d.replicas(ctx, deployment.Name, process.Replicas)
+		if err != nil {
+			return err
+		}
+		deployment.Spec.Replicas = &replicas
+		wanted[deployment.Name] = true
+
+		err = d.apply("deployment", deployment.Name, func(force bool) error {
+			_, err := d.k8sClient.ApplyDeployment(ctx, d.config.Namespace, deployment, force)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply process %s: %w", process.Name, err)
+		}
+	}
+
+	existing, err := d.processDeployments(ctx, build.ProjectID)
+	if err != nil {
+		return err
+	}
+	for _, deployment := range existing {
+		if wanted[deployment.Name] {
+			continue
+		}
+		if err := d.k8sClient.DeleteDeployment(ctx, d.config.Namespace, deployment.Name); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete process %s: %w", deployment.Labels[processLabel], err)
+		}
+		d.logger.Info("removed process",
+			zap.String("project", build.ProjectID),
+			zap.String("process", deployment.Labels[processLabel]))
+	}
+	return nil
+}
+
+func (d *K8sDeployer) processDeployment(build *types.Build, process types.Process) (*appsv1.Deployment, error) {
+	vars := make(map[string]string, len(build.EnvVars)+len(process.Env))
+	for key, value := range build.EnvVars {
+		vars[key] = value
+	}
+	for key, value := range process.Env {
+		vars[key] = value
+	}
+	env, err := d.renderEnv(build, vars)
+	if err != nil {
+		return nil, fmt.Errorf("process %s: %w", process.Name, err)
+	}
+
+	labels := processLabels(build.ProjectID, process.Name)
+	replicas := process.Replicas
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      processName(build.ProjectID, process.Name),
+			Namespace: d.config.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				changeCauseAnnotation: "Deploy " + build.Describe(),
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Affinity: architectureAffinity(build.Platforms),
+					Containers: []corev1.Container{
+						{
+							Name:    process.Name,
+							Image:   build.ImageID,
+							Command: []string{"/bin/sh", "-c", process.Command},
+							Env:     env,
+							EnvFrom: addOnEnv(build),
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// processDeployments returns the Deployments of a project's processes
+// besides web
+func (d *K8sDeployer) processDeployments(ctx context.Context, projectID string) ([]appsv1.Deployment, error) {
+	list, err := d.k8sClient.ListDeployments(ctx, d.config.Namespace, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s", projectLabel, projectID, processLabel),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+	return list.Items, nil
+}
+
+// restoreProcessImages points the project's processes at the image a
+// rollback restored
+func (d *K8sDeployer) restoreProcessImages(ctx context.Context, projectID, image string) error {
+	deployments, err := d.processDeployments(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	for i := range deployments {
+		deployment := &deployments[i]
+		containers := deployment.Spec.Template.Spec.Containers
+		for j := range containers {
+			containers[j].Image = image
+		}
+		if _, err := d.k8sClient.UpdateDeployment(ctx, d.config.Namespace, deployment); err != nil {
+			return fmt.Errorf("failed to roll back process %s: %w", deployment.Labels[processLabel], err)
+		}
+	}
+	return nil
+}
+
+// Processes reports the web process and the project's other processes
+func (d *K8sDeployer) Processes(ctx context.Context, projectID string) ([]types.ProcessStatus, error) {
+	web, err := d.k8sClient.GetDeployment(ctx, d.config.Namespace, projectID)
+	if k8serrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s is not deployed", ErrProcessNotFound, projectID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	deployments, err := d.processDeployments(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].Labels[processLabel] < deployments[j].Labels[processLabel]
+	})
+
+	statuses := []types.ProcessStatus{processStatus(types.WebProcess, web)}
+	for i := range deployments {
+		statuses = append(statuses, processStatus(deployments[i].Labels[processLabel], &deployments[i]))
+	}
+	return statuses, nil
+}
+
+// ScaleProcess sets the replicas of a process; web scales the project's
+// main deployment
+func (d *K8sDeployer) ScaleProcess(ctx context.Context, projectID, process string, replicas int32) error {
+	if process == "" || process == types.WebProcess {
+		return d.Scale(ctx, projectID, replicas)
+	}
+	deployment, err := d.k8sClient.GetDeployment(ctx, d.config.Namespace, processName(projectID, process))
+	if k8serrors.IsNotFound(err) || err == nil && deployment.Labels[processLabel] != process {
+		return fmt.Errorf("%w: %s has no process %s", ErrProcessNotFound, projectID, process)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get process %s: %w", process, err)
+	}
+
+	deployment.Spec.Replicas = &replicas
+	if _, err := d.k8sClient.UpdateDeployment(ctx, d.config.Namespace, deployment); err != nil {
+		return fmt.Errorf("failed to scale process %s: %w", process, err)
+	}
+	return nil
+}
+
+func processStatus(name string, deployment *appsv1.Deployment) types.ProcessStatus {
+	status := types.ProcessStatus{
+		Name:          name,
+		ReadyReplicas: deployment.Status.ReadyReplicas,
+	}
+	if deployment.Spec.Replicas != nil {
+		status.Replicas = *deployment.Spec.Replicas
+	}
+	if containers := deployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+		status.Image = containers[0].Image
+		if name != types.WebProcess && len(containers[0].Command) == 3 {
+			status.Command = containers[0].Command[2]
+		}
+	}
+	return status
+}
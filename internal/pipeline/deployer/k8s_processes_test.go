@@ -0,0 +1,97 @@
+package deployer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestK8sDeployer_Processes(t *testing.T) {
+	client := NewTestK8sClient()
+	deployer := &K8sDeployer{
+		config: &config.DeployConfig{
+			Namespace:     "default",
+			IngressDomain: "test.local",
+			ReplicaCount:  1,
+		},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+	ctx := context.TODO()
+
+	build := &types.Build{
+		ID:        "test-app-1",
+		ProjectID: "test-app",
+		ImageID:   "test-image:v1",
+		EnvVars:   map[string]string{"QUEUE": "default", "LOG_LEVEL": "info"},
+		Processes: []types.Process{
+			{Name: "worker", Command: "node worker.js", Replicas: 2, Env: map[string]string{"QUEUE": "emails"}},
+			{Name: "clock", Command: "node clock.js", Replicas: 1},
+		},
+	}
+	require.NoError(t, deployer.Validate(build))
+	require.NoError(t, deployer.Deploy(ctx, build))
+
+	worker, err := client.GetDeployment(ctx, "default", "test-app-worker")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), *worker.Spec.Replicas)
+	container := worker.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "test-image:v1", container.Image)
+	assert.Equal(t, []string{"/bin/sh", "-c", "node worker.js"}, container.Command)
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "QUEUE", Value: "emails"})
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "LOG_LEVEL", Value: "info"})
+
+	// Workers receive no traffic
+	_, err = client.GetService(ctx, "default", "test-app-worker")
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	require.NoError(t, deployer.ScaleProcess(ctx, "test-app", "worker", 5))
+	require.NoError(t, deployer.ScaleProcess(ctx, "test-app", types.WebProcess, 3))
+	assert.ErrorIs(t, deployer.ScaleProcess(ctx, "test-app", "mailer", 1), ErrProcessNotFound)
+
+	processes, err := deployer.Processes(ctx, "test-app")
+	require.NoError(t, err)
+	require.Len(t, processes, 3)
+	assert.Equal(t, types.ProcessStatus{Name: "web", Image: "test-image:v1", Replicas: 3}, processes[0])
+	assert.Equal(t, "clock", processes[1].Name)
+	assert.Equal(t, types.ProcessStatus{Name: "worker", Command: "node worker.js", Image: "test-image:v1", Replicas: 5}, processes[2])
+
+	_, err = deployer.Processes(ctx, "other-app")
+	assert.ErrorIs(t, err, ErrProcessNotFound)
+
+	// Ready replicas of every process count towards the project
+	worker, err = client.GetDeployment(ctx, "default", "test-app-worker")
+	require.NoError(t, err)
+	worker.Status.ReadyReplicas = 5
+	_, err = client.GetClientset().AppsV1().Deployments("default").UpdateStatus(ctx, worker, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	replicas, err := deployer.Replicas(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int32{"test-app": 5}, replicas)
+
+	// Dropping a process from chef.yaml removes its deployment
+	build.Processes = build.Processes[:1]
+	require.NoError(t, deployer.Deploy(ctx, build))
+	_, err = client.GetDeployment(ctx, "default", "test-app-clock")
+	assert.True(t, k8serrors.IsNotFound(err))
+
+	// Deploys keep the replicas processes were scaled to
+	processes, err = deployer.Processes(ctx, "test-app")
+	require.NoError(t, err)
+	require.Len(t, processes, 2)
+	assert.Equal(t, int32(3), processes[0].Replicas)
+	assert.Equal(t, int32(5), processes[1].Replicas)
+
+	require.NoError(t, deployer.Remove(ctx, "test-app", nil))
+	_, err = client.GetDeployment(ctx, "default", "test-app-worker")
+	assert.True(t, k8serrors.IsNotFound(err))
+}
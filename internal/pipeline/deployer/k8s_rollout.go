@@ -0,0 +1,331 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// ErrRolloutFailed is returned when the new pods of a deploy do not become
+// available
+var ErrRolloutFailed = errors.New("rollout did not finish")
+
+// stuckReasons are container waiting reasons that keep a rollout from
+// progressing until something changes
+var stuckReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// pullReasons are the stuck reasons of containers whose image cannot be
+// pulled. Deploys fail on them at once since they rarely resolve before
+// the rollout times out.
+var pullReasons = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+	"InvalidImageName": true,
+}
+
+// ImagePullError is a pod of a deploy that cannot pull its image
+type ImagePullError struct {
+	Pod    string
+	Image  string
+	Reason string // Waiting reason of the container, e.g. ImagePullBackOff
+	// Message is why the pull failed, from the pod's events when the
+	// kubelet recorded them
+	Message string
+	// Statuses and Events describe the pod's containers and its warning
+	// events, oldest first
+	Statuses []string
+	Events   []string
+}
+
+func (e *ImagePullError) Error() string {
+	return fmt.Sprintf("pod %s: %s (%s)", e.Pod, e.Message, e.Reason)
+}
+
+// Details returns the container statuses and events of the pod
+func (e *ImagePullError) Details() []string {
+	return append(slices.Clone(e.Statuses), e.Events...)
+}
+
+// podProblem is why a pod is not becoming ready
+type podProblem struct {
+	reason  string
+	message string
+}
+
+// rolloutWatcher records updates of a deployment and the pods of a build
+// as build events, each change once
+type rolloutWatcher struct {
+	build     *types.Build
+	progress  string
+	scheduled map[string]bool
+	problems  map[string]podProblem
+}
+
+// waitForRollout watches the build's web deployment and pods until the new
+// pods are available, when the deploy target waits for rollouts
+func (d *K8sDeployer) waitForRollout(ctx context.Context, build *types.Build) error {
+	if d.config.RolloutTimeout <= 0 {
+		return nil
+	}
+	timeout := time.Duration(d.config.RolloutTimeout) * time.Second
+	watchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	watchDeployment := func() (watch.Interface, error) {
+		return d.k8sClient.WatchDeployment(watchCtx, d.config.Namespace, build.ProjectID)
+	}
+	watchPods := func() (watch.Interface, error) {
+		return d.k8sClient.WatchPods(watchCtx, d.config.Namespace, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("app=%s", build.ProjectID),
+		})
+	}
+	deployments, err := watchDeployment()
+	if err != nil {
+		return fmt.Errorf("failed to watch deployment: %w", err)
+	}
+	defer func() { deployments.Stop() }()
+	pods, err := watchPods()
+	if err != nil {
+		return fmt.Errorf("failed to watch pods: %w", err)
+	}
+	defer func() { pods.Stop() }()
+
+	w := &rolloutWatcher{
+		build:     build,
+		scheduled: make(map[string]bool),
+		problems:  make(map[string]podProblem),
+	}
+	for {
+		select {
+		case <-watchCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w within %s: %s", ErrRolloutFailed, timeout, w.describe())
+
+		case event, ok := <-deployments.ResultChan():
+			// The API server ends watches after a while
+			if !ok {
+				if deployments, err = rewatch(watchCtx, deployments, watchDeployment); err != nil {
+					return fmt.Errorf("failed to watch deployment: %w", err)
+				}
+				continue
+			}
+			deployment, isDeployment := event.Object.(*appsv1.Deployment)
+			if !isDeployment || deployment.Name != build.ProjectID || event.Type == watch.Deleted {
+				continue
+			}
+			done, err := w.deploymentChanged(ctx, deployment)
+			if err != nil || done {
+				if done {
+					buildlog.Logger(ctx, d.logger).Info("rollout complete")
+				}
+				return err
+			}
+
+		case event, ok := <-pods.ResultChan():
+			if !ok {
+				if pods, err = rewatch(watchCtx, pods, watchPods); err != nil {
+					return fmt.Errorf("failed to watch pods: %w", err)
+				}
+				continue
+			}
+			pod, isPod := event.Object.(*corev1.Pod)
+			if !isPod || pod.Annotations[buildAnnotation] != build.ID {
+				continue
+			}
+			if event.Type == watch.Deleted {
+				delete(w.problems, pod.Name)
+				continue
+			}
+			w.podChanged(ctx, pod)
+			if pullReasons[w.problems[pod.Name].reason] {
+				return fmt.Errorf("%w: %w", ErrRolloutFailed, d.imagePullError(watchCtx, pod))
+			}
+		}
+	}
+}
+
+// rewatch replaces a watch the server ended. Once ctx is done the
+// replacement receives nothing, leaving the caller to return on ctx. On
+// error the stopped watch is returned.
+func rewatch(ctx context.Context, ended watch.Interface, open func() (watch.Interface, error)) (watch.Interface, error) {
+	ended.Stop()
+	if ctx.Err() != nil {
+		return watch.NewFake(), nil
+	}
+	w, err := open()
+	if err != nil {
+		return ended, err
+	}
+	return w, nil
+}
+
+// deploymentChanged reports the rollout's progress and whether it is
+// complete, with the checks of kubectl rollout status
+func (w *rolloutWatcher) deploymentChanged(ctx context.Context, deployment *appsv1.Deployment) (bool, error) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, nil
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("%w: %s; %s", ErrRolloutFailed, condition.Message, w.describe())
+		}
+	}
+
+	wanted := int32(1)
+	if deployment.Spec.Replicas != nil {
+		wanted = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	progress := fmt.Sprintf("%d of %d replicas updated, %d available", status.UpdatedReplicas, wanted, status.AvailableReplicas)
+	if progress != w.progress {
+		w.progress = progress
+		types.RecordEvent(ctx, w.build, types.EventRolloutProgress, "", progress)
+	}
+
+	if status.UpdatedReplicas < wanted || status.Replicas > status.UpdatedReplicas || status.AvailableReplicas < status.UpdatedReplicas {
+		return false, nil
+	}
+	types.RecordEvent(ctx, w.build, types.EventRolloutComplete, "", "")
+	return true, nil
+}
+
+func (w *rolloutWatcher) podChanged(ctx context.Context, pod *corev1.Pod) {
+	if pod.Spec.NodeName != "" && !w.scheduled[pod.Name] {
+		w.scheduled[pod.Name] = true
+		types.RecordEvent(ctx, w.build, types.EventPodScheduled, pod.Name, pod.Spec.NodeName)
+	}
+
+	problem, found := problemOf(pod)
+	if !found {
+		delete(w.problems, pod.Name)
+		return
+	}
+	if w.problems[pod.Name].reason != problem.reason {
+		types.RecordEvent(ctx, w.build, types.EventPodProblem, pod.Name, problem.String())
+	}
+	w.problems[pod.Name] = problem
+}
+
+// problemOf returns why pod cannot be scheduled or its containers cannot
+// start, if it is one of the reasons rollouts get stuck on
+func problemOf(pod *corev1.Pod) (podProblem, bool) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+			return podProblem{reason: condition.Reason, message: condition.Message}, true
+		}
+	}
+	statuses := append(slices.Clone(pod.Status.InitContainerStatuses), pod.Status.ContainerStatuses...)
+	for _, container := range statuses {
+		waiting := container.State.Waiting
+		if waiting == nil || !stuckReasons[waiting.Reason] {
+			continue
+		}
+		message := waiting.Message
+		if terminated := container.LastTerminationState.Terminated; waiting.Reason == "CrashLoopBackOff" && terminated != nil {
+			message = fmt.Sprintf("container %s exited with code %d (%s), restarted %d times",
+				container.Name, terminated.ExitCode, terminated.Reason, container.RestartCount)
+		}
+		return podProblem{reason: waiting.Reason, message: message}, true
+	}
+	return podProblem{}, false
+}
+
+func (p podProblem) String() string {
+	if p.message == "" {
+		return p.reason
+	}
+	return p.reason + ": " + p.message
+}
+
+// describe summarizes the rollout's last progress and the pods' problems
+func (w *rolloutWatcher) describe() string {
+	parts := []string{"no progress reported"}
+	if w.progress != "" {
+		parts[0] = w.progress
+	}
+	for _, pod := range slices.Sorted(maps.Keys(w.problems)) {
+		parts = append(parts, fmt.Sprintf("pod %s: %s", pod, w.problems[pod]))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// imagePullError describes a pod failing to pull its image with its
+// container statuses and warning events
+func (d *K8sDeployer) imagePullError(ctx context.Context, pod *corev1.Pod) *ImagePullError {
+	pullErr := &ImagePullError{Pod: pod.Name}
+	statuses := append(slices.Clone(pod.Status.InitContainerStatuses), pod.Status.ContainerStatuses...)
+	for _, container := range statuses {
+		state := "running"
+		switch {
+		case container.State.Waiting != nil:
+			waiting := container.State.Waiting
+			state = fmt.Sprintf("waiting (%s): %s", waiting.Reason, waiting.Message)
+			if pullErr.Reason == "" && pullReasons[waiting.Reason] {
+				pullErr.Image = container.Image
+				pullErr.Reason = waiting.Reason
+				pullErr.Message = fmt.Sprintf("Failed to pull image %q: %s", container.Image, waiting.Message)
+			}
+		case container.State.Terminated != nil:
+			state = fmt.Sprintf("terminated (%s)", container.State.Terminated.Reason)
+		}
+		pullErr.Statuses = append(pullErr.Statuses, fmt.Sprintf("container %s: %s", container.Name, state))
+	}
+
+	// Field selectors narrow the list on a real API server
+	events, err := d.k8sClient.ListEvents(ctx, d.config.Namespace, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=Pod,involvedObject.name=%s", pod.Name),
+	})
+	if err != nil {
+		buildlog.Logger(ctx, d.logger).Warn("failed to list pod events",
+			zap.String("pod", pod.Name),
+			zap.Error(err))
+		return pullErr
+	}
+	items := slices.DeleteFunc(events.Items, func(event corev1.Event) bool {
+		return event.InvolvedObject.Name != pod.Name || event.Type != corev1.EventTypeWarning
+	})
+	slices.SortStableFunc(items, func(a, b corev1.Event) int {
+		return eventTime(a).Compare(eventTime(b))
+	})
+	for _, event := range items {
+		pullErr.Events = append(pullErr.Events, fmt.Sprintf("%s: %s", event.Reason, event.Message))
+		if strings.HasPrefix(event.Message, "Failed to pull image") {
+			pullErr.Message = event.Message
+		}
+	}
+	return pullErr
+}
+
+// eventTime is when an event last occurred
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,195 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// newRolloutTest returns a deployer waiting for rollouts and the watches
+// it will receive, buffered so updates can be queued before it watches
+func newRolloutTest() (*K8sDeployer, *watch.FakeWatcher, *watch.FakeWatcher) {
+	client := NewTestK8sClient()
+	deployments := watch.NewFakeWithChanSize(10, false)
+	pods := watch.NewFakeWithChanSize(10, false)
+	client.GetClientset().PrependWatchReactor("deployments", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, deployments, nil
+	})
+	client.GetClientset().PrependWatchReactor("pods", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, pods, nil
+	})
+	deployer := &K8sDeployer{
+		config:    &config.DeployConfig{Namespace: "default", RolloutTimeout: 1},
+		logger:    zap.NewNop(),
+		k8sClient: client,
+	}
+	return deployer, deployments, pods
+}
+
+func rolloutDeployment(updated, available int32, conditions ...appsv1.DeploymentCondition) *appsv1.Deployment {
+	deployment := createTestDeployment("test-app", "test-image:v2")
+	deployment.Spec.Replicas = &[]int32{2}[0]
+	deployment.Status = appsv1.DeploymentStatus{
+		Replicas:          2,
+		UpdatedReplicas:   updated,
+		AvailableReplicas: available,
+		Conditions:        conditions,
+	}
+	return deployment
+}
+
+func rolloutPod(name, buildID string, waiting *corev1.ContainerStateWaiting) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{"app": "test-app"},
+			Annotations: map[string]string{buildAnnotation: buildID},
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "test-app", State: corev1.ContainerState{Waiting: waiting}},
+			},
+		},
+	}
+}
+
+func eventsOf(build *types.Build, eventType types.DeploymentEventType) []types.DeploymentEvent {
+	var events []types.DeploymentEvent
+	for _, event := range build.Events {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestK8sDeployer_WaitForRollout(t *testing.T) {
+	build := &types.Build{ID: "test-app-2", ProjectID: "test-app", ImageID: "test-image:v2"}
+
+	t.Run("complete", func(t *testing.T) {
+		deployer, deployments, _ := newRolloutTest()
+		deployments.Add(rolloutDeployment(1, 0))
+		deployments.Modify(rolloutDeployment(1, 0))
+		deployments.Modify(rolloutDeployment(2, 2))
+
+		build := *build
+		require.NoError(t, deployer.waitForRollout(context.Background(), &build))
+		progress := eventsOf(&build, types.EventRolloutProgress)
+		require.Len(t, progress, 2, "unchanged progress is reported once")
+		assert.Equal(t, "1 of 2 replicas updated, 0 available", progress[0].Message)
+		assert.Equal(t, "2 of 2 replicas updated, 2 available", progress[1].Message)
+		assert.Len(t, eventsOf(&build, types.EventRolloutComplete), 1)
+	})
+
+	t.Run("unschedulable", func(t *testing.T) {
+		deployer, deployments, pods := newRolloutTest()
+		deployments.Add(rolloutDeployment(1, 0))
+		pod := rolloutPod("test-app-abc", "test-app-2", nil)
+		pod.Spec.NodeName = ""
+		pod.Status.Conditions = []corev1.PodCondition{{
+			Type:    corev1.PodScheduled,
+			Status:  corev1.ConditionFalse,
+			Reason:  corev1.PodReasonUnschedulable,
+			Message: "0/3 nodes are available: 3 Insufficient memory.",
+		}}
+		pods.Add(pod)
+		pods.Modify(pod)
+		// Pods of the previous build are not reported
+		pods.Add(rolloutPod("test-app-old", "test-app-1", &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}))
+
+		build := *build
+		err := deployer.waitForRollout(context.Background(), &build)
+		assert.ErrorIs(t, err, ErrRolloutFailed)
+		assert.Contains(t, err.Error(), "1 of 2 replicas updated, 0 available; pod test-app-abc: Unschedulable: 0/3 nodes are available: 3 Insufficient memory.")
+
+		problems := eventsOf(&build, types.EventPodProblem)
+		require.Len(t, problems, 1)
+		assert.Equal(t, "test-app-abc", problems[0].Hook)
+		assert.Empty(t, eventsOf(&build, types.EventPodScheduled))
+	})
+
+	t.Run("image pull failure", func(t *testing.T) {
+		deployer, deployments, pods := newRolloutTest()
+		client := deployer.k8sClient.(*TestK8sClient)
+		for i, message := range []string{
+			`Pulling image "test-image:v2"`,
+			`Failed to pull image "test-image:v2": failed to authorize: failed to fetch anonymous token: unexpected status: 401 Unauthorized`,
+			"Error: ErrImagePull",
+		} {
+			eventType := corev1.EventTypeWarning
+			if i == 0 {
+				eventType = corev1.EventTypeNormal
+			}
+			_, err := client.GetClientset().CoreV1().Events("default").Create(context.TODO(), &corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: fmt.Sprintf("test-app-abc.%d", i)},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "test-app-abc"},
+				Type:           eventType,
+				Reason:         "Failed",
+				Message:        message,
+				LastTimestamp:  metav1.NewTime(time.Unix(int64(1000+i), 0)),
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+		}
+		deployments.Add(rolloutDeployment(1, 0))
+		pod := rolloutPod("test-app-abc", "test-app-2", &corev1.ContainerStateWaiting{
+			Reason:  "ImagePullBackOff",
+			Message: `Back-off pulling image "test-image:v2"`,
+		})
+		pod.Status.ContainerStatuses[0].Image = "test-image:v2"
+		pods.Add(pod)
+
+		// Fails before the rollout timeout
+		build := *build
+		start := time.Now()
+		err := deployer.waitForRollout(context.Background(), &build)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.ErrorIs(t, err, ErrRolloutFailed)
+
+		var pullErr *ImagePullError
+		require.ErrorAs(t, err, &pullErr)
+		assert.Equal(t, "test-image:v2", pullErr.Image)
+		assert.Equal(t, "ImagePullBackOff", pullErr.Reason)
+		assert.Contains(t, pullErr.Message, "401 Unauthorized")
+		assert.Equal(t, []string{
+			`container test-app: waiting (ImagePullBackOff): Back-off pulling image "test-image:v2"`,
+			`Failed: Failed to pull image "test-image:v2": failed to authorize: failed to fetch anonymous token: unexpected status: 401 Unauthorized`,
+			"Failed: Error: ErrImagePull",
+		}, pullErr.Details())
+	})
+
+	t.Run("progress deadline exceeded", func(t *testing.T) {
+		deployer, deployments, pods := newRolloutTest()
+		pods.Add(rolloutPod("test-app-abc", "test-app-2", &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}))
+		deployments.Add(rolloutDeployment(1, 0, appsv1.DeploymentCondition{
+			Type:    appsv1.DeploymentProgressing,
+			Status:  corev1.ConditionFalse,
+			Reason:  "ProgressDeadlineExceeded",
+			Message: `ReplicaSet "test-app-abc" has timed out progressing.`,
+		}))
+
+		build := *build
+		err := deployer.waitForRollout(context.Background(), &build)
+		assert.ErrorIs(t, err, ErrRolloutFailed)
+		assert.Contains(t, err.Error(), "has timed out progressing")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		deployer, _, _ := newRolloutTest()
+		deployer.config.RolloutTimeout = 0
+		assert.NoError(t, deployer.waitForRollout(context.Background(), build))
+	})
+}
@@ -0,0 +1,116 @@
+package deployer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// StreamLogs tails the logs of every pod belonging to the project's current
+// deployment, or to the process opts selects. Lines from different pods
+// are interleaved as they arrive.
+func (d *K8sDeployer) StreamLogs(ctx context.Context, projectID string, opts LogOptions, send func(LogLine) error) error {
+	selector, container := fmt.Sprintf("app=%s", projectID), projectID
+	if opts.Process != "" && opts.Process != types.WebProcess {
+		selector = fmt.Sprintf("%s=%s,%s=%s", projectLabel, projectID, processLabel, opts.Process)
+		container = opts.Process
+	}
+	pods, err := d.k8sClient.ListPods(ctx, d.config.Namespace, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("no running pods for project %s", projectID)
+	}
+	return d.streamPodLogs(ctx, pods.Items, container, opts, send)
+}
+
+// streamPodLogs tails a container of each pod, interleaving the lines as
+// they arrive
+func (d *K8sDeployer) streamPodLogs(ctx context.Context, pods []corev1.Pod, container string, opts LogOptions, send func(LogLine) error) error {
+	podOpts := &corev1.PodLogOptions{
+		Container: container,
+		Follow:    opts.Follow,
+	}
+	if opts.SinceSeconds > 0 {
+		podOpts.SinceSeconds = &opts.SinceSeconds
+	}
+	if opts.Limit > 0 {
+		podOpts.TailLines = &opts.Limit
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for _, pod := range pods {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			stream, err := d.k8sClient.StreamPodLogs(ctx, d.config.Namespace, name, podOpts)
+			if err != nil {
+				fail(fmt.Errorf("failed to stream logs for pod %s: %w", name, err))
+				return
+			}
+			defer stream.Close()
+
+			scanner := bufio.NewScanner(stream)
+			for scanner.Scan() {
+				mu.Lock()
+				err := send(LogLine{Source: name, Line: scanner.Text()})
+				mu.Unlock()
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+			if err := scanner.Err(); err != nil && ctx.Err() == nil {
+				fail(fmt.Errorf("failed to read logs for pod %s: %w", name, err))
+			}
+		}(pod.Name)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// Exec runs a command in the first running pod of the project's deployment
+func (d *K8sDeployer) Exec(ctx context.Context, projectID string, opts ExecOptions) error {
+	pods, err := d.k8sClient.ListPods(ctx, d.config.Namespace, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=%s", projectID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		return d.k8sClient.ExecInPod(ctx, d.config.Namespace, pod.Name, projectID, opts)
+	}
+
+	return fmt.Errorf("no running pods for project %s", projectID)
+}
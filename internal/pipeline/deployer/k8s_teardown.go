@@ -0,0 +1,70 @@
+package deployer
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/teardown"
+)
+
+func (d *K8sDeployer) deleteTimeout() time.Duration {
+	return time.Duration(d.config.DeleteTimeout) * time.Second
+}
+
+// deploymentObject is a deployment to delete, kind names it in errors
+func (d *K8sDeployer) deploymentObject(kind, name string) teardown.Object {
+	return teardown.Object{
+		Kind: kind,
+		Name: name,
+		Delete: func(ctx context.Context) error {
+			return d.k8sClient.DeleteDeployment(ctx, d.config.Namespace, name)
+		},
+		Get: teardown.Getter(func(ctx context.Context) (*appsv1.Deployment, error) {
+			return d.k8sClient.GetDeployment(ctx, d.config.Namespace, name)
+		}),
+	}
+}
+
+func (d *K8sDeployer) serviceObject(name string) teardown.Object {
+	return teardown.Object{
+		Kind: "service",
+		Name: name,
+		Delete: func(ctx context.Context) error {
+			return d.k8sClient.DeleteService(ctx, d.config.Namespace, name)
+		},
+		Get: teardown.Getter(func(ctx context.Context) (*corev1.Service, error) {
+			return d.k8sClient.GetService(ctx, d.config.Namespace, name)
+		}),
+	}
+}
+
+func (d *K8sDeployer) ingressObject(name string) teardown.Object {
+	return teardown.Object{
+		Kind: "ingress",
+		Name: name,
+		Delete: func(ctx context.Context) error {
+			return d.k8sClient.DeleteIngress(ctx, d.config.Namespace, name)
+		},
+		Get: teardown.Getter(func(ctx context.Context) (*networkingv1.Ingress, error) {
+			return d.k8sClient.GetIngress(ctx, d.config.Namespace, name)
+		}),
+	}
+}
+
+func (d *K8sDeployer) cronJobObject(name string) teardown.Object {
+	return teardown.Object{
+		Kind: "cron job",
+		Name: name,
+		Delete: func(ctx context.Context) error {
+			return d.k8sClient.DeleteCronJob(ctx, d.config.Namespace, name)
+		},
+		Get: teardown.Getter(func(ctx context.Context) (*batchv1.CronJob, error) {
+			return d.k8sClient.GetCronJob(ctx, d.config.Namespace, name)
+		}),
+	}
+}
@@ -0,0 +1,20 @@
+package deployer
+
+import "context"
+
+type LogOptions struct {
+	SinceSeconds int64  // Only return logs newer than this many seconds
+	Limit        int64  // Number of most recent lines per instance, 0 for all
+	Follow       bool   // Keep streaming new lines until the context is cancelled
+	Process      string // Process to read, the web process when empty
+}
+
+type LogLine struct {
+	Source string // Pod or container the line came from
+	Line   string
+}
+
+// LogStreamer is implemented by deployers whose workloads produce runtime logs
+type LogStreamer interface {
+	StreamLogs(ctx context.Context, projectID string, opts LogOptions, send func(LogLine) error) error
+}
@@ -0,0 +1,177 @@
+package deployer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNetlifyAPI = "https://api.netlify.com/api/v1"
+
+	// maxProviderErrorBody caps the error responses read from providers
+	maxProviderErrorBody = 64 * 1024
+)
+
+// netlify deploys sites with Netlify's zip deploy API. Production deploys
+// are published right away, other environments become draft deploys with
+// a unique URL.
+type netlify struct {
+	endpoint string
+	http     *http.Client
+}
+
+func newNetlify(endpoint string) *netlify {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if endpoint == "" {
+		endpoint = defaultNetlifyAPI
+	}
+	return &netlify{endpoint: endpoint, http: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+type netlifyDeploy struct {
+	ID           string `json:"id"`
+	State        string `json:"state"` // e.g. "uploaded", "processing", "ready" or "error"
+	Context      string `json:"context"`
+	SSLURL       string `json:"ssl_url"`        // Of the site
+	DeploySSLURL string `json:"deploy_ssl_url"` // Of this deploy
+	ErrorMessage string `json:"error_message"`
+}
+
+func (d *netlifyDeploy) deployment() *providerDeployment {
+	deployURL := d.DeploySSLURL
+	if d.Context == productionEnvironment && d.SSLURL != "" {
+		deployURL = d.SSLURL
+	}
+	return &providerDeployment{
+		ID:      d.ID,
+		URL:     deployURL,
+		Status:  d.State,
+		Message: d.ErrorMessage,
+		Live:    d.State == "ready",
+		Failed:  d.State == "error" || d.State == "rejected",
+	}
+}
+
+func (n *netlify) Production(ctx context.Context, token, site string) (string, error) {
+	var out struct {
+		PublishedDeploy *struct {
+			ID string `json:"id"`
+		} `json:"published_deploy"`
+	}
+	if err := n.call(ctx, token, http.MethodGet, "/sites/"+url.PathEscape(site), "", nil, &out); err != nil {
+		return "", err
+	}
+	if out.PublishedDeploy == nil {
+		return "", nil
+	}
+	return out.PublishedDeploy.ID, nil
+}
+
+func (n *netlify) Publish(ctx context.Context, token, site, environment, dir string) (*providerDeployment, error) {
+	archive, err := zipDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive site: %w", err)
+	}
+	path := "/sites/" + url.PathEscape(site) + "/deploys"
+	if environment != productionEnvironment {
+		path += "?" + url.Values{"draft": {"true"}, "branch": {environment}}.Encode()
+	}
+
+	var deploy netlifyDeploy
+	if err := n.call(ctx, token, http.MethodPost, path, "application/zip", archive, &deploy); err != nil {
+		return nil, err
+	}
+	return deploy.deployment(), nil
+}
+
+func (n *netlify) Get(ctx context.Context, token, _, id string) (*providerDeployment, error) {
+	var deploy netlifyDeploy
+	if err := n.call(ctx, token, http.MethodGet, "/deploys/"+url.PathEscape(id), "", nil, &deploy); err != nil {
+		return nil, err
+	}
+	return deploy.deployment(), nil
+}
+
+func (n *netlify) Restore(ctx context.Context, token, site, id string) error {
+	path := "/sites/" + url.PathEscape(site) + "/deploys/" + url.PathEscape(id) + "/restore"
+	return n.call(ctx, token, http.MethodPost, path, "", nil, nil)
+}
+
+func (n *netlify) call(ctx context.Context, token, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, n.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := n.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("netlify %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxProviderErrorBody))
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("netlify %s %s: %s: %s", method, path, resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("netlify %s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode netlify response: %w", err)
+	}
+	return nil
+}
+
+// zipDir archives the regular files below dir
+func zipDir(dir string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
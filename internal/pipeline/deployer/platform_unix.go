@@ -0,0 +1,14 @@
+//go:build !windows
+
+package deployer
+
+const (
+	defaultStaticPath = "/var/www/html"
+
+	// defaultTerraformManifestDir is kept out of the static path so the
+	// manifests are not served
+	defaultTerraformManifestDir = "/var/lib/chef-infra/terraform"
+
+	// symlinksSupported reports whether extracted archives may contain symlinks
+	symlinksSupported = true
+)
@@ -0,0 +1,15 @@
+//go:build windows
+
+package deployer
+
+const (
+	defaultStaticPath = `C:\inetpub\wwwroot`
+
+	// defaultTerraformManifestDir is kept out of the static path so the
+	// manifests are not served
+	defaultTerraformManifestDir = `C:\ProgramData\chef-infra\terraform`
+
+	// symlinksSupported is false because creating symlinks on Windows requires
+	// elevated privileges or developer mode; symlink entries are skipped.
+	symlinksSupported = false
+)
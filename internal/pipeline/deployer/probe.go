@@ -0,0 +1,73 @@
+package deployer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Check is the outcome of checking something a deploy target relies on
+type Check struct {
+	Name    string // e.g. "cluster", "static_path" or "registry"
+	OK      bool
+	Message string
+}
+
+// Prober is implemented by deployers that can check they are able to
+// deploy without deploying anything
+type Prober interface {
+	Probe(ctx context.Context) []Check
+}
+
+// SizeLimiter is implemented by deployers refusing artifacts above a size
+type SizeLimiter interface {
+	MaxDeploySize() int64 // Bytes
+}
+
+// registryCheck reports whether the server is logged in to the registry
+// images are pushed to
+func registryCheck(registry string) Check {
+	host := strings.SplitN(registry, "/", 2)[0]
+	if !HasDockerCredentials(host) {
+		return Check{Name: "registry", Message: fmt.Sprintf("no docker login found for %s", host)}
+	}
+	return Check{Name: "registry", OK: true, Message: fmt.Sprintf("docker login found for %s", host)}
+}
+
+// HasDockerCredentials looks for a login entry in the docker CLI config
+func HasDockerCredentials(host string) bool {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return false
+	}
+
+	var dockerConfig struct {
+		Auths       map[string]json.RawMessage `json:"auths"`
+		CredsStore  string                     `json:"credsStore"`
+		CredHelpers map[string]string          `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &dockerConfig); err != nil {
+		return false
+	}
+
+	if _, ok := dockerConfig.Auths[host]; ok {
+		return true
+	}
+	if _, ok := dockerConfig.Auths["https://"+host]; ok {
+		return true
+	}
+	_, ok := dockerConfig.CredHelpers[host]
+	return ok
+}
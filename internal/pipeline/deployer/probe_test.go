@@ -0,0 +1,56 @@
+package deployer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestHasDockerCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	assert.False(t, HasDockerCredentials("registry.example.com"), "no docker config")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+		"auths": {"https://registry.example.com": {}},
+		"credHelpers": {"123.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}
+	}`), 0600))
+	assert.True(t, HasDockerCredentials("registry.example.com"))
+	assert.True(t, HasDockerCredentials("123.dkr.ecr.us-east-1.amazonaws.com"))
+	assert.False(t, HasDockerCredentials("ghcr.io"))
+}
+
+func TestK8sDeployer_Probe(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	deployer := &K8sDeployer{
+		config:    &config.DeployConfig{Namespace: "default", Registry: "registry.example.com/apps"},
+		logger:    zap.NewNop(),
+		k8sClient: NewTestK8sClient(),
+	}
+
+	checks := deployer.Probe(context.Background())
+	require.Len(t, checks, 2)
+	assert.Equal(t, Check{Name: "cluster", OK: true, Message: "cluster reachable"}, checks[0])
+	assert.Equal(t, Check{Name: "registry", Message: "no docker login found for registry.example.com"}, checks[1])
+}
+
+func TestStaticDeployer_Probe(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sites")
+	deployer := NewStaticDeployer(&config.DeployConfig{StaticPath: dir}, zap.NewNop())
+
+	checks := deployer.Probe(context.Background())
+	require.Len(t, checks, 1)
+	assert.True(t, checks[0].OK, checks[0].Message)
+	assert.Equal(t, int64(defaultMaxDeploySize), deployer.MaxDeploySize())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the probe leaves nothing behind")
+}
@@ -0,0 +1,10 @@
+package deployer
+
+import "context"
+
+// Remover is implemented by deployers that can tear down everything they
+// created for a project. buildIDs lists the project's known builds so
+// per-build artifacts such as backups can be removed as well.
+type Remover interface {
+	Remove(ctx context.Context, projectID string, buildIDs []string) error
+}
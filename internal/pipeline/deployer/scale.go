@@ -0,0 +1,36 @@
+package deployer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// ErrProcessNotFound is returned for processes the project does not run
+var ErrProcessNotFound = errors.New("process not found")
+
+// Restarter is implemented by deployers that can restart a running workload
+type Restarter interface {
+	Restart(ctx context.Context, projectID string) error
+}
+
+// Scaler is implemented by deployers that can change a workload's replicas
+type Scaler interface {
+	Scale(ctx context.Context, projectID string, replicas int32) error
+}
+
+// ReplicaCounter is implemented by deployers that can report the replicas
+// running for each deployed workload
+type ReplicaCounter interface {
+	Replicas(ctx context.Context) (map[string]int32, error)
+}
+
+// ProcessManager is implemented by deployers that run the processes of a
+// project, such as workers, as separate workloads next to the web process
+type ProcessManager interface {
+	// Processes returns the web process followed by the others by name
+	Processes(ctx context.Context, projectID string) ([]types.ProcessStatus, error)
+	// ScaleProcess sets the replicas of one process
+	ScaleProcess(ctx context.Context, projectID, process string, replicas int32) error
+}
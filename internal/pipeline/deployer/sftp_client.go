@@ -0,0 +1,316 @@
+package deployer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP version 3 packet types, see draft-ietf-secsh-filexfer-02
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpAttrs    = 105
+	sftpExtended = 200
+)
+
+const (
+	sftpStatusOK     = 0
+	sftpStatusEOF    = 1
+	sftpStatusNoFile = 2
+
+	sftpFlagRead     = 0x01
+	sftpFlagWrite    = 0x02
+	sftpFlagCreate   = 0x08
+	sftpFlagTruncate = 0x10
+
+	sftpPosixRename = "posix-rename@openssh.com"
+
+	// sftpMaxPacketData is the largest read or write all servers accept
+	sftpMaxPacketData = 32 * 1024
+)
+
+// sftpClient is a minimal SFTP client covering what uploads need. Requests
+// are sent one at a time; uploads run concurrently over several clients.
+type sftpClient struct {
+	conn        *ssh.Client
+	in          io.WriteCloser
+	out         io.Reader
+	nextID      uint32
+	posixRename bool // Server renames over existing files
+}
+
+func dialSFTP(ctx context.Context, host string, clientConfig *ssh.ClientConfig) (*sftpClient, error) {
+	conn, err := dialSSH(ctx, host, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newSFTPClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func newSFTPClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ssh session: %w", err)
+	}
+	in, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, fmt.Errorf("failed to start sftp: %w", err)
+	}
+
+	c := &sftpClient{conn: conn, in: in, out: out}
+	if err := c.send(sftpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return nil, err
+	}
+	typ, data, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpVersion || len(data) < 4 {
+		return nil, fmt.Errorf("unexpected sftp handshake")
+	}
+	// Extensions follow the version as name and data pairs
+	for rest := data[4:]; len(rest) > 0; {
+		var name, value []byte
+		if name, rest, err = sftpString(rest); err != nil {
+			break
+		}
+		if value, rest, err = sftpString(rest); err != nil {
+			break
+		}
+		if string(name) == sftpPosixRename && string(value) == "1" {
+			c.posixRename = true
+		}
+	}
+	return c, nil
+}
+
+func (c *sftpClient) MkdirAll(dir string) error {
+	for _, parent := range remoteDirs(dir) {
+		if _, err := c.request(sftpStat, sftpStrings(parent)); err == nil {
+			continue
+		}
+		// An empty attribute set follows the path
+		if _, err := c.request(sftpMkdir, append(sftpStrings(parent), 0, 0, 0, 0)); err != nil {
+			return fmt.Errorf("mkdir %s: %w", parent, err)
+		}
+	}
+	return nil
+}
+
+func (c *sftpClient) WriteFile(name string, r io.Reader) error {
+	handle, err := c.open(name, sftpFlagWrite|sftpFlagCreate|sftpFlagTruncate)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, sftpMaxPacketData)
+	var offset uint64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			payload := sftpStrings(string(handle))
+			payload = binary.BigEndian.AppendUint64(payload, offset)
+			payload = append(payload, sftpStrings(string(buf[:n]))...)
+			if _, err := c.request(sftpWrite, payload); err != nil {
+				c.closeHandle(handle)
+				return fmt.Errorf("write %s: %w", name, err)
+			}
+			offset += uint64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			c.closeHandle(handle)
+			return readErr
+		}
+	}
+	return c.closeHandle(handle)
+}
+
+func (c *sftpClient) ReadFile(name string) ([]byte, error) {
+	handle, err := c.open(name, sftpFlagRead)
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeHandle(handle)
+
+	var content []byte
+	for {
+		payload := sftpStrings(string(handle))
+		payload = binary.BigEndian.AppendUint64(payload, uint64(len(content)))
+		payload = binary.BigEndian.AppendUint32(payload, sftpMaxPacketData)
+		data, err := c.request(sftpRead, payload)
+		if errors.Is(err, io.EOF) {
+			return content, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		chunk, _, err := sftpString(data)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, chunk...)
+	}
+}
+
+// Rename replaces to with from. Servers without POSIX renames refuse to
+// rename over a file, so to is removed first there.
+func (c *sftpClient) Rename(from, to string) error {
+	if c.posixRename {
+		payload := append(sftpStrings(sftpPosixRename), sftpStrings(from, to)...)
+		if _, err := c.request(sftpExtended, payload); err != nil {
+			return fmt.Errorf("rename %s: %w", from, err)
+		}
+		return nil
+	}
+	if err := c.Remove(to); err != nil && !errors.Is(err, errRemoteNotExist) {
+		return err
+	}
+	if _, err := c.request(sftpRename, sftpStrings(from, to)); err != nil {
+		return fmt.Errorf("rename %s: %w", from, err)
+	}
+	return nil
+}
+
+func (c *sftpClient) Remove(name string) error {
+	if _, err := c.request(sftpRemove, sftpStrings(name)); err != nil {
+		return fmt.Errorf("remove %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *sftpClient) Close() error {
+	c.in.Close()
+	return c.conn.Close()
+}
+
+func (c *sftpClient) open(name string, flags uint32) ([]byte, error) {
+	payload := sftpStrings(name)
+	payload = binary.BigEndian.AppendUint32(payload, flags)
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	data, err := c.request(sftpOpen, payload)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", name, err)
+	}
+	handle, _, err := sftpString(data)
+	return handle, err
+}
+
+func (c *sftpClient) closeHandle(handle []byte) error {
+	_, err := c.request(sftpClose, sftpStrings(string(handle)))
+	return err
+}
+
+// request sends a packet and returns the payload of its reply after the
+// request ID. Status replies other than OK are returned as errors, EOF as
+// io.EOF and missing files as errRemoteNotExist.
+func (c *sftpClient) request(typ byte, payload []byte) ([]byte, error) {
+	c.nextID++
+	id := c.nextID
+	if err := c.send(typ, append(binary.BigEndian.AppendUint32(nil, id), payload...)); err != nil {
+		return nil, err
+	}
+	replyType, data, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != id {
+		return nil, fmt.Errorf("unexpected sftp reply")
+	}
+	data = data[4:]
+
+	switch replyType {
+	case sftpStatus:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("malformed sftp status")
+		}
+		code := binary.BigEndian.Uint32(data)
+		message, _, _ := sftpString(data[4:])
+		switch code {
+		case sftpStatusOK:
+			return nil, nil
+		case sftpStatusEOF:
+			return nil, io.EOF
+		case sftpStatusNoFile:
+			return nil, errRemoteNotExist
+		}
+		return nil, fmt.Errorf("sftp status %d: %s", code, message)
+	case sftpHandle, sftpData, sftpAttrs:
+		return data, nil
+	}
+	return nil, fmt.Errorf("unexpected sftp reply type %d", replyType)
+}
+
+func (c *sftpClient) send(typ byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	packet = append(packet, typ)
+	packet = append(packet, payload...)
+	_, err := c.in.Write(packet)
+	return err
+}
+
+func (c *sftpClient) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.out, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("failed to read sftp reply: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > 4*sftpMaxPacketData {
+		return 0, nil, fmt.Errorf("sftp reply of %d bytes", length)
+	}
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(c.out, data); err != nil {
+		return 0, nil, fmt.Errorf("failed to read sftp reply: %w", err)
+	}
+	return header[4], data, nil
+}
+
+// sftpStrings encodes each of values as a length prefixed string
+func sftpStrings(values ...string) []byte {
+	var data []byte
+	for _, value := range values {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(value)))
+		data = append(data, value...)
+	}
+	return data
+}
+
+func sftpString(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("malformed sftp string")
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return nil, nil, fmt.Errorf("malformed sftp string")
+	}
+	return data[4 : 4+length], data[4+length:], nil
+}
@@ -0,0 +1,367 @@
+package deployer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultSSHRemotePath     = "/srv/chef"
+	defaultSSHTimeout        = 5 * time.Minute
+	defaultSSHExtractCommand = `tar -xf "$ARTIFACT" -C "$RELEASE" --strip-components=1`
+
+	// sshKeySecretKey is where kubernetes.io/ssh-auth secrets keep the key
+	sshKeySecretKey = "ssh-privatekey"
+
+	// maxCommandOutput caps the output quoted in errors of remote commands
+	maxCommandOutput = 2048
+)
+
+// SSHDeployer deploys artifacts to a plain VM over SSH
+type SSHDeployer struct {
+	config       *config.SSHDeployConfig
+	clientConfig *ssh.ClientConfig
+	logger       *zap.Logger
+}
+
+func NewSSHDeployer(cfg *config.DeployConfig, logger *zap.Logger) (*SSHDeployer, error) {
+	sshConfig := &cfg.SSH
+	if sshConfig.Host == "" || sshConfig.User == "" {
+		return nil, fmt.Errorf("ssh deployer requires host and user")
+	}
+
+	key, err := loadSSHKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var signer ssh.Signer
+	if sshConfig.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(sshConfig.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh private key: %w", err)
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback(sshConfig.KnownHosts, sshConfig.HostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultSSHTimeout
+	if sshConfig.Timeout > 0 {
+		timeout = time.Duration(sshConfig.Timeout) * time.Second
+	}
+	return &SSHDeployer{
+		config: sshConfig,
+		clientConfig: &ssh.ClientConfig{
+			User:            sshConfig.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         timeout,
+		},
+		logger: logger,
+	}, nil
+}
+
+// loadSSHKey reads the private key from the config, a file or a
+// Kubernetes secret
+func loadSSHKey(cfg *config.DeployConfig) ([]byte, error) {
+	sshConfig := &cfg.SSH
+	switch {
+	case sshConfig.PrivateKey != "":
+		return []byte(sshConfig.PrivateKey), nil
+	case sshConfig.PrivateKeyFile != "":
+		key, err := os.ReadFile(sshConfig.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh private key: %w", err)
+		}
+		return key, nil
+	case sshConfig.PrivateKeySecret != "":
+		restConfig, err := LoadKubeConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create k8s client: %w", err)
+		}
+		namespace := cfg.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), sshConfig.PrivateKeySecret, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh private key secret: %w", err)
+		}
+		key, ok := secret.Data[sshKeySecretKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no %s key", sshConfig.PrivateKeySecret, sshKeySecretKey)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("ssh deployer requires private_key, private_key_file or private_key_secret")
+	}
+}
+
+// sshHostKeyCallback verifies hosts against the expected host key or a
+// known_hosts file
+func sshHostKeyCallback(knownHostsFile, hostKey string) (ssh.HostKeyCallback, error) {
+	switch {
+	case hostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ssh host key: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	case knownHostsFile != "":
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read known hosts: %w", err)
+		}
+		return callback, nil
+	default:
+		return nil, fmt.Errorf("known_hosts or host_key is required to verify the host")
+	}
+}
+
+// Deploy uploads the artifact, extracts it to a new release and switches
+// the current symlink to it before running the configured commands. A
+// failing command leaves the release live for Rollback to revert.
+func (d *SSHDeployer) Deploy(ctx context.Context, build *types.Build) error {
+	ctx, cancel := context.WithTimeout(ctx, d.clientConfig.Timeout)
+	defer cancel()
+
+	client, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	projectDir := d.projectDir(build.ProjectID)
+	release := path.Join("releases", build.ID)
+	env := d.env(build, path.Join(projectDir, release))
+
+	buildlog.Logger(ctx, d.logger).Info("uploading artifact",
+		zap.String("host", d.config.Host),
+		zap.String("release", release))
+
+	artifact, err := os.Open(build.ArtifactPath)
+	if err != nil {
+		return fmt.Errorf("failed to open artifact: %w", err)
+	}
+	defer artifact.Close()
+	if err := runSSH(client, fmt.Sprintf(`%s mkdir -p "$RELEASE" && cat > "$ARTIFACT"`, env), artifact); err != nil {
+		return fmt.Errorf("failed to upload artifact: %w", err)
+	}
+
+	extract := d.config.ExtractCommand
+	if extract == "" {
+		extract = defaultSSHExtractCommand
+	}
+	if err := runSSH(client, fmt.Sprintf(`%s %s && rm -f "$ARTIFACT"`, env, extract), nil); err != nil {
+		return fmt.Errorf("failed to extract artifact: %w", err)
+	}
+
+	switchScript := fmt.Sprintf(`cd %s && `+
+		`if [ -L current ]; then ln -sfn "$(readlink current)" previous; fi && `+
+		`ln -sfn %s current.next && mv -T current.next current`,
+		quote(projectDir), quote(release))
+	if err := runSSH(client, switchScript, nil); err != nil {
+		return fmt.Errorf("failed to switch release: %w", err)
+	}
+
+	for _, command := range d.config.Commands {
+		if err := runSSH(client, fmt.Sprintf(`%s cd "$RELEASE" && %s`, env, command), nil); err != nil {
+			return fmt.Errorf("command %q failed: %w", command, err)
+		}
+	}
+	if err := d.restart(client, env); err != nil {
+		return err
+	}
+
+	keep := d.config.KeepReleases
+	if keep == 0 {
+		keep = defaultKeepReleases
+	}
+	// The previous release is needed for rollbacks
+	keep = max(keep, 2)
+	prune := fmt.Sprintf(`cd %s && ls -1t | tail -n +%d | xargs -r rm -rf`, quote(path.Join(projectDir, "releases")), keep+1)
+	if err := runSSH(client, prune, nil); err != nil {
+		buildlog.Logger(ctx, d.logger).Warn("failed to prune old releases",
+			zap.String("host", d.config.Host),
+			zap.Error(err))
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("ssh deployment completed",
+		zap.String("host", d.config.Host),
+		zap.String("release", release))
+	return nil
+}
+
+// Rollback switches back to the previous release if the build's release
+// is live, restarts the unit and removes the build's release
+func (d *SSHDeployer) Rollback(ctx context.Context, build *types.Build) error {
+	ctx, cancel := context.WithTimeout(ctx, d.clientConfig.Timeout)
+	defer cancel()
+
+	client, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	projectDir := d.projectDir(build.ProjectID)
+	release := path.Join("releases", build.ID)
+	buildlog.Logger(ctx, d.logger).Info("rolling back deployment",
+		zap.String("host", d.config.Host),
+		zap.String("release", release))
+
+	var output bytes.Buffer
+	script := fmt.Sprintf(`cd %s && `+
+		`if [ "$(readlink current)" = %s ]; then `+
+		`if [ -L previous ]; then mv -T previous current; else rm -f current; fi && echo reverted; `+
+		`fi && rm -rf %s`,
+		quote(projectDir), quote(release), quote(release))
+	if err := runSSHOutput(client, script, nil, &output); err != nil {
+		return fmt.Errorf("failed to restore previous release: %w", err)
+	}
+	if strings.TrimSpace(output.String()) != "reverted" {
+		return nil
+	}
+	return d.restart(client, d.env(build, path.Join(projectDir, "current")))
+}
+
+// Remove deletes the project's releases from the host
+func (d *SSHDeployer) Remove(ctx context.Context, projectID string, _ []string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.clientConfig.Timeout)
+	defer cancel()
+
+	client, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := runSSH(client, "rm -rf "+quote(d.projectDir(projectID)), nil); err != nil {
+		return fmt.Errorf("failed to remove deployment: %w", err)
+	}
+	d.logger.Info("removed ssh deployment",
+		zap.String("host", d.config.Host),
+		zap.String("project", projectID))
+	return nil
+}
+
+func (d *SSHDeployer) Validate(build *types.Build) error {
+	if build.ArtifactPath == "" {
+		return fmt.Errorf("artifact path is required")
+	}
+	if _, err := os.Stat(build.ArtifactPath); err != nil {
+		return fmt.Errorf("failed to stat artifact: %w", err)
+	}
+	return nil
+}
+
+// connect dials the host. The connection is closed when ctx is done so
+// commands cannot outlive the deploy.
+func (d *SSHDeployer) connect(ctx context.Context) (*ssh.Client, error) {
+	return dialSSH(ctx, d.config.Host, d.clientConfig)
+}
+
+// dialSSH connects to host, on port 22 unless it names one, and closes the
+// connection once ctx is done
+func dialSSH(ctx context.Context, host string, clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", addr, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+	return client, nil
+}
+
+func (d *SSHDeployer) restart(client *ssh.Client, env string) error {
+	if d.config.SystemdUnit == "" {
+		return nil
+	}
+	if err := runSSH(client, fmt.Sprintf(`%s sudo -n systemctl restart -- %s`, env, d.config.SystemdUnit), nil); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", d.config.SystemdUnit, err)
+	}
+	return nil
+}
+
+func (d *SSHDeployer) projectDir(projectID string) string {
+	remotePath := d.config.RemotePath
+	if remotePath == "" {
+		remotePath = defaultSSHRemotePath
+	}
+	return path.Join(remotePath, projectID)
+}
+
+// env sets the variables remote commands see
+func (d *SSHDeployer) env(build *types.Build, release string) string {
+	return fmt.Sprintf("export RELEASE=%s PROJECT=%s BUILD_ID=%s ARTIFACT=%s;",
+		quote(release), quote(build.ProjectID), quote(build.ID), quote(release+".artifact"))
+}
+
+func runSSH(client *ssh.Client, script string, stdin io.Reader) error {
+	return runSSHOutput(client, script, stdin, io.Discard)
+}
+
+// runSSHOutput runs script in a new session, quoting the end of its
+// output in the error when it fails
+func runSSHOutput(client *ssh.Client, script string, stdin io.Reader, stdout io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	if err := session.Run(script); err != nil {
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > maxCommandOutput {
+			output = output[len(output)-maxCommandOutput:]
+		}
+		if output == "" {
+			return err
+		}
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package deployer
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// startSSHServer serves exec requests by running them with the local sh.
+// It returns the address and the host key in authorized_keys format.
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) (string, string) {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, assert.AnError
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, serverConfig)
+		}
+	}()
+	return listener.Addr().String(), string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey()))
+}
+
+func serveSSH(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+
+				cmd := exec.Command("sh", "-c", payload.Command)
+				cmd.Stdin = channel
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				status := make([]byte, 4)
+				if err := cmd.Run(); err != nil {
+					binary.BigEndian.PutUint32(status, 1)
+				}
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+func writeSSHArtifact(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "build.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	defer tw.Close()
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "html/index.html", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+	_, err = tw.Write([]byte(content))
+	require.NoError(t, err)
+	return path
+}
+
+func TestSSHDeployer(t *testing.T) {
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	require.NoError(t, err)
+
+	addr, hostKey := startSSHServer(t, clientSigner.PublicKey())
+	remote := t.TempDir()
+	d, err := NewSSHDeployer(&config.DeployConfig{SSH: config.SSHDeployConfig{
+		Host:         addr,
+		User:         "deploy",
+		PrivateKey:   string(pem.EncodeToMemory(block)),
+		HostKey:      hostKey,
+		RemotePath:   remote,
+		Commands:     []string{`echo "$BUILD_ID $(cat index.html)" >> ../../deployed.log`},
+		KeepReleases: 2,
+	}}, zap.NewNop())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	projectDir := filepath.Join(remote, "shop")
+	current := func() string {
+		data, err := os.ReadFile(filepath.Join(projectDir, "current", "index.html"))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	for _, id := range []string{"b1", "b2", "b3"} {
+		build := &types.Build{ID: id, ProjectID: "shop", ArtifactPath: writeSSHArtifact(t, "page "+id)}
+		require.NoError(t, d.Validate(build))
+		require.NoError(t, d.Deploy(ctx, build))
+		assert.Equal(t, "page "+id, current())
+	}
+	log, err := os.ReadFile(filepath.Join(projectDir, "deployed.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "b1 page b1\nb2 page b2\nb3 page b3\n", string(log))
+
+	releases, err := os.ReadDir(filepath.Join(projectDir, "releases"))
+	require.NoError(t, err)
+	var names []string
+	for _, release := range releases {
+		names = append(names, release.Name())
+	}
+	assert.Equal(t, []string{"b2", "b3"}, names, "old releases are pruned")
+
+	require.NoError(t, d.Rollback(ctx, &types.Build{ID: "b3", ProjectID: "shop"}))
+	assert.Equal(t, "page b2", current())
+	assert.NoDirExists(t, filepath.Join(projectDir, "releases", "b3"))
+
+	// Rolling back a build that is not live leaves the current release
+	require.NoError(t, d.Rollback(ctx, &types.Build{ID: "b1", ProjectID: "shop"}))
+	assert.Equal(t, "page b2", current())
+
+	d.config.Commands = []string{"echo migration failed >&2; exit 1"}
+	err = d.Deploy(ctx, &types.Build{ID: "b4", ProjectID: "shop", ArtifactPath: writeSSHArtifact(t, "page b4")})
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "migration failed"), err.Error())
+
+	require.NoError(t, d.Remove(ctx, "shop", nil))
+	assert.NoDirExists(t, projectDir)
+}
+
+func TestNewSSHDeployer_RequiresHostVerification(t *testing.T) {
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	require.NoError(t, err)
+
+	_, err = NewSSHDeployer(&config.DeployConfig{SSH: config.SSHDeployConfig{
+		Host:       "vm1",
+		User:       "deploy",
+		PrivateKey: string(pem.EncodeToMemory(block)),
+	}}, zap.NewNop())
+	assert.ErrorContains(t, err, "known_hosts or host_key")
+}
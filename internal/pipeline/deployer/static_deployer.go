@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
 	"github.com/elskow/chef-infra/internal/pipeline/types"
 	"go.uber.org/zap"
@@ -17,69 +17,120 @@ const (
 )
 
 type StaticDeployer struct {
-	config *config.DeployConfig
-	logger *zap.Logger
+	config     *config.DeployConfig
+	replicator replicator // Nil without sync hosts
+	logger     *zap.Logger
 }
 
 func NewStaticDeployer(config *config.DeployConfig, logger *zap.Logger) *StaticDeployer {
 	if config.StaticPath == "" {
-		logger.Warn("static_path not configured, using default /var/www/html")
-		config.StaticPath = "/var/www/html"
+		logger.Warn("static_path not configured, using default", zap.String("path", defaultStaticPath))
+		config.StaticPath = defaultStaticPath
 	}
 	if config.MaxDeploySize == 0 {
 		logger.Warn("max_deploy_size not configured, using default 100MB")
 		config.MaxDeploySize = defaultMaxDeploySize
 	}
 
-	return &StaticDeployer{
+	deployer := &StaticDeployer{
 		config: config,
 		logger: logger,
 	}
+	if len(config.Sync.Hosts) > 0 {
+		remotePath := config.Sync.RemotePath
+		if remotePath == "" {
+			remotePath = config.StaticPath
+		}
+		deployer.replicator = newSSHReplicator(&config.Sync, remotePath)
+	}
+	return deployer
 }
 
-func (d *StaticDeployer) Deploy(_ context.Context, build *types.Build) error {
+func (d *StaticDeployer) Deploy(ctx context.Context, build *types.Build) error {
 	// Ensure static path exists
 	if err := os.MkdirAll(d.config.StaticPath, 0755); err != nil {
 		return fmt.Errorf("failed to create static directory: %w", err)
 	}
 
 	targetDir := filepath.Join(d.config.StaticPath, build.ProjectID)
-	d.logger.Info("deploying to static directory",
-		zap.String("target", targetDir),
-		zap.String("project", build.ProjectID))
+	buildlog.Logger(ctx, d.logger).Info("deploying to static directory",
+		zap.String("target", targetDir))
 
 	// Create backup of current deployment
-	if err := d.createBackup(targetDir, build); err != nil {
+	if err := d.createBackup(ctx, targetDir, build); err != nil {
 		return fmt.Errorf("failed to create backup: %w", err)
 	}
 
 	// Extract artifact to target directory
-	if err := d.extractArtifact(build.ArtifactPath, targetDir); err != nil {
+	if err := d.extractArtifact(ctx, build.ArtifactPath, targetDir); err != nil {
 		return fmt.Errorf("failed to extract artifact: %w", err)
 	}
 
-	d.logger.Info("static deployment completed",
-		zap.String("project", build.ProjectID),
+	if d.config.TerraformManifest {
+		if err := d.writeTerraformManifest(targetDir, build); err != nil {
+			return fmt.Errorf("failed to write terraform manifest: %w", err)
+		}
+	}
+
+	if d.replicator != nil {
+		if err := d.syncRelease(ctx, build, targetDir); err != nil {
+			return fmt.Errorf("failed to replicate release: %w", err)
+		}
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("static deployment completed",
 		zap.String("location", targetDir))
 
 	return nil
 }
 
-func (d *StaticDeployer) Rollback(_ context.Context, build *types.Build) error {
+func (d *StaticDeployer) Rollback(ctx context.Context, build *types.Build) error {
 	targetDir := filepath.Join(d.config.StaticPath, build.ProjectID)
 	backupPath := filepath.Join(d.config.StaticPath, "backups", fmt.Sprintf("%s.tar.gz", build.ID))
 
-	d.logger.Info("rolling back deployment",
-		zap.String("project", build.ProjectID),
+	buildlog.Logger(ctx, d.logger).Info("rolling back deployment",
 		zap.String("backup", backupPath))
 
-	if err := d.extractArtifact(backupPath, targetDir); err != nil {
+	if d.replicator != nil {
+		if err := d.revertHosts(ctx, build, d.config.Sync.Hosts); err != nil {
+			return fmt.Errorf("failed to revert hosts: %w", err)
+		}
+	}
+
+	if err := d.extractArtifact(ctx, backupPath, targetDir); err != nil {
 		return fmt.Errorf("failed to restore backup: %w", err)
 	}
 
 	return nil
 }
 
+// Remove deletes the project's deployed files, also from the sync hosts,
+// and the backups of its builds
+func (d *StaticDeployer) Remove(ctx context.Context, projectID string, buildIDs []string) error {
+	targetDir := filepath.Join(d.config.StaticPath, projectID)
+	if err := os.RemoveAll(targetDir); err != nil {
+		return fmt.Errorf("failed to remove deployment: %w", err)
+	}
+
+	for _, buildID := range buildIDs {
+		backupPath := filepath.Join(d.config.StaticPath, "backups", fmt.Sprintf("%s.tar.gz", buildID))
+		if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove backup: %w", err)
+		}
+	}
+
+	if d.replicator != nil {
+		for _, host := range d.config.Sync.Hosts {
+			if err := d.replicator.Remove(ctx, host, projectID); err != nil {
+				return fmt.Errorf("failed to remove deployment from %s: %w", host, err)
+			}
+		}
+	}
+
+	d.logger.Info("removed static deployment", zap.String("project", projectID))
+	return nil
+}
+
 func (d *StaticDeployer) Validate(build *types.Build) error {
 	if build.ArtifactPath == "" {
 		return fmt.Errorf("artifact path is required")
@@ -97,10 +148,38 @@ func (d *StaticDeployer) Validate(build *types.Build) error {
 	return nil
 }
 
-func (d *StaticDeployer) createBackup(sourceDir string, build *types.Build) error {
+// MaxDeploySize returns the bytes above which Validate refuses artifacts
+func (d *StaticDeployer) MaxDeploySize() int64 {
+	return d.config.MaxDeploySize
+}
+
+// Probe checks that the static path is writable
+func (d *StaticDeployer) Probe(_ context.Context) []Check {
+	check := Check{Name: "static_path", OK: true, Message: d.config.StaticPath + " writable"}
+	if err := checkWritable(d.config.StaticPath); err != nil {
+		check = Check{Name: "static_path", Message: fmt.Sprintf("%s not writable: %v", d.config.StaticPath, err)}
+	}
+	return []Check{check}
+}
+
+// checkWritable creates and removes a file in dir, creating dir as deploys
+// do
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".chef-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+func (d *StaticDeployer) createBackup(ctx context.Context, sourceDir string, build *types.Build) error {
 	if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
-		d.logger.Info("no existing deployment to backup",
-			zap.String("project", build.ProjectID))
+		buildlog.Logger(ctx, d.logger).Info("no existing deployment to backup")
 		return nil
 	}
 
@@ -110,24 +189,21 @@ func (d *StaticDeployer) createBackup(sourceDir string, build *types.Build) erro
 	}
 
 	backupPath := filepath.Join(backupDir, fmt.Sprintf("%s.tar.gz", build.ID))
-	cmd := exec.Command("tar", "-czf", backupPath, "-C", sourceDir, ".")
 
-	d.logger.Info("creating backup",
-		zap.String("project", build.ProjectID),
+	buildlog.Logger(ctx, d.logger).Info("creating backup",
 		zap.String("backup_path", backupPath))
 
-	return cmd.Run()
+	return createTarGz(sourceDir, backupPath)
 }
 
-func (d *StaticDeployer) extractArtifact(artifactPath, targetDir string) error {
+func (d *StaticDeployer) extractArtifact(ctx context.Context, artifactPath, targetDir string) error {
 	if err := os.MkdirAll(targetDir, 0755); err != nil {
 		return err
 	}
 
-	cmd := exec.Command("tar", "-xzf", artifactPath, "-C", targetDir)
-	d.logger.Info("extracting artifact",
+	buildlog.Logger(ctx, d.logger).Info("extracting artifact",
 		zap.String("source", artifactPath),
 		zap.String("target", targetDir))
 
-	return cmd.Run()
+	return extractArchive(artifactPath, targetDir)
 }
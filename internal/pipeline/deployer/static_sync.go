@@ -0,0 +1,358 @@
+package deployer
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultSyncTimeout  = 5 * time.Minute
+	defaultKeepReleases = 5
+
+	// maxReportedFiles caps the differing files named in a sync error
+	maxReportedFiles = 5
+)
+
+// replicator copies releases to web servers and switches which release a
+// host serves. Releases are paths relative to the remote path, e.g.
+// releases/shop/<build>.
+type replicator interface {
+	// Stage runs once per deploy before the release is pushed to any host
+	Stage(ctx context.Context, localDir, release string) error
+	Push(ctx context.Context, host, localDir, release string) error
+	// Checksums returns the SHA-256 of every file of the release on host,
+	// keyed by slash separated path
+	Checksums(ctx context.Context, host, release string) (map[string]string, error)
+	Switch(ctx context.Context, host, projectID, release string) error
+	// Revert switches host back to the release it served before release,
+	// if it serves release
+	Revert(ctx context.Context, host, projectID, release string) error
+	Remove(ctx context.Context, host, projectID string) error
+}
+
+// syncRelease replicates the deployed files in localDir to the configured
+// hosts. Hosts are only switched to the release once at least min_hosts
+// hold an identical copy; hosts that fail keep serving their previous
+// release and are recorded as failed on the build.
+func (d *StaticDeployer) syncRelease(ctx context.Context, build *types.Build, localDir string) error {
+	hosts := d.config.Sync.Hosts
+	required := d.config.Sync.MinHosts
+	if required == 0 {
+		required = len(hosts)
+	}
+	release := releasePath(build)
+
+	want, err := checksumDir(localDir)
+	if err != nil {
+		return fmt.Errorf("failed to hash release: %w", err)
+	}
+	if err := d.replicator.Stage(ctx, localDir, release); err != nil {
+		return fmt.Errorf("failed to stage release: %w", err)
+	}
+
+	buildlog.Logger(ctx, d.logger).Info("replicating release",
+		zap.String("release", release),
+		zap.Int("hosts", len(hosts)))
+
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = d.pushRelease(ctx, host, localDir, release, want)
+		}()
+	}
+	wg.Wait()
+
+	var consistent []string
+	for i, host := range hosts {
+		if errs[i] != nil {
+			buildlog.Logger(ctx, d.logger).Warn("failed to replicate release",
+				zap.String("host", host),
+				zap.String("release", release),
+				zap.Error(errs[i]))
+			types.RecordEvent(ctx, build, types.EventHostSyncFailed, host, errs[i].Error())
+			continue
+		}
+		consistent = append(consistent, host)
+	}
+	if len(consistent) < required {
+		return fmt.Errorf("release is consistent on %d of %d hosts, %d required", len(consistent), len(hosts), required)
+	}
+
+	var switched []string
+	for _, host := range consistent {
+		if err := d.replicator.Switch(ctx, host, build.ProjectID, release); err != nil {
+			buildlog.Logger(ctx, d.logger).Warn("failed to switch release",
+				zap.String("host", host),
+				zap.String("release", release),
+				zap.Error(err))
+			types.RecordEvent(ctx, build, types.EventHostSyncFailed, host, "switch failed: "+err.Error())
+			continue
+		}
+		switched = append(switched, host)
+	}
+	if len(switched) < required {
+		d.revertHosts(ctx, build, switched)
+		return fmt.Errorf("release was switched on %d of %d hosts, %d required", len(switched), len(hosts), required)
+	}
+
+	for _, host := range switched {
+		types.RecordEvent(ctx, build, types.EventHostSynced, host, release)
+	}
+	return nil
+}
+
+// pushRelease copies the release to host and checks that every file
+// arrived intact
+func (d *StaticDeployer) pushRelease(ctx context.Context, host, localDir, release string, want map[string]string) error {
+	timeout := defaultSyncTimeout
+	if d.config.Sync.Timeout > 0 {
+		timeout = time.Duration(d.config.Sync.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := d.replicator.Push(ctx, host, localDir, release); err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	got, err := d.replicator.Checksums(ctx, host, release)
+	if err != nil {
+		return fmt.Errorf("consistency check failed: %w", err)
+	}
+	if differing := diffChecksums(want, got); len(differing) > 0 {
+		count := len(differing)
+		if count > maxReportedFiles {
+			differing = differing[:maxReportedFiles]
+		}
+		return fmt.Errorf("%d files differ from the release: %s", count, strings.Join(differing, ", "))
+	}
+	return nil
+}
+
+// revertHosts switches hosts back from the build's release
+func (d *StaticDeployer) revertHosts(ctx context.Context, build *types.Build, hosts []string) error {
+	release := releasePath(build)
+	var errs []error
+	for _, host := range hosts {
+		if err := d.replicator.Revert(ctx, host, build.ProjectID, release); err != nil {
+			buildlog.Logger(ctx, d.logger).Error("failed to revert release",
+				zap.String("host", host),
+				zap.String("release", release),
+				zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func releasePath(build *types.Build) string {
+	return path.Join("releases", build.ProjectID, build.ID)
+}
+
+// checksumDir hashes every regular file below dir
+func checksumDir(dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return sums, err
+}
+
+// parseChecksums reads sha256sum output
+func parseChecksums(output string) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		sum, name, found := strings.Cut(line, "  ")
+		if !found || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("unexpected sha256sum output: %q", line)
+		}
+		sums[strings.TrimPrefix(name, "./")] = sum
+	}
+	return sums, scanner.Err()
+}
+
+// diffChecksums lists the files missing from got, differing in it or only
+// found there, sorted
+func diffChecksums(want, got map[string]string) []string {
+	var differing []string
+	for name, sum := range want {
+		if got[name] != sum {
+			differing = append(differing, name)
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			differing = append(differing, name)
+		}
+	}
+	sort.Strings(differing)
+	return differing
+}
+
+// sshReplicator reaches hosts over SSH. The rsync method pushes every
+// release from this server, the s3 method uploads it once and has each
+// host pull it with the AWS CLI.
+type sshReplicator struct {
+	config     *config.StaticSyncConfig
+	remotePath string
+}
+
+func newSSHReplicator(cfg *config.StaticSyncConfig, remotePath string) *sshReplicator {
+	return &sshReplicator{config: cfg, remotePath: remotePath}
+}
+
+func (r *sshReplicator) Stage(ctx context.Context, localDir, release string) error {
+	if r.config.Method != "s3" {
+		return nil
+	}
+	_, err := runSyncCommand(ctx, "aws", "s3", "sync", "--delete", "--only-show-errors", localDir+"/", r.bucketURL(release))
+	return err
+}
+
+func (r *sshReplicator) Push(ctx context.Context, host, localDir, release string) error {
+	dir := path.Join(r.remotePath, release)
+	if r.config.Method == "s3" {
+		_, err := r.ssh(ctx, host, fmt.Sprintf("mkdir -p %s && aws s3 sync --delete --only-show-errors %s %s",
+			quote(dir), quote(r.bucketURL(release)), quote(dir+"/")))
+		return err
+	}
+
+	if _, err := r.ssh(ctx, host, "mkdir -p "+quote(dir)); err != nil {
+		return err
+	}
+	_, err := runSyncCommand(ctx, "rsync", "-a", "--delete", "--checksum",
+		"-e", strings.Join(append([]string{"ssh"}, r.sshOptions()...), " "),
+		localDir+"/", host+":"+dir+"/")
+	return err
+}
+
+func (r *sshReplicator) Checksums(ctx context.Context, host, release string) (map[string]string, error) {
+	output, err := r.ssh(ctx, host, fmt.Sprintf("cd %s && find . -type f -exec sha256sum {} +",
+		quote(path.Join(r.remotePath, release))))
+	if err != nil {
+		return nil, err
+	}
+	return parseChecksums(string(output))
+}
+
+// Switch points the project's symlink at the release with a rename, so
+// the web server never sees a missing path, and prunes old releases
+func (r *sshReplicator) Switch(ctx context.Context, host, projectID, release string) error {
+	keep := r.config.KeepReleases
+	if keep == 0 {
+		keep = defaultKeepReleases
+	}
+	// The previous release is needed for rollbacks
+	keep = max(keep, 2)
+
+	link, previous, next := quote(projectID), quote("."+projectID+".previous"), quote("."+projectID+".next")
+	script := fmt.Sprintf(`cd %s && `+
+		`if [ -L %s ]; then ln -sfn "$(readlink %s)" %s; fi && `+
+		`ln -sfn %s %s && mv -T %s %s && `+
+		`cd %s && ls -1t | tail -n +%d | xargs -r rm -rf`,
+		quote(r.remotePath),
+		link, link, previous,
+		quote(release), next, next, link,
+		quote(path.Dir(release)), keep+1)
+	_, err := r.ssh(ctx, host, script)
+	return err
+}
+
+func (r *sshReplicator) Revert(ctx context.Context, host, projectID, release string) error {
+	link, previous := quote(projectID), quote("."+projectID+".previous")
+	_, err := r.ssh(ctx, host, fmt.Sprintf(`cd %s && if [ "$(readlink %s)" = %s ] && [ -L %s ]; then mv -T %s %s; fi`,
+		quote(r.remotePath), link, quote(release), previous, previous, link))
+	return err
+}
+
+func (r *sshReplicator) Remove(ctx context.Context, host, projectID string) error {
+	_, err := r.ssh(ctx, host, fmt.Sprintf("cd %s && rm -rf %s %s %s %s",
+		quote(r.remotePath), quote(projectID), quote("."+projectID+".previous"), quote("."+projectID+".next"),
+		quote(path.Join("releases", projectID))))
+	return err
+}
+
+func (r *sshReplicator) bucketURL(release string) string {
+	return strings.TrimSuffix(r.config.Bucket, "/") + "/" + release + "/"
+}
+
+func (r *sshReplicator) sshOptions() []string {
+	options := []string{"-o", "BatchMode=yes"}
+	if r.config.SSHPort > 0 {
+		options = append(options, "-p", strconv.Itoa(r.config.SSHPort))
+	}
+	if r.config.SSHKey != "" {
+		options = append(options, "-i", r.config.SSHKey)
+	}
+	return options
+}
+
+func (r *sshReplicator) ssh(ctx context.Context, host, script string) ([]byte, error) {
+	args := append(r.sshOptions(), host, script)
+	return runSyncCommand(ctx, "ssh", args...)
+}
+
+func runSyncCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out")
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return output, nil
+}
+
+// quote makes s a single shell word
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,182 @@
+package deployer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// fakeReplicator keeps the checksums of what was pushed to each host
+type fakeReplicator struct {
+	mu         sync.Mutex
+	failPush   map[string]bool
+	corrupt    map[string]bool // Report a changed file
+	failSwitch map[string]bool
+	pushed     map[string]map[string]string
+	switched   []string
+	reverted   []string
+}
+
+func (f *fakeReplicator) Stage(context.Context, string, string) error { return nil }
+
+func (f *fakeReplicator) Push(_ context.Context, host, localDir, _ string) error {
+	if f.failPush[host] {
+		return fmt.Errorf("connection refused")
+	}
+	sums, err := checksumDir(localDir)
+	if err != nil {
+		return err
+	}
+	if f.corrupt[host] {
+		sums["index.html"] = "truncated"
+	}
+	f.mu.Lock()
+	f.pushed[host] = sums
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeReplicator) Checksums(_ context.Context, host, _ string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pushed[host], nil
+}
+
+func (f *fakeReplicator) Switch(_ context.Context, host, _, _ string) error {
+	if f.failSwitch[host] {
+		return fmt.Errorf("permission denied")
+	}
+	f.switched = append(f.switched, host)
+	return nil
+}
+
+func (f *fakeReplicator) Revert(_ context.Context, host, _, _ string) error {
+	f.reverted = append(f.reverted, host)
+	return nil
+}
+
+func (f *fakeReplicator) Remove(context.Context, string, string) error { return nil }
+
+func hostEvents(build *types.Build) map[string]types.DeploymentEventType {
+	events := make(map[string]types.DeploymentEventType)
+	for _, event := range build.Events {
+		events[event.Hook] = event.Type
+	}
+	return events
+}
+
+func TestStaticDeployer_SyncRelease(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("app"), 0644))
+	hosts := []string{"web1", "web2", "web3"}
+
+	tests := []struct {
+		name       string
+		minHosts   int
+		replicator *fakeReplicator
+		wantErr    string
+		switched   []string
+		reverted   []string
+		failed     []string
+	}{
+		{
+			name:       "all hosts",
+			replicator: &fakeReplicator{},
+			switched:   hosts,
+		},
+		{
+			name:       "unreachable host below quorum",
+			minHosts:   2,
+			replicator: &fakeReplicator{failPush: map[string]bool{"web2": true}},
+			switched:   []string{"web1", "web3"},
+			failed:     []string{"web2"},
+		},
+		{
+			name:       "unreachable host",
+			replicator: &fakeReplicator{failPush: map[string]bool{"web2": true}},
+			wantErr:    "consistent on 2 of 3 hosts, 3 required",
+			failed:     []string{"web2"},
+		},
+		{
+			name:       "inconsistent copy",
+			minHosts:   2,
+			replicator: &fakeReplicator{corrupt: map[string]bool{"web1": true, "web3": true}},
+			wantErr:    "consistent on 1 of 3 hosts, 2 required",
+			failed:     []string{"web1", "web3"},
+		},
+		{
+			name:       "switch failure reverts switched hosts",
+			minHosts:   3,
+			replicator: &fakeReplicator{failSwitch: map[string]bool{"web3": true}},
+			wantErr:    "switched on 2 of 3 hosts, 3 required",
+			switched:   []string{"web1", "web2"},
+			reverted:   []string{"web1", "web2"},
+			failed:     []string{"web3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.replicator.pushed = make(map[string]map[string]string)
+			d := &StaticDeployer{
+				config:     &config.DeployConfig{Sync: config.StaticSyncConfig{Hosts: hosts, MinHosts: tt.minHosts}},
+				replicator: tt.replicator,
+				logger:     zap.NewNop(),
+			}
+			build := &types.Build{ID: "b1", ProjectID: "shop"}
+
+			err := d.syncRelease(context.Background(), build, dir)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.switched, tt.replicator.switched)
+			assert.Equal(t, tt.reverted, tt.replicator.reverted)
+
+			var failed []string
+			for host, event := range hostEvents(build) {
+				if event == types.EventHostSyncFailed {
+					failed = append(failed, host)
+				} else if tt.wantErr == "" {
+					assert.Equal(t, types.EventHostSynced, event)
+				}
+			}
+			sort.Strings(failed)
+			assert.Equal(t, tt.failed, failed)
+		})
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	output := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  ./index.html\n" +
+		"486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7  ./assets/logo 1.svg\n"
+	sums, err := parseChecksums(output)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"index.html":        "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"assets/logo 1.svg": "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7",
+	}, sums)
+
+	_, err = parseChecksums("sha256sum: ./index.html: Permission denied\n")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"a", "c", "d"}, diffChecksums(
+		map[string]string{"a": "1", "b": "2", "c": "3"},
+		map[string]string{"a": "0", "b": "2", "d": "4"},
+	))
+}
@@ -0,0 +1,64 @@
+package deployer
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+// Targets holds the deployer of every deploy target. Environments without
+// a target of their own deploy with the default deployer.
+type Targets struct {
+	defaultDeployer Deployer
+	environments    map[string]Deployer
+}
+
+// NewTargets creates the default deployer from cfg and one per environment
+// listed in its targets
+func NewTargets(cfg *config.DeployConfig, logger *zap.Logger) (*Targets, error) {
+	defaultDeployer, err := NewDeployer(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := &Targets{
+		defaultDeployer: defaultDeployer,
+		environments:    make(map[string]Deployer, len(cfg.Targets)),
+	}
+	for env := range cfg.Targets {
+		deployer, err := NewDeployer(cfg.Target(env), logger.With(zap.String("environment", env)))
+		if err != nil {
+			return nil, fmt.Errorf("deploy target %s: %w", env, err)
+		}
+		targets.environments[env] = deployer
+	}
+	return targets, nil
+}
+
+// Default returns the deployer of environments without a target
+func (t *Targets) Default() Deployer {
+	return t.defaultDeployer
+}
+
+// Environments returns the deployers of environments with a target of
+// their own
+func (t *Targets) Environments() map[string]Deployer {
+	return t.environments
+}
+
+// WrapK8sClients replaces the client of every Kubernetes deployer with
+// wrap of it, e.g. to inject faults in tests. Hook runners created from
+// the targets before keep the unwrapped client.
+func (t *Targets) WrapK8sClients(wrap func(K8sClient) K8sClient) {
+	deployers := []Deployer{t.defaultDeployer}
+	for _, d := range t.environments {
+		deployers = append(deployers, d)
+	}
+	for _, d := range deployers {
+		if k8s, ok := d.(*K8sDeployer); ok {
+			k8s.k8sClient = wrap(k8s.k8sClient)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package deployer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestNewTargets(t *testing.T) {
+	targets, err := NewTargets(&config.DeployConfig{
+		Platform:   "static",
+		StaticPath: "/srv/default",
+		Targets: map[string]config.DeployConfig{
+			"production": {StaticPath: "/srv/production"},
+		},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.Equal(t, "/srv/default", targets.Default().(*StaticDeployer).config.StaticPath)
+	require.Contains(t, targets.Environments(), "production")
+	production := targets.Environments()["production"].(*StaticDeployer)
+	assert.Equal(t, "/srv/production", production.config.StaticPath)
+	assert.Equal(t, "static", production.config.Platform, "unset settings are inherited")
+
+	_, err = NewTargets(&config.DeployConfig{
+		Platform: "static",
+		Targets:  map[string]config.DeployConfig{"staging": {Platform: "s3"}},
+	}, zap.NewNop())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "deploy target staging: unsupported deployment platform: s3")
+}
@@ -0,0 +1,183 @@
+package deployer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const terraformManifestFile = "main.tf.json"
+
+// TerraformObject describes a single deployed file in a form that maps
+// directly onto object storage resources (e.g. aws_s3_object).
+type TerraformObject struct {
+	Source      string `json:"source"`
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag"`
+	Size        int64  `json:"size"`
+}
+
+type terraformOutput struct {
+	Description string      `json:"description,omitempty"`
+	Value       interface{} `json:"value"`
+}
+
+// terraformModule is the JSON syntax of a Terraform/OpenTofu module that only
+// declares outputs, so infra pipelines can consume it with a module block.
+type terraformModule struct {
+	Output map[string]terraformOutput `json:"output"`
+}
+
+func (d *StaticDeployer) terraformManifestPath(build *types.Build) string {
+	dir := d.config.TerraformManifestDir
+	if dir == "" {
+		dir = defaultTerraformManifestDir
+	}
+	return filepath.Join(dir, build.ProjectID, terraformManifestFile)
+}
+
+func (d *StaticDeployer) writeTerraformManifest(targetDir string, build *types.Build) error {
+	objects, err := collectTerraformObjects(targetDir)
+	if err != nil {
+		return fmt.Errorf("failed to collect deployed objects: %w", err)
+	}
+
+	manifestPath := d.terraformManifestPath(build)
+	previous, err := readTerraformObjects(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read previous manifest: %w", err)
+	}
+
+	module := terraformModule{
+		Output: map[string]terraformOutput{
+			"project_id":  {Value: build.ProjectID},
+			"build_id":    {Value: build.ID},
+			"commit_hash": {Value: build.CommitHash},
+			"objects": {
+				Description: "Deployed objects keyed by their path relative to the site root",
+				Value:       objects,
+			},
+			"invalidation_paths": {
+				Description: "CDN paths that changed or were removed since the previous deployment",
+				Value:       invalidationPaths(previous, objects),
+			},
+		},
+	}
+
+	data, err := json.MarshalIndent(module, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(manifestPath, data, 0644)
+}
+
+func collectTerraformObjects(rootDir string) (map[string]TerraformObject, error) {
+	objects := make(map[string]TerraformObject)
+
+	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+
+		etag, err := fileMD5(path)
+		if err != nil {
+			return err
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		objects[filepath.ToSlash(relPath)] = TerraformObject{
+			Source:      path,
+			ContentType: contentType,
+			ETag:        etag,
+			Size:        info.Size(),
+		}
+		return nil
+	})
+
+	return objects, err
+}
+
+func readTerraformObjects(manifestPath string) (map[string]TerraformObject, error) {
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var module struct {
+		Output struct {
+			Objects struct {
+				Value map[string]TerraformObject `json:"value"`
+			} `json:"objects"`
+		} `json:"output"`
+	}
+	if err := json.Unmarshal(data, &module); err != nil {
+		return nil, err
+	}
+
+	return module.Output.Objects.Value, nil
+}
+
+// invalidationPaths returns the CDN paths that must be purged when moving from
+// previous to current. With no previous manifest everything is invalidated.
+func invalidationPaths(previous, current map[string]TerraformObject) []string {
+	if previous == nil {
+		return []string{"/*"}
+	}
+
+	paths := []string{}
+	for key, obj := range current {
+		if prev, exists := previous[key]; !exists || prev.ETag != obj.ETag {
+			paths = append(paths, "/"+key)
+		}
+	}
+	for key := range previous {
+		if _, exists := current[key]; !exists {
+			paths = append(paths, "/"+key)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+func fileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,73 @@
+package deployer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStaticDeployer_WriteTerraformManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	siteDir := filepath.Join(tmpDir, "site")
+	require.NoError(t, os.MkdirAll(filepath.Join(siteDir, "assets"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(siteDir, "index.html"), []byte("<html></html>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(siteDir, "assets", "app.js"), []byte("console.log(1)"), 0644))
+
+	deployer := NewStaticDeployer(&config.DeployConfig{
+		StaticPath:           filepath.Join(tmpDir, "www"),
+		TerraformManifest:    true,
+		TerraformManifestDir: filepath.Join(tmpDir, "terraform"),
+	}, zap.NewNop())
+
+	build := &types.Build{ID: "build-1", ProjectID: "test-app", CommitHash: "abc123"}
+	require.NoError(t, deployer.writeTerraformManifest(siteDir, build))
+
+	data, err := os.ReadFile(deployer.terraformManifestPath(build))
+	require.NoError(t, err)
+
+	var module struct {
+		Output struct {
+			Objects struct {
+				Value map[string]TerraformObject `json:"value"`
+			} `json:"objects"`
+			InvalidationPaths struct {
+				Value []string `json:"value"`
+			} `json:"invalidation_paths"`
+		} `json:"output"`
+	}
+	require.NoError(t, json.Unmarshal(data, &module))
+
+	assert.Len(t, module.Output.Objects.Value, 2)
+	assert.Contains(t, module.Output.Objects.Value["index.html"].ContentType, "text/html")
+	assert.Equal(t, []string{"/*"}, module.Output.InvalidationPaths.Value)
+
+	// Second deploy only invalidates what changed
+	require.NoError(t, os.WriteFile(filepath.Join(siteDir, "assets", "app.js"), []byte("console.log(2)"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(siteDir, "index.html")))
+	require.NoError(t, deployer.writeTerraformManifest(siteDir, &types.Build{ID: "build-2", ProjectID: "test-app"}))
+
+	data, err = os.ReadFile(deployer.terraformManifestPath(build))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &module))
+	assert.Equal(t, []string{"/assets/app.js", "/index.html"}, module.Output.InvalidationPaths.Value)
+}
+
+func TestStaticDeployer_TerraformManifestPathDefault(t *testing.T) {
+	staticPath := t.TempDir()
+	deployer := NewStaticDeployer(&config.DeployConfig{StaticPath: staticPath, TerraformManifest: true}, zap.NewNop())
+
+	// Neither served nor in the way of a project called terraform
+	path := deployer.terraformManifestPath(&types.Build{ProjectID: "terraform"})
+	assert.Equal(t, filepath.Join(defaultTerraformManifestDir, "terraform", terraformManifestFile), path)
+	rel, err := filepath.Rel(staticPath, path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rel, ".."))
+}
@@ -0,0 +1,335 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const (
+	defaultDeployLockLease   = time.Minute
+	defaultDeployLockTimeout = 30 * time.Minute
+	deployLockReleaseTimeout = 10 * time.Second
+)
+
+// deployLockPollInterval is how often a waiting deploy retries the lock
+var deployLockPollInterval = 2 * time.Second
+
+var ErrDeployLockTimeout = errors.New("timed out waiting for the deploy lock")
+
+func (p *Pipeline) deployLockLease() time.Duration {
+	if p.config.DeployLock.Lease > 0 {
+		return time.Duration(p.config.DeployLock.Lease) * time.Second
+	}
+	return defaultDeployLockLease
+}
+
+func (p *Pipeline) deployLockTimeout() time.Duration {
+	if p.config.DeployLock.Timeout > 0 {
+		return time.Duration(p.config.DeployLock.Timeout) * time.Second
+	}
+	return defaultDeployLockTimeout
+}
+
+// lockDeploy waits for the deploy lock of the build's environment and keeps
+// it renewed until the returned function releases it. Builds of this
+// process take the lock in the order they asked for it; the build records
+// whom it waits for as events.
+func (p *Pipeline) lockDeploy(ctx context.Context, build *types.Build) (func(), error) {
+	key := migrationKey{build.ProjectID, build.Environment}
+	p.mu.Lock()
+	if p.deployQueue == nil {
+		p.deployQueue = make(map[migrationKey][]*types.Build)
+	}
+	p.deployQueue[key] = append(p.deployQueue[key], build)
+	p.mu.Unlock()
+	defer p.leaveDeployQueue(key, build)
+
+	lease := p.deployLockLease()
+	deadline := time.Now().Add(p.deployLockTimeout())
+	var waitingFor string
+	for {
+		acquired, holder, err := p.tryDeployLock(ctx, key, build, lease)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire deploy lock: %w", err)
+		}
+		if acquired {
+			break
+		}
+		if holder != waitingFor {
+			waitingFor = holder
+			p.updateBuild(build, func(b *types.Build) {
+				b.AddEvent(types.EventDeployLockWaiting, "", holder)
+			})
+			p.persist(build)
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrDeployLockTimeout, holder)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(deployLockPollInterval):
+		}
+	}
+
+	renewCtx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.renewDeployLock(renewCtx, key, build, lease)
+	}()
+
+	return func() {
+		stop()
+		<-done
+		ctx, cancel := context.WithTimeout(context.Background(), deployLockReleaseTimeout)
+		defer cancel()
+		if err := p.releaseDeployLock(ctx, key, build.ID); err != nil && !errors.Is(err, types.ErrDeployLockNotFound) {
+			p.logger.Warn("failed to release deploy lock, it expires after its lease",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+		}
+	}, nil
+}
+
+// tryDeployLock takes the lock for the build when it is first in line.
+// Otherwise it describes the build it waits for.
+func (p *Pipeline) tryDeployLock(ctx context.Context, key migrationKey, build *types.Build, lease time.Duration) (bool, string, error) {
+	p.mu.RLock()
+	first := p.deployQueue[key][0]
+	p.mu.RUnlock()
+	if first != build {
+		return false, fmt.Sprintf("build %s is first in line", first.ID), nil
+	}
+
+	now := time.Now()
+	lock := &types.DeployLock{
+		ProjectID:   key.projectID,
+		Environment: key.environment,
+		BuildID:     build.ID,
+		Owner:       p.instance,
+		AcquiredAt:  now,
+		ExpiresAt:   now.Add(lease),
+	}
+	holder, err := p.acquireDeployLock(ctx, lock)
+	if err != nil {
+		return false, "", err
+	}
+	if holder.BuildID == build.ID {
+		return true, "", nil
+	}
+	return false, fmt.Sprintf("build %s is deploying on %s", holder.BuildID, holder.Owner), nil
+}
+
+func (p *Pipeline) leaveDeployQueue(key migrationKey, build *types.Build) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queue := slices.DeleteFunc(p.deployQueue[key], func(b *types.Build) bool { return b == build })
+	if len(queue) == 0 {
+		delete(p.deployQueue, key)
+		return
+	}
+	p.deployQueue[key] = queue
+}
+
+// renewDeployLock extends the build's lock every third of its lease until
+// ctx is done. A lock lost mid-deploy, e.g. released by an admin, is
+// recorded but does not stop the deploy.
+func (p *Pipeline) renewDeployLock(ctx context.Context, key migrationKey, build *types.Build, lease time.Duration) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := p.extendDeployLock(ctx, key, build.ID, time.Now().Add(lease))
+		if errors.Is(err, types.ErrDeployLockNotFound) {
+			p.logger.Warn("deploy lock lost",
+				zap.String("build_id", build.ID),
+				zap.String("project_id", key.projectID),
+				zap.String("environment", key.environment))
+			p.updateBuild(build, func(b *types.Build) {
+				b.AddEvent(types.EventDeployLockLost, "", "another deploy of "+key.environment+" may start")
+			})
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			p.logger.Warn("failed to renew deploy lock",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// acquireDeployLock takes the lock unless another build holds it
+// unexpired, and returns its holder
+func (p *Pipeline) acquireDeployLock(ctx context.Context, lock *types.DeployLock) (*types.DeployLock, error) {
+	if p.store != nil {
+		return p.store.AcquireDeployLock(ctx, lock)
+	}
+
+	key := migrationKey{lock.ProjectID, lock.Environment}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.deployLocks == nil {
+		p.deployLocks = make(map[migrationKey]*types.DeployLock)
+	}
+	current := p.deployLocks[key]
+	if current == nil || current.Expired(lock.AcquiredAt) || current.BuildID == lock.BuildID {
+		current = lock
+		p.deployLocks[key] = lock
+	}
+	holder := *current
+	return &holder, nil
+}
+
+func (p *Pipeline) extendDeployLock(ctx context.Context, key migrationKey, buildID string, expiresAt time.Time) error {
+	if p.store != nil {
+		return p.store.RenewDeployLock(ctx, key.projectID, key.environment, buildID, expiresAt)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current := p.deployLocks[key]
+	if current == nil || current.BuildID != buildID {
+		return types.ErrDeployLockNotFound
+	}
+	current.ExpiresAt = expiresAt
+	return nil
+}
+
+func (p *Pipeline) releaseDeployLock(ctx context.Context, key migrationKey, buildID string) error {
+	if p.store != nil {
+		return p.store.ReleaseDeployLock(ctx, key.projectID, key.environment, buildID)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current := p.deployLocks[key]
+	if current == nil || current.BuildID != buildID {
+		return types.ErrDeployLockNotFound
+	}
+	delete(p.deployLocks, key)
+	return nil
+}
+
+// ListDeployLocks returns the held deploy locks of the project's
+// environments with the builds of this process queued for them
+func (p *Pipeline) ListDeployLocks(ctx context.Context, projectID string) ([]types.DeployLock, error) {
+	var locks []types.DeployLock
+	if p.store != nil {
+		var err error
+		if locks, err = p.store.ListDeployLocks(ctx, projectID); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.store == nil {
+		for key, lock := range p.deployLocks {
+			if key.projectID == projectID && !lock.Expired(now) {
+				locks = append(locks, *lock)
+			}
+		}
+		sort.Slice(locks, func(i, j int) bool { return locks[i].Environment < locks[j].Environment })
+	}
+	for i := range locks {
+		for _, build := range p.deployQueue[migrationKey{projectID, locks[i].Environment}] {
+			if build.ID != locks[i].BuildID {
+				locks[i].Queued = append(locks[i].Queued, build.ID)
+			}
+		}
+	}
+	return locks, nil
+}
+
+// ReleaseDeployLock force-releases the lock of a project's environment,
+// e.g. one held by a stuck deploy, letting the next deploy start. The
+// holder records the lost lock when it next renews it.
+func (p *Pipeline) ReleaseDeployLock(ctx context.Context, projectID, environment string) (*types.DeployLock, error) {
+	locks, err := p.ListDeployLocks(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range locks {
+		if locks[i].Environment != environment {
+			continue
+		}
+		if err := p.releaseDeployLock(ctx, migrationKey{projectID, environment}, locks[i].BuildID); err != nil {
+			return nil, err
+		}
+		return &locks[i], nil
+	}
+	return nil, types.ErrDeployLockNotFound
+}
+
+func (h *Handler) ListDeployLocks(ctx context.Context, req *pb.ListDeployLocksRequest) (*pb.ListDeployLocksResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	locks, err := h.pipeline.ListDeployLocks(ctx, req.ProjectId)
+	if err != nil {
+		h.log.Error("failed to list deploy locks", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list deploy locks")
+	}
+
+	resp := &pb.ListDeployLocksResponse{Locks: make([]*pb.DeployLock, len(locks))}
+	for i := range locks {
+		resp.Locks[i] = deployLockToProto(&locks[i])
+	}
+	return resp, nil
+}
+
+func (h *Handler) ReleaseDeployLock(ctx context.Context, req *pb.ReleaseDeployLockRequest) (*pb.ReleaseDeployLockResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	lock, err := h.pipeline.ReleaseDeployLock(ctx, req.ProjectId, req.Environment)
+	if errors.Is(err, types.ErrDeployLockNotFound) {
+		return nil, status.Error(codes.NotFound, "the environment's deploy lock is not held")
+	}
+	if err != nil {
+		h.log.Error("failed to release deploy lock", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to release deploy lock")
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "deploy_lock.release", req.ProjectId, map[string]interface{}{
+		"environment": req.Environment,
+		"build_id":    lock.BuildID,
+		"owner":       lock.Owner,
+	})
+
+	return &pb.ReleaseDeployLockResponse{Lock: deployLockToProto(lock)}, nil
+}
+
+func deployLockToProto(lock *types.DeployLock) *pb.DeployLock {
+	return &pb.DeployLock{
+		ProjectId:    lock.ProjectID,
+		Environment:  lock.Environment,
+		BuildId:      lock.BuildID,
+		Owner:        lock.Owner,
+		AcquiredAt:   lock.AcquiredAt.Unix(),
+		ExpiresAt:    lock.ExpiresAt.Unix(),
+		QueuedBuilds: lock.Queued,
+	}
+}
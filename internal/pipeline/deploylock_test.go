@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+func lockedBuild(id string) *types.Build {
+	return &types.Build{ID: id, ProjectID: "shop", Environment: "production"}
+}
+
+func TestPipeline_LockDeploy_Serializes(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.instance = "host-1"
+	defer func(interval time.Duration) { deployLockPollInterval = interval }(deployLockPollInterval)
+	deployLockPollInterval = 10 * time.Millisecond
+
+	first, second := lockedBuild("b1"), lockedBuild("b2")
+	unlock, err := pipeline.lockDeploy(context.Background(), first)
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		unlock, err := pipeline.lockDeploy(context.Background(), second)
+		assert.NoError(t, err)
+		acquired <- unlock
+	}()
+
+	require.Eventually(t, func() bool {
+		locks, err := pipeline.ListDeployLocks(context.Background(), "shop")
+		require.NoError(t, err)
+		return len(locks) == 1 && len(locks[0].Queued) == 1
+	}, time.Second, 10*time.Millisecond)
+	locks, err := pipeline.ListDeployLocks(context.Background(), "shop")
+	require.NoError(t, err)
+	assert.Equal(t, "b1", locks[0].BuildID)
+	assert.Equal(t, "host-1", locks[0].Owner)
+	assert.Equal(t, []string{"b2"}, locks[0].Queued)
+
+	select {
+	case <-acquired:
+		t.Fatal("second deploy took a held lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	(<-acquired)()
+
+	pipeline.mu.RLock()
+	defer pipeline.mu.RUnlock()
+	require.Len(t, second.Events, 1)
+	assert.Equal(t, types.EventDeployLockWaiting, second.Events[0].Type)
+	assert.Equal(t, "build b1 is deploying on host-1", second.Events[0].Message)
+	assert.Empty(t, pipeline.deployLocks)
+	assert.Empty(t, pipeline.deployQueue)
+}
+
+func TestPipeline_LockDeploy_TakesOverExpiredLock(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.deployLocks = map[migrationKey]*types.DeployLock{
+		{"shop", "production"}: {ProjectID: "shop", Environment: "production", BuildID: "crashed", ExpiresAt: time.Now().Add(-time.Second)},
+	}
+
+	unlock, err := pipeline.lockDeploy(context.Background(), lockedBuild("b1"))
+	require.NoError(t, err)
+	defer unlock()
+
+	locks, err := pipeline.ListDeployLocks(context.Background(), "shop")
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	assert.Equal(t, "b1", locks[0].BuildID)
+}
+
+func TestPipeline_LockDeploy_TimesOut(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.config.DeployLock.Timeout = 1
+	defer func(interval time.Duration) { deployLockPollInterval = interval }(deployLockPollInterval)
+	deployLockPollInterval = 100 * time.Millisecond
+
+	unlock, err := pipeline.lockDeploy(context.Background(), lockedBuild("b1"))
+	require.NoError(t, err)
+	defer unlock()
+
+	_, err = pipeline.lockDeploy(context.Background(), lockedBuild("b2"))
+	assert.ErrorIs(t, err, ErrDeployLockTimeout)
+}
+
+func TestHandler_ReleaseDeployLock(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	auditor := &recordingAuditor{}
+	h := NewHandler(pipeline, nil, nil, nil, nil, ownerAuthorizer{}, auditor, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+
+	build := lockedBuild("b1")
+	unlock, err := pipeline.lockDeploy(context.Background(), build)
+	require.NoError(t, err)
+	defer unlock()
+
+	resp, err := h.ReleaseDeployLock(alice, &pb.ReleaseDeployLockRequest{ProjectId: "shop", Environment: "production"})
+	require.NoError(t, err)
+	assert.Equal(t, "b1", resp.Lock.BuildId)
+	assert.Equal(t, []string{"deploy_lock.release"}, auditor.actions)
+
+	list, err := h.ListDeployLocks(alice, &pb.ListDeployLocksRequest{ProjectId: "shop"})
+	require.NoError(t, err)
+	assert.Empty(t, list.Locks)
+
+	// The holder learns of it when renewing
+	err = pipeline.extendDeployLock(context.Background(), migrationKey{"shop", "production"}, "b1", time.Now().Add(time.Minute))
+	assert.ErrorIs(t, err, types.ErrDeployLockNotFound)
+
+	_, err = h.ReleaseDeployLock(alice, &pb.ReleaseDeployLockRequest{ProjectId: "shop", Environment: "production"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
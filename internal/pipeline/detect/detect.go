@@ -0,0 +1,132 @@
+// Package detect inspects a checked-out repository and proposes build
+// settings for it.
+package detect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupported is returned when no supported project type is found
+var ErrUnsupported = errors.New("no supported framework detected, only Node.js projects with a package.json are supported")
+
+// Settings are the proposed build settings for a repository
+type Settings struct {
+	Builder        string // Builder framework, e.g. nodejs
+	Framework      string // Detected web framework, e.g. nextjs
+	PackageManager string
+	InstallCommand string
+	BuildCommand   string
+	OutputDir      string
+	NodeVersion    string // Version constraint, empty when unpinned
+}
+
+type packageJSON struct {
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+	Scripts         map[string]string `json:"scripts"`
+	Engines         map[string]string `json:"engines"`
+}
+
+func (p *packageJSON) has(dependency string) bool {
+	if _, ok := p.Dependencies[dependency]; ok {
+		return true
+	}
+	_, ok := p.DevDependencies[dependency]
+	return ok
+}
+
+// frameworks are checked in order, meta-frameworks before the libraries
+// they build on
+var frameworks = []struct {
+	name       string
+	dependency string
+	outputDir  string
+}{
+	{"nextjs", "next", "out"},
+	{"nuxt", "nuxt", ".output/public"},
+	{"gatsby", "gatsby", "public"},
+	{"astro", "astro", "dist"},
+	{"sveltekit", "@sveltejs/kit", "build"},
+	{"angular", "@angular/core", "dist"},
+	{"create-react-app", "react-scripts", "build"},
+	{"vue-cli", "@vue/cli-service", "dist"},
+	{"vite", "vite", "dist"},
+}
+
+// lockfiles map to their package manager, npm is the fallback
+var lockfiles = []struct {
+	file    string
+	manager string
+	install string
+}{
+	{"pnpm-lock.yaml", "pnpm", "pnpm install --frozen-lockfile"},
+	{"yarn.lock", "yarn", "yarn install --frozen-lockfile"},
+	{"package-lock.json", "npm", "npm ci"},
+}
+
+// Detect proposes build settings for the repository checked out in dir
+func Detect(dir string) (*Settings, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrUnsupported
+		}
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+	var pkg packageJSON
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("invalid package.json: %w", err)
+	}
+
+	settings := &Settings{
+		Builder:        "nodejs",
+		Framework:      "node",
+		PackageManager: "npm",
+		InstallCommand: "npm install",
+		OutputDir:      "dist",
+		NodeVersion:    nodeVersion(dir, &pkg),
+	}
+	for _, framework := range frameworks {
+		if pkg.has(framework.dependency) {
+			settings.Framework = framework.name
+			settings.OutputDir = framework.outputDir
+			break
+		}
+	}
+	for _, lockfile := range lockfiles {
+		if _, err := os.Stat(filepath.Join(dir, lockfile.file)); err == nil {
+			settings.PackageManager = lockfile.manager
+			settings.InstallCommand = lockfile.install
+			break
+		}
+	}
+	if _, ok := pkg.Scripts["build"]; ok {
+		settings.BuildCommand = settings.PackageManager + " run build"
+	}
+	return settings, nil
+}
+
+// nodeVersion prefers engines.node and falls back to .nvmrc or
+// .node-version
+func nodeVersion(dir string, pkg *packageJSON) string {
+	if version := strings.TrimSpace(pkg.Engines["node"]); version != "" {
+		return version
+	}
+	for _, file := range []string{".nvmrc", ".node-version"} {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			continue
+		}
+		version := strings.TrimPrefix(strings.TrimSpace(string(data)), "v")
+		// Aliases such as lts/* cannot be pinned
+		if version != "" && !strings.Contains(version, "/") {
+			return version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package detect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected *Settings
+		err      error
+	}{
+		{
+			name: "next with pnpm and engines",
+			files: map[string]string{
+				"package.json":   `{"dependencies":{"next":"14.1.0","react":"18.2.0"},"scripts":{"build":"next build"},"engines":{"node":">=18"}}`,
+				"pnpm-lock.yaml": "",
+			},
+			expected: &Settings{
+				Builder: "nodejs", Framework: "nextjs", PackageManager: "pnpm",
+				InstallCommand: "pnpm install --frozen-lockfile", BuildCommand: "pnpm run build",
+				OutputDir: "out", NodeVersion: ">=18",
+			},
+		},
+		{
+			name: "vite with yarn and nvmrc",
+			files: map[string]string{
+				"package.json": `{"devDependencies":{"vite":"5.0.0"},"scripts":{"build":"vite build"}}`,
+				"yarn.lock":    "",
+				".nvmrc":       "v20.11.0\n",
+			},
+			expected: &Settings{
+				Builder: "nodejs", Framework: "vite", PackageManager: "yarn",
+				InstallCommand: "yarn install --frozen-lockfile", BuildCommand: "yarn run build",
+				OutputDir: "dist", NodeVersion: "20.11.0",
+			},
+		},
+		{
+			name: "create react app without build script",
+			files: map[string]string{
+				"package.json":      `{"dependencies":{"react-scripts":"5.0.1"}}`,
+				"package-lock.json": "{}",
+				".nvmrc":            "lts/*",
+			},
+			expected: &Settings{
+				Builder: "nodejs", Framework: "create-react-app", PackageManager: "npm",
+				InstallCommand: "npm ci", OutputDir: "build",
+			},
+		},
+		{
+			name:  "no package.json",
+			files: map[string]string{"go.mod": "module example.com/app"},
+			err:   ErrUnsupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+			}
+
+			settings, err := Detect(dir)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, settings)
+		})
+	}
+}
+
+func TestDetect_InvalidPackageJSON(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte("{"), 0644))
+
+	_, err := Detect(dir)
+	assert.ErrorContains(t, err, "invalid package.json")
+}
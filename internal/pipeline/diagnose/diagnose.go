@@ -0,0 +1,153 @@
+// Package diagnose recognizes known causes of failed builds and deploys in
+// their output and suggests a fix.
+package diagnose
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// Rule names as recorded in diagnoses
+const (
+	RuleNoSpace        = "no_space_left"
+	RuleOutOfMemory    = "out_of_memory"
+	RulePeerDependency = "peer_dependency"
+	RuleNodeVersion    = "node_version"
+	RuleMissingModule  = "missing_module"
+
+	// Pods of a deploy that could not pull the build's image
+	RuleImagePullAuth    = "image_pull_auth"
+	RuleImageNotFound    = "image_not_found"
+	RuleImagePullNetwork = "image_pull_network"
+	RuleImagePull        = "image_pull_failed"
+)
+
+// rule recognizes one cause. Cause and suggestion may refer to the named
+// groups of the patterns, e.g. ${required}.
+type rule struct {
+	name       string
+	patterns   []*regexp.Regexp
+	cause      string
+	suggestion string
+}
+
+// rules are tried in order, so causes that make other errors follow, such
+// as a full disk, come first and engine warnings, which npm also prints for
+// builds failing otherwise, last
+var rules = []rule{
+	{
+		name: RuleNoSpace,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)no space left on device`),
+		},
+		cause:      "The build host ran out of disk space",
+		suggestion: "Free disk space on the build host, e.g. by enabling image garbage collection, or remove large files from the build context with a .dockerignore",
+	},
+	{
+		name: RuleOutOfMemory,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`JavaScript heap out of memory`),
+			regexp.MustCompile(`FATAL ERROR: .*Allocation failed`),
+			regexp.MustCompile(`returned a non-zero code: 137`),
+			regexp.MustCompile(`exit code: 137`),
+			regexp.MustCompile(`npm ERR! signal SIGKILL`),
+		},
+		cause:      "The build ran out of memory and was killed",
+		suggestion: "Give the build more memory with build.memory in chef.yaml, or cap Node's heap below the limit with build.max_old_space_size in megabytes",
+	},
+	{
+		name: RulePeerDependency,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`ERESOLVE (?:unable to resolve dependency tree|could not resolve)`),
+			regexp.MustCompile(`(?i)unmet peer dependency`),
+			regexp.MustCompile(`ERR_PNPM_PEER_DEP_ISSUES`),
+		},
+		cause:      "A dependency's peer dependency is missing or conflicts with the installed version",
+		suggestion: "Install a version satisfying the peer range listed in the output, or set NPM_CONFIG_LEGACY_PEER_DEPS=true in the build environment to install anyway",
+	},
+	{
+		name: RuleMissingModule,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`Cannot find module '(?P<module>[^'./][^']*)'`),
+			regexp.MustCompile(`Module not found: (?:Error: )?Can't resolve '(?P<module>[^'./][^']*)'`),
+		},
+		cause:      "The package ${module} is imported but not installed",
+		suggestion: "Add ${module} to the dependencies in package.json and commit the updated lockfile",
+	},
+	{
+		name: RuleNodeVersion,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`The engine "node" is incompatible with this module\. Expected version "(?P<required>[^"]+)"`),
+			regexp.MustCompile(`required: \{ node: '(?P<required>[^']+)'`),
+			regexp.MustCompile(`Expected version: (?P<required>\S+)\s+Got: \S+`),
+		},
+		cause:      "A dependency requires Node ${required}, which differs from the build's Node version",
+		suggestion: `Set "engines.node" in package.json to a version matching ${required}`,
+	},
+	{
+		name: RuleImagePullAuth,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)failed to pull image "(?P<image>[^"]+)": .*(?:401 Unauthorized|403 Forbidden|unauthorized|authentication required|no basic auth credentials|failed to authorize)`),
+		},
+		cause:      "The cluster is not allowed to pull ${image} from its registry",
+		suggestion: "Check that deploy.pull_secret names a secret in the deploy namespace holding valid credentials for the registry",
+	},
+	{
+		name: RuleImageNotFound,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)failed to pull image "(?P<image>[^"]+)": .*(?:not found|manifest unknown|repository does not exist)`),
+		},
+		cause:      "The image ${image} does not exist in its registry",
+		suggestion: "Check that the build pushed the image to deploy.registry and that the registry is reachable under the same name from the cluster",
+	},
+	{
+		name: RuleImagePullNetwork,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)failed to pull image "(?P<image>[^"]+)": .*(?:dial tcp|no such host|i/o timeout|connection refused|connection reset|TLS handshake timeout|context deadline exceeded)`),
+		},
+		cause:      "The cluster's nodes could not reach the registry of ${image}",
+		suggestion: "Check the nodes' DNS and network access to the registry, including proxies and firewalls",
+	},
+	{
+		name: RuleImagePull,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`Failed to pull image "(?P<image>[^"]+)"`),
+		},
+		cause:      "The cluster could not pull ${image}",
+		suggestion: "Look for the reason in the pod's events listed in the details",
+	},
+}
+
+// Analyze returns the first known cause found in the output of a failed
+// build, or nil when none matches
+func Analyze(output string) *types.Diagnosis {
+	for _, r := range rules {
+		for _, pattern := range r.patterns {
+			match := pattern.FindStringSubmatchIndex(output)
+			if match == nil {
+				continue
+			}
+			return &types.Diagnosis{
+				Rule:       r.name,
+				Cause:      string(pattern.ExpandString(nil, r.cause, output, match)),
+				Suggestion: string(pattern.ExpandString(nil, r.suggestion, output, match)),
+				Line:       line(output, match[0]),
+			}
+		}
+	}
+	return nil
+}
+
+// line returns the line of output containing offset, trimmed
+func line(output string, offset int) string {
+	start := strings.LastIndexByte(output[:offset], '\n') + 1
+	end := strings.IndexByte(output[offset:], '\n')
+	if end < 0 {
+		end = len(output)
+	} else {
+		end += offset
+	}
+	return strings.TrimSpace(output[start:end])
+}
@@ -0,0 +1,125 @@
+package diagnose
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		rule       string
+		cause      string
+		suggestion string
+		line       string
+	}{
+		{
+			name: "heap out of memory",
+			output: "Step 6/12 : RUN npm ci\n" +
+				"<--- Last few GCs --->\n" +
+				"FATAL ERROR: Reached heap limit Allocation failed - JavaScript heap out of memory\n",
+			rule: RuleOutOfMemory,
+			line: "FATAL ERROR: Reached heap limit Allocation failed - JavaScript heap out of memory",
+		},
+		{
+			name:   "killed install",
+			output: "docker build error: The command '/bin/sh -c npm ci' returned a non-zero code: 137",
+			rule:   RuleOutOfMemory,
+		},
+		{
+			name: "peer dependency",
+			output: "npm ERR! code ERESOLVE\n" +
+				"npm ERR! ERESOLVE unable to resolve dependency tree\n" +
+				"npm ERR! Could not resolve dependency:\n" +
+				"npm ERR! peer react@\"^17.0.0\" from react-beautiful-dnd@13.1.1\n",
+			rule: RulePeerDependency,
+			line: "npm ERR! ERESOLVE unable to resolve dependency tree",
+		},
+		{
+			name: "npm engine",
+			output: "npm WARN EBADENGINE Unsupported engine {\n" +
+				"npm WARN EBADENGINE   package: 'vite@5.0.0',\n" +
+				"npm WARN EBADENGINE   required: { node: '^18.0.0 || >=20.0.0' },\n" +
+				"npm WARN EBADENGINE   current: { node: 'v16.20.2', npm: '8.19.4' }\n",
+			rule:       RuleNodeVersion,
+			cause:      "A dependency requires Node ^18.0.0 || >=20.0.0, which differs from the build's Node version",
+			suggestion: `Set "engines.node" in package.json to a version matching ^18.0.0 || >=20.0.0`,
+		},
+		{
+			name:       "yarn engine",
+			output:     `error vite@5.0.0: The engine "node" is incompatible with this module. Expected version "^18.0.0 || >=20.0.0". Got "16.20.2"`,
+			rule:       RuleNodeVersion,
+			suggestion: `Set "engines.node" in package.json to a version matching ^18.0.0 || >=20.0.0`,
+		},
+		{
+			name: "image pull unauthorized",
+			output: `deployment failed: rollout did not finish: pod shop-7d9f-x2k4l failed to pull image "registry.local/shop:abc": ` +
+				`Failed to pull image "registry.local/shop:abc": failed to authorize: failed to fetch oauth token: unexpected status: 401 Unauthorized`,
+			rule:  RuleImagePullAuth,
+			cause: "The cluster is not allowed to pull registry.local/shop:abc from its registry",
+		},
+		{
+			name:   "image not found",
+			output: `Failed to pull image "registry.local/shop:abc": rpc error: code = NotFound desc = failed to pull and unpack image "registry.local/shop:abc": failed to resolve reference "registry.local/shop:abc": registry.local/shop:abc: not found`,
+			rule:   RuleImageNotFound,
+		},
+		{
+			name:   "registry unreachable",
+			output: `Failed to pull image "registry.local/shop:abc": rpc error: code = Unknown desc = failed to do request: Head "https://registry.local/v2/shop/manifests/abc": dial tcp: lookup registry.local: no such host`,
+			rule:   RuleImagePullNetwork,
+		},
+		{
+			name:   "invalid image name",
+			output: `Failed to pull image "registry.local/Shop:abc": couldn't parse image reference "registry.local/Shop:abc": invalid reference format: repository name must be lowercase`,
+			rule:   RuleImagePull,
+		},
+		{
+			name:   "disk full",
+			output: "npm ERR! code ENOSPC\nnpm ERR! syscall write\nnpm ERR! ENOSPC: no space left on device, write\n",
+			rule:   RuleNoSpace,
+			line:   "npm ERR! ENOSPC: no space left on device, write",
+		},
+		{
+			name: "disk full before running out of memory",
+			output: "FATAL ERROR: Reached heap limit Allocation failed - JavaScript heap out of memory\n" +
+				"write /var/lib/docker/tmp: no space left on device\n",
+			rule: RuleNoSpace,
+		},
+		{
+			name:   "missing package",
+			output: "Module not found: Error: Can't resolve 'lodash' in '/app/src'",
+			rule:   RuleMissingModule,
+			cause:  "The package lodash is imported but not installed",
+		},
+		{
+			name:   "missing relative import",
+			output: "Module not found: Error: Can't resolve './App' in '/app/src'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnosis := Analyze(tt.output)
+			if tt.rule == "" {
+				assert.Nil(t, diagnosis)
+				return
+			}
+			require.NotNil(t, diagnosis)
+			assert.Equal(t, tt.rule, diagnosis.Rule)
+			assert.NotEmpty(t, diagnosis.Cause)
+			assert.NotEmpty(t, diagnosis.Suggestion)
+			if tt.cause != "" {
+				assert.Equal(t, tt.cause, diagnosis.Cause)
+			}
+			if tt.suggestion != "" {
+				assert.Equal(t, tt.suggestion, diagnosis.Suggestion)
+			}
+			if tt.line != "" {
+				assert.Equal(t, tt.line, diagnosis.Line)
+			}
+		})
+	}
+}
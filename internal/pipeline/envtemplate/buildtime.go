@@ -0,0 +1,70 @@
+package envtemplate
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultPublicPrefixes mark the variables frontend toolchains inline into
+// the client bundle at build time
+var DefaultPublicPrefixes = []string{"REACT_APP_", "VITE_", "NEXT_PUBLIC_", "NUXT_PUBLIC_", "GATSBY_", "PUBLIC_"}
+
+// secretMarkers in a variable name suggest its value must stay server-side
+var secretMarkers = []string{"SECRET", "TOKEN", "PASSWORD", "PASSWD", "PRIVATE", "API_KEY", "APIKEY", "CREDENTIAL"}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Public returns the variables of vars that are exposed to the frontend
+// build, selected by name prefix. DefaultPublicPrefixes apply when prefixes
+// is empty.
+func Public(vars map[string]string, prefixes []string) map[string]string {
+	if len(prefixes) == 0 {
+		prefixes = DefaultPublicPrefixes
+	}
+
+	public := make(map[string]string)
+	for key, value := range vars {
+		if !envName.MatchString(key) {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				public[key] = value
+				break
+			}
+		}
+	}
+	return public
+}
+
+// LooksSecret reports whether a variable name suggests a credential
+func LooksSecret(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// BundledSecrets lists the public variables that appear to carry a secret,
+// either by name or because they copy the value of a secret-looking
+// variable of the same set. Their values end up readable in the bundle.
+func BundledSecrets(vars map[string]string, prefixes []string) []string {
+	secretValues := make(map[string]bool)
+	for key, value := range vars {
+		if value != "" && LooksSecret(key) {
+			secretValues[value] = true
+		}
+	}
+
+	public := Public(vars, prefixes)
+	var bundled []string
+	for _, key := range SortedKeys(public) {
+		if LooksSecret(key) || secretValues[public[key]] {
+			bundled = append(bundled, key)
+		}
+	}
+	return bundled
+}
@@ -0,0 +1,97 @@
+package envtemplate
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// Data is the set of values available to env var templates. Only the fields
+// declared here may be referenced; anything else fails validation.
+type Data struct {
+	Build       BuildData
+	Project     ProjectData
+	Environment EnvironmentData
+}
+
+type BuildData struct {
+	ID          string
+	CommitHash  string
+	ImageID     string
+	NodeVersion string
+}
+
+type ProjectData struct {
+	ID     string
+	Domain string
+}
+
+type EnvironmentData struct {
+	Name string
+}
+
+// NewData builds template values for a build deployed under domain
+func NewData(build *types.Build, domain string) Data {
+	return Data{
+		Build: BuildData{
+			ID:          build.ID,
+			CommitHash:  build.CommitHash,
+			ImageID:     build.ImageID,
+			NodeVersion: build.NodeVersion,
+		},
+		Project: ProjectData{
+			ID:     build.ProjectID,
+			Domain: domain,
+		},
+		Environment: EnvironmentData{
+			Name: build.Environment,
+		},
+	}
+}
+
+// Validate parses every value and evaluates it against empty data so unknown
+// keys are rejected before a build starts rather than at deploy time.
+func Validate(vars map[string]string) error {
+	_, err := Render(vars, Data{})
+	return err
+}
+
+// Render resolves all templated values in vars
+func Render(vars map[string]string, data Data) (map[string]string, error) {
+	rendered := make(map[string]string, len(vars))
+
+	for _, key := range SortedKeys(vars) {
+		value := vars[key]
+		if !strings.Contains(value, "{{") {
+			rendered[key] = value
+			continue
+		}
+
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template in env var %s: %w", key, err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("invalid template in env var %s: %w", key, err)
+		}
+		rendered[key] = buf.String()
+	}
+
+	return rendered, nil
+}
+
+// SortedKeys returns the keys of vars in a stable order
+func SortedKeys(vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,84 @@
+package envtemplate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestRender(t *testing.T) {
+	build := &types.Build{
+		ID:          "build-1",
+		ProjectID:   "shop",
+		CommitHash:  "abc123",
+		Environment: "production",
+	}
+
+	rendered, err := Render(map[string]string{
+		"RELEASE":  "{{ .Build.CommitHash }}",
+		"SITE_URL": "https://{{ .Project.Domain }}",
+		"ENV_NAME": "{{ .Environment.Name }}",
+		"PLAIN":    "value",
+	}, NewData(build, "shop.example.com"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "abc123", rendered["RELEASE"])
+	assert.Equal(t, "https://shop.example.com", rendered["SITE_URL"])
+	assert.Equal(t, "production", rendered["ENV_NAME"])
+	assert.Equal(t, "value", rendered["PLAIN"])
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		vars        map[string]string
+		expectError bool
+	}{
+		{name: "known keys", vars: map[string]string{"A": "{{ .Build.ID }}-{{ .Project.ID }}"}},
+		{name: "unknown field", vars: map[string]string{"A": "{{ .Build.Branch }}"}, expectError: true},
+		{name: "unknown root", vars: map[string]string{"A": "{{ .Secrets.Token }}"}, expectError: true},
+		{name: "syntax error", vars: map[string]string{"A": "{{ .Build.ID "}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.vars)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestPublic(t *testing.T) {
+	vars := map[string]string{
+		"VITE_API_URL":      "https://api.example.com",
+		"REACT_APP_VERSION": "{{ .Build.CommitHash }}",
+		"DATABASE_URL":      "postgres://db",
+		"VITE-BAD":          "x",
+	}
+
+	assert.Equal(t, map[string]string{
+		"VITE_API_URL":      "https://api.example.com",
+		"REACT_APP_VERSION": "{{ .Build.CommitHash }}",
+	}, Public(vars, nil))
+	assert.Equal(t, map[string]string{"DATABASE_URL": "postgres://db"}, Public(vars, []string{"DATABASE_"}))
+}
+
+func TestBundledSecrets(t *testing.T) {
+	vars := map[string]string{
+		"STRIPE_SECRET_KEY":   "sk_live_123",
+		"VITE_STRIPE_KEY":     "sk_live_123",
+		"VITE_GITHUB_TOKEN":   "ghp_abc",
+		"VITE_API_URL":        "https://api.example.com",
+		"REACT_APP_AUTH_HOST": "auth.example.com",
+	}
+
+	assert.Equal(t, []string{"VITE_GITHUB_TOKEN", "VITE_STRIPE_KEY"}, BundledSecrets(vars, nil))
+	assert.Empty(t, BundledSecrets(map[string]string{"VITE_API_URL": "x"}, nil))
+}
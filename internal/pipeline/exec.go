@@ -0,0 +1,245 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const (
+	defaultExecSessionTimeout = 15 * time.Minute
+	defaultExecIdleTimeout    = 5 * time.Minute
+)
+
+// AuditRecorder stores audit entries for privileged operations
+type AuditRecorder interface {
+	Record(actor, action, resource string, details map[string]interface{}) error
+}
+
+// ExecApp opens an interactive session in a project's running workload. The
+// session is limited to allowlisted commands, bounded by session and idle
+// timeouts, and audited from start to end. Only the amount of input is
+// audited since it may hold passwords or tokens typed into the session.
+func (h *Handler) ExecApp(stream pb.Pipeline_ExecAppServer) error {
+	ctx := stream.Context()
+	cfg := h.pipeline.config.Exec
+
+	if !cfg.Enabled {
+		return status.Error(codes.FailedPrecondition, "exec sessions are disabled")
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "missing session request")
+	}
+	if err := h.authorizeProject(ctx, first.ProjectId); err != nil {
+		return err
+	}
+	if len(first.Command) == 0 {
+		return status.Error(codes.InvalidArgument, "command is required")
+	}
+	if !commandAllowed(cfg, first.Command[0]) {
+		return status.Errorf(codes.PermissionDenied, "command %q is not allowed", first.Command[0])
+	}
+
+	execer, ok := h.deployer.(deployer.Execer)
+	if !ok {
+		return status.Error(codes.Unimplemented, "exec is not supported by the deployment platform")
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "exec.start", first.ProjectId, map[string]interface{}{
+		"command": first.Command,
+		"tty":     first.Tty,
+	})
+
+	sessionTimeout, idleTimeout := execTimeouts(cfg)
+	sessionCtx, cancel := context.WithTimeout(ctx, sessionTimeout)
+	defer cancel()
+
+	session := &execSession{
+		stream: stream,
+		idle:   time.AfterFunc(idleTimeout, cancel),
+	}
+	defer session.idle.Stop()
+
+	stdin, stdinWriter := io.Pipe()
+	go session.pumpStdin(first.Stdin, stdinWriter, idleTimeout, func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		return req.Stdin, nil
+	})
+
+	start := time.Now()
+	execErr := execer.Exec(sessionCtx, first.ProjectId, deployer.ExecOptions{
+		Command: first.Command,
+		Stdin:   stdin,
+		Stdout:  session.writer(false),
+		Stderr:  session.writer(true),
+		TTY:     first.Tty,
+	})
+	stdin.Close()
+
+	result := &pb.ExecResponse{Exited: true}
+	var exitErr utilexec.ExitError
+	switch {
+	case execErr == nil:
+	case errors.As(execErr, &exitErr):
+		result.ExitCode = int32(exitErr.ExitStatus())
+	case sessionCtx.Err() != nil && ctx.Err() == nil:
+		result.ExitCode = -1
+		result.Error = "session timed out"
+	default:
+		result.ExitCode = -1
+		result.Error = execErr.Error()
+	}
+
+	h.audit(username, "exec.end", first.ProjectId, map[string]interface{}{
+		"command":     first.Command,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"exit_code":   result.ExitCode,
+		"error":       result.Error,
+		"stdin_bytes": session.inputBytes(),
+	})
+
+	if ctx.Err() != nil {
+		// Client went away, nothing left to report
+		return nil
+	}
+	return session.send(result)
+}
+
+func (h *Handler) audit(actor, action, resource string, details map[string]interface{}) {
+	if err := h.auditor.Record(actor, action, resource, details); err != nil {
+		h.log.Error("failed to record audit entry",
+			zap.String("action", action),
+			zap.String("resource", resource),
+			zap.Error(err))
+	}
+}
+
+// execTimeouts returns the session and idle timeouts of interactive
+// sessions
+func execTimeouts(cfg config.ExecConfig) (time.Duration, time.Duration) {
+	sessionTimeout := defaultExecSessionTimeout
+	if cfg.SessionTimeout > 0 {
+		sessionTimeout = time.Duration(cfg.SessionTimeout) * time.Second
+	}
+	idleTimeout := defaultExecIdleTimeout
+	if cfg.IdleTimeout > 0 {
+		idleTimeout = time.Duration(cfg.IdleTimeout) * time.Second
+	}
+	return sessionTimeout, idleTimeout
+}
+
+// commandAllowed reports whether command is allowlisted exactly as sent,
+// so /tmp/x/ls does not pass for ls
+func commandAllowed(cfg config.ExecConfig, command string) bool {
+	for _, allowed := range cfg.AllowedCommands {
+		if command == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// execStream is the server side of ExecApp and DebugBuild
+type execStream interface {
+	Send(*pb.ExecResponse) error
+}
+
+// execSession multiplexes process output onto the gRPC stream and tracks
+// client input for idle detection and auditing.
+type execSession struct {
+	stream     execStream
+	idle       *time.Timer
+	sendMu     sync.Mutex
+	logMu      sync.Mutex
+	stdinBytes int
+}
+
+func (s *execSession) send(resp *pb.ExecResponse) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return s.stream.Send(resp)
+}
+
+// pumpStdin writes client input to w until recv fails
+func (s *execSession) pumpStdin(initial []byte, w *io.PipeWriter, idleTimeout time.Duration, recv func() ([]byte, error)) {
+	write := func(data []byte) error {
+		if len(data) == 0 {
+			return nil
+		}
+		s.idle.Reset(idleTimeout)
+		s.record(data)
+		_, err := w.Write(data)
+		return err
+	}
+
+	if err := write(initial); err != nil {
+		w.CloseWithError(err)
+		return
+	}
+	for {
+		data, err := recv()
+		if err != nil {
+			// io.EOF closes stdin cleanly, anything else aborts it
+			if errors.Is(err, io.EOF) {
+				w.Close()
+			} else {
+				w.CloseWithError(err)
+			}
+			return
+		}
+		if err := write(data); err != nil {
+			w.CloseWithError(err)
+			return
+		}
+	}
+}
+
+func (s *execSession) record(data []byte) {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	s.stdinBytes += len(data)
+}
+
+// inputBytes returns how much input the client sent
+func (s *execSession) inputBytes() int {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	return s.stdinBytes
+}
+
+func (s *execSession) writer(stderr bool) io.Writer {
+	return execOutput(func(p []byte) error {
+		data := append([]byte(nil), p...)
+		if stderr {
+			return s.send(&pb.ExecResponse{Stderr: data})
+		}
+		return s.send(&pb.ExecResponse{Stdout: data})
+	})
+}
+
+type execOutput func([]byte) error
+
+func (f execOutput) Write(p []byte) (int, error) {
+	if err := f(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// echoDeployer copies stdin to stdout like `cat`
+type echoDeployer struct {
+	mockDeployer
+}
+
+func (d *echoDeployer) Exec(_ context.Context, _ string, opts deployer.ExecOptions) error {
+	_, err := io.Copy(opts.Stdout, opts.Stdin)
+	return err
+}
+
+type ownerAuthorizer struct{}
+
+func (ownerAuthorizer) CanAccessProject(username, projectID string) (bool, error) {
+	return username == "alice", nil
+}
+
+func (ownerAuthorizer) OwnsProject(username, projectID string) (bool, error) {
+	return username == "alice", nil
+}
+
+type recordingAuditor struct {
+	mu      sync.Mutex
+	actions []string
+	details []map[string]interface{}
+}
+
+func (a *recordingAuditor) Record(_, action, _ string, details map[string]interface{}) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.actions = append(a.actions, action)
+	a.details = append(a.details, details)
+	return nil
+}
+
+type fakeExecStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests chan *pb.ExecRequest
+	mu       sync.Mutex
+	sent     []*pb.ExecResponse
+}
+
+func (s *fakeExecStream) Context() context.Context { return s.ctx }
+
+func (s *fakeExecStream) Recv() (*pb.ExecRequest, error) {
+	req, ok := <-s.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (s *fakeExecStream) Send(resp *pb.ExecResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, resp)
+	return nil
+}
+
+func newExecHandler(execCfg config.ExecConfig) (*Handler, *recordingAuditor) {
+	auditor := &recordingAuditor{}
+	p := &Pipeline{config: &config.PipelineConfig{Exec: execCfg}}
+	return NewHandler(p, nil, nil, nil, &echoDeployer{}, ownerAuthorizer{}, auditor, zap.NewNop()), auditor
+}
+
+func runExec(h *Handler, username string, requests ...*pb.ExecRequest) (*fakeExecStream, error) {
+	stream := &fakeExecStream{
+		ctx:      context.WithValue(context.Background(), auth.UserContextKey, username),
+		requests: make(chan *pb.ExecRequest, len(requests)),
+	}
+	for _, req := range requests {
+		stream.requests <- req
+	}
+	close(stream.requests)
+	return stream, h.ExecApp(stream)
+}
+
+func TestHandler_ExecApp(t *testing.T) {
+	enabled := config.ExecConfig{Enabled: true, AllowedCommands: []string{"cat"}}
+
+	t.Run("session echoes input and is audited", func(t *testing.T) {
+		h, auditor := newExecHandler(enabled)
+
+		stream, err := runExec(h, "alice",
+			&pb.ExecRequest{ProjectId: "shop", Command: []string{"cat"}, Stdin: []byte("hello ")},
+			&pb.ExecRequest{Stdin: []byte("world")},
+		)
+		require.NoError(t, err)
+
+		var out strings.Builder
+		for _, resp := range stream.sent {
+			out.Write(resp.Stdout)
+		}
+		assert.Equal(t, "hello world", out.String())
+
+		last := stream.sent[len(stream.sent)-1]
+		assert.True(t, last.Exited)
+		assert.Equal(t, int32(0), last.ExitCode)
+
+		assert.Equal(t, []string{"exec.start", "exec.end"}, auditor.actions)
+		assert.Equal(t, len("hello world"), auditor.details[1]["stdin_bytes"])
+		assert.NotContains(t, auditor.details[1], "stdin", "input may hold secrets")
+	})
+
+	tests := []struct {
+		name     string
+		cfg      config.ExecConfig
+		username string
+		request  *pb.ExecRequest
+		code     codes.Code
+	}{
+		{
+			name:     "disabled",
+			cfg:      config.ExecConfig{AllowedCommands: []string{"cat"}},
+			username: "alice",
+			request:  &pb.ExecRequest{ProjectId: "shop", Command: []string{"cat"}},
+			code:     codes.FailedPrecondition,
+		},
+		{
+			name:     "not the owner",
+			cfg:      enabled,
+			username: "bob",
+			request:  &pb.ExecRequest{ProjectId: "shop", Command: []string{"cat"}},
+			code:     codes.PermissionDenied,
+		},
+		{
+			name:     "command not allowed",
+			cfg:      enabled,
+			username: "alice",
+			request:  &pb.ExecRequest{ProjectId: "shop", Command: []string{"rm", "-rf", "/"}},
+			code:     codes.PermissionDenied,
+		},
+		{
+			name:     "path ending in an allowed name",
+			cfg:      enabled,
+			username: "alice",
+			request:  &pb.ExecRequest{ProjectId: "shop", Command: []string{"/tmp/x/cat"}},
+			code:     codes.PermissionDenied,
+		},
+		{
+			name:     "missing command",
+			cfg:      enabled,
+			username: "alice",
+			request:  &pb.ExecRequest{ProjectId: "shop"},
+			code:     codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, auditor := newExecHandler(tt.cfg)
+
+			_, err := runExec(h, tt.username, tt.request)
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Empty(t, auditor.actions)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/elskow/chef-infra/internal/pipeline/faults"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// faultyStore injects DBWrite faults into the writes of a BuildStore
+type faultyStore struct {
+	BuildStore
+	injector *faults.Injector
+}
+
+func (s *faultyStore) CreateBuild(ctx context.Context, build *types.Build) error {
+	if err := s.injector.Check(ctx, faults.DBWrite); err != nil {
+		return err
+	}
+	return s.BuildStore.CreateBuild(ctx, build)
+}
+
+func (s *faultyStore) SaveBuild(ctx context.Context, build *types.Build, events []types.DeploymentEvent) error {
+	if err := s.injector.Check(ctx, faults.DBWrite); err != nil {
+		return err
+	}
+	return s.BuildStore.SaveBuild(ctx, build, events)
+}
+
+func (s *faultyStore) SetPinned(ctx context.Context, id string, pinned bool) error {
+	if err := s.injector.Check(ctx, faults.DBWrite); err != nil {
+		return err
+	}
+	return s.BuildStore.SetPinned(ctx, id, pinned)
+}
+
+func (s *faultyStore) DeleteProjectBuilds(ctx context.Context, projectID string) error {
+	if err := s.injector.Check(ctx, faults.DBWrite); err != nil {
+		return err
+	}
+	return s.BuildStore.DeleteProjectBuilds(ctx, projectID)
+}
+
+func (s *faultyStore) SaveMigration(ctx context.Context, migration *types.Migration) error {
+	if err := s.injector.Check(ctx, faults.DBWrite); err != nil {
+		return err
+	}
+	return s.BuildStore.SaveMigration(ctx, migration)
+}
@@ -0,0 +1,150 @@
+// Package faults injects failures and hangs into pipeline operations so
+// integration tests can verify retry, rollback and timeout behavior
+// without real outages. It is only enabled in the testing environment.
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+var ErrInjected = errors.New("injected fault")
+
+// Points at which faults are injected
+const (
+	DockerBuild    = "docker_build"
+	ArtifactUpload = "artifact_upload"
+	K8sApply       = "k8s_apply"
+	DBWrite        = "db_write"
+)
+
+// Fault modes
+const (
+	Fail = "fail"
+	Hang = "hang"
+)
+
+var points = map[string]bool{DockerBuild: true, ArtifactUpload: true, K8sApply: true, DBWrite: true}
+
+// Fault is what happens to the operations at a point
+type Fault struct {
+	Mode    string
+	Message string
+	// Times is the number of operations affected, all when 0
+	Times int
+	// Duration is how long a hang lasts before the operation goes on,
+	// until its context is done when 0
+	Duration time.Duration
+}
+
+// Injector holds the faults of every point. A nil Injector injects
+// nothing.
+type Injector struct {
+	mu     sync.Mutex
+	faults map[string]*Fault
+	hits   map[string]int
+}
+
+// New returns the injector of cfg, nil when fault injection is disabled
+func New(cfg *config.FaultsConfig) (*Injector, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	i := &Injector{faults: make(map[string]*Fault), hits: make(map[string]int)}
+	for _, f := range cfg.Faults {
+		err := i.Set(f.Point, Fault{
+			Mode:     f.Mode,
+			Message:  f.Message,
+			Times:    f.Times,
+			Duration: time.Duration(f.Duration) * time.Millisecond,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+func (i *Injector) Enabled() bool {
+	return i != nil
+}
+
+// Set replaces the fault at point
+func (i *Injector) Set(point string, fault Fault) error {
+	if !points[point] {
+		return fmt.Errorf("unknown fault point %q", point)
+	}
+	if fault.Mode != Fail && fault.Mode != Hang {
+		return fmt.Errorf("invalid mode %q of fault %s, expected fail or hang", fault.Mode, point)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.faults[point] = &fault
+	return nil
+}
+
+// Clear removes the fault at point, or all faults when point is empty
+func (i *Injector) Clear(point string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if point == "" {
+		i.faults = make(map[string]*Fault)
+		return
+	}
+	delete(i.faults, point)
+}
+
+// Hits returns the number of operations at point a fault was injected into
+func (i *Injector) Hits(point string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.hits[point]
+}
+
+// Check applies the fault at point to an operation. It returns an error
+// wrapping ErrInjected when the operation has to fail.
+func (i *Injector) Check(ctx context.Context, point string) error {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	fault, ok := i.faults[point]
+	if !ok {
+		i.mu.Unlock()
+		return nil
+	}
+	f := *fault
+	if fault.Times > 0 {
+		if fault.Times--; fault.Times == 0 {
+			delete(i.faults, point)
+		}
+	}
+	i.hits[point]++
+	i.mu.Unlock()
+
+	if f.Mode == Hang {
+		var expired <-chan time.Time
+		if f.Duration > 0 {
+			timer := time.NewTimer(f.Duration)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("%w at %s: %w", ErrInjected, point, ctx.Err())
+		}
+	}
+
+	message := f.Message
+	if message == "" {
+		message = "operation failed"
+	}
+	return fmt.Errorf("%w at %s: %s", ErrInjected, point, message)
+}
@@ -0,0 +1,80 @@
+package faults
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestNew(t *testing.T) {
+	injector, err := New(&config.FaultsConfig{Faults: []config.FaultConfig{{Point: DBWrite, Mode: Fail}}})
+	require.NoError(t, err)
+	assert.False(t, injector.Enabled())
+	assert.NoError(t, injector.Check(context.Background(), DBWrite))
+
+	_, err = New(&config.FaultsConfig{Enabled: true, Faults: []config.FaultConfig{{Point: "dns", Mode: Fail}}})
+	assert.Error(t, err)
+	_, err = New(&config.FaultsConfig{Enabled: true, Faults: []config.FaultConfig{{Point: DBWrite, Mode: "slow"}}})
+	assert.Error(t, err)
+
+	injector, err = New(&config.FaultsConfig{Enabled: true, Faults: []config.FaultConfig{
+		{Point: DBWrite, Mode: Fail, Message: "connection reset", Times: 2},
+	}})
+	require.NoError(t, err)
+	assert.True(t, injector.Enabled())
+	ctx := context.Background()
+
+	err = injector.Check(ctx, DBWrite)
+	assert.ErrorIs(t, err, ErrInjected)
+	assert.Contains(t, err.Error(), "connection reset")
+	assert.ErrorIs(t, injector.Check(ctx, DBWrite), ErrInjected)
+	assert.NoError(t, injector.Check(ctx, DBWrite), "only the first two writes fail")
+	assert.NoError(t, injector.Check(ctx, K8sApply))
+	assert.Equal(t, 2, injector.Hits(DBWrite))
+}
+
+func TestInjector_Hang(t *testing.T) {
+	injector, err := New(&config.FaultsConfig{Enabled: true})
+	require.NoError(t, err)
+
+	// Hangs without a duration last until the operation is cancelled
+	require.NoError(t, injector.Set(K8sApply, Fault{Mode: Hang}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = injector.Check(ctx, K8sApply)
+	assert.ErrorIs(t, err, ErrInjected)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, injector.Set(K8sApply, Fault{Mode: Hang, Duration: 10 * time.Millisecond}))
+	start := time.Now()
+	assert.NoError(t, injector.Check(context.Background(), K8sApply))
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	injector.Clear("")
+	assert.NoError(t, injector.Check(context.Background(), K8sApply))
+	assert.Equal(t, 2, injector.Hits(K8sApply))
+}
+
+func TestArtifactStore(t *testing.T) {
+	injector, err := New(&config.FaultsConfig{Enabled: true, Faults: []config.FaultConfig{
+		{Point: ArtifactUpload, Mode: Fail, Times: 1},
+	}})
+	require.NoError(t, err)
+	store := ArtifactStore(agent.NewFileStore(builder.Layout{ArtifactsDir: t.TempDir()}), injector)
+
+	_, _, err = store.Put(context.Background(), "build-1", strings.NewReader("artifact"))
+	assert.ErrorIs(t, err, ErrInjected)
+
+	// The retry goes through
+	_, size, err := store.Put(context.Background(), "build-1", strings.NewReader("artifact"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), size)
+}
@@ -0,0 +1,128 @@
+package faults
+
+import (
+	"context"
+	"io"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// Factory injects DockerBuild faults into the builds of next
+func Factory(next builder.FactoryInterface, i *Injector) *BuilderFactory {
+	return &BuilderFactory{next: next, injector: i}
+}
+
+type BuilderFactory struct {
+	next     builder.FactoryInterface
+	injector *Injector
+}
+
+func (f *BuilderFactory) CreateBuilder(framework string, options *builder.Options) (builder.Builder, error) {
+	b, err := f.next.CreateBuilder(framework, options)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyBuilder{Builder: b, injector: f.injector}, nil
+}
+
+// Unwrap returns the wrapped factory
+func (f *BuilderFactory) Unwrap() builder.FactoryInterface {
+	return f.next
+}
+
+type faultyBuilder struct {
+	builder.Builder
+	injector *Injector
+}
+
+func (b *faultyBuilder) Build(ctx context.Context, build *types.Build) (*types.BuildResult, error) {
+	if err := b.injector.Check(ctx, DockerBuild); err != nil {
+		return nil, err
+	}
+	return b.Builder.Build(ctx, build)
+}
+
+// ArtifactStore injects ArtifactUpload faults into the uploads to next
+func ArtifactStore(next agent.ArtifactStore, i *Injector) agent.ArtifactStore {
+	return &faultyArtifactStore{next: next, injector: i}
+}
+
+type faultyArtifactStore struct {
+	next     agent.ArtifactStore
+	injector *Injector
+}
+
+func (s *faultyArtifactStore) Put(ctx context.Context, buildID string, artifact io.Reader) (string, int64, error) {
+	if err := s.injector.Check(ctx, ArtifactUpload); err != nil {
+		return "", 0, err
+	}
+	return s.next.Put(ctx, buildID, artifact)
+}
+
+// K8sClient injects K8sApply faults into the applies and updates of next.
+// Reads and deletes are passed through.
+func K8sClient(next deployer.K8sClient, i *Injector) deployer.K8sClient {
+	return &faultyK8sClient{K8sClient: next, injector: i}
+}
+
+type faultyK8sClient struct {
+	deployer.K8sClient
+	injector *Injector
+}
+
+func (c *faultyK8sClient) ApplyDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment, force bool) (*appsv1.Deployment, error) {
+	if err := c.injector.Check(ctx, K8sApply); err != nil {
+		return nil, err
+	}
+	return c.K8sClient.ApplyDeployment(ctx, namespace, deployment, force)
+}
+
+func (c *faultyK8sClient) UpdateDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
+	if err := c.injector.Check(ctx, K8sApply); err != nil {
+		return nil, err
+	}
+	return c.K8sClient.UpdateDeployment(ctx, namespace, deployment)
+}
+
+func (c *faultyK8sClient) ApplyService(ctx context.Context, namespace string, service *corev1.Service, force bool) (*corev1.Service, error) {
+	if err := c.injector.Check(ctx, K8sApply); err != nil {
+		return nil, err
+	}
+	return c.K8sClient.ApplyService(ctx, namespace, service, force)
+}
+
+func (c *faultyK8sClient) ApplyIngress(ctx context.Context, namespace string, ingress *networkingv1.Ingress, force bool) (*networkingv1.Ingress, error) {
+	if err := c.injector.Check(ctx, K8sApply); err != nil {
+		return nil, err
+	}
+	return c.K8sClient.ApplyIngress(ctx, namespace, ingress, force)
+}
+
+func (c *faultyK8sClient) CreateJob(ctx context.Context, namespace string, job *batchv1.Job) (*batchv1.Job, error) {
+	if err := c.injector.Check(ctx, K8sApply); err != nil {
+		return nil, err
+	}
+	return c.K8sClient.CreateJob(ctx, namespace, job)
+}
+
+func (c *faultyK8sClient) ApplyCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob, force bool) (*batchv1.CronJob, error) {
+	if err := c.injector.Check(ctx, K8sApply); err != nil {
+		return nil, err
+	}
+	return c.K8sClient.ApplyCronJob(ctx, namespace, cronJob, force)
+}
+
+func (c *faultyK8sClient) UpdateCronJob(ctx context.Context, namespace string, cronJob *batchv1.CronJob) (*batchv1.CronJob, error) {
+	if err := c.injector.Check(ctx, K8sApply); err != nil {
+		return nil, err
+	}
+	return c.K8sClient.UpdateCronJob(ctx, namespace, cronJob)
+}
@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/faults"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestPipeline_InjectedFaults(t *testing.T) {
+	pipeline, builder, deployer, _ := setupTestPipeline(t)
+	injector, err := faults.New(&config.FaultsConfig{Enabled: true, Faults: []config.FaultConfig{
+		{Point: faults.DBWrite, Mode: faults.Fail, Times: 1},
+		{Point: faults.DockerBuild, Mode: faults.Fail, Times: 1},
+	}})
+	require.NoError(t, err)
+	store := &recordingStore{eventStatus: make(map[types.DeploymentEventType]types.BuildStatus)}
+	pipeline.store = &faultyStore{BuildStore: store, injector: injector}
+	pipeline.builderFactory = faults.Factory(pipeline.builderFactory, injector)
+	ctx := context.Background()
+
+	// A build that cannot be recorded is not started
+	err = pipeline.StartBuild(ctx, createTestBuild())
+	assert.ErrorIs(t, err, faults.ErrInjected)
+	assert.Empty(t, store.created)
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(ctx, build))
+	require.NoError(t, pipeline.Shutdown(ctx))
+	assert.Equal(t, types.BuildStatusFailed, build.Status)
+	assert.False(t, builder.buildCalled.Load(), "the build failed before reaching Docker")
+	assert.False(t, deployer.deployCalled.Load())
+	assert.Equal(t, 1, injector.Hits(faults.DockerBuild))
+}
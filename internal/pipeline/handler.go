@@ -0,0 +1,230 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/monitor"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/pipeline/usage"
+	"github.com/elskow/chef-infra/internal/pipeline/validator"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// ProjectAuthorizer decides whether a user may operate on a project
+type ProjectAuthorizer interface {
+	CanAccessProject(username, projectID string) (bool, error)
+	// OwnsProject is CanAccessProject without the exception for admins
+	OwnsProject(username, projectID string) (bool, error)
+}
+
+type Handler struct {
+	pb.UnimplementedPipelineServer
+	pipeline   *Pipeline
+	matrix     *validator.VersionMatrix
+	monitor    *monitor.Monitor
+	usage      *usage.Meter
+	deployer   deployer.Deployer
+	authorizer ProjectAuthorizer
+	auditor    AuditRecorder
+	log        *zap.Logger
+}
+
+func NewHandler(
+	pipeline *Pipeline,
+	matrix *validator.VersionMatrix,
+	monitor *monitor.Monitor,
+	usage *usage.Meter,
+	deployer deployer.Deployer,
+	authorizer ProjectAuthorizer,
+	auditor AuditRecorder,
+	log *zap.Logger,
+) *Handler {
+	return &Handler{
+		pipeline:   pipeline,
+		matrix:     matrix,
+		monitor:    monitor,
+		usage:      usage,
+		deployer:   deployer,
+		authorizer: authorizer,
+		auditor:    auditor,
+		log:        log,
+	}
+}
+
+func (h *Handler) ListNodeVersions(_ context.Context, _ *pb.ListNodeVersionsRequest) (*pb.ListNodeVersionsResponse, error) {
+	versions, defaultVersion := h.matrix.Versions()
+
+	resp := &pb.ListNodeVersionsResponse{
+		DefaultVersion: defaultVersion,
+	}
+	for _, v := range versions {
+		resp.Versions = append(resp.Versions, &pb.NodeVersion{
+			Version:    v.Version,
+			Deprecated: v.Deprecated,
+			EolDate:    v.EOLDate,
+		})
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) UpdateNodeVersions(ctx context.Context, req *pb.UpdateNodeVersionsRequest) (*pb.UpdateNodeVersionsResponse, error) {
+	versions := make([]config.NodeVersionConfig, 0, len(req.Versions))
+	for _, v := range req.Versions {
+		versions = append(versions, config.NodeVersionConfig{
+			Version:    v.Version,
+			Deprecated: v.Deprecated,
+			EOLDate:    v.EolDate,
+		})
+	}
+
+	if err := h.matrix.Save(ctx, versions, req.DefaultVersion); err != nil {
+		if errors.Is(err, validator.ErrInvalidVersions) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to update node version matrix", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to update node version matrix")
+	}
+
+	h.log.Info("node version matrix updated",
+		zap.Int("versions", len(versions)),
+		zap.String("default_version", req.DefaultVersion))
+
+	return &pb.UpdateNodeVersionsResponse{
+		Success: true,
+		Message: "Node version matrix updated successfully",
+	}, nil
+}
+
+func (h *Handler) GetUptime(ctx context.Context, req *pb.GetUptimeRequest) (*pb.GetUptimeResponse, error) {
+	if req.ProjectId == "" {
+		return nil, status.Error(codes.InvalidArgument, "project id is required")
+	}
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+	if !h.monitor.Enabled() {
+		return nil, status.Error(codes.FailedPrecondition, "uptime monitoring is disabled")
+	}
+
+	environment := req.Environment
+	if environment == "" {
+		environment = defaultStatusEnvironment
+	}
+	stats, ok := h.monitor.Stats(req.ProjectId, environment)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "environment is not monitored")
+	}
+
+	resp := &pb.GetUptimeResponse{
+		ProjectId:        stats.ProjectID,
+		Environment:      stats.Environment,
+		Url:              stats.URL,
+		Up:               stats.Up,
+		Checks:           stats.Checks,
+		Failures:         stats.Failures,
+		Availability:     stats.Availability(),
+		LastLatencyMs:    stats.LastLatency.Milliseconds(),
+		AverageLatencyMs: stats.AverageLatency().Milliseconds(),
+		LastStatusCode:   int32(stats.LastStatusCode),
+		LastError:        stats.LastError,
+	}
+	if !stats.LastCheck.IsZero() {
+		resp.LastCheck = stats.LastCheck.Unix()
+	}
+
+	return resp, nil
+}
+
+func (h *Handler) GetAppLogs(req *pb.GetAppLogsRequest, stream pb.Pipeline_GetAppLogsServer) error {
+	ctx := stream.Context()
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return err
+	}
+	if req.SinceSeconds < 0 || req.Limit < 0 {
+		return status.Error(codes.InvalidArgument, "since_seconds and limit must not be negative")
+	}
+
+	streamer, ok := h.deployer.(deployer.LogStreamer)
+	if !ok {
+		return status.Error(codes.Unimplemented, "log access is not supported by the deployment platform")
+	}
+	if _, ok := h.deployer.(deployer.ProcessManager); !ok && req.Process != "" && req.Process != types.WebProcess {
+		return status.Error(codes.Unimplemented, "processes are not supported by the deployment platform")
+	}
+
+	opts := deployer.LogOptions{
+		SinceSeconds: req.SinceSeconds,
+		Limit:        req.Limit,
+		Follow:       req.Follow,
+		Process:      req.Process,
+	}
+	err := streamer.StreamLogs(ctx, req.ProjectId, opts, func(line deployer.LogLine) error {
+		return stream.Send(&pb.LogEntry{Source: line.Source, Line: line.Line})
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			// Client went away, nothing left to report
+			return nil
+		}
+		h.log.Error("failed to stream app logs",
+			zap.String("project", req.ProjectId),
+			zap.Error(err))
+		return status.Error(codes.Internal, "failed to stream logs")
+	}
+
+	return nil
+}
+
+// authorizeProject rejects callers that are neither admins nor the project owner
+func (h *Handler) authorizeProject(ctx context.Context, projectID string) error {
+	if projectID == "" {
+		return status.Error(codes.InvalidArgument, "project id is required")
+	}
+
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	allowed, err := h.authorizer.CanAccessProject(username, projectID)
+	if err != nil {
+		h.log.Error("failed to check project access",
+			zap.String("project", projectID),
+			zap.Error(err))
+		return status.Error(codes.Internal, "failed to check project access")
+	}
+	if !allowed {
+		return status.Error(codes.PermissionDenied, "access to project denied")
+	}
+	return nil
+}
+
+// authorizeOwner rejects callers that do not own the project, admins
+// included
+func (h *Handler) authorizeOwner(ctx context.Context, projectID string) error {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	owns, err := h.authorizer.OwnsProject(username, projectID)
+	if err != nil {
+		h.log.Error("failed to check project ownership",
+			zap.String("project", projectID),
+			zap.Error(err))
+		return status.Error(codes.Internal, "failed to check project access")
+	}
+	if !owns {
+		return status.Error(codes.PermissionDenied, "only the project owner may do this")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package imagegc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+)
+
+// DockerSource manages images of the local Docker daemon
+type DockerSource struct {
+	cli *client.Client
+}
+
+func NewDockerSource() (*DockerSource, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	return &DockerSource{cli: cli}, nil
+}
+
+func (d *DockerSource) ListImages(ctx context.Context) ([]Image, error) {
+	summaries, err := d.cli.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", repoPrefix+"*")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var images []Image
+	for _, summary := range summaries {
+		// Stage images are reaped with their build, not by the policy
+		if _, ok := summary.Labels[builder.StageLabel]; ok {
+			continue
+		}
+		for _, tag := range summary.RepoTags {
+			ref, project, ok := ParseRef(tag)
+			if !ok || ref != tag {
+				continue
+			}
+			images = append(images, Image{
+				Ref:     ref,
+				Project: project,
+				ID:      summary.ID,
+				Size:    summary.Size,
+				Created: time.Unix(summary.Created, 0),
+			})
+		}
+	}
+	return images, nil
+}
+
+// RemoveImage untags ref. The daemon refuses to remove images used by
+// containers, which keeps anything still running.
+func (d *DockerSource) RemoveImage(ctx context.Context, ref string) error {
+	_, err := d.cli.ImageRemove(ctx, ref, image.RemoveOptions{PruneChildren: true})
+	return err
+}
+
+// ListLeftovers lists the containers and images builds labelled as
+// stages, containers first since they keep their images from being
+// removed. Containers of build images, e.g. debug shells, are not stages.
+func (d *DockerSource) ListLeftovers(ctx context.Context) ([]Leftover, error) {
+	containers, err := d.cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", builder.StageLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var leftovers []Leftover
+	for _, c := range containers {
+		ref := c.ID
+		if len(c.Names) > 0 {
+			ref = c.Names[0]
+		}
+		leftovers = append(leftovers, Leftover{
+			Container: true,
+			ID:        c.ID,
+			Ref:       ref,
+			BuildID:   c.Labels[builder.BuildLabel],
+			Created:   time.Unix(c.Created, 0),
+		})
+	}
+
+	summaries, err := d.cli.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", builder.StageLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		ref := summary.ID
+		if len(summary.RepoTags) > 0 {
+			ref = summary.RepoTags[0]
+		}
+		leftovers = append(leftovers, Leftover{
+			ID:      summary.ID,
+			Ref:     ref,
+			BuildID: summary.Labels[builder.BuildLabel],
+			Created: time.Unix(summary.Created, 0),
+		})
+	}
+	return leftovers, nil
+}
+
+// RemoveLeftover force removes a container, or an image with all its tags.
+// Leftovers already gone are not an error.
+func (d *DockerSource) RemoveLeftover(ctx context.Context, leftover Leftover) error {
+	var err error
+	if leftover.Container {
+		err = d.cli.ContainerRemove(ctx, leftover.ID, container.RemoveOptions{Force: true})
+	} else {
+		_, err = d.cli.ImageRemove(ctx, leftover.ID, image.RemoveOptions{Force: true, PruneChildren: true})
+	}
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
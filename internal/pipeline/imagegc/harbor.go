@@ -0,0 +1,127 @@
+package imagegc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const harborPageSize = 100
+
+// HarborSource manages chef-* repositories of a Harbor project. Removed
+// tags leave untagged artifacts behind, which Harbor's garbage collection
+// deletes.
+type HarborSource struct {
+	api     *registryAPI
+	project string
+	prefix  string // Path between the project and the repository, if any
+}
+
+type harborArtifact struct {
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	PushTime time.Time `json:"push_time"`
+	Tags     []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+}
+
+func (h *HarborSource) ListImages(ctx context.Context) ([]Image, error) {
+	repositories, err := h.repositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []Image
+	for _, repository := range repositories {
+		for page := 1; ; page++ {
+			var artifacts []harborArtifact
+			path := fmt.Sprintf("%s/artifacts?with_tag=true&page=%d&page_size=%d", h.repositoryPath(repository), page, harborPageSize)
+			if _, _, err := h.api.do(ctx, http.MethodGet, path, nil, nil, &artifacts); err != nil {
+				return nil, err
+			}
+
+			for _, artifact := range artifacts {
+				for _, tag := range artifact.Tags {
+					ref, project, ok := ParseRef(repository + ":" + tag.Name)
+					if !ok {
+						continue
+					}
+					images = append(images, Image{
+						Ref:     ref,
+						Project: project,
+						ID:      artifact.Digest,
+						Size:    artifact.Size,
+						Created: artifact.PushTime,
+					})
+				}
+			}
+			if len(artifacts) < harborPageSize {
+				break
+			}
+		}
+	}
+	return images, nil
+}
+
+// repositories lists the chef-* repository names relative to the prefix
+func (h *HarborSource) repositories(ctx context.Context) ([]string, error) {
+	var names []string
+	for page := 1; ; page++ {
+		var repositories []struct {
+			Name string `json:"name"` // Includes the project
+		}
+		path := fmt.Sprintf("/api/v2.0/projects/%s/repositories?page=%d&page_size=%d", url.PathEscape(h.project), page, harborPageSize)
+		if _, _, err := h.api.do(ctx, http.MethodGet, path, nil, nil, &repositories); err != nil {
+			return nil, err
+		}
+
+		for _, repository := range repositories {
+			name := strings.TrimPrefix(repository.Name, h.project+"/")
+			if h.prefix != "" {
+				if !strings.HasPrefix(name, h.prefix+"/") {
+					continue
+				}
+				name = strings.TrimPrefix(name, h.prefix+"/")
+			}
+			if strings.HasPrefix(name, repoPrefix) && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if len(repositories) < harborPageSize {
+			return names, nil
+		}
+	}
+}
+
+// repositoryPath is the API path of a repository. Harbor expects slashes
+// in repository names to be escaped twice.
+func (h *HarborSource) repositoryPath(repository string) string {
+	if h.prefix != "" {
+		repository = h.prefix + "/" + repository
+	}
+	return fmt.Sprintf("/api/v2.0/projects/%s/repositories/%s", url.PathEscape(h.project), url.PathEscape(url.PathEscape(repository)))
+}
+
+// RemoveImage deletes the tag, leaving the artifact to garbage collection
+// once no tag references it
+func (h *HarborSource) RemoveImage(ctx context.Context, ref string) error {
+	repository, tag, _ := strings.Cut(LocalRef(ref), ":")
+	path := fmt.Sprintf("%s/artifacts/%s/tags/%s", h.repositoryPath(repository), url.PathEscape(tag), url.PathEscape(tag))
+	_, _, err := h.api.do(ctx, http.MethodDelete, path, nil, nil, nil)
+	return err
+}
+
+// GarbageCollect starts a manual Harbor GC run that also deletes untagged
+// artifacts. A run already in progress counts as started.
+func (h *HarborSource) GarbageCollect(ctx context.Context) error {
+	body := map[string]interface{}{
+		"schedule":   map[string]string{"type": "Manual"},
+		"parameters": map[string]bool{"delete_untagged": true},
+	}
+	_, _, err := h.api.do(ctx, http.MethodPost, "/api/v2.0/system/gc/schedule", nil, body, nil, http.StatusConflict)
+	return err
+}
@@ -0,0 +1,275 @@
+// Package imagegc removes old chef-<project>:<tag> images left behind by
+// builds on the Docker host and in the registry they are pushed to, and
+// the containers and stage images of builds that are no longer running.
+package imagegc
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const (
+	defaultInterval = time.Hour
+	defaultMinAge   = 24 * time.Hour
+
+	// repoPrefix starts the repository name of every image a build tags
+	repoPrefix = "chef-"
+)
+
+// Image is one chef-* tag. Tags sharing an image ID share its size.
+type Image struct {
+	Ref     string // e.g. "chef-shop:a1b2c3d"
+	Project string
+	ID      string
+	Size    int64
+	Created time.Time
+}
+
+// Source lists and removes images
+type Source interface {
+	ListImages(ctx context.Context) ([]Image, error)
+	// RemoveImage removes a tag; the image goes once no tag is left
+	RemoveImage(ctx context.Context, ref string) error
+}
+
+// ProtectedSource lists the image references that must never be removed,
+// such as those of pinned builds and current deployments
+type ProtectedSource interface {
+	ProtectedImages(ctx context.Context) (map[string]bool, error)
+}
+
+// Policy decides which images are removed
+type Policy struct {
+	// KeepPerProject newest images of each project are kept, 0 keeps all
+	KeepPerProject int
+	// MaxTotalSize removes the oldest remaining images, except the newest
+	// of each project, until the total fits. 0 disables it.
+	MaxTotalSize int64
+	// MinAge keeps images younger than it regardless of the limits
+	MinAge time.Duration
+}
+
+// Select returns the images the policy removes. Protected images are
+// never selected; protected is keyed by local reference.
+func Select(images []Image, protected map[string]bool, policy Policy, now time.Time) []Image {
+	byProject := make(map[string][]Image)
+	for _, img := range images {
+		byProject[img.Project] = append(byProject[img.Project], img)
+	}
+
+	removable := func(img Image) bool {
+		return !protected[img.Ref] && now.Sub(img.Created) >= policy.MinAge
+	}
+
+	var remove, evictable []Image
+	keptTags := make(map[string]int) // Image ID -> kept tags
+	sizes := make(map[string]int64)
+	for _, imgs := range byProject {
+		sort.Slice(imgs, func(i, j int) bool {
+			if !imgs[i].Created.Equal(imgs[j].Created) {
+				return imgs[i].Created.After(imgs[j].Created)
+			}
+			return imgs[i].Ref > imgs[j].Ref
+		})
+
+		for i, img := range imgs {
+			if policy.KeepPerProject > 0 && i >= policy.KeepPerProject && removable(img) {
+				remove = append(remove, img)
+				continue
+			}
+			keptTags[img.ID]++
+			sizes[img.ID] = img.Size
+			if i > 0 && removable(img) {
+				evictable = append(evictable, img)
+			}
+		}
+	}
+
+	if policy.MaxTotalSize > 0 {
+		var total int64
+		for id, size := range sizes {
+			if keptTags[id] > 0 {
+				total += size
+			}
+		}
+
+		sort.Slice(evictable, func(i, j int) bool {
+			if !evictable[i].Created.Equal(evictable[j].Created) {
+				return evictable[i].Created.Before(evictable[j].Created)
+			}
+			return evictable[i].Ref < evictable[j].Ref
+		})
+		for _, img := range evictable {
+			if total <= policy.MaxTotalSize {
+				break
+			}
+			remove = append(remove, img)
+			if keptTags[img.ID]--; keptTags[img.ID] == 0 {
+				total -= sizes[img.ID]
+			}
+		}
+	}
+
+	sort.Slice(remove, func(i, j int) bool { return remove[i].Ref < remove[j].Ref })
+	return remove
+}
+
+// ParseRef splits a chef-<project>:<tag> reference. Registry-qualified
+// references are reduced to their local form.
+func ParseRef(ref string) (local, project string, ok bool) {
+	local = LocalRef(ref)
+	repo, _, found := strings.Cut(local, ":")
+	if !found || !strings.HasPrefix(repo, repoPrefix) || len(repo) == len(repoPrefix) {
+		return "", "", false
+	}
+	return local, strings.TrimPrefix(repo, repoPrefix), true
+}
+
+// LocalRef strips the registry from a reference, builds tag the same
+// image locally without it
+func LocalRef(ref string) string {
+	return path.Base(ref)
+}
+
+// Collector applies the policy periodically
+type Collector struct {
+	images    Source
+	protected ProtectedSource
+	policy    Policy
+	interval  time.Duration
+	dryRun    bool
+	log       *zap.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewCollector(cfg *config.ImageGCConfig, images Source, protected ProtectedSource, log *zap.Logger) *Collector {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	minAge := time.Duration(cfg.MinAge) * time.Second
+	if minAge <= 0 {
+		minAge = defaultMinAge
+	}
+
+	return &Collector{
+		images:    images,
+		protected: protected,
+		policy: Policy{
+			KeepPerProject: cfg.KeepPerProject,
+			MaxTotalSize:   cfg.MaxTotalSize,
+			MinAge:         minAge,
+		},
+		interval: interval,
+		dryRun:   cfg.DryRun,
+		log:      log,
+	}
+}
+
+// Start runs the collector in the background until Stop is called
+func (c *Collector) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := c.Collect(ctx); err != nil {
+				c.log.Error("image garbage collection failed", zap.Error(err))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *Collector) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// Collect removes the images selected by the policy and returns their
+// references; in dry-run mode it only reports them. Nothing is removed
+// when the protected images cannot be determined, and a failed removal is
+// logged and retried on the next run. Sources that can reclaim storage
+// are garbage collected after images were removed. Leftovers of finished
+// builds are reaped first, see Reap.
+func (c *Collector) Collect(ctx context.Context) ([]string, error) {
+	if _, err := c.Reap(ctx); err != nil {
+		c.log.Error("failed to reap build leftovers", zap.Error(err))
+	}
+
+	inUse, err := c.protected.ProtectedImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list protected images: %w", err)
+	}
+	protected := make(map[string]bool, len(inUse))
+	for ref := range inUse {
+		protected[LocalRef(ref)] = true
+	}
+
+	images, err := c.images.ListImages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var removed []string
+	for _, img := range Select(images, protected, c.policy, time.Now()) {
+		if ctx.Err() != nil {
+			break
+		}
+		if c.dryRun {
+			c.log.Info("would remove image",
+				zap.String("image", img.Ref),
+				zap.String("project", img.Project),
+				zap.Int64("size", img.Size),
+				zap.Time("created", img.Created))
+			removed = append(removed, img.Ref)
+			continue
+		}
+		if err := c.images.RemoveImage(ctx, img.Ref); err != nil {
+			c.log.Warn("failed to remove image",
+				zap.String("image", img.Ref),
+				zap.Error(err))
+			continue
+		}
+		c.log.Info("removed image",
+			zap.String("image", img.Ref),
+			zap.String("project", img.Project))
+		removed = append(removed, img.Ref)
+	}
+
+	if gc, ok := c.images.(GarbageCollector); ok && !c.dryRun && len(removed) > 0 {
+		if err := gc.GarbageCollect(ctx); err != nil {
+			return removed, fmt.Errorf("failed to start garbage collection: %w", err)
+		}
+	}
+	if c.dryRun {
+		c.log.Info("image garbage collection dry run finished",
+			zap.Int("images", len(images)),
+			zap.Int("would_remove", len(removed)))
+	}
+	return removed, nil
+}
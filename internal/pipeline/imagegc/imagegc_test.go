@@ -0,0 +1,268 @@
+package imagegc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func testImage(ref, id string, age time.Duration, size int64) Image {
+	_, project, _ := ParseRef(ref)
+	return Image{Ref: ref, Project: project, ID: id, Size: size, Created: now.Add(-age)}
+}
+
+func refs(images []Image) []string {
+	var out []string
+	for _, img := range images {
+		out = append(out, img.Ref)
+	}
+	return out
+}
+
+func TestSelect(t *testing.T) {
+	day := 24 * time.Hour
+	images := []Image{
+		testImage("chef-shop:v4", "s4", 1*day, 100),
+		testImage("chef-shop:v3", "s3", 2*day, 100),
+		testImage("chef-shop:v2", "s2", 3*day, 100),
+		testImage("chef-shop:v1", "s1", 4*day, 100),
+		testImage("chef-blog:v2", "b2", 1*time.Hour, 100),
+		testImage("chef-blog:v1", "b1", 5*day, 100),
+	}
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		protected map[string]bool
+		want      []string
+	}{
+		{
+			name:   "keeps newest per project",
+			policy: Policy{KeepPerProject: 2},
+			want:   []string{"chef-shop:v1", "chef-shop:v2"},
+		},
+		{
+			name:      "never removes protected images",
+			policy:    Policy{KeepPerProject: 1},
+			protected: map[string]bool{"chef-shop:v2": true},
+			want:      []string{"chef-blog:v1", "chef-shop:v1", "chef-shop:v3"},
+		},
+		{
+			name:   "min age keeps recent images",
+			policy: Policy{KeepPerProject: 1, MinAge: 3 * day},
+			want:   []string{"chef-blog:v1", "chef-shop:v1", "chef-shop:v2"},
+		},
+		{
+			name:   "size limit removes oldest but keeps newest per project",
+			policy: Policy{MaxTotalSize: 350},
+			want:   []string{"chef-blog:v1", "chef-shop:v1", "chef-shop:v2"},
+		},
+		{
+			name:   "size limit cannot remove newest images",
+			policy: Policy{MaxTotalSize: 1},
+			want:   []string{"chef-blog:v1", "chef-shop:v1", "chef-shop:v2", "chef-shop:v3"},
+		},
+		{
+			name: "no limits keep everything",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, refs(Select(images, tt.protected, tt.policy, now)))
+		})
+	}
+}
+
+func TestSelect_SharedImageCountsOnce(t *testing.T) {
+	images := []Image{
+		testImage("chef-shop:v2", "same", time.Hour, 100),
+		testImage("chef-shop:abc", "same", 72*time.Hour, 100),
+		testImage("chef-shop:v1", "old", 48*time.Hour, 100),
+	}
+
+	// Removing the second tag of the newest image frees nothing, so the
+	// oldest image has to go as well
+	removed := Select(images, nil, Policy{MaxTotalSize: 100}, now)
+	assert.Equal(t, []string{"chef-shop:abc", "chef-shop:v1"}, refs(removed))
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		local   string
+		project string
+		ok      bool
+	}{
+		{"chef-shop:abc", "chef-shop:abc", "shop", true},
+		{"registry.local:5000/chef-my-app:abc", "chef-my-app:abc", "my-app", true},
+		{"nginx:alpine", "", "", false},
+		{"chef-:abc", "", "", false},
+		{"chef-shop", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			local, project, ok := ParseRef(tt.ref)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.local, local)
+			assert.Equal(t, tt.project, project)
+		})
+	}
+}
+
+type fakeSource struct {
+	images  []Image
+	failing map[string]bool
+	removed []string
+}
+
+func (s *fakeSource) ListImages(context.Context) ([]Image, error) {
+	return s.images, nil
+}
+
+func (s *fakeSource) RemoveImage(_ context.Context, ref string) error {
+	if s.failing[ref] {
+		return errors.New("image is being used by a container")
+	}
+	s.removed = append(s.removed, ref)
+	return nil
+}
+
+type fakeProtected struct {
+	refs map[string]bool
+	err  error
+}
+
+func (p fakeProtected) ProtectedImages(context.Context) (map[string]bool, error) {
+	return p.refs, p.err
+}
+
+func TestCollector_Collect(t *testing.T) {
+	// Older than the default minimum age
+	old := time.Now().Add(-48 * time.Hour)
+	source := &fakeSource{
+		images: []Image{
+			{Ref: "chef-shop:v3", Project: "shop", ID: "s3", Created: time.Now()},
+			{Ref: "chef-shop:v2", Project: "shop", ID: "s2", Created: old},
+			{Ref: "chef-shop:v1", Project: "shop", ID: "s1", Created: old.Add(-time.Hour)},
+			{Ref: "chef-shop:v0", Project: "shop", ID: "s0", Created: old.Add(-2 * time.Hour)},
+		},
+		failing: map[string]bool{"chef-shop:v0": true},
+	}
+	cfg := &config.ImageGCConfig{KeepPerProject: 1}
+
+	t.Run("registry references protect local tags", func(t *testing.T) {
+		protected := fakeProtected{refs: map[string]bool{"registry.local/chef-shop:v2": true}}
+		removed, err := NewCollector(cfg, source, protected, zap.NewNop()).Collect(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"chef-shop:v1"}, removed)
+	})
+
+	t.Run("nothing is removed without protected images", func(t *testing.T) {
+		source.removed = nil
+		protected := fakeProtected{err: errors.New("database unavailable")}
+		_, err := NewCollector(cfg, source, protected, zap.NewNop()).Collect(context.Background())
+		assert.Error(t, err)
+		assert.Empty(t, source.removed)
+	})
+}
+
+type gcSource struct {
+	fakeSource
+	collected int
+}
+
+func (s *gcSource) GarbageCollect(context.Context) error {
+	s.collected++
+	return nil
+}
+
+func TestCollector_DryRun(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	source := &gcSource{fakeSource: fakeSource{images: []Image{
+		{Ref: "chef-shop:v2", Project: "shop", ID: "s2", Created: old},
+		{Ref: "chef-shop:v1", Project: "shop", ID: "s1", Created: old.Add(-time.Hour)},
+	}}}
+	cfg := &config.ImageGCConfig{KeepPerProject: 1, DryRun: true}
+
+	removed, err := NewCollector(cfg, source, fakeProtected{}, zap.NewNop()).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chef-shop:v1"}, removed)
+	assert.Empty(t, source.removed)
+	assert.Zero(t, source.collected)
+
+	cfg.DryRun = false
+	removed, err = NewCollector(cfg, source, fakeProtected{}, zap.NewNop()).Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chef-shop:v1"}, source.removed)
+	assert.Equal(t, removed, source.removed)
+	assert.Equal(t, 1, source.collected)
+}
+
+// leftoverSource also lists build leftovers
+type leftoverSource struct {
+	fakeSource
+	leftovers []Leftover
+	reaped    []string
+}
+
+func (s *leftoverSource) ListLeftovers(context.Context) ([]Leftover, error) {
+	return s.leftovers, nil
+}
+
+func (s *leftoverSource) RemoveLeftover(_ context.Context, leftover Leftover) error {
+	s.reaped = append(s.reaped, leftover.ID)
+	return nil
+}
+
+type liveProtected struct {
+	fakeProtected
+	live map[string]bool
+	err  error
+}
+
+func (p liveProtected) LiveBuilds(context.Context) (map[string]bool, error) {
+	return p.live, p.err
+}
+
+func TestCollector_Reap(t *testing.T) {
+	source := &leftoverSource{leftovers: []Leftover{
+		{Container: true, ID: "c1", BuildID: "failed"},
+		{Container: true, ID: "c2", BuildID: "running"},
+		{ID: "sha256:test", BuildID: "failed"},
+		{ID: "sha256:output", BuildID: "running"},
+	}}
+	protected := liveProtected{live: map[string]bool{"running": true}}
+
+	t.Run("dry run", func(t *testing.T) {
+		cfg := &config.ImageGCConfig{DryRun: true}
+		reaped, err := NewCollector(cfg, source, protected, zap.NewNop()).Reap(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, reaped, 2)
+		assert.Empty(t, source.reaped)
+	})
+
+	t.Run("leftovers of finished builds", func(t *testing.T) {
+		_, err := NewCollector(&config.ImageGCConfig{}, source, protected, zap.NewNop()).Collect(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c1", "sha256:test"}, source.reaped)
+	})
+
+	t.Run("nothing is reaped without running builds", func(t *testing.T) {
+		source.reaped = nil
+		protected := liveProtected{err: errors.New("database unavailable")}
+		_, err := NewCollector(&config.ImageGCConfig{}, source, protected, zap.NewNop()).Reap(context.Background())
+		assert.Error(t, err)
+		assert.Empty(t, source.reaped)
+	})
+}
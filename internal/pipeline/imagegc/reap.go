@@ -0,0 +1,95 @@
+package imagegc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Leftover is a container or stage image a build created, labelled with
+// the build's ID
+type Leftover struct {
+	Container bool // An image otherwise
+	ID        string
+	Ref       string // Container name or image tag, for logging
+	BuildID   string
+	Created   time.Time
+}
+
+// LeftoverSource is implemented by sources that can list and remove what
+// builds left behind. The collector reaps leftovers before applying the
+// policy.
+type LeftoverSource interface {
+	ListLeftovers(ctx context.Context) ([]Leftover, error)
+	RemoveLeftover(ctx context.Context, leftover Leftover) error
+}
+
+// LiveBuildSource is implemented by protected sources that know which
+// builds are still running; their leftovers are kept
+type LiveBuildSource interface {
+	LiveBuilds(ctx context.Context) (map[string]bool, error)
+}
+
+// Reap removes the containers and stage images of builds that are no
+// longer running and returns them; in dry-run mode it only reports them.
+// Nothing is removed when the running builds cannot be determined.
+func (c *Collector) Reap(ctx context.Context) ([]Leftover, error) {
+	source, ok := c.images.(LeftoverSource)
+	if !ok {
+		return nil, nil
+	}
+	live, ok := c.protected.(LiveBuildSource)
+	if !ok {
+		return nil, nil
+	}
+
+	// Listed before the running builds, so a build that starts in between
+	// is never mistaken for a finished one
+	leftovers, err := source.ListLeftovers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list build leftovers: %w", err)
+	}
+	if len(leftovers) == 0 {
+		return nil, nil
+	}
+	running, err := live.LiveBuilds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list running builds: %w", err)
+	}
+
+	var reaped []Leftover
+	for _, leftover := range leftovers {
+		if ctx.Err() != nil {
+			break
+		}
+		if running[leftover.BuildID] {
+			continue
+		}
+		kind := "image"
+		if leftover.Container {
+			kind = "container"
+		}
+		if c.dryRun {
+			c.log.Info("would remove build "+kind,
+				zap.String("ref", leftover.Ref),
+				zap.String("build_id", leftover.BuildID),
+				zap.Time("created", leftover.Created))
+			reaped = append(reaped, leftover)
+			continue
+		}
+		if err := source.RemoveLeftover(ctx, leftover); err != nil {
+			c.log.Warn("failed to remove build "+kind,
+				zap.String("ref", leftover.Ref),
+				zap.String("build_id", leftover.BuildID),
+				zap.Error(err))
+			continue
+		}
+		c.log.Info("removed build "+kind,
+			zap.String("ref", leftover.Ref),
+			zap.String("build_id", leftover.BuildID))
+		reaped = append(reaped, leftover)
+	}
+	return reaped, nil
+}
@@ -0,0 +1,303 @@
+package imagegc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const maxErrorBody = 1024
+
+// Media types accepted when reading manifests. Multi-platform builds push
+// an index pointing at one manifest per platform.
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// GarbageCollector is implemented by sources that can reclaim the storage
+// of removed images. The collector triggers it after removing images.
+type GarbageCollector interface {
+	GarbageCollect(ctx context.Context) error
+}
+
+// NewRegistrySource returns the source for the configured registry
+// provider. registry is the image prefix builds push to, e.g.
+// "registry.example.com/team".
+func NewRegistrySource(cfg *config.RegistryGCConfig, registry string) (Source, error) {
+	if registry == "" {
+		return nil, errors.New("registry image GC requires a registry")
+	}
+	host, namespace, _ := strings.Cut(strings.TrimSuffix(registry, "/"), "/")
+	api := &registryAPI{
+		baseURL:          strings.TrimSuffix(cfg.URL, "/"),
+		username:         cfg.Username,
+		password:         cfg.Password,
+		previousPassword: cfg.PreviousPassword,
+		http:             &http.Client{Timeout: 30 * time.Second},
+	}
+	if api.baseURL == "" {
+		api.baseURL = "https://" + host
+	}
+
+	switch cfg.Provider {
+	case "", "registry":
+		return &DistributionSource{api: api, namespace: namespace}, nil
+	case "harbor":
+		if namespace == "" {
+			return nil, fmt.Errorf("harbor registry %s must include a project, e.g. %s/<project>", registry, host)
+		}
+		// Harbor keys repositories by project and the rest of the path
+		project, prefix, _ := strings.Cut(namespace, "/")
+		return &HarborSource{api: api, project: project, prefix: prefix}, nil
+	case "ecr":
+		return nil, errors.New("ecr is not supported, configure an ECR lifecycle policy instead")
+	default:
+		return nil, fmt.Errorf("unknown registry provider %q", cfg.Provider)
+	}
+}
+
+// registryAPI sends authenticated requests to a registry. Requests the
+// registry refuses are retried with the previous password while it is
+// being rotated.
+type registryAPI struct {
+	baseURL          string
+	username         string
+	password         string
+	previousPassword string
+	http             *http.Client
+}
+
+// do sends a request with an optional JSON body, decodes a JSON response
+// into out and returns the response headers. Statuses in allowed are
+// accepted besides 2xx.
+func (a *registryAPI) do(ctx context.Context, method, path string, header http.Header, body, out interface{}, allowed ...int) (http.Header, int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, 0, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	resp, err := a.send(ctx, method, path, header, payload, a.password)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && a.username != "" && a.previousPassword != "" {
+		resp.Body.Close()
+		resp, err = a.send(ctx, method, path, header, payload, a.previousPassword)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	for _, code := range allowed {
+		ok = ok || resp.StatusCode == code
+	}
+	if !ok {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, resp.StatusCode, fmt.Errorf("registry %s %s returned %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil && method != http.MethodHead {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.Header, resp.StatusCode, nil
+}
+
+func (a *registryAPI) send(ctx context.Context, method, path string, header http.Header, payload []byte, password string) (*http.Response, error) {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.username != "" {
+		req.SetBasicAuth(a.username, password)
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registry request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// DistributionSource manages chef-* repositories of a Docker Registry v2
+// (distribution) compatible registry. The registry must allow deletes;
+// space is reclaimed by its offline garbage-collect command.
+type DistributionSource struct {
+	api       *registryAPI
+	namespace string
+}
+
+type manifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	} `json:"config"`
+	Layers []struct {
+		Size int64 `json:"size"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"`
+}
+
+func (d *DistributionSource) ListImages(ctx context.Context) ([]Image, error) {
+	repositories, err := d.repositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []Image
+	for _, repository := range repositories {
+		var tags struct {
+			Tags []string `json:"tags"`
+		}
+		if _, _, err := d.api.do(ctx, http.MethodGet, "/v2/"+repository+"/tags/list", nil, nil, &tags); err != nil {
+			return nil, err
+		}
+
+		for _, tag := range tags.Tags {
+			ref, project, ok := ParseRef(repository + ":" + tag)
+			if !ok {
+				continue
+			}
+			img, err := d.inspect(ctx, repository, tag)
+			if err != nil {
+				return nil, fmt.Errorf("failed to inspect %s: %w", ref, err)
+			}
+			img.Ref = ref
+			img.Project = project
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// repositories lists the chef-* repositories under the namespace
+func (d *DistributionSource) repositories(ctx context.Context) ([]string, error) {
+	var repositories []string
+	path := "/v2/_catalog?n=1000"
+	for path != "" {
+		var catalog struct {
+			Repositories []string `json:"repositories"`
+		}
+		header, _, err := d.api.do(ctx, http.MethodGet, path, nil, nil, &catalog)
+		if err != nil {
+			return nil, err
+		}
+		for _, repository := range catalog.Repositories {
+			if d.inNamespace(repository) {
+				repositories = append(repositories, repository)
+			}
+		}
+		path = nextLink(header)
+	}
+	return repositories, nil
+}
+
+func (d *DistributionSource) inNamespace(repository string) bool {
+	name := repository
+	if d.namespace != "" {
+		if !strings.HasPrefix(repository, d.namespace+"/") {
+			return false
+		}
+		name = strings.TrimPrefix(repository, d.namespace+"/")
+	}
+	return strings.HasPrefix(name, repoPrefix) && !strings.Contains(name, "/")
+}
+
+// inspect reads the digest, size and creation time of a tag. For an index
+// the first platform's manifest is inspected.
+func (d *DistributionSource) inspect(ctx context.Context, repository, tag string) (Image, error) {
+	accept := http.Header{"Accept": {strings.Join(manifestTypes, ", ")}}
+
+	var m manifest
+	header, _, err := d.api.do(ctx, http.MethodGet, "/v2/"+repository+"/manifests/"+tag, accept, nil, &m)
+	if err != nil {
+		return Image{}, err
+	}
+	img := Image{ID: header.Get("Docker-Content-Digest")}
+
+	if len(m.Manifests) > 0 {
+		if _, _, err := d.api.do(ctx, http.MethodGet, "/v2/"+repository+"/manifests/"+m.Manifests[0].Digest, accept, nil, &m); err != nil {
+			return Image{}, err
+		}
+	}
+	img.Size = m.Config.Size
+	for _, layer := range m.Layers {
+		img.Size += layer.Size
+	}
+
+	var imageConfig struct {
+		Created time.Time `json:"created"`
+	}
+	if _, _, err := d.api.do(ctx, http.MethodGet, "/v2/"+repository+"/blobs/"+m.Config.Digest, nil, nil, &imageConfig); err != nil {
+		return Image{}, err
+	}
+	img.Created = imageConfig.Created
+	return img, nil
+}
+
+// RemoveImage deletes the manifest ref points to. The registry API deletes
+// by digest, so other tags of the same manifest go with it.
+func (d *DistributionSource) RemoveImage(ctx context.Context, ref string) error {
+	repository, tag, _ := strings.Cut(LocalRef(ref), ":")
+	if d.namespace != "" {
+		repository = d.namespace + "/" + repository
+	}
+
+	accept := http.Header{"Accept": {strings.Join(manifestTypes, ", ")}}
+	header, _, err := d.api.do(ctx, http.MethodHead, "/v2/"+repository+"/manifests/"+tag, accept, nil, nil)
+	if err != nil {
+		return err
+	}
+	digest := header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return fmt.Errorf("registry returned no digest for %s", ref)
+	}
+
+	_, _, err = d.api.do(ctx, http.MethodDelete, "/v2/"+repository+"/manifests/"+digest, nil, nil, nil)
+	return err
+}
+
+// nextLink returns the path of the next page from an RFC 5988 Link header
+func nextLink(header http.Header) string {
+	link := header.Get("Link")
+	if link == "" || !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end <= start {
+		return ""
+	}
+	next, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return next.RequestURI()
+}
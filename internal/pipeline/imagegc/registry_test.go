@@ -0,0 +1,185 @@
+package imagegc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestNewRegistrySource(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		registry string
+		wantErr  bool
+	}{
+		{name: "distribution by default", registry: "registry.example.com"},
+		{name: "harbor project", provider: "harbor", registry: "harbor.example.com/team"},
+		{name: "harbor needs a project", provider: "harbor", registry: "harbor.example.com", wantErr: true},
+		{name: "ecr is not supported", provider: "ecr", registry: "1234.dkr.ecr.eu-west-1.amazonaws.com", wantErr: true},
+		{name: "unknown provider", provider: "quay", registry: "quay.io/team", wantErr: true},
+		{name: "registry required", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRegistrySource(&config.RegistryGCConfig{Provider: tt.provider}, tt.registry)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestDistributionSource(t *testing.T) {
+	created := time.Unix(1700000000, 0).UTC()
+	var deleted []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/_catalog", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/_catalog?last=team%2Fchef-shop&n=1000>; rel="next"`)
+			json.NewEncoder(w).Encode(map[string][]string{"repositories": {"other/chef-shop", "team/chef-shop"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"repositories": {"team/nginx"}})
+	})
+	mux.HandleFunc("/v2/team/chef-shop/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]string{"tags": {"abc"}})
+	})
+	mux.HandleFunc("/v2/team/chef-shop/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodHead:
+			w.Header().Set("Docker-Content-Digest", "sha256:index")
+		default:
+			if r.URL.Path == "/v2/team/chef-shop/manifests/abc" {
+				w.Header().Set("Docker-Content-Digest", "sha256:index")
+				w.Write([]byte(`{"manifests": [{"digest": "sha256:amd64"}]}`))
+				return
+			}
+			w.Write([]byte(`{"config": {"digest": "sha256:config", "size": 10}, "layers": [{"size": 100}, {"size": 200}]}`))
+		}
+	})
+	mux.HandleFunc("/v2/team/chef-shop/blobs/sha256:config", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]time.Time{"created": created})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	source, err := NewRegistrySource(&config.RegistryGCConfig{URL: server.URL}, "registry.example.com/team")
+	require.NoError(t, err)
+
+	images, err := source.ListImages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Image{{
+		Ref:     "chef-shop:abc",
+		Project: "shop",
+		ID:      "sha256:index",
+		Size:    310,
+		Created: created,
+	}}, images)
+
+	require.NoError(t, source.RemoveImage(context.Background(), "registry.example.com/team/chef-shop:abc"))
+	assert.Equal(t, []string{"/v2/team/chef-shop/manifests/sha256:index"}, deleted)
+}
+
+func TestHarborSource(t *testing.T) {
+	pushed := time.Unix(1700000000, 0).UTC()
+	var deleted []string
+	var gcParams map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2.0/projects/team/repositories", func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if user != "robot" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"name": "team/chef-shop"}, {"name": "team/nginx"}})
+	})
+	mux.HandleFunc("/api/v2.0/projects/team/repositories/chef-shop/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get("with_tag"))
+		json.NewEncoder(w).Encode([]map[string]interface{}{{
+			"digest":    "sha256:abc",
+			"size":      512,
+			"push_time": pushed,
+			"tags":      []map[string]string{{"name": "v1"}, {"name": "latest"}},
+		}})
+	})
+	mux.HandleFunc("/api/v2.0/projects/team/repositories/chef-shop/artifacts/v1/tags/v1", func(w http.ResponseWriter, r *http.Request) {
+		deleted = append(deleted, r.Method)
+	})
+	mux.HandleFunc("/api/v2.0/system/gc/schedule", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gcParams)
+		w.WriteHeader(http.StatusCreated)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	source, err := NewRegistrySource(&config.RegistryGCConfig{
+		Provider: "harbor",
+		URL:      server.URL,
+		Username: "robot",
+		Password: "secret",
+	}, "harbor.example.com/team")
+	require.NoError(t, err)
+
+	images, err := source.ListImages(context.Background())
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, Image{Ref: "chef-shop:v1", Project: "shop", ID: "sha256:abc", Size: 512, Created: pushed}, images[0])
+	assert.Equal(t, "chef-shop:latest", images[1].Ref)
+
+	require.NoError(t, source.RemoveImage(context.Background(), "chef-shop:v1"))
+	assert.Equal(t, []string{http.MethodDelete}, deleted)
+
+	require.NoError(t, source.(GarbageCollector).GarbageCollect(context.Background()))
+	assert.Equal(t, map[string]interface{}{"delete_untagged": true}, gcParams["parameters"])
+}
+
+func TestRegistryAPI_PreviousPassword(t *testing.T) {
+	accepted := "old"
+	var tried []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, _ := r.BasicAuth()
+		tried = append(tried, password)
+		if password != accepted {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"repositories": {}})
+	}))
+	defer server.Close()
+
+	source, err := NewRegistrySource(&config.RegistryGCConfig{
+		URL:              server.URL,
+		Username:         "robot",
+		Password:         "new",
+		PreviousPassword: "old",
+	}, "registry.example.com/team")
+	require.NoError(t, err)
+
+	// The registry still has the old password
+	_, err = source.ListImages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new", "old"}, tried)
+
+	// And then the new one
+	accepted, tried = "new", nil
+	_, err = source.ListImages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, tried)
+
+	accepted = "other"
+	_, err = source.ListImages(context.Background())
+	assert.ErrorContains(t, err, "401")
+}
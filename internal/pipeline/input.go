@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/elskow/chef-infra/internal/pipeline/source"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+var ErrInvalidBuild = errors.New("invalid build")
+
+// buildID restricts caller supplied IDs since they name directories,
+// artifacts and image tags
+var buildID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// projectID matches the names projects are deployed under, which have to
+// be valid DNS labels
+var projectID = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{1,61}[a-z0-9])$`)
+
+// prepareBuild checks what the caller sent before anything touches the
+// host. A missing build ID is generated and the sources are the project's
+// checkout in the source root, callers cannot point the builder at other
+// directories. Sources above the size limits fail with
+// source.ErrSourceTooLarge before they are copied.
+func (p *Pipeline) prepareBuild(build *types.Build) error {
+	if build.ID == "" {
+		build.ID = uuid.NewString()
+	} else if !buildID.MatchString(build.ID) {
+		return fmt.Errorf("%w: build id %q may only contain letters, digits, - and _", ErrInvalidBuild, build.ID)
+	}
+	if !projectID.MatchString(build.ProjectID) {
+		return fmt.Errorf("%w: project id %q must be 3 to 63 lowercase letters, digits or -, starting and ending with a letter or digit", ErrInvalidBuild, build.ProjectID)
+	}
+	if !types.ValidBuildPriority(build.Priority) {
+		return fmt.Errorf("%w: priority %q is not supported, expected high, normal or low", ErrInvalidBuild, build.Priority)
+	}
+
+	p.mu.RLock()
+	_, exists := p.builds[build.ID]
+	p.mu.RUnlock()
+	if exists {
+		return fmt.Errorf("%w: build %s already exists", ErrInvalidBuild, build.ID)
+	}
+
+	if len(build.BuilderConfig) > 0 {
+		keys := make([]string, 0, len(build.BuilderConfig))
+		for key := range build.BuilderConfig {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return fmt.Errorf("%w: builder config is set by the server, got %s", ErrInvalidBuild, strings.Join(keys, ", "))
+	}
+
+	resolved, err := p.resolveSource(build.ProjectID)
+	if err != nil {
+		return err
+	}
+	if err := source.CheckTree(resolved, &p.config.Source.Limits); err != nil {
+		return err
+	}
+	build.BuilderConfig = map[string]interface{}{"sourceDir": resolved}
+	build.Platforms = p.targetPlatforms(build)
+	return nil
+}
+
+// targetPlatforms returns the platforms the build asks for, else those of
+// its project, else the default ones. The builder, the deployers and the
+// provenance all read them from the build.
+func (p *Pipeline) targetPlatforms(build *types.Build) []string {
+	if len(build.Platforms) > 0 {
+		return build.Platforms
+	}
+	if platforms := p.config.NodeJS.ProjectPlatforms[build.ProjectID]; len(platforms) > 0 {
+		return platforms
+	}
+	return p.config.NodeJS.Platforms
+}
+
+// resolveSource maps a source directory relative to the source root to its
+// host path. Absolute paths and paths leaving the root, also through
+// symlinks, are rejected.
+func (p *Pipeline) resolveSource(dir string) (string, error) {
+	if filepath.IsAbs(dir) || !filepath.IsLocal(dir) {
+		return "", fmt.Errorf("%w: sourceDir must be a path relative to the source root", ErrInvalidBuild)
+	}
+
+	root, err := filepath.EvalSymlinks(p.sourceRoot())
+	if err != nil {
+		return "", fmt.Errorf("source root is unavailable: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, dir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%w: source %s does not exist", ErrInvalidBuild, dir)
+		}
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: sourceDir must be a path relative to the source root", ErrInvalidBuild)
+	}
+	return resolved, nil
+}
+
+// sourceRoot is where project sources are checked out
+func (p *Pipeline) sourceRoot() string {
+	if p.config.Source.Root != "" {
+		return p.config.Source.Root
+	}
+	return filepath.Join(p.config.BuildDir, "sources")
+}
@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// verifyAssets compares a sample of the deployed files with the build's
+// artifact. Mismatches only fail the deploy when the check is enforced,
+// otherwise they are recorded as an event.
+func (p *Pipeline) verifyAssets(ctx context.Context, build *types.Build) error {
+	if p.integrity == nil || !p.integrity.Enabled() || build.ArtifactPath == "" {
+		return nil
+	}
+
+	checked, err := p.integrity.Verify(ctx, p.appURL(build.Environment, build.ProjectID), build.ArtifactPath)
+	if err != nil {
+		p.logger.Warn("deployed files do not match the artifact",
+			zap.String("build_id", build.ID),
+			zap.Error(err))
+		p.updateBuild(build, func(b *types.Build) {
+			b.AddEvent(types.EventAssetsMismatch, "", err.Error())
+		})
+		if p.integrity.Enforced() {
+			return fmt.Errorf("asset verification failed: %w", err)
+		}
+		return nil
+	}
+
+	p.updateBuild(build, func(b *types.Build) {
+		b.AddEvent(types.EventAssetsVerified, "", fmt.Sprintf("%d files match the artifact", checked))
+	})
+	return nil
+}
@@ -0,0 +1,252 @@
+// Package integrity checks that a deployment serves the files of its
+// artifact, catching partial syncs, stale caches and misrouted paths.
+package integrity
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultSamples  = 20
+	defaultAttempts = 3
+	defaultTimeout  = 10 * time.Second
+	retryDelay      = 5 * time.Second
+
+	// artifactRoot prefixes every file of an artifact copied out of the
+	// build image's /usr/share/nginx/html
+	artifactRoot = "html/"
+	indexFile    = "index.html"
+)
+
+// Manifest maps the URL path of each file of an artifact to its SHA-256
+type Manifest map[string]string
+
+// ReadManifest hashes the regular files of a tar or tar.gz artifact
+func ReadManifest(artifactPath string) (Manifest, error) {
+	f, err := os.Open(artifactPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var reader io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		reader = gr
+	}
+
+	manifest := make(Manifest)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifact: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, fmt.Errorf("failed to read artifact: %w", err)
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(header.Name, "./"), artifactRoot)
+		manifest["/"+name] = hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// Sample picks up to n paths spread evenly over the manifest, always
+// starting with the index page since it references everything else
+func (m Manifest) Sample(n int) []string {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		if path != "/"+indexFile {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var sample []string
+	if _, ok := m["/"+indexFile]; ok && n > 0 {
+		sample = append(sample, "/"+indexFile)
+		n--
+	}
+	if n >= len(paths) {
+		return append(sample, paths...)
+	}
+	for i := 0; i < n; i++ {
+		sample = append(sample, paths[i*len(paths)/n])
+	}
+	return sample
+}
+
+// Digest hashes the sorted paths and digests of the manifest, so artifacts
+// with the same files have the same digest regardless of archive metadata
+// such as timestamps
+func (m Manifest) Digest() string {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s %s\n", path, m[path])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Diff lists the files that differ in other, sorted by path
+func (m Manifest) Diff(other Manifest) []types.FileChange {
+	var changes []types.FileChange
+	for path, digest := range m {
+		rebuilt, ok := other[path]
+		switch {
+		case !ok:
+			changes = append(changes, types.FileChange{Path: path, Change: types.FileRemoved, Original: digest})
+		case rebuilt != digest:
+			changes = append(changes, types.FileChange{Path: path, Change: types.FileModified, Original: digest, Rebuilt: rebuilt})
+		}
+	}
+	for path, digest := range other {
+		if _, ok := m[path]; !ok {
+			changes = append(changes, types.FileChange{Path: path, Change: types.FileAdded, Rebuilt: digest})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// Verifier fetches sampled files of an artifact from its deployment
+type Verifier struct {
+	config *config.IntegrityConfig
+	client *http.Client
+	delay  time.Duration
+	log    *zap.Logger
+}
+
+func NewVerifier(cfg *config.IntegrityConfig, log *zap.Logger) *Verifier {
+	timeout := defaultTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	return &Verifier{
+		config: cfg,
+		client: &http.Client{Timeout: timeout},
+		delay:  retryDelay,
+		log:    log,
+	}
+}
+
+func (v *Verifier) Enabled() bool {
+	return v.config.Enabled
+}
+
+// Enforced reports whether mismatches should roll the deployment back
+func (v *Verifier) Enforced() bool {
+	return v.config.Enforce
+}
+
+// Verify compares sampled files of the artifact with what baseURL serves.
+// Checks are retried while caches settle; the returned error lists the
+// files that still differ after the last attempt.
+func (v *Verifier) Verify(ctx context.Context, baseURL, artifactPath string) (int, error) {
+	manifest, err := ReadManifest(artifactPath)
+	if err != nil {
+		return 0, err
+	}
+	samples := v.config.Samples
+	if samples <= 0 {
+		samples = defaultSamples
+	}
+	attempts := v.config.Attempts
+	if attempts <= 0 {
+		attempts = defaultAttempts
+	}
+
+	pending := manifest.Sample(samples)
+	checked := len(pending)
+	var failures []string
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return checked, ctx.Err()
+			case <-time.After(v.delay):
+			}
+		}
+
+		var retry []string
+		failures = nil
+		for _, path := range pending {
+			if err := v.check(ctx, baseURL, path, manifest[path]); err != nil {
+				if ctx.Err() != nil {
+					return checked, ctx.Err()
+				}
+				retry = append(retry, path)
+				failures = append(failures, fmt.Sprintf("%s: %v", path, err))
+			}
+		}
+		if len(retry) == 0 {
+			return checked, nil
+		}
+		v.log.Debug("deployed files differ from the artifact",
+			zap.Int("attempt", attempt),
+			zap.Strings("files", retry))
+		pending = retry
+	}
+	return checked, fmt.Errorf("%d of %d deployed files differ from the artifact: %s",
+		len(failures), checked, strings.Join(failures, "; "))
+}
+
+func (v *Verifier) check(ctx context.Context, baseURL, path, want string) error {
+	target := strings.TrimSuffix(baseURL, "/") + (&url.URL{Path: path}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("sha256 %.12s, expected %.12s", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package integrity
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+var files = map[string]string{
+	"html/index.html":         "<script src=/assets/app.js></script>",
+	"html/assets/app.js":      "console.log('v2')",
+	"html/assets/app.css":     "body{}",
+	"html/assets/logo 1.svg":  "<svg/>",
+	"html/robots.txt":         "User-agent: *",
+	"html/nested/page/a.html": "a",
+}
+
+func writeArtifact(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "build.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "html/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	return path
+}
+
+func TestReadManifest(t *testing.T) {
+	manifest, err := ReadManifest(writeArtifact(t))
+	require.NoError(t, err)
+	assert.Len(t, manifest, len(files))
+	sum := sha256.Sum256([]byte("body{}"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), manifest["/assets/app.css"])
+	assert.Contains(t, manifest, "/assets/logo 1.svg")
+}
+
+func TestManifest_Sample(t *testing.T) {
+	manifest := Manifest{"/index.html": "", "/a": "", "/b": "", "/c": "", "/d": ""}
+	assert.Equal(t, []string{"/index.html", "/a", "/c"}, manifest.Sample(3))
+	assert.Equal(t, []string{"/index.html", "/a", "/b", "/c", "/d"}, manifest.Sample(20))
+	assert.Empty(t, Manifest{}.Sample(5))
+}
+
+func TestManifest_Digest(t *testing.T) {
+	manifest := Manifest{"/index.html": "a", "/app.js": "b"}
+	assert.Equal(t, manifest.Digest(), Manifest{"/app.js": "b", "/index.html": "a"}.Digest())
+	assert.NotEqual(t, manifest.Digest(), Manifest{"/index.html": "a", "/app.js": "c"}.Digest())
+	assert.NotEqual(t, manifest.Digest(), Manifest{"/index.html": "a", "/main.js": "b"}.Digest())
+}
+
+func TestManifest_Diff(t *testing.T) {
+	original := Manifest{"/index.html": "a", "/app.js": "b", "/old.css": "c"}
+	rebuilt := Manifest{"/index.html": "a", "/app.js": "d", "/new.css": "c"}
+	assert.Equal(t, []types.FileChange{
+		{Path: "/app.js", Change: types.FileModified, Original: "b", Rebuilt: "d"},
+		{Path: "/new.css", Change: types.FileAdded, Rebuilt: "c"},
+		{Path: "/old.css", Change: types.FileRemoved, Original: "c"},
+	}, original.Diff(rebuilt))
+	assert.Empty(t, original.Diff(original))
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	artifact := writeArtifact(t)
+	var stale atomic.Int32
+	var missing atomic.Bool
+	missing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/assets/app.js" && stale.Load() > 0:
+			stale.Add(-1)
+			_, _ = w.Write([]byte("console.log('v1')"))
+		case r.URL.Path == "/robots.txt" && missing.Load():
+			http.NotFound(w, r)
+		default:
+			content, ok := files["html"+r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(content))
+		}
+	}))
+	defer server.Close()
+
+	v := NewVerifier(&config.IntegrityConfig{Enabled: true, Attempts: 2}, zap.NewNop())
+	v.delay = 0
+
+	checked, err := v.Verify(context.Background(), server.URL, artifact)
+	require.Error(t, err)
+	assert.Equal(t, len(files), checked)
+	assert.Contains(t, err.Error(), "1 of 6 deployed files differ from the artifact: /robots.txt: unexpected status 404")
+
+	// A stale copy that is replaced before the last attempt passes
+	missing.Store(false)
+	stale.Store(1)
+	_, err = v.Verify(context.Background(), server.URL, artifact)
+	assert.NoError(t, err)
+
+	stale.Store(2)
+	_, err = v.Verify(context.Background(), server.URL, artifact)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/assets/app.js: sha256")
+}
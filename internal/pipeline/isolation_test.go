@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/monitor"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// projectOwners grants each user the projects they own, like
+// project.Service does for users that are not admins
+type projectOwners map[string]string
+
+func (o projectOwners) CanAccessProject(username, projectID string) (bool, error) {
+	return o[projectID] == username, nil
+}
+
+func (o projectOwners) OwnsProject(username, projectID string) (bool, error) {
+	return o[projectID] == username, nil
+}
+
+func TestHandler_OwnerIsolation(t *testing.T) {
+	p := &Pipeline{
+		config: &config.PipelineConfig{Exec: config.ExecConfig{Enabled: true, AllowedCommands: []string{"cat"}}},
+		builds: map[string]*types.Build{
+			"shop-1": {ID: "shop-1", ProjectID: "shop", Status: types.BuildStatusSuccess},
+			"blog-1": {ID: "blog-1", ProjectID: "blog", Status: types.BuildStatusSuccess},
+		},
+	}
+	uptime := monitor.NewMonitor(&config.MonitorConfig{Enabled: true}, zap.NewNop())
+	uptime.Track("shop", "production", "https://shop.example.com", nil)
+	uptime.Track("blog", "production", "https://blog.example.com", nil)
+	auditor := &recordingAuditor{}
+	h := NewHandler(p, nil, uptime, nil, &echoDeployer{}, projectOwners{"shop": "alice", "blog": "bob"}, auditor, zap.NewNop())
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	// Bob reaches his own project
+	build, err := h.GetBuild(bob, &pb.GetBuildRequest{BuildId: "blog-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "blog", build.ProjectId)
+	stats, err := h.GetUptime(bob, &pb.GetUptimeRequest{ProjectId: "blog"})
+	require.NoError(t, err)
+	assert.Equal(t, "blog", stats.ProjectId)
+
+	// but nothing of Alice's
+	calls := map[string]func() error{
+		"get build": func() error {
+			_, err := h.GetBuild(bob, &pb.GetBuildRequest{BuildId: "shop-1"})
+			return err
+		},
+		"list builds": func() error {
+			_, err := h.ListBuilds(bob, &pb.ListBuildsRequest{ProjectId: "shop"})
+			return err
+		},
+		"pin build": func() error {
+			_, err := h.PinBuild(bob, &pb.PinBuildRequest{BuildId: "shop-1"})
+			return err
+		},
+		"uptime": func() error {
+			_, err := h.GetUptime(bob, &pb.GetUptimeRequest{ProjectId: "shop"})
+			return err
+		},
+		"restart": func() error {
+			_, err := h.RestartDeployment(bob, &pb.RestartDeploymentRequest{ProjectId: "shop"})
+			return err
+		},
+		"scale": func() error {
+			_, err := h.ScaleDeployment(bob, &pb.ScaleDeploymentRequest{ProjectId: "shop", Replicas: 2})
+			return err
+		},
+		"exec": func() error {
+			_, err := runExec(h, "bob", &pb.ExecRequest{ProjectId: "shop", Command: []string{"cat"}})
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, codes.PermissionDenied, status.Code(call()))
+		})
+	}
+
+	assert.Empty(t, auditor.actions)
+	pinned, err := p.GetBuild("shop-1")
+	require.NoError(t, err)
+	assert.False(t, pinned.Pinned)
+}
@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+func (h *Handler) ListJobRuns(ctx context.Context, req *pb.ListJobRunsRequest) (*pb.ListJobRunsResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+	runner, err := h.jobRunner(req.Job)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := runner.JobRuns(ctx, req.ProjectId, req.Job)
+	if err != nil {
+		return nil, h.jobError(err, "failed to list job runs", req.ProjectId)
+	}
+
+	resp := &pb.ListJobRunsResponse{}
+	for i := range runs {
+		resp.Runs = append(resp.Runs, jobRunToProto(&runs[i]))
+	}
+	return resp, nil
+}
+
+func (h *Handler) TriggerJob(ctx context.Context, req *pb.TriggerJobRequest) (*pb.TriggerJobResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+	runner, err := h.jobRunner(req.Job)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := runner.TriggerJob(ctx, req.ProjectId, req.Job)
+	if err != nil {
+		return nil, h.jobError(err, "failed to trigger job", req.ProjectId)
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.pipeline.RecordProjectEvent(req.ProjectId, types.EventJobTriggered,
+		fmt.Sprintf("job %s triggered by %s", req.Job, username))
+	h.audit(username, "job.trigger", req.ProjectId, map[string]interface{}{
+		"job": req.Job,
+		"run": run.ID,
+	})
+
+	return &pb.TriggerJobResponse{Run: jobRunToProto(run)}, nil
+}
+
+func (h *Handler) GetJobLogs(req *pb.GetJobLogsRequest, stream pb.Pipeline_GetJobLogsServer) error {
+	ctx := stream.Context()
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return err
+	}
+	if req.RunId == "" {
+		return status.Error(codes.InvalidArgument, "run id is required")
+	}
+	if req.Limit < 0 {
+		return status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	runner, ok := h.deployer.(deployer.JobRunner)
+	if !ok {
+		return status.Error(codes.Unimplemented, "jobs are not supported by the deployment platform")
+	}
+
+	opts := deployer.LogOptions{Limit: req.Limit, Follow: req.Follow}
+	err := runner.StreamJobLogs(ctx, req.ProjectId, req.RunId, opts, func(line deployer.LogLine) error {
+		return stream.Send(&pb.LogEntry{Source: line.Source, Line: line.Line})
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			// Client went away, nothing left to report
+			return nil
+		}
+		return h.jobError(err, "failed to stream job logs", req.ProjectId)
+	}
+	return nil
+}
+
+func (h *Handler) jobRunner(job string) (deployer.JobRunner, error) {
+	if job == "" {
+		return nil, status.Error(codes.InvalidArgument, "job is required")
+	}
+	runner, ok := h.deployer.(deployer.JobRunner)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "jobs are not supported by the deployment platform")
+	}
+	return runner, nil
+}
+
+// jobError maps unknown jobs and runs to NotFound and logs anything else
+func (h *Handler) jobError(err error, message, projectID string) error {
+	if errors.Is(err, deployer.ErrJobNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	h.log.Error(message,
+		zap.String("project", projectID),
+		zap.Error(err))
+	return status.Error(codes.Internal, message)
+}
+
+func jobRunToProto(run *types.JobRun) *pb.JobRun {
+	info := &pb.JobRun{
+		Id:        run.ID,
+		Job:       run.Job,
+		Status:    string(run.Status),
+		Manual:    run.Manual,
+		StartedAt: run.StartedAt.Unix(),
+	}
+	if run.FinishedAt != nil {
+		info.FinishedAt = run.FinishedAt.Unix()
+	}
+	return info
+}
@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// SearchLogs returns a page of the project's build events matching the
+// query, newest first. Without a store the events of the running process
+// are matched case-insensitively and returned as a single page.
+func (p *Pipeline) SearchLogs(ctx context.Context, query types.LogQuery, params pagination.Params) (*pagination.Page[types.LogMatch], error) {
+	if p.store != nil {
+		return p.store.SearchLogs(ctx, query, params)
+	}
+	if params.PageToken != "" {
+		return nil, pagination.ErrInvalidPageToken
+	}
+
+	include, exclude := parseLogQuery(query.Query)
+	page := &pagination.Page[types.LogMatch]{}
+	p.mu.RLock()
+	for _, build := range p.builds {
+		if build.ProjectID != query.ProjectID || (query.Status != "" && build.Status != query.Status) {
+			continue
+		}
+		for _, event := range build.Events {
+			if (!query.From.IsZero() && event.Timestamp.Before(query.From)) ||
+				(!query.To.IsZero() && !event.Timestamp.Before(query.To)) {
+				continue
+			}
+			highlights, ok := matchLog(event.Message, include, exclude)
+			if !ok {
+				continue
+			}
+			page.Items = append(page.Items, types.LogMatch{
+				BuildID:     build.ID,
+				BuildStatus: build.Status,
+				Event:       event,
+				Snippet:     event.Message,
+				Highlights:  highlights,
+			})
+		}
+	}
+	p.mu.RUnlock()
+
+	sort.SliceStable(page.Items, func(i, j int) bool {
+		return page.Items[i].Event.Timestamp.After(page.Items[j].Event.Timestamp)
+	})
+	return page, nil
+}
+
+// parseLogQuery splits a web search style query into lowercase words and
+// "quoted phrases" that must match and -words that must not
+func parseLogQuery(query string) (include, exclude []string) {
+	for i, part := range strings.Split(strings.ToLower(query), `"`) {
+		if i%2 == 1 {
+			if phrase := strings.Join(strings.Fields(part), " "); phrase != "" {
+				include = append(include, phrase)
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			switch {
+			case word == "or":
+			case strings.HasPrefix(word, "-") && len(word) > 1:
+				exclude = append(exclude, word[1:])
+			default:
+				include = append(include, word)
+			}
+		}
+	}
+	return include, exclude
+}
+
+// matchLog reports whether the message contains all included terms and
+// none of the excluded ones, and where the included terms occur
+func matchLog(message string, include, exclude []string) ([]types.Highlight, bool) {
+	if len(include) == 0 {
+		return nil, false
+	}
+	lower := strings.ToLower(message)
+	for _, term := range exclude {
+		if strings.Contains(lower, term) {
+			return nil, false
+		}
+	}
+
+	var highlights []types.Highlight
+	for _, term := range include {
+		if !strings.Contains(lower, term) {
+			return nil, false
+		}
+		for offset := 0; ; {
+			i := strings.Index(lower[offset:], term)
+			if i < 0 {
+				break
+			}
+			start := offset + i
+			highlights = append(highlights, types.Highlight{Start: start, End: start + len(term)})
+			offset = start + len(term)
+		}
+	}
+
+	// Overlapping terms are highlighted as one range
+	sort.Slice(highlights, func(i, j int) bool { return highlights[i].Start < highlights[j].Start })
+	merged := highlights[:1]
+	for _, h := range highlights[1:] {
+		last := &merged[len(merged)-1]
+		if h.Start <= last.End {
+			last.End = max(last.End, h.End)
+			continue
+		}
+		merged = append(merged, h)
+	}
+	return merged, true
+}
+
+func (h *Handler) SearchLogs(ctx context.Context, req *pb.SearchLogsRequest) (*pb.SearchLogsResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, status.Error(codes.InvalidArgument, "query is required")
+	}
+
+	query := types.LogQuery{
+		ProjectID: req.ProjectId,
+		Query:     req.Query,
+		Status:    types.BuildStatus(req.Status),
+	}
+	if req.From > 0 {
+		query.From = time.Unix(req.From, 0)
+	}
+	if req.To > 0 {
+		query.To = time.Unix(req.To, 0)
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return nil, status.Error(codes.InvalidArgument, "from must be before to")
+	}
+
+	page, err := h.pipeline.SearchLogs(ctx, query, pagination.Params{
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to search build logs", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to search build logs")
+	}
+
+	resp := &pb.SearchLogsResponse{NextPageToken: page.NextPageToken}
+	for _, match := range page.Items {
+		result := &pb.LogMatch{
+			BuildId:     match.BuildID,
+			BuildStatus: string(match.BuildStatus),
+			Event: &pb.BuildEvent{
+				Type:      string(match.Event.Type),
+				Hook:      match.Event.Hook,
+				Message:   match.Event.Message,
+				Timestamp: match.Event.Timestamp.Unix(),
+			},
+			Snippet: match.Snippet,
+		}
+		for _, highlight := range match.Highlights {
+			result.Highlights = append(result.Highlights, &pb.LogHighlight{
+				Start: int32(highlight.Start),
+				End:   int32(highlight.End),
+			})
+		}
+		resp.Matches = append(resp.Matches, result)
+	}
+	return resp, nil
+}
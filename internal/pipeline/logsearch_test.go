@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+func TestMatchLog(t *testing.T) {
+	message := "Error: Cannot find module 'react' (module not found)"
+
+	tests := []struct {
+		name  string
+		query string
+		want  []types.Highlight
+	}{
+		{name: "words", query: "Module react", want: []types.Highlight{{Start: 19, End: 25}, {Start: 27, End: 32}, {Start: 35, End: 41}}},
+		{name: "phrase", query: `"module not found"`, want: []types.Highlight{{Start: 35, End: 51}}},
+		{name: "missing word", query: "module lodash"},
+		{name: "excluded word", query: "module -react"},
+		{name: "only exclusions", query: "-lodash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			include, exclude := parseLogQuery(tt.query)
+			highlights, ok := matchLog(message, include, exclude)
+			assert.Equal(t, tt.want != nil, ok)
+			assert.Equal(t, tt.want, highlights)
+		})
+	}
+}
+
+func TestHandler_SearchLogs(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := &Pipeline{builds: map[string]*types.Build{
+		"b1": {
+			ID:        "b1",
+			ProjectID: "shop",
+			Status:    types.BuildStatusFailed,
+			Events: []types.DeploymentEvent{
+				{Type: types.EventHookFailed, Message: "Module not found: Can't resolve './App'", Timestamp: now.Add(-time.Hour)},
+			},
+		},
+		"b2": {
+			ID:        "b2",
+			ProjectID: "shop",
+			Status:    types.BuildStatusSuccess,
+			Events: []types.DeploymentEvent{
+				{Type: types.EventHookFailed, Message: "warning: module not found, using fallback", Timestamp: now},
+			},
+		},
+		"b3": {
+			ID:        "b3",
+			ProjectID: "blog",
+			Status:    types.BuildStatusFailed,
+			Events:    []types.DeploymentEvent{{Message: "module not found", Timestamp: now}},
+		},
+	}}
+	h := NewHandler(p, nil, nil, nil, nil, ownerAuthorizer{}, nil, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	resp, err := h.SearchLogs(alice, &pb.SearchLogsRequest{ProjectId: "shop", Query: `"module not found"`})
+	require.NoError(t, err)
+	require.Len(t, resp.Matches, 2)
+	assert.Equal(t, "b2", resp.Matches[0].BuildId, "newest first")
+	assert.Equal(t, "b1", resp.Matches[1].BuildId)
+	assert.Equal(t, []*pb.LogHighlight{{Start: 9, End: 25}}, resp.Matches[0].Highlights)
+
+	resp, err = h.SearchLogs(alice, &pb.SearchLogsRequest{ProjectId: "shop", Query: "module", Status: "failed"})
+	require.NoError(t, err)
+	require.Len(t, resp.Matches, 1)
+	assert.Equal(t, "b1", resp.Matches[0].BuildId)
+
+	resp, err = h.SearchLogs(alice, &pb.SearchLogsRequest{ProjectId: "shop", Query: "module", To: now.Unix()})
+	require.NoError(t, err)
+	require.Len(t, resp.Matches, 1)
+	assert.Equal(t, "b1", resp.Matches[0].BuildId)
+
+	_, err = h.SearchLogs(alice, &pb.SearchLogsRequest{ProjectId: "shop", Query: " "})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = h.SearchLogs(alice, &pb.SearchLogsRequest{ProjectId: "shop", Query: "module", From: now.Unix(), To: now.Unix()})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = h.SearchLogs(bob, &pb.SearchLogsRequest{ProjectId: "shop", Query: "module"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
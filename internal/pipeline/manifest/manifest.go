@@ -0,0 +1,448 @@
+// Package manifest reads chef.yaml, the optional settings file projects
+// keep at the root of their repository.
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// FileName is looked up in the repository root
+const FileName = "chef.yaml"
+
+var ErrInvalidManifest = errors.New("invalid " + FileName)
+
+// headerName matches HTTP header field names (RFC 9110 tokens)
+var headerName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// envName matches portable environment variable names
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jobName matches job and process names usable in Kubernetes object names
+var jobName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,18}[a-z0-9])?$`)
+
+// quantity matches Kubernetes resource quantities such as 250m or 512Mi
+var quantity = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|k|[KMGTPE]i?)?$`)
+
+// scheduleMacros are the cron shorthands Kubernetes accepts besides five
+// field expressions
+var scheduleMacros = map[string]bool{
+	"@yearly": true, "@annually": true, "@monthly": true,
+	"@weekly": true, "@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// scriptName matches package.json script names safe to pass to npm run
+var scriptName = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z:._-]*$`)
+
+// Manifest is the content of chef.yaml
+type Manifest struct {
+	Build Build `yaml:"build"`
+	Serve Serve `yaml:"serve"`
+	Test  Test  `yaml:"test"`
+	// AddOns are managed services provisioned for the project on its first
+	// deploy, "postgres" or "redis". Their connection settings are injected
+	// as env vars, e.g. DATABASE_URL and REDIS_URL.
+	AddOns []string `yaml:"addons"`
+	// Jobs run commands in the project's image on a schedule
+	Jobs []Job `yaml:"jobs"`
+	// Processes run in the project's image next to the web server, like
+	// the entries of a Procfile
+	Processes []Process `yaml:"processes"`
+	// Timeouts override the server's phase timeouts for the project
+	Timeouts Timeouts `yaml:"timeouts"`
+	// Hooks run in order after each successful deploy
+	Hooks []Hook `yaml:"hooks"`
+}
+
+// Hook is a post-deploy hook. Command hooks run in the project's image
+// and k8s_job hooks in their own image, both as Kubernetes Jobs; http
+// hooks send a request whose url and body may use env templates.
+type Hook struct {
+	Name          string              `yaml:"name"`
+	Type          types.HookType      `yaml:"type"` // command, http or k8s_job
+	Command       []string            `yaml:"command"`
+	Image         string              `yaml:"image"`
+	URL           string              `yaml:"url"`
+	Method        string              `yaml:"method"` // Defaults to POST
+	Body          string              `yaml:"body"`
+	Headers       map[string]string   `yaml:"headers"`
+	Timeout       int                 `yaml:"timeout"`        // Seconds, defaults to 5 minutes
+	FailurePolicy types.FailurePolicy `yaml:"failure_policy"` // ignore, warn or rollback, defaults to warn
+}
+
+// Timeouts are the seconds each phase of the project's builds may take,
+// the server's default when 0. The clone phase runs before chef.yaml is
+// read and cannot be overridden.
+type Timeouts struct {
+	Install     int `yaml:"install"`
+	Build       int `yaml:"build"`
+	Package     int `yaml:"package"`
+	Deploy      int `yaml:"deploy"`
+	HealthCheck int `yaml:"health_check"`
+}
+
+// Phases returns the overrides in the form builds carry them
+func (t Timeouts) Phases() types.PhaseTimeouts {
+	return types.PhaseTimeouts{
+		Install:     t.Install,
+		Build:       t.Build,
+		Package:     t.Package,
+		Deploy:      t.Deploy,
+		HealthCheck: t.HealthCheck,
+	}
+}
+
+// Process is a long-running command such as a queue worker. It receives
+// no HTTP traffic.
+type Process struct {
+	Name     string            `yaml:"name"`
+	Command  string            `yaml:"command"`  // Run with /bin/sh -c
+	Replicas int32             `yaml:"replicas"` // Of the first deploy, defaults to 1
+	Env      map[string]string `yaml:"env"`      // Added to the project's env vars
+}
+
+// Job is a command run on a cron schedule, e.g. a nightly cleanup
+type Job struct {
+	Name     string `yaml:"name"`
+	Schedule string `yaml:"schedule"` // Five cron fields in UTC, or a macro like @daily
+	Command  string `yaml:"command"`  // Run with /bin/sh -c
+	CPU      string `yaml:"cpu"`      // e.g. "250m", no limit when empty
+	Memory   string `yaml:"memory"`   // e.g. "256Mi", no limit when empty
+}
+
+// Build configures the container dependencies are installed and the
+// project is built in, and what is done with the bundle's source maps
+type Build struct {
+	// Memory limits the build container, e.g. "4Gi". Defaults to the
+	// server's nodejs.build_memory.
+	Memory string `yaml:"memory"`
+	// MaxOldSpaceSize is Node's heap limit in megabytes, passed in
+	// NODE_OPTIONS. Defaults to three quarters of the memory limit.
+	MaxOldSpaceSize int `yaml:"max_old_space_size"`
+	// SourceMaps keeps the bundle's *.map files, strips them from the
+	// artifact, or uploads them to the server's error tracker before
+	// stripping them: "keep", "strip" or "upload". Defaults to keep.
+	SourceMaps types.SourceMaps `yaml:"source_maps"`
+}
+
+// Test runs a package.json script after dependencies are installed and
+// before the build. Failed tests fail the build.
+type Test struct {
+	// Script is run with npm run, e.g. "test". No tests run when empty.
+	Script string `yaml:"script"`
+	// Reports are JUnit XML or Jest JSON files, or directories of them,
+	// relative to the repository root
+	Reports []string `yaml:"reports"`
+	// Coverage are lcov or Cobertura XML files, or directories of them,
+	// relative to the repository root
+	Coverage []string `yaml:"coverage"`
+	// MinCoverage fails builds whose line coverage in percent is lower
+	MinCoverage float64 `yaml:"min_coverage"`
+}
+
+// Serve configures the web server of the runtime image
+type Serve struct {
+	// SPA serves index.html for paths that match no file so client-side
+	// routes survive a refresh. Defaults to true.
+	SPA *bool `yaml:"spa"`
+	// Headers are added to every response
+	Headers map[string]string `yaml:"headers"`
+	// Gzip compresses text responses on the fly. Defaults to true.
+	Gzip *bool `yaml:"gzip"`
+	// Brotli compresses text responses for clients that accept it. It
+	// requires a runtime image with the brotli module.
+	Brotli bool `yaml:"brotli"`
+	// Cache sets Cache-Control per request path. The first matching rule
+	// applies.
+	Cache []CacheRule `yaml:"cache"`
+}
+
+// CacheRule sets the Cache-Control header of paths matching Pattern. "*"
+// and "?" stay within a path segment and "**" spans segments; patterns
+// without a leading "/" match in any directory, so "*.js" matches
+// /assets/app.js.
+type CacheRule struct {
+	Pattern string `yaml:"pattern"`
+	Control string `yaml:"control"` // e.g. "public, max-age=31536000, immutable"
+}
+
+func (s Serve) SPAEnabled() bool {
+	return s.SPA == nil || *s.SPA
+}
+
+func (s Serve) GzipEnabled() bool {
+	return s.Gzip == nil || *s.Gzip
+}
+
+// Load reads chef.yaml from dir. A missing file yields the defaults.
+func Load(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &Manifest{}, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", FileName, err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates chef.yaml content. Unknown keys are
+// rejected so typos do not go unnoticed.
+func Parse(data []byte) (*Manifest, error) {
+	var m Manifest
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate rejects values that cannot be written safely into the server
+// configuration
+func (m *Manifest) Validate() error {
+	if m.Build.Memory != "" && !quantity.MatchString(m.Build.Memory) {
+		return fmt.Errorf("%w: invalid build memory %q", ErrInvalidManifest, m.Build.Memory)
+	}
+	if m.Build.MaxOldSpaceSize < 0 {
+		return fmt.Errorf("%w: max_old_space_size must not be negative", ErrInvalidManifest)
+	}
+	if !types.ValidSourceMaps(m.Build.SourceMaps) {
+		return fmt.Errorf("%w: source_maps %q is not supported, expected keep, strip or upload", ErrInvalidManifest, m.Build.SourceMaps)
+	}
+	for name, value := range m.Serve.Headers {
+		if !headerName.MatchString(name) {
+			return fmt.Errorf("%w: invalid header name %q", ErrInvalidManifest, name)
+		}
+		if !printable(value) {
+			return fmt.Errorf("%w: header %s has an invalid value", ErrInvalidManifest, name)
+		}
+	}
+	for _, rule := range m.Serve.Cache {
+		if rule.Pattern == "" || !printable(rule.Pattern) || strings.ContainsAny(rule.Pattern, " \"'{};") {
+			return fmt.Errorf("%w: invalid cache pattern %q", ErrInvalidManifest, rule.Pattern)
+		}
+		if rule.Control == "" || !printable(rule.Control) {
+			return fmt.Errorf("%w: cache rule %s needs a valid control value", ErrInvalidManifest, rule.Pattern)
+		}
+	}
+	if m.Test.Script != "" && !scriptName.MatchString(m.Test.Script) {
+		return fmt.Errorf("%w: invalid test script %q", ErrInvalidManifest, m.Test.Script)
+	}
+	for _, report := range append(m.Test.Reports, m.Test.Coverage...) {
+		if !localPath(report) {
+			return fmt.Errorf("%w: test report %q must be a path within the repository", ErrInvalidManifest, report)
+		}
+	}
+	if m.Test.MinCoverage < 0 || m.Test.MinCoverage > 100 {
+		return fmt.Errorf("%w: min_coverage must be between 0 and 100", ErrInvalidManifest)
+	}
+	if m.Test.MinCoverage > 0 && len(m.Test.Coverage) == 0 {
+		return fmt.Errorf("%w: min_coverage needs a coverage report", ErrInvalidManifest)
+	}
+	seen := make(map[string]bool, len(m.AddOns))
+	for _, kind := range m.AddOns {
+		if !types.ValidAddOn(kind) {
+			return fmt.Errorf("%w: unknown addon %q, expected postgres or redis", ErrInvalidManifest, kind)
+		}
+		if seen[kind] {
+			return fmt.Errorf("%w: addon %s is listed twice", ErrInvalidManifest, kind)
+		}
+		seen[kind] = true
+	}
+	names := make(map[string]bool, len(m.Jobs))
+	for _, job := range m.Jobs {
+		if err := job.validate(); err != nil {
+			return err
+		}
+		if names[job.Name] {
+			return fmt.Errorf("%w: job %s is defined twice", ErrInvalidManifest, job.Name)
+		}
+		names[job.Name] = true
+	}
+	processes := make(map[string]bool, len(m.Processes))
+	for _, process := range m.Processes {
+		if err := process.validate(); err != nil {
+			return err
+		}
+		if processes[process.Name] {
+			return fmt.Errorf("%w: process %s is defined twice", ErrInvalidManifest, process.Name)
+		}
+		processes[process.Name] = true
+	}
+	hooks := make(map[string]bool, len(m.Hooks))
+	for _, hook := range m.Hooks {
+		if err := hook.validate(); err != nil {
+			return err
+		}
+		if hooks[hook.Name] {
+			return fmt.Errorf("%w: hook %s is defined twice", ErrInvalidManifest, hook.Name)
+		}
+		hooks[hook.Name] = true
+	}
+	t := m.Timeouts
+	if t.Install < 0 || t.Build < 0 || t.Package < 0 || t.Deploy < 0 || t.HealthCheck < 0 {
+		return fmt.Errorf("%w: timeouts must not be negative", ErrInvalidManifest)
+	}
+	return nil
+}
+
+func (p Process) validate() error {
+	if !jobName.MatchString(p.Name) {
+		return fmt.Errorf("%w: invalid process name %q, use up to 20 lowercase letters, digits and dashes", ErrInvalidManifest, p.Name)
+	}
+	if p.Name == types.WebProcess {
+		return fmt.Errorf("%w: the web process is the project's server and cannot be redefined", ErrInvalidManifest)
+	}
+	if strings.TrimSpace(p.Command) == "" {
+		return fmt.Errorf("%w: process %s needs a command", ErrInvalidManifest, p.Name)
+	}
+	if p.Replicas < 0 {
+		return fmt.Errorf("%w: process %s has negative replicas", ErrInvalidManifest, p.Name)
+	}
+	for name := range p.Env {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("%w: process %s has an invalid env var name %q", ErrInvalidManifest, p.Name, name)
+		}
+	}
+	return nil
+}
+
+func (j Job) validate() error {
+	if !jobName.MatchString(j.Name) {
+		return fmt.Errorf("%w: invalid job name %q, use up to 20 lowercase letters, digits and dashes", ErrInvalidManifest, j.Name)
+	}
+	fields := strings.Fields(j.Schedule)
+	if !(len(fields) == 5 || len(fields) == 1 && scheduleMacros[fields[0]]) || !printable(j.Schedule) {
+		return fmt.Errorf("%w: job %s has an invalid schedule %q", ErrInvalidManifest, j.Name, j.Schedule)
+	}
+	if strings.TrimSpace(j.Command) == "" {
+		return fmt.Errorf("%w: job %s needs a command", ErrInvalidManifest, j.Name)
+	}
+	if j.CPU != "" && !quantity.MatchString(j.CPU) {
+		return fmt.Errorf("%w: job %s has an invalid cpu %q", ErrInvalidManifest, j.Name, j.CPU)
+	}
+	if j.Memory != "" && !quantity.MatchString(j.Memory) {
+		return fmt.Errorf("%w: job %s has an invalid memory %q", ErrInvalidManifest, j.Name, j.Memory)
+	}
+	return nil
+}
+
+func (h Hook) validate() error {
+	if !jobName.MatchString(h.Name) {
+		return fmt.Errorf("%w: invalid hook name %q, use up to 20 lowercase letters, digits and dashes", ErrInvalidManifest, h.Name)
+	}
+	switch h.Type {
+	case types.HookTypeCommand:
+		if len(h.Command) == 0 {
+			return fmt.Errorf("%w: hook %s needs a command", ErrInvalidManifest, h.Name)
+		}
+	case types.HookTypeHTTP:
+		if h.URL == "" || !printable(h.URL) {
+			return fmt.Errorf("%w: hook %s needs a valid url", ErrInvalidManifest, h.Name)
+		}
+	case types.HookTypeK8sJob:
+		if h.Image == "" || !printable(h.Image) {
+			return fmt.Errorf("%w: hook %s needs a valid image", ErrInvalidManifest, h.Name)
+		}
+	default:
+		return fmt.Errorf("%w: hook %s has an unknown type %q, expected command, http or k8s_job", ErrInvalidManifest, h.Name, h.Type)
+	}
+	for name, value := range h.Headers {
+		if !headerName.MatchString(name) || !printable(value) {
+			return fmt.Errorf("%w: hook %s has an invalid header %q", ErrInvalidManifest, h.Name, name)
+		}
+	}
+	switch h.FailurePolicy {
+	case "", types.FailurePolicyIgnore, types.FailurePolicyWarn, types.FailurePolicyRollback:
+	default:
+		return fmt.Errorf("%w: hook %s has an unknown failure policy %q", ErrInvalidManifest, h.Name, h.FailurePolicy)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("%w: hook %s has a negative timeout", ErrInvalidManifest, h.Name)
+	}
+	return nil
+}
+
+// BuildHooks returns the hooks in the form builds carry them
+func (m *Manifest) BuildHooks() []types.Hook {
+	var hooks []types.Hook
+	for _, hook := range m.Hooks {
+		hooks = append(hooks, types.Hook{
+			Name:           hook.Name,
+			Type:           hook.Type,
+			Command:        hook.Command,
+			Image:          hook.Image,
+			URL:            hook.URL,
+			Method:         hook.Method,
+			Body:           hook.Body,
+			Headers:        hook.Headers,
+			TimeoutSeconds: hook.Timeout,
+			FailurePolicy:  hook.FailurePolicy,
+		})
+	}
+	return hooks
+}
+
+// BuildProcesses returns the processes in the form builds carry them
+func (m *Manifest) BuildProcesses() []types.Process {
+	var processes []types.Process
+	for _, process := range m.Processes {
+		replicas := process.Replicas
+		if replicas == 0 {
+			replicas = 1
+		}
+		processes = append(processes, types.Process{
+			Name:     process.Name,
+			Command:  process.Command,
+			Replicas: replicas,
+			Env:      process.Env,
+		})
+	}
+	return processes
+}
+
+// BuildJobs returns the jobs in the form builds carry them
+func (m *Manifest) BuildJobs() []types.Job {
+	var jobs []types.Job
+	for _, job := range m.Jobs {
+		jobs = append(jobs, types.Job{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Command:  job.Command,
+			CPU:      job.CPU,
+			Memory:   job.Memory,
+		})
+	}
+	return jobs
+}
+
+// localPath reports whether p is a relative path that stays within its
+// root
+func localPath(p string) bool {
+	return p != "" && printable(p) && filepath.IsLocal(p)
+}
+
+// printable reports whether s is free of control characters, which would
+// let a value break out of its configuration line
+func printable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("missing file uses defaults", func(t *testing.T) {
+		m, err := Load(t.TempDir())
+		require.NoError(t, err)
+		assert.True(t, m.Serve.SPAEnabled())
+		assert.True(t, m.Serve.GzipEnabled())
+		assert.False(t, m.Serve.Brotli)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `
+build:
+  memory: 4Gi
+  max_old_space_size: 3072
+  source_maps: upload
+serve:
+  spa: false
+  brotli: true
+  headers:
+    X-Frame-Options: DENY
+  cache:
+    - pattern: "/assets/**"
+      control: "public, max-age=31536000, immutable"
+test:
+  script: test:ci
+  reports: [reports/junit.xml]
+  coverage: [coverage/lcov.info]
+  min_coverage: 80
+addons: [postgres, redis]
+jobs:
+  - name: cleanup
+    schedule: "0 3 * * *"
+    command: node scripts/cleanup.js
+    memory: 256Mi
+processes:
+  - name: worker
+    command: node worker.js
+    env:
+      QUEUE: emails
+timeouts:
+  install: 1200
+  health_check: 60
+hooks:
+  - name: migrate
+    type: command
+    command: [npm, run, migrate]
+    failure_policy: rollback
+  - name: announce
+    type: http
+    url: https://hooks.example.com/deploy
+    timeout: 10
+`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0644))
+
+		m, err := Load(dir)
+		require.NoError(t, err)
+		assert.Equal(t, Build{Memory: "4Gi", MaxOldSpaceSize: 3072, SourceMaps: types.SourceMapsUpload}, m.Build)
+		assert.False(t, m.Serve.SPAEnabled())
+		assert.True(t, m.Serve.Brotli)
+		assert.Equal(t, map[string]string{"X-Frame-Options": "DENY"}, m.Serve.Headers)
+		assert.Equal(t, []CacheRule{{Pattern: "/assets/**", Control: "public, max-age=31536000, immutable"}}, m.Serve.Cache)
+		assert.Equal(t, Test{
+			Script:      "test:ci",
+			Reports:     []string{"reports/junit.xml"},
+			Coverage:    []string{"coverage/lcov.info"},
+			MinCoverage: 80,
+		}, m.Test)
+		assert.Equal(t, []string{"postgres", "redis"}, m.AddOns)
+		assert.Equal(t, []types.Job{{
+			Name:     "cleanup",
+			Schedule: "0 3 * * *",
+			Command:  "node scripts/cleanup.js",
+			Memory:   "256Mi",
+		}}, m.BuildJobs())
+		assert.Equal(t, []types.Process{{
+			Name:     "worker",
+			Command:  "node worker.js",
+			Replicas: 1,
+			Env:      map[string]string{"QUEUE": "emails"},
+		}}, m.BuildProcesses())
+		assert.Equal(t, types.PhaseTimeouts{Install: 1200, HealthCheck: 60}, m.Timeouts.Phases())
+		assert.Equal(t, []types.Hook{
+			{Name: "migrate", Type: types.HookTypeCommand, Command: []string{"npm", "run", "migrate"}, FailurePolicy: types.FailurePolicyRollback},
+			{Name: "announce", Type: types.HookTypeHTTP, URL: "https://hooks.example.com/deploy", TimeoutSeconds: 10},
+		}, m.BuildHooks())
+	})
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown key", "serve:\n  spa_fallback: true\n"},
+		{"malformed", "serve: [\n"},
+		{"build memory", "build:\n  memory: 4GB\n"},
+		{"negative heap", "build:\n  max_old_space_size: -1\n"},
+		{"source maps", "build:\n  source_maps: hide\n"},
+		{"header name", "serve:\n  headers:\n    \"X Bad\": value\n"},
+		{"header value", "serve:\n  headers:\n    X-Test: \"a\\nb\"\n"},
+		{"empty pattern", "serve:\n  cache:\n    - control: no-cache\n"},
+		{"pattern with brace", "serve:\n  cache:\n    - pattern: \"*.js}\"\n      control: no-cache\n"},
+		{"missing control", "serve:\n  cache:\n    - pattern: \"*.js\"\n"},
+		{"test script with shell", "test:\n  script: \"test && curl evil\"\n"},
+		{"report outside repository", "test:\n  script: test\n  reports: [\"../junit.xml\"]\n"},
+		{"coverage above 100", "test:\n  script: test\n  coverage: [coverage/lcov.info]\n  min_coverage: 120\n"},
+		{"min coverage without report", "test:\n  script: test\n  min_coverage: 80\n"},
+		{"unknown addon", "addons: [mysql]\n"},
+		{"duplicate addon", "addons: [redis, redis]\n"},
+		{"job name", "jobs:\n  - name: Nightly_Cleanup\n    schedule: \"@daily\"\n    command: true\n"},
+		{"job schedule", "jobs:\n  - name: cleanup\n    schedule: \"0 3 * *\"\n    command: true\n"},
+		{"job without command", "jobs:\n  - name: cleanup\n    schedule: \"@daily\"\n"},
+		{"job memory", "jobs:\n  - name: cleanup\n    schedule: \"@daily\"\n    command: true\n    memory: 1GB\n"},
+		{"web process", "processes:\n  - name: web\n    command: node server.js\n"},
+		{"process without command", "processes:\n  - name: worker\n"},
+		{"process replicas", "processes:\n  - name: worker\n    command: node worker.js\n    replicas: -1\n"},
+		{"process env name", "processes:\n  - name: worker\n    command: node worker.js\n    env:\n      BAD-NAME: x\n"},
+		{"negative timeout", "timeouts:\n  deploy: -1\n"},
+		{"hook name", "hooks:\n  - {name: \"a b\", type: http, url: \"https://x\"}\n"},
+		{"hook type", "hooks:\n  - {name: purge, type: shell, command: [\"true\"]}\n"},
+		{"command hook without command", "hooks:\n  - {name: purge, type: command}\n"},
+		{"hook failure policy", "hooks:\n  - {name: purge, type: http, url: \"https://x\", failure_policy: retry}\n"},
+		{"duplicate hook", "hooks:\n  - {name: a, type: http, url: \"https://x\"}\n  - {name: a, type: http, url: \"https://y\"}\n"},
+		{"duplicate job", "jobs:\n  - {name: a, schedule: \"@daily\", command: \"true\"}\n  - {name: a, schedule: \"@hourly\", command: \"true\"}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content))
+			assert.ErrorIs(t, err, ErrInvalidManifest)
+		})
+	}
+
+	m, err := Parse(nil)
+	require.NoError(t, err)
+	assert.True(t, m.Serve.SPAEnabled())
+}
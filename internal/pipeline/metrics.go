@@ -1,8 +1,14 @@
 package pipeline
 
 import (
+	"fmt"
+	"io"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/elskow/chef-infra/internal/events"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
 )
 
 type BuildMetrics struct {
@@ -15,14 +21,45 @@ type BuildMetrics struct {
 	WarningCount   int
 }
 
+// stageBuckets are the upper bounds in seconds of the stage duration
+// histogram buckets
+var stageBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	buckets []int // Observations up to each of stageBuckets
+	count   int
+	sum     float64
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range stageBuckets {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// MetricsCollector tracks the builds of this instance. Finished builds are
+// folded into per-status totals and their stage timings into histograms.
 type MetricsCollector struct {
-	metrics map[string]*BuildMetrics
-	mu      sync.RWMutex
+	metrics   map[string]*BuildMetrics
+	finished  map[string]int
+	durations map[string]time.Duration
+	events    map[string]int
+	stages    map[string]*histogram
+	mu        sync.RWMutex
 }
 
 func NewMetricsCollector() *MetricsCollector {
 	return &MetricsCollector{
-		metrics: make(map[string]*BuildMetrics),
+		metrics:   make(map[string]*BuildMetrics),
+		finished:  make(map[string]int),
+		durations: make(map[string]time.Duration),
+		events:    make(map[string]int),
+		stages:    make(map[string]*histogram),
 	}
 }
 
@@ -44,5 +81,95 @@ func (mc *MetricsCollector) EndBuild(buildID string, status string) {
 		m.EndTime = time.Now()
 		m.BuildDuration = m.EndTime.Sub(m.StartTime)
 		m.Status = status
+
+		mc.finished[status]++
+		mc.durations[status] += m.BuildDuration
+		delete(mc.metrics, buildID)
+	}
+}
+
+// ObserveStages adds the stage timings of a build run to the stage
+// duration histograms
+func (mc *MetricsCollector) ObserveStages(stages []types.StageTiming) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for _, stage := range stages {
+		h, ok := mc.stages[string(stage.Stage)]
+		if !ok {
+			h = &histogram{buckets: make([]int, len(stageBuckets))}
+			mc.stages[string(stage.Stage)] = h
+		}
+		h.observe(stage.Duration.Seconds())
+	}
+}
+
+// finishedStatus maps the events that end a build to its final status
+var finishedStatus = map[types.LifecycleEvent]string{
+	types.LifecycleBuildSucceeded:   "succeeded",
+	types.LifecycleBuildFailed:      "failed",
+	types.LifecycleBuildCancelled:   "cancelled",
+	types.LifecycleBuildSuperseded:  "superseded",
+	types.LifecycleBuildInterrupted: "interrupted",
+}
+
+// Handle consumes the build and deploy events this instance publishes
+func (mc *MetricsCollector) Handle(event events.Event) {
+	if event.Build == nil {
+		return
+	}
+	mc.mu.Lock()
+	mc.events[event.Type]++
+	mc.mu.Unlock()
+
+	lifecycle := types.LifecycleEvent(event.Type)
+	if lifecycle == types.LifecycleBuildStarted {
+		mc.StartBuild(event.Build.ID)
+	} else if status, ok := finishedStatus[lifecycle]; ok {
+		mc.EndBuild(event.Build.ID, status)
+	}
+}
+
+// WriteMetrics writes build metrics in the Prometheus text exposition format
+func (mc *MetricsCollector) WriteMetrics(w io.Writer) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	fmt.Fprintf(w, "# HELP chef_builds_running Builds in progress.\n# TYPE chef_builds_running gauge\n")
+	fmt.Fprintf(w, "chef_builds_running %d\n", len(mc.metrics))
+
+	fmt.Fprintf(w, "# HELP chef_builds_total Finished builds by status.\n# TYPE chef_builds_total counter\n")
+	for _, status := range sortedKeys(mc.finished) {
+		fmt.Fprintf(w, "chef_builds_total{status=\"%s\"} %d\n", status, mc.finished[status])
+	}
+
+	fmt.Fprintf(w, "# HELP chef_build_duration_seconds_total Time spent in finished builds by status.\n# TYPE chef_build_duration_seconds_total counter\n")
+	for _, status := range sortedKeys(mc.durations) {
+		fmt.Fprintf(w, "chef_build_duration_seconds_total{status=\"%s\"} %g\n", status, mc.durations[status].Seconds())
+	}
+
+	fmt.Fprintf(w, "# HELP chef_build_stage_duration_seconds Time builds spent in each stage.\n# TYPE chef_build_stage_duration_seconds histogram\n")
+	for _, stage := range sortedKeys(mc.stages) {
+		h := mc.stages[stage]
+		for i, bound := range stageBuckets {
+			fmt.Fprintf(w, "chef_build_stage_duration_seconds_bucket{stage=\"%s\",le=\"%g\"} %d\n", stage, bound, h.buckets[i])
+		}
+		fmt.Fprintf(w, "chef_build_stage_duration_seconds_bucket{stage=\"%s\",le=\"+Inf\"} %d\n", stage, h.count)
+		fmt.Fprintf(w, "chef_build_stage_duration_seconds_sum{stage=\"%s\"} %g\n", stage, h.sum)
+		fmt.Fprintf(w, "chef_build_stage_duration_seconds_count{stage=\"%s\"} %d\n", stage, h.count)
+	}
+
+	fmt.Fprintf(w, "# HELP chef_lifecycle_events_total Build and deploy events.\n# TYPE chef_lifecycle_events_total counter\n")
+	for _, event := range sortedKeys(mc.events) {
+		fmt.Fprintf(w, "chef_lifecycle_events_total{event=\"%s\"} %d\n", event, mc.events[event])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
+	slices.Sort(keys)
+	return keys
 }
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elskow/chef-infra/internal/events"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestMetricsCollector_Handle(t *testing.T) {
+	mc := NewMetricsCollector()
+	build := func(id string) *types.Build { return &types.Build{ID: id} }
+
+	mc.Handle(events.Event{Type: string(types.LifecycleBuildStarted), Build: build("a")})
+	mc.Handle(events.Event{Type: string(types.LifecycleBuildStarted), Build: build("b")})
+	mc.Handle(events.Event{Type: string(types.LifecycleBuildFailed), Build: build("a")})
+	mc.Handle(events.Event{Type: events.AuthLogin, Actor: "alice"})
+
+	var out strings.Builder
+	mc.WriteMetrics(&out)
+	metrics := out.String()
+
+	assert.Contains(t, metrics, "chef_builds_running 1\n")
+	assert.Contains(t, metrics, "chef_builds_total{status=\"failed\"} 1\n")
+	assert.Contains(t, metrics, "chef_lifecycle_events_total{event=\"build.started\"} 2\n")
+	assert.NotContains(t, metrics, "auth.login")
+}
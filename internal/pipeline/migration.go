@@ -0,0 +1,592 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/monitor"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const (
+	defaultRollbackWindow         = 24 * time.Hour
+	defaultMigrationHealthTimeout = 5 * time.Minute
+	migrationHealthInterval       = 5 * time.Second
+	migrationSweepInterval        = time.Minute
+)
+
+var (
+	ErrInvalidMigration    = errors.New("invalid migration")
+	ErrMigrationInProgress = errors.New("the environment is being migrated")
+	ErrMigrationNotFound   = errors.New("the environment was never migrated")
+	ErrNotRollbackable     = errors.New("the migration can no longer be rolled back")
+	ErrNothingToMigrate    = errors.New("no deployed build of the environment to migrate")
+)
+
+// migrationKey identifies a project environment
+type migrationKey struct {
+	projectID   string
+	environment string
+}
+
+func (p *Pipeline) rollbackWindow() time.Duration {
+	if p.config.Migration.RollbackWindow > 0 {
+		return time.Duration(p.config.Migration.RollbackWindow) * time.Second
+	}
+	return defaultRollbackWindow
+}
+
+func (p *Pipeline) migrationHealthTimeout() time.Duration {
+	if p.config.Migration.HealthTimeout > 0 {
+		return time.Duration(p.config.Migration.HealthTimeout) * time.Second
+	}
+	return defaultMigrationHealthTimeout
+}
+
+// MigrateProject moves a project's environment to another deploy target.
+// The environment's latest build is deployed to the target in the
+// background and traffic is switched once the target is healthy; the old
+// target keeps its deployment for the rollback window. Only builds of the
+// running process can be migrated since their artifacts and settings are
+// held in memory. Hooks are not run again.
+func (p *Pipeline) MigrateProject(ctx context.Context, projectID, environment, to string) (*types.Migration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := p.namedTarget(to); !ok {
+		return nil, fmt.Errorf("%w: unknown target %s", ErrInvalidMigration, to)
+	}
+
+	key := migrationKey{projectID, environment}
+	p.mu.Lock()
+	if current := p.migrations[key]; current != nil && (current.InProgress() || current.Status == types.MigrationSwitched) {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("%w: migration to %s is %s", ErrMigrationInProgress, describeTarget(current.To), current.Status)
+	}
+	from := p.servingTarget(projectID, environment)
+	if from == to {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s already serves %s", ErrInvalidMigration, describeTarget(to), environment)
+	}
+	if p.servesOtherEnvironment(projectID, environment, to) {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s serves another environment of %s", ErrInvalidMigration, describeTarget(to), projectID)
+	}
+
+	var build *types.Build
+	for _, candidate := range p.builds {
+		if candidate.ProjectID != projectID || candidate.Environment != environment || candidate.Status != types.BuildStatusSuccess {
+			continue
+		}
+		if candidate.PreviewOnly && (candidate.Preview == nil || candidate.Preview.PromotedAt == nil) {
+			continue
+		}
+		if build == nil || candidate.StartTime.After(build.StartTime) {
+			build = candidate
+		}
+	}
+	if build == nil {
+		p.mu.Unlock()
+		return nil, ErrNothingToMigrate
+	}
+	snapshot := *build
+	snapshot.Events = nil
+	snapshot.CancelFunc = nil
+
+	migration := &types.Migration{
+		ProjectID:   projectID,
+		Environment: environment,
+		From:        from,
+		To:          to,
+		BuildID:     build.ID,
+		Status:      types.MigrationDeploying,
+		StartedAt:   time.Now(),
+	}
+	p.migrations[key] = migration
+	result := *migration
+	p.running.Add(1)
+	p.mu.Unlock()
+
+	p.saveMigration(migration)
+	go p.runMigration(migration, &snapshot)
+	return &result, nil
+}
+
+// servesOtherEnvironment reports whether target serves another
+// environment of the project. Deployments are named after the project, so
+// each target holds one environment of it. Callers hold mu.
+func (p *Pipeline) servesOtherEnvironment(projectID, environment, target string) bool {
+	for key, migration := range p.migrations {
+		if key.projectID == projectID && key.environment != environment && migration.Serving() == target {
+			return true
+		}
+	}
+	// Targets are named after the environment they were configured for
+	if target == "" || target == environment || p.servingTarget(projectID, target) != target {
+		return false
+	}
+	for _, build := range p.builds {
+		if build.ProjectID == projectID && build.Environment == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pipeline) runMigration(migration *types.Migration, build *types.Build) {
+	defer p.running.Done()
+	ctx := buildlog.WithBuild(p.baseContext(), build)
+
+	if err := p.moveTraffic(ctx, migration, build); err != nil {
+		p.logger.Error("migration failed",
+			zap.String("project", migration.ProjectID),
+			zap.String("environment", migration.Environment),
+			zap.Error(err))
+		// Nothing points at the new target yet
+		if rmErr := p.removeDeployment(context.WithoutCancel(ctx), migration.To, migration.ProjectID); rmErr != nil {
+			p.logger.Warn("failed to remove the deployment of a failed migration",
+				zap.String("project", migration.ProjectID),
+				zap.Error(rmErr))
+		}
+		finished := time.Now()
+		p.updateMigration(migration, func(m *types.Migration) {
+			m.Status = types.MigrationFailed
+			m.Message = err.Error()
+			m.FinishedAt = &finished
+		})
+		p.RecordProjectEvent(migration.ProjectID, types.EventMigrationFailed, err.Error())
+		return
+	}
+
+	switched := time.Now()
+	until := switched.Add(p.rollbackWindow())
+	p.updateMigration(migration, func(m *types.Migration) {
+		m.Status = types.MigrationSwitched
+		m.SwitchedAt = &switched
+		m.RollbackUntil = &until
+	})
+	p.trackServing(migration.ProjectID, migration.Environment)
+	p.RecordProjectEvent(migration.ProjectID, types.EventMigrationSwitched,
+		fmt.Sprintf("%s moved from %s to %s, rollback possible until %s",
+			migration.Environment, describeTarget(migration.From), describeTarget(migration.To), until.UTC().Format(time.RFC3339)))
+	p.logger.Info("migrated project",
+		zap.String("project", migration.ProjectID),
+		zap.String("environment", migration.Environment),
+		zap.String("from", describeTarget(migration.From)),
+		zap.String("to", describeTarget(migration.To)))
+}
+
+// moveTraffic deploys the build to the migration's new target, waits for
+// it to become healthy and switches traffic to it
+func (p *Pipeline) moveTraffic(ctx context.Context, migration *types.Migration, build *types.Build) error {
+	target, _ := p.namedTarget(migration.To)
+	if err := target.deployer.Validate(build); err != nil {
+		return fmt.Errorf("build %s cannot be deployed to %s: %w", build.ID, describeTarget(migration.To), err)
+	}
+	p.deploying.Add(1)
+	err := target.deployer.Deploy(ctx, build)
+	p.deploying.Add(-1)
+	if err != nil {
+		return fmt.Errorf("deployment failed: %w", err)
+	}
+
+	p.updateMigration(migration, func(m *types.Migration) {
+		m.Status = types.MigrationVerifying
+	})
+	healthPath := p.config.Migration.HealthPath
+	if healthPath == "" {
+		healthPath = "/"
+	}
+	if err := p.waitHealthy(ctx, p.targetURL(migration.To, migration.ProjectID)+healthPath); err != nil {
+		return err
+	}
+
+	if err := p.switchTraffic(ctx, migration, migration.From, migration.To); err != nil {
+		return fmt.Errorf("failed to switch traffic: %w", err)
+	}
+	return nil
+}
+
+// waitHealthy polls url until it responds with a 2xx status or the
+// health timeout passes
+func (p *Pipeline) waitHealthy(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.migrationHealthTimeout())
+	defer cancel()
+
+	ticker := time.NewTicker(migrationHealthInterval)
+	defer ticker.Stop()
+	for {
+		err := p.checkHealth(ctx, url)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not become healthy: %w", url, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pipeline) checkHealth(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// switchTraffic runs the configured switch command to move DNS or ingress
+// of the migration's environment from one target to another
+func (p *Pipeline) switchTraffic(ctx context.Context, migration *types.Migration, from, to string) error {
+	command := p.config.Migration.SwitchCommand
+	if len(command) == 0 {
+		return nil
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"CHEF_PROJECT_ID="+migration.ProjectID,
+		"CHEF_ENVIRONMENT="+migration.Environment,
+		"CHEF_FROM_TARGET="+from,
+		"CHEF_TO_TARGET="+to,
+		"CHEF_FROM_URL="+p.targetURL(from, migration.ProjectID),
+		"CHEF_TO_URL="+p.targetURL(to, migration.ProjectID),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("switch command failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// GetMigration returns the latest migration of a project's environment
+func (p *Pipeline) GetMigration(projectID, environment string) (*types.Migration, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	migration, ok := p.migrations[migrationKey{projectID, environment}]
+	if !ok {
+		return nil, ErrMigrationNotFound
+	}
+	snapshot := *migration
+	return &snapshot, nil
+}
+
+// RollbackMigration switches a migrated environment back to its old
+// target, which kept its deployment during the rollback window. Builds
+// deployed since the switch only reached the new target.
+func (p *Pipeline) RollbackMigration(ctx context.Context, projectID, environment string) (*types.Migration, error) {
+	p.migrateMu.Lock()
+	defer p.migrateMu.Unlock()
+
+	p.mu.RLock()
+	migration, ok := p.migrations[migrationKey{projectID, environment}]
+	var current types.Migration
+	if ok {
+		current = *migration
+	}
+	p.mu.RUnlock()
+	if !ok {
+		return nil, ErrMigrationNotFound
+	}
+	if current.Status != types.MigrationSwitched || !time.Now().Before(*current.RollbackUntil) {
+		return nil, fmt.Errorf("%w: migration is %s", ErrNotRollbackable, current.Status)
+	}
+
+	if err := p.switchTraffic(ctx, &current, current.To, current.From); err != nil {
+		return nil, fmt.Errorf("failed to switch traffic back: %w", err)
+	}
+	finished := time.Now()
+	p.updateMigration(migration, func(m *types.Migration) {
+		m.Status = types.MigrationRolledBack
+		m.FinishedAt = &finished
+	})
+	p.trackServing(projectID, environment)
+	p.RecordProjectEvent(projectID, types.EventMigrationRolledBack,
+		fmt.Sprintf("%s moved back to %s", environment, describeTarget(current.From)))
+
+	if err := p.removeDeployment(ctx, current.To, projectID); err != nil {
+		p.logger.Warn("failed to remove the deployment of a rolled back migration",
+			zap.String("project", projectID),
+			zap.Error(err))
+	}
+	return p.GetMigration(projectID, environment)
+}
+
+// CompleteMigrations removes the old target's deployment of migrations
+// past their rollback window
+func (p *Pipeline) CompleteMigrations(ctx context.Context) {
+	p.migrateMu.Lock()
+	defer p.migrateMu.Unlock()
+
+	now := time.Now()
+	p.mu.RLock()
+	var due []*types.Migration
+	for _, migration := range p.migrations {
+		if migration.Status == types.MigrationSwitched && migration.RollbackUntil.Before(now) {
+			due = append(due, migration)
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, migration := range due {
+		if err := p.removeDeployment(ctx, migration.From, migration.ProjectID); err != nil {
+			p.logger.Error("failed to remove the old target of a migration",
+				zap.String("project", migration.ProjectID),
+				zap.String("target", describeTarget(migration.From)),
+				zap.Error(err))
+			continue
+		}
+		finished := time.Now()
+		p.updateMigration(migration, func(m *types.Migration) {
+			m.Status = types.MigrationCompleted
+			m.FinishedAt = &finished
+		})
+		p.RecordProjectEvent(migration.ProjectID, types.EventMigrationCompleted,
+			fmt.Sprintf("removed %s from %s", migration.Environment, describeTarget(migration.From)))
+	}
+}
+
+func (p *Pipeline) sweepMigrations() {
+	defer p.running.Done()
+
+	ticker := time.NewTicker(migrationSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.rootCtx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(p.rootCtx, migrationSweepInterval)
+			p.CompleteMigrations(ctx)
+			cancel()
+		}
+	}
+}
+
+// removeDeployment removes a project from a target. Targets unable to
+// remove deployments keep them.
+func (p *Pipeline) removeDeployment(ctx context.Context, target, projectID string) error {
+	t, ok := p.namedTarget(target)
+	if !ok {
+		return nil
+	}
+	remover, ok := t.deployer.(deployer.Remover)
+	if !ok {
+		return nil
+	}
+	return remover.Remove(ctx, projectID, nil)
+}
+
+// trackDeployments points the monitor at every deployed environment in the
+// store, also those deployed by other instances, and stops monitoring the
+// projects that are no longer deployed
+func (p *Pipeline) trackDeployments(ctx context.Context) {
+	if p.store == nil || p.monitor == nil {
+		return
+	}
+	deployments, err := p.store.ListDeployments(ctx)
+	if err != nil {
+		p.logger.Warn("failed to list the deployments to monitor", zap.Error(err))
+		return
+	}
+
+	deployed := make(map[string]bool)
+	for _, build := range deployments {
+		deployed[build.ProjectID] = true
+		// Tracking again would drop the stats collected so far
+		if _, tracked := p.monitor.Stats(build.ProjectID, build.Environment); !tracked {
+			p.trackServing(build.ProjectID, build.Environment)
+		}
+	}
+	for _, stats := range p.monitor.All() {
+		if !deployed[stats.ProjectID] {
+			p.monitor.Untrack(stats.ProjectID)
+		}
+	}
+}
+
+// trackServing points the monitor at the target serving the environment
+func (p *Pipeline) trackServing(projectID, environment string) {
+	if p.monitor != nil && p.monitor.Enabled() {
+		// Only platforms that run workloads can be restarted
+		d, _ := p.target(projectID, environment)
+		restarter, _ := d.(monitor.Restarter)
+		p.monitor.Track(projectID, environment, p.appURL(environment, projectID), restarter)
+	}
+}
+
+// updateMigration applies change under the lock and persists the result
+func (p *Pipeline) updateMigration(migration *types.Migration, change func(*types.Migration)) {
+	p.mu.Lock()
+	change(migration)
+	p.mu.Unlock()
+	p.saveMigration(migration)
+}
+
+func (p *Pipeline) saveMigration(migration *types.Migration) {
+	if p.store == nil {
+		return
+	}
+
+	p.mu.RLock()
+	snapshot := *migration
+	p.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	if err := p.store.SaveMigration(ctx, &snapshot); err != nil {
+		p.logger.Error("failed to persist migration",
+			zap.String("project", snapshot.ProjectID),
+			zap.String("environment", snapshot.Environment),
+			zap.String("status", string(snapshot.Status)),
+			zap.Error(err))
+	}
+}
+
+// loadMigrations restores the targets projects were migrated to. A
+// migration interrupted by a restart never switched traffic and is
+// recorded as failed.
+func (p *Pipeline) loadMigrations() {
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	migrations, err := p.store.ListMigrations(ctx)
+	if err != nil {
+		p.logger.Error("failed to load migrations", zap.Error(err))
+		return
+	}
+	for i := range migrations {
+		migration := &migrations[i]
+		p.migrations[migrationKey{migration.ProjectID, migration.Environment}] = migration
+		if migration.InProgress() {
+			finished := time.Now()
+			p.updateMigration(migration, func(m *types.Migration) {
+				m.Status = types.MigrationFailed
+				m.Message = "interrupted by a restart"
+				m.FinishedAt = &finished
+			})
+		}
+	}
+}
+
+// describeTarget names a target in messages
+func describeTarget(name string) string {
+	if name == "" {
+		return "the default target"
+	}
+	return name
+}
+
+func (h *Handler) MigrateProject(ctx context.Context, req *pb.MigrateProjectRequest) (*pb.MigrateProjectResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	migration, err := h.pipeline.MigrateProject(ctx, req.ProjectId, req.Environment, req.Target)
+	if err != nil {
+		return nil, h.migrationError(err, "failed to start migration", req.ProjectId)
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "migration.start", req.ProjectId, map[string]interface{}{
+		"environment": req.Environment,
+		"from":        migration.From,
+		"to":          migration.To,
+		"build_id":    migration.BuildID,
+	})
+
+	return &pb.MigrateProjectResponse{Migration: migrationToProto(migration)}, nil
+}
+
+func (h *Handler) GetMigration(ctx context.Context, req *pb.GetMigrationRequest) (*pb.GetMigrationResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	migration, err := h.pipeline.GetMigration(req.ProjectId, req.Environment)
+	if err != nil {
+		return nil, h.migrationError(err, "failed to get migration", req.ProjectId)
+	}
+	return &pb.GetMigrationResponse{Migration: migrationToProto(migration)}, nil
+}
+
+func (h *Handler) RollbackMigration(ctx context.Context, req *pb.RollbackMigrationRequest) (*pb.RollbackMigrationResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	migration, err := h.pipeline.RollbackMigration(ctx, req.ProjectId, req.Environment)
+	if err != nil {
+		return nil, h.migrationError(err, "failed to roll back migration", req.ProjectId)
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "migration.rollback", req.ProjectId, map[string]interface{}{
+		"environment": req.Environment,
+		"from":        migration.To,
+		"to":          migration.From,
+	})
+
+	return &pb.RollbackMigrationResponse{Migration: migrationToProto(migration)}, nil
+}
+
+func (h *Handler) migrationError(err error, message, projectID string) error {
+	switch {
+	case errors.Is(err, ErrInvalidMigration):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrMigrationNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrMigrationInProgress), errors.Is(err, ErrNotRollbackable), errors.Is(err, ErrNothingToMigrate):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	h.log.Error(message,
+		zap.String("project", projectID),
+		zap.Error(err))
+	return status.Error(codes.Internal, message)
+}
+
+func migrationToProto(migration *types.Migration) *pb.Migration {
+	info := &pb.Migration{
+		ProjectId:   migration.ProjectID,
+		Environment: migration.Environment,
+		From:        migration.From,
+		To:          migration.To,
+		BuildId:     migration.BuildID,
+		Status:      string(migration.Status),
+		Message:     migration.Message,
+		StartedAt:   migration.StartedAt.Unix(),
+	}
+	if migration.SwitchedAt != nil {
+		info.SwitchedAt = migration.SwitchedAt.Unix()
+	}
+	if migration.RollbackUntil != nil {
+		info.RollbackUntil = migration.RollbackUntil.Unix()
+	}
+	if migration.FinishedAt != nil {
+		info.FinishedAt = migration.FinishedAt.Unix()
+	}
+	return info
+}
@@ -0,0 +1,209 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/monitor"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// healthTransport answers every request with status and records the URLs
+type healthTransport struct {
+	mu     sync.Mutex
+	status int
+	urls   []string
+}
+
+func (t *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.urls = append(t.urls, req.URL.String())
+	return &http.Response{StatusCode: t.status, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func setupMigrationPipeline(t *testing.T) (*Pipeline, *previewDeployer, *previewDeployer, *healthTransport) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	static := &previewDeployer{}
+	k8s := &previewDeployer{}
+	health := &healthTransport{status: http.StatusOK}
+
+	pipeline.deployer = static
+	pipeline.targets = map[string]deployTarget{"k8s": {deployer: k8s}}
+	pipeline.config.Deploy.IngressDomain = "static.example.com"
+	pipeline.config.Deploy.Targets = map[string]config.DeployConfig{"k8s": {IngressDomain: "k8s.example.com"}}
+	pipeline.migrations = make(map[migrationKey]*types.Migration)
+	pipeline.httpClient = &http.Client{Transport: health}
+	pipeline.store = &recordingStore{eventStatus: make(map[types.DeploymentEventType]types.BuildStatus)}
+	pipeline.storedEvents = make(map[string]int)
+	pipeline.builds["shop-1"] = &types.Build{
+		ID:          "shop-1",
+		ProjectID:   "shop",
+		Environment: "production",
+		Status:      types.BuildStatusSuccess,
+		StartTime:   time.Now(),
+	}
+	return pipeline, static, k8s, health
+}
+
+func waitForMigration(t *testing.T, pipeline *Pipeline, status types.MigrationStatus) *types.Migration {
+	var migration *types.Migration
+	require.Eventually(t, func() bool {
+		var err error
+		migration, err = pipeline.GetMigration("shop", "production")
+		return err == nil && migration.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+	return migration
+}
+
+func TestPipeline_MigrateProject(t *testing.T) {
+	pipeline, static, k8s, health := setupMigrationPipeline(t)
+	switches := filepath.Join(t.TempDir(), "switches")
+	pipeline.config.Migration.HealthPath = "/healthz"
+	pipeline.config.Migration.SwitchCommand = []string{"sh", "-c", `echo "$CHEF_FROM_URL $CHEF_TO_URL" >> ` + switches}
+	ctx := context.Background()
+
+	_, err := pipeline.GetMigration("shop", "production")
+	assert.ErrorIs(t, err, ErrMigrationNotFound)
+
+	migration, err := pipeline.MigrateProject(ctx, "shop", "production", "k8s")
+	require.NoError(t, err)
+	assert.Equal(t, types.MigrationDeploying, migration.Status)
+	assert.Equal(t, "", migration.From)
+	assert.Equal(t, "shop-1", migration.BuildID)
+
+	migration = waitForMigration(t, pipeline, types.MigrationSwitched)
+	assert.WithinDuration(t, time.Now().Add(defaultRollbackWindow), *migration.RollbackUntil, time.Minute)
+	assert.Equal(t, []string{"shop"}, k8s.deployed)
+	assert.Equal(t, []string{"https://shop.k8s.example.com/healthz"}, health.urls)
+
+	// New deploys of the environment go to the new target, others stay
+	target, _ := pipeline.target("shop", "production")
+	assert.Same(t, k8s, target)
+	assert.Equal(t, "https://shop.k8s.example.com", pipeline.appURL("production", "shop"))
+	target, _ = pipeline.target("shop", "staging")
+	assert.Same(t, static, target)
+
+	_, err = pipeline.MigrateProject(ctx, "shop", "production", "")
+	assert.ErrorIs(t, err, ErrMigrationInProgress)
+
+	// The old target kept its deployment, so switching back is immediate
+	migration, err = pipeline.RollbackMigration(ctx, "shop", "production")
+	require.NoError(t, err)
+	assert.Equal(t, types.MigrationRolledBack, migration.Status)
+	assert.Equal(t, []string{"shop"}, k8s.removed)
+	assert.Empty(t, static.removed)
+	target, _ = pipeline.target("shop", "production")
+	assert.Same(t, static, target)
+
+	_, err = pipeline.RollbackMigration(ctx, "shop", "production")
+	assert.ErrorIs(t, err, ErrNotRollbackable)
+
+	output, err := os.ReadFile(switches)
+	require.NoError(t, err)
+	assert.Equal(t, "https://shop.static.example.com https://shop.k8s.example.com\n"+
+		"https://shop.k8s.example.com https://shop.static.example.com\n", string(output))
+
+	// Once the rollback window passes the old target is removed
+	_, err = pipeline.MigrateProject(ctx, "shop", "production", "k8s")
+	require.NoError(t, err)
+	waitForMigration(t, pipeline, types.MigrationSwitched)
+	pipeline.mu.Lock()
+	expired := time.Now().Add(-time.Second)
+	pipeline.migrations[migrationKey{"shop", "production"}].RollbackUntil = &expired
+	pipeline.mu.Unlock()
+
+	pipeline.CompleteMigrations(ctx)
+	migration, err = pipeline.GetMigration("shop", "production")
+	require.NoError(t, err)
+	assert.Equal(t, types.MigrationCompleted, migration.Status)
+	assert.Equal(t, []string{"shop"}, static.removed)
+	target, _ = pipeline.target("shop", "production")
+	assert.Same(t, k8s, target)
+
+	store := pipeline.store.(*recordingStore)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	assert.Equal(t, types.MigrationCompleted, store.migrations[len(store.migrations)-1].Status)
+}
+
+func TestPipeline_MigrateProjectRejected(t *testing.T) {
+	pipeline, _, _, _ := setupMigrationPipeline(t)
+	ctx := context.Background()
+
+	_, err := pipeline.MigrateProject(ctx, "shop", "production", "fly")
+	assert.ErrorIs(t, err, ErrInvalidMigration)
+	_, err = pipeline.MigrateProject(ctx, "shop", "production", "")
+	assert.ErrorIs(t, err, ErrInvalidMigration, "already served by the default target")
+	_, err = pipeline.MigrateProject(ctx, "blog", "production", "k8s")
+	assert.ErrorIs(t, err, ErrNothingToMigrate)
+
+	// Deployments are named after the project, so a target serving another
+	// environment of it cannot take this one
+	pipeline.targets["staging"] = deployTarget{deployer: &previewDeployer{}}
+	pipeline.builds["shop-2"] = &types.Build{ID: "shop-2", ProjectID: "shop", Environment: "staging"}
+	_, err = pipeline.MigrateProject(ctx, "shop", "production", "staging")
+	assert.ErrorIs(t, err, ErrInvalidMigration)
+}
+
+func TestPipeline_MigrateProjectUnhealthy(t *testing.T) {
+	pipeline, static, k8s, health := setupMigrationPipeline(t)
+	health.status = http.StatusServiceUnavailable
+	pipeline.config.Migration.HealthTimeout = 1
+
+	_, err := pipeline.MigrateProject(context.Background(), "shop", "production", "k8s")
+	require.NoError(t, err)
+
+	migration := waitForMigration(t, pipeline, types.MigrationFailed)
+	assert.Contains(t, migration.Message, "did not become healthy")
+	assert.Equal(t, []string{"shop"}, k8s.removed)
+	target, _ := pipeline.target("shop", "production")
+	assert.Same(t, static, target)
+}
+
+func TestPipeline_TrackDeployments(t *testing.T) {
+	pipeline, _, _, _ := setupMigrationPipeline(t)
+	store := &recordingStore{deployments: []types.Build{
+		{ID: "shop-1", ProjectID: "shop", Environment: "production"},
+		{ID: "blog-1", ProjectID: "blog", Environment: "staging"},
+	}}
+	pipeline.store = store
+	pipeline.monitor = monitor.NewMonitor(&config.MonitorConfig{Enabled: true, HealthPath: "/healthz"}, zap.NewNop())
+	pipeline.config.Deploy.Targets["staging"] = config.DeployConfig{IngressDomain: "staging.example.com"}
+	pipeline.targets["staging"] = deployTarget{deployer: &previewDeployer{}}
+	ctx := context.Background()
+
+	// Deployments other instances made are monitored too
+	pipeline.trackDeployments(ctx)
+	stats, ok := pipeline.monitor.Stats("shop", "production")
+	require.True(t, ok)
+	assert.Equal(t, "https://shop.static.example.com/healthz", stats.URL)
+	stats, ok = pipeline.monitor.Stats("blog", "staging")
+	require.True(t, ok)
+	assert.Equal(t, "https://blog.staging.example.com/healthz", stats.URL)
+
+	// Refreshing leaves tracked environments and their stats alone
+	pipeline.monitor.Track("shop", "production", "http://shop.internal", nil)
+	pipeline.trackDeployments(ctx)
+	stats, _ = pipeline.monitor.Stats("shop", "production")
+	assert.Equal(t, "http://shop.internal/healthz", stats.URL)
+
+	// Projects removed elsewhere are no longer monitored
+	store.deployments = store.deployments[:1]
+	pipeline.trackDeployments(ctx)
+	_, ok = pipeline.monitor.Stats("blog", "staging")
+	assert.False(t, ok)
+	assert.Len(t, pipeline.monitor.All(), 1)
+}
@@ -1,44 +1,420 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
 
+	"github.com/elskow/chef-infra/internal/events"
+	"github.com/elskow/chef-infra/internal/httpsec"
+	"github.com/elskow/chef-infra/internal/leader"
+	"github.com/elskow/chef-infra/internal/pipeline/addon"
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/pipeline/autoscale"
 	"github.com/elskow/chef-infra/internal/pipeline/builder"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
 	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/faults"
+	"github.com/elskow/chef-infra/internal/pipeline/imagegc"
+	"github.com/elskow/chef-infra/internal/pipeline/monitor"
+	"github.com/elskow/chef-infra/internal/pipeline/plugin"
+	"github.com/elskow/chef-infra/internal/pipeline/usage"
 	"github.com/elskow/chef-infra/internal/pipeline/validator"
 )
 
 func Module() fx.Option {
 	return fx.Options(
 		fx.Provide(
+			// Faults are only injected in the testing environment, the
+			// injector is nil otherwise
+			fx.Annotate(
+				func(config *config.PipelineConfig) (*faults.Injector, error) {
+					return faults.New(&config.Faults)
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, injector *faults.Injector, logger *zap.Logger) (*agent.Registry, error) {
+					// Uploaded artifacts live beside those of local builds
+					layout, err := builder.NewLayout(config)
+					if err != nil {
+						return nil, err
+					}
+					var artifacts agent.ArtifactStore = agent.NewFileStore(layout)
+					if injector.Enabled() {
+						artifacts = faults.ArtifactStore(artifacts, injector)
+					}
+					return agent.NewRegistry(&config.Agents, artifacts, logger.With(zap.String("component", "agents"))), nil
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, registry *agent.Registry, logger *zap.Logger) *agent.Handler {
+					return agent.NewHandler(registry, config.Agents.Token, logger)
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, registry *agent.Registry, auditor AuditRecorder, logger *zap.Logger) (*autoscale.Autoscaler, error) {
+					var provider autoscale.Provider
+					if config.Agents.Enabled && config.Agents.Autoscale.Enabled {
+						var err error
+						if provider, err = autoscale.NewProvider(&config.Agents.Autoscale, &config.Deploy); err != nil {
+							return nil, err
+						}
+					}
+					return autoscale.NewAutoscaler(&config.Agents.Autoscale, provider, registry, auditor, logger.With(zap.String("component", "autoscaler"))), nil
+				},
+			),
+			// Builds run on agents when enabled, on the local Docker host otherwise
+			fx.Annotate(
+				func(config *config.PipelineConfig, registry *agent.Registry, injector *faults.Injector, logger *zap.Logger) builder.FactoryInterface {
+					var factory builder.FactoryInterface
+					if registry.Enabled() {
+						factory = agent.NewFactory(registry)
+					} else {
+						factory = builder.NewBuilderFactory(config, logger)
+					}
+					if injector.Enabled() {
+						factory = faults.Factory(factory, injector)
+					}
+					return factory
+				},
+			),
 			fx.Annotate(
-				func(config *config.PipelineConfig, logger *zap.Logger) (*builder.Factory, error) {
-					return builder.NewBuilderFactory(config, logger), nil
+				func(config *config.PipelineConfig, injector *faults.Injector, logger *zap.Logger) (*deployer.Targets, error) {
+					targets, err := deployer.NewTargets(&config.Deploy, logger)
+					if err != nil {
+						return nil, err
+					}
+					if injector.Enabled() {
+						targets.WrapK8sClients(func(client deployer.K8sClient) deployer.K8sClient {
+							return faults.K8sClient(client, injector)
+						})
+					}
+					return targets, nil
 				},
 			),
+			// Logs, exec, scaling and restarts act on the default target
 			fx.Annotate(
-				func(config *config.PipelineConfig, logger *zap.Logger) (deployer.Deployer, error) {
-					return deployer.NewDeployer(&config.Deploy, logger)
+				func(targets *deployer.Targets) deployer.Deployer {
+					return targets.Default()
 				},
 			),
 			fx.Annotate(
-				func(config *config.PipelineConfig) validator.Validator {
-					return validator.NewNodeJSValidator(&config.NodeJS)
+				func(config *config.PipelineConfig, logger *zap.Logger) *validator.NodeJSValidator {
+					return validator.NewNodeJSValidator(&config.NodeJS, logger)
+				},
+			),
+			fx.Annotate(
+				func(v *validator.NodeJSValidator) validator.Validator {
+					return v
+				},
+			),
+			fx.Annotate(
+				func(v *validator.NodeJSValidator, store validator.VersionStore) *validator.VersionMatrix {
+					matrix := v.Matrix()
+					matrix.SetStore(store)
+					return matrix
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, logger *zap.Logger) *monitor.Monitor {
+					return monitor.NewMonitor(&config.Monitor, logger)
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, logger *zap.Logger) (*plugin.Runner, error) {
+					return plugin.NewRunner(config.Plugins, logger)
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, store addon.Store, logger *zap.Logger) (*addon.Manager, error) {
+					logger = logger.With(zap.String("component", "addons"))
+					if config.AddOns.Provider == "" {
+						return addon.NewManager(&config.AddOns, "", nil, nil, store, logger), nil
+					}
+					namespace := config.AddOns.Namespace
+					if namespace == "" {
+						namespace = config.Deploy.Namespace
+					}
+					restConfig, err := deployer.LoadKubeConfig()
+					if err != nil {
+						return nil, err
+					}
+					client, err := kubernetes.NewForConfig(restConfig)
+					if err != nil {
+						return nil, fmt.Errorf("failed to create k8s client: %w", err)
+					}
+					provider, err := addon.NewProvider(&config.AddOns, namespace, client)
+					if err != nil {
+						return nil, err
+					}
+					return addon.NewManager(&config.AddOns, namespace, provider, client, store, logger), nil
 				},
 			),
 			fx.Annotate(
 				func(
 					config *config.PipelineConfig,
-					builderFactory *builder.Factory,
-					deployer deployer.Deployer,
+					builderFactory builder.FactoryInterface,
+					targets *deployer.Targets,
 					validator validator.Validator,
+					monitor *monitor.Monitor,
+					plugins *plugin.Runner,
+					addons *addon.Manager,
+					store BuildStore,
+					notifier Notifier,
+					releaser Releaser,
+					lanes LaneStore,
+					injector *faults.Injector,
 					logger *zap.Logger,
 				) *Pipeline {
-					return NewPipeline(config, builderFactory, deployer, validator, logger)
+					if injector.Enabled() && store != nil {
+						store = &faultyStore{BuildStore: store, injector: injector}
+					}
+					return NewPipeline(config, builderFactory, targets, validator, monitor, plugins, addons, store, notifier, releaser, lanes, logger)
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, store usage.Store, p *Pipeline, logger *zap.Logger) *usage.Meter {
+					return usage.NewMeter(&config.Usage, store, p, logger)
+				},
+			),
+			// Provide handler
+			fx.Annotate(
+				func(
+					pipeline *Pipeline,
+					matrix *validator.VersionMatrix,
+					monitor *monitor.Monitor,
+					meter *usage.Meter,
+					deployer deployer.Deployer,
+					authorizer ProjectAuthorizer,
+					auditor AuditRecorder,
+					logger *zap.Logger,
+				) *Handler {
+					return NewHandler(pipeline, matrix, monitor, meter, deployer, authorizer, auditor, logger)
+				},
+			),
+			fx.Annotate(NewPublicHandler),
+			fx.Annotate(
+				func(pipeline *Pipeline, authorizer StatusPageAuthorizer, logger *zap.Logger) *StatusPage {
+					return NewStatusPage(pipeline, authorizer, logger)
+				},
+			),
+			fx.Annotate(
+				func(config *config.PipelineConfig, pipeline *Pipeline, authorizer StatusPageAuthorizer, logger *zap.Logger) *Badges {
+					return NewBadges(&config.Badges, pipeline, authorizer, logger)
 				},
 			),
 		),
+		fx.Invoke(registerVersionMatrixHooks),
+		fx.Invoke(registerPipelineHooks),
+		fx.Invoke(registerAgentHooks),
+		fx.Invoke(registerMonitorHooks),
+		fx.Invoke(registerImageGCHooks),
+		fx.Invoke(registerUsageHooks),
+		fx.Invoke(registerMetricsConsumer),
 	)
 }
+
+// registerPipelineHooks moves artifacts to artifacts_dir and recovers the
+// builds a crashed server left unfinished before new builds start, and
+// cancels running builds on stop
+func registerPipelineHooks(lifecycle fx.Lifecycle, p *Pipeline, logger *zap.Logger) {
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if moved, err := p.MigrateArtifacts(ctx); err != nil {
+				logger.Error("failed to migrate artifacts", zap.Error(err))
+			} else if moved > 0 {
+				logger.Info("Moved artifacts to artifacts_dir", zap.Int("artifacts", moved))
+			}
+			interrupted, err := p.RecoverBuilds(ctx)
+			if err != nil {
+				logger.Error("failed to recover interrupted builds", zap.Error(err))
+			} else if len(interrupted) > 0 {
+				logger.Warn("Interrupted builds left unfinished by a stopped server", zap.Int("builds", len(interrupted)))
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			logger.Info("Cancelling running builds")
+			return p.Shutdown(ctx)
+		},
+	})
+}
+
+// registerVersionMatrixHooks loads the stored node version matrix before
+// builds start and keeps reloading it
+func registerVersionMatrixHooks(lifecycle fx.Lifecycle, matrix *validator.VersionMatrix) {
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := matrix.Load(ctx); err != nil {
+				return err
+			}
+			matrix.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			matrix.Stop()
+			return nil
+		},
+	})
+}
+
+// registerAgentHooks expires agents that stop sending heartbeats and
+// scales the agent pool. Agents connect to one instance, so every instance
+// scales for its own builds.
+func registerAgentHooks(lifecycle fx.Lifecycle, registry *agent.Registry, scaler *autoscale.Autoscaler) {
+	if !registry.Enabled() {
+		return
+	}
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			registry.Start()
+			if scaler.Enabled() {
+				scaler.Start()
+			}
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			scaler.Stop()
+			registry.Stop()
+			return nil
+		},
+	})
+}
+
+// registerMonitorHooks runs health checks on every instance, against the
+// deployments in the store so each one reports the uptime of all of them.
+// Only the leader notifies and restarts, so sustained failures are acted
+// on once. Every instance serves its metrics, also without the monitor
+// since they drive the autoscaling of replicas, and the project status
+// pages and badges when enabled.
+func registerMonitorHooks(
+	lifecycle fx.Lifecycle,
+	config *config.PipelineConfig,
+	m *monitor.Monitor,
+	p *Pipeline,
+	builderFactory builder.FactoryInterface,
+	scaler *autoscale.Autoscaler,
+	elector *leader.Elector,
+	statusPage *StatusPage,
+	badges *Badges,
+	secure httpsec.Middleware,
+	logger *zap.Logger,
+) {
+	if config.Monitor.Enabled {
+		m.SetRefresh(p.trackDeployments)
+		m.SetActing(elector.IsLeader)
+		lifecycle.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				m.Start()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				m.Stop()
+				return nil
+			},
+		})
+	}
+
+	if config.Monitor.MetricsAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m.ServeHTTP(w, r)
+		p.Metrics().WriteMetrics(w)
+		p.WriteConcurrency(w)
+		if docker, ok := builderFactory.(*builder.Factory); ok {
+			docker.WriteMetrics(w)
+		}
+		if scaler.Enabled() {
+			scaler.WriteMetrics(w)
+		}
+	})
+	if config.StatusPage.Enabled {
+		mux.Handle("/status/", statusPage)
+	}
+	if config.Badges.Enabled {
+		mux.Handle("/badge/", badges)
+	}
+	metricsServer := &http.Server{Addr: config.Monitor.MetricsAddr, Handler: secure(mux)}
+
+	lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go func() {
+				logger.Info("Starting metrics server", zap.String("address", metricsServer.Addr))
+				if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Error("failed to start metrics server", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return metricsServer.Shutdown(ctx)
+		},
+	})
+}
+
+// registerImageGCHooks collects images on every instance's Docker host, and
+// in the shared registry from the leader only
+func registerImageGCHooks(
+	lifecycle fx.Lifecycle,
+	config *config.PipelineConfig,
+	p *Pipeline,
+	elector *leader.Elector,
+	logger *zap.Logger,
+) error {
+	var collectors []*imagegc.Collector
+	if config.ImageGC.Enabled {
+		images, err := imagegc.NewDockerSource()
+		if err != nil {
+			return err
+		}
+		collectors = append(collectors, imagegc.NewCollector(&config.ImageGC, images, p, logger.With(zap.String("target", "docker"))))
+	}
+	if config.ImageGC.Registry.Enabled {
+		registry := config.NodeJS.Registry
+		if registry == "" {
+			registry = config.Deploy.Registry
+		}
+		images, err := imagegc.NewRegistrySource(&config.ImageGC.Registry, registry)
+		if err != nil {
+			return err
+		}
+		elector.Register("registry-image-gc", imagegc.NewCollector(&config.ImageGC, images, p, logger.With(zap.String("target", "registry"))))
+	}
+
+	for _, collector := range collectors {
+		collector := collector
+		lifecycle.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				collector.Start()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				collector.Stop()
+				return nil
+			},
+		})
+	}
+	return nil
+}
+
+// registerMetricsConsumer counts the builds of this instance from the
+// events it publishes
+func registerMetricsConsumer(bus events.Bus, p *Pipeline) error {
+	return bus.SubscribeLocal("build-metrics", p.Metrics().Handle)
+}
+
+// registerUsageHooks meters on the leader only so usage is not counted
+// once per replica
+func registerUsageHooks(elector *leader.Elector, meter *usage.Meter) {
+	if !meter.Enabled() {
+		return
+	}
+	elector.Register("usage-meter", meter)
+}
@@ -0,0 +1,372 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const (
+	defaultInterval         = 30 * time.Second
+	defaultTimeout          = 5 * time.Second
+	defaultFailureThreshold = 3
+)
+
+// Restarter restarts a project's running workload
+type Restarter interface {
+	Restart(ctx context.Context, projectID string) error
+}
+
+// Stats is the uptime record of a deployed environment of a project
+type Stats struct {
+	ProjectID           string
+	Environment         string
+	URL                 string
+	Up                  bool
+	Checks              int64
+	Failures            int64
+	ConsecutiveFailures int
+	LastLatency         time.Duration
+	TotalLatency        time.Duration
+	LastStatusCode      int
+	LastError           string
+	LastCheck           time.Time
+}
+
+// Availability is the fraction of successful checks
+func (s Stats) Availability() float64 {
+	if s.Checks == 0 {
+		return 0
+	}
+	return float64(s.Checks-s.Failures) / float64(s.Checks)
+}
+
+// AverageLatency is the mean latency over all checks
+func (s Stats) AverageLatency() time.Duration {
+	if s.Checks == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Checks)
+}
+
+// targetKey identifies a monitored environment of a project
+type targetKey struct {
+	projectID   string
+	environment string
+}
+
+// target is a monitored environment and the restarter of the deploy
+// target serving it
+type target struct {
+	stats     Stats
+	restarter Restarter
+}
+
+// Monitor periodically requests the health URL of each deployed app
+type Monitor struct {
+	config     *config.MonitorConfig
+	httpClient *http.Client
+	logger     *zap.Logger
+	targets    map[targetKey]*target
+	refresh    func(ctx context.Context) // Updates the targets before each round, may be nil
+	acting     func() bool               // Whether failures are acted on, always when nil
+	mu         sync.RWMutex
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+func NewMonitor(cfg *config.MonitorConfig, logger *zap.Logger) *Monitor {
+	timeout := defaultTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+
+	return &Monitor{
+		config:     cfg,
+		httpClient: &http.Client{Timeout: timeout},
+		logger:     logger,
+		targets:    make(map[targetKey]*target),
+	}
+}
+
+// Enabled reports whether uptime checks are configured to run
+func (m *Monitor) Enabled() bool {
+	return m.config.Enabled
+}
+
+// SetRefresh makes each round of checks start with refresh, which updates
+// the targets, e.g. with deployments other instances made. It must be set
+// before Start.
+func (m *Monitor) SetRefresh(refresh func(ctx context.Context)) {
+	m.refresh = refresh
+}
+
+// SetActing limits notifications and restarts to when acting reports true,
+// so instances checking the same apps act on a failure once. It must be
+// set before Start.
+func (m *Monitor) SetActing(acting func() bool) {
+	m.acting = acting
+}
+
+// Track starts monitoring an environment of a project at the given base
+// URL, replacing any previous target for it. restarter restarts the
+// workload serving the environment; it may be nil when its platform
+// cannot restart workloads.
+func (m *Monitor) Track(projectID, environment, baseURL string, restarter Restarter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.targets[targetKey{projectID, environment}] = &target{
+		stats: Stats{
+			ProjectID:   projectID,
+			Environment: environment,
+			URL:         baseURL + m.config.HealthPath,
+		},
+		restarter: restarter,
+	}
+}
+
+// Untrack stops monitoring every environment of a project
+func (m *Monitor) Untrack(projectID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.targets {
+		if key.projectID == projectID {
+			delete(m.targets, key)
+		}
+	}
+}
+
+// Stats returns the uptime record for an environment of a project
+func (m *Monitor) Stats(projectID, environment string) (Stats, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	target, ok := m.targets[targetKey{projectID, environment}]
+	if !ok {
+		return Stats{}, false
+	}
+	return target.stats, true
+}
+
+// All returns uptime records for every monitored environment ordered by
+// project and environment
+func (m *Monitor) All() []Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	all := make([]Stats, 0, len(m.targets))
+	for _, target := range m.targets {
+		all = append(all, target.stats)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].ProjectID != all[j].ProjectID {
+			return all[i].ProjectID < all[j].ProjectID
+		}
+		return all[i].Environment < all[j].Environment
+	})
+	return all
+}
+
+func (m *Monitor) Start() {
+	interval := defaultInterval
+	if m.config.Interval > 0 {
+		interval = time.Duration(m.config.Interval) * time.Second
+	}
+
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		ctx := context.Background()
+		if m.refresh != nil {
+			m.refresh(ctx)
+		}
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				if m.refresh != nil {
+					m.refresh(ctx)
+				}
+				m.CheckAll(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+}
+
+// CheckAll runs one round of health checks against every target
+func (m *Monitor) CheckAll(ctx context.Context) {
+	m.mu.RLock()
+	targets := make([]targetKey, 0, len(m.targets))
+	for key := range m.targets {
+		targets = append(targets, key)
+	}
+	m.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, key := range targets {
+		wg.Add(1)
+		go func(key targetKey) {
+			defer wg.Done()
+			m.check(ctx, key)
+		}(key)
+	}
+	wg.Wait()
+}
+
+func (m *Monitor) check(ctx context.Context, key targetKey) {
+	m.mu.RLock()
+	current, ok := m.targets[key]
+	var url string
+	if ok {
+		url = current.stats.URL
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	statusCode, err := m.probe(ctx, url)
+	latency := time.Since(start)
+
+	m.mu.Lock()
+	current, ok = m.targets[key]
+	if !ok || current.stats.URL != url {
+		// Redeployed or removed while the check was in flight
+		m.mu.Unlock()
+		return
+	}
+
+	stats := &current.stats
+	stats.Checks++
+	stats.LastCheck = start
+	stats.LastLatency = latency
+	stats.TotalLatency += latency
+	stats.LastStatusCode = statusCode
+	stats.Up = err == nil
+	if err != nil {
+		stats.Failures++
+		stats.ConsecutiveFailures++
+		stats.LastError = err.Error()
+	} else {
+		stats.ConsecutiveFailures = 0
+		stats.LastError = ""
+	}
+	snapshot := *stats
+	restarter := current.restarter
+	m.mu.Unlock()
+
+	threshold := m.config.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	// Act once when the failure streak crosses the threshold
+	if snapshot.ConsecutiveFailures == threshold {
+		m.handleSustainedFailure(ctx, snapshot, restarter)
+	}
+}
+
+func (m *Monitor) probe(ctx context.Context, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, fmt.Errorf("unhealthy status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (m *Monitor) handleSustainedFailure(ctx context.Context, stats Stats, restarter Restarter) {
+	m.logger.Warn("app failing health checks",
+		zap.String("project", stats.ProjectID),
+		zap.String("environment", stats.Environment),
+		zap.String("url", stats.URL),
+		zap.Int("consecutive_failures", stats.ConsecutiveFailures),
+		zap.String("error", stats.LastError))
+
+	if m.acting != nil && !m.acting() {
+		return
+	}
+	if m.config.NotifyURL != "" {
+		if err := m.notify(ctx, stats); err != nil {
+			m.logger.Error("failed to send uptime notification",
+				zap.String("project", stats.ProjectID),
+				zap.Error(err))
+		}
+	}
+
+	if m.config.AutoRestart && restarter != nil {
+		if err := restarter.Restart(ctx, stats.ProjectID); err != nil {
+			m.logger.Error("automatic restart failed",
+				zap.String("project", stats.ProjectID),
+				zap.Error(err))
+			return
+		}
+		m.logger.Info("restarted unhealthy app", zap.String("project", stats.ProjectID))
+	}
+}
+
+func (m *Monitor) notify(ctx context.Context, stats Stats) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"project_id":           stats.ProjectID,
+		"environment":          stats.Environment,
+		"url":                  stats.URL,
+		"consecutive_failures": stats.ConsecutiveFailures,
+		"error":                stats.LastError,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.config.NotifyURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
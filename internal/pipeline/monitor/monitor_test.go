@@ -0,0 +1,177 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+type fakeRestarter struct {
+	restarts []string
+}
+
+func (r *fakeRestarter) Restart(_ context.Context, projectID string) error {
+	r.restarts = append(r.restarts, projectID)
+	return nil
+}
+
+func TestMonitor(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer app.Close()
+
+	var notifications atomic.Int32
+	notifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notifications.Add(1)
+	}))
+	defer notifier.Close()
+
+	restarter := &fakeRestarter{}
+	m := NewMonitor(&config.MonitorConfig{
+		Enabled:          true,
+		HealthPath:       "/healthz",
+		FailureThreshold: 2,
+		NotifyURL:        notifier.URL,
+		AutoRestart:      true,
+	}, zap.NewNop())
+
+	m.Track("shop", "production", app.URL, restarter)
+	ctx := context.Background()
+
+	m.CheckAll(ctx)
+	stats, ok := m.Stats("shop", "production")
+	require.True(t, ok)
+	assert.True(t, stats.Up)
+	assert.Equal(t, int64(1), stats.Checks)
+	assert.Equal(t, http.StatusOK, stats.LastStatusCode)
+	assert.Equal(t, app.URL+"/healthz", stats.URL)
+
+	// Failures below the threshold do not trigger any action
+	healthy.Store(false)
+	m.CheckAll(ctx)
+	assert.Empty(t, restarter.restarts)
+	assert.Equal(t, int32(0), notifications.Load())
+
+	// Crossing the threshold notifies and restarts exactly once
+	m.CheckAll(ctx)
+	m.CheckAll(ctx)
+	assert.Equal(t, []string{"shop"}, restarter.restarts)
+	assert.Equal(t, int32(1), notifications.Load())
+
+	stats, _ = m.Stats("shop", "production")
+	assert.False(t, stats.Up)
+	assert.Equal(t, int64(4), stats.Checks)
+	assert.Equal(t, int64(3), stats.Failures)
+	assert.Equal(t, 3, stats.ConsecutiveFailures)
+	assert.InDelta(t, 0.25, stats.Availability(), 0.001)
+
+	// Recovery resets the failure streak
+	healthy.Store(true)
+	m.CheckAll(ctx)
+	stats, _ = m.Stats("shop", "production")
+	assert.True(t, stats.Up)
+	assert.Equal(t, 0, stats.ConsecutiveFailures)
+
+	m.Untrack("shop")
+	_, ok = m.Stats("shop", "production")
+	assert.False(t, ok)
+}
+
+func TestMonitor_Environments(t *testing.T) {
+	production := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer production.Close()
+	staging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer staging.Close()
+
+	productionRestarter, stagingRestarter := &fakeRestarter{}, &fakeRestarter{}
+	m := NewMonitor(&config.MonitorConfig{Enabled: true, FailureThreshold: 1, AutoRestart: true}, zap.NewNop())
+	m.Track("shop", "production", production.URL, productionRestarter)
+	m.Track("shop", "staging", staging.URL, stagingRestarter)
+	m.CheckAll(context.Background())
+
+	// Deploying one environment does not replace the other's record
+	stats, ok := m.Stats("shop", "production")
+	require.True(t, ok)
+	assert.True(t, stats.Up)
+	stats, ok = m.Stats("shop", "staging")
+	require.True(t, ok)
+	assert.False(t, stats.Up)
+	assert.Len(t, m.All(), 2)
+
+	// Only the failing environment's target is restarted
+	assert.Empty(t, productionRestarter.restarts)
+	assert.Equal(t, []string{"shop"}, stagingRestarter.restarts)
+
+	m.Untrack("shop")
+	assert.Empty(t, m.All())
+}
+
+func TestMonitor_Acting(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer app.Close()
+
+	var leading atomic.Bool
+	restarter := &fakeRestarter{}
+	m := NewMonitor(&config.MonitorConfig{Enabled: true, Interval: 1, FailureThreshold: 1, AutoRestart: true}, zap.NewNop())
+	m.SetActing(leading.Load)
+	var refreshes atomic.Int32
+	m.SetRefresh(func(context.Context) {
+		if refreshes.Add(1) == 1 {
+			m.Track("shop", "production", app.URL, restarter)
+		}
+	})
+
+	// The targets are refreshed as soon as the checks start
+	m.Start()
+	require.Eventually(t, func() bool {
+		_, ok := m.Stats("shop", "production")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	m.Stop()
+
+	// Instances that do not act still keep the stats
+	m.CheckAll(context.Background())
+	stats, _ := m.Stats("shop", "production")
+	assert.False(t, stats.Up)
+	assert.Equal(t, 1, stats.ConsecutiveFailures)
+	assert.Empty(t, restarter.restarts)
+
+	leading.Store(true)
+	m.Track("shop", "production", app.URL, restarter)
+	m.CheckAll(context.Background())
+	assert.Equal(t, []string{"shop"}, restarter.restarts)
+}
+
+func TestWriteMetrics(t *testing.T) {
+	m := NewMonitor(&config.MonitorConfig{Enabled: true}, zap.NewNop())
+	m.Track("shop", "production", "http://127.0.0.1:0", nil)
+	m.CheckAll(context.Background())
+
+	var buf bytes.Buffer
+	m.WriteMetrics(&buf)
+
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE chef_app_up gauge")
+	assert.Contains(t, out, `chef_app_up{project="shop",environment="production"} 0`)
+	assert.Contains(t, out, `chef_app_checks_total{project="shop",environment="production"} 1`)
+	assert.Contains(t, out, `chef_app_check_failures_total{project="shop",environment="production"} 1`)
+}
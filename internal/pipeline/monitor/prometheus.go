@@ -0,0 +1,55 @@
+package monitor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ServeHTTP exposes uptime metrics in the Prometheus text exposition format
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteMetrics(w)
+}
+
+// WriteMetrics writes the current uptime metrics to w
+func (m *Monitor) WriteMetrics(w io.Writer) {
+	all := m.All()
+
+	gauge := func(name, help string, value func(Stats) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, stats := range all {
+			fmt.Fprintf(w, "%s{project=\"%s\",environment=\"%s\"} %g\n", name, escapeLabel(stats.ProjectID), escapeLabel(stats.Environment), value(stats))
+		}
+	}
+	counter := func(name, help string, value func(Stats) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, stats := range all {
+			fmt.Fprintf(w, "%s{project=\"%s\",environment=\"%s\"} %g\n", name, escapeLabel(stats.ProjectID), escapeLabel(stats.Environment), value(stats))
+		}
+	}
+
+	gauge("chef_app_up", "Whether the last health check succeeded.", func(s Stats) float64 {
+		if s.Up {
+			return 1
+		}
+		return 0
+	})
+	gauge("chef_app_availability_ratio", "Fraction of successful health checks.", Stats.Availability)
+	gauge("chef_app_check_latency_seconds", "Latency of the last health check.", func(s Stats) float64 {
+		return s.LastLatency.Seconds()
+	})
+	counter("chef_app_checks_total", "Health checks performed.", func(s Stats) float64 {
+		return float64(s.Checks)
+	})
+	counter("chef_app_check_failures_total", "Failed health checks.", func(s Stats) float64 {
+		return float64(s.Failures)
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// Notifier receives build and deploy lifecycle events. Notify must not
+// block; the build is a snapshot the notifier may keep.
+type Notifier interface {
+	Notify(event types.LifecycleEvent, build *types.Build, message string)
+}
+
+// deploymentLifecycle maps deployment events that are reported to notifiers
+var deploymentLifecycle = map[types.DeploymentEventType]types.LifecycleEvent{
+	types.EventRestarted:  types.LifecycleDeployRestarted,
+	types.EventScaled:     types.LifecycleDeployScaled,
+	types.EventRolledBack: types.LifecycleDeployRolledBack,
+}
+
+func (p *Pipeline) notify(event types.LifecycleEvent, build *types.Build, message string) {
+	if p.notifier == nil {
+		return
+	}
+
+	p.mu.RLock()
+	snapshot := *build
+	snapshot.Events = append([]types.DeploymentEvent(nil), build.Events...)
+	snapshot.CancelFunc = nil
+	p.mu.RUnlock()
+
+	p.notifier.Notify(event, &snapshot, message)
+}
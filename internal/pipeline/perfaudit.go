@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// auditDeployment runs a performance audit of the deployed build in the
+// background and notifies when scores regressed since the previous audit of
+// the same environment. Audits never fail a deployment.
+func (p *Pipeline) auditDeployment(build *types.Build) {
+	if p.perfAudit == nil || !p.perfAudit.Applies(build) {
+		return
+	}
+
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+
+		ctx, cancel := context.WithTimeout(p.rootCtx, p.perfAudit.Timeout())
+		defer cancel()
+
+		previous, err := p.previousPerfAudit(ctx, build)
+		if err != nil {
+			p.logger.Warn("failed to load previous performance audit",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+		}
+
+		audit, err := p.perfAudit.Audit(ctx, p.appURL(build.Environment, build.ProjectID))
+		if err != nil {
+			p.logger.Warn("performance audit failed",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+			return
+		}
+		audit.Regressions = p.perfAudit.Regressions(previous, audit)
+
+		p.updateBuild(build, func(b *types.Build) {
+			b.PerfAudit = audit
+			b.AddEvent(types.EventPerfAudited, "", strings.Join(audit.Regressions, ", "))
+		})
+		p.persist(build)
+
+		if len(audit.Regressions) > 0 {
+			p.notify(types.LifecycleAuditRegressed, build,
+				"performance audit regressed: "+strings.Join(audit.Regressions, ", "))
+		}
+	}()
+}
+
+// previousPerfAudit returns the latest audit of the project's environment
+// before build, or nil when it was never audited
+func (p *Pipeline) previousPerfAudit(ctx context.Context, build *types.Build) (*types.PerfAudit, error) {
+	if p.store != nil {
+		return p.store.LatestPerfAudit(ctx, build.ProjectID, build.Environment)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var latest *types.Build
+	for _, b := range p.builds {
+		if b == build || b.ProjectID != build.ProjectID || b.Environment != build.Environment || b.PerfAudit == nil {
+			continue
+		}
+		if latest == nil || b.StartTime.After(latest.StartTime) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return latest.PerfAudit, nil
+}
@@ -0,0 +1,176 @@
+// Package perfaudit runs Lighthouse against deployed apps and detects
+// score regressions between deployments.
+package perfaudit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultImage     = "femtopixel/google-lighthouse"
+	defaultThreshold = 10
+	defaultTimeout   = 120 * time.Second
+)
+
+var defaultCategories = []string{"performance", "accessibility", "best-practices", "seo"}
+
+// metrics are the audits kept with the scores. Timings are in
+// milliseconds, cumulative-layout-shift is unitless.
+var metrics = []string{
+	"first-contentful-paint",
+	"largest-contentful-paint",
+	"total-blocking-time",
+	"speed-index",
+	"cumulative-layout-shift",
+}
+
+// Auditor runs Lighthouse in a throwaway container through the docker CLI
+type Auditor struct {
+	config     *config.PerfAuditConfig
+	dockerPath string
+	log        *zap.Logger
+}
+
+func NewAuditor(cfg *config.PerfAuditConfig, log *zap.Logger) *Auditor {
+	return &Auditor{config: cfg, dockerPath: "docker", log: log}
+}
+
+// Applies reports whether deployments of build are audited
+func (a *Auditor) Applies(build *types.Build) bool {
+	if !a.config.Enabled {
+		return false
+	}
+	if len(a.config.Environments) == 0 {
+		return true
+	}
+	for _, env := range a.config.Environments {
+		if env == build.Environment {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeout bounds one audit
+func (a *Auditor) Timeout() time.Duration {
+	if a.config.Timeout > 0 {
+		return time.Duration(a.config.Timeout) * time.Second
+	}
+	return defaultTimeout
+}
+
+// Audit runs Lighthouse against url
+func (a *Auditor) Audit(ctx context.Context, url string) (*types.PerfAudit, error) {
+	image := a.config.Image
+	if image == "" {
+		image = defaultImage
+	}
+	categories := a.config.Categories
+	if len(categories) == 0 {
+		categories = defaultCategories
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.dockerPath, "run", "--rm", "--entrypoint", "lighthouse", image,
+		url,
+		"--output=json",
+		"--output-path=stdout",
+		"--quiet",
+		"--chrome-flags=--headless --no-sandbox",
+		"--only-categories="+strings.Join(categories, ","),
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("docker is not installed on the server")
+		}
+		return nil, fmt.Errorf("lighthouse failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	audit, err := ParseReport(stdout.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	audit.URL = url
+	return audit, nil
+}
+
+// ParseReport reads the category scores and metrics of a Lighthouse JSON
+// report
+func ParseReport(data []byte) (*types.PerfAudit, error) {
+	var report struct {
+		RuntimeError *struct {
+			Message string `json:"message"`
+		} `json:"runtimeError"`
+		Categories map[string]struct {
+			Score *float64 `json:"score"`
+		} `json:"categories"`
+		Audits map[string]struct {
+			NumericValue *float64 `json:"numericValue"`
+		} `json:"audits"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode lighthouse report: %w", err)
+	}
+	if report.RuntimeError != nil && report.RuntimeError.Message != "" {
+		return nil, fmt.Errorf("lighthouse could not audit the page: %s", report.RuntimeError.Message)
+	}
+	if len(report.Categories) == 0 {
+		return nil, fmt.Errorf("lighthouse report has no categories")
+	}
+
+	audit := &types.PerfAudit{
+		Scores:    make(map[string]int, len(report.Categories)),
+		Metrics:   make(map[string]float64, len(metrics)),
+		AuditedAt: time.Now(),
+	}
+	for name, category := range report.Categories {
+		// A null score means the category could not be computed
+		if category.Score != nil {
+			audit.Scores[name] = int(math.Round(*category.Score * 100))
+		}
+	}
+	for _, name := range metrics {
+		if value := report.Audits[name].NumericValue; value != nil {
+			audit.Metrics[name] = *value
+		}
+	}
+	return audit, nil
+}
+
+// Regressions lists the categories whose score dropped from previous to
+// current by more than the threshold, e.g. "performance 92 -> 71"
+func (a *Auditor) Regressions(previous, current *types.PerfAudit) []string {
+	if previous == nil {
+		return nil
+	}
+	threshold := a.config.Threshold
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+
+	var regressions []string
+	for category, score := range current.Scores {
+		before, ok := previous.Scores[category]
+		if ok && before-score > threshold {
+			regressions = append(regressions, fmt.Sprintf("%s %d -> %d", category, before, score))
+		}
+	}
+	sort.Strings(regressions)
+	return regressions
+}
@@ -0,0 +1,72 @@
+package perfaudit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const report = `{
+  "categories": {
+    "performance": {"score": 0.914},
+    "accessibility": {"score": 1},
+    "pwa": {"score": null}
+  },
+  "audits": {
+    "largest-contentful-paint": {"numericValue": 1834.5},
+    "cumulative-layout-shift": {"numericValue": 0.02},
+    "uses-http2": {"numericValue": 3}
+  }
+}`
+
+func TestParseReport(t *testing.T) {
+	audit, err := ParseReport([]byte(report))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"performance": 91, "accessibility": 100}, audit.Scores)
+	assert.Equal(t, map[string]float64{"largest-contentful-paint": 1834.5, "cumulative-layout-shift": 0.02}, audit.Metrics)
+
+	_, err = ParseReport([]byte(`{"runtimeError": {"code": "ERRORED_DOCUMENT_REQUEST", "message": "status code 502"}}`))
+	assert.EqualError(t, err, "lighthouse could not audit the page: status code 502")
+}
+
+func TestAuditor_Audit(t *testing.T) {
+	dir := t.TempDir()
+	docker := filepath.Join(dir, "docker")
+	// Echoes the report when called with the expected arguments
+	script := "#!/bin/sh\n" +
+		`[ "$5" = "lighthouse/ci" ] && [ "$6" = "https://shop.example.com" ] && [ "${11}" = "--only-categories=performance" ] || exit 1` + "\n" +
+		"cat <<'EOF'\n" + report + "\nEOF\n"
+	require.NoError(t, os.WriteFile(docker, []byte(script), 0755))
+
+	a := NewAuditor(&config.PerfAuditConfig{Enabled: true, Image: "lighthouse/ci", Categories: []string{"performance"}}, zap.NewNop())
+	a.dockerPath = docker
+
+	audit, err := a.Audit(context.Background(), "https://shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "https://shop.example.com", audit.URL)
+	assert.Equal(t, 91, audit.Scores["performance"])
+}
+
+func TestAuditor_Regressions(t *testing.T) {
+	a := NewAuditor(&config.PerfAuditConfig{Threshold: 5}, zap.NewNop())
+	previous := &types.PerfAudit{Scores: map[string]int{"performance": 92, "seo": 100, "accessibility": 90}}
+	current := &types.PerfAudit{Scores: map[string]int{"performance": 71, "seo": 96, "accessibility": 80, "pwa": 10}}
+
+	assert.Equal(t, []string{"accessibility 90 -> 80", "performance 92 -> 71"}, a.Regressions(previous, current))
+	assert.Nil(t, a.Regressions(nil, current))
+}
+
+func TestAuditor_Applies(t *testing.T) {
+	a := NewAuditor(&config.PerfAuditConfig{Enabled: true, Environments: []string{"production"}}, zap.NewNop())
+	assert.True(t, a.Applies(&types.Build{Environment: "production"}))
+	assert.False(t, a.Applies(&types.Build{Environment: "staging"}))
+	assert.False(t, NewAuditor(&config.PerfAuditConfig{}, zap.NewNop()).Applies(&types.Build{}))
+}
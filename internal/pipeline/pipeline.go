@@ -2,14 +2,30 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/elskow/chef-infra/internal/events"
+	"github.com/elskow/chef-infra/internal/pipeline/addon"
 	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
 	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/diagnose"
+	"github.com/elskow/chef-infra/internal/pipeline/envtemplate"
+	"github.com/elskow/chef-infra/internal/pipeline/integrity"
+	"github.com/elskow/chef-infra/internal/pipeline/monitor"
+	"github.com/elskow/chef-infra/internal/pipeline/perfaudit"
+	"github.com/elskow/chef-infra/internal/pipeline/plugin"
+	"github.com/elskow/chef-infra/internal/pipeline/policy"
+	"github.com/elskow/chef-infra/internal/pipeline/provenance"
+	"github.com/elskow/chef-infra/internal/pipeline/sourcemap"
 	"github.com/elskow/chef-infra/internal/pipeline/types"
 	"github.com/elskow/chef-infra/internal/pipeline/validator"
 	"go.uber.org/zap"
@@ -18,58 +34,308 @@ import (
 type Pipeline struct {
 	config         *config.PipelineConfig
 	builderFactory builder.FactoryInterface
-	deployer       deployer.Deployer
+	deployer       deployer.Deployer // Default target
+	hooks          *deployer.HookRunner
+	targets        map[string]deployTarget // Environments deployed elsewhere
+	attestor       *provenance.Attestor
+	policy         *policy.Engine
+	perfAudit      *perfaudit.Auditor
+	integrity      *integrity.Verifier
+	sourceMaps     *sourcemap.Uploader
+	plugins        *plugin.Runner // Optional
+	addons         *addon.Manager // Optional
+	debugShell     DebugShell     // Set when failed builds are kept for debugging
 	validator      validator.Validator
+	monitor        *monitor.Monitor
 	logger         *zap.Logger
 	builds         map[string]*types.Build
 	metrics        *MetricsCollector
 	mu             sync.RWMutex
+
+	// store is optional; without it builds only live in memory
+	store        BuildStore
+	storedEvents map[string]int // Events already persisted per build
+	persistMu    sync.Mutex
+
+	// notifier is optional and receives lifecycle events, e.g. webhooks
+	notifier Notifier
+	// releaser is optional and tags deploys at the git provider
+	releaser Releaser
+
+	// lanes serialize builds of the same project branch, guarded by mu;
+	// laneStore is optional and shares them with the other instances
+	lanes     map[laneKey]*lane
+	laneStore LaneStore
+	// active holds the builds occupying a build slot, true once preempted;
+	// waiting those waiting for one, highest priority first. Both guarded
+	// by mu.
+	active  map[*types.Build]bool
+	waiting []*types.Build
+	// deploying counts the deploys in progress
+	deploying atomic.Int32
+
+	// migrations holds the latest migration of each project environment,
+	// guarded by mu. migrateMu serializes rollbacks with the removal of
+	// old targets.
+	migrations map[migrationKey]*types.Migration
+	migrateMu  sync.Mutex
+	httpClient *http.Client // Health checks of migration targets
+
+	// deployLocks holds the deploy locks when there is no store, and
+	// deployQueue the builds waiting for one, first in line first. Both
+	// guarded by mu.
+	deployLocks map[migrationKey]*types.DeployLock
+	deployQueue map[migrationKey][]*types.Build
+	instance    string // Owner of the deploy locks taken by this process
+
+	// Builds run under a server-owned context so a client disconnect cannot
+	// cancel them; only Shutdown or CancelBuild stops a running build.
+	rootCtx    context.Context
+	rootCancel context.CancelFunc
+	running    sync.WaitGroup
 }
 
 func NewPipeline(
 	config *config.PipelineConfig,
-	builderFactory *builder.Factory,
-	deployer deployer.Deployer,
+	builderFactory builder.FactoryInterface,
+	targets *deployer.Targets,
 	validator validator.Validator,
+	monitor *monitor.Monitor,
+	plugins *plugin.Runner,
+	addons *addon.Manager,
+	store BuildStore,
+	notifier Notifier,
+	releaser Releaser,
+	lanes LaneStore,
 	logger *zap.Logger,
 ) *Pipeline {
-	return &Pipeline{
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	platformDeployer := targets.Default()
+
+	p := &Pipeline{
 		config:         config,
 		builderFactory: builderFactory,
-		deployer:       deployer,
+		deployer:       platformDeployer,
+		hooks:          deployer.NewHookRunner(&config.Deploy, platformDeployer, logger),
+		targets:        make(map[string]deployTarget, len(targets.Environments())),
+		attestor:       provenance.NewAttestor(&config.Provenance, logger),
+		policy:         policy.NewEngine(&config.Policy, logger),
+		perfAudit:      perfaudit.NewAuditor(&config.PerfAudit, logger),
+		integrity:      integrity.NewVerifier(&config.Integrity, logger),
+		sourceMaps:     sourcemap.NewUploader(&config.SourceMaps, logger),
+		plugins:        plugins,
+		addons:         addons,
 		validator:      validator,
+		monitor:        monitor,
 		logger:         logger,
 		builds:         make(map[string]*types.Build),
 		metrics:        NewMetricsCollector(),
+		store:          store,
+		storedEvents:   make(map[string]int),
+		notifier:       notifier,
+		releaser:       releaser,
+		laneStore:      lanes,
+		migrations:     make(map[migrationKey]*types.Migration),
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+		instance:       events.InstanceID(),
+		rootCtx:        rootCtx,
+		rootCancel:     rootCancel,
+	}
+
+	for env, d := range targets.Environments() {
+		p.targets[env] = deployTarget{
+			deployer: d,
+			hooks:    deployer.NewHookRunner(config.Deploy.Target(env), d, logger),
+		}
 	}
+
+	if store != nil {
+		p.loadMigrations()
+		p.running.Add(1)
+		go p.keepBuildsAlive()
+	}
+	p.running.Add(1)
+	go p.sweepMigrations()
+	if len(p.removers()) > 0 {
+		p.running.Add(1)
+		go p.sweepPreviews()
+	}
+	if config.Debug.Enabled {
+		if shell, err := builder.NewShell(); err != nil {
+			logger.Error("debugging failed builds is unavailable", zap.Error(err))
+		} else {
+			p.debugShell = shell
+			p.running.Add(1)
+			go p.sweepDebugImages()
+		}
+	}
+	return p
 }
 
+// StartBuild validates the build synchronously and runs it in the background.
+// An empty build ID is generated and set on build. The caller's context only
+// governs validation; the build itself runs under the pipeline's root
+// context.
 func (p *Pipeline) StartBuild(ctx context.Context, build *types.Build) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := p.prepareBuild(build); err != nil {
+		return fmt.Errorf("build validation failed: %w", err)
+	}
+	ctx = buildlog.WithBuild(ctx, build)
+
 	// Validate build configuration
-	if err := p.validator.ValidateBuildConfig(build); err != nil {
+	if err := p.validator.ValidateBuildConfig(ctx, build); err != nil {
+		return fmt.Errorf("build validation failed: %w", err)
+	}
+	if err := deployer.ValidateHooks(build.Hooks); err != nil {
 		return fmt.Errorf("build validation failed: %w", err)
 	}
+	if build.PreviewOnly {
+		if d, _ := p.target(build.ProjectID, build.Environment); !isRemover(d) {
+			return fmt.Errorf("build validation failed: %w", ErrPreviewUnsupported)
+		}
+	}
+
+	for _, warning := range build.Warnings {
+		buildlog.Logger(ctx, p.logger).Warn("build warning", zap.String("warning", warning))
+	}
+
+	if build.Status == "" {
+		build.Status = types.BuildStatusPending
+	}
+	build.Instance = p.instance
+	build.Input = newBuildInput(build)
+	if build.StartTime.IsZero() {
+		build.StartTime = time.Now()
+	}
+	if p.store != nil {
+		if err := p.store.CreateBuild(ctx, build); err != nil {
+			return fmt.Errorf("failed to record build: %w", err)
+		}
+	}
 
 	p.mu.Lock()
 	p.builds[build.ID] = build
 	p.mu.Unlock()
 
-	go func() {
-		if err := p.executeBuild(ctx, build); err != nil {
-			p.logger.Error("build failed",
-				zap.String("build_id", build.ID),
-				zap.Error(err))
-			build.Status = types.BuildStatusFailed
-			build.ErrorMessage = err.Error()
+	start, superseded := p.admit(build)
+	for _, old := range superseded {
+		p.persist(old)
+		p.notify(types.LifecycleBuildSuperseded, old, "superseded by build "+build.ID)
+	}
+	if start {
+		p.enter(build)
+	}
+	return nil
+}
+
+// run executes the build and reports its outcome. It returns true when
+// the build was preempted and is to wait for a slot again.
+func (p *Pipeline) run(build *types.Build) bool {
+	ctx := types.WithBuildUpdater(buildlog.WithBuild(p.baseContext(), build), p.updateBuild)
+	err := p.executeBuild(ctx, build)
+	p.mu.Lock()
+	build.Deploying = false
+	stages := build.Stages
+	p.mu.Unlock()
+	p.metrics.ObserveStages(stages)
+	if err == nil {
+		p.persist(build)
+		if build.PreviewOnly {
+			p.notify(types.LifecyclePreviewReady, build, build.Preview.URL)
+		} else {
+			p.notify(types.LifecycleDeploySucceeded, build, "")
 		}
-	}()
+		return false
+	}
 
-	return nil
+	// A successful build only fails afterwards while deploying; cancelled
+	// and superseded builds were already reported and keep their status
+	p.mu.RLock()
+	previous := build.Status
+	p.mu.RUnlock()
+
+	switch previous {
+	case types.BuildStatusCancelled, types.BuildStatusSuperseded:
+		p.persist(build)
+		return false
+	}
+	if p.preempted(build) {
+		return true
+	}
+
+	buildlog.Logger(ctx, p.logger).Error("build failed", zap.Error(err))
+
+	diagnosis := diagnose.Analyze(failureOutput(err))
+	var pullErr *deployer.ImagePullError
+	var memoryErr *builder.OutOfMemoryError
+	switch {
+	case diagnosis == nil:
+	case errors.As(err, &pullErr):
+		diagnosis.Details = pullErr.Details()
+	case errors.As(err, &memoryErr):
+		diagnosis.Details = memoryErr.Details()
+	}
+	var timeoutErr *types.PhaseTimeoutError
+	p.updateBuild(build, func(b *types.Build) {
+		if errors.As(err, &timeoutErr) {
+			b.AddEvent(types.EventPhaseTimedOut, string(timeoutErr.Phase), "timed out after "+timeoutErr.Timeout.String())
+		}
+		b.Status = types.BuildStatusFailed
+		b.ErrorMessage = err.Error()
+		b.Diagnosis = diagnosis
+	})
+	p.persist(build)
+
+	if previous == types.BuildStatusSuccess {
+		p.notify(types.LifecycleDeployFailed, build, err.Error())
+	} else {
+		p.notify(types.LifecycleBuildFailed, build, err.Error())
+	}
+	return false
+}
+
+// failureOutput is the error of a failed build followed by the output the
+// builder kept
+func failureOutput(err error) string {
+	var outputErr *builder.OutputError
+	if !errors.As(err, &outputErr) {
+		return err.Error()
+	}
+	lines := append(append([]string(nil), outputErr.Output...), err.Error())
+	return strings.Join(lines, "\n")
+}
+
+// phaseTimeouts are the server's phase timeouts, those left unset fall
+// back to default_timeout
+func (p *Pipeline) phaseTimeouts() types.PhaseTimeouts {
+	fallback := p.config.DefaultTimeout
+	return types.PhaseTimeouts{
+		Clone:       fallback,
+		Install:     fallback,
+		Build:       fallback,
+		Package:     fallback,
+		Deploy:      fallback,
+		HealthCheck: fallback,
+	}.Override(types.PhaseTimeouts(p.config.Timeouts))
 }
 
 func (p *Pipeline) executeBuild(ctx context.Context, build *types.Build) error {
 	// Set initial status
-	build.Status = types.BuildStatusBuilding
+	p.updateBuild(build, func(b *types.Build) {
+		b.Status = types.BuildStatusBuilding
+		b.BuilderVersion = types.BuilderVersion()
+	})
+	p.persist(build)
+	p.notify(types.LifecycleBuildStarted, build, "")
+
+	// Stages are recorded on the build when it returns, before run
+	// persists its outcome
+	timer := &types.StageTimer{}
+	ctx = types.WithStageTimer(ctx, timer)
+	defer p.recordStages(build, timer)
 
 	buildCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -80,15 +346,17 @@ func (p *Pipeline) executeBuild(ctx context.Context, build *types.Build) error {
 	p.mu.Unlock()
 
 	// Create build context with cleanup
-	buildContext, err := builder.NewBuildContext(p.config.BuildDir, build.ID)
+	layout, err := builder.NewLayout(p.config)
+	if err != nil {
+		return err
+	}
+	buildContext, err := builder.NewBuildContext(layout, build.ID)
 	if err != nil {
 		return fmt.Errorf("failed to create build context: %w", err)
 	}
 	defer func() {
 		if err := buildContext.Cleanup(); err != nil {
-			p.logger.Error("cleanup failed",
-				zap.String("build_id", build.ID),
-				zap.Error(err))
+			buildlog.Logger(ctx, p.logger).Error("cleanup failed", zap.Error(err))
 		}
 	}()
 
@@ -103,65 +371,292 @@ func (p *Pipeline) executeBuild(ctx context.Context, build *types.Build) error {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	// Create builder without timeout
+	buildEnv, err := p.buildTimeEnv(build)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	build.BuildEnv = envtemplate.SortedKeys(buildEnv)
+	p.mu.Unlock()
+
+	if err := p.runPlugins(buildCtx, plugin.PreBuild, build); err != nil {
+		return err
+	}
+
 	builder, err := p.builderFactory.CreateBuilder(build.Framework, &builder.Options{
 		WorkDir:     buildContext.BuildDir,
+		ArtifactDir: buildContext.ArtifactDir,
 		CacheDir:    buildContext.CacheDir,
-		Environment: p.config.NodeJS.EnvVars,
-		Timeout:     0, // No timeout
+		Environment: buildEnv,
+		Timeouts:    p.phaseTimeouts(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create builder: %w", err)
 	}
 	defer func() {
 		if err := builder.Cleanup(); err != nil {
-			p.logger.Error("cleanup failed",
-				zap.String("build_id", build.ID),
-				zap.Error(err))
+			buildlog.Logger(ctx, p.logger).Error("cleanup failed", zap.Error(err))
 		}
 	}()
 
-	// Run build with the cancellable context but no timeout
+	// The builder bounds each of its phases
 	buildResult, err := builder.Build(buildCtx, build)
 	if err != nil {
+		p.recordTestFailure(build, err)
+		p.keepDebugImage(buildCtx, build, builder)
 		return fmt.Errorf("build failed: %w", err)
 	}
+	p.recordTestResults(build, buildResult.TestResults, buildResult.Coverage)
+	for _, stage := range buildResult.Stages {
+		timer.Record(stage.Stage, stage.StartedAt, stage.Duration)
+	}
 
-	// Validate artifact
-	if err := p.validator.ValidateArtifact(buildResult.ArtifactPath); err != nil {
-		return fmt.Errorf("artifact validation failed: %w", err)
+	err = types.TimeStage(ctx, types.StageScan, func() error {
+		if err := p.validator.ValidateArtifact(buildCtx, buildResult.ArtifactPath, buildResult.SourceMaps); err != nil {
+			return fmt.Errorf("artifact validation failed: %w", err)
+		}
+		p.uploadSourceMaps(buildCtx, build, buildResult)
+		p.recordArtifactDigest(build, buildResult.ArtifactPath, buildContext.ArtifactDir)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Update build status
-	build.Status = types.BuildStatusSuccess
-	build.ArtifactPath = buildResult.ArtifactPath
-	build.ImageID = buildResult.ImageID
+	if err := deployer.ValidateHooks(buildResult.Hooks); err != nil {
+		return fmt.Errorf("invalid hooks: %w", err)
+	}
 	completeTime := time.Now()
-	build.CompleteTime = &completeTime
+	p.updateBuild(build, func(b *types.Build) {
+		b.ArtifactPath = buildResult.ArtifactPath
+		b.ImageID = buildResult.ImageID
+		b.BaseImages = buildResult.BaseImages
+		b.ImageSize = buildResult.ImageSize
+		b.Toolchain = buildResult.Toolchain
+		b.AddOns = buildResult.AddOns
+		b.Jobs = buildResult.Jobs
+		b.Processes = buildResult.Processes
+		b.Timeouts = buildResult.Timeouts
+		b.Hooks = buildResult.Hooks
+		b.CompleteTime = &completeTime
+	})
+
+	err = types.TimeStage(ctx, types.StageScan, func() error {
+		if p.attestor != nil && p.attestor.Enabled() {
+			sourceDir, _ := build.BuilderConfig["sourceDir"].(string)
+			prov, err := p.attestor.Attest(ctx, build, sourceDir)
+			if err != nil {
+				return fmt.Errorf("failed to attest build: %w", err)
+			}
+			p.updateBuild(build, func(b *types.Build) {
+				b.Provenance = prov
+			})
+		}
+		return p.runPlugins(buildCtx, plugin.PostBuild, build)
+	})
+	if err != nil {
+		return err
+	}
 
-	// Deploy
-	if err := p.deployer.Deploy(ctx, build); err != nil {
-		if rbErr := p.deployer.Rollback(ctx, build); rbErr != nil {
-			p.logger.Error("rollback failed",
-				zap.String("build_id", build.ID),
-				zap.Error(rbErr))
+	p.updateBuild(build, func(b *types.Build) {
+		b.Status = types.BuildStatusSuccess
+		b.Deploying = true
+	})
+	p.recordStages(build, timer)
+	p.persist(build)
+	p.notify(types.LifecycleBuildSucceeded, build, "")
+
+	if build.PreviewOnly {
+		return p.deployPreview(ctx, build)
+	}
+	return p.deploy(ctx, build)
+}
+
+// recordStages copies the stage timings recorded so far onto the build
+func (p *Pipeline) recordStages(build *types.Build, timer *types.StageTimer) {
+	stages := timer.Stages()
+	p.updateBuild(build, func(b *types.Build) {
+		b.Stages = stages
+	})
+}
+
+// updateBuild applies change to a build others may be reading, such as
+// persist and notify. Changes to the status, results and events of
+// running builds go through it, deployers reach it through the context
+// of the build.
+func (p *Pipeline) updateBuild(build *types.Build, change func(*types.Build)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	change(build)
+}
+
+// deploy rolls the build out to the project's environment and runs the
+// post-deploy hooks, rolling back when either fails. Deploys of the same
+// environment wait for each other on its deploy lock.
+func (p *Pipeline) deploy(ctx context.Context, build *types.Build) error {
+	unlock, err := p.lockDeploy(ctx, build)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	p.deploying.Add(1)
+	defer p.deploying.Add(-1)
+
+	err = types.TimeStage(ctx, types.StageScan, func() error {
+		if err := p.verify(ctx, build); err != nil {
+			return err
+		}
+		if err := p.checkPolicy(ctx, build, false); err != nil {
+			return err
+		}
+		return p.runPlugins(ctx, plugin.PreDeploy, build)
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.ensureAddOns(ctx, build); err != nil {
+		return err
+	}
+	timeouts := p.phaseTimeouts().Override(build.Timeouts)
+	target, hooks := p.target(build.ProjectID, build.Environment)
+	err = timeouts.RunPhase(ctx, types.PhaseDeploy, func(ctx context.Context) error {
+		return target.Deploy(ctx, build)
+	})
+	if err != nil {
+		if rbErr := target.Rollback(ctx, build); rbErr != nil {
+			buildlog.Logger(ctx, p.logger).Error("rollback failed", zap.Error(rbErr))
 		}
 		return fmt.Errorf("deployment failed: %w", err)
 	}
 
+	// Post-deploy hooks, the asset check, then plugins; a failure of any
+	// rolls back
+	err = timeouts.RunPhase(ctx, types.PhaseHealthCheck, func(ctx context.Context) error {
+		if hooks != nil {
+			if err := hooks.Run(ctx, build); err != nil {
+				return err
+			}
+		}
+		return p.verifyAssets(ctx, build)
+	})
+	if err != nil {
+		p.rollback(ctx, build, err)
+		return err
+	}
+	err = types.TimeStage(ctx, types.StageVerify, func() error {
+		return p.runPlugins(ctx, plugin.PostDeploy, build)
+	})
+	if err != nil {
+		p.rollback(ctx, build, err)
+		return err
+	}
+
+	p.trackServing(build.ProjectID, build.Environment)
+	p.auditDeployment(build)
+	p.releaseDeploy(ctx, build)
+
+	return nil
+}
+
+// rollback restores the previous deployment after a post-deploy failure
+func (p *Pipeline) rollback(ctx context.Context, build *types.Build, cause error) {
+	target, _ := p.target(build.ProjectID, build.Environment)
+	if err := target.Rollback(ctx, build); err != nil {
+		buildlog.Logger(ctx, p.logger).Error("rollback failed", zap.Error(err))
+		return
+	}
+	types.RecordEvent(ctx, build, types.EventRolledBack, "", cause.Error())
+	p.notify(types.LifecycleDeployRolledBack, build, cause.Error())
+}
+
+// verify checks a build's signatures before anything of it is deployed
+func (p *Pipeline) verify(ctx context.Context, build *types.Build) error {
+	if p.attestor == nil {
+		return nil
+	}
+	if err := p.attestor.Verify(ctx, build); err != nil {
+		return fmt.Errorf("refusing to deploy: %w", err)
+	}
 	return nil
 }
 
+// buildTimeEnv resolves the variables passed to the frontend build: the
+// server's Node.js env vars overlaid with the environment's public ones.
+// Templates see no image yet since it is being built.
+func (p *Pipeline) buildTimeEnv(build *types.Build) (map[string]string, error) {
+	public := envtemplate.Public(build.EnvVars, p.config.NodeJS.PublicEnvPrefixes)
+	target := p.targetName(build.ProjectID, build.Environment)
+	domain := fmt.Sprintf("%s.%s", build.ProjectID, p.config.Deploy.Target(target).IngressDomain)
+	rendered, err := envtemplate.Render(public, envtemplate.NewData(build, domain))
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string, len(p.config.NodeJS.EnvVars)+len(rendered))
+	for key, value := range p.config.NodeJS.EnvVars {
+		env[key] = value
+	}
+	for key, value := range rendered {
+		env[key] = value
+	}
+	return env, nil
+}
+
+// appURL is the public base URL of a project deployed to environment
+func (p *Pipeline) appURL(environment, projectID string) string {
+	return p.targetURL(p.targetName(projectID, environment), projectID)
+}
+
+// targetURL is the base URL a target serves host at
+func (p *Pipeline) targetURL(target, host string) string {
+	scheme := p.config.Monitor.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s.%s", scheme, host, p.config.Deploy.Target(target).IngressDomain)
+}
+
+func (p *Pipeline) baseContext() context.Context {
+	if p.rootCtx == nil {
+		return context.Background()
+	}
+	return p.rootCtx
+}
+
+// Shutdown cancels all running builds and waits for them to finish or for
+// ctx to expire.
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	if p.rootCancel != nil {
+		p.rootCancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for running builds: %w", ctx.Err())
+	}
+}
+
 func (p *Pipeline) CancelBuild(buildID string) error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 
 	build, exists := p.builds[buildID]
 	if !exists {
-		return fmt.Errorf("build not found: %s", buildID)
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", types.ErrBuildNotFound, buildID)
 	}
 
 	if build.Status != types.BuildStatusBuilding {
+		p.mu.Unlock()
 		return fmt.Errorf("cannot cancel build with status: %s", build.Status)
 	}
 
@@ -173,7 +668,10 @@ func (p *Pipeline) CancelBuild(buildID string) error {
 	build.Status = types.BuildStatusCancelled
 	completeTime := time.Now()
 	build.CompleteTime = &completeTime
+	p.mu.Unlock()
 
+	p.persist(build)
+	p.notify(types.LifecycleBuildCancelled, build, "")
 	return nil
 }
 
@@ -183,8 +681,42 @@ func (p *Pipeline) GetBuild(buildID string) (*types.Build, error) {
 
 	build, exists := p.builds[buildID]
 	if !exists {
-		return nil, fmt.Errorf("build not found: %s", buildID)
+		return nil, fmt.Errorf("%w: %s", types.ErrBuildNotFound, buildID)
 	}
 
 	return build, nil
 }
+
+// Metrics returns the build metrics of this instance
+func (p *Pipeline) Metrics() *MetricsCollector {
+	return p.metrics
+}
+
+// RecordProjectEvent appends a deployment event to the project's most recent
+// build. It reports false when the project has no known build.
+func (p *Pipeline) RecordProjectEvent(projectID string, eventType types.DeploymentEventType, message string) bool {
+	p.mu.Lock()
+
+	var latest *types.Build
+	for _, build := range p.builds {
+		if build.ProjectID != projectID {
+			continue
+		}
+		if latest == nil || build.StartTime.After(latest.StartTime) {
+			latest = build
+		}
+	}
+	if latest == nil {
+		p.mu.Unlock()
+		return false
+	}
+
+	latest.AddEvent(eventType, "", message)
+	p.mu.Unlock()
+
+	p.persist(latest)
+	if event, ok := deploymentLifecycle[eventType]; ok {
+		p.notify(event, latest, message)
+	}
+	return true
+}
@@ -62,10 +62,6 @@ func TestPipelineIntegration(t *testing.T) {
 				Framework:    "react",
 				BuildCommand: "build",
 				OutputDir:    "build",
-				BuilderConfig: map[string]interface{}{
-					"sourceDir": filepath.Join(tmpDir, "test-react-app"),
-					"workDir":   filepath.Join(tmpDir, "test-react-app"),
-				},
 			},
 			setupFiles: func(dir string) {
 				createValidReactProject(t, dir)
@@ -89,10 +85,6 @@ func TestPipelineIntegration(t *testing.T) {
 				Framework:    "react",
 				BuildCommand: "build",
 				OutputDir:    "build",
-				BuilderConfig: map[string]interface{}{
-					"sourceDir": filepath.Join(tmpDir, "invalid-app"),
-					"workDir":   filepath.Join(tmpDir, "invalid-app"),
-				},
 			},
 			setupFiles: func(dir string) {
 				// Create invalid package.json
@@ -115,10 +107,6 @@ func TestPipelineIntegration(t *testing.T) {
 				Framework:    "react",
 				BuildCommand: "nonexistent",
 				OutputDir:    "build",
-				BuilderConfig: map[string]interface{}{
-					"sourceDir": filepath.Join(tmpDir, "missing-cmd-app"),
-					"workDir":   filepath.Join(tmpDir, "missing-cmd-app"),
-				},
 			},
 			setupFiles: func(dir string) {
 				createReactProjectWithoutBuildScript(t, dir)
@@ -151,10 +139,6 @@ func TestPipelineIntegration(t *testing.T) {
 				t.Fatalf("package.json not created: %v", err)
 			}
 
-			// Update build config with absolute paths
-			tt.build.BuilderConfig["sourceDir"] = projectDir
-			tt.build.BuilderConfig["workDir"] = projectDir
-
 			err = pipeline.StartBuild(ctx, tt.build)
 
 			if !tt.expectError {
@@ -276,6 +260,7 @@ func createTestConfig(_ *testing.T, tmpDir string) *config.PipelineConfig {
 		ArtifactsDir:   filepath.Join(tmpDir, "artifacts"),
 		CacheDir:       filepath.Join(tmpDir, "cache"),
 		DefaultTimeout: 300,
+		Source:         config.SourceConfig{Root: tmpDir},
 		NodeJS: config.NodeJSConfig{
 			DefaultVersion: "16",
 			AllowedEngines: []string{"14", "16", "18"},
@@ -416,15 +401,15 @@ func setupPipeline(t *testing.T, cfg *config.PipelineConfig, logger *zap.Logger)
 	// Create builder factory
 	builderFactory := builder.NewBuilderFactory(cfg, logger)
 
-	// Create deployer
-	deployer, err := deployer.NewDeployer(&cfg.Deploy, logger)
+	// Create deployers
+	targets, err := deployer.NewTargets(&cfg.Deploy, logger)
 	require.NoError(t, err)
 
 	// Create validator
-	validator := validator.NewNodeJSValidator(&cfg.NodeJS)
+	validator := validator.NewNodeJSValidator(&cfg.NodeJS, logger)
 
 	// Create pipeline
-	pipeline := NewPipeline(cfg, builderFactory, deployer, validator, logger)
+	pipeline := NewPipeline(cfg, builderFactory, targets, validator, nil, nil, nil, nil, nil, nil, nil, logger)
 	require.NotNil(t, pipeline)
 
 	return pipeline
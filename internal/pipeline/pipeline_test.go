@@ -2,25 +2,50 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
+	"github.com/elskow/chef-infra/internal/pagination"
 	"github.com/elskow/chef-infra/internal/pipeline/builder"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/diagnose"
+	"github.com/elskow/chef-infra/internal/pipeline/provenance"
+	"github.com/elskow/chef-infra/internal/pipeline/source"
 	"github.com/elskow/chef-infra/internal/pipeline/types"
 )
 
+var (
+	testBuildDir     = filepath.Join(os.TempDir(), "test-builds")
+	testArtifactsDir = filepath.Join(os.TempDir(), "test-artifacts")
+	testCacheDir     = filepath.Join(os.TempDir(), "test-cache")
+	testSourceDir    = filepath.Join(os.TempDir(), "test-project")
+)
+
+// The flags of the mocks are set by build goroutines and read by tests
 type mockBuilder struct {
-	buildCalled    bool
-	validateCalled bool
-	cleanupCalled  bool
+	buildCalled    atomic.Bool
+	validateCalled atomic.Bool
+	cleanupCalled  atomic.Bool
 	shouldFail     bool
+	buildErr       error               // Returned by Build when set
+	artifact       string              // ArtifactPath returned by Build when set
+	stages         []types.StageTiming // Returned by Build, as agents do
+	hooks          []types.Hook        // Returned by Build, as if defined in chef.yaml
 	delay          time.Duration
 }
 
@@ -33,10 +58,7 @@ func (f *mockBuilderFactory) CreateBuilder(framework string, options *builder.Op
 }
 
 func (m *mockBuilder) Build(ctx context.Context, build *types.Build) (*types.BuildResult, error) {
-	m.buildCalled = true
-
-	// Set build status to building immediately
-	build.Status = types.BuildStatusBuilding
+	m.buildCalled.Store(true)
 
 	// Simulate work with delay if specified
 	if m.delay > 0 {
@@ -47,19 +69,28 @@ func (m *mockBuilder) Build(ctx context.Context, build *types.Build) (*types.Bui
 		}
 	}
 
+	if m.buildErr != nil {
+		return nil, m.buildErr
+	}
 	if m.shouldFail {
 		return nil, fmt.Errorf("mock build failure")
 	}
 
+	artifact := m.artifact
+	if artifact == "" {
+		artifact = filepath.Join(testArtifactsDir, "test-artifact.tar.gz")
+	}
 	return &types.BuildResult{
 		Success:      true,
-		ArtifactPath: "/tmp/test-artifact.tar.gz",
+		ArtifactPath: artifact,
 		ImageID:      "test-image:latest",
+		Stages:       m.stages,
+		Hooks:        m.hooks,
 	}, nil
 }
 
 func (m *mockBuilder) Validate(build *types.Build) error {
-	m.validateCalled = true
+	m.validateCalled.Store(true)
 	if m.shouldFail {
 		return fmt.Errorf("mock validation failure")
 	}
@@ -67,7 +98,7 @@ func (m *mockBuilder) Validate(build *types.Build) error {
 }
 
 func (m *mockBuilder) Cleanup() error {
-	m.cleanupCalled = true
+	m.cleanupCalled.Store(true)
 	if m.shouldFail {
 		return fmt.Errorf("mock cleanup failure")
 	}
@@ -75,14 +106,26 @@ func (m *mockBuilder) Cleanup() error {
 }
 
 type mockDeployer struct {
-	deployCalled   bool
-	rollbackCalled bool
-	validateCalled bool
+	deployCalled   atomic.Bool
+	rollbackCalled atomic.Bool
+	validateCalled atomic.Bool
 	shouldFail     bool
+	deployErr      error
+	delay          time.Duration // Deploy waits this long unless ctx ends first
 }
 
 func (m *mockDeployer) Deploy(ctx context.Context, build *types.Build) error {
-	m.deployCalled = true
+	m.deployCalled.Store(true)
+	if m.delay > 0 {
+		select {
+		case <-time.After(m.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if m.deployErr != nil {
+		return m.deployErr
+	}
 	if m.shouldFail {
 		return fmt.Errorf("mock deploy failure")
 	}
@@ -90,7 +133,7 @@ func (m *mockDeployer) Deploy(ctx context.Context, build *types.Build) error {
 }
 
 func (m *mockDeployer) Rollback(ctx context.Context, build *types.Build) error {
-	m.rollbackCalled = true
+	m.rollbackCalled.Store(true)
 	if m.shouldFail {
 		return fmt.Errorf("mock rollback failure")
 	}
@@ -98,7 +141,7 @@ func (m *mockDeployer) Rollback(ctx context.Context, build *types.Build) error {
 }
 
 func (m *mockDeployer) Validate(build *types.Build) error {
-	m.validateCalled = true
+	m.validateCalled.Store(true)
 	if m.shouldFail {
 		return fmt.Errorf("mock validation failure")
 	}
@@ -107,11 +150,11 @@ func (m *mockDeployer) Validate(build *types.Build) error {
 
 type mockValidator struct {
 	validateBuildConfigCalled bool
-	validateArtifactCalled    bool
+	validateArtifactCalled    atomic.Bool
 	shouldFail                bool
 }
 
-func (m *mockValidator) ValidateBuildConfig(build *types.Build) error {
+func (m *mockValidator) ValidateBuildConfig(ctx context.Context, build *types.Build) error {
 	m.validateBuildConfigCalled = true
 	if m.shouldFail {
 		return fmt.Errorf("mock validation failure")
@@ -119,8 +162,8 @@ func (m *mockValidator) ValidateBuildConfig(build *types.Build) error {
 	return nil
 }
 
-func (m *mockValidator) ValidateArtifact(artifactPath string) error {
-	m.validateArtifactCalled = true
+func (m *mockValidator) ValidateArtifact(ctx context.Context, artifactPath string, sourceMaps types.SourceMaps) error {
+	m.validateArtifactCalled.Store(true)
 	if m.shouldFail {
 		return fmt.Errorf("mock artifact validation failure")
 	}
@@ -130,10 +173,11 @@ func (m *mockValidator) ValidateArtifact(artifactPath string) error {
 func setupTestPipeline(t *testing.T) (*Pipeline, *mockBuilder, *mockDeployer, *mockValidator) {
 	// Create test config
 	cfg := &config.PipelineConfig{
-		BuildDir:       "/tmp/test-builds",
-		ArtifactsDir:   "/tmp/test-artifacts",
-		CacheDir:       "/tmp/test-cache",
+		BuildDir:       testBuildDir,
+		ArtifactsDir:   testArtifactsDir,
+		CacheDir:       testCacheDir,
 		DefaultTimeout: 300,
+		Source:         config.SourceConfig{Root: os.TempDir()},
 	}
 
 	// Create test logger
@@ -150,6 +194,9 @@ func setupTestPipeline(t *testing.T) (*Pipeline, *mockBuilder, *mockDeployer, *m
 		builder: mockBuilder,
 	}
 
+	rootCtx, rootCancel := context.WithCancel(context.Background())
+	t.Cleanup(rootCancel)
+
 	// Create pipeline
 	pipeline := &Pipeline{
 		config:         cfg,
@@ -159,6 +206,8 @@ func setupTestPipeline(t *testing.T) (*Pipeline, *mockBuilder, *mockDeployer, *m
 		logger:         logger,
 		builds:         make(map[string]*types.Build),
 		metrics:        NewMetricsCollector(),
+		rootCtx:        rootCtx,
+		rootCancel:     rootCancel,
 	}
 
 	return pipeline, mockBuilder, mockDeployer, mockValidator
@@ -172,9 +221,6 @@ func createTestBuild() *types.Build {
 		BuildCommand: "build",
 		OutputDir:    "build",
 		Status:       types.BuildStatusPending,
-		BuilderConfig: map[string]interface{}{
-			"sourceDir": "/tmp/test-source",
-		},
 	}
 }
 
@@ -213,8 +259,8 @@ func TestPipeline_StartBuild(t *testing.T) {
 			validate: func(t *testing.T, p *Pipeline, b *mockBuilder, d *mockDeployer, v *mockValidator, err error) {
 				assert.Error(t, err)
 				assert.True(t, v.validateBuildConfigCalled)
-				assert.False(t, b.buildCalled)
-				assert.False(t, d.deployCalled)
+				assert.False(t, b.buildCalled.Load())
+				assert.False(t, d.deployCalled.Load())
 			},
 		},
 		{
@@ -231,7 +277,7 @@ func TestPipeline_StartBuild(t *testing.T) {
 				build, err := p.GetBuild("test-build-123")
 				require.NoError(t, err)
 				assert.Equal(t, types.BuildStatusFailed, build.Status)
-				assert.False(t, d.deployCalled)
+				assert.False(t, d.deployCalled.Load())
 			},
 		},
 		{
@@ -249,9 +295,9 @@ func TestPipeline_StartBuild(t *testing.T) {
 				build, err := p.GetBuild("test-build-123")
 				require.NoError(t, err)
 				assert.Equal(t, types.BuildStatusFailed, build.Status)
-				assert.True(t, b.buildCalled, "Build should have been called")
-				assert.True(t, d.deployCalled, "Deploy should have been called")
-				assert.True(t, d.rollbackCalled, "Rollback should have been called")
+				assert.True(t, b.buildCalled.Load(), "Build should have been called")
+				assert.True(t, d.deployCalled.Load(), "Deploy should have been called")
+				assert.True(t, d.rollbackCalled.Load(), "Rollback should have been called")
 			},
 		},
 	}
@@ -279,6 +325,116 @@ func TestPipeline_StartBuild(t *testing.T) {
 	}
 }
 
+func TestPipeline_StartBuildRejectsUntrustedInput(t *testing.T) {
+	// The source root and a directory beside it
+	root := filepath.Join(t.TempDir(), "sources")
+	outside := t.TempDir()
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "test-source-link")))
+
+	tests := []struct {
+		name     string
+		buildMod func(*types.Build)
+		wantErr  string
+	}{
+		{
+			name:     "caller source dir",
+			buildMod: func(b *types.Build) { b.BuilderConfig = map[string]interface{}{"sourceDir": "/etc", "workDir": "/"} },
+			wantErr:  "builder config is set by the server, got sourceDir, workDir",
+		},
+		{
+			name:     "project id with a path",
+			buildMod: func(b *types.Build) { b.ProjectID = "../etc" },
+			wantErr:  "project id \"../etc\" must be 3 to 63 lowercase letters",
+		},
+		{
+			name:     "project id that is no DNS label",
+			buildMod: func(b *types.Build) { b.ProjectID = "Test_Project" },
+			wantErr:  "project id \"Test_Project\" must be 3 to 63 lowercase letters",
+		},
+		{
+			name:     "project checkout linking out of the root",
+			buildMod: func(b *types.Build) { b.ProjectID = "test-source-link" },
+			wantErr:  "sourceDir must be a path relative to the source root",
+		},
+		{
+			name:     "project without a checkout",
+			buildMod: func(b *types.Build) { b.ProjectID = "other-project" },
+			wantErr:  "source other-project does not exist",
+		},
+		{
+			name:     "build id with a path",
+			buildMod: func(b *types.Build) { b.ID = "../../etc" },
+			wantErr:  "may only contain letters, digits, - and _",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, builder, _, validator := setupTestPipeline(t)
+			pipeline.config.Source.Root = root
+			build := createTestBuild()
+			tt.buildMod(build)
+
+			err := pipeline.StartBuild(context.Background(), build)
+			require.ErrorIs(t, err, ErrInvalidBuild)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.False(t, validator.validateBuildConfigCalled)
+			assert.False(t, builder.buildCalled.Load())
+		})
+	}
+}
+
+func TestPipeline_StartBuildSourceLimits(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	pipeline.config.Source.Limits.MaxTreeSize = 1
+	err := pipeline.StartBuild(context.Background(), createTestBuild())
+	require.ErrorIs(t, err, source.ErrSourceTooLarge)
+	assert.Contains(t, err.Error(), "SOURCE_TOO_LARGE: source is larger than 1 bytes")
+	assert.False(t, builder.buildCalled.Load())
+}
+
+func TestPipeline_StartBuildGeneratesID(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+
+	build := createTestBuild()
+	build.ID = ""
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	_, err := uuid.Parse(build.ID)
+	require.NoError(t, err)
+	resolved, err := filepath.EvalSymlinks(testSourceDir)
+	require.NoError(t, err)
+	assert.Equal(t, resolved, build.BuilderConfig["sourceDir"])
+
+	duplicate := createTestBuild()
+	duplicate.ID = build.ID
+	assert.ErrorIs(t, pipeline.StartBuild(context.Background(), duplicate), ErrInvalidBuild)
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+}
+
+func TestPipeline_StartBuildTargetPlatforms(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.config.NodeJS.Platforms = []string{"linux/amd64"}
+	pipeline.config.NodeJS.ProjectPlatforms = map[string][]string{"test-project": {"linux/amd64", "linux/arm64"}}
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	assert.Equal(t, []string{"linux/amd64", "linux/arm64"}, build.Platforms)
+	assert.Equal(t, build.Platforms, build.Input.Platforms)
+
+	requested := createTestBuild()
+	requested.ID = ""
+	requested.Platforms = []string{"linux/arm64"}
+	require.NoError(t, pipeline.StartBuild(context.Background(), requested))
+	assert.Equal(t, []string{"linux/arm64"}, requested.Platforms)
+
+	delete(pipeline.config.NodeJS.ProjectPlatforms, "test-project")
+	fallback := createTestBuild()
+	fallback.ID = ""
+	require.NoError(t, pipeline.StartBuild(context.Background(), fallback))
+	assert.Equal(t, []string{"linux/amd64"}, fallback.Platforms)
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+}
+
 func TestPipeline_CancelBuild(t *testing.T) {
 	pipeline, builder, _, _ := setupTestPipeline(t)
 
@@ -322,7 +478,7 @@ func TestPipeline_GetBuild(t *testing.T) {
 
 	// Test non-existent build
 	_, err := pipeline.GetBuild("non-existent")
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, types.ErrBuildNotFound)
 
 	// Add a build and test retrieval
 	build := createTestBuild()
@@ -334,22 +490,773 @@ func TestPipeline_GetBuild(t *testing.T) {
 	assert.Equal(t, build.ID, retrievedBuild.ID)
 }
 
+func TestPipeline_BuildOutlivesRequestContext(t *testing.T) {
+	pipeline, builder, deployer, _ := setupTestPipeline(t)
+	builder.delay = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(ctx, build))
+
+	// Simulate the client disconnecting right after the build was accepted
+	cancel()
+
+	require.Eventually(t, func() bool {
+		return deployer.deployCalled.Load()
+	}, 2*time.Second, 10*time.Millisecond)
+	snapshot, err := pipeline.LookupBuild(context.Background(), build.ID)
+	require.NoError(t, err)
+	assert.Empty(t, snapshot.ErrorMessage)
+}
+
+func TestPipeline_StartBuildWithCancelledContext(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := pipeline.StartBuild(ctx, createTestBuild())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, builder.buildCalled.Load())
+}
+
+func TestPipeline_Shutdown(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	builder.delay = 10 * time.Second
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, pipeline.Shutdown(ctx))
+
+	assert.Equal(t, types.BuildStatusFailed, build.Status)
+}
+
+// recordingStore remembers every persisted state and the status stored with
+// each event
+type recordingStore struct {
+	mu           sync.Mutex
+	created      []string
+	statuses     []types.BuildStatus
+	eventStatus  map[types.DeploymentEventType]types.BuildStatus
+	storedEvents int
+	migrations   []types.Migration
+	unfinished   []types.Build
+	changed      map[string]bool // Builds another instance interrupted first
+	relocated    map[string]string
+	deployments  []types.Build
+}
+
+func TestPipeline_BuildTimeEnv(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.config.Deploy.IngressDomain = "apps.example.com"
+	pipeline.config.NodeJS.EnvVars = map[string]string{"NPM_CONFIG_LOGLEVEL": "warn", "VITE_API_URL": "default"}
+
+	build := createTestBuild()
+	build.EnvVars = map[string]string{
+		"VITE_API_URL":      "https://api.example.com",
+		"REACT_APP_SITE":    "https://{{ .Project.Domain }}",
+		"DATABASE_PASSWORD": "hunter2",
+	}
+
+	env, err := pipeline.buildTimeEnv(build)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"NPM_CONFIG_LOGLEVEL": "warn",
+		"VITE_API_URL":        "https://api.example.com",
+		"REACT_APP_SITE":      "https://" + build.ProjectID + ".apps.example.com",
+	}, env)
+
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+	assert.Equal(t, []string{"NPM_CONFIG_LOGLEVEL", "REACT_APP_SITE", "VITE_API_URL"}, build.BuildEnv)
+}
+
+func TestPipeline_ListBuildsInMemory(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	now := time.Now()
+	pipeline.builds["old"] = &types.Build{ID: "old", ProjectID: "shop", StartTime: now.Add(-time.Hour)}
+	pipeline.builds["new"] = &types.Build{
+		ID:        "new",
+		ProjectID: "shop",
+		StartTime: now,
+		Commit:    &types.CommitInfo{Author: "Jane", Branch: "main"},
+		Events:    []types.DeploymentEvent{{Type: types.EventRestarted}},
+	}
+	pipeline.builds["other"] = &types.Build{ID: "other", ProjectID: "blog", StartTime: now}
+
+	page, err := pipeline.ListBuilds(context.Background(), "shop", nil, pagination.Params{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, "new", page.Items[0].ID)
+	assert.Equal(t, "Jane", page.Items[0].Commit.Author)
+	assert.Empty(t, page.Items[0].Events)
+	assert.Equal(t, "old", page.Items[1].ID)
+
+	_, err = pipeline.ListBuilds(context.Background(), "shop", nil, pagination.Params{PageToken: "abc"})
+	assert.ErrorIs(t, err, pagination.ErrInvalidPageToken)
+
+	build, err := pipeline.LookupBuild(context.Background(), "new")
+	require.NoError(t, err)
+	assert.Len(t, build.Events, 1)
+
+	_, err = pipeline.LookupBuild(context.Background(), "missing")
+	assert.ErrorIs(t, err, types.ErrBuildNotFound)
+}
+
+func (s *recordingStore) CreateBuild(_ context.Context, build *types.Build) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.created = append(s.created, build.ID)
+	return nil
+}
+
+func (s *recordingStore) SaveBuild(_ context.Context, build *types.Build, events []types.DeploymentEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = append(s.statuses, build.Status)
+	for _, event := range events {
+		s.eventStatus[event.Type] = build.Status
+	}
+	s.storedEvents += len(events)
+	return nil
+}
+
+func (s *recordingStore) InterruptBuild(ctx context.Context, build *types.Build, events []types.DeploymentEvent) error {
+	s.mu.Lock()
+	changed := s.changed[build.ID]
+	s.mu.Unlock()
+	if changed {
+		return types.ErrBuildChanged
+	}
+	return s.SaveBuild(ctx, build, events)
+}
+
+func (s *recordingStore) ListUnfinishedBuilds(context.Context) ([]types.Build, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]types.Build(nil), s.unfinished...), nil
+}
+
+func (s *recordingStore) TouchBuilds(context.Context, string, time.Time) error {
+	return nil
+}
+
+func (s *recordingStore) RelocateArtifacts(_ context.Context, paths map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.relocated = paths
+	return nil
+}
+
+func (s *recordingStore) GetBuild(context.Context, string) (*types.Build, error) {
+	return nil, types.ErrBuildNotFound
+}
+
+func (s *recordingStore) ListBuilds(context.Context, string, []string, pagination.Params) (*pagination.Page[types.Build], error) {
+	return &pagination.Page[types.Build]{}, nil
+}
+
+func (s *recordingStore) SearchLogs(context.Context, types.LogQuery, pagination.Params) (*pagination.Page[types.LogMatch], error) {
+	return &pagination.Page[types.LogMatch]{}, nil
+}
+
+func (s *recordingStore) ListExpiredPreviews(context.Context, time.Time) ([]types.Build, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) SetPinned(context.Context, string, bool) error {
+	return types.ErrBuildNotFound
+}
+
+func (s *recordingStore) SetAnnotation(context.Context, string, string, []string) error {
+	return nil
+}
+
+func (s *recordingStore) ListPinned(context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) ListCoverage(context.Context, string, string, int) ([]types.Build, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) LatestPerfAudit(context.Context, string, string) (*types.PerfAudit, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) LatestRelease(context.Context, string, string) (*types.Release, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) LatestDeployment(context.Context, string, string) (*types.Build, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) ListDeployments(context.Context) ([]types.Build, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]types.Build(nil), s.deployments...), nil
+}
+
+func (s *recordingStore) LatestFinishedBuild(context.Context, string, string) (*types.Build, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) ListProtectedImages(context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) DeleteProjectBuilds(context.Context, string) error {
+	return nil
+}
+
+func (s *recordingStore) SaveMigration(_ context.Context, migration *types.Migration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.migrations = append(s.migrations, *migration)
+	return nil
+}
+
+func (s *recordingStore) ListMigrations(context.Context) ([]types.Migration, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) AcquireDeployLock(_ context.Context, lock *types.DeployLock) (*types.DeployLock, error) {
+	return lock, nil
+}
+
+func (s *recordingStore) RenewDeployLock(context.Context, string, string, string, time.Time) error {
+	return nil
+}
+
+func (s *recordingStore) ReleaseDeployLock(context.Context, string, string, string) error {
+	return nil
+}
+
+func (s *recordingStore) ListDeployLocks(context.Context, string) ([]types.DeployLock, error) {
+	return nil, nil
+}
+
+func TestPipeline_PersistsBuildState(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	store := &recordingStore{eventStatus: make(map[types.DeploymentEventType]types.BuildStatus)}
+	pipeline.store = store
+	pipeline.storedEvents = make(map[string]int)
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	assert.Equal(t, []string{build.ID}, store.created)
+	assert.False(t, build.StartTime.IsZero())
+	require.NotEmpty(t, store.statuses)
+	assert.Equal(t, types.BuildStatusBuilding, store.statuses[0])
+	assert.Equal(t, types.BuildStatusSuccess, store.statuses[len(store.statuses)-1])
+
+	require.True(t, pipeline.RecordProjectEvent(build.ProjectID, types.EventRestarted, "restarted by alice"))
+	require.True(t, pipeline.RecordProjectEvent(build.ProjectID, types.EventScaled, "scaled to 2"))
+
+	// Each event is stored once, alongside the build state it belongs to
+	assert.Equal(t, 2, store.storedEvents)
+	assert.Equal(t, types.BuildStatusSuccess, store.eventStatus[types.EventRestarted])
+}
+
+// recordingNotifier remembers the lifecycle events it was sent
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []types.LifecycleEvent
+}
+
+func (n *recordingNotifier) Notify(event types.LifecycleEvent, _ *types.Build, _ string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+}
+
+func TestPipeline_NotifiesLifecycleEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(*mockBuilder, *mockDeployer)
+		events []types.LifecycleEvent
+	}{
+		{
+			name: "successful deploy",
+			events: []types.LifecycleEvent{
+				types.LifecycleBuildStarted,
+				types.LifecycleBuildSucceeded,
+				types.LifecycleDeploySucceeded,
+			},
+		},
+		{
+			name:  "build failure",
+			setup: func(b *mockBuilder, _ *mockDeployer) { b.shouldFail = true },
+			events: []types.LifecycleEvent{
+				types.LifecycleBuildStarted,
+				types.LifecycleBuildFailed,
+			},
+		},
+		{
+			name:  "deploy failure",
+			setup: func(_ *mockBuilder, d *mockDeployer) { d.shouldFail = true },
+			events: []types.LifecycleEvent{
+				types.LifecycleBuildStarted,
+				types.LifecycleBuildSucceeded,
+				types.LifecycleDeployFailed,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, builder, deployer, _ := setupTestPipeline(t)
+			notifier := &recordingNotifier{}
+			pipeline.notifier = notifier
+			if tt.setup != nil {
+				tt.setup(builder, deployer)
+			}
+
+			require.NoError(t, pipeline.StartBuild(context.Background(), createTestBuild()))
+			require.NoError(t, pipeline.Shutdown(context.Background()))
+
+			assert.Equal(t, tt.events, notifier.events)
+		})
+	}
+}
+
+func TestPipeline_NotifiesDeploymentEvents(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	notifier := &recordingNotifier{}
+	pipeline.notifier = notifier
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+	notifier.events = nil
+
+	require.True(t, pipeline.RecordProjectEvent(build.ProjectID, types.EventScaled, "scaled to 2"))
+	assert.Equal(t, []types.LifecycleEvent{types.LifecycleDeployScaled}, notifier.events)
+}
+
+func TestPipeline_Provenance(t *testing.T) {
+	artifact := filepath.Join(testArtifactsDir, "test-artifact.tar.gz")
+	require.NoError(t, os.WriteFile(artifact, []byte("artifact"), 0644))
+	t.Cleanup(func() {
+		os.Remove(artifact)
+		os.Remove(artifact + ".intoto.json")
+	})
+
+	t.Run("provenance is recorded", func(t *testing.T) {
+		pipeline, _, deployer, _ := setupTestPipeline(t)
+		pipeline.attestor = provenance.NewAttestor(&config.ProvenanceConfig{Enabled: true}, zap.NewNop())
+
+		build := createTestBuild()
+		require.NoError(t, pipeline.StartBuild(context.Background(), build))
+		require.NoError(t, pipeline.Shutdown(context.Background()))
+
+		assert.Equal(t, types.BuildStatusSuccess, build.Status)
+		require.NotNil(t, build.Provenance)
+		assert.FileExists(t, build.Provenance.Path)
+		assert.NotEmpty(t, build.Provenance.InputsDigest)
+		assert.True(t, deployer.deployCalled.Load())
+	})
+
+	t.Run("unsigned builds are not deployed when verifying", func(t *testing.T) {
+		pipeline, _, deployer, _ := setupTestPipeline(t)
+		pipeline.attestor = provenance.NewAttestor(&config.ProvenanceConfig{Enabled: true, Verify: true}, zap.NewNop())
+
+		build := createTestBuild()
+		require.NoError(t, pipeline.StartBuild(context.Background(), build))
+		require.NoError(t, pipeline.Shutdown(context.Background()))
+
+		assert.Equal(t, types.BuildStatusFailed, build.Status)
+		assert.Contains(t, build.ErrorMessage, provenance.ErrUnsigned.Error())
+		assert.False(t, deployer.deployCalled.Load())
+	})
+}
+
+func TestPipeline_DiagnosesFailedBuilds(t *testing.T) {
+	pipeline, mock, _, _ := setupTestPipeline(t)
+	mock.buildErr = fmt.Errorf("failed to run tests: %w", &builder.OutputError{
+		Err:    errors.New("docker build error: The command '/bin/sh -c npm test' returned a non-zero code: 1"),
+		Output: []string{"Step 7/12 : RUN npm test", "Error: Cannot find module 'supertest'"},
+	})
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	assert.Equal(t, types.BuildStatusFailed, build.Status)
+	require.NotNil(t, build.Diagnosis)
+	assert.Equal(t, diagnose.RuleMissingModule, build.Diagnosis.Rule)
+	assert.Equal(t, "Error: Cannot find module 'supertest'", build.Diagnosis.Line)
+	assert.NotContains(t, build.ErrorMessage, "supertest", "output is not part of the error message")
+}
+
+func TestPipeline_DiagnosesImagePullFailures(t *testing.T) {
+	pipeline, _, mock, _ := setupTestPipeline(t)
+	mock.deployErr = fmt.Errorf("%w: %w", deployer.ErrRolloutFailed, &deployer.ImagePullError{
+		Pod:      "test-project-abc",
+		Image:    "registry.local/test-project:abc",
+		Reason:   "ErrImagePull",
+		Message:  `Failed to pull image "registry.local/test-project:abc": rpc error: code = NotFound desc = manifest unknown`,
+		Statuses: []string{"container test-project: waiting (ErrImagePull): manifest unknown"},
+		Events:   []string{`Failed: Failed to pull image "registry.local/test-project:abc": rpc error: code = NotFound desc = manifest unknown`},
+	})
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	assert.Equal(t, types.BuildStatusFailed, build.Status)
+	require.NotNil(t, build.Diagnosis)
+	assert.Equal(t, diagnose.RuleImageNotFound, build.Diagnosis.Rule)
+	assert.Len(t, build.Diagnosis.Details, 2)
+}
+
+func TestPipeline_DiagnosesOutOfMemoryBuilds(t *testing.T) {
+	pipeline, mock, _, _ := setupTestPipeline(t)
+	mock.buildErr = &builder.OutOfMemoryError{Memory: "2Gi", HeapMB: 1536, Err: &builder.OutputError{
+		Err:    errors.New("docker build error: The command '/bin/sh -c npm run build' returned a non-zero code: 137"),
+		Output: []string{"Step 12/14 : RUN npm run build", "Creating an optimized production build..."},
+	}}
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	assert.Equal(t, types.BuildStatusFailed, build.Status)
+	assert.Contains(t, build.ErrorMessage, "OUT_OF_MEMORY: build ran out of memory with a memory limit of 2Gi")
+	require.NotNil(t, build.Diagnosis)
+	assert.Equal(t, diagnose.RuleOutOfMemory, build.Diagnosis.Rule)
+	assert.Equal(t, []string{"memory limit: 2Gi", "node heap limit: 1536 MB"}, build.Diagnosis.Details)
+}
+
+func TestPipeline_DeployPhaseTimeout(t *testing.T) {
+	pipeline, _, mock, _ := setupTestPipeline(t)
+	pipeline.config.Timeouts.Deploy = 1
+	mock.delay = time.Minute
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.Eventually(t, func() bool {
+		pipeline.mu.RLock()
+		defer pipeline.mu.RUnlock()
+		return build.Status == types.BuildStatusFailed
+	}, 3*time.Second, 10*time.Millisecond)
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	assert.Contains(t, build.ErrorMessage, "deploy phase timed out after 1s")
+	assert.True(t, mock.rollbackCalled.Load())
+	last := build.Events[len(build.Events)-1]
+	assert.Equal(t, types.EventPhaseTimedOut, last.Type)
+	assert.Equal(t, "deploy", last.Hook)
+}
+
+func TestPipeline_RecordsStageTimings(t *testing.T) {
+	pipeline, mock, _, _ := setupTestPipeline(t)
+	started := time.Now()
+	mock.stages = []types.StageTiming{
+		{Stage: types.StageFetch, StartedAt: started, Duration: time.Second},
+		{Stage: types.StageBuild, StartedAt: started.Add(time.Second), Duration: time.Minute},
+	}
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	require.Equal(t, types.BuildStatusSuccess, build.Status)
+	var stages []types.Stage
+	for _, stage := range build.Stages {
+		stages = append(stages, stage.Stage)
+	}
+	assert.Equal(t, []types.Stage{types.StageFetch, types.StageBuild, types.StageScan, types.StageDeploy, types.StageVerify}, stages)
+	assert.Equal(t, time.Minute, build.Stages[1].Duration)
+
+	var out strings.Builder
+	pipeline.Metrics().WriteMetrics(&out)
+	assert.Contains(t, out.String(), "chef_build_stage_duration_seconds_bucket{stage=\"build\",le=\"30\"} 0\n")
+	assert.Contains(t, out.String(), "chef_build_stage_duration_seconds_bucket{stage=\"build\",le=\"60\"} 1\n")
+	assert.Contains(t, out.String(), "chef_build_stage_duration_seconds_count{stage=\"deploy\"} 1\n")
+}
+
+func TestPipeline_PurgeProject(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.config.BuildDir = t.TempDir()
+	pipeline.config.ArtifactsDir = "" // Kept in <build_dir>/artifacts
+
+	for _, build := range []*types.Build{
+		{ID: "purged-1", ProjectID: "shop", Status: types.BuildStatusSuccess},
+		{ID: "kept-1", ProjectID: "blog", Status: types.BuildStatusSuccess},
+	} {
+		pipeline.builds[build.ID] = build
+		require.NoError(t, os.MkdirAll(filepath.Join(pipeline.config.BuildDir, "artifacts", build.ID), 0755))
+	}
+
+	require.NoError(t, pipeline.PurgeProject(context.Background(), "shop"))
+
+	_, err := pipeline.GetBuild("purged-1")
+	assert.Error(t, err)
+	assert.NoDirExists(t, filepath.Join(pipeline.config.BuildDir, "artifacts", "purged-1"))
+
+	_, err = pipeline.GetBuild("kept-1")
+	assert.NoError(t, err)
+	assert.DirExists(t, filepath.Join(pipeline.config.BuildDir, "artifacts", "kept-1"))
+}
+
+func TestPipeline_SetPinned(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.builds["b1"] = &types.Build{ID: "b1", ProjectID: "shop", Status: types.BuildStatusSuccess}
+	pipeline.builds["b2"] = &types.Build{ID: "b2", ProjectID: "shop", Status: types.BuildStatusSuccess}
+
+	require.NoError(t, pipeline.SetPinned(context.Background(), "b1", true))
+	pinned, err := pipeline.PinnedBuilds(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"b1": true}, pinned)
+
+	require.NoError(t, pipeline.SetPinned(context.Background(), "b1", false))
+	pinned, err = pipeline.PinnedBuilds(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, pinned)
+
+	assert.ErrorIs(t, pipeline.SetPinned(context.Background(), "missing", true), types.ErrBuildNotFound)
+}
+
+func TestPipeline_MigrateArtifacts(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.config.BuildDir = t.TempDir()
+	pipeline.config.ArtifactsDir = filepath.Join(t.TempDir(), "artifacts")
+	store := &recordingStore{}
+	pipeline.store = store
+
+	legacy := filepath.Join(pipeline.config.BuildDir, "artifacts", "b1")
+	require.NoError(t, os.MkdirAll(legacy, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(legacy, "b1.tar.gz"), []byte("artifact"), 0644))
+
+	moved, err := pipeline.MigrateArtifacts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	artifact := filepath.Join(pipeline.config.ArtifactsDir, "b1", "b1.tar.gz")
+	assert.FileExists(t, artifact)
+	assert.Equal(t, map[string]string{filepath.Join(legacy, "b1.tar.gz"): artifact}, store.relocated)
+}
+
+func TestCleanupManager_KeepsPinnedBuilds(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.config.BuildDir = t.TempDir()
+	pipeline.config.ArtifactsDir = "" // Kept in <build_dir>/artifacts
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, id := range []string{"pinned-1", "old-1", "recent-1"} {
+		pipeline.builds[id] = &types.Build{ID: id, ProjectID: "shop", Status: types.BuildStatusSuccess, Pinned: id == "pinned-1"}
+		for _, kind := range []string{"builds", "artifacts"} {
+			dir := filepath.Join(pipeline.config.BuildDir, kind, id)
+			require.NoError(t, os.MkdirAll(dir, 0755))
+			if id != "recent-1" {
+				require.NoError(t, os.Chtimes(dir, old, old))
+			}
+		}
+	}
+
+	cm := NewCleanupManager(pipeline.config, pipeline, zap.NewNop())
+	require.NoError(t, cm.CleanupOldBuilds(context.Background(), 24*time.Hour))
+
+	for _, kind := range []string{"builds", "artifacts"} {
+		assert.DirExists(t, filepath.Join(pipeline.config.BuildDir, kind, "pinned-1"))
+		assert.DirExists(t, filepath.Join(pipeline.config.BuildDir, kind, "recent-1"))
+		assert.NoDirExists(t, filepath.Join(pipeline.config.BuildDir, kind, "old-1"))
+	}
+}
+
+func TestPipeline_ProtectedImages(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	started := time.Now()
+	for _, build := range []*types.Build{
+		{ID: "old", ProjectID: "shop", Status: types.BuildStatusSuccess, ImageID: "chef-shop:old", StartTime: started.Add(-2 * time.Hour)},
+		{ID: "pinned", ProjectID: "shop", Status: types.BuildStatusSuccess, ImageID: "chef-shop:pinned", StartTime: started.Add(-3 * time.Hour), Pinned: true},
+		{ID: "current", ProjectID: "shop", Status: types.BuildStatusSuccess, ImageID: "chef-shop:current", StartTime: started.Add(-time.Hour)},
+		{ID: "failed", ProjectID: "shop", Status: types.BuildStatusFailed, ImageID: "chef-shop:failed", StartTime: started},
+		{ID: "preview", ProjectID: "shop", Status: types.BuildStatusSuccess, ImageID: "chef-shop:preview", StartTime: started,
+			Preview: &types.Preview{Name: "preview-shop"}},
+	} {
+		pipeline.builds[build.ID] = build
+	}
+
+	protected, err := pipeline.ProtectedImages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"chef-shop:pinned":  true,
+		"chef-shop:current": true,
+		"chef-shop:preview": true,
+	}, protected)
+}
+
+func TestPipeline_LiveBuilds(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.store = &recordingStore{unfinished: []types.Build{{ID: "replica", Status: types.BuildStatusBuilding}}}
+	for _, build := range []*types.Build{
+		{ID: "building", Status: types.BuildStatusBuilding},
+		{ID: "deploying", Status: types.BuildStatusSuccess, Deploying: true},
+		{ID: "failed", Status: types.BuildStatusFailed},
+	} {
+		pipeline.builds[build.ID] = build
+	}
+
+	live, err := pipeline.LiveBuilds(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"replica": true, "building": true, "deploying": true}, live)
+}
+
+// eventDeployer records rollout events while deploying, as the kubernetes
+// deployer does
+type eventDeployer struct {
+	mockDeployer
+	events int
+}
+
+func (d *eventDeployer) Deploy(ctx context.Context, build *types.Build) error {
+	for i := 1; i <= d.events; i++ {
+		types.RecordEvent(ctx, build, types.EventRolloutProgress, "", fmt.Sprintf("%d of %d replicas updated", i, d.events))
+		time.Sleep(time.Millisecond)
+	}
+	return d.mockDeployer.Deploy(ctx, build)
+}
+
+// The build goroutine, deployers and hooks change the build while persist,
+// notify and lookups read it; go test -race fails unless every change
+// takes the pipeline's lock
+func TestPipeline_BuildChangesAreLocked(t *testing.T) {
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hookServer.Close()
+
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	pipeline.store = &recordingStore{eventStatus: make(map[types.DeploymentEventType]types.BuildStatus)}
+	pipeline.storedEvents = make(map[string]int)
+	pipeline.notifier = &recordingNotifier{}
+	target := &eventDeployer{events: 20}
+	pipeline.deployer = target
+	pipeline.hooks = deployer.NewHookRunner(&pipeline.config.Deploy, target, zap.NewNop())
+	builder.hooks = []types.Hook{{Name: "announce", Type: types.HookTypeHTTP, URL: hookServer.URL}}
+
+	build := createTestBuild()
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_, _ = pipeline.LookupBuild(context.Background(), build.ID)
+				pipeline.persist(build)
+			}
+		}()
+	}
+
+	var snapshot *types.Build
+	require.Eventually(t, func() bool {
+		snapshot, _ = pipeline.LookupBuild(context.Background(), build.ID)
+		return snapshot.Status == types.BuildStatusSuccess && !snapshot.Deploying
+	}, 5*time.Second, 10*time.Millisecond)
+	close(done)
+	readers.Wait()
+
+	var progress, hooks int
+	for _, event := range snapshot.Events {
+		switch event.Type {
+		case types.EventRolloutProgress:
+			progress++
+		case types.EventHookSucceeded:
+			hooks++
+		}
+	}
+	assert.Equal(t, 20, progress)
+	assert.Equal(t, 1, hooks)
+}
+
+func TestPipeline_DeploysToEnvironmentTarget(t *testing.T) {
+	p, _, defaultDeployer, _ := setupTestPipeline(t)
+	production := &mockDeployer{}
+	p.targets = map[string]deployTarget{"production": {deployer: production}}
+
+	build := createTestBuild()
+	build.Environment = "production"
+	require.NoError(t, p.deploy(context.Background(), build))
+	assert.True(t, production.deployCalled.Load())
+	assert.False(t, defaultDeployer.deployCalled.Load())
+
+	build = createTestBuild()
+	build.Environment = "staging"
+	require.NoError(t, p.deploy(context.Background(), build))
+	assert.True(t, defaultDeployer.deployCalled.Load(), "environments without a target use the default one")
+}
+
+func TestPipeline_DescribeTargets(t *testing.T) {
+	p, _, _, _ := setupTestPipeline(t)
+	p.config.Deploy.Platform = "kubernetes"
+	p.config.Deploy.IngressDomain = "apps.example.com"
+	p.config.Deploy.Targets = map[string]config.DeployConfig{
+		"production": {Platform: "static", StaticPath: t.TempDir(), MaxDeploySize: 1024},
+		"staging":    {Platform: "static", IngressDomain: "staging.example.com"},
+	}
+	// A file where staging's directory would be
+	blocked := filepath.Join(t.TempDir(), "static")
+	require.NoError(t, os.WriteFile(blocked, nil, 0644))
+	staging := p.config.Deploy.Target("staging")
+	staging.StaticPath = filepath.Join(blocked, "sites")
+	p.targets = map[string]deployTarget{
+		"staging":    {deployer: deployer.NewStaticDeployer(staging, zap.NewNop())},
+		"production": {deployer: deployer.NewStaticDeployer(p.config.Deploy.Target("production"), zap.NewNop())},
+	}
+
+	targets := p.DescribeTargets(context.Background())
+	require.Len(t, targets, 3)
+	fallback, production, staged := targets[0], targets[1], targets[2]
+
+	assert.Equal(t, "", fallback.Environment)
+	assert.Equal(t, "kubernetes", fallback.Platform)
+	assert.Equal(t, "apps.example.com", fallback.Domain)
+	assert.True(t, fallback.Healthy(), "the mock deployer has no checks")
+	assert.Empty(t, fallback.Features)
+
+	assert.Equal(t, "production", production.Environment)
+	assert.Equal(t, "static", production.Platform)
+	assert.True(t, production.Healthy())
+	require.Len(t, production.Checks, 1)
+	assert.Equal(t, "static_path", production.Checks[0].Name)
+	assert.Equal(t, int64(1024), production.MaxDeploySize)
+	assert.Zero(t, production.MaxReplicas)
+	assert.Equal(t, []string{"remove"}, production.Features)
+
+	assert.Equal(t, "staging.example.com", staged.Domain)
+	assert.False(t, staged.Healthy())
+	assert.Contains(t, staged.Checks[0].Message, "not writable")
+}
+
 func TestMain(m *testing.M) {
 	// Setup
-	if err := os.MkdirAll("/tmp/test-source", 0755); err != nil {
+	if err := os.MkdirAll(testSourceDir, 0755); err != nil {
 		panic(err)
 	}
 
 	// Create dummy package.json
-	if err := os.WriteFile("/tmp/test-source/package.json", []byte("{}"), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(testSourceDir, "package.json"), []byte("{}"), 0644); err != nil {
 		panic(err)
 	}
 
 	// Create test directories
 	testDirs := []string{
-		"/tmp/test-builds",
-		"/tmp/test-artifacts",
-		"/tmp/test-cache",
+		testBuildDir,
+		testArtifactsDir,
+		testCacheDir,
 	}
 	for _, dir := range testDirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -361,7 +1268,7 @@ func TestMain(m *testing.M) {
 	code := m.Run()
 
 	// Cleanup
-	cleanupDirs := append(testDirs, "/tmp/test-source")
+	cleanupDirs := append(testDirs, testSourceDir)
 	for _, dir := range cleanupDirs {
 		os.RemoveAll(dir)
 	}
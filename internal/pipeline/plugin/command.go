@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// maxOutput bounds the output of a failed plugin quoted in its error
+const maxOutput = 1024
+
+// request is what external plugins receive
+type request struct {
+	Stage Stage        `json:"stage"`
+	Build *types.Build `json:"build"`
+}
+
+// command runs an executable per invocation
+type command struct {
+	args []string
+}
+
+func newCommand(cfg config.PluginConfig, _ *zap.Logger) (Plugin, error) {
+	if len(cfg.Command) == 0 {
+		return nil, fmt.Errorf("command is required")
+	}
+	return &command{args: cfg.Command}, nil
+}
+
+func (c *command) Run(ctx context.Context, stage Stage, build *types.Build) error {
+	payload, err := json.Marshal(request{Stage: stage, Build: build})
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"CHEF_PLUGIN_STAGE="+string(stage),
+		"CHEF_BUILD_ID="+build.ID,
+		"CHEF_PROJECT_ID="+build.ProjectID,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, truncate(output))
+	}
+	return nil
+}
+
+func truncate(output []byte) string {
+	s := strings.TrimSpace(string(output))
+	if len(s) > maxOutput {
+		s = s[:maxOutput] + "..."
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// httpPlugin posts each invocation to a URL
+type httpPlugin struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTP(cfg config.PluginConfig, _ *zap.Logger) (Plugin, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	return &httpPlugin{url: cfg.URL, client: &http.Client{}}, nil
+}
+
+func (h *httpPlugin) Run(ctx context.Context, stage Stage, build *types.Build) error {
+	payload, err := json.Marshal(request{Stage: stage, Build: build})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutput+1))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, truncate(body))
+	}
+	return nil
+}
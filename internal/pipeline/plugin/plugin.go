@@ -0,0 +1,203 @@
+// Package plugin runs custom code at fixed points of the pipeline, such as
+// a scanner before deploys or a ticket update after them, without changes
+// to the pipeline itself.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const defaultTimeout = 60 * time.Second
+
+// Stage is a point of the pipeline plugins run at
+type Stage string
+
+const (
+	PreBuild   Stage = "pre_build"   // Before the builder starts
+	PostBuild  Stage = "post_build"  // After the artifact is built and validated
+	PreDeploy  Stage = "pre_deploy"  // After policies pass, before anything is deployed
+	PostDeploy Stage = "post_deploy" // After the deploy and its hooks succeeded
+)
+
+// Stages lists every stage in pipeline order
+var Stages = []Stage{PreBuild, PostBuild, PreDeploy, PostDeploy}
+
+// Plugin is invoked at the stages it is configured for. The build is a
+// snapshot, changes to it are not kept. The context carries the plugin's
+// timeout and is cancelled with the build.
+type Plugin interface {
+	Run(ctx context.Context, stage Stage, build *types.Build) error
+}
+
+// Factory creates a plugin from its config
+type Factory func(cfg config.PluginConfig, log *zap.Logger) (Plugin, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{
+		"command": newCommand,
+		"http":    newHTTP,
+	}
+)
+
+// Register makes a built-in plugin type available to the config. It
+// panics when the type is already registered.
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, exists := factories[typ]; exists {
+		panic("plugin: type registered twice: " + typ)
+	}
+	factories[typ] = factory
+}
+
+// Types lists the plugin types that may be configured
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func factory(typ string) (Factory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	f, ok := factories[typ]
+	return f, ok
+}
+
+// Validate checks plugin definitions before the server starts
+func Validate(cfgs []config.PluginConfig) error {
+	names := make(map[string]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return fmt.Errorf("plugin name is required")
+		}
+		if names[cfg.Name] {
+			return fmt.Errorf("duplicate plugin name: %s", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		if _, ok := factory(cfg.Type); !ok {
+			return fmt.Errorf("plugin %s: unknown type %q, expected one of %v", cfg.Name, cfg.Type, Types())
+		}
+		if len(cfg.Stages) == 0 {
+			return fmt.Errorf("plugin %s: at least one stage is required", cfg.Name)
+		}
+		for _, stage := range cfg.Stages {
+			if !validStage(Stage(stage)) {
+				return fmt.Errorf("plugin %s: unknown stage %q, expected one of %v", cfg.Name, stage, Stages)
+			}
+		}
+		if cfg.Timeout < 0 {
+			return fmt.Errorf("plugin %s: timeout must not be negative", cfg.Name)
+		}
+	}
+	return nil
+}
+
+func validStage(stage Stage) bool {
+	for _, s := range Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Failure is a plugin run that failed
+type Failure struct {
+	Plugin   string
+	Required bool
+	Err      error
+}
+
+type configured struct {
+	config.PluginConfig
+	plugin Plugin
+	stages map[Stage]bool
+}
+
+// Runner invokes the configured plugins in order
+type Runner struct {
+	plugins []configured
+	log     *zap.Logger
+}
+
+func NewRunner(cfgs []config.PluginConfig, log *zap.Logger) (*Runner, error) {
+	if err := Validate(cfgs); err != nil {
+		return nil, err
+	}
+
+	r := &Runner{log: log}
+	for _, cfg := range cfgs {
+		create, _ := factory(cfg.Type)
+		p, err := create(cfg, log.With(zap.String("plugin", cfg.Name)))
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", cfg.Name, err)
+		}
+		stages := make(map[Stage]bool, len(cfg.Stages))
+		for _, stage := range cfg.Stages {
+			stages[Stage(stage)] = true
+		}
+		r.plugins = append(r.plugins, configured{PluginConfig: cfg, plugin: p, stages: stages})
+	}
+	return r, nil
+}
+
+// Run invokes the plugins of stage and returns those that failed. The
+// error is set when a required plugin failed; later plugins are skipped.
+func (r *Runner) Run(ctx context.Context, stage Stage, build *types.Build) ([]Failure, error) {
+	var failures []Failure
+	for _, p := range r.plugins {
+		if !p.stages[stage] {
+			continue
+		}
+
+		err := r.run(ctx, p, stage, build)
+		if err == nil {
+			continue
+		}
+		failures = append(failures, Failure{Plugin: p.Name, Required: p.Required, Err: err})
+		if p.Required {
+			return failures, fmt.Errorf("plugin %s failed at %s: %w", p.Name, stage, err)
+		}
+		r.log.Warn("plugin failed",
+			zap.String("build_id", build.ID),
+			zap.String("plugin", p.Name),
+			zap.String("stage", string(stage)),
+			zap.Error(err))
+	}
+	return failures, nil
+}
+
+// run gives the plugin its own deadline and turns a panic into an error so
+// a faulty plugin cannot take the server down
+func (r *Runner) run(ctx context.Context, p configured, stage Stage, build *types.Build) (err error) {
+	timeout := defaultTimeout
+	if p.Timeout > 0 {
+		timeout = time.Duration(p.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("plugin panicked: %v", recovered)
+		}
+	}()
+	return p.plugin.Run(ctx, stage, build)
+}
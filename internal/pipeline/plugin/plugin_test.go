@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// funcPlugin adapts a function to the Plugin interface
+type funcPlugin func(ctx context.Context, stage Stage, build *types.Build) error
+
+func (f funcPlugin) Run(ctx context.Context, stage Stage, build *types.Build) error {
+	return f(ctx, stage, build)
+}
+
+var calls []string
+
+func init() {
+	Register("test", func(cfg config.PluginConfig, _ *zap.Logger) (Plugin, error) {
+		return funcPlugin(func(_ context.Context, stage Stage, _ *types.Build) error {
+			calls = append(calls, cfg.Name+"@"+string(stage))
+			switch cfg.Options["behavior"] {
+			case "fail":
+				return errors.New("scanner found issues")
+			case "panic":
+				panic("nil map")
+			}
+			return nil
+		}), nil
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins []config.PluginConfig
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			plugins: []config.PluginConfig{{Name: "scan", Type: "command", Command: []string{"scan"}, Stages: []string{"pre_deploy"}}},
+		},
+		{
+			name:    "unknown type",
+			plugins: []config.PluginConfig{{Name: "jira", Type: "jira", Stages: []string{"post_deploy"}}},
+			wantErr: `plugin jira: unknown type "jira"`,
+		},
+		{
+			name:    "unknown stage",
+			plugins: []config.PluginConfig{{Name: "scan", Type: "test", Stages: []string{"pre_test"}}},
+			wantErr: `plugin scan: unknown stage "pre_test"`,
+		},
+		{
+			name:    "no stages",
+			plugins: []config.PluginConfig{{Name: "scan", Type: "test"}},
+			wantErr: "plugin scan: at least one stage is required",
+		},
+		{
+			name: "duplicate name",
+			plugins: []config.PluginConfig{
+				{Name: "scan", Type: "test", Stages: []string{"pre_build"}},
+				{Name: "scan", Type: "test", Stages: []string{"post_build"}},
+			},
+			wantErr: "duplicate plugin name: scan",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.plugins)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestRunner_Run(t *testing.T) {
+	runner, err := NewRunner([]config.PluginConfig{
+		{Name: "tickets", Type: "test", Stages: []string{"post_deploy"}},
+		{Name: "flaky", Type: "test", Stages: []string{"pre_deploy"}, Options: map[string]string{"behavior": "fail"}},
+		{Name: "broken", Type: "test", Stages: []string{"pre_deploy"}, Options: map[string]string{"behavior": "panic"}},
+		{Name: "scanner", Type: "test", Stages: []string{"pre_deploy"}, Options: map[string]string{"behavior": "fail"}, Required: true},
+		{Name: "after", Type: "test", Stages: []string{"pre_deploy"}},
+	}, zap.NewNop())
+	require.NoError(t, err)
+	build := &types.Build{ID: "build-1"}
+
+	calls = nil
+	failures, err := runner.Run(context.Background(), PreDeploy, build)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "plugin scanner failed at pre_deploy")
+	assert.Equal(t, []string{"flaky@pre_deploy", "broken@pre_deploy", "scanner@pre_deploy"}, calls,
+		"plugins after a failed required one are skipped")
+	require.Len(t, failures, 3)
+	assert.Contains(t, failures[1].Err.Error(), "plugin panicked")
+	assert.True(t, failures[2].Required)
+
+	calls = nil
+	failures, err = runner.Run(context.Background(), PostDeploy, build)
+	require.NoError(t, err)
+	assert.Empty(t, failures)
+	assert.Equal(t, []string{"tickets@post_deploy"}, calls)
+}
+
+func TestCommandPlugin(t *testing.T) {
+	p, err := newCommand(config.PluginConfig{Command: []string{"sh", "-c", `grep -q '"id":"build-1"' && test "$CHEF_PLUGIN_STAGE" = post_build`}}, zap.NewNop())
+	require.NoError(t, err)
+	assert.NoError(t, p.Run(context.Background(), PostBuild, &types.Build{ID: "build-1"}))
+
+	p, err = newCommand(config.PluginConfig{Command: []string{"sh", "-c", "echo 2 critical findings; exit 1"}}, zap.NewNop())
+	require.NoError(t, err)
+	err = p.Run(context.Background(), PreDeploy, &types.Build{ID: "build-1"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 critical findings")
+}
+
+func TestHTTPPlugin(t *testing.T) {
+	var got request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		if got.Build.ProjectID == "blocked" {
+			http.Error(w, "ticket is not approved", http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	p, err := newHTTP(config.PluginConfig{URL: server.URL}, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, p.Run(context.Background(), PostDeploy, &types.Build{ID: "build-1", ProjectID: "shop"}))
+	assert.Equal(t, PostDeploy, got.Stage)
+	assert.Equal(t, "build-1", got.Build.ID)
+
+	err = p.Run(context.Background(), PreDeploy, &types.Build{ID: "build-2", ProjectID: "blocked"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected status 409: ticket is not approved")
+}
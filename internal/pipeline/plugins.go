@@ -0,0 +1,35 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/elskow/chef-infra/internal/pipeline/plugin"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// runPlugins invokes the plugins of stage with a snapshot of the build.
+// Env vars are left out since they may hold secrets. Failures are recorded
+// as build events and a failed required plugin fails the build.
+func (p *Pipeline) runPlugins(ctx context.Context, stage plugin.Stage, build *types.Build) error {
+	if p.plugins == nil {
+		return nil
+	}
+
+	p.mu.RLock()
+	snapshot := *build
+	snapshot.Events = append([]types.DeploymentEvent(nil), build.Events...)
+	snapshot.EnvVars = nil
+	snapshot.CancelFunc = nil
+	p.mu.RUnlock()
+
+	failures, err := p.plugins.Run(ctx, stage, &snapshot)
+	if len(failures) > 0 {
+		p.updateBuild(build, func(b *types.Build) {
+			for _, failure := range failures {
+				b.AddEvent(types.EventPluginFailed, failure.Plugin, string(stage)+": "+failure.Err.Error())
+			}
+		})
+		p.persist(build)
+	}
+	return err
+}
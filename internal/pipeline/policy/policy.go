@@ -0,0 +1,201 @@
+// Package policy evaluates the rules a build has to pass before it is
+// deployed.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+var ErrDenied = errors.New("deploy blocked by policy")
+
+// Rule names as recorded in policy results
+const (
+	RuleAllowedRegistries  = "allowed_registries"
+	RuleAllowedBaseImages  = "allowed_base_images"
+	RuleMaxImageSize       = "max_image_size"
+	RuleMaxVulnerabilities = "max_vulnerabilities"
+	RuleRequiredApprovals  = "required_approvals"
+)
+
+// localRegistry stands for images that were never pushed
+const localRegistry = "local"
+
+// Scanner counts an image's vulnerabilities by severity
+type Scanner interface {
+	Scan(ctx context.Context, image string) (map[string]int, error)
+}
+
+// Engine evaluates the configured rules
+type Engine struct {
+	config  *config.PolicyConfig
+	scanner Scanner
+	log     *zap.Logger
+}
+
+func NewEngine(cfg *config.PolicyConfig, log *zap.Logger) *Engine {
+	return &Engine{config: cfg, scanner: NewTrivyScanner(), log: log}
+}
+
+// Applies reports whether build is subject to the rules
+func (e *Engine) Applies(build *types.Build) bool {
+	if !e.config.Enabled {
+		return false
+	}
+	if len(e.config.Environments) == 0 {
+		return true
+	}
+	for _, env := range e.config.Environments {
+		if env == build.Environment {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate runs every configured rule against build and returns all
+// results. The error wraps ErrDenied and names the failed rules when any
+// failed. Previews skip the approval rule since they are how a build is
+// reviewed. Scan results are stored on build, which should be a snapshot
+// the caller copies them from.
+func (e *Engine) Evaluate(ctx context.Context, build *types.Build, preview bool) ([]types.PolicyResult, error) {
+	var results []types.PolicyResult
+	if len(e.config.AllowedRegistries) > 0 {
+		results = append(results, e.allowedRegistries(build))
+	}
+	if len(e.config.AllowedBaseImages) > 0 {
+		results = append(results, e.allowedBaseImages(build))
+	}
+	if e.config.MaxImageSize > 0 {
+		results = append(results, e.maxImageSize(build))
+	}
+	if len(e.config.MaxVulnerabilities) > 0 {
+		results = append(results, e.maxVulnerabilities(ctx, build))
+	}
+	if e.config.RequiredApprovals > 0 && !preview {
+		results = append(results, e.requiredApprovals(build))
+	}
+
+	var failed []string
+	for _, result := range results {
+		if !result.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Rule, result.Message))
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%w: %s", ErrDenied, strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+func (e *Engine) allowedRegistries(build *types.Build) types.PolicyResult {
+	result := types.PolicyResult{Rule: RuleAllowedRegistries}
+	for _, allowed := range e.config.AllowedRegistries {
+		if allowed == localRegistry && !strings.Contains(build.ImageID, "/") {
+			result.Passed = true
+			return result
+		}
+		if strings.HasPrefix(build.ImageID, strings.TrimSuffix(allowed, "/")+"/") {
+			result.Passed = true
+			return result
+		}
+	}
+	result.Message = fmt.Sprintf("image %s is not from an allowed registry", build.ImageID)
+	return result
+}
+
+func (e *Engine) allowedBaseImages(build *types.Build) types.PolicyResult {
+	result := types.PolicyResult{Rule: RuleAllowedBaseImages}
+	if len(build.BaseImages) == 0 {
+		result.Message = "base images are unknown"
+		return result
+	}
+	for _, image := range build.BaseImages {
+		if !matchesAny(image, e.config.AllowedBaseImages) {
+			result.Message = fmt.Sprintf("base image %s is not allowed", image)
+			return result
+		}
+	}
+	result.Passed = true
+	return result
+}
+
+func (e *Engine) maxImageSize(build *types.Build) types.PolicyResult {
+	result := types.PolicyResult{Rule: RuleMaxImageSize}
+	switch {
+	case build.ImageSize == 0:
+		result.Message = "image size is unknown"
+	case build.ImageSize > e.config.MaxImageSize:
+		result.Message = fmt.Sprintf("image is %d bytes, the limit is %d", build.ImageSize, e.config.MaxImageSize)
+	default:
+		result.Passed = true
+	}
+	return result
+}
+
+func (e *Engine) maxVulnerabilities(ctx context.Context, build *types.Build) types.PolicyResult {
+	result := types.PolicyResult{Rule: RuleMaxVulnerabilities}
+	if build.Vulnerabilities == nil {
+		counts, err := e.scanner.Scan(ctx, build.ImageID)
+		if err != nil {
+			e.log.Warn("vulnerability scan failed",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+			result.Message = "image could not be scanned"
+			return result
+		}
+		build.Vulnerabilities = counts
+	}
+
+	severities := make([]string, 0, len(e.config.MaxVulnerabilities))
+	for severity := range e.config.MaxVulnerabilities {
+		severities = append(severities, severity)
+	}
+	sort.Strings(severities)
+
+	var exceeded []string
+	for _, severity := range severities {
+		limit := e.config.MaxVulnerabilities[severity]
+		if count := build.Vulnerabilities[strings.ToUpper(severity)]; count > limit {
+			exceeded = append(exceeded, fmt.Sprintf("%d %s (limit %d)", count, strings.ToLower(severity), limit))
+		}
+	}
+	if len(exceeded) > 0 {
+		result.Message = strings.Join(exceeded, ", ")
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+func (e *Engine) requiredApprovals(build *types.Build) types.PolicyResult {
+	result := types.PolicyResult{Rule: RuleRequiredApprovals}
+	approvers := make(map[string]bool)
+	for _, approval := range build.Approvals {
+		approvers[approval.User] = true
+	}
+	if len(approvers) < e.config.RequiredApprovals {
+		result.Message = fmt.Sprintf("%d of %d approvals", len(approvers), e.config.RequiredApprovals)
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+func matchesAny(image string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, image); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+type fakeScanner struct {
+	counts map[string]int
+	err    error
+	scans  int
+}
+
+func (s *fakeScanner) Scan(context.Context, string) (map[string]int, error) {
+	s.scans++
+	return s.counts, s.err
+}
+
+func newTestEngine(cfg *config.PolicyConfig, scanner Scanner) *Engine {
+	e := NewEngine(cfg, zap.NewNop())
+	e.scanner = scanner
+	return e
+}
+
+func TestEngine_Applies(t *testing.T) {
+	e := NewEngine(&config.PolicyConfig{}, zap.NewNop())
+	assert.False(t, e.Applies(&types.Build{Environment: "production"}))
+
+	e.config.Enabled = true
+	assert.True(t, e.Applies(&types.Build{}))
+
+	e.config.Environments = []string{"production"}
+	assert.True(t, e.Applies(&types.Build{Environment: "production"}))
+	assert.False(t, e.Applies(&types.Build{Environment: "staging"}))
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	build := func() *types.Build {
+		return &types.Build{
+			ID:         "b1",
+			ImageID:    "registry.example.com/chef-shop:a1b2c3d",
+			BaseImages: []string{"node:20-alpine", "nginx:alpine"},
+			ImageSize:  50 << 20,
+			Approvals:  []types.Approval{{User: "alice"}, {User: "bob"}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		config  config.PolicyConfig
+		modify  func(*types.Build)
+		scanner *fakeScanner
+		preview bool
+		failed  string
+	}{
+		{
+			name:   "no rules",
+			config: config.PolicyConfig{},
+		},
+		{
+			name:   "allowed registry",
+			config: config.PolicyConfig{AllowedRegistries: []string{"registry.example.com/"}},
+		},
+		{
+			name:   "registry not allowed",
+			config: config.PolicyConfig{AllowedRegistries: []string{"ghcr.io"}},
+			failed: RuleAllowedRegistries,
+		},
+		{
+			name:   "local images",
+			config: config.PolicyConfig{AllowedRegistries: []string{"local"}},
+			modify: func(b *types.Build) { b.ImageID = "chef-shop:a1b2c3d" },
+		},
+		{
+			name:   "base images match patterns",
+			config: config.PolicyConfig{AllowedBaseImages: []string{"node:*-alpine", "nginx:alpine"}},
+		},
+		{
+			name:   "base image not allowed",
+			config: config.PolicyConfig{AllowedBaseImages: []string{"node:*-alpine"}},
+			failed: RuleAllowedBaseImages,
+		},
+		{
+			name:   "unknown base images",
+			config: config.PolicyConfig{AllowedBaseImages: []string{"*"}},
+			modify: func(b *types.Build) { b.BaseImages = nil },
+			failed: RuleAllowedBaseImages,
+		},
+		{
+			name:   "image too large",
+			config: config.PolicyConfig{MaxImageSize: 10 << 20},
+			failed: RuleMaxImageSize,
+		},
+		{
+			name:    "vulnerabilities within limits",
+			config:  config.PolicyConfig{MaxVulnerabilities: map[string]int{"critical": 0, "high": 5}},
+			scanner: &fakeScanner{counts: map[string]int{"HIGH": 2, "LOW": 40}},
+		},
+		{
+			name:    "too many vulnerabilities",
+			config:  config.PolicyConfig{MaxVulnerabilities: map[string]int{"critical": 0}},
+			scanner: &fakeScanner{counts: map[string]int{"CRITICAL": 1}},
+			failed:  RuleMaxVulnerabilities,
+		},
+		{
+			name:    "scan failure",
+			config:  config.PolicyConfig{MaxVulnerabilities: map[string]int{"critical": 0}},
+			scanner: &fakeScanner{err: errors.New("trivy is not installed on the server")},
+			failed:  RuleMaxVulnerabilities,
+		},
+		{
+			name:   "duplicate approvals count once",
+			config: config.PolicyConfig{RequiredApprovals: 2},
+			modify: func(b *types.Build) { b.Approvals = []types.Approval{{User: "alice"}, {User: "alice"}} },
+			failed: RuleRequiredApprovals,
+		},
+		{
+			name:    "previews skip approvals",
+			config:  config.PolicyConfig{RequiredApprovals: 3},
+			preview: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := build()
+			if tt.modify != nil {
+				tt.modify(b)
+			}
+			scanner := tt.scanner
+			if scanner == nil {
+				scanner = &fakeScanner{}
+			}
+
+			results, err := newTestEngine(&tt.config, scanner).Evaluate(context.Background(), b, tt.preview)
+			if tt.failed == "" {
+				require.NoError(t, err)
+				for _, result := range results {
+					assert.True(t, result.Passed, result.Rule)
+				}
+				return
+			}
+			require.ErrorIs(t, err, ErrDenied)
+			assert.Contains(t, err.Error(), tt.failed)
+			require.Len(t, results, 1)
+			assert.Equal(t, tt.failed, results[0].Rule)
+			assert.False(t, results[0].Passed)
+			assert.NotEmpty(t, results[0].Message)
+		})
+	}
+}
+
+func TestEngine_ScansOnce(t *testing.T) {
+	scanner := &fakeScanner{counts: map[string]int{"HIGH": 1}}
+	e := newTestEngine(&config.PolicyConfig{MaxVulnerabilities: map[string]int{"high": 1}}, scanner)
+	build := &types.Build{ID: "b1", ImageID: "chef-shop:a1b2c3d"}
+
+	_, err := e.Evaluate(context.Background(), build, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"HIGH": 1}, build.Vulnerabilities)
+
+	_, err = e.Evaluate(context.Background(), build, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, scanner.scans)
+}
@@ -0,0 +1,53 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// TrivyScanner scans images with the trivy CLI
+type TrivyScanner struct {
+	trivyPath string
+}
+
+func NewTrivyScanner() *TrivyScanner {
+	return &TrivyScanner{trivyPath: "trivy"}
+}
+
+// Scan returns the number of vulnerabilities per upper-case severity,
+// e.g. "CRITICAL"
+func (s *TrivyScanner) Scan(ctx context.Context, image string) (map[string]int, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.trivyPath, "image", "--quiet", "--format", "json", "--scanners", "vuln", image)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("trivy is not installed on the server")
+		}
+		return nil, fmt.Errorf("trivy failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				Severity string `json:"Severity"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		return nil, fmt.Errorf("failed to decode trivy report: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, result := range report.Results {
+		for _, vulnerability := range result.Vulnerabilities {
+			counts[vulnerability.Severity]++
+		}
+	}
+	return counts, nil
+}
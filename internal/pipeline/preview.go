@@ -0,0 +1,211 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/plugin"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultPreviewTTL    = 24 * time.Hour
+	previewSweepInterval = time.Minute
+)
+
+var (
+	ErrPreviewUnsupported = errors.New("preview deployments are not supported by the deployment platform")
+	ErrNotPromotable      = errors.New("build is not an active preview")
+)
+
+func (p *Pipeline) previewTTL() time.Duration {
+	if p.config.Preview.TTL > 0 {
+		return time.Duration(p.config.Preview.TTL) * time.Second
+	}
+	return defaultPreviewTTL
+}
+
+// deployPreview serves the build under its preview name instead of the
+// project ID, so none of the project's resources are touched. Hooks and
+// post-deploy plugins only run on promotion.
+func (p *Pipeline) deployPreview(ctx context.Context, build *types.Build) error {
+	p.deploying.Add(1)
+	defer p.deploying.Add(-1)
+
+	name := types.PreviewName(build.ProjectID, build.ID)
+	preview := *build
+	preview.ProjectID = name
+	preview.Hooks = nil
+	preview.CancelFunc = nil
+	// Previews only run the web process, without the project's add-ons,
+	// so they cannot touch its data; the rest starts on promotion
+	preview.AddOns = nil
+	preview.Jobs = nil
+	preview.Processes = nil
+	err := types.TimeStage(ctx, types.StageScan, func() error {
+		if err := p.verify(ctx, build); err != nil {
+			return err
+		}
+		if err := p.checkPolicy(ctx, build, true); err != nil {
+			return err
+		}
+		return p.runPlugins(ctx, plugin.PreDeploy, build)
+	})
+	if err != nil {
+		return err
+	}
+	target, _ := p.target(build.ProjectID, build.Environment)
+	err = p.phaseTimeouts().Override(build.Timeouts).RunPhase(ctx, types.PhaseDeploy, func(ctx context.Context) error {
+		return target.Deploy(ctx, &preview)
+	})
+	if err != nil {
+		return fmt.Errorf("preview deployment failed: %w", err)
+	}
+
+	expires := time.Now().Add(p.previewTTL())
+	url := p.targetURL(p.targetName(build.ProjectID, build.Environment), name)
+	p.updateBuild(build, func(b *types.Build) {
+		b.Preview = &types.Preview{Name: name, URL: url, ExpiresAt: expires}
+		b.AddEvent(types.EventPreviewReady, "", fmt.Sprintf("preview at %s until %s", url, expires.UTC().Format(time.RFC3339)))
+	})
+	return nil
+}
+
+// PromoteBuild deploys a previewed build to the project's environment and
+// tears the preview down. Only builds of the running process can be
+// promoted since their artifacts and settings are held in memory.
+func (p *Pipeline) PromoteBuild(ctx context.Context, buildID string) error {
+	p.mu.Lock()
+	build, exists := p.builds[buildID]
+	if !exists {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", types.ErrBuildNotFound, buildID)
+	}
+	if build.Status != types.BuildStatusSuccess || !build.Preview.Active() || build.Preview.PromotedAt != nil {
+		p.mu.Unlock()
+		return ErrNotPromotable
+	}
+	// Claim the promotion so concurrent calls do not deploy twice. The
+	// preview is replaced rather than modified because notifiers may hold
+	// snapshots sharing it.
+	now := time.Now()
+	claimed := *build.Preview
+	claimed.PromotedAt = &now
+	build.Preview = &claimed
+	p.mu.Unlock()
+
+	ctx = types.WithBuildUpdater(buildlog.WithBuild(ctx, build), p.updateBuild)
+	if err := p.deploy(ctx, build); err != nil {
+		p.updateBuild(build, func(b *types.Build) {
+			released := *b.Preview
+			released.PromotedAt = nil
+			b.Preview = &released
+		})
+		p.persist(build)
+		p.notify(types.LifecycleDeployFailed, build, err.Error())
+		return fmt.Errorf("failed to promote build: %w", err)
+	}
+
+	p.updateBuild(build, func(b *types.Build) {
+		b.AddEvent(types.EventPromoted, "", "")
+	})
+	if err := p.removePreview(ctx, build); err != nil {
+		// The sweeper retries once the preview expires
+		p.logger.Warn("failed to remove promoted preview",
+			zap.String("build_id", build.ID),
+			zap.Error(err))
+	}
+	p.persist(build)
+	p.notify(types.LifecycleDeploySucceeded, build, "")
+	return nil
+}
+
+// removePreview tears down the build's preview deployment
+func (p *Pipeline) removePreview(ctx context.Context, build *types.Build) error {
+	target, _ := p.target(build.ProjectID, build.Environment)
+	remover, ok := target.(deployer.Remover)
+	if !ok {
+		return ErrPreviewUnsupported
+	}
+
+	p.mu.RLock()
+	name := build.Preview.Name
+	p.mu.RUnlock()
+	if err := remover.Remove(ctx, name, nil); err != nil {
+		return fmt.Errorf("failed to remove preview %s: %w", name, err)
+	}
+
+	now := time.Now()
+	p.updateBuild(build, func(b *types.Build) {
+		removed := *b.Preview
+		removed.RemovedAt = &now
+		b.Preview = &removed
+		b.AddEvent(types.EventPreviewRemoved, "", "")
+	})
+	return nil
+}
+
+// ExpirePreviews removes previews past their expiry, including those
+// recorded by an earlier process
+func (p *Pipeline) ExpirePreviews(ctx context.Context) {
+	now := time.Now()
+
+	p.mu.RLock()
+	var expired []*types.Build
+	for _, build := range p.builds {
+		if build.Preview.Active() && build.Preview.ExpiresAt.Before(now) {
+			expired = append(expired, build)
+		}
+	}
+	p.mu.RUnlock()
+
+	if p.store != nil {
+		stored, err := p.store.ListExpiredPreviews(ctx, now)
+		if err != nil {
+			p.logger.Error("failed to list expired previews", zap.Error(err))
+		}
+		p.mu.RLock()
+		for i := range stored {
+			if _, inMemory := p.builds[stored[i].ID]; !inMemory {
+				expired = append(expired, &stored[i])
+			}
+		}
+		p.mu.RUnlock()
+	}
+
+	for _, build := range expired {
+		if err := p.removePreview(ctx, build); err != nil {
+			p.logger.Error("failed to remove expired preview",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+			continue
+		}
+		p.persist(build)
+		p.logger.Info("removed expired preview",
+			zap.String("project", build.ProjectID),
+			zap.String("build_id", build.ID))
+	}
+}
+
+func (p *Pipeline) sweepPreviews() {
+	defer p.running.Done()
+
+	ticker := time.NewTicker(previewSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.rootCtx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(p.rootCtx, previewSweepInterval)
+			p.ExpirePreviews(ctx)
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/policy"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// previewDeployer records the names builds were deployed and removed under
+type previewDeployer struct {
+	mockDeployer
+	mu       sync.Mutex
+	deployed []string
+	removed  []string
+}
+
+func (d *previewDeployer) Deploy(_ context.Context, build *types.Build) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deployed = append(d.deployed, build.ProjectID)
+	return nil
+}
+
+func (d *previewDeployer) Remove(_ context.Context, projectID string, _ []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removed = append(d.removed, projectID)
+	return nil
+}
+
+func setupPreviewPipeline(t *testing.T) (*Pipeline, *previewDeployer, *recordingNotifier) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	deployer := &previewDeployer{}
+	notifier := &recordingNotifier{}
+	pipeline.deployer = deployer
+	pipeline.notifier = notifier
+	pipeline.config.Deploy.IngressDomain = "apps.example.com"
+	return pipeline, deployer, notifier
+}
+
+func TestPipeline_PreviewAndPromote(t *testing.T) {
+	pipeline, deployer, notifier := setupPreviewPipeline(t)
+
+	build := createTestBuild()
+	build.PreviewOnly = true
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.Eventually(t, func() bool {
+		snapshot, err := pipeline.LookupBuild(context.Background(), build.ID)
+		return err == nil && snapshot.Preview != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	name := types.PreviewName(build.ProjectID, build.ID)
+	snapshot, err := pipeline.LookupBuild(context.Background(), build.ID)
+	require.NoError(t, err)
+	assert.Equal(t, types.BuildStatusSuccess, snapshot.Status)
+	assert.Equal(t, "https://"+name+".apps.example.com", snapshot.Preview.URL)
+	assert.WithinDuration(t, time.Now().Add(defaultPreviewTTL), snapshot.Preview.ExpiresAt, time.Minute)
+	assert.Equal(t, []string{name}, deployer.deployed)
+	require.Eventually(t, func() bool {
+		notifier.mu.Lock()
+		defer notifier.mu.Unlock()
+		return len(notifier.events) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, types.LifecyclePreviewReady, notifier.events[2])
+
+	require.NoError(t, pipeline.PromoteBuild(context.Background(), build.ID))
+	assert.Equal(t, []string{name, build.ProjectID}, deployer.deployed)
+	assert.Equal(t, []string{name}, deployer.removed)
+	assert.Equal(t, types.LifecycleDeploySucceeded, notifier.events[len(notifier.events)-1])
+
+	snapshot, err = pipeline.LookupBuild(context.Background(), build.ID)
+	require.NoError(t, err)
+	assert.False(t, snapshot.Preview.Active())
+	assert.NotNil(t, snapshot.Preview.PromotedAt)
+
+	assert.ErrorIs(t, pipeline.PromoteBuild(context.Background(), build.ID), ErrNotPromotable)
+	assert.ErrorIs(t, pipeline.PromoteBuild(context.Background(), "missing"), types.ErrBuildNotFound)
+}
+
+func TestPipeline_ExpirePreviews(t *testing.T) {
+	pipeline, deployer, _ := setupPreviewPipeline(t)
+	now := time.Now()
+	pipeline.builds["expired"] = &types.Build{
+		ID:        "expired",
+		ProjectID: "shop",
+		Status:    types.BuildStatusSuccess,
+		Preview:   &types.Preview{Name: "preview-expired", ExpiresAt: now.Add(-time.Minute)},
+	}
+	pipeline.builds["fresh"] = &types.Build{
+		ID:        "fresh",
+		ProjectID: "shop",
+		Status:    types.BuildStatusSuccess,
+		Preview:   &types.Preview{Name: "preview-fresh", ExpiresAt: now.Add(time.Hour)},
+	}
+
+	pipeline.ExpirePreviews(context.Background())
+	assert.Equal(t, []string{"preview-expired"}, deployer.removed)
+	assert.False(t, pipeline.builds["expired"].Preview.Active())
+	assert.True(t, pipeline.builds["fresh"].Preview.Active())
+	assert.ErrorIs(t, pipeline.PromoteBuild(context.Background(), "expired"), ErrNotPromotable)
+
+	// Removed previews are not removed again
+	pipeline.ExpirePreviews(context.Background())
+	assert.Len(t, deployer.removed, 1)
+}
+
+func TestPipeline_PromotionRequiresApprovals(t *testing.T) {
+	pipeline, deployer, _ := setupPreviewPipeline(t)
+	pipeline.policy = policy.NewEngine(&config.PolicyConfig{
+		Enabled:           true,
+		Environments:      []string{"production"},
+		RequiredApprovals: 2,
+	}, zap.NewNop())
+
+	build := createTestBuild()
+	build.PreviewOnly = true
+	build.Environment = "production"
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.Eventually(t, func() bool {
+		snapshot, err := pipeline.LookupBuild(context.Background(), build.ID)
+		return err == nil && snapshot.Preview != nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	err := pipeline.PromoteBuild(context.Background(), build.ID)
+	assert.ErrorIs(t, err, policy.ErrDenied)
+	assert.Len(t, deployer.deployed, 1)
+
+	require.NoError(t, pipeline.ApproveBuild(context.Background(), build.ID, "alice"))
+	require.NoError(t, pipeline.ApproveBuild(context.Background(), build.ID, "alice"))
+	assert.ErrorIs(t, pipeline.PromoteBuild(context.Background(), build.ID), policy.ErrDenied)
+
+	require.NoError(t, pipeline.ApproveBuild(context.Background(), build.ID, "bob"))
+	require.NoError(t, pipeline.PromoteBuild(context.Background(), build.ID))
+	assert.Equal(t, build.ProjectID, deployer.deployed[len(deployer.deployed)-1])
+
+	snapshot, err := pipeline.LookupBuild(context.Background(), build.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []types.PolicyResult{{Rule: policy.RuleRequiredApprovals, Passed: true}}, snapshot.PolicyResults)
+	assert.ErrorIs(t, pipeline.ApproveBuild(context.Background(), build.ID, "carol"), ErrNotPromotable)
+}
+
+func TestPipeline_PreviewRequiresRemover(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+
+	build := createTestBuild()
+	build.PreviewOnly = true
+	assert.ErrorIs(t, pipeline.StartBuild(context.Background(), build), ErrPreviewUnsupported)
+}
+
+func TestPreviewName(t *testing.T) {
+	name := types.PreviewName("a-very-long-project-name-that-already-uses-most-of-a-dns-label", "build-1")
+	assert.LessOrEqual(t, len(name), 63)
+	assert.Equal(t, name, types.PreviewName("a-very-long-project-name-that-already-uses-most-of-a-dns-label", "build-1"))
+	assert.NotEqual(t, name, types.PreviewName("a-very-long-project-name-that-already-uses-most-of-a-dns-label", "build-2"))
+}
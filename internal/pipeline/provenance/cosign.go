@@ -0,0 +1,196 @@
+package provenance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+var (
+	ErrUnsigned           = errors.New("build is not signed")
+	ErrArtifactMismatch   = errors.New("artifact does not match its provenance")
+	ErrVerificationFailed = errors.New("signature verification failed")
+)
+
+// cosignType is the attestation type of SLSA v1 provenance
+const cosignType = "slsaprovenance1"
+
+// Attestor writes provenance for builds and signs it with cosign
+type Attestor struct {
+	config     *config.ProvenanceConfig
+	cosignPath string
+	log        *zap.Logger
+}
+
+func NewAttestor(cfg *config.ProvenanceConfig, log *zap.Logger) *Attestor {
+	return &Attestor{config: cfg, cosignPath: "cosign", log: log}
+}
+
+// Enabled reports whether builds get provenance
+func (a *Attestor) Enabled() bool {
+	return a.config.Enabled || a.signing()
+}
+
+func (a *Attestor) signing() bool {
+	return a.config.Key != ""
+}
+
+// publicKey is the key signatures are verified with
+func (a *Attestor) publicKey() string {
+	switch {
+	case a.config.PublicKey != "":
+		return a.config.PublicKey
+	case strings.Contains(a.config.Key, "://"):
+		return a.config.Key
+	}
+	return strings.TrimSuffix(a.config.Key, ".key") + ".pub"
+}
+
+// Attest writes the provenance of a built artifact next to it, hashing
+// the sources in sourceDir. With a key configured the statement is signed,
+// and registry images are signed and get the provenance attached.
+func (a *Attestor) Attest(ctx context.Context, build *types.Build, sourceDir string) (*types.Provenance, error) {
+	inputs, err := InputsDigest(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := FileDigest(build.ArtifactPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash artifact: %w", err)
+	}
+
+	statement := New(build, artifact, inputs, a.config.BuilderID)
+	result := &types.Provenance{
+		Path:         build.ArtifactPath + ".intoto.json",
+		InputsDigest: inputs,
+	}
+	if result.Digest, err = statement.Write(result.Path); err != nil {
+		return nil, err
+	}
+	if !a.signing() {
+		return result, nil
+	}
+
+	signature := result.Path + ".sig"
+	if err := a.cosign(ctx, "sign-blob", "--yes", "--output-signature", signature, result.Path); err != nil {
+		return nil, err
+	}
+	result.SignaturePath = signature
+
+	if isRegistryRef(build.ImageID) {
+		if err := a.cosign(ctx, "sign", "--yes", build.ImageID); err != nil {
+			return nil, err
+		}
+
+		predicate, err := writePredicate(statement, result.Path+".predicate")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(predicate)
+		if err := a.cosign(ctx, "attest", "--yes", "--type", cosignType, "--predicate", predicate, build.ImageID); err != nil {
+			return nil, err
+		}
+		result.SignedImage = build.ImageID
+	}
+	return result, nil
+}
+
+// Verify checks the signatures of a build before it is deployed. It
+// passes without checks unless verification is configured.
+func (a *Attestor) Verify(ctx context.Context, build *types.Build) error {
+	if !a.config.Verify {
+		return nil
+	}
+	prov := build.Provenance
+	if !prov.Signed() {
+		return ErrUnsigned
+	}
+
+	key := a.publicKey()
+	if err := a.cosign(ctx, "verify-blob", "--key", key, "--signature", prov.SignaturePath, prov.Path); err != nil {
+		return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+
+	// The signature covers the statement, the statement the artifact
+	statement, err := Read(prov.Path)
+	if err != nil {
+		return err
+	}
+	digest, err := FileDigest(build.ArtifactPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash artifact: %w", err)
+	}
+	if len(statement.Subject) == 0 || statement.Subject[0].Digest["sha256"] != digest {
+		return ErrArtifactMismatch
+	}
+
+	if prov.SignedImage != "" {
+		if err := a.cosign(ctx, "verify", "--key", key, prov.SignedImage); err != nil {
+			return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+		}
+		if err := a.cosign(ctx, "verify-attestation", "--key", key, "--type", cosignType, prov.SignedImage); err != nil {
+			return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+		}
+	}
+	return nil
+}
+
+// cosign runs a cosign command with the configured key and transparency
+// log setting. Signing commands use the private key unless --key is given.
+func (a *Attestor) cosign(ctx context.Context, command string, args ...string) error {
+	full := []string{command}
+	verifying := strings.HasPrefix(command, "verify")
+	if !verifying {
+		full = append(full, "--key", a.config.Key)
+	}
+	if !a.config.TlogUpload {
+		if verifying {
+			full = append(full, "--insecure-ignore-tlog=true")
+		} else {
+			full = append(full, "--tlog-upload=false")
+		}
+	}
+	full = append(full, args...)
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.cosignPath, full...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("cosign is not installed on the server")
+		}
+		return fmt.Errorf("cosign %s failed: %w: %s", command, err, bytes.TrimSpace(output.Bytes()))
+	}
+	a.log.Debug("cosign finished", zap.String("command", command))
+	return nil
+}
+
+// writePredicate stores the statement's predicate alone, cosign wraps it
+// in a statement about the image
+func writePredicate(statement *Statement, path string) (string, error) {
+	data, err := json.Marshal(statement.Predicate)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode predicate: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write predicate: %w", err)
+	}
+	return path, nil
+}
+
+// isRegistryRef reports whether an image was pushed to a registry. Local
+// images are tagged chef-<project>:<tag> without one.
+func isRegistryRef(ref string) bool {
+	return strings.Contains(ref, "/")
+}
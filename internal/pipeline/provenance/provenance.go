@@ -0,0 +1,224 @@
+// Package provenance writes SLSA provenance for builds and signs it, along
+// with pushed images, using cosign.
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	StatementType    = "https://in-toto.io/Statement/v1"
+	PredicateType    = "https://slsa.dev/provenance/v1"
+	BuildType        = "https://github.com/elskow/chef-infra/build/v1"
+	DefaultBuilderID = "https://github.com/elskow/chef-infra"
+)
+
+// Statement is an in-toto statement carrying SLSA provenance
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Predicate            `json:"predicate"`
+}
+
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor   `json:"resolvedDependencies,omitempty"`
+}
+
+type ResourceDescriptor struct {
+	URI    string            `json:"uri,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type RunDetails struct {
+	Builder  Builder  `json:"builder"`
+	Metadata Metadata `json:"metadata"`
+}
+
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type Metadata struct {
+	InvocationID string     `json:"invocationId"`
+	StartedOn    time.Time  `json:"startedOn"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// New describes how build produced the artifact with the given digest.
+// inputsDigest covers the source tree the build started from.
+func New(build *types.Build, artifactDigest, inputsDigest, builderID string) *Statement {
+	if builderID == "" {
+		builderID = DefaultBuilderID
+	}
+
+	external := map[string]interface{}{
+		"project":   build.ProjectID,
+		"framework": build.Framework,
+	}
+	if build.Environment != "" {
+		external["environment"] = build.Environment
+	}
+	if build.BuildCommand != "" {
+		external["buildCommand"] = build.BuildCommand
+	}
+	if len(build.Platforms) > 0 {
+		external["platforms"] = build.Platforms
+	}
+	if len(build.BuildEnv) > 0 {
+		// Values may be sensitive, their names are enough to reproduce
+		external["buildEnv"] = build.BuildEnv
+	}
+	if commit := build.Commit; commit != nil {
+		if commit.Branch != "" {
+			external["branch"] = commit.Branch
+		}
+		if commit.Tag != "" {
+			external["tag"] = commit.Tag
+		}
+	}
+
+	internal := map[string]interface{}{}
+	if build.NodeVersion != "" {
+		internal["nodeVersion"] = build.NodeVersion
+	}
+
+	dependencies := []ResourceDescriptor{{
+		Name:   "source",
+		Digest: map[string]string{"sha256": inputsDigest},
+	}}
+	if build.CommitHash != "" {
+		source := ResourceDescriptor{Name: "commit", Digest: map[string]string{"gitCommit": build.CommitHash}}
+		if build.Source != nil && build.Source.Repository != "" {
+			source.URI = fmt.Sprintf("git+https://%s.com/%s@%s", build.Source.Provider, build.Source.Repository, build.CommitHash)
+		}
+		dependencies = append(dependencies, source)
+	}
+
+	return &Statement{
+		Type: StatementType,
+		Subject: []ResourceDescriptor{{
+			Name:   filepath.Base(build.ArtifactPath),
+			Digest: map[string]string{"sha256": artifactDigest},
+		}},
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			BuildDefinition: BuildDefinition{
+				BuildType:            BuildType,
+				ExternalParameters:   external,
+				InternalParameters:   internal,
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{
+					ID:      builderID,
+					Version: map[string]string{"chef-infra": types.BuilderVersion()},
+				},
+				Metadata: Metadata{
+					InvocationID: build.ID,
+					StartedOn:    build.StartTime,
+					FinishedOn:   build.CompleteTime,
+				},
+			},
+		},
+	}
+}
+
+// Write stores the statement at path and returns its sha256
+func (s *Statement) Write(path string) (string, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode provenance: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write provenance: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Read loads a statement written by Write
+func Read(path string) (*Statement, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provenance: %w", err)
+	}
+	var statement Statement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, fmt.Errorf("failed to decode provenance: %w", err)
+	}
+	return &statement, nil
+}
+
+// FileDigest returns the hex sha256 of a file
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// InputsDigest hashes the source tree in dir: every file's relative path
+// and content, in path order. .git and node_modules are skipped like they
+// are when sources are copied into the build.
+func InputsDigest(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to walk sources: %w", err)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, path := range files {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return "", err
+		}
+		digest, err := FileDigest(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash %s: %w", rel, err)
+		}
+		fmt.Fprintf(h, "%s  %s\n", digest, filepath.ToSlash(rel))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,172 @@
+package provenance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// fakeCosign writes a cosign stand-in that logs its arguments and creates
+// the signature file sign-blob is asked for
+const fakeCosign = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/calls"
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--output-signature" ]; then echo sig > "$2"; fi
+	shift
+done
+exit ${COSIGN_EXIT:-0}
+`
+
+func writeSources(t *testing.T) string {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"name":"shop"}`), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte("console.log(1)"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules", "dep"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "dep", "index.js"), []byte("x"), 0644))
+	return dir
+}
+
+func testBuild(t *testing.T) *types.Build {
+	artifact := filepath.Join(t.TempDir(), "b1.tar.gz")
+	require.NoError(t, os.WriteFile(artifact, []byte("artifact"), 0644))
+	completed := time.Unix(1700000100, 0)
+	return &types.Build{
+		ID:           "b1",
+		ProjectID:    "shop",
+		CommitHash:   "a1b2c3d",
+		Framework:    "react",
+		NodeVersion:  "20.11.0",
+		BuildEnv:     []string{"VITE_API_URL"},
+		Source:       &types.BuildSource{Provider: "github", Repository: "acme/shop"},
+		Commit:       &types.CommitInfo{Branch: "main"},
+		ArtifactPath: artifact,
+		StartTime:    time.Unix(1700000000, 0),
+		CompleteTime: &completed,
+	}
+}
+
+func TestInputsDigest(t *testing.T) {
+	dir := writeSources(t)
+	first, err := InputsDigest(dir)
+	require.NoError(t, err)
+
+	// Dependencies are not inputs
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "dep", "index.js"), []byte("y"), 0644))
+	second, err := InputsDigest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "index.js"), []byte("console.log(2)"), 0644))
+	third, err := InputsDigest(dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, third)
+}
+
+func TestNew(t *testing.T) {
+	build := testBuild(t)
+	statement := New(build, "artifactdigest", "inputsdigest", "")
+
+	assert.Equal(t, StatementType, statement.Type)
+	assert.Equal(t, PredicateType, statement.PredicateType)
+	assert.Equal(t, []ResourceDescriptor{{Name: "b1.tar.gz", Digest: map[string]string{"sha256": "artifactdigest"}}}, statement.Subject)
+	assert.Equal(t, DefaultBuilderID, statement.Predicate.RunDetails.Builder.ID)
+	assert.Equal(t, "b1", statement.Predicate.RunDetails.Metadata.InvocationID)
+	assert.Equal(t, []string{"VITE_API_URL"}, statement.Predicate.BuildDefinition.ExternalParameters["buildEnv"])
+	assert.Equal(t, "main", statement.Predicate.BuildDefinition.ExternalParameters["branch"])
+	assert.Equal(t, []ResourceDescriptor{
+		{Name: "source", Digest: map[string]string{"sha256": "inputsdigest"}},
+		{Name: "commit", URI: "git+https://github.com/acme/shop@a1b2c3d", Digest: map[string]string{"gitCommit": "a1b2c3d"}},
+	}, statement.Predicate.BuildDefinition.ResolvedDependencies)
+}
+
+func newTestAttestor(t *testing.T, cfg *config.ProvenanceConfig) (*Attestor, string) {
+	bin := t.TempDir()
+	cosign := filepath.Join(bin, "cosign")
+	require.NoError(t, os.WriteFile(cosign, []byte(fakeCosign), 0755))
+	a := NewAttestor(cfg, zap.NewNop())
+	a.cosignPath = cosign
+	return a, filepath.Join(bin, "calls")
+}
+
+func readCalls(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestAttestor_Unsigned(t *testing.T) {
+	a, calls := newTestAttestor(t, &config.ProvenanceConfig{Enabled: true})
+	build := testBuild(t)
+
+	prov, err := a.Attest(context.Background(), build, writeSources(t))
+	require.NoError(t, err)
+	assert.False(t, prov.Signed())
+	assert.Equal(t, build.ArtifactPath+".intoto.json", prov.Path)
+	assert.Empty(t, readCalls(t, calls))
+
+	digest, err := FileDigest(prov.Path)
+	require.NoError(t, err)
+	assert.Equal(t, digest, prov.Digest)
+
+	// Verification is opt-in
+	build.Provenance = prov
+	assert.NoError(t, a.Verify(context.Background(), build))
+	a.config.Verify = true
+	assert.ErrorIs(t, a.Verify(context.Background(), build), ErrUnsigned)
+}
+
+func TestAttestor_SignAndVerify(t *testing.T) {
+	a, calls := newTestAttestor(t, &config.ProvenanceConfig{Key: "/keys/cosign.key", Verify: true})
+	build := testBuild(t)
+	build.ImageID = "registry.example.com/chef-shop:a1b2c3d"
+
+	prov, err := a.Attest(context.Background(), build, writeSources(t))
+	require.NoError(t, err)
+	assert.True(t, prov.Signed())
+	assert.FileExists(t, prov.SignaturePath)
+	assert.Equal(t, build.ImageID, prov.SignedImage)
+	assert.NoFileExists(t, prov.Path+".predicate")
+
+	signing := readCalls(t, calls)
+	require.Len(t, signing, 3)
+	assert.True(t, strings.HasPrefix(signing[0], "sign-blob --key /keys/cosign.key --tlog-upload=false"))
+	assert.True(t, strings.HasPrefix(signing[1], "sign --key /keys/cosign.key"))
+	assert.Contains(t, signing[2], "--type slsaprovenance1")
+
+	build.Provenance = prov
+	require.NoError(t, a.Verify(context.Background(), build))
+	verifying := readCalls(t, calls)[3:]
+	require.Len(t, verifying, 3)
+	assert.True(t, strings.HasPrefix(verifying[0], "verify-blob --insecure-ignore-tlog=true --key /keys/cosign.pub"))
+	assert.True(t, strings.HasPrefix(verifying[1], "verify "))
+	assert.True(t, strings.HasPrefix(verifying[2], "verify-attestation "))
+
+	// A swapped artifact no longer matches the signed statement
+	require.NoError(t, os.WriteFile(build.ArtifactPath, []byte("tampered"), 0644))
+	assert.ErrorIs(t, a.Verify(context.Background(), build), ErrArtifactMismatch)
+}
+
+func TestAttestor_VerifyFailure(t *testing.T) {
+	a, _ := newTestAttestor(t, &config.ProvenanceConfig{Key: "awskms:///alias/chef", Verify: true})
+	build := testBuild(t)
+	prov, err := a.Attest(context.Background(), build, writeSources(t))
+	require.NoError(t, err)
+	build.Provenance = prov
+
+	t.Setenv("COSIGN_EXIT", "1")
+	assert.ErrorIs(t, a.Verify(context.Background(), build), ErrVerificationFailed)
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/public/v1"
+)
+
+// PublicHandler serves read-only views of projects. The server admits
+// callers by the project's visibility before a request gets here, so
+// handlers don't check access themselves.
+type PublicHandler struct {
+	pb.UnimplementedPublicProjectsServer
+	pipeline *Pipeline
+	log      *zap.Logger
+}
+
+func NewPublicHandler(pipeline *Pipeline, log *zap.Logger) *PublicHandler {
+	return &PublicHandler{pipeline: pipeline, log: log}
+}
+
+func (h *PublicHandler) GetProjectStatus(ctx context.Context, req *pb.GetProjectStatusRequest) (*pb.GetProjectStatusResponse, error) {
+	environment := req.Environment
+	if environment == "" {
+		environment = defaultStatusEnvironment
+	}
+	resp := &pb.GetProjectStatusResponse{ProjectId: req.ProjectId, Environment: environment}
+
+	deployed, err := h.pipeline.latestDeployment(ctx, req.ProjectId, environment)
+	if err != nil {
+		h.log.Error("failed to get latest deployment", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get project status")
+	}
+	if deployed != nil {
+		resp.Deployment = &pb.Deployment{
+			BuildId:    deployed.ID,
+			Commit:     deployed.ShortHash(),
+			DeployedAt: deployed.CompleteTime.Unix(),
+		}
+		if deployed.Commit != nil {
+			resp.Deployment.Branch, resp.Deployment.Tag = deployed.Commit.Branch, deployed.Commit.Tag
+		}
+		if deployed.Release != nil {
+			resp.Deployment.Release = deployed.Release.Tag
+		}
+	}
+
+	page, err := h.pipeline.ListBuilds(ctx, req.ProjectId, nil, pagination.Params{PageSize: 1})
+	if err != nil {
+		h.log.Error("failed to get latest build", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get project status")
+	}
+	if len(page.Items) > 0 {
+		resp.LatestBuild = publicBuild(&page.Items[0])
+	}
+	return resp, nil
+}
+
+func (h *PublicHandler) ListBuilds(ctx context.Context, req *pb.ListBuildsRequest) (*pb.ListBuildsResponse, error) {
+	page, err := h.pipeline.ListBuilds(ctx, req.ProjectId, nil, pagination.Params{
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to list builds", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list builds")
+	}
+
+	resp := &pb.ListBuildsResponse{NextPageToken: page.NextPageToken}
+	for i := range page.Items {
+		resp.Builds = append(resp.Builds, publicBuild(&page.Items[i]))
+	}
+	return resp, nil
+}
+
+// publicBuild keeps only what may be shown to anyone of a build
+func publicBuild(build *types.Build) *pb.Build {
+	info := &pb.Build{
+		Id:          build.ID,
+		Status:      string(build.Status),
+		Commit:      build.ShortHash(),
+		Environment: build.Environment,
+		StartedAt:   build.StartTime.Unix(),
+	}
+	if build.Commit != nil {
+		info.Branch, info.Tag = build.Commit.Branch, build.Commit.Tag
+	}
+	if build.CompleteTime != nil {
+		info.CompletedAt = build.CompleteTime.Unix()
+	}
+	return info
+}
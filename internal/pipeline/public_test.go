@@ -0,0 +1,57 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/public/v1"
+)
+
+func TestPublicHandler(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	now := time.Now()
+	pipeline.builds["b1"] = deployedBuild("b1", now.Add(-time.Hour))
+	pipeline.builds["b1"].StartTime = now.Add(-2 * time.Hour)
+	pipeline.builds["b1"].ErrorMessage = "secret detail"
+	running := &types.Build{
+		ID:         "b2",
+		ProjectID:  "shop",
+		CommitHash: "4567abcdef",
+		Commit:     &types.CommitInfo{Branch: "feature"},
+		Status:     types.BuildStatusBuilding,
+		StartTime:  now,
+	}
+	pipeline.builds["b2"] = running
+
+	h := NewPublicHandler(pipeline, zap.NewNop())
+	ctx := context.Background()
+
+	status, err := h.GetProjectStatus(ctx, &pb.GetProjectStatusRequest{ProjectId: "shop"})
+	require.NoError(t, err)
+	assert.Equal(t, "production", status.Environment)
+	require.NotNil(t, status.Deployment)
+	assert.Equal(t, "b1", status.Deployment.BuildId)
+	assert.Equal(t, "0123abc", status.Deployment.Commit)
+	require.NotNil(t, status.LatestBuild)
+	assert.Equal(t, "b2", status.LatestBuild.Id)
+	assert.Equal(t, string(types.BuildStatusBuilding), status.LatestBuild.Status)
+	assert.Zero(t, status.LatestBuild.CompletedAt)
+
+	// Environments never deployed have no deployment
+	status, err = h.GetProjectStatus(ctx, &pb.GetProjectStatusRequest{ProjectId: "shop", Environment: "staging"})
+	require.NoError(t, err)
+	assert.Nil(t, status.Deployment)
+
+	builds, err := h.ListBuilds(ctx, &pb.ListBuildsRequest{ProjectId: "shop"})
+	require.NoError(t, err)
+	require.Len(t, builds.Builds, 2)
+	assert.Equal(t, "b2", builds.Builds[0].Id, "newest first")
+	assert.Equal(t, "main", builds.Builds[1].Branch)
+	assert.NotZero(t, builds.Builds[1].CompletedAt)
+}
@@ -0,0 +1,252 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultStaleAfter = 10 * time.Minute
+	recoveryTimeout   = time.Minute
+)
+
+// leftoverRemover is implemented by builder factories running builds on
+// the local Docker host
+type leftoverRemover interface {
+	RemoveLeftovers(ctx context.Context, build *types.Build) error
+}
+
+func (p *Pipeline) staleAfter() time.Duration {
+	if p.config.Recovery.StaleAfter > 0 {
+		return time.Duration(p.config.Recovery.StaleAfter) * time.Second
+	}
+	return defaultStaleAfter
+}
+
+// newBuildInput records what a build is started with besides what its
+// record keeps
+func newBuildInput(build *types.Build) *types.BuildInput {
+	input := &types.BuildInput{
+		SourceDir:    build.ProjectID,
+		BuildCommand: build.BuildCommand,
+		OutputDir:    build.OutputDir,
+		NodeVersion:  build.NodeVersion,
+		Platforms:    build.Platforms,
+		Source:       build.Source,
+		Dedup:        build.Dedup,
+		Priority:     build.Priority,
+		Requirements: build.Requirements,
+		PreviewOnly:  build.PreviewOnly,
+		Hooks:        build.Hooks,
+	}
+	for name := range build.EnvVars {
+		input.EnvVars = append(input.EnvVars, name)
+	}
+	sort.Strings(input.EnvVars)
+	return input
+}
+
+// keepBuildsAlive marks the unfinished builds of this process alive and
+// recovers those of stopped instances until the pipeline shuts down
+func (p *Pipeline) keepBuildsAlive() {
+	defer p.running.Done()
+
+	ticker := time.NewTicker(p.staleAfter() / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.rootCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(p.rootCtx, persistTimeout)
+		if err := p.store.TouchBuilds(ctx, p.instance, time.Now()); err != nil && ctx.Err() == nil {
+			p.logger.Warn("failed to mark builds alive", zap.Error(err))
+		}
+		cancel()
+
+		ctx, cancel = context.WithTimeout(p.rootCtx, recoveryTimeout)
+		if _, err := p.RecoverBuilds(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Warn("failed to recover interrupted builds", zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+// RecoverBuilds marks the builds left unfinished by stopped instances
+// interrupted, requeues them when configured and removes what those of
+// this host left behind. A build is abandoned when an earlier process on
+// this host ran it, or when it was not marked alive within stale_after.
+// Replicas recovering at once interrupt each build only once.
+func (p *Pipeline) RecoverBuilds(ctx context.Context) ([]*types.Build, error) {
+	if p.store == nil {
+		return nil, nil
+	}
+	builds, err := p.store.ListUnfinishedBuilds(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unfinished builds: %w", err)
+	}
+
+	now := time.Now()
+	var interrupted []*types.Build
+	for i := range builds {
+		build := &builds[i]
+		if !p.abandoned(build, now) {
+			continue
+		}
+		deploying := build.Deploying
+		if err := p.interrupt(ctx, build, now); err != nil {
+			if !errors.Is(err, types.ErrBuildChanged) {
+				p.logger.Error("failed to interrupt build", zap.String("build_id", build.ID), zap.Error(err))
+			}
+			continue
+		}
+		interrupted = append(interrupted, build)
+
+		if p.config.Recovery.Requeue {
+			p.requeueInterrupted(ctx, build)
+		}
+		if sameHost(build.Instance, p.instance) {
+			p.removeLeftovers(ctx, build, deploying)
+		}
+	}
+	return interrupted, nil
+}
+
+// abandoned reports whether no live instance runs the build. Builds
+// stored before instances were recorded are judged by their last write.
+func (p *Pipeline) abandoned(build *types.Build, now time.Time) bool {
+	if build.Instance == p.instance {
+		return false
+	}
+	if sameHost(build.Instance, p.instance) {
+		return true
+	}
+	return now.Sub(build.UpdatedAt) > p.staleAfter()
+}
+
+// interrupt marks the build interrupted unless it changed since it was
+// read, in which case types.ErrBuildChanged is returned
+func (p *Pipeline) interrupt(ctx context.Context, build *types.Build, now time.Time) error {
+	owner := build.Instance
+	if owner == "" {
+		owner = "an earlier server"
+	}
+	phase := "build"
+	if build.Deploying {
+		phase = "deploy"
+	}
+
+	build.Status = types.BuildStatusInterrupted
+	build.Deploying = false
+	build.CompleteTime = &now
+	build.ErrorMessage = fmt.Sprintf("%s stopped before the %s finished", owner, phase)
+	build.AddEvent(types.EventInterrupted, "", owner)
+	if err := p.store.InterruptBuild(ctx, build, build.Events); err != nil {
+		return err
+	}
+
+	p.logger.Warn("interrupted abandoned build",
+		zap.String("build_id", build.ID),
+		zap.String("project_id", build.ProjectID),
+		zap.String("instance", owner))
+	p.notify(types.LifecycleBuildInterrupted, build, build.ErrorMessage)
+	return nil
+}
+
+// requeueInterrupted starts an interrupted build again and records the
+// new build, or why there is none, on it
+func (p *Pipeline) requeueInterrupted(ctx context.Context, build *types.Build) {
+	var message string
+	requeued, err := p.restartBuild(ctx, build)
+	if err != nil {
+		message = "not requeued: " + err.Error()
+		p.logger.Warn("interrupted build not requeued", zap.String("build_id", build.ID), zap.Error(err))
+	} else {
+		message = "requeued as build " + requeued.ID
+	}
+
+	stored := len(build.Events)
+	build.AddEvent(types.EventRequeued, "", message)
+	if err := p.store.SaveBuild(ctx, build, build.Events[stored:]); err != nil {
+		p.logger.Error("failed to persist build", zap.String("build_id", build.ID), zap.Error(err))
+	}
+}
+
+// restartBuild starts an interrupted build again under a new ID from its
+// recorded input. Builds started with env vars are not, since their
+// values were not kept.
+func (p *Pipeline) restartBuild(ctx context.Context, build *types.Build) (*types.Build, error) {
+	input := build.Input
+	if input == nil {
+		return nil, errors.New("its input was not recorded")
+	}
+	if len(input.EnvVars) > 0 {
+		return nil, fmt.Errorf("the values of its env vars %s were not kept", strings.Join(input.EnvVars, ", "))
+	}
+
+	requeued := &types.Build{
+		ProjectID:    build.ProjectID,
+		CommitHash:   build.CommitHash,
+		Commit:       build.Commit,
+		Framework:    build.Framework,
+		Environment:  build.Environment,
+		BuildCommand: input.BuildCommand,
+		OutputDir:    input.OutputDir,
+		NodeVersion:  input.NodeVersion,
+		Platforms:    input.Platforms,
+		Source:       input.Source,
+		Dedup:        input.Dedup,
+		Priority:     input.Priority,
+		Requirements: input.Requirements,
+		PreviewOnly:  input.PreviewOnly,
+		Hooks:        input.Hooks,
+	}
+	if err := p.StartBuild(ctx, requeued); err != nil {
+		return nil, err
+	}
+	return requeued, nil
+}
+
+// removeLeftovers removes the directories, containers and stage images an
+// interrupted build left on this host. Artifacts of builds interrupted
+// while deploying are kept since the deployment may use them.
+func (p *Pipeline) removeLeftovers(ctx context.Context, build *types.Build, deploying bool) {
+	layout, err := builder.NewLayout(p.config)
+	if err == nil {
+		err = builder.RemoveBuildContext(layout, build.ID, deploying)
+	}
+	if err != nil {
+		p.logger.Warn("failed to remove build directories", zap.String("build_id", build.ID), zap.Error(err))
+	}
+	if remover, ok := p.builderFactory.(leftoverRemover); ok {
+		if err := remover.RemoveLeftovers(ctx, build); err != nil {
+			p.logger.Warn("failed to remove build containers", zap.String("build_id", build.ID), zap.Error(err))
+		}
+	}
+}
+
+// sameHost reports whether two instance IDs, host and process ID, name
+// the same host
+func sameHost(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	host := func(instance string) string {
+		if i := strings.LastIndex(instance, "-"); i >= 0 {
+			return instance[:i]
+		}
+		return instance
+	}
+	return host(a) == host(b)
+}
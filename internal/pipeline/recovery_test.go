@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// leftoverFactory remembers the builds whose containers it removed
+type leftoverFactory struct {
+	*mockBuilderFactory
+	removed []string
+}
+
+func (f *leftoverFactory) RemoveLeftovers(_ context.Context, build *types.Build) error {
+	f.removed = append(f.removed, build.ID)
+	return nil
+}
+
+func TestPipeline_StartBuildRecordsInput(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.instance = "web-1-200"
+
+	build := createTestBuild()
+	build.EnvVars = map[string]string{"VITE_B": "b", "VITE_A": "a"}
+	require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	assert.Equal(t, "web-1-200", build.Instance)
+	require.NotNil(t, build.Input)
+	assert.Equal(t, filepath.Base(testSourceDir), build.Input.SourceDir)
+	assert.Equal(t, "build", build.Input.BuildCommand)
+	assert.Equal(t, []string{"VITE_A", "VITE_B"}, build.Input.EnvVars)
+	assert.False(t, build.Deploying)
+}
+
+func TestPipeline_RecoverBuilds(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.instance = "web-1-200"
+	pipeline.config.BuildDir = t.TempDir()
+	pipeline.config.ArtifactsDir = "" // Kept in <build_dir>/artifacts
+	pipeline.config.Recovery.Requeue = true
+	factory := &leftoverFactory{mockBuilderFactory: pipeline.builderFactory.(*mockBuilderFactory)}
+	pipeline.builderFactory = factory
+	notifier := &recordingNotifier{}
+	pipeline.notifier = notifier
+
+	now := time.Now()
+	input := &types.BuildInput{SourceDir: "test-project", BuildCommand: "build", OutputDir: "build"}
+	store := &recordingStore{
+		eventStatus: make(map[types.DeploymentEventType]types.BuildStatus),
+		changed:     map[string]bool{"taken": true},
+		unfinished: []types.Build{
+			// An earlier process on this host
+			{ID: "restarted", ProjectID: "test-project", Framework: "react", Status: types.BuildStatusBuilding, Instance: "web-1-100", Input: input, UpdatedAt: now},
+			// A replica that stopped marking its deploy alive
+			{ID: "stale", ProjectID: "shop", Status: types.BuildStatusSuccess, Deploying: true, Instance: "web-2-100", UpdatedAt: now.Add(-time.Hour)},
+			// A replica still running its build
+			{ID: "alive", ProjectID: "shop", Status: types.BuildStatusBuilding, Instance: "web-2-100", UpdatedAt: now},
+			{ID: "own", ProjectID: "shop", Status: types.BuildStatusPending, Instance: "web-1-200", UpdatedAt: now.Add(-time.Hour)},
+			{ID: "secret", ProjectID: "shop", Status: types.BuildStatusPending, Instance: "web-1-100", UpdatedAt: now,
+				Input: &types.BuildInput{SourceDir: input.SourceDir, EnvVars: []string{"API_KEY"}}},
+			// Interrupted by another replica first
+			{ID: "taken", ProjectID: "shop", Status: types.BuildStatusBuilding, Instance: "web-1-100", UpdatedAt: now},
+		},
+	}
+	pipeline.store = store
+	pipeline.storedEvents = make(map[string]int)
+
+	for _, dir := range []string{"builds", "cache", "artifacts"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(pipeline.config.BuildDir, dir, "restarted"), 0755))
+	}
+
+	interrupted, err := pipeline.RecoverBuilds(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	require.Len(t, interrupted, 3)
+	restarted, stale, secret := interrupted[0], interrupted[1], interrupted[2]
+	assert.Equal(t, "restarted", restarted.ID)
+	assert.Equal(t, "stale", stale.ID)
+	assert.Equal(t, "secret", secret.ID)
+	for _, build := range interrupted {
+		assert.Equal(t, types.BuildStatusInterrupted, build.Status)
+		assert.False(t, build.Deploying)
+		assert.NotNil(t, build.CompleteTime)
+	}
+	assert.Equal(t, "web-2-100 stopped before the deploy finished", stale.ErrorMessage)
+
+	// The restarted build runs again under a new ID
+	require.Len(t, store.created, 1)
+	require.Len(t, restarted.Events, 2)
+	assert.Equal(t, types.EventInterrupted, restarted.Events[0].Type)
+	assert.Equal(t, "requeued as build "+store.created[0], restarted.Events[1].Message)
+	requeued, err := pipeline.GetBuild(store.created[0])
+	require.NoError(t, err)
+	assert.Equal(t, "test-project", requeued.ProjectID)
+	assert.Equal(t, types.BuildStatusSuccess, requeued.Status)
+
+	// Env var values were not kept
+	require.Len(t, secret.Events, 2)
+	assert.Equal(t, "not requeued: the values of its env vars API_KEY were not kept", secret.Events[1].Message)
+
+	// Leftovers are only removed for builds of this host
+	assert.Equal(t, []string{"restarted", "secret"}, factory.removed)
+	for _, dir := range []string{"builds", "cache", "artifacts"} {
+		assert.NoDirExists(t, filepath.Join(pipeline.config.BuildDir, dir, "restarted"))
+	}
+	assert.Contains(t, notifier.events, types.LifecycleBuildInterrupted)
+}
+
+func TestSameHost(t *testing.T) {
+	assert.True(t, sameHost("web-1-100", "web-1-200"))
+	assert.False(t, sameHost("web-1-100", "web-2-100"))
+	assert.False(t, sameHost("", "web-1-200"))
+}
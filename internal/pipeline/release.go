@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultReleaseEnvironment = "production"
+	defaultReleaseTagFormat   = "{env}-{timestamp}"
+	defaultReleaseTimeout     = 30 * time.Second
+
+	// maxChangelog caps the commits kept on a release, the newest are kept
+	maxChangelog = 100
+)
+
+// Releaser creates releases at the git provider a build was pushed to
+type Releaser interface {
+	// Changelog returns the commits after base up to the build's commit,
+	// oldest first, and their total, which may exceed those returned
+	Changelog(ctx context.Context, build *types.Build, base string) ([]types.ChangelogEntry, int, error)
+	// CreateRelease tags the build's commit and returns the release's URL
+	CreateRelease(ctx context.Context, build *types.Build, tag, notes string) (string, error)
+}
+
+// releaseDeploy records the commits a deploy shipped since the previous
+// release of its environment and tags the commit at the git provider.
+// Failures are recorded on the release and never fail the deploy.
+func (p *Pipeline) releaseDeploy(ctx context.Context, build *types.Build) {
+	cfg := &p.config.Releases
+	if !cfg.Enabled || p.releaser == nil || build.Source == nil || build.CommitHash == "" || !releasesEnvironment(cfg.Environments, build.Environment) {
+		return
+	}
+
+	timeout := defaultReleaseTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	release := &types.Release{Commit: build.CommitHash, CreatedAt: time.Now()}
+	var errs []string
+	previous, err := p.previousRelease(ctx, build)
+	if err != nil {
+		errs = append(errs, "previous release: "+err.Error())
+	}
+	if previous != nil {
+		release.Previous = previous.Commit
+	}
+	if release.Previous != "" && release.Previous != release.Commit {
+		changelog, total, err := p.releaser.Changelog(ctx, build, release.Previous)
+		if err != nil {
+			errs = append(errs, "changelog: "+err.Error())
+		}
+		if len(changelog) > maxChangelog {
+			changelog = changelog[len(changelog)-maxChangelog:]
+		}
+		release.Changelog, release.Commits = changelog, total
+	}
+
+	if cfg.Tag {
+		tag := releaseTag(cfg.TagFormat, build, release.CreatedAt)
+		url, err := p.releaser.CreateRelease(ctx, build, tag, releaseNotes(release))
+		if err != nil {
+			errs = append(errs, "tag: "+err.Error())
+		} else {
+			release.Tag, release.URL = tag, url
+		}
+	}
+	release.Error = strings.Join(errs, "; ")
+
+	event, message := types.EventReleased, release.Tag
+	switch {
+	case release.Error != "":
+		event, message = types.EventReleaseFailed, release.Error
+		p.logger.Warn("failed to release deploy",
+			zap.String("build_id", build.ID),
+			zap.String("error", release.Error))
+	case message == "":
+		message = fmt.Sprintf("%d commits since the previous release", release.Commits)
+	}
+
+	p.updateBuild(build, func(b *types.Build) {
+		b.Release = release
+		b.AddEvent(event, "", message)
+	})
+}
+
+// releasesEnvironment reports whether deploys to environment are released
+func releasesEnvironment(environments []string, environment string) bool {
+	if len(environments) == 0 {
+		return environment == defaultReleaseEnvironment
+	}
+	return slices.Contains(environments, environment)
+}
+
+// previousRelease returns the latest release of the project's environment
+// before build, or nil when it was never released
+func (p *Pipeline) previousRelease(ctx context.Context, build *types.Build) (*types.Release, error) {
+	if p.store != nil {
+		return p.store.LatestRelease(ctx, build.ProjectID, build.Environment)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var latest *types.Build
+	for _, b := range p.builds {
+		if b == build || b.ProjectID != build.ProjectID || b.Environment != build.Environment || b.Release == nil {
+			continue
+		}
+		if latest == nil || b.Release.CreatedAt.After(latest.Release.CreatedAt) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return latest.Release, nil
+}
+
+// releaseTag names the release of a build, e.g. production-20250319-090000
+func releaseTag(format string, build *types.Build, at time.Time) string {
+	if format == "" {
+		format = defaultReleaseTagFormat
+	}
+	return strings.NewReplacer(
+		"{env}", build.Environment,
+		"{timestamp}", at.UTC().Format("20060102-150405"),
+		"{commit}", build.ShortHash(),
+		"{build}", build.ID,
+	).Replace(format)
+}
+
+// releaseNotes lists the release's commits, newest first, as markdown
+func releaseNotes(release *types.Release) string {
+	if release.Previous == "" {
+		return "First release of " + release.Commit
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Changes since %s:\n\n", release.Previous)
+	for i := len(release.Changelog) - 1; i >= 0; i-- {
+		entry := release.Changelog[i]
+		commit := entry.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		fmt.Fprintf(&b, "- %s %s", commit, entry.Subject)
+		if entry.Author != "" {
+			fmt.Fprintf(&b, " (%s)", entry.Author)
+		}
+		b.WriteString("\n")
+	}
+	if omitted := release.Commits - len(release.Changelog); omitted > 0 {
+		fmt.Fprintf(&b, "- and %d earlier commits\n", omitted)
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+type fakeReleaser struct {
+	base       string
+	tag, notes string
+	tagErr     error
+}
+
+func (f *fakeReleaser) Changelog(_ context.Context, _ *types.Build, base string) ([]types.ChangelogEntry, int, error) {
+	f.base = base
+	return []types.ChangelogEntry{
+		{Commit: "1111111111", Author: "Jane", Subject: "Add cart"},
+		{Commit: "2222222222", Author: "John", Subject: "Fix checkout"},
+	}, 3, nil
+}
+
+func (f *fakeReleaser) CreateRelease(_ context.Context, _ *types.Build, tag, notes string) (string, error) {
+	f.tag, f.notes = tag, notes
+	if f.tagErr != nil {
+		return "", f.tagErr
+	}
+	return "https://github.com/acme/shop/releases/tag/" + tag, nil
+}
+
+func releasedBuild(id, commit string) *types.Build {
+	return &types.Build{
+		ID:          id,
+		ProjectID:   "shop",
+		CommitHash:  commit,
+		Environment: "production",
+		Source:      &types.BuildSource{Provider: "github", Repository: "acme/shop"},
+	}
+}
+
+func TestPipeline_ReleaseDeploy(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	releaser := &fakeReleaser{}
+	pipeline.releaser = releaser
+	pipeline.config.Releases.Enabled = true
+	pipeline.config.Releases.Tag = true
+
+	previous := releasedBuild("b1", "0000000000")
+	previous.Release = &types.Release{Commit: "0000000000", CreatedAt: time.Now().Add(-time.Hour)}
+	pipeline.builds[previous.ID] = previous
+
+	build := releasedBuild("b2", "2222222222")
+	pipeline.builds[build.ID] = build
+	pipeline.releaseDeploy(context.Background(), build)
+
+	require.NotNil(t, build.Release)
+	assert.Equal(t, "0000000000", releaser.base)
+	assert.Equal(t, "0000000000", build.Release.Previous)
+	assert.Len(t, build.Release.Changelog, 2)
+	assert.Equal(t, 3, build.Release.Commits)
+	assert.Regexp(t, `^production-\d{8}-\d{6}$`, build.Release.Tag)
+	assert.Equal(t, "https://github.com/acme/shop/releases/tag/"+build.Release.Tag, build.Release.URL)
+	assert.Equal(t, "Changes since 0000000000:\n\n- 2222222 Fix checkout (John)\n- 1111111 Add cart (Jane)\n- and 1 earlier commits\n", releaser.notes)
+	assert.Equal(t, types.EventReleased, build.Events[len(build.Events)-1].Type)
+
+	// A failed tag keeps the changelog
+	releaser.tagErr = errors.New("github rejected the credentials")
+	next := releasedBuild("b3", "3333333333")
+	pipeline.builds[next.ID] = next
+	pipeline.releaseDeploy(context.Background(), next)
+
+	require.NotNil(t, next.Release)
+	assert.Equal(t, "2222222222", next.Release.Previous)
+	assert.Empty(t, next.Release.Tag)
+	assert.Len(t, next.Release.Changelog, 2)
+	assert.Equal(t, "tag: github rejected the credentials", next.Release.Error)
+	assert.Equal(t, types.EventReleaseFailed, next.Events[len(next.Events)-1].Type)
+}
+
+func TestPipeline_ReleaseDeploy_SkipsOtherEnvironments(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.releaser = &fakeReleaser{}
+	pipeline.config.Releases.Enabled = true
+
+	staging := releasedBuild("b1", "1111111111")
+	staging.Environment = "staging"
+	pipeline.releaseDeploy(context.Background(), staging)
+	assert.Nil(t, staging.Release)
+
+	manual := releasedBuild("b2", "2222222222")
+	manual.Source = nil
+	pipeline.releaseDeploy(context.Background(), manual)
+	assert.Nil(t, manual.Release)
+
+	first := releasedBuild("b3", "3333333333")
+	pipeline.releaseDeploy(context.Background(), first)
+	require.NotNil(t, first.Release)
+	assert.Empty(t, first.Release.Previous)
+	assert.Empty(t, first.Release.Changelog)
+}
+
+func TestReleaseTag(t *testing.T) {
+	build := releasedBuild("build-7", "0123abcdef")
+	at := time.Date(2025, 3, 19, 9, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "production-20250319-090000", releaseTag("", build, at))
+	assert.Equal(t, "v-0123abc-build-7", releaseTag("v-{commit}-{build}", build, at))
+}
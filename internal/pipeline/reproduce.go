@@ -0,0 +1,265 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/integrity"
+	"github.com/elskow/chef-infra/internal/pipeline/provenance"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const (
+	// manifestFile keeps the file list of a build's artifact in its
+	// artifact directory, which outlives the artifact itself
+	manifestFile = "manifest.json"
+
+	// maxFileChanges caps the changes returned by a verification
+	maxFileChanges = 100
+)
+
+var ErrNotVerifiable = errors.New("build cannot be verified")
+
+// recordArtifactDigest records the digest of a finished build's artifact
+// and keeps its file list so a verification can show which files differ.
+// Failing to read the artifact only leaves the build unverifiable.
+func (p *Pipeline) recordArtifactDigest(build *types.Build, artifactPath, artifactDir string) {
+	manifest, err := integrity.ReadManifest(artifactPath)
+	if err != nil {
+		p.logger.Warn("failed to hash artifact",
+			zap.String("build_id", build.ID),
+			zap.Error(err))
+		return
+	}
+	build.ArtifactDigest = manifest.Digest()
+
+	data, err := json.Marshal(manifest)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(artifactDir, manifestFile), data, 0644)
+	}
+	if err != nil {
+		p.logger.Warn("failed to keep artifact manifest",
+			zap.String("build_id", build.ID),
+			zap.Error(err))
+	}
+}
+
+// VerifyBuild rebuilds a past build from its recorded inputs and compares
+// the artifacts file by file, ignoring archive metadata. sourceDir,
+// relative to the source root, overrides the build's own sources, e.g. a
+// fresh checkout of its commit. When the build has provenance the sources
+// must match its inputs digest, so a mismatch points at the toolchain.
+//
+// The rebuild runs under its own ID without a commit, so it neither
+// replaces the build's image nor pushes to the registry.
+func (p *Pipeline) VerifyBuild(ctx context.Context, buildID, sourceDir string) (*types.Verification, error) {
+	build, err := p.LookupBuild(ctx, buildID)
+	if err != nil {
+		return nil, err
+	}
+	if build.ArtifactDigest == "" {
+		return nil, fmt.Errorf("%w: no artifact digest was recorded for build %s", ErrNotVerifiable, buildID)
+	}
+
+	if sourceDir != "" {
+		if sourceDir, err = p.resolveSource(sourceDir); err != nil {
+			return nil, err
+		}
+	} else if sourceDir, _ = build.BuilderConfig["sourceDir"].(string); sourceDir == "" {
+		return nil, fmt.Errorf("%w: the sources of build %s are unknown, pass a source directory", ErrNotVerifiable, buildID)
+	}
+	if _, err := os.Stat(sourceDir); err != nil {
+		return nil, fmt.Errorf("%w: sources of build %s are unavailable: %v", ErrNotVerifiable, buildID, err)
+	}
+
+	verification := &types.Verification{
+		BuildID:        build.ID,
+		ArtifactDigest: build.ArtifactDigest,
+	}
+	if build.Provenance != nil {
+		digest, err := provenance.InputsDigest(sourceDir)
+		if err != nil {
+			return nil, err
+		}
+		if digest != build.Provenance.InputsDigest {
+			return nil, fmt.Errorf("%w: sources do not match the inputs recorded in the provenance of build %s", ErrNotVerifiable, buildID)
+		}
+		verification.InputsVerified = true
+	}
+
+	rebuilt, err := p.rebuild(ctx, build, sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	verification.RebuildDigest = rebuilt.Digest()
+	verification.Reproducible = verification.RebuildDigest == build.ArtifactDigest
+	verification.VerifiedAt = time.Now()
+
+	if !verification.Reproducible {
+		original, err := p.readArtifactManifest(build.ID)
+		if err != nil {
+			p.logger.Warn("file list of the original artifact is unavailable",
+				zap.String("build_id", build.ID),
+				zap.Error(err))
+		} else {
+			changes := original.Diff(rebuilt)
+			verification.ChangeCount = len(changes)
+			if len(changes) > maxFileChanges {
+				changes = changes[:maxFileChanges]
+			}
+			verification.Changes = changes
+		}
+	}
+
+	p.logger.Info("verified build",
+		zap.String("build_id", build.ID),
+		zap.Bool("reproducible", verification.Reproducible),
+		zap.Int("changes", verification.ChangeCount))
+	return verification, nil
+}
+
+// rebuild builds the recorded inputs of build from sourceDir and returns
+// the file list of the artifact
+func (p *Pipeline) rebuild(ctx context.Context, build *types.Build, sourceDir string) (integrity.Manifest, error) {
+	rebuild := &types.Build{
+		ID:            "verify-" + uuid.NewString(),
+		ProjectID:     build.ProjectID,
+		Status:        types.BuildStatusBuilding,
+		BuilderConfig: map[string]interface{}{"sourceDir": sourceDir},
+		Framework:     build.Framework,
+		BuildCommand:  build.BuildCommand,
+		OutputDir:     build.OutputDir,
+		NodeVersion:   build.NodeVersion,
+		Environment:   build.Environment,
+		EnvVars:       build.EnvVars,
+		StartTime:     time.Now(),
+	}
+
+	layout, err := builder.NewLayout(p.config)
+	if err != nil {
+		return nil, err
+	}
+	buildContext, err := builder.NewBuildContext(layout, rebuild.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build context: %w", err)
+	}
+	defer func() {
+		for _, dir := range []string{buildContext.BuildDir, buildContext.ArtifactDir, buildContext.CacheDir} {
+			if err := os.RemoveAll(dir); err != nil {
+				p.logger.Error("cleanup failed",
+					zap.String("build_id", rebuild.ID),
+					zap.Error(err))
+			}
+		}
+	}()
+
+	buildEnv, err := p.buildTimeEnv(rebuild)
+	if err != nil {
+		return nil, err
+	}
+	b, err := p.builderFactory.CreateBuilder(rebuild.Framework, &builder.Options{
+		WorkDir:     buildContext.BuildDir,
+		ArtifactDir: buildContext.ArtifactDir,
+		CacheDir:    buildContext.CacheDir,
+		Environment: buildEnv,
+		Timeouts:    p.phaseTimeouts(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create builder: %w", err)
+	}
+	defer func() {
+		if err := b.Cleanup(); err != nil {
+			p.logger.Error("cleanup failed",
+				zap.String("build_id", rebuild.ID),
+				zap.Error(err))
+		}
+	}()
+
+	result, err := b.Build(ctx, rebuild)
+	if err != nil {
+		return nil, fmt.Errorf("rebuild failed: %w", err)
+	}
+	manifest, err := integrity.ReadManifest(result.ArtifactPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash rebuilt artifact: %w", err)
+	}
+	return manifest, nil
+}
+
+// readArtifactManifest loads the file list kept by recordArtifactDigest
+func (p *Pipeline) readArtifactManifest(buildID string) (integrity.Manifest, error) {
+	layout, err := builder.NewLayout(p.config)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(layout.ArtifactDir(buildID), manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest integrity.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// VerifyBuild is restricted to admins by the auth interceptor since a
+// rebuild occupies the build host
+func (h *Handler) VerifyBuild(ctx context.Context, req *pb.VerifyBuildRequest) (*pb.VerifyBuildResponse, error) {
+	if req.BuildId == "" {
+		return nil, status.Error(codes.InvalidArgument, "build id is required")
+	}
+
+	verification, err := h.pipeline.VerifyBuild(ctx, req.BuildId, req.SourceDir)
+	if err != nil {
+		switch {
+		case errors.Is(err, types.ErrBuildNotFound):
+			return nil, status.Error(codes.NotFound, "build not found")
+		case errors.Is(err, ErrNotVerifiable):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, ErrInvalidBuild):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to verify build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to verify build")
+	}
+
+	username, _ := auth.GetUserFromContext(ctx)
+	h.audit(username, "build.verify", req.BuildId, map[string]interface{}{
+		"reproducible":    verification.Reproducible,
+		"inputs_verified": verification.InputsVerified,
+		"changes":         verification.ChangeCount,
+	})
+
+	resp := &pb.VerifyBuildResponse{
+		BuildId:        verification.BuildID,
+		Reproducible:   verification.Reproducible,
+		InputsVerified: verification.InputsVerified,
+		ArtifactDigest: verification.ArtifactDigest,
+		RebuildDigest:  verification.RebuildDigest,
+		ChangeCount:    int32(verification.ChangeCount),
+		VerifiedAt:     verification.VerifiedAt.Unix(),
+	}
+	for _, change := range verification.Changes {
+		resp.Changes = append(resp.Changes, &pb.FileChange{
+			Path:     change.Path,
+			Change:   change.Change,
+			Original: change.Original,
+			Rebuilt:  change.Rebuilt,
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+func writeTestArtifact(t *testing.T, files map[string]string) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "artifact-*.tar")
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	defer tw.Close()
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "html/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	return f.Name()
+}
+
+func TestPipeline_VerifyBuild(t *testing.T) {
+	p, mock, _, _ := setupTestPipeline(t)
+	p.config.BuildDir = t.TempDir()
+	p.config.ArtifactsDir = "" // Kept in <build_dir>/artifacts
+
+	files := map[string]string{"index.html": "<script src=/app.js></script>", "app.js": "v1"}
+	build := &types.Build{
+		ID:            "b1",
+		ProjectID:     "shop",
+		Status:        types.BuildStatusSuccess,
+		Framework:     "react",
+		BuilderConfig: map[string]interface{}{"sourceDir": t.TempDir()},
+	}
+	artifactDir := filepath.Join(p.config.BuildDir, "artifacts", build.ID)
+	require.NoError(t, os.MkdirAll(artifactDir, 0755))
+	p.recordArtifactDigest(build, writeTestArtifact(t, files), artifactDir)
+	require.NotEmpty(t, build.ArtifactDigest)
+	p.builds[build.ID] = build
+
+	mock.artifact = writeTestArtifact(t, files)
+	verification, err := p.VerifyBuild(context.Background(), "b1", "")
+	require.NoError(t, err)
+	assert.True(t, verification.Reproducible)
+	assert.Equal(t, build.ArtifactDigest, verification.RebuildDigest)
+	assert.Empty(t, verification.Changes)
+
+	mock.artifact = writeTestArtifact(t, map[string]string{"index.html": files["index.html"], "app.js": "v2", "extra.js": ""})
+	verification, err = p.VerifyBuild(context.Background(), "b1", "")
+	require.NoError(t, err)
+	assert.False(t, verification.Reproducible)
+	assert.Equal(t, 2, verification.ChangeCount)
+	require.Len(t, verification.Changes, 2)
+	assert.Equal(t, "/app.js", verification.Changes[0].Path)
+	assert.Equal(t, types.FileModified, verification.Changes[0].Change)
+	assert.Equal(t, types.FileAdded, verification.Changes[1].Change)
+
+	// The rebuild leaves nothing behind
+	entries, err := os.ReadDir(filepath.Join(p.config.BuildDir, "artifacts"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	build.Provenance = &types.Provenance{InputsDigest: "other"}
+	_, err = p.VerifyBuild(context.Background(), "b1", "")
+	assert.ErrorIs(t, err, ErrNotVerifiable)
+}
+
+func TestHandler_VerifyBuild(t *testing.T) {
+	p, _, _, _ := setupTestPipeline(t)
+	p.builds["b1"] = &types.Build{ID: "b1", ProjectID: "shop", Status: types.BuildStatusFailed}
+	h := NewHandler(p, nil, nil, nil, nil, ownerAuthorizer{}, nil, zap.NewNop())
+
+	_, err := h.VerifyBuild(context.Background(), &pb.VerifyBuildRequest{BuildId: "b1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = h.VerifyBuild(context.Background(), &pb.VerifyBuildRequest{BuildId: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = h.VerifyBuild(context.Background(), &pb.VerifyBuildRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const defaultHighPriorityEnvironment = "production"
+
+// priority returns the build's priority, defaulting by its environment
+func (p *Pipeline) priority(build *types.Build) types.BuildPriority {
+	if build.Priority != "" {
+		return build.Priority
+	}
+	environments := p.config.Scheduling.HighPriorityEnvironments
+	if len(environments) == 0 {
+		environments = []string{defaultHighPriorityEnvironment}
+	}
+	for _, env := range environments {
+		if build.Environment == env {
+			return types.PriorityHigh
+		}
+	}
+	return types.PriorityNormal
+}
+
+// launch runs the build once a build slot is free. Without one the build
+// waits by priority and, when preemption is enabled, a running build of
+// lower priority is cancelled to make room.
+func (p *Pipeline) launch(build *types.Build) {
+	p.mu.Lock()
+	if p.active == nil {
+		p.active = make(map[*types.Build]bool)
+	}
+	limit := p.config.Scheduling.MaxConcurrentBuilds
+	if limit <= 0 || len(p.active) < limit {
+		p.active[build] = false
+		p.mu.Unlock()
+		p.start(build)
+		return
+	}
+
+	p.enqueue(build)
+	p.logger.Info("build waiting for a build slot",
+		zap.String("build_id", build.ID),
+		zap.String("priority", string(p.priority(build))),
+		zap.Int("waiting", len(p.waiting)))
+	if p.config.Scheduling.Preempt {
+		p.preemptFor(build)
+	}
+	p.mu.Unlock()
+}
+
+// start runs the build in the background. Once it finishes, a preempted
+// build waits again; any other hands its branch lane to the next queued
+// build. Either way its slot goes to the next waiting build.
+func (p *Pipeline) start(build *types.Build) {
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		if p.run(build) {
+			p.requeue(build)
+		} else {
+			p.mu.Lock()
+			delete(p.active, build)
+			p.mu.Unlock()
+			p.release(build)
+		}
+		p.dispatch()
+	}()
+}
+
+// enqueue inserts the build among the waiting builds, highest priority
+// first and in the order they were started within a priority. Callers hold
+// mu.
+func (p *Pipeline) enqueue(build *types.Build) {
+	rank := p.priority(build).Rank()
+	i := sort.Search(len(p.waiting), func(i int) bool {
+		other := p.waiting[i]
+		if otherRank := p.priority(other).Rank(); otherRank != rank {
+			return otherRank < rank
+		}
+		return other.StartTime.After(build.StartTime)
+	})
+	p.waiting = append(p.waiting, nil)
+	copy(p.waiting[i+1:], p.waiting[i:])
+	p.waiting[i] = build
+}
+
+// preemptFor cancels the running build of lowest priority below the
+// waiting build's, the latest started first so the least work is lost.
+// Builds that are deploying are left to finish. Builds already preempted
+// count toward the waiting builds that outrank their victims, so a burst
+// of builds does not cancel more than it needs. Callers hold mu.
+func (p *Pipeline) preemptFor(build *types.Build) {
+	rank := p.priority(build).Rank()
+
+	var victim *types.Build
+	var victimRank, preempting int
+	for running, preempted := range p.active {
+		if preempted {
+			preempting++
+			continue
+		}
+		r := p.priority(running).Rank()
+		if running.Status != types.BuildStatusBuilding || r >= rank {
+			continue
+		}
+		if victim == nil || r < victimRank || (r == victimRank && running.StartTime.After(victim.StartTime)) {
+			victim, victimRank = running, r
+		}
+	}
+	if victim == nil {
+		return
+	}
+
+	outranking := 0
+	for _, waiting := range p.waiting {
+		if p.priority(waiting).Rank() > victimRank {
+			outranking++
+		}
+	}
+	if preempting >= outranking {
+		return
+	}
+
+	p.active[victim] = true
+	if victim.CancelFunc != nil {
+		victim.CancelFunc()
+	}
+	victim.AddEvent(types.EventPreempted, "", "preempted by build "+build.ID)
+	p.logger.Info("preempting build",
+		zap.String("build_id", victim.ID),
+		zap.String("priority", string(p.priority(victim))),
+		zap.String("preempted_by", build.ID),
+		zap.String("preempted_by_priority", string(p.priority(build))))
+}
+
+// preempted reports whether the build was cancelled to make room for
+// another
+func (p *Pipeline) preempted(build *types.Build) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.active[build]
+}
+
+// requeue returns a preempted build to the waiting builds. It keeps its
+// branch lane so later pushes still wait behind it.
+func (p *Pipeline) requeue(build *types.Build) {
+	p.mu.Lock()
+	delete(p.active, build)
+	build.Status = types.BuildStatusPending
+	build.CancelFunc = nil
+	p.enqueue(build)
+	p.mu.Unlock()
+
+	p.persist(build)
+	p.logger.Info("requeued preempted build",
+		zap.String("build_id", build.ID),
+		zap.String("priority", string(p.priority(build))))
+}
+
+// dispatch starts waiting builds while build slots are free. Waiting
+// builds are cancelled instead once the pipeline is shutting down.
+func (p *Pipeline) dispatch() {
+	p.mu.Lock()
+	var next, cancelled []*types.Build
+	if p.baseContext().Err() != nil {
+		cancelled = p.waiting
+		p.waiting = nil
+	} else {
+		limit := p.config.Scheduling.MaxConcurrentBuilds
+		for len(p.waiting) > 0 && (limit <= 0 || len(p.active) < limit) {
+			build := p.waiting[0]
+			p.waiting = p.waiting[1:]
+			p.active[build] = false
+			next = append(next, build)
+		}
+	}
+
+	now := time.Now()
+	for _, build := range cancelled {
+		build.Status = types.BuildStatusCancelled
+		build.CompleteTime = &now
+	}
+	p.mu.Unlock()
+
+	for _, build := range cancelled {
+		p.persist(build)
+		p.notify(types.LifecycleBuildCancelled, build, "pipeline shutting down")
+	}
+	for _, build := range next {
+		p.logger.Info("starting waiting build",
+			zap.String("build_id", build.ID),
+			zap.String("priority", string(p.priority(build))))
+		p.start(build)
+	}
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// startRecorder records the builds in the order they start, and any
+// failures
+type startRecorder struct {
+	mu      sync.Mutex
+	started []string
+	failed  []string
+}
+
+func (r *startRecorder) Notify(event types.LifecycleEvent, build *types.Build, _ string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch event {
+	case types.LifecycleBuildStarted:
+		r.started = append(r.started, build.ID)
+	case types.LifecycleBuildFailed:
+		r.failed = append(r.failed, build.ID)
+	}
+}
+
+func priorityBuild(id string, priority types.BuildPriority) *types.Build {
+	build := createTestBuild()
+	build.ID = id
+	build.Priority = priority
+	return build
+}
+
+func TestPipeline_StartsWaitingBuildsByPriority(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	pipeline.config.Scheduling.MaxConcurrentBuilds = 1
+	recorder := &startRecorder{}
+	pipeline.notifier = recorder
+	builder.delay = 100 * time.Millisecond
+
+	// Production builds default to high priority
+	release := priorityBuild("release", "")
+	release.Environment = "production"
+	for _, build := range []*types.Build{
+		priorityBuild("rebuild-1", types.PriorityLow),
+		priorityBuild("rebuild-2", types.PriorityLow),
+		priorityBuild("preview", types.PriorityNormal),
+		release,
+	} {
+		require.NoError(t, pipeline.StartBuild(context.Background(), build))
+	}
+	assert.Equal(t, types.BuildStatusPending, buildStatus(t, pipeline, "release"))
+
+	require.Eventually(t, func() bool {
+		return buildStatus(t, pipeline, "rebuild-2") == types.BuildStatusSuccess
+	}, 5*time.Second, 20*time.Millisecond)
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	assert.Equal(t, []string{"rebuild-1", "release", "preview", "rebuild-2"}, recorder.started)
+}
+
+func TestPipeline_PreemptsLowerPriorityBuilds(t *testing.T) {
+	pipeline, builder, _, _ := setupTestPipeline(t)
+	pipeline.config.Scheduling.MaxConcurrentBuilds = 1
+	pipeline.config.Scheduling.Preempt = true
+	recorder := &startRecorder{}
+	pipeline.notifier = recorder
+	builder.delay = 200 * time.Millisecond
+
+	require.NoError(t, pipeline.StartBuild(context.Background(), priorityBuild("rebuild", types.PriorityLow)))
+	require.Eventually(t, func() bool {
+		return buildStatus(t, pipeline, "rebuild") == types.BuildStatusBuilding
+	}, time.Second, 10*time.Millisecond)
+
+	// Equal priorities wait their turn
+	require.NoError(t, pipeline.StartBuild(context.Background(), priorityBuild("other-rebuild", types.PriorityLow)))
+	require.NoError(t, pipeline.StartBuild(context.Background(), priorityBuild("release", types.PriorityHigh)))
+
+	require.Eventually(t, func() bool {
+		return buildStatus(t, pipeline, "rebuild") == types.BuildStatusSuccess &&
+			buildStatus(t, pipeline, "other-rebuild") == types.BuildStatusSuccess
+	}, 5*time.Second, 20*time.Millisecond)
+	require.NoError(t, pipeline.Shutdown(context.Background()))
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	assert.Equal(t, []string{"rebuild", "release", "rebuild", "other-rebuild"}, recorder.started,
+		"the preempted build runs again before later builds of its priority")
+	assert.Empty(t, recorder.failed)
+
+	build, err := pipeline.GetBuild("rebuild")
+	require.NoError(t, err)
+	var preempted []string
+	for _, event := range build.Events {
+		if event.Type == types.EventPreempted {
+			preempted = append(preempted, event.Message)
+		}
+	}
+	assert.Equal(t, []string{"preempted by build release"}, preempted)
+}
@@ -0,0 +1,303 @@
+// Package source fetches project sources from git repositories.
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+var (
+	ErrInvalidURL       = errors.New("repository URL must be an https, ssh or git URL")
+	ErrCloneFailed      = errors.New("failed to clone repository")
+	ErrSubmoduleFailed  = errors.New("failed to fetch submodules")
+	ErrLFSUnavailable   = errors.New("repository uses git LFS but git-lfs is not installed on the server")
+	ErrLFSFailed        = errors.New("failed to fetch git LFS objects")
+	ErrLFSQuotaExceeded = errors.New("git LFS bandwidth or storage quota exceeded on the provider")
+	ErrLFSUnauthorized  = errors.New("git LFS server rejected the credentials")
+)
+
+// CloneOptions selects what to fetch
+type CloneOptions struct {
+	URL   string
+	Ref   string // Branch or tag, the remote default branch when empty
+	Depth int    // Full history when zero
+	// Token authenticates https clones. It is passed through the
+	// environment so it never shows up in the process list or in errors,
+	// and is only sent to the repository's host.
+	Token string
+	// Submodules initializes submodules recursively when the repository
+	// has a .gitmodules file
+	Submodules bool
+	// LFS pulls LFS objects when .gitattributes routes files through the
+	// lfs filter
+	LFS bool
+	// Size is the repository size in bytes the provider reports, 0 when
+	// unknown. Repositories above the size limit are not cloned.
+	Size int64
+}
+
+// Fetcher clones repositories with the git CLI
+type Fetcher struct {
+	config     *config.SourceConfig
+	gitPath    string
+	allowLocal bool // Tests clone from local fixture repositories
+	log        *zap.Logger
+}
+
+func NewFetcher(cfg *config.SourceConfig, log *zap.Logger) *Fetcher {
+	return &Fetcher{config: cfg, gitPath: "git", log: log}
+}
+
+// Clone checks out opts.URL into dir, which must not exist or be empty,
+// and returns the commit hash of HEAD. With a repository size limit the
+// clone is stopped with ErrSourceTooLarge once dir grows beyond it.
+func (f *Fetcher) Clone(ctx context.Context, dir string, opts CloneOptions) (string, error) {
+	limit := f.config.Limits.MaxRepoSize
+	if limit <= 0 {
+		return f.clone(ctx, dir, opts)
+	}
+	if opts.Size > limit {
+		return "", fmt.Errorf("%w: repository is %d bytes, the limit is %d", ErrSourceTooLarge, opts.Size, limit)
+	}
+
+	ctx, stop := watchSize(ctx, dir, limit)
+	commit, err := f.clone(ctx, dir, opts)
+	if stop() {
+		return "", fmt.Errorf("%w: repository is larger than %d bytes", ErrSourceTooLarge, limit)
+	}
+	return commit, err
+}
+
+func (f *Fetcher) clone(ctx context.Context, dir string, opts CloneOptions) (string, error) {
+	if !f.allowLocal {
+		if err := ValidateURL(opts.URL); err != nil {
+			return "", err
+		}
+	}
+	env := f.env(opts)
+
+	args := []string{"clone", "--quiet", "--no-tags", "--single-branch"}
+	if opts.Depth > 0 {
+		args = append(args, "--depth", fmt.Sprint(opts.Depth))
+	}
+	if opts.Ref != "" {
+		args = append(args, "--branch", opts.Ref)
+	}
+	args = append(args, "--", opts.URL, dir)
+
+	// LFS objects are pulled in a separate step so their failures can be
+	// told apart from clone failures
+	if out, err := f.git(ctx, "", append(env, "GIT_LFS_SKIP_SMUDGE=1"), args...); err != nil {
+		f.log.Warn("git clone failed",
+			zap.String("url", redact(opts.URL)),
+			zap.String("ref", opts.Ref),
+			zap.String("output", out),
+			zap.Error(err))
+		return "", fmt.Errorf("%w: %s", ErrCloneFailed, lastLine(out))
+	}
+
+	if opts.Submodules && !f.config.DisableSubmodules && exists(filepath.Join(dir, ".gitmodules")) {
+		if err := f.updateSubmodules(ctx, dir, env, opts.Depth); err != nil {
+			return "", err
+		}
+	}
+	if opts.LFS && !f.config.DisableLFS && usesLFS(dir) {
+		if err := f.pullLFS(ctx, dir, env); err != nil {
+			return "", err
+		}
+	}
+
+	commit, err := f.git(ctx, dir, env, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return strings.TrimSpace(commit), nil
+}
+
+// Describe reads the author, message, branch and tag of HEAD in a checkout.
+// Builds whose trigger carried no commit details use it after cloning.
+func (f *Fetcher) Describe(ctx context.Context, dir string) (*types.CommitInfo, error) {
+	out, err := f.git(ctx, dir, nil, "log", "-1", "--format=%an%x00%ae%x00%B")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit: %s", lastLine(out))
+	}
+	fields := strings.SplitN(out, "\x00", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("failed to read commit: unexpected git log output")
+	}
+	info := &types.CommitInfo{
+		Author:      fields[0],
+		AuthorEmail: fields[1],
+		Message:     strings.TrimSpace(fields[2]),
+	}
+
+	// A detached HEAD, e.g. a tag checkout, has no branch
+	if branch, err := f.git(ctx, dir, nil, "symbolic-ref", "--quiet", "--short", "HEAD"); err == nil {
+		info.Branch = strings.TrimSpace(branch)
+	}
+	if tag, err := f.git(ctx, dir, nil, "describe", "--tags", "--exact-match", "HEAD"); err == nil {
+		info.Tag = strings.TrimSpace(tag)
+	}
+	return info, nil
+}
+
+func (f *Fetcher) updateSubmodules(ctx context.Context, dir string, env []string, depth int) error {
+	args := []string{"submodule", "update", "--init", "--recursive", "--quiet"}
+	if depth > 0 {
+		args = append(args, "--depth", fmt.Sprint(depth))
+	}
+	if out, err := f.git(ctx, dir, append(env, "GIT_LFS_SKIP_SMUDGE=1"), args...); err != nil {
+		f.log.Warn("git submodule update failed", zap.String("output", out), zap.Error(err))
+		return fmt.Errorf("%w: %s", ErrSubmoduleFailed, lastLine(out))
+	}
+	return nil
+}
+
+func (f *Fetcher) pullLFS(ctx context.Context, dir string, env []string) error {
+	if _, err := f.git(ctx, dir, env, "lfs", "version"); err != nil {
+		return ErrLFSUnavailable
+	}
+
+	out, err := f.git(ctx, dir, env, "lfs", "pull")
+	if err == nil {
+		return nil
+	}
+	f.log.Warn("git lfs pull failed", zap.String("output", out), zap.Error(err))
+
+	lower := strings.ToLower(out)
+	switch {
+	case strings.Contains(lower, "quota") || strings.Contains(lower, "budget"):
+		return fmt.Errorf("%w: %s", ErrLFSQuotaExceeded, lastLine(out))
+	case strings.Contains(lower, "authentication required") || strings.Contains(lower, "401") ||
+		strings.Contains(lower, "403") || strings.Contains(lower, "access denied"):
+		return fmt.Errorf("%w: %s", ErrLFSUnauthorized, lastLine(out))
+	}
+	return fmt.Errorf("%w: %s", ErrLFSFailed, lastLine(out))
+}
+
+// env configures git for non-interactive use. Only remote transports are
+// allowed so submodule URLs cannot read the server's filesystem, and the
+// token is scoped to the repository's host so submodules hosted elsewhere
+// never receive it.
+func (f *Fetcher) env(opts CloneOptions) []string {
+	settings := [][2]string{
+		{"protocol.ext.allow", "never"},
+	}
+	if f.allowLocal {
+		settings = append(settings, [2]string{"protocol.file.allow", "always"})
+	} else {
+		settings = append(settings, [2]string{"protocol.file.allow", "never"})
+	}
+	if origin := httpsOrigin(opts.URL); origin != "" && opts.Token != "" {
+		basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + opts.Token))
+		settings = append(settings, [2]string{"http." + origin + "/.extraHeader", "Authorization: Basic " + basic})
+	}
+
+	env := []string{
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ASKPASS=true",
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(settings)),
+	}
+	for i, kv := range settings {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]))
+	}
+	return env
+}
+
+// git runs a git command and returns its combined output
+func (f *Fetcher) git(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, f.gitPath, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// usesLFS reports whether the root .gitattributes routes any path through
+// the lfs filter
+func usesLFS(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") && strings.Contains(line, "filter=lfs") {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// ValidateURL accepts remote URLs only, so user input cannot point the
+// clone at the server's filesystem
+func ValidateURL(raw string) error {
+	if strings.HasPrefix(raw, "-") {
+		return ErrInvalidURL
+	}
+	// scp-like syntax, e.g. git@github.com:owner/name.git
+	if user, rest, ok := strings.Cut(raw, "@"); ok && !strings.Contains(user, "/") && !strings.Contains(raw, "://") {
+		if host, path, ok := strings.Cut(rest, ":"); ok && host != "" && path != "" {
+			return nil
+		}
+		return ErrInvalidURL
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return ErrInvalidURL
+	}
+	switch parsed.Scheme {
+	case "https", "ssh", "git":
+		return nil
+	}
+	return ErrInvalidURL
+}
+
+// httpsOrigin returns scheme://host of an https URL, empty otherwise
+func httpsOrigin(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return ""
+	}
+	return "https://" + parsed.Host
+}
+
+// redact drops credentials embedded in a URL before it is logged
+func redact(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	parsed.User = url.User("redacted")
+	return parsed.String()
+}
+
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return strings.TrimPrefix(lines[len(lines)-1], "fatal: ")
+}
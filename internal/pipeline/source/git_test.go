@@ -0,0 +1,270 @@
+package source
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+// gitFixture is a local repository tests commit files to
+type gitFixture struct {
+	t   *testing.T
+	dir string
+}
+
+func newGitFixture(t *testing.T) *gitFixture {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	f := &gitFixture{t: t, dir: t.TempDir()}
+	f.run("init", "--quiet", "--initial-branch=main")
+	return f
+}
+
+func (f *gitFixture) run(args ...string) {
+	f.t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "protocol.file.allow=always"}, args...)...)
+	cmd.Dir = f.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=chef", "GIT_AUTHOR_EMAIL=chef@example.com",
+		"GIT_COMMITTER_NAME=chef", "GIT_COMMITTER_EMAIL=chef@example.com")
+	out, err := cmd.CombinedOutput()
+	require.NoError(f.t, err, string(out))
+}
+
+func (f *gitFixture) commit(files map[string]string) {
+	f.t.Helper()
+	for name, content := range files {
+		require.NoError(f.t, os.WriteFile(filepath.Join(f.dir, name), []byte(content), 0644))
+	}
+	f.run("add", ".")
+	f.run("commit", "--quiet", "-m", "update")
+}
+
+func (f *gitFixture) url() string {
+	return "file://" + f.dir
+}
+
+func newTestFetcher(cfg config.SourceConfig) *Fetcher {
+	return &Fetcher{config: &cfg, gitPath: "git", allowLocal: true, log: zap.NewNop()}
+}
+
+func TestFetcher_Clone(t *testing.T) {
+	repo := newGitFixture(t)
+	repo.commit(map[string]string{"package.json": `{"name":"app"}`})
+	repo.run("checkout", "--quiet", "-b", "feature")
+	repo.commit(map[string]string{"feature.txt": "feature"})
+	repo.run("checkout", "--quiet", "main")
+
+	fetcher := newTestFetcher(config.SourceConfig{})
+
+	t.Run("default branch", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "checkout")
+		commit, err := fetcher.Clone(context.Background(), dir, CloneOptions{URL: repo.url(), Depth: 1})
+		require.NoError(t, err)
+		assert.Len(t, commit, 40)
+		assert.FileExists(t, filepath.Join(dir, "package.json"))
+		assert.NoFileExists(t, filepath.Join(dir, "feature.txt"))
+	})
+
+	t.Run("branch", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "checkout")
+		_, err := fetcher.Clone(context.Background(), dir, CloneOptions{URL: repo.url(), Ref: "feature", Depth: 1})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "feature.txt"))
+	})
+
+	t.Run("missing branch", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "checkout")
+		_, err := fetcher.Clone(context.Background(), dir, CloneOptions{URL: repo.url(), Ref: "missing"})
+		assert.ErrorIs(t, err, ErrCloneFailed)
+	})
+}
+
+func TestFetcher_Describe(t *testing.T) {
+	repo := newGitFixture(t)
+	repo.commit(map[string]string{"package.json": `{"name":"app"}`})
+	repo.run("commit", "--quiet", "--allow-empty", "-m", "Fix checkout\n\nThe total was off by one.")
+	repo.run("tag", "v1.0.0")
+
+	fetcher := newTestFetcher(config.SourceConfig{})
+	ctx := context.Background()
+
+	commit, err := fetcher.Describe(ctx, repo.dir)
+	require.NoError(t, err)
+	assert.Equal(t, "chef", commit.Author)
+	assert.Equal(t, "chef@example.com", commit.AuthorEmail)
+	assert.Equal(t, "main", commit.Branch)
+	assert.Equal(t, "v1.0.0", commit.Tag)
+	assert.Equal(t, "Fix checkout\n\nThe total was off by one.", commit.Message)
+
+	repo.run("checkout", "--quiet", "--detach", "HEAD~1")
+	commit, err = fetcher.Describe(ctx, repo.dir)
+	require.NoError(t, err)
+	assert.Empty(t, commit.Branch)
+	assert.Empty(t, commit.Tag)
+	assert.Equal(t, "update", commit.Message)
+}
+
+func TestFetcher_Submodules(t *testing.T) {
+	nested := newGitFixture(t)
+	nested.commit(map[string]string{"nested.txt": "nested"})
+
+	lib := newGitFixture(t)
+	lib.commit(map[string]string{"lib.txt": "lib"})
+	lib.run("submodule", "--quiet", "add", nested.url(), "nested")
+	lib.run("commit", "--quiet", "-m", "add nested")
+
+	repo := newGitFixture(t)
+	repo.commit(map[string]string{"package.json": "{}"})
+	repo.run("submodule", "--quiet", "add", lib.url(), "lib")
+	repo.run("commit", "--quiet", "-m", "add lib")
+
+	tests := []struct {
+		name       string
+		config     config.SourceConfig
+		submodules bool
+		fetched    bool
+	}{
+		{name: "recursive", submodules: true, fetched: true},
+		{name: "not requested", submodules: false, fetched: false},
+		{name: "disabled by config", config: config.SourceConfig{DisableSubmodules: true}, submodules: true, fetched: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "checkout")
+			_, err := newTestFetcher(tt.config).Clone(context.Background(), dir,
+				CloneOptions{URL: repo.url(), Submodules: tt.submodules})
+			require.NoError(t, err)
+
+			if tt.fetched {
+				assert.FileExists(t, filepath.Join(dir, "lib", "lib.txt"))
+				assert.FileExists(t, filepath.Join(dir, "lib", "nested", "nested.txt"))
+			} else {
+				assert.NoFileExists(t, filepath.Join(dir, "lib", "lib.txt"))
+			}
+		})
+	}
+}
+
+func TestFetcher_SubmoduleFailure(t *testing.T) {
+	lib := newGitFixture(t)
+	lib.commit(map[string]string{"lib.txt": "lib"})
+
+	repo := newGitFixture(t)
+	repo.commit(map[string]string{"package.json": "{}"})
+	repo.run("submodule", "--quiet", "add", lib.url(), "lib")
+	repo.run("commit", "--quiet", "-m", "add lib")
+	require.NoError(t, os.RemoveAll(lib.dir))
+
+	dir := filepath.Join(t.TempDir(), "checkout")
+	_, err := newTestFetcher(config.SourceConfig{}).Clone(context.Background(), dir,
+		CloneOptions{URL: repo.url(), Submodules: true})
+	assert.ErrorIs(t, err, ErrSubmoduleFailed)
+}
+
+func TestFetcher_LFS(t *testing.T) {
+	repo := newGitFixture(t)
+	repo.commit(map[string]string{
+		".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n",
+		"asset.bin":      "version https://git-lfs.github.com/spec/v1\n",
+	})
+
+	t.Run("disabled by config", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "checkout")
+		_, err := newTestFetcher(config.SourceConfig{DisableLFS: true}).Clone(context.Background(), dir,
+			CloneOptions{URL: repo.url(), LFS: true})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "asset.bin"))
+	})
+
+	t.Run("git-lfs missing", func(t *testing.T) {
+		if exec.Command("git", "lfs", "version").Run() == nil {
+			t.Skip("git-lfs is installed")
+		}
+		dir := filepath.Join(t.TempDir(), "checkout")
+		_, err := newTestFetcher(config.SourceConfig{}).Clone(context.Background(), dir,
+			CloneOptions{URL: repo.url(), LFS: true})
+		assert.ErrorIs(t, err, ErrLFSUnavailable)
+	})
+}
+
+func TestUsesLFS(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes string
+		expected   bool
+	}{
+		{name: "lfs filter", attributes: "*.psd filter=lfs diff=lfs merge=lfs -text\n", expected: true},
+		{name: "commented out", attributes: "# *.psd filter=lfs\n*.sh text eol=lf\n"},
+		{name: "no file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.attributes != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte(tt.attributes), 0644))
+			}
+			assert.Equal(t, tt.expected, usesLFS(dir))
+		})
+	}
+}
+
+func TestFetcher_TokenScopedToHost(t *testing.T) {
+	fetcher := NewFetcher(&config.SourceConfig{}, zap.NewNop())
+
+	env := strings.Join(fetcher.env(CloneOptions{URL: "https://github.com/acme/app.git", Token: "secret"}), "\n")
+	assert.Contains(t, env, "=http.https://github.com/.extraHeader")
+	assert.Contains(t, env, "protocol.file.allow\nGIT_CONFIG_VALUE_1=never")
+	assert.NotContains(t, env, "secret")
+
+	env = strings.Join(fetcher.env(CloneOptions{URL: "git@github.com:acme/app.git", Token: "secret"}), "\n")
+	assert.NotContains(t, env, "extraHeader")
+}
+
+func TestFetcher_RejectsLocalURLs(t *testing.T) {
+	fetcher := NewFetcher(&config.SourceConfig{}, zap.NewNop())
+	_, err := fetcher.Clone(context.Background(), t.TempDir(), CloneOptions{URL: "file:///etc"})
+	assert.ErrorIs(t, err, ErrInvalidURL)
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://github.com/elskow/chef-infra.git", true},
+		{"ssh://git@github.com/elskow/chef-infra.git", true},
+		{"git@github.com:elskow/chef-infra.git", true},
+		{"git://example.com/repo.git", true},
+		{"file:///etc", false},
+		{"/var/lib/repo", false},
+		{"--upload-pack=touch /tmp/x", false},
+		{"http://github.com/elskow/chef-infra.git", false},
+		{"ext::sh -c touch% /tmp/x", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := ValidateURL(tt.url)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+// sizeCheckInterval is how often a clone's size is measured
+const sizeCheckInterval = time.Second
+
+// ErrSourceTooLarge is returned, wrapped with the exceeded limit, for
+// repositories and source trees above the configured limits
+var ErrSourceTooLarge = errors.New("SOURCE_TOO_LARGE")
+
+// errLimitReached stops walking a tree once a limit is exceeded
+var errLimitReached = errors.New("limit reached")
+
+// ignoredDirs are left out when builds copy their sources
+var ignoredDirs = map[string]bool{"node_modules": true, ".git": true}
+
+// Ignored reports whether builds leave a directory of the source tree out
+func Ignored(name string) bool {
+	return ignoredDirs[name]
+}
+
+// CheckTree returns ErrSourceTooLarge when the part of the source tree in
+// dir builds copy exceeds the size or file limit
+func CheckTree(dir string, limits *config.SourceLimitsConfig) error {
+	if limits.MaxTreeSize <= 0 && limits.MaxFiles <= 0 {
+		return nil
+	}
+
+	var size int64
+	var files int
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && Ignored(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		files++
+		if (limits.MaxTreeSize > 0 && size > limits.MaxTreeSize) || (limits.MaxFiles > 0 && files > limits.MaxFiles) {
+			return errLimitReached
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errLimitReached) && limits.MaxFiles > 0 && files > limits.MaxFiles:
+		return fmt.Errorf("%w: source has more than %d files", ErrSourceTooLarge, limits.MaxFiles)
+	case errors.Is(err, errLimitReached):
+		return fmt.Errorf("%w: source is larger than %d bytes", ErrSourceTooLarge, limits.MaxTreeSize)
+	case err != nil:
+		return fmt.Errorf("failed to measure source: %w", err)
+	}
+	return nil
+}
+
+// dirSize returns the bytes of the files below dir, ignoring files that
+// disappear while it is measured
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// watchSize cancels the returned context once dir grows beyond limit. stop
+// ends the watch and reports whether the limit was exceeded, measuring a
+// last time.
+func watchSize(ctx context.Context, dir string, limit int64) (context.Context, func() bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	var exceeded atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(sizeCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if dirSize(dir) > limit {
+				exceeded.Store(true)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return ctx, func() bool {
+		cancel()
+		<-done
+		return exceeded.Load() || dirSize(dir) > limit
+	}
+}
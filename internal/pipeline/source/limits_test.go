@@ -0,0 +1,59 @@
+package source
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func TestCheckTree(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"name":"app"}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.js"), []byte("console.log(1)"), 0644))
+	// Builds don't copy node_modules
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules", "big"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "big", "index.js"), make([]byte, 4096), 0644))
+
+	assert.NoError(t, CheckTree(dir, &config.SourceLimitsConfig{}))
+	assert.NoError(t, CheckTree(dir, &config.SourceLimitsConfig{MaxTreeSize: 1024, MaxFiles: 2}))
+
+	err := CheckTree(dir, &config.SourceLimitsConfig{MaxFiles: 1})
+	assert.ErrorIs(t, err, ErrSourceTooLarge)
+	assert.EqualError(t, err, "SOURCE_TOO_LARGE: source has more than 1 files")
+
+	err = CheckTree(dir, &config.SourceLimitsConfig{MaxTreeSize: 16})
+	assert.ErrorIs(t, err, ErrSourceTooLarge)
+	assert.EqualError(t, err, "SOURCE_TOO_LARGE: source is larger than 16 bytes")
+}
+
+func TestFetcher_CloneSizeLimit(t *testing.T) {
+	repo := newGitFixture(t)
+	repo.commit(map[string]string{"data.txt": strings.Repeat("chef", 4096)})
+
+	t.Run("reported by the provider", func(t *testing.T) {
+		fetcher := newTestFetcher(config.SourceConfig{Limits: config.SourceLimitsConfig{MaxRepoSize: 1 << 20}})
+		dir := filepath.Join(t.TempDir(), "checkout")
+		_, err := fetcher.Clone(context.Background(), dir, CloneOptions{URL: repo.url(), Size: 2 << 20})
+		assert.ErrorIs(t, err, ErrSourceTooLarge)
+		assert.NoDirExists(t, dir, "nothing is cloned")
+	})
+
+	t.Run("measured while cloning", func(t *testing.T) {
+		fetcher := newTestFetcher(config.SourceConfig{Limits: config.SourceLimitsConfig{MaxRepoSize: 1024}})
+		_, err := fetcher.Clone(context.Background(), filepath.Join(t.TempDir(), "checkout"), CloneOptions{URL: repo.url(), Depth: 1})
+		assert.ErrorIs(t, err, ErrSourceTooLarge)
+	})
+
+	t.Run("within the limit", func(t *testing.T) {
+		fetcher := newTestFetcher(config.SourceConfig{Limits: config.SourceLimitsConfig{MaxRepoSize: 1 << 20}})
+		_, err := fetcher.Clone(context.Background(), filepath.Join(t.TempDir(), "checkout"), CloneOptions{URL: repo.url(), Depth: 1})
+		assert.NoError(t, err)
+	})
+}
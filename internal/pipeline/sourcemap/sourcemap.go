@@ -0,0 +1,187 @@
+// Package sourcemap uploads the source maps removed from builds to an
+// error tracker, so errors of the deployed bundle get readable stack traces
+// without the maps being served.
+package sourcemap
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultURL     = "https://sentry.io"
+	defaultTimeout = 30 * time.Second
+
+	// maxErrorBody caps the error responses read from Sentry
+	maxErrorBody = 64 * 1024
+)
+
+// Uploader uploads source maps as Sentry release files
+type Uploader struct {
+	config   *config.SourceMapsConfig
+	endpoint string
+	client   *http.Client
+	log      *zap.Logger
+}
+
+func NewUploader(cfg *config.SourceMapsConfig, log *zap.Logger) *Uploader {
+	endpoint := strings.TrimSuffix(cfg.URL, "/")
+	if endpoint == "" {
+		endpoint = defaultURL
+	}
+	timeout := defaultTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	return &Uploader{
+		config:   cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+		log:      log,
+	}
+}
+
+// Enabled reports whether an error tracker is configured
+func (u *Uploader) Enabled() bool {
+	return u.config.Provider != ""
+}
+
+// Upload creates the release of build in the project's Sentry project and
+// uploads the source maps of the tar at path as its files. Files are named
+// after their path in the output, e.g. ~/static/js/main.js.map, so they
+// match the bundle on any host. It returns the release and the number of
+// files, counting those uploaded before.
+func (u *Uploader) Upload(ctx context.Context, build *types.Build, path string) (string, int, error) {
+	if u.config.Provider != "sentry" {
+		return "", 0, fmt.Errorf("unsupported source map provider: %q", u.config.Provider)
+	}
+	project := u.config.Projects[strings.ToLower(build.ProjectID)]
+	if project == "" {
+		project = build.ProjectID
+	}
+	release := build.ID
+
+	releases := "/api/0/organizations/" + url.PathEscape(u.config.Organization) + "/releases/"
+	body, err := json.Marshal(map[string]interface{}{"version": release, "projects": []string{project}})
+	if err != nil {
+		return "", 0, err
+	}
+	if err := u.call(ctx, releases, "application/json", bytes.NewReader(body)); err != nil {
+		return "", 0, fmt.Errorf("failed to create release %s: %w", release, err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	files := releases + url.PathEscape(release) + "/files/"
+	uploaded := 0
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return release, uploaded, fmt.Errorf("failed to read source maps: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := fileName(header.Name)
+		if err := u.uploadFile(ctx, files, name, tr); err != nil {
+			return release, uploaded, fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		uploaded++
+	}
+	u.log.Info("uploaded source maps",
+		zap.String("build_id", build.ID),
+		zap.String("sentry_project", project),
+		zap.Int("files", uploaded))
+	return release, uploaded, nil
+}
+
+// fileName names a source map by its path in the output. Paths in the tar
+// start with the directory the maps were copied from.
+func fileName(tarPath string) string {
+	_, rel, _ := strings.Cut(strings.TrimPrefix(tarPath, "./"), "/")
+	return "~/" + strings.TrimPrefix(rel, "./")
+}
+
+func (u *Uploader) uploadFile(ctx context.Context, path, name string, content io.Reader) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("name", name); err != nil {
+		return err
+	}
+	part, err := mw.CreateFormFile("file", name[strings.LastIndex(name, "/")+1:])
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	err = u.call(ctx, path, mw.FormDataContentType(), &buf)
+	if apiErr, ok := err.(*apiError); ok && apiErr.status == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// apiError is a Sentry response with an error status
+type apiError struct {
+	status int
+	text   string
+}
+
+func (e *apiError) Error() string {
+	return e.text
+}
+
+func (u *Uploader) call(ctx context.Context, path, contentType string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+u.config.Token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sentry POST %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		var detail struct {
+			Detail string `json:"detail"`
+		}
+		text := fmt.Sprintf("sentry POST %s: %s", path, resp.Status)
+		if json.Unmarshal(data, &detail) == nil && detail.Detail != "" {
+			text += ": " + detail.Detail
+		}
+		return &apiError{status: resp.StatusCode, text: text}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package sourcemap
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func writeMaps(t *testing.T, files map[string]string) string {
+	path := filepath.Join(t.TempDir(), "maps.tar")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "chef-sourcemaps/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return path
+}
+
+func TestUploader_Upload(t *testing.T) {
+	var mu sync.Mutex
+	var release map[string]interface{}
+	uploaded := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sntrys_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/api/0/organizations/acme/releases/":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&release))
+			w.WriteHeader(http.StatusCreated)
+		case "/api/0/organizations/acme/releases/build-1/files/":
+			name := r.FormValue("name")
+			if _, ok := uploaded[name]; ok || name == "~/static/js/vendor.js.map" {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			file, _, err := r.FormFile("file")
+			require.NoError(t, err)
+			content, _ := io.ReadAll(file)
+			uploaded[name] = string(content)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	uploader := NewUploader(&config.SourceMapsConfig{
+		Provider:     "sentry",
+		URL:          server.URL,
+		Organization: "acme",
+		Token:        "sntrys_token",
+		Projects:     map[string]string{"shop": "shop-frontend"},
+	}, zap.NewNop())
+	require.True(t, uploader.Enabled())
+
+	path := writeMaps(t, map[string]string{
+		"chef-sourcemaps/static/js/main.js.map":   `{"version":3}`,
+		"chef-sourcemaps/static/js/vendor.js.map": `{"version":3}`,
+	})
+	name, files, err := uploader.Upload(context.Background(), &types.Build{ID: "build-1", ProjectID: "shop"}, path)
+	require.NoError(t, err)
+	assert.Equal(t, "build-1", name)
+	assert.Equal(t, 2, files, "files uploaded before count as uploaded")
+	assert.Equal(t, map[string]interface{}{"version": "build-1", "projects": []interface{}{"shop-frontend"}}, release)
+	assert.Equal(t, map[string]string{"~/static/js/main.js.map": `{"version":3}`}, uploaded)
+
+	uploader.config.Token = "revoked"
+	_, _, err = uploader.Upload(context.Background(), &types.Build{ID: "build-1", ProjectID: "shop"}, path)
+	assert.ErrorContains(t, err, "failed to create release build-1: sentry POST /api/0/organizations/acme/releases/: 401 Unauthorized")
+}
+
+func TestUploader_Disabled(t *testing.T) {
+	uploader := NewUploader(&config.SourceMapsConfig{}, zap.NewNop())
+	assert.False(t, uploader.Enabled())
+}
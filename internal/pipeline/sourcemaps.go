@@ -0,0 +1,42 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// uploadSourceMaps uploads the source maps a build removed from its
+// artifact to the error tracker. Failures are recorded as an event, the
+// build is deployed without its maps uploaded.
+func (p *Pipeline) uploadSourceMaps(ctx context.Context, build *types.Build, result *types.BuildResult) {
+	if result.SourceMaps != types.SourceMapsUpload || result.SourceMapsPath == "" {
+		return
+	}
+	defer os.Remove(result.SourceMapsPath)
+
+	var release string
+	var files int
+	err := errors.New("no error tracker is configured")
+	if p.sourceMaps != nil && p.sourceMaps.Enabled() {
+		release, files, err = p.sourceMaps.Upload(ctx, build, result.SourceMapsPath)
+	}
+	if err != nil {
+		p.logger.Warn("failed to upload source maps",
+			zap.String("build_id", build.ID),
+			zap.Error(err))
+		p.updateBuild(build, func(b *types.Build) {
+			b.AddEvent(types.EventSourceMapsUploadFailed, "", err.Error())
+		})
+		return
+	}
+
+	p.updateBuild(build, func(b *types.Build) {
+		b.AddEvent(types.EventSourceMapsUploaded, "", fmt.Sprintf("%d files uploaded to release %s", files, release))
+	})
+}
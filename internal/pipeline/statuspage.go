@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultStatusEnvironment = "production"
+
+	// statusPageMaxAge is how long readers may cache a status page
+	statusPageMaxAge = "max-age=30"
+)
+
+// StatusPageAuthorizer decides who may read a project's status page
+type StatusPageAuthorizer interface {
+	// CanReadStatusPage is false for projects without a status page
+	CanReadStatusPage(projectID, token string) (bool, error)
+}
+
+// ProjectStatus is what a project's status page shows
+type ProjectStatus struct {
+	Project     string           `json:"project"`
+	Environment string           `json:"environment"`
+	Version     *DeployedVersion `json:"version,omitempty"` // Unset until the environment is deployed
+	Uptime      *UptimeStatus    `json:"uptime,omitempty"`  // Unset when the project is not monitored
+}
+
+// DeployedVersion is the build currently deployed to the environment
+type DeployedVersion struct {
+	Build      string    `json:"build"`
+	Commit     string    `json:"commit,omitempty"` // Short hash
+	Branch     string    `json:"branch,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	Release    string    `json:"release,omitempty"` // Release tag at the git provider
+	DeployedAt time.Time `json:"deployed_at"`
+}
+
+// UptimeStatus summarizes the uptime monitor's record of the project
+type UptimeStatus struct {
+	Up               bool       `json:"up"`
+	Availability     float64    `json:"availability"` // Fraction of successful checks
+	AverageLatencyMs int64      `json:"average_latency_ms"`
+	Checks           int64      `json:"checks"`
+	LastCheck        *time.Time `json:"last_check,omitempty"`
+}
+
+// ProjectStatus returns the project's deployed version and uptime. Only
+// what is safe to publish is included, e.g. no URLs or errors.
+func (p *Pipeline) ProjectStatus(ctx context.Context, projectID string) (*ProjectStatus, error) {
+	environment := p.config.StatusPage.Environment
+	if environment == "" {
+		environment = defaultStatusEnvironment
+	}
+	status := &ProjectStatus{Project: projectID, Environment: environment}
+
+	build, err := p.latestDeployment(ctx, projectID, environment)
+	if err != nil {
+		return nil, err
+	}
+	if build != nil {
+		version := &DeployedVersion{Build: build.ID, Commit: build.ShortHash(), DeployedAt: *build.CompleteTime}
+		if build.Commit != nil {
+			version.Branch, version.Tag = build.Commit.Branch, build.Commit.Tag
+		}
+		if build.Release != nil {
+			version.Release = build.Release.Tag
+		}
+		status.Version = version
+	}
+
+	if p.monitor != nil && p.monitor.Enabled() {
+		if stats, ok := p.monitor.Stats(projectID, environment); ok {
+			uptime := &UptimeStatus{
+				Up:               stats.Up,
+				Availability:     stats.Availability(),
+				AverageLatencyMs: stats.AverageLatency().Milliseconds(),
+				Checks:           stats.Checks,
+			}
+			if !stats.LastCheck.IsZero() {
+				uptime.LastCheck = &stats.LastCheck
+			}
+			status.Uptime = uptime
+		}
+	}
+	return status, nil
+}
+
+// latestDeployment returns the project's last successful deploy to
+// environment, or nil when there is none
+func (p *Pipeline) latestDeployment(ctx context.Context, projectID, environment string) (*types.Build, error) {
+	if p.store != nil {
+		return p.store.LatestDeployment(ctx, projectID, environment)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var latest *types.Build
+	for _, b := range p.builds {
+		if b.ProjectID != projectID || b.Environment != environment || b.Status != types.BuildStatusSuccess || b.CompleteTime == nil {
+			continue
+		}
+		if latest == nil || b.CompleteTime.After(*latest.CompleteTime) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	snapshot := *latest
+	snapshot.Events = nil
+	snapshot.CancelFunc = nil
+	return &snapshot, nil
+}
+
+// StatusPage serves the status pages of projects that enable one at
+// /status/{project}, as HTML, and /status/{project}.json. Protected pages
+// take the token as ?token= or a bearer token.
+type StatusPage struct {
+	pipeline   *Pipeline
+	authorizer StatusPageAuthorizer
+	logger     *zap.Logger
+}
+
+func NewStatusPage(pipeline *Pipeline, authorizer StatusPageAuthorizer, logger *zap.Logger) *StatusPage {
+	return &StatusPage{pipeline: pipeline, authorizer: authorizer, logger: logger}
+}
+
+func (s *StatusPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := strings.TrimPrefix(r.URL.Path, "/status/")
+	projectID, asJSON := strings.CutSuffix(projectID, ".json")
+	if projectID == "" || strings.Contains(projectID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := statusPageToken(r)
+	// Disabled pages, unknown projects and wrong tokens look the same
+	allowed, err := s.authorizer.CanReadStatusPage(projectID, token)
+	if err != nil {
+		s.logger.Error("failed to check status page access", zap.String("project_id", projectID), zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.NotFound(w, r)
+		return
+	}
+
+	status, err := s.pipeline.ProjectStatus(r.Context(), projectID)
+	if err != nil {
+		s.logger.Error("failed to get project status", zap.String("project_id", projectID), zap.Error(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if token != "" {
+		w.Header().Set("Cache-Control", "private, "+statusPageMaxAge)
+	} else {
+		w.Header().Set("Cache-Control", "public, "+statusPageMaxAge)
+	}
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPageTemplate.Execute(w, status); err != nil {
+		s.logger.Warn("failed to render status page", zap.String("project_id", projectID), zap.Error(err))
+	}
+}
+
+// statusPageToken reads the token of protected status pages from the
+// query or a bearer token
+func statusPageToken(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return bearer
+	}
+	return r.URL.Query().Get("token")
+}
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"percent": func(fraction float64) string { return fmt.Sprintf("%.2f%%", fraction*100) },
+	"time":    func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Project}} status</title>
+</head>
+<body>
+<h1>{{.Project}}</h1>
+{{with .Uptime}}<p><strong>{{if .Up}}Operational{{else}}Down{{end}}</strong>{{if .Checks}}, {{percent .Availability}} uptime over {{.Checks}} checks, {{.AverageLatencyMs}} ms average response{{end}}</p>
+{{end}}{{with .Version}}<p>Version {{if .Release}}{{.Release}}{{else}}{{.Build}}{{end}}{{with .Commit}} ({{.}}){{end}} deployed to {{$.Environment}} on {{time .DeployedAt}}</p>
+{{else}}<p>Not deployed to {{.Environment}} yet</p>
+{{end}}</body>
+</html>
+`))
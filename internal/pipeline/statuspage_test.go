@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// statusPages grants the status page of each project to its token, empty
+// for public pages
+type statusPages map[string]string
+
+func (s statusPages) CanReadStatusPage(projectID, token string) (bool, error) {
+	want, ok := s[projectID]
+	return ok && (want == "" || want == token), nil
+}
+
+func deployedBuild(id string, completed time.Time) *types.Build {
+	return &types.Build{
+		ID:           id,
+		ProjectID:    "shop",
+		CommitHash:   "0123abcdef",
+		Commit:       &types.CommitInfo{Branch: "main"},
+		Environment:  "production",
+		Status:       types.BuildStatusSuccess,
+		CompleteTime: &completed,
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	now := time.Now()
+	pipeline.builds["b1"] = deployedBuild("b1", now.Add(-time.Hour))
+	pipeline.builds["b2"] = deployedBuild("b2", now)
+	pipeline.builds["b2"].Release = &types.Release{Tag: "production-20261016-090000"}
+	failed := deployedBuild("b3", now.Add(time.Minute))
+	failed.Status = types.BuildStatusFailed
+	pipeline.builds["b3"] = failed
+	preview := deployedBuild("b4", now.Add(time.Minute))
+	preview.Environment = "feature-x"
+	pipeline.builds["b4"] = preview
+
+	page := NewStatusPage(pipeline, statusPages{"shop": "", "admin": "s3cret"}, zap.NewNop())
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		page.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/status/shop.json", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "public, max-age=30", rec.Header().Get("Cache-Control"))
+	var status ProjectStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	require.NotNil(t, status.Version)
+	assert.Equal(t, "b2", status.Version.Build)
+	assert.Equal(t, "0123abc", status.Version.Commit)
+	assert.Equal(t, "main", status.Version.Branch)
+	assert.Equal(t, "production-20261016-090000", status.Version.Release)
+	assert.Nil(t, status.Uptime)
+
+	rec = get("/status/shop", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Version production-20261016-090000 (0123abc) deployed to production")
+
+	// Protected pages need the token, and look like missing ones without it
+	assert.Equal(t, http.StatusNotFound, get("/status/admin.json", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("/status/admin.json", "wrong").Code)
+	rec = get("/status/admin.json", "s3cret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "private, max-age=30", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Body.String(), `"project":"admin"`)
+	assert.NotContains(t, rec.Body.String(), `"version"`)
+
+	assert.Equal(t, http.StatusNotFound, get("/status/other", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("/status/", "").Code)
+}
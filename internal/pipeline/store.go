@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const persistTimeout = 10 * time.Second
+
+// BuildStore persists build records and their deployment events
+type BuildStore interface {
+	CreateBuild(ctx context.Context, build *types.Build) error
+	// SaveBuild stores the build's state and appends events atomically
+	SaveBuild(ctx context.Context, build *types.Build, events []types.DeploymentEvent) error
+	// InterruptBuild saves the build like SaveBuild unless its record
+	// changed since it was read, returning types.ErrBuildChanged then
+	InterruptBuild(ctx context.Context, build *types.Build, events []types.DeploymentEvent) error
+	// ListUnfinishedBuilds returns the builds still to be built or deployed
+	ListUnfinishedBuilds(ctx context.Context) ([]types.Build, error)
+	// TouchBuilds marks the unfinished builds of an instance alive
+	TouchBuilds(ctx context.Context, instance string, at time.Time) error
+	// RelocateArtifacts replaces artifact paths, keyed by their old path
+	RelocateArtifacts(ctx context.Context, paths map[string]string) error
+	// GetBuild returns types.ErrBuildNotFound for unknown builds
+	GetBuild(ctx context.Context, id string) (*types.Build, error)
+	// ListBuilds returns builds with all of labels, any when empty
+	ListBuilds(ctx context.Context, projectID string, labels []string, params pagination.Params) (*pagination.Page[types.Build], error)
+	SearchLogs(ctx context.Context, query types.LogQuery, params pagination.Params) (*pagination.Page[types.LogMatch], error)
+	// ListExpiredPreviews returns builds whose preview is still deployed
+	// but expired before the given time
+	ListExpiredPreviews(ctx context.Context, before time.Time) ([]types.Build, error)
+	// ListCoverage returns the latest builds with coverage, newest first
+	ListCoverage(ctx context.Context, projectID, branch string, limit int) ([]types.Build, error)
+	// LatestPerfAudit returns nil when no deployment to the environment
+	// was audited
+	LatestPerfAudit(ctx context.Context, projectID, environment string) (*types.PerfAudit, error)
+	// LatestRelease returns nil when the environment was never released
+	LatestRelease(ctx context.Context, projectID, environment string) (*types.Release, error)
+	// LatestDeployment returns nil when the environment was never deployed
+	LatestDeployment(ctx context.Context, projectID, environment string) (*types.Build, error)
+	// ListDeployments returns the latest deployment of every environment
+	// of every project, whichever instance made it
+	ListDeployments(ctx context.Context) ([]types.Build, error)
+	// LatestFinishedBuild returns nil when no build of the branch, any
+	// when empty, passed or failed
+	LatestFinishedBuild(ctx context.Context, projectID, branch string) (*types.Build, error)
+	// SetPinned returns types.ErrBuildNotFound for unknown builds
+	SetPinned(ctx context.Context, id string, pinned bool) error
+	// SetAnnotation returns types.ErrBuildNotFound for unknown builds
+	SetAnnotation(ctx context.Context, id, note string, labels []string) error
+	ListPinned(ctx context.Context) ([]string, error)
+	ListProtectedImages(ctx context.Context) ([]string, error)
+	DeleteProjectBuilds(ctx context.Context, projectID string) error
+	// SaveMigration replaces the migration of the project's environment
+	SaveMigration(ctx context.Context, migration *types.Migration) error
+	ListMigrations(ctx context.Context) ([]types.Migration, error)
+	// AcquireDeployLock takes the environment's lock unless another build
+	// holds it unexpired, and returns the holder
+	AcquireDeployLock(ctx context.Context, lock *types.DeployLock) (*types.DeployLock, error)
+	// RenewDeployLock and ReleaseDeployLock return
+	// types.ErrDeployLockNotFound when the build lost the lock
+	RenewDeployLock(ctx context.Context, projectID, environment, buildID string, expiresAt time.Time) error
+	ReleaseDeployLock(ctx context.Context, projectID, environment, buildID string) error
+	// ListDeployLocks returns the project's unexpired locks
+	ListDeployLocks(ctx context.Context, projectID string) ([]types.DeployLock, error)
+}
+
+// LookupBuild returns a snapshot of a build. Builds of the running process
+// are served from memory, older ones from the store.
+func (p *Pipeline) LookupBuild(ctx context.Context, buildID string) (*types.Build, error) {
+	p.mu.RLock()
+	build, exists := p.builds[buildID]
+	if exists {
+		snapshot := *build
+		snapshot.Events = append([]types.DeploymentEvent(nil), build.Events...)
+		snapshot.CancelFunc = nil
+		p.mu.RUnlock()
+		return &snapshot, nil
+	}
+	p.mu.RUnlock()
+
+	if p.store == nil {
+		return nil, fmt.Errorf("%w: %s", types.ErrBuildNotFound, buildID)
+	}
+	return p.store.GetBuild(ctx, buildID)
+}
+
+// ListBuilds returns a page of the project's builds with all of labels,
+// newest first. Without a store only the builds of the running process are
+// known and they are returned as a single page.
+func (p *Pipeline) ListBuilds(ctx context.Context, projectID string, labels []string, params pagination.Params) (*pagination.Page[types.Build], error) {
+	if p.store != nil {
+		return p.store.ListBuilds(ctx, projectID, labels, params)
+	}
+	if params.PageToken != "" {
+		return nil, pagination.ErrInvalidPageToken
+	}
+
+	p.mu.RLock()
+	page := &pagination.Page[types.Build]{}
+	for _, build := range p.builds {
+		if build.ProjectID == projectID && hasLabels(build, labels) {
+			snapshot := *build
+			snapshot.Events = nil
+			snapshot.CancelFunc = nil
+			page.Items = append(page.Items, snapshot)
+		}
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(page.Items, func(i, j int) bool {
+		return page.Items[i].StartTime.After(page.Items[j].StartTime)
+	})
+	return page, nil
+}
+
+// CoverageTrend returns up to limit of the project's builds with coverage,
+// newest first. An empty branch includes all branches.
+func (p *Pipeline) CoverageTrend(ctx context.Context, projectID, branch string, limit int) ([]types.Build, error) {
+	if p.store != nil {
+		return p.store.ListCoverage(ctx, projectID, branch, limit)
+	}
+
+	p.mu.RLock()
+	var builds []types.Build
+	for _, build := range p.builds {
+		if build.ProjectID != projectID || build.Coverage == nil {
+			continue
+		}
+		if branch != "" && (build.Commit == nil || build.Commit.Branch != branch) {
+			continue
+		}
+		snapshot := *build
+		snapshot.Events = nil
+		snapshot.CancelFunc = nil
+		builds = append(builds, snapshot)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].StartTime.After(builds[j].StartTime)
+	})
+	if len(builds) > limit {
+		builds = builds[:limit]
+	}
+	return builds, nil
+}
+
+// persist writes the build's current state together with the events
+// recorded since the last write. Writes are serialized so every event is
+// stored exactly once. Failures are logged; the in-memory build remains
+// authoritative for the running process.
+func (p *Pipeline) persist(build *types.Build) {
+	if p.store == nil {
+		return
+	}
+
+	p.persistMu.Lock()
+	defer p.persistMu.Unlock()
+
+	p.mu.RLock()
+	snapshot := *build
+	stored := p.storedEvents[build.ID]
+	events := append([]types.DeploymentEvent(nil), build.Events[stored:]...)
+	p.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+
+	if err := p.store.SaveBuild(ctx, &snapshot, events); err != nil {
+		p.logger.Error("failed to persist build",
+			zap.String("build_id", build.ID),
+			zap.String("status", string(snapshot.Status)),
+			zap.Error(err))
+		return
+	}
+
+	p.mu.Lock()
+	if p.storedEvents == nil {
+		p.storedEvents = make(map[string]int)
+	}
+	p.storedEvents[build.ID] = stored + len(events)
+	p.mu.Unlock()
+}
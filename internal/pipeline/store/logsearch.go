@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// Markers ts_headline puts around matches, private use characters that do
+// not occur in log output
+const (
+	highlightStart = "\uE000"
+	highlightStop  = "\uE001"
+)
+
+// headlineOptions keeps snippets of long messages, such as build output,
+// to a few fragments around the matches
+const headlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop +
+	`, MaxFragments=3, MaxWords=30, MinWords=10, FragmentDelimiter=" ... "`
+
+var logSearchSpec = pagination.Spec{
+	SortFields: map[string]string{
+		"timestamp": "timestamp",
+	},
+	DefaultSort: "-timestamp",
+}
+
+// logMatch is a row of the search query, Status being the build's current
+// status
+type logMatch struct {
+	ID        uint
+	BuildID   string
+	Type      string
+	Hook      string
+	Message   string
+	Status    string
+	Timestamp time.Time
+	Headline  string
+}
+
+// SearchLogs returns a page of the project's build events matching the
+// query, newest first. Messages are matched with Postgres full-text search
+// on the message_search column.
+func (s *Store) SearchLogs(ctx context.Context, query types.LogQuery, params pagination.Params) (*pagination.Page[types.LogMatch], error) {
+	matches := s.db.WithContext(ctx).
+		Table("build_events AS e").
+		Select("e.id, e.build_id, e.type, e.hook, e.message, b.status, e.timestamp, "+
+			"ts_headline('simple', e.message, q.query, ?) AS headline", headlineOptions).
+		Joins("JOIN builds b ON b.id = e.build_id").
+		Joins("CROSS JOIN websearch_to_tsquery('simple', ?) AS q(query)", query.Query).
+		Where("b.project_id = ? AND e.message_search @@ q.query", query.ProjectID)
+	if !query.From.IsZero() {
+		matches = matches.Where("e.timestamp >= ?", query.From)
+	}
+	if !query.To.IsZero() {
+		matches = matches.Where("e.timestamp < ?", query.To)
+	}
+	if query.Status != "" {
+		matches = matches.Where("b.status = ?", string(query.Status))
+	}
+
+	// Paged as a subquery so the sort columns are unambiguous
+	page, err := pagination.List[logMatch](s.db.WithContext(ctx).Table("(?) AS matches", matches), params, logSearchSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &pagination.Page[types.LogMatch]{NextPageToken: page.NextPageToken}
+	for _, row := range page.Items {
+		snippet, highlights := parseHeadline(row.Headline)
+		result.Items = append(result.Items, types.LogMatch{
+			BuildID:     row.BuildID,
+			BuildStatus: types.BuildStatus(row.Status),
+			Event: types.DeploymentEvent{
+				Type:      types.DeploymentEventType(row.Type),
+				Hook:      row.Hook,
+				Message:   row.Message,
+				Timestamp: row.Timestamp,
+			},
+			Snippet:    snippet,
+			Highlights: highlights,
+		})
+	}
+	return result, nil
+}
+
+// parseHeadline removes the highlight markers from a ts_headline result
+// and returns the ranges they enclosed
+func parseHeadline(headline string) (string, []types.Highlight) {
+	var (
+		snippet    strings.Builder
+		highlights []types.Highlight
+	)
+	for {
+		start := strings.Index(headline, highlightStart)
+		if start < 0 {
+			break
+		}
+		snippet.WriteString(headline[:start])
+		headline = headline[start+len(highlightStart):]
+
+		stop := strings.Index(headline, highlightStop)
+		if stop < 0 {
+			stop = len(headline)
+		}
+		highlights = append(highlights, types.Highlight{Start: snippet.Len(), End: snippet.Len() + stop})
+		snippet.WriteString(headline[:stop])
+		headline = strings.TrimPrefix(headline[stop:], highlightStop)
+	}
+	snippet.WriteString(headline)
+	return snippet.String(), highlights
+}
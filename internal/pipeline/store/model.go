@@ -0,0 +1,161 @@
+package store
+
+import (
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+type Build struct {
+	ID         string `gorm:"primaryKey"`
+	ProjectID  string `gorm:"index;not null"`
+	CommitHash string
+	// Commit details are empty when the trigger did not provide them
+	CommitAuthor      string
+	CommitAuthorEmail string
+	CommitMessage     string
+	Branch            string
+	Tag               string
+	Framework         string
+	Environment       string
+	Status            string `gorm:"index;not null"`
+	Deploying         bool   `gorm:"not null;default:false"`
+	Instance          string // Server instance running the build
+	ImageID           string
+	ArtifactPath      string
+	ArtifactDigest    string
+	RolledBackTo      string
+	ErrorMessage      string
+	Warnings          []string            `gorm:"serializer:json"`
+	BuildEnv          []string            `gorm:"serializer:json"` // Names only, values may be sensitive
+	AddOns            []string            `gorm:"column:addons;serializer:json"`
+	Jobs              []types.Job         `gorm:"serializer:json"`
+	Processes         []types.Process     `gorm:"serializer:json"`
+	Timeouts          types.PhaseTimeouts `gorm:"serializer:json"`
+	// Preview columns are empty for builds deployed directly
+	PreviewName       string
+	PreviewURL        string
+	PreviewExpiresAt  *time.Time
+	PreviewPromotedAt *time.Time
+	PreviewRemovedAt  *time.Time
+	Pinned            bool `gorm:"not null;default:false"`
+	Note              string
+	Labels            []string          `gorm:"serializer:json"`
+	Provenance        *types.Provenance `gorm:"serializer:json"`
+	BaseImages        []string          `gorm:"serializer:json"`
+	ImageSize         int64
+	BuilderVersion    string
+	Vulnerabilities   map[string]int            `gorm:"serializer:json"`
+	Approvals         []types.Approval          `gorm:"serializer:json"`
+	PolicyResults     []types.PolicyResult      `gorm:"serializer:json"`
+	TestResults       *types.TestResults        `gorm:"serializer:json"`
+	Coverage          *types.Coverage           `gorm:"serializer:json"`
+	PerfAudit         *types.PerfAudit          `gorm:"serializer:json"`
+	Release           *types.Release            `gorm:"serializer:json"`
+	External          *types.ExternalDeployment `gorm:"serializer:json"`
+	Diagnosis         *types.Diagnosis          `gorm:"serializer:json"`
+	Stages            []types.StageTiming       `gorm:"serializer:json"`
+	Toolchain         *types.Toolchain          `gorm:"serializer:json"`
+	Input             *types.BuildInput         `gorm:"serializer:json"` // Nil for builds started before it was kept
+	StartTime         time.Time
+	CompleteTime      *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func (Build) TableName() string {
+	return "builds"
+}
+
+type Event struct {
+	ID          uint   `gorm:"primaryKey"`
+	BuildID     string `gorm:"index;not null"`
+	Type        string `gorm:"not null"`
+	Hook        string
+	Message     string
+	BuildStatus string `gorm:"not null"` // Status of the build when the event was recorded
+	Timestamp   time.Time
+}
+
+func (Event) TableName() string {
+	return "build_events"
+}
+
+// Usage is a project's consumption on one day
+type Usage struct {
+	ProjectID      string    `gorm:"primaryKey"`
+	Day            time.Time `gorm:"primaryKey;type:date"`
+	BuildSeconds   int64     `gorm:"not null;default:0"`
+	ReplicaSeconds int64     `gorm:"not null;default:0"`
+	ArtifactBytes  int64     `gorm:"not null;default:0"`
+}
+
+func (Usage) TableName() string {
+	return "project_usage"
+}
+
+// AddOn is a managed service provisioned for a project
+type AddOn struct {
+	ProjectID string `gorm:"primaryKey"`
+	Kind      string `gorm:"primaryKey"`
+	Provider  string `gorm:"not null"`
+	Status    string `gorm:"not null"`
+	Ref       string
+	Host      string
+	Secret    string `gorm:"not null"`
+	Message   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (AddOn) TableName() string {
+	return "project_addons"
+}
+
+// Migration is the latest move of a project's environment between deploy
+// targets
+type Migration struct {
+	ProjectID     string `gorm:"primaryKey"`
+	Environment   string `gorm:"primaryKey"`
+	FromTarget    string `gorm:"not null"`
+	ToTarget      string `gorm:"not null"`
+	BuildID       string `gorm:"not null"`
+	Status        string `gorm:"not null"`
+	Message       string
+	StartedAt     time.Time
+	SwitchedAt    *time.Time
+	RollbackUntil *time.Time
+	FinishedAt    *time.Time
+}
+
+func (Migration) TableName() string {
+	return "project_migrations"
+}
+
+// DeployLock is held by the build deploying a project's environment
+type DeployLock struct {
+	ProjectID   string    `gorm:"primaryKey"`
+	Environment string    `gorm:"primaryKey"`
+	BuildID     string    `gorm:"not null"`
+	Owner       string    `gorm:"not null"`
+	AcquiredAt  time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null"`
+}
+
+func (DeployLock) TableName() string {
+	return "deploy_locks"
+}
+
+// NodeVersionMatrix is the node version matrix set through the admin API,
+// stored in a single row
+type NodeVersionMatrix struct {
+	ID             int                        `gorm:"primaryKey"`
+	Versions       []config.NodeVersionConfig `gorm:"serializer:json;not null"`
+	DefaultVersion string                     `gorm:"not null"`
+	UpdatedAt      time.Time
+}
+
+func (NodeVersionMatrix) TableName() string {
+	return "node_version_matrix"
+}
@@ -0,0 +1,811 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+var ErrBuildNotFound = types.ErrBuildNotFound
+
+var unfinishedStatuses = []string{string(types.BuildStatusPending), string(types.BuildStatusBuilding)}
+
+var buildListSpec = pagination.Spec{
+	SortFields: map[string]string{
+		"start_time": "start_time",
+	},
+	DefaultSort:   "-start_time",
+	SearchColumns: []string{"commit_hash", "commit_message", "commit_author", "branch", "tag", "note"},
+}
+
+// Store persists build records and their deployment events
+type Store struct {
+	db *gorm.DB
+}
+
+func New(db *gorm.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) CreateBuild(ctx context.Context, build *types.Build) error {
+	return s.db.WithContext(ctx).Create(fromBuild(build)).Error
+}
+
+// SaveBuild writes the build's current state and appends events in one
+// transaction. The build row is locked first so concurrent writers are
+// serialized and each event is stored with the state it was recorded in;
+// readers never see events ahead of the build record.
+func (s *Store) SaveBuild(ctx context.Context, build *types.Build, events []types.DeploymentEvent) error {
+	return s.saveBuild(ctx, build, events, nil)
+}
+
+// InterruptBuild saves the build like SaveBuild unless its record changed
+// since build was read, e.g. because its instance is still alive or
+// another instance interrupted it first. It returns types.ErrBuildChanged
+// then.
+func (s *Store) InterruptBuild(ctx context.Context, build *types.Build, events []types.DeploymentEvent) error {
+	return s.saveBuild(ctx, build, events, func(current *Build) error {
+		if !toBuild(current, nil).Unfinished() || !current.UpdatedAt.Equal(build.UpdatedAt) {
+			return types.ErrBuildChanged
+		}
+		return nil
+	})
+}
+
+func (s *Store) saveBuild(ctx context.Context, build *types.Build, events []types.DeploymentEvent, check func(current *Build) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current Build
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", build.ID).
+			First(&current).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrBuildNotFound
+			}
+			return err
+		}
+		if check != nil {
+			if err := check(&current); err != nil {
+				return err
+			}
+		}
+
+		record := fromBuild(build)
+		record.CreatedAt = current.CreatedAt
+		if err := tx.Save(record).Error; err != nil {
+			return err
+		}
+
+		if len(events) == 0 {
+			return nil
+		}
+		rows := make([]Event, len(events))
+		for i, event := range events {
+			rows[i] = Event{
+				BuildID:     build.ID,
+				Type:        string(event.Type),
+				Hook:        event.Hook,
+				Message:     event.Message,
+				BuildStatus: record.Status,
+				Timestamp:   event.Timestamp,
+			}
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+// GetBuild loads a build record with its events in insertion order
+func (s *Store) GetBuild(ctx context.Context, id string) (*types.Build, error) {
+	var record Build
+	if err := s.db.WithContext(ctx).Where("id = ?", id).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBuildNotFound
+		}
+		return nil, err
+	}
+
+	var events []Event
+	if err := s.db.WithContext(ctx).Where("build_id = ?", id).Order("id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return toBuild(&record, events), nil
+}
+
+// ListBuilds returns a page of the project's builds, newest first. Events
+// are not loaded; GetBuild returns them.
+func (s *Store) ListBuilds(ctx context.Context, projectID string, labels []string, params pagination.Params) (*pagination.Page[types.Build], error) {
+	query := s.db.WithContext(ctx).Where("project_id = ?", projectID)
+	if len(labels) > 0 {
+		filter, err := json.Marshal(labels)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("labels @> ?", string(filter))
+	}
+	page, err := pagination.List[Build](query, params, buildListSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	builds := &pagination.Page[types.Build]{NextPageToken: page.NextPageToken}
+	for i := range page.Items {
+		builds.Items = append(builds.Items, *toBuild(&page.Items[i], nil))
+	}
+	return builds, nil
+}
+
+// ListUnfinishedBuilds returns the builds still to be built or deployed,
+// oldest first. Events are not loaded.
+func (s *Store) ListUnfinishedBuilds(ctx context.Context) ([]types.Build, error) {
+	var records []Build
+	err := s.db.WithContext(ctx).
+		Where("status IN ? OR deploying", unfinishedStatuses).
+		Order("start_time").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	builds := make([]types.Build, len(records))
+	for i := range records {
+		builds[i] = *toBuild(&records[i], nil)
+	}
+	return builds, nil
+}
+
+// TouchBuilds marks the unfinished builds of an instance alive
+func (s *Store) TouchBuilds(ctx context.Context, instance string, at time.Time) error {
+	return s.db.WithContext(ctx).Model(&Build{}).
+		Where("instance = ? AND (status IN ? OR deploying)", instance, unfinishedStatuses).
+		Update("updated_at", at).Error
+}
+
+// ListExpiredPreviews returns builds whose preview is still deployed but
+// expired before the given time
+func (s *Store) ListExpiredPreviews(ctx context.Context, before time.Time) ([]types.Build, error) {
+	var records []Build
+	err := s.db.WithContext(ctx).
+		Where("preview_name <> '' AND preview_removed_at IS NULL AND preview_expires_at < ?", before).
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	builds := make([]types.Build, len(records))
+	for i := range records {
+		builds[i] = *toBuild(&records[i], nil)
+	}
+	return builds, nil
+}
+
+// ListCoverage returns the project's latest builds with coverage, newest
+// first, optionally of one branch
+func (s *Store) ListCoverage(ctx context.Context, projectID, branch string, limit int) ([]types.Build, error) {
+	query := s.db.WithContext(ctx).Where("project_id = ? AND coverage IS NOT NULL", projectID)
+	if branch != "" {
+		query = query.Where("branch = ?", branch)
+	}
+	var records []Build
+	if err := query.Order("start_time DESC").Limit(limit).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	builds := make([]types.Build, len(records))
+	for i := range records {
+		builds[i] = *toBuild(&records[i], nil)
+	}
+	return builds, nil
+}
+
+// LatestPerfAudit returns the most recent performance audit of the
+// project's deployments to environment, or nil when there is none
+func (s *Store) LatestPerfAudit(ctx context.Context, projectID, environment string) (*types.PerfAudit, error) {
+	var record Build
+	err := s.db.WithContext(ctx).
+		Where("project_id = ? AND environment = ? AND perf_audit IS NOT NULL", projectID, environment).
+		Order("start_time DESC").
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.PerfAudit, nil
+}
+
+// LatestRelease returns the most recent release of the project's
+// deployments to environment, or nil when there is none
+func (s *Store) LatestRelease(ctx context.Context, projectID, environment string) (*types.Release, error) {
+	var record Build
+	err := s.db.WithContext(ctx).
+		Where("project_id = ? AND environment = ? AND release IS NOT NULL", projectID, environment).
+		Order("start_time DESC").
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record.Release, nil
+}
+
+// LatestDeployment returns the project's most recently completed
+// successful build of environment, or nil when there is none
+func (s *Store) LatestDeployment(ctx context.Context, projectID, environment string) (*types.Build, error) {
+	var record Build
+	err := s.db.WithContext(ctx).
+		Where("project_id = ? AND environment = ? AND status = ? AND complete_time IS NOT NULL",
+			projectID, environment, string(types.BuildStatusSuccess)).
+		Order("complete_time DESC").
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toBuild(&record, nil), nil
+}
+
+// ListDeployments returns the most recently completed successful build of
+// each environment of each project. Previews are not included.
+func (s *Store) ListDeployments(ctx context.Context) ([]types.Build, error) {
+	deployed := "status = ? AND complete_time IS NOT NULL AND preview_name = ''"
+	latest := s.db.Model(&Build{}).
+		Select("project_id, environment, MAX(complete_time)").
+		Where(deployed, string(types.BuildStatusSuccess)).
+		Group("project_id, environment")
+
+	var records []Build
+	err := s.db.WithContext(ctx).
+		Where(deployed, string(types.BuildStatusSuccess)).
+		Where("(project_id, environment, complete_time) IN (?)", latest).
+		Order("project_id, environment").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	builds := make([]types.Build, len(records))
+	for i := range records {
+		builds[i] = *toBuild(&records[i], nil)
+	}
+	return builds, nil
+}
+
+// LatestFinishedBuild returns the project's most recent build that passed
+// or failed, of branch unless empty, or nil when there is none
+func (s *Store) LatestFinishedBuild(ctx context.Context, projectID, branch string) (*types.Build, error) {
+	query := s.db.WithContext(ctx).Where("project_id = ? AND status IN ?", projectID,
+		[]string{string(types.BuildStatusSuccess), string(types.BuildStatusFailed)})
+	if branch != "" {
+		query = query.Where("branch = ?", branch)
+	}
+	var record Build
+	err := query.Order("start_time DESC").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toBuild(&record, nil), nil
+}
+
+// SetPinned marks a build as pinned or unpinned
+func (s *Store) SetPinned(ctx context.Context, id string, pinned bool) error {
+	result := s.db.WithContext(ctx).Model(&Build{}).Where("id = ?", id).Update("pinned", pinned)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBuildNotFound
+	}
+	return nil
+}
+
+// SetAnnotation replaces the note and labels of a build
+func (s *Store) SetAnnotation(ctx context.Context, id, note string, labels []string) error {
+	result := s.db.WithContext(ctx).Model(&Build{}).Where("id = ?", id).
+		Select("note", "labels").
+		Updates(&Build{Note: note, Labels: labels})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBuildNotFound
+	}
+	return nil
+}
+
+// ListPinned returns the IDs of all pinned builds
+func (s *Store) ListPinned(ctx context.Context) ([]string, error) {
+	var ids []string
+	err := s.db.WithContext(ctx).Model(&Build{}).Where("pinned").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ListProtectedImages returns the images of pinned builds, of deployed
+// previews and of the latest deployed build of each project
+func (s *Store) ListProtectedImages(ctx context.Context) ([]string, error) {
+	var refs []string
+	err := s.db.WithContext(ctx).Raw(`
+		SELECT image_id FROM builds
+		WHERE image_id <> '' AND (pinned OR (preview_name <> '' AND preview_removed_at IS NULL))
+		UNION
+		(SELECT DISTINCT ON (project_id) image_id FROM builds
+		WHERE image_id <> '' AND status = ? AND (preview_name = '' OR preview_promoted_at IS NOT NULL)
+		ORDER BY project_id, start_time DESC)`,
+		string(types.BuildStatusSuccess)).
+		Scan(&refs).Error
+	return refs, err
+}
+
+// DeleteProjectBuilds removes all build records and events of a project
+func (s *Store) DeleteProjectBuilds(ctx context.Context, projectID string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		builds := tx.Model(&Build{}).Select("id").Where("project_id = ?", projectID)
+		if err := tx.Where("build_id IN (?)", builds).Delete(&Event{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", projectID).Delete(&Migration{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("project_id = ?", projectID).Delete(&Build{}).Error
+	})
+}
+
+func fromBuild(build *types.Build) *Build {
+	record := &Build{
+		ID:              build.ID,
+		ProjectID:       build.ProjectID,
+		CommitHash:      build.CommitHash,
+		Framework:       build.Framework,
+		Environment:     build.Environment,
+		Status:          string(build.Status),
+		Deploying:       build.Deploying,
+		Instance:        build.Instance,
+		ImageID:         build.ImageID,
+		ArtifactPath:    build.ArtifactPath,
+		ArtifactDigest:  build.ArtifactDigest,
+		RolledBackTo:    build.RolledBackTo,
+		ErrorMessage:    build.ErrorMessage,
+		Diagnosis:       build.Diagnosis,
+		Stages:          build.Stages,
+		Warnings:        build.Warnings,
+		BuildEnv:        build.BuildEnv,
+		AddOns:          build.AddOns,
+		Jobs:            build.Jobs,
+		Processes:       build.Processes,
+		Timeouts:        build.Timeouts,
+		Pinned:          build.Pinned,
+		Note:            build.Note,
+		BuilderVersion:  build.BuilderVersion,
+		Labels:          build.Labels,
+		Provenance:      build.Provenance,
+		BaseImages:      build.BaseImages,
+		ImageSize:       build.ImageSize,
+		Toolchain:       build.Toolchain,
+		Input:           build.Input,
+		Vulnerabilities: build.Vulnerabilities,
+		Approvals:       build.Approvals,
+		PolicyResults:   build.PolicyResults,
+		TestResults:     build.TestResults,
+		Coverage:        build.Coverage,
+		PerfAudit:       build.PerfAudit,
+		Release:         build.Release,
+		External:        build.External,
+		StartTime:       build.StartTime,
+		CompleteTime:    build.CompleteTime,
+	}
+	if preview := build.Preview; preview != nil {
+		record.PreviewName = preview.Name
+		record.PreviewURL = preview.URL
+		record.PreviewExpiresAt = &preview.ExpiresAt
+		record.PreviewPromotedAt = preview.PromotedAt
+		record.PreviewRemovedAt = preview.RemovedAt
+	}
+	if commit := build.Commit; commit != nil {
+		record.CommitAuthor = commit.Author
+		record.CommitAuthorEmail = commit.AuthorEmail
+		record.CommitMessage = commit.Message
+		record.Branch = commit.Branch
+		record.Tag = commit.Tag
+	}
+	return record
+}
+
+func toBuild(record *Build, events []Event) *types.Build {
+	build := &types.Build{
+		ID:              record.ID,
+		ProjectID:       record.ProjectID,
+		CommitHash:      record.CommitHash,
+		Framework:       record.Framework,
+		Environment:     record.Environment,
+		Status:          types.BuildStatus(record.Status),
+		Deploying:       record.Deploying,
+		Instance:        record.Instance,
+		ImageID:         record.ImageID,
+		ArtifactPath:    record.ArtifactPath,
+		ArtifactDigest:  record.ArtifactDigest,
+		RolledBackTo:    record.RolledBackTo,
+		ErrorMessage:    record.ErrorMessage,
+		Diagnosis:       record.Diagnosis,
+		Stages:          record.Stages,
+		Warnings:        record.Warnings,
+		BuildEnv:        record.BuildEnv,
+		AddOns:          record.AddOns,
+		Jobs:            record.Jobs,
+		Processes:       record.Processes,
+		Timeouts:        record.Timeouts,
+		Pinned:          record.Pinned,
+		Note:            record.Note,
+		BuilderVersion:  record.BuilderVersion,
+		Labels:          record.Labels,
+		Provenance:      record.Provenance,
+		BaseImages:      record.BaseImages,
+		ImageSize:       record.ImageSize,
+		Toolchain:       record.Toolchain,
+		Input:           record.Input,
+		Vulnerabilities: record.Vulnerabilities,
+		Approvals:       record.Approvals,
+		PolicyResults:   record.PolicyResults,
+		TestResults:     record.TestResults,
+		Coverage:        record.Coverage,
+		PerfAudit:       record.PerfAudit,
+		Release:         record.Release,
+		External:        record.External,
+		StartTime:       record.StartTime,
+		CompleteTime:    record.CompleteTime,
+		UpdatedAt:       record.UpdatedAt,
+	}
+	if record.PreviewName != "" {
+		build.Preview = &types.Preview{
+			Name:       record.PreviewName,
+			URL:        record.PreviewURL,
+			PromotedAt: record.PreviewPromotedAt,
+			RemovedAt:  record.PreviewRemovedAt,
+		}
+		if record.PreviewExpiresAt != nil {
+			build.Preview.ExpiresAt = *record.PreviewExpiresAt
+		}
+	}
+	commit := types.CommitInfo{
+		Author:      record.CommitAuthor,
+		AuthorEmail: record.CommitAuthorEmail,
+		Message:     record.CommitMessage,
+		Branch:      record.Branch,
+		Tag:         record.Tag,
+	}
+	if commit != (types.CommitInfo{}) {
+		build.Commit = &commit
+	}
+	for _, event := range events {
+		build.Events = append(build.Events, types.DeploymentEvent{
+			Type:      types.DeploymentEventType(event.Type),
+			Hook:      event.Hook,
+			Message:   event.Message,
+			Timestamp: event.Timestamp,
+		})
+	}
+	return build
+}
+
+// AddUsage adds build and replica seconds to the stored days and keeps the
+// larger artifact size
+func (s *Store) AddUsage(ctx context.Context, records []types.Usage) error {
+	rows := make([]Usage, len(records))
+	for i, usage := range records {
+		rows[i] = Usage{
+			ProjectID:      usage.ProjectID,
+			Day:            usage.Day,
+			BuildSeconds:   usage.BuildSeconds,
+			ReplicaSeconds: usage.ReplicaSeconds,
+			ArtifactBytes:  usage.ArtifactBytes,
+		}
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "project_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"build_seconds":   gorm.Expr("project_usage.build_seconds + excluded.build_seconds"),
+			"replica_seconds": gorm.Expr("project_usage.replica_seconds + excluded.replica_seconds"),
+			"artifact_bytes":  gorm.Expr("GREATEST(project_usage.artifact_bytes, excluded.artifact_bytes)"),
+		}),
+	}).Create(&rows).Error
+}
+
+// ListUsage returns the days in [from, to] for a project, or for all
+// projects when projectID is empty
+func (s *Store) ListUsage(ctx context.Context, projectID string, from, to time.Time) ([]types.Usage, error) {
+	query := s.db.WithContext(ctx).Where("day BETWEEN ? AND ?", from, to)
+	if projectID != "" {
+		query = query.Where("project_id = ?", projectID)
+	}
+	var rows []Usage
+	if err := query.Order("day, project_id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	records := make([]types.Usage, len(rows))
+	for i, row := range rows {
+		records[i] = types.Usage{
+			ProjectID:      row.ProjectID,
+			Day:            row.Day,
+			BuildSeconds:   row.BuildSeconds,
+			ReplicaSeconds: row.ReplicaSeconds,
+			ArtifactBytes:  row.ArtifactBytes,
+		}
+	}
+	return records, nil
+}
+
+// BuildSeconds sums the duration of builds per project that finished in
+// [from, to)
+func (s *Store) BuildSeconds(ctx context.Context, from, to time.Time) (map[string]int64, error) {
+	var rows []struct {
+		ProjectID string
+		Seconds   int64
+	}
+	err := s.db.WithContext(ctx).Model(&Build{}).
+		Select("project_id, CAST(SUM(EXTRACT(EPOCH FROM complete_time - start_time)) AS BIGINT) AS seconds").
+		Where("complete_time >= ? AND complete_time < ?", from, to).
+		Group("project_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	seconds := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		seconds[row.ProjectID] = row.Seconds
+	}
+	return seconds, nil
+}
+
+// ListArtifacts maps the artifact paths of stored builds to their projects
+func (s *Store) ListArtifacts(ctx context.Context) (map[string]string, error) {
+	var rows []struct {
+		ArtifactPath string
+		ProjectID    string
+	}
+	err := s.db.WithContext(ctx).Model(&Build{}).
+		Select("artifact_path, project_id").
+		Where("artifact_path <> ''").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := make(map[string]string, len(rows))
+	for _, row := range rows {
+		artifacts[row.ArtifactPath] = row.ProjectID
+	}
+	return artifacts, nil
+}
+
+// RelocateArtifacts replaces the artifact paths of stored builds, keyed by
+// their old path
+func (s *Store) RelocateArtifacts(ctx context.Context, paths map[string]string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for from, to := range paths {
+			err := tx.Model(&Build{}).Where("artifact_path = ?", from).UpdateColumn("artifact_path", to).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveAddOn creates or replaces the record of a project's add-on
+func (s *Store) SaveAddOn(ctx context.Context, addon *types.AddOn) error {
+	return s.db.WithContext(ctx).Save(&AddOn{
+		ProjectID: addon.ProjectID,
+		Kind:      addon.Kind,
+		Provider:  addon.Provider,
+		Status:    string(addon.Status),
+		Ref:       addon.Ref,
+		Host:      addon.Host,
+		Secret:    addon.Secret,
+		Message:   addon.Message,
+		CreatedAt: addon.CreatedAt,
+		UpdatedAt: addon.UpdatedAt,
+	}).Error
+}
+
+// ListAddOns returns the add-ons of a project ordered by kind
+func (s *Store) ListAddOns(ctx context.Context, projectID string) ([]types.AddOn, error) {
+	var rows []AddOn
+	if err := s.db.WithContext(ctx).Where("project_id = ?", projectID).Order("kind").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	addons := make([]types.AddOn, len(rows))
+	for i, row := range rows {
+		addons[i] = types.AddOn{
+			ProjectID: row.ProjectID,
+			Kind:      row.Kind,
+			Provider:  row.Provider,
+			Status:    types.AddOnStatus(row.Status),
+			Ref:       row.Ref,
+			Host:      row.Host,
+			Secret:    row.Secret,
+			Message:   row.Message,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+		}
+	}
+	return addons, nil
+}
+
+// DeleteAddOn removes the record of a torn down add-on
+func (s *Store) DeleteAddOn(ctx context.Context, projectID, kind string) error {
+	return s.db.WithContext(ctx).Where("project_id = ? AND kind = ?", projectID, kind).Delete(&AddOn{}).Error
+}
+
+// SaveMigration creates or replaces the migration of a project's
+// environment
+func (s *Store) SaveMigration(ctx context.Context, migration *types.Migration) error {
+	return s.db.WithContext(ctx).Save(&Migration{
+		ProjectID:     migration.ProjectID,
+		Environment:   migration.Environment,
+		FromTarget:    migration.From,
+		ToTarget:      migration.To,
+		BuildID:       migration.BuildID,
+		Status:        string(migration.Status),
+		Message:       migration.Message,
+		StartedAt:     migration.StartedAt,
+		SwitchedAt:    migration.SwitchedAt,
+		RollbackUntil: migration.RollbackUntil,
+		FinishedAt:    migration.FinishedAt,
+	}).Error
+}
+
+// ListMigrations returns the latest migration of every project environment
+func (s *Store) ListMigrations(ctx context.Context) ([]types.Migration, error) {
+	var rows []Migration
+	if err := s.db.WithContext(ctx).Order("project_id, environment").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	migrations := make([]types.Migration, len(rows))
+	for i, row := range rows {
+		migrations[i] = types.Migration{
+			ProjectID:     row.ProjectID,
+			Environment:   row.Environment,
+			From:          row.FromTarget,
+			To:            row.ToTarget,
+			BuildID:       row.BuildID,
+			Status:        types.MigrationStatus(row.Status),
+			Message:       row.Message,
+			StartedAt:     row.StartedAt,
+			SwitchedAt:    row.SwitchedAt,
+			RollbackUntil: row.RollbackUntil,
+			FinishedAt:    row.FinishedAt,
+		}
+	}
+	return migrations, nil
+}
+
+// AcquireDeployLock takes the lock of the project's environment unless an
+// unexpired one is held by another build, and returns the lock's holder
+func (s *Store) AcquireDeployLock(ctx context.Context, lock *types.DeployLock) (*types.DeployLock, error) {
+	var holder DeployLock
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "project_id"}, {Name: "environment"}},
+			Where: clause.Where{Exprs: []clause.Expression{
+				gorm.Expr("deploy_locks.expires_at <= ? OR deploy_locks.build_id = ?", lock.AcquiredAt, lock.BuildID),
+			}},
+			DoUpdates: clause.AssignmentColumns([]string{"build_id", "owner", "acquired_at", "expires_at"}),
+		}).Create(&DeployLock{
+			ProjectID:   lock.ProjectID,
+			Environment: lock.Environment,
+			BuildID:     lock.BuildID,
+			Owner:       lock.Owner,
+			AcquiredAt:  lock.AcquiredAt,
+			ExpiresAt:   lock.ExpiresAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("project_id = ? AND environment = ?", lock.ProjectID, lock.Environment).First(&holder).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toDeployLock(&holder), nil
+}
+
+// RenewDeployLock extends the build's lock to expiresAt. It returns
+// types.ErrDeployLockNotFound when the build no longer holds the lock.
+func (s *Store) RenewDeployLock(ctx context.Context, projectID, environment, buildID string, expiresAt time.Time) error {
+	result := s.db.WithContext(ctx).Model(&DeployLock{}).
+		Where("project_id = ? AND environment = ? AND build_id = ?", projectID, environment, buildID).
+		Update("expires_at", expiresAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return types.ErrDeployLockNotFound
+	}
+	return nil
+}
+
+// ReleaseDeployLock releases the build's lock. It returns
+// types.ErrDeployLockNotFound when the build no longer holds the lock.
+func (s *Store) ReleaseDeployLock(ctx context.Context, projectID, environment, buildID string) error {
+	result := s.db.WithContext(ctx).
+		Where("project_id = ? AND environment = ? AND build_id = ?", projectID, environment, buildID).
+		Delete(&DeployLock{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return types.ErrDeployLockNotFound
+	}
+	return nil
+}
+
+// ListDeployLocks returns the unexpired locks of the project's environments
+func (s *Store) ListDeployLocks(ctx context.Context, projectID string) ([]types.DeployLock, error) {
+	var rows []DeployLock
+	err := s.db.WithContext(ctx).
+		Where("project_id = ? AND expires_at > ?", projectID, time.Now()).
+		Order("environment").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	locks := make([]types.DeployLock, len(rows))
+	for i := range rows {
+		locks[i] = *toDeployLock(&rows[i])
+	}
+	return locks, nil
+}
+
+func toDeployLock(row *DeployLock) *types.DeployLock {
+	return &types.DeployLock{
+		ProjectID:   row.ProjectID,
+		Environment: row.Environment,
+		BuildID:     row.BuildID,
+		Owner:       row.Owner,
+		AcquiredAt:  row.AcquiredAt,
+		ExpiresAt:   row.ExpiresAt,
+	}
+}
+
+// nodeVersionMatrixID is the ID of the matrix's single row
+const nodeVersionMatrixID = 1
+
+// LoadNodeVersions returns the stored node version matrix, no versions
+// when none was stored
+func (s *Store) LoadNodeVersions(ctx context.Context) ([]config.NodeVersionConfig, string, error) {
+	var row NodeVersionMatrix
+	err := s.db.WithContext(ctx).First(&row, nodeVersionMatrixID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return row.Versions, row.DefaultVersion, nil
+}
+
+// SaveNodeVersions replaces the stored node version matrix
+func (s *Store) SaveNodeVersions(ctx context.Context, versions []config.NodeVersionConfig, defaultVersion string) error {
+	return s.db.WithContext(ctx).Save(&NodeVersionMatrix{
+		ID:             nodeVersionMatrixID,
+		Versions:       versions,
+		DefaultVersion: defaultVersion,
+		UpdatedAt:      time.Now(),
+	}).Error
+}
@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// deployTarget is where the builds of one environment are deployed
+type deployTarget struct {
+	deployer deployer.Deployer
+	hooks    *deployer.HookRunner
+}
+
+// target returns the deployer and hooks serving a project's environment
+func (p *Pipeline) target(projectID, environment string) (deployer.Deployer, *deployer.HookRunner) {
+	t, _ := p.namedTarget(p.targetName(projectID, environment))
+	return t.deployer, t.hooks
+}
+
+// targetName returns the name of the target serving a project's
+// environment: the one it was migrated to, the environment's own target or
+// the default one, named ""
+func (p *Pipeline) targetName(projectID, environment string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.servingTarget(projectID, environment)
+}
+
+// servingTarget is targetName for callers holding mu
+func (p *Pipeline) servingTarget(projectID, environment string) string {
+	if migration := p.migrations[migrationKey{projectID, environment}]; migration != nil {
+		// Targets dropped from the config since are ignored
+		if _, ok := p.namedTarget(migration.Serving()); ok {
+			return migration.Serving()
+		}
+	}
+	if _, ok := p.targets[environment]; ok {
+		return environment
+	}
+	return ""
+}
+
+// namedTarget returns a target of deploy.targets, or the default target
+// for an empty name
+func (p *Pipeline) namedTarget(name string) (deployTarget, bool) {
+	if name == "" {
+		return deployTarget{deployer: p.deployer, hooks: p.hooks}, true
+	}
+	t, ok := p.targets[name]
+	return t, ok
+}
+
+// removers returns every target able to remove deployments, the default
+// one first
+func (p *Pipeline) removers() []deployer.Remover {
+	var removers []deployer.Remover
+	if remover, ok := p.deployer.(deployer.Remover); ok {
+		removers = append(removers, remover)
+	}
+	for _, t := range p.targets {
+		if remover, ok := t.deployer.(deployer.Remover); ok {
+			removers = append(removers, remover)
+		}
+	}
+	return removers
+}
+
+func isRemover(d deployer.Deployer) bool {
+	_, ok := d.(deployer.Remover)
+	return ok
+}
+
+// probeTimeout bounds the checks of each deploy target
+const probeTimeout = 10 * time.Second
+
+// TargetDescription is what a deploy target can do and whether it is able
+// to deploy
+type TargetDescription struct {
+	Environment   string // Empty for the default target
+	Platform      string
+	Domain        string // Apps are served at <project>.<domain>
+	Checks        []deployer.Check
+	MaxDeploySize int64 // Bytes, 0 for no limit
+	MinReplicas   int32 // Bounds of ScaleDeployment, 0 when the target cannot scale
+	MaxReplicas   int32
+	Features      []string
+}
+
+// Healthy reports whether every check of the target passed. Targets whose
+// platform has no checks are healthy.
+func (d *TargetDescription) Healthy() bool {
+	for _, check := range d.Checks {
+		if !check.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// DescribeTargets checks the deploy targets, the default one first and the
+// others by environment
+func (p *Pipeline) DescribeTargets(ctx context.Context) []TargetDescription {
+	names := []string{""}
+	for env := range p.targets {
+		names = append(names, env)
+	}
+	sort.Strings(names[1:])
+
+	descriptions := make([]TargetDescription, 0, len(names))
+	for _, name := range names {
+		t, _ := p.namedTarget(name)
+		cfg := p.config.Deploy.Target(name)
+		description := TargetDescription{
+			Environment: name,
+			Platform:    cfg.Platform,
+			Domain:      cfg.IngressDomain,
+			Features:    p.targetFeatures(name, t.deployer),
+		}
+		if prober, ok := t.deployer.(deployer.Prober); ok {
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			description.Checks = prober.Probe(probeCtx)
+			cancel()
+		}
+		if limiter, ok := t.deployer.(deployer.SizeLimiter); ok {
+			description.MaxDeploySize = limiter.MaxDeploySize()
+		}
+		if _, ok := t.deployer.(deployer.Scaler); ok && name == "" {
+			description.MinReplicas, description.MaxReplicas = p.replicaBounds()
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+// targetFeatures lists the optional operations a target supports. Logs,
+// exec, scaling, restarts, processes and jobs act on the default target
+// only.
+func (p *Pipeline) targetFeatures(name string, d deployer.Deployer) []string {
+	var features []string
+	supports := func(feature string, ok bool) {
+		if ok {
+			features = append(features, feature)
+		}
+	}
+	if name == "" {
+		_, ok := d.(deployer.LogStreamer)
+		supports("logs", ok)
+		_, ok = d.(deployer.Execer)
+		supports("exec", ok)
+		_, ok = d.(deployer.Scaler)
+		supports("scale", ok)
+		_, ok = d.(deployer.Restarter)
+		supports("restart", ok)
+		_, ok = d.(deployer.ProcessManager)
+		supports("processes", ok)
+		_, ok = d.(deployer.JobRunner)
+		supports("jobs", ok)
+	}
+	supports("remove", isRemover(d))
+	return features
+}
+
+// DescribeDeployTargets reports the targets of the instance answering so
+// clients can offer what they support. It is admin only: the checks name
+// cluster and registry addresses.
+func (h *Handler) DescribeDeployTargets(ctx context.Context, _ *pb.DescribeDeployTargetsRequest) (*pb.DescribeDeployTargetsResponse, error) {
+	resp := &pb.DescribeDeployTargetsResponse{}
+	for _, description := range h.pipeline.DescribeTargets(ctx) {
+		target := &pb.DeployTarget{
+			Environment:   description.Environment,
+			Platform:      description.Platform,
+			Healthy:       description.Healthy(),
+			Domain:        description.Domain,
+			MaxDeploySize: description.MaxDeploySize,
+			MinReplicas:   description.MinReplicas,
+			MaxReplicas:   description.MaxReplicas,
+			Features:      description.Features,
+		}
+		for _, check := range description.Checks {
+			target.Checks = append(target.Checks, &pb.TargetCheck{
+				Name:    check.Name,
+				Ok:      check.OK,
+				Message: check.Message,
+			})
+		}
+		resp.Targets = append(resp.Targets, target)
+	}
+	return resp, nil
+}
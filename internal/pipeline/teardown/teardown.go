@@ -0,0 +1,115 @@
+// Package teardown deletes kubernetes objects and waits until they are
+// gone, so a removed project's names can be reused and objects held by
+// finalizers are reported instead of lingering unnoticed.
+package teardown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultTimeout bounds the wait for deleted objects
+const DefaultTimeout = 2 * time.Minute
+
+const maxPollInterval = 2 * time.Second
+
+// ErrStuck is returned when deleted objects are still present after the
+// timeout, usually held by a finalizer whose controller is not running
+var ErrStuck = errors.New("deletion did not finish")
+
+// firstPoll is the delay before objects are first checked, doubling up to
+// maxPollInterval
+var firstPoll = 100 * time.Millisecond
+
+// Object is a kubernetes object to delete
+type Object struct {
+	Kind   string
+	Name   string
+	Delete func(ctx context.Context) error
+	// Get returns the object while it exists
+	Get func(ctx context.Context) (metav1.Object, error)
+}
+
+// Getter adapts a typed get of an object to Object.Get
+func Getter[T metav1.Object](get func(ctx context.Context) (T, error)) func(ctx context.Context) (metav1.Object, error) {
+	return func(ctx context.Context) (metav1.Object, error) {
+		return get(ctx)
+	}
+}
+
+// Delete deletes objects and waits up to timeout until all are gone.
+// Objects that are already gone are skipped. Objects still present after
+// timeout fail with ErrStuck naming the finalizers holding them.
+func Delete(ctx context.Context, timeout time.Duration, objects ...Object) error {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	var pending []Object
+	for _, object := range objects {
+		err := object.Delete(ctx)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete %s %s: %w", object.Kind, object.Name, err)
+		}
+		pending = append(pending, object)
+	}
+
+	deadline := time.Now().Add(timeout)
+	delay := firstPoll
+	var remaining []metav1.Object
+	for len(pending) > 0 {
+		select {
+		case <-time.After(min(delay, time.Until(deadline))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(delay*2, maxPollInterval)
+
+		var next []Object
+		remaining = remaining[:0]
+		for _, object := range pending {
+			current, err := object.Get(ctx)
+			if k8serrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get %s %s: %w", object.Kind, object.Name, err)
+			}
+			next = append(next, object)
+			remaining = append(remaining, current)
+		}
+		pending = next
+
+		if len(pending) > 0 && !time.Now().Before(deadline) {
+			return stuck(pending, remaining, timeout)
+		}
+	}
+	return nil
+}
+
+func stuck(objects []Object, current []metav1.Object, timeout time.Duration) error {
+	descriptions := make([]string, len(objects))
+	finalized := false
+	for i, object := range objects {
+		description := fmt.Sprintf("%s %s", object.Kind, object.Name)
+		if finalizers := current[i].GetFinalizers(); len(finalizers) > 0 {
+			description += fmt.Sprintf(" (finalizers: %s)", strings.Join(finalizers, ", "))
+			finalized = true
+		}
+		descriptions[i] = description
+	}
+	err := fmt.Errorf("%w after %s: %s still present", ErrStuck, timeout, strings.Join(descriptions, ", "))
+	if finalized {
+		err = fmt.Errorf("%w; the controllers owning the finalizers have not released them", err)
+	}
+	return err
+}
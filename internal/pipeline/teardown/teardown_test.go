@@ -0,0 +1,64 @@
+package teardown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeObject is gone after it was looked up getsLeft times
+type fakeObject struct {
+	name       string
+	deleted    bool
+	getsLeft   int
+	finalizers []string
+	deleteErr  error
+}
+
+func (o *fakeObject) object() Object {
+	notFound := k8serrors.NewNotFound(schema.GroupResource{Resource: "services"}, o.name)
+	return Object{
+		Kind: "service",
+		Name: o.name,
+		Delete: func(context.Context) error {
+			o.deleted = true
+			return o.deleteErr
+		},
+		Get: Getter(func(context.Context) (*corev1.Service, error) {
+			if o.getsLeft == 0 {
+				return nil, notFound
+			}
+			o.getsLeft--
+			return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: o.name, Finalizers: o.finalizers}}, nil
+		}),
+	}
+}
+
+func TestDelete(t *testing.T) {
+	firstPoll = time.Millisecond
+	ctx := context.Background()
+
+	web := &fakeObject{name: "web", getsLeft: 2}
+	gone := &fakeObject{name: "gone", deleteErr: k8serrors.NewNotFound(schema.GroupResource{Resource: "services"}, "gone")}
+	require.NoError(t, Delete(ctx, time.Second, web.object(), gone.object()))
+	assert.True(t, web.deleted)
+	assert.Zero(t, web.getsLeft)
+
+	failing := &fakeObject{name: "api", deleteErr: errors.New("forbidden")}
+	err := Delete(ctx, time.Second, failing.object())
+	assert.EqualError(t, err, "failed to delete service api: forbidden")
+
+	stuck := &fakeObject{name: "db", getsLeft: -1, finalizers: []string{"example.com/protect"}}
+	err = Delete(ctx, 20*time.Millisecond, web.object(), stuck.object())
+	assert.ErrorIs(t, err, ErrStuck)
+	assert.Contains(t, err.Error(), "service db (finalizers: example.com/protect) still present")
+	assert.NotContains(t, err.Error(), "service web")
+}
@@ -0,0 +1,142 @@
+package testreport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// sourceRoot is where the sources live in the build container; coverage
+// paths are reported relative to it
+const sourceRoot = "/app/"
+
+// ParseCoverage reads an lcov or Cobertura XML coverage report, detected
+// from its content
+func ParseCoverage(data []byte) (*types.Coverage, error) {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) == 0:
+		return nil, ErrUnknownFormat
+	case data[0] == '<':
+		return parseCobertura(data)
+	case bytes.HasPrefix(data, []byte("TN:")), bytes.HasPrefix(data, []byte("SF:")):
+		return parseLcov(data)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// packageLines accumulates line counts per package
+type packageLines map[string]*types.PackageCoverage
+
+func (p packageLines) add(name string, lines, covered int) {
+	pkg, ok := p[name]
+	if !ok {
+		pkg = &types.PackageCoverage{Name: name}
+		p[name] = pkg
+	}
+	pkg.Lines += lines
+	pkg.Covered += covered
+}
+
+func (p packageLines) coverage() *types.Coverage {
+	total := &types.Coverage{}
+	other := &types.Coverage{}
+	for _, pkg := range p {
+		other.Lines += pkg.Lines
+		other.Covered += pkg.Covered
+		other.Packages = append(other.Packages, *pkg)
+	}
+	// Add sorts and bounds the packages
+	total.Add(other)
+	return total
+}
+
+// filePackage is the directory of a source file relative to the sources
+func filePackage(file string) string {
+	dir := path.Dir(strings.TrimPrefix(path.Clean(file), sourceRoot))
+	if dir == "/" {
+		return "."
+	}
+	return dir
+}
+
+// parseLcov reads the LF (lines found) and LH (lines hit) totals of each
+// SF record
+func parseLcov(data []byte) (*types.Coverage, error) {
+	packages := packageLines{}
+	var file string
+	var found, hit int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		var err error
+		switch key {
+		case "SF":
+			file, found, hit = value, 0, 0
+		case "LF":
+			found, err = strconv.Atoi(value)
+		case "LH":
+			hit, err = strconv.Atoi(value)
+		case "end_of_record":
+			if file == "" {
+				return nil, fmt.Errorf("invalid lcov report: record without SF")
+			}
+			packages.add(filePackage(file), found, hit)
+			file = ""
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid lcov report: %s: %w", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid lcov report: %w", err)
+	}
+	return packages.coverage(), nil
+}
+
+type cobertura struct {
+	XMLName  xml.Name `xml:"coverage"`
+	Packages []struct {
+		Name    string `xml:"name,attr"`
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Hits int `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+// parseCobertura counts the lines of each package's classes; a line is
+// covered when it was hit at least once
+func parseCobertura(data []byte) (*types.Coverage, error) {
+	var report cobertura
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid Cobertura report: %w", err)
+	}
+
+	packages := packageLines{}
+	for _, pkg := range report.Packages {
+		for _, class := range pkg.Classes {
+			name := pkg.Name
+			if name == "" {
+				name = filePackage(class.Filename)
+			}
+			covered := 0
+			for _, line := range class.Lines {
+				if line.Hits > 0 {
+					covered++
+				}
+			}
+			packages.add(name, len(class.Lines), covered)
+		}
+	}
+	return packages.coverage(), nil
+}
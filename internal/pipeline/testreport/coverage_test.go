@@ -0,0 +1,74 @@
+package testreport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestParseCoverage_Lcov(t *testing.T) {
+	report := `TN:
+SF:/app/src/cart/total.js
+FN:1,total
+LF:10
+LH:8
+end_of_record
+SF:/app/src/cart/coupon.js
+LF:10
+LH:2
+end_of_record
+SF:src/index.js
+LF:5
+LH:5
+end_of_record
+`
+	coverage, err := ParseCoverage([]byte(report))
+	require.NoError(t, err)
+	assert.Equal(t, 25, coverage.Lines)
+	assert.Equal(t, 15, coverage.Covered)
+	assert.InDelta(t, 60.0, coverage.Percent(), 0.001)
+	assert.Equal(t, []types.PackageCoverage{
+		{Name: "src", Lines: 5, Covered: 5},
+		{Name: "src/cart", Lines: 20, Covered: 10},
+	}, coverage.Packages)
+}
+
+func TestParseCoverage_Cobertura(t *testing.T) {
+	report := `<?xml version="1.0" ?>
+<coverage lines-valid="4" lines-covered="3">
+  <packages>
+    <package name="src.cart">
+      <classes>
+        <class filename="src/cart/total.js">
+          <lines><line number="1" hits="3"/><line number="2" hits="0"/></lines>
+        </class>
+      </classes>
+    </package>
+    <package name="">
+      <classes>
+        <class filename="src/index.js">
+          <lines><line number="1" hits="1"/><line number="2" hits="1"/></lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>`
+	coverage, err := ParseCoverage([]byte(report))
+	require.NoError(t, err)
+	assert.Equal(t, 4, coverage.Lines)
+	assert.Equal(t, 3, coverage.Covered)
+	assert.Equal(t, []types.PackageCoverage{
+		{Name: "src", Lines: 2, Covered: 2},
+		{Name: "src.cart", Lines: 2, Covered: 1},
+	}, coverage.Packages)
+}
+
+func TestParseCoverage_Invalid(t *testing.T) {
+	for _, report := range []string{"", "All files | 80.5", "SF:a.js\nLF:ten\nend_of_record\n", "<testsuite/>"} {
+		_, err := ParseCoverage([]byte(report))
+		assert.Error(t, err, report)
+	}
+}
@@ -0,0 +1,156 @@
+// Package testreport reads the JUnit XML and Jest JSON reports written by a
+// project's test command.
+package testreport
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// maxMessage bounds the failure message kept per test
+const maxMessage = 1024
+
+var ErrUnknownFormat = errors.New("unknown test report format")
+
+// Parse reads a JUnit XML or Jest JSON report, detected from its content
+func Parse(data []byte) (*types.TestResults, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, ErrUnknownFormat
+	}
+	switch data[0] {
+	case '<':
+		return parseJUnit(data)
+	case '{':
+		return parseJest(data)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// junitSuite is a <testsuite>, or the <testsuites> root holding them
+type junitSuite struct {
+	XMLName xml.Name
+	Name    string       `xml:"name,attr"`
+	Cases   []junitCase  `xml:"testcase"`
+	Suites  []junitSuite `xml:"testsuite"` // Suites may nest
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failures  []junitResult `xml:"failure"`
+	Errors    []junitResult `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func parseJUnit(data []byte) (*types.TestResults, error) {
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid JUnit report: %w", err)
+	}
+	if root.XMLName.Local != "testsuites" && root.XMLName.Local != "testsuite" {
+		return nil, fmt.Errorf("invalid JUnit report: unexpected element <%s>", root.XMLName.Local)
+	}
+
+	results := &types.TestResults{}
+	addJUnitSuite(results, root)
+	return results, nil
+}
+
+func addJUnitSuite(results *types.TestResults, suite junitSuite) {
+	for _, tc := range suite.Cases {
+		results.Total++
+		failures := append(tc.Failures, tc.Errors...)
+		switch {
+		case len(failures) > 0:
+			results.Failed++
+			name := suite.Name
+			if name == "" {
+				name = tc.ClassName
+			}
+			message := failures[0].Message
+			if message == "" {
+				message = failures[0].Text
+			}
+			addFailure(results, name, tc.Name, message)
+		case tc.Skipped != nil:
+			results.Skipped++
+		default:
+			results.Passed++
+		}
+	}
+	for _, nested := range suite.Suites {
+		addJUnitSuite(results, nested)
+	}
+}
+
+// jestReport is the output of jest --json
+type jestReport struct {
+	NumTotalTests *int `json:"numTotalTests"`
+	TestResults   []struct {
+		Name             string `json:"name"`
+		Message          string `json:"message"` // Set when the file failed to run
+		Status           string `json:"status"`
+		AssertionResults []struct {
+			FullName        string   `json:"fullName"`
+			Status          string   `json:"status"`
+			FailureMessages []string `json:"failureMessages"`
+		} `json:"assertionResults"`
+	} `json:"testResults"`
+}
+
+func parseJest(data []byte) (*types.TestResults, error) {
+	var report jestReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid Jest report: %w", err)
+	}
+	if report.NumTotalTests == nil {
+		return nil, ErrUnknownFormat
+	}
+
+	results := &types.TestResults{}
+	for _, file := range report.TestResults {
+		if len(file.AssertionResults) == 0 && file.Status == "failed" {
+			// A file that failed to load has no assertions but fails the run
+			results.Total++
+			results.Failed++
+			addFailure(results, file.Name, "", file.Message)
+			continue
+		}
+		for _, assertion := range file.AssertionResults {
+			results.Total++
+			switch assertion.Status {
+			case "passed":
+				results.Passed++
+			case "failed":
+				results.Failed++
+				addFailure(results, file.Name, assertion.FullName, strings.Join(assertion.FailureMessages, "\n"))
+			default: // pending, skipped, todo, disabled
+				results.Skipped++
+			}
+		}
+	}
+	return results, nil
+}
+
+func addFailure(results *types.TestResults, suite, name, message string) {
+	if len(results.Failures) >= types.MaxTestFailures {
+		return
+	}
+	message = strings.TrimSpace(message)
+	if len(message) > maxMessage {
+		message = message[:maxMessage] + "..."
+	}
+	results.Failures = append(results.Failures, types.TestFailure{Suite: suite, Name: name, Message: message})
+}
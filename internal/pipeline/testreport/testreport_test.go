@@ -0,0 +1,93 @@
+package testreport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func TestParse_JUnit(t *testing.T) {
+	report := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="jest tests" tests="4">
+  <testsuite name="src/cart.test.js" tests="3">
+    <testcase classname="cart" name="adds items"/>
+    <testcase classname="cart" name="applies coupons">
+      <failure message="expected 90 to equal 80">AssertionError: expected 90 to equal 80</failure>
+    </testcase>
+    <testcase classname="cart" name="ships abroad"><skipped/></testcase>
+  </testsuite>
+  <testsuite name="src/api.test.js" tests="1">
+    <testcase classname="api" name="fetches"><error>TypeError: fetch is not a function</error></testcase>
+  </testsuite>
+</testsuites>`
+
+	results, err := Parse([]byte(report))
+	require.NoError(t, err)
+	assert.Equal(t, 4, results.Total)
+	assert.Equal(t, 1, results.Passed)
+	assert.Equal(t, 2, results.Failed)
+	assert.Equal(t, 1, results.Skipped)
+	assert.Equal(t, []types.TestFailure{
+		{Suite: "src/cart.test.js", Name: "applies coupons", Message: "expected 90 to equal 80"},
+		{Suite: "src/api.test.js", Name: "fetches", Message: "TypeError: fetch is not a function"},
+	}, results.Failures)
+
+	single, err := Parse([]byte(`<testsuite name="unit"><testcase name="works"/></testsuite>`))
+	require.NoError(t, err)
+	assert.Equal(t, 1, single.Passed)
+}
+
+func TestParse_Jest(t *testing.T) {
+	report := `{
+  "numTotalTests": 4,
+  "testResults": [
+    {
+      "name": "/app/src/cart.test.js",
+      "status": "failed",
+      "assertionResults": [
+        {"fullName": "cart adds items", "status": "passed", "failureMessages": []},
+        {"fullName": "cart applies coupons", "status": "failed", "failureMessages": ["Expected: 80\nReceived: 90"]},
+        {"fullName": "cart ships abroad", "status": "pending", "failureMessages": []}
+      ]
+    },
+    {"name": "/app/src/broken.test.js", "status": "failed", "message": "Cannot find module './api'", "assertionResults": []}
+  ]
+}`
+
+	results, err := Parse([]byte(report))
+	require.NoError(t, err)
+	assert.Equal(t, 4, results.Total)
+	assert.Equal(t, 1, results.Passed)
+	assert.Equal(t, 2, results.Failed)
+	assert.Equal(t, 1, results.Skipped)
+	require.Len(t, results.Failures, 2)
+	assert.Equal(t, "cart applies coupons", results.Failures[0].Name)
+	assert.Equal(t, "Expected: 80\nReceived: 90", results.Failures[0].Message)
+	assert.Equal(t, "Cannot find module './api'", results.Failures[1].Message)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, report := range []string{"", "PASS src/cart.test.js", `{"coverage": {}}`, "<html></html>"} {
+		_, err := Parse([]byte(report))
+		assert.Error(t, err, report)
+	}
+}
+
+func TestParse_BoundsFailures(t *testing.T) {
+	var report strings.Builder
+	report.WriteString("<testsuite name=\"big\">")
+	for i := 0; i < types.MaxTestFailures+10; i++ {
+		report.WriteString(`<testcase name="case"><failure message="` + strings.Repeat("x", 2000) + `"/></testcase>`)
+	}
+	report.WriteString("</testsuite>")
+
+	results, err := Parse([]byte(report.String()))
+	require.NoError(t, err)
+	assert.Equal(t, types.MaxTestFailures+10, results.Failed)
+	assert.Len(t, results.Failures, types.MaxTestFailures)
+	assert.Len(t, results.Failures[0].Message, maxMessage+3)
+}
@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const (
+	defaultCoverageTrendLimit = 30
+	maxCoverageTrendLimit     = 200
+)
+
+// recordTestFailure keeps the results of tests that failed a build
+func (p *Pipeline) recordTestFailure(build *types.Build, err error) {
+	var failed *builder.TestsFailedError
+	if errors.As(err, &failed) {
+		p.recordTestResults(build, failed.Results, failed.Coverage)
+	}
+}
+
+func (p *Pipeline) recordTestResults(build *types.Build, results *types.TestResults, coverage *types.Coverage) {
+	if results == nil {
+		return
+	}
+	p.updateBuild(build, func(b *types.Build) {
+		b.TestResults = results
+		b.Coverage = coverage
+	})
+}
+
+func (h *Handler) GetBuildTestResults(ctx context.Context, req *pb.GetBuildTestResultsRequest) (*pb.TestResults, error) {
+	if req.BuildId == "" {
+		return nil, status.Error(codes.InvalidArgument, "build id is required")
+	}
+
+	build, err := h.pipeline.LookupBuild(ctx, req.BuildId)
+	if err != nil {
+		if errors.Is(err, types.ErrBuildNotFound) {
+			return nil, status.Error(codes.NotFound, "build not found")
+		}
+		h.log.Error("failed to get build", zap.String("build_id", req.BuildId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get build")
+	}
+	if err := h.authorizeProject(ctx, build.ProjectID); err != nil {
+		return nil, err
+	}
+	if build.TestResults == nil {
+		return nil, status.Error(codes.NotFound, "build has no test results")
+	}
+	resp := testResultsToProto(build.TestResults)
+	if build.Coverage != nil {
+		resp.Coverage = coverageToProto(build.Coverage)
+	}
+	return resp, nil
+}
+
+func (h *Handler) GetCoverageTrend(ctx context.Context, req *pb.GetCoverageTrendRequest) (*pb.GetCoverageTrendResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	limit := int(req.Limit)
+	switch {
+	case limit <= 0:
+		limit = defaultCoverageTrendLimit
+	case limit > maxCoverageTrendLimit:
+		limit = maxCoverageTrendLimit
+	}
+
+	builds, err := h.pipeline.CoverageTrend(ctx, req.ProjectId, req.Branch, limit)
+	if err != nil {
+		h.log.Error("failed to list coverage", zap.String("project", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list coverage")
+	}
+
+	resp := &pb.GetCoverageTrendResponse{}
+	for _, build := range builds {
+		point := &pb.CoveragePoint{
+			BuildId:    build.ID,
+			CommitHash: build.CommitHash,
+			StartTime:  build.StartTime.Unix(),
+			Percent:    build.Coverage.Percent(),
+			Lines:      int32(build.Coverage.Lines),
+			Covered:    int32(build.Coverage.Covered),
+		}
+		if build.Commit != nil {
+			point.Branch = build.Commit.Branch
+		}
+		resp.Points = append(resp.Points, point)
+	}
+	return resp, nil
+}
+
+func testResultsToProto(results *types.TestResults) *pb.TestResults {
+	resp := &pb.TestResults{
+		Total:    int32(results.Total),
+		Passed:   int32(results.Passed),
+		Failed:   int32(results.Failed),
+		Skipped:  int32(results.Skipped),
+		ExitCode: int32(results.ExitCode),
+	}
+	for _, failure := range results.Failures {
+		resp.Failures = append(resp.Failures, &pb.TestFailure{
+			Suite:   failure.Suite,
+			Name:    failure.Name,
+			Message: failure.Message,
+		})
+	}
+	return resp
+}
+
+func coverageToProto(coverage *types.Coverage) *pb.Coverage {
+	resp := &pb.Coverage{
+		Lines:   int32(coverage.Lines),
+		Covered: int32(coverage.Covered),
+		Percent: coverage.Percent(),
+	}
+	for _, pkg := range coverage.Packages {
+		resp.Packages = append(resp.Packages, &pb.PackageCoverage{
+			Name:    pkg.Name,
+			Lines:   int32(pkg.Lines),
+			Covered: int32(pkg.Covered),
+			Percent: pkg.Percent(),
+		})
+	}
+	return resp
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/builder"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+func TestPipeline_RecordTestFailure(t *testing.T) {
+	p, _, _, _ := setupTestPipeline(t)
+	build := createTestBuild()
+	results := &types.TestResults{Total: 2, Failed: 1}
+	coverage := &types.Coverage{Lines: 10, Covered: 9}
+
+	p.recordTestFailure(build, errors.New("npm run build exited with 1"))
+	assert.Nil(t, build.TestResults)
+
+	err := fmt.Errorf("build failed: %w", &builder.TestsFailedError{Results: results, Coverage: coverage})
+	p.recordTestFailure(build, err)
+	assert.Same(t, results, build.TestResults)
+	assert.Same(t, coverage, build.Coverage)
+	assert.Equal(t, "build failed: tests failed: 1 of 2 tests failed", err.Error())
+}
+
+func TestHandler_GetBuildTestResults(t *testing.T) {
+	p := &Pipeline{
+		config: &config.PipelineConfig{},
+		builds: map[string]*types.Build{
+			"build-1": {ID: "build-1", ProjectID: "shop", TestResults: &types.TestResults{
+				Total:    2,
+				Passed:   1,
+				Failed:   1,
+				ExitCode: 1,
+				Failures: []types.TestFailure{{Suite: "cart.test.js", Name: "applies coupons", Message: "expected 80"}},
+			}},
+			"build-2": {ID: "build-2", ProjectID: "shop"},
+		},
+	}
+	h := NewHandler(p, nil, nil, nil, &mockDeployer{}, ownerAuthorizer{}, &recordingAuditor{}, zap.NewNop())
+	ctx := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+
+	resp, err := h.GetBuildTestResults(ctx, &pb.GetBuildTestResultsRequest{BuildId: "build-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), resp.Failed)
+	require.Len(t, resp.Failures, 1)
+	assert.Equal(t, "applies coupons", resp.Failures[0].Name)
+
+	_, err = h.GetBuildTestResults(ctx, &pb.GetBuildTestResultsRequest{BuildId: "build-2"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	other := context.WithValue(context.Background(), auth.UserContextKey, "mallory")
+	_, err = h.GetBuildTestResults(other, &pb.GetBuildTestResultsRequest{BuildId: "build-1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestHandler_GetCoverageTrend(t *testing.T) {
+	now := time.Now()
+	build := func(id, branch string, age time.Duration, covered int) *types.Build {
+		b := &types.Build{ID: id, ProjectID: "shop", StartTime: now.Add(-age), Commit: &types.CommitInfo{Branch: branch}}
+		if covered > 0 {
+			b.Coverage = &types.Coverage{Lines: 100, Covered: covered}
+		}
+		return b
+	}
+	p := &Pipeline{
+		config: &config.PipelineConfig{},
+		builds: map[string]*types.Build{
+			"old":     build("old", "main", 3*time.Hour, 70),
+			"new":     build("new", "main", time.Hour, 80),
+			"feature": build("feature", "feature", 2*time.Hour, 60),
+			"nocov":   build("nocov", "main", 0, 0),
+		},
+	}
+	h := NewHandler(p, nil, nil, nil, &mockDeployer{}, ownerAuthorizer{}, &recordingAuditor{}, zap.NewNop())
+	ctx := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+
+	resp, err := h.GetCoverageTrend(ctx, &pb.GetCoverageTrendRequest{ProjectId: "shop", Branch: "main"})
+	require.NoError(t, err)
+	require.Len(t, resp.Points, 2)
+	assert.Equal(t, "new", resp.Points[0].BuildId)
+	assert.InDelta(t, 80.0, resp.Points[0].Percent, 0.001)
+	assert.Equal(t, "old", resp.Points[1].BuildId)
+
+	resp, err = h.GetCoverageTrend(ctx, &pb.GetCoverageTrendRequest{ProjectId: "shop", Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, resp.Points, 2)
+	assert.Equal(t, "feature", resp.Points[1].BuildId)
+}
@@ -0,0 +1,39 @@
+package types
+
+import "time"
+
+// Managed services a project can request with addons in chef.yaml
+const (
+	AddOnPostgres = "postgres"
+	AddOnRedis    = "redis"
+)
+
+// ValidAddOn reports whether kind is a known add-on
+func ValidAddOn(kind string) bool {
+	return kind == AddOnPostgres || kind == AddOnRedis
+}
+
+type AddOnStatus string
+
+const (
+	AddOnProvisioning AddOnStatus = "provisioning"
+	AddOnReady        AddOnStatus = "ready"
+	AddOnFailed       AddOnStatus = "failed"
+	AddOnDeleting     AddOnStatus = "deleting" // Torn down with the project
+)
+
+// AddOn is a managed service provisioned for a project. Its connection
+// settings are kept in the Kubernetes secret Secret and injected into the
+// project's deployments as env vars.
+type AddOn struct {
+	ProjectID string      `json:"project_id"`
+	Kind      string      `json:"kind"`     // "postgres" or "redis"
+	Provider  string      `json:"provider"` // "kubernetes" or "external"
+	Status    AddOnStatus `json:"status"`
+	Ref       string      `json:"ref,omitempty"` // Provider's ID of the instance
+	Host      string      `json:"host,omitempty"`
+	Secret    string      `json:"secret"`
+	Message   string      `json:"message,omitempty"` // Why provisioning failed
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
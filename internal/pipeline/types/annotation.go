@@ -0,0 +1,16 @@
+package types
+
+import "regexp"
+
+const (
+	MaxNoteLength = 500 // Characters of a build's note
+	MaxLabels     = 10  // Labels per build
+)
+
+var labelPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// ValidLabel reports whether label can be attached to a build, e.g.
+// hotfix or release-2.1
+func ValidLabel(label string) bool {
+	return labelPattern.MatchString(label)
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// DebugImage is what a failed build left behind for a shell session: an
+// image of the last Dockerfile stage that built. It is not stored.
+type DebugImage struct {
+	Image     string    `json:"image"`
+	Stage     string    `json:"stage"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Available reports whether the image may still be debugged at now
+func (d *DebugImage) Available(now time.Time) bool {
+	return d != nil && now.Before(d.ExpiresAt)
+}
@@ -0,0 +1,28 @@
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrDeployLockNotFound = errors.New("deploy lock not found")
+
+// DeployLock serializes the deploys of a project's environment across
+// builds and replicas. It expires unless its holder renews it, so a
+// crashed replica blocks deploys for one lease at most.
+type DeployLock struct {
+	ProjectID   string    `json:"project_id"`
+	Environment string    `json:"environment"`
+	BuildID     string    `json:"build_id"`
+	Owner       string    `json:"owner"` // Instance deploying the build, hostname-pid
+	AcquiredAt  time.Time `json:"acquired_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Queued lists the builds of the instance answering that wait for the
+	// lock, first in line first
+	Queued []string `json:"queued,omitempty"`
+}
+
+// Expired reports whether the holder stopped renewing the lock
+func (l *DeployLock) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
@@ -0,0 +1,11 @@
+package types
+
+// Diagnosis is the probable cause of a failed build or deploy, recognized
+// from its output
+type Diagnosis struct {
+	Rule       string   `json:"rule"` // e.g. "out_of_memory"
+	Cause      string   `json:"cause"`
+	Suggestion string   `json:"suggestion"`
+	Line       string   `json:"line,omitempty"`    // Output line the cause was recognized by
+	Details    []string `json:"details,omitempty"` // Further evidence, e.g. the events of a pod failing to pull its image
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// ExternalDeployment is a deployment on a static hosting provider
+type ExternalDeployment struct {
+	Provider    string    `json:"provider"`           // "cloudflare" or "netlify"
+	Site        string    `json:"site"`               // Pages project or Netlify site
+	ID          string    `json:"id"`                 // Provider's deployment ID
+	Environment string    `json:"environment"`        // "production" or the preview branch
+	URL         string    `json:"url,omitempty"`      // Unset until the provider assigned one
+	Status      string    `json:"status"`             // Provider's status, e.g. "success" or "ready"
+	Previous    string    `json:"previous,omitempty"` // Production deployment it replaced, restored on rollback
+	Message     string    `json:"message,omitempty"`  // Provider's error when it failed
+	DeployedAt  time.Time `json:"deployed_at"`
+}
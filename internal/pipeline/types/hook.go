@@ -0,0 +1,104 @@
+package types
+
+import "time"
+
+type HookType string
+
+const (
+	HookTypeCommand HookType = "command" // Command run in the deployed image as a Kubernetes job, e.g. a cache purge
+	HookTypeHTTP    HookType = "http"    // HTTP request, e.g. a Slack webhook
+	HookTypeK8sJob  HookType = "k8s_job" // One-off Kubernetes job, e.g. database migrations
+)
+
+type FailurePolicy string
+
+const (
+	FailurePolicyIgnore   FailurePolicy = "ignore"
+	FailurePolicyWarn     FailurePolicy = "warn"
+	FailurePolicyRollback FailurePolicy = "rollback"
+)
+
+// Hook is a lifecycle command executed after a successful deploy
+type Hook struct {
+	Name           string            `json:"name"`
+	Type           HookType          `json:"type"`
+	Command        []string          `json:"command,omitempty"` // command and k8s_job hooks, run as a Kubernetes job
+	Image          string            `json:"image,omitempty"`   // k8s_job hooks
+	URL            string            `json:"url,omitempty"`     // http hooks, may contain env templates
+	Method         string            `json:"method,omitempty"`  // http hooks, defaults to POST
+	Body           string            `json:"body,omitempty"`    // http hooks, may contain env templates
+	Headers        map[string]string `json:"headers,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"`
+	FailurePolicy  FailurePolicy     `json:"failure_policy,omitempty"` // Defaults to warn
+}
+
+type DeploymentEventType string
+
+const (
+	EventHookStarted    DeploymentEventType = "hook_started"
+	EventHookSucceeded  DeploymentEventType = "hook_succeeded"
+	EventHookFailed     DeploymentEventType = "hook_failed"
+	EventRolledBack     DeploymentEventType = "rolled_back"
+	EventRestarted      DeploymentEventType = "restarted"
+	EventScaled         DeploymentEventType = "scaled"
+	EventPreviewReady   DeploymentEventType = "preview_ready"
+	EventPromoted       DeploymentEventType = "promoted"
+	EventPreviewRemoved DeploymentEventType = "preview_removed"
+	EventApproved       DeploymentEventType = "approved"
+	EventPluginFailed   DeploymentEventType = "plugin_failed"
+	EventDebugImageKept DeploymentEventType = "debug_image_kept"
+	EventPerfAudited    DeploymentEventType = "perf_audited"
+	EventAssetsVerified DeploymentEventType = "assets_verified"
+	EventAssetsMismatch DeploymentEventType = "assets_mismatch"
+	EventPreempted      DeploymentEventType = "preempted"
+	EventHostSynced     DeploymentEventType = "host_synced"      // Hook names the host
+	EventHostSyncFailed DeploymentEventType = "host_sync_failed" // Hook names the host
+	EventFilesSynced    DeploymentEventType = "files_synced"
+	EventSyncPlanned    DeploymentEventType = "sync_planned" // Dry run, the message lists the changes
+	EventAddOnReady     DeploymentEventType = "addon_ready"  // Hook names the add-on
+	EventJobTriggered   DeploymentEventType = "job_triggered"
+
+	EventReleased      DeploymentEventType = "released"       // The message names the tag or counts the commits
+	EventReleaseFailed DeploymentEventType = "release_failed" // The deploy stands, the release records why
+
+	EventSourceMapsUploaded     DeploymentEventType = "source_maps_uploaded"      // The message names the release
+	EventSourceMapsUploadFailed DeploymentEventType = "source_maps_upload_failed" // The build is deployed without them
+
+	// Rollout of the web deployment on kubernetes, when deploys wait for it
+	EventRolloutProgress DeploymentEventType = "rollout_progress" // The message counts the updated and ready replicas
+	EventPodScheduled    DeploymentEventType = "pod_scheduled"    // Hook names the pod, the message its node
+	EventPodProblem      DeploymentEventType = "pod_problem"      // Hook names the pod, e.g. an image pull error or crash loop
+	EventRolloutComplete DeploymentEventType = "rollout_complete"
+
+	EventMigrationSwitched   DeploymentEventType = "migration_switched"
+	EventMigrationFailed     DeploymentEventType = "migration_failed"
+	EventMigrationRolledBack DeploymentEventType = "migration_rolled_back"
+	EventMigrationCompleted  DeploymentEventType = "migration_completed"
+
+	EventPhaseTimedOut DeploymentEventType = "phase_timed_out" // Hook names the phase
+
+	EventDeployLockWaiting DeploymentEventType = "deploy_lock_waiting" // The message names the build holding the lock
+	EventDeployLockLost    DeploymentEventType = "deploy_lock_lost"    // The lock expired or was released by an admin mid-deploy
+
+	EventLaneWaiting DeploymentEventType = "lane_waiting" // The message names the build holding the branch on another instance
+
+	EventInterrupted DeploymentEventType = "interrupted" // The message names the instance that stopped
+	EventRequeued    DeploymentEventType = "requeued"    // The message names the new build, or why there is none
+)
+
+type DeploymentEvent struct {
+	Type      DeploymentEventType `json:"type"`
+	Hook      string              `json:"hook,omitempty"`
+	Message   string              `json:"message,omitempty"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+// AddEvent records a deployment event on the build
+func (b *Build) AddEvent(eventType DeploymentEventType, hook, message string) {
+	b.Events = append(b.Events, DeploymentEvent{
+		Type:      eventType,
+		Hook:      hook,
+		Message:   message,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+// Job is a scheduled command a project defines in chef.yaml. It runs in
+// the project's image with the same env vars and add-ons as the app.
+type Job struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`         // Cron expression in UTC, e.g. "0 3 * * *"
+	Command  string `json:"command"`          // Run with /bin/sh -c
+	CPU      string `json:"cpu,omitempty"`    // Kubernetes quantity, e.g. "250m"
+	Memory   string `json:"memory,omitempty"` // Kubernetes quantity, e.g. "256Mi"
+}
+
+type JobRunStatus string
+
+const (
+	JobRunPending   JobRunStatus = "pending"
+	JobRunRunning   JobRunStatus = "running"
+	JobRunSucceeded JobRunStatus = "succeeded"
+	JobRunFailed    JobRunStatus = "failed"
+)
+
+// JobRun is one execution of a job, scheduled or triggered by hand
+type JobRun struct {
+	ID         string       `json:"id"`
+	Job        string       `json:"job"`
+	Status     JobRunStatus `json:"status"`
+	Manual     bool         `json:"manual,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
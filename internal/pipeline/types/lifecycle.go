@@ -0,0 +1,37 @@
+package types
+
+// LifecycleEvent names a build or deploy transition reported to notifiers
+type LifecycleEvent string
+
+const (
+	LifecycleBuildStarted     LifecycleEvent = "build.started"
+	LifecycleBuildSucceeded   LifecycleEvent = "build.succeeded"
+	LifecycleBuildFailed      LifecycleEvent = "build.failed"
+	LifecycleBuildCancelled   LifecycleEvent = "build.cancelled"
+	LifecycleBuildSuperseded  LifecycleEvent = "build.superseded"
+	LifecycleBuildInterrupted LifecycleEvent = "build.interrupted"
+	LifecycleDeploySucceeded  LifecycleEvent = "deploy.succeeded"
+	LifecycleDeployFailed     LifecycleEvent = "deploy.failed"
+	LifecycleDeployRestarted  LifecycleEvent = "deploy.restarted"
+	LifecycleDeployScaled     LifecycleEvent = "deploy.scaled"
+	LifecycleDeployRolledBack LifecycleEvent = "deploy.rolled_back"
+	LifecyclePreviewReady     LifecycleEvent = "preview.ready"
+	LifecycleAuditRegressed   LifecycleEvent = "audit.regressed"
+)
+
+// LifecycleEvents lists every event notifiers may subscribe to
+var LifecycleEvents = []LifecycleEvent{
+	LifecycleBuildStarted,
+	LifecycleBuildSucceeded,
+	LifecycleBuildFailed,
+	LifecycleBuildCancelled,
+	LifecycleBuildSuperseded,
+	LifecycleBuildInterrupted,
+	LifecycleDeploySucceeded,
+	LifecycleDeployFailed,
+	LifecycleDeployRestarted,
+	LifecycleDeployScaled,
+	LifecycleDeployRolledBack,
+	LifecyclePreviewReady,
+	LifecycleAuditRegressed,
+}
@@ -0,0 +1,28 @@
+package types
+
+import "time"
+
+// LogQuery selects stored build events by full-text search on their
+// message
+type LogQuery struct {
+	ProjectID string
+	Query     string      // Web search syntax: words, "phrases" and -exclusions
+	From      time.Time   // Zero leaves the range open
+	To        time.Time   // Zero leaves the range open
+	Status    BuildStatus // Current status of the build, empty for any
+}
+
+// LogMatch is a build event matching a LogQuery
+type LogMatch struct {
+	BuildID     string
+	BuildStatus BuildStatus
+	Event       DeploymentEvent
+	Snippet     string // Fragments of the message around the matches
+	Highlights  []Highlight
+}
+
+// Highlight is a matched range of a snippet in byte offsets, End exclusive
+type Highlight struct {
+	Start int
+	End   int
+}
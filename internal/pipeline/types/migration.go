@@ -0,0 +1,43 @@
+package types
+
+import "time"
+
+type MigrationStatus string
+
+const (
+	MigrationDeploying  MigrationStatus = "deploying"
+	MigrationVerifying  MigrationStatus = "verifying"
+	MigrationSwitched   MigrationStatus = "switched"  // Traffic moved, the old target kept for rollback
+	MigrationCompleted  MigrationStatus = "completed" // The old target was removed after the rollback window
+	MigrationRolledBack MigrationStatus = "rolled_back"
+	MigrationFailed     MigrationStatus = "failed" // Traffic never moved
+)
+
+// Migration moves a project's environment from one deploy target to
+// another. Targets are named as in deploy.targets, empty for the default.
+type Migration struct {
+	ProjectID     string          `json:"project_id"`
+	Environment   string          `json:"environment"`
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	BuildID       string          `json:"build_id"`
+	Status        MigrationStatus `json:"status"`
+	Message       string          `json:"message,omitempty"`
+	StartedAt     time.Time       `json:"started_at"`
+	SwitchedAt    *time.Time      `json:"switched_at,omitempty"`
+	RollbackUntil *time.Time      `json:"rollback_until,omitempty"` // The old target is removed afterwards
+	FinishedAt    *time.Time      `json:"finished_at,omitempty"`
+}
+
+// InProgress reports whether the new target is still being prepared
+func (m *Migration) InProgress() bool {
+	return m.Status == MigrationDeploying || m.Status == MigrationVerifying
+}
+
+// Serving returns the target receiving the environment's traffic
+func (m *Migration) Serving() string {
+	if m.Status == MigrationSwitched || m.Status == MigrationCompleted {
+		return m.To
+	}
+	return m.From
+}
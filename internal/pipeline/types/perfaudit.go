@@ -0,0 +1,12 @@
+package types
+
+import "time"
+
+// PerfAudit is a Lighthouse audit of a deployment
+type PerfAudit struct {
+	URL         string             `json:"url"`
+	Scores      map[string]int     `json:"scores"`                // 0 to 100 per category, e.g. "performance"
+	Metrics     map[string]float64 `json:"metrics,omitempty"`     // e.g. "largest-contentful-paint" in milliseconds
+	Regressions []string           `json:"regressions,omitempty"` // e.g. "performance 92 -> 71" beyond the threshold
+	AuditedAt   time.Time          `json:"audited_at"`
+}
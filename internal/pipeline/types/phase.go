@@ -0,0 +1,100 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Phase is a step of a build with its own timeout
+type Phase string
+
+const (
+	PhaseClone       Phase = "clone"        // Copying the sources into the build directory
+	PhaseInstall     Phase = "install"      // Installing dependencies
+	PhaseBuild       Phase = "build"        // Running the tests and the build command
+	PhasePackage     Phase = "package"      // Building the image and the artifact
+	PhaseDeploy      Phase = "deploy"       // Rolling the build out
+	PhaseHealthCheck Phase = "health_check" // Post-deploy hooks and the asset check
+)
+
+// PhaseTimeouts are the seconds each phase may take, 0 for no limit
+type PhaseTimeouts struct {
+	Clone       int `json:"clone,omitempty"`
+	Install     int `json:"install,omitempty"`
+	Build       int `json:"build,omitempty"`
+	Package     int `json:"package,omitempty"`
+	Deploy      int `json:"deploy,omitempty"`
+	HealthCheck int `json:"health_check,omitempty"`
+}
+
+// Of returns the timeout of phase, 0 for no limit
+func (t PhaseTimeouts) Of(phase Phase) time.Duration {
+	var seconds int
+	switch phase {
+	case PhaseClone:
+		seconds = t.Clone
+	case PhaseInstall:
+		seconds = t.Install
+	case PhaseBuild:
+		seconds = t.Build
+	case PhasePackage:
+		seconds = t.Package
+	case PhaseDeploy:
+		seconds = t.Deploy
+	case PhaseHealthCheck:
+		seconds = t.HealthCheck
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Override returns t with the phases set in overrides replaced
+func (t PhaseTimeouts) Override(overrides PhaseTimeouts) PhaseTimeouts {
+	pick := func(value, override int) int {
+		if override > 0 {
+			return override
+		}
+		return value
+	}
+	return PhaseTimeouts{
+		Clone:       pick(t.Clone, overrides.Clone),
+		Install:     pick(t.Install, overrides.Install),
+		Build:       pick(t.Build, overrides.Build),
+		Package:     pick(t.Package, overrides.Package),
+		Deploy:      pick(t.Deploy, overrides.Deploy),
+		HealthCheck: pick(t.HealthCheck, overrides.HealthCheck),
+	}
+}
+
+// PhaseTimeoutError reports a phase that ran out of time
+type PhaseTimeoutError struct {
+	Phase   Phase
+	Timeout time.Duration
+	Err     error // What the phase failed with when its context expired
+}
+
+func (e *PhaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s phase timed out after %s: %v", e.Phase, e.Timeout, e.Err)
+}
+
+func (e *PhaseTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// RunPhase runs fn with ctx bounded by the phase's timeout and records its
+// time under the phase's stage. A failure after the timeout passed is
+// returned as a PhaseTimeoutError; cancellation of ctx itself is not.
+func (t PhaseTimeouts) RunPhase(ctx context.Context, phase Phase, fn func(ctx context.Context) error) error {
+	timeout := t.Of(phase)
+	if timeout <= 0 {
+		return TimeStage(ctx, phase.Stage(), func() error { return fn(ctx) })
+	}
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := TimeStage(ctx, phase.Stage(), func() error { return fn(phaseCtx) })
+	if err != nil && errors.Is(phaseCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return &PhaseTimeoutError{Phase: phase, Timeout: timeout, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SupportedPlatforms lists the target platforms builds may request
+var SupportedPlatforms = map[string]bool{
+	"linux/amd64": true,
+	"linux/arm64": true,
+}
+
+// ValidatePlatforms checks that every requested platform is supported
+func ValidatePlatforms(platforms []string) error {
+	for _, platform := range platforms {
+		if !SupportedPlatforms[platform] {
+			return fmt.Errorf("unsupported target platform: %s", platform)
+		}
+	}
+	return nil
+}
+
+// PlatformArchitectures returns the CPU architectures of the given platforms,
+// e.g. "linux/arm64" -> "arm64".
+func PlatformArchitectures(platforms []string) []string {
+	archs := make([]string, 0, len(platforms))
+	for _, platform := range platforms {
+		parts := strings.Split(platform, "/")
+		archs = append(archs, parts[len(parts)-1])
+	}
+	return archs
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// PolicyResult is the outcome of one deploy policy rule
+type PolicyResult struct {
+	Rule    string `json:"rule"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// Approval records a user approving a build for deployment
+type Approval struct {
+	User string    `json:"user"`
+	Time time.Time `json:"time"`
+}
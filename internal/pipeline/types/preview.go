@@ -0,0 +1,29 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Preview is a temporary deployment of a build under its own URL, made
+// before the build is promoted to the project's environment
+type Preview struct {
+	Name       string     `json:"name"` // Deployment name used in place of the project ID
+	URL        string     `json:"url"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	PromotedAt *time.Time `json:"promoted_at,omitempty"`
+	RemovedAt  *time.Time `json:"removed_at,omitempty"` // Set once the preview was torn down
+}
+
+// Active reports whether the preview is still deployed
+func (p *Preview) Active() bool {
+	return p != nil && p.RemovedAt == nil
+}
+
+// PreviewName derives the deployment name of a build's preview. It is a
+// valid DNS label however long the project and build IDs are.
+func PreviewName(projectID, buildID string) string {
+	sum := sha256.Sum256([]byte(projectID + "/" + buildID))
+	return "preview-" + hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,24 @@
+package types
+
+// WebProcess is the process serving a project's HTTP traffic. Every
+// deployed project has one; chef.yaml declares the others.
+const WebProcess = "web"
+
+// Process is a long-running command a project runs besides its web
+// server, e.g. a queue worker. It runs in the project's image without a
+// Service or Ingress.
+type Process struct {
+	Name     string            `json:"name"`
+	Command  string            `json:"command"` // Run with /bin/sh -c
+	Replicas int32             `json:"replicas"`
+	Env      map[string]string `json:"env,omitempty"` // Added to the build's env vars, may contain deploy-time templates
+}
+
+// ProcessStatus is the state of one of a project's processes
+type ProcessStatus struct {
+	Name          string `json:"name"`
+	Command       string `json:"command,omitempty"` // Empty for the web process, which runs the image's default
+	Image         string `json:"image"`
+	Replicas      int32  `json:"replicas"` // Desired
+	ReadyReplicas int32  `json:"ready_replicas"`
+}
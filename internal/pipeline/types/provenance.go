@@ -0,0 +1,16 @@
+package types
+
+// Provenance references the SLSA provenance written for a build and the
+// signatures made over it
+type Provenance struct {
+	Path          string `json:"path"`                     // in-toto statement next to the artifact
+	Digest        string `json:"digest"`                   // sha256 of the statement
+	InputsDigest  string `json:"inputs_digest"`            // sha256 over the source tree
+	SignaturePath string `json:"signature_path,omitempty"` // cosign signature of the statement
+	SignedImage   string `json:"signed_image,omitempty"`   // Signed and attested in the registry
+}
+
+// Signed reports whether the provenance was signed
+func (p *Provenance) Signed() bool {
+	return p != nil && p.SignaturePath != ""
+}
@@ -0,0 +1,23 @@
+package types
+
+import "time"
+
+// Release records what a deploy shipped since the previous deploy of its
+// environment
+type Release struct {
+	Commit    string           `json:"commit"`
+	Previous  string           `json:"previous,omitempty"`  // Commit of the previous release, empty for the first
+	Changelog []ChangelogEntry `json:"changelog,omitempty"` // Oldest first
+	Commits   int              `json:"commits,omitempty"`   // Commits since the previous release, may exceed the changelog
+	Tag       string           `json:"tag,omitempty"`       // Set once tagged at the git provider
+	URL       string           `json:"url,omitempty"`       // Provider page of the release
+	Error     string           `json:"error,omitempty"`     // Why the changelog or the tag is missing
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// ChangelogEntry is a commit of a release
+type ChangelogEntry struct {
+	Commit  string `json:"commit"`
+	Author  string `json:"author,omitempty"`
+	Subject string `json:"subject"`
+}
@@ -0,0 +1,24 @@
+package types
+
+// SourceMaps is what a build does with the source maps of its bundle
+type SourceMaps string
+
+const (
+	SourceMapsKeep   SourceMaps = "keep"   // Deployed next to the bundle
+	SourceMapsStrip  SourceMaps = "strip"  // Removed from the artifact and image
+	SourceMapsUpload SourceMaps = "upload" // Uploaded to the error tracker, then removed
+)
+
+// ValidSourceMaps reports whether s is a known policy, empty meaning keep
+func ValidSourceMaps(s SourceMaps) bool {
+	switch s {
+	case "", SourceMapsKeep, SourceMapsStrip, SourceMapsUpload:
+		return true
+	}
+	return false
+}
+
+// Removed reports whether the policy keeps source maps out of the artifact
+func (s SourceMaps) Removed() bool {
+	return s == SourceMapsStrip || s == SourceMapsUpload
+}
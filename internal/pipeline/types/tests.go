@@ -0,0 +1,109 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MaxTestFailures bounds the failed tests kept per build
+const MaxTestFailures = 50
+
+// TestResults summarizes the reports written by a build's test command
+type TestResults struct {
+	Total    int           `json:"total"`
+	Passed   int           `json:"passed"`
+	Failed   int           `json:"failed"` // Failures and errors
+	Skipped  int           `json:"skipped"`
+	ExitCode int           `json:"exit_code"`          // Of the test command
+	Failures []TestFailure `json:"failures,omitempty"` // The first MaxTestFailures
+}
+
+// TestFailure is a failed test case
+type TestFailure struct {
+	Suite   string `json:"suite,omitempty"` // Test file or suite name
+	Name    string `json:"name"`
+	Message string `json:"message,omitempty"`
+}
+
+// Add merges the counts and failures of another report
+func (r *TestResults) Add(other *TestResults) {
+	r.Total += other.Total
+	r.Passed += other.Passed
+	r.Failed += other.Failed
+	r.Skipped += other.Skipped
+	for _, failure := range other.Failures {
+		if len(r.Failures) >= MaxTestFailures {
+			break
+		}
+		r.Failures = append(r.Failures, failure)
+	}
+}
+
+// Succeeded reports whether the test command passed without failed tests
+func (r *TestResults) Succeeded() bool {
+	return r.ExitCode == 0 && r.Failed == 0
+}
+
+func (r *TestResults) String() string {
+	if r.Failed == 0 && r.ExitCode != 0 {
+		return fmt.Sprintf("test command exited with code %d", r.ExitCode)
+	}
+	return fmt.Sprintf("%d of %d tests failed", r.Failed, r.Total)
+}
+
+// MaxCoveragePackages bounds the packages kept per build
+const MaxCoveragePackages = 200
+
+// Coverage counts the instrumented and covered lines of a build's tests,
+// in total and per package (directory)
+type Coverage struct {
+	Lines    int               `json:"lines"`
+	Covered  int               `json:"covered"`
+	Packages []PackageCoverage `json:"packages,omitempty"` // Sorted by name
+}
+
+type PackageCoverage struct {
+	Name    string `json:"name"`
+	Lines   int    `json:"lines"`
+	Covered int    `json:"covered"`
+}
+
+// Percent is the share of covered lines, 0 without instrumented lines
+func (c *Coverage) Percent() float64 {
+	return percent(c.Covered, c.Lines)
+}
+
+func (p PackageCoverage) Percent() float64 {
+	return percent(p.Covered, p.Lines)
+}
+
+func percent(covered, lines int) float64 {
+	if lines == 0 {
+		return 0
+	}
+	return float64(covered) * 100 / float64(lines)
+}
+
+// Add merges another report, summing packages of the same name
+func (c *Coverage) Add(other *Coverage) {
+	c.Lines += other.Lines
+	c.Covered += other.Covered
+
+	index := make(map[string]int, len(c.Packages))
+	for i, pkg := range c.Packages {
+		index[pkg.Name] = i
+	}
+	for _, pkg := range other.Packages {
+		if i, ok := index[pkg.Name]; ok {
+			c.Packages[i].Lines += pkg.Lines
+			c.Packages[i].Covered += pkg.Covered
+			continue
+		}
+		index[pkg.Name] = len(c.Packages)
+		c.Packages = append(c.Packages, pkg)
+	}
+	sort.Slice(c.Packages, func(i, j int) bool { return c.Packages[i].Name < c.Packages[j].Name })
+	if len(c.Packages) > MaxCoveragePackages {
+		c.Packages = c.Packages[:MaxCoveragePackages]
+	}
+}
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Stage is a part of a build whose wall-clock time is recorded on it
+type Stage string
+
+const (
+	StageFetch   Stage = "fetch"   // Getting the sources into the build directory
+	StageInstall Stage = "install" // Installing dependencies
+	StageBuild   Stage = "build"   // Running the tests and the build command
+	StagePackage Stage = "package" // Building the image and the artifact
+	StageScan    Stage = "scan"    // Validating, attesting and checking the artifact before it deploys
+	StageDeploy  Stage = "deploy"  // Rolling the build out
+	StageVerify  Stage = "verify"  // Post-deploy hooks, the asset check and plugins
+)
+
+// Stages are the stages in the order builds run them
+var Stages = []Stage{StageFetch, StageInstall, StageBuild, StagePackage, StageScan, StageDeploy, StageVerify}
+
+// Stage returns the stage the phase's time is recorded under
+func (p Phase) Stage() Stage {
+	switch p {
+	case PhaseClone:
+		return StageFetch
+	case PhaseHealthCheck:
+		return StageVerify
+	}
+	return Stage(p)
+}
+
+// StageTiming is the wall-clock time a build spent in a stage. A stage
+// entered more than once adds up its durations.
+type StageTiming struct {
+	Stage     Stage         `json:"stage"`
+	StartedAt time.Time     `json:"started_at"` // When the stage was first entered
+	Duration  time.Duration `json:"duration"`
+}
+
+// StageTimer collects the stage timings of a build; it is safe for
+// concurrent use
+type StageTimer struct {
+	mu     sync.Mutex
+	stages []StageTiming
+}
+
+// Record adds the time spent in stage
+func (t *StageTimer) Record(stage Stage, startedAt time.Time, duration time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.stages {
+		if t.stages[i].Stage != stage {
+			continue
+		}
+		if startedAt.Before(t.stages[i].StartedAt) {
+			t.stages[i].StartedAt = startedAt
+		}
+		t.stages[i].Duration += duration
+		return
+	}
+	t.stages = append(t.stages, StageTiming{Stage: stage, StartedAt: startedAt, Duration: duration})
+}
+
+// Stages returns the recorded timings in the order the stages were entered
+func (t *StageTimer) Stages() []StageTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]StageTiming(nil), t.stages...)
+}
+
+type stageTimerKey struct{}
+
+// WithStageTimer returns a context whose stages are recorded on t
+func WithStageTimer(ctx context.Context, t *StageTimer) context.Context {
+	return context.WithValue(ctx, stageTimerKey{}, t)
+}
+
+// TimeStage runs fn and records its wall-clock time under stage on the
+// timer of ctx, if it has one. Failed attempts count too.
+func TimeStage(ctx context.Context, stage Stage, fn func() error) error {
+	t, _ := ctx.Value(stageTimerKey{}).(*StageTimer)
+	if t == nil {
+		return fn()
+	}
+	start := time.Now()
+	err := fn()
+	t.Record(stage, start, time.Since(start))
+	return err
+}
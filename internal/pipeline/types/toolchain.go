@@ -0,0 +1,20 @@
+package types
+
+import "github.com/elskow/chef-infra/internal/version"
+
+// Toolchain records the tools a build ran with so it can be reproduced.
+// Values that could not be determined are empty.
+type Toolchain struct {
+	NodeImage       string `json:"node_image"`                 // With digest, e.g. node:20-alpine@sha256:...
+	NodeVersion     string `json:"node_version,omitempty"`     // e.g. 20.11.1
+	NPMVersion      string `json:"npm_version,omitempty"`      // e.g. 10.2.4
+	RuntimeImage    string `json:"runtime_image"`              // With digest, e.g. nginx:alpine@sha256:...
+	BuilderVersion  string `json:"builder_version"`            // chef-infra version of the server or agent that built it
+	DockerVersion   string `json:"docker_version,omitempty"`   // Of the daemon
+	BuildKitVersion string `json:"buildkit_version,omitempty"` // Empty when the classic builder ran the build
+}
+
+// BuilderVersion is the version and commit of the running binary
+func BuilderVersion() string {
+	return version.Get().String()
+}
@@ -2,9 +2,16 @@ package types
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 )
 
+var (
+	ErrBuildNotFound = errors.New("build not found")
+	ErrBuildChanged  = errors.New("build changed since it was read")
+)
+
 type BuildStatus string
 
 const (
@@ -13,28 +20,235 @@ const (
 	BuildStatusSuccess   BuildStatus = "success"
 	BuildStatusFailed    BuildStatus = "failed"
 	BuildStatusCancelled BuildStatus = "cancelled"
+	// A newer push to the same branch replaced the build
+	BuildStatusSuperseded BuildStatus = "superseded"
+	// The server running the build stopped before it finished
+	BuildStatusInterrupted BuildStatus = "interrupted"
+)
+
+// DedupPolicy decides what happens to earlier builds of a branch when a
+// new push arrives
+type DedupPolicy string
+
+const (
+	DedupQueue     DedupPolicy = "queue"     // Build every push, one at a time
+	DedupSupersede DedupPolicy = "supersede" // Only build the latest push
+)
+
+// ValidDedupPolicy reports whether policy is known; empty selects the
+// pipeline default
+func ValidDedupPolicy(policy DedupPolicy) bool {
+	switch policy {
+	case "", DedupQueue, DedupSupersede:
+		return true
+	}
+	return false
+}
+
+// BuildPriority orders builds waiting for a build slot
+type BuildPriority string
+
+const (
+	PriorityHigh   BuildPriority = "high"   // e.g. production deploys
+	PriorityNormal BuildPriority = "normal" // e.g. branch previews
+	PriorityLow    BuildPriority = "low"    // e.g. scheduled rebuilds
 )
 
+// ValidBuildPriority reports whether priority is known; empty selects the
+// default of the build's environment
+func ValidBuildPriority(priority BuildPriority) bool {
+	switch priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return true
+	}
+	return false
+}
+
+// Rank orders priorities, higher first
+func (p BuildPriority) Rank() int {
+	switch p {
+	case PriorityHigh:
+		return 2
+	case PriorityLow:
+		return 0
+	}
+	return 1
+}
+
 type Build struct {
-	ID            string                 `json:"id"`
-	ProjectID     string                 `json:"project_id"`
-	CommitHash    string                 `json:"commit_hash"`
-	Status        BuildStatus            `json:"status"`
-	ImageID       string                 `json:"image_id,omitempty"`
-	BuilderConfig map[string]interface{} `json:"builder_config"`
-	Framework     string                 `json:"framework"`
-	BuildCommand  string                 `json:"build_command"`
-	OutputDir     string                 `json:"output_dir"`
-	ErrorMessage  string                 `json:"error_message,omitempty"`
-	StartTime     time.Time              `json:"start_time"`
-	CompleteTime  *time.Time             `json:"complete_time,omitempty"`
-	ArtifactPath  string                 `json:"artifact_path,omitempty"`
-	CancelFunc    context.CancelFunc     `json:"-"` // Internal use only`
+	ID              string                 `json:"id"`
+	ProjectID       string                 `json:"project_id"`
+	CommitHash      string                 `json:"commit_hash"`
+	Source          *BuildSource           `json:"source,omitempty"` // Set when triggered by a git provider
+	Commit          *CommitInfo            `json:"commit,omitempty"`
+	Dedup           DedupPolicy            `json:"dedup,omitempty"` // Applies to builds with a source ref
+	Priority        BuildPriority          `json:"priority,omitempty"`
+	Requirements    map[string]string      `json:"requirements,omitempty"` // Labels of the build agents it may run on, e.g. arch=arm64
+	Status          BuildStatus            `json:"status"`
+	Deploying       bool                   `json:"deploying,omitempty"` // Set while a successful build is being deployed
+	ImageID         string                 `json:"image_id,omitempty"`
+	BuilderConfig   map[string]interface{} `json:"builder_config"`
+	Framework       string                 `json:"framework"`
+	BuildCommand    string                 `json:"build_command"`
+	OutputDir       string                 `json:"output_dir"`
+	Platforms       []string               `json:"platforms,omitempty"`
+	NodeVersion     string                 `json:"node_version,omitempty"`
+	Warnings        []string               `json:"warnings,omitempty"`
+	Environment     string                 `json:"environment,omitempty"`
+	EnvVars         map[string]string      `json:"env_vars,omitempty"`     // May contain deploy-time templates
+	BuildEnv        []string               `json:"build_env,omitempty"`    // Names of the variables inlined at build time
+	AddOns          []string               `json:"addons,omitempty"`       // Managed services requested in chef.yaml
+	Jobs            []Job                  `json:"jobs,omitempty"`         // Scheduled jobs defined in chef.yaml
+	Processes       []Process              `json:"processes,omitempty"`    // Processes besides web declared in chef.yaml
+	Timeouts        PhaseTimeouts          `json:"timeouts"`               // Phase timeouts set in chef.yaml
+	PreviewOnly     bool                   `json:"preview_only,omitempty"` // Deploy to a preview URL and wait for promotion
+	Preview         *Preview               `json:"preview,omitempty"`
+	Debug           *DebugImage            `json:"debug,omitempty"`  // Kept from a failed build when debugging is enabled
+	Pinned          bool                   `json:"pinned,omitempty"` // Kept by cleanup, e.g. the last known-good release
+	Note            string                 `json:"note,omitempty"`   // Set by users, e.g. "hotfix for checkout bug"
+	Labels          []string               `json:"labels,omitempty"` // Set by users, e.g. hotfix
+	Provenance      *Provenance            `json:"provenance,omitempty"`
+	BaseImages      []string               `json:"base_images,omitempty"`
+	ImageSize       int64                  `json:"image_size,omitempty"`
+	Toolchain       *Toolchain             `json:"toolchain,omitempty"`       // Set once the build succeeded
+	BuilderVersion  string                 `json:"builder_version,omitempty"` // chef-infra version and commit of the server that ran it
+	ArtifactDigest  string                 `json:"artifact_digest,omitempty"` // sha256 over the artifact's files, ignoring archive metadata
+	Vulnerabilities map[string]int         `json:"vulnerabilities,omitempty"` // Findings by severity, nil until scanned
+	TestResults     *TestResults           `json:"test_results,omitempty"`    // Nil when the project runs no tests
+	Coverage        *Coverage              `json:"coverage,omitempty"`        // Nil without coverage reports
+	PerfAudit       *PerfAudit             `json:"perf_audit,omitempty"`      // Set once the deployment was audited
+	Release         *Release               `json:"release,omitempty"`         // Set once deployed to an environment with releases
+	External        *ExternalDeployment    `json:"external,omitempty"`        // Set when deployed to a hosting provider
+	RolledBackTo    string                 `json:"rolled_back_to,omitempty"`  // Build restored when its deploy was rolled back, if known
+	Approvals       []Approval             `json:"approvals,omitempty"`
+	PolicyResults   []PolicyResult         `json:"policy_results,omitempty"` // Rules evaluated before the last deploy
+	Hooks           []Hook                 `json:"hooks,omitempty"`          // Post-deploy hooks
+	Events          []DeploymentEvent      `json:"events,omitempty"`
+	ErrorMessage    string                 `json:"error_message,omitempty"`
+	Diagnosis       *Diagnosis             `json:"diagnosis,omitempty"` // Probable cause of a failure, when recognized
+	Stages          []StageTiming          `json:"stages,omitempty"`    // Time spent in each stage, in the order entered
+	StartTime       time.Time              `json:"start_time"`
+	CompleteTime    *time.Time             `json:"complete_time,omitempty"`
+	ArtifactPath    string                 `json:"artifact_path,omitempty"`
+	Instance        string                 `json:"instance,omitempty"` // Server instance running the build
+	Input           *BuildInput            `json:"-"`                  // What the build was started with, to requeue it
+	UpdatedAt       time.Time              `json:"-"`                  // Last write or heartbeat of the stored build
+	CancelFunc      context.CancelFunc     `json:"-"`                  // Internal use only`
+}
+
+// BuildInput is what a build was started with besides what its record
+// keeps, so an interrupted build can be started again. The values of env
+// vars may be sensitive and are not kept, only their names.
+type BuildInput struct {
+	SourceDir    string            `json:"source_dir"` // The project's checkout, relative to the source root
+	BuildCommand string            `json:"build_command,omitempty"`
+	OutputDir    string            `json:"output_dir,omitempty"`
+	NodeVersion  string            `json:"node_version,omitempty"`
+	Platforms    []string          `json:"platforms,omitempty"`
+	Source       *BuildSource      `json:"source,omitempty"`
+	Dedup        DedupPolicy       `json:"dedup,omitempty"`
+	Priority     BuildPriority     `json:"priority,omitempty"`
+	Requirements map[string]string `json:"requirements,omitempty"`
+	PreviewOnly  bool              `json:"preview_only,omitempty"`
+	Hooks        []Hook            `json:"hooks,omitempty"`
+	EnvVars      []string          `json:"env_vars,omitempty"`
+}
+
+// Unfinished reports whether the build is still to be built or deployed
+func (b *Build) Unfinished() bool {
+	return b.Status == BuildStatusPending || b.Status == BuildStatusBuilding || b.Deploying
+}
+
+// BuildSource describes the git provider event that triggered a build
+type BuildSource struct {
+	Provider    string `json:"provider"`   // e.g. "github"
+	Repository  string `json:"repository"` // owner/name
+	Ref         string `json:"ref,omitempty"`
+	PullRequest int    `json:"pull_request,omitempty"`
+}
+
+// CommitInfo describes the commit a build was made from, taken from the
+// provider's push payload or from the checkout
+type CommitInfo struct {
+	Author      string `json:"author,omitempty"`
+	AuthorEmail string `json:"author_email,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+}
+
+const maxCommitSubject = 72
+
+// Subject returns the first line of the commit message, truncated for
+// display
+func (c *CommitInfo) Subject() string {
+	if c == nil {
+		return ""
+	}
+	subject, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+	subject = strings.TrimSpace(subject)
+	if runes := []rune(subject); len(runes) > maxCommitSubject {
+		subject = string(runes[:maxCommitSubject-3]) + "..."
+	}
+	return subject
+}
+
+// ShortHash abbreviates the build's commit hash
+func (b *Build) ShortHash() string {
+	if len(b.CommitHash) > 7 {
+		return b.CommitHash[:7]
+	}
+	return b.CommitHash
+}
+
+// Describe summarizes what the build deploys, e.g.
+// "a1b2c3d on main by Jane: Fix checkout", falling back to the build ID
+// when nothing is known about the commit
+func (b *Build) Describe() string {
+	var parts []string
+	if hash := b.ShortHash(); hash != "" {
+		parts = append(parts, hash)
+	}
+	if c := b.Commit; c != nil {
+		switch {
+		case c.Tag != "":
+			parts = append(parts, "tag "+c.Tag)
+		case c.Branch != "":
+			parts = append(parts, "on "+c.Branch)
+		}
+		if c.Author != "" {
+			parts = append(parts, "by "+c.Author)
+		}
+	}
+	if len(parts) == 0 {
+		return "build " + b.ID
+	}
+
+	summary := strings.Join(parts, " ")
+	if subject := b.Commit.Subject(); subject != "" {
+		summary += ": " + subject
+	}
+	return summary
 }
 
 type BuildResult struct {
 	Success      bool
 	ArtifactPath string
 	ImageID      string
-	Error        error
+	BaseImages   []string     // Images the Dockerfile builds FROM
+	ImageSize    int64        // Bytes, 0 when unknown
+	TestResults  *TestResults // Nil when the project runs no tests
+	Coverage     *Coverage    // Nil without coverage reports
+	Toolchain    *Toolchain
+	AddOns       []string  // Managed services requested in chef.yaml
+	Jobs         []Job     // Scheduled jobs defined in chef.yaml
+	Processes    []Process // Processes besides web declared in chef.yaml
+	Hooks        []Hook    // Post-deploy hooks defined in chef.yaml
+	SourceMaps   SourceMaps
+	// SourceMapsPath is a tar of the source maps removed from the artifact,
+	// set when they are to be uploaded
+	SourceMapsPath string
+	Timeouts       PhaseTimeouts // Phase timeouts set in chef.yaml
+	Stages         []StageTiming // Timed by builders running on another host
+	Error          error
 }
@@ -0,0 +1,33 @@
+package types
+
+import "context"
+
+// BuildUpdater applies change to build while holding the lock readers of
+// the build hold
+type BuildUpdater func(build *Build, change func(*Build))
+
+type buildUpdaterKey struct{}
+
+// WithBuildUpdater returns a context whose builds are changed through
+// update
+func WithBuildUpdater(ctx context.Context, update BuildUpdater) context.Context {
+	return context.WithValue(ctx, buildUpdaterKey{}, update)
+}
+
+// UpdateBuild applies change to build through the updater of ctx. Without
+// one the build is not shared and is changed directly.
+func UpdateBuild(ctx context.Context, build *Build, change func(*Build)) {
+	if update, _ := ctx.Value(buildUpdaterKey{}).(BuildUpdater); update != nil {
+		update(build, change)
+		return
+	}
+	change(build)
+}
+
+// RecordEvent records a deployment event on the build through the updater
+// of ctx
+func RecordEvent(ctx context.Context, build *Build, eventType DeploymentEventType, hook, message string) {
+	UpdateBuild(ctx, build, func(b *Build) {
+		b.AddEvent(eventType, hook, message)
+	})
+}
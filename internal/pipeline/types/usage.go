@@ -0,0 +1,12 @@
+package types
+
+import "time"
+
+// Usage is what a project consumed on one UTC day
+type Usage struct {
+	ProjectID      string
+	Day            time.Time
+	BuildSeconds   int64 // Duration of builds that finished on the day
+	ReplicaSeconds int64 // Running replicas integrated over the day, previews included
+	ArtifactBytes  int64 // Peak size of the project's stored artifacts
+}
@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// File changes between an artifact and its rebuild
+const (
+	FileAdded    = "added"
+	FileRemoved  = "removed"
+	FileModified = "modified"
+)
+
+// Verification is the result of rebuilding a past build from its recorded
+// inputs and comparing the artifacts
+type Verification struct {
+	BuildID        string       `json:"build_id"`
+	Reproducible   bool         `json:"reproducible"`
+	InputsVerified bool         `json:"inputs_verified"` // The sources matched the provenance's inputs digest
+	ArtifactDigest string       `json:"artifact_digest"`
+	RebuildDigest  string       `json:"rebuild_digest"`
+	Changes        []FileChange `json:"changes,omitempty"`      // Nil when the original's file list was cleaned up
+	ChangeCount    int          `json:"change_count,omitempty"` // Changes may be truncated
+	VerifiedAt     time.Time    `json:"verified_at"`
+}
+
+// FileChange is a file that differs between an artifact and its rebuild
+type FileChange struct {
+	Path     string `json:"path"`
+	Change   string `json:"change"`             // FileAdded, FileRemoved or FileModified
+	Original string `json:"original,omitempty"` // sha256, empty when added
+	Rebuilt  string `json:"rebuilt,omitempty"`  // sha256, empty when removed
+}
@@ -0,0 +1,126 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/pipeline/deployer"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/pipeline/usage"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+const defaultUsageRange = 30 * 24 * time.Hour
+
+// Replicas returns the running replicas of each project on platforms that
+// run workloads. Previews of this process's builds count toward their
+// project.
+func (p *Pipeline) Replicas(ctx context.Context) (map[string]int32, error) {
+	counter, ok := p.deployer.(deployer.ReplicaCounter)
+	if !ok {
+		return nil, nil
+	}
+	running, err := counter.Replicas(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	previews := make(map[string]string)
+	for _, build := range p.builds {
+		if build.Preview != nil {
+			previews[build.Preview.Name] = build.ProjectID
+		}
+	}
+	p.mu.RUnlock()
+
+	replicas := make(map[string]int32, len(running))
+	for name, count := range running {
+		if projectID, ok := previews[name]; ok {
+			name = projectID
+		}
+		replicas[name] += count
+	}
+	return replicas, nil
+}
+
+func (h *Handler) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	if err := h.authorizeProject(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+	records, err := h.listUsage(ctx, req.ProjectId, req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.GetUsageResponse{Total: &pb.UsageRecord{ProjectId: req.ProjectId}}
+	for _, record := range records {
+		resp.Days = append(resp.Days, usageToProto(record))
+		resp.Total.BuildSeconds += record.BuildSeconds
+		resp.Total.ReplicaSeconds += record.ReplicaSeconds
+		resp.Total.ArtifactBytes = max(resp.Total.ArtifactBytes, record.ArtifactBytes)
+	}
+	return resp, nil
+}
+
+// ExportUsage returns usage as CSV for billing. Admins only, see
+// api.AdminEndpoints.
+func (h *Handler) ExportUsage(ctx context.Context, req *pb.ExportUsageRequest) (*pb.ExportUsageResponse, error) {
+	records, err := h.listUsage(ctx, req.ProjectId, req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := usage.WriteCSV(&buf, records); err != nil {
+		h.log.Error("failed to encode usage", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to export usage")
+	}
+	return &pb.ExportUsageResponse{Csv: buf.Bytes()}, nil
+}
+
+func (h *Handler) listUsage(ctx context.Context, projectID, startDate, endDate string) ([]types.Usage, error) {
+	if h.usage == nil || !h.usage.Enabled() {
+		return nil, status.Error(codes.FailedPrecondition, "usage metering is disabled")
+	}
+
+	to := time.Now()
+	if endDate != "" {
+		var err error
+		if to, err = time.Parse(usage.DayLayout, endDate); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "end_date must be formatted YYYY-MM-DD")
+		}
+	}
+	from := to.Add(-defaultUsageRange)
+	if startDate != "" {
+		var err error
+		if from, err = time.Parse(usage.DayLayout, startDate); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "start_date must be formatted YYYY-MM-DD")
+		}
+	}
+	if from.After(to) {
+		return nil, status.Error(codes.InvalidArgument, "start_date is after end_date")
+	}
+
+	records, err := h.usage.Usage(ctx, projectID, from, to)
+	if err != nil {
+		h.log.Error("failed to list usage", zap.String("project", projectID), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list usage")
+	}
+	return records, nil
+}
+
+func usageToProto(record types.Usage) *pb.UsageRecord {
+	return &pb.UsageRecord{
+		ProjectId:      record.ProjectID,
+		Date:           record.Day.Format(usage.DayLayout),
+		BuildSeconds:   record.BuildSeconds,
+		ReplicaSeconds: record.ReplicaSeconds,
+		ArtifactBytes:  record.ArtifactBytes,
+	}
+}
@@ -0,0 +1,237 @@
+// Package usage meters what projects consume and aggregates it per day
+// for billing and chargeback.
+package usage
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const defaultInterval = 5 * time.Minute
+
+// DayLayout formats the days usage is aggregated by
+const DayLayout = "2006-01-02"
+
+// Store keeps the daily usage table and reads the build history it is
+// derived from
+type Store interface {
+	// AddUsage adds build and replica seconds to the stored days and keeps
+	// the larger artifact size
+	AddUsage(ctx context.Context, records []types.Usage) error
+	// ListUsage returns the days in [from, to] for a project, or for all
+	// projects when projectID is empty, ordered by day and project
+	ListUsage(ctx context.Context, projectID string, from, to time.Time) ([]types.Usage, error)
+	// BuildSeconds sums the duration of builds per project that finished
+	// in [from, to)
+	BuildSeconds(ctx context.Context, from, to time.Time) (map[string]int64, error)
+	// ListArtifacts maps the artifact paths of stored builds to their
+	// projects
+	ListArtifacts(ctx context.Context) (map[string]string, error)
+}
+
+// ReplicaCounter reports the running replicas of each project
+type ReplicaCounter interface {
+	Replicas(ctx context.Context) (map[string]int32, error)
+}
+
+// Meter samples usage periodically and adds it to the daily totals
+type Meter struct {
+	config   *config.UsageConfig
+	store    Store
+	replicas ReplicaCounter
+	interval time.Duration
+	log      *zap.Logger
+	last     time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewMeter creates a meter. replicas may be nil on platforms that do not
+// run workloads.
+func NewMeter(cfg *config.UsageConfig, store Store, replicas ReplicaCounter, log *zap.Logger) *Meter {
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &Meter{config: cfg, store: store, replicas: replicas, interval: interval, log: log}
+}
+
+// Enabled reports whether usage is metered
+func (m *Meter) Enabled() bool {
+	return m.config.Enabled
+}
+
+func (m *Meter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.last = time.Now()
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := m.Record(ctx, now); err != nil {
+					m.log.Error("failed to record usage", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop records the usage since the last sample and stops metering
+func (m *Meter) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+	if err := m.Record(context.Background(), time.Now()); err != nil {
+		m.log.Error("failed to record usage", zap.Error(err))
+	}
+}
+
+// Record adds the usage between the previous sample and now. Replica
+// seconds assume the replicas running now ran since the previous sample.
+// A failed write is retried with the next sample.
+func (m *Meter) Record(ctx context.Context, now time.Time) error {
+	if m.last.IsZero() {
+		m.last = now
+		return nil
+	}
+
+	var replicas map[string]int32
+	if m.replicas != nil {
+		var err error
+		if replicas, err = m.replicas.Replicas(ctx); err != nil {
+			m.log.Warn("failed to count replicas", zap.Error(err))
+		}
+	}
+
+	days := make(map[string]*types.Usage)
+	day := func(projectID string, t time.Time) *types.Usage {
+		start := Day(t)
+		key := projectID + "/" + start.Format(DayLayout)
+		if _, ok := days[key]; !ok {
+			days[key] = &types.Usage{ProjectID: projectID, Day: start}
+		}
+		return days[key]
+	}
+
+	for _, window := range splitDays(m.last, now) {
+		seconds, err := m.store.BuildSeconds(ctx, window.from, window.to)
+		if err != nil {
+			return fmt.Errorf("failed to sum build time: %w", err)
+		}
+		for projectID, s := range seconds {
+			day(projectID, window.from).BuildSeconds += s
+		}
+
+		elapsed := int64(window.to.Sub(window.from).Seconds())
+		for projectID, count := range replicas {
+			if count > 0 {
+				day(projectID, window.from).ReplicaSeconds += int64(count) * elapsed
+			}
+		}
+	}
+
+	artifacts, err := m.artifactBytes(ctx)
+	if err != nil {
+		m.log.Warn("failed to measure artifacts", zap.Error(err))
+	}
+	for projectID, size := range artifacts {
+		day(projectID, now).ArtifactBytes = size
+	}
+
+	records := make([]types.Usage, 0, len(days))
+	for _, usage := range days {
+		records = append(records, *usage)
+	}
+	if len(records) > 0 {
+		if err := m.store.AddUsage(ctx, records); err != nil {
+			return fmt.Errorf("failed to store usage: %w", err)
+		}
+	}
+	m.last = now
+	return nil
+}
+
+// artifactBytes sums the size of the artifacts on disk per project.
+// Artifacts removed by cleanup no longer count.
+func (m *Meter) artifactBytes(ctx context.Context) (map[string]int64, error) {
+	paths, err := m.store.ListArtifacts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	for path, projectID := range paths {
+		if info, err := os.Stat(path); err == nil {
+			sizes[projectID] += info.Size()
+		}
+	}
+	return sizes, nil
+}
+
+// Usage returns the daily usage of a project, or of all projects when
+// projectID is empty, for the days from through to
+func (m *Meter) Usage(ctx context.Context, projectID string, from, to time.Time) ([]types.Usage, error) {
+	return m.store.ListUsage(ctx, projectID, Day(from), Day(to))
+}
+
+// WriteCSV writes usage records as CSV with a header row. Build and
+// replica time are in minutes and hours to match how they are billed.
+func WriteCSV(w io.Writer, records []types.Usage) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"day", "project", "build_minutes", "replica_hours", "artifact_bytes"})
+	for _, usage := range records {
+		out.Write([]string{
+			usage.Day.Format(DayLayout),
+			usage.ProjectID,
+			strconv.FormatFloat(float64(usage.BuildSeconds)/60, 'f', 2, 64),
+			strconv.FormatFloat(float64(usage.ReplicaSeconds)/3600, 'f', 2, 64),
+			strconv.FormatInt(usage.ArtifactBytes, 10),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Day returns the start of t's UTC day
+func Day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+type window struct {
+	from, to time.Time
+}
+
+// splitDays splits [from, to) at UTC midnights
+func splitDays(from, to time.Time) []window {
+	var windows []window
+	for from.Before(to) {
+		end := Day(from).Add(24 * time.Hour)
+		if end.After(to) {
+			end = to
+		}
+		windows = append(windows, window{from, end})
+		from = end
+	}
+	return windows
+}
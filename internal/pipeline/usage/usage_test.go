@@ -0,0 +1,122 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+type fakeStore struct {
+	builds    map[string]int64
+	artifacts map[string]string
+	added     []types.Usage
+	windows   [][2]time.Time
+	err       error
+}
+
+func (s *fakeStore) AddUsage(_ context.Context, records []types.Usage) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.added = append(s.added, records...)
+	return nil
+}
+
+func (s *fakeStore) ListUsage(context.Context, string, time.Time, time.Time) ([]types.Usage, error) {
+	return s.added, nil
+}
+
+func (s *fakeStore) BuildSeconds(_ context.Context, from, to time.Time) (map[string]int64, error) {
+	s.windows = append(s.windows, [2]time.Time{from, to})
+	return s.builds, nil
+}
+
+func (s *fakeStore) ListArtifacts(context.Context) (map[string]string, error) {
+	return s.artifacts, nil
+}
+
+type fakeReplicas map[string]int32
+
+func (r fakeReplicas) Replicas(context.Context) (map[string]int32, error) {
+	return r, nil
+}
+
+// find returns the recorded usage of a project on a day
+func find(records []types.Usage, projectID string, day time.Time) types.Usage {
+	var total types.Usage
+	for _, record := range records {
+		if record.ProjectID == projectID && record.Day.Equal(day) {
+			total.BuildSeconds += record.BuildSeconds
+			total.ReplicaSeconds += record.ReplicaSeconds
+			total.ArtifactBytes = max(total.ArtifactBytes, record.ArtifactBytes)
+		}
+	}
+	return total
+}
+
+func TestMeter_Record(t *testing.T) {
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "b1.tar.gz")
+	require.NoError(t, os.WriteFile(artifact, make([]byte, 1024), 0644))
+
+	store := &fakeStore{
+		builds: map[string]int64{"shop": 90},
+		artifacts: map[string]string{
+			artifact:                          "shop",
+			filepath.Join(dir, "gone.tar.gz"): "shop",
+		},
+	}
+	m := NewMeter(&config.UsageConfig{Enabled: true}, store, fakeReplicas{"shop": 2, "idle": 0}, zap.NewNop())
+
+	// The first sample only starts the clock
+	start := time.Date(2025, 3, 4, 23, 0, 0, 0, time.UTC)
+	require.NoError(t, m.Record(context.Background(), start))
+	assert.Empty(t, store.added)
+
+	// Two hours across midnight are split between the days
+	require.NoError(t, m.Record(context.Background(), start.Add(2*time.Hour)))
+	midnight := time.Date(2025, 3, 5, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, [][2]time.Time{{start, midnight}, {midnight, start.Add(2 * time.Hour)}}, store.windows)
+
+	first := find(store.added, "shop", midnight.Add(-24*time.Hour))
+	assert.Equal(t, types.Usage{BuildSeconds: 90, ReplicaSeconds: 2 * 3600}, first)
+	second := find(store.added, "shop", midnight)
+	assert.Equal(t, types.Usage{BuildSeconds: 90, ReplicaSeconds: 2 * 3600, ArtifactBytes: 1024}, second)
+	assert.Equal(t, types.Usage{}, find(store.added, "idle", midnight))
+}
+
+func TestMeter_RetriesFailedWrites(t *testing.T) {
+	store := &fakeStore{err: errors.New("database is down")}
+	m := NewMeter(&config.UsageConfig{Enabled: true}, store, fakeReplicas{"shop": 1}, zap.NewNop())
+
+	start := time.Date(2025, 3, 5, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, m.Record(context.Background(), start))
+	assert.Error(t, m.Record(context.Background(), start.Add(time.Hour)))
+
+	store.err = nil
+	require.NoError(t, m.Record(context.Background(), start.Add(2*time.Hour)))
+	assert.Equal(t, int64(2*3600), find(store.added, "shop", Day(start)).ReplicaSeconds)
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, []types.Usage{{
+		ProjectID:      "shop",
+		Day:            time.Date(2025, 3, 5, 0, 0, 0, 0, time.UTC),
+		BuildSeconds:   90,
+		ReplicaSeconds: 5400,
+		ArtifactBytes:  1024,
+	}}))
+	assert.Equal(t, "day,project,build_minutes,replica_hours,artifact_bytes\n2025-03-05,shop,1.50,1.50,1024\n", buf.String())
+}
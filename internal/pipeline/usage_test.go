@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/pipeline/usage"
+	pb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+)
+
+// replicaDeployer reports fixed replica counts
+type replicaDeployer struct {
+	mockDeployer
+	replicas map[string]int32
+}
+
+func (d *replicaDeployer) Replicas(context.Context) (map[string]int32, error) {
+	return d.replicas, nil
+}
+
+func TestPipeline_Replicas(t *testing.T) {
+	pipeline, _, _, _ := setupTestPipeline(t)
+	pipeline.builds["b1"] = &types.Build{
+		ID:        "b1",
+		ProjectID: "shop",
+		Preview:   &types.Preview{Name: "preview-b1"},
+	}
+
+	replicas, err := pipeline.Replicas(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, replicas)
+
+	pipeline.deployer = &replicaDeployer{replicas: map[string]int32{"shop": 2, "preview-b1": 1, "blog": 1}}
+	replicas, err = pipeline.Replicas(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int32{"shop": 3, "blog": 1}, replicas)
+}
+
+// usageStore serves fixed usage records
+type usageStore struct {
+	records []types.Usage
+}
+
+func (s *usageStore) AddUsage(context.Context, []types.Usage) error { return nil }
+
+func (s *usageStore) ListUsage(context.Context, string, time.Time, time.Time) ([]types.Usage, error) {
+	return s.records, nil
+}
+
+func (s *usageStore) BuildSeconds(context.Context, time.Time, time.Time) (map[string]int64, error) {
+	return nil, nil
+}
+
+func (s *usageStore) ListArtifacts(context.Context) (map[string]string, error) { return nil, nil }
+
+func TestHandler_GetUsage(t *testing.T) {
+	day := time.Date(2025, 3, 5, 0, 0, 0, 0, time.UTC)
+	store := &usageStore{records: []types.Usage{
+		{ProjectID: "shop", Day: day, BuildSeconds: 60, ReplicaSeconds: 3600, ArtifactBytes: 2048},
+		{ProjectID: "shop", Day: day.Add(24 * time.Hour), BuildSeconds: 30, ReplicaSeconds: 3600, ArtifactBytes: 1024},
+	}}
+	cfg := &config.UsageConfig{}
+	meter := usage.NewMeter(cfg, store, nil, zap.NewNop())
+	h := NewHandler(&Pipeline{}, nil, nil, meter, nil, ownerAuthorizer{}, nil, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	_, err := h.GetUsage(alice, &pb.GetUsageRequest{ProjectId: "shop"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	cfg.Enabled = true
+
+	_, err = h.GetUsage(bob, &pb.GetUsageRequest{ProjectId: "shop"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = h.GetUsage(alice, &pb.GetUsageRequest{ProjectId: "shop", StartDate: "05/03/2025"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = h.GetUsage(alice, &pb.GetUsageRequest{ProjectId: "shop", StartDate: "2025-03-06", EndDate: "2025-03-05"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := h.GetUsage(alice, &pb.GetUsageRequest{ProjectId: "shop", StartDate: "2025-03-05", EndDate: "2025-03-06"})
+	require.NoError(t, err)
+	require.Len(t, resp.Days, 2)
+	assert.Equal(t, "2025-03-05", resp.Days[0].Date)
+	assert.Equal(t, &pb.UsageRecord{ProjectId: "shop", BuildSeconds: 90, ReplicaSeconds: 7200, ArtifactBytes: 2048}, resp.Total)
+
+	export, err := h.ExportUsage(alice, &pb.ExportUsageRequest{})
+	require.NoError(t, err)
+	assert.Contains(t, string(export.Csv), "2025-03-06,shop,0.50,1.00,1024\n")
+}
@@ -0,0 +1,115 @@
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// operatorSpace matches the spaces npm allows between an operator and its
+// version, as in ">= 18"
+var operatorSpace = regexp.MustCompile(`(>=|<=|>|<|=|\^|~)\s+`)
+
+// satisfiesMajor reports whether a Node.js major version satisfies an npm
+// style engines range such as ">=18", "^20.1.0", "18.x" or "16 || >=20".
+// Build images are selected per major version, so comparisons are made at
+// major granularity.
+func satisfiesMajor(rangeExpr string, major int) (bool, error) {
+	for _, clause := range strings.Split(rangeExpr, "||") {
+		ok, err := satisfiesClause(strings.TrimSpace(clause), major)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func satisfiesClause(clause string, major int) (bool, error) {
+	if clause == "" || clause == "*" || clause == "x" {
+		return true, nil
+	}
+
+	// Hyphen ranges: "16 - 20"
+	if parts := strings.SplitN(clause, " - ", 2); len(parts) == 2 {
+		lower, _, err := parseVersion(parts[0])
+		if err != nil {
+			return false, err
+		}
+		upper, _, err := parseVersion(parts[1])
+		if err != nil {
+			return false, err
+		}
+		return major >= lower && major <= upper, nil
+	}
+
+	for _, comparator := range strings.Fields(operatorSpace.ReplaceAllString(clause, "$1")) {
+		ok, err := satisfiesComparator(comparator, major)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func satisfiesComparator(comparator string, major int) (bool, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(comparator, prefix) {
+			op = prefix
+			break
+		}
+	}
+
+	version, partial, err := parseVersion(strings.TrimPrefix(comparator, op))
+	if err != nil {
+		return false, err
+	}
+
+	switch op {
+	case ">=":
+		return major >= version, nil
+	case "<=":
+		return major <= version, nil
+	case ">":
+		// ">18.2" is satisfied by a later 18.x release
+		if partial {
+			return major >= version, nil
+		}
+		return major > version, nil
+	case "<":
+		// "<18.2" is satisfied by 18.0
+		if partial {
+			return major <= version, nil
+		}
+		return major < version, nil
+	default:
+		return major == version, nil
+	}
+}
+
+// parseVersion returns the major component of a (possibly partial) version
+// and whether a non-zero minor or patch component was given.
+func parseVersion(v string) (int, bool, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	parts := strings.Split(v, ".")
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid version %q in engines range", v)
+	}
+
+	partial := false
+	for _, part := range parts[1:] {
+		if part != "0" && part != "x" && part != "*" {
+			partial = true
+		}
+	}
+	return major, partial, nil
+}
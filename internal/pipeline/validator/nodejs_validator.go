@@ -1,12 +1,20 @@
 package validator
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/buildlog"
 	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/envtemplate"
+	"github.com/elskow/chef-infra/internal/pipeline/integrity"
 	"github.com/elskow/chef-infra/internal/pipeline/types"
 )
 
@@ -20,15 +28,24 @@ type PackageJSON struct {
 
 type NodeJSValidator struct {
 	config *config.NodeJSConfig
+	matrix *VersionMatrix
+	logger *zap.Logger
 }
 
-func NewNodeJSValidator(config *config.NodeJSConfig) *NodeJSValidator {
+func NewNodeJSValidator(config *config.NodeJSConfig, logger *zap.Logger) *NodeJSValidator {
 	return &NodeJSValidator{
 		config: config,
+		matrix: NewVersionMatrix(config, logger),
+		logger: logger,
 	}
 }
 
-func (v *NodeJSValidator) ValidateBuildConfig(build *types.Build) error {
+// Matrix returns the node version matrix used to resolve build images
+func (v *NodeJSValidator) Matrix() *VersionMatrix {
+	return v.matrix
+}
+
+func (v *NodeJSValidator) ValidateBuildConfig(ctx context.Context, build *types.Build) error {
 	// Validate package.json
 	pkgJSON, err := v.readPackageJSON(build)
 	if err != nil {
@@ -36,7 +53,7 @@ func (v *NodeJSValidator) ValidateBuildConfig(build *types.Build) error {
 	}
 
 	// Validate node version compatibility
-	if err := v.validateNodeVersion(pkgJSON); err != nil {
+	if err := v.validateNodeVersion(pkgJSON, build); err != nil {
 		return err
 	}
 
@@ -45,10 +62,25 @@ func (v *NodeJSValidator) ValidateBuildConfig(build *types.Build) error {
 		return err
 	}
 
+	// Validate requested target platforms
+	if err := types.ValidatePlatforms(build.Platforms); err != nil {
+		return err
+	}
+
+	// Reject env var templates referencing unknown keys
+	if err := envtemplate.Validate(build.EnvVars); err != nil {
+		return err
+	}
+
+	for _, key := range envtemplate.BundledSecrets(build.EnvVars, v.config.PublicEnvPrefixes) {
+		build.Warnings = append(build.Warnings,
+			fmt.Sprintf("env var %s looks like a secret and will be readable in the client bundle", key))
+	}
+
 	return nil
 }
 
-func (v *NodeJSValidator) ValidateArtifact(artifactPath string) error {
+func (v *NodeJSValidator) ValidateArtifact(ctx context.Context, artifactPath string, sourceMaps types.SourceMaps) error {
 	// Check if artifact exists
 	if _, err := os.Stat(artifactPath); err != nil {
 		return fmt.Errorf("artifact not found: %w", err)
@@ -64,10 +96,38 @@ func (v *NodeJSValidator) ValidateArtifact(artifactPath string) error {
 	if info.Size() > maxSize {
 		return fmt.Errorf("artifact size exceeds maximum allowed size")
 	}
+	buildlog.Logger(ctx, v.logger).Debug("artifact size validated",
+		zap.String("artifact", artifactPath),
+		zap.Int64("size", info.Size()))
 
+	if sourceMaps.Removed() {
+		return validateNoSourceMaps(artifactPath, sourceMaps)
+	}
 	return nil
 }
 
+// validateNoSourceMaps fails when source maps the project strips or
+// uploads made it into the artifact anyway
+func validateNoSourceMaps(artifactPath string, sourceMaps types.SourceMaps) error {
+	files, err := integrity.ReadManifest(artifactPath)
+	if err != nil {
+		return fmt.Errorf("failed to read artifact: %w", err)
+	}
+	var maps []string
+	for path := range files {
+		if strings.HasSuffix(path, ".map") {
+			maps = append(maps, path)
+		}
+	}
+	if len(maps) == 0 {
+		return nil
+	}
+	sort.Strings(maps)
+	listed := maps[:min(len(maps), 5)]
+	return fmt.Errorf("artifact contains %d source maps although the project's source_maps is %s: %s",
+		len(maps), sourceMaps, strings.Join(listed, ", "))
+}
+
 func (v *NodeJSValidator) readPackageJSON(build *types.Build) (*PackageJSON, error) {
 	sourceDir, ok := build.BuilderConfig["sourceDir"].(string)
 	if !ok {
@@ -88,20 +148,19 @@ func (v *NodeJSValidator) readPackageJSON(build *types.Build) (*PackageJSON, err
 	return &pkg, nil
 }
 
-func (v *NodeJSValidator) validateNodeVersion(pkg *PackageJSON) error {
-	if pkg.Engines == nil || pkg.Engines["node"] == "" {
-		return nil // No engine constraints specified
+func (v *NodeJSValidator) validateNodeVersion(pkg *PackageJSON, build *types.Build) error {
+	// Resolve the newest version in the matrix satisfying the engines range
+	version, err := v.matrix.Resolve(pkg.Engines["node"])
+	if err != nil {
+		return err
 	}
 
-	// For now, we only support exact node version matching
-	// TODO: Implement semver validation
-	for _, allowed := range v.config.AllowedEngines {
-		if pkg.Engines["node"] == allowed {
-			return nil
-		}
+	build.NodeVersion = version.Version
+	if warning := v.matrix.deprecationWarning(version); warning != "" {
+		build.Warnings = append(build.Warnings, warning)
 	}
 
-	return fmt.Errorf("unsupported node version: %s", pkg.Engines["node"])
+	return nil
 }
 
 func (v *NodeJSValidator) validateBuildScript(pkg *PackageJSON, build *types.Build) error {
@@ -0,0 +1,45 @@
+package validator
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+func writeArtifact(t *testing.T, names ...string) string {
+	path := filepath.Join(t.TempDir(), "artifact.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, name := range names {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 2}))
+		_, err := tw.Write([]byte("{}"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return path
+}
+
+func TestValidateArtifact_SourceMaps(t *testing.T) {
+	v := NewNodeJSValidator(&config.NodeJSConfig{DefaultVersion: "20"}, zap.NewNop())
+	withMaps := writeArtifact(t, "html/index.html", "html/static/js/main.js", "html/static/js/main.js.map")
+	stripped := writeArtifact(t, "html/index.html", "html/static/js/main.js")
+	ctx := context.Background()
+
+	assert.NoError(t, v.ValidateArtifact(ctx, withMaps, ""))
+	assert.NoError(t, v.ValidateArtifact(ctx, withMaps, types.SourceMapsKeep))
+	assert.NoError(t, v.ValidateArtifact(ctx, stripped, types.SourceMapsStrip))
+	assert.EqualError(t, v.ValidateArtifact(ctx, withMaps, types.SourceMapsUpload),
+		"artifact contains 1 source maps although the project's source_maps is upload: /static/js/main.js.map")
+}
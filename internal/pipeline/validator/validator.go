@@ -1,10 +1,16 @@
 package validator
 
 import (
+	"context"
+
 	"github.com/elskow/chef-infra/internal/pipeline/types"
 )
 
+// Validator checks builds before and after building. Contexts carry the
+// build being validated, see buildlog.
 type Validator interface {
-	ValidateBuildConfig(build *types.Build) error
-	ValidateArtifact(artifactPath string) error
+	ValidateBuildConfig(ctx context.Context, build *types.Build) error
+	// ValidateArtifact checks a build's artifact, including that it holds
+	// no source maps when the project removes them
+	ValidateArtifact(ctx context.Context, artifactPath string, sourceMaps types.SourceMaps) error
 }
@@ -0,0 +1,253 @@
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+const (
+	fallbackNodeVersion = "20"
+	// matrixRefreshInterval is how often replicas reload the stored matrix
+	matrixRefreshInterval = time.Minute
+)
+
+var ErrInvalidVersions = errors.New("invalid node version matrix")
+
+// VersionStore persists the matrix set through the admin API
+type VersionStore interface {
+	// LoadNodeVersions returns no versions when the matrix was never set
+	LoadNodeVersions(ctx context.Context) ([]config.NodeVersionConfig, string, error)
+	SaveNodeVersions(ctx context.Context, versions []config.NodeVersionConfig, defaultVersion string) error
+}
+
+// VersionMatrix holds the Node.js versions builds may use. It can be replaced
+// at runtime through the admin API without restarting the server. With a
+// store the replacement survives restarts and replicas reload it every
+// refresh interval; until one is stored the configured matrix is used.
+type VersionMatrix struct {
+	versions       []config.NodeVersionConfig
+	defaultVersion string
+	mu             sync.RWMutex
+
+	store  VersionStore
+	log    *zap.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewVersionMatrix(cfg *config.NodeJSConfig, log *zap.Logger) *VersionMatrix {
+	versions := cfg.Versions
+	if len(versions) == 0 {
+		for _, engine := range cfg.AllowedEngines {
+			versions = append(versions, config.NodeVersionConfig{Version: engine})
+		}
+	}
+
+	defaultVersion := cfg.DefaultVersion
+	if defaultVersion == "" {
+		defaultVersion = fallbackNodeVersion
+	}
+
+	m := &VersionMatrix{log: log}
+	if err := m.Update(versions, defaultVersion); err != nil {
+		// Keep the configured values so validation surfaces the problem per build
+		m.versions = versions
+		m.defaultVersion = defaultVersion
+	}
+	return m
+}
+
+// Versions returns a copy of the matrix ordered newest first
+func (m *VersionMatrix) Versions() ([]config.NodeVersionConfig, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := make([]config.NodeVersionConfig, len(m.versions))
+	copy(versions, m.versions)
+	return versions, m.defaultVersion
+}
+
+// SetStore persists the matrix in store; Load reads it back
+func (m *VersionMatrix) SetStore(store VersionStore) {
+	m.store = store
+}
+
+// Load replaces the matrix with the stored one, if one was stored
+func (m *VersionMatrix) Load(ctx context.Context) error {
+	if m.store == nil {
+		return nil
+	}
+	versions, defaultVersion, err := m.store.LoadNodeVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load node versions: %w", err)
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	return m.Update(versions, defaultVersion)
+}
+
+// Save stores the matrix and replaces it. Matrices Update rejects fail
+// with ErrInvalidVersions and are not stored.
+func (m *VersionMatrix) Save(ctx context.Context, versions []config.NodeVersionConfig, defaultVersion string) error {
+	sorted, defaultVersion, err := sortVersions(versions, defaultVersion)
+	if err != nil {
+		return err
+	}
+	if m.store != nil {
+		if err := m.store.SaveNodeVersions(ctx, sorted, defaultVersion); err != nil {
+			return fmt.Errorf("failed to save node versions: %w", err)
+		}
+	}
+	m.set(sorted, defaultVersion)
+	return nil
+}
+
+// Start reloads the stored matrix every refresh interval, so an update on
+// one replica reaches the others
+func (m *VersionMatrix) Start() {
+	if m.store == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(matrixRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := m.Load(ctx); err != nil {
+				m.log.Warn("failed to reload node versions", zap.Error(err))
+			}
+		}
+	}()
+}
+
+func (m *VersionMatrix) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	<-m.done
+}
+
+// Update atomically replaces the matrix
+func (m *VersionMatrix) Update(versions []config.NodeVersionConfig, defaultVersion string) error {
+	sorted, defaultVersion, err := sortVersions(versions, defaultVersion)
+	if err != nil {
+		return err
+	}
+	m.set(sorted, defaultVersion)
+	return nil
+}
+
+func (m *VersionMatrix) set(versions []config.NodeVersionConfig, defaultVersion string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.versions = versions
+	m.defaultVersion = defaultVersion
+}
+
+// sortVersions checks a matrix and orders it newest first. An empty
+// default version is the newest one.
+func sortVersions(versions []config.NodeVersionConfig, defaultVersion string) ([]config.NodeVersionConfig, string, error) {
+	if len(versions) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one node version is required", ErrInvalidVersions)
+	}
+
+	sorted := make([]config.NodeVersionConfig, len(versions))
+	copy(sorted, versions)
+
+	majors := make(map[string]int, len(sorted))
+	for _, v := range sorted {
+		major, err := strconv.Atoi(v.Version)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: node version must be a major version number: %q", ErrInvalidVersions, v.Version)
+		}
+		majors[v.Version] = major
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return majors[sorted[i].Version] > majors[sorted[j].Version]
+	})
+
+	if defaultVersion == "" {
+		defaultVersion = sorted[0].Version
+	}
+	if _, ok := majors[defaultVersion]; !ok {
+		return nil, "", fmt.Errorf("%w: default node version %s is not in the version matrix", ErrInvalidVersions, defaultVersion)
+	}
+	return sorted, defaultVersion, nil
+}
+
+// Resolve picks the newest version satisfying the engines range, or the
+// default version when no range is given.
+func (m *VersionMatrix) Resolve(engineRange string) (config.NodeVersionConfig, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if engineRange == "" {
+		for _, v := range m.versions {
+			if v.Version == m.defaultVersion {
+				return v, nil
+			}
+		}
+		return config.NodeVersionConfig{Version: m.defaultVersion}, nil
+	}
+
+	for _, v := range m.versions {
+		major, err := strconv.Atoi(v.Version)
+		if err != nil {
+			return config.NodeVersionConfig{}, fmt.Errorf("invalid node version in matrix: %q", v.Version)
+		}
+
+		ok, err := satisfiesMajor(engineRange, major)
+		if err != nil {
+			return config.NodeVersionConfig{}, err
+		}
+		if ok {
+			return v, nil
+		}
+	}
+
+	return config.NodeVersionConfig{}, fmt.Errorf("unsupported node version: %s", engineRange)
+}
+
+// deprecationWarning describes why a resolved version should be upgraded
+func (m *VersionMatrix) deprecationWarning(v config.NodeVersionConfig) string {
+	if !v.Deprecated {
+		return ""
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	warning := fmt.Sprintf("node %s is end-of-life", v.Version)
+	if v.EOLDate != "" {
+		warning = fmt.Sprintf("%s since %s", warning, v.EOLDate)
+	}
+	for _, candidate := range m.versions {
+		if !candidate.Deprecated {
+			return fmt.Sprintf("%s, consider upgrading to node %s", warning, candidate.Version)
+		}
+	}
+	return warning
+}
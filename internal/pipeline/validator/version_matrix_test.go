@@ -0,0 +1,145 @@
+package validator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/config"
+)
+
+func newTestMatrix(t *testing.T) *VersionMatrix {
+	matrix := NewVersionMatrix(&config.NodeJSConfig{
+		DefaultVersion: "20",
+		Versions: []config.NodeVersionConfig{
+			{Version: "16", Deprecated: true, EOLDate: "2023-09-11"},
+			{Version: "18"},
+			{Version: "20"},
+			{Version: "22"},
+		},
+	}, zap.NewNop())
+	versions, _ := matrix.Versions()
+	require.Equal(t, "22", versions[0].Version)
+	return matrix
+}
+
+func TestVersionMatrix_Resolve(t *testing.T) {
+	matrix := newTestMatrix(t)
+
+	tests := []struct {
+		name        string
+		engines     string
+		want        string
+		expectError bool
+	}{
+		{name: "no engines uses default", engines: "", want: "20"},
+		{name: "exact major", engines: "18", want: "18"},
+		{name: "x range", engines: "18.x", want: "18"},
+		{name: "lower bound picks newest", engines: ">=18", want: "22"},
+		{name: "bounded range", engines: ">=16 <21", want: "20"},
+		{name: "spaced operators", engines: ">= 16 < 21", want: "20"},
+		{name: "spaced lower bound", engines: ">= 18", want: "22"},
+		{name: "caret", engines: "^20.11.0", want: "20"},
+		{name: "partial upper bound", engines: "<18.5", want: "18"},
+		{name: "or clauses", engines: "16 || 18", want: "18"},
+		{name: "hyphen range", engines: "16 - 18", want: "18"},
+		{name: "no match", engines: ">=24", expectError: true},
+		{name: "invalid range", engines: ">=latest", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := matrix.Resolve(tt.engines)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, version.Version)
+		})
+	}
+}
+
+func TestVersionMatrix_DeprecationWarning(t *testing.T) {
+	matrix := newTestMatrix(t)
+
+	version, err := matrix.Resolve("16")
+	require.NoError(t, err)
+	assert.Equal(t, "node 16 is end-of-life since 2023-09-11, consider upgrading to node 22", matrix.deprecationWarning(version))
+
+	version, err = matrix.Resolve("20")
+	require.NoError(t, err)
+	assert.Empty(t, matrix.deprecationWarning(version))
+}
+
+func TestVersionMatrix_Update(t *testing.T) {
+	matrix := newTestMatrix(t)
+
+	assert.Error(t, matrix.Update(nil, ""))
+	assert.Error(t, matrix.Update([]config.NodeVersionConfig{{Version: "lts"}}, ""))
+	assert.Error(t, matrix.Update([]config.NodeVersionConfig{{Version: "22"}}, "20"))
+
+	require.NoError(t, matrix.Update([]config.NodeVersionConfig{{Version: "20"}, {Version: "22"}}, ""))
+	versions, defaultVersion := matrix.Versions()
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "22", defaultVersion)
+}
+
+// memoryVersions is a VersionStore keeping the matrix in memory
+type memoryVersions struct {
+	versions       []config.NodeVersionConfig
+	defaultVersion string
+	err            error
+}
+
+func (s *memoryVersions) LoadNodeVersions(context.Context) ([]config.NodeVersionConfig, string, error) {
+	return s.versions, s.defaultVersion, s.err
+}
+
+func (s *memoryVersions) SaveNodeVersions(_ context.Context, versions []config.NodeVersionConfig, defaultVersion string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.versions, s.defaultVersion = versions, defaultVersion
+	return nil
+}
+
+func TestVersionMatrix_Store(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryVersions{}
+	matrix := newTestMatrix(t)
+	matrix.SetStore(store)
+
+	// Nothing stored keeps the configured matrix
+	require.NoError(t, matrix.Load(ctx))
+	versions, defaultVersion := matrix.Versions()
+	assert.Len(t, versions, 4)
+	assert.Equal(t, "20", defaultVersion)
+
+	assert.ErrorIs(t, matrix.Save(ctx, []config.NodeVersionConfig{{Version: "lts"}}, ""), ErrInvalidVersions)
+	assert.Nil(t, store.versions)
+
+	require.NoError(t, matrix.Save(ctx, []config.NodeVersionConfig{{Version: "20"}, {Version: "22"}}, ""))
+	assert.Equal(t, []config.NodeVersionConfig{{Version: "22"}, {Version: "20"}}, store.versions)
+	assert.Equal(t, "22", store.defaultVersion)
+
+	// A restarted server or another replica reads it back
+	restarted := newTestMatrix(t)
+	restarted.SetStore(store)
+	require.NoError(t, restarted.Load(ctx))
+	versions, defaultVersion = restarted.Versions()
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "22", defaultVersion)
+
+	// A failed save leaves the matrix as it was
+	store.err = errors.New("database is down")
+	err := matrix.Save(ctx, []config.NodeVersionConfig{{Version: "22"}}, "")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidVersions)
+	versions, _ = matrix.Versions()
+	assert.Len(t, versions, 2)
+}
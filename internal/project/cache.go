@@ -0,0 +1,68 @@
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/cache"
+)
+
+// projectCacheTTL bounds how stale a cached project can be on a replica
+// that missed an invalidation, e.g. while Redis was down
+const projectCacheTTL = 30 * time.Second
+
+// cachedRepository caches project lookups by name, which every pipeline,
+// webhook and exec call makes to authorize the caller. Writes through it
+// invalidate the cached project.
+type cachedRepository struct {
+	Repository
+	cache cache.Store
+}
+
+// NewCachedRepository wraps repo with a read-through cache of projects
+func NewCachedRepository(repo Repository, store cache.Store) Repository {
+	return &cachedRepository{Repository: repo, cache: store}
+}
+
+func projectKey(name string) string {
+	return "project:" + name
+}
+
+func (r *cachedRepository) GetProjectByName(name string) (*Project, error) {
+	ctx := context.Background()
+	if cached, ok, _ := r.cache.Get(ctx, projectKey(name)); ok {
+		var project Project
+		if err := json.Unmarshal([]byte(cached), &project); err == nil {
+			return &project, nil
+		}
+	}
+
+	project, err := r.Repository.GetProjectByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(project); err == nil {
+		_ = r.cache.Set(ctx, projectKey(name), string(data), projectCacheTTL)
+	}
+	return project, nil
+}
+
+func (r *cachedRepository) DeleteProject(name string) error {
+	defer r.invalidate(name)
+	return r.Repository.DeleteProject(name)
+}
+
+func (r *cachedRepository) RestoreProject(name string) error {
+	defer r.invalidate(name)
+	return r.Repository.RestoreProject(name)
+}
+
+func (r *cachedRepository) UpdateProject(project *Project) error {
+	defer r.invalidate(project.Name)
+	return r.Repository.UpdateProject(project)
+}
+
+func (r *cachedRepository) invalidate(name string) {
+	_ = r.cache.Delete(context.Background(), projectKey(name))
+}
@@ -0,0 +1,48 @@
+package project
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/cache"
+)
+
+// countingRepository counts lookups that reach the database
+type countingRepository struct {
+	*mockRepository
+	lookups int
+}
+
+func (r *countingRepository) GetProjectByName(name string) (*Project, error) {
+	r.lookups++
+	return r.mockRepository.GetProjectByName(name)
+}
+
+func TestCachedRepository(t *testing.T) {
+	backing := &countingRepository{mockRepository: newMockRepository()}
+	repo := NewCachedRepository(backing, cache.NewMemory())
+	require.NoError(t, repo.CreateProject(&Project{Name: "shop", Owner: "alice"}))
+
+	for i := 0; i < 3; i++ {
+		project, err := repo.GetProjectByName("shop")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", project.Owner)
+	}
+	assert.Equal(t, 1, backing.lookups)
+
+	// Updates are visible right away
+	project, err := repo.GetProjectByName("shop")
+	require.NoError(t, err)
+	project.Branch = "release"
+	require.NoError(t, repo.UpdateProject(project))
+	project, err = repo.GetProjectByName("shop")
+	require.NoError(t, err)
+	assert.Equal(t, "release", project.Branch)
+
+	// Deleted projects are not served from the cache
+	require.NoError(t, repo.DeleteProject("shop"))
+	_, err = repo.GetProjectByName("shop")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+}
@@ -0,0 +1,302 @@
+package project
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/detect"
+	"github.com/elskow/chef-infra/internal/pipeline/source"
+	"github.com/elskow/chef-infra/internal/trigger"
+	pb "github.com/elskow/chef-infra/proto/gen/project"
+)
+
+type Handler struct {
+	pb.UnimplementedProjectServer
+	service *Service
+	log     *zap.Logger
+}
+
+func NewHandler(service *Service, log *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		log:     log,
+	}
+}
+
+func (h *Handler) CreateProject(ctx context.Context, req *pb.CreateProjectRequest) (*pb.CreateProjectResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	project, err := h.service.CreateProject(username, req.Name, req.RepoUrl, req.Framework)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidName):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, ErrProjectExists):
+			return nil, status.Error(codes.AlreadyExists, "project already exists")
+		case errors.Is(err, ErrNameReserved):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		h.log.Error("failed to create project", zap.String("name", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to create project")
+	}
+
+	h.log.Info("project created",
+		zap.String("name", project.Name),
+		zap.String("owner", project.Owner))
+
+	return &pb.CreateProjectResponse{Project: toProto(project)}, nil
+}
+
+func (h *Handler) CreateProjectFromRepo(ctx context.Context, req *pb.CreateProjectFromRepoRequest) (*pb.CreateProjectFromRepoResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if req.RepoUrl == "" {
+		return nil, status.Error(codes.InvalidArgument, "repo_url is required")
+	}
+
+	result, err := h.service.CreateProjectFromRepo(ctx, username, req.Name, req.RepoUrl, req.Branch, req.RegisterWebhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidName), errors.Is(err, source.ErrInvalidURL):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, ErrProjectExists):
+			return nil, status.Error(codes.AlreadyExists, "project already exists")
+		case errors.Is(err, source.ErrSourceTooLarge):
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, ErrNameReserved), errors.Is(err, source.ErrCloneFailed), errors.Is(err, detect.ErrUnsupported):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, context.DeadlineExceeded):
+			return nil, status.Error(codes.DeadlineExceeded, "timed out cloning repository")
+		}
+		h.log.Error("failed to create project from repository", zap.String("repo_url", req.RepoUrl), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to create project")
+	}
+
+	h.log.Info("project created from repository",
+		zap.String("name", result.Project.Name),
+		zap.String("owner", result.Project.Owner),
+		zap.String("framework", result.Settings.Framework),
+		zap.Bool("webhook_registered", result.WebhookRegistered))
+
+	return &pb.CreateProjectFromRepoResponse{
+		Project:           toProto(result.Project),
+		DetectedFramework: result.Settings.Framework,
+		PackageManager:    result.Settings.PackageManager,
+		WebhookRegistered: result.WebhookRegistered,
+		WebhookMessage:    result.WebhookMessage,
+	}, nil
+}
+
+func (h *Handler) GetProject(ctx context.Context, req *pb.GetProjectRequest) (*pb.GetProjectResponse, error) {
+	if err := h.authorize(ctx, req.Name); err != nil {
+		return nil, err
+	}
+
+	project, err := h.service.GetProject(req.Name)
+	if err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return nil, status.Error(codes.NotFound, "project not found")
+		}
+		h.log.Error("failed to get project", zap.String("name", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get project")
+	}
+
+	return &pb.GetProjectResponse{Project: toProto(project)}, nil
+}
+
+func (h *Handler) ListProjects(ctx context.Context, req *pb.ListProjectsRequest) (*pb.ListProjectsResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	page, err := h.service.ListProjects(username, pagination.Params{
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+		SortBy:    req.SortBy,
+		Search:    req.Search,
+	})
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidPageToken) || errors.Is(err, pagination.ErrInvalidSort) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to list projects", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list projects")
+	}
+
+	resp := &pb.ListProjectsResponse{NextPageToken: page.NextPageToken}
+	for i := range page.Items {
+		resp.Projects = append(resp.Projects, toProto(&page.Items[i]))
+	}
+	return resp, nil
+}
+
+func (h *Handler) DeleteProject(ctx context.Context, req *pb.DeleteProjectRequest) (*pb.DeleteProjectResponse, error) {
+	if err := h.authorize(ctx, req.Name); err != nil {
+		return nil, err
+	}
+
+	if err := h.service.DeleteProject(req.Name); err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return nil, status.Error(codes.NotFound, "project not found")
+		}
+		h.log.Error("failed to delete project", zap.String("name", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to delete project")
+	}
+
+	return &pb.DeleteProjectResponse{
+		Success: true,
+		Message: "Project deleted, it can be restored until it is purged",
+	}, nil
+}
+
+func (h *Handler) RestoreProject(ctx context.Context, req *pb.RestoreProjectRequest) (*pb.RestoreProjectResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	allowed, err := h.service.CanRestoreProject(username, req.Name)
+	if err != nil {
+		h.log.Error("failed to check project access", zap.String("name", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to check project access")
+	}
+	if !allowed {
+		return nil, status.Error(codes.PermissionDenied, "access to project denied")
+	}
+
+	project, err := h.service.RestoreProject(req.Name)
+	if err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return nil, status.Error(codes.NotFound, "deleted project not found")
+		}
+		h.log.Error("failed to restore project", zap.String("name", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to restore project")
+	}
+
+	h.log.Info("project restored",
+		zap.String("name", project.Name),
+		zap.String("restored_by", username))
+
+	return &pb.RestoreProjectResponse{Project: toProto(project)}, nil
+}
+
+func (h *Handler) UpdateProjectSettings(ctx context.Context, req *pb.UpdateProjectSettingsRequest) (*pb.UpdateProjectSettingsResponse, error) {
+	if err := h.authorize(ctx, req.Name); err != nil {
+		return nil, err
+	}
+
+	project, err := h.service.UpdateSettings(req.Name, Settings{
+		BuildDedup:            req.BuildDedup,
+		BranchFilters:         req.BranchFilters,
+		PathFilters:           req.PathFilters,
+		StatusPage:            req.StatusPage,
+		RotateStatusPageToken: req.RotateStatusPageToken,
+		Visibility:            req.Visibility,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidDedup), errors.Is(err, ErrInvalidStatusPage), errors.Is(err, ErrInvalidVisibility),
+			errors.Is(err, trigger.ErrInvalidPattern):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, ErrProjectNotFound):
+			return nil, status.Error(codes.NotFound, "project not found")
+		}
+		h.log.Error("failed to update project settings", zap.String("name", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to update project settings")
+	}
+
+	return &pb.UpdateProjectSettingsResponse{Project: toProto(project)}, nil
+}
+
+// SimulatePush reports whether a push would build the project, without
+// building. The server checks the admin role.
+func (h *Handler) SimulatePush(ctx context.Context, req *pb.SimulatePushRequest) (*pb.SimulatePushResponse, error) {
+	sim := PushSimulation{
+		Ref:          req.Ref,
+		Commit:       req.Commit,
+		Before:       req.Before,
+		ChangedPaths: req.ChangedPaths,
+	}
+	if req.Payload != "" {
+		sim.Payload = []byte(req.Payload)
+	}
+
+	result, err := h.service.SimulatePush(ctx, req.Name, sim)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidPush):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, ErrProjectNotFound):
+			return nil, status.Error(codes.NotFound, "project not found")
+		}
+		h.log.Error("failed to simulate push", zap.String("name", req.Name), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to simulate push")
+	}
+
+	return &pb.SimulatePushResponse{
+		Build:         result.Decision.Build,
+		Reason:        result.Decision.Reason,
+		Ref:           result.Push.Ref,
+		Branch:        result.Commit.Branch,
+		Tag:           result.Commit.Tag,
+		Commit:        result.Push.After,
+		Repository:    result.Repository,
+		ChangedPaths:  result.Push.ChangedPaths,
+		PathsComplete: result.Push.PathsComplete,
+		Author:        result.Commit.Author,
+		Message:       result.Commit.Message,
+	}, nil
+}
+
+// authorize rejects callers that are neither admins nor the project owner
+func (h *Handler) authorize(ctx context.Context, name string) error {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	allowed, err := h.service.CanAccessProject(username, name)
+	if err != nil {
+		h.log.Error("failed to check project access", zap.String("name", name), zap.Error(err))
+		return status.Error(codes.Internal, "failed to check project access")
+	}
+	if !allowed {
+		return status.Error(codes.PermissionDenied, "access to project denied")
+	}
+	return nil
+}
+
+func toProto(project *Project) *pb.ProjectInfo {
+	return &pb.ProjectInfo{
+		Name:            project.Name,
+		Owner:           project.Owner,
+		RepoUrl:         project.RepoURL,
+		Framework:       project.Framework,
+		CreatedAt:       project.CreatedAt.Unix(),
+		BuildDedup:      project.BuildDedup,
+		BranchFilters:   project.BranchFilters,
+		PathFilters:     project.PathFilters,
+		StatusPage:      project.StatusPage,
+		StatusPageToken: project.StatusPageToken,
+		Visibility:      project.Visibility,
+		Settings: &pb.BuildSettings{
+			Branch:         project.Branch,
+			InstallCommand: project.InstallCommand,
+			BuildCommand:   project.BuildCommand,
+			OutputDir:      project.OutputDir,
+			NodeVersion:    project.NodeVersion,
+		},
+	}
+}
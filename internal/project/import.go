@@ -0,0 +1,100 @@
+package project
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/detect"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// RepoImporter inspects repositories and registers push webhooks on them
+type RepoImporter interface {
+	// Inspect clones the repository and proposes build settings
+	Inspect(ctx context.Context, username, repoURL, ref string) (*detect.Settings, error)
+	RegisterPushHook(ctx context.Context, username, repoURL string) error
+}
+
+// ImportResult describes a project created from a repository
+type ImportResult struct {
+	Project           *Project
+	Settings          *detect.Settings
+	WebhookRegistered bool
+	WebhookMessage    string // Why the webhook was not registered
+}
+
+// CreateProjectFromRepo creates a project with the build settings detected
+// in the repository. The name defaults to the repository name. A failed
+// webhook registration does not fail the import, the project is usable
+// without it.
+func (s *Service) CreateProjectFromRepo(ctx context.Context, owner, name, repoURL, branch string, registerWebhook bool) (*ImportResult, error) {
+	if name == "" {
+		name = nameFromRepoURL(repoURL)
+	}
+	// Check the name before paying for the clone
+	if err := s.checkNameAvailable(name); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.importer.Inspect(ctx, owner, repoURL, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &Project{
+		Name:           name,
+		Owner:          owner,
+		RepoURL:        repoURL,
+		Framework:      settings.Builder,
+		Branch:         branch,
+		InstallCommand: settings.InstallCommand,
+		BuildCommand:   settings.BuildCommand,
+		OutputDir:      settings.OutputDir,
+		NodeVersion:    settings.NodeVersion,
+		Visibility:     VisibilityPrivate,
+	}
+	// A project created while cloning is caught by the unique index
+	if err := s.repository.CreateProject(project); err != nil {
+		return nil, fmt.Errorf("failed to create project: %w", err)
+	}
+
+	result := &ImportResult{Project: project, Settings: settings}
+	if registerWebhook {
+		if err := s.importer.RegisterPushHook(ctx, owner, repoURL); err != nil {
+			s.log.Warn("failed to register push webhook",
+				zap.String("project", name),
+				zap.Error(err))
+			result.WebhookMessage = err.Error()
+		} else {
+			result.WebhookRegistered = true
+		}
+	}
+	return result, nil
+}
+
+// nameFromRepoURL turns the last path element of a clone URL into a
+// project name, e.g. git@github.com:acme/My_App.git becomes my-app
+func nameFromRepoURL(repoURL string) string {
+	raw := repoURL
+	if parsed, err := url.Parse(repoURL); err == nil && parsed.Path != "" {
+		raw = parsed.Path
+	}
+	// scp-like URLs separate the host with a colon
+	if i := strings.LastIndex(raw, ":"); i >= 0 {
+		raw = raw[i+1:]
+	}
+
+	name := strings.TrimSuffix(path.Base(strings.TrimSuffix(raw, "/")), ".git")
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-")
+}
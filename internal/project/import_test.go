@@ -0,0 +1,105 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/detect"
+)
+
+type fakeImporter struct {
+	inspectErr error
+	hookErr    error
+	inspected  int
+	hooks      []string
+}
+
+func (f *fakeImporter) Inspect(_ context.Context, _, _, _ string) (*detect.Settings, error) {
+	f.inspected++
+	if f.inspectErr != nil {
+		return nil, f.inspectErr
+	}
+	return &detect.Settings{
+		Builder:        "nodejs",
+		Framework:      "vite",
+		PackageManager: "pnpm",
+		InstallCommand: "pnpm install --frozen-lockfile",
+		BuildCommand:   "pnpm run build",
+		OutputDir:      "dist",
+		NodeVersion:    "20",
+	}, nil
+}
+
+func (f *fakeImporter) RegisterPushHook(_ context.Context, _, repoURL string) error {
+	if f.hookErr != nil {
+		return f.hookErr
+	}
+	f.hooks = append(f.hooks, repoURL)
+	return nil
+}
+
+func TestService_CreateProjectFromRepo(t *testing.T) {
+	importer := &fakeImporter{}
+	svc := NewService(newMockRepository(), fakeAdmins{}, importer, zap.NewNop())
+
+	result, err := svc.CreateProjectFromRepo(context.Background(), "alice", "",
+		"https://github.com/acme/Web_Shop.git", "main", true)
+	require.NoError(t, err)
+	assert.Equal(t, "web-shop", result.Project.Name)
+	assert.Equal(t, "nodejs", result.Project.Framework)
+	assert.Equal(t, "main", result.Project.Branch)
+	assert.Equal(t, "pnpm run build", result.Project.BuildCommand)
+	assert.Equal(t, "dist", result.Project.OutputDir)
+	assert.Equal(t, "vite", result.Settings.Framework)
+	assert.True(t, result.WebhookRegistered)
+	assert.Equal(t, []string{"https://github.com/acme/Web_Shop.git"}, importer.hooks)
+
+	// Taken names are rejected before cloning
+	_, err = svc.CreateProjectFromRepo(context.Background(), "alice", "web-shop",
+		"https://github.com/acme/web-shop.git", "", false)
+	assert.ErrorIs(t, err, ErrProjectExists)
+	assert.Equal(t, 1, importer.inspected)
+}
+
+func TestService_CreateProjectFromRepo_WebhookFailure(t *testing.T) {
+	importer := &fakeImporter{hookErr: errors.New("provider is not connected")}
+	svc := NewService(newMockRepository(), fakeAdmins{}, importer, zap.NewNop())
+
+	result, err := svc.CreateProjectFromRepo(context.Background(), "alice", "shop",
+		"git@github.com:acme/shop.git", "", true)
+	require.NoError(t, err)
+	assert.False(t, result.WebhookRegistered)
+	assert.Equal(t, "provider is not connected", result.WebhookMessage)
+
+	_, err = svc.GetProject("shop")
+	assert.NoError(t, err)
+}
+
+func TestService_CreateProjectFromRepo_InspectFailure(t *testing.T) {
+	importer := &fakeImporter{inspectErr: detect.ErrUnsupported}
+	svc := NewService(newMockRepository(), fakeAdmins{}, importer, zap.NewNop())
+
+	_, err := svc.CreateProjectFromRepo(context.Background(), "alice", "",
+		"https://github.com/acme/tool.git", "", false)
+	assert.ErrorIs(t, err, detect.ErrUnsupported)
+
+	_, err = svc.GetProject("tool")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+}
+
+func TestNameFromRepoURL(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/acme/web-shop.git": "web-shop",
+		"https://github.com/acme/Web_Shop/":    "web-shop",
+		"git@github.com:acme/My.App.git":       "my-app",
+		"ssh://git@example.com/team/api":       "api",
+	}
+	for repoURL, expected := range tests {
+		assert.Equal(t, expected, nameFromRepoURL(repoURL), repoURL)
+	}
+}
@@ -0,0 +1,158 @@
+package project
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+)
+
+// mockRepository keeps soft-deleted projects alongside live ones, like the
+// database does
+type mockRepository struct {
+	projects map[string]*Project
+	nextID   uint
+	mu       sync.RWMutex
+}
+
+func newMockRepository() *mockRepository {
+	return &mockRepository{
+		projects: make(map[string]*Project),
+	}
+}
+
+func (r *mockRepository) CreateProject(project *Project) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.projects[project.Name]; exists {
+		return ErrProjectExists
+	}
+
+	r.nextID++
+	project.ID = r.nextID
+	stored := *project
+	r.projects[project.Name] = &stored
+	return nil
+}
+
+func (r *mockRepository) GetProjectByName(name string) (*Project, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	project, exists := r.projects[name]
+	if !exists || project.DeletedAt.Valid {
+		return nil, ErrProjectNotFound
+	}
+	found := *project
+	return &found, nil
+}
+
+// ListProjects returns all matches on one page sorted by name
+func (r *mockRepository) ListProjects(owner string, params pagination.Params) (*pagination.Page[Project], error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	search := strings.ToLower(params.Search)
+	projects := make([]Project, 0, len(r.projects))
+	for _, project := range r.projects {
+		if project.DeletedAt.Valid {
+			continue
+		}
+		if owner != "" && project.Owner != owner {
+			continue
+		}
+		if search != "" && !strings.Contains(project.Name, search) && !strings.Contains(strings.ToLower(project.Owner), search) {
+			continue
+		}
+		projects = append(projects, *project)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].Name < projects[j].Name
+	})
+	return &pagination.Page[Project]{Items: projects}, nil
+}
+
+func (r *mockRepository) DeleteProject(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	project, exists := r.projects[name]
+	if !exists || project.DeletedAt.Valid {
+		return ErrProjectNotFound
+	}
+	project.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (r *mockRepository) GetDeletedProject(name string) (*Project, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	project, exists := r.projects[name]
+	if !exists || !project.DeletedAt.Valid {
+		return nil, ErrProjectNotFound
+	}
+	found := *project
+	return &found, nil
+}
+
+func (r *mockRepository) RestoreProject(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	project, exists := r.projects[name]
+	if !exists || !project.DeletedAt.Valid {
+		return ErrProjectNotFound
+	}
+	project.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (r *mockRepository) UpdateProject(project *Project) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.projects[project.Name]; !exists {
+		return ErrProjectNotFound
+	}
+	stored := *project
+	r.projects[project.Name] = &stored
+	return nil
+}
+
+func (r *mockRepository) ListExpiredProjects(deletedBefore time.Time) ([]Project, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var projects []Project
+	for _, project := range r.projects {
+		if project.DeletedAt.Valid && project.DeletedAt.Time.Before(deletedBefore) {
+			projects = append(projects, *project)
+		}
+	}
+	return projects, nil
+}
+
+func (r *mockRepository) PurgeProject(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, project := range r.projects {
+		if project.ID == id && project.DeletedAt.Valid {
+			delete(r.projects, name)
+		}
+	}
+	return nil
+}
+
+// deletedAgo backdates a soft delete for retention tests
+func (r *mockRepository) deletedAgo(name string, age time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.projects[name].DeletedAt = gorm.DeletedAt{Time: time.Now().Add(-age), Valid: true}
+}
@@ -0,0 +1,53 @@
+package project
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Project struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"uniqueIndex;not null"` // Slug used as the deployment name
+	Owner     string `gorm:"index;not null"`       // Username of the creator
+	RepoURL   string
+	Framework string `gorm:"not null;default:nodejs"`
+	// Build settings, proposed by detection for imported repositories
+	Branch         string
+	InstallCommand string
+	BuildCommand   string
+	OutputDir      string
+	NodeVersion    string
+	// BuildDedup is "queue" or "supersede" for rapid pushes to a branch,
+	// empty follows the pipeline default
+	BuildDedup string
+	// Pushes only trigger builds when the branch and a changed file match.
+	// Only SimulatePush applies them until push webhooks are received.
+	BranchFilters []string `gorm:"serializer:json"`
+	PathFilters   []string `gorm:"serializer:json"`
+	// StatusPage is StatusPagePublic, StatusPageToken or empty when the
+	// project has no public status page
+	StatusPage      string
+	StatusPageToken string
+	// Visibility decides who may read the project's builds and
+	// deployments through the read-only RPCs, private when empty
+	Visibility string `gorm:"not null;default:private"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+}
+
+func (Project) TableName() string {
+	return "projects"
+}
+
+const (
+	StatusPagePublic = "public" // Anyone may read the status page
+	StatusPageToken  = "token"  // Readers must present the project's status page token
+)
+
+const (
+	VisibilityPrivate  = "private"  // Only the owner and admins
+	VisibilityInternal = "internal" // Any signed-in user
+	VisibilityPublic   = "public"   // Anyone, without signing in
+)
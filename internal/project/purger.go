@@ -0,0 +1,119 @@
+package project
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const (
+	defaultDeletedRetention = 7 * 24 * time.Hour
+	defaultPurgeInterval    = time.Hour
+)
+
+// ResourceCleaner removes everything deployed or built for a project
+type ResourceCleaner interface {
+	PurgeProject(ctx context.Context, name string) error
+}
+
+// Purger permanently removes projects whose retention window has expired,
+// cleaning up their resources before deleting the database row.
+type Purger struct {
+	repository Repository
+	cleaner    ResourceCleaner
+	retention  time.Duration
+	interval   time.Duration
+	log        *zap.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPurger(repo Repository, cleaner ResourceCleaner, cfg *config.ProjectConfig, log *zap.Logger) *Purger {
+	retention := cfg.DeletedRetention
+	if retention <= 0 {
+		retention = defaultDeletedRetention
+	}
+	interval := cfg.PurgeInterval
+	if interval <= 0 {
+		interval = defaultPurgeInterval
+	}
+
+	return &Purger{
+		repository: repo,
+		cleaner:    cleaner,
+		retention:  retention,
+		interval:   interval,
+		log:        log,
+	}
+}
+
+// Start runs the purge job in the background until Stop is called
+func (p *Purger) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			p.PurgeExpired(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (p *Purger) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+}
+
+// PurgeExpired purges every project deleted longer ago than the retention
+// window and returns how many were removed. A project whose cleanup fails is
+// kept and retried on the next run.
+func (p *Purger) PurgeExpired(ctx context.Context) int {
+	projects, err := p.repository.ListExpiredProjects(time.Now().Add(-p.retention))
+	if err != nil {
+		p.log.Error("failed to list expired projects", zap.Error(err))
+		return 0
+	}
+
+	purged := 0
+	for _, project := range projects {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err := p.cleaner.PurgeProject(ctx, project.Name); err != nil {
+			p.log.Error("failed to clean up project resources",
+				zap.String("name", project.Name),
+				zap.Error(err))
+			continue
+		}
+		if err := p.repository.PurgeProject(project.ID); err != nil {
+			p.log.Error("failed to purge project",
+				zap.String("name", project.Name),
+				zap.Error(err))
+			continue
+		}
+
+		p.log.Info("project purged", zap.String("name", project.Name))
+		purged++
+	}
+	return purged
+}
@@ -0,0 +1,55 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+type fakeCleaner struct {
+	cleaned []string
+	failing map[string]bool
+}
+
+func (c *fakeCleaner) PurgeProject(_ context.Context, name string) error {
+	if c.failing[name] {
+		return errors.New("cluster unavailable")
+	}
+	c.cleaned = append(c.cleaned, name)
+	return nil
+}
+
+func TestPurger_PurgeExpired(t *testing.T) {
+	repo := newMockRepository()
+	for _, name := range []string{"expired-app", "recent-app", "live-app", "stuck-app"} {
+		require.NoError(t, repo.CreateProject(&Project{Name: name, Owner: "alice"}))
+	}
+	repo.deletedAgo("expired-app", 48*time.Hour)
+	repo.deletedAgo("stuck-app", 48*time.Hour)
+	repo.deletedAgo("recent-app", time.Hour)
+
+	cleaner := &fakeCleaner{failing: map[string]bool{"stuck-app": true}}
+	purger := NewPurger(repo, cleaner, &config.ProjectConfig{DeletedRetention: 24 * time.Hour}, zap.NewNop())
+
+	assert.Equal(t, 1, purger.PurgeExpired(context.Background()))
+	assert.Equal(t, []string{"expired-app"}, cleaner.cleaned)
+
+	_, err := repo.GetDeletedProject("expired-app")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+
+	// Failed cleanups keep the row so the next run retries
+	_, err = repo.GetDeletedProject("stuck-app")
+	assert.NoError(t, err)
+
+	_, err = repo.GetDeletedProject("recent-app")
+	assert.NoError(t, err)
+	_, err = repo.GetProjectByName("live-app")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,142 @@
+package project
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/elskow/chef-infra/internal/database"
+	"github.com/elskow/chef-infra/internal/pagination"
+)
+
+var (
+	ErrProjectNotFound = errors.New("project not found")
+	ErrProjectExists   = errors.New("project already exists")
+)
+
+type Repository interface {
+	CreateProject(project *Project) error
+	GetProjectByName(name string) (*Project, error)
+	ListProjects(owner string, params pagination.Params) (*pagination.Page[Project], error)
+	DeleteProject(name string) error
+	GetDeletedProject(name string) (*Project, error)
+	RestoreProject(name string) error
+	UpdateProject(project *Project) error
+	ListExpiredProjects(deletedBefore time.Time) ([]Project, error)
+	PurgeProject(id uint) error
+}
+
+var listSpec = pagination.Spec{
+	SortFields: map[string]string{
+		"name":       "name",
+		"created_at": "created_at",
+	},
+	DefaultSort:   "name",
+	SearchColumns: []string{"name", "owner"},
+}
+
+type repository struct {
+	db    *gorm.DB
+	reads database.ReadSource
+}
+
+// NewRepository creates a repository writing to db. List queries go through
+// reads, which may route them to a read replica.
+func NewRepository(db *gorm.DB, reads database.ReadSource) Repository {
+	return &repository{db: db, reads: reads}
+}
+
+func (r *repository) CreateProject(project *Project) error {
+	if err := r.db.Create(project).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrProjectExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *repository) GetProjectByName(name string) (*Project, error) {
+	var project Project
+	if err := r.db.Where("name = ?", name).First(&project).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	return &project, nil
+}
+
+// ListProjects returns a page of the owner's projects, or of all projects
+// when owner is empty
+func (r *repository) ListProjects(owner string, params pagination.Params) (*pagination.Page[Project], error) {
+	query := r.reads.ReadDB()
+	if owner != "" {
+		query = query.Where("owner = ?", owner)
+	}
+	return pagination.List[Project](query, params, listSpec)
+}
+
+func (r *repository) DeleteProject(name string) error {
+	result := r.db.Where("name = ?", name).Delete(&Project{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+// GetDeletedProject returns a soft-deleted project that has not been purged
+func (r *repository) GetDeletedProject(name string) (*Project, error) {
+	var project Project
+	err := r.db.Unscoped().
+		Where("name = ? AND deleted_at IS NOT NULL", name).
+		First(&project).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProjectNotFound
+		}
+		return nil, err
+	}
+	return &project, nil
+}
+
+func (r *repository) RestoreProject(name string) error {
+	result := r.db.Unscoped().Model(&Project{}).
+		Where("name = ? AND deleted_at IS NOT NULL", name).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+func (r *repository) UpdateProject(project *Project) error {
+	return r.db.Save(project).Error
+}
+
+// ListExpiredProjects returns soft-deleted projects deleted before the cutoff
+func (r *repository) ListExpiredProjects(deletedBefore time.Time) ([]Project, error) {
+	var projects []Project
+	err := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Order("deleted_at").
+		Find(&projects).Error
+	if err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// PurgeProject permanently deletes a soft-deleted project row
+func (r *repository) PurgeProject(id uint) error {
+	return r.db.Unscoped().
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Delete(&Project{}).Error
+}
@@ -0,0 +1,297 @@
+package project
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/trigger"
+)
+
+var (
+	ErrInvalidName       = errors.New("project name must be 3-63 lowercase letters, digits or hyphens")
+	ErrNameReserved      = errors.New("project name belongs to a deleted project that can still be restored")
+	ErrInvalidDedup      = errors.New("build_dedup must be queue, supersede or empty for the pipeline default")
+	ErrInvalidStatusPage = errors.New("status_page must be public, token or empty to disable it")
+	ErrInvalidVisibility = errors.New("visibility must be private, internal or public")
+
+	// Names double as Kubernetes resource names and hostnames
+	namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{1,61}[a-z0-9])$`)
+)
+
+// AdminChecker reports whether a user holds the admin role
+type AdminChecker interface {
+	IsAdmin(username string) (bool, error)
+}
+
+type Service struct {
+	repository Repository
+	admins     AdminChecker
+	importer   RepoImporter
+	log        *zap.Logger
+}
+
+func NewService(repo Repository, admins AdminChecker, importer RepoImporter, log *zap.Logger) *Service {
+	return &Service{
+		repository: repo,
+		admins:     admins,
+		importer:   importer,
+		log:        log,
+	}
+}
+
+func (s *Service) CreateProject(owner, name, repoURL, framework string) (*Project, error) {
+	if framework == "" {
+		framework = "nodejs"
+	}
+	if err := s.checkNameAvailable(name); err != nil {
+		return nil, err
+	}
+
+	project := &Project{
+		Name:       name,
+		Owner:      owner,
+		RepoURL:    repoURL,
+		Framework:  framework,
+		Visibility: VisibilityPrivate,
+	}
+	if err := s.repository.CreateProject(project); err != nil {
+		return nil, fmt.Errorf("failed to create project: %w", err)
+	}
+	return project, nil
+}
+
+// checkNameAvailable validates a new project name and makes sure no live
+// or restorable project holds it
+func (s *Service) checkNameAvailable(name string) error {
+	if !namePattern.MatchString(name) {
+		return ErrInvalidName
+	}
+
+	if _, err := s.repository.GetProjectByName(name); err == nil {
+		return ErrProjectExists
+	} else if !errors.Is(err, ErrProjectNotFound) {
+		return err
+	}
+
+	// The name stays reserved until the deleted project is purged
+	if _, err := s.repository.GetDeletedProject(name); err == nil {
+		return ErrNameReserved
+	} else if !errors.Is(err, ErrProjectNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *Service) GetProject(name string) (*Project, error) {
+	return s.repository.GetProjectByName(name)
+}
+
+// ListProjects returns every project for admins and owned projects otherwise
+func (s *Service) ListProjects(username string, params pagination.Params) (*pagination.Page[Project], error) {
+	isAdmin, err := s.admins.IsAdmin(username)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		return s.repository.ListProjects("", params)
+	}
+	return s.repository.ListProjects(username, params)
+}
+
+// DeleteProject soft-deletes a project. It can be restored until the purge
+// job removes it after the retention window.
+func (s *Service) DeleteProject(name string) error {
+	return s.repository.DeleteProject(name)
+}
+
+func (s *Service) RestoreProject(name string) (*Project, error) {
+	if err := s.repository.RestoreProject(name); err != nil {
+		return nil, err
+	}
+	return s.repository.GetProjectByName(name)
+}
+
+// Settings are the project options UpdateSettings replaces
+type Settings struct {
+	BuildDedup    string // queue or supersede, empty for the pipeline default
+	BranchFilters []string
+	PathFilters   []string
+	StatusPage    string // StatusPagePublic, StatusPageToken or empty
+	// RotateStatusPageToken replaces the token of a token-protected status page
+	RotateStatusPageToken bool
+	Visibility            string // VisibilityPrivate, VisibilityInternal or VisibilityPublic, private when empty
+}
+
+// UpdateSettings replaces the project's build trigger, status page and
+// visibility settings. A token is generated when the status page becomes
+// protected.
+func (s *Service) UpdateSettings(name string, settings Settings) (*Project, error) {
+	if !types.ValidDedupPolicy(types.DedupPolicy(settings.BuildDedup)) {
+		return nil, ErrInvalidDedup
+	}
+	if settings.StatusPage != "" && settings.StatusPage != StatusPagePublic && settings.StatusPage != StatusPageToken {
+		return nil, ErrInvalidStatusPage
+	}
+	visibility := settings.Visibility
+	switch visibility {
+	case "":
+		visibility = VisibilityPrivate
+	case VisibilityPrivate, VisibilityInternal, VisibilityPublic:
+	default:
+		return nil, ErrInvalidVisibility
+	}
+	filters := trigger.Filters{Branches: settings.BranchFilters, Paths: settings.PathFilters}
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+
+	project, err := s.repository.GetProjectByName(name)
+	if err != nil {
+		return nil, err
+	}
+	project.BuildDedup = settings.BuildDedup
+	project.BranchFilters = settings.BranchFilters
+	project.PathFilters = settings.PathFilters
+	project.StatusPage = settings.StatusPage
+	project.Visibility = visibility
+	switch {
+	case settings.StatusPage != StatusPageToken:
+		project.StatusPageToken = ""
+	case project.StatusPageToken == "" || settings.RotateStatusPageToken:
+		token, err := generateToken()
+		if err != nil {
+			return nil, err
+		}
+		project.StatusPageToken = token
+	}
+	if err := s.repository.UpdateProject(project); err != nil {
+		return nil, fmt.Errorf("failed to update project: %w", err)
+	}
+	return project, nil
+}
+
+// ShouldBuild applies the project's filters to a push. diff lists the
+// changed files when the push payload was truncated and may be nil. It is
+// meant for the receiver of push webhooks, which the server does not have
+// yet, so the filters do not stop any build today.
+func (s *Service) ShouldBuild(ctx context.Context, name string, push trigger.Push, diff trigger.DiffFunc) (trigger.Decision, error) {
+	project, err := s.repository.GetProjectByName(name)
+	if err != nil {
+		return trigger.Decision{}, err
+	}
+	return trigger.Evaluate(ctx, trigger.Filters{
+		Branches: project.BranchFilters,
+		Paths:    project.PathFilters,
+	}, push, diff)
+}
+
+// CanAccessProject reports whether the user is an admin or owns the project
+func (s *Service) CanAccessProject(username, name string) (bool, error) {
+	isAdmin, err := s.admins.IsAdmin(username)
+	if err != nil {
+		return false, err
+	}
+	if isAdmin {
+		return true, nil
+	}
+
+	project, err := s.repository.GetProjectByName(name)
+	if err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return project.Owner == username, nil
+}
+
+// OwnsProject reports whether the user owns the project. Unlike
+// CanAccessProject it makes no exception for admins.
+func (s *Service) OwnsProject(username, name string) (bool, error) {
+	project, err := s.repository.GetProjectByName(name)
+	if err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return project.Owner == username, nil
+}
+
+// CanReadStatusPage reports whether the project's status page is enabled
+// and readable with token, which is ignored for public pages
+func (s *Service) CanReadStatusPage(name, token string) (bool, error) {
+	project, err := s.repository.GetProjectByName(name)
+	if err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	switch project.StatusPage {
+	case StatusPagePublic:
+		return true, nil
+	case StatusPageToken:
+		return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(project.StatusPageToken)) == 1, nil
+	}
+	return false, nil
+}
+
+// CanReadProject reports whether the project's visibility lets the user
+// read its builds and deployments. Anonymous callers have no username.
+func (s *Service) CanReadProject(username, name string) (bool, error) {
+	project, err := s.repository.GetProjectByName(name)
+	if err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	switch {
+	case project.Visibility == VisibilityPublic:
+		return true, nil
+	case username == "":
+		return false, nil
+	case project.Visibility == VisibilityInternal:
+		return true, nil
+	}
+	return s.CanAccessProject(username, name)
+}
+
+// CanRestoreProject reports whether the user is an admin or owned the
+// deleted project
+func (s *Service) CanRestoreProject(username, name string) (bool, error) {
+	isAdmin, err := s.admins.IsAdmin(username)
+	if err != nil {
+		return false, err
+	}
+	if isAdmin {
+		return true, nil
+	}
+
+	project, err := s.repository.GetDeletedProject(name)
+	if err != nil {
+		if errors.Is(err, ErrProjectNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return project.Owner == username, nil
+}
+
+func generateToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate status page token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,285 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/trigger"
+)
+
+type fakeAdmins map[string]bool
+
+func (a fakeAdmins) IsAdmin(username string) (bool, error) {
+	return a[username], nil
+}
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	return NewService(newMockRepository(), fakeAdmins{"root": true}, &fakeImporter{}, zap.NewNop())
+}
+
+func TestService_CreateProject(t *testing.T) {
+	svc := newTestService(t)
+
+	tests := []struct {
+		name        string
+		projectName string
+		wantErr     error
+	}{
+		{name: "valid name", projectName: "my-shop"},
+		{name: "duplicate name", projectName: "my-shop", wantErr: ErrProjectExists},
+		{name: "uppercase", projectName: "MyShop", wantErr: ErrInvalidName},
+		{name: "too short", projectName: "ab", wantErr: ErrInvalidName},
+		{name: "trailing hyphen", projectName: "shop-", wantErr: ErrInvalidName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project, err := svc.CreateProject("alice", tt.projectName, "", "")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "alice", project.Owner)
+			assert.Equal(t, "nodejs", project.Framework)
+		})
+	}
+}
+
+func TestService_CanAccessProject(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "alice-app", "", "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		username string
+		project  string
+		want     bool
+	}{
+		{name: "owner", username: "alice", project: "alice-app", want: true},
+		{name: "admin", username: "root", project: "alice-app", want: true},
+		{name: "other user", username: "bob", project: "alice-app", want: false},
+		{name: "missing project", username: "alice", project: "missing", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := svc.CanAccessProject(tt.username, tt.project)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, allowed)
+		})
+	}
+}
+
+func TestService_OwnsProject(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "alice-app", "", "")
+	require.NoError(t, err)
+
+	owns, err := svc.OwnsProject("alice", "alice-app")
+	require.NoError(t, err)
+	assert.True(t, owns)
+
+	// Admins may access the project but do not own it
+	owns, err = svc.OwnsProject("root", "alice-app")
+	require.NoError(t, err)
+	assert.False(t, owns)
+
+	owns, err = svc.OwnsProject("alice", "missing")
+	require.NoError(t, err)
+	assert.False(t, owns)
+}
+
+func TestService_ListProjects(t *testing.T) {
+	svc := newTestService(t)
+	for _, p := range []struct{ owner, name string }{{"alice", "alice-app"}, {"bob", "bob-app"}} {
+		_, err := svc.CreateProject(p.owner, p.name, "", "")
+		require.NoError(t, err)
+	}
+
+	page, err := svc.ListProjects("alice", pagination.Params{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "alice-app", page.Items[0].Name)
+
+	page, err = svc.ListProjects("root", pagination.Params{})
+	require.NoError(t, err)
+	assert.Len(t, page.Items, 2)
+
+	page, err = svc.ListProjects("root", pagination.Params{Search: "BOB"})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "bob-app", page.Items[0].Name)
+}
+
+func TestService_DeleteAndRestoreProject(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "alice-app", "", "")
+	require.NoError(t, err)
+
+	require.NoError(t, svc.DeleteProject("alice-app"))
+
+	// Deleted projects disappear from normal queries
+	_, err = svc.GetProject("alice-app")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+	page, err := svc.ListProjects("alice", pagination.Params{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Items)
+
+	// The name stays reserved while the project can be restored
+	_, err = svc.CreateProject("bob", "alice-app", "", "")
+	assert.ErrorIs(t, err, ErrNameReserved)
+
+	allowed, err := svc.CanRestoreProject("alice", "alice-app")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = svc.CanRestoreProject("bob", "alice-app")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	project, err := svc.RestoreProject("alice-app")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", project.Owner)
+
+	_, err = svc.RestoreProject("alice-app")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+}
+
+func TestService_UpdateSettings(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "alice-app", "", "")
+	require.NoError(t, err)
+
+	project, err := svc.UpdateSettings("alice-app", Settings{
+		BuildDedup:    "supersede",
+		BranchFilters: []string{"main", "release/*"},
+		PathFilters:   []string{"web/**"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "supersede", project.BuildDedup)
+	assert.Equal(t, []string{"main", "release/*"}, project.BranchFilters)
+
+	_, err = svc.UpdateSettings("alice-app", Settings{BuildDedup: "latest"})
+	assert.ErrorIs(t, err, ErrInvalidDedup)
+
+	_, err = svc.UpdateSettings("alice-app", Settings{PathFilters: []string{"web/[a"}})
+	assert.ErrorIs(t, err, trigger.ErrInvalidPattern)
+
+	_, err = svc.UpdateSettings("missing", Settings{BuildDedup: "queue"})
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+}
+
+func TestService_StatusPage(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "alice-app", "", "")
+	require.NoError(t, err)
+
+	allowed, err := svc.CanReadStatusPage("alice-app", "")
+	require.NoError(t, err)
+	assert.False(t, allowed, "status pages are disabled by default")
+
+	_, err = svc.UpdateSettings("alice-app", Settings{StatusPage: "private"})
+	assert.ErrorIs(t, err, ErrInvalidStatusPage)
+
+	project, err := svc.UpdateSettings("alice-app", Settings{StatusPage: StatusPageToken})
+	require.NoError(t, err)
+	token := project.StatusPageToken
+	require.Len(t, token, 48)
+
+	allowed, _ = svc.CanReadStatusPage("alice-app", token)
+	assert.True(t, allowed)
+	allowed, _ = svc.CanReadStatusPage("alice-app", "")
+	assert.False(t, allowed)
+
+	// The token survives other changes until rotated
+	project, err = svc.UpdateSettings("alice-app", Settings{StatusPage: StatusPageToken, BuildDedup: "queue"})
+	require.NoError(t, err)
+	assert.Equal(t, token, project.StatusPageToken)
+	project, err = svc.UpdateSettings("alice-app", Settings{StatusPage: StatusPageToken, RotateStatusPageToken: true})
+	require.NoError(t, err)
+	assert.NotEqual(t, token, project.StatusPageToken)
+
+	project, err = svc.UpdateSettings("alice-app", Settings{StatusPage: StatusPagePublic})
+	require.NoError(t, err)
+	assert.Empty(t, project.StatusPageToken)
+	allowed, _ = svc.CanReadStatusPage("alice-app", "")
+	assert.True(t, allowed)
+
+	allowed, err = svc.CanReadStatusPage("missing", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestService_ShouldBuild(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "monorepo", "", "")
+	require.NoError(t, err)
+	_, err = svc.UpdateSettings("monorepo", Settings{
+		BranchFilters: []string{"main"},
+		PathFilters:   []string{"web/**"},
+	})
+	require.NoError(t, err)
+
+	push := trigger.Push{
+		Ref:           "refs/heads/main",
+		Before:        "1111111111111111111111111111111111111111",
+		ChangedPaths:  []string{"api/main.go"},
+		PathsComplete: true,
+	}
+	decision, err := svc.ShouldBuild(context.Background(), "monorepo", push, nil)
+	require.NoError(t, err)
+	assert.False(t, decision.Build)
+
+	push.ChangedPaths = append(push.ChangedPaths, "web/app.ts")
+	decision, err = svc.ShouldBuild(context.Background(), "monorepo", push, nil)
+	require.NoError(t, err)
+	assert.True(t, decision.Build)
+}
+
+func TestService_Visibility(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "alice-app", "", "")
+	require.NoError(t, err)
+
+	_, err = svc.UpdateSettings("alice-app", Settings{Visibility: "everyone"})
+	assert.ErrorIs(t, err, ErrInvalidVisibility)
+
+	canRead := func(username string) bool {
+		allowed, err := svc.CanReadProject(username, "alice-app")
+		require.NoError(t, err)
+		return allowed
+	}
+
+	// Projects are private by default
+	assert.True(t, canRead("alice"))
+	assert.True(t, canRead("root"))
+	assert.False(t, canRead("bob"))
+	assert.False(t, canRead(""))
+
+	project, err := svc.UpdateSettings("alice-app", Settings{Visibility: VisibilityInternal})
+	require.NoError(t, err)
+	assert.Equal(t, VisibilityInternal, project.Visibility)
+	assert.True(t, canRead("bob"))
+	assert.False(t, canRead(""), "internal projects need a signed-in user")
+
+	_, err = svc.UpdateSettings("alice-app", Settings{Visibility: VisibilityPublic})
+	require.NoError(t, err)
+	assert.True(t, canRead(""))
+
+	// Settings replace the visibility, so leaving it out makes it private
+	project, err = svc.UpdateSettings("alice-app", Settings{BuildDedup: "queue"})
+	require.NoError(t, err)
+	assert.Equal(t, VisibilityPrivate, project.Visibility)
+
+	allowed, err := svc.CanReadProject("", "missing")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
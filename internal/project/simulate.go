@@ -0,0 +1,108 @@
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/github"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/trigger"
+)
+
+var ErrInvalidPush = errors.New("a push payload, or a ref and commit, are required")
+
+// PushSimulation describes a push to evaluate against a project. Payload,
+// a push webhook body as the provider delivered it, replaces the other
+// fields.
+type PushSimulation struct {
+	Payload      []byte
+	Ref          string // Branch or full ref, e.g. main or refs/tags/v1.2.0
+	Commit       string
+	Before       string   // Empty for a new branch, which path filters don't apply to
+	ChangedPaths []string // The complete list of changed files
+}
+
+// SimulatedPush is what a push would do to a project
+type SimulatedPush struct {
+	Push       trigger.Push
+	Commit     *types.CommitInfo
+	Repository string // owner/name of the payload, empty without one
+	Decision   trigger.Decision
+}
+
+// SimulatePush evaluates a push against the project the way a delivered
+// push webhook is, without building anything, so webhook to build mapping
+// issues can be debugged without pushing commits
+func (s *Service) SimulatePush(ctx context.Context, name string, sim PushSimulation) (*SimulatedPush, error) {
+	project, err := s.repository.GetProjectByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := simulatedEvent(sim)
+	if err != nil {
+		return nil, err
+	}
+	result := &SimulatedPush{
+		Push:       event.Push(),
+		Commit:     event.Commit(),
+		Repository: event.Repository.FullName,
+	}
+	if sim.Payload == nil {
+		result.Push.ChangedPaths = sim.ChangedPaths
+		result.Push.PathsComplete = true
+	}
+
+	if result.Repository != "" && project.RepoURL != "" && !sameRepository(result.Repository, project.RepoURL) {
+		result.Decision = trigger.Decision{
+			Reason: fmt.Sprintf("push is for %s, the project builds %s", result.Repository, project.RepoURL),
+		}
+		return result, nil
+	}
+
+	result.Decision, err = trigger.Evaluate(ctx, trigger.Filters{
+		Branches: project.BranchFilters,
+		Paths:    project.PathFilters,
+	}, result.Push, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func simulatedEvent(sim PushSimulation) (*github.PushEvent, error) {
+	if sim.Payload != nil {
+		event, err := github.ParsePushEvent(sim.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPush, err)
+		}
+		return event, nil
+	}
+	if sim.Ref == "" || sim.Commit == "" {
+		return nil, ErrInvalidPush
+	}
+
+	ref := sim.Ref
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+	return &github.PushEvent{Ref: ref, Before: sim.Before, After: sim.Commit}, nil
+}
+
+// sameRepository reports whether a repository (owner/name) is the one a
+// project's clone URL points to
+func sameRepository(repository, repoURL string) bool {
+	raw := repoURL
+	if parsed, err := url.Parse(repoURL); err == nil && parsed.Path != "" {
+		raw = parsed.Path
+	}
+	// scp-like URLs separate the host with a colon
+	if i := strings.LastIndex(raw, ":"); i >= 0 {
+		raw = raw[i+1:]
+	}
+	raw = strings.Trim(strings.TrimSuffix(strings.TrimSuffix(raw, "/"), ".git"), "/")
+	return strings.EqualFold(raw, repository) || strings.HasSuffix(strings.ToLower(raw), "/"+strings.ToLower(repository))
+}
@@ -0,0 +1,83 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pushPayload = `{
+	"ref": "refs/heads/main",
+	"before": "1111111111111111111111111111111111111111",
+	"after": "2222222222222222222222222222222222222222",
+	"repository": {"full_name": "acme/monorepo"},
+	"commits": [{"modified": ["api/main.go"]}],
+	"head_commit": {"id": "2222222222222222222222222222222222222222", "message": "Fix api", "author": {"name": "Alice"}}
+}`
+
+func TestService_SimulatePush(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.CreateProject("alice", "monorepo", "git@github.com:acme/monorepo.git", "")
+	require.NoError(t, err)
+	_, err = svc.UpdateSettings("monorepo", Settings{
+		BranchFilters: []string{"main"},
+		PathFilters:   []string{"web/**"},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("payload", func(t *testing.T) {
+		result, err := svc.SimulatePush(ctx, "monorepo", PushSimulation{Payload: []byte(pushPayload)})
+		require.NoError(t, err)
+		assert.False(t, result.Decision.Build)
+		assert.Equal(t, "no changed files match the path filters", result.Decision.Reason)
+		assert.Equal(t, "acme/monorepo", result.Repository)
+		assert.Equal(t, "main", result.Commit.Branch)
+		assert.Equal(t, "Alice", result.Commit.Author)
+		assert.Equal(t, []string{"api/main.go"}, result.Push.ChangedPaths)
+	})
+
+	t.Run("other repository", func(t *testing.T) {
+		payload := []byte(`{"ref": "refs/heads/main", "after": "2222", "repository": {"full_name": "acme/other"}}`)
+		result, err := svc.SimulatePush(ctx, "monorepo", PushSimulation{Payload: payload})
+		require.NoError(t, err)
+		assert.False(t, result.Decision.Build)
+		assert.Contains(t, result.Decision.Reason, "push is for acme/other")
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		result, err := svc.SimulatePush(ctx, "monorepo", PushSimulation{
+			Ref:          "main",
+			Commit:       "2222222222222222222222222222222222222222",
+			Before:       "1111111111111111111111111111111111111111",
+			ChangedPaths: []string{"web/app.ts"},
+		})
+		require.NoError(t, err)
+		assert.True(t, result.Decision.Build)
+		assert.Equal(t, "refs/heads/main", result.Push.Ref)
+
+		result, err = svc.SimulatePush(ctx, "monorepo", PushSimulation{Ref: "release/1.0", Commit: "3333"})
+		require.NoError(t, err)
+		assert.False(t, result.Decision.Build)
+		assert.Equal(t, "refs/heads/release/1.0 does not match the branch filters", result.Decision.Reason)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := svc.SimulatePush(ctx, "monorepo", PushSimulation{Ref: "main"})
+		assert.ErrorIs(t, err, ErrInvalidPush)
+		_, err = svc.SimulatePush(ctx, "monorepo", PushSimulation{Payload: []byte(`{}`)})
+		assert.ErrorIs(t, err, ErrInvalidPush)
+		_, err = svc.SimulatePush(ctx, "missing", PushSimulation{Ref: "main", Commit: "2222"})
+		assert.ErrorIs(t, err, ErrProjectNotFound)
+	})
+}
+
+func TestSameRepository(t *testing.T) {
+	assert.True(t, sameRepository("acme/site", "https://github.com/acme/site.git"))
+	assert.True(t, sameRepository("acme/site", "git@github.com:Acme/site.git"))
+	assert.True(t, sameRepository("acme/site", "https://github.com/acme/site/"))
+	assert.False(t, sameRepository("acme/site", "https://github.com/evilacme/site"))
+	assert.False(t, sameRepository("acme/site", "https://github.com/acme/site-docs"))
+}
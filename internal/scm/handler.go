@@ -0,0 +1,150 @@
+package scm
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	pb "github.com/elskow/chef-infra/proto/gen/scm"
+)
+
+type Handler struct {
+	pb.UnimplementedSourceControlServer
+	service *Service
+	log     *zap.Logger
+}
+
+func NewHandler(service *Service, log *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		log:     log,
+	}
+}
+
+func (h *Handler) ConnectProvider(ctx context.Context, req *pb.ConnectProviderRequest) (*pb.ConnectProviderResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if req.AccessToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "access_token is required")
+	}
+
+	connection, err := h.service.Connect(ctx, username, req.Provider, req.AccessToken)
+	if err != nil {
+		if errors.Is(err, ErrTokenRejected) {
+			return nil, status.Error(codes.InvalidArgument, "provider rejected the access token")
+		}
+		return nil, h.toStatus(err, "failed to connect provider", req.Provider)
+	}
+
+	h.log.Info("provider connected",
+		zap.String("username", username),
+		zap.String("provider", connection.Provider),
+		zap.String("account", connection.AccountLogin))
+
+	return &pb.ConnectProviderResponse{Connection: toProto(connection)}, nil
+}
+
+func (h *Handler) DisconnectProvider(ctx context.Context, req *pb.DisconnectProviderRequest) (*pb.DisconnectProviderResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	if err := h.service.Disconnect(username, req.Provider); err != nil {
+		return nil, h.toStatus(err, "failed to disconnect provider", req.Provider)
+	}
+
+	return &pb.DisconnectProviderResponse{Success: true, Message: "Provider disconnected"}, nil
+}
+
+func (h *Handler) ListConnections(ctx context.Context, _ *pb.ListConnectionsRequest) (*pb.ListConnectionsResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	connections, err := h.service.ListConnections(username)
+	if err != nil {
+		h.log.Error("failed to list connections", zap.String("username", username), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list connections")
+	}
+
+	resp := &pb.ListConnectionsResponse{}
+	for i := range connections {
+		resp.Connections = append(resp.Connections, toProto(&connections[i]))
+	}
+	return resp, nil
+}
+
+func (h *Handler) ListRepositories(ctx context.Context, req *pb.ListRepositoriesRequest) (*pb.ListRepositoriesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	repos, next, err := h.service.ListRepositories(ctx, username, req.Provider, req.PageToken, int(req.PageSize))
+	if err != nil {
+		return nil, h.toStatus(err, "failed to list repositories", req.Provider)
+	}
+
+	resp := &pb.ListRepositoriesResponse{NextPageToken: next}
+	for _, repo := range repos {
+		resp.Repositories = append(resp.Repositories, &pb.RepositoryInfo{
+			FullName:      repo.FullName,
+			CloneUrl:      repo.CloneURL,
+			DefaultBranch: repo.DefaultBranch,
+			Private:       repo.Private,
+			Description:   repo.Description,
+		})
+	}
+	return resp, nil
+}
+
+func (h *Handler) ListBranches(ctx context.Context, req *pb.ListBranchesRequest) (*pb.ListBranchesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if req.Repository == "" {
+		return nil, status.Error(codes.InvalidArgument, "repository is required")
+	}
+
+	branches, err := h.service.ListBranches(ctx, username, req.Provider, req.Repository)
+	if err != nil {
+		return nil, h.toStatus(err, "failed to list branches", req.Provider)
+	}
+
+	resp := &pb.ListBranchesResponse{}
+	for _, branch := range branches {
+		resp.Branches = append(resp.Branches, &pb.BranchInfo{Name: branch.Name, CommitSha: branch.CommitSHA})
+	}
+	return resp, nil
+}
+
+// toStatus maps service errors to gRPC codes, logging unexpected ones
+func (h *Handler) toStatus(err error, msg, provider string) error {
+	switch {
+	case errors.Is(err, ErrUnknownProvider), errors.Is(err, ErrInvalidPageToken):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNotConnected):
+		return status.Error(codes.FailedPrecondition, "provider is not connected")
+	case errors.Is(err, ErrTokenRejected):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	h.log.Error(msg, zap.String("provider", provider), zap.Error(err))
+	return status.Error(codes.Internal, msg)
+}
+
+func toProto(connection *Connection) *pb.ConnectionInfo {
+	return &pb.ConnectionInfo{
+		Provider:     connection.Provider,
+		AccountLogin: connection.AccountLogin,
+		ConnectedAt:  connection.UpdatedAt.Unix(),
+	}
+}
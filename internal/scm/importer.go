@@ -0,0 +1,127 @@
+package scm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/detect"
+	"github.com/elskow/chef-infra/internal/pipeline/source"
+)
+
+const cloneTimeout = 2 * time.Minute
+
+var (
+	ErrHookNotConfigured = errors.New("push webhook URL is not configured")
+	ErrUnsupportedHost   = errors.New("repository is not hosted on a supported provider")
+)
+
+// Importer inspects repositories for new projects and registers push
+// webhooks on them. It implements project.RepoImporter.
+type Importer struct {
+	service    *Service
+	fetcher    *source.Fetcher
+	hookURL    string
+	hookSecret string
+	log        *zap.Logger
+}
+
+func NewImporter(service *Service, fetcher *source.Fetcher, hookURL, hookSecret string, log *zap.Logger) *Importer {
+	return &Importer{
+		service:    service,
+		fetcher:    fetcher,
+		hookURL:    hookURL,
+		hookSecret: hookSecret,
+		log:        log,
+	}
+}
+
+// Inspect shallow-clones the repository and proposes build settings. The
+// user's provider connection authenticates the clone when there is one so
+// private repositories can be imported, and reports the repository's size
+// so one above the size limit is not cloned at all.
+func (i *Importer) Inspect(ctx context.Context, username, repoURL, ref string) (*detect.Settings, error) {
+	if err := source.ValidateURL(repoURL); err != nil {
+		return nil, err
+	}
+
+	token := ""
+	var size int64
+	if provider, connection, repository, err := i.service.connectionFor(username, repoURL); err == nil {
+		token = connection.AccessToken
+		if size, err = provider.RepositorySize(ctx, token, repository); err != nil {
+			// The clone is still bounded by the size limit
+			i.log.Warn("failed to read repository size",
+				zap.String("provider", provider.Name()),
+				zap.String("repository", repository),
+				zap.Error(err))
+		}
+	} else if !errors.Is(err, ErrNotConnected) && !errors.Is(err, ErrUnsupportedHost) {
+		return nil, err
+	}
+
+	workDir, err := os.MkdirTemp("", "chef-import-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	ctx, cancel := context.WithTimeout(ctx, cloneTimeout)
+	defer cancel()
+
+	dir := filepath.Join(workDir, "repo")
+	if _, err := i.fetcher.Clone(ctx, dir, source.CloneOptions{
+		URL:   repoURL,
+		Ref:   ref,
+		Depth: 1,
+		Token: token,
+		Size:  size,
+	}); err != nil {
+		return nil, err
+	}
+	return detect.Detect(dir)
+}
+
+// RegisterPushHook creates a push webhook on the repository with the
+// user's provider connection
+func (i *Importer) RegisterPushHook(ctx context.Context, username, repoURL string) error {
+	if i.hookURL == "" {
+		return ErrHookNotConfigured
+	}
+
+	provider, connection, repository, err := i.service.connectionFor(username, repoURL)
+	if err != nil {
+		return err
+	}
+	if err := provider.CreateHook(ctx, connection.AccessToken, repository, i.hookURL, i.hookSecret); err != nil {
+		return fmt.Errorf("failed to create webhook on %s: %w", provider.Name(), err)
+	}
+
+	i.log.Info("push webhook registered",
+		zap.String("username", username),
+		zap.String("provider", provider.Name()),
+		zap.String("repository", repository))
+	return nil
+}
+
+// connectionFor finds the provider hosting repoURL and the user's
+// connection to it
+func (s *Service) connectionFor(username, repoURL string) (Provider, *Connection, string, error) {
+	for _, provider := range s.providers {
+		repository, ok := provider.ParseRepositoryURL(repoURL)
+		if !ok {
+			continue
+		}
+		connection, err := s.repository.GetConnection(username, provider.Name())
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return provider, connection, repository, nil
+	}
+	return nil, nil, "", ErrUnsupportedHost
+}
@@ -0,0 +1,68 @@
+package scm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type mockRepository struct {
+	connections map[string]*Connection // Keyed by username/provider
+	mu          sync.RWMutex
+}
+
+func newMockRepository() *mockRepository {
+	return &mockRepository{
+		connections: make(map[string]*Connection),
+	}
+}
+
+func (r *mockRepository) SaveConnection(connection *Connection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	connection.UpdatedAt = time.Now()
+	stored := *connection
+	r.connections[connection.Username+"/"+connection.Provider] = &stored
+	return nil
+}
+
+func (r *mockRepository) GetConnection(username, provider string) (*Connection, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	connection, exists := r.connections[username+"/"+provider]
+	if !exists {
+		return nil, ErrNotConnected
+	}
+	found := *connection
+	return &found, nil
+}
+
+func (r *mockRepository) ListConnections(username string) ([]Connection, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var connections []Connection
+	for _, connection := range r.connections {
+		if connection.Username == username {
+			connections = append(connections, *connection)
+		}
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].Provider < connections[j].Provider
+	})
+	return connections, nil
+}
+
+func (r *mockRepository) DeleteConnection(username, provider string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := username + "/" + provider
+	if _, exists := r.connections[key]; !exists {
+		return ErrNotConnected
+	}
+	delete(r.connections, key)
+	return nil
+}
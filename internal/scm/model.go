@@ -0,0 +1,18 @@
+package scm
+
+import "time"
+
+// Connection links a chef user to their account on a git provider
+type Connection struct {
+	ID           uint   `gorm:"primaryKey"`
+	Username     string `gorm:"uniqueIndex:idx_provider_connections_user_provider;not null"`
+	Provider     string `gorm:"uniqueIndex:idx_provider_connections_user_provider;not null"`
+	AccountLogin string `gorm:"not null"` // Username on the provider
+	AccessToken  string `gorm:"not null"` // OAuth or personal access token, never returned by the API; sealed at rest once secrets.master_key is set
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (Connection) TableName() string {
+	return "provider_connections"
+}
@@ -0,0 +1,133 @@
+package scm
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/elskow/chef-infra/internal/github"
+)
+
+// ErrTokenRejected is returned when the provider no longer accepts a
+// stored token, e.g. after the user revoked it
+var ErrTokenRejected = errors.New("provider rejected the stored token, reconnect the provider")
+
+type Repository struct {
+	FullName      string // owner/name
+	CloneURL      string
+	DefaultBranch string
+	Private       bool
+	Description   string
+}
+
+type Branch struct {
+	Name      string
+	CommitSHA string
+}
+
+// Provider lists repositories from a git hosting service
+type Provider interface {
+	Name() string
+	// Account returns the login the token belongs to
+	Account(ctx context.Context, token string) (string, error)
+	// ListRepositories returns a page of repositories and the token of the
+	// next page, empty on the last page
+	ListRepositories(ctx context.Context, token, pageToken string, pageSize int) ([]Repository, string, error)
+	ListBranches(ctx context.Context, token, repository string) ([]Branch, error)
+	// RepositorySize returns the size of the repository in bytes
+	RepositorySize(ctx context.Context, token, repository string) (int64, error)
+	// ParseRepositoryURL returns the repository a clone URL points to when
+	// the provider hosts it
+	ParseRepositoryURL(url string) (string, bool)
+	// CreateHook registers a push webhook on the repository
+	CreateHook(ctx context.Context, token, repository, hookURL, secret string) error
+}
+
+type githubProvider struct {
+	client *github.Client
+}
+
+func NewGitHubProvider(apiURL string) Provider {
+	return &githubProvider{client: github.NewClient(apiURL)}
+}
+
+func (p *githubProvider) Name() string {
+	return github.ProviderName
+}
+
+func (p *githubProvider) Account(ctx context.Context, token string) (string, error) {
+	user, err := p.client.AuthenticatedUser(ctx, token)
+	if err != nil {
+		return "", githubError(err)
+	}
+	return user.Login, nil
+}
+
+func (p *githubProvider) ListRepositories(ctx context.Context, token, pageToken string, pageSize int) ([]Repository, string, error) {
+	page := 1
+	if pageToken != "" {
+		parsed, err := strconv.Atoi(pageToken)
+		if err != nil || parsed < 1 {
+			return nil, "", ErrInvalidPageToken
+		}
+		page = parsed
+	}
+
+	repos, next, err := p.client.ListRepositories(ctx, token, page, pageSize)
+	if err != nil {
+		return nil, "", githubError(err)
+	}
+
+	result := make([]Repository, 0, len(repos))
+	for _, repo := range repos {
+		result = append(result, Repository{
+			FullName:      repo.FullName,
+			CloneURL:      repo.CloneURL,
+			DefaultBranch: repo.DefaultBranch,
+			Private:       repo.Private,
+			Description:   repo.Description,
+		})
+	}
+
+	nextToken := ""
+	if next > 0 {
+		nextToken = strconv.Itoa(next)
+	}
+	return result, nextToken, nil
+}
+
+func (p *githubProvider) ListBranches(ctx context.Context, token, repository string) ([]Branch, error) {
+	branches, err := p.client.ListBranches(ctx, token, repository)
+	if err != nil {
+		return nil, githubError(err)
+	}
+
+	result := make([]Branch, 0, len(branches))
+	for _, branch := range branches {
+		result = append(result, Branch{Name: branch.Name, CommitSHA: branch.Commit.SHA})
+	}
+	return result, nil
+}
+
+func (p *githubProvider) RepositorySize(ctx context.Context, token, repository string) (int64, error) {
+	repo, err := p.client.GetRepository(ctx, token, repository)
+	if err != nil {
+		return 0, githubError(err)
+	}
+	return repo.Size * 1024, nil
+}
+
+func (p *githubProvider) ParseRepositoryURL(url string) (string, bool) {
+	return github.ParseRepositoryURL(url)
+}
+
+func (p *githubProvider) CreateHook(ctx context.Context, token, repository, hookURL, secret string) error {
+	return githubError(p.client.CreateHook(ctx, token, repository, hookURL, secret))
+}
+
+func githubError(err error) error {
+	if errors.Is(err, github.ErrUnauthorized) {
+		return ErrTokenRejected
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package scm
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNotConnected = errors.New("provider is not connected")
+
+type ConnectionRepository interface {
+	// SaveConnection creates or replaces the user's connection to a provider
+	SaveConnection(connection *Connection) error
+	GetConnection(username, provider string) (*Connection, error)
+	ListConnections(username string) ([]Connection, error)
+	DeleteConnection(username, provider string) error
+}
+
+// Cipher encrypts access tokens at rest. secrets.Keyring stores them as
+// they are until a master key is configured.
+type Cipher interface {
+	Seal(plaintext string) (string, error)
+	Open(value string) (string, error)
+}
+
+type repository struct {
+	db     *gorm.DB
+	cipher Cipher
+}
+
+func NewRepository(db *gorm.DB, cipher Cipher) ConnectionRepository {
+	return &repository{db: db, cipher: cipher}
+}
+
+func (r *repository) SaveConnection(connection *Connection) error {
+	stored := *connection
+	sealed, err := r.cipher.Seal(connection.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt access token: %w", err)
+	}
+	stored.AccessToken = sealed
+
+	err = r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "username"}, {Name: "provider"}},
+		DoUpdates: clause.AssignmentColumns([]string{"account_login", "access_token", "updated_at"}),
+	}).Create(&stored).Error
+	if err != nil {
+		return err
+	}
+	stored.AccessToken = connection.AccessToken
+	*connection = stored
+	return nil
+}
+
+func (r *repository) GetConnection(username, provider string) (*Connection, error) {
+	var connection Connection
+	err := r.db.Where("username = ? AND provider = ?", username, provider).First(&connection).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotConnected
+		}
+		return nil, err
+	}
+	if err := r.open(&connection); err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+func (r *repository) ListConnections(username string) ([]Connection, error) {
+	var connections []Connection
+	if err := r.db.Where("username = ?", username).Order("provider").Find(&connections).Error; err != nil {
+		return nil, err
+	}
+	for i := range connections {
+		if err := r.open(&connections[i]); err != nil {
+			return nil, err
+		}
+	}
+	return connections, nil
+}
+
+func (r *repository) open(connection *Connection) error {
+	token, err := r.cipher.Open(connection.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt access token of %s: %w", connection.Provider, err)
+	}
+	connection.AccessToken = token
+	return nil
+}
+
+func (r *repository) DeleteConnection(username, provider string) error {
+	result := r.db.Where("username = ? AND provider = ?", username, provider).Delete(&Connection{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotConnected
+	}
+	return nil
+}
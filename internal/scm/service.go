@@ -0,0 +1,114 @@
+package scm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPageSize = 30
+	maxPageSize     = 100
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown git provider")
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+// Service browses repositories through the user's provider connections
+type Service struct {
+	repository ConnectionRepository
+	providers  map[string]Provider
+	log        *zap.Logger
+}
+
+func NewService(repo ConnectionRepository, log *zap.Logger, providers ...Provider) *Service {
+	byName := make(map[string]Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+	return &Service{
+		repository: repo,
+		providers:  byName,
+		log:        log,
+	}
+}
+
+// Connect verifies the token with the provider and stores it, replacing
+// any previous connection
+func (s *Service) Connect(ctx context.Context, username, providerName, token string) (*Connection, error) {
+	provider, err := s.provider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	login, err := provider.Account(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &Connection{
+		Username:     username,
+		Provider:     providerName,
+		AccountLogin: login,
+		AccessToken:  token,
+	}
+	if err := s.repository.SaveConnection(connection); err != nil {
+		return nil, fmt.Errorf("failed to save connection: %w", err)
+	}
+	return connection, nil
+}
+
+func (s *Service) Disconnect(username, providerName string) error {
+	return s.repository.DeleteConnection(username, providerName)
+}
+
+func (s *Service) ListConnections(username string) ([]Connection, error) {
+	return s.repository.ListConnections(username)
+}
+
+func (s *Service) ListRepositories(ctx context.Context, username, providerName, pageToken string, pageSize int) ([]Repository, string, error) {
+	provider, connection, err := s.connection(username, providerName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return provider.ListRepositories(ctx, connection.AccessToken, pageToken, pageSize)
+}
+
+func (s *Service) ListBranches(ctx context.Context, username, providerName, repository string) ([]Branch, error) {
+	provider, connection, err := s.connection(username, providerName)
+	if err != nil {
+		return nil, err
+	}
+	return provider.ListBranches(ctx, connection.AccessToken, repository)
+}
+
+func (s *Service) provider(name string) (Provider, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+func (s *Service) connection(username, providerName string) (Provider, *Connection, error) {
+	provider, err := s.provider(providerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	connection, err := s.repository.GetConnection(username, providerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return provider, connection, nil
+}
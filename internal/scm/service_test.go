@@ -0,0 +1,149 @@
+package scm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeGitHub serves two pages of repositories to the "valid" token
+func fakeGitHub(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "token valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			json.NewEncoder(w).Encode(map[string]string{"login": "octocat"})
+		}
+	})
+	mux.HandleFunc("/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		page := r.URL.Query().Get("page")
+		if page == "1" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/user/repos?page=2>; rel="next"`, "http://"+r.Host))
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"full_name": "octocat/repo-" + page, "clone_url": "https://github.com/octocat/repo-" + page + ".git", "default_branch": "main"},
+		})
+	})
+	mux.HandleFunc("/repos/octocat/repo-1/hooks", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+	mux.HandleFunc("/repos/octocat/repo-1/branches", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"name": "main", "commit": map[string]string{"sha": "abc"}},
+				{"name": "develop", "commit": map[string]string{"sha": "def"}},
+			})
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestService_Connect(t *testing.T) {
+	server := fakeGitHub(t)
+	svc := NewService(newMockRepository(), zap.NewNop(), NewGitHubProvider(server.URL))
+
+	_, err := svc.Connect(context.Background(), "alice", "github", "revoked")
+	assert.ErrorIs(t, err, ErrTokenRejected)
+
+	_, err = svc.Connect(context.Background(), "alice", "bitbucket", "valid")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	connection, err := svc.Connect(context.Background(), "alice", "github", "valid")
+	require.NoError(t, err)
+	assert.Equal(t, "octocat", connection.AccountLogin)
+
+	connections, err := svc.ListConnections("alice")
+	require.NoError(t, err)
+	require.Len(t, connections, 1)
+
+	require.NoError(t, svc.Disconnect("alice", "github"))
+	assert.ErrorIs(t, svc.Disconnect("alice", "github"), ErrNotConnected)
+}
+
+func TestService_ListRepositories(t *testing.T) {
+	server := fakeGitHub(t)
+	svc := NewService(newMockRepository(), zap.NewNop(), NewGitHubProvider(server.URL))
+
+	_, _, err := svc.ListRepositories(context.Background(), "alice", "github", "", 0)
+	assert.ErrorIs(t, err, ErrNotConnected)
+
+	_, err = svc.Connect(context.Background(), "alice", "github", "valid")
+	require.NoError(t, err)
+
+	repos, next, err := svc.ListRepositories(context.Background(), "alice", "github", "", 0)
+	require.NoError(t, err)
+	require.Len(t, repos, 1)
+	assert.Equal(t, "octocat/repo-1", repos[0].FullName)
+	assert.Equal(t, "main", repos[0].DefaultBranch)
+	assert.Equal(t, "2", next)
+
+	repos, next, err = svc.ListRepositories(context.Background(), "alice", "github", next, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "octocat/repo-2", repos[0].FullName)
+	assert.Empty(t, next)
+
+	_, _, err = svc.ListRepositories(context.Background(), "alice", "github", "nope", 0)
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
+
+func TestService_ListBranches(t *testing.T) {
+	server := fakeGitHub(t)
+	repo := newMockRepository()
+	svc := NewService(repo, zap.NewNop(), NewGitHubProvider(server.URL))
+
+	_, err := svc.Connect(context.Background(), "alice", "github", "valid")
+	require.NoError(t, err)
+
+	branches, err := svc.ListBranches(context.Background(), "alice", "github", "octocat/repo-1")
+	require.NoError(t, err)
+	assert.Equal(t, []Branch{{Name: "main", CommitSHA: "abc"}, {Name: "develop", CommitSHA: "def"}}, branches)
+
+	// A token revoked on the provider asks the user to reconnect
+	repo.connections["alice/github"].AccessToken = "revoked"
+	_, err = svc.ListBranches(context.Background(), "alice", "github", "octocat/repo-1")
+	assert.ErrorIs(t, err, ErrTokenRejected)
+}
+
+func TestImporter_RegisterPushHook(t *testing.T) {
+	server := fakeGitHub(t)
+	svc := NewService(newMockRepository(), zap.NewNop(), NewGitHubProvider(server.URL))
+	importer := NewImporter(svc, nil, "https://chef.example.com/hooks/github", "secret", zap.NewNop())
+	repoURL := "https://github.com/octocat/repo-1.git"
+
+	err := importer.RegisterPushHook(context.Background(), "alice", repoURL)
+	assert.ErrorIs(t, err, ErrNotConnected)
+
+	_, err = svc.Connect(context.Background(), "alice", "github", "valid")
+	require.NoError(t, err)
+	require.NoError(t, importer.RegisterPushHook(context.Background(), "alice", repoURL))
+
+	err = importer.RegisterPushHook(context.Background(), "alice", "https://gitlab.com/octocat/repo-1.git")
+	assert.ErrorIs(t, err, ErrUnsupportedHost)
+
+	unconfigured := NewImporter(svc, nil, "", "", zap.NewNop())
+	err = unconfigured.RegisterPushHook(context.Background(), "alice", repoURL)
+	assert.ErrorIs(t, err, ErrHookNotConfigured)
+}
@@ -0,0 +1,131 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/events"
+	pb "github.com/elskow/chef-infra/proto/gen/secrets/v1"
+)
+
+// PasswordFallback reports whether connections are made with a previous
+// password
+type PasswordFallback interface {
+	UsingPreviousPassword() bool
+}
+
+// Handler serves the Secrets API. The server checks the admin role.
+type Handler struct {
+	pb.UnimplementedSecretsServer
+	keys     *SigningKeys
+	rotator  *Rotator
+	keyring  *Keyring
+	database PasswordFallback
+	events   events.Publisher
+	log      *zap.Logger
+}
+
+func NewHandler(keys *SigningKeys, rotator *Rotator, keyring *Keyring, database PasswordFallback, publisher events.Publisher, log *zap.Logger) *Handler {
+	return &Handler{
+		keys:     keys,
+		rotator:  rotator,
+		keyring:  keyring,
+		database: database,
+		events:   publisher,
+		log:      log,
+	}
+}
+
+func (h *Handler) RotateSigningKey(ctx context.Context, req *pb.RotateSigningKeyRequest) (*pb.RotateSigningKeyResponse, error) {
+	if req.GracePeriodSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "grace_period_seconds must not be negative")
+	}
+	grace := h.keys.Grace()
+	if req.GracePeriodSeconds > 0 {
+		grace = time.Duration(req.GracePeriodSeconds) * time.Second
+	}
+	if req.Immediate {
+		grace = 0
+	}
+
+	key, err := h.keys.Rotate(grace)
+	if err != nil {
+		if errors.Is(err, ErrNoMasterKey) {
+			return nil, status.Error(codes.FailedPrecondition, "secrets.master_key must be configured to store signing keys")
+		}
+		h.log.Error("failed to rotate signing key", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to rotate signing key")
+	}
+
+	retireAt := key.CreatedAt.Add(grace)
+	h.log.Warn("signing key rotated",
+		zap.String("key_id", key.KeyID),
+		zap.Time("previous_retire_at", retireAt))
+	h.publish(ctx, events.SecretsSigningKeyRotated,
+		fmt.Sprintf("key %s, previous keys retire at %s", key.KeyID, retireAt.UTC().Format(time.RFC3339)))
+
+	return &pb.RotateSigningKeyResponse{
+		KeyId:            key.KeyID,
+		PreviousRetireAt: retireAt.Unix(),
+	}, nil
+}
+
+func (h *Handler) ReencryptSecrets(ctx context.Context, _ *pb.ReencryptSecretsRequest) (*pb.ReencryptSecretsResponse, error) {
+	progress, err := h.rotator.Reencrypt(ctx)
+	if err != nil {
+		h.log.Error("failed to re-encrypt secrets", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to re-encrypt secrets")
+	}
+
+	h.publish(ctx, events.SecretsReencrypted,
+		fmt.Sprintf("%d re-encrypted, %d pending", progress.Reencrypted, progress.Pending))
+	return &pb.ReencryptSecretsResponse{
+		Reencrypted: int32(progress.Reencrypted),
+		Pending:     int32(progress.Pending),
+	}, nil
+}
+
+func (h *Handler) GetRotationStatus(ctx context.Context, _ *pb.GetRotationStatusRequest) (*pb.GetRotationStatusResponse, error) {
+	keys, err := h.keys.List()
+	if err != nil {
+		h.log.Error("failed to list signing keys", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list signing keys")
+	}
+	pending, err := h.rotator.Pending(ctx)
+	if err != nil {
+		h.log.Error("failed to count pending secrets", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to count pending secrets")
+	}
+
+	resp := &pb.GetRotationStatusResponse{
+		MasterKeyId:              h.keyring.KeyID(),
+		PendingSecrets:           int32(pending),
+		DatabasePreviousPassword: h.database.UsingPreviousPassword(),
+	}
+	for _, key := range keys {
+		info := &pb.SigningKey{KeyId: key.KeyID, CreatedAt: key.CreatedAt.Unix()}
+		if key.RetiresAt != nil {
+			info.RetiresAt = key.RetiresAt.Unix()
+		}
+		resp.SigningKeys = append(resp.SigningKeys, info)
+	}
+	return resp, nil
+}
+
+// publish reports a rotation for the audit log
+func (h *Handler) publish(ctx context.Context, eventType, message string) {
+	admin, _ := auth.GetUserFromContext(ctx)
+	event := events.Event{Type: eventType, Actor: admin, Message: message}
+	if err := h.events.Publish(ctx, event); err != nil {
+		h.log.Warn("failed to publish secrets event",
+			zap.String("event", eventType),
+			zap.Error(err))
+	}
+}
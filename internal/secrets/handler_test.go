@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/events"
+	pb "github.com/elskow/chef-infra/proto/gen/secrets/v1"
+)
+
+// recordingPublisher remembers the secrets events a handler publishes
+type recordingPublisher struct {
+	events []events.Event
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, event events.Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+type staticFallback bool
+
+func (f staticFallback) UsingPreviousPassword() bool {
+	return bool(f)
+}
+
+func newTestHandler(t *testing.T, keyring *Keyring) (*Handler, *recordingPublisher) {
+	repo := newMockRepository()
+	keys := newTestSigningKeys(t, repo, keyring)
+	rotator := NewRotator(repo, keyring, keys, nil, &config.SecretsConfig{}, zap.NewNop())
+	publisher := &recordingPublisher{}
+	return NewHandler(keys, rotator, keyring, staticFallback(true), publisher, zap.NewNop()), publisher
+}
+
+func TestHandler_RotateSigningKey(t *testing.T) {
+	ctx := context.WithValue(context.Background(), auth.UserContextKey, "admin")
+
+	t.Run("negative grace", func(t *testing.T) {
+		h, _ := newTestHandler(t, newTestKeyring(t, testMasterKey(1)))
+		_, err := h.RotateSigningKey(ctx, &pb.RotateSigningKeyRequest{GracePeriodSeconds: -1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("without master key", func(t *testing.T) {
+		h, _ := newTestHandler(t, newTestKeyring(t, ""))
+		_, err := h.RotateSigningKey(ctx, &pb.RotateSigningKeyRequest{})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("rotates", func(t *testing.T) {
+		h, publisher := newTestHandler(t, newTestKeyring(t, testMasterKey(1)))
+		resp, err := h.RotateSigningKey(ctx, &pb.RotateSigningKeyRequest{GracePeriodSeconds: 60})
+		require.NoError(t, err)
+		assert.NotEmpty(t, resp.KeyId)
+
+		id, _ := h.keys.SigningKey()
+		assert.Equal(t, resp.KeyId, id)
+		require.Len(t, publisher.events, 1)
+		assert.Equal(t, events.SecretsSigningKeyRotated, publisher.events[0].Type)
+		assert.Equal(t, "admin", publisher.events[0].Actor)
+
+		rotation, err := h.GetRotationStatus(ctx, &pb.GetRotationStatusRequest{})
+		require.NoError(t, err)
+		require.Len(t, rotation.SigningKeys, 2)
+		assert.Equal(t, ConfigKeyID, rotation.SigningKeys[0].KeyId)
+		assert.Equal(t, resp.PreviousRetireAt, rotation.SigningKeys[0].RetiresAt)
+		assert.Equal(t, resp.KeyId, rotation.SigningKeys[1].KeyId)
+		assert.Zero(t, rotation.SigningKeys[1].RetiresAt)
+		assert.Equal(t, h.keyring.KeyID(), rotation.MasterKeyId)
+		assert.Zero(t, rotation.PendingSecrets)
+		assert.True(t, rotation.DatabasePreviousPassword)
+	})
+}
+
+func TestHandler_ReencryptSecrets(t *testing.T) {
+	h, publisher := newTestHandler(t, newTestKeyring(t, testMasterKey(1)))
+
+	resp, err := h.ReencryptSecrets(context.Background(), &pb.ReencryptSecretsRequest{})
+	require.NoError(t, err)
+	assert.Zero(t, resp.Reencrypted)
+	assert.Zero(t, resp.Pending)
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, events.SecretsReencrypted, publisher.events[0].Type)
+}
@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+// sealedPrefix marks values encrypted with a master key. Sealed values
+// read "sealed:<key id>:<base64 nonce and ciphertext>".
+const sealedPrefix = "sealed:"
+
+var (
+	ErrNoMasterKey   = errors.New("secrets.master_key is not configured")
+	ErrUnknownKey    = errors.New("secret was sealed with a master key that is not configured")
+	ErrMalformedSeal = errors.New("sealed secret is malformed")
+)
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring seals secrets with the current master key and opens those sealed
+// with it or a previous one. Without a master key secrets are stored as
+// they are, and values stored before one was configured are read as they
+// are.
+type Keyring struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+func NewKeyring(cfg *config.SecretsConfig) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*masterKey)}
+	if cfg.MasterKey != "" {
+		key, err := newMasterKey(cfg.MasterKey)
+		if err != nil {
+			return nil, fmt.Errorf("secrets.master_key %w", err)
+		}
+		k.current = key
+		k.keys[key.id] = key
+	}
+	for i, previous := range cfg.PreviousMasterKeys {
+		key, err := newMasterKey(previous)
+		if err != nil {
+			return nil, fmt.Errorf("secrets.previous_master_keys[%d] %w", i, err)
+		}
+		if _, ok := k.keys[key.id]; !ok {
+			k.keys[key.id] = key
+		}
+	}
+	return k, nil
+}
+
+func newMasterKey(encoded string) (*masterKey, error) {
+	raw, err := config.DecodeMasterKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// The ID tells keys apart without revealing them
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// Enabled reports whether secrets are sealed
+func (k *Keyring) Enabled() bool {
+	return k.current != nil
+}
+
+// KeyID identifies the current master key, empty when there is none
+func (k *Keyring) KeyID() string {
+	if k.current == nil {
+		return ""
+	}
+	return k.current.id
+}
+
+// Seal encrypts a secret with the current master key
+func (k *Keyring) Seal(plaintext string) (string, error) {
+	if k.current == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	nonce := make([]byte, k.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := k.current.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + k.current.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a sealed secret. Values that were never sealed are
+// returned as they are.
+func (k *Keyring) Open(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, sealedPrefix), ":")
+	if !ok {
+		return "", ErrMalformedSeal
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", ErrMalformedSeal
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrMalformedSeal
+	}
+	return string(plaintext), nil
+}
+
+// Current reports whether a stored value is as Seal would store it now:
+// sealed with the current master key, or in plain text without one
+func (k *Keyring) Current(value string) bool {
+	if value == "" {
+		return true
+	}
+	if k.current == nil {
+		return !strings.HasPrefix(value, sealedPrefix)
+	}
+	return strings.HasPrefix(value, sealedPrefix+k.current.id+":")
+}
@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+func testMasterKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, config.MasterKeySize))
+}
+
+func newTestKeyring(t *testing.T, key string, previous ...string) *Keyring {
+	keyring, err := NewKeyring(&config.SecretsConfig{MasterKey: key, PreviousMasterKeys: previous})
+	require.NoError(t, err)
+	return keyring
+}
+
+func TestKeyring_SealOpen(t *testing.T) {
+	keyring := newTestKeyring(t, testMasterKey(1))
+	assert.True(t, keyring.Enabled())
+	assert.Len(t, keyring.KeyID(), 8)
+
+	sealed, err := keyring.Seal("ghp_token")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "ghp_token")
+	assert.True(t, keyring.Current(sealed))
+
+	plaintext, err := keyring.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "ghp_token", plaintext)
+
+	// Values stored before the master key was configured are read as they are
+	plaintext, err = keyring.Open("ghp_plain")
+	require.NoError(t, err)
+	assert.Equal(t, "ghp_plain", plaintext)
+	assert.False(t, keyring.Current("ghp_plain"))
+}
+
+func TestKeyring_PreviousKey(t *testing.T) {
+	old := newTestKeyring(t, testMasterKey(1))
+	sealed, err := old.Seal("ghp_token")
+	require.NoError(t, err)
+
+	rotated := newTestKeyring(t, testMasterKey(2), testMasterKey(1))
+	assert.NotEqual(t, old.KeyID(), rotated.KeyID())
+	assert.False(t, rotated.Current(sealed))
+	plaintext, err := rotated.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "ghp_token", plaintext)
+
+	// Once the previous key is removed its values cannot be opened
+	_, err = newTestKeyring(t, testMasterKey(2)).Open(sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKeyring_Malformed(t *testing.T) {
+	keyring := newTestKeyring(t, testMasterKey(1))
+
+	_, err := keyring.Open("sealed:" + keyring.KeyID())
+	assert.ErrorIs(t, err, ErrMalformedSeal)
+	_, err = keyring.Open("sealed:" + keyring.KeyID() + ":bm90IGEgc2VhbA==")
+	assert.ErrorIs(t, err, ErrMalformedSeal)
+}
+
+func TestKeyring_Disabled(t *testing.T) {
+	keyring := newTestKeyring(t, "")
+	assert.False(t, keyring.Enabled())
+	assert.Empty(t, keyring.KeyID())
+
+	sealed, err := keyring.Seal("ghp_token")
+	require.NoError(t, err)
+	assert.Equal(t, "ghp_token", sealed)
+	assert.True(t, keyring.Current(sealed))
+}
+
+func TestNewKeyring_InvalidKey(t *testing.T) {
+	_, err := NewKeyring(&config.SecretsConfig{MasterKey: "c2hvcnQ="})
+	assert.EqualError(t, err, "secrets.master_key must decode to 32 bytes, got 5")
+}
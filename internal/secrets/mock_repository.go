@@ -0,0 +1,122 @@
+package secrets
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type mockRepository struct {
+	keys   []SigningKey
+	sealed map[Column]map[uint]string
+	nextID uint
+	mu     sync.Mutex
+}
+
+func newMockRepository() *mockRepository {
+	return &mockRepository{
+		sealed: make(map[Column]map[uint]string),
+	}
+}
+
+func (r *mockRepository) ListSigningKeys() ([]SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]SigningKey(nil), r.keys...), nil
+}
+
+func (r *mockRepository) AddSigningKey(key *SigningKey, retireAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.keys) == 0 {
+		r.add(&SigningKey{KeyID: ConfigKeyID, RetiresAt: &retireAt})
+	} else {
+		for i := range r.keys {
+			if r.keys[i].RetiresAt == nil || r.keys[i].RetiresAt.After(retireAt) {
+				r.keys[i].RetiresAt = &retireAt
+			}
+		}
+	}
+	r.add(key)
+	return nil
+}
+
+func (r *mockRepository) add(key *SigningKey) {
+	r.nextID++
+	key.ID = r.nextID
+	key.CreatedAt = time.Now()
+	r.keys = append(r.keys, *key)
+}
+
+func (r *mockRepository) DeleteRetiredKeys(before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var kept []SigningKey
+	for _, key := range r.keys {
+		if key.RetiresAt == nil || !key.RetiresAt.Before(before) {
+			kept = append(kept, key)
+		}
+	}
+	deleted := int64(len(r.keys) - len(kept))
+	r.keys = kept
+	return deleted, nil
+}
+
+func (r *mockRepository) ListSealed(column Column, afterID uint, limit int) ([]Sealed, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rows []Sealed
+	if column == SigningKeyColumn {
+		for _, key := range r.keys {
+			rows = append(rows, Sealed{ID: key.ID, Value: key.Secret})
+		}
+	} else {
+		for id, value := range r.sealed[column] {
+			rows = append(rows, Sealed{ID: id, Value: value})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].ID < rows[j].ID
+	})
+
+	var page []Sealed
+	for _, row := range rows {
+		if row.ID > afterID && row.Value != "" && len(page) < limit {
+			page = append(page, row)
+		}
+	}
+	return page, nil
+}
+
+func (r *mockRepository) UpdateSealed(column Column, id uint, old, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if column == SigningKeyColumn {
+		for i := range r.keys {
+			if r.keys[i].ID == id && r.keys[i].Secret == old {
+				r.keys[i].Secret = value
+			}
+		}
+		return nil
+	}
+	if r.sealed[column][id] == old {
+		r.sealed[column][id] = value
+	}
+	return nil
+}
+
+// store saves a value in a column other than the signing keys
+func (r *mockRepository) store(column Column, id uint, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sealed[column] == nil {
+		r.sealed[column] = make(map[uint]string)
+	}
+	r.sealed[column][id] = value
+}
@@ -0,0 +1,29 @@
+package secrets
+
+import "time"
+
+// ConfigKeyID stands for the configured jwt_secret once rotated keys took
+// over. Its row keeps no secret, only when tokens signed with it retire.
+const ConfigKeyID = "config"
+
+// SigningKey is a JWT signing key. The key without RetiresAt signs new
+// tokens; the others verify the tokens they signed until they retire.
+type SigningKey struct {
+	ID        uint   `gorm:"primaryKey"`
+	KeyID     string `gorm:"uniqueIndex;not null"` // kid header of the tokens it signs
+	Secret    string `gorm:"not null"`             // Sealed with the master key
+	CreatedAt time.Time
+	RetiresAt *time.Time `gorm:"index"` // Set once a newer key took over
+}
+
+// Column is a database column holding sealed secrets
+type Column struct {
+	Table string
+	Name  string
+}
+
+// Sealed is a stored secret and the ID of its row
+type Sealed struct {
+	ID    uint
+	Value string
+}
@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	ListSigningKeys() ([]SigningKey, error)
+	// AddSigningKey makes key the current one and retires the others at
+	// retireAt. The first key added retires the configured jwt_secret.
+	AddSigningKey(key *SigningKey, retireAt time.Time) error
+	// DeleteRetiredKeys removes the keys retired before a time
+	DeleteRetiredKeys(before time.Time) (int64, error)
+	// ListSealed returns up to limit non-empty values of a column from the
+	// rows after afterID, in ID order
+	ListSealed(column Column, afterID uint, limit int) ([]Sealed, error)
+	// UpdateSealed replaces a value unless it changed since it was read
+	UpdateSealed(column Column, id uint, old, value string) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) ListSigningKeys() ([]SigningKey, error) {
+	var keys []SigningKey
+	if err := r.db.Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *repository) AddSigningKey(key *SigningKey, retireAt time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&SigningKey{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			if err := tx.Create(&SigningKey{KeyID: ConfigKeyID, RetiresAt: &retireAt}).Error; err != nil {
+				return err
+			}
+		} else {
+			// An earlier grace period is cut short when this one ends first
+			err := tx.Model(&SigningKey{}).
+				Where("retires_at IS NULL OR retires_at > ?", retireAt).
+				Update("retires_at", retireAt).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(key).Error
+	})
+}
+
+func (r *repository) DeleteRetiredKeys(before time.Time) (int64, error) {
+	result := r.db.Where("retires_at < ?", before).Delete(&SigningKey{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *repository) ListSealed(column Column, afterID uint, limit int) ([]Sealed, error) {
+	var rows []Sealed
+	err := r.db.Table(column.Table).
+		Select("id, ? AS value", clause.Column{Name: column.Name}).
+		Where("id > ? AND ? <> ''", afterID, clause.Column{Name: column.Name}).
+		Order("id").
+		Limit(limit).
+		Scan(&rows).Error
+	return rows, err
+}
+
+func (r *repository) UpdateSealed(column Column, id uint, old, value string) error {
+	return r.db.Table(column.Table).
+		Where("id = ? AND ? = ?", id, clause.Column{Name: column.Name}, old).
+		Update(column.Name, value).Error
+}
@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const (
+	defaultReencryptInterval = time.Hour
+	reencryptBatchSize       = 100
+)
+
+// SigningKeyColumn holds the sealed JWT signing keys
+var SigningKeyColumn = Column{Table: "signing_keys", Name: "secret"}
+
+// Progress counts the stored secrets a re-encryption went through
+type Progress struct {
+	Reencrypted int
+	Pending     int // Not sealed with the current master key, e.g. sealed with a key that is no longer configured
+}
+
+// Rotator moves stored secrets to the current master key and removes
+// retired signing keys. It runs on the leader every reencrypt interval, so
+// a previous master key can be removed from the config once nothing is
+// pending.
+type Rotator struct {
+	repository Repository
+	keyring    *Keyring
+	keys       *SigningKeys
+	columns    []Column
+	interval   time.Duration
+	log        *zap.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRotator returns the rotator of the secrets stored in columns, besides
+// the signing keys
+func NewRotator(repo Repository, keyring *Keyring, keys *SigningKeys, columns []Column, cfg *config.SecretsConfig, log *zap.Logger) *Rotator {
+	interval := cfg.ReencryptInterval
+	if interval <= 0 {
+		interval = defaultReencryptInterval
+	}
+	return &Rotator{
+		repository: repo,
+		keyring:    keyring,
+		keys:       keys,
+		columns:    append([]Column{SigningKeyColumn}, columns...),
+		interval:   interval,
+		log:        log,
+	}
+}
+
+func (r *Rotator) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			r.run(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (r *Rotator) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+func (r *Rotator) run(ctx context.Context) {
+	progress, err := r.Reencrypt(ctx)
+	if err != nil {
+		r.log.Error("failed to re-encrypt secrets", zap.Error(err))
+	} else if progress.Reencrypted > 0 || progress.Pending > 0 {
+		r.log.Info("re-encrypted secrets",
+			zap.Int("reencrypted", progress.Reencrypted),
+			zap.Int("pending", progress.Pending))
+	}
+
+	deleted, err := r.keys.DeleteRetired()
+	if err != nil {
+		r.log.Error("failed to delete retired signing keys", zap.Error(err))
+	} else if deleted > 0 {
+		r.log.Info("deleted retired signing keys", zap.Int64("count", deleted))
+	}
+}
+
+// Reencrypt seals every stored secret that is not sealed with the current
+// master key with it
+func (r *Rotator) Reencrypt(ctx context.Context) (Progress, error) {
+	return r.walk(ctx, true)
+}
+
+// Pending counts the stored secrets not sealed with the current master key
+func (r *Rotator) Pending(ctx context.Context) (int, error) {
+	progress, err := r.walk(ctx, false)
+	return progress.Pending, err
+}
+
+func (r *Rotator) walk(ctx context.Context, reencrypt bool) (Progress, error) {
+	var progress Progress
+	for _, column := range r.columns {
+		var afterID uint
+		for {
+			if err := ctx.Err(); err != nil {
+				return progress, err
+			}
+			batch, err := r.repository.ListSealed(column, afterID, reencryptBatchSize)
+			if err != nil {
+				return progress, err
+			}
+			for _, sealed := range batch {
+				afterID = sealed.ID
+				if r.keyring.Current(sealed.Value) {
+					continue
+				}
+				if !reencrypt || !r.reencrypt(column, sealed) {
+					progress.Pending++
+					continue
+				}
+				progress.Reencrypted++
+			}
+			if len(batch) < reencryptBatchSize {
+				break
+			}
+		}
+	}
+	return progress, nil
+}
+
+func (r *Rotator) reencrypt(column Column, sealed Sealed) bool {
+	log := r.log.With(zap.String("table", column.Table), zap.Uint("id", sealed.ID))
+	plaintext, err := r.keyring.Open(sealed.Value)
+	if err != nil {
+		log.Warn("failed to open secret", zap.Error(err))
+		return false
+	}
+	value, err := r.keyring.Seal(plaintext)
+	if err != nil {
+		log.Warn("failed to seal secret", zap.Error(err))
+		return false
+	}
+	if err := r.repository.UpdateSealed(column, sealed.ID, sealed.Value, value); err != nil {
+		log.Warn("failed to update secret", zap.Error(err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,245 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+const (
+	defaultRefreshInterval = time.Minute
+	// minReload bounds the reloads caused by tokens naming a key this
+	// replica has not loaded yet
+	minReload = 5 * time.Second
+)
+
+type verificationKey struct {
+	secret    []byte
+	retiresAt *time.Time
+}
+
+// SigningKeys signs tokens with the current rotated key and verifies them
+// with every key that has not retired, implementing auth.Keys. Until a key
+// is rotated in, the configured jwt_secret is used. Replicas reload the
+// keys every refresh interval and when a token names a key they have not
+// loaded, so a rotation on one replica reaches the others in time.
+type SigningKeys struct {
+	repository Repository
+	keyring    *Keyring
+	fallback   []byte // The configured jwt_secret
+	grace      time.Duration
+	interval   time.Duration
+	log        *zap.Logger
+
+	mu        sync.RWMutex
+	currentID string
+	keys      map[string]verificationKey
+	loadedAt  time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewSigningKeys(repo Repository, keyring *Keyring, auth *config.AuthConfig, cfg *config.SecretsConfig, log *zap.Logger) *SigningKeys {
+	// By default every token signed with a rotated out key may live out
+	// its lifetime
+	grace := cfg.SigningKeyGrace
+	if grace <= 0 {
+		grace = max(auth.AccessTokenDuration, auth.ImpersonationDuration)
+		if auth.RefreshTokenEnabled {
+			grace = max(grace, auth.RefreshTokenDuration)
+		}
+	}
+	interval := cfg.RefreshInterval
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	return &SigningKeys{
+		repository: repo,
+		keyring:    keyring,
+		fallback:   []byte(auth.JWTSecret),
+		grace:      grace,
+		interval:   interval,
+		log:        log,
+		keys:       map[string]verificationKey{"": {secret: []byte(auth.JWTSecret)}},
+	}
+}
+
+// Grace is how long tokens of rotated out keys are accepted by default
+func (k *SigningKeys) Grace() time.Duration {
+	return k.grace
+}
+
+// Load reads the keys from the database. Keys that cannot be opened with
+// the configured master keys are skipped, except the current one, which
+// fails the load and leaves the loaded keys in place.
+func (k *SigningKeys) Load() error {
+	stored, err := k.repository.ListSigningKeys()
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	keys := make(map[string]verificationKey, len(stored)+1)
+	currentID := ""
+	if len(stored) == 0 {
+		keys[""] = verificationKey{secret: k.fallback}
+	}
+	for _, key := range stored {
+		if key.KeyID == ConfigKeyID {
+			keys[""] = verificationKey{secret: k.fallback, retiresAt: key.RetiresAt}
+			continue
+		}
+		secret, err := k.keyring.Open(key.Secret)
+		if err != nil {
+			if key.RetiresAt == nil {
+				return fmt.Errorf("failed to open current signing key %s: %w", key.KeyID, err)
+			}
+			k.log.Error("failed to open signing key", zap.String("key_id", key.KeyID), zap.Error(err))
+			continue
+		}
+		keys[key.KeyID] = verificationKey{secret: []byte(secret), retiresAt: key.RetiresAt}
+		if key.RetiresAt == nil {
+			currentID = key.KeyID
+		}
+	}
+
+	k.mu.Lock()
+	k.currentID = currentID
+	k.keys = keys
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *SigningKeys) SigningKey() (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if key, ok := k.keys[k.currentID]; ok && k.currentID != "" {
+		return k.currentID, key.secret
+	}
+	return "", k.fallback
+}
+
+func (k *SigningKeys) VerificationKey(id string) ([]byte, bool) {
+	if secret, ok := k.lookup(id); ok {
+		return secret, true
+	}
+	if id == "" || !k.claimReload() {
+		return nil, false
+	}
+	if err := k.Load(); err != nil {
+		k.log.Warn("failed to reload signing keys", zap.Error(err))
+		return nil, false
+	}
+	return k.lookup(id)
+}
+
+func (k *SigningKeys) lookup(id string) ([]byte, bool) {
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok || (key.retiresAt != nil && time.Now().After(*key.retiresAt)) {
+		return nil, false
+	}
+	return key.secret, true
+}
+
+// claimReload reports whether a reload for an unknown key may run now
+func (k *SigningKeys) claimReload() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if time.Since(k.loadedAt) < minReload {
+		return false
+	}
+	k.loadedAt = time.Now()
+	return true
+}
+
+// Rotate makes a new random key current. Tokens signed with the keys
+// before it are accepted for the grace period; a grace of zero rejects
+// them at once, e.g. after a key leaked, logging everyone out. Keys are
+// sealed with the master key, which must be configured.
+func (k *SigningKeys) Rotate(grace time.Duration) (*SigningKey, error) {
+	if !k.keyring.Enabled() {
+		return nil, ErrNoMasterKey
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	sealed, err := k.keyring.Seal(hex.EncodeToString(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{KeyID: hex.EncodeToString(id), Secret: sealed}
+	if err := k.repository.AddSigningKey(key, time.Now().Add(grace)); err != nil {
+		return nil, fmt.Errorf("failed to save signing key: %w", err)
+	}
+	if err := k.Load(); err != nil {
+		k.log.Warn("failed to reload signing keys", zap.Error(err))
+	}
+	return key, nil
+}
+
+// List returns the stored keys without their secrets
+func (k *SigningKeys) List() ([]SigningKey, error) {
+	keys, err := k.repository.ListSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i].Secret = ""
+	}
+	return keys, nil
+}
+
+// DeleteRetired removes the keys no token is accepted from anymore
+func (k *SigningKeys) DeleteRetired() (int64, error) {
+	return k.repository.DeleteRetiredKeys(time.Now())
+}
+
+// Start reloads the keys every refresh interval
+func (k *SigningKeys) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	k.cancel = cancel
+	k.done = make(chan struct{})
+
+	go func() {
+		defer close(k.done)
+
+		ticker := time.NewTicker(k.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := k.Load(); err != nil {
+				k.log.Warn("failed to reload signing keys", zap.Error(err))
+			}
+		}
+	}()
+}
+
+func (k *SigningKeys) Stop() {
+	if k.cancel == nil {
+		return
+	}
+	k.cancel()
+	<-k.done
+}
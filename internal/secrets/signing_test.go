@@ -0,0 +1,176 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+)
+
+func newTestAuthConfig() *config.AuthConfig {
+	return &config.AuthConfig{
+		JWTSecret:           "test-secret-key",
+		AccessTokenDuration: time.Hour,
+	}
+}
+
+func newTestSigningKeys(t *testing.T, repo Repository, keyring *Keyring) *SigningKeys {
+	keys := NewSigningKeys(repo, keyring, newTestAuthConfig(), &config.SecretsConfig{}, zap.NewNop())
+	require.NoError(t, keys.Load())
+	return keys
+}
+
+func TestNewSigningKeys_Grace(t *testing.T) {
+	auth := newTestAuthConfig()
+	keys := NewSigningKeys(newMockRepository(), newTestKeyring(t, ""), auth, &config.SecretsConfig{}, zap.NewNop())
+	assert.Equal(t, time.Hour, keys.Grace())
+
+	auth.RefreshTokenEnabled = true
+	auth.RefreshTokenDuration = 7 * 24 * time.Hour
+	keys = NewSigningKeys(newMockRepository(), newTestKeyring(t, ""), auth, &config.SecretsConfig{}, zap.NewNop())
+	assert.Equal(t, 7*24*time.Hour, keys.Grace())
+
+	keys = NewSigningKeys(newMockRepository(), newTestKeyring(t, ""), auth, &config.SecretsConfig{SigningKeyGrace: time.Minute}, zap.NewNop())
+	assert.Equal(t, time.Minute, keys.Grace())
+}
+
+func TestSigningKeys_ConfiguredSecret(t *testing.T) {
+	keys := newTestSigningKeys(t, newMockRepository(), newTestKeyring(t, ""))
+
+	id, secret := keys.SigningKey()
+	assert.Empty(t, id)
+	assert.Equal(t, []byte("test-secret-key"), secret)
+
+	secret, ok := keys.VerificationKey("")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("test-secret-key"), secret)
+
+	// Storing keys needs a master key
+	_, err := keys.Rotate(time.Hour)
+	assert.ErrorIs(t, err, ErrNoMasterKey)
+}
+
+func TestSigningKeys_Rotate(t *testing.T) {
+	repo := newMockRepository()
+	keys := newTestSigningKeys(t, repo, newTestKeyring(t, testMasterKey(1)))
+
+	first, err := keys.Rotate(time.Hour)
+	require.NoError(t, err)
+	id, secret := keys.SigningKey()
+	assert.Equal(t, first.KeyID, id)
+	assert.Len(t, secret, 64)
+
+	// Tokens of the configured secret are accepted during the grace period
+	_, ok := keys.VerificationKey("")
+	assert.True(t, ok)
+
+	second, err := keys.Rotate(time.Hour)
+	require.NoError(t, err)
+	id, _ = keys.SigningKey()
+	assert.Equal(t, second.KeyID, id)
+	_, ok = keys.VerificationKey(first.KeyID)
+	assert.True(t, ok)
+
+	// Secrets are sealed at rest and not listed
+	stored, err := repo.ListSigningKeys()
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	assert.Equal(t, ConfigKeyID, stored[0].KeyID)
+	assert.Contains(t, stored[2].Secret, sealedPrefix)
+	listed, err := keys.List()
+	require.NoError(t, err)
+	for _, key := range listed {
+		assert.Empty(t, key.Secret)
+	}
+}
+
+func TestSigningKeys_RotateImmediately(t *testing.T) {
+	keys := newTestSigningKeys(t, newMockRepository(), newTestKeyring(t, testMasterKey(1)))
+
+	first, err := keys.Rotate(time.Hour)
+	require.NoError(t, err)
+	_, err = keys.Rotate(0)
+	require.NoError(t, err)
+
+	// Without a grace period the previous keys are rejected at once
+	_, ok := keys.VerificationKey(first.KeyID)
+	assert.False(t, ok)
+	_, ok = keys.VerificationKey("")
+	assert.False(t, ok)
+
+	deleted, err := keys.DeleteRetired()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+}
+
+func TestSigningKeys_ReloadsUnknownKey(t *testing.T) {
+	repo := newMockRepository()
+	keyring := newTestKeyring(t, testMasterKey(1))
+	leader := newTestSigningKeys(t, repo, keyring)
+	replica := newTestSigningKeys(t, repo, keyring)
+
+	key, err := leader.Rotate(time.Hour)
+	require.NoError(t, err)
+
+	// Reloads are rate limited
+	_, ok := replica.VerificationKey(key.KeyID)
+	assert.False(t, ok)
+
+	replica.loadedAt = time.Now().Add(-minReload)
+	_, ok = replica.VerificationKey(key.KeyID)
+	assert.True(t, ok)
+	id, _ := replica.SigningKey()
+	assert.Equal(t, key.KeyID, id)
+}
+
+func TestSigningKeys_LoadWithoutMasterKey(t *testing.T) {
+	repo := newMockRepository()
+	_, err := newTestSigningKeys(t, repo, newTestKeyring(t, testMasterKey(1))).Rotate(time.Hour)
+	require.NoError(t, err)
+
+	// The current key cannot be opened once its master key is gone
+	keys := NewSigningKeys(repo, newTestKeyring(t, testMasterKey(2)), newTestAuthConfig(), &config.SecretsConfig{}, zap.NewNop())
+	assert.ErrorIs(t, keys.Load(), ErrUnknownKey)
+}
+
+func TestRotator_Reencrypt(t *testing.T) {
+	tokens := Column{Table: "provider_connections", Name: "access_token"}
+	repo := newMockRepository()
+	old := newTestKeyring(t, testMasterKey(1))
+	key, err := newTestSigningKeys(t, repo, old).Rotate(time.Hour)
+	require.NoError(t, err)
+
+	sealed, err := old.Seal("ghp_sealed")
+	require.NoError(t, err)
+	repo.store(tokens, 1, sealed)
+	repo.store(tokens, 2, "ghp_plain")
+	repo.store(tokens, 3, "sealed:0badc0de:AAAA")
+
+	keyring := newTestKeyring(t, testMasterKey(2), testMasterKey(1))
+	keys := newTestSigningKeys(t, repo, keyring)
+	rotator := NewRotator(repo, keyring, keys, []Column{tokens}, &config.SecretsConfig{}, zap.NewNop())
+
+	pending, err := rotator.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 4, pending)
+
+	progress, err := rotator.Reencrypt(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Progress{Reencrypted: 3, Pending: 1}, progress)
+
+	// Everything but the value of an unknown key opens with the new key alone
+	current := newTestKeyring(t, testMasterKey(2))
+	for id, want := range map[uint]string{1: "ghp_sealed", 2: "ghp_plain"} {
+		value, err := current.Open(repo.sealed[tokens][id])
+		require.NoError(t, err)
+		assert.Equal(t, want, value)
+	}
+	reloaded := newTestSigningKeys(t, repo, current)
+	id, _ := reloaded.SigningKey()
+	assert.Equal(t, key.KeyID, id)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
 )
 
 const (
@@ -30,6 +31,15 @@ func LoadConfig() (*config.AppConfig, error) {
 		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
 
+	// Encrypted values are decrypted before anything reads them
+	key, err := config.LoadConfigKey()
+	if err != nil {
+		return nil, err
+	}
+	if problems := config.DecryptValues(v, key); len(problems) > 0 {
+		return nil, fmt.Errorf("error decrypting config: %s: %s", problems[0].Key, problems[0].Message)
+	}
+
 	var config config.AppConfig
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
@@ -42,5 +52,16 @@ func LoadConfig() (*config.AppConfig, error) {
 		}
 	}
 
+	config.GRPC.ApplyDefaults()
+	if err := config.GRPC.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", env, err)
+	}
+	if !types.ValidDedupPolicy(types.DedupPolicy(config.Pipeline.BuildDedup)) {
+		return nil, fmt.Errorf("invalid pipeline build_dedup %q, expected queue or supersede", config.Pipeline.BuildDedup)
+	}
+	if config.Pipeline.Faults.Enabled && env != EnvTesting {
+		return nil, fmt.Errorf("pipeline faults can only be enabled with APP_ENV=%s", EnvTesting)
+	}
+
 	return &config, nil
 }
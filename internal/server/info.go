@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+
+	"github.com/elskow/chef-infra/internal/api"
+	"github.com/elskow/chef-infra/internal/version"
+	pb "github.com/elskow/chef-infra/proto/gen/server/v1"
+)
+
+// InfoHandler serves ServerInfo. It is public so clients can check the
+// API versions before logging in.
+type InfoHandler struct {
+	pb.UnimplementedServerServer
+}
+
+func (h *InfoHandler) ServerInfo(ctx context.Context, req *pb.ServerInfoRequest) (*pb.ServerInfoResponse, error) {
+	resp := &pb.ServerInfoResponse{
+		ApiVersions: api.Versions,
+		Build:       buildMetadata(),
+	}
+	for _, service := range api.Services {
+		resp.Services = append(resp.Services, &pb.ApiService{
+			Name:       service.Name,
+			Version:    service.Version,
+			Deprecated: service.Deprecated,
+			ReplacedBy: service.ReplacedBy,
+		})
+	}
+	return resp, nil
+}
+
+// buildMetadata describes the running binary
+func buildMetadata() *pb.BuildMetadata {
+	info := version.Get()
+	build := &pb.BuildMetadata{
+		Version:   info.Version,
+		Commit:    info.Commit,
+		Modified:  info.Modified,
+		GoVersion: info.GoVersion,
+	}
+	if !info.BuildDate.IsZero() {
+		build.BuildDate = info.BuildDate.Unix()
+	}
+	return build
+}
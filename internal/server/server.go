@@ -9,32 +9,85 @@ import (
 	"google.golang.org/grpc/status"
 	"net"
 	"os"
+	"slices"
 
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 
 	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/cache"
 	"github.com/elskow/chef-infra/internal/config"
-	pb "github.com/elskow/chef-infra/proto/gen/auth"
+	"github.com/elskow/chef-infra/internal/diagnostics"
+	"github.com/elskow/chef-infra/internal/events"
+	"github.com/elskow/chef-infra/internal/i18n"
+	"github.com/elskow/chef-infra/internal/pipeline"
+	"github.com/elskow/chef-infra/internal/pipeline/agent"
+	"github.com/elskow/chef-infra/internal/project"
+	"github.com/elskow/chef-infra/internal/scm"
+	"github.com/elskow/chef-infra/internal/secrets"
+	"github.com/elskow/chef-infra/internal/subscription"
+	"github.com/elskow/chef-infra/internal/validate"
+	"github.com/elskow/chef-infra/internal/version"
+	"github.com/elskow/chef-infra/internal/webhook"
+	agentpb "github.com/elskow/chef-infra/proto/gen/agent"
+	legacyauthpb "github.com/elskow/chef-infra/proto/gen/auth"
+	pb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+	diagnosticspb "github.com/elskow/chef-infra/proto/gen/diagnostics"
+	pipelinepb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+	projectpb "github.com/elskow/chef-infra/proto/gen/project"
+	publicpb "github.com/elskow/chef-infra/proto/gen/public/v1"
+	scmpb "github.com/elskow/chef-infra/proto/gen/scm"
+	secretspb "github.com/elskow/chef-infra/proto/gen/secrets/v1"
+	serverpb "github.com/elskow/chef-infra/proto/gen/server/v1"
+	subscriptionpb "github.com/elskow/chef-infra/proto/gen/subscription"
+	webhookpb "github.com/elskow/chef-infra/proto/gen/webhook"
 )
 
 type Server struct {
-	config         *config.AppConfig
-	log            *zap.Logger
-	grpcServer     *grpc.Server
-	authHandler    *auth.Handler
-	authMiddleware *auth.AuthMiddleware
+	config          *config.AppConfig
+	log             *zap.Logger
+	grpcServer      *grpc.Server
+	authHandler     *auth.Handler
+	authMiddleware  *auth.AuthMiddleware
+	pipelineHandler *pipeline.Handler
+	projectHandler  *project.Handler
+}
+
+// authenticatedStream carries the authenticated context into stream handlers
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
 }
 
 type Params struct {
 	fx.In
 
-	Config         *config.AppConfig
-	Logger         *zap.Logger
-	AuthHandler    *auth.Handler
-	AuthMiddleware *auth.AuthMiddleware
+	Config              *config.AppConfig
+	Logger              *zap.Logger
+	AuthHandler         *auth.Handler
+	LegacyAuthHandler   *auth.LegacyHandler
+	AuthMiddleware      *auth.AuthMiddleware
+	AuthService         *auth.Service
+	Events              events.Bus
+	ProjectService      *project.Service
+	Limiter             *cache.Limiter
+	PipelineHandler     *pipeline.Handler
+	PublicHandler       *pipeline.PublicHandler
+	ProjectHandler      *project.Handler
+	DiagnosticsHandler  *diagnostics.Handler
+	WebhookHandler      *webhook.Handler
+	SubscriptionHandler *subscription.Handler
+	SCMHandler          *scm.Handler
+	AgentHandler        *agent.Handler
+	SecretsHandler      *secrets.Handler
 }
 
 func isProtectedEndpoint(method string) bool {
@@ -43,27 +96,180 @@ func isProtectedEndpoint(method string) bool {
 }
 
 func NewServer(p Params) *Server {
-	authInterceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		// Skip authentication for non-protected endpoints
-		if !isProtectedEndpoint(info.FullMethod) {
-			return handler(ctx, req)
+	// limit rejects callers over the rate limit. Counting errors let the
+	// request through rather than failing every call.
+	limit := func(ctx context.Context, method, key string) error {
+		allowed, err := p.Limiter.Allow(ctx, key)
+		if err != nil {
+			p.Logger.Warn("failed to check rate limit", zap.Error(err))
+			return nil
+		}
+		if !allowed {
+			p.Logger.Warn("rate limit exceeded",
+				zap.String("method", method),
+				zap.String("caller", key))
+			return status.Error(codes.ResourceExhausted, i18n.T(ctx, i18n.RateLimited))
+		}
+		return nil
+	}
+
+	// recordImpersonation audits each request made with an impersonation
+	// token under both the admin and the user acted as
+	recordImpersonation := func(ctx context.Context, method, username string) {
+		impersonator, ok := auth.GetImpersonatorFromContext(ctx)
+		if !ok {
+			return
+		}
+		p.Logger.Info("impersonated request",
+			zap.String("method", method),
+			zap.String("admin", impersonator),
+			zap.String("username", username))
+		event := events.Event{
+			Type:    events.AuthImpersonatedRequest,
+			Actor:   impersonator,
+			Subject: username,
+			Message: method,
+		}
+		if err := p.Events.Publish(ctx, event); err != nil {
+			p.Logger.Warn("failed to publish impersonated request", zap.Error(err))
+		}
+	}
+
+	// authorizeRead admits callers to a project read endpoint when the
+	// project's visibility lets them read it. Callers without a token are
+	// anonymous and limited by address.
+	authorizeRead := func(ctx context.Context, method string, req interface{}, requested bool) (context.Context, error) {
+		target, ok := req.(interface{ GetProjectId() string })
+		if !ok {
+			return nil, status.Error(codes.Internal, "request does not name a project")
+		}
+
+		username, key := "", "addr:"+auth.ClientAddr(ctx)
+		if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("authorization")) > 0 {
+			newCtx, err := p.AuthMiddleware.AuthenticationMiddleware(ctx)
+			if err != nil {
+				p.Logger.Warn("authentication failed",
+					zap.String("method", method),
+					zap.Error(err))
+				return nil, status.Error(codes.Unauthenticated, i18n.T(ctx, i18n.AuthRequired))
+			}
+			ctx = newCtx
+			username, _ = auth.GetUserFromContext(ctx)
+			key = "user:" + username
+			recordImpersonation(ctx, method, username)
+			if !requested {
+				if saved, ok := p.AuthService.UserLocale(username); ok {
+					ctx = i18n.WithLocale(ctx, saved)
+				}
+			}
+		}
+		if err := limit(ctx, method, key); err != nil {
+			return nil, err
+		}
+
+		allowed, err := p.ProjectService.CanReadProject(username, target.GetProjectId())
+		if err != nil {
+			p.Logger.Error("failed to check project visibility",
+				zap.String("project", target.GetProjectId()),
+				zap.Error(err))
+			return nil, status.Error(codes.Internal, "failed to check project access")
+		}
+		if !allowed {
+			if username == "" {
+				return nil, status.Error(codes.Unauthenticated, i18n.T(ctx, i18n.AuthRequired))
+			}
+			return nil, status.Error(codes.PermissionDenied, "access to project denied")
+		}
+		return ctx, nil
+	}
+
+	// authorize authenticates the caller and enforces endpoint roles. req
+	// is nil for streams.
+	authorize := func(ctx context.Context, method string, req interface{}) (context.Context, error) {
+		// Messages follow the locale asked for, then the server default
+		// until the caller is known
+		locale, requested := i18n.FromMetadata(ctx)
+		if !requested {
+			locale = p.Config.I18n.Locale()
+		}
+		ctx = i18n.WithLocale(ctx, locale)
+
+		if api.ProjectReadEndpoints[method] {
+			return authorizeRead(ctx, method, req, requested)
+		}
+
+		// Skip authentication for non-protected endpoints, limiting
+		// callers by address instead
+		if !isProtectedEndpoint(method) {
+			if err := limit(ctx, method, "addr:"+auth.ClientAddr(ctx)); err != nil {
+				return nil, err
+			}
+			return ctx, nil
 		}
 
 		// Authenticate the request
 		newCtx, err := p.AuthMiddleware.AuthenticationMiddleware(ctx)
 		if err != nil {
 			p.Logger.Warn("authentication failed",
-				zap.String("method", info.FullMethod),
+				zap.String("method", method),
 				zap.Error(err))
-			return nil, status.Error(codes.Unauthenticated, "authentication required")
+			return nil, status.Error(codes.Unauthenticated, i18n.T(ctx, i18n.AuthRequired))
+		}
+
+		// Without a requested locale the user's saved one applies
+		username, _ := auth.GetUserFromContext(newCtx)
+		recordImpersonation(newCtx, method, username)
+		if err := limit(newCtx, method, "user:"+username); err != nil {
+			return nil, err
+		}
+		if !requested {
+			if saved, ok := p.AuthService.UserLocale(username); ok {
+				newCtx = i18n.WithLocale(newCtx, saved)
+			}
+		}
+
+		// Enforce the admin role on privileged endpoints
+		if api.AdminEndpoints[method] {
+			isAdmin, err := p.AuthService.IsAdmin(username)
+			if err != nil || !isAdmin {
+				p.Logger.Warn("admin access denied",
+					zap.String("method", method),
+					zap.String("username", username))
+				return nil, status.Error(codes.PermissionDenied, i18n.T(newCtx, i18n.AdminRequired))
+			}
+		}
+
+		return newCtx, nil
+	}
+
+	authInterceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		newCtx, err := authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
 		}
 
 		// Call the handler with the authenticated context
 		return handler(newCtx, req)
 	}
 
+	streamAuthInterceptor := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		newCtx, err := authorize(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+
+		if p.Config.GRPC.EnableCompression && api.CompressedEndpoints[info.FullMethod] {
+			compressResponses(ss.Context(), p.Logger, info.FullMethod)
+		}
+
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: newCtx})
+	}
+
+	// Requests are validated once the caller is known, so unauthenticated
+	// callers learn nothing about field rules and messages use their locale
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(authInterceptor),
+		grpc.ChainUnaryInterceptor(authInterceptor, validate.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(streamAuthInterceptor, validate.StreamServerInterceptor()),
 		grpc.MaxRecvMsgSize(p.Config.GRPC.MaxReceiveMessageSize),
 		grpc.MaxSendMsgSize(p.Config.GRPC.MaxSendMessageSize),
 	}
@@ -71,15 +277,28 @@ func NewServer(p Params) *Server {
 	grpcServer := grpc.NewServer(opts...)
 
 	server := &Server{
-		config:         p.Config,
-		log:            p.Logger,
-		grpcServer:     grpcServer,
-		authHandler:    p.AuthHandler,
-		authMiddleware: p.AuthMiddleware,
+		config:          p.Config,
+		log:             p.Logger,
+		grpcServer:      grpcServer,
+		authHandler:     p.AuthHandler,
+		authMiddleware:  p.AuthMiddleware,
+		pipelineHandler: p.PipelineHandler,
+		projectHandler:  p.ProjectHandler,
 	}
 
 	// Register services
 	pb.RegisterAuthServer(grpcServer, p.AuthHandler)
+	legacyauthpb.RegisterAuthServer(grpcServer, p.LegacyAuthHandler)
+	serverpb.RegisterServerServer(grpcServer, &InfoHandler{})
+	pipelinepb.RegisterPipelineServer(grpcServer, p.PipelineHandler)
+	publicpb.RegisterPublicProjectsServer(grpcServer, p.PublicHandler)
+	projectpb.RegisterProjectServer(grpcServer, p.ProjectHandler)
+	diagnosticspb.RegisterDiagnosticsServer(grpcServer, p.DiagnosticsHandler)
+	webhookpb.RegisterWebhookServer(grpcServer, p.WebhookHandler)
+	subscriptionpb.RegisterSubscriptionsServer(grpcServer, p.SubscriptionHandler)
+	scmpb.RegisterSourceControlServer(grpcServer, p.SCMHandler)
+	agentpb.RegisterAgentsServer(grpcServer, p.AgentHandler)
+	secretspb.RegisterSecretsServer(grpcServer, p.SecretsHandler)
 
 	if p.Config.GRPC.EnableReflection {
 		reflection.Register(grpcServer)
@@ -88,6 +307,20 @@ func NewServer(p Params) *Server {
 	return server
 }
 
+// compressResponses switches the stream to gzip when the client accepts it.
+// Clients that don't advertise gzip keep receiving uncompressed messages.
+func compressResponses(ctx context.Context, log *zap.Logger, method string) {
+	accepted, err := grpc.ClientSupportedCompressors(ctx)
+	if err != nil || !slices.Contains(accepted, gzip.Name) {
+		return
+	}
+	if err := grpc.SetSendCompressor(ctx, gzip.Name); err != nil {
+		log.Warn("failed to enable response compression",
+			zap.String("method", method),
+			zap.Error(err))
+	}
+}
+
 func (s *Server) Start() error {
 	addr := fmt.Sprintf("%s:%s", s.config.Server.Host, s.config.Server.Port)
 	lis, err := net.Listen("tcp", addr)
@@ -95,10 +328,10 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	s.log.Info("Starting gRPC server",
+	s.log.Info("Starting gRPC server", append(version.Get().Fields(),
 		zap.String("address", addr),
 		zap.Object("config", serverConfigToField(s.config)),
-	)
+	)...)
 
 	if err := s.grpcServer.Serve(lis); err != nil {
 		return fmt.Errorf("failed to serve: %w", err)
@@ -111,6 +344,7 @@ func serverConfigToField(config *config.AppConfig) zapcore.ObjectMarshaler {
 	return zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
 		enc.AddString("environment", os.Getenv("APP_ENV"))
 		enc.AddBool("reflection_enabled", config.GRPC.EnableReflection)
+		enc.AddBool("compression_enabled", config.GRPC.EnableCompression)
 		enc.AddInt("max_receive_size", config.GRPC.MaxReceiveMessageSize)
 		enc.AddInt("max_send_size", config.GRPC.MaxSendMessageSize)
 		return nil
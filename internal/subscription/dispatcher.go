@@ -0,0 +1,201 @@
+package subscription
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/webhook"
+)
+
+const maxErrorBody = 1024
+
+type notification struct {
+	event   types.LifecycleEvent
+	build   *types.Build
+	message string
+}
+
+// Notify queues the event for the channels subscribed to a matching
+// filter. Events are dropped when the queue is full so builds never wait
+// on slow receivers.
+func (s *Service) Notify(event types.LifecycleEvent, build *types.Build, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+
+	select {
+	case s.queue <- notification{event: event, build: build, message: message}:
+	default:
+		s.log.Warn("subscription queue full, dropping event",
+			zap.String("event", string(event)),
+			zap.String("project_id", build.ProjectID),
+			zap.String("build_id", build.ID))
+	}
+}
+
+// Start launches the delivery workers
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for i := 0; i < s.workers; i++ {
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			for n := range s.queue {
+				s.dispatch(n)
+			}
+		}()
+	}
+}
+
+// Stop delivers the queued events and waits for the workers or ctx
+func (s *Service) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out delivering subscriptions: %w", ctx.Err())
+	}
+}
+
+// dispatch sends the event once to every channel subscribed to a matching
+// filter of users who can access the build's project
+func (s *Service) dispatch(n notification) {
+	subscribed, err := s.repository.ListSubscribed()
+	if err != nil {
+		s.log.Error("failed to list subscriptions", zap.Error(err))
+		return
+	}
+
+	for i := range subscribed {
+		prefs := &subscribed[i]
+		channels := matchingChannels(prefs, n.event, n.build)
+		if len(channels) == 0 {
+			continue
+		}
+		allowed, err := s.authorizer.CanAccessProject(prefs.Username, n.build.ProjectID)
+		if err != nil {
+			s.log.Error("failed to check project access",
+				zap.String("username", prefs.Username),
+				zap.String("project_id", n.build.ProjectID),
+				zap.Error(err))
+			continue
+		}
+		if !allowed {
+			continue
+		}
+
+		for _, channel := range channels {
+			if err := s.send(context.Background(), channel, n); err != nil {
+				s.log.Warn("failed to notify subscription channel",
+					zap.String("username", prefs.Username),
+					zap.String("channel", channel.Name),
+					zap.String("event", string(n.event)),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// matchingChannels returns the channels subscribed to a filter matching
+// the event, each once
+func matchingChannels(prefs *Preferences, event types.LifecycleEvent, build *types.Build) []*Channel {
+	var channels []*Channel
+	for _, sub := range prefs.Subscriptions {
+		filter, channel := prefs.filter(sub.Filter), prefs.channel(sub.Channel)
+		if filter == nil || channel == nil || !filter.Matches(event, build) {
+			continue
+		}
+		seen := false
+		for _, c := range channels {
+			seen = seen || c == channel
+		}
+		if !seen {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+func (s *Service) send(ctx context.Context, channel *Channel, n notification) error {
+	var body []byte
+	headers := map[string]string{"Content-Type": "application/json"}
+	switch channel.Type {
+	case ChannelWebhook:
+		payload, err := webhook.NewPayload(n.event, n.build, n.message)
+		if err != nil {
+			return err
+		}
+		if body, err = json.Marshal(payload); err != nil {
+			return err
+		}
+		headers[webhook.EventHeader] = payload.Event
+		headers[webhook.DeliveryHeader] = payload.ID
+		headers[webhook.SignatureHeader] = webhook.Sign(channel.Secret, body)
+	case ChannelSlack:
+		var err error
+		if body, err = json.Marshal(map[string]string{"text": slackText(n)}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported channel type %q", channel.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "chef-infra-webhook")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// slackText summarizes the event, e.g.
+// "[web] build.failed: a1b2c3d on main by Jane: Fix checkout (exit code 1)"
+func slackText(n notification) string {
+	text := fmt.Sprintf("[%s] %s: %s", n.build.ProjectID, n.event, n.build.Describe())
+	if n.message != "" {
+		text += " (" + n.message + ")"
+	}
+	return text
+}
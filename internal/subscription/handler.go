@@ -0,0 +1,170 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	pb "github.com/elskow/chef-infra/proto/gen/subscription"
+)
+
+// Handler serves the caller's own preferences, project access is checked
+// when events are delivered
+type Handler struct {
+	pb.UnimplementedSubscriptionsServer
+	service *Service
+	log     *zap.Logger
+}
+
+func NewHandler(service *Service, log *zap.Logger) *Handler {
+	return &Handler{
+		service: service,
+		log:     log,
+	}
+}
+
+func (h *Handler) GetPreferences(ctx context.Context, _ *pb.GetPreferencesRequest) (*pb.PreferencesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	prefs, err := h.service.Preferences(username)
+	if err != nil {
+		return nil, h.statusError(username, "get preferences", err)
+	}
+	return toProto(prefs), nil
+}
+
+func (h *Handler) SaveFilter(ctx context.Context, req *pb.SaveFilterRequest) (*pb.PreferencesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if req.Filter == nil {
+		return nil, status.Error(codes.InvalidArgument, "filter is required")
+	}
+	prefs, err := h.service.SaveFilter(username, Filter{
+		Name:         req.Filter.Name,
+		Projects:     req.Filter.Projects,
+		Events:       req.Filter.Events,
+		Environments: req.Filter.Environments,
+		Branches:     req.Filter.Branches,
+		Labels:       req.Filter.Labels,
+	})
+	if err != nil {
+		return nil, h.statusError(username, "save filter", err)
+	}
+	return toProto(prefs), nil
+}
+
+func (h *Handler) DeleteFilter(ctx context.Context, req *pb.DeleteFilterRequest) (*pb.PreferencesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	prefs, err := h.service.DeleteFilter(username, req.Name)
+	if err != nil {
+		return nil, h.statusError(username, "delete filter", err)
+	}
+	return toProto(prefs), nil
+}
+
+func (h *Handler) SaveChannel(ctx context.Context, req *pb.SaveChannelRequest) (*pb.PreferencesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if req.Channel == nil {
+		return nil, status.Error(codes.InvalidArgument, "channel is required")
+	}
+	prefs, err := h.service.SaveChannel(username, Channel{
+		Name:   req.Channel.Name,
+		Type:   req.Channel.Type,
+		URL:    req.Channel.Url,
+		Secret: req.Channel.Secret,
+	})
+	if err != nil {
+		return nil, h.statusError(username, "save channel", err)
+	}
+	resp := toProto(prefs)
+	if channel := prefs.channel(req.Channel.Name); channel != nil {
+		resp.Secret = channel.Secret
+	}
+	return resp, nil
+}
+
+func (h *Handler) DeleteChannel(ctx context.Context, req *pb.DeleteChannelRequest) (*pb.PreferencesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	prefs, err := h.service.DeleteChannel(username, req.Name)
+	if err != nil {
+		return nil, h.statusError(username, "delete channel", err)
+	}
+	return toProto(prefs), nil
+}
+
+func (h *Handler) Subscribe(ctx context.Context, req *pb.SubscribeRequest) (*pb.PreferencesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	prefs, err := h.service.Subscribe(username, req.Filter, req.Channel)
+	if err != nil {
+		return nil, h.statusError(username, "subscribe", err)
+	}
+	return toProto(prefs), nil
+}
+
+func (h *Handler) Unsubscribe(ctx context.Context, req *pb.SubscribeRequest) (*pb.PreferencesResponse, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	prefs, err := h.service.Unsubscribe(username, req.Filter, req.Channel)
+	if err != nil {
+		return nil, h.statusError(username, "unsubscribe", err)
+	}
+	return toProto(prefs), nil
+}
+
+// statusError maps service errors to gRPC statuses
+func (h *Handler) statusError(username, action string, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidFilter), errors.Is(err, ErrInvalidChannel):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrLimitExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	h.log.Error("failed to "+action, zap.String("username", username), zap.Error(err))
+	return status.Error(codes.Internal, "failed to "+action)
+}
+
+// toProto leaves out channel secrets
+func toProto(prefs *Preferences) *pb.PreferencesResponse {
+	resp := &pb.PreferencesResponse{}
+	for _, f := range prefs.Filters {
+		resp.Filters = append(resp.Filters, &pb.Filter{
+			Name:         f.Name,
+			Projects:     f.Projects,
+			Events:       f.Events,
+			Environments: f.Environments,
+			Branches:     f.Branches,
+			Labels:       f.Labels,
+		})
+	}
+	for _, c := range prefs.Channels {
+		resp.Channels = append(resp.Channels, &pb.Channel{Name: c.Name, Type: c.Type, Url: c.URL})
+	}
+	for _, s := range prefs.Subscriptions {
+		resp.Subscriptions = append(resp.Subscriptions, &pb.Subscription{Filter: s.Filter, Channel: s.Channel})
+	}
+	return resp
+}
@@ -0,0 +1,68 @@
+package subscription
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+type mockRepository struct {
+	prefs map[string]*Preferences
+	mu    sync.Mutex
+}
+
+func newMockRepository() *mockRepository {
+	return &mockRepository{prefs: make(map[string]*Preferences)}
+}
+
+// copyPreferences keeps callers from modifying stored preferences
+func copyPreferences(prefs *Preferences) *Preferences {
+	data, _ := json.Marshal(prefs)
+	var copied Preferences
+	_ = json.Unmarshal(data, &copied)
+	return &copied
+}
+
+func (r *mockRepository) GetPreferences(username string) (*Preferences, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prefs, exists := r.prefs[username]
+	if !exists {
+		return &Preferences{Username: username}, nil
+	}
+	return copyPreferences(prefs), nil
+}
+
+func (r *mockRepository) UpdatePreferences(username string, update func(*Preferences) error) (*Preferences, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prefs := &Preferences{Username: username}
+	if stored, exists := r.prefs[username]; exists {
+		prefs = copyPreferences(stored)
+	}
+	if err := update(prefs); err != nil {
+		return nil, err
+	}
+	prefs.UpdatedAt = time.Now()
+	r.prefs[username] = copyPreferences(prefs)
+	return prefs, nil
+}
+
+func (r *mockRepository) ListSubscribed() ([]Preferences, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var subscribed []Preferences
+	for _, prefs := range r.prefs {
+		if len(prefs.Subscriptions) > 0 {
+			subscribed = append(subscribed, *copyPreferences(prefs))
+		}
+	}
+	sort.Slice(subscribed, func(i, j int) bool {
+		return subscribed[i].Username < subscribed[j].Username
+	})
+	return subscribed, nil
+}
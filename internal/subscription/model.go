@@ -0,0 +1,65 @@
+package subscription
+
+import "time"
+
+// Preferences holds a user's saved filters, notification channels and the
+// subscriptions connecting them, as a single record per user
+type Preferences struct {
+	Username      string         `gorm:"primaryKey"`
+	Filters       []Filter       `gorm:"serializer:json"`
+	Channels      []Channel      `gorm:"serializer:json"`
+	Subscriptions []Subscription `gorm:"serializer:json"`
+	UpdatedAt     time.Time
+}
+
+func (Preferences) TableName() string {
+	return "user_preferences"
+}
+
+// Filter selects build and deploy events, e.g. failures of one project or
+// production deploys. Empty fields match anything.
+type Filter struct {
+	Name         string   `json:"name"`
+	Projects     []string `json:"projects,omitempty"` // Only projects the user can access match
+	Events       []string `json:"events,omitempty"`   // e.g. build.failed, deploy.succeeded
+	Environments []string `json:"environments,omitempty"`
+	Branches     []string `json:"branches,omitempty"`
+	Labels       []string `json:"labels,omitempty"` // Builds must carry all of them
+}
+
+// Channel is where notifications of a user's subscriptions are sent
+type Channel struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"` // ChannelWebhook or ChannelSlack
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"` // HMAC key of webhook channels
+}
+
+const (
+	ChannelWebhook = "webhook" // Signed JSON payloads like project webhooks
+	ChannelSlack   = "slack"   // Slack incoming webhook
+)
+
+// Subscription sends the events matching a filter to a channel
+type Subscription struct {
+	Filter  string `json:"filter"`
+	Channel string `json:"channel"`
+}
+
+func (p *Preferences) filter(name string) *Filter {
+	for i := range p.Filters {
+		if p.Filters[i].Name == name {
+			return &p.Filters[i]
+		}
+	}
+	return nil
+}
+
+func (p *Preferences) channel(name string) *Channel {
+	for i := range p.Channels {
+		if p.Channels[i].Name == name {
+			return &p.Channels[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package subscription
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	// GetPreferences returns empty preferences for users without any
+	GetPreferences(username string) (*Preferences, error)
+	// UpdatePreferences applies update to the user's preferences and stores
+	// them unless update fails. Concurrent updates of a user are serialized.
+	UpdatePreferences(username string, update func(*Preferences) error) (*Preferences, error)
+	// ListSubscribed returns the preferences of users with subscriptions
+	ListSubscribed() ([]Preferences, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) GetPreferences(username string) (*Preferences, error) {
+	var prefs Preferences
+	err := r.db.Where("username = ?", username).First(&prefs).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Preferences{Username: username}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+func (r *repository) UpdatePreferences(username string, update func(*Preferences) error) (*Preferences, error) {
+	var prefs Preferences
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Preferences{Username: username}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("username = ?", username).First(&prefs).Error; err != nil {
+			return err
+		}
+		if err := update(&prefs); err != nil {
+			return err
+		}
+		return tx.Model(&prefs).Select("filters", "channels", "subscriptions").Updates(&prefs).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+func (r *repository) ListSubscribed() ([]Preferences, error) {
+	var prefs []Preferences
+	err := r.db.
+		Where("jsonb_typeof(subscriptions) = 'array' AND jsonb_array_length(subscriptions) > 0").
+		Find(&prefs).Error
+	return prefs, err
+}
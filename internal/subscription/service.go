@@ -0,0 +1,255 @@
+// Package subscription stores per-user preferences: named build and deploy
+// filters, notification channels, and subscriptions sending the events
+// matching a filter to a channel.
+package subscription
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/webhook"
+)
+
+const (
+	// maxEntries caps the filters, channels and subscriptions of a user
+	maxEntries = 50
+
+	defaultTimeout   = 10 * time.Second
+	defaultWorkers   = 2
+	defaultQueueSize = 256
+)
+
+var (
+	ErrInvalidFilter  = errors.New("invalid filter")
+	ErrInvalidChannel = errors.New("invalid channel")
+	ErrNotFound       = errors.New("not found")
+	ErrLimitExceeded  = fmt.Errorf("at most %d filters, channels and subscriptions are allowed", maxEntries)
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._-]{0,63}$`)
+
+// ProjectAuthorizer decides whether a user may see a project's events
+type ProjectAuthorizer interface {
+	CanAccessProject(username, projectID string) (bool, error)
+}
+
+// Service manages user preferences and notifies subscribed channels of
+// the lifecycle events matching their filters
+type Service struct {
+	repository Repository
+	authorizer ProjectAuthorizer
+	client     *http.Client
+	workers    int
+	log        *zap.Logger
+
+	queue   chan notification
+	running sync.WaitGroup
+	mu      sync.Mutex
+	started bool
+	stopped bool
+}
+
+// NewService delivers with the timeout and address policy of project
+// webhooks
+func NewService(repo Repository, authorizer ProjectAuthorizer, cfg *config.WebhookConfig, log *zap.Logger) *Service {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Service{
+		repository: repo,
+		authorizer: authorizer,
+		client:     webhook.NewHTTPClient(timeout, cfg.AllowPrivateURLs),
+		workers:    defaultWorkers,
+		log:        log,
+		queue:      make(chan notification, defaultQueueSize),
+	}
+}
+
+func (s *Service) Preferences(username string) (*Preferences, error) {
+	return s.repository.GetPreferences(username)
+}
+
+// SaveFilter creates the filter or replaces the one of the same name
+func (s *Service) SaveFilter(username string, filter Filter) (*Preferences, error) {
+	if err := validateFilter(&filter); err != nil {
+		return nil, err
+	}
+	return s.repository.UpdatePreferences(username, func(prefs *Preferences) error {
+		if existing := prefs.filter(filter.Name); existing != nil {
+			*existing = filter
+			return nil
+		}
+		if len(prefs.Filters) >= maxEntries {
+			return ErrLimitExceeded
+		}
+		prefs.Filters = append(prefs.Filters, filter)
+		return nil
+	})
+}
+
+// DeleteFilter removes the filter and the subscriptions to it
+func (s *Service) DeleteFilter(username, name string) (*Preferences, error) {
+	return s.repository.UpdatePreferences(username, func(prefs *Preferences) error {
+		if prefs.filter(name) == nil {
+			return fmt.Errorf("filter %q %w", name, ErrNotFound)
+		}
+		prefs.Filters = slices.DeleteFunc(prefs.Filters, func(f Filter) bool { return f.Name == name })
+		prefs.Subscriptions = slices.DeleteFunc(prefs.Subscriptions, func(sub Subscription) bool { return sub.Filter == name })
+		return nil
+	})
+}
+
+// SaveChannel creates the channel or replaces the one of the same name.
+// Webhook channels get a secret generated when none is given or kept.
+func (s *Service) SaveChannel(username string, channel Channel) (*Preferences, error) {
+	if err := validateChannel(&channel); err != nil {
+		return nil, err
+	}
+	return s.repository.UpdatePreferences(username, func(prefs *Preferences) error {
+		existing := prefs.channel(channel.Name)
+		if channel.Type == ChannelWebhook && channel.Secret == "" {
+			if existing != nil && existing.Type == ChannelWebhook {
+				channel.Secret = existing.Secret
+			} else {
+				secret, err := generateSecret()
+				if err != nil {
+					return err
+				}
+				channel.Secret = secret
+			}
+		}
+		if channel.Type != ChannelWebhook {
+			channel.Secret = ""
+		}
+
+		if existing != nil {
+			*existing = channel
+			return nil
+		}
+		if len(prefs.Channels) >= maxEntries {
+			return ErrLimitExceeded
+		}
+		prefs.Channels = append(prefs.Channels, channel)
+		return nil
+	})
+}
+
+// DeleteChannel removes the channel and the subscriptions using it
+func (s *Service) DeleteChannel(username, name string) (*Preferences, error) {
+	return s.repository.UpdatePreferences(username, func(prefs *Preferences) error {
+		if prefs.channel(name) == nil {
+			return fmt.Errorf("channel %q %w", name, ErrNotFound)
+		}
+		prefs.Channels = slices.DeleteFunc(prefs.Channels, func(c Channel) bool { return c.Name == name })
+		prefs.Subscriptions = slices.DeleteFunc(prefs.Subscriptions, func(sub Subscription) bool { return sub.Channel == name })
+		return nil
+	})
+}
+
+// Subscribe sends the events matching a saved filter to a saved channel.
+// Subscribing twice is a no-op.
+func (s *Service) Subscribe(username, filter, channel string) (*Preferences, error) {
+	return s.repository.UpdatePreferences(username, func(prefs *Preferences) error {
+		if prefs.filter(filter) == nil {
+			return fmt.Errorf("filter %q %w", filter, ErrNotFound)
+		}
+		if prefs.channel(channel) == nil {
+			return fmt.Errorf("channel %q %w", channel, ErrNotFound)
+		}
+		sub := Subscription{Filter: filter, Channel: channel}
+		if slices.Contains(prefs.Subscriptions, sub) {
+			return nil
+		}
+		if len(prefs.Subscriptions) >= maxEntries {
+			return ErrLimitExceeded
+		}
+		prefs.Subscriptions = append(prefs.Subscriptions, sub)
+		return nil
+	})
+}
+
+func (s *Service) Unsubscribe(username, filter, channel string) (*Preferences, error) {
+	return s.repository.UpdatePreferences(username, func(prefs *Preferences) error {
+		sub := Subscription{Filter: filter, Channel: channel}
+		if !slices.Contains(prefs.Subscriptions, sub) {
+			return fmt.Errorf("subscription of %q to %q %w", channel, filter, ErrNotFound)
+		}
+		prefs.Subscriptions = slices.DeleteFunc(prefs.Subscriptions, func(existing Subscription) bool { return existing == sub })
+		return nil
+	})
+}
+
+// Matches reports whether the filter selects the event of build. Empty
+// fields match anything, a build must carry all of the filter's labels.
+func (f *Filter) Matches(event types.LifecycleEvent, build *types.Build) bool {
+	if len(f.Projects) > 0 && !slices.Contains(f.Projects, build.ProjectID) {
+		return false
+	}
+	if len(f.Events) > 0 && !slices.Contains(f.Events, string(event)) {
+		return false
+	}
+	if len(f.Environments) > 0 && !slices.Contains(f.Environments, build.Environment) {
+		return false
+	}
+	if len(f.Branches) > 0 && (build.Commit == nil || !slices.Contains(f.Branches, build.Commit.Branch)) {
+		return false
+	}
+	for _, label := range f.Labels {
+		if !slices.Contains(build.Labels, label) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateFilter(filter *Filter) error {
+	if !validName.MatchString(filter.Name) {
+		return fmt.Errorf("%w: name must be 1-64 letters, digits, spaces, dots, dashes or underscores", ErrInvalidFilter)
+	}
+	for _, event := range filter.Events {
+		if !slices.Contains(types.LifecycleEvents, types.LifecycleEvent(event)) {
+			return fmt.Errorf("%w: unknown event %s", ErrInvalidFilter, event)
+		}
+	}
+	for _, label := range filter.Labels {
+		if !types.ValidLabel(label) {
+			return fmt.Errorf("%w: invalid label %q", ErrInvalidFilter, label)
+		}
+	}
+	return nil
+}
+
+func validateChannel(channel *Channel) error {
+	if !validName.MatchString(channel.Name) {
+		return fmt.Errorf("%w: name must be 1-64 letters, digits, spaces, dots, dashes or underscores", ErrInvalidChannel)
+	}
+	if channel.Type != ChannelWebhook && channel.Type != ChannelSlack {
+		return fmt.Errorf("%w: type must be %s or %s", ErrInvalidChannel, ChannelWebhook, ChannelSlack)
+	}
+	parsed, err := url.Parse(channel.URL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return fmt.Errorf("%w: URL must be an absolute http or https URL", ErrInvalidChannel)
+	}
+	return nil
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate channel secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
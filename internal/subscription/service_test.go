@@ -0,0 +1,213 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+	"github.com/elskow/chef-infra/internal/webhook"
+)
+
+// receiver is a channel endpoint that records requests
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// projectAccess grants users the listed projects
+type projectAccess map[string][]string
+
+func (a projectAccess) CanAccessProject(username, projectID string) (bool, error) {
+	for _, id := range a[username] {
+		if id == projectID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newTestService(t *testing.T, access projectAccess) *Service {
+	t.Helper()
+	svc := NewService(newMockRepository(), access, &config.WebhookConfig{AllowPrivateURLs: true}, zap.NewNop())
+	svc.Start()
+	t.Cleanup(func() { svc.Stop(context.Background()) })
+	return svc
+}
+
+func testBuild() *types.Build {
+	return &types.Build{
+		ID:          "build-1",
+		ProjectID:   "web",
+		CommitHash:  "abc1234def",
+		Commit:      &types.CommitInfo{Author: "Jane", Message: "Fix checkout", Branch: "main"},
+		Status:      types.BuildStatusFailed,
+		Environment: "production",
+		Labels:      []string{"release", "hotfix"},
+		StartTime:   time.Now(),
+	}
+}
+
+func TestService_SaveFilter(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr error
+	}{
+		{name: "valid", filter: Filter{Name: "web failures", Projects: []string{"web"}, Events: []string{"build.failed"}}},
+		{name: "everything", filter: Filter{Name: "all"}},
+		{name: "missing name", filter: Filter{}, wantErr: ErrInvalidFilter},
+		{name: "unknown event", filter: Filter{Name: "x", Events: []string{"build.exploded"}}, wantErr: ErrInvalidFilter},
+		{name: "invalid label", filter: Filter{Name: "x", Labels: []string{"Not A Label"}}, wantErr: ErrInvalidFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.SaveFilter("jane", tt.filter)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	// Saving a filter of the same name replaces it
+	prefs, err := svc.SaveFilter("jane", Filter{Name: "all", Environments: []string{"production"}})
+	require.NoError(t, err)
+	require.Len(t, prefs.Filters, 2)
+	assert.Equal(t, []string{"production"}, prefs.Filters[1].Environments)
+}
+
+func TestService_Channels(t *testing.T) {
+	svc := newTestService(t, nil)
+
+	_, err := svc.SaveChannel("jane", Channel{Name: "ops", Type: "email", URL: "https://example.com"})
+	assert.ErrorIs(t, err, ErrInvalidChannel)
+	_, err = svc.SaveChannel("jane", Channel{Name: "ops", Type: ChannelSlack, URL: "/hooks"})
+	assert.ErrorIs(t, err, ErrInvalidChannel)
+
+	prefs, err := svc.SaveChannel("jane", Channel{Name: "ops", Type: ChannelWebhook, URL: "https://hooks.example.com"})
+	require.NoError(t, err)
+	secret := prefs.Channels[0].Secret
+	assert.Len(t, secret, 64)
+
+	// Updating the URL keeps the secret
+	prefs, err = svc.SaveChannel("jane", Channel{Name: "ops", Type: ChannelWebhook, URL: "https://hooks.example.com/v2"})
+	require.NoError(t, err)
+	assert.Equal(t, secret, prefs.Channels[0].Secret)
+
+	_, err = svc.SaveFilter("jane", Filter{Name: "failures", Events: []string{"build.failed"}})
+	require.NoError(t, err)
+	_, err = svc.Subscribe("jane", "failures", "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = svc.Subscribe("jane", "failures", "ops")
+	require.NoError(t, err)
+	prefs, err = svc.Subscribe("jane", "failures", "ops")
+	require.NoError(t, err)
+	assert.Len(t, prefs.Subscriptions, 1)
+
+	// Deleting the channel removes its subscriptions
+	prefs, err = svc.DeleteChannel("jane", "ops")
+	require.NoError(t, err)
+	assert.Empty(t, prefs.Channels)
+	assert.Empty(t, prefs.Subscriptions)
+	assert.Len(t, prefs.Filters, 1)
+
+	// Preferences are per user
+	prefs, err = svc.Preferences("john")
+	require.NoError(t, err)
+	assert.Empty(t, prefs.Filters)
+}
+
+func TestFilter_Matches(t *testing.T) {
+	build := testBuild()
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty", want: true},
+		{name: "project failures", filter: Filter{Projects: []string{"web"}, Events: []string{"build.failed"}}, want: true},
+		{name: "other event", filter: Filter{Events: []string{"deploy.succeeded"}}},
+		{name: "other project", filter: Filter{Projects: []string{"api"}}},
+		{name: "environment", filter: Filter{Environments: []string{"production"}}, want: true},
+		{name: "other branch", filter: Filter{Branches: []string{"develop"}}},
+		{name: "all labels", filter: Filter{Labels: []string{"hotfix", "release"}}, want: true},
+		{name: "missing label", filter: Filter{Labels: []string{"hotfix", "canary"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Matches(types.LifecycleBuildFailed, build))
+		})
+	}
+}
+
+func TestService_Notify(t *testing.T) {
+	hooks, slack := &receiver{}, &receiver{}
+	hookServer, slackServer := httptest.NewServer(hooks), httptest.NewServer(slack)
+	defer hookServer.Close()
+	defer slackServer.Close()
+
+	svc := newTestService(t, projectAccess{"jane": {"web"}})
+	for _, username := range []string{"jane", "john"} {
+		_, err := svc.SaveFilter(username, Filter{Name: "web failures", Projects: []string{"web"}, Events: []string{"build.failed"}})
+		require.NoError(t, err)
+		_, err = svc.SaveFilter(username, Filter{Name: "production", Environments: []string{"production"}})
+		require.NoError(t, err)
+		_, err = svc.SaveChannel(username, Channel{Name: "hook", Type: ChannelWebhook, URL: hookServer.URL, Secret: "s3cret"})
+		require.NoError(t, err)
+		_, err = svc.SaveChannel(username, Channel{Name: "slack", Type: ChannelSlack, URL: slackServer.URL})
+		require.NoError(t, err)
+		for _, sub := range []Subscription{{"web failures", "hook"}, {"production", "hook"}, {"production", "slack"}} {
+			_, err = svc.Subscribe(username, sub.Filter, sub.Channel)
+			require.NoError(t, err)
+		}
+	}
+
+	svc.Notify(types.LifecycleBuildFailed, testBuild(), "exit code 1")
+	require.NoError(t, svc.Stop(context.Background()))
+
+	// Sent once per channel despite two matching filters, and not to john
+	// who cannot access the project
+	require.Equal(t, 1, hooks.count())
+	require.Equal(t, 1, slack.count())
+
+	req, body := hooks.requests[0], hooks.bodies[0]
+	assert.Equal(t, webhook.Sign("s3cret", body), req.Header.Get(webhook.SignatureHeader))
+	var payload webhook.Payload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "build.failed", payload.Event)
+	assert.Equal(t, "build-1", payload.Build.ID)
+
+	var message map[string]string
+	require.NoError(t, json.Unmarshal(slack.bodies[0], &message))
+	assert.Equal(t, "[web] build.failed: abc1234 on main by Jane: Fix checkout (exit code 1)", message["text"])
+}
@@ -0,0 +1,102 @@
+// Package trigger decides whether a push to a repository should start a
+// build.
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const branchPrefix = "refs/heads/"
+
+// Filters restrict which pushes trigger builds. Empty lists allow
+// everything.
+type Filters struct {
+	Branches []string // Branch globs, e.g. main or release/*
+	Paths    []string // Changed-path globs, e.g. web/**
+}
+
+func (f Filters) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Branches...), f.Paths...) {
+		if err := ValidatePattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Push is the part of a provider push event the filters look at
+type Push struct {
+	Ref     string // e.g. refs/heads/main
+	Before  string // Commit before the push, all zeros for a new branch
+	After   string
+	Deleted bool
+	// ChangedPaths lists the files touched by the pushed commits.
+	// PathsComplete is false when the provider truncated the list.
+	ChangedPaths  []string
+	PathsComplete bool
+}
+
+// Branch returns the pushed branch, empty for tags
+func (p Push) Branch() string {
+	if !strings.HasPrefix(p.Ref, branchPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(p.Ref, branchPrefix)
+}
+
+// NewBranch reports whether the push created the branch, in which case
+// there is no previous commit to diff against
+func (p Push) NewBranch() bool {
+	return strings.Trim(p.Before, "0") == ""
+}
+
+// DiffFunc lists the files changed between two commits. It reports false
+// when the provider could not return the complete list.
+type DiffFunc func(ctx context.Context, before, after string) ([]string, bool, error)
+
+// Decision is the outcome of evaluating a push
+type Decision struct {
+	Build  bool
+	Reason string // Why the push was skipped
+}
+
+// Evaluate applies the filters to a push. When path filters are set and
+// the push lists only part of its changes, diff fetches the full list.
+// Pushes whose changes cannot be determined are built rather than skipped.
+func Evaluate(ctx context.Context, filters Filters, push Push, diff DiffFunc) (Decision, error) {
+	if push.Deleted {
+		return Decision{Reason: "branch deleted"}, nil
+	}
+
+	if len(filters.Branches) > 0 {
+		branch := push.Branch()
+		if branch == "" || !matchList(filters.Branches, branch) {
+			return Decision{Reason: fmt.Sprintf("%s does not match the branch filters", push.Ref)}, nil
+		}
+	}
+
+	if len(filters.Paths) == 0 || push.NewBranch() {
+		return Decision{Build: true}, nil
+	}
+
+	changed, complete := push.ChangedPaths, push.PathsComplete
+	if !complete && diff != nil {
+		var err error
+		changed, complete, err = diff(ctx, push.Before, push.After)
+		if err != nil {
+			return Decision{}, fmt.Errorf("failed to list changed files: %w", err)
+		}
+	}
+	if !complete {
+		return Decision{Build: true}, nil
+	}
+
+	for _, file := range changed {
+		if matchList(filters.Paths, file) {
+			return Decision{Build: true}, nil
+		}
+	}
+	return Decision{Reason: "no changed files match the path filters"}, nil
+}
@@ -0,0 +1,131 @@
+package trigger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"main", "main", true},
+		{"main", "maintenance", false},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/hotfix", false},
+		{"release/**", "release/1.0/hotfix", true},
+		{"web/**", "web/src/app.tsx", true},
+		{"web/**", "api/main.go", false},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/setup.md", true},
+		{"packages/*/package.json", "packages/ui/package.json", true},
+		{"feature-?", "feature-a", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.match, Match(tt.pattern, tt.name))
+		})
+	}
+}
+
+func TestFilters_Validate(t *testing.T) {
+	assert.NoError(t, Filters{Branches: []string{"main", "release/*"}, Paths: []string{"web/**", "!web/docs/**"}}.Validate())
+	assert.ErrorIs(t, Filters{Branches: []string{""}}.Validate(), ErrInvalidPattern)
+	assert.ErrorIs(t, Filters{Paths: []string{"web/[a"}}.Validate(), ErrInvalidPattern)
+	assert.ErrorIs(t, Filters{Paths: []string{"!"}}.Validate(), ErrInvalidPattern)
+}
+
+func TestEvaluate(t *testing.T) {
+	const before = "1111111111111111111111111111111111111111"
+	webOnly := Filters{Branches: []string{"main", "release/*"}, Paths: []string{"web/**", "!web/**/*.md"}}
+
+	tests := []struct {
+		name    string
+		filters Filters
+		push    Push
+		diff    DiffFunc
+		build   bool
+	}{
+		{
+			name:  "no filters",
+			push:  Push{Ref: "refs/heads/anything", Before: before},
+			build: true,
+		},
+		{
+			name:    "branch not matched",
+			filters: webOnly,
+			push:    Push{Ref: "refs/heads/feature", Before: before, ChangedPaths: []string{"web/app.ts"}, PathsComplete: true},
+		},
+		{
+			name:    "tag with branch filters",
+			filters: webOnly,
+			push:    Push{Ref: "refs/tags/v1.0", Before: before},
+		},
+		{
+			name:    "matching path",
+			filters: webOnly,
+			push:    Push{Ref: "refs/heads/release/2.0", Before: before, ChangedPaths: []string{"api/main.go", "web/app.ts"}, PathsComplete: true},
+			build:   true,
+		},
+		{
+			name:    "only excluded paths",
+			filters: webOnly,
+			push:    Push{Ref: "refs/heads/main", Before: before, ChangedPaths: []string{"web/docs/README.md", "api/main.go"}, PathsComplete: true},
+		},
+		{
+			name:    "truncated payload uses the diff",
+			filters: webOnly,
+			push:    Push{Ref: "refs/heads/main", Before: before, ChangedPaths: []string{"api/main.go"}},
+			diff: func(context.Context, string, string) ([]string, bool, error) {
+				return []string{"api/main.go", "web/index.html"}, true, nil
+			},
+			build: true,
+		},
+		{
+			name:    "incomplete diff builds",
+			filters: webOnly,
+			push:    Push{Ref: "refs/heads/main", Before: before},
+			diff: func(context.Context, string, string) ([]string, bool, error) {
+				return []string{"api/main.go"}, false, nil
+			},
+			build: true,
+		},
+		{
+			name:    "new branch builds",
+			filters: webOnly,
+			push:    Push{Ref: "refs/heads/main", Before: "0000000000000000000000000000000000000000", PathsComplete: true},
+			build:   true,
+		},
+		{
+			name: "deleted branch",
+			push: Push{Ref: "refs/heads/main", Before: before, Deleted: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := Evaluate(context.Background(), tt.filters, tt.push, tt.diff)
+			require.NoError(t, err)
+			assert.Equal(t, tt.build, decision.Build)
+			if !tt.build {
+				assert.NotEmpty(t, decision.Reason)
+			}
+		})
+	}
+}
+
+func TestEvaluate_DiffError(t *testing.T) {
+	failing := func(context.Context, string, string) ([]string, bool, error) {
+		return nil, false, errors.New("rate limited")
+	}
+	_, err := Evaluate(context.Background(), Filters{Paths: []string{"web/**"}},
+		Push{Ref: "refs/heads/main", Before: "1111111111111111111111111111111111111111"}, failing)
+	assert.ErrorContains(t, err, "rate limited")
+}
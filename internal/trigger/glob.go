@@ -0,0 +1,69 @@
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var ErrInvalidPattern = errors.New("invalid filter pattern")
+
+// Match reports whether name matches a glob pattern. Patterns are matched
+// per "/" separated segment: "*", "?" and character classes stay within a
+// segment and "**" matches any number of segments, so "release/*" matches
+// release/1.0 but not release/1.0/hotfix, and "web/**" matches everything
+// under web/.
+func Match(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ValidatePattern rejects empty and malformed patterns. A leading "!"
+// negates the pattern.
+func ValidatePattern(pattern string) error {
+	glob := strings.TrimPrefix(pattern, "!")
+	if glob == "" {
+		return fmt.Errorf("%w: empty pattern", ErrInvalidPattern)
+	}
+	for _, segment := range strings.Split(glob, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+		}
+	}
+	return nil
+}
+
+// matchList evaluates patterns in order and the last one matching name
+// decides, so "!docs/**" after "**" excludes docs
+func matchList(patterns []string, name string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if Match(strings.TrimPrefix(pattern, "!"), name) {
+			matched = !negated
+		}
+	}
+	return matched
+}
@@ -0,0 +1,140 @@
+// Package validate checks request messages against the field rules
+// declared in their proto definitions (see proto/validate/validate.proto).
+package validate
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/elskow/chef-infra/internal/i18n"
+	validatepb "github.com/elskow/chef-infra/proto/gen/validate"
+)
+
+// patterns caches compiled field patterns by expression
+var patterns sync.Map
+
+// Message checks msg and the messages nested in it against their field
+// rules. The first violation is returned as an InvalidArgument status
+// rendered in the request's locale.
+func Message(ctx context.Context, msg proto.Message) error {
+	if msg == nil {
+		return nil
+	}
+	return check(ctx, msg.ProtoReflect())
+}
+
+func check(ctx context.Context, m protoreflect.Message) error {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if err := checkField(ctx, m, fd); err != nil {
+			return err
+		}
+
+		if fd.Message() == nil || !m.Has(fd) {
+			continue
+		}
+		switch {
+		case fd.IsList():
+			list := m.Get(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				if err := check(ctx, list.Get(j).Message()); err != nil {
+					return err
+				}
+			}
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				continue
+			}
+			var err error
+			m.Get(fd).Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				err = check(ctx, v.Message())
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+		default:
+			if err := check(ctx, m.Get(fd).Message()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkField applies the rules declared on a single field
+func checkField(ctx context.Context, m protoreflect.Message, fd protoreflect.FieldDescriptor) error {
+	opts := fd.Options()
+	if opts == nil {
+		return nil
+	}
+	name := i18n.Field(ctx, string(fd.Name()))
+
+	if proto.GetExtension(opts, validatepb.E_Required).(bool) && !m.Has(fd) {
+		return invalid(ctx, i18n.FieldRequired, name)
+	}
+	if fd.Kind() != protoreflect.StringKind || fd.IsList() || fd.IsMap() {
+		return nil
+	}
+
+	// Optional fields left empty are not checked further
+	value := m.Get(fd).String()
+	if value == "" {
+		return nil
+	}
+
+	minLen := proto.GetExtension(opts, validatepb.E_MinLen).(uint32)
+	maxLen := proto.GetExtension(opts, validatepb.E_MaxLen).(uint32)
+	length := uint32(utf8.RuneCountInString(value))
+	switch {
+	case minLen > 0 && maxLen > 0 && (length < minLen || length > maxLen):
+		return invalid(ctx, i18n.FieldLength, name, minLen, maxLen)
+	case minLen > 0 && length < minLen:
+		return invalid(ctx, i18n.FieldMinLength, name, minLen)
+	case maxLen > 0 && length > maxLen:
+		return invalid(ctx, i18n.FieldMaxLength, name, maxLen)
+	}
+
+	if expr := proto.GetExtension(opts, validatepb.E_Pattern).(string); expr != "" {
+		re, err := compile(expr)
+		if err != nil {
+			return status.Error(codes.Internal, fmt.Sprintf("invalid pattern on %s: %v", fd.FullName(), err))
+		}
+		if !re.MatchString(value) {
+			return invalid(ctx, i18n.FieldPattern, name)
+		}
+	}
+
+	if proto.GetExtension(opts, validatepb.E_Email).(bool) {
+		if _, err := mail.ParseAddress(value); err != nil {
+			return invalid(ctx, i18n.FieldEmail, name)
+		}
+	}
+	return nil
+}
+
+func invalid(ctx context.Context, key i18n.Key, args ...interface{}) error {
+	return status.Error(codes.InvalidArgument, i18n.T(ctx, key, args...))
+}
+
+func compile(expr string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(expr, re)
+	return re, nil
+}
@@ -0,0 +1,139 @@
+package validate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/elskow/chef-infra/internal/i18n"
+	authpb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+)
+
+func TestMessage(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		msg     proto.Message
+		wantMsg string
+	}{
+		{
+			name: "valid",
+			msg:  &authpb.RegisterRequest{Username: "testuser", Password: "testpass123", Email: "test@example.com"},
+		},
+		{
+			name:    "missing required field",
+			msg:     &authpb.RegisterRequest{Password: "testpass123", Email: "test@example.com"},
+			wantMsg: "username is required",
+		},
+		{
+			name:    "too short",
+			msg:     &authpb.RegisterRequest{Username: "ab", Password: "testpass123", Email: "test@example.com"},
+			wantMsg: "username must be between 3 and 32 characters",
+		},
+		{
+			name:    "too long",
+			msg:     &authpb.RegisterRequest{Username: "thisusernameiswaytoolongandshouldfail", Password: "testpass123", Email: "test@example.com"},
+			wantMsg: "username must be between 3 and 32 characters",
+		},
+		{
+			name:    "minimum length only",
+			msg:     &authpb.RegisterRequest{Username: "testuser", Password: "short", Email: "test@example.com"},
+			wantMsg: "password must be at least 8 characters",
+		},
+		{
+			name:    "invalid email",
+			msg:     &authpb.RegisterRequest{Username: "testuser", Password: "testpass123", Email: "invalid-email"},
+			wantMsg: "invalid email format",
+		},
+		{
+			name:    "multi-word field name",
+			msg:     &authpb.RefreshTokenRequest{},
+			wantMsg: "refresh token is required",
+		},
+		{
+			name: "message without rules",
+			msg:  &authpb.ValidateTokenRequest{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Message(ctx, tt.msg)
+			if tt.wantMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			st, ok := status.FromError(err)
+			require.True(t, ok)
+			assert.Equal(t, codes.InvalidArgument, st.Code())
+			assert.Equal(t, tt.wantMsg, st.Message())
+		})
+	}
+}
+
+func TestMessageLocale(t *testing.T) {
+	ctx := i18n.WithLocale(context.Background(), i18n.Indonesian)
+
+	err := Message(ctx, &authpb.LoginRequest{Username: "budi"})
+	assert.Equal(t, "kata sandi wajib diisi", status.Convert(err).Message())
+}
+
+func TestMessageCountsCharacters(t *testing.T) {
+	// Three characters, nine bytes
+	err := Message(context.Background(), &authpb.LoginRequest{Username: "日本語", Password: "testpass123"})
+	assert.NoError(t, err)
+	err = Message(context.Background(), &authpb.RegisterRequest{Username: "日本語", Password: "testpass123", Email: "test@example.com"})
+	assert.NoError(t, err)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor()
+	called := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return "ok", nil
+	}
+
+	_, err := interceptor(context.Background(), &authpb.LoginRequest{Username: "testuser"}, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.False(t, called, "invalid requests must not reach the handler")
+
+	resp, err := interceptor(context.Background(), &authpb.LoginRequest{Username: "testuser", Password: "testpass123"}, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
+
+// fakeStream receives a single message
+type fakeStream struct {
+	grpc.ServerStream
+	msg proto.Message
+}
+
+func (s *fakeStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	proto.Merge(m.(proto.Message), s.msg)
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor()
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		return ss.RecvMsg(&authpb.RefreshTokenRequest{})
+	}
+
+	err := interceptor(nil, &fakeStream{msg: &authpb.RefreshTokenRequest{}}, &grpc.StreamServerInfo{}, handler)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = interceptor(nil, &fakeStream{msg: &authpb.RefreshTokenRequest{RefreshToken: "token"}}, &grpc.StreamServerInfo{}, handler)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,160 @@
+package version
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// DefaultReleaseURL returns the latest release of chef-infra
+	DefaultReleaseURL = "https://api.github.com/repos/elskow/chef-infra/releases/latest"
+
+	defaultCheckInterval = 24 * time.Hour
+	checkTimeout         = 10 * time.Second
+)
+
+// Release is the part of a GitHub release the update check reads
+type Release struct {
+	Tag string `json:"tag_name"`
+	URL string `json:"html_url"`
+}
+
+// Checker periodically looks up the latest chef-infra release and logs
+// when it is newer than the running binary. It runs as a leader job so a
+// cluster reports it once.
+type Checker struct {
+	url      string
+	interval time.Duration
+	current  Info
+	client   *http.Client
+	log      *zap.Logger
+
+	reported string // Tag last logged, each release is logged once
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewChecker checks url, DefaultReleaseURL when empty, every interval,
+// defaulting to a day
+func NewChecker(url string, interval time.Duration, log *zap.Logger) *Checker {
+	if url == "" {
+		url = DefaultReleaseURL
+	}
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	return &Checker{
+		url:      url,
+		interval: interval,
+		current:  Get(),
+		client:   &http.Client{Timeout: checkTimeout},
+		log:      log,
+	}
+}
+
+// Start runs the check in the background until Stop is called
+func (c *Checker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			c.Check(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *Checker) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// Check logs the latest release when it is newer than the running one and
+// returns it. Development builds are not compared.
+func (c *Checker) Check(ctx context.Context) (*Release, bool) {
+	if c.current.Devel() {
+		c.log.Debug("skipping update check of a development build")
+		return nil, false
+	}
+
+	release, err := c.latest(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.log.Warn("failed to check for chef-infra updates", zap.Error(err))
+		}
+		return nil, false
+	}
+	if !Newer(release.Tag, c.current.Version) {
+		return release, false
+	}
+	if release.Tag != c.reported {
+		c.reported = release.Tag
+		c.log.Info("a newer chef-infra release is available",
+			zap.String("current", c.current.Version),
+			zap.String("latest", release.Tag),
+			zap.String("url", release.URL))
+	}
+	return release, true
+}
+
+func (c *Checker) latest(ctx context.Context) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "chef-infra/"+c.current.Version)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to decode release: %w", err)
+	}
+	if !semver.IsValid(canonical(release.Tag)) {
+		return nil, fmt.Errorf("release tag %q is not a semantic version", release.Tag)
+	}
+	return &release, nil
+}
+
+// Newer reports whether version a is newer than b. Versions may omit the
+// leading "v"; invalid ones are never newer.
+func Newer(a, b string) bool {
+	a, b = canonical(a), canonical(b)
+	return semver.IsValid(a) && semver.IsValid(b) && semver.Compare(a, b) > 0
+}
+
+func canonical(v string) string {
+	if v != "" && v[0] != 'v' {
+		return "v" + v
+	}
+	return v
+}
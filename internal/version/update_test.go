@@ -0,0 +1,56 @@
+package version
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewer(t *testing.T) {
+	assert.True(t, Newer("v1.5.0", "1.4.2"))
+	assert.True(t, Newer("1.4.0", "1.4.0-rc.1"))
+	assert.False(t, Newer("v1.4.0", "1.4.0"))
+	assert.False(t, Newer("v1.3.9", "1.4.0"))
+	assert.False(t, Newer("latest", "1.4.0"))
+	assert.False(t, Newer("v1.5.0", devel))
+}
+
+func TestChecker_Check(t *testing.T) {
+	tag := "v1.5.0"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "chef-infra/1.4.0", r.Header.Get("User-Agent"))
+		w.Write([]byte(`{"tag_name": "` + tag + `", "html_url": "https://github.com/elskow/chef-infra/releases/tag/` + tag + `"}`))
+	}))
+	defer server.Close()
+
+	core, logs := observer.New(zap.InfoLevel)
+	checker := NewChecker(server.URL, 0, zap.New(core))
+	checker.current = Info{Version: "1.4.0"}
+
+	release, newer := checker.Check(context.Background())
+	require.True(t, newer)
+	assert.Equal(t, "v1.5.0", release.Tag)
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "a newer chef-infra release is available", logs.All()[0].Message)
+
+	// Each release is logged once
+	_, newer = checker.Check(context.Background())
+	assert.True(t, newer)
+	assert.Equal(t, 1, logs.Len())
+
+	tag = "v1.4.0"
+	_, newer = checker.Check(context.Background())
+	assert.False(t, newer)
+
+	// Development builds are not compared
+	checker.current = Info{Version: devel}
+	release, newer = checker.Check(context.Background())
+	assert.Nil(t, release)
+	assert.False(t, newer)
+}
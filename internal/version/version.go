@@ -0,0 +1,103 @@
+// Package version describes the running chef-infra binary. Release builds
+// set version, commit and buildDate with -ldflags "-X ...", see the
+// Makefile; other builds fall back to the build info the Go toolchain
+// embeds.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Set at link time
+var (
+	version   string
+	commit    string
+	buildDate string // RFC 3339
+)
+
+// devel is the version of builds without release information
+const devel = "(devel)"
+
+// Info is how the binary was built. Fields that could not be determined
+// are empty.
+type Info struct {
+	Version   string // Without a leading "v", (devel) for source builds
+	Commit    string
+	BuildDate time.Time // Falls back to the commit time
+	Modified  bool      // Built from a tree with uncommitted changes
+	GoVersion string
+}
+
+// Get returns the running binary's build information
+func Get() Info {
+	info := Info{Version: version, Commit: commit, GoVersion: runtime.Version()}
+	info.BuildDate, _ = time.Parse(time.RFC3339, buildDate)
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && build.Main.Version != "" {
+			info.Version = build.Main.Version
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate.IsZero() {
+					info.BuildDate, _ = time.Parse(time.RFC3339, setting.Value)
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	info.Version = strings.TrimPrefix(info.Version, "v")
+	if info.Version == "" {
+		info.Version = devel
+	}
+	return info
+}
+
+// Devel reports whether the binary was built without a release version
+func (i Info) Devel() bool {
+	return i.Version == devel
+}
+
+// ShortCommit is the first 7 characters of the commit
+func (i Info) ShortCommit() string {
+	if len(i.Commit) > 7 {
+		return i.Commit[:7]
+	}
+	return i.Commit
+}
+
+// String is the version followed by the short commit, e.g. "1.4.0 (0123abc)"
+func (i Info) String() string {
+	if i.Commit == "" {
+		return i.Version
+	}
+	return i.Version + " (" + i.ShortCommit() + ")"
+}
+
+// Fields describe the build in logs
+func (i Info) Fields() []zap.Field {
+	fields := []zap.Field{
+		zap.String("version", i.Version),
+		zap.String("commit", i.Commit),
+		zap.String("go_version", i.GoVersion),
+	}
+	if !i.BuildDate.IsZero() {
+		fields = append(fields, zap.Time("build_date", i.BuildDate))
+	}
+	if i.Modified {
+		fields = append(fields, zap.Bool("modified", true))
+	}
+	return fields
+}
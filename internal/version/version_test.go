@@ -0,0 +1,28 @@
+package version
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet_LinkTimeValues(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "v1.4.0", "0123abcdef0123abcdef", "2025-03-24T09:00:00Z"
+
+	info := Get()
+	assert.Equal(t, "1.4.0", info.Version)
+	assert.False(t, info.Devel())
+	assert.Equal(t, "0123abc", info.ShortCommit())
+	assert.Equal(t, "1.4.0 (0123abc)", info.String())
+	assert.Equal(t, 2025, info.BuildDate.Year())
+	assert.NotEmpty(t, info.GoVersion)
+}
+
+func TestGet_Devel(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = ""
+
+	// Test binaries carry no module version
+	assert.True(t, Get().Devel())
+}
@@ -0,0 +1,314 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of
+	// the request body keyed with the webhook secret
+	SignatureHeader = "X-Chef-Signature-256"
+	EventHeader     = "X-Chef-Event"
+	DeliveryHeader  = "X-Chef-Delivery"
+
+	maxErrorBody = 1024
+)
+
+// Payload is the JSON body posted to webhooks
+type Payload struct {
+	ID        string       `json:"id"` // Stays the same across redeliveries
+	Event     string       `json:"event"`
+	Timestamp time.Time    `json:"timestamp"`
+	Project   string       `json:"project"`
+	Message   string       `json:"message,omitempty"`
+	Build     BuildPayload `json:"build"`
+}
+
+type BuildPayload struct {
+	ID           string             `json:"id"`
+	CommitHash   string             `json:"commit_hash,omitempty"`
+	Commit       *types.CommitInfo  `json:"commit,omitempty"` // Author, message, branch and tag when known
+	Status       string             `json:"status"`
+	Environment  string             `json:"environment,omitempty"`
+	ErrorMessage string             `json:"error_message,omitempty"`
+	Diagnosis    *types.Diagnosis   `json:"diagnosis,omitempty"` // Probable cause of a failure, when recognized
+	StartTime    time.Time          `json:"start_time"`
+	CompleteTime *time.Time         `json:"complete_time,omitempty"`
+	Tests        *types.TestResults `json:"tests,omitempty"`      // Set once the project's tests ran
+	PerfAudit    *types.PerfAudit   `json:"perf_audit,omitempty"` // Set once the deployment was audited
+	Release      *types.Release     `json:"release,omitempty"`    // Changelog and tag of deploys to released environments
+}
+
+type notification struct {
+	id      string
+	event   types.LifecycleEvent
+	payload []byte
+	project string
+}
+
+// NewPayload describes a lifecycle event of a build under a new event ID
+func NewPayload(event types.LifecycleEvent, build *types.Build, message string) (*Payload, error) {
+	id, err := newEventID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook event id: %w", err)
+	}
+	return &Payload{
+		ID:        id,
+		Event:     string(event),
+		Timestamp: time.Now().UTC(),
+		Project:   build.ProjectID,
+		Message:   message,
+		Build: BuildPayload{
+			ID:           build.ID,
+			CommitHash:   build.CommitHash,
+			Commit:       build.Commit,
+			Status:       string(build.Status),
+			Environment:  build.Environment,
+			ErrorMessage: build.ErrorMessage,
+			Diagnosis:    build.Diagnosis,
+			StartTime:    build.StartTime,
+			CompleteTime: build.CompleteTime,
+			Tests:        build.TestResults,
+			PerfAudit:    build.PerfAudit,
+			Release:      build.Release,
+		},
+	}, nil
+}
+
+// Notify queues the event for every subscribed webhook of the build's
+// project. Events are dropped when the queue is full so builds never wait
+// on slow receivers.
+func (s *Service) Notify(event types.LifecycleEvent, build *types.Build, message string) {
+	p, err := NewPayload(event, build, message)
+	if err != nil {
+		s.log.Error("failed to create webhook payload", zap.Error(err))
+		return
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		s.log.Error("failed to encode webhook payload", zap.Error(err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+
+	select {
+	case s.queue <- notification{id: p.ID, event: event, payload: payload, project: build.ProjectID}:
+	default:
+		s.log.Warn("webhook queue full, dropping event",
+			zap.String("event", string(event)),
+			zap.String("project_id", build.ProjectID),
+			zap.String("build_id", build.ID))
+	}
+}
+
+// Start launches the delivery workers
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for i := 0; i < s.workers; i++ {
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			for n := range s.queue {
+				s.dispatch(n)
+			}
+		}()
+	}
+}
+
+// Stop delivers the queued events and waits for the workers or ctx
+func (s *Service) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out delivering webhooks: %w", ctx.Err())
+	}
+}
+
+func (s *Service) dispatch(n notification) {
+	webhooks, err := s.repository.ListWebhooks(n.project)
+	if err != nil {
+		s.log.Error("failed to list webhooks",
+			zap.String("project_id", n.project),
+			zap.Error(err))
+		return
+	}
+
+	for i := range webhooks {
+		webhook := &webhooks[i]
+		if !webhook.Active || !webhook.subscribed(n.event) {
+			continue
+		}
+		if _, err := s.deliver(context.Background(), webhook, string(n.event), n.id, n.payload, nil); err != nil {
+			s.log.Error("failed to record webhook delivery",
+				zap.Uint("webhook_id", webhook.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// Redeliver sends a stored delivery's payload again and records the
+// attempt. Disabled webhooks can be redelivered to verify a fix before
+// re-enabling them.
+func (s *Service) Redeliver(ctx context.Context, deliveryID uint) (*Delivery, error) {
+	original, err := s.repository.GetDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := s.repository.GetWebhook(original.WebhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload Payload
+	if err := json.Unmarshal([]byte(original.Payload), &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode stored payload: %w", err)
+	}
+	return s.deliver(ctx, webhook, original.Event, payload.ID, []byte(original.Payload), &original.ID)
+}
+
+// deliver posts the payload and records the outcome
+func (s *Service) deliver(ctx context.Context, webhook *Webhook, event, eventID string, payload []byte, redeliveryOf *uint) (*Delivery, error) {
+	delivery := &Delivery{
+		WebhookID:    webhook.ID,
+		Event:        event,
+		Payload:      string(payload),
+		RedeliveryOf: redeliveryOf,
+	}
+
+	start := time.Now()
+	statusCode, err := s.post(ctx, webhook, event, eventID, payload)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.StatusCode = statusCode
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		delivery.Success = true
+	}
+
+	updated, err := s.repository.RecordDelivery(delivery, s.failureThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.Active && !updated.Active {
+		s.log.Warn("webhook disabled",
+			zap.Uint("webhook_id", webhook.ID),
+			zap.String("project_id", webhook.ProjectID),
+			zap.String("reason", updated.DisabledReason))
+	}
+	return delivery, nil
+}
+
+func (s *Service) post(ctx context.Context, webhook *Webhook, event, eventID string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chef-infra-webhook")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, eventID)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	return resp.StatusCode, nil
+}
+
+func newEventID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Sign returns the signature header value for a payload
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewHTTPClient refuses to connect to internal addresses unless allowed so
+// users cannot use webhooks to reach the cluster network
+func NewHTTPClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// Receivers must answer directly, a redirect fails the delivery
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/pagination"
+	pb "github.com/elskow/chef-infra/proto/gen/webhook"
+)
+
+// ProjectAuthorizer decides whether a user may manage a project's webhooks
+type ProjectAuthorizer interface {
+	CanAccessProject(username, projectID string) (bool, error)
+}
+
+type Handler struct {
+	pb.UnimplementedWebhookServer
+	service    *Service
+	authorizer ProjectAuthorizer
+	log        *zap.Logger
+}
+
+func NewHandler(service *Service, authorizer ProjectAuthorizer, log *zap.Logger) *Handler {
+	return &Handler{
+		service:    service,
+		authorizer: authorizer,
+		log:        log,
+	}
+}
+
+func (h *Handler) CreateWebhook(ctx context.Context, req *pb.CreateWebhookRequest) (*pb.CreateWebhookResponse, error) {
+	username, err := h.authorize(ctx, req.ProjectId)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := h.service.CreateWebhook(req.ProjectId, username, req.Url, req.Secret, req.Events)
+	if err != nil {
+		if errors.Is(err, ErrInvalidURL) || errors.Is(err, ErrInvalidEvent) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to create webhook", zap.String("project_id", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to create webhook")
+	}
+
+	h.log.Info("webhook created",
+		zap.Uint("webhook_id", webhook.ID),
+		zap.String("project_id", webhook.ProjectID),
+		zap.String("created_by", username))
+
+	return &pb.CreateWebhookResponse{Webhook: toProto(webhook), Secret: webhook.Secret}, nil
+}
+
+func (h *Handler) ListWebhooks(ctx context.Context, req *pb.ListWebhooksRequest) (*pb.ListWebhooksResponse, error) {
+	if _, err := h.authorize(ctx, req.ProjectId); err != nil {
+		return nil, err
+	}
+
+	webhooks, err := h.service.ListWebhooks(req.ProjectId)
+	if err != nil {
+		h.log.Error("failed to list webhooks", zap.String("project_id", req.ProjectId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list webhooks")
+	}
+
+	resp := &pb.ListWebhooksResponse{}
+	for i := range webhooks {
+		resp.Webhooks = append(resp.Webhooks, toProto(&webhooks[i]))
+	}
+	return resp, nil
+}
+
+func (h *Handler) UpdateWebhook(ctx context.Context, req *pb.UpdateWebhookRequest) (*pb.UpdateWebhookResponse, error) {
+	if _, err := h.authorizeWebhook(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+
+	webhook, err := h.service.UpdateWebhook(uint(req.Id), req.Url, req.Events, req.Active)
+	if err != nil {
+		if errors.Is(err, ErrInvalidURL) || errors.Is(err, ErrInvalidEvent) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to update webhook", zap.Uint64("webhook_id", req.Id), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to update webhook")
+	}
+
+	return &pb.UpdateWebhookResponse{Webhook: toProto(webhook)}, nil
+}
+
+func (h *Handler) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.DeleteWebhookResponse, error) {
+	if _, err := h.authorizeWebhook(ctx, uint(req.Id)); err != nil {
+		return nil, err
+	}
+
+	if err := h.service.DeleteWebhook(uint(req.Id)); err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			return nil, status.Error(codes.NotFound, "webhook not found")
+		}
+		h.log.Error("failed to delete webhook", zap.Uint64("webhook_id", req.Id), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to delete webhook")
+	}
+
+	return &pb.DeleteWebhookResponse{Success: true, Message: "Webhook deleted"}, nil
+}
+
+func (h *Handler) ListDeliveries(ctx context.Context, req *pb.ListDeliveriesRequest) (*pb.ListDeliveriesResponse, error) {
+	if _, err := h.authorizeWebhook(ctx, uint(req.WebhookId)); err != nil {
+		return nil, err
+	}
+
+	page, err := h.service.ListDeliveries(uint(req.WebhookId), pagination.Params{
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		h.log.Error("failed to list deliveries", zap.Uint64("webhook_id", req.WebhookId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list deliveries")
+	}
+
+	resp := &pb.ListDeliveriesResponse{NextPageToken: page.NextPageToken}
+	for i := range page.Items {
+		resp.Deliveries = append(resp.Deliveries, deliveryToProto(&page.Items[i]))
+	}
+	return resp, nil
+}
+
+func (h *Handler) RedeliverWebhook(ctx context.Context, req *pb.RedeliverWebhookRequest) (*pb.RedeliverWebhookResponse, error) {
+	original, err := h.service.GetDelivery(uint(req.DeliveryId))
+	if err != nil {
+		if errors.Is(err, ErrDeliveryNotFound) {
+			return nil, status.Error(codes.NotFound, "delivery not found")
+		}
+		h.log.Error("failed to get delivery", zap.Uint64("delivery_id", req.DeliveryId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get delivery")
+	}
+	if _, err := h.authorizeWebhook(ctx, original.WebhookID); err != nil {
+		return nil, err
+	}
+
+	delivery, err := h.service.Redeliver(ctx, original.ID)
+	if err != nil {
+		h.log.Error("failed to redeliver webhook", zap.Uint64("delivery_id", req.DeliveryId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to redeliver webhook")
+	}
+
+	return &pb.RedeliverWebhookResponse{Delivery: deliveryToProto(delivery)}, nil
+}
+
+// authorize rejects callers that cannot manage the project
+func (h *Handler) authorize(ctx context.Context, projectID string) (string, error) {
+	username, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, "authentication required")
+	}
+	if projectID == "" {
+		return "", status.Error(codes.InvalidArgument, "project_id is required")
+	}
+
+	allowed, err := h.authorizer.CanAccessProject(username, projectID)
+	if err != nil {
+		h.log.Error("failed to check project access", zap.String("project_id", projectID), zap.Error(err))
+		return "", status.Error(codes.Internal, "failed to check project access")
+	}
+	if !allowed {
+		return "", status.Error(codes.PermissionDenied, "access to project denied")
+	}
+	return username, nil
+}
+
+// authorizeWebhook loads the webhook and checks access to its project
+func (h *Handler) authorizeWebhook(ctx context.Context, id uint) (*Webhook, error) {
+	webhook, err := h.service.GetWebhook(id)
+	if err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			return nil, status.Error(codes.NotFound, "webhook not found")
+		}
+		h.log.Error("failed to get webhook", zap.Uint("webhook_id", id), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get webhook")
+	}
+	if _, err := h.authorize(ctx, webhook.ProjectID); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func toProto(webhook *Webhook) *pb.WebhookInfo {
+	return &pb.WebhookInfo{
+		Id:                  uint64(webhook.ID),
+		ProjectId:           webhook.ProjectID,
+		Url:                 webhook.URL,
+		Events:              webhook.Events,
+		Active:              webhook.Active,
+		ConsecutiveFailures: int32(webhook.ConsecutiveFailures),
+		DisabledReason:      webhook.DisabledReason,
+		CreatedAt:           webhook.CreatedAt.Unix(),
+	}
+}
+
+func deliveryToProto(delivery *Delivery) *pb.DeliveryInfo {
+	info := &pb.DeliveryInfo{
+		Id:         uint64(delivery.ID),
+		WebhookId:  uint64(delivery.WebhookID),
+		Event:      delivery.Event,
+		Payload:    delivery.Payload,
+		StatusCode: int32(delivery.StatusCode),
+		Error:      delivery.Error,
+		Success:    delivery.Success,
+		DurationMs: delivery.DurationMs,
+		CreatedAt:  delivery.CreatedAt.Unix(),
+	}
+	if delivery.RedeliveryOf != nil {
+		info.RedeliveryOf = uint64(*delivery.RedeliveryOf)
+	}
+	return info
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/auth"
+	"github.com/elskow/chef-infra/internal/config"
+	pb "github.com/elskow/chef-infra/proto/gen/webhook"
+)
+
+// projectOwners grants each user the projects they own
+type projectOwners map[string]string
+
+func (o projectOwners) CanAccessProject(username, projectID string) (bool, error) {
+	return o[projectID] == username, nil
+}
+
+func TestHandler_OwnerIsolation(t *testing.T) {
+	svc, _ := newTestService(t, config.WebhookConfig{})
+	h := NewHandler(svc, projectOwners{"shop": "alice", "blog": "bob"}, zap.NewNop())
+	alice := context.WithValue(context.Background(), auth.UserContextKey, "alice")
+	bob := context.WithValue(context.Background(), auth.UserContextKey, "bob")
+
+	created, err := h.CreateWebhook(alice, &pb.CreateWebhookRequest{ProjectId: "shop", Url: "https://hooks.example.com/shop"})
+	require.NoError(t, err)
+	id := created.Webhook.Id
+
+	list, err := h.ListWebhooks(bob, &pb.ListWebhooksRequest{ProjectId: "blog"})
+	require.NoError(t, err)
+	assert.Empty(t, list.Webhooks)
+
+	calls := map[string]func() error{
+		"create": func() error {
+			_, err := h.CreateWebhook(bob, &pb.CreateWebhookRequest{ProjectId: "shop", Url: "https://hooks.example.com/bob"})
+			return err
+		},
+		"list": func() error {
+			_, err := h.ListWebhooks(bob, &pb.ListWebhooksRequest{ProjectId: "shop"})
+			return err
+		},
+		"update": func() error {
+			_, err := h.UpdateWebhook(bob, &pb.UpdateWebhookRequest{Id: id, Url: "https://hooks.example.com/bob"})
+			return err
+		},
+		"delete": func() error {
+			_, err := h.DeleteWebhook(bob, &pb.DeleteWebhookRequest{Id: id})
+			return err
+		},
+		"deliveries": func() error {
+			_, err := h.ListDeliveries(bob, &pb.ListDeliveriesRequest{WebhookId: id})
+			return err
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, codes.PermissionDenied, status.Code(call()))
+		})
+	}
+
+	list, err = h.ListWebhooks(alice, &pb.ListWebhooksRequest{ProjectId: "shop"})
+	require.NoError(t, err)
+	require.Len(t, list.Webhooks, 1)
+	assert.Equal(t, "https://hooks.example.com/shop", list.Webhooks[0].Url)
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elskow/chef-infra/internal/pagination"
+)
+
+type mockRepository struct {
+	webhooks   map[uint]*Webhook
+	deliveries []Delivery
+	nextID     uint
+	mu         sync.RWMutex
+}
+
+func newMockRepository() *mockRepository {
+	return &mockRepository{
+		webhooks: make(map[uint]*Webhook),
+	}
+}
+
+func (r *mockRepository) CreateWebhook(webhook *Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	webhook.ID = r.nextID
+	webhook.CreatedAt = time.Now()
+	stored := *webhook
+	r.webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (r *mockRepository) GetWebhook(id uint) (*Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	webhook, exists := r.webhooks[id]
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+	found := *webhook
+	return &found, nil
+}
+
+func (r *mockRepository) ListWebhooks(projectID string) ([]Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var webhooks []Webhook
+	for _, webhook := range r.webhooks {
+		if webhook.ProjectID == projectID {
+			webhooks = append(webhooks, *webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+func (r *mockRepository) UpdateWebhook(webhook *Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.webhooks[webhook.ID]; !exists {
+		return ErrWebhookNotFound
+	}
+	stored := *webhook
+	r.webhooks[webhook.ID] = &stored
+	return nil
+}
+
+func (r *mockRepository) DeleteWebhook(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.webhooks[id]; !exists {
+		return ErrWebhookNotFound
+	}
+	delete(r.webhooks, id)
+	return nil
+}
+
+func (r *mockRepository) RecordDelivery(delivery *Delivery, failureThreshold int) (*Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	webhook, exists := r.webhooks[delivery.WebhookID]
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+	delivery.ID = uint(len(r.deliveries) + 1)
+	delivery.CreatedAt = time.Now()
+	r.deliveries = append(r.deliveries, *delivery)
+
+	recordOutcome(webhook, delivery, failureThreshold)
+	updated := *webhook
+	return &updated, nil
+}
+
+func (r *mockRepository) GetDelivery(id uint) (*Delivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if id == 0 || int(id) > len(r.deliveries) {
+		return nil, ErrDeliveryNotFound
+	}
+	delivery := r.deliveries[id-1]
+	return &delivery, nil
+}
+
+// ListDeliveries returns all of the webhook's deliveries on one page,
+// newest first
+func (r *mockRepository) ListDeliveries(webhookID uint, _ pagination.Params) (*pagination.Page[Delivery], error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var deliveries []Delivery
+	for i := len(r.deliveries) - 1; i >= 0; i-- {
+		if r.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, r.deliveries[i])
+		}
+	}
+	return &pagination.Page[Delivery]{Items: deliveries}, nil
+}
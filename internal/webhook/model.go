@@ -0,0 +1,39 @@
+package webhook
+
+import "time"
+
+type Webhook struct {
+	ID                  uint     `gorm:"primaryKey"`
+	ProjectID           string   `gorm:"index;not null"` // Project name
+	URL                 string   `gorm:"not null"`
+	Secret              string   `gorm:"not null"`        // HMAC key for payload signatures, sealed at rest once secrets.master_key is set
+	Events              []string `gorm:"serializer:json"` // Empty subscribes to every event
+	Active              bool     `gorm:"not null;default:true"`
+	ConsecutiveFailures int      `gorm:"not null;default:0"`
+	DisabledReason      string
+	CreatedBy           string `gorm:"not null"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// Delivery records one attempt to deliver an event to a webhook
+type Delivery struct {
+	ID           uint   `gorm:"primaryKey"`
+	WebhookID    uint   `gorm:"index;not null"`
+	Event        string `gorm:"not null"`
+	Payload      string `gorm:"type:jsonb;not null"`
+	StatusCode   int
+	Error        string
+	Success      bool `gorm:"not null"`
+	DurationMs   int64
+	RedeliveryOf *uint // Delivery this attempt manually repeats
+	CreatedAt    time.Time
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
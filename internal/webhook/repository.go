@@ -0,0 +1,187 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/elskow/chef-infra/internal/database"
+	"github.com/elskow/chef-infra/internal/pagination"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+)
+
+type Repository interface {
+	CreateWebhook(webhook *Webhook) error
+	GetWebhook(id uint) (*Webhook, error)
+	ListWebhooks(projectID string) ([]Webhook, error)
+	UpdateWebhook(webhook *Webhook) error
+	DeleteWebhook(id uint) error
+	// RecordDelivery stores the delivery and updates the webhook's failure
+	// streak, disabling it once failureThreshold consecutive deliveries failed
+	RecordDelivery(delivery *Delivery, failureThreshold int) (*Webhook, error)
+	GetDelivery(id uint) (*Delivery, error)
+	ListDeliveries(webhookID uint, params pagination.Params) (*pagination.Page[Delivery], error)
+}
+
+var deliveryListSpec = pagination.Spec{
+	SortFields: map[string]string{
+		"created_at": "created_at",
+	},
+	DefaultSort: "-created_at",
+}
+
+// Cipher encrypts webhook secrets at rest
+type Cipher interface {
+	Seal(plaintext string) (string, error)
+	Open(value string) (string, error)
+}
+
+type repository struct {
+	db     *gorm.DB
+	reads  database.ReadSource
+	cipher Cipher
+}
+
+func NewRepository(db *gorm.DB, reads database.ReadSource, cipher Cipher) Repository {
+	return &repository{db: db, reads: reads, cipher: cipher}
+}
+
+func (r *repository) CreateWebhook(webhook *Webhook) error {
+	stored := *webhook
+	sealed, err := r.cipher.Seal(webhook.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	stored.Secret = sealed
+
+	if err := r.db.Create(&stored).Error; err != nil {
+		return err
+	}
+	stored.Secret = webhook.Secret
+	*webhook = stored
+	return nil
+}
+
+func (r *repository) GetWebhook(id uint) (*Webhook, error) {
+	var webhook Webhook
+	if err := r.db.First(&webhook, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	if err := r.open(&webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (r *repository) ListWebhooks(projectID string) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := r.db.Where("project_id = ?", projectID).Order("id").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		if err := r.open(&webhooks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return webhooks, nil
+}
+
+func (r *repository) open(webhook *Webhook) error {
+	secret, err := r.cipher.Open(webhook.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secret of webhook %d: %w", webhook.ID, err)
+	}
+	webhook.Secret = secret
+	return nil
+}
+
+func (r *repository) UpdateWebhook(webhook *Webhook) error {
+	result := r.db.Model(webhook).Select("url", "events", "active", "consecutive_failures", "disabled_reason").Updates(webhook)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r *repository) DeleteWebhook(id uint) error {
+	result := r.db.Delete(&Webhook{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (r *repository) RecordDelivery(delivery *Delivery, failureThreshold int) (*Webhook, error) {
+	var webhook Webhook
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Concurrent deliveries to the same webhook must not lose updates
+		// to the failure streak
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&webhook, delivery.WebhookID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrWebhookNotFound
+			}
+			return err
+		}
+		if err := tx.Create(delivery).Error; err != nil {
+			return fmt.Errorf("failed to store delivery: %w", err)
+		}
+
+		recordOutcome(&webhook, delivery, failureThreshold)
+		return tx.Model(&webhook).
+			Select("active", "consecutive_failures", "disabled_reason").
+			Updates(&webhook).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.open(&webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (r *repository) GetDelivery(id uint) (*Delivery, error) {
+	var delivery Delivery
+	if err := r.db.First(&delivery, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeliveryNotFound
+		}
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListDeliveries returns a page of the webhook's deliveries, newest first
+func (r *repository) ListDeliveries(webhookID uint, params pagination.Params) (*pagination.Page[Delivery], error) {
+	query := r.reads.ReadDB().Where("webhook_id = ?", webhookID)
+	return pagination.List[Delivery](query, params, deliveryListSpec)
+}
+
+// recordOutcome applies a delivery result to the webhook's failure streak
+func recordOutcome(webhook *Webhook, delivery *Delivery, failureThreshold int) {
+	if delivery.Success {
+		webhook.ConsecutiveFailures = 0
+		return
+	}
+
+	webhook.ConsecutiveFailures++
+	if webhook.Active && failureThreshold > 0 && webhook.ConsecutiveFailures >= failureThreshold {
+		webhook.Active = false
+		webhook.DisabledReason = fmt.Sprintf("disabled after %d consecutive failed deliveries", webhook.ConsecutiveFailures)
+	}
+}
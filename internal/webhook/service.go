@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+const (
+	defaultTimeout          = 10 * time.Second
+	defaultWorkers          = 4
+	defaultQueueSize        = 256
+	defaultFailureThreshold = 10
+)
+
+var (
+	ErrInvalidURL   = errors.New("webhook URL must be an absolute http or https URL")
+	ErrInvalidEvent = errors.New("unknown webhook event")
+)
+
+// Service manages project webhooks and delivers lifecycle events to them
+type Service struct {
+	repository       Repository
+	client           *http.Client
+	failureThreshold int
+	workers          int
+	log              *zap.Logger
+
+	queue   chan notification
+	running sync.WaitGroup
+	mu      sync.Mutex
+	started bool
+	stopped bool
+}
+
+func NewService(repo Repository, cfg *config.WebhookConfig, log *zap.Logger) *Service {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	threshold := cfg.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	return &Service{
+		repository:       repo,
+		client:           NewHTTPClient(timeout, cfg.AllowPrivateURLs),
+		failureThreshold: threshold,
+		workers:          workers,
+		log:              log,
+		queue:            make(chan notification, queueSize),
+	}
+}
+
+// CreateWebhook registers a webhook for the project. A secret is generated
+// when none is given.
+func (s *Service) CreateWebhook(projectID, createdBy, rawURL, secret string, events []string) (*Webhook, error) {
+	if err := validateURL(rawURL); err != nil {
+		return nil, err
+	}
+	if err := validateEvents(events); err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		generated, err := generateSecret()
+		if err != nil {
+			return nil, err
+		}
+		secret = generated
+	}
+
+	webhook := &Webhook{
+		ProjectID: projectID,
+		URL:       rawURL,
+		Secret:    secret,
+		Events:    events,
+		Active:    true,
+		CreatedBy: createdBy,
+	}
+	if err := s.repository.CreateWebhook(webhook); err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+func (s *Service) GetWebhook(id uint) (*Webhook, error) {
+	return s.repository.GetWebhook(id)
+}
+
+func (s *Service) ListWebhooks(projectID string) ([]Webhook, error) {
+	return s.repository.ListWebhooks(projectID)
+}
+
+// UpdateWebhook replaces the webhook's URL, event filter and state.
+// Re-enabling a webhook clears its failure streak.
+func (s *Service) UpdateWebhook(id uint, rawURL string, events []string, active bool) (*Webhook, error) {
+	if err := validateURL(rawURL); err != nil {
+		return nil, err
+	}
+	if err := validateEvents(events); err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.repository.GetWebhook(id)
+	if err != nil {
+		return nil, err
+	}
+	if active && !webhook.Active {
+		webhook.ConsecutiveFailures = 0
+		webhook.DisabledReason = ""
+	}
+	if !active && webhook.Active {
+		webhook.DisabledReason = "disabled by user"
+	}
+	webhook.URL = rawURL
+	webhook.Events = events
+	webhook.Active = active
+
+	if err := s.repository.UpdateWebhook(webhook); err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+func (s *Service) DeleteWebhook(id uint) error {
+	return s.repository.DeleteWebhook(id)
+}
+
+func (s *Service) GetDelivery(id uint) (*Delivery, error) {
+	return s.repository.GetDelivery(id)
+}
+
+func (s *Service) ListDeliveries(webhookID uint, params pagination.Params) (*pagination.Page[Delivery], error) {
+	return s.repository.ListDeliveries(webhookID, params)
+}
+
+// subscribed reports whether the webhook wants the event
+func (w *Webhook) subscribed(event types.LifecycleEvent) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, string(event))
+}
+
+func validateURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+func validateEvents(events []string) error {
+	for _, event := range events {
+		if !slices.Contains(types.LifecycleEvents, types.LifecycleEvent(event)) {
+			return fmt.Errorf("%w: %s", ErrInvalidEvent, event)
+		}
+	}
+	return nil
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
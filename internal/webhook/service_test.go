@@ -0,0 +1,220 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/elskow/chef-infra/internal/config"
+	"github.com/elskow/chef-infra/internal/pagination"
+	"github.com/elskow/chef-infra/internal/pipeline/types"
+)
+
+// receiver is a webhook endpoint that records requests
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newTestService(t *testing.T, cfg config.WebhookConfig) (*Service, *mockRepository) {
+	t.Helper()
+	repo := newMockRepository()
+	svc := NewService(repo, &cfg, zap.NewNop())
+	svc.Start()
+	t.Cleanup(func() { svc.Stop(context.Background()) })
+	return svc, repo
+}
+
+func testBuild() *types.Build {
+	return &types.Build{
+		ID:         "build-1",
+		ProjectID:  "web",
+		CommitHash: "abc123",
+		Commit:     &types.CommitInfo{Author: "Jane Doe", Message: "Fix checkout", Branch: "main"},
+		Status:     types.BuildStatusFailed,
+		StartTime:  time.Now(),
+	}
+}
+
+func TestService_CreateWebhook(t *testing.T) {
+	svc, _ := newTestService(t, config.WebhookConfig{})
+
+	tests := []struct {
+		name    string
+		url     string
+		events  []string
+		wantErr error
+	}{
+		{name: "valid", url: "https://hooks.example.com/chef", events: []string{"build.failed"}},
+		{name: "all events", url: "https://hooks.example.com/chef"},
+		{name: "relative url", url: "/chef", wantErr: ErrInvalidURL},
+		{name: "unsupported scheme", url: "ftp://hooks.example.com", wantErr: ErrInvalidURL},
+		{name: "unknown event", url: "https://hooks.example.com", events: []string{"build.exploded"}, wantErr: ErrInvalidEvent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhook, err := svc.CreateWebhook("web", "alice", tt.url, "", tt.events)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, webhook.Active)
+			assert.Len(t, webhook.Secret, 64)
+		})
+	}
+}
+
+func TestService_NotifyDeliversSignedPayload(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	svc, repo := newTestService(t, config.WebhookConfig{AllowPrivateURLs: true})
+	all, err := svc.CreateWebhook("web", "alice", server.URL, "s3cret", nil)
+	require.NoError(t, err)
+	_, err = svc.CreateWebhook("web", "alice", server.URL, "", []string{"deploy.succeeded"})
+	require.NoError(t, err)
+
+	build := testBuild()
+	build.TestResults = &types.TestResults{Total: 3, Passed: 2, Failed: 1, ExitCode: 1}
+	svc.Notify(types.LifecycleBuildFailed, build, "npm run build exited with 1")
+	require.Eventually(t, func() bool { return recv.count() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	req, body := recv.requests[0], recv.bodies[0]
+	assert.Equal(t, "build.failed", req.Header.Get(EventHeader))
+	assert.Equal(t, Sign("s3cret", body), req.Header.Get(SignatureHeader))
+
+	var payload Payload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, req.Header.Get(DeliveryHeader), payload.ID)
+	assert.Equal(t, "web", payload.Project)
+	assert.Equal(t, "build-1", payload.Build.ID)
+	require.NotNil(t, payload.Build.Commit)
+	assert.Equal(t, "Jane Doe", payload.Build.Commit.Author)
+	assert.Equal(t, "main", payload.Build.Commit.Branch)
+	assert.Equal(t, "npm run build exited with 1", payload.Message)
+	require.NotNil(t, payload.Build.Tests)
+	assert.Equal(t, 1, payload.Build.Tests.Failed)
+
+	page, err := svc.ListDeliveries(all.ID, pagination.Params{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.True(t, page.Items[0].Success)
+	assert.Equal(t, http.StatusOK, page.Items[0].StatusCode)
+	assert.Len(t, repo.deliveries, 1)
+}
+
+func TestService_DisablesAfterRepeatedFailures(t *testing.T) {
+	recv := &receiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	svc, _ := newTestService(t, config.WebhookConfig{AllowPrivateURLs: true, FailureThreshold: 3, Workers: 1})
+	webhook, err := svc.CreateWebhook("web", "alice", server.URL, "", nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		svc.Notify(types.LifecycleBuildFailed, testBuild(), "")
+	}
+	require.NoError(t, svc.Stop(context.Background()))
+
+	// Deliveries stop once the webhook is disabled
+	assert.Equal(t, 3, recv.count())
+	disabled, err := svc.GetWebhook(webhook.ID)
+	require.NoError(t, err)
+	assert.False(t, disabled.Active)
+	assert.Equal(t, 3, disabled.ConsecutiveFailures)
+	assert.Contains(t, disabled.DisabledReason, "3 consecutive failed deliveries")
+
+	// Re-enabling clears the failure streak
+	enabled, err := svc.UpdateWebhook(webhook.ID, server.URL, nil, true)
+	require.NoError(t, err)
+	assert.True(t, enabled.Active)
+	assert.Zero(t, enabled.ConsecutiveFailures)
+	assert.Empty(t, enabled.DisabledReason)
+}
+
+func TestService_Redeliver(t *testing.T) {
+	recv := &receiver{status: http.StatusBadGateway}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	svc, _ := newTestService(t, config.WebhookConfig{AllowPrivateURLs: true})
+	webhook, err := svc.CreateWebhook("web", "alice", server.URL, "", nil)
+	require.NoError(t, err)
+
+	svc.Notify(types.LifecycleDeployFailed, testBuild(), "")
+	require.NoError(t, svc.Stop(context.Background()))
+
+	page, err := svc.ListDeliveries(webhook.ID, pagination.Params{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	original := page.Items[0]
+	assert.False(t, original.Success)
+	assert.Contains(t, original.Error, "unexpected status 502")
+
+	recv.mu.Lock()
+	recv.status = http.StatusNoContent
+	recv.mu.Unlock()
+
+	redelivery, err := svc.Redeliver(context.Background(), original.ID)
+	require.NoError(t, err)
+	assert.True(t, redelivery.Success)
+	require.NotNil(t, redelivery.RedeliveryOf)
+	assert.Equal(t, original.ID, *redelivery.RedeliveryOf)
+	assert.Equal(t, original.Payload, redelivery.Payload)
+	assert.Equal(t, recv.requests[0].Header.Get(DeliveryHeader), recv.requests[1].Header.Get(DeliveryHeader))
+
+	healthy, err := svc.GetWebhook(webhook.ID)
+	require.NoError(t, err)
+	assert.Zero(t, healthy.ConsecutiveFailures)
+}
+
+func TestService_RejectsPrivateAddresses(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	svc, _ := newTestService(t, config.WebhookConfig{})
+	webhook, err := svc.CreateWebhook("web", "alice", server.URL, "", nil)
+	require.NoError(t, err)
+
+	svc.Notify(types.LifecycleBuildStarted, testBuild(), "")
+	require.NoError(t, svc.Stop(context.Background()))
+
+	assert.Zero(t, recv.count())
+	page, err := svc.ListDeliveries(webhook.ID, pagination.Params{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Contains(t, page.Items[0].Error, "is not allowed")
+}
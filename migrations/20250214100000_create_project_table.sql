@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE projects (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(63) NOT NULL,
+    owner VARCHAR(32) NOT NULL,
+    repo_url VARCHAR(255),
+    framework VARCHAR(32) NOT NULL DEFAULT 'nodejs',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+
+    CONSTRAINT projects_name_key UNIQUE (name)
+);
+
+CREATE INDEX idx_projects_owner ON projects (owner);
+CREATE INDEX idx_projects_deleted_at ON projects (deleted_at);
+
+CREATE TRIGGER update_projects_updated_at
+    BEFORE UPDATE ON projects
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_projects_updated_at ON projects;
+DROP TABLE IF EXISTS projects;
+-- +goose StatementEnd
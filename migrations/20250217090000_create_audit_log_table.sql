@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE audit_logs (
+    id SERIAL PRIMARY KEY,
+    actor VARCHAR(32) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    resource VARCHAR(255),
+    details JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_logs_actor ON audit_logs (actor);
+CREATE INDEX idx_audit_logs_action ON audit_logs (action);
+CREATE INDEX idx_audit_logs_resource ON audit_logs (resource);
+CREATE INDEX idx_audit_logs_created_at ON audit_logs (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_logs;
+-- +goose StatementEnd
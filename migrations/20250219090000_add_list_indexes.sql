@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Trigram indexes serve ILIKE '%term%' searches
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Keyset pagination orders by the sort column with id as tie-breaker
+CREATE INDEX idx_users_username_id ON users (username, id);
+CREATE INDEX idx_users_created_at_id ON users (created_at, id);
+CREATE INDEX idx_users_username_trgm ON users USING gin (username gin_trgm_ops);
+CREATE INDEX idx_users_email_trgm ON users USING gin (email gin_trgm_ops);
+
+CREATE INDEX idx_projects_name_id ON projects (name, id);
+CREATE INDEX idx_projects_created_at_id ON projects (created_at, id);
+CREATE INDEX idx_projects_owner_name_id ON projects (owner, name, id);
+CREATE INDEX idx_projects_name_trgm ON projects USING gin (name gin_trgm_ops);
+CREATE INDEX idx_projects_owner_trgm ON projects USING gin (owner gin_trgm_ops);
+
+DROP INDEX IF EXISTS idx_audit_logs_created_at;
+CREATE INDEX idx_audit_logs_created_at_id ON audit_logs (created_at, id);
+CREATE INDEX idx_audit_logs_actor_created_at_id ON audit_logs (actor, created_at, id);
+CREATE INDEX idx_audit_logs_resource_created_at_id ON audit_logs (resource, created_at, id);
+CREATE INDEX idx_audit_logs_action_trgm ON audit_logs USING gin (action gin_trgm_ops);
+CREATE INDEX idx_audit_logs_resource_trgm ON audit_logs USING gin (resource gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_audit_logs_resource_trgm;
+DROP INDEX IF EXISTS idx_audit_logs_action_trgm;
+DROP INDEX IF EXISTS idx_audit_logs_resource_created_at_id;
+DROP INDEX IF EXISTS idx_audit_logs_actor_created_at_id;
+DROP INDEX IF EXISTS idx_audit_logs_created_at_id;
+CREATE INDEX idx_audit_logs_created_at ON audit_logs (created_at);
+
+DROP INDEX IF EXISTS idx_projects_owner_trgm;
+DROP INDEX IF EXISTS idx_projects_name_trgm;
+DROP INDEX IF EXISTS idx_projects_owner_name_id;
+DROP INDEX IF EXISTS idx_projects_created_at_id;
+DROP INDEX IF EXISTS idx_projects_name_id;
+
+DROP INDEX IF EXISTS idx_users_email_trgm;
+DROP INDEX IF EXISTS idx_users_username_trgm;
+DROP INDEX IF EXISTS idx_users_created_at_id;
+DROP INDEX IF EXISTS idx_users_username_id;
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE builds (
+    id VARCHAR(64) PRIMARY KEY,
+    project_id VARCHAR(63) NOT NULL,
+    commit_hash VARCHAR(64),
+    framework VARCHAR(32),
+    environment VARCHAR(64),
+    status VARCHAR(16) NOT NULL,
+    image_id VARCHAR(255),
+    artifact_path TEXT,
+    error_message TEXT,
+    warnings JSONB,
+    start_time TIMESTAMP,
+    complete_time TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_builds_project_id_start_time ON builds (project_id, start_time);
+CREATE INDEX idx_builds_status ON builds (status);
+
+CREATE TRIGGER update_builds_updated_at
+    BEFORE UPDATE ON builds
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE build_events (
+    id SERIAL PRIMARY KEY,
+    build_id VARCHAR(64) NOT NULL REFERENCES builds (id) ON DELETE CASCADE,
+    type VARCHAR(32) NOT NULL,
+    hook VARCHAR(63),
+    message TEXT,
+    build_status VARCHAR(16) NOT NULL,
+    timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_build_events_build_id_id ON build_events (build_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS build_events;
+DROP TRIGGER IF EXISTS update_builds_updated_at ON builds;
+DROP TABLE IF EXISTS builds;
+-- +goose StatementEnd
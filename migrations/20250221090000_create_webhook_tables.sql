@@ -0,0 +1,45 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE webhooks (
+    id SERIAL PRIMARY KEY,
+    project_id VARCHAR(63) NOT NULL REFERENCES projects (name) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    events JSONB,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_reason TEXT,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhooks_project_id ON webhooks (project_id);
+
+CREATE TRIGGER update_webhooks_updated_at
+    BEFORE UPDATE ON webhooks
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TABLE webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    event VARCHAR(32) NOT NULL,
+    payload JSONB NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    success BOOLEAN NOT NULL,
+    duration_ms BIGINT,
+    redelivery_of INTEGER REFERENCES webhook_deliveries (id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id_created_at ON webhook_deliveries (webhook_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TRIGGER IF EXISTS update_webhooks_updated_at ON webhooks;
+DROP TABLE IF EXISTS webhooks;
+-- +goose StatementEnd
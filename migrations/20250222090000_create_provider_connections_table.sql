@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE provider_connections (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(32) NOT NULL REFERENCES users (username) ON DELETE CASCADE,
+    provider VARCHAR(32) NOT NULL,
+    account_login VARCHAR(255) NOT NULL,
+    access_token TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT idx_provider_connections_user_provider UNIQUE (username, provider)
+);
+
+CREATE TRIGGER update_provider_connections_updated_at
+    BEFORE UPDATE ON provider_connections
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_provider_connections_updated_at ON provider_connections;
+DROP TABLE IF EXISTS provider_connections;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE projects
+    ADD COLUMN branch VARCHAR(255),
+    ADD COLUMN install_command VARCHAR(255),
+    ADD COLUMN build_command VARCHAR(255),
+    ADD COLUMN output_dir VARCHAR(255),
+    ADD COLUMN node_version VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE projects
+    DROP COLUMN IF EXISTS node_version,
+    DROP COLUMN IF EXISTS output_dir,
+    DROP COLUMN IF EXISTS build_command,
+    DROP COLUMN IF EXISTS install_command,
+    DROP COLUMN IF EXISTS branch;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE projects
+    ADD COLUMN build_dedup VARCHAR(16);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE projects
+    DROP COLUMN IF EXISTS build_dedup;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE projects
+    ADD COLUMN branch_filters JSONB,
+    ADD COLUMN path_filters JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE projects
+    DROP COLUMN IF EXISTS path_filters,
+    DROP COLUMN IF EXISTS branch_filters;
+-- +goose StatementEnd
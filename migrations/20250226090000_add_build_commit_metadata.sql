@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds
+    ADD COLUMN commit_author VARCHAR(255),
+    ADD COLUMN commit_author_email VARCHAR(255),
+    ADD COLUMN commit_message TEXT,
+    ADD COLUMN branch VARCHAR(255),
+    ADD COLUMN tag VARCHAR(255);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE builds
+    DROP COLUMN IF EXISTS tag,
+    DROP COLUMN IF EXISTS branch,
+    DROP COLUMN IF EXISTS commit_message,
+    DROP COLUMN IF EXISTS commit_author_email,
+    DROP COLUMN IF EXISTS commit_author;
+-- +goose StatementEnd
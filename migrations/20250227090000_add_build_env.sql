@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN build_env JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE builds DROP COLUMN IF EXISTS build_env;
+-- +goose StatementEnd
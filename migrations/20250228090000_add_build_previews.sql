@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds
+    ADD COLUMN preview_name VARCHAR(63),
+    ADD COLUMN preview_url TEXT,
+    ADD COLUMN preview_expires_at TIMESTAMP,
+    ADD COLUMN preview_promoted_at TIMESTAMP,
+    ADD COLUMN preview_removed_at TIMESTAMP;
+
+CREATE INDEX idx_builds_active_previews ON builds (preview_expires_at)
+    WHERE preview_name <> '' AND preview_removed_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_builds_active_previews;
+ALTER TABLE builds
+    DROP COLUMN IF EXISTS preview_removed_at,
+    DROP COLUMN IF EXISTS preview_promoted_at,
+    DROP COLUMN IF EXISTS preview_expires_at,
+    DROP COLUMN IF EXISTS preview_url,
+    DROP COLUMN IF EXISTS preview_name;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX idx_builds_pinned ON builds (id) WHERE pinned;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_builds_pinned;
+ALTER TABLE builds DROP COLUMN IF EXISTS pinned;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN base_images JSONB;
+ALTER TABLE builds ADD COLUMN image_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE builds ADD COLUMN vulnerabilities JSONB;
+ALTER TABLE builds ADD COLUMN approvals JSONB;
+ALTER TABLE builds ADD COLUMN policy_results JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE builds DROP COLUMN IF EXISTS policy_results;
+ALTER TABLE builds DROP COLUMN IF EXISTS approvals;
+ALTER TABLE builds DROP COLUMN IF EXISTS vulnerabilities;
+ALTER TABLE builds DROP COLUMN IF EXISTS image_size;
+ALTER TABLE builds DROP COLUMN IF EXISTS base_images;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE project_usage (
+    project_id VARCHAR(63) NOT NULL,
+    day DATE NOT NULL,
+    build_seconds BIGINT NOT NULL DEFAULT 0,
+    replica_seconds BIGINT NOT NULL DEFAULT 0,
+    artifact_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (project_id, day)
+);
+
+CREATE INDEX idx_project_usage_day ON project_usage (day);
+CREATE INDEX idx_builds_complete_time ON builds (complete_time);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_builds_complete_time;
+DROP TABLE IF EXISTS project_usage;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN test_results JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE builds DROP COLUMN IF EXISTS test_results;
+-- +goose StatementEnd
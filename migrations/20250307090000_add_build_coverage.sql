@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN coverage JSONB;
+CREATE INDEX idx_builds_project_coverage ON builds (project_id, start_time DESC) WHERE coverage IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_builds_project_coverage;
+ALTER TABLE builds DROP COLUMN IF EXISTS coverage;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN perf_audit JSONB;
+CREATE INDEX idx_builds_project_perf_audit ON builds (project_id, environment, start_time DESC) WHERE perf_audit IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_builds_project_perf_audit;
+ALTER TABLE builds DROP COLUMN IF EXISTS perf_audit;
+-- +goose StatementEnd
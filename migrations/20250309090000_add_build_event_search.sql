@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE build_events ADD COLUMN message_search TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector('simple', COALESCE(message, ''))) STORED;
+CREATE INDEX idx_build_events_message_search ON build_events USING GIN (message_search);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_build_events_message_search;
+ALTER TABLE build_events DROP COLUMN IF EXISTS message_search;
+-- +goose StatementEnd
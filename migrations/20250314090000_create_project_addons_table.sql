@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE project_addons (
+    project_id VARCHAR(63) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    ref TEXT,
+    host TEXT,
+    secret VARCHAR(253) NOT NULL,
+    message TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (project_id, kind)
+);
+
+ALTER TABLE builds ADD COLUMN addons JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE builds DROP COLUMN IF EXISTS addons;
+DROP TABLE IF EXISTS project_addons;
+-- +goose StatementEnd
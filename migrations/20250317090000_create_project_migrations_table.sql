@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE project_migrations (
+    project_id VARCHAR(63) NOT NULL,
+    environment VARCHAR(64) NOT NULL,
+    from_target VARCHAR(64) NOT NULL,
+    to_target VARCHAR(64) NOT NULL,
+    build_id VARCHAR(64) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    message TEXT,
+    started_at TIMESTAMP NOT NULL,
+    switched_at TIMESTAMP,
+    rollback_until TIMESTAMP,
+    finished_at TIMESTAMP,
+    PRIMARY KEY (project_id, environment)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS project_migrations;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN release JSONB;
+CREATE INDEX idx_builds_project_release ON builds (project_id, environment, start_time DESC) WHERE release IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_builds_project_release;
+ALTER TABLE builds DROP COLUMN IF EXISTS release;
+-- +goose StatementEnd
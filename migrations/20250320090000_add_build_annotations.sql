@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN note TEXT NOT NULL DEFAULT '';
+ALTER TABLE builds ADD COLUMN labels JSONB;
+CREATE INDEX idx_builds_labels ON builds USING GIN (labels);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_builds_labels;
+ALTER TABLE builds DROP COLUMN IF EXISTS labels;
+ALTER TABLE builds DROP COLUMN IF EXISTS note;
+-- +goose StatementEnd
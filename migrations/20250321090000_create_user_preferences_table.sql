@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_preferences (
+    username VARCHAR(255) PRIMARY KEY,
+    filters JSONB,
+    channels JSONB,
+    subscriptions JSONB,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_user_preferences_updated_at
+    BEFORE UPDATE ON user_preferences
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS update_user_preferences_updated_at ON user_preferences;
+DROP TABLE IF EXISTS user_preferences;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE projects
+    ADD COLUMN status_page VARCHAR(16),
+    ADD COLUMN status_page_token VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE projects
+    DROP COLUMN IF EXISTS status_page_token,
+    DROP COLUMN IF EXISTS status_page;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE deploy_locks (
+    project_id VARCHAR(63) NOT NULL,
+    environment VARCHAR(64) NOT NULL,
+    build_id VARCHAR(64) NOT NULL,
+    owner VARCHAR(255) NOT NULL,
+    acquired_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (project_id, environment)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS deploy_locks;
+-- +goose StatementEnd
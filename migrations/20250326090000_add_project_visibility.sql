@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE projects ADD COLUMN visibility VARCHAR(16) NOT NULL DEFAULT 'private';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE projects DROP COLUMN IF EXISTS visibility;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE signing_keys (
+    id SERIAL PRIMARY KEY,
+    key_id VARCHAR(64) NOT NULL UNIQUE,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    retires_at TIMESTAMP
+);
+CREATE INDEX idx_signing_keys_retires_at ON signing_keys (retires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS signing_keys;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE builds ADD COLUMN instance VARCHAR(255);
+ALTER TABLE builds ADD COLUMN deploying BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE builds ADD COLUMN input JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE builds DROP COLUMN IF EXISTS input;
+ALTER TABLE builds DROP COLUMN IF EXISTS deploying;
+ALTER TABLE builds DROP COLUMN IF EXISTS instance;
+-- +goose StatementEnd
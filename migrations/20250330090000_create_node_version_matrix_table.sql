@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE node_version_matrix (
+    id INTEGER PRIMARY KEY,
+    versions JSONB NOT NULL,
+    default_version VARCHAR(16) NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS node_version_matrix;
+-- +goose StatementEnd
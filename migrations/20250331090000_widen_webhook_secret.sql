@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Sealed secrets are longer than the secrets themselves
+ALTER TABLE webhooks ALTER COLUMN secret TYPE TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE webhooks ALTER COLUMN secret TYPE VARCHAR(255);
+-- +goose StatementEnd
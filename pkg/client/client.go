@@ -0,0 +1,118 @@
+// Package client is a Go SDK for the chef-infra gRPC API.
+//
+// A Client manages access tokens for the caller: it logs in with the
+// configured credentials, refreshes tokens before they expire and retries
+// calls rejected with an expired token. Transient failures are retried
+// according to the RetryPolicy.
+//
+//	c, err := client.New("chef.example.com:443", client.WithCredentials("alice", "secret"))
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	projects, err := client.ListAllProjects(ctx, c.Projects, "")
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	authpb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+	pipelinepb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+	projectpb "github.com/elskow/chef-infra/proto/gen/project"
+)
+
+// Client is a connection to a chef-infra server
+type Client struct {
+	conn   *grpc.ClientConn
+	auth   authpb.AuthClient
+	tokens *tokenManager
+
+	// Projects and Pipeline are interfaces so user code can substitute fakes
+	Projects ProjectService
+	Pipeline PipelineService
+}
+
+// New connects to the server at target
+func New(target string, opts ...Option) (*Client, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	creds := o.creds
+	if creds == nil {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	if o.insecure {
+		creds = insecure.NewCredentials()
+	}
+
+	tokens := &tokenManager{
+		username:    o.username,
+		password:    o.password,
+		access:      o.accessToken,
+		refresh:     o.refreshToken,
+		refreshSkew: o.refreshSkew,
+	}
+	tokens.expiry = tokenExpiry(o.accessToken)
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// Retries wrap authentication so every attempt carries a fresh token
+		grpc.WithChainUnaryInterceptor(retryInterceptor(o.retry), tokens.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(tokens.streamInterceptor),
+	}, o.dialOptions...)
+
+	conn, err := grpc.NewClient(target, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	c := &Client{
+		conn:     conn,
+		auth:     authpb.NewAuthClient(conn),
+		tokens:   tokens,
+		Projects: &projectClient{client: projectpb.NewProjectClient(conn)},
+		Pipeline: &pipelineClient{client: pipelinepb.NewPipelineClient(conn)},
+	}
+	tokens.auth = c.auth
+	return c, nil
+}
+
+// Close releases the underlying connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Register creates a new user account
+func (c *Client) Register(ctx context.Context, username, password, email string) error {
+	_, err := c.auth.Register(ctx, &authpb.RegisterRequest{
+		Username: username,
+		Password: password,
+		Email:    email,
+	})
+	return err
+}
+
+// Login authenticates and stores the credentials so expired sessions can
+// be renewed without user interaction
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	return c.tokens.login(ctx, username, password)
+}
+
+// SetTokens replaces the stored token pair, e.g. one persisted by a CLI
+func (c *Client) SetTokens(accessToken, refreshToken string) {
+	c.tokens.set(accessToken, refreshToken)
+}
+
+// Tokens returns the current token pair
+func (c *Client) Tokens() (accessToken, refreshToken string) {
+	return c.tokens.get()
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	authpb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+	pipelinepb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+	projectpb "github.com/elskow/chef-infra/proto/gen/project"
+)
+
+// fakeServer issues numbered tokens and only accepts the latest one
+type fakeServer struct {
+	authpb.UnimplementedAuthServer
+	projectpb.UnimplementedProjectServer
+	pipelinepb.UnimplementedPipelineServer
+
+	mu          sync.Mutex
+	issued      int
+	valid       string
+	logins      int
+	refreshes   int
+	unavailable int // Calls to fail with Unavailable before succeeding
+}
+
+func (s *fakeServer) issue() (string, string) {
+	s.issued++
+	s.valid = "access-" + string(rune('0'+s.issued))
+	return s.valid, "refresh-" + string(rune('0'+s.issued))
+}
+
+func (s *fakeServer) Login(_ context.Context, req *authpb.LoginRequest) (*authpb.LoginResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Password != "secret" {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	s.logins++
+	access, refresh := s.issue()
+	return &authpb.LoginResponse{Success: true, AccessToken: access, RefreshToken: refresh}, nil
+}
+
+func (s *fakeServer) RefreshToken(_ context.Context, _ *authpb.RefreshTokenRequest) (*authpb.RefreshTokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshes++
+	access, refresh := s.issue()
+	return &authpb.RefreshTokenResponse{Success: true, AccessToken: access, RefreshToken: refresh}, nil
+}
+
+func (s *fakeServer) authorize(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unavailable > 0 {
+		s.unavailable--
+		return status.Error(codes.Unavailable, "try again")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) == 0 || values[0] != s.valid {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return nil
+}
+
+func (s *fakeServer) ListProjects(ctx context.Context, req *projectpb.ListProjectsRequest) (*projectpb.ListProjectsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.PageToken == "" {
+		return &projectpb.ListProjectsResponse{
+			Projects:      []*projectpb.ProjectInfo{{Name: "alpha"}},
+			NextPageToken: "next",
+		}, nil
+	}
+	return &projectpb.ListProjectsResponse{Projects: []*projectpb.ProjectInfo{{Name: "beta"}}}, nil
+}
+
+func (s *fakeServer) GetAppLogs(req *pipelinepb.GetAppLogsRequest, stream pipelinepb.Pipeline_GetAppLogsServer) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	for _, line := range []string{"starting", "listening"} {
+		if err := stream.Send(&pipelinepb.LogEntry{Source: req.ProjectId, Line: line}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newTestClient(t *testing.T, server *fakeServer, opts ...Option) *Client {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	authpb.RegisterAuthServer(grpcServer, server)
+	projectpb.RegisterProjectServer(grpcServer, server)
+	pipelinepb.RegisterPipelineServer(grpcServer, server)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	opts = append([]Option{
+		WithInsecure(),
+		WithDialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		})),
+	}, opts...)
+	c, err := New("passthrough:///bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient_LoginOnFirstCall(t *testing.T) {
+	server := &fakeServer{}
+	c := newTestClient(t, server, WithCredentials("alice", "secret"))
+
+	projects, err := ListAllProjects(context.Background(), c.Projects, "")
+	require.NoError(t, err)
+	require.Len(t, projects, 2)
+	assert.Equal(t, "alpha", projects[0].Name)
+	assert.Equal(t, "beta", projects[1].Name)
+	assert.Equal(t, 1, server.logins)
+}
+
+func TestClient_RefreshesRejectedToken(t *testing.T) {
+	server := &fakeServer{}
+	c := newTestClient(t, server, WithTokens("stale", "refresh-0"))
+
+	_, err := c.Projects.ListProjects(context.Background(), &projectpb.ListProjectsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.refreshes)
+
+	access, refresh := c.Tokens()
+	assert.Equal(t, "access-1", access)
+	assert.Equal(t, "refresh-1", refresh)
+}
+
+func TestClient_NotAuthenticated(t *testing.T) {
+	c := newTestClient(t, &fakeServer{})
+
+	_, err := c.Projects.ListProjects(context.Background(), &projectpb.ListProjectsRequest{})
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+}
+
+func TestClient_RetriesUnavailable(t *testing.T) {
+	server := &fakeServer{unavailable: 2}
+	policy := DefaultRetryPolicy
+	policy.InitialBackoff = time.Millisecond
+	c := newTestClient(t, server, WithCredentials("alice", "secret"), WithRetryPolicy(policy))
+
+	_, err := c.Projects.ListProjects(context.Background(), &projectpb.ListProjectsRequest{})
+	require.NoError(t, err)
+
+	server.unavailable = 10
+	_, err = c.Projects.ListProjects(context.Background(), &projectpb.ListProjectsRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 10-policy.MaxAttempts, server.unavailable)
+}
+
+func TestClient_GetAppLogs(t *testing.T) {
+	c := newTestClient(t, &fakeServer{}, WithCredentials("alice", "secret"))
+
+	var lines []string
+	err := c.Pipeline.GetAppLogs(context.Background(), &pipelinepb.GetAppLogsRequest{ProjectId: "web"}, func(entry *pipelinepb.LogEntry) error {
+		lines = append(lines, entry.Source+": "+entry.Line)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web: starting", "web: listening"}, lines)
+}
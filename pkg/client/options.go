@@ -0,0 +1,83 @@
+package client
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type options struct {
+	creds        credentials.TransportCredentials
+	insecure     bool
+	dialOptions  []grpc.DialOption
+	retry        RetryPolicy
+	username     string
+	password     string
+	accessToken  string
+	refreshToken string
+	refreshSkew  time.Duration
+}
+
+func defaultOptions() *options {
+	return &options{
+		retry:       DefaultRetryPolicy,
+		refreshSkew: 30 * time.Second,
+	}
+}
+
+// Option configures a Client
+type Option func(*options)
+
+// WithTransportCredentials sets the TLS configuration, system roots are
+// used by default
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) {
+		o.creds = creds
+	}
+}
+
+// WithInsecure disables transport security, for local development only
+func WithInsecure() Option {
+	return func(o *options) {
+		o.insecure = true
+	}
+}
+
+// WithDialOptions appends raw gRPC dial options
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// WithCredentials logs in on the first call and whenever the refresh
+// token can no longer be used
+func WithCredentials(username, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithTokens starts the client with an existing token pair
+func WithTokens(accessToken, refreshToken string) Option {
+	return func(o *options) {
+		o.accessToken = accessToken
+		o.refreshToken = refreshToken
+	}
+}
+
+// WithRefreshSkew sets how long before expiry the access token is renewed
+func WithRefreshSkew(skew time.Duration) Option {
+	return func(o *options) {
+		o.refreshSkew = skew
+	}
+}
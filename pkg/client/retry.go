@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy controls how failed unary calls are retried
+type RetryPolicy struct {
+	MaxAttempts    int // Including the first call, 1 disables retries
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	RetryableCodes []codes.Code
+}
+
+// DefaultRetryPolicy retries unavailable servers with exponential backoff
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	RetryableCodes: []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+}
+
+// backoff returns the jittered delay before the given retry
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 0; i < retry; i++ {
+		delay *= p.Multiplier
+	}
+	if max := float64(p.MaxBackoff); p.MaxBackoff > 0 && delay > max {
+		delay = max
+	}
+	// Full jitter keeps clients from retrying in lockstep
+	return time.Duration(rand.Float64() * delay)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	return slices.Contains(p.RetryableCodes, status.Code(err))
+}
+
+func retryInterceptor(policy RetryPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var err error
+		for attempt := 0; ; attempt++ {
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || !policy.retryable(err) || attempt+1 >= policy.MaxAttempts {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(policy.backoff(attempt)):
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	pipelinepb "github.com/elskow/chef-infra/proto/gen/pipeline/v1"
+	projectpb "github.com/elskow/chef-infra/proto/gen/project"
+)
+
+// ProjectService mirrors the Project gRPC service
+type ProjectService interface {
+	CreateProject(ctx context.Context, req *projectpb.CreateProjectRequest) (*projectpb.CreateProjectResponse, error)
+	GetProject(ctx context.Context, req *projectpb.GetProjectRequest) (*projectpb.GetProjectResponse, error)
+	ListProjects(ctx context.Context, req *projectpb.ListProjectsRequest) (*projectpb.ListProjectsResponse, error)
+	DeleteProject(ctx context.Context, req *projectpb.DeleteProjectRequest) (*projectpb.DeleteProjectResponse, error)
+	RestoreProject(ctx context.Context, req *projectpb.RestoreProjectRequest) (*projectpb.RestoreProjectResponse, error)
+	CreateProjectFromRepo(ctx context.Context, req *projectpb.CreateProjectFromRepoRequest) (*projectpb.CreateProjectFromRepoResponse, error)
+	UpdateProjectSettings(ctx context.Context, req *projectpb.UpdateProjectSettingsRequest) (*projectpb.UpdateProjectSettingsResponse, error)
+}
+
+// PipelineService mirrors the Pipeline gRPC service. Server streams are
+// consumed through callbacks.
+type PipelineService interface {
+	ListNodeVersions(ctx context.Context, req *pipelinepb.ListNodeVersionsRequest) (*pipelinepb.ListNodeVersionsResponse, error)
+	UpdateNodeVersions(ctx context.Context, req *pipelinepb.UpdateNodeVersionsRequest) (*pipelinepb.UpdateNodeVersionsResponse, error)
+	GetUptime(ctx context.Context, req *pipelinepb.GetUptimeRequest) (*pipelinepb.GetUptimeResponse, error)
+	GetUsage(ctx context.Context, req *pipelinepb.GetUsageRequest) (*pipelinepb.GetUsageResponse, error)
+	ExportUsage(ctx context.Context, req *pipelinepb.ExportUsageRequest) (*pipelinepb.ExportUsageResponse, error)
+	// GetAppLogs calls fn for each log line until the stream ends. With
+	// Follow set it keeps watching until ctx is cancelled.
+	GetAppLogs(ctx context.Context, req *pipelinepb.GetAppLogsRequest, fn func(*pipelinepb.LogEntry) error) error
+	ExecApp(ctx context.Context) (pipelinepb.Pipeline_ExecAppClient, error)
+	RestartDeployment(ctx context.Context, req *pipelinepb.RestartDeploymentRequest) (*pipelinepb.RestartDeploymentResponse, error)
+	ScaleDeployment(ctx context.Context, req *pipelinepb.ScaleDeploymentRequest) (*pipelinepb.ScaleDeploymentResponse, error)
+	GetBuild(ctx context.Context, req *pipelinepb.GetBuildRequest) (*pipelinepb.BuildInfo, error)
+	ListBuilds(ctx context.Context, req *pipelinepb.ListBuildsRequest) (*pipelinepb.ListBuildsResponse, error)
+	PromoteBuild(ctx context.Context, req *pipelinepb.PromoteBuildRequest) (*pipelinepb.PromoteBuildResponse, error)
+	PinBuild(ctx context.Context, req *pipelinepb.PinBuildRequest) (*pipelinepb.PinBuildResponse, error)
+	UnpinBuild(ctx context.Context, req *pipelinepb.UnpinBuildRequest) (*pipelinepb.UnpinBuildResponse, error)
+	ApproveBuild(ctx context.Context, req *pipelinepb.ApproveBuildRequest) (*pipelinepb.ApproveBuildResponse, error)
+}
+
+// ListAllProjects follows page tokens and returns every matching project
+func ListAllProjects(ctx context.Context, projects ProjectService, search string) ([]*projectpb.ProjectInfo, error) {
+	var all []*projectpb.ProjectInfo
+	req := &projectpb.ListProjectsRequest{Search: search}
+	for {
+		resp, err := projects.ListProjects(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, resp.Projects...)
+		if resp.NextPageToken == "" {
+			return all, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
+
+type projectClient struct {
+	client projectpb.ProjectClient
+}
+
+func (c *projectClient) CreateProject(ctx context.Context, req *projectpb.CreateProjectRequest) (*projectpb.CreateProjectResponse, error) {
+	return c.client.CreateProject(ctx, req)
+}
+
+func (c *projectClient) GetProject(ctx context.Context, req *projectpb.GetProjectRequest) (*projectpb.GetProjectResponse, error) {
+	return c.client.GetProject(ctx, req)
+}
+
+func (c *projectClient) ListProjects(ctx context.Context, req *projectpb.ListProjectsRequest) (*projectpb.ListProjectsResponse, error) {
+	return c.client.ListProjects(ctx, req)
+}
+
+func (c *projectClient) DeleteProject(ctx context.Context, req *projectpb.DeleteProjectRequest) (*projectpb.DeleteProjectResponse, error) {
+	return c.client.DeleteProject(ctx, req)
+}
+
+func (c *projectClient) RestoreProject(ctx context.Context, req *projectpb.RestoreProjectRequest) (*projectpb.RestoreProjectResponse, error) {
+	return c.client.RestoreProject(ctx, req)
+}
+
+func (c *projectClient) CreateProjectFromRepo(ctx context.Context, req *projectpb.CreateProjectFromRepoRequest) (*projectpb.CreateProjectFromRepoResponse, error) {
+	return c.client.CreateProjectFromRepo(ctx, req)
+}
+
+func (c *projectClient) UpdateProjectSettings(ctx context.Context, req *projectpb.UpdateProjectSettingsRequest) (*projectpb.UpdateProjectSettingsResponse, error) {
+	return c.client.UpdateProjectSettings(ctx, req)
+}
+
+type pipelineClient struct {
+	client pipelinepb.PipelineClient
+}
+
+func (c *pipelineClient) ListNodeVersions(ctx context.Context, req *pipelinepb.ListNodeVersionsRequest) (*pipelinepb.ListNodeVersionsResponse, error) {
+	return c.client.ListNodeVersions(ctx, req)
+}
+
+func (c *pipelineClient) UpdateNodeVersions(ctx context.Context, req *pipelinepb.UpdateNodeVersionsRequest) (*pipelinepb.UpdateNodeVersionsResponse, error) {
+	return c.client.UpdateNodeVersions(ctx, req)
+}
+
+func (c *pipelineClient) GetUptime(ctx context.Context, req *pipelinepb.GetUptimeRequest) (*pipelinepb.GetUptimeResponse, error) {
+	return c.client.GetUptime(ctx, req)
+}
+
+func (c *pipelineClient) GetUsage(ctx context.Context, req *pipelinepb.GetUsageRequest) (*pipelinepb.GetUsageResponse, error) {
+	return c.client.GetUsage(ctx, req)
+}
+
+func (c *pipelineClient) ExportUsage(ctx context.Context, req *pipelinepb.ExportUsageRequest) (*pipelinepb.ExportUsageResponse, error) {
+	return c.client.ExportUsage(ctx, req)
+}
+
+func (c *pipelineClient) GetAppLogs(ctx context.Context, req *pipelinepb.GetAppLogsRequest, fn func(*pipelinepb.LogEntry) error) error {
+	stream, err := c.client.GetAppLogs(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *pipelineClient) ExecApp(ctx context.Context) (pipelinepb.Pipeline_ExecAppClient, error) {
+	return c.client.ExecApp(ctx)
+}
+
+func (c *pipelineClient) RestartDeployment(ctx context.Context, req *pipelinepb.RestartDeploymentRequest) (*pipelinepb.RestartDeploymentResponse, error) {
+	return c.client.RestartDeployment(ctx, req)
+}
+
+func (c *pipelineClient) ScaleDeployment(ctx context.Context, req *pipelinepb.ScaleDeploymentRequest) (*pipelinepb.ScaleDeploymentResponse, error) {
+	return c.client.ScaleDeployment(ctx, req)
+}
+
+func (c *pipelineClient) GetBuild(ctx context.Context, req *pipelinepb.GetBuildRequest) (*pipelinepb.BuildInfo, error) {
+	return c.client.GetBuild(ctx, req)
+}
+
+func (c *pipelineClient) ListBuilds(ctx context.Context, req *pipelinepb.ListBuildsRequest) (*pipelinepb.ListBuildsResponse, error) {
+	return c.client.ListBuilds(ctx, req)
+}
+
+func (c *pipelineClient) PromoteBuild(ctx context.Context, req *pipelinepb.PromoteBuildRequest) (*pipelinepb.PromoteBuildResponse, error) {
+	return c.client.PromoteBuild(ctx, req)
+}
+
+func (c *pipelineClient) PinBuild(ctx context.Context, req *pipelinepb.PinBuildRequest) (*pipelinepb.PinBuildResponse, error) {
+	return c.client.PinBuild(ctx, req)
+}
+
+func (c *pipelineClient) UnpinBuild(ctx context.Context, req *pipelinepb.UnpinBuildRequest) (*pipelinepb.UnpinBuildResponse, error) {
+	return c.client.UnpinBuild(ctx, req)
+}
+
+func (c *pipelineClient) ApproveBuild(ctx context.Context, req *pipelinepb.ApproveBuildRequest) (*pipelinepb.ApproveBuildResponse, error) {
+	return c.client.ApproveBuild(ctx, req)
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/elskow/chef-infra/internal/api"
+	authpb "github.com/elskow/chef-infra/proto/gen/auth/v1"
+)
+
+// ErrNotAuthenticated is returned when a protected call is made without
+// tokens or credentials
+var ErrNotAuthenticated = errors.New("client is not authenticated")
+
+// tokenManager attaches the access token to outgoing calls and renews it
+// with the refresh token, falling back to a fresh login
+type tokenManager struct {
+	auth        authpb.AuthClient
+	refreshSkew time.Duration
+
+	mu       sync.Mutex
+	username string
+	password string
+	access   string
+	refresh  string
+	expiry   time.Time
+}
+
+func (t *tokenManager) get() (string, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.access, t.refresh
+}
+
+func (t *tokenManager) set(access, refresh string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.access = access
+	t.refresh = refresh
+	t.expiry = tokenExpiry(access)
+}
+
+func (t *tokenManager) login(ctx context.Context, username, password string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.username = username
+	t.password = password
+	return t.loginLocked(ctx)
+}
+
+// token returns a valid access token, renewing it when it is about to expire
+func (t *tokenManager) token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.access != "" && (t.expiry.IsZero() || time.Now().Add(t.refreshSkew).Before(t.expiry)) {
+		return t.access, nil
+	}
+	if err := t.renewLocked(ctx); err != nil {
+		return "", err
+	}
+	return t.access, nil
+}
+
+// invalidate drops the access token the server rejected unless another
+// call already replaced it
+func (t *tokenManager) invalidate(rejected string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.access == rejected {
+		t.access = ""
+	}
+}
+
+func (t *tokenManager) renewLocked(ctx context.Context) error {
+	if t.refresh != "" {
+		resp, err := t.auth.RefreshToken(ctx, &authpb.RefreshTokenRequest{RefreshToken: t.refresh})
+		if err == nil {
+			t.access = resp.AccessToken
+			t.refresh = resp.RefreshToken
+			t.expiry = tokenExpiry(resp.AccessToken)
+			return nil
+		}
+		if t.username == "" {
+			return fmt.Errorf("failed to refresh token: %w", err)
+		}
+	}
+	if t.username == "" {
+		return ErrNotAuthenticated
+	}
+	return t.loginLocked(ctx)
+}
+
+func (t *tokenManager) loginLocked(ctx context.Context) error {
+	resp, err := t.auth.Login(ctx, &authpb.LoginRequest{Username: t.username, Password: t.password})
+	if err != nil {
+		return fmt.Errorf("failed to login: %w", err)
+	}
+	t.access = resp.AccessToken
+	t.refresh = resp.RefreshToken
+	t.expiry = tokenExpiry(resp.AccessToken)
+	return nil
+}
+
+func (t *tokenManager) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if api.PublicEndpoints[method] {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	token, err := t.token(ctx)
+	if err != nil {
+		return err
+	}
+	err = invoker(withToken(ctx, token), method, req, reply, cc, opts...)
+	if status.Code(err) != codes.Unauthenticated {
+		return err
+	}
+
+	// The token was revoked or expired early, renew it once
+	t.invalidate(token)
+	if token, err = t.token(ctx); err != nil {
+		return err
+	}
+	return invoker(withToken(ctx, token), method, req, reply, cc, opts...)
+}
+
+func (t *tokenManager) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if api.PublicEndpoints[method] {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	token, err := t.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return streamer(withToken(ctx, token), desc, cc, method, opts...)
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", token)
+}
+
+// tokenExpiry reads the exp claim without verifying the signature, the
+// server remains the authority on whether the token is valid
+func tokenExpiry(token string) time.Time {
+	if token == "" {
+		return time.Time{}
+	}
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return claims.ExpiresAt.Time
+}